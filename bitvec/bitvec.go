@@ -3,6 +3,8 @@
 // Package bitvec defines a bit vector type useful for
 // resource management (e.g., memory allocation and
 // free list implementations).
+// The engine uses it to manage mesh storage, staging
+// buffers and IDs.
 package bitvec
 
 import (
@@ -220,6 +222,135 @@ func (v *V[T]) SearchRange(n int) (index int, ok bool) {
 	return
 }
 
+// SearchRangeFrom is like SearchRange, but the search
+// starts at bit hint and wraps around, so the range
+// that is closest to hint is located first.
+// A hint that is out of bounds is treated as 0.
+// Callers can use the end of the previous allocation
+// as hint (i.e., next fit) to avoid repeatedly
+// scanning the allocated prefix of the vector.
+func (v *V[T]) SearchRangeFrom(hint, n int) (index int, ok bool) {
+	n = max(n, 1)
+	if v.Rem() < n {
+		return
+	}
+	ln := v.Len()
+	if hint < 0 || hint >= ln {
+		hint = 0
+	}
+	if index, ok = v.searchIn(hint, ln, n); ok || hint == 0 {
+		return
+	}
+	// Ranges that start before hint may
+	// extend past it.
+	return v.searchIn(0, min(hint+n-1, ln), n)
+}
+
+// searchIn locates the first range of n unset bits
+// within [start, end).
+func (v *V[T]) searchIn(start, end, n int) (index int, ok bool) {
+	nb := v.nbit()
+	// The current range of unset bits is
+	// [index, i).
+	index = start
+	for i := start; i < end; {
+		x := v.s[i/nb]
+		if i%nb == 0 && i+nb <= end {
+			// Whole Uint.
+			switch x {
+			case ^T(0):
+				i += nb
+				index = i
+				continue
+			case 0:
+				i += nb
+				if i-index >= n {
+					return index, true
+				}
+				continue
+			}
+		}
+		i++
+		if x&(1<<((i-1)%nb)) != 0 {
+			index = i
+		} else if i-index >= n {
+			return index, true
+		}
+	}
+	return 0, false
+}
+
+// Trim removes the trailing Uints that have no set
+// bits, shrinking the vector to the smallest length
+// that keeps every set bit.
+// It returns the number of Uints removed.
+// The memory of the removed Uints is released.
+func (v *V[T]) Trim() int {
+	n := len(v.s)
+	for n > 0 && v.s[n-1] == 0 {
+		n--
+	}
+	nminus := len(v.s) - n
+	if nminus > 0 {
+		v.Shrink(nminus)
+		s := make([]T, len(v.s))
+		copy(s, v.s)
+		v.s = s
+	}
+	return nminus
+}
+
+// Runs returns an iterator over the maximal runs of
+// bits that match the given state - true for set bits
+// and false for unset bits.
+// The first value in the pair is the index of the run's
+// first bit, while the second is the run's length.
+// Uints that do not match are skipped as a whole.
+func (v *V[T]) Runs(set bool) iter.Seq2[int, int] {
+	var skip, full T
+	if set {
+		full = ^T(0)
+	} else {
+		skip = ^T(0)
+	}
+	return func(yield func(int, int) bool) {
+		nb := v.nbit()
+		start, cnt := 0, 0
+		for i, x := range v.s {
+			switch x {
+			case full:
+				if cnt == 0 {
+					start = i * nb
+				}
+				cnt += nb
+				continue
+			case skip:
+				if cnt > 0 && !yield(start, cnt) {
+					return
+				}
+				cnt = 0
+				continue
+			}
+			for b := range nb {
+				if (x&(1<<b) != 0) == set {
+					if cnt == 0 {
+						start = i*nb + b
+					}
+					cnt++
+				} else if cnt > 0 {
+					if !yield(start, cnt) {
+						return
+					}
+					cnt = 0
+				}
+			}
+		}
+		if cnt > 0 {
+			yield(start, cnt)
+		}
+	}
+}
+
 // Clear unsets every bit in the vector.
 func (v *V[T]) Clear() {
 	n := v.Len()
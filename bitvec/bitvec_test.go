@@ -4,6 +4,8 @@ package bitvec
 
 import (
 	"iter"
+	"math/rand/v2"
+	"slices"
 	"strconv"
 	"testing"
 	"unsafe"
@@ -389,6 +391,153 @@ func TestSearchRange(t *testing.T) {
 	v16.checkSearchRange(79, 81, t)
 }
 
+// searchRangeFrom is a naive SearchRangeFrom.
+func (v *V[_]) searchRangeFrom(hint, n int) (int, bool) {
+	if hint < 0 || hint >= v.Len() {
+		hint = 0
+	}
+	n = max(n, 1)
+	for k := range v.Len() {
+		i := (hint + k) % v.Len()
+		if i+n > v.Len() {
+			continue
+		}
+		ok := true
+		for j := i; j < i+n; j++ {
+			if v.IsSet(j) {
+				ok = false
+				break
+			}
+		}
+		if ok {
+			return i, true
+		}
+	}
+	return 0, false
+}
+
+func TestSearchRangeFrom(t *testing.T) {
+	var v8 V[uint8]
+	if _, ok := v8.SearchRangeFrom(0, 1); ok {
+		t.Fatal("v8.SearchRangeFrom: unexpected success")
+	}
+	v8.Grow(4)
+	for _, i := range [...]int{0, 1, 2, 9, 10, 20, 31} {
+		v8.Set(i)
+	}
+	for _, x := range [...]struct{ hint, n, want int }{
+		{0, 1, 3},
+		{0, 6, 3},
+		{0, 7, 11},
+		{3, 6, 3},
+		{4, 6, 11},
+		{11, 9, 11},
+		{12, 9, 21},
+		{12, 10, 21},
+		{12, 11, -1},
+		{25, 6, 25},
+		{26, 6, 3},
+		{25, 5, 25},
+		{-1, 1, 3},
+		{32, 1, 3},
+		{30, 1, 30},
+		{31, 1, 3},
+		{31, 2, 3},
+		{22, 9, 22},
+	} {
+		index, ok := v8.SearchRangeFrom(x.hint, x.n)
+		switch {
+		case x.want < 0 && ok:
+			t.Fatalf("v8.SearchRangeFrom(%d, %d):\nhave %d, true\nwant _, false", x.hint, x.n, index)
+		case x.want >= 0 && (!ok || index != x.want):
+			t.Fatalf("v8.SearchRangeFrom(%d, %d):\nhave %d, %t\nwant %d, true", x.hint, x.n, index, ok, x.want)
+		}
+	}
+
+	var v16 V[uint16]
+	v16.Grow(8)
+	rnd := rand.New(rand.NewPCG(1, 2))
+	for range 200 {
+		i := rnd.IntN(v16.Len())
+		if v16.IsSet(i) {
+			v16.Unset(i)
+		} else {
+			v16.Set(i)
+		}
+		hint, n := rnd.IntN(v16.Len()), 1+rnd.IntN(40)
+		want, wok := v16.searchRangeFrom(hint, n)
+		index, ok := v16.SearchRangeFrom(hint, n)
+		if ok != wok || ok && index != want {
+			t.Fatalf("v16.SearchRangeFrom(%d, %d):\nhave %d, %t\nwant %d, %t", hint, n, index, ok, want, wok)
+		}
+	}
+}
+
+func TestRuns(t *testing.T) {
+	var v8 V[uint8]
+	for range v8.Runs(true) {
+		t.Fatal("v8.Runs: unexpected run")
+	}
+	v8.Grow(4)
+	for _, i := range [...]int{0, 1, 2, 9, 10, 20} {
+		v8.Set(i)
+	}
+	for i := 24; i < 32; i++ {
+		v8.Set(i)
+	}
+	for _, x := range [...]struct {
+		set  bool
+		want [][2]int
+	}{
+		{true, [][2]int{{0, 3}, {9, 2}, {20, 1}, {24, 8}}},
+		{false, [][2]int{{3, 6}, {11, 9}, {21, 3}}},
+	} {
+		var have [][2]int
+		for i, n := range v8.Runs(x.set) {
+			have = append(have, [2]int{i, n})
+		}
+		if !slices.Equal(have, x.want) {
+			t.Fatalf("v8.Runs(%t):\nhave %v\nwant %v", x.set, have, x.want)
+		}
+	}
+	// Break early.
+	var n int
+	for range v8.Runs(false) {
+		n++
+		break
+	}
+	if n != 1 {
+		t.Fatalf("v8.Runs: yield count\nhave %d\nwant 1", n)
+	}
+	v8.Clear()
+	for i, n := range v8.Runs(false) {
+		if i != 0 || n != 32 {
+			t.Fatalf("v8.Runs(false):\nhave %d, %d\nwant 0, 32", i, n)
+		}
+	}
+}
+
+func TestTrim(t *testing.T) {
+	var v8 V[uint8]
+	if n := v8.Trim(); n != 0 {
+		t.Fatalf("v8.Trim:\nhave %d\nwant 0", n)
+	}
+	v8.Grow(4)
+	v8.Set(3)
+	v8.Set(10)
+	if n := v8.Trim(); n != 2 {
+		t.Fatalf("v8.Trim:\nhave %d\nwant 2", n)
+	}
+	if v8.Len() != 16 || v8.Rem() != 14 || cap(v8.s) != 2 {
+		t.Fatalf("v8.Trim: Len, Rem, cap\nhave %d, %d, %d\nwant 16, 14, 2", v8.Len(), v8.Rem(), cap(v8.s))
+	}
+	v8.Unset(3)
+	v8.Unset(10)
+	if n := v8.Trim(); n != 2 || v8.Len() != 0 || v8.Rem() != 0 {
+		t.Fatalf("v8.Trim:\nhave %d, %d, %d\nwant 2, 0, 0", n, v8.Len(), v8.Rem())
+	}
+}
+
 func TestClear(t *testing.T) {
 	var vu V[uint]
 	checkClear := func() {
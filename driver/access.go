@@ -0,0 +1,143 @@
+// Copyright 2024 Gustavo C. Viegas. All rights reserved.
+
+package driver
+
+import (
+	"unsafe"
+)
+
+// ErrNotVisible means that a buffer that is not host
+// visible was accessed by the CPU.
+// It wraps ErrInvalidParam.
+var ErrNotVisible = NewError("driver: buffer not host visible", ErrInvalidParam)
+
+// ErrBufferRange means that a buffer access is out of
+// bounds or misaligned.
+// It wraps ErrInvalidParam.
+var ErrBufferRange = NewError("driver: buffer access out of range", ErrInvalidParam)
+
+// The functions and types below access buffer memory
+// as typed data. The type parameter T must be a
+// fixed-size type that contains no pointers (e.g.,
+// float32, [4]float32 or a struct of such fields),
+// and is copied using the host's memory layout and
+// byte order. Offsets must be aligned to T's
+// alignment.
+
+// WriteAt copies src into buf's memory, starting at
+// the given byte offset.
+func WriteAt[T any](buf Buffer, off int64, src []T) error {
+	dst, err := access[T](buf, off, len(src))
+	if err != nil {
+		return err
+	}
+	copy(dst, src)
+	return nil
+}
+
+// ReadAt copies data from buf's memory, starting at
+// the given byte offset, into dst.
+func ReadAt[T any](buf Buffer, off int64, dst []T) error {
+	src, err := access[T](buf, off, len(dst))
+	if err != nil {
+		return err
+	}
+	copy(dst, src)
+	return nil
+}
+
+// Slice returns a slice of n elements of type T that
+// refers to buf's memory, starting at the given byte
+// offset.
+// The slice is valid for the lifetime of the buffer.
+func Slice[T any](buf Buffer, off int64, n int) ([]T, error) {
+	return access[T](buf, off, n)
+}
+
+// access checks that n elements of type T starting at
+// off are within buf's bounds and aligned, and returns
+// a slice referring to them.
+func access[T any](buf Buffer, off int64, n int) ([]T, error) {
+	var x T
+	sz := int64(unsafe.Sizeof(x))
+	switch {
+	case !buf.Visible():
+		return nil, ErrNotVisible
+	case off < 0 || n < 0 || off+sz*int64(n) > buf.Cap():
+		return nil, ErrBufferRange
+	case n == 0:
+		return nil, nil
+	case sz == 0:
+		// No memory to refer to (off may be
+		// buf.Cap()).
+		return make([]T, n), nil
+	}
+	p := unsafe.Pointer(&buf.Bytes()[off])
+	if uintptr(p)%unsafe.Alignof(x) != 0 {
+		return nil, ErrBufferRange
+	}
+	return unsafe.Slice((*T)(p), n), nil
+}
+
+// Accessor provides strided access to elements of type
+// T in a buffer, such as an attribute in interleaved
+// vertex data.
+type Accessor[T any] struct {
+	b      []byte
+	stride int64
+	n      int
+}
+
+// NewAccessor creates a new Accessor for n elements of
+// type T in buf.
+// The first element starts at the given byte offset,
+// and consecutive elements are stride bytes apart.
+// stride must be at least the size of T.
+func NewAccessor[T any](buf Buffer, off, stride int64, n int) (Accessor[T], error) {
+	var x T
+	sz := int64(unsafe.Sizeof(x))
+	al := int64(unsafe.Alignof(x))
+	switch {
+	case !buf.Visible():
+		return Accessor[T]{}, ErrNotVisible
+	case off < 0 || n < 0 || stride < sz || stride%al != 0:
+		return Accessor[T]{}, ErrBufferRange
+	case n == 0:
+		return Accessor[T]{}, nil
+	}
+	end := off + stride*int64(n-1) + sz
+	if end > buf.Cap() {
+		return Accessor[T]{}, ErrBufferRange
+	}
+	if sz == 0 {
+		// b is empty, so ptr does not index it.
+		return Accessor[T]{nil, 0, n}, nil
+	}
+	b := buf.Bytes()[off:end]
+	if uintptr(unsafe.Pointer(&b[0]))%uintptr(al) != 0 {
+		return Accessor[T]{}, ErrBufferRange
+	}
+	return Accessor[T]{b, stride, n}, nil
+}
+
+// Len returns the number of elements in a.
+func (a Accessor[T]) Len() int { return a.n }
+
+// At returns the element at index i.
+// i must be in [0, a.Len()).
+func (a Accessor[T]) At(i int) T { return *a.ptr(i) }
+
+// Set sets the element at index i to v.
+// i must be in [0, a.Len()).
+func (a Accessor[T]) Set(i int, v T) { *a.ptr(i) = v }
+
+// ptr returns a pointer to the element at index i.
+func (a Accessor[T]) ptr(i int) *T {
+	if uint(i) >= uint(a.n) {
+		panic("driver.Accessor: index out of range")
+	}
+	if len(a.b) == 0 {
+		return new(T)
+	}
+	return (*T)(unsafe.Pointer(&a.b[int64(i)*a.stride]))
+}
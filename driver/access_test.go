@@ -0,0 +1,147 @@
+// Copyright 2024 Gustavo C. Viegas. All rights reserved.
+
+package driver_test
+
+import (
+	"testing"
+	"unsafe"
+
+	"gviegas/neo3/driver"
+)
+
+// hostBuffer is a driver.Buffer backed by Go memory.
+type hostBuffer struct {
+	driver.Buffer
+	p   []byte
+	vis bool
+}
+
+func newHostBuffer(size int, vis bool) *hostBuffer {
+	// Use uint64 to ensure proper alignment.
+	p := make([]uint64, (size+7)/8)
+	return &hostBuffer{
+		p:   unsafe.Slice((*byte)(unsafe.Pointer(&p[0])), size),
+		vis: vis,
+	}
+}
+
+func (b *hostBuffer) Visible() bool { return b.vis }
+
+func (b *hostBuffer) Bytes() []byte {
+	if !b.vis {
+		return nil
+	}
+	return b.p
+}
+
+func (b *hostBuffer) Cap() int64 { return int64(len(b.p)) }
+
+func TestWriteReadAt(t *testing.T) {
+	buf := newHostBuffer(64, true)
+	src := []float32{1, 2, 3, 4}
+	if err := driver.WriteAt(buf, 16, src); err != nil {
+		t.Fatalf("driver.WriteAt failed: %v", err)
+	}
+	dst := make([]float32, 4)
+	if err := driver.ReadAt(buf, 16, dst); err != nil {
+		t.Fatalf("driver.ReadAt failed: %v", err)
+	}
+	for i := range src {
+		if dst[i] != src[i] {
+			t.Fatalf("driver.ReadAt: [%d]\nhave %v\nwant %v", i, dst[i], src[i])
+		}
+	}
+	s, err := driver.Slice[[2]float32](buf, 16, 2)
+	if err != nil {
+		t.Fatalf("driver.Slice failed: %v", err)
+	}
+	if s[1] != [2]float32{3, 4} {
+		t.Fatalf("driver.Slice: [1]\nhave %v\nwant %v", s[1], [2]float32{3, 4})
+	}
+	s[0][0] = 5
+	if err := driver.ReadAt(buf, 16, dst[:1]); err != nil || dst[0] != 5 {
+		t.Fatalf("driver.Slice: write\nhave %v, %v\nwant 5, nil", dst[0], err)
+	}
+
+	for _, x := range [...]struct {
+		off int64
+		n   int
+	}{
+		{-4, 1},
+		{52, 4},
+		{64, 1},
+		{2, 1},
+	} {
+		if err := driver.WriteAt(buf, x.off, make([]float32, x.n)); err != driver.ErrBufferRange {
+			t.Fatalf("driver.WriteAt(%d, %d):\nhave %v\nwant %v", x.off, x.n, err, driver.ErrBufferRange)
+		}
+	}
+	if err := driver.WriteAt(buf, 64, []float32{}); err != nil {
+		t.Fatalf("driver.WriteAt: empty\nhave %v\nwant nil", err)
+	}
+	if err := driver.ReadAt(newHostBuffer(64, false), 0, dst); err != driver.ErrNotVisible {
+		t.Fatalf("driver.ReadAt: not visible\nhave %v\nwant %v", err, driver.ErrNotVisible)
+	}
+
+	// Zero-sized types can be accessed anywhere
+	// within the buffer, including at its end.
+	for _, off := range [...]int64{0, 63, 64} {
+		if s, err := driver.Slice[struct{}](buf, off, 3); err != nil || len(s) != 3 {
+			t.Fatalf("driver.Slice(%d): zero-sized\nhave %d, %v\nwant 3, nil", off, len(s), err)
+		}
+	}
+	if _, err := driver.Slice[struct{}](buf, 65, 1); err != driver.ErrBufferRange {
+		t.Fatalf("driver.Slice(65): zero-sized\nhave %v\nwant %v", err, driver.ErrBufferRange)
+	}
+	a, err := driver.NewAccessor[struct{}](buf, 64, 0, 2)
+	if err != nil || a.Len() != 2 {
+		t.Fatalf("driver.NewAccessor: zero-sized\nhave %d, %v\nwant 2, nil", a.Len(), err)
+	}
+	a.Set(1, a.At(0))
+}
+
+func TestAccessor(t *testing.T) {
+	// Interleaved position (3 floats) and
+	// color (4 bytes) attributes.
+	const stride, n = 16, 4
+	buf := newHostBuffer(stride*n, true)
+	pos, err := driver.NewAccessor[[3]float32](buf, 0, stride, n)
+	if err != nil {
+		t.Fatalf("driver.NewAccessor failed: %v", err)
+	}
+	col, err := driver.NewAccessor[[4]byte](buf, 12, stride, n)
+	if err != nil {
+		t.Fatalf("driver.NewAccessor failed: %v", err)
+	}
+	if pos.Len() != n || col.Len() != n {
+		t.Fatalf("Accessor.Len:\nhave %d, %d\nwant %d, %d", pos.Len(), col.Len(), n, n)
+	}
+	for i := range n {
+		f := float32(i)
+		pos.Set(i, [3]float32{f, f + 1, f + 2})
+		col.Set(i, [4]byte{byte(i), 255, 0, 255})
+	}
+	for i := range n {
+		f := float32(i)
+		if x := pos.At(i); x != [3]float32{f, f + 1, f + 2} {
+			t.Fatalf("Accessor.At: position [%d]\nhave %v\nwant %v", i, x, [3]float32{f, f + 1, f + 2})
+		}
+		if x := col.At(i); x != [4]byte{byte(i), 255, 0, 255} {
+			t.Fatalf("Accessor.At: color [%d]\nhave %v\nwant %v", i, x, [4]byte{byte(i), 255, 0, 255})
+		}
+	}
+
+	for _, x := range [...]struct {
+		off, stride int64
+		n           int
+	}{
+		{0, 8, n},
+		{8, stride, n},
+		{0, 14, n},
+		{-4, stride, 1},
+	} {
+		if _, err := driver.NewAccessor[[3]float32](buf, x.off, x.stride, x.n); err != driver.ErrBufferRange {
+			t.Fatalf("driver.NewAccessor(%d, %d, %d):\nhave %v\nwant %v", x.off, x.stride, x.n, err, driver.ErrBufferRange)
+		}
+	}
+}
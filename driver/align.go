@@ -0,0 +1,32 @@
+// Copyright 2024 Gustavo C. Viegas. All rights reserved.
+
+package driver
+
+// DescAlign is the alignment, in bytes, required of the
+// buffer ranges that are used in DBuffer and DConstant
+// descriptors (see DescHeap.SetBuffer), and of the
+// offsets of buffer views.
+const DescAlign = 256
+
+// AlignUp rounds n up to the nearest multiple of align.
+// align must be a power of two.
+func AlignUp(n, align int64) int64 {
+	if align <= 0 || align&(align-1) != 0 {
+		panic("driver.AlignUp: alignment is not a power of two")
+	}
+	return (n + align - 1) &^ (align - 1)
+}
+
+// PaddedStride returns the stride, in bytes, of an array
+// of elements of size structSize whose elements are to
+// be used individually in DConstant descriptors.
+// The stride is structSize rounded up to DescAlign.
+// It returns 0 if structSize is not positive or is
+// greater than lim.MaxDescConstantRange, in which case
+// an element cannot be used as a constant range.
+func PaddedStride(structSize int64, lim *Limits) int64 {
+	if structSize <= 0 || structSize > lim.MaxDescConstantRange {
+		return 0
+	}
+	return AlignUp(structSize, DescAlign)
+}
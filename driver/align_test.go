@@ -0,0 +1,43 @@
+// Copyright 2024 Gustavo C. Viegas. All rights reserved.
+
+package driver_test
+
+import (
+	"testing"
+
+	"gviegas/neo3/driver"
+)
+
+func TestAlignUp(t *testing.T) {
+	for _, x := range [...]struct{ n, align, want int64 }{
+		{0, 256, 0},
+		{1, 256, 256},
+		{255, 256, 256},
+		{256, 256, 256},
+		{257, 256, 512},
+		{13, 4, 16},
+		{7, 1, 7},
+	} {
+		if n := driver.AlignUp(x.n, x.align); n != x.want {
+			t.Fatalf("driver.AlignUp(%d, %d):\nhave %d\nwant %d", x.n, x.align, n, x.want)
+		}
+	}
+}
+
+func TestPaddedStride(t *testing.T) {
+	lim := driver.Limits{MaxDescConstantRange: 16384}
+	for _, x := range [...]struct{ size, want int64 }{
+		{1, driver.DescAlign},
+		{64, driver.DescAlign},
+		{driver.DescAlign, driver.DescAlign},
+		{driver.DescAlign + 4, driver.DescAlign * 2},
+		{16384, 16384},
+		{16385, 0},
+		{0, 0},
+		{-1, 0},
+	} {
+		if n := driver.PaddedStride(x.size, &lim); n != x.want {
+			t.Fatalf("driver.PaddedStride(%d):\nhave %d\nwant %d", x.size, n, x.want)
+		}
+	}
+}
@@ -40,6 +40,17 @@ type GPU interface {
 	// synchronize with the receive on ch.
 	Commit(wk *WorkItem, ch chan<- *WorkItem) error
 
+	// CommitBatch commits a number of work items to the
+	// GPU for execution in a single submission.
+	// It behaves as if Commit were called for each
+	// element of wk, in order, except that the work
+	// items complete together: ch receives every
+	// element of wk (in no particular order) once all
+	// of them complete execution. ch should be able to
+	// buffer len(wk) items.
+	// If it fails, none of the work items is committed.
+	CommitBatch(wk []*WorkItem, ch chan<- *WorkItem) error
+
 	// NewCmdBuffer creates a new command buffer.
 	NewCmdBuffer() (CmdBuffer, error)
 
@@ -54,6 +65,24 @@ type GPU interface {
 	// or a pointer to a CompState.
 	NewPipeline(state any) (Pipeline, error)
 
+	// NewPipelines creates a number of pipelines at once.
+	// Each element of states must be valid as the state
+	// parameter of NewPipeline. Creating related pipelines
+	// in a single call may be faster than calling
+	// NewPipeline repeatedly.
+	// If it fails, no pipeline is created.
+	NewPipelines(states []any) ([]Pipeline, error)
+
+	// NewPipelineAsync creates a new pipeline in the
+	// background. It returns immediately.
+	// The state parameter is as in NewPipeline. The
+	// caller must not modify state until the returned
+	// AsyncPipeline is done.
+	// The caller must either obtain the pipeline with
+	// AsyncPipeline.Wait (and eventually destroy it)
+	// or call AsyncPipeline.Destroy.
+	NewPipelineAsync(state any) *AsyncPipeline
+
 	// NewBuffer creates a new buffer.
 	NewBuffer(size int64, visible bool, usg Usage) (Buffer, error)
 
@@ -67,6 +96,12 @@ type GPU interface {
 	// They are immutable for the lifetime of the GPU.
 	Limits() Limits
 
+	// Status returns nil if the GPU is usable.
+	// Once the device is lost, it returns a
+	// *DeviceLostError for as long as the GPU
+	// remains open.
+	Status() error
+
 	// Features returns the supported features.
 	// They are immutable for the lifetime of the GPU.
 	Features() Features
@@ -96,10 +131,57 @@ type Off3D struct {
 // in Work is meaningful.
 // Err is set by GPU.Commit to indicate the result of the
 // call, while Custom is ignored.
+// Background is a hint that the work item only transfers
+// data and is not needed for the current frame (e.g.,
+// streaming of large assets). The GPU may execute such
+// work in a low-priority queue, so it does not contend
+// with other submissions. Background work items are not
+// ordered with respect to other work items, but the
+// visibility guarantees of GPU.Commit still hold once
+// they complete. If a CommitBatch call mixes background
+// and other work items, the hint is ignored.
+// Compute is a hint that the work item only records
+// compute, copy and synchronization commands. If
+// Features.AsyncCompute is set, the GPU may execute
+// such work in a separate queue, concurrently with
+// other work items committed after it. Compute work
+// items are ordered with respect to one another and
+// to every work item committed before them, but work
+// items committed after them only wait for their
+// completion if WaitCompute is set. If a CommitBatch
+// call mixes compute and other work items, the hint
+// is ignored. If the GPU implements AsyncComputer,
+// command buffers created by NewComputeCmdBuffer
+// can only be committed in compute work items that
+// contain no other command buffers, and not mixed
+// with other work items in a CommitBatch call.
+// WaitCompute indicates that the work item must not
+// start executing until every compute work item
+// committed before it completes. It is ignored for
+// compute and background work items.
 type WorkItem struct {
-	Work   []CmdBuffer
-	Err    error
-	Custom any
+	Work        []CmdBuffer
+	Err         error
+	Custom      any
+	Background  bool
+	Compute     bool
+	WaitCompute bool
+}
+
+// AsyncComputer is the interface that a GPU may
+// implement to provide command buffers for async
+// compute work (see WorkItem.Compute).
+// A GPU may execute such work in a queue family that
+// does not support graphics, so command buffers that
+// it records into must be created by this interface.
+type AsyncComputer interface {
+	// NewComputeCmdBuffer creates a new command
+	// buffer for compute work items.
+	// It can only record compute, copy and
+	// synchronization commands. In particular,
+	// BeginPass, BeginPassViews and BlitImage must
+	// not be called.
+	NewComputeCmdBuffer() (CmdBuffer, error)
 }
 
 // CmdBuffer is the interface that defines a command buffer.
@@ -123,7 +205,7 @@ type WorkItem struct {
 //  3. repeat 1-2 as needed
 //
 // To record copy commands:
-//  1. call Copy*/Fill commands
+//  1. call Copy*/BlitImage/Fill/UpdateBuffer commands
 //
 // To record synchronization commands:
 //  1. call Barrier/Transition commands
@@ -144,6 +226,17 @@ type CmdBuffer interface {
 	// BeginPass begins a render pass.
 	BeginPass(width, height, layers int, color []ColorTarget, ds *DSTarget)
 
+	// BeginPassViews begins a multiview render pass.
+	// Each bit set in viewMask identifies a view, and
+	// every draw in the pass is broadcast to all views.
+	// View i renders to layer i of the targets, so
+	// these must have at least as many layers as the
+	// most significant bit set in viewMask.
+	// Pipelines used in the pass must have been created
+	// with a matching GraphState.ViewMask.
+	// It requires Features.Multiview.
+	BeginPassViews(width, height int, viewMask uint32, color []ColorTarget, ds *DSTarget)
+
 	// EndPass ends the current render pass.
 	EndPass()
 
@@ -202,6 +295,12 @@ type CmdBuffer interface {
 	// It must not be called during a render pass.
 	CopyImage(param *ImageCopy)
 
+	// BlitImage copies a region of an image to a
+	// region of another image, scaling and filtering
+	// it as needed.
+	// It must not be called during a render pass.
+	BlitImage(param *ImageBlit)
+
 	// CopyBufToImg copies data from a buffer to
 	// an image.
 	// It must not be called during a render pass.
@@ -218,6 +317,17 @@ type CmdBuffer interface {
 	// It must not be called during a render pass.
 	Fill(buf Buffer, off int64, value byte, size int64)
 
+	// UpdateBuffer writes data to a buffer range
+	// starting at off.
+	// data is copied into the command buffer when
+	// the command is recorded, so it can be reused
+	// right away and buf need not be host visible.
+	// This is intended for small updates: off and
+	// len(data) must be aligned to 4 bytes, and
+	// len(data) must not exceed MaxUpdateBuffer.
+	// It must not be called during a render pass.
+	UpdateBuffer(buf Buffer, off int64, data []byte)
+
 	// Barrier inserts a number of global barriers
 	// in the command buffer.
 	// It must not be called during a render pass.
@@ -342,6 +452,10 @@ type DSTarget struct {
 	DSRead  bool
 }
 
+// MaxUpdateBuffer is the maximum number of bytes
+// that a single CmdBuffer.UpdateBuffer call can write.
+const MaxUpdateBuffer = 65536
+
 // BufferCopy describes the parameters of a copy command
 // that copies data from one buffer to another.
 type BufferCopy struct {
@@ -367,6 +481,31 @@ type ImageCopy struct {
 	Layers    int
 }
 
+// ImageBlit describes the parameters of a blit command
+// that copies a region of one image to a region of
+// another, which may differ in size.
+// Only single-sample color images can be blitted, and
+// their formats must either both be IsNonfloatColor or
+// both not be. Filter must be FNearest for
+// IsNonfloatColor formats.
+// The regions are given by an offset and a size.
+// Filter is used to resample the source region when
+// the sizes differ.
+type ImageBlit struct {
+	From      Image
+	FromOff   Off3D
+	FromSize  Dim3D
+	FromLayer int
+	FromLevel int
+	To        Image
+	ToOff     Off3D
+	ToSize    Dim3D
+	ToLayer   int
+	ToLevel   int
+	Layers    int
+	Filter    Filter
+}
+
 // BufImgCopy describes the parameters of a copy command
 // that copies data between a buffer and an image.
 // BufOff must be aligned to 512 bytes.
@@ -526,6 +665,9 @@ type Barrier struct {
 
 // Transition represents a layout transition on a
 // specific image subresource.
+// Xfer can be used to transfer ownership of an image
+// created by an External GPU. AccessBefore is ignored
+// for OAcquire, and AccessAfter is ignored for ORelease.
 type Transition struct {
 	Barrier
 	LayoutBefore Layout
@@ -535,6 +677,7 @@ type Transition struct {
 	Layers       int
 	Level        int
 	Levels       int
+	Xfer         Ownership
 }
 
 // ShaderFunc defines the shader code of a programmable stage.
@@ -568,6 +711,10 @@ const (
 	DTexture
 	// Texture sampler.
 	DSampler
+	// Read-only texel buffer.
+	DUniformTexelBuffer
+	// Read/write texel buffer.
+	DStorageTexelBuffer
 )
 
 // Descriptor describes data for use in shaders.
@@ -576,10 +723,25 @@ type Descriptor struct {
 	Stages Stage
 	Nr     int
 	Len    int
+	// Immutable, if not nil, contains the samplers
+	// that the descriptor refers to in every heap
+	// copy. It is only valid for DSampler, and its
+	// length must equal Len. Such descriptors must
+	// not be updated with DescHeap.SetSampler.
+	// The samplers must not be destroyed while the
+	// heap is in use.
+	Immutable []Sampler
 }
 
 // DescHeap is the interface that defines a set of descriptors
 // for use in programmable pipeline stages.
+//
+// Every descriptor of a heap copy must be set before the copy
+// is used in a command buffer, unless Features.PartiallyBound
+// is supported, in which case only descriptors that shaders
+// access must be set. If Features.NullDescriptor is supported,
+// nil buffers, image views and buffer views can be given to
+// the Set* methods to set null descriptors.
 type DescHeap interface {
 	Destroyer
 
@@ -594,7 +756,9 @@ type DescHeap interface {
 	// SetBuffer updates the buffer ranges referred by the
 	// given descriptor of the given heap copy.
 	// The descriptor must be of type DBuffer or DConstant.
-	// Buffer ranges must be aligned to 256 bytes.
+	// Buffer ranges must be aligned to DescAlign bytes,
+	// and the size of DConstant ranges must not exceed
+	// Limits.MaxDescConstantRange.
 	SetBuffer(cpy, nr, start int, buf []Buffer, off, size []int64)
 
 	// SetImage updates the image views referred by the
@@ -610,6 +774,36 @@ type DescHeap interface {
 	// The descriptor must be of type DSampler.
 	SetSampler(cpy, nr, start int, splr []Sampler)
 
+	// SetBufferView updates the buffer views referred by
+	// the given descriptor of the given heap copy.
+	// The descriptor must be of type DUniformTexelBuffer
+	// or DStorageTexelBuffer.
+	SetBufferView(cpy, nr, start int, bv []BufferView)
+
+	// CopyFrom copies every descriptor of the heap copy
+	// srcCpy of src into the heap copy cpy.
+	// src must have been created with the same
+	// descriptors as the heap (it may be the heap
+	// itself, provided that cpy and srcCpy differ).
+	// Descriptors with immutable samplers are not
+	// copied.
+	CopyFrom(cpy int, src DescHeap, srcCpy int)
+
+	// BeginUpdate causes subsequent Set* and CopyFrom
+	// calls to be deferred until EndUpdate is called,
+	// so that many updates can be submitted at once.
+	// Deferred updates take effect in the order in
+	// which they were made.
+	// Updates still pending when New is called are
+	// discarded.
+	BeginUpdate()
+
+	// EndUpdate submits the updates deferred since
+	// the last BeginUpdate call.
+	// Heap copies must not be used in command buffers
+	// while their updates are deferred.
+	EndUpdate()
+
 	// Len returns the number of heap copies created
 	// by New.
 	Len() int
@@ -672,6 +866,18 @@ const (
 	Float32x2 VertexFmt = iota | 8<<12 | 2<<24
 	Float32x3 VertexFmt = iota | 12<<12 | 3<<24
 	Float32x4 VertexFmt = iota | 16<<12 | 4<<24
+	// Signed normalized 10-bit x, y and z and 2-bit w,
+	// packed into 32 bits (x in the least significant
+	// bits). Suitable for normals and tangents.
+	RGB10A2Norm VertexFmt = iota | 4<<12 | 4<<24
+	// Unsigned normalized 16-bit integer, 2 or 4
+	// components. Values are mapped to [0.0, 1.0].
+	Unorm16x2 VertexFmt = iota | 4<<12 | 2<<24
+	Unorm16x4 VertexFmt = iota | 8<<12 | 4<<24
+	// Signed normalized 16-bit integer, 2 or 4
+	// components. Values are mapped to [-1.0, 1.0].
+	Snorm16x2 VertexFmt = iota | 4<<12 | 2<<24
+	Snorm16x4 VertexFmt = iota | 8<<12 | 4<<24
 )
 
 // Size returns the size of f, in bytes.
@@ -760,6 +966,13 @@ type RasterState struct {
 	BiasValue float32
 	BiasSlope float32
 	BiasClamp float32
+	// ClipDistances is the number of clip
+	// distances (i.e., user clip planes) that
+	// the vertex shader writes. Primitives are
+	// clipped where any distance is negative.
+	// It requires Features.ClipDistance and
+	// must not exceed Limits.MaxClipDistances.
+	ClipDistances int
 }
 
 // CmpFunc is the type of comparison functions.
@@ -890,6 +1103,13 @@ type BlendState struct {
 	// only Color[0] is used. Otherwise, it uses one
 	// element per color render target.
 	Color []ColorBlend
+	// IndependentWriteMask enables each render target
+	// to use a different ColorBlend.WriteMask, while
+	// sharing the remaining blend parameters of
+	// Color[0]. It has no effect if IndependentBlend
+	// is true. When set, Color must contain one element
+	// per color render target.
+	IndependentWriteMask bool
 }
 
 // GraphState defines the combination of programmable and
@@ -909,6 +1129,11 @@ type GraphState struct {
 	Blend    BlendState
 	ColorFmt []PixelFmt
 	DSFmt    PixelFmt
+	// ViewMask must be zero unless the pipeline is
+	// used in render passes that begin with
+	// CmdBuffer.BeginPassViews, in which case it must
+	// match the pass' view mask.
+	ViewMask uint32
 }
 
 // CompState defines the single programmable stage of a
@@ -923,6 +1148,68 @@ type Pipeline interface {
 	Destroyer
 }
 
+// AsyncPipeline is a pipeline whose creation may not have
+// completed yet.
+// It is obtained from a call to GPU.NewPipelineAsync.
+type AsyncPipeline struct {
+	done chan struct{}
+	pl   Pipeline
+	err  error
+}
+
+// NewAsyncPipeline calls create in a new goroutine and
+// returns an AsyncPipeline that completes when the call
+// returns.
+// It is intended for use by GPU implementations.
+func NewAsyncPipeline(create func() (Pipeline, error)) *AsyncPipeline {
+	p := &AsyncPipeline{done: make(chan struct{})}
+	go func() {
+		p.pl, p.err = create()
+		close(p.done)
+	}()
+	return p
+}
+
+// Ready returns whether p's creation has completed.
+// It does not block.
+func (p *AsyncPipeline) Ready() bool {
+	select {
+	case <-p.done:
+		return true
+	default:
+		return false
+	}
+}
+
+// Wait blocks until p's creation completes and then
+// returns the created Pipeline, or the error that
+// caused creation to fail.
+func (p *AsyncPipeline) Wait() (Pipeline, error) {
+	<-p.done
+	return p.pl, p.err
+}
+
+// Destroy discards p. It does not block.
+// The created Pipeline, if any, is destroyed once p's
+// creation completes, so it must not be used after
+// this call even if it was obtained from Wait.
+// Results that are not waited for must be discarded
+// with Destroy, otherwise the Pipeline leaks.
+func (p *AsyncPipeline) Destroy() {
+	if p.Ready() {
+		if p.pl != nil {
+			p.pl.Destroy()
+		}
+		return
+	}
+	go func() {
+		<-p.done
+		if p.pl != nil {
+			p.pl.Destroy()
+		}
+	}()
+}
+
 // Usage is a mask indicating valid uses for a resource.
 type Usage int
 
@@ -953,6 +1240,20 @@ const (
 	URenderTarget
 	// The resource can be used for any purpose.
 	UGeneric Usage = 1<<iota - 1
+	// The image can be viewed with a format other
+	// than the one it was created with (see
+	// PixelFmt.ViewCompatible).
+	// Valid only for Image. Not included in UGeneric.
+	// Requires Features.MutableFormat.
+	UMutableFmt = URenderTarget << 1
+	// The image is a render target whose contents
+	// need not outlive a render pass (i.e., it is
+	// neither loaded nor stored). Tile-based GPUs
+	// may avoid allocating memory for it.
+	// Valid only for Image. Not included in UGeneric.
+	// Can only be combined with URenderTarget and
+	// UMutableFmt.
+	UTransient = UMutableFmt << 1
 )
 
 // Buffer is the interface that defines a GPU buffer.
@@ -978,6 +1279,34 @@ type Buffer interface {
 	// This value is immutable for the lifetime of the
 	// buffer.
 	Cap() int64
+
+	// NewView creates a new buffer view.
+	// Buffer views interpret a range of the buffer as
+	// an array of texels of format pf, for use in
+	// texel buffer descriptors. A buffer created with
+	// UShaderConst usage can be used in
+	// DUniformTexelBuffer descriptors, and one created
+	// with UShaderRead and/or UShaderWrite usage can be
+	// used in DStorageTexelBuffer descriptors. pf must
+	// support all of these that apply to the buffer.
+	// off must be aligned to 256 bytes, and the number
+	// of texels in the range must not exceed
+	// Limits.MaxTexelBuffer.
+	// All views created from a given buffer must be
+	// destroyed before the buffer itself is destroyed.
+	NewView(pf PixelFmt, off, size int64) (BufferView, error)
+}
+
+// BufferView is the interface that defines a formatted
+// view of a Buffer resource.
+type BufferView interface {
+	Destroyer
+
+	// Buffer returns the buffer from which the view
+	// was created.
+	// This value is immutable for the lifetime of
+	// the BufferView.
+	Buffer() Buffer
 }
 
 // PixelFmt describes the format of a pixel.
@@ -1027,6 +1356,8 @@ const (
 	R32Float    PixelFmt = iota | 4<<12 | 1<<20 | fColorF
 	R32Uint     PixelFmt = iota | 4<<12 | 1<<20 | fColorI
 	R32Int      PixelFmt = iota | 4<<12 | 1<<20 | fColorI
+	// Color, packed.
+	RGB10A2Unorm PixelFmt = iota | 4<<12 | 4<<20 | fColorF
 	// Depth/Stencil.
 	D16Unorm       PixelFmt = iota | 2<<12 | 1<<20 | fDepth
 	D32Float       PixelFmt = iota | 4<<12 | 1<<20 | fDepth
@@ -1074,6 +1405,34 @@ func (f PixelFmt) IsDS() (depth bool, stencil bool) {
 	}
 }
 
+// ViewCompatible returns whether an image created
+// with format f and UMutableFmt usage can be viewed
+// with format g.
+// Formats are compatible if both are color formats
+// of the same compatibility class, which comprises
+// the formats that have the same channels, in the
+// same order and with the same sizes (e.g.,
+// RGBA8Unorm and RGBA8SRGB, but neither BGRA8Unorm
+// nor R32Float).
+// f and g must not be internal formats.
+func (f PixelFmt) ViewCompatible(g PixelFmt) bool {
+	return f.IsColor() && g.IsColor() && f.compatClass() == g.compatClass()
+}
+
+// compatClass returns the compatibility class of the
+// color format f.
+// Formats that differ only in the interpretation of
+// their channels share a class.
+func (f PixelFmt) compatClass() int {
+	switch f {
+	case BGRA8Unorm, BGRA8SRGB:
+		return -1
+	case RGB10A2Unorm:
+		return -2
+	}
+	return f.Size()<<4 | f.Channels()
+}
+
 // Image is the interface that defines a GPU image.
 // The dimensionality of the image is derived from the size
 // it was created with:
@@ -1102,8 +1461,47 @@ type Image interface {
 	// All views created from a given image must be
 	// destroyed before the image itself is destroyed.
 	NewView(typ ViewType, layer, layers, level, levels int) (ImageView, error)
+
+	// NewViewParam is like NewView, but it also allows
+	// for non-default view parameters such as component
+	// swizzles.
+	NewViewParam(param *ViewParam) (ImageView, error)
+}
+
+// ViewParam describes the parameters of an image view.
+type ViewParam struct {
+	Type    ViewType
+	Layer   int
+	Layers  int
+	Level   int
+	Levels  int
+	Swizzle Swizzle
+	// PixelFmt is the format of the view.
+	// FInvalid means the image's format.
+	// Other formats require the image to have
+	// been created with UMutableFmt usage.
+	PixelFmt PixelFmt
 }
 
+// Component is the type of image view components.
+type Component int
+
+// Components.
+const (
+	// CIdentity maps the component to itself.
+	CIdentity Component = iota
+	CZero
+	COne
+	CR
+	CG
+	CB
+	CA
+)
+
+// Swizzle is a component mapping in RGBA order.
+// The zero value is the identity mapping.
+type Swizzle [4]Component
+
 // ViewType is the type of a resource view.
 type ViewType int
 
@@ -1210,6 +1608,8 @@ type Limits struct {
 	MaxDescBufferRange int64
 	// Maximum range of constant descriptors.
 	MaxDescConstantRange int64
+	// Maximum number of texels in a BufferView.
+	MaxTexelBuffer int
 
 	// Maximum number of color render targets in
 	// a render pass.
@@ -1218,6 +1618,10 @@ type Limits struct {
 	MaxRenderSize [2]int
 	// Maximum number of layers in a render pass.
 	MaxRenderLayers int
+	// Maximum sample count of color and depth/stencil
+	// render targets. Every power of two up to this
+	// value is supported.
+	MaxRenderSamples int
 	// Maximum size of a point primitive.
 	MaxPointSize float32
 
@@ -1227,6 +1631,9 @@ type Limits struct {
 	// Maximum number of fragment inputs in a
 	// fragment shader.
 	MaxFragmentIn int
+	// Maximum number of clip distances in a
+	// pipeline.
+	MaxClipDistances int
 
 	// Maximum dispatch count.
 	MaxDispatch [3]int
@@ -1243,6 +1650,12 @@ type Features struct {
 	// Whether BlendState.IndependentBlend
 	// is supported.
 	IndependentBlend bool
+	// Whether BlendState.IndependentWriteMask
+	// is supported. It is only reported when
+	// IndependentBlend is supported, since it is
+	// not emulated otherwise. Drivers may accept
+	// write masks that are all equal regardless.
+	IndependentWriteMask bool
 	// Whether RasterState.BiasClamp is supported.
 	DepthBiasClamp bool
 	// Whether the FLines FillMode is supported.
@@ -1250,4 +1663,25 @@ type Features struct {
 	// Whether ImageView of type IViewCubeArray
 	// is supported.
 	CubeArray bool
+	// Whether UMutableFmt is supported.
+	MutableFormat bool
+	// Whether descriptor heaps can be partially
+	// bound, i.e., descriptors that are not
+	// accessed by shaders need not be set.
+	PartiallyBound bool
+	// Whether nil resources can be given to
+	// DescHeap.Set* methods (other than
+	// SetSampler). Shader access to a null
+	// descriptor reads zeros and discards writes.
+	NullDescriptor bool
+	// Whether CmdBuffer.BeginPassViews is supported.
+	// If so, view masks of at least 6 bits (i.e.,
+	// one view per cube face) can be used.
+	Multiview bool
+	// Whether RasterState.ClipDistances can be
+	// greater than zero.
+	ClipDistance bool
+	// Whether WorkItem.Compute causes work items
+	// to execute in a separate queue.
+	AsyncCompute bool
 }
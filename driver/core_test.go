@@ -111,6 +111,50 @@ var tDesc = [...][]driver.Descriptor{
 	},
 }
 
+func TestViewCompatible(t *testing.T) {
+	for _, x := range [...]struct {
+		f, g driver.PixelFmt
+		want bool
+	}{
+		{driver.RGBA8Unorm, driver.RGBA8Unorm, true},
+		{driver.RGBA8Unorm, driver.RGBA8SRGB, true},
+		{driver.RGBA8Unorm, driver.RGBA8Uint, true},
+		{driver.BGRA8Unorm, driver.BGRA8SRGB, true},
+		{driver.RG16Float, driver.RG16Int, true},
+		{driver.RGBA8Unorm, driver.BGRA8Unorm, false},
+		{driver.RGBA8SRGB, driver.BGRA8SRGB, false},
+		{driver.RGBA8Unorm, driver.R32Float, false},
+		{driver.RGBA8Unorm, driver.RG16Float, false},
+		{driver.RGBA8Unorm, driver.RGB10A2Unorm, false},
+		{driver.R32Float, driver.D32Float, false},
+		{driver.D32Float, driver.D32Float, false},
+	} {
+		if have := x.f.ViewCompatible(x.g); have != x.want {
+			t.Errorf("PixelFmt(%#x).ViewCompatible(%#x):\nhave %t\nwant %t", x.f, x.g, have, x.want)
+		}
+		if have := x.g.ViewCompatible(x.f); have != x.want {
+			t.Errorf("PixelFmt(%#x).ViewCompatible(%#x):\nhave %t\nwant %t", x.g, x.f, have, x.want)
+		}
+	}
+}
+
+func TestPipelineAsync(t *testing.T) {
+	// Invalid state type; creation must fail.
+	ap := gpu.NewPipelineAsync(nil)
+	pl, err := ap.Wait()
+	if err == nil {
+		pl.Destroy()
+		t.Error("GPU.NewPipelineAsync: AsyncPipeline.Wait unexpectedly succeeded")
+	}
+	if !ap.Ready() {
+		t.Error("AsyncPipeline.Ready:\nhave false\nwant true")
+	}
+	// Destroy must not block nor fail for
+	// pipelines that were not created.
+	ap.Destroy()
+	gpu.NewPipelineAsync(nil).Destroy()
+}
+
 func TestDescHeap(t *testing.T) {
 	for _, ds := range tDesc {
 		dh, err := gpu.NewDescHeap(ds)
@@ -4,10 +4,35 @@
 // common GPU functionality.
 // It is designed to allow platform-specific APIs to be
 // implemented in a mostly straightforward manner.
+//
+// # Compute-only use
+//
+// The package can be used for general-purpose GPU
+// computation, without any window system. Setting
+// Options.Headless in Open selects drivers that can
+// skip the initialization of presentation support
+// (see also the NEO3_HEADLESS variable in package wsi).
+// The following subset of the API is stable for such
+// use:
+//
+//   - GPU.NewBuffer, Buffer.Bytes and Buffer.Destroy
+//   - GPU.NewDescHeap, GPU.NewDescTable and the
+//     DescHeap methods that set buffers
+//   - GPU.NewPipeline with a *CompState
+//   - GPU.NewCmdBuffer and the CmdBuffer methods Begin,
+//     End, Reset, SetPipeline, SetDescTableComp,
+//     Dispatch, CopyBuffer, Fill and Barrier
+//   - GPU.Commit, WorkItem and Timeline
+//   - GPU.Limits and GPU.Features
+//
+// Presentation (GPU.NewSwapchain) is not available on
+// a GPU opened in this way; it fails with
+// ErrCannotPresent.
 package driver
 
 import (
 	"errors"
+	"strings"
 	"sync"
 )
 
@@ -41,21 +66,90 @@ var ErrNotInstalled = errors.New("driver: missing required library")
 // found.
 var ErrNoDevice = errors.New("driver: no suitable device found")
 
+// Error kinds.
+// Errors returned by drivers (and by packages built on
+// top of them) wrap one of these when applicable, so
+// callers can classify errors with errors.Is rather
+// than by inspecting their messages.
+var (
+	// ErrInvalidParam means that a call was made with
+	// invalid parameters or in an invalid state.
+	ErrInvalidParam = errors.New("driver: invalid parameter")
+	// ErrOutOfMemory means that host or device memory
+	// could not be allocated.
+	ErrOutOfMemory = errors.New("driver: out of memory")
+	// ErrDeviceLost means that the GPU device was lost.
+	// errors.Is reports true for a *DeviceLostError.
+	ErrDeviceLost = errors.New("driver: device lost")
+	// ErrUnsupported means that a feature is not
+	// supported by the driver or device.
+	ErrUnsupported = errors.New("driver: not supported")
+)
+
+// kindError is an error that wraps an error kind.
+type kindError struct {
+	msg  string
+	kind error
+}
+
+// Error implements error.
+func (e *kindError) Error() string { return e.msg }
+
+// Unwrap returns the error kind.
+func (e *kindError) Unwrap() error { return e.kind }
+
+// NewError returns an error whose message is msg and
+// that wraps kind (one of the error kinds above).
+// Driver implementations use it to create errors that
+// can be classified with errors.Is.
+func NewError(msg string, kind error) error { return &kindError{msg, kind} }
+
 // ErrNoHostMemory means that host memory could not be
 // allocated.
-var ErrNoHostMemory = errors.New("driver: out of host memory")
+// It wraps ErrOutOfMemory.
+var ErrNoHostMemory = NewError("driver: out of host memory", ErrOutOfMemory)
 
 // ErrNoDeviceMemory means that device memory could not
 // be allocated.
-var ErrNoDeviceMemory = errors.New("driver: out of device memory")
+// It wraps ErrOutOfMemory.
+var ErrNoDeviceMemory = NewError("driver: out of device memory", ErrOutOfMemory)
 
 // ErrFatal means that the driver is in an unrecoverable
 // state. Upon encountering such an error, the application
 // must destroy everything that it created using the
 // driver's GPU and then call the Close method. It may call
 // Open again to reinitialize the driver for further use.
+// Fatal errors may wrap ErrFatal (e.g., *DeviceLostError),
+// so errors.Is must be used to test for it.
 var ErrFatal = errors.New("driver: fatal error")
 
+// DeviceLostError is a fatal error that means that the
+// GPU device was lost (e.g., due to a hardware fault or
+// a driver timeout).
+// It wraps ErrFatal, so errors.Is(err, ErrFatal) reports
+// true for a *DeviceLostError. errors.Is(err,
+// ErrDeviceLost) reports true as well.
+type DeviceLostError struct {
+	// Op identifies the operation that
+	// observed the device loss.
+	// It may be empty.
+	Op string
+}
+
+// Error implements error.
+func (e *DeviceLostError) Error() string {
+	if e.Op == "" {
+		return "driver: device lost"
+	}
+	return "driver: device lost (" + e.Op + ")"
+}
+
+// Unwrap returns ErrFatal.
+func (e *DeviceLostError) Unwrap() error { return ErrFatal }
+
+// Is reports whether target is ErrDeviceLost.
+func (e *DeviceLostError) Is(target error) bool { return target == ErrDeviceLost }
+
 // Drivers returns the registered Drivers.
 // Client code imports specific driver packages, and then
 // call this function from init. As such, drivers that do
@@ -86,6 +180,170 @@ func Register(drv Driver) {
 	drivers = append(drivers, drv)
 }
 
+// ErrNoDriver means that no registered Driver matches
+// the name given to Open.
+var ErrNoDriver = errors.New("driver: driver not found")
+
+// AdapterType is the type of an Adapter.
+type AdapterType int
+
+// Adapter types.
+const (
+	AOther AdapterType = iota
+	AIntegrated
+	ADiscrete
+	AVirtual
+	ACPU
+)
+
+// Adapter describes a device that a Driver can use.
+type Adapter struct {
+	// Name of the device.
+	Name string
+	// Vendor ID of the device (e.g., PCI vendor ID).
+	Vendor uint32
+	// Type of the device.
+	Type AdapterType
+	// Amount of device-local memory, in bytes.
+	Memory int64
+}
+
+// Enumerator is the interface that a Driver may implement
+// to allow selection of the device to use.
+type Enumerator interface {
+	// Adapters returns the devices that the driver can
+	// use, in no particular order.
+	// It may partially initialize the driver, so Close
+	// must still be called if Open is never called.
+	Adapters() ([]Adapter, error)
+
+	// OpenAdapter is like Open, but uses the device
+	// identified by index in the list returned by
+	// Adapters.
+	// If the driver is already open, index must refer
+	// to the device in use.
+	OpenAdapter(index int) (GPU, error)
+}
+
+// Headless is the interface that a Driver may implement
+// to allow initialization without presentation support.
+type Headless interface {
+	// OpenHeadless is like Open, but does not initialize
+	// anything that is only needed for presentation.
+	// If the driver implements Enumerator and index is
+	// not negative, it identifies the device to use as
+	// in OpenAdapter. Otherwise, the driver chooses the
+	// device.
+	// If the driver is already open, it must have been
+	// opened by OpenHeadless with the same index.
+	OpenHeadless(index int) (GPU, error)
+}
+
+// Options are used to configure Open.
+type Options struct {
+	// Adapter selects the first device whose name
+	// contains this string (case insensitive).
+	// Only drivers that implement Enumerator are
+	// considered when set.
+	// If empty, the driver chooses the device.
+	Adapter string
+
+	// Headless requests a compute-only GPU, with no
+	// presentation support.
+	// Only drivers that implement Headless are
+	// considered when set.
+	Headless bool
+}
+
+// Open opens the first registered Driver whose name
+// contains name (case insensitive) and that succeeds
+// in opening.
+// If name is the empty string, then all registered
+// drivers are considered.
+// opts can be nil.
+func Open(name string, opts *Options) (Driver, GPU, error) {
+	err := ErrNoDriver
+	name = strings.ToLower(name)
+	for _, drv := range Drivers() {
+		if !strings.Contains(strings.ToLower(drv.Name()), name) {
+			continue
+		}
+		var gpu GPU
+		if gpu, err = OpenWith(drv, opts); err == nil {
+			return drv, gpu, nil
+		}
+	}
+	return nil, nil, err
+}
+
+// OpenWith opens drv as configured by opts.
+// It is used by Open, and can be used to open a
+// driver again after a call to Close (e.g., for
+// recovery from device loss) with the same options.
+// opts can be nil.
+func OpenWith(drv Driver, opts *Options) (GPU, error) {
+	switch {
+	case opts == nil:
+		return drv.Open()
+	case opts.Headless:
+		return openHeadless(drv, strings.ToLower(opts.Adapter))
+	case opts.Adapter == "":
+		return drv.Open()
+	default:
+		return openAdapter(drv, strings.ToLower(opts.Adapter))
+	}
+}
+
+// openAdapter opens drv using the first adapter whose
+// name contains name.
+func openAdapter(drv Driver, name string) (GPU, error) {
+	i, err := findAdapter(drv, name)
+	if err != nil {
+		return nil, err
+	}
+	return drv.(Enumerator).OpenAdapter(i)
+}
+
+// openHeadless opens drv with no presentation support.
+// If name is not empty, the first adapter whose name
+// contains name is used.
+func openHeadless(drv Driver, name string) (GPU, error) {
+	hl, ok := drv.(Headless)
+	if !ok {
+		return nil, ErrNoDriver
+	}
+	i := -1
+	if name != "" {
+		var err error
+		if i, err = findAdapter(drv, name); err != nil {
+			return nil, err
+		}
+	}
+	return hl.OpenHeadless(i)
+}
+
+// findAdapter returns the index of the first adapter of
+// drv whose name contains name.
+// drv is closed on failure.
+func findAdapter(drv Driver, name string) (int, error) {
+	enum, ok := drv.(Enumerator)
+	if !ok {
+		return -1, ErrNoDevice
+	}
+	adapters, err := enum.Adapters()
+	if err != nil {
+		drv.Close()
+		return -1, err
+	}
+	for i := range adapters {
+		if strings.Contains(strings.ToLower(adapters[i].Name), name) {
+			return i, nil
+		}
+	}
+	drv.Close()
+	return -1, ErrNoDevice
+}
+
 // Variables for driver registration.
 var (
 	mu      sync.Mutex // Unnecessary currently.
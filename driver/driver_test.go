@@ -3,6 +3,7 @@
 package driver_test
 
 import (
+	"errors"
 	"testing"
 
 	"gviegas/neo3/driver"
@@ -59,3 +60,89 @@ func TestDriverOpen(t *testing.T) {
 		t.Fatal("Driver.Open: unexpected GPU value")
 	}
 }
+
+// fakeDriver is a Driver that implements Enumerator.
+type fakeDriver struct {
+	open    int // Adapter index plus one.
+	adapter []driver.Adapter
+}
+
+func (d *fakeDriver) Open() (driver.GPU, error) { return d.OpenAdapter(0) }
+func (d *fakeDriver) Name() string              { return "fake" }
+func (d *fakeDriver) Close()                    { d.open = 0 }
+
+func (d *fakeDriver) Adapters() ([]driver.Adapter, error) { return d.adapter, nil }
+
+func (d *fakeDriver) OpenAdapter(index int) (driver.GPU, error) {
+	if index < 0 || index >= len(d.adapter) {
+		return nil, driver.ErrNoDevice
+	}
+	d.open = index + 1
+	return nil, nil
+}
+
+func TestOpen(t *testing.T) {
+	fake := &fakeDriver{adapter: []driver.Adapter{
+		{Name: "Foo GPU", Type: driver.AIntegrated},
+		{Name: "Bar GPU", Type: driver.ADiscrete},
+	}}
+	driver.Register(fake)
+
+	if _, _, err := driver.Open("no such driver", nil); err != driver.ErrNoDriver {
+		t.Fatalf("driver.Open:\nhave %v\nwant %v", err, driver.ErrNoDriver)
+	}
+	drv, _, err := driver.Open("FAKE", nil)
+	if err != nil {
+		t.Fatalf("driver.Open failed: %v", err)
+	}
+	if drv != fake || fake.open != 1 {
+		t.Fatal("driver.Open: unexpected driver/adapter")
+	}
+	drv.Close()
+	if _, _, err = driver.Open("fake", &driver.Options{Adapter: "bar"}); err != nil {
+		t.Fatalf("driver.Open failed: %v", err)
+	}
+	if fake.open != 2 {
+		t.Fatalf("driver.Open: adapter\nhave %d\nwant 1", fake.open-1)
+	}
+	fake.Close()
+	if _, _, err = driver.Open("fake", &driver.Options{Adapter: "baz"}); err != driver.ErrNoDevice {
+		t.Fatalf("driver.Open:\nhave %v\nwant %v", err, driver.ErrNoDevice)
+	}
+	if _, err = driver.OpenWith(fake, &driver.Options{Adapter: "BAR"}); err != nil {
+		t.Fatalf("driver.OpenWith failed: %v", err)
+	}
+	if fake.open != 2 {
+		t.Fatalf("driver.OpenWith: adapter\nhave %d\nwant 1", fake.open-1)
+	}
+	fake.Close()
+	if _, err = driver.OpenWith(fake, &driver.Options{Headless: true}); err != driver.ErrNoDriver {
+		t.Fatalf("driver.OpenWith:\nhave %v\nwant %v", err, driver.ErrNoDriver)
+	}
+}
+
+func TestErrorKinds(t *testing.T) {
+	for _, x := range [...]struct {
+		err  error
+		kind error
+	}{
+		{driver.ErrNoHostMemory, driver.ErrOutOfMemory},
+		{driver.ErrNoDeviceMemory, driver.ErrOutOfMemory},
+		{&driver.DeviceLostError{Op: "Commit"}, driver.ErrDeviceLost},
+		{&driver.DeviceLostError{}, driver.ErrFatal},
+		{driver.ErrCannotPresent, driver.ErrUnsupported},
+		{driver.ErrHandleType, driver.ErrUnsupported},
+		{driver.ErrBufferRange, driver.ErrInvalidParam},
+		{driver.NewError("x", driver.ErrUnsupported), driver.ErrUnsupported},
+	} {
+		if !errors.Is(x.err, x.kind) {
+			t.Fatalf("errors.Is(%v, %v)\nhave false\nwant true", x.err, x.kind)
+		}
+	}
+	if errors.Is(driver.ErrNoHostMemory, driver.ErrUnsupported) {
+		t.Fatal("errors.Is(driver.ErrNoHostMemory, driver.ErrUnsupported)\nhave true\nwant false")
+	}
+	if s := driver.NewError("x", driver.ErrUnsupported).Error(); s != "x" {
+		t.Fatalf("driver.NewError: Error\nhave %s\nwant x", s)
+	}
+}
@@ -72,10 +72,18 @@ func Example_draw() {
 	}
 	defer upld.Destroy()
 
-	p := upld.Bytes()
-	copy(p, unsafe.Slice((*byte)(unsafe.Pointer(&triPos[0])), triPosSize))
-	copy(p[triPosSize:], unsafe.Slice((*byte)(unsafe.Pointer(&triCol[0])), triColSize))
-	copy(p[bsz-256:], unsafe.Slice((*byte)(unsafe.Pointer(&triM[0])), triMSize))
+	for _, x := range [...]struct {
+		off  int64
+		data []float32
+	}{
+		{0, triPos[:]},
+		{triPosSize, triCol[:]},
+		{bsz - 256, triM[:]},
+	} {
+		if err := driver.WriteAt(upld, x.off, x.data); err != nil {
+			log.Fatal(err)
+		}
+	}
 
 	// Get the shaders.
 	var shd [2]struct {
@@ -4,6 +4,7 @@ package driver_test
 
 import (
 	"bytes"
+	"errors"
 	"image"
 	"image/png"
 	"log"
@@ -248,13 +249,15 @@ func (t *T) bufferSetup() {
 
 	// Since vertex/index data is not going to change,
 	// we can copy it upfront.
-	stg := stgBuf.Bytes()
-	pos := unsafe.Slice((*byte)(unsafe.Pointer(&cubePos[0])), cubePosSize)
-	uv := unsafe.Slice((*byte)(unsafe.Pointer(&cubeUV[0])), cubeUVSize)
-	idx := unsafe.Slice((*byte)(unsafe.Pointer(&cubeIdx[0])), cubeIdxSize)
-	copy(stg, pos)
-	copy(stg[cubePosSize:], uv)
-	copy(stg[vbSize:], idx)
+	if err := driver.WriteAt(stgBuf, 0, cubePos[:]); err != nil {
+		log.Fatal(err)
+	}
+	if err := driver.WriteAt(stgBuf, cubePosSize, cubeUV[:]); err != nil {
+		log.Fatal(err)
+	}
+	if err := driver.WriteAt(stgBuf, vbSize, cubeIdx[:]); err != nil {
+		log.Fatal(err)
+	}
 	if err := t.cb[0].Begin(); err != nil {
 		log.Fatal(err)
 	}
@@ -513,8 +516,8 @@ func (t *T) renderLoop() {
 	for !t.quit {
 		wk := <-t.ch
 		if err = wk.Err; err != nil {
-			switch err {
-			case driver.ErrFatal:
+			switch {
+			case errors.Is(err, driver.ErrFatal):
 				log.Fatal(err)
 			default:
 				log.Printf("GPU.Commit <send>: %v\n", err)
@@ -564,7 +567,9 @@ func (t *T) renderLoop() {
 		// Note that, as long as we use the same buffer range,
 		// we need not set the descriptor heap again.
 		t.updateTransform(dt)
-		copy(t.stgBuf.Bytes()[256*frame:], unsafe.Slice((*byte)(unsafe.Pointer(&t.xform[0])), 64))
+		if err := driver.WriteAt(t.stgBuf, int64(256*frame), t.xform[:]); err != nil {
+			log.Fatal(err)
+		}
 		cb.CopyBuffer(&driver.BufferCopy{
 			From:    t.stgBuf,
 			FromOff: int64(256 * frame),
@@ -0,0 +1,110 @@
+// Copyright 2024 Gustavo C. Viegas. All rights reserved.
+
+package driver
+
+// ErrHandleType means that the driver and/or device do not
+// support a given HandleType.
+// It wraps ErrUnsupported.
+var ErrHandleType = NewError("driver: handle type not supported", ErrUnsupported)
+
+// HandleType is the type of an external memory handle.
+type HandleType int
+
+// Handle types.
+const (
+	// Opaque file descriptor (POSIX).
+	// It can only be shared between instances of the
+	// same driver and device.
+	HOpaqueFD HandleType = iota
+	// Linux DMA-BUF file descriptor.
+	// Only linear DMA-BUFs are supported (i.e., DRM
+	// format modifiers are not), so images shared
+	// through DMA-BUF use a linear layout and must
+	// be 2D color images with one layer, one level
+	// and one sample.
+	HDMABuf
+	// Opaque NT handle (Windows).
+	// It can only be shared between instances of the
+	// same driver and device.
+	HOpaqueWin32
+)
+
+// ExternalHandle is a handle to memory that is shared
+// with other APIs or processes.
+type ExternalHandle struct {
+	Type HandleType
+	// File descriptor or NT handle, depending
+	// on Type.
+	Handle uintptr
+}
+
+// External is the interface that a GPU may implement
+// to enable sharing of memory with other APIs or
+// processes (e.g., to sample frames produced by a video
+// decoder without copying them).
+//
+// Buffers and images that are created by this interface
+// are used as any other, with two exceptions. First, the
+// ones created by NewExport* methods implement Exporter.
+// Second, images accessed by external users must have
+// their ownership transferred using Transition.Xfer.
+// In either case, the client is responsible for the
+// synchronization with the external user itself.
+type External interface {
+	// HandleTypes returns the handle types that can
+	// be imported and exported.
+	HandleTypes() []HandleType
+
+	// NewExportBuffer is like GPU.NewBuffer, but the
+	// buffer's memory can be exported as a handle of
+	// type ht.
+	NewExportBuffer(size int64, visible bool, usg Usage, ht HandleType) (Buffer, error)
+
+	// NewExportImage is like GPU.NewImage, but the
+	// image's memory can be exported as a handle of
+	// type ht.
+	NewExportImage(pf PixelFmt, size Dim3D, layers, levels, samples int, usg Usage, ht HandleType) (Image, error)
+
+	// ImportBuffer creates a new buffer backed by the
+	// memory that h refers to.
+	// size must not be greater than the size of the
+	// external memory.
+	// On success, file descriptors are owned by the
+	// driver and must not be used by the client
+	// anymore. NT handles are never owned by the
+	// driver.
+	ImportBuffer(h ExternalHandle, size int64, visible bool, usg Usage) (Buffer, error)
+
+	// ImportImage creates a new image backed by the
+	// memory that h refers to.
+	// The parameters must match the ones used to
+	// create the external image.
+	// Ownership of h is as described in ImportBuffer.
+	ImportImage(h ExternalHandle, pf PixelFmt, size Dim3D, layers, levels, samples int, usg Usage) (Image, error)
+}
+
+// Exporter is the interface that buffers and images
+// created by External.NewExport* methods implement.
+type Exporter interface {
+	// Export creates a new handle to the memory.
+	// The client owns the handle and is responsible
+	// for closing it.
+	Export() (ExternalHandle, error)
+}
+
+// Ownership specifies a transfer of ownership between
+// the GPU and an external user of the memory.
+type Ownership int
+
+// Ownership transfers.
+const (
+	// No transfer.
+	ONone Ownership = iota
+	// Acquire from an external user.
+	// LayoutBefore must match the layout left by
+	// the external user (which is usually
+	// LUndefined).
+	OAcquire
+	// Release to an external user.
+	ORelease
+)
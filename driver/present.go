@@ -10,7 +10,8 @@ import (
 
 // ErrCannotPresent means that the driver and/or device do not
 // support presentation.
-var ErrCannotPresent = errors.New("driver: presentation not supported")
+// It wraps ErrUnsupported.
+var ErrCannotPresent = NewError("driver: presentation not supported", ErrUnsupported)
 
 // ErrWindow represents an error related to a specific window.
 // This error usually indicates that a window misconfiguration
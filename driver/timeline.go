@@ -0,0 +1,119 @@
+// Copyright 2024 Gustavo C. Viegas. All rights reserved.
+
+package driver
+
+import (
+	"sync"
+)
+
+// Timeline tracks the completion of work committed to a
+// GPU using a monotonically increasing counter.
+// Each work item committed through a Timeline is given
+// the next value of the counter, and the Timeline
+// reaches that value once the work item and every work
+// item committed before it have completed execution.
+// It is safe for concurrent use.
+type Timeline struct {
+	gpu  GPU
+	cmu  sync.Mutex // Serializes Commit calls.
+	mu   sync.Mutex
+	cond sync.Cond
+	next uint64 // Last value given.
+	cur  uint64 // Value reached.
+	// Values that completed out of order.
+	pend map[uint64]bool
+	// First work item that failed, if any.
+	errVal uint64
+	err    error
+}
+
+// NewTimeline creates a new Timeline for gpu.
+// Its initial value is 0.
+func NewTimeline(gpu GPU) *Timeline {
+	t := &Timeline{gpu: gpu, pend: make(map[uint64]bool)}
+	t.cond.L = &t.mu
+	return t
+}
+
+// Commit commits wk to the GPU and returns the value
+// that the timeline will reach when wk completes.
+// wk must not be accessed until Wait is called with the
+// returned value (or a greater one).
+// Values are given in the order in which Commit calls
+// return, which is also the execution order of the
+// work items.
+// If GPU.Commit fails, no value is consumed.
+func (t *Timeline) Commit(wk *WorkItem) (uint64, error) {
+	t.cmu.Lock()
+	defer t.cmu.Unlock()
+	ch := make(chan *WorkItem, 1)
+	if err := t.gpu.Commit(wk, ch); err != nil {
+		return 0, err
+	}
+	t.mu.Lock()
+	t.next++
+	v := t.next
+	t.mu.Unlock()
+	go func() {
+		wk := <-ch
+		t.signal(v, wk.Err)
+	}()
+	return v, nil
+}
+
+// signal records the completion of the work item that
+// was given value v.
+func (t *Timeline) signal(v uint64, err error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if err != nil && (t.err == nil || v < t.errVal) {
+		t.errVal, t.err = v, err
+	}
+	if v != t.cur+1 {
+		t.pend[v] = true
+		return
+	}
+	t.cur = v
+	for t.pend[t.cur+1] {
+		delete(t.pend, t.cur+1)
+		t.cur++
+	}
+	t.cond.Broadcast()
+}
+
+// Value returns the current value of the timeline.
+// Every work item whose value is less than or equal to
+// the current value has completed execution.
+func (t *Timeline) Value() uint64 {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.cur
+}
+
+// Last returns the value given by the latest Commit.
+func (t *Timeline) Last() uint64 {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.next
+}
+
+// Wait blocks until the timeline reaches v.
+// It returns the WorkItem.Err of the first work item
+// that failed with a value less than or equal to v, if
+// any.
+// It fails without blocking if v is greater than the
+// value given by the latest Commit call.
+func (t *Timeline) Wait(v uint64) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if v > t.next {
+		return NewError("driver: Timeline.Wait: value not committed", ErrInvalidParam)
+	}
+	for t.cur < v {
+		t.cond.Wait()
+	}
+	if t.err != nil && t.errVal <= v {
+		return t.err
+	}
+	return nil
+}
@@ -0,0 +1,91 @@
+// Copyright 2024 Gustavo C. Viegas. All rights reserved.
+
+package driver_test
+
+import (
+	"errors"
+	"sync"
+	"testing"
+
+	"gviegas/neo3/driver"
+)
+
+// timelineGPU is a driver.GPU whose Commit method
+// completes work items only when asked to.
+type timelineGPU struct {
+	driver.GPU
+	mu  sync.Mutex
+	chs []chan<- *driver.WorkItem
+	wks []*driver.WorkItem
+}
+
+func (g *timelineGPU) Commit(wk *driver.WorkItem, ch chan<- *driver.WorkItem) error {
+	if wk.Custom != nil {
+		return wk.Custom.(error)
+	}
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.chs = append(g.chs, ch)
+	g.wks = append(g.wks, wk)
+	return nil
+}
+
+func (g *timelineGPU) complete(i int, err error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.wks[i].Err = err
+	g.chs[i] <- g.wks[i]
+}
+
+func TestTimeline(t *testing.T) {
+	g := new(timelineGPU)
+	tl := driver.NewTimeline(g)
+	if v := tl.Value(); v != 0 {
+		t.Fatalf("Timeline.Value:\nhave %d\nwant 0", v)
+	}
+	if err := tl.Wait(0); err != nil {
+		t.Fatalf("Timeline.Wait(0):\nhave %v\nwant nil", err)
+	}
+	for i := range 4 {
+		v, err := tl.Commit(new(driver.WorkItem))
+		if err != nil {
+			t.Fatalf("Timeline.Commit failed: %v", err)
+		}
+		if v != uint64(i+1) {
+			t.Fatalf("Timeline.Commit:\nhave %d\nwant %d", v, i+1)
+		}
+	}
+	errCommit := errors.New("commit")
+	if _, err := tl.Commit(&driver.WorkItem{Custom: errCommit}); err != errCommit {
+		t.Fatalf("Timeline.Commit:\nhave %v\nwant %v", err, errCommit)
+	}
+	if v := tl.Last(); v != 4 {
+		t.Fatalf("Timeline.Last:\nhave %d\nwant 4", v)
+	}
+	if err := tl.Wait(5); err == nil {
+		t.Fatal("Timeline.Wait(5):\nhave nil\nwant non-nil")
+	}
+
+	// Out of order completion must not advance
+	// the timeline past pending work.
+	errExec := errors.New("exec")
+	g.complete(1, nil)
+	g.complete(3, errExec)
+	g.complete(0, nil)
+	if err := tl.Wait(2); err != nil {
+		t.Fatalf("Timeline.Wait(2):\nhave %v\nwant nil", err)
+	}
+	if v := tl.Value(); v != 2 {
+		t.Fatalf("Timeline.Value:\nhave %d\nwant 2", v)
+	}
+	g.complete(2, nil)
+	if err := tl.Wait(4); err != errExec {
+		t.Fatalf("Timeline.Wait(4):\nhave %v\nwant %v", err, errExec)
+	}
+	if err := tl.Wait(3); err != nil {
+		t.Fatalf("Timeline.Wait(3):\nhave %v\nwant nil", err)
+	}
+	if v := tl.Value(); v != 4 {
+		t.Fatalf("Timeline.Value:\nhave %d\nwant 4", v)
+	}
+}
@@ -0,0 +1,784 @@
+// Copyright 2024 Gustavo C. Viegas. All rights reserved.
+
+package validate
+
+import (
+	"fmt"
+	"math/bits"
+	"sync/atomic"
+
+	"gviegas/neo3/driver"
+)
+
+// cmdBuffer implements driver.CmdBuffer.
+type cmdBuffer struct {
+	driver.CmdBuffer
+	g *gpu
+
+	rec    bool
+	inPass bool
+	ended  bool
+	// Whether it was created by
+	// NewComputeCmdBuffer.
+	compute bool
+	// Set while committed for execution.
+	pending atomic.Bool
+
+	// First error found during recording.
+	// Once set, commands are no longer
+	// forwarded to the wrapped CmdBuffer.
+	err error
+
+	// Layout changes made by recorded
+	// transitions, in recording order.
+	// Image layouts are tracked as if
+	// transitions executed when recorded,
+	// so that command buffers recorded in
+	// sequence see each other's changes.
+	// These are undone if the commands are
+	// discarded instead of committed.
+	undo []layoutUndo
+}
+
+// layoutUndo records the layouts that a transition
+// replaced in a subresource range of an image.
+type layoutUndo struct {
+	img                          *image
+	layer, layers, level, levels int
+	old                          []driver.Layout
+}
+
+// discard undoes the layout changes of the commands
+// recorded in cb, most recent first.
+func (cb *cmdBuffer) discard() {
+	for i := len(cb.undo) - 1; i >= 0; i-- {
+		x := &cb.undo[i]
+		x.img.setLayout(x.layer, x.layers, x.level, x.levels, x.old)
+	}
+	cb.undo = nil
+}
+
+// Where a command is allowed to be recorded.
+const (
+	anywhere = iota
+	duringPass
+	outsidePass
+)
+
+// fail records the first error found during recording.
+func (cb *cmdBuffer) fail(reason string) {
+	if cb.err == nil {
+		cb.err = newErr(reason)
+	}
+}
+
+// valid checks whether cmd can be recorded.
+func (cb *cmdBuffer) valid(cmd string, where int) bool {
+	switch {
+	case cb.err != nil:
+	case !cb.rec:
+		cb.fail("CmdBuffer." + cmd + " called while not recording")
+	case where == duringPass && !cb.inPass:
+		cb.fail("CmdBuffer." + cmd + " called outside of a render pass")
+	case where == outsidePass && cb.inPass:
+		cb.fail("CmdBuffer." + cmd + " called during a render pass")
+	default:
+		return true
+	}
+	return false
+}
+
+// graphics checks whether cmd, which requires graphics
+// support, can be recorded.
+// Command buffers created by NewComputeCmdBuffer
+// cannot record such commands.
+func (cb *cmdBuffer) graphics(cmd string) bool {
+	if cb.compute {
+		cb.fail("CmdBuffer." + cmd + " called on compute command buffer")
+		return false
+	}
+	return true
+}
+
+// Begin prepares the command buffer for recording.
+func (cb *cmdBuffer) Begin() error {
+	switch {
+	case cb.pending.Load():
+		return newErr("CmdBuffer.Begin called while pending execution")
+	case cb.rec:
+		return newErr("CmdBuffer.Begin called while recording")
+	}
+	if err := cb.CmdBuffer.Begin(); err != nil {
+		return err
+	}
+	// Commands recorded but not committed
+	// are discarded.
+	cb.discard()
+	cb.rec = true
+	cb.inPass = false
+	cb.ended = false
+	cb.err = nil
+	return nil
+}
+
+// BeginPass begins a render pass.
+func (cb *cmdBuffer) BeginPass(width, height, layers int, color []driver.ColorTarget, ds *driver.DSTarget) {
+	if !cb.valid("BeginPass", outsidePass) || !cb.graphics("BeginPass") {
+		return
+	}
+	color, ds, ok := cb.beginPass("BeginPass", width, height, layers, color, ds)
+	if ok {
+		cb.CmdBuffer.BeginPass(width, height, layers, color, ds)
+		cb.inPass = true
+	}
+}
+
+// BeginPassViews begins a multiview render pass.
+func (cb *cmdBuffer) BeginPassViews(width, height int, viewMask uint32, color []driver.ColorTarget, ds *driver.DSTarget) {
+	if !cb.valid("BeginPassViews", outsidePass) || !cb.graphics("BeginPassViews") {
+		return
+	}
+	switch {
+	case !cb.g.Features().Multiview:
+		cb.fail("CmdBuffer.BeginPassViews requires driver.Features.Multiview")
+		return
+	case viewMask == 0:
+		cb.fail("CmdBuffer.BeginPassViews called with zero view mask")
+		return
+	}
+	// Views are routed to layers, so the targets must
+	// have one layer per bit up to the last one set.
+	color, ds, ok := cb.beginPass("BeginPassViews", width, height, bits.Len32(viewMask), color, ds)
+	if ok {
+		cb.CmdBuffer.BeginPassViews(width, height, viewMask, color, ds)
+		cb.inPass = true
+	}
+}
+
+// beginPass validates the parameters of a BeginPass*
+// call and returns copies of color and ds that are
+// suitable for the wrapped command buffer.
+func (cb *cmdBuffer) beginPass(name string, width, height, layers int, color []driver.ColorTarget, ds *driver.DSTarget) ([]driver.ColorTarget, *driver.DSTarget, bool) {
+	lim := cb.g.Limits()
+	switch {
+	case width < 1 || height < 1 || layers < 1:
+		cb.fail("CmdBuffer." + name + " called with invalid render size")
+		return nil, nil, false
+	case width > lim.MaxRenderSize[0] || height > lim.MaxRenderSize[1] || layers > lim.MaxRenderLayers:
+		cb.fail("CmdBuffer." + name + " called with render size exceeding limits")
+		return nil, nil, false
+	case len(color) > lim.MaxColorTargets:
+		cb.fail("CmdBuffer." + name + " called with too many color targets")
+		return nil, nil, false
+	case len(color) == 0 && ds == nil:
+		cb.fail("CmdBuffer." + name + " called with no render target")
+		return nil, nil, false
+	}
+	icolor := make([]driver.ColorTarget, len(color))
+	for i := range color {
+		icolor[i] = color[i]
+		if !cb.target(name, color[i].Color, layers, driver.LColorTarget, "color") {
+			return nil, nil, false
+		}
+		if transient(color[i].Color) && (color[i].Load == driver.LLoad || color[i].Store == driver.SStore) {
+			cb.fail("CmdBuffer." + name + " called with transient color target that is loaded or stored")
+			return nil, nil, false
+		}
+		icolor[i].Color = unwrapView(color[i].Color)
+		if color[i].Resolve != nil {
+			if !cb.target(name, color[i].Resolve, layers, driver.LColorTarget, "resolve") {
+				return nil, nil, false
+			}
+			icolor[i].Resolve = unwrapView(color[i].Resolve)
+		}
+	}
+	var ids *driver.DSTarget
+	if ds != nil {
+		l := driver.LDSTarget
+		if ds.DSRead {
+			l = driver.LDSRead
+		}
+		if !cb.target(name, ds.DS, layers, l, "depth/stencil") {
+			return nil, nil, false
+		}
+		if transient(ds.DS) && (ds.LoadD == driver.LLoad || ds.StoreD == driver.SStore || ds.LoadS == driver.LLoad || ds.StoreS == driver.SStore) {
+			cb.fail("CmdBuffer." + name + " called with transient depth/stencil target that is loaded or stored")
+			return nil, nil, false
+		}
+		x := *ds
+		x.DS = unwrapView(ds.DS)
+		if ds.Resolve != nil {
+			if !cb.target(name, ds.Resolve, layers, l, "depth/stencil resolve") {
+				return nil, nil, false
+			}
+			x.Resolve = unwrapView(ds.Resolve)
+		}
+		ids = &x
+	}
+	return icolor, ids, true
+}
+
+// target validates a render target view that must
+// have at least the given number of layers.
+// Views not created by the validating GPU (e.g., from a
+// swapchain) are only checked for nil.
+func (cb *cmdBuffer) target(name string, iv driver.ImageView, layers int, l driver.Layout, what string) bool {
+	if iv == nil {
+		cb.fail("CmdBuffer." + name + " called with nil " + what + " target")
+		return false
+	}
+	v, ok := iv.(*imageView)
+	if !ok {
+		return true
+	}
+	switch {
+	case v.img.usg&driver.URenderTarget == 0:
+		cb.fail("CmdBuffer." + name + " called with " + what + " target lacking driver.URenderTarget usage")
+		return false
+	case v.layers < layers:
+		cb.fail("CmdBuffer." + name + " called with " + what + " target having too few layers")
+		return false
+	case !v.img.checkLayout(v.layer, v.layers, v.level, v.levels, l):
+		cb.fail("CmdBuffer." + name + " called with " + what + " target in wrong layout")
+		return false
+	}
+	return true
+}
+
+// transient returns whether iv is a view of an image
+// created with driver.UTransient.
+func transient(iv driver.ImageView) bool {
+	v, ok := iv.(*imageView)
+	return ok && v.img.usg&driver.UTransient != 0
+}
+
+// EndPass ends the current render pass.
+func (cb *cmdBuffer) EndPass() {
+	if cb.valid("EndPass", duringPass) {
+		cb.CmdBuffer.EndPass()
+		cb.inPass = false
+	}
+}
+
+// SetPipeline sets the pipeline.
+func (cb *cmdBuffer) SetPipeline(pl driver.Pipeline) {
+	if !cb.valid("SetPipeline", anywhere) {
+		return
+	}
+	if pl == nil {
+		cb.fail("CmdBuffer.SetPipeline called with nil pipeline")
+		return
+	}
+	cb.CmdBuffer.SetPipeline(unwrapPipeln(pl))
+}
+
+// SetViewport sets the bounds of the viewport.
+func (cb *cmdBuffer) SetViewport(vp driver.Viewport) {
+	if cb.valid("SetViewport", anywhere) {
+		cb.CmdBuffer.SetViewport(vp)
+	}
+}
+
+// SetScissor sets the scissor rectangle.
+func (cb *cmdBuffer) SetScissor(sciss driver.Scissor) {
+	if cb.valid("SetScissor", anywhere) {
+		cb.CmdBuffer.SetScissor(sciss)
+	}
+}
+
+// SetBlendColor sets the constant blend color.
+func (cb *cmdBuffer) SetBlendColor(r, g, b, a float32) {
+	if cb.valid("SetBlendColor", anywhere) {
+		cb.CmdBuffer.SetBlendColor(r, g, b, a)
+	}
+}
+
+// SetStencilRef sets the stencil reference value.
+func (cb *cmdBuffer) SetStencilRef(value uint32) {
+	if cb.valid("SetStencilRef", anywhere) {
+		cb.CmdBuffer.SetStencilRef(value)
+	}
+}
+
+// SetVertexBuf sets one or more vertex buffers.
+func (cb *cmdBuffer) SetVertexBuf(start int, buf []driver.Buffer, off []int64) {
+	if !cb.valid("SetVertexBuf", anywhere) {
+		return
+	}
+	if len(buf) != len(off) {
+		cb.fail("CmdBuffer.SetVertexBuf called with mismatched slice lengths")
+		return
+	}
+	if start < 0 || start+len(buf) > cb.g.Limits().MaxVertexIn {
+		cb.fail("CmdBuffer.SetVertexBuf called with range out of bounds")
+		return
+	}
+	ibuf := make([]driver.Buffer, len(buf))
+	for i := range buf {
+		if !cb.buffer("SetVertexBuf", buf[i], driver.UVertexData, off[i], 0) {
+			return
+		}
+		ibuf[i] = unwrapBuf(buf[i])
+	}
+	cb.CmdBuffer.SetVertexBuf(start, ibuf, off)
+}
+
+// SetIndexBuf sets the index buffer.
+func (cb *cmdBuffer) SetIndexBuf(format driver.IndexFmt, buf driver.Buffer, off int64) {
+	if !cb.valid("SetIndexBuf", anywhere) {
+		return
+	}
+	switch {
+	case format != driver.Index16 && format != driver.Index32:
+		cb.fail("CmdBuffer.SetIndexBuf called with undefined index format")
+		return
+	case off&3 != 0:
+		cb.fail("CmdBuffer.SetIndexBuf called with misaligned offset")
+		return
+	}
+	if cb.buffer("SetIndexBuf", buf, driver.UIndexData, off, 0) {
+		cb.CmdBuffer.SetIndexBuf(format, unwrapBuf(buf), off)
+	}
+}
+
+// buffer validates a buffer range used by cmd.
+// usg is the usage that buf must support.
+func (cb *cmdBuffer) buffer(cmd string, buf driver.Buffer, usg driver.Usage, off, size int64) bool {
+	b, ok := buf.(*buffer)
+	switch {
+	case buf == nil:
+		cb.fail("CmdBuffer." + cmd + " called with nil buffer")
+	case !ok:
+		cb.fail("CmdBuffer." + cmd + " called with foreign buffer")
+	case b.usg&usg != usg:
+		cb.fail(fmt.Sprintf("CmdBuffer.%s called with buffer lacking usage %#x", cmd, usg))
+	case off < 0 || size < 0 || off >= b.Cap() || size > b.Cap()-off:
+		cb.fail("CmdBuffer." + cmd + " called with buffer range out of bounds")
+	default:
+		return true
+	}
+	return false
+}
+
+// SetDescTableGraph sets a descriptor table range for
+// graphics pipelines.
+func (cb *cmdBuffer) SetDescTableGraph(table driver.DescTable, start int, heapCopy []int) {
+	if dt := cb.descTable("SetDescTableGraph", table, start, heapCopy); dt != nil {
+		cb.CmdBuffer.SetDescTableGraph(dt, start, heapCopy)
+	}
+}
+
+// SetDescTableComp sets a descriptor table range for
+// compute pipelines.
+func (cb *cmdBuffer) SetDescTableComp(table driver.DescTable, start int, heapCopy []int) {
+	if dt := cb.descTable("SetDescTableComp", table, start, heapCopy); dt != nil {
+		cb.CmdBuffer.SetDescTableComp(dt, start, heapCopy)
+	}
+}
+
+// descTable validates the parameters of SetDescTable*.
+// It returns the wrapped DescTable, or nil if validation
+// fails.
+func (cb *cmdBuffer) descTable(cmd string, table driver.DescTable, start int, heapCopy []int) driver.DescTable {
+	if !cb.valid(cmd, anywhere) {
+		return nil
+	}
+	dt, ok := table.(*descTable)
+	switch {
+	case table == nil:
+		cb.fail("CmdBuffer." + cmd + " called with nil descriptor table")
+		return nil
+	case !ok:
+		cb.fail("CmdBuffer." + cmd + " called with foreign descriptor table")
+		return nil
+	case start < 0 || len(heapCopy) == 0 || start+len(heapCopy) > len(dt.heaps):
+		cb.fail("CmdBuffer." + cmd + " called with heap range out of bounds")
+		return nil
+	}
+	for i, cpy := range heapCopy {
+		h := dt.heaps[start+i]
+		if cpy < 0 || cpy >= h.Len() {
+			cb.fail(fmt.Sprintf("CmdBuffer.%s called with heap copy out of bounds for heap %d", cmd, start+i))
+			return nil
+		}
+		h.mu.Lock()
+		err := h.err
+		if err == nil && h.updating {
+			err = newErr("DescHeap has deferred updates")
+		}
+		h.mu.Unlock()
+		if err != nil {
+			if cb.err == nil {
+				cb.err = fmt.Errorf("%sCmdBuffer.%s called with invalid heap %d: %w", prefix, cmd, start+i, err)
+			}
+			return nil
+		}
+	}
+	return dt.DescTable
+}
+
+// Draw draws primitives.
+func (cb *cmdBuffer) Draw(vertCnt, instCnt, baseVert, baseInst int) {
+	if !cb.valid("Draw", duringPass) {
+		return
+	}
+	if vertCnt < 0 || instCnt < 0 || baseVert < 0 || baseInst < 0 {
+		cb.fail("CmdBuffer.Draw called with negative parameter")
+		return
+	}
+	cb.CmdBuffer.Draw(vertCnt, instCnt, baseVert, baseInst)
+}
+
+// DrawIndexed draws indexed primitives.
+func (cb *cmdBuffer) DrawIndexed(idxCnt, instCnt, baseIdx, vertOff, baseInst int) {
+	if !cb.valid("DrawIndexed", duringPass) {
+		return
+	}
+	if idxCnt < 0 || instCnt < 0 || baseIdx < 0 || baseInst < 0 {
+		cb.fail("CmdBuffer.DrawIndexed called with negative parameter")
+		return
+	}
+	cb.CmdBuffer.DrawIndexed(idxCnt, instCnt, baseIdx, vertOff, baseInst)
+}
+
+// Dispatch dispatches compute thread groups.
+func (cb *cmdBuffer) Dispatch(grpCntX, grpCntY, grpCntZ int) {
+	if !cb.valid("Dispatch", outsidePass) {
+		return
+	}
+	lim := cb.g.Limits().MaxDispatch
+	for i, n := range [3]int{grpCntX, grpCntY, grpCntZ} {
+		if n < 0 || n > lim[i] {
+			cb.fail("CmdBuffer.Dispatch called with group count exceeding limits")
+			return
+		}
+	}
+	cb.CmdBuffer.Dispatch(grpCntX, grpCntY, grpCntZ)
+}
+
+// CopyBuffer copies data between buffers.
+func (cb *cmdBuffer) CopyBuffer(param *driver.BufferCopy) {
+	if !cb.valid("CopyBuffer", outsidePass) {
+		return
+	}
+	if param.Size < 1 {
+		cb.fail("CmdBuffer.CopyBuffer called with invalid size")
+		return
+	}
+	if !cb.buffer("CopyBuffer", param.From, driver.UCopySrc, param.FromOff, param.Size) ||
+		!cb.buffer("CopyBuffer", param.To, driver.UCopyDst, param.ToOff, param.Size) {
+		return
+	}
+	if param.From == param.To && param.FromOff < param.ToOff+param.Size && param.ToOff < param.FromOff+param.Size {
+		cb.fail("CmdBuffer.CopyBuffer called with overlapping ranges")
+		return
+	}
+	x := *param
+	x.From = unwrapBuf(param.From)
+	x.To = unwrapBuf(param.To)
+	cb.CmdBuffer.CopyBuffer(&x)
+}
+
+// CopyImage copies data between images.
+func (cb *cmdBuffer) CopyImage(param *driver.ImageCopy) {
+	if !cb.valid("CopyImage", outsidePass) {
+		return
+	}
+	if !cb.image("CopyImage", param.From, driver.UCopySrc, driver.LCopySrc, param.FromOff, param.FromLayer, param.Layers, param.FromLevel, param.Size) ||
+		!cb.image("CopyImage", param.To, driver.UCopyDst, driver.LCopyDst, param.ToOff, param.ToLayer, param.Layers, param.ToLevel, param.Size) {
+		return
+	}
+	x := *param
+	x.From = unwrapImg(param.From)
+	x.To = unwrapImg(param.To)
+	cb.CmdBuffer.CopyImage(&x)
+}
+
+// BlitImage copies a region of an image to a region of
+// another image, scaling and filtering it as needed.
+func (cb *cmdBuffer) BlitImage(param *driver.ImageBlit) {
+	if !cb.valid("BlitImage", outsidePass) || !cb.graphics("BlitImage") {
+		return
+	}
+	if param.Filter != driver.FNearest && param.Filter != driver.FLinear {
+		cb.fail("CmdBuffer.BlitImage called with undefined filter")
+		return
+	}
+	if !cb.image("BlitImage", param.From, driver.UCopySrc, driver.LCopySrc, param.FromOff, param.FromLayer, param.Layers, param.FromLevel, param.FromSize) ||
+		!cb.image("BlitImage", param.To, driver.UCopyDst, driver.LCopyDst, param.ToOff, param.ToLayer, param.Layers, param.ToLevel, param.ToSize) {
+		return
+	}
+	from, fok := param.From.(*image)
+	to, tok := param.To.(*image)
+	if fok && tok {
+		switch {
+		case !from.pf.IsColor() || !to.pf.IsColor():
+			cb.fail("CmdBuffer.BlitImage called with non-color image")
+			return
+		case from.pf.IsNonfloatColor() != to.pf.IsNonfloatColor():
+			cb.fail("CmdBuffer.BlitImage called with images of incompatible formats")
+			return
+		case from.pf.IsNonfloatColor() && param.Filter != driver.FNearest:
+			cb.fail("CmdBuffer.BlitImage called with linear filter for non-float format")
+			return
+		}
+	}
+	x := *param
+	x.From = unwrapImg(param.From)
+	x.To = unwrapImg(param.To)
+	cb.CmdBuffer.BlitImage(&x)
+}
+
+// CopyBufToImg copies data from a buffer to an image.
+func (cb *cmdBuffer) CopyBufToImg(param *driver.BufImgCopy) {
+	if cb.bufImg("CopyBufToImg", param, driver.UCopySrc, driver.UCopyDst, driver.LCopyDst) {
+		x := *param
+		x.Buf = unwrapBuf(param.Buf)
+		x.Img = unwrapImg(param.Img)
+		cb.CmdBuffer.CopyBufToImg(&x)
+	}
+}
+
+// CopyImgToBuf copies data from an image to a buffer.
+func (cb *cmdBuffer) CopyImgToBuf(param *driver.BufImgCopy) {
+	if cb.bufImg("CopyImgToBuf", param, driver.UCopyDst, driver.UCopySrc, driver.LCopySrc) {
+		x := *param
+		x.Buf = unwrapBuf(param.Buf)
+		x.Img = unwrapImg(param.Img)
+		cb.CmdBuffer.CopyImgToBuf(&x)
+	}
+}
+
+// bufImg validates the parameters of CopyBufToImg and
+// CopyImgToBuf.
+func (cb *cmdBuffer) bufImg(cmd string, param *driver.BufImgCopy, bufUsg, imgUsg driver.Usage, l driver.Layout) bool {
+	if !cb.valid(cmd, outsidePass) {
+		return false
+	}
+	switch {
+	case param.BufOff&511 != 0:
+		cb.fail("CmdBuffer." + cmd + " called with misaligned buffer offset")
+		return false
+	case param.RowStrd < 0 || param.SlcStrd < 0:
+		cb.fail("CmdBuffer." + cmd + " called with negative stride")
+		return false
+	}
+	if !cb.buffer(cmd, param.Buf, bufUsg, param.BufOff, 0) {
+		return false
+	}
+	return cb.image(cmd, param.Img, imgUsg, l, param.ImgOff, param.Layer, param.Layers, param.Level, param.Size)
+}
+
+// image validates an image region used by cmd.
+// usg is the usage that img must support and l is
+// the layout that the region must be in.
+// Images not created by the validating GPU (e.g.,
+// from a swapchain) are only checked for nil.
+func (cb *cmdBuffer) image(cmd string, img driver.Image, usg driver.Usage, l driver.Layout, off driver.Off3D, layer, layers, level int, size driver.Dim3D) bool {
+	if img == nil {
+		cb.fail("CmdBuffer." + cmd + " called with nil image")
+		return false
+	}
+	im, ok := img.(*image)
+	if !ok {
+		return true
+	}
+	w := max(1, im.size.Width>>level)
+	h := max(1, im.size.Height>>level)
+	d := max(1, im.size.Depth>>level)
+	switch {
+	case im.usg&usg != usg:
+		cb.fail(fmt.Sprintf("CmdBuffer.%s called with image lacking usage %#x", cmd, usg))
+	case im.samples != 1:
+		cb.fail("CmdBuffer." + cmd + " called with multisample image")
+	case layer < 0 || layers < 1 || layer+layers > im.layers:
+		cb.fail("CmdBuffer." + cmd + " called with layer range out of bounds")
+	case level < 0 || level >= im.levels:
+		cb.fail("CmdBuffer." + cmd + " called with level out of bounds")
+	case off.X < 0 || off.Y < 0 || off.Z < 0 || size.Width < 1 ||
+		off.X+size.Width > w || off.Y+max(1, size.Height) > h || off.Z+max(1, size.Depth) > d:
+		cb.fail("CmdBuffer." + cmd + " called with image region out of bounds")
+	case !im.checkLayout(layer, layers, level, 1, l):
+		cb.fail("CmdBuffer." + cmd + " called with image in wrong layout")
+	default:
+		return true
+	}
+	return false
+}
+
+// Fill fills a buffer range with copies of a byte value.
+func (cb *cmdBuffer) Fill(buf driver.Buffer, off int64, value byte, size int64) {
+	if !cb.valid("Fill", outsidePass) {
+		return
+	}
+	if off&3 != 0 || size&3 != 0 || size < 1 {
+		cb.fail("CmdBuffer.Fill called with misaligned range")
+		return
+	}
+	if cb.buffer("Fill", buf, driver.UCopyDst, off, size) {
+		cb.CmdBuffer.Fill(unwrapBuf(buf), off, value, size)
+	}
+}
+
+// UpdateBuffer writes data to a buffer range.
+func (cb *cmdBuffer) UpdateBuffer(buf driver.Buffer, off int64, data []byte) {
+	if !cb.valid("UpdateBuffer", outsidePass) {
+		return
+	}
+	size := int64(len(data))
+	switch {
+	case off&3 != 0 || size&3 != 0 || size < 1:
+		cb.fail("CmdBuffer.UpdateBuffer called with misaligned range")
+		return
+	case size > driver.MaxUpdateBuffer:
+		cb.fail("CmdBuffer.UpdateBuffer called with too much data")
+		return
+	}
+	if cb.buffer("UpdateBuffer", buf, driver.UCopyDst, off, size) {
+		cb.CmdBuffer.UpdateBuffer(unwrapBuf(buf), off, data)
+	}
+}
+
+// Barrier inserts a number of global barriers.
+func (cb *cmdBuffer) Barrier(b []driver.Barrier) {
+	if cb.valid("Barrier", outsidePass) {
+		cb.CmdBuffer.Barrier(b)
+	}
+}
+
+// Transition inserts a number of image layout transitions.
+func (cb *cmdBuffer) Transition(t []driver.Transition) {
+	if !cb.valid("Transition", outsidePass) {
+		return
+	}
+	// Every transition is validated before any
+	// layout is changed, so a failed call leaves
+	// the tracked layouts as they were.
+	it := make([]driver.Transition, len(t))
+	for i := range t {
+		it[i] = t[i]
+		if t[i].Img == nil {
+			cb.fail("CmdBuffer.Transition called with nil image")
+			return
+		}
+		if t[i].LayoutAfter == driver.LUndefined {
+			cb.fail("CmdBuffer.Transition called with driver.LUndefined as LayoutAfter")
+			return
+		}
+		if t[i].Xfer < driver.ONone || t[i].Xfer > driver.ORelease {
+			cb.fail("CmdBuffer.Transition called with undefined ownership transfer")
+			return
+		}
+		im, ok := t[i].Img.(*image)
+		if !ok {
+			continue
+		}
+		x := &t[i]
+		switch {
+		case x.Xfer != driver.ONone && !im.ext:
+			cb.fail("CmdBuffer.Transition called with ownership transfer of non-external image")
+			return
+		case x.Layer < 0 || x.Layers < 1 || x.Layer+x.Layers > im.layers:
+			cb.fail("CmdBuffer.Transition called with layer range out of bounds")
+			return
+		case x.Level < 0 || x.Levels < 1 || x.Level+x.Levels > im.levels:
+			cb.fail("CmdBuffer.Transition called with level range out of bounds")
+			return
+		case x.LayoutBefore != driver.LUndefined && !im.checkLayout(x.Layer, x.Layers, x.Level, x.Levels, x.LayoutBefore):
+			cb.fail("CmdBuffer.Transition called with LayoutBefore not matching current layout")
+			return
+		}
+		it[i].Img = im.Image
+	}
+	for i := range t {
+		im, ok := t[i].Img.(*image)
+		if !ok {
+			continue
+		}
+		x := &t[i]
+		cb.undo = append(cb.undo, layoutUndo{
+			img:    im,
+			layer:  x.Layer,
+			layers: x.Layers,
+			level:  x.Level,
+			levels: x.Levels,
+			old:    im.transition(x.Layer, x.Layers, x.Level, x.Levels, x.LayoutAfter),
+		})
+	}
+	cb.CmdBuffer.Transition(it)
+}
+
+// End ends command recording.
+func (cb *cmdBuffer) End() error {
+	if !cb.rec {
+		return newErr("CmdBuffer.End called while not recording")
+	}
+	if cb.inPass {
+		cb.fail("CmdBuffer.End called during a render pass")
+	}
+	cb.rec = false
+	cb.inPass = false
+	if err := cb.err; err != nil {
+		cb.err = nil
+		cb.discard()
+		cb.CmdBuffer.Reset()
+		return err
+	}
+	if err := cb.CmdBuffer.End(); err != nil {
+		return err
+	}
+	cb.ended = true
+	return nil
+}
+
+// Reset discards all recorded commands.
+func (cb *cmdBuffer) Reset() error {
+	if cb.pending.Load() {
+		return newErr("CmdBuffer.Reset called while pending execution")
+	}
+	cb.rec = false
+	cb.inPass = false
+	cb.ended = false
+	cb.err = nil
+	cb.discard()
+	return cb.CmdBuffer.Reset()
+}
+
+// IsRecording returns whether the command buffer has
+// begun recording commands.
+func (cb *cmdBuffer) IsRecording() bool { return cb.rec }
+
+func unwrapBuf(buf driver.Buffer) driver.Buffer {
+	if x, ok := buf.(*buffer); ok {
+		return x.Buffer
+	}
+	return buf
+}
+
+func unwrapImg(img driver.Image) driver.Image {
+	if x, ok := img.(*image); ok {
+		return x.Image
+	}
+	return img
+}
+
+func unwrapView(iv driver.ImageView) driver.ImageView {
+	if x, ok := iv.(*imageView); ok {
+		return x.ImageView
+	}
+	return iv
+}
+
+func unwrapPipeln(pl driver.Pipeline) driver.Pipeline {
+	if x, ok := pl.(*pipeline); ok {
+		return x.Pipeline
+	}
+	return pl
+}
+
+func unwrapSplr(splr driver.Sampler) driver.Sampler {
+	if x, ok := splr.(*sampler); ok {
+		return x.Sampler
+	}
+	return splr
+}
@@ -0,0 +1,129 @@
+// Copyright 2024 Gustavo C. Viegas. All rights reserved.
+
+package validate
+
+import (
+	"slices"
+
+	"gviegas/neo3/driver"
+)
+
+// HandleTypes returns the handle types that can be
+// imported and exported.
+func (g *gpu) HandleTypes() []driver.HandleType {
+	if g.ext == nil {
+		return nil
+	}
+	return g.ext.HandleTypes()
+}
+
+// checkHandle checks whether ht is supported.
+func (g *gpu) checkHandle(name string, ht driver.HandleType) error {
+	if !slices.Contains(g.HandleTypes(), ht) {
+		return newErr(name + " called with unsupported handle type")
+	}
+	return nil
+}
+
+// checkLinear checks whether an image shared through
+// a handle of type ht is within the limits of the
+// linear layout that DMA-BUF images use.
+func checkLinear(name string, ht driver.HandleType, pf driver.PixelFmt, size driver.Dim3D, layers, levels, samples int) error {
+	if ht != driver.HDMABuf {
+		return nil
+	}
+	if !pf.IsColor() || size.Height < 1 || size.Depth > 0 || layers != 1 || levels != 1 || samples != 1 {
+		return newErr(name + " called with DMA-BUF image that is not a single-level, single-layer and single-sample 2D color image")
+	}
+	return nil
+}
+
+// NewExportBuffer creates a new buffer whose memory
+// can be exported.
+func (g *gpu) NewExportBuffer(size int64, visible bool, usg driver.Usage, ht driver.HandleType) (driver.Buffer, error) {
+	const name = "External.NewExportBuffer"
+	if err := checkBuffer(name, size, usg); err != nil {
+		return nil, err
+	}
+	if err := g.checkHandle(name, ht); err != nil {
+		return nil, err
+	}
+	buf, err := g.ext.NewExportBuffer(size, visible, usg, ht)
+	if err != nil {
+		return nil, err
+	}
+	return g.newBuffer(buf, usg, true), nil
+}
+
+// NewExportImage creates a new image whose memory
+// can be exported.
+func (g *gpu) NewExportImage(pf driver.PixelFmt, size driver.Dim3D, layers, levels, samples int, usg driver.Usage, ht driver.HandleType) (driver.Image, error) {
+	const name = "External.NewExportImage"
+	if err := g.checkImage(name, pf, size, layers, levels, samples, usg); err != nil {
+		return nil, err
+	}
+	if err := g.checkHandle(name, ht); err != nil {
+		return nil, err
+	}
+	if err := checkLinear(name, ht, pf, size, layers, levels, samples); err != nil {
+		return nil, err
+	}
+	img, err := g.ext.NewExportImage(pf, size, layers, levels, samples, usg, ht)
+	if err != nil {
+		return nil, err
+	}
+	return g.newImage(img, pf, size, layers, levels, samples, usg, true, true), nil
+}
+
+// ImportBuffer creates a new buffer backed by
+// external memory.
+func (g *gpu) ImportBuffer(h driver.ExternalHandle, size int64, visible bool, usg driver.Usage) (driver.Buffer, error) {
+	const name = "External.ImportBuffer"
+	if err := checkBuffer(name, size, usg); err != nil {
+		return nil, err
+	}
+	if err := g.checkHandle(name, h.Type); err != nil {
+		return nil, err
+	}
+	buf, err := g.ext.ImportBuffer(h, size, visible, usg)
+	if err != nil {
+		return nil, err
+	}
+	return g.newBuffer(buf, usg, false), nil
+}
+
+// ImportImage creates a new image backed by external
+// memory.
+func (g *gpu) ImportImage(h driver.ExternalHandle, pf driver.PixelFmt, size driver.Dim3D, layers, levels, samples int, usg driver.Usage) (driver.Image, error) {
+	const name = "External.ImportImage"
+	if err := g.checkImage(name, pf, size, layers, levels, samples, usg); err != nil {
+		return nil, err
+	}
+	if err := g.checkHandle(name, h.Type); err != nil {
+		return nil, err
+	}
+	if err := checkLinear(name, h.Type, pf, size, layers, levels, samples); err != nil {
+		return nil, err
+	}
+	img, err := g.ext.ImportImage(h, pf, size, layers, levels, samples, usg)
+	if err != nil {
+		return nil, err
+	}
+	return g.newImage(img, pf, size, layers, levels, samples, usg, true, false), nil
+}
+
+// Export creates a new handle to the buffer's memory.
+func (b *buffer) Export() (driver.ExternalHandle, error) {
+	if !b.exp {
+		return driver.ExternalHandle{}, newErr("Buffer.Export called on non-exportable buffer")
+	}
+	return b.Buffer.(driver.Exporter).Export()
+}
+
+// Export creates a new handle to the image's memory.
+func (im *image) Export() (driver.ExternalHandle, error) {
+	if !im.exp {
+		return driver.ExternalHandle{}, newErr("Image.Export called on non-exportable image")
+	}
+	return im.Image.(driver.Exporter).Export()
+}
@@ -0,0 +1,234 @@
+// Copyright 2024 Gustavo C. Viegas. All rights reserved.
+
+package validate
+
+import (
+	"io"
+	"os"
+	"runtime"
+	"sort"
+	"strconv"
+	"sync"
+
+	"gviegas/neo3/driver"
+)
+
+// Track is like New, but the returned GPU also records
+// where every object created from it was created.
+// Objects that are not destroyed are reported as leaks
+// when the Driver of the returned GPU is closed. Leaks
+// can also be queried at any time by calling Leaks.
+// Buffers and images are also reference counted by
+// the views created from them. Destroying one that
+// still has live views, or destroying any object more
+// than once, is reported when it happens.
+func Track(g driver.GPU) driver.GPU {
+	return wrap(g, &tracker{objs: make(map[any]*Leak), w: os.Stderr})
+}
+
+// Leak describes an object that has not been destroyed.
+type Leak struct {
+	// Type is the name of the object's interface
+	// in the driver package (e.g., "Buffer").
+	Type string
+	// Stack is the stack trace of the goroutine
+	// that created the object.
+	Stack string
+	// Seq is the creation order of the object.
+	Seq int64
+	// Refs is the number of live objects that
+	// refer to the object (i.e., views of a
+	// buffer or image).
+	Refs int
+}
+
+// Leaks returns every object created from g that has not
+// been destroyed yet, in creation order.
+// g must have been returned by Track, otherwise Leaks
+// returns nil.
+func Leaks(g driver.GPU) []Leak {
+	var trk *tracker
+	switch x := g.(type) {
+	case *gpu:
+		trk = x.trk
+	case *presGPU:
+		trk = x.trk
+	}
+	if trk == nil {
+		return nil
+	}
+	return trk.leaks()
+}
+
+// tracker records live objects.
+type tracker struct {
+	mu   sync.Mutex
+	seq  int64
+	objs map[any]*Leak
+	// Where misuse and leaks are reported.
+	w io.Writer
+}
+
+// stack returns the stack trace of the calling
+// goroutine.
+func stack() string {
+	buf := make([]byte, 4096)
+	for {
+		n := runtime.Stack(buf, false)
+		if n < len(buf) {
+			return string(buf[:n])
+		}
+		buf = make([]byte, 2*len(buf))
+	}
+}
+
+// track records obj as a live object of the given type.
+// If parent is not nil, it is the object that obj
+// refers to, whose reference count is incremented.
+// It does nothing if g is not tracking.
+func (g *gpu) track(obj any, typ string, parent any) {
+	if g.trk == nil {
+		return
+	}
+	s := stack()
+	g.trk.mu.Lock()
+	g.trk.seq++
+	g.trk.objs[obj] = &Leak{Type: typ, Stack: s, Seq: g.trk.seq}
+	if x, ok := g.trk.objs[parent]; ok {
+		x.Refs++
+	}
+	g.trk.mu.Unlock()
+}
+
+// untrack removes obj, of the given type, from the
+// set of live objects.
+// If parent is not nil, it is the object that obj
+// refers to, whose reference count is decremented.
+// It reports objects that are not live (i.e., that
+// were destroyed already) and objects that are
+// still referred to.
+// It does nothing if g is not tracking.
+func (g *gpu) untrack(obj any, typ string, parent any) {
+	if g.trk == nil {
+		return
+	}
+	g.trk.mu.Lock()
+	x, ok := g.trk.objs[obj]
+	delete(g.trk.objs, obj)
+	if p, ok := g.trk.objs[parent]; ok {
+		p.Refs--
+	}
+	g.trk.mu.Unlock()
+	switch {
+	case !ok:
+		g.trk.warn(typ+" destroyed more than once", "destroyed at", stack())
+	case x.Refs > 0:
+		g.trk.warn(typ+" destroyed while "+strconv.Itoa(x.Refs)+" view(s) refer to it", "created at", x.Stack, "destroyed at", stack())
+	}
+}
+
+// warn writes msg to t.w followed by the given pairs
+// of description and stack trace.
+func (t *tracker) warn(msg string, stacks ...string) {
+	s := prefix + msg + "\n"
+	for i := 0; i+1 < len(stacks); i += 2 {
+		s += "\n" + stacks[i] + ":\n" + stacks[i+1]
+	}
+	io.WriteString(t.w, s)
+}
+
+// leaks returns the live objects in creation order.
+func (t *tracker) leaks() []Leak {
+	t.mu.Lock()
+	l := make([]Leak, 0, len(t.objs))
+	for _, x := range t.objs {
+		l = append(l, *x)
+	}
+	t.mu.Unlock()
+	sort.Slice(l, func(i, j int) bool { return l[i].Seq < l[j].Seq })
+	return l
+}
+
+// report writes the live objects to t.w.
+func (t *tracker) report() {
+	l := t.leaks()
+	if len(l) == 0 {
+		return
+	}
+	s := prefix + strconv.Itoa(len(l)) + " object(s) not destroyed\n"
+	for _, x := range l {
+		s += "\n" + x.Type + " created at:\n" + x.Stack
+	}
+	io.WriteString(t.w, s)
+}
+
+// drv implements driver.Driver.
+type drv struct {
+	driver.Driver
+	g *gpu
+}
+
+// Driver returns the Driver that owns the GPU.
+func (g *gpu) Driver() driver.Driver { return &drv{g.GPU.Driver(), g} }
+
+// Open opens the driver.
+// If it returns the wrapped GPU, then the validating GPU
+// is returned in its place.
+func (d *drv) Open() (driver.GPU, error) {
+	u, err := d.Driver.Open()
+	if err != nil {
+		return nil, err
+	}
+	if u == d.g.GPU {
+		return d.g.self, nil
+	}
+	return u, nil
+}
+
+// Close closes the driver.
+// Leaks are reported before the wrapped Driver is closed.
+func (d *drv) Close() {
+	if d.g.trk != nil {
+		d.g.trk.report()
+	}
+	d.Driver.Close()
+}
+
+// pipeline implements driver.Pipeline.
+type pipeline struct {
+	driver.Pipeline
+	g *gpu
+}
+
+// sampler implements driver.Sampler.
+type sampler struct {
+	driver.Sampler
+	g *gpu
+}
+
+// Destroy destroys the pipeline.
+func (p *pipeline) Destroy() { p.g.untrack(p, "Pipeline", nil); p.Pipeline.Destroy() }
+
+// Destroy destroys the sampler.
+func (s *sampler) Destroy() { s.g.untrack(s, "Sampler", nil); s.Sampler.Destroy() }
+
+// Destroy destroys the buffer.
+func (b *buffer) Destroy() { b.g.untrack(b, "Buffer", nil); b.Buffer.Destroy() }
+
+// Destroy destroys the buffer view.
+func (v *bufferView) Destroy() { v.buf.g.untrack(v, "BufferView", v.buf); v.BufferView.Destroy() }
+
+// Destroy destroys the image.
+func (im *image) Destroy() { im.g.untrack(im, "Image", nil); im.Image.Destroy() }
+
+// Destroy destroys the image view.
+func (v *imageView) Destroy() { v.img.g.untrack(v, "ImageView", v.img); v.ImageView.Destroy() }
+
+// Destroy destroys the descriptor heap.
+func (h *descHeap) Destroy() { h.g.untrack(h, "DescHeap", nil); h.DescHeap.Destroy() }
+
+// Destroy destroys the descriptor table.
+func (t *descTable) Destroy() { t.g.untrack(t, "DescTable", nil); t.DescTable.Destroy() }
+
+// Destroy destroys the command buffer.
+func (cb *cmdBuffer) Destroy() { cb.g.untrack(cb, "CmdBuffer", nil); cb.CmdBuffer.Destroy() }
@@ -0,0 +1,1042 @@
+// Copyright 2024 Gustavo C. Viegas. All rights reserved.
+
+// Package validate implements a driver.GPU that checks
+// API contracts at runtime.
+//
+// The GPU returned by New wraps another driver.GPU and
+// forwards valid calls to it. Calls that violate the
+// contracts documented in the driver package are not
+// forwarded. Instead, an error describing the violation
+// is returned, either directly (for methods that return
+// an error) or by the next call to CmdBuffer.End.
+//
+// Validation has a significant cost and is meant to be
+// used for debugging only.
+package validate
+
+import (
+	"fmt"
+	"slices"
+	"sync"
+
+	"gviegas/neo3/driver"
+	"gviegas/neo3/wsi"
+)
+
+const prefix = "validate: "
+
+func newErr(reason string) error { return driver.NewError(prefix+reason, driver.ErrInvalidParam) }
+
+// gpu implements driver.GPU.
+type gpu struct {
+	driver.GPU
+	// The GPU returned by New/Track.
+	self driver.GPU
+	// Nil unless created by Track.
+	trk *tracker
+	// Nil unless the wrapped GPU implements
+	// driver.External.
+	ext driver.External
+}
+
+// presGPU implements driver.GPU and driver.Presenter.
+type presGPU struct {
+	gpu
+	pres driver.Presenter
+}
+
+// New returns a driver.GPU that validates calls before
+// forwarding them to g.
+// If g implements driver.Presenter, then so does the
+// returned GPU.
+// The returned GPU always implements driver.External;
+// it supports no handle types if g does not.
+// It also implements driver.AsyncComputer, creating
+// regular command buffers if g does not.
+// Objects created from the returned GPU must not be
+// used with g directly, and vice versa.
+func New(g driver.GPU) driver.GPU { return wrap(g, nil) }
+
+// wrap creates the validating GPU.
+// trk is optional.
+func wrap(g driver.GPU, trk *tracker) driver.GPU {
+	ext, _ := g.(driver.External)
+	if p, ok := g.(driver.Presenter); ok {
+		x := &presGPU{gpu{GPU: g, trk: trk, ext: ext}, p}
+		x.self = x
+		return x
+	}
+	x := &gpu{GPU: g, trk: trk, ext: ext}
+	x.self = x
+	return x
+}
+
+// Commit validates wk and commits it for execution.
+func (g *gpu) Commit(wk *driver.WorkItem, ch chan<- *driver.WorkItem) error {
+	return g.commit("GPU.Commit", []*driver.WorkItem{wk}, ch, false)
+}
+
+// CommitBatch validates wk and commits it for execution.
+func (g *gpu) CommitBatch(wk []*driver.WorkItem, ch chan<- *driver.WorkItem) error {
+	if len(wk) == 0 {
+		return newErr("GPU.CommitBatch called with no work items")
+	}
+	return g.commit("GPU.CommitBatch", wk, ch, true)
+}
+
+// commit implements Commit and CommitBatch.
+// name identifies the method in error messages.
+func (g *gpu) commit(name string, wk []*driver.WorkItem, ch chan<- *driver.WorkItem, batch bool) error {
+	if ch == nil {
+		return newErr(name + " called with nil channel")
+	}
+	var cbs []*cmdBuffer
+	var compute, other bool
+	iwk := make([]*driver.WorkItem, len(wk))
+	for i, wk := range wk {
+		switch {
+		case wk == nil:
+			return newErr(name + " called with nil work item")
+		case len(wk.Work) == 0:
+			return newErr(name + " called with empty work item")
+		}
+		work := make([]driver.CmdBuffer, len(wk.Work))
+		var hasComp bool
+		for j, x := range wk.Work {
+			cb, ok := x.(*cmdBuffer)
+			switch {
+			case !ok:
+				return newErr(name + " called with foreign command buffer")
+			case cb.rec:
+				return newErr(name + " called with recording command buffer")
+			case !cb.ended:
+				return newErr(name + " called with command buffer that has not ended")
+			case cb.pending.Load():
+				return newErr(name + " called with pending command buffer")
+			case slices.Contains(cbs, cb):
+				return newErr(name + " called with duplicate command buffer")
+			}
+			if cb.compute {
+				compute = true
+				hasComp = true
+			} else {
+				other = true
+			}
+			cbs = append(cbs, cb)
+			work[j] = cb.CmdBuffer
+		}
+		if hasComp && !wk.Compute {
+			return newErr(name + " called with compute command buffer in non-compute work item")
+		}
+		iwk[i] = &driver.WorkItem{
+			Work:        work,
+			Custom:      i,
+			Background:  wk.Background,
+			Compute:     wk.Compute,
+			WaitCompute: wk.WaitCompute,
+		}
+	}
+	if compute && other {
+		return newErr(name + " called with compute and regular command buffers")
+	}
+	for _, cb := range cbs {
+		cb.pending.Store(true)
+	}
+	ich := make(chan *driver.WorkItem, len(iwk))
+	var err error
+	if batch {
+		err = g.GPU.CommitBatch(iwk, ich)
+	} else {
+		err = g.GPU.Commit(iwk[0], ich)
+	}
+	if err != nil {
+		for _, cb := range cbs {
+			cb.pending.Store(false)
+		}
+		return err
+	}
+	// Committed commands can no longer be
+	// discarded.
+	for _, cb := range cbs {
+		cb.undo = nil
+	}
+	go func() {
+		for range iwk {
+			x := <-ich
+			wk[x.Custom.(int)].Err = x.Err
+		}
+		for _, cb := range cbs {
+			cb.ended = false
+			cb.pending.Store(false)
+		}
+		for _, wk := range wk {
+			ch <- wk
+		}
+	}()
+	return nil
+}
+
+// NewCmdBuffer creates a new command buffer.
+func (g *gpu) NewCmdBuffer() (driver.CmdBuffer, error) {
+	cb, err := g.GPU.NewCmdBuffer()
+	if err != nil {
+		return nil, err
+	}
+	x := &cmdBuffer{CmdBuffer: cb, g: g}
+	g.track(x, "CmdBuffer", nil)
+	return x, nil
+}
+
+// NewComputeCmdBuffer creates a new command buffer
+// for compute work items.
+func (g *gpu) NewComputeCmdBuffer() (driver.CmdBuffer, error) {
+	var cb driver.CmdBuffer
+	var err error
+	if ac, ok := g.GPU.(driver.AsyncComputer); ok {
+		cb, err = ac.NewComputeCmdBuffer()
+	} else {
+		cb, err = g.GPU.NewCmdBuffer()
+	}
+	if err != nil {
+		return nil, err
+	}
+	x := &cmdBuffer{CmdBuffer: cb, g: g, compute: true}
+	g.track(x, "CmdBuffer", nil)
+	return x, nil
+}
+
+// NewDescHeap creates a new descriptor heap.
+func (g *gpu) NewDescHeap(ds []driver.Descriptor) (driver.DescHeap, error) {
+	for i := range ds {
+		switch ds[i].Type {
+		case driver.DBuffer, driver.DImage, driver.DConstant, driver.DTexture, driver.DSampler,
+			driver.DUniformTexelBuffer, driver.DStorageTexelBuffer:
+		default:
+			return nil, newErr("GPU.NewDescHeap called with undefined descriptor type")
+		}
+		if ds[i].Stages&^(driver.SVertex|driver.SFragment|driver.SCompute) != 0 || ds[i].Stages == 0 {
+			return nil, newErr("GPU.NewDescHeap called with invalid descriptor stages")
+		}
+		if ds[i].Len < 1 {
+			return nil, newErr("GPU.NewDescHeap called with invalid descriptor length")
+		}
+		for j := range i {
+			if ds[j].Nr == ds[i].Nr {
+				return nil, newErr(fmt.Sprintf("GPU.NewDescHeap called with duplicate descriptor number %d", ds[i].Nr))
+			}
+		}
+		if ds[i].Immutable != nil {
+			switch {
+			case ds[i].Type != driver.DSampler:
+				return nil, newErr("GPU.NewDescHeap called with immutable samplers for non-sampler descriptor")
+			case len(ds[i].Immutable) != ds[i].Len:
+				return nil, newErr("GPU.NewDescHeap called with immutable sampler count not matching descriptor length")
+			case slices.Contains(ds[i].Immutable, nil):
+				return nil, newErr("GPU.NewDescHeap called with nil immutable sampler")
+			}
+		}
+	}
+	// The wrapped GPU needs the wrapped samplers.
+	inner := append([]driver.Descriptor(nil), ds...)
+	for i := range inner {
+		if inner[i].Immutable != nil {
+			splr := make([]driver.Sampler, len(inner[i].Immutable))
+			for j, x := range inner[i].Immutable {
+				splr[j] = unwrapSplr(x)
+			}
+			inner[i].Immutable = splr
+		}
+	}
+	dh, err := g.GPU.NewDescHeap(inner)
+	if err != nil {
+		return nil, err
+	}
+	x := &descHeap{DescHeap: dh, g: g, ds: append([]driver.Descriptor(nil), ds...)}
+	g.track(x, "DescHeap", nil)
+	return x, nil
+}
+
+// NewDescTable creates a new descriptor table.
+func (g *gpu) NewDescTable(dh []driver.DescHeap) (driver.DescTable, error) {
+	if len(dh) > g.Limits().MaxDescHeaps {
+		return nil, newErr("GPU.NewDescTable called with too many heaps")
+	}
+	heaps := make([]*descHeap, len(dh))
+	inner := make([]driver.DescHeap, len(dh))
+	for i, x := range dh {
+		h, ok := x.(*descHeap)
+		if !ok {
+			return nil, newErr("GPU.NewDescTable called with foreign descriptor heap")
+		}
+		heaps[i] = h
+		inner[i] = h.DescHeap
+	}
+	dt, err := g.GPU.NewDescTable(inner)
+	if err != nil {
+		return nil, err
+	}
+	x := &descTable{DescTable: dt, g: g, heaps: heaps}
+	g.track(x, "DescTable", nil)
+	return x, nil
+}
+
+// NewPipeline creates a new pipeline.
+func (g *gpu) NewPipeline(state any) (driver.Pipeline, error) {
+	state, err := g.pipelineState(state)
+	if err != nil {
+		return nil, err
+	}
+	pl, err := g.GPU.NewPipeline(state)
+	if err != nil {
+		return nil, err
+	}
+	return g.newPipeline(pl), nil
+}
+
+// NewPipelines creates a number of pipelines at once.
+func (g *gpu) NewPipelines(states []any) ([]driver.Pipeline, error) {
+	inner := make([]any, len(states))
+	for i := range states {
+		var err error
+		if inner[i], err = g.pipelineState(states[i]); err != nil {
+			return nil, err
+		}
+	}
+	pls, err := g.GPU.NewPipelines(inner)
+	if err != nil {
+		return nil, err
+	}
+	for i := range pls {
+		pls[i] = g.newPipeline(pls[i])
+	}
+	return pls, nil
+}
+
+// NewPipelineAsync creates a new pipeline in the background.
+func (g *gpu) NewPipelineAsync(state any) *driver.AsyncPipeline {
+	state, err := g.pipelineState(state)
+	if err != nil {
+		return driver.NewAsyncPipeline(func() (driver.Pipeline, error) { return nil, err })
+	}
+	ap := g.GPU.NewPipelineAsync(state)
+	return driver.NewAsyncPipeline(func() (driver.Pipeline, error) {
+		pl, err := ap.Wait()
+		if err != nil {
+			return nil, err
+		}
+		return g.newPipeline(pl), nil
+	})
+}
+
+// newPipeline wraps a pipeline created by the wrapped GPU.
+func (g *gpu) newPipeline(pl driver.Pipeline) driver.Pipeline {
+	x := &pipeline{Pipeline: pl, g: g}
+	g.track(x, "Pipeline", nil)
+	return x
+}
+
+// pipelineState validates state and returns a copy of
+// it that is suitable for the wrapped GPU.
+func (g *gpu) pipelineState(state any) (any, error) {
+	switch t := state.(type) {
+	case *driver.GraphState:
+		if t.VertFunc.Code == nil {
+			return nil, newErr("GraphState.VertFunc has no code")
+		}
+		ncolor := len(t.ColorFmt)
+		if ncolor > g.Limits().MaxColorTargets {
+			return nil, newErr("GraphState.ColorFmt exceeds Limits.MaxColorTargets")
+		}
+		for _, pf := range t.ColorFmt {
+			if !pf.IsColor() {
+				return nil, newErr("GraphState.ColorFmt contains non-color format")
+			}
+		}
+		if t.DSFmt != driver.FInvalid {
+			if d, s := t.DSFmt.IsDS(); !d && !s {
+				return nil, newErr("GraphState.DSFmt is not a depth/stencil format")
+			}
+		}
+		if len(t.Input) > g.Limits().MaxVertexIn {
+			return nil, newErr("GraphState.Input exceeds Limits.MaxVertexIn")
+		}
+		switch n := t.Samples; {
+		case n < 1, n&(n-1) != 0:
+			return nil, newErr("GraphState.Samples is not a power of two")
+		case n > g.Limits().MaxRenderSamples:
+			return nil, newErr("GraphState.Samples exceeds Limits.MaxRenderSamples")
+		}
+		switch {
+		case t.Blend.IndependentBlend:
+			if !g.Features().IndependentBlend {
+				return nil, newErr("BlendState.IndependentBlend is not supported")
+			}
+			if len(t.Blend.Color) < ncolor {
+				return nil, newErr("BlendState.Color has fewer elements than GraphState.ColorFmt")
+			}
+		case t.Blend.IndependentWriteMask:
+			if len(t.Blend.Color) < ncolor {
+				return nil, newErr("BlendState.Color has fewer elements than GraphState.ColorFmt")
+			}
+		case ncolor > 0 && len(t.Blend.Color) == 0:
+			return nil, newErr("BlendState.Color is empty")
+		}
+		if t.Raster.Fill == driver.FLines && !g.Features().FLines {
+			return nil, newErr("FLines fill mode is not supported")
+		}
+		if t.ViewMask != 0 && !g.Features().Multiview {
+			return nil, newErr("GraphState.ViewMask requires driver.Features.Multiview")
+		}
+		switch n := t.Raster.ClipDistances; {
+		case n < 0:
+			return nil, newErr("RasterState.ClipDistances is negative")
+		case n > 0 && !g.Features().ClipDistance:
+			return nil, newErr("RasterState.ClipDistances requires driver.Features.ClipDistance")
+		case n > g.Limits().MaxClipDistances:
+			return nil, newErr("RasterState.ClipDistances exceeds Limits.MaxClipDistances")
+		}
+		gs := *t
+		if t.Desc != nil {
+			dt, ok := t.Desc.(*descTable)
+			if !ok {
+				return nil, newErr("GraphState.Desc is a foreign descriptor table")
+			}
+			gs.Desc = dt.DescTable
+		}
+		return &gs, nil
+	case *driver.CompState:
+		if t.Func.Code == nil {
+			return nil, newErr("CompState.Func has no code")
+		}
+		cs := *t
+		if t.Desc != nil {
+			dt, ok := t.Desc.(*descTable)
+			if !ok {
+				return nil, newErr("CompState.Desc is a foreign descriptor table")
+			}
+			cs.Desc = dt.DescTable
+		}
+		return &cs, nil
+	}
+	return nil, newErr("unknown pipeline state type")
+}
+
+// NewBuffer creates a new buffer.
+func (g *gpu) NewBuffer(size int64, visible bool, usg driver.Usage) (driver.Buffer, error) {
+	if err := checkBuffer("GPU.NewBuffer", size, usg); err != nil {
+		return nil, err
+	}
+	buf, err := g.GPU.NewBuffer(size, visible, usg)
+	if err != nil {
+		return nil, err
+	}
+	return g.newBuffer(buf, usg, false), nil
+}
+
+// checkBuffer validates the parameters of a buffer
+// creation call.
+func checkBuffer(name string, size int64, usg driver.Usage) error {
+	switch {
+	case size <= 0:
+		return newErr(name + " called with invalid size")
+	case usg&^driver.UGeneric != 0:
+		return newErr(name + " called with undefined usage")
+	case usg&driver.URenderTarget != 0:
+		return newErr(name + " called with driver.URenderTarget usage")
+	}
+	return nil
+}
+
+// newBuffer wraps buf.
+func (g *gpu) newBuffer(buf driver.Buffer, usg driver.Usage, exp bool) *buffer {
+	x := &buffer{Buffer: buf, g: g, usg: usg, exp: exp}
+	g.track(x, "Buffer", nil)
+	return x
+}
+
+// NewImage creates a new image.
+func (g *gpu) NewImage(pf driver.PixelFmt, size driver.Dim3D, layers, levels, samples int, usg driver.Usage) (driver.Image, error) {
+	if err := g.checkImage("GPU.NewImage", pf, size, layers, levels, samples, usg); err != nil {
+		return nil, err
+	}
+	img, err := g.GPU.NewImage(pf, size, layers, levels, samples, usg)
+	if err != nil {
+		return nil, err
+	}
+	return g.newImage(img, pf, size, layers, levels, samples, usg, false, false), nil
+}
+
+// checkImage validates the parameters of an image
+// creation call.
+func (g *gpu) checkImage(name string, pf driver.PixelFmt, size driver.Dim3D, layers, levels, samples int, usg driver.Usage) error {
+	lim := g.Limits()
+	switch {
+	case pf == driver.FInvalid:
+		return newErr(name + " called with invalid pixel format")
+	case size.Width < 1 || size.Height < 0 || size.Depth < 0:
+		return newErr(name + " called with invalid size")
+	case size.Depth > 0 && layers > 1:
+		return newErr(name + " called with arrayed 3D size")
+	case layers < 1 || layers > lim.MaxLayers:
+		return newErr(name + " called with invalid layer count")
+	case levels < 1:
+		return newErr(name + " called with invalid level count")
+	case samples < 1 || samples&(samples-1) != 0 || samples > lim.MaxRenderSamples:
+		return newErr(name + " called with invalid sample count")
+	case samples > 1 && levels > 1:
+		return newErr(name + " called with multisampled mipmaps")
+	case usg&^(driver.UGeneric|driver.UMutableFmt|driver.UTransient) != 0:
+		return newErr(name + " called with undefined usage")
+	case usg&driver.UTransient != 0 && usg&^(driver.URenderTarget|driver.UMutableFmt|driver.UTransient) != 0:
+		return newErr(name + " called with driver.UTransient and non-render-target usage")
+	case usg&driver.UTransient != 0 && usg&driver.URenderTarget == 0:
+		return newErr(name + " called with driver.UTransient but no driver.URenderTarget")
+	case usg&driver.UMutableFmt != 0 && !g.Features().MutableFormat:
+		return newErr(name + " called with driver.UMutableFmt (not supported)")
+	case usg&driver.UMutableFmt != 0 && !pf.IsColor():
+		return newErr(name + " called with driver.UMutableFmt and non-color format")
+	case usg&(driver.UVertexData|driver.UIndexData|driver.UShaderConst) != 0:
+		return newErr(name + " called with buffer-only usage")
+	}
+	n := max(size.Width, size.Height, size.Depth)
+	if 1<<(levels-1) > n {
+		return newErr(name + " called with too many levels")
+	}
+	return nil
+}
+
+// newImage wraps img.
+func (g *gpu) newImage(img driver.Image, pf driver.PixelFmt, size driver.Dim3D, layers, levels, samples int, usg driver.Usage, ext, exp bool) *image {
+	x := &image{
+		Image:   img,
+		g:       g,
+		pf:      pf,
+		size:    size,
+		layers:  layers,
+		levels:  levels,
+		samples: samples,
+		usg:     usg,
+		ext:     ext,
+		exp:     exp,
+		layout:  make([]driver.Layout, layers*levels),
+	}
+	g.track(x, "Image", nil)
+	return x
+}
+
+// NewSampler creates a new sampler.
+func (g *gpu) NewSampler(spln *driver.Sampling) (driver.Sampler, error) {
+	if spln == nil {
+		return nil, newErr("GPU.NewSampler called with nil sampling")
+	}
+	if spln.MinLOD < 0 || spln.MaxLOD < spln.MinLOD {
+		return nil, newErr("GPU.NewSampler called with invalid LOD range")
+	}
+	splr, err := g.GPU.NewSampler(spln)
+	if err != nil {
+		return nil, err
+	}
+	x := &sampler{Sampler: splr, g: g}
+	g.track(x, "Sampler", nil)
+	return x, nil
+}
+
+// NewSwapchain creates a new swapchain.
+func (g *presGPU) NewSwapchain(win wsi.Window, imageCount int) (driver.Swapchain, error) {
+	if win == nil {
+		return nil, newErr("Presenter.NewSwapchain called with nil window")
+	}
+	if imageCount < 1 {
+		return nil, newErr("Presenter.NewSwapchain called with invalid image count")
+	}
+	return g.pres.NewSwapchain(win, imageCount)
+}
+
+// buffer implements driver.Buffer.
+type buffer struct {
+	driver.Buffer
+	g   *gpu
+	usg driver.Usage
+	exp bool // Created by External.NewExportBuffer.
+}
+
+// NewView creates a new buffer view.
+func (b *buffer) NewView(pf driver.PixelFmt, off, size int64) (driver.BufferView, error) {
+	switch {
+	case pf == driver.FInvalid || pf.IsInternal() || !pf.IsColor():
+		return nil, newErr("Buffer.NewView called with invalid pixel format")
+	case b.usg&(driver.UShaderRead|driver.UShaderWrite|driver.UShaderConst) == 0:
+		return nil, newErr("Buffer.NewView called for buffer lacking shader usage")
+	case off&255 != 0:
+		return nil, newErr("Buffer.NewView called with misaligned offset")
+	case off < 0 || size < 1 || off+size > b.Cap():
+		return nil, newErr("Buffer.NewView called with range out of bounds")
+	case size%int64(pf.Size()) != 0:
+		return nil, newErr("Buffer.NewView called with size not multiple of texel size")
+	case size/int64(pf.Size()) > int64(b.g.Limits().MaxTexelBuffer):
+		return nil, newErr("Buffer.NewView called with too many texels")
+	}
+	bv, err := b.Buffer.NewView(pf, off, size)
+	if err != nil {
+		return nil, err
+	}
+	x := &bufferView{BufferView: bv, buf: b}
+	b.g.track(x, "BufferView", b)
+	return x, nil
+}
+
+// bufferView implements driver.BufferView.
+type bufferView struct {
+	driver.BufferView
+	buf *buffer
+}
+
+// Buffer returns the buffer from which the view was created.
+func (v *bufferView) Buffer() driver.Buffer { return v.buf }
+
+// image implements driver.Image.
+type image struct {
+	driver.Image
+	g       *gpu
+	pf      driver.PixelFmt
+	size    driver.Dim3D
+	layers  int
+	levels  int
+	samples int
+	usg     driver.Usage
+	ext     bool // Created by External.
+	exp     bool // Created by External.NewExportImage.
+
+	// Layouts indexed by layer*levels+level.
+	// They are updated as transitions are
+	// recorded in command buffers.
+	mu     sync.Mutex
+	layout []driver.Layout
+}
+
+// NewView creates a new image view.
+func (im *image) NewView(typ driver.ViewType, layer, layers, level, levels int) (driver.ImageView, error) {
+	return im.newView(&driver.ViewParam{
+		Type:   typ,
+		Layer:  layer,
+		Layers: layers,
+		Level:  level,
+		Levels: levels,
+	}, false)
+}
+
+// NewViewParam creates a new image view from param.
+func (im *image) NewViewParam(param *driver.ViewParam) (driver.ImageView, error) {
+	if param == nil {
+		return nil, newErr("Image.NewViewParam called with nil param")
+	}
+	for _, c := range param.Swizzle {
+		if c < driver.CIdentity || c > driver.CA {
+			return nil, newErr("Image.NewViewParam called with undefined component")
+		}
+	}
+	if pf := param.PixelFmt; pf != driver.FInvalid && pf != im.pf {
+		switch {
+		case im.usg&driver.UMutableFmt == 0:
+			return nil, newErr("Image.NewViewParam called with different format for non-mutable image")
+		case !im.pf.ViewCompatible(pf):
+			return nil, newErr("Image.NewViewParam called with incompatible format")
+		}
+	}
+	return im.newView(param, true)
+}
+
+// newView validates and creates a new image view.
+// If withParam is true, the view is created by
+// calling NewViewParam on the wrapped image.
+func (im *image) newView(param *driver.ViewParam, withParam bool) (driver.ImageView, error) {
+	typ := param.Type
+	layer, layers := param.Layer, param.Layers
+	level, levels := param.Level, param.Levels
+	switch {
+	case layer < 0 || layers < 1 || layer+layers > im.layers:
+		return nil, newErr("Image.NewView called with layer range out of bounds")
+	case level < 0 || levels < 1 || level+levels > im.levels:
+		return nil, newErr("Image.NewView called with level range out of bounds")
+	}
+	switch typ {
+	case driver.IView1D, driver.IView2D, driver.IView3D, driver.IView2DMS:
+		if layers != 1 {
+			return nil, newErr("Image.NewView called with non-arrayed type and multiple layers")
+		}
+	case driver.IViewCube:
+		if layers != 6 {
+			return nil, newErr("Image.NewView called with driver.IViewCube and layer count other than 6")
+		}
+	case driver.IViewCubeArray:
+		if layers%6 != 0 {
+			return nil, newErr("Image.NewView called with driver.IViewCubeArray and layer count not multiple of 6")
+		}
+	case driver.IView1DArray, driver.IView2DArray, driver.IView2DMSArray:
+	default:
+		return nil, newErr("Image.NewView called with undefined view type")
+	}
+	switch typ {
+	case driver.IView2DMS, driver.IView2DMSArray:
+		if im.samples == 1 {
+			return nil, newErr("Image.NewView called with multisample type for single-sample image")
+		}
+	default:
+		if im.samples > 1 {
+			return nil, newErr("Image.NewView called with single-sample type for multisample image")
+		}
+	}
+	var iv driver.ImageView
+	var err error
+	if withParam {
+		iv, err = im.Image.NewViewParam(param)
+	} else {
+		iv, err = im.Image.NewView(typ, layer, layers, level, levels)
+	}
+	if err != nil {
+		return nil, err
+	}
+	x := &imageView{
+		ImageView: iv,
+		img:       im,
+		layer:     layer,
+		layers:    layers,
+		level:     level,
+		levels:    levels,
+	}
+	im.g.track(x, "ImageView", im)
+	return x, nil
+}
+
+// checkLayout checks whether the given subresource range
+// of im is in layout l.
+func (im *image) checkLayout(layer, layers, level, levels int, l driver.Layout) bool {
+	im.mu.Lock()
+	defer im.mu.Unlock()
+	for i := layer; i < layer+layers; i++ {
+		for j := level; j < level+levels; j++ {
+			if im.layout[i*im.levels+j] != l {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// transition sets the layout of the given subresource
+// range of im to after.
+// It returns the previous layouts of the range, in
+// the order expected by setLayout.
+func (im *image) transition(layer, layers, level, levels int, after driver.Layout) []driver.Layout {
+	im.mu.Lock()
+	defer im.mu.Unlock()
+	old := make([]driver.Layout, 0, layers*levels)
+	for i := layer; i < layer+layers; i++ {
+		for j := level; j < level+levels; j++ {
+			old = append(old, im.layout[i*im.levels+j])
+			im.layout[i*im.levels+j] = after
+		}
+	}
+	return old
+}
+
+// setLayout sets the layouts of the given subresource
+// range of im to those in l, which must be ordered by
+// layer and then by level.
+func (im *image) setLayout(layer, layers, level, levels int, l []driver.Layout) {
+	im.mu.Lock()
+	defer im.mu.Unlock()
+	for i := layer; i < layer+layers; i++ {
+		for j := level; j < level+levels; j++ {
+			im.layout[i*im.levels+j] = l[0]
+			l = l[1:]
+		}
+	}
+}
+
+// imageView implements driver.ImageView.
+type imageView struct {
+	driver.ImageView
+	img    *image
+	layer  int
+	layers int
+	level  int
+	levels int
+}
+
+// Image returns the image from which the view was created.
+func (v *imageView) Image() driver.Image { return v.img }
+
+// descHeap implements driver.DescHeap.
+type descHeap struct {
+	driver.DescHeap
+	g  *gpu
+	ds []driver.Descriptor
+
+	// First error found in a call to
+	// a Set* method. It is reported by
+	// command buffers that use the heap.
+	mu  sync.Mutex
+	err error
+	// Whether BeginUpdate was called without
+	// a matching EndUpdate.
+	updating bool
+}
+
+// fail records the first error found in a call to
+// a Set* method.
+func (h *descHeap) fail(reason string) {
+	h.mu.Lock()
+	if h.err == nil {
+		h.err = newErr(reason)
+	}
+	h.mu.Unlock()
+}
+
+// validate checks the common parameters of Set* methods.
+// It returns the descriptor identified by nr, or nil if
+// validation fails.
+func (h *descHeap) validate(method string, cpy, nr, start, n int, types ...driver.DescType) *driver.Descriptor {
+	if cpy < 0 || cpy >= h.Len() {
+		h.fail("DescHeap." + method + " called with heap copy out of bounds")
+		return nil
+	}
+	var d *driver.Descriptor
+	for i := range h.ds {
+		if h.ds[i].Nr == nr {
+			d = &h.ds[i]
+			break
+		}
+	}
+	if d == nil {
+		h.fail(fmt.Sprintf("DescHeap.%s called with undefined descriptor number %d", method, nr))
+		return nil
+	}
+	typeOK := false
+	for _, t := range types {
+		typeOK = typeOK || d.Type == t
+	}
+	if !typeOK {
+		h.fail(fmt.Sprintf("DescHeap.%s called for descriptor %d of mismatched type", method, nr))
+		return nil
+	}
+	if start < 0 || n < 1 || start+n > d.Len {
+		h.fail(fmt.Sprintf("DescHeap.%s called with range out of bounds for descriptor %d", method, nr))
+		return nil
+	}
+	return d
+}
+
+// SetBuffer updates buffer ranges of a descriptor.
+func (h *descHeap) SetBuffer(cpy, nr, start int, buf []driver.Buffer, off, size []int64) {
+	if len(off) != len(buf) || len(size) != len(buf) {
+		h.fail("DescHeap.SetBuffer called with mismatched slice lengths")
+		return
+	}
+	d := h.validate("SetBuffer", cpy, nr, start, len(buf), driver.DBuffer, driver.DConstant)
+	if d == nil {
+		return
+	}
+	usg := driver.UShaderRead | driver.UShaderWrite
+	if d.Type == driver.DConstant {
+		usg = driver.UShaderConst
+	}
+	null := h.g.Features().NullDescriptor
+	inner := make([]driver.Buffer, len(buf))
+	for i, x := range buf {
+		if x == nil {
+			if !null {
+				h.fail("DescHeap.SetBuffer called with nil buffer (null descriptors not supported)")
+				return
+			}
+			continue
+		}
+		b, ok := x.(*buffer)
+		switch {
+		case !ok:
+			h.fail("DescHeap.SetBuffer called with foreign buffer")
+			return
+		case b.usg&usg == 0:
+			h.fail(fmt.Sprintf("DescHeap.SetBuffer called with buffer lacking usage for descriptor %d", nr))
+			return
+		case off[i]&255 != 0:
+			h.fail("DescHeap.SetBuffer called with misaligned buffer offset")
+			return
+		case off[i] < 0 || size[i] < 1 || off[i]+size[i] > b.Cap():
+			h.fail("DescHeap.SetBuffer called with buffer range out of bounds")
+			return
+		}
+		inner[i] = b.Buffer
+	}
+	h.DescHeap.SetBuffer(cpy, nr, start, inner, off, size)
+}
+
+// SetImage updates image views of a descriptor.
+func (h *descHeap) SetImage(cpy, nr, start int, iv []driver.ImageView, plane []int) {
+	if plane != nil && len(plane) != len(iv) {
+		h.fail("DescHeap.SetImage called with mismatched slice lengths")
+		return
+	}
+	d := h.validate("SetImage", cpy, nr, start, len(iv), driver.DImage, driver.DTexture)
+	if d == nil {
+		return
+	}
+	usg := driver.UShaderRead | driver.UShaderWrite
+	if d.Type == driver.DTexture {
+		usg = driver.UShaderSample
+	}
+	null := h.g.Features().NullDescriptor
+	inner := make([]driver.ImageView, len(iv))
+	for i, x := range iv {
+		if x == nil {
+			if !null {
+				h.fail("DescHeap.SetImage called with nil image view (null descriptors not supported)")
+				return
+			}
+			continue
+		}
+		v, ok := x.(*imageView)
+		switch {
+		case !ok:
+			h.fail("DescHeap.SetImage called with foreign image view")
+			return
+		case v.img.usg&usg == 0:
+			h.fail(fmt.Sprintf("DescHeap.SetImage called with image lacking usage for descriptor %d", nr))
+			return
+		}
+		inner[i] = v.ImageView
+	}
+	h.DescHeap.SetImage(cpy, nr, start, inner, plane)
+}
+
+// SetSampler updates samplers of a descriptor.
+func (h *descHeap) SetSampler(cpy, nr, start int, splr []driver.Sampler) {
+	d := h.validate("SetSampler", cpy, nr, start, len(splr), driver.DSampler)
+	if d == nil {
+		return
+	}
+	if d.Immutable != nil {
+		h.fail(fmt.Sprintf("DescHeap.SetSampler called for immutable descriptor %d", nr))
+		return
+	}
+	inner := make([]driver.Sampler, len(splr))
+	for i, x := range splr {
+		if x == nil {
+			h.fail("DescHeap.SetSampler called with nil sampler")
+			return
+		}
+		inner[i] = unwrapSplr(x)
+	}
+	h.DescHeap.SetSampler(cpy, nr, start, inner)
+}
+
+// SetBufferView updates buffer views of a descriptor.
+func (h *descHeap) SetBufferView(cpy, nr, start int, bv []driver.BufferView) {
+	d := h.validate("SetBufferView", cpy, nr, start, len(bv), driver.DUniformTexelBuffer, driver.DStorageTexelBuffer)
+	if d == nil {
+		return
+	}
+	usg := driver.UShaderRead | driver.UShaderWrite
+	if d.Type == driver.DUniformTexelBuffer {
+		usg = driver.UShaderConst
+	}
+	null := h.g.Features().NullDescriptor
+	inner := make([]driver.BufferView, len(bv))
+	for i, x := range bv {
+		if x == nil {
+			if !null {
+				h.fail("DescHeap.SetBufferView called with nil buffer view (null descriptors not supported)")
+				return
+			}
+			continue
+		}
+		v, ok := x.(*bufferView)
+		switch {
+		case !ok:
+			h.fail("DescHeap.SetBufferView called with foreign buffer view")
+			return
+		case v.buf.usg&usg == 0:
+			h.fail(fmt.Sprintf("DescHeap.SetBufferView called with buffer lacking usage for descriptor %d", nr))
+			return
+		}
+		inner[i] = v.BufferView
+	}
+	h.DescHeap.SetBufferView(cpy, nr, start, inner)
+}
+
+// CopyFrom copies the descriptors of a heap copy.
+func (h *descHeap) CopyFrom(cpy int, src driver.DescHeap, srcCpy int) {
+	sh, ok := src.(*descHeap)
+	switch {
+	case !ok:
+		h.fail("DescHeap.CopyFrom called with foreign heap")
+		return
+	case cpy < 0 || cpy >= h.Len() || srcCpy < 0 || srcCpy >= sh.Len():
+		h.fail("DescHeap.CopyFrom called with heap copy out of bounds")
+		return
+	case sh == h && cpy == srcCpy:
+		h.fail("DescHeap.CopyFrom called with same source and destination")
+		return
+	case len(sh.ds) != len(h.ds):
+		h.fail("DescHeap.CopyFrom called with incompatible heap")
+		return
+	}
+	for i := range h.ds {
+		d, s := &h.ds[i], &sh.ds[i]
+		if d.Type != s.Type || d.Nr != s.Nr || d.Len != s.Len || (d.Immutable == nil) != (s.Immutable == nil) {
+			h.fail("DescHeap.CopyFrom called with incompatible heap")
+			return
+		}
+	}
+	if sh != h {
+		sh.mu.Lock()
+		err := sh.err
+		sh.mu.Unlock()
+		if err != nil {
+			h.mu.Lock()
+			if h.err == nil {
+				h.err = err
+			}
+			h.mu.Unlock()
+		}
+	}
+	h.DescHeap.CopyFrom(cpy, sh.DescHeap, srcCpy)
+}
+
+// BeginUpdate starts deferring updates.
+func (h *descHeap) BeginUpdate() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.updating {
+		if h.err == nil {
+			h.err = newErr("DescHeap.BeginUpdate called twice")
+		}
+		return
+	}
+	h.updating = true
+	h.DescHeap.BeginUpdate()
+}
+
+// EndUpdate submits deferred updates.
+func (h *descHeap) EndUpdate() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if !h.updating {
+		if h.err == nil {
+			h.err = newErr("DescHeap.EndUpdate called without BeginUpdate")
+		}
+		return
+	}
+	h.updating = false
+	h.DescHeap.EndUpdate()
+}
+
+// descTable implements driver.DescTable.
+type descTable struct {
+	driver.DescTable
+	g     *gpu
+	heaps []*descHeap
+}
+
+// Heap returns the descriptor heap at index idx.
+func (t *descTable) Heap(idx int) driver.DescHeap { return t.heaps[idx] }
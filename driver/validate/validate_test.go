@@ -0,0 +1,1028 @@
+// Copyright 2024 Gustavo C. Viegas. All rights reserved.
+
+package validate
+
+import (
+	"slices"
+	"strings"
+	"testing"
+
+	"gviegas/neo3/driver"
+)
+
+// fakeGPU is a driver.GPU that does nothing.
+// Only the methods used by tests are implemented.
+type fakeGPU struct{ driver.GPU }
+
+func (fakeGPU) NewCmdBuffer() (driver.CmdBuffer, error) { return &fakeCB{}, nil }
+
+func (fakeGPU) NewBuffer(size int64, _ bool, _ driver.Usage) (driver.Buffer, error) {
+	return &fakeBuf{size: size}, nil
+}
+
+func (fakeGPU) NewImage(driver.PixelFmt, driver.Dim3D, int, int, int, driver.Usage) (driver.Image, error) {
+	return &fakeImg{}, nil
+}
+
+func (fakeGPU) NewDescHeap([]driver.Descriptor) (driver.DescHeap, error) { return &fakeHeap{}, nil }
+
+func (fakeGPU) NewDescTable([]driver.DescHeap) (driver.DescTable, error) { return &fakeTable{}, nil }
+
+func (fakeGPU) Limits() driver.Limits {
+	return driver.Limits{
+		MaxImage2D:       4096,
+		MaxLayers:        256,
+		MaxDescHeaps:     4,
+		MaxColorTargets:  4,
+		MaxRenderSize:    [2]int{4096, 4096},
+		MaxRenderLayers:  256,
+		MaxRenderSamples: 8,
+		MaxVertexIn:      16,
+		MaxClipDistances: 8,
+		MaxDispatch:      [3]int{65535, 65535, 65535},
+		MaxTexelBuffer:   65536,
+	}
+}
+
+func (fakeGPU) Features() driver.Features { return driver.Features{MutableFormat: true} }
+
+func (fakeGPU) Commit(wk *driver.WorkItem, ch chan<- *driver.WorkItem) error {
+	ch <- wk
+	return nil
+}
+
+func (fakeGPU) CommitBatch(wk []*driver.WorkItem, ch chan<- *driver.WorkItem) error {
+	for _, wk := range wk {
+		ch <- wk
+	}
+	return nil
+}
+
+// fakeExtGPU is a fakeGPU that implements
+// driver.External with HOpaqueFD and HDMABuf support.
+type fakeExtGPU struct{ fakeGPU }
+
+func (fakeExtGPU) HandleTypes() []driver.HandleType {
+	return []driver.HandleType{driver.HOpaqueFD, driver.HDMABuf}
+}
+
+func (fakeExtGPU) NewExportBuffer(size int64, _ bool, _ driver.Usage, _ driver.HandleType) (driver.Buffer, error) {
+	return &fakeExpBuf{fakeBuf{size: size}}, nil
+}
+
+func (fakeExtGPU) NewExportImage(driver.PixelFmt, driver.Dim3D, int, int, int, driver.Usage, driver.HandleType) (driver.Image, error) {
+	return &fakeImg{}, nil
+}
+
+func (fakeExtGPU) ImportBuffer(_ driver.ExternalHandle, size int64, _ bool, _ driver.Usage) (driver.Buffer, error) {
+	return &fakeBuf{size: size}, nil
+}
+
+func (fakeExtGPU) ImportImage(driver.ExternalHandle, driver.PixelFmt, driver.Dim3D, int, int, int, driver.Usage) (driver.Image, error) {
+	return &fakeImg{}, nil
+}
+
+// fakeMVGPU is a fakeGPU that supports
+// driver.Features.Multiview.
+type fakeMVGPU struct{ fakeGPU }
+
+func (fakeMVGPU) Features() driver.Features {
+	return driver.Features{MutableFormat: true, Multiview: true}
+}
+
+// fakeClipGPU is a fakeGPU that supports
+// driver.Features.ClipDistance.
+type fakeClipGPU struct{ fakeGPU }
+
+func (fakeClipGPU) Features() driver.Features {
+	return driver.Features{MutableFormat: true, ClipDistance: true}
+}
+
+type fakeCB struct {
+	driver.CmdBuffer
+	calls []string
+}
+
+func (cb *fakeCB) Begin() error        { cb.calls = append(cb.calls, "Begin"); return nil }
+func (cb *fakeCB) End() error          { cb.calls = append(cb.calls, "End"); return nil }
+func (cb *fakeCB) Reset() error        { cb.calls = append(cb.calls, "Reset"); return nil }
+func (cb *fakeCB) EndPass()            { cb.calls = append(cb.calls, "EndPass") }
+func (cb *fakeCB) Draw(_, _, _, _ int) { cb.calls = append(cb.calls, "Draw") }
+
+func (cb *fakeCB) BeginPass(_, _, _ int, _ []driver.ColorTarget, _ *driver.DSTarget) {
+	cb.calls = append(cb.calls, "BeginPass")
+}
+
+func (cb *fakeCB) BeginPassViews(_, _ int, _ uint32, _ []driver.ColorTarget, _ *driver.DSTarget) {
+	cb.calls = append(cb.calls, "BeginPassViews")
+}
+
+func (cb *fakeCB) CopyBuffer(*driver.BufferCopy) { cb.calls = append(cb.calls, "CopyBuffer") }
+
+func (cb *fakeCB) UpdateBuffer(driver.Buffer, int64, []byte) {
+	cb.calls = append(cb.calls, "UpdateBuffer")
+}
+
+func (cb *fakeCB) CopyBufToImg(*driver.BufImgCopy) { cb.calls = append(cb.calls, "CopyBufToImg") }
+
+func (cb *fakeCB) BlitImage(*driver.ImageBlit) { cb.calls = append(cb.calls, "BlitImage") }
+
+func (cb *fakeCB) Transition([]driver.Transition) { cb.calls = append(cb.calls, "Transition") }
+
+func (cb *fakeCB) SetDescTableGraph(driver.DescTable, int, []int) {
+	cb.calls = append(cb.calls, "SetDescTableGraph")
+}
+
+type fakeBuf struct {
+	driver.Buffer
+	size int64
+}
+
+func (b *fakeBuf) Cap() int64 { return b.size }
+func (b *fakeBuf) Destroy()   {}
+
+func (*fakeBuf) NewView(driver.PixelFmt, int64, int64) (driver.BufferView, error) {
+	return &fakeBufView{}, nil
+}
+
+type fakeExpBuf struct{ fakeBuf }
+
+func (*fakeExpBuf) Export() (driver.ExternalHandle, error) {
+	return driver.ExternalHandle{Type: driver.HOpaqueFD, Handle: 3}, nil
+}
+
+type fakeBufView struct{ driver.BufferView }
+
+func (*fakeBufView) Destroy() {}
+
+type fakeImg struct{ driver.Image }
+
+func (*fakeImg) Destroy() {}
+
+func (*fakeImg) NewView(driver.ViewType, int, int, int, int) (driver.ImageView, error) {
+	return &fakeView{}, nil
+}
+
+func (*fakeImg) NewViewParam(*driver.ViewParam) (driver.ImageView, error) {
+	return &fakeView{}, nil
+}
+
+type fakeView struct{ driver.ImageView }
+
+func (*fakeView) Destroy() {}
+
+type fakeSplr struct{ driver.Sampler }
+
+func (*fakeSplr) Destroy() {}
+
+type fakeHeap struct {
+	driver.DescHeap
+	n int
+}
+
+func (h *fakeHeap) New(n int) error                                            { h.n = n; return nil }
+func (h *fakeHeap) Len() int                                                   { return h.n }
+func (h *fakeHeap) SetImage(int, int, int, []driver.ImageView, []int)          {}
+func (h *fakeHeap) SetBuffer(int, int, int, []driver.Buffer, []int64, []int64) {}
+func (h *fakeHeap) SetBufferView(int, int, int, []driver.BufferView)           {}
+func (h *fakeHeap) CopyFrom(int, driver.DescHeap, int)                         {}
+func (h *fakeHeap) BeginUpdate()                                               {}
+func (h *fakeHeap) EndUpdate()                                                 {}
+
+type fakeTable struct{ driver.DescTable }
+
+func newCB(t *testing.T, g driver.GPU) (*cmdBuffer, *fakeCB) {
+	cb, err := g.NewCmdBuffer()
+	if err != nil {
+		t.Fatalf("GPU.NewCmdBuffer failed: %v", err)
+	}
+	return cb.(*cmdBuffer), cb.(*cmdBuffer).CmdBuffer.(*fakeCB)
+}
+
+func checkErr(t *testing.T, err error, want string) {
+	t.Helper()
+	switch {
+	case err == nil:
+		t.Errorf("have nil error\nwant error containing %q", want)
+	case !strings.Contains(err.Error(), want):
+		t.Errorf("have %q\nwant error containing %q", err, want)
+	}
+}
+
+func TestBeginEnd(t *testing.T) {
+	g := New(fakeGPU{})
+	cb, fcb := newCB(t, g)
+	checkErr(t, cb.End(), "End called while not recording")
+	if err := cb.Begin(); err != nil {
+		t.Fatalf("CmdBuffer.Begin failed: %v", err)
+	}
+	checkErr(t, cb.Begin(), "Begin called while recording")
+	if err := cb.End(); err != nil {
+		t.Fatalf("CmdBuffer.End failed: %v", err)
+	}
+	if want := []string{"Begin", "End"}; !slices.Equal(fcb.calls, want) {
+		t.Errorf("forwarded calls:\nhave %v\nwant %v", fcb.calls, want)
+	}
+	if !cb.ended {
+		t.Error("cmdBuffer.ended:\nhave false\nwant true")
+	}
+}
+
+func TestRenderPass(t *testing.T) {
+	g := New(fakeGPU{})
+	cb, fcb := newCB(t, g)
+
+	cb.Begin()
+	cb.Draw(3, 1, 0, 0)
+	checkErr(t, cb.End(), "Draw called outside of a render pass")
+	if want := []string{"Begin", "Reset"}; !slices.Equal(fcb.calls, want) {
+		t.Errorf("forwarded calls:\nhave %v\nwant %v", fcb.calls, want)
+	}
+
+	img, _ := g.NewImage(driver.RGBA8Unorm, driver.Dim3D{Width: 64, Height: 64}, 1, 1, 1, driver.URenderTarget)
+	iv, _ := img.NewView(driver.IView2D, 0, 1, 0, 1)
+	color := []driver.ColorTarget{{Color: iv}}
+
+	fcb.calls = nil
+	cb.Begin()
+	cb.BeginPass(64, 64, 1, color, nil)
+	checkErr(t, cb.End(), "color target in wrong layout")
+
+	tr := []driver.Transition{{
+		LayoutBefore: driver.LUndefined,
+		LayoutAfter:  driver.LColorTarget,
+		Img:          img,
+		Layers:       1,
+		Levels:       1,
+	}}
+	fcb.calls = nil
+	cb.Begin()
+	cb.Transition(tr)
+	cb.BeginPass(64, 64, 1, color, nil)
+	cb.Draw(3, 1, 0, 0)
+	cb.Transition(nil)
+	checkErr(t, cb.End(), "Transition called during a render pass")
+
+	fcb.calls = nil
+	cb.Begin()
+	cb.Transition(tr)
+	cb.BeginPass(64, 64, 1, color, nil)
+	cb.Draw(3, 1, 0, 0)
+	cb.EndPass()
+	if err := cb.End(); err != nil {
+		t.Fatalf("CmdBuffer.End failed: %v", err)
+	}
+	if want := []string{"Begin", "Transition", "BeginPass", "Draw", "EndPass", "End"}; !slices.Equal(fcb.calls, want) {
+		t.Errorf("forwarded calls:\nhave %v\nwant %v", fcb.calls, want)
+	}
+}
+
+func TestRenderPassViews(t *testing.T) {
+	img, _ := New(fakeGPU{}).NewImage(driver.D16Unorm, driver.Dim3D{Width: 64, Height: 64}, 6, 1, 1, driver.URenderTarget)
+	iv, _ := img.NewView(driver.IView2DArray, 0, 6, 0, 1)
+	ds := &driver.DSTarget{DS: iv}
+	cb, _ := newCB(t, New(fakeGPU{}))
+	cb.Begin()
+	cb.BeginPassViews(64, 64, 1<<6-1, nil, ds)
+	checkErr(t, cb.End(), "requires driver.Features.Multiview")
+
+	g := New(fakeMVGPU{})
+	cb, fcb := newCB(t, g)
+	img, _ = g.NewImage(driver.D16Unorm, driver.Dim3D{Width: 64, Height: 64}, 6, 1, 1, driver.URenderTarget)
+	tr := []driver.Transition{{
+		LayoutBefore: driver.LUndefined,
+		LayoutAfter:  driver.LDSTarget,
+		Img:          img,
+		Layers:       6,
+		Levels:       1,
+	}}
+
+	cb.Begin()
+	cb.BeginPassViews(64, 64, 0, nil, ds)
+	checkErr(t, cb.End(), "zero view mask")
+
+	face, _ := img.NewView(driver.IView2D, 0, 1, 0, 1)
+	cb.Begin()
+	cb.Transition(tr)
+	cb.BeginPassViews(64, 64, 1<<6-1, nil, &driver.DSTarget{DS: face})
+	checkErr(t, cb.End(), "too few layers")
+
+	iv, _ = img.NewView(driver.IView2DArray, 0, 6, 0, 1)
+	fcb.calls = nil
+	cb.Begin()
+	cb.Transition(tr)
+	cb.BeginPassViews(64, 64, 1<<6-1, nil, &driver.DSTarget{DS: iv})
+	cb.Draw(3, 1, 0, 0)
+	cb.EndPass()
+	if err := cb.End(); err != nil {
+		t.Fatalf("CmdBuffer.End failed: %v", err)
+	}
+	if want := []string{"Begin", "Transition", "BeginPassViews", "Draw", "EndPass", "End"}; !slices.Equal(fcb.calls, want) {
+		t.Errorf("forwarded calls:\nhave %v\nwant %v", fcb.calls, want)
+	}
+}
+
+func TestCopy(t *testing.T) {
+	g := New(fakeGPU{})
+	cb, _ := newCB(t, g)
+	src, _ := g.NewBuffer(1024, true, driver.UCopySrc)
+	dst, _ := g.NewBuffer(1024, true, driver.UCopyDst)
+
+	cb.Begin()
+	cb.CopyBuffer(&driver.BufferCopy{From: dst, To: src, Size: 256})
+	checkErr(t, cb.End(), "CopyBuffer called with buffer lacking usage")
+
+	cb.Begin()
+	cb.CopyBuffer(&driver.BufferCopy{From: src, To: dst, ToOff: 512, Size: 1024})
+	checkErr(t, cb.End(), "CopyBuffer called with buffer range out of bounds")
+
+	cb.Begin()
+	cb.CopyBuffer(&driver.BufferCopy{From: src, To: dst, Size: 1024})
+	if err := cb.End(); err != nil {
+		t.Errorf("CmdBuffer.End failed: %v", err)
+	}
+
+	img, _ := g.NewImage(driver.RGBA8Unorm, driver.Dim3D{Width: 16, Height: 16}, 1, 1, 1, driver.UCopyDst)
+	param := driver.BufImgCopy{
+		Buf:    src,
+		Img:    img,
+		Size:   driver.Dim3D{Width: 16, Height: 16},
+		Layers: 1,
+	}
+
+	cb.Begin()
+	cb.CopyBufToImg(&param)
+	checkErr(t, cb.End(), "CopyBufToImg called with image in wrong layout")
+
+	cb.Begin()
+	cb.Transition([]driver.Transition{{
+		LayoutBefore: driver.LUndefined,
+		LayoutAfter:  driver.LCopyDst,
+		Img:          img,
+		Layers:       1,
+		Levels:       1,
+	}})
+	cb.CopyBufToImg(&param)
+	if err := cb.End(); err != nil {
+		t.Errorf("CmdBuffer.End failed: %v", err)
+	}
+
+	cb.Begin()
+	cb.Transition([]driver.Transition{{
+		LayoutBefore: driver.LShaderRead,
+		LayoutAfter:  driver.LCopySrc,
+		Img:          img,
+		Layers:       1,
+		Levels:       1,
+	}})
+	checkErr(t, cb.End(), "LayoutBefore not matching current layout")
+}
+
+func TestBlit(t *testing.T) {
+	g := New(fakeGPU{})
+	cb, fcb := newCB(t, g)
+	src, _ := g.NewImage(driver.RGBA16Float, driver.Dim3D{Width: 8, Height: 8}, 1, 1, 1, driver.UCopySrc)
+	dst, _ := g.NewImage(driver.RGBA8Unorm, driver.Dim3D{Width: 16, Height: 16}, 1, 1, 1, driver.UCopyDst)
+	ui, _ := g.NewImage(driver.RGBA8Uint, driver.Dim3D{Width: 16, Height: 16}, 1, 1, 1, driver.UCopyDst)
+	tr := []driver.Transition{
+		{LayoutAfter: driver.LCopySrc, Img: src, Layers: 1, Levels: 1},
+		{LayoutAfter: driver.LCopyDst, Img: dst, Layers: 1, Levels: 1},
+		{LayoutAfter: driver.LCopyDst, Img: ui, Layers: 1, Levels: 1},
+	}
+	param := func(to driver.Image, f driver.Filter) *driver.ImageBlit {
+		return &driver.ImageBlit{
+			From:     src,
+			FromSize: driver.Dim3D{Width: 8, Height: 8},
+			To:       to,
+			ToSize:   driver.Dim3D{Width: 16, Height: 16},
+			Layers:   1,
+			Filter:   f,
+		}
+	}
+
+	cb.Begin()
+	cb.BlitImage(param(dst, driver.FLinear))
+	checkErr(t, cb.End(), "BlitImage called with image in wrong layout")
+
+	cb.Begin()
+	cb.Transition(tr)
+	x := param(dst, driver.FLinear)
+	x.FromSize.Width = 16
+	cb.BlitImage(x)
+	checkErr(t, cb.End(), "BlitImage called with image region out of bounds")
+
+	cb.Begin()
+	cb.Transition(tr)
+	cb.BlitImage(param(dst, 2))
+	checkErr(t, cb.End(), "undefined filter")
+
+	cb.Begin()
+	cb.Transition(tr)
+	cb.BlitImage(param(ui, driver.FNearest))
+	checkErr(t, cb.End(), "incompatible formats")
+
+	fcb.calls = nil
+	cb.Begin()
+	cb.Transition(tr)
+	cb.BlitImage(param(dst, driver.FLinear))
+	if err := cb.End(); err != nil {
+		t.Fatalf("CmdBuffer.End failed: %v", err)
+	}
+	if want := []string{"Begin", "Transition", "BlitImage", "End"}; !slices.Equal(fcb.calls, want) {
+		t.Errorf("forwarded calls:\nhave %v\nwant %v", fcb.calls, want)
+	}
+}
+
+func TestExternal(t *testing.T) {
+	ext := New(fakeGPU{}).(driver.External)
+	if ht := ext.HandleTypes(); len(ht) != 0 {
+		t.Fatalf("External.HandleTypes:\nhave %v\nwant []", ht)
+	}
+	_, err := ext.NewExportBuffer(1024, false, driver.UCopySrc, driver.HOpaqueFD)
+	checkErr(t, err, "NewExportBuffer called with unsupported handle type")
+
+	g := New(fakeExtGPU{})
+	ext = g.(driver.External)
+	_, err = ext.ImportImage(driver.ExternalHandle{Type: driver.HOpaqueWin32}, driver.RGBA8Unorm, driver.Dim3D{Width: 16, Height: 16}, 1, 1, 1, driver.UShaderSample)
+	checkErr(t, err, "ImportImage called with unsupported handle type")
+	_, err = ext.ImportBuffer(driver.ExternalHandle{Type: driver.HOpaqueFD}, 0, false, driver.UCopySrc)
+	checkErr(t, err, "ImportBuffer called with invalid size")
+
+	// DMA-BUF images are linear.
+	dma := driver.ExternalHandle{Type: driver.HDMABuf}
+	for _, x := range [...]struct {
+		pf                      driver.PixelFmt
+		size                    driver.Dim3D
+		layers, levels, samples int
+		usg                     driver.Usage
+	}{
+		{driver.RGBA8Unorm, driver.Dim3D{Width: 16, Height: 16}, 1, 2, 1, driver.UShaderSample},
+		{driver.RGBA8Unorm, driver.Dim3D{Width: 16, Height: 16}, 2, 1, 1, driver.UShaderSample},
+		{driver.RGBA8Unorm, driver.Dim3D{Width: 16, Height: 16}, 1, 1, 4, driver.URenderTarget},
+		{driver.RGBA8Unorm, driver.Dim3D{Width: 16, Height: 16, Depth: 4}, 1, 1, 1, driver.UShaderSample},
+		{driver.RGBA8Unorm, driver.Dim3D{Width: 16}, 1, 1, 1, driver.UShaderSample},
+		{driver.D16Unorm, driver.Dim3D{Width: 16, Height: 16}, 1, 1, 1, driver.URenderTarget},
+	} {
+		_, err = ext.ImportImage(dma, x.pf, x.size, x.layers, x.levels, x.samples, x.usg)
+		checkErr(t, err, "ImportImage called with DMA-BUF image that is not")
+		_, err = ext.NewExportImage(x.pf, x.size, x.layers, x.levels, x.samples, x.usg, driver.HDMABuf)
+		checkErr(t, err, "NewExportImage called with DMA-BUF image that is not")
+	}
+	if _, err := ext.ImportImage(dma, driver.RGBA8Unorm, driver.Dim3D{Width: 16, Height: 16}, 1, 1, 1, driver.UShaderSample); err != nil {
+		t.Fatalf("External.ImportImage failed: %v", err)
+	}
+
+	buf, err := ext.NewExportBuffer(1024, false, driver.UCopySrc, driver.HOpaqueFD)
+	if err != nil {
+		t.Fatalf("External.NewExportBuffer failed: %v", err)
+	}
+	h, err := buf.(driver.Exporter).Export()
+	if err != nil {
+		t.Fatalf("Exporter.Export failed: %v", err)
+	}
+	if h.Type != driver.HOpaqueFD || h.Handle != 3 {
+		t.Fatalf("Exporter.Export:\nhave %v\nwant {%v 3}", h, driver.HOpaqueFD)
+	}
+	buf, _ = g.NewBuffer(1024, false, driver.UCopySrc)
+	_, err = buf.(driver.Exporter).Export()
+	checkErr(t, err, "Export called on non-exportable buffer")
+
+	img, err := ext.ImportImage(h, driver.RGBA8Unorm, driver.Dim3D{Width: 16, Height: 16}, 1, 1, 1, driver.UShaderSample)
+	if err != nil {
+		t.Fatalf("External.ImportImage failed: %v", err)
+	}
+	cb, _ := newCB(t, g)
+	cb.Begin()
+	cb.Transition([]driver.Transition{{
+		LayoutBefore: driver.LUndefined,
+		LayoutAfter:  driver.LShaderRead,
+		Img:          img,
+		Layers:       1,
+		Levels:       1,
+		Xfer:         driver.OAcquire,
+	}})
+	if err := cb.End(); err != nil {
+		t.Errorf("CmdBuffer.End failed: %v", err)
+	}
+
+	img, _ = g.NewImage(driver.RGBA8Unorm, driver.Dim3D{Width: 16, Height: 16}, 1, 1, 1, driver.UShaderSample)
+	cb.Begin()
+	cb.Transition([]driver.Transition{{
+		LayoutBefore: driver.LUndefined,
+		LayoutAfter:  driver.LShaderRead,
+		Img:          img,
+		Layers:       1,
+		Levels:       1,
+		Xfer:         driver.ORelease,
+	}})
+	checkErr(t, cb.End(), "ownership transfer of non-external image")
+}
+
+func TestTransient(t *testing.T) {
+	g := New(fakeGPU{})
+	size := driver.Dim3D{Width: 16, Height: 16}
+	_, err := g.NewImage(driver.RGBA8Unorm, size, 1, 1, 1, driver.UTransient|driver.URenderTarget|driver.UShaderSample)
+	checkErr(t, err, "driver.UTransient and non-render-target usage")
+	_, err = g.NewImage(driver.RGBA8Unorm, size, 1, 1, 1, driver.UTransient)
+	checkErr(t, err, "driver.UTransient but no driver.URenderTarget")
+
+	img, err := g.NewImage(driver.RGBA8Unorm, size, 1, 1, 1, driver.UTransient|driver.URenderTarget)
+	if err != nil {
+		t.Fatalf("GPU.NewImage failed: %v", err)
+	}
+	view, _ := img.NewView(driver.IView2D, 0, 1, 0, 1)
+	cb, _ := newCB(t, g)
+	tr := []driver.Transition{{
+		LayoutBefore: driver.LUndefined,
+		LayoutAfter:  driver.LColorTarget,
+		Img:          img,
+		Layers:       1,
+		Levels:       1,
+	}}
+
+	cb.Begin()
+	cb.Transition(tr)
+	cb.BeginPass(16, 16, 1, []driver.ColorTarget{{Color: view, Load: driver.LLoad}}, nil)
+	checkErr(t, cb.End(), "transient color target that is loaded or stored")
+
+	cb.Begin()
+	cb.Transition(tr)
+	cb.BeginPass(16, 16, 1, []driver.ColorTarget{{Color: view, Load: driver.LClear, Store: driver.SStore}}, nil)
+	checkErr(t, cb.End(), "transient color target that is loaded or stored")
+
+	cb.Begin()
+	cb.Transition(tr)
+	cb.BeginPass(16, 16, 1, []driver.ColorTarget{{Color: view, Load: driver.LClear}}, nil)
+	cb.EndPass()
+	if err := cb.End(); err != nil {
+		t.Errorf("CmdBuffer.End failed: %v", err)
+	}
+}
+
+func TestDescHeap(t *testing.T) {
+	g := New(fakeGPU{})
+	cb, fcb := newCB(t, g)
+
+	_, err := g.NewDescHeap([]driver.Descriptor{
+		{Type: driver.DConstant, Stages: driver.SVertex, Nr: 0, Len: 1},
+		{Type: driver.DTexture, Stages: driver.SFragment, Nr: 0, Len: 1},
+	})
+	checkErr(t, err, "duplicate descriptor number 0")
+
+	dh, err := g.NewDescHeap([]driver.Descriptor{
+		{Type: driver.DConstant, Stages: driver.SVertex, Nr: 0, Len: 1},
+		{Type: driver.DTexture, Stages: driver.SFragment, Nr: 1, Len: 1},
+	})
+	if err != nil {
+		t.Fatalf("GPU.NewDescHeap failed: %v", err)
+	}
+	dh.New(1)
+	dt, err := g.NewDescTable([]driver.DescHeap{dh})
+	if err != nil {
+		t.Fatalf("GPU.NewDescTable failed: %v", err)
+	}
+
+	buf, _ := g.NewBuffer(256, true, driver.UShaderConst)
+	dh.SetBuffer(0, 0, 0, []driver.Buffer{buf}, []int64{0}, []int64{256})
+	cb.Begin()
+	cb.SetDescTableGraph(dt, 0, []int{0})
+	if err := cb.End(); err != nil {
+		t.Errorf("CmdBuffer.End failed: %v", err)
+	}
+
+	img, _ := g.NewImage(driver.RGBA8Unorm, driver.Dim3D{Width: 16, Height: 16}, 1, 1, 1, driver.UShaderSample)
+	iv, _ := img.NewView(driver.IView2D, 0, 1, 0, 1)
+	dh.SetImage(0, 0, 0, []driver.ImageView{iv}, nil)
+	fcb.calls = nil
+	cb.Begin()
+	cb.SetDescTableGraph(dt, 0, []int{0})
+	checkErr(t, cb.End(), "descriptor 0 of mismatched type")
+	if want := []string{"Begin", "Reset"}; !slices.Equal(fcb.calls, want) {
+		t.Errorf("forwarded calls:\nhave %v\nwant %v", fcb.calls, want)
+	}
+}
+
+func TestViewParam(t *testing.T) {
+	g := New(fakeGPU{})
+	size := driver.Dim3D{Width: 16, Height: 16}
+
+	_, err := g.NewImage(driver.D32Float, size, 1, 1, 1, driver.UShaderSample|driver.UMutableFmt)
+	checkErr(t, err, "driver.UMutableFmt and non-color format")
+
+	img, _ := g.NewImage(driver.RGBA8SRGB, size, 1, 1, 1, driver.UShaderSample)
+	_, err = img.NewViewParam(&driver.ViewParam{Type: driver.IView2D, Layers: 1, Levels: 1, PixelFmt: driver.RGBA8Unorm})
+	checkErr(t, err, "different format for non-mutable image")
+	_, err = img.NewViewParam(&driver.ViewParam{Type: driver.IView2D, Layers: 1, Levels: 1, Swizzle: driver.Swizzle{-1}})
+	checkErr(t, err, "undefined component")
+
+	img, _ = g.NewImage(driver.RGBA8SRGB, size, 1, 1, 1, driver.UShaderSample|driver.UMutableFmt)
+	_, err = img.NewViewParam(&driver.ViewParam{Type: driver.IView2D, Layers: 1, Levels: 1, PixelFmt: driver.RG8Unorm})
+	checkErr(t, err, "incompatible format")
+	_, err = img.NewViewParam(&driver.ViewParam{Type: driver.IView2D, Layers: 1, Levels: 1, PixelFmt: driver.RGBA8Unorm})
+	if err != nil {
+		t.Errorf("Image.NewViewParam failed: %v", err)
+	}
+}
+
+func TestBufferView(t *testing.T) {
+	g := New(fakeGPU{})
+	cb, _ := newCB(t, g)
+
+	buf, _ := g.NewBuffer(4096, false, driver.UShaderRead)
+	_, err := buf.NewView(driver.RGBA8Unorm, 100, 256)
+	checkErr(t, err, "misaligned offset")
+	_, err = buf.NewView(driver.RGBA8Unorm, 256, 4096)
+	checkErr(t, err, "range out of bounds")
+	_, err = buf.NewView(driver.D16Unorm, 0, 256)
+	checkErr(t, err, "invalid pixel format")
+	bv, err := buf.NewView(driver.RGBA8Unorm, 256, 1024)
+	if err != nil {
+		t.Fatalf("Buffer.NewView failed: %v", err)
+	}
+
+	dh, _ := g.NewDescHeap([]driver.Descriptor{
+		{Type: driver.DUniformTexelBuffer, Stages: driver.SCompute, Nr: 0, Len: 1},
+		{Type: driver.DStorageTexelBuffer, Stages: driver.SCompute, Nr: 1, Len: 1},
+	})
+	dh.New(1)
+	dt, _ := g.NewDescTable([]driver.DescHeap{dh})
+	dh.SetBufferView(0, 1, 0, []driver.BufferView{bv})
+	cb.Begin()
+	cb.SetDescTableGraph(dt, 0, []int{0})
+	if err := cb.End(); err != nil {
+		t.Errorf("CmdBuffer.End failed: %v", err)
+	}
+	dh.SetBufferView(0, 0, 0, []driver.BufferView{bv})
+	cb.Begin()
+	cb.SetDescTableGraph(dt, 0, []int{0})
+	checkErr(t, cb.End(), "buffer lacking usage for descriptor 0")
+}
+
+func TestNullDescriptor(t *testing.T) {
+	g := New(fakeGPU{})
+	cb, _ := newCB(t, g)
+
+	dh, _ := g.NewDescHeap([]driver.Descriptor{
+		{Type: driver.DTexture, Stages: driver.SFragment, Nr: 0, Len: 2},
+	})
+	dh.New(1)
+	dt, _ := g.NewDescTable([]driver.DescHeap{dh})
+	dh.SetImage(0, 0, 0, []driver.ImageView{nil, nil}, nil)
+	cb.Begin()
+	cb.SetDescTableGraph(dt, 0, []int{0})
+	checkErr(t, cb.End(), "nil image view (null descriptors not supported)")
+}
+
+func TestImmutableSampler(t *testing.T) {
+	g := New(fakeGPU{})
+
+	splr := &fakeSplr{}
+	_, err := g.NewDescHeap([]driver.Descriptor{
+		{Type: driver.DTexture, Stages: driver.SFragment, Nr: 0, Len: 1, Immutable: []driver.Sampler{splr}},
+	})
+	checkErr(t, err, "immutable samplers for non-sampler descriptor")
+	_, err = g.NewDescHeap([]driver.Descriptor{
+		{Type: driver.DSampler, Stages: driver.SFragment, Nr: 0, Len: 2, Immutable: []driver.Sampler{splr}},
+	})
+	checkErr(t, err, "immutable sampler count not matching")
+
+	dh, err := g.NewDescHeap([]driver.Descriptor{
+		{Type: driver.DSampler, Stages: driver.SFragment, Nr: 0, Len: 1, Immutable: []driver.Sampler{splr}},
+	})
+	if err != nil {
+		t.Fatalf("GPU.NewDescHeap failed: %v", err)
+	}
+	dh.New(1)
+	dt, _ := g.NewDescTable([]driver.DescHeap{dh})
+	dh.SetSampler(0, 0, 0, []driver.Sampler{splr})
+	cb, _ := newCB(t, g)
+	cb.Begin()
+	cb.SetDescTableGraph(dt, 0, []int{0})
+	checkErr(t, cb.End(), "immutable descriptor 0")
+}
+
+func TestDescUpdate(t *testing.T) {
+	g := New(fakeGPU{})
+	cb, _ := newCB(t, g)
+	ds := []driver.Descriptor{{Type: driver.DConstant, Stages: driver.SVertex, Nr: 0, Len: 1}}
+
+	dh, _ := g.NewDescHeap(ds)
+	dh.New(2)
+	dt, _ := g.NewDescTable([]driver.DescHeap{dh})
+	dh.BeginUpdate()
+	dh.CopyFrom(1, dh, 0)
+	cb.Begin()
+	cb.SetDescTableGraph(dt, 0, []int{1})
+	checkErr(t, cb.End(), "DescHeap has deferred updates")
+	dh.EndUpdate()
+	cb.Begin()
+	cb.SetDescTableGraph(dt, 0, []int{1})
+	if err := cb.End(); err != nil {
+		t.Errorf("CmdBuffer.End failed: %v", err)
+	}
+
+	other, _ := g.NewDescHeap([]driver.Descriptor{{Type: driver.DBuffer, Stages: driver.SVertex, Nr: 0, Len: 1}})
+	other.New(1)
+	dh.CopyFrom(0, other, 0)
+	cb.Begin()
+	cb.SetDescTableGraph(dt, 0, []int{0})
+	checkErr(t, cb.End(), "incompatible heap")
+}
+
+func TestCommitBatch(t *testing.T) {
+	g := New(fakeGPU{})
+	cb1, _ := newCB(t, g)
+	cb2, _ := newCB(t, g)
+	ch := make(chan *driver.WorkItem, 2)
+	wk1 := &driver.WorkItem{Work: []driver.CmdBuffer{cb1}}
+	wk2 := &driver.WorkItem{Work: []driver.CmdBuffer{cb2}}
+
+	checkErr(t, g.CommitBatch(nil, ch), "no work items")
+	checkErr(t, g.CommitBatch([]*driver.WorkItem{nil, wk1}, ch), "nil work item")
+	checkErr(t, g.CommitBatch([]*driver.WorkItem{{}, wk1}, ch), "empty work item")
+	checkErr(t, g.CommitBatch([]*driver.WorkItem{wk1, wk2}, ch), "has not ended")
+	cb1.Begin()
+	cb1.End()
+	cb2.Begin()
+	cb2.End()
+	checkErr(t, g.CommitBatch([]*driver.WorkItem{wk1, wk1}, ch), "duplicate command buffer")
+	if err := g.CommitBatch([]*driver.WorkItem{wk1, wk2}, ch); err != nil {
+		t.Fatalf("GPU.CommitBatch failed: %v", err)
+	}
+	for range 2 {
+		if wk := <-ch; wk != wk1 && wk != wk2 {
+			t.Fatal("GPU.CommitBatch: unexpected work item")
+		}
+	}
+	if cb1.ended || cb2.ended || cb1.pending.Load() || cb2.pending.Load() {
+		t.Error("GPU.CommitBatch: command buffers should be idle")
+	}
+	checkErr(t, g.Commit(wk1, ch), "has not ended")
+}
+
+func TestComputeCmdBuffer(t *testing.T) {
+	g := New(fakeGPU{})
+	x, err := g.(driver.AsyncComputer).NewComputeCmdBuffer()
+	if err != nil {
+		t.Fatalf("GPU.NewComputeCmdBuffer failed: %v", err)
+	}
+	comp := x.(*cmdBuffer)
+	reg, _ := newCB(t, g)
+	ch := make(chan *driver.WorkItem, 2)
+
+	comp.Begin()
+	comp.BeginPass(16, 16, 1, nil, nil)
+	checkErr(t, comp.End(), "compute command buffer")
+	comp.Begin()
+	comp.Transition(nil)
+	if err := comp.End(); err != nil {
+		t.Fatalf("CmdBuffer.End failed: %v", err)
+	}
+	reg.Begin()
+	reg.End()
+	checkErr(t, g.Commit(&driver.WorkItem{Work: []driver.CmdBuffer{comp}}, ch), "non-compute work item")
+	checkErr(t, g.Commit(&driver.WorkItem{Work: []driver.CmdBuffer{comp, reg}, Compute: true}, ch), "compute and regular")
+	checkErr(t, g.CommitBatch([]*driver.WorkItem{
+		{Work: []driver.CmdBuffer{comp}, Compute: true},
+		{Work: []driver.CmdBuffer{reg}},
+	}, ch), "compute and regular")
+	if err := g.Commit(&driver.WorkItem{Work: []driver.CmdBuffer{comp}, Compute: true}, ch); err != nil {
+		t.Fatalf("GPU.Commit failed: %v", err)
+	}
+	<-ch
+}
+
+func TestTransitionUndo(t *testing.T) {
+	g := New(fakeGPU{})
+	cb, _ := newCB(t, g)
+	img, _ := g.NewImage(driver.RGBA8Unorm, driver.Dim3D{Width: 16, Height: 16}, 2, 1, 1, driver.URenderTarget)
+	im := img.(*image)
+	check := func(want driver.Layout) {
+		t.Helper()
+		if !im.checkLayout(0, 2, 0, 1, want) {
+			t.Errorf("image.layout:\nhave %v\nwant [%v %v]", im.layout, want, want)
+		}
+	}
+	tr := func(layer int, before, after driver.Layout) driver.Transition {
+		return driver.Transition{
+			LayoutBefore: before,
+			LayoutAfter:  after,
+			Img:          img,
+			Layer:        layer,
+			Layers:       1,
+			Levels:       1,
+		}
+	}
+
+	// A failed call changes no layout.
+	cb.Begin()
+	cb.Transition([]driver.Transition{
+		tr(0, driver.LUndefined, driver.LColorTarget),
+		tr(1, driver.LShaderRead, driver.LColorTarget),
+	})
+	check(driver.LUndefined)
+	checkErr(t, cb.End(), "LayoutBefore not matching")
+
+	// Layouts are restored when commands are
+	// discarded.
+	cb.Begin()
+	cb.Transition([]driver.Transition{
+		tr(0, driver.LUndefined, driver.LColorTarget),
+		tr(1, driver.LUndefined, driver.LColorTarget),
+	})
+	check(driver.LColorTarget)
+	cb.Draw(3, 1, 0, 0)
+	checkErr(t, cb.End(), "outside of a render pass")
+	check(driver.LUndefined)
+
+	cb.Begin()
+	cb.Transition([]driver.Transition{tr(0, driver.LUndefined, driver.LShaderRead), tr(1, driver.LUndefined, driver.LShaderRead)})
+	cb.Reset()
+	check(driver.LUndefined)
+
+	cb.Begin()
+	cb.Transition([]driver.Transition{tr(0, driver.LUndefined, driver.LShaderRead), tr(1, driver.LUndefined, driver.LShaderRead)})
+	if err := cb.End(); err != nil {
+		t.Fatalf("CmdBuffer.End failed: %v", err)
+	}
+	cb.Begin()
+	check(driver.LUndefined)
+
+	// Committed layouts are kept.
+	cb.Transition([]driver.Transition{tr(0, driver.LUndefined, driver.LShaderRead), tr(1, driver.LUndefined, driver.LShaderRead)})
+	if err := cb.End(); err != nil {
+		t.Fatalf("CmdBuffer.End failed: %v", err)
+	}
+	ch := make(chan *driver.WorkItem, 1)
+	if err := g.Commit(&driver.WorkItem{Work: []driver.CmdBuffer{cb}}, ch); err != nil {
+		t.Fatalf("GPU.Commit failed: %v", err)
+	}
+	<-ch
+	cb.Reset()
+	check(driver.LShaderRead)
+}
+
+func TestUpdateBuffer(t *testing.T) {
+	g := New(fakeGPU{})
+	cb, fcb := newCB(t, g)
+	buf, _ := g.NewBuffer(1024, false, driver.UCopyDst)
+	src, _ := g.NewBuffer(1024, false, driver.UCopySrc)
+
+	cb.Begin()
+	cb.UpdateBuffer(buf, 2, make([]byte, 16))
+	checkErr(t, cb.End(), "misaligned range")
+
+	cb.Begin()
+	cb.UpdateBuffer(buf, 0, nil)
+	checkErr(t, cb.End(), "misaligned range")
+
+	cb.Begin()
+	cb.UpdateBuffer(buf, 0, make([]byte, driver.MaxUpdateBuffer+4))
+	checkErr(t, cb.End(), "too much data")
+
+	cb.Begin()
+	cb.UpdateBuffer(buf, 1020, make([]byte, 8))
+	checkErr(t, cb.End(), "out of bounds")
+
+	cb.Begin()
+	cb.UpdateBuffer(src, 0, make([]byte, 16))
+	checkErr(t, cb.End(), "lacking usage")
+
+	fcb.calls = fcb.calls[:0]
+	cb.Begin()
+	cb.UpdateBuffer(buf, 1008, make([]byte, 16))
+	if err := cb.End(); err != nil {
+		t.Fatalf("CmdBuffer.End failed: %v", err)
+	}
+	if want := []string{"Begin", "UpdateBuffer", "End"}; !slices.Equal(fcb.calls, want) {
+		t.Errorf("forwarded calls:\nhave %v\nwant %v", fcb.calls, want)
+	}
+}
+
+func TestClipDistances(t *testing.T) {
+	gs := driver.GraphState{
+		VertFunc: driver.ShaderFunc{Code: []byte{0}, Name: "main"},
+		Samples:  1,
+		Raster:   driver.RasterState{ClipDistances: 1},
+	}
+	_, err := New(fakeGPU{}).(*gpu).pipelineState(&gs)
+	checkErr(t, err, "requires driver.Features.ClipDistance")
+
+	g := New(fakeClipGPU{}).(*gpu)
+	for _, x := range [...]struct {
+		n    int
+		want string
+	}{
+		{-1, "is negative"},
+		{9, "exceeds Limits.MaxClipDistances"},
+	} {
+		gs.Raster.ClipDistances = x.n
+		_, err := g.pipelineState(&gs)
+		checkErr(t, err, x.want)
+	}
+	for _, n := range [...]int{0, 1, 8} {
+		gs.Raster.ClipDistances = n
+		if _, err := g.pipelineState(&gs); err != nil {
+			t.Errorf("pipelineState: %d clip distances\nhave %v\nwant nil", n, err)
+		}
+	}
+}
+
+func TestSamples(t *testing.T) {
+	g := New(fakeGPU{}).(*gpu)
+	for _, n := range [...]int{0, 3, 16} {
+		_, err := g.NewImage(driver.RGBA8Unorm, driver.Dim3D{Width: 16, Height: 16}, 1, 1, n, driver.URenderTarget)
+		checkErr(t, err, "invalid sample count")
+	}
+	if _, err := g.NewImage(driver.RGBA8Unorm, driver.Dim3D{Width: 16, Height: 16}, 1, 1, 8, driver.URenderTarget); err != nil {
+		t.Errorf("GPU.NewImage: 8 samples\nhave %v\nwant nil", err)
+	}
+
+	gs := driver.GraphState{VertFunc: driver.ShaderFunc{Code: []byte{0}, Name: "main"}}
+	for _, x := range [...]struct {
+		n    int
+		want string
+	}{
+		{0, "not a power of two"},
+		{3, "not a power of two"},
+		{16, "exceeds Limits.MaxRenderSamples"},
+	} {
+		gs.Samples = x.n
+		_, err := g.pipelineState(&gs)
+		checkErr(t, err, x.want)
+	}
+	for _, n := range [...]int{1, 2, 4, 8} {
+		gs.Samples = n
+		if _, err := g.pipelineState(&gs); err != nil {
+			t.Errorf("pipelineState: %d samples\nhave %v\nwant nil", n, err)
+		}
+	}
+}
+
+func TestTrack(t *testing.T) {
+	g := Track(fakeGPU{})
+	if n := len(Leaks(g)); n != 0 {
+		t.Fatalf("Leaks: len\nhave %d\nwant 0", n)
+	}
+	if l := Leaks(New(fakeGPU{})); l != nil {
+		t.Fatalf("Leaks: untracked GPU\nhave %v\nwant nil", l)
+	}
+
+	buf, _ := g.NewBuffer(256, false, driver.UCopyDst)
+	img, _ := g.NewImage(driver.RGBA8Unorm, driver.Dim3D{Width: 16, Height: 16}, 1, 1, 1, driver.UShaderSample)
+	iv, _ := img.NewView(driver.IView2D, 0, 1, 0, 1)
+
+	l := Leaks(g)
+	if len(l) != 3 {
+		t.Fatalf("Leaks: len\nhave %d\nwant 3", len(l))
+	}
+	for i, typ := range [...]string{"Buffer", "Image", "ImageView"} {
+		if l[i].Type != typ {
+			t.Errorf("Leaks: [%d].Type\nhave %s\nwant %s", i, l[i].Type, typ)
+		}
+		if !strings.Contains(l[i].Stack, "TestTrack") {
+			t.Errorf("Leaks: [%d].Stack does not contain the creation site", i)
+		}
+	}
+
+	if l[1].Refs != 1 || l[0].Refs != 0 || l[2].Refs != 0 {
+		t.Errorf("Leaks: Refs\nhave %d, %d, %d\nwant 0, 1, 0", l[0].Refs, l[1].Refs, l[2].Refs)
+	}
+
+	var w strings.Builder
+	g.(*gpu).trk.w = &w
+	iv.Destroy()
+	buf.Destroy()
+	if l := Leaks(g); len(l) != 1 || l[0].Type != "Image" || l[0].Refs != 0 {
+		t.Errorf("Leaks: after Destroy\nhave %v\nwant [Image]", l)
+	}
+	if w.Len() != 0 {
+		t.Errorf("Destroy: unexpected report\n%s", w.String())
+	}
+	img.Destroy()
+	if n := len(Leaks(g)); n != 0 {
+		t.Errorf("Leaks: len\nhave %d\nwant 0", n)
+	}
+
+	// Misuse is reported, but forwarded.
+	img, _ = g.NewImage(driver.RGBA8Unorm, driver.Dim3D{Width: 16, Height: 16}, 1, 1, 1, driver.UShaderSample)
+	iv, _ = img.NewView(driver.IView2D, 0, 1, 0, 1)
+	img.Destroy()
+	if s := w.String(); !strings.Contains(s, "Image destroyed while 1 view(s) refer to it") || !strings.Contains(s, "TestTrack") {
+		t.Errorf("Image.Destroy: live view\nhave %q", s)
+	}
+	w.Reset()
+	iv.Destroy()
+	if w.Len() != 0 {
+		t.Errorf("ImageView.Destroy: unexpected report\n%s", w.String())
+	}
+	buf, _ = g.NewBuffer(256, false, driver.UCopyDst)
+	buf.Destroy()
+	buf.Destroy()
+	if s := w.String(); !strings.Contains(s, "Buffer destroyed more than once") {
+		t.Errorf("Buffer.Destroy: twice\nhave %q", s)
+	}
+	if n := len(Leaks(g)); n != 0 {
+		t.Errorf("Leaks: len\nhave %d\nwant 0", n)
+	}
+}
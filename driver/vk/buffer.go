@@ -2,10 +2,13 @@
 
 package vk
 
+// #include <stdlib.h>
 // #include <proc.h>
 import "C"
 
 import (
+	"unsafe"
+
 	"gviegas/neo3/driver"
 )
 
@@ -13,10 +16,17 @@ import (
 type buffer struct {
 	m   *memory
 	buf C.VkBuffer
+	usg C.VkBufferUsageFlags
 }
 
 // NewBuffer creates a new buffer.
 func (d *Driver) NewBuffer(size int64, visible bool, usg driver.Usage) (driver.Buffer, error) {
+	return d.newBuffer(size, visible, usg, nil)
+}
+
+// newBuffer creates a new buffer whose memory is
+// external if x is not nil.
+func (d *Driver) newBuffer(size int64, visible bool, usg driver.Usage, x *extMem) (driver.Buffer, error) {
 	var u C.VkBufferUsageFlags
 	if usg&driver.UCopySrc != 0 {
 		u |= C.VK_BUFFER_USAGE_TRANSFER_SRC_BIT
@@ -27,13 +37,11 @@ func (d *Driver) NewBuffer(size int64, visible bool, usg driver.Usage) (driver.B
 	// Not exposed.
 	//u |= C.VK_BUFFER_USAGE_INDIRECT_BUFFER_BIT
 	if usg&(driver.UShaderRead|driver.UShaderWrite) != 0 {
-		// Not exposed.
-		//u |= C.VK_BUFFER_USAGE_STORAGE_TEXEL_BUFFER_BIT
+		u |= C.VK_BUFFER_USAGE_STORAGE_TEXEL_BUFFER_BIT
 		u |= C.VK_BUFFER_USAGE_STORAGE_BUFFER_BIT
 	}
 	if usg&driver.UShaderConst != 0 {
-		// Not exposed.
-		//u |= C.VK_BUFFER_USAGE_UNIFORM_TEXEL_BUFFER_BIT
+		u |= C.VK_BUFFER_USAGE_UNIFORM_TEXEL_BUFFER_BIT
 		u |= C.VK_BUFFER_USAGE_UNIFORM_BUFFER_BIT
 	}
 	if usg&driver.UVertexData != 0 {
@@ -49,20 +57,39 @@ func (d *Driver) NewBuffer(size int64, visible bool, usg driver.Usage) (driver.B
 		usage:       u,
 		sharingMode: C.VK_SHARING_MODE_EXCLUSIVE,
 	}
+	if d.shareFams != nil {
+		info.sharingMode = C.VK_SHARING_MODE_CONCURRENT
+		info.queueFamilyIndexCount = 2
+		info.pQueueFamilyIndices = d.shareFams
+	}
+	if x != nil {
+		ext := (*C.VkExternalMemoryBufferCreateInfo)(C.malloc(C.sizeof_VkExternalMemoryBufferCreateInfo))
+		defer C.free(unsafe.Pointer(ext))
+		*ext = C.VkExternalMemoryBufferCreateInfo{
+			sType:       C.VK_STRUCTURE_TYPE_EXTERNAL_MEMORY_BUFFER_CREATE_INFO,
+			handleTypes: C.VkExternalMemoryHandleTypeFlags(convHandleType(x.h.Type)),
+		}
+		info.pNext = unsafe.Pointer(ext)
+	}
 	var buf C.VkBuffer
-	err := checkResult(C.vkCreateBuffer(d.dev, &info, nil, &buf))
+	err := d.checkLost("NewBuffer", checkResult(C.vkCreateBuffer(d.dev, &info, nil, &buf)))
 	if err != nil {
 		return nil, err
 	}
 
 	var req C.VkMemoryRequirements
 	C.vkGetBufferMemoryRequirements(d.dev, buf, &req)
-	m, err := d.newMemory(req, visible)
+	var m *memory
+	if x == nil {
+		m, err = d.newMemory(req, visible)
+	} else {
+		m, err = d.newExtMemory(req, visible, x, C.VkMemoryDedicatedAllocateInfo{buffer: buf})
+	}
 	if err != nil {
 		C.vkDestroyBuffer(d.dev, buf, nil)
 		return nil, err
 	}
-	err = checkResult(C.vkBindBufferMemory(d.dev, buf, m.mem, 0))
+	err = d.checkLost("NewBuffer", checkResult(C.vkBindBufferMemory(d.dev, buf, m.mem, 0)))
 	if err != nil {
 		m.free()
 		C.vkDestroyBuffer(d.dev, buf, nil)
@@ -81,6 +108,7 @@ func (d *Driver) NewBuffer(size int64, visible bool, usg driver.Usage) (driver.B
 	return &buffer{
 		m:   m,
 		buf: buf,
+		usg: u,
 	}, nil
 }
 
@@ -104,3 +132,60 @@ func (b *buffer) Destroy() {
 	}
 	*b = buffer{}
 }
+
+// NewView creates a new buffer view.
+// The format must support every texel buffer usage
+// of b, since the view can be used as either.
+func (b *buffer) NewView(pf driver.PixelFmt, off, size int64) (driver.BufferView, error) {
+	format := convPixelFmt(pf)
+	var prop C.VkFormatProperties
+	C.vkGetPhysicalDeviceFormatProperties(b.m.d.pdev, format, &prop)
+	var need C.VkFormatFeatureFlags
+	if b.usg&C.VK_BUFFER_USAGE_UNIFORM_TEXEL_BUFFER_BIT != 0 {
+		need |= C.VK_FORMAT_FEATURE_UNIFORM_TEXEL_BUFFER_BIT
+	}
+	if b.usg&C.VK_BUFFER_USAGE_STORAGE_TEXEL_BUFFER_BIT != 0 {
+		need |= C.VK_FORMAT_FEATURE_STORAGE_TEXEL_BUFFER_BIT
+	}
+	if need == 0 {
+		return nil, driver.NewError("vk: buffer has no texel buffer usage", driver.ErrInvalidParam)
+	}
+	if prop.bufferFeatures&need != need {
+		return nil, errUnsupportedFormat
+	}
+	info := C.VkBufferViewCreateInfo{
+		sType:  C.VK_STRUCTURE_TYPE_BUFFER_VIEW_CREATE_INFO,
+		buffer: b.buf,
+		format: format,
+		offset: C.VkDeviceSize(off),
+		_range: C.VkDeviceSize(size),
+	}
+	var view C.VkBufferView
+	if err := b.m.d.checkLost("Buffer.NewView", checkResult(C.vkCreateBufferView(b.m.d.dev, &info, nil, &view))); err != nil {
+		return nil, err
+	}
+	return &bufferView{
+		b:    b,
+		view: view,
+	}, nil
+}
+
+// bufferView implements driver.BufferView.
+type bufferView struct {
+	b    *buffer
+	view C.VkBufferView
+}
+
+// Buffer returns the buffer from which the view was created.
+func (v *bufferView) Buffer() driver.Buffer { return v.b }
+
+// Destroy destroys the buffer view.
+func (v *bufferView) Destroy() {
+	if v == nil {
+		return
+	}
+	if v.b != nil && v.b.m != nil {
+		C.vkDestroyBufferView(v.b.m.d.dev, v.view, nil)
+	}
+	*v = bufferView{}
+}
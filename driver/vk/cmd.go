@@ -7,6 +7,7 @@ package vk
 import "C"
 
 import (
+	"slices"
 	"unsafe"
 
 	"gviegas/neo3/driver"
@@ -21,6 +22,167 @@ type cmdBuffer struct {
 	status cbStatus
 	err    error // Why cbFailed.
 	pres   []presentOp
+	scr    scratch
+	bat    cmdBatch
+}
+
+// scratch is grow-only C memory used to pass arrays
+// to recording commands.
+// Vulkan does not retain the parameters of vkCmd*
+// calls, so every command reuses the same memory.
+type scratch struct {
+	p unsafe.Pointer
+	n int
+}
+
+// scratchMax is the size above which scratch memory
+// is released when a command buffer begins.
+const scratchMax = 65536
+
+// get returns at least size bytes of scratch memory.
+// The memory is only valid until the next call to
+// get or free.
+func (s *scratch) get(size int) unsafe.Pointer {
+	if size > s.n {
+		n := max(256, s.n)
+		for n < size {
+			n *= 2
+		}
+		C.free(s.p)
+		s.p = C.malloc(C.size_t(n))
+		s.n = n
+	}
+	return s.p
+}
+
+// free releases the scratch memory.
+func (s *scratch) free() {
+	C.free(s.p)
+	*s = scratch{}
+}
+
+// scratchCopy copies x into scratch memory and returns
+// a pointer to the first element.
+// x must not be empty.
+func scratchCopy[T any](s *scratch, x []T) *T {
+	p := (*T)(s.get(int(unsafe.Sizeof(x[0])) * len(x)))
+	copy(unsafe.Slice(p, len(x)), x)
+	return p
+}
+
+// cmdBatch holds commands whose recording is deferred
+// so that consecutive calls can be merged into a single
+// Vulkan command.
+// Copies between the same source and destination are
+// recorded as one copy with many regions, and layout
+// transitions as one vkCmdPipelineBarrier2KHR with many
+// image barriers. Commands that are not separated by a
+// barrier are not ordered with respect to each other,
+// so this does not change their semantics.
+// The batch is flushed by any command that accesses
+// memory or synchronizes, and by End. Commands that
+// only set state do not flush it.
+type cmdBatch struct {
+	kind batchKind
+	// Source and destination of copies.
+	from, to any
+	ibar     []C.VkImageMemoryBarrier2KHR
+	bcpy     []C.VkBufferCopy2KHR
+	icpy     []C.VkImageCopy2KHR
+	bicpy    []C.VkBufferImageCopy2KHR
+}
+
+// batchKind identifies the command held by a cmdBatch.
+type batchKind int
+
+// batchKind constants.
+const (
+	batchNone batchKind = iota
+	batchTransition
+	batchCopyBuffer
+	batchCopyImage
+	batchCopyBufToImg
+	batchCopyImgToBuf
+)
+
+// batchFor returns cb.bat, ready to hold a command of
+// the given kind between from and to.
+// Pending commands that cannot be merged with it are
+// flushed first.
+func (cb *cmdBuffer) batchFor(kind batchKind, from, to any) *cmdBatch {
+	b := &cb.bat
+	if b.kind != kind || b.from != from || b.to != to {
+		cb.flush()
+		b.kind, b.from, b.to = kind, from, to
+	}
+	return b
+}
+
+// flush records the commands held by cb.bat.
+func (cb *cmdBuffer) flush() {
+	b := &cb.bat
+	switch b.kind {
+	case batchNone:
+		return
+	case batchTransition:
+		dep := C.VkDependencyInfoKHR{
+			sType:                   C.VK_STRUCTURE_TYPE_DEPENDENCY_INFO_KHR,
+			imageMemoryBarrierCount: C.uint32_t(len(b.ibar)),
+			pImageMemoryBarriers:    scratchCopy(&cb.scr, b.ibar),
+		}
+		C.vkCmdPipelineBarrier2KHR(cb.cb, &dep)
+	case batchCopyBuffer:
+		info := C.VkCopyBufferInfo2KHR{
+			sType:       C.VK_STRUCTURE_TYPE_COPY_BUFFER_INFO_2_KHR,
+			srcBuffer:   b.from.(*buffer).buf,
+			dstBuffer:   b.to.(*buffer).buf,
+			regionCount: C.uint32_t(len(b.bcpy)),
+			pRegions:    scratchCopy(&cb.scr, b.bcpy),
+		}
+		C.vkCmdCopyBuffer2KHR(cb.cb, &info)
+	case batchCopyImage:
+		info := C.VkCopyImageInfo2KHR{
+			sType:          C.VK_STRUCTURE_TYPE_COPY_IMAGE_INFO_2_KHR,
+			srcImage:       b.from.(*image).img,
+			srcImageLayout: C.VK_IMAGE_LAYOUT_TRANSFER_SRC_OPTIMAL,
+			dstImage:       b.to.(*image).img,
+			dstImageLayout: C.VK_IMAGE_LAYOUT_TRANSFER_DST_OPTIMAL,
+			regionCount:    C.uint32_t(len(b.icpy)),
+			pRegions:       scratchCopy(&cb.scr, b.icpy),
+		}
+		C.vkCmdCopyImage2KHR(cb.cb, &info)
+	case batchCopyBufToImg:
+		info := C.VkCopyBufferToImageInfo2KHR{
+			sType:          C.VK_STRUCTURE_TYPE_COPY_BUFFER_TO_IMAGE_INFO_2_KHR,
+			srcBuffer:      b.from.(*buffer).buf,
+			dstImage:       b.to.(*image).img,
+			dstImageLayout: C.VK_IMAGE_LAYOUT_TRANSFER_DST_OPTIMAL,
+			regionCount:    C.uint32_t(len(b.bicpy)),
+			pRegions:       scratchCopy(&cb.scr, b.bicpy),
+		}
+		C.vkCmdCopyBufferToImage2KHR(cb.cb, &info)
+	case batchCopyImgToBuf:
+		info := C.VkCopyImageToBufferInfo2KHR{
+			sType:          C.VK_STRUCTURE_TYPE_COPY_IMAGE_TO_BUFFER_INFO_2_KHR,
+			srcImage:       b.from.(*image).img,
+			srcImageLayout: C.VK_IMAGE_LAYOUT_TRANSFER_SRC_OPTIMAL,
+			dstBuffer:      b.to.(*buffer).buf,
+			regionCount:    C.uint32_t(len(b.bicpy)),
+			pRegions:       scratchCopy(&cb.scr, b.bicpy),
+		}
+		C.vkCmdCopyImageToBuffer2KHR(cb.cb, &info)
+	}
+	b.discard()
+}
+
+// discard discards the commands held by b.
+func (b *cmdBatch) discard() {
+	b.kind = batchNone
+	b.from, b.to = nil, nil
+	b.ibar = b.ibar[:0]
+	b.bcpy = b.bcpy[:0]
+	b.icpy = b.icpy[:0]
+	b.bicpy = b.bicpy[:0]
 }
 
 // cbStatus represents the status of the
@@ -77,6 +239,17 @@ func (d *Driver) NewCmdBuffer() (driver.CmdBuffer, error) {
 	return cb, nil
 }
 
+// NewComputeCmdBuffer creates a new command buffer
+// for async compute work.
+// Its pool is created using d.cfam.
+func (d *Driver) NewComputeCmdBuffer() (driver.CmdBuffer, error) {
+	cb, err := d.newCmdBuffer(d.cfam)
+	if err != nil {
+		return nil, err
+	}
+	return cb, nil
+}
+
 // newCmdBuffer creates a new command buffer.
 // The command buffer handle is allocated from an exclusive command pool.
 // It must only be submitted to d.ques[qfam].
@@ -86,7 +259,7 @@ func (d *Driver) newCmdBuffer(qfam C.uint32_t) (*cmdBuffer, error) {
 		sType:            C.VK_STRUCTURE_TYPE_COMMAND_POOL_CREATE_INFO,
 		queueFamilyIndex: qfam,
 	}
-	err := checkResult(C.vkCreateCommandPool(d.dev, &poolInfo, nil, &pool))
+	err := d.checkLost("NewCmdBuffer", checkResult(C.vkCreateCommandPool(d.dev, &poolInfo, nil, &pool)))
 	if err != nil {
 		return nil, err
 	}
@@ -97,7 +270,7 @@ func (d *Driver) newCmdBuffer(qfam C.uint32_t) (*cmdBuffer, error) {
 		level:              C.VK_COMMAND_BUFFER_LEVEL_PRIMARY,
 		commandBufferCount: 1,
 	}
-	err = checkResult(C.vkAllocateCommandBuffers(d.dev, &cbInfo, &cb))
+	err = d.checkLost("NewCmdBuffer", checkResult(C.vkAllocateCommandBuffers(d.dev, &cbInfo, &cb)))
 	if err != nil {
 		C.vkDestroyCommandPool(d.dev, pool, nil)
 		return nil, err
@@ -114,15 +287,21 @@ func (d *Driver) newCmdBuffer(qfam C.uint32_t) (*cmdBuffer, error) {
 func (cb *cmdBuffer) Begin() error {
 	switch cb.status {
 	case cbIdle:
-		err := checkResult(C.vkResetCommandPool(cb.d.dev, cb.pool, 0))
+		err := cb.d.checkLost("CmdBuffer.Begin", checkResult(C.vkResetCommandPool(cb.d.dev, cb.pool, 0)))
 		if err != nil {
 			return err
 		}
+		cb.bat.discard()
+		if cb.scr.n > scratchMax {
+			// Do not hold onto a large block
+			// because of a single command.
+			cb.scr.free()
+		}
 		info := C.VkCommandBufferBeginInfo{
 			sType: C.VK_STRUCTURE_TYPE_COMMAND_BUFFER_BEGIN_INFO,
 			flags: C.VK_COMMAND_BUFFER_USAGE_ONE_TIME_SUBMIT_BIT,
 		}
-		err = checkResult(C.vkBeginCommandBuffer(cb.cb, &info))
+		err = cb.d.checkLost("CmdBuffer.Begin", checkResult(C.vkBeginCommandBuffer(cb.cb, &info)))
 		if err != nil {
 			return err
 		}
@@ -140,7 +319,8 @@ func (cb *cmdBuffer) Begin() error {
 func (cb *cmdBuffer) End() error {
 	switch cb.status {
 	case cbBegun:
-		if err := checkResult(C.vkEndCommandBuffer(cb.cb)); err != nil {
+		cb.flush()
+		if err := cb.d.checkLost("CmdBuffer.End", checkResult(C.vkEndCommandBuffer(cb.cb))); err != nil {
 			// This suffices since cb.pool is reset on Begin.
 			cb.status = cbIdle
 			cb.detachSC()
@@ -173,6 +353,7 @@ func (cb *cmdBuffer) Reset() error {
 	case cbEnded:
 		cb.status = cbIdle
 		cb.detachSC()
+		cb.bat.discard()
 		fallthrough
 	case cbIdle:
 		// The actual reset happens on Begin.
@@ -190,8 +371,9 @@ func (cb *cmdBuffer) IsRecording() bool {
 
 // Barrier inserts a number of global barriers in the command buffer.
 func (cb *cmdBuffer) Barrier(b []driver.Barrier) {
+	cb.flush()
 	nb := len(b)
-	pb := (*C.VkMemoryBarrier2KHR)(C.malloc(C.sizeof_VkMemoryBarrier2KHR * C.size_t(nb)))
+	pb := (*C.VkMemoryBarrier2KHR)(cb.scr.get(C.sizeof_VkMemoryBarrier2KHR * nb))
 	sb := unsafe.Slice(pb, nb)
 	for i := range sb {
 		sb[i] = C.VkMemoryBarrier2KHR{
@@ -208,18 +390,25 @@ func (cb *cmdBuffer) Barrier(b []driver.Barrier) {
 		pMemoryBarriers:    pb,
 	}
 	C.vkCmdPipelineBarrier2KHR(cb.cb, &dep)
-	C.free(unsafe.Pointer(pb))
 }
 
 // Transition inserts a number of image layout transitions in the
 // command buffer.
+// Consecutive calls are merged into a single barrier, unless
+// they transition the same image.
 func (cb *cmdBuffer) Transition(t []driver.Transition) {
-	nib := len(t)
-	pib := (*C.VkImageMemoryBarrier2KHR)(C.malloc(C.sizeof_VkImageMemoryBarrier2KHR * C.size_t(nib)))
-	sib := unsafe.Slice(pib, nib)
-	for i := range sib {
+	b := cb.batchFor(batchTransition, nil, nil)
+	for i := range t {
+		img := t[i].Img.(*image).img
+		if slices.ContainsFunc(b.ibar, func(x C.VkImageMemoryBarrier2KHR) bool { return x.image == img }) {
+			cb.flush()
+			b = cb.batchFor(batchTransition, nil, nil)
+			break
+		}
+	}
+	for i := range t {
 		img := t[i].Img.(*image)
-		sib[i] = C.VkImageMemoryBarrier2KHR{
+		b.ibar = append(b.ibar, C.VkImageMemoryBarrier2KHR{
 			sType:         C.VK_STRUCTURE_TYPE_IMAGE_MEMORY_BARRIER_2_KHR,
 			srcStageMask:  convSync(t[i].SyncBefore),
 			srcAccessMask: convAccess(t[i].AccessBefore),
@@ -235,6 +424,24 @@ func (cb *cmdBuffer) Transition(t []driver.Transition) {
 				baseArrayLayer: C.uint32_t(t[i].Layer),
 				layerCount:     C.uint32_t(t[i].Layers),
 			},
+		})
+		ib := &b.ibar[len(b.ibar)-1]
+		// Images shared by d.qfam and d.cfam use
+		// concurrent sharing mode, which requires
+		// the non-external family to be ignored.
+		own := cb.qfam
+		if cb.d.shareFams != nil && img.m != nil {
+			own = C.VK_QUEUE_FAMILY_IGNORED
+		}
+		switch t[i].Xfer {
+		case driver.OAcquire:
+			ib.srcAccessMask = 0
+			ib.srcQueueFamilyIndex = C.VK_QUEUE_FAMILY_EXTERNAL
+			ib.dstQueueFamilyIndex = own
+		case driver.ORelease:
+			ib.dstAccessMask = 0
+			ib.srcQueueFamilyIndex = own
+			ib.dstQueueFamilyIndex = C.VK_QUEUE_FAMILY_EXTERNAL
 		}
 		if img.m != nil {
 			continue
@@ -274,12 +481,12 @@ func (cb *cmdBuffer) Transition(t []driver.Transition) {
 			// Queue transfer from rendering to presentation.
 			// This transfer must always be performed when
 			// using different queues.
-			sib[i].srcQueueFamilyIndex = cb.qfam
-			sib[i].dstQueueFamilyIndex = sc.qfam
+			ib.srcQueueFamilyIndex = cb.qfam
+			ib.dstQueueFamilyIndex = sc.qfam
 			dep := C.VkDependencyInfoKHR{
 				sType:                   C.VK_STRUCTURE_TYPE_DEPENDENCY_INFO_KHR,
 				imageMemoryBarrierCount: 1,
-				pImageMemoryBarriers:    &sib[i],
+				pImageMemoryBarriers:    scratchCopy(&cb.scr, []C.VkImageMemoryBarrier2KHR{*ib}),
 			}
 			presAcq := sc.getQueSync(viewIdx).presAcq
 			if err := presAcq.Begin(); err != nil {
@@ -298,12 +505,12 @@ func (cb *cmdBuffer) Transition(t []driver.Transition) {
 			// Queue transfer from presentation to rendering.
 			// This transfer can be skipped by transitioning
 			// from driver.LUndefined instead.
-			sib[i].srcQueueFamilyIndex = sc.qfam
-			sib[i].dstQueueFamilyIndex = cb.qfam
+			ib.srcQueueFamilyIndex = sc.qfam
+			ib.dstQueueFamilyIndex = cb.qfam
 			dep := C.VkDependencyInfoKHR{
 				sType:                   C.VK_STRUCTURE_TYPE_DEPENDENCY_INFO_KHR,
 				imageMemoryBarrierCount: 1,
-				pImageMemoryBarriers:    &sib[i],
+				pImageMemoryBarriers:    scratchCopy(&cb.scr, []C.VkImageMemoryBarrier2KHR{*ib}),
 			}
 			presRel := sc.getQueSync(viewIdx).presRel
 			if err := presRel.Begin(); err != nil {
@@ -319,19 +526,25 @@ func (cb *cmdBuffer) Transition(t []driver.Transition) {
 			continue
 		}
 	}
-	dep := C.VkDependencyInfoKHR{
-		sType:                   C.VK_STRUCTURE_TYPE_DEPENDENCY_INFO_KHR,
-		imageMemoryBarrierCount: C.uint32_t(nib),
-		pImageMemoryBarriers:    pib,
-	}
-	C.vkCmdPipelineBarrier2KHR(cb.cb, &dep)
-	C.free(unsafe.Pointer(pib))
 }
 
 // BeginPass begins a render pass.
 func (cb *cmdBuffer) BeginPass(width, height, layers int, color []driver.ColorTarget, ds *driver.DSTarget) {
+	cb.beginPass(width, height, layers, 0, color, ds)
+}
+
+// BeginPassViews begins a multiview render pass.
+func (cb *cmdBuffer) BeginPassViews(width, height int, viewMask uint32, color []driver.ColorTarget, ds *driver.DSTarget) {
+	// layerCount is ignored when viewMask is not zero.
+	cb.beginPass(width, height, 1, viewMask, color, ds)
+}
+
+// beginPass begins a render pass.
+// If viewMask is not zero, then layers is ignored.
+func (cb *cmdBuffer) beginPass(width, height, layers int, viewMask uint32, color []driver.ColorTarget, ds *driver.DSTarget) {
+	cb.flush()
 	natt := len(color) + 2
-	patt := (*C.VkRenderingAttachmentInfoKHR)(C.malloc(C.sizeof_VkRenderingAttachmentInfoKHR * C.size_t(natt)))
+	patt := (*C.VkRenderingAttachmentInfoKHR)(cb.scr.get(C.sizeof_VkRenderingAttachmentInfoKHR * natt))
 	satt := unsafe.Slice(patt, natt)
 	var (
 		pcolor   *C.VkRenderingAttachmentInfoKHR
@@ -447,14 +660,13 @@ func (cb *cmdBuffer) BeginPass(width, height, layers int, color []driver.ColorTa
 			},
 		},
 		layerCount:           C.uint32_t(layers),
-		viewMask:             0,
+		viewMask:             C.uint32_t(viewMask),
 		colorAttachmentCount: C.uint32_t(natt - 2),
 		pColorAttachments:    pcolor,
 		pDepthAttachment:     pdepth,
 		pStencilAttachment:   pstencil,
 	}
 	C.vkCmdBeginRenderingKHR(cb.cb, &info)
-	C.free(unsafe.Pointer(patt))
 }
 
 // EndPass ends the current render pass.
@@ -521,8 +733,9 @@ func (cb *cmdBuffer) SetVertexBuf(start int, buf []driver.Buffer, off []int64) {
 		off := C.VkDeviceSize(off[0])
 		C.vkCmdBindVertexBuffers(cb.cb, C.uint32_t(start), 1, &buf, &off)
 	case nbuf > 1:
-		sbuf := make([]C.VkBuffer, nbuf)
-		soff := make([]C.VkDeviceSize, nbuf)
+		p := cb.scr.get((C.sizeof_VkDeviceSize + C.sizeof_VkBuffer) * nbuf)
+		soff := unsafe.Slice((*C.VkDeviceSize)(p), nbuf)
+		sbuf := unsafe.Slice((*C.VkBuffer)(unsafe.Add(p, C.sizeof_VkDeviceSize*nbuf)), nbuf)
 		for i := range sbuf {
 			sbuf[i] = buf[i].(*buffer).buf
 			soff[i] = C.VkDeviceSize(off[i])
@@ -562,7 +775,7 @@ func (cb *cmdBuffer) setDescTable(table driver.DescTable, start int, heapCopy []
 		set := desc.h[start].sets[heapCopy[0]]
 		C.vkCmdBindDescriptorSets(cb.cb, bindPoint, desc.layout, C.uint32_t(start), 1, &set, 0, nil)
 	case ncpy > 1:
-		set := make([]C.VkDescriptorSet, ncpy)
+		set := unsafe.Slice((*C.VkDescriptorSet)(cb.scr.get(C.sizeof_VkDescriptorSet*ncpy)), ncpy)
 		for i := range set {
 			set[i] = desc.h[start+i].sets[heapCopy[i]]
 		}
@@ -582,27 +795,35 @@ func (cb *cmdBuffer) DrawIndexed(idxCnt, instCnt, baseIdx, vertOff, baseInst int
 
 // Dispatch dispatches compute thread groups.
 func (cb *cmdBuffer) Dispatch(grpCntX, grpCntY, grpCntZ int) {
+	cb.flush()
 	C.vkCmdDispatch(cb.cb, C.uint32_t(grpCntX), C.uint32_t(grpCntY), C.uint32_t(grpCntZ))
 }
 
 // CopyBuffer copies data between buffers.
+// Consecutive copies between the same buffers are recorded
+// as a single copy command.
 func (cb *cmdBuffer) CopyBuffer(param *driver.BufferCopy) {
-	cpy := C.VkBufferCopy{
+	b := cb.batchFor(batchCopyBuffer, param.From, param.To)
+	b.bcpy = append(b.bcpy, C.VkBufferCopy2KHR{
+		sType:     C.VK_STRUCTURE_TYPE_BUFFER_COPY_2_KHR,
 		srcOffset: C.VkDeviceSize(param.FromOff),
 		dstOffset: C.VkDeviceSize(param.ToOff),
 		size:      C.VkDeviceSize(param.Size),
-	}
-	C.vkCmdCopyBuffer(cb.cb, param.From.(*buffer).buf, param.To.(*buffer).buf, 1, &cpy)
+	})
 }
 
 // CopyImage copies data between images.
+// Consecutive copies between the same images are recorded
+// as a single copy command.
 func (cb *cmdBuffer) CopyImage(param *driver.ImageCopy) {
 	from := param.From.(*image)
 	to := param.To.(*image)
 	width := max(1, param.Size.Width)
 	height := max(1, param.Size.Height)
 	depth := max(1, param.Size.Depth)
-	cpy := C.VkImageCopy{
+	b := cb.batchFor(batchCopyImage, param.From, param.To)
+	b.icpy = append(b.icpy, C.VkImageCopy2KHR{
+		sType: C.VK_STRUCTURE_TYPE_IMAGE_COPY_2_KHR,
 		srcSubresource: C.VkImageSubresourceLayers{
 			aspectMask:     from.subres.aspectMask,
 			mipLevel:       C.uint32_t(param.FromLevel),
@@ -630,61 +851,71 @@ func (cb *cmdBuffer) CopyImage(param *driver.ImageCopy) {
 			height: C.uint32_t(height),
 			depth:  C.uint32_t(depth),
 		},
-	}
-	const (
-		slayout = C.VK_IMAGE_LAYOUT_TRANSFER_SRC_OPTIMAL
-		dlayout = C.VK_IMAGE_LAYOUT_TRANSFER_DST_OPTIMAL
-	)
-	C.vkCmdCopyImage(cb.cb, from.img, slayout, to.img, dlayout, 1, &cpy)
+	})
 }
 
-// CopyBufToImg copies data from a buffer to an image.
-func (cb *cmdBuffer) CopyBufToImg(param *driver.BufImgCopy) {
-	buf := param.Buf.(*buffer)
-	img := param.Img.(*image)
-	var aspect C.VkImageAspectFlags
-	if img.subres.aspectMask == C.VK_IMAGE_ASPECT_DEPTH_BIT|C.VK_IMAGE_ASPECT_STENCIL_BIT {
-		switch param.Plane {
-		case 0:
-			aspect = C.VK_IMAGE_ASPECT_DEPTH_BIT
-		case 1:
-			aspect = C.VK_IMAGE_ASPECT_STENCIL_BIT
-		}
-	} else {
-		aspect = img.subres.aspectMask
-	}
-	width := max(1, param.Size.Width)
-	height := max(1, param.Size.Height)
-	depth := max(1, param.Size.Depth)
-	cpy := C.VkBufferImageCopy{
-		bufferOffset:      C.VkDeviceSize(param.BufOff),
-		bufferRowLength:   C.uint32_t(param.RowStrd),
-		bufferImageHeight: C.uint32_t(param.SlcStrd),
-		imageSubresource: C.VkImageSubresourceLayers{
-			aspectMask:     aspect,
-			mipLevel:       C.uint32_t(param.Level),
-			baseArrayLayer: C.uint32_t(param.Layer),
+// BlitImage copies a region of an image to a region of
+// another image, scaling and filtering it as needed.
+func (cb *cmdBuffer) BlitImage(param *driver.ImageBlit) {
+	cb.flush()
+	from := param.From.(*image)
+	to := param.To.(*image)
+	blit := C.VkImageBlit{
+		srcSubresource: C.VkImageSubresourceLayers{
+			aspectMask:     from.subres.aspectMask,
+			mipLevel:       C.uint32_t(param.FromLevel),
+			baseArrayLayer: C.uint32_t(param.FromLayer),
 			layerCount:     C.uint32_t(param.Layers),
 		},
-		imageOffset: C.VkOffset3D{
-			x: C.int32_t(param.ImgOff.X),
-			y: C.int32_t(param.ImgOff.Y),
-			z: C.int32_t(param.ImgOff.Z),
+		dstSubresource: C.VkImageSubresourceLayers{
+			aspectMask:     to.subres.aspectMask,
+			mipLevel:       C.uint32_t(param.ToLevel),
+			baseArrayLayer: C.uint32_t(param.ToLayer),
+			layerCount:     C.uint32_t(param.Layers),
 		},
-		imageExtent: C.VkExtent3D{
-			width:  C.uint32_t(width),
-			height: C.uint32_t(height),
-			depth:  C.uint32_t(depth),
+	}
+	blit.srcOffsets = blitOffsets(param.FromOff, param.FromSize)
+	blit.dstOffsets = blitOffsets(param.ToOff, param.ToSize)
+	C.vkCmdBlitImage(cb.cb, from.img, C.VK_IMAGE_LAYOUT_TRANSFER_SRC_OPTIMAL, to.img, C.VK_IMAGE_LAYOUT_TRANSFER_DST_OPTIMAL, 1, &blit, convFilter(param.Filter))
+}
+
+// blitOffsets converts an image region to the pair of
+// offsets that bound it in a VkImageBlit.
+func blitOffsets(off driver.Off3D, size driver.Dim3D) [2]C.VkOffset3D {
+	return [2]C.VkOffset3D{
+		{
+			x: C.int32_t(off.X),
+			y: C.int32_t(off.Y),
+			z: C.int32_t(off.Z),
+		},
+		{
+			x: C.int32_t(off.X + max(1, size.Width)),
+			y: C.int32_t(off.Y + max(1, size.Height)),
+			z: C.int32_t(off.Z + max(1, size.Depth)),
 		},
 	}
-	const layout = C.VK_IMAGE_LAYOUT_TRANSFER_DST_OPTIMAL
-	C.vkCmdCopyBufferToImage(cb.cb, buf.buf, img.img, layout, 1, &cpy)
+}
+
+// CopyBufToImg copies data from a buffer to an image.
+// Consecutive copies between the same buffer and image are
+// recorded as a single copy command.
+func (cb *cmdBuffer) CopyBufToImg(param *driver.BufImgCopy) {
+	b := cb.batchFor(batchCopyBufToImg, param.Buf, param.Img)
+	b.bicpy = append(b.bicpy, convBufImgCopy(param))
 }
 
 // CopyImgToBuf copies data from an image to a buffer.
+// Consecutive copies between the same image and buffer are
+// recorded as a single copy command.
 func (cb *cmdBuffer) CopyImgToBuf(param *driver.BufImgCopy) {
+	b := cb.batchFor(batchCopyImgToBuf, param.Img, param.Buf)
+	b.bicpy = append(b.bicpy, convBufImgCopy(param))
+}
+
+// convBufImgCopy converts a driver.BufImgCopy to a
+// VkBufferImageCopy2KHR.
+func convBufImgCopy(param *driver.BufImgCopy) C.VkBufferImageCopy2KHR {
 	img := param.Img.(*image)
-	buf := param.Buf.(*buffer)
 	var aspect C.VkImageAspectFlags
 	if img.subres.aspectMask == C.VK_IMAGE_ASPECT_DEPTH_BIT|C.VK_IMAGE_ASPECT_STENCIL_BIT {
 		switch param.Plane {
@@ -699,7 +930,8 @@ func (cb *cmdBuffer) CopyImgToBuf(param *driver.BufImgCopy) {
 	width := max(1, param.Size.Width)
 	height := max(1, param.Size.Height)
 	depth := max(1, param.Size.Depth)
-	cpy := C.VkBufferImageCopy{
+	return C.VkBufferImageCopy2KHR{
+		sType:             C.VK_STRUCTURE_TYPE_BUFFER_IMAGE_COPY_2_KHR,
 		bufferOffset:      C.VkDeviceSize(param.BufOff),
 		bufferRowLength:   C.uint32_t(param.RowStrd),
 		bufferImageHeight: C.uint32_t(param.SlcStrd),
@@ -720,17 +952,22 @@ func (cb *cmdBuffer) CopyImgToBuf(param *driver.BufImgCopy) {
 			depth:  C.uint32_t(depth),
 		},
 	}
-	const layout = C.VK_IMAGE_LAYOUT_TRANSFER_SRC_OPTIMAL
-	C.vkCmdCopyImageToBuffer(cb.cb, img.img, layout, buf.buf, 1, &cpy)
 }
 
 // Fill fills a buffer range with copies of a byte value.
 func (cb *cmdBuffer) Fill(buf driver.Buffer, off int64, value byte, size int64) {
+	cb.flush()
 	val := C.uint32_t(value)
 	val |= val<<24 | val<<16 | val<<8
 	C.vkCmdFillBuffer(cb.cb, buf.(*buffer).buf, C.VkDeviceSize(off), C.VkDeviceSize(size), val)
 }
 
+// UpdateBuffer writes data to a buffer range.
+func (cb *cmdBuffer) UpdateBuffer(buf driver.Buffer, off int64, data []byte) {
+	cb.flush()
+	C.vkCmdUpdateBuffer(cb.cb, buf.(*buffer).buf, C.VkDeviceSize(off), C.VkDeviceSize(len(data)), unsafe.Pointer(unsafe.SliceData(data)))
+}
+
 // detachSC clears any existing dependencies between the
 // command buffer and swapchains.
 // cb.pres is set to contain no elements.
@@ -778,6 +1015,7 @@ func (cb *cmdBuffer) Destroy() {
 		// executing.
 		C.vkDestroyCommandPool(cb.d.dev, cb.pool, nil)
 	}
+	cb.scr.free()
 	*cb = cmdBuffer{}
 }
 
@@ -786,6 +1024,7 @@ func (cb *cmdBuffer) Destroy() {
 // It is only safe to reuse these data after the Commit
 // call returns.
 type commitInfo struct {
+	rend    []submit                         // Go memory.
 	subInfo []C.VkSubmitInfo2KHR             // Go memory.
 	cbInfo  []C.VkCommandBufferSubmitInfoKHR // C memory.
 	semInfo []C.VkSemaphoreSubmitInfoKHR     // C memory.
@@ -862,6 +1101,84 @@ func (ci *commitInfo) resizeSem(semInfoN int) {
 // call writes to the provided channel.
 type commitSync struct {
 	fence []C.VkFence
+
+	// Set by Commit for the worker that waits
+	// on the fences.
+	// If fenceN is zero, the worker waits for
+	// d.bgSem (or d.compSem, if compute is set)
+	// to reach timeline instead.
+	fenceN   int
+	timeline uint64
+	compute  bool
+	wk       []*driver.WorkItem
+	rend     []*cmdBuffer
+	ch       chan<- *driver.WorkItem
+}
+
+// submit identifies a command buffer to submit and
+// the semaphores that it must wait on/signal.
+type submit struct {
+	cb     *cmdBuffer
+	wait   []C.VkSemaphore
+	signal []C.VkSemaphore
+}
+
+// putCommitSync clears cs's references to committed
+// data and makes it available for reuse.
+func (d *Driver) putCommitSync(cs *commitSync) {
+	clear(cs.wk)
+	clear(cs.rend)
+	cs.wk = cs.wk[:0]
+	cs.rend = cs.rend[:0]
+	cs.ch = nil
+	d.csync <- cs
+}
+
+// commitWorker waits for the execution of committed
+// work to complete.
+// It runs until d.cwait is closed.
+// Work items are sent on the client's channel without
+// blocking the worker; those that do not fit are sent
+// by a separate goroutine. Otherwise, a channel that
+// is not drained would stall every other commit, as
+// well as Close.
+func (d *Driver) commitWorker() {
+	defer d.cwg.Done()
+	for cs := range d.cwait {
+		var err error
+		switch {
+		case cs.fenceN > 0:
+			err = d.checkLost("Commit", d.waitCommitFence(cs, cs.fenceN))
+		case cs.compute:
+			err = d.checkLost("Commit", d.waitTimeline(d.compSem, cs.timeline))
+		default:
+			err = d.checkLost("Commit", d.waitBackground(cs.timeline))
+		}
+		for _, cb := range cs.rend {
+			cb.status = cbIdle
+			cb.yieldSC()
+		}
+		for _, wk := range cs.wk {
+			wk.Err = err
+		}
+		for i, wk := range cs.wk {
+			select {
+			case cs.ch <- wk:
+				continue
+			default:
+			}
+			go sendWork(cs.ch, slices.Clone(cs.wk[i:]))
+			break
+		}
+		d.putCommitSync(cs)
+	}
+}
+
+// sendWork sends every element of wk on ch, in order.
+func sendWork(ch chan<- *driver.WorkItem, wk []*driver.WorkItem) {
+	for _, wk := range wk {
+		ch <- wk
+	}
 }
 
 // newCommitSync creates new commitSync data.
@@ -884,7 +1201,7 @@ func (d *Driver) resizeCommitFence(cs *commitSync, fenceN int) error {
 	info := C.VkFenceCreateInfo{sType: C.VK_STRUCTURE_TYPE_FENCE_CREATE_INFO}
 	var fence C.VkFence
 	for i := n; i < fenceN; i++ {
-		err := checkResult(C.vkCreateFence(d.dev, &info, nil, &fence))
+		err := d.checkLost("Commit", checkResult(C.vkCreateFence(d.dev, &info, nil, &fence)))
 		if err != nil {
 			return err
 		}
@@ -901,7 +1218,7 @@ func (d *Driver) waitCommitFence(cs *commitSync, fenceN int) error {
 	case C.VK_SUCCESS:
 		return nil
 	default:
-		switch err := checkResult(res); err {
+		switch err := d.checkLost("Commit", checkResult(res)); err {
 		case nil:
 			// Should never happen.
 			panic("unexpected result from fence waiting")
@@ -911,10 +1228,42 @@ func (d *Driver) waitCommitFence(cs *commitSync, fenceN int) error {
 	}
 }
 
+// waitBackground waits for d.bgSem to reach value.
+// On success, it updates d.bgDone.
+func (d *Driver) waitBackground(value uint64) error {
+	if err := d.waitTimeline(d.bgSem, value); err != nil {
+		return err
+	}
+	for {
+		done := d.bgDone.Load()
+		if done >= value || d.bgDone.CompareAndSwap(done, value) {
+			return nil
+		}
+	}
+}
+
+// waitTimeline waits for the timeline semaphore
+// timeline to reach value.
+func (d *Driver) waitTimeline(timeline C.VkSemaphore, value uint64) error {
+	sem := (*C.VkSemaphore)(C.malloc(C.sizeof_VkSemaphore))
+	val := (*C.uint64_t)(C.malloc(C.sizeof_uint64_t))
+	defer C.free(unsafe.Pointer(sem))
+	defer C.free(unsafe.Pointer(val))
+	*sem = timeline
+	*val = C.uint64_t(value)
+	info := C.VkSemaphoreWaitInfoKHR{
+		sType:          C.VK_STRUCTURE_TYPE_SEMAPHORE_WAIT_INFO_KHR,
+		semaphoreCount: 1,
+		pSemaphores:    sem,
+		pValues:        val,
+	}
+	return d.checkLost("Commit", checkResult(C.vkWaitSemaphoresKHR(d.dev, &info, C.UINT64_MAX)))
+}
+
 // resetCommitFence resets a number of cs.fence.
 // fenceN must be at least 1 and no greater than len(cs.fence).
 func (d *Driver) resetCommitFence(cs *commitSync, fenceN int) error {
-	return checkResult(C.vkResetFences(d.dev, C.uint32_t(fenceN), unsafe.SliceData(cs.fence)))
+	return d.checkLost("Commit", checkResult(C.vkResetFences(d.dev, C.uint32_t(fenceN), unsafe.SliceData(cs.fence))))
 }
 
 // destroyCommitSync destroys cs.
@@ -932,28 +1281,84 @@ func (d *Driver) Commit(wk *driver.WorkItem, ch chan<- *driver.WorkItem) error {
 		// Client error.
 		panic("invalid call to GPU.Commit")
 	}
+	d.checkFamily("GPU.Commit", []*driver.WorkItem{wk})
+	cs := <-d.csync
+	cs.wk = append(cs.wk, wk)
+	return d.commit(cs, ch)
+}
+
+// CommitBatch commits a number of work items to the GPU
+// for execution.
+func (d *Driver) CommitBatch(wk []*driver.WorkItem, ch chan<- *driver.WorkItem) error {
+	if len(wk) == 0 || ch == nil {
+		// Client error.
+		panic("invalid call to GPU.CommitBatch")
+	}
+	for _, wk := range wk {
+		if wk == nil || len(wk.Work) == 0 {
+			// Client error.
+			panic("invalid call to GPU.CommitBatch")
+		}
+	}
+	d.checkFamily("GPU.CommitBatch", wk)
+	cs := <-d.csync
+	cs.wk = append(cs.wk, wk...)
+	return d.commit(cs, ch)
+}
+
+// checkFamily panics if wk cannot be submitted to a
+// single queue: command buffers created by
+// NewComputeCmdBuffer can only be committed in
+// compute work items when d.cfam is not d.qfam.
+func (d *Driver) checkFamily(name string, wk []*driver.WorkItem) {
+	if d.cfam == d.qfam || d.isCompute(wk) {
+		return
+	}
+	for _, x := range wk {
+		for _, x := range x.Work {
+			if x.(*cmdBuffer).qfam != d.qfam {
+				// Client error.
+				panic("invalid call to " + name)
+			}
+		}
+	}
+}
+
+// commit implements Commit and CommitBatch.
+// cs.wk must contain the work items to commit.
+// Every command buffer of every work item is submitted
+// in a single vkQueueSubmit2KHR call (not counting the
+// queue ownership transfers for presentation), waited
+// on by a single set of fences.
+// In the common case (no presentation), this method
+// does not allocate.
+func (d *Driver) commit(cs *commitSync, ch chan<- *driver.WorkItem) error {
 	// Take commit data from the driver an return it when
 	// this call completes.
 	// If too many calls to Commit were issued, we will
 	// block here waiting that another call completes.
 	ci := <-d.cinfo
-	defer func() { d.cinfo <- ci }()
-	cs := <-d.csync
+	defer func() {
+		clear(ci.rend)
+		ci.rend = ci.rend[:0]
+		d.cinfo <- ci
+	}()
+	if d.isBackground(cs.wk) {
+		return d.commitBackground(cs, ci, ch)
+	}
+	if d.isCompute(cs.wk) {
+		return d.commitCompute(cs, ci, ch)
+	}
 	if err := d.resetCommitFence(cs, len(cs.fence)); err != nil {
-		d.csync <- cs
+		d.putCommitSync(cs)
 		return err
 	}
 	fenceN := 1
 
 	// Start by identifying what we will need to submit.
-	type submit struct {
-		cb     *cmdBuffer
-		wait   []C.VkSemaphore
-		signal []C.VkSemaphore
-	}
 	var (
 		// Rendering command buffers.
-		rend = make([]submit, len(wk.Work))
+		rend = ci.rend
 		// Presentation command buffers that
 		// release queue ownership.
 		presRel []submit
@@ -961,9 +1366,14 @@ func (d *Driver) Commit(wk *driver.WorkItem, ch chan<- *driver.WorkItem) error {
 		// acquire queue ownership.
 		presAcq []submit
 	)
-	for i := range wk.Work {
-		cb := wk.Work[i].(*cmdBuffer)
-		rend[i].cb = cb
+	for _, x := range cs.wk {
+		for _, x := range x.Work {
+			rend = append(rend, submit{cb: x.(*cmdBuffer)})
+		}
+	}
+	ci.rend = rend
+	for i := range rend {
+		cb := rend[i].cb
 		for i := range cb.pres {
 			var (
 				sc   = cb.pres[i].sc
@@ -1014,9 +1424,24 @@ func (d *Driver) Commit(wk *driver.WorkItem, ch chan<- *driver.WorkItem) error {
 		cbInfoN  = len(rend)
 		semInfoN int
 	)
+	// Every rendering submission waits on the last
+	// value of d.bgSem known to be signaled, so the
+	// writes of completed background work are visible
+	// to it. The wait itself is satisfied already.
+	bgDone := d.bgDone.Load()
+	bgWaitN := 0
+	if bgDone > 0 {
+		bgWaitN = 1
+	}
+	// Work items that set WaitCompute make every
+	// rendering submission wait on the last value
+	// of d.compSem. The last submission signals
+	// the next value of d.mainSem.
+	compVal, compWaitN, mainSigN := d.computeSync(cs.wk)
 	for i := range rend {
-		semInfoN += len(rend[i].wait) + len(rend[i].signal)
+		semInfoN += len(rend[i].wait) + bgWaitN + compWaitN + len(rend[i].signal)
 	}
+	semInfoN += mainSigN
 	if n := len(presRel); n > 0 {
 		if n > cbInfoN {
 			cbInfoN = n
@@ -1074,8 +1499,8 @@ func (d *Driver) Commit(wk *driver.WorkItem, ch chan<- *driver.WorkItem) error {
 				d.qmus[presQF].Lock()
 				res := C.vkQueueSubmit2KHR(d.ques[presQF], subN, &ci.subInfo[subInfo], null)
 				d.qmus[presQF].Unlock()
-				if err := checkResult(res); err != nil {
-					d.csync <- cs
+				if err := d.checkLost("Commit", checkResult(res)); err != nil {
+					d.putCommitSync(cs)
 					return err
 				}
 				if i < n-1 {
@@ -1094,14 +1519,21 @@ func (d *Driver) Commit(wk *driver.WorkItem, ch chan<- *driver.WorkItem) error {
 	var (
 		cbInfo  int
 		semInfo int
+		// Index in ci.semInfo of the signal
+		// operation on d.mainSem, whose value
+		// is only known during submission.
+		mainSig = -1
 	)
 	for i := range rend {
 		var (
-			waitInfoN = len(rend[i].wait)
+			waitInfoN = len(rend[i].wait) + bgWaitN + compWaitN
 			sigInfoN  = len(rend[i].signal)
 			waitInfo  = semInfo
 			sigInfo   = waitInfo + waitInfoN
 		)
+		if i == len(rend)-1 {
+			sigInfoN += mainSigN
+		}
 		ci.subInfo = append(ci.subInfo, C.VkSubmitInfo2KHR{
 			sType:                    C.VK_STRUCTURE_TYPE_SUBMIT_INFO_2_KHR,
 			waitSemaphoreInfoCount:   C.uint32_t(waitInfoN),
@@ -1124,6 +1556,24 @@ func (d *Driver) Commit(wk *driver.WorkItem, ch chan<- *driver.WorkItem) error {
 				}
 				waitInfo++
 			}
+			if bgWaitN > 0 {
+				ci.semInfo[waitInfo] = C.VkSemaphoreSubmitInfoKHR{
+					sType:     C.VK_STRUCTURE_TYPE_SEMAPHORE_SUBMIT_INFO_KHR,
+					semaphore: d.bgSem,
+					value:     C.uint64_t(bgDone),
+					stageMask: C.VK_PIPELINE_STAGE_2_ALL_COMMANDS_BIT_KHR,
+				}
+				waitInfo++
+			}
+			if compWaitN > 0 {
+				ci.semInfo[waitInfo] = C.VkSemaphoreSubmitInfoKHR{
+					sType:     C.VK_STRUCTURE_TYPE_SEMAPHORE_SUBMIT_INFO_KHR,
+					semaphore: d.compSem,
+					value:     C.uint64_t(compVal),
+					stageMask: C.VK_PIPELINE_STAGE_2_ALL_COMMANDS_BIT_KHR,
+				}
+				waitInfo++
+			}
 		}
 		if sigInfoN > 0 {
 			ci.subInfo[len(ci.subInfo)-1].pSignalSemaphoreInfos = &ci.semInfo[sigInfo]
@@ -1135,23 +1585,26 @@ func (d *Driver) Commit(wk *driver.WorkItem, ch chan<- *driver.WorkItem) error {
 				}
 				sigInfo++
 			}
+			if i == len(rend)-1 && mainSigN > 0 {
+				mainSig = sigInfo
+				ci.semInfo[sigInfo] = C.VkSemaphoreSubmitInfoKHR{
+					sType:     C.VK_STRUCTURE_TYPE_SEMAPHORE_SUBMIT_INFO_KHR,
+					semaphore: d.mainSem,
+					stageMask: C.VK_PIPELINE_STAGE_2_ALL_COMMANDS_BIT_KHR,
+				}
+				sigInfo++
+			}
 		}
 		semInfo = sigInfo
 	}
 	if n := len(presAcq); n == 0 {
-		d.qmus[d.qfam].Lock()
-		res := C.vkQueueSubmit2KHR(d.ques[d.qfam], C.uint32_t(len(rend)), unsafe.SliceData(ci.subInfo), cs.fence[0])
-		d.qmus[d.qfam].Unlock()
-		if err := checkResult(res); err != nil {
-			d.csync <- cs
+		if err := d.submitMain(cs, ci, len(rend), mainSig); err != nil {
+			d.putCommitSync(cs)
 			return err
 		}
 	} else {
-		d.qmus[d.qfam].Lock()
-		res := C.vkQueueSubmit2KHR(d.ques[d.qfam], C.uint32_t(len(rend)), unsafe.SliceData(ci.subInfo), cs.fence[0])
-		d.qmus[d.qfam].Unlock()
-		if err := checkResult(res); err != nil {
-			d.csync <- cs
+		if err := d.submitMain(cs, ci, len(rend), mainSig); err != nil {
+			d.putCommitSync(cs)
 			return err
 		}
 		// Presentation queue's command buffers that acquire
@@ -1188,16 +1641,16 @@ func (d *Driver) Commit(wk *driver.WorkItem, ch chan<- *driver.WorkItem) error {
 			if i == n-1 || presQF != presAcq[i+1].cb.qfam {
 				if err := d.resizeCommitFence(cs, fenceN+1); err != nil {
 					d.waitCommitFence(cs, fenceN)
-					d.csync <- cs
+					d.putCommitSync(cs)
 					return err
 				}
 				subN := C.uint32_t(1 + i - subInfo)
 				d.qmus[presQF].Lock()
-				res = C.vkQueueSubmit2KHR(d.ques[presQF], subN, &ci.subInfo[subInfo], cs.fence[fenceN])
+				res := C.vkQueueSubmit2KHR(d.ques[presQF], subN, &ci.subInfo[subInfo], cs.fence[fenceN])
 				d.qmus[presQF].Unlock()
-				if err := checkResult(res); err != nil {
+				if err := d.checkLost("Commit", checkResult(res)); err != nil {
 					d.waitCommitFence(cs, fenceN)
-					d.csync <- cs
+					d.putCommitSync(cs)
 					return err
 				}
 				fenceN++
@@ -1213,26 +1666,265 @@ func (d *Driver) Commit(wk *driver.WorkItem, ch chan<- *driver.WorkItem) error {
 
 	// Change the status to cbCommitted and return
 	// to the caller.
-	// We launch a new goroutine to wait on the
-	// fence(s) as it may take an arbitrary amount
-	// of time for execution to complete.
+	// A worker goroutine waits on the fence(s) as
+	// it may take an arbitrary amount of time for
+	// execution to complete.
 	// Note that cbStatus will be set (to cbIdle)
-	// by the new goroutine, and thus will race
-	// with any other accesses that happen before
-	// ch receives wk.
+	// by the worker, and thus will race with any
+	// other accesses that happen before ch
+	// receives wk.
 	for i := range rend {
 		rend[i].cb.status = cbCommitted
 		rend[i].cb.unpendSC()
+		cs.rend = append(cs.rend, rend[i].cb)
 	}
-	go func() {
-		wk.Err = d.waitCommitFence(cs, fenceN)
-		for i := range rend {
-			rend[i].cb.status = cbIdle
-			rend[i].cb.yieldSC()
+	cs.fenceN = fenceN
+	cs.ch = ch
+	d.cwait <- cs
+	return nil
+}
+
+// submitMain submits the first subN elements of
+// ci.subInfo to d.ques[d.qfam], signaling cs.fence[0].
+// If mainSig is not negative, ci.semInfo[mainSig] is
+// set to signal the next value of d.mainSem.
+func (d *Driver) submitMain(cs *commitSync, ci *commitInfo, subN, mainSig int) error {
+	d.qmus[d.qfam].Lock()
+	defer d.qmus[d.qfam].Unlock()
+	if mainSig >= 0 {
+		ci.semInfo[mainSig].value = C.uint64_t(d.mainVal + 1)
+	}
+	res := C.vkQueueSubmit2KHR(d.ques[d.qfam], C.uint32_t(subN), unsafe.SliceData(ci.subInfo), cs.fence[0])
+	if err := d.checkLost("Commit", checkResult(res)); err != nil {
+		return err
+	}
+	if mainSig >= 0 {
+		d.mainVal++
+	}
+	return nil
+}
+
+// computeSync returns the synchronization with async
+// compute work that a rendering submission of wk
+// requires: the value of d.compSem to wait on, the
+// number of wait operations on d.compSem (zero or
+// one) and the number of signal operations on
+// d.mainSem (zero or one).
+func (d *Driver) computeSync(wk []*driver.WorkItem) (compVal uint64, compWaitN, mainSigN int) {
+	if d.compQue == nil {
+		return
+	}
+	mainSigN = 1
+	for _, x := range wk {
+		if x.WaitCompute && !x.Compute && !x.Background {
+			d.compMu.Lock()
+			compVal = d.compVal
+			d.compMu.Unlock()
+			if compVal > 0 {
+				compWaitN = 1
+			}
+			break
 		}
-		ch <- wk
-		d.csync <- cs
-	}()
+	}
+	return
+}
+
+// isBackground returns whether wk can be submitted to
+// d.bgQue.
+// Every work item must be marked as background, and no
+// command buffer can have presentation dependencies.
+func (d *Driver) isBackground(wk []*driver.WorkItem) bool {
+	if d.bgQue == nil {
+		return false
+	}
+	for _, x := range wk {
+		if !x.Background {
+			return false
+		}
+		for _, x := range x.Work {
+			if len(x.(*cmdBuffer).pres) != 0 {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// commitBackground is the commit path for background
+// work.
+// Every command buffer of every work item is submitted
+// to d.bgQue in a single vkQueueSubmit2KHR call that
+// signals the next value of d.bgSem. No fence is used.
+func (d *Driver) commitBackground(cs *commitSync, ci *commitInfo, ch chan<- *driver.WorkItem) error {
+	var n int
+	for _, x := range cs.wk {
+		n += len(x.Work)
+	}
+	ci.resizeCB(n)
+	ci.resizeSem(1)
+	var i int
+	for _, x := range cs.wk {
+		for _, x := range x.Work {
+			cb := x.(*cmdBuffer)
+			ci.cbInfo[i] = C.VkCommandBufferSubmitInfoKHR{
+				sType:         C.VK_STRUCTURE_TYPE_COMMAND_BUFFER_SUBMIT_INFO_KHR,
+				commandBuffer: cb.cb,
+			}
+			ci.rend = append(ci.rend, submit{cb: cb})
+			i++
+		}
+	}
+	ci.subInfo = append(ci.subInfo[:0], C.VkSubmitInfo2KHR{
+		sType:                    C.VK_STRUCTURE_TYPE_SUBMIT_INFO_2_KHR,
+		commandBufferInfoCount:   C.uint32_t(n),
+		pCommandBufferInfos:      &ci.cbInfo[0],
+		signalSemaphoreInfoCount: 1,
+		pSignalSemaphoreInfos:    &ci.semInfo[0],
+	})
+	var null C.VkFence
+	d.bgMu.Lock()
+	ci.semInfo[0] = C.VkSemaphoreSubmitInfoKHR{
+		sType:     C.VK_STRUCTURE_TYPE_SEMAPHORE_SUBMIT_INFO_KHR,
+		semaphore: d.bgSem,
+		value:     C.uint64_t(d.bgVal + 1),
+		stageMask: C.VK_PIPELINE_STAGE_2_ALL_COMMANDS_BIT_KHR,
+	}
+	res := C.vkQueueSubmit2KHR(d.bgQue, 1, unsafe.SliceData(ci.subInfo), null)
+	if err := d.checkLost("Commit", checkResult(res)); err != nil {
+		d.bgMu.Unlock()
+		d.putCommitSync(cs)
+		return err
+	}
+	d.bgVal++
+	cs.timeline = d.bgVal
+	d.bgMu.Unlock()
+
+	for _, x := range ci.rend {
+		x.cb.status = cbCommitted
+		cs.rend = append(cs.rend, x.cb)
+	}
+	cs.fenceN = 0
+	cs.compute = false
+	cs.ch = ch
+	d.cwait <- cs
+	return nil
+}
+
+// isCompute returns whether wk can be submitted to
+// d.compQue.
+// Every work item must be marked as compute, and no
+// command buffer can have presentation dependencies.
+// If d.compQue is not a queue of d.qfam, every command
+// buffer must have been created for d.cfam.
+func (d *Driver) isCompute(wk []*driver.WorkItem) bool {
+	if d.compQue == nil {
+		return false
+	}
+	for _, x := range wk {
+		if !x.Compute {
+			return false
+		}
+		for _, x := range x.Work {
+			cb := x.(*cmdBuffer)
+			if len(cb.pres) != 0 || cb.qfam != d.cfam {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// commitCompute is the commit path for async compute
+// work.
+// Every command buffer of every work item is submitted
+// to d.compQue in a single vkQueueSubmit2KHR call that
+// waits on the last value of d.mainSem and signals the
+// next value of d.compSem. No fence is used.
+func (d *Driver) commitCompute(cs *commitSync, ci *commitInfo, ch chan<- *driver.WorkItem) error {
+	var n int
+	for _, x := range cs.wk {
+		n += len(x.Work)
+	}
+	ci.resizeCB(n)
+	ci.resizeSem(3)
+	var i int
+	for _, x := range cs.wk {
+		for _, x := range x.Work {
+			cb := x.(*cmdBuffer)
+			ci.cbInfo[i] = C.VkCommandBufferSubmitInfoKHR{
+				sType:         C.VK_STRUCTURE_TYPE_COMMAND_BUFFER_SUBMIT_INFO_KHR,
+				commandBuffer: cb.cb,
+			}
+			ci.rend = append(ci.rend, submit{cb: cb})
+			i++
+		}
+	}
+	// Compute work must not start before work that
+	// was committed to the main queue before it.
+	// It also waits on the last value of d.bgSem
+	// known to be signaled, as rendering does.
+	d.qmus[d.qfam].Lock()
+	mainVal := d.mainVal
+	d.qmus[d.qfam].Unlock()
+	waitN := 0
+	for _, x := range [2]struct {
+		sem C.VkSemaphore
+		val uint64
+	}{{d.mainSem, mainVal}, {d.bgSem, d.bgDone.Load()}} {
+		if x.val == 0 {
+			continue
+		}
+		ci.semInfo[waitN] = C.VkSemaphoreSubmitInfoKHR{
+			sType:     C.VK_STRUCTURE_TYPE_SEMAPHORE_SUBMIT_INFO_KHR,
+			semaphore: x.sem,
+			value:     C.uint64_t(x.val),
+			stageMask: C.VK_PIPELINE_STAGE_2_ALL_COMMANDS_BIT_KHR,
+		}
+		waitN++
+	}
+	ci.subInfo = append(ci.subInfo[:0], C.VkSubmitInfo2KHR{
+		sType:                    C.VK_STRUCTURE_TYPE_SUBMIT_INFO_2_KHR,
+		waitSemaphoreInfoCount:   C.uint32_t(waitN),
+		pWaitSemaphoreInfos:      &ci.semInfo[0],
+		commandBufferInfoCount:   C.uint32_t(n),
+		pCommandBufferInfos:      &ci.cbInfo[0],
+		signalSemaphoreInfoCount: 1,
+		pSignalSemaphoreInfos:    &ci.semInfo[waitN],
+	})
+	var null C.VkFence
+	d.compMu.Lock()
+	ci.semInfo[waitN] = C.VkSemaphoreSubmitInfoKHR{
+		sType:     C.VK_STRUCTURE_TYPE_SEMAPHORE_SUBMIT_INFO_KHR,
+		semaphore: d.compSem,
+		value:     C.uint64_t(d.compVal + 1),
+		stageMask: C.VK_PIPELINE_STAGE_2_ALL_COMMANDS_BIT_KHR,
+	}
+	// The queue of a compute-only family may be
+	// used for presentation as well.
+	if d.cfam != d.qfam {
+		d.qmus[d.cfam].Lock()
+	}
+	res := C.vkQueueSubmit2KHR(d.compQue, 1, unsafe.SliceData(ci.subInfo), null)
+	if d.cfam != d.qfam {
+		d.qmus[d.cfam].Unlock()
+	}
+	if err := d.checkLost("Commit", checkResult(res)); err != nil {
+		d.compMu.Unlock()
+		d.putCommitSync(cs)
+		return err
+	}
+	d.compVal++
+	cs.timeline = d.compVal
+	d.compMu.Unlock()
+
+	for _, x := range ci.rend {
+		x.cb.status = cbCommitted
+		cs.rend = append(cs.rend, x.cb)
+	}
+	cs.fenceN = 0
+	cs.compute = true
+	cs.ch = ch
+	d.cwait <- cs
 	return nil
 }
 
@@ -0,0 +1,437 @@
+// Copyright 2024 Gustavo C. Viegas. All rights reserved.
+
+package vk
+
+import (
+	"testing"
+
+	"gviegas/neo3/driver"
+)
+
+// cmdsPerBegin is the number of commands that the
+// recording benchmarks record before resetting the
+// command buffer.
+const cmdsPerBegin = 4096
+
+// benchRecord calls rec b.N times, beginning and
+// resetting the command buffer as needed.
+func benchRecord(b *testing.B, rec func(driver.CmdBuffer)) {
+	cb, err := tDrv.NewCmdBuffer()
+	if err != nil {
+		b.Fatalf("Driver.NewCmdBuffer failed: %v", err)
+	}
+	defer cb.Destroy()
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if i%cmdsPerBegin == 0 {
+			cb.Reset()
+			if err := cb.Begin(); err != nil {
+				b.Fatalf("CmdBuffer.Begin failed: %v", err)
+			}
+		}
+		rec(cb)
+	}
+	b.StopTimer()
+	cb.Reset()
+}
+
+// benchTarget creates a 2D render target and a view
+// of it.
+func benchTarget(b *testing.B) (driver.Image, driver.ImageView) {
+	img, err := tDrv.NewImage(driver.RGBA8Unorm, driver.Dim3D{Width: 256, Height: 256}, 1, 1, 1, driver.URenderTarget)
+	if err != nil {
+		b.Fatalf("Driver.NewImage failed: %v", err)
+	}
+	view, err := img.NewView(driver.IView2D, 0, 1, 0, 1)
+	if err != nil {
+		img.Destroy()
+		b.Fatalf("Image.NewView failed: %v", err)
+	}
+	return img, view
+}
+
+func BenchmarkBarrier(b *testing.B) {
+	bar := []driver.Barrier{{
+		SyncBefore:   driver.SCopy,
+		SyncAfter:    driver.SVertexShading,
+		AccessBefore: driver.ACopyWrite,
+		AccessAfter:  driver.AShaderRead,
+	}}
+	benchRecord(b, func(cb driver.CmdBuffer) { cb.Barrier(bar) })
+}
+
+func BenchmarkTransition(b *testing.B) {
+	img, view := benchTarget(b)
+	defer img.Destroy()
+	defer view.Destroy()
+	tr := []driver.Transition{{
+		Barrier: driver.Barrier{
+			SyncBefore:   driver.SColorOutput,
+			SyncAfter:    driver.SColorOutput,
+			AccessBefore: driver.AColorWrite,
+			AccessAfter:  driver.AColorWrite,
+		},
+		LayoutBefore: driver.LUndefined,
+		LayoutAfter:  driver.LColorTarget,
+		Img:          img,
+		Layers:       1,
+		Levels:       1,
+	}}
+	benchRecord(b, func(cb driver.CmdBuffer) { cb.Transition(tr) })
+}
+
+func BenchmarkCopyBuffer(b *testing.B) {
+	var buf [2]driver.Buffer
+	for i := range buf {
+		var err error
+		buf[i], err = tDrv.NewBuffer(1<<20, false, driver.UCopySrc|driver.UCopyDst)
+		if err != nil {
+			b.Fatalf("Driver.NewBuffer failed: %v", err)
+		}
+		defer buf[i].Destroy()
+	}
+	var n int64
+	benchRecord(b, func(cb driver.CmdBuffer) {
+		cb.CopyBuffer(&driver.BufferCopy{
+			From:    buf[0],
+			FromOff: n,
+			To:      buf[1],
+			ToOff:   n,
+			Size:    256,
+		})
+		n = (n + 256) % (1 << 20)
+	})
+}
+
+func BenchmarkBeginPass(b *testing.B) {
+	img, view := benchTarget(b)
+	defer img.Destroy()
+	defer view.Destroy()
+	color := []driver.ColorTarget{{
+		Color: view,
+		Load:  driver.LDontCare,
+		Store: driver.SStore,
+	}}
+	benchRecord(b, func(cb driver.CmdBuffer) {
+		cb.BeginPass(256, 256, 1, color, nil)
+		cb.EndPass()
+	})
+}
+
+func BenchmarkCommit(b *testing.B) {
+	cb, err := tDrv.NewCmdBuffer()
+	if err != nil {
+		b.Fatalf("Driver.NewCmdBuffer failed: %v", err)
+	}
+	defer cb.Destroy()
+	wk := &driver.WorkItem{Work: []driver.CmdBuffer{cb}}
+	ch := make(chan *driver.WorkItem, 1)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if err := cb.Begin(); err != nil {
+			b.Fatalf("CmdBuffer.Begin failed: %v", err)
+		}
+		if err := cb.End(); err != nil {
+			b.Fatalf("CmdBuffer.End failed: %v", err)
+		}
+		if err := tDrv.Commit(wk, ch); err != nil {
+			b.Fatalf("Driver.Commit failed: %v", err)
+		}
+		if err := (<-ch).Err; err != nil {
+			b.Fatalf("Driver.Commit: execution failed: %v", err)
+		}
+	}
+}
+
+func TestCmdBatch(t *testing.T) {
+	c, err := tDrv.NewCmdBuffer()
+	if err != nil {
+		t.Fatalf("Driver.NewCmdBuffer failed: %v", err)
+	}
+	defer c.Destroy()
+	cb := c.(*cmdBuffer)
+	var buf [3]driver.Buffer
+	for i := range buf {
+		buf[i], err = tDrv.NewBuffer(4096, false, driver.UCopySrc|driver.UCopyDst)
+		if err != nil {
+			t.Fatalf("Driver.NewBuffer failed: %v", err)
+		}
+		defer buf[i].Destroy()
+	}
+	var img [2]driver.Image
+	for i := range img {
+		img[i], err = tDrv.NewImage(driver.RGBA8Unorm, driver.Dim3D{Width: 64, Height: 64}, 1, 1, 1, driver.UCopyDst|driver.UShaderSample)
+		if err != nil {
+			t.Fatalf("Driver.NewImage failed: %v", err)
+		}
+		defer img[i].Destroy()
+	}
+	if err := cb.Begin(); err != nil {
+		t.Fatalf("CmdBuffer.Begin failed: %v", err)
+	}
+
+	// Copies between the same buffers are merged.
+	for i := range 4 {
+		cb.CopyBuffer(&driver.BufferCopy{From: buf[0], FromOff: int64(i) * 256, To: buf[1], ToOff: int64(i) * 256, Size: 256})
+	}
+	if cb.bat.kind != batchCopyBuffer || len(cb.bat.bcpy) != 4 {
+		t.Fatalf("cmdBuffer.CopyBuffer: batch\nhave %v, %d regions\nwant %v, 4 regions", cb.bat.kind, len(cb.bat.bcpy), batchCopyBuffer)
+	}
+	// A different destination starts a new batch.
+	cb.CopyBuffer(&driver.BufferCopy{From: buf[0], To: buf[2], Size: 256})
+	if len(cb.bat.bcpy) != 1 || cb.bat.to != buf[2] {
+		t.Fatalf("cmdBuffer.CopyBuffer: batch after flush\nhave %d regions\nwant 1 region", len(cb.bat.bcpy))
+	}
+
+	// Transitions of distinct images are merged.
+	tr := func(img driver.Image, before, after driver.Layout) []driver.Transition {
+		return []driver.Transition{{
+			Barrier: driver.Barrier{
+				SyncBefore:  driver.SNone,
+				SyncAfter:   driver.SCopy,
+				AccessAfter: driver.ACopyWrite,
+			},
+			LayoutBefore: before,
+			LayoutAfter:  after,
+			Img:          img,
+			Layers:       1,
+			Levels:       1,
+		}}
+	}
+	cb.Transition(tr(img[0], driver.LUndefined, driver.LCopyDst))
+	cb.Transition(tr(img[1], driver.LUndefined, driver.LCopyDst))
+	if cb.bat.kind != batchTransition || len(cb.bat.ibar) != 2 {
+		t.Fatalf("cmdBuffer.Transition: batch\nhave %v, %d barriers\nwant %v, 2 barriers", cb.bat.kind, len(cb.bat.ibar), batchTransition)
+	}
+	// Transitioning the same image again does not.
+	cb.Transition(tr(img[0], driver.LCopyDst, driver.LShaderRead))
+	if len(cb.bat.ibar) != 1 {
+		t.Fatalf("cmdBuffer.Transition: batch with repeated image\nhave %d barriers\nwant 1 barrier", len(cb.bat.ibar))
+	}
+
+	cb.Fill(buf[0], 0, 0xff, 256)
+	if cb.bat.kind != batchNone {
+		t.Fatal("cmdBuffer.Fill: batch should have been flushed")
+	}
+	cb.CopyBuffer(&driver.BufferCopy{From: buf[1], To: buf[0], Size: 256})
+	if err := cb.End(); err != nil {
+		t.Fatalf("CmdBuffer.End failed: %v", err)
+	}
+	if cb.bat.kind != batchNone {
+		t.Fatal("cmdBuffer.End: batch should have been flushed")
+	}
+	cb.Reset()
+}
+
+func TestCommitBackground(t *testing.T) {
+	c, err := tDrv.NewCmdBuffer()
+	if err != nil {
+		t.Fatalf("Driver.NewCmdBuffer failed: %v", err)
+	}
+	defer c.Destroy()
+	var buf [2]driver.Buffer
+	for i := range buf {
+		buf[i], err = tDrv.NewBuffer(256, true, driver.UCopySrc|driver.UCopyDst)
+		if err != nil {
+			t.Fatalf("Driver.NewBuffer failed: %v", err)
+		}
+		defer buf[i].Destroy()
+	}
+	for i := range buf[0].Bytes() {
+		buf[0].Bytes()[i] = byte(i)
+	}
+	wk := &driver.WorkItem{Work: []driver.CmdBuffer{c}, Background: true}
+	ch := make(chan *driver.WorkItem, 1)
+	var timeline uint64
+	for i := range 3 {
+		if err := c.Begin(); err != nil {
+			t.Fatalf("CmdBuffer.Begin failed: %v", err)
+		}
+		c.CopyBuffer(&driver.BufferCopy{From: buf[0], To: buf[1], Size: 256})
+		if err := c.End(); err != nil {
+			t.Fatalf("CmdBuffer.End failed: %v", err)
+		}
+		if err := tDrv.Commit(wk, ch); err != nil {
+			t.Fatalf("Driver.Commit failed: %v", err)
+		}
+		if x := <-ch; x != wk || x.Err != nil {
+			t.Fatalf("Driver.Commit: work item\nhave %p, %v\nwant %p, <nil>", x, x.Err, wk)
+		}
+		if tDrv.bgQue == nil {
+			continue
+		}
+		if x := tDrv.bgDone.Load(); x <= timeline {
+			t.Fatalf("Driver.Commit: background timeline (%d)\nhave %d\nwant > %d", i, x, timeline)
+		} else {
+			timeline = x
+		}
+	}
+	for i, x := range buf[1].Bytes() {
+		if x != byte(i) {
+			t.Fatalf("Driver.Commit: buf[1].Bytes()[%d]\nhave %d\nwant %d", i, x, byte(i))
+		}
+	}
+}
+
+func TestCommitUndrained(t *testing.T) {
+	var wk [2]*driver.WorkItem
+	for i := range wk {
+		c, err := tDrv.NewCmdBuffer()
+		if err != nil {
+			t.Fatalf("Driver.NewCmdBuffer failed: %v", err)
+		}
+		defer c.Destroy()
+		if err := c.Begin(); err != nil {
+			t.Fatalf("CmdBuffer.Begin failed: %v", err)
+		}
+		if err := c.End(); err != nil {
+			t.Fatalf("CmdBuffer.End failed: %v", err)
+		}
+		wk[i] = &driver.WorkItem{Work: []driver.CmdBuffer{c}}
+	}
+	// Nothing receives from undrained until the
+	// second commit completes, which must not
+	// stall the commit worker.
+	undrained := make(chan *driver.WorkItem)
+	ch := make(chan *driver.WorkItem, 1)
+	if err := tDrv.Commit(wk[0], undrained); err != nil {
+		t.Fatalf("Driver.Commit failed: %v", err)
+	}
+	if err := tDrv.Commit(wk[1], ch); err != nil {
+		t.Fatalf("Driver.Commit failed: %v", err)
+	}
+	if x := <-ch; x != wk[1] || x.Err != nil {
+		t.Fatalf("Driver.Commit: work item\nhave %p, %v\nwant %p, <nil>", x, x.Err, wk[1])
+	}
+	if x := <-undrained; x != wk[0] || x.Err != nil {
+		t.Fatalf("Driver.Commit: work item\nhave %p, %v\nwant %p, <nil>", x, x.Err, wk[0])
+	}
+}
+
+func TestCommitCompute(t *testing.T) {
+	if x := tDrv.Features().AsyncCompute; x != (tDrv.compQue != nil) {
+		t.Fatalf("Driver.Features: AsyncCompute\nhave %t\nwant %t", x, !x)
+	}
+	if x := tDrv.shareFams != nil; x != (tDrv.cfam != tDrv.qfam) {
+		t.Fatalf("Driver.shareFams: set\nhave %t\nwant %t", x, !x)
+	}
+	var c [2]driver.CmdBuffer
+	for i := range c {
+		var err error
+		if i == 0 {
+			c[i], err = tDrv.NewComputeCmdBuffer()
+		} else {
+			c[i], err = tDrv.NewCmdBuffer()
+		}
+		if err != nil {
+			t.Fatalf("Driver.New[Compute]CmdBuffer failed: %v", err)
+		}
+		defer c[i].Destroy()
+	}
+	if x := c[0].(*cmdBuffer).qfam; x != tDrv.cfam {
+		t.Fatalf("Driver.NewComputeCmdBuffer: qfam\nhave %d\nwant %d", x, tDrv.cfam)
+	}
+	var buf [3]driver.Buffer
+	for i := range buf {
+		var err error
+		buf[i], err = tDrv.NewBuffer(256, true, driver.UCopySrc|driver.UCopyDst)
+		if err != nil {
+			t.Fatalf("Driver.NewBuffer failed: %v", err)
+		}
+		defer buf[i].Destroy()
+	}
+	for i := range buf[0].Bytes() {
+		buf[0].Bytes()[i] = byte(i)
+	}
+	// The second work item copies what the
+	// first one writes, and is committed
+	// before the first one completes.
+	wk := [2]*driver.WorkItem{
+		{Work: []driver.CmdBuffer{c[0]}, Compute: true},
+		{Work: []driver.CmdBuffer{c[1]}, WaitCompute: true},
+	}
+	ch := make(chan *driver.WorkItem, 2)
+	for i := range 3 {
+		for j, x := range c {
+			if err := x.Begin(); err != nil {
+				t.Fatalf("CmdBuffer.Begin failed: %v", err)
+			}
+			if j > 0 {
+				x.Barrier([]driver.Barrier{{
+					SyncBefore:   driver.SCopy,
+					SyncAfter:    driver.SCopy,
+					AccessBefore: driver.ACopyWrite,
+					AccessAfter:  driver.ACopyRead,
+				}})
+			}
+			x.CopyBuffer(&driver.BufferCopy{From: buf[j], To: buf[j+1], Size: 256})
+			if err := x.End(); err != nil {
+				t.Fatalf("CmdBuffer.End failed: %v", err)
+			}
+		}
+		for _, x := range wk {
+			if err := tDrv.Commit(x, ch); err != nil {
+				t.Fatalf("Driver.Commit failed: %v", err)
+			}
+		}
+		for range wk {
+			if x := <-ch; x.Err != nil {
+				t.Fatalf("Driver.Commit: work item (%d)\nhave %v\nwant <nil>", i, x.Err)
+			}
+		}
+		if tDrv.compQue == nil {
+			continue
+		}
+		tDrv.compMu.Lock()
+		compVal := tDrv.compVal
+		tDrv.compMu.Unlock()
+		if compVal != uint64(i+1) {
+			t.Fatalf("Driver.Commit: compute timeline\nhave %d\nwant %d", compVal, i+1)
+		}
+	}
+	for i, x := range buf[2].Bytes() {
+		if x != byte(i) {
+			t.Fatalf("Driver.Commit: buf[2].Bytes()[%d]\nhave %d\nwant %d", i, x, byte(i))
+		}
+	}
+}
+
+func TestUpdateBuffer(t *testing.T) {
+	cb, err := tDrv.NewCmdBuffer()
+	if err != nil {
+		t.Fatalf("Driver.NewCmdBuffer failed: %v", err)
+	}
+	defer cb.Destroy()
+	buf, err := tDrv.NewBuffer(1024, true, driver.UCopyDst)
+	if err != nil {
+		t.Fatalf("Driver.NewBuffer failed: %v", err)
+	}
+	defer buf.Destroy()
+	data := make([]byte, 256)
+	for i := range data {
+		data[i] = byte(i)
+	}
+	if err := cb.Begin(); err != nil {
+		t.Fatalf("CmdBuffer.Begin failed: %v", err)
+	}
+	cb.UpdateBuffer(buf, 512, data)
+	// The data must have been copied already.
+	clear(data)
+	if err := cb.End(); err != nil {
+		t.Fatalf("CmdBuffer.End failed: %v", err)
+	}
+	ch := make(chan *driver.WorkItem, 1)
+	if err := tDrv.Commit(&driver.WorkItem{Work: []driver.CmdBuffer{cb}}, ch); err != nil {
+		t.Fatalf("Driver.Commit failed: %v", err)
+	}
+	if wk := <-ch; wk.Err != nil {
+		t.Fatalf("Driver.Commit: WorkItem.Err\nhave %v\nwant <nil>", wk.Err)
+	}
+	for i, x := range buf.Bytes()[512 : 512+256] {
+		if x != byte(i) {
+			t.Fatalf("CmdBuffer.UpdateBuffer: buf.Bytes()[%d]\nhave %d\nwant %d", 512+i, x, byte(i))
+		}
+	}
+}
@@ -44,6 +44,7 @@ func TestPixelFmt(t *testing.T) {
 		driver.R32Float,
 		driver.R32Uint,
 		driver.R32Int,
+		driver.RGB10A2Unorm,
 		driver.D16Unorm,
 		driver.D32Float,
 		driver.S8Uint,
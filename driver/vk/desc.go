@@ -7,7 +7,6 @@ package vk
 import "C"
 
 import (
-	"errors"
 	"unsafe"
 
 	"gviegas/neo3/driver"
@@ -29,14 +28,31 @@ type descHeap struct {
 	nconst int
 	ntex   int
 	nsplr  int
+	nutb   int
+	nstb   int
+
+	// Updates deferred by BeginUpdate.
+	// The info arrays referred by writes are
+	// kept in wfree until the updates are
+	// submitted.
+	batch  bool
+	writes []C.VkWriteDescriptorSet
+	wfree  []unsafe.Pointer
+	copies []C.VkCopyDescriptorSet
 }
 
 // NewDescHeap creates a new descriptor heap.
 func (d *Driver) NewDescHeap(ds []driver.Descriptor) (driver.DescHeap, error) {
-	var nbuf, nimg, nconst, ntex, nsplr int
+	var nbuf, nimg, nconst, ntex, nsplr, nutb, nstb int
 	p := (*C.VkDescriptorSetLayoutBinding)(C.malloc(C.size_t(len(ds)) * C.sizeof_VkDescriptorSetLayoutBinding))
 	defer C.free(unsafe.Pointer(p))
 	binds := unsafe.Slice(p, len(ds))
+	var splrs []unsafe.Pointer
+	defer func() {
+		for _, x := range splrs {
+			C.free(x)
+		}
+	}()
 
 	for i := range ds {
 		switch ds[i].Type {
@@ -55,19 +71,37 @@ func (d *Driver) NewDescHeap(ds []driver.Descriptor) (driver.DescHeap, error) {
 		case driver.DSampler:
 			nsplr += ds[i].Len
 			binds[i].descriptorType = C.VK_DESCRIPTOR_TYPE_SAMPLER
+		case driver.DUniformTexelBuffer:
+			nutb += ds[i].Len
+			binds[i].descriptorType = C.VK_DESCRIPTOR_TYPE_UNIFORM_TEXEL_BUFFER
+		case driver.DStorageTexelBuffer:
+			nstb += ds[i].Len
+			binds[i].descriptorType = C.VK_DESCRIPTOR_TYPE_STORAGE_TEXEL_BUFFER
 		}
 		// Descriptor.Nr is the binding number in Vulkan, which must be
 		// unique within a descriptor set.
 		for j := i + 1; j < len(ds); j++ {
 			if ds[i].Nr == ds[j].Nr {
 				// TODO: Consider panicking instead.
-				return nil, errors.New("vk: descriptor number is not unique")
+				return nil, driver.NewError("vk: descriptor number is not unique", driver.ErrInvalidParam)
 			}
 		}
 		binds[i].binding = C.uint32_t(ds[i].Nr)
 		binds[i].descriptorCount = C.uint32_t(ds[i].Len)
 		binds[i].stageFlags = convStage(ds[i].Stages)
 		binds[i].pImmutableSamplers = nil
+		if n := len(ds[i].Immutable); n > 0 {
+			if ds[i].Type != driver.DSampler || n != ds[i].Len {
+				return nil, driver.NewError("vk: invalid immutable samplers", driver.ErrInvalidParam)
+			}
+			sp := (*C.VkSampler)(C.malloc(C.size_t(n) * C.sizeof_VkSampler))
+			splrs = append(splrs, unsafe.Pointer(sp))
+			is := unsafe.Slice(sp, n)
+			for j := range is {
+				is[j] = ds[i].Immutable[j].(*sampler).splr
+			}
+			binds[i].pImmutableSamplers = sp
+		}
 	}
 
 	info := C.VkDescriptorSetLayoutCreateInfo{
@@ -75,8 +109,24 @@ func (d *Driver) NewDescHeap(ds []driver.Descriptor) (driver.DescHeap, error) {
 		bindingCount: C.uint32_t(len(binds)),
 		pBindings:    p,
 	}
+	if d.feat.PartiallyBound && len(ds) > 0 {
+		fp := (*C.VkDescriptorBindingFlagsEXT)(C.malloc(C.size_t(len(ds)) * C.sizeof_VkDescriptorBindingFlagsEXT))
+		defer C.free(unsafe.Pointer(fp))
+		bf := unsafe.Slice(fp, len(ds))
+		for i := range bf {
+			bf[i] = C.VK_DESCRIPTOR_BINDING_PARTIALLY_BOUND_BIT_EXT
+		}
+		flags := (*C.VkDescriptorSetLayoutBindingFlagsCreateInfoEXT)(C.malloc(C.sizeof_VkDescriptorSetLayoutBindingFlagsCreateInfoEXT))
+		defer C.free(unsafe.Pointer(flags))
+		*flags = C.VkDescriptorSetLayoutBindingFlagsCreateInfoEXT{
+			sType:         C.VK_STRUCTURE_TYPE_DESCRIPTOR_SET_LAYOUT_BINDING_FLAGS_CREATE_INFO_EXT,
+			bindingCount:  C.uint32_t(len(ds)),
+			pBindingFlags: fp,
+		}
+		info.pNext = unsafe.Pointer(flags)
+	}
 	var layout C.VkDescriptorSetLayout
-	err := checkResult(C.vkCreateDescriptorSetLayout(d.dev, &info, nil, &layout))
+	err := d.checkLost("NewDescHeap", checkResult(C.vkCreateDescriptorSetLayout(d.dev, &info, nil, &layout)))
 	if err != nil {
 		return nil, err
 	}
@@ -92,6 +142,8 @@ func (d *Driver) NewDescHeap(ds []driver.Descriptor) (driver.DescHeap, error) {
 		nconst: nconst,
 		ntex:   ntex,
 		nsplr:  nsplr,
+		nutb:   nutb,
+		nstb:   nstb,
 	}, nil
 }
 
@@ -104,6 +156,8 @@ func (h *descHeap) New(n int) error {
 	case len(h.sets) == 0:
 		// Nothing to destroy/free.
 	default:
+		// Pending updates refer to the old sets.
+		h.discard()
 		C.vkDestroyDescriptorPool(h.d.dev, h.pool, nil)
 		C.free(unsafe.Pointer(unsafe.SliceData(h.sets)))
 		h.sets = nil
@@ -113,7 +167,7 @@ func (h *descHeap) New(n int) error {
 	}
 
 	// TODO: Consider storing some of this data in descHeap.
-	const ntype = 5
+	const ntype = 7
 	p := (*C.VkDescriptorPoolSize)(C.malloc(ntype * C.sizeof_VkDescriptorPoolSize))
 	defer C.free(unsafe.Pointer(p))
 	sizes := unsafe.Slice(p, ntype)
@@ -126,6 +180,8 @@ func (h *descHeap) New(n int) error {
 		{C.VK_DESCRIPTOR_TYPE_UNIFORM_BUFFER, C.uint32_t(h.nconst * n)},
 		{C.VK_DESCRIPTOR_TYPE_SAMPLED_IMAGE, C.uint32_t(h.ntex * n)},
 		{C.VK_DESCRIPTOR_TYPE_SAMPLER, C.uint32_t(h.nsplr * n)},
+		{C.VK_DESCRIPTOR_TYPE_UNIFORM_TEXEL_BUFFER, C.uint32_t(h.nutb * n)},
+		{C.VK_DESCRIPTOR_TYPE_STORAGE_TEXEL_BUFFER, C.uint32_t(h.nstb * n)},
 	}
 	nsize := 0
 	for i := range dc {
@@ -144,7 +200,7 @@ func (h *descHeap) New(n int) error {
 		pPoolSizes:    p,
 	}
 	var pool C.VkDescriptorPool
-	err := checkResult(C.vkCreateDescriptorPool(h.d.dev, &info, nil, &pool))
+	err := h.d.checkLost("DescHeap.New", checkResult(C.vkCreateDescriptorPool(h.d.dev, &info, nil, &pool)))
 	if err != nil {
 		return err
 	}
@@ -167,7 +223,7 @@ func (h *descHeap) New(n int) error {
 		descriptorSetCount: C.uint32_t(n),
 		pSetLayouts:        lp,
 	}
-	err = checkResult(C.vkAllocateDescriptorSets(h.d.dev, &sinfo, sp))
+	err = h.d.checkLost("DescHeap.New", checkResult(C.vkAllocateDescriptorSets(h.d.dev, &sinfo, sp)))
 	if err != nil {
 		C.vkDestroyDescriptorPool(h.d.dev, pool, nil)
 		C.free(unsafe.Pointer(sp))
@@ -182,9 +238,13 @@ func (h *descHeap) New(n int) error {
 // the given heap copy.
 func (h *descHeap) SetBuffer(cpy, nr, start int, buf []driver.Buffer, off, size []int64) {
 	p := (*C.VkDescriptorBufferInfo)(C.malloc(C.size_t(len(buf)) * C.sizeof_VkDescriptorBufferInfo))
-	defer C.free(unsafe.Pointer(p))
 	s := unsafe.Slice(p, len(buf))
 	for i := range s {
+		if buf[i] == nil {
+			// Null descriptor.
+			s[i] = C.VkDescriptorBufferInfo{_range: C.VK_WHOLE_SIZE}
+			continue
+		}
 		s[i] = C.VkDescriptorBufferInfo{
 			buffer: buf[i].(*buffer).buf,
 			offset: C.VkDeviceSize(off[i]),
@@ -200,14 +260,13 @@ func (h *descHeap) SetBuffer(cpy, nr, start int, buf []driver.Buffer, off, size
 		descriptorType:  h.typeOf(nr),
 		pBufferInfo:     p,
 	}
-	C.vkUpdateDescriptorSets(h.d.dev, 1, &write, 0, nil)
+	h.update(&write, unsafe.Pointer(p))
 }
 
 // SetImage updates the image views referred by the given descriptor of
 // the given heap copy.
 func (h *descHeap) SetImage(cpy, nr, start int, iv []driver.ImageView, plane []int) {
 	p := (*C.VkDescriptorImageInfo)(C.malloc(C.size_t(len(iv)) * C.sizeof_VkDescriptorImageInfo))
-	defer C.free(unsafe.Pointer(p))
 	s := unsafe.Slice(p, len(iv))
 	typ := h.typeOf(nr)
 	var lay C.VkImageLayout
@@ -216,19 +275,19 @@ func (h *descHeap) SetImage(cpy, nr, start int, iv []driver.ImageView, plane []i
 	} else {
 		lay = C.VK_IMAGE_LAYOUT_GENERAL
 	}
-	if len(plane) == 0 {
-		for i := range s {
-			s[i] = C.VkDescriptorImageInfo{
-				imageView:   iv[i].(*imageView).view[0],
-				imageLayout: lay,
-			}
+	for i := range s {
+		if iv[i] == nil {
+			// Null descriptor.
+			s[i] = C.VkDescriptorImageInfo{imageLayout: lay}
+			continue
 		}
-	} else {
-		for i := range s {
-			s[i] = C.VkDescriptorImageInfo{
-				imageView:   iv[i].(*imageView).view[plane[i]],
-				imageLayout: lay,
-			}
+		var pl int
+		if len(plane) != 0 {
+			pl = plane[i]
+		}
+		s[i] = C.VkDescriptorImageInfo{
+			imageView:   iv[i].(*imageView).view[pl],
+			imageLayout: lay,
 		}
 	}
 	write := C.VkWriteDescriptorSet{
@@ -240,14 +299,13 @@ func (h *descHeap) SetImage(cpy, nr, start int, iv []driver.ImageView, plane []i
 		descriptorType:  typ,
 		pImageInfo:      p,
 	}
-	C.vkUpdateDescriptorSets(h.d.dev, 1, &write, 0, nil)
+	h.update(&write, unsafe.Pointer(p))
 }
 
 // SetSampler updates the samplers referred by the given descriptor of
 // the given heap copy.
 func (h *descHeap) SetSampler(cpy, nr, start int, splr []driver.Sampler) {
 	p := (*C.VkDescriptorImageInfo)(C.malloc(C.size_t(len(splr)) * C.sizeof_VkDescriptorImageInfo))
-	defer C.free(unsafe.Pointer(p))
 	s := unsafe.Slice(p, len(splr))
 	for i := range s {
 		s[i] = C.VkDescriptorImageInfo{
@@ -263,7 +321,111 @@ func (h *descHeap) SetSampler(cpy, nr, start int, splr []driver.Sampler) {
 		descriptorType:  h.typeOf(nr),
 		pImageInfo:      p,
 	}
-	C.vkUpdateDescriptorSets(h.d.dev, 1, &write, 0, nil)
+	h.update(&write, unsafe.Pointer(p))
+}
+
+// SetBufferView updates the buffer views referred by the given
+// descriptor of the given heap copy.
+func (h *descHeap) SetBufferView(cpy, nr, start int, bv []driver.BufferView) {
+	p := (*C.VkBufferView)(C.malloc(C.size_t(len(bv)) * C.sizeof_VkBufferView))
+	s := unsafe.Slice(p, len(bv))
+	for i := range s {
+		if bv[i] == nil {
+			// Null descriptor.
+			s[i] = nil
+			continue
+		}
+		s[i] = bv[i].(*bufferView).view
+	}
+	write := C.VkWriteDescriptorSet{
+		sType:            C.VK_STRUCTURE_TYPE_WRITE_DESCRIPTOR_SET,
+		dstSet:           h.sets[cpy],
+		dstBinding:       C.uint32_t(nr),
+		dstArrayElement:  C.uint32_t(start),
+		descriptorCount:  C.uint32_t(len(bv)),
+		descriptorType:   h.typeOf(nr),
+		pTexelBufferView: p,
+	}
+	h.update(&write, unsafe.Pointer(p))
+}
+
+// update submits write, or defers it if h is batching
+// updates. p is the info array referred by write.
+func (h *descHeap) update(write *C.VkWriteDescriptorSet, p unsafe.Pointer) {
+	if h.batch {
+		// vkUpdateDescriptorSets performs every
+		// write before any copy, so deferred copies
+		// must be submitted first to preserve the
+		// order of the calls.
+		if len(h.copies) > 0 {
+			h.flush()
+		}
+		h.writes = append(h.writes, *write)
+		h.wfree = append(h.wfree, p)
+		return
+	}
+	C.vkUpdateDescriptorSets(h.d.dev, 1, write, 0, nil)
+	C.free(p)
+}
+
+// CopyFrom copies every descriptor of the given copy of src
+// into the given copy of h.
+func (h *descHeap) CopyFrom(cpy int, src driver.DescHeap, srcCpy int) {
+	sh := src.(*descHeap)
+	for i := range h.ds {
+		if h.ds[i].Immutable != nil {
+			continue
+		}
+		h.copies = append(h.copies, C.VkCopyDescriptorSet{
+			sType:           C.VK_STRUCTURE_TYPE_COPY_DESCRIPTOR_SET,
+			srcSet:          sh.sets[srcCpy],
+			srcBinding:      C.uint32_t(h.ds[i].Nr),
+			dstSet:          h.sets[cpy],
+			dstBinding:      C.uint32_t(h.ds[i].Nr),
+			descriptorCount: C.uint32_t(h.ds[i].Len),
+		})
+	}
+	if !h.batch {
+		h.flush()
+	}
+}
+
+// BeginUpdate starts deferring updates.
+func (h *descHeap) BeginUpdate() { h.batch = true }
+
+// EndUpdate submits every deferred update at once.
+func (h *descHeap) EndUpdate() {
+	h.batch = false
+	h.flush()
+}
+
+// flush submits the pending writes/copies.
+// The writes must precede the copies.
+func (h *descHeap) flush() {
+	if len(h.writes) > 0 || len(h.copies) > 0 {
+		var wp *C.VkWriteDescriptorSet
+		var cp *C.VkCopyDescriptorSet
+		if len(h.writes) > 0 {
+			wp = &h.writes[0]
+		}
+		if len(h.copies) > 0 {
+			cp = &h.copies[0]
+		}
+		C.vkUpdateDescriptorSets(h.d.dev, C.uint32_t(len(h.writes)), wp, C.uint32_t(len(h.copies)), cp)
+	}
+	h.discard()
+}
+
+// discard drops the pending writes/copies.
+func (h *descHeap) discard() {
+	for _, p := range h.wfree {
+		C.free(p)
+	}
+	clear(h.writes)
+	clear(h.wfree)
+	h.writes = h.writes[:0]
+	h.wfree = h.wfree[:0]
+	h.copies = h.copies[:0]
 }
 
 // Len returns the number of heap copies created by New.
@@ -275,6 +437,7 @@ func (h *descHeap) Destroy() {
 		return
 	}
 	if h.d != nil {
+		h.discard()
 		C.vkDestroyDescriptorSetLayout(h.d.dev, h.layout, nil)
 		// Note that h.pool is never cleared by New, just replaced.
 		if len(h.sets) != 0 {
@@ -304,6 +467,10 @@ func (h *descHeap) typeOf(descNr int) C.VkDescriptorType {
 			typ = C.VK_DESCRIPTOR_TYPE_SAMPLED_IMAGE
 		case driver.DSampler:
 			typ = C.VK_DESCRIPTOR_TYPE_SAMPLER
+		case driver.DUniformTexelBuffer:
+			typ = C.VK_DESCRIPTOR_TYPE_UNIFORM_TEXEL_BUFFER
+		case driver.DStorageTexelBuffer:
+			typ = C.VK_DESCRIPTOR_TYPE_STORAGE_TEXEL_BUFFER
 		}
 		break
 	}
@@ -338,7 +505,7 @@ func (d *Driver) NewDescTable(dh []driver.DescHeap) (driver.DescTable, error) {
 		pSetLayouts:    p,
 	}
 	var layout C.VkPipelineLayout
-	err := checkResult(C.vkCreatePipelineLayout(d.dev, &info, nil, &layout))
+	err := d.checkLost("NewDescTable", checkResult(C.vkCreatePipelineLayout(d.dev, &info, nil, &layout)))
 	if err != nil {
 		return nil, err
 	}
@@ -9,8 +9,10 @@ import "C"
 
 import (
 	"errors"
+	"math/bits"
 	"runtime"
 	"sync"
+	"sync/atomic"
 	"unsafe"
 
 	"gviegas/neo3/driver"
@@ -32,6 +34,46 @@ type Driver struct {
 	ques  []C.VkQueue
 	qfam  C.uint32_t
 
+	// Low-priority queue of d.qfam used for
+	// background work, and the timeline semaphore
+	// that its submissions signal.
+	// bgQue is nil if the device cannot create
+	// another queue of d.qfam or does not support
+	// timeline semaphores.
+	bgQue C.VkQueue
+	bgMu  sync.Mutex
+	bgSem C.VkSemaphore
+	bgVal uint64 // Guarded by bgMu.
+	// Last value of bgSem known to be signaled.
+	bgDone atomic.Uint64
+	// Queue used for async compute work, its
+	// family, and the timeline semaphore that its
+	// submissions signal. mainSem is signaled by
+	// submissions to d.ques[d.qfam], so compute
+	// work can wait for work committed before it.
+	// A family that supports compute but not
+	// graphics is preferred; if there is none,
+	// compQue is a third queue of d.qfam.
+	// compQue is nil if neither is available or
+	// the device does not support timeline
+	// semaphores. In that case, cfam is d.qfam.
+	compQue C.VkQueue
+	cfam    C.uint32_t
+	compMu  sync.Mutex
+	compSem C.VkSemaphore
+	compVal uint64 // Guarded by compMu.
+	mainSem C.VkSemaphore
+	mainVal uint64 // Guarded by d.qmus[d.qfam].
+	// Queue families that share buffers and
+	// images (d.qfam and d.cfam), or nil if
+	// they are the same family. Resources are
+	// created with concurrent sharing mode when
+	// set, so that they can be used by compute
+	// work without ownership transfers.
+	shareFams *C.uint32_t
+	// Whether timeline semaphores are enabled.
+	timeline bool
+
 	// Mutexes for ques synchronization.
 	// Queue submission requires that the queue handle
 	// be externally synchronized, thus this is needed
@@ -44,6 +86,12 @@ type Driver struct {
 	cinfo chan *commitInfo
 	csync chan *commitSync
 
+	// Committed work waiting for completion.
+	// There is one worker goroutine for every
+	// commitSync.
+	cwait chan *commitSync
+	cwg   sync.WaitGroup
+
 	// Enabled extensions, indexed by ext* constants.
 	exts [extN]bool
 
@@ -58,12 +106,36 @@ type Driver struct {
 
 	// Features of pdev.
 	feat driver.Features
+
+	// Set once the device is lost.
+	lost atomic.Pointer[driver.DeviceLostError]
+
+	// Index of the adapter chosen by OpenAdapter,
+	// plus one. 0 means none.
+	adapter int
+
+	// Whether the driver was opened by OpenHeadless.
+	// No presentation support is initialized in this
+	// case.
+	headless bool
 }
 
 func init() {
 	driver.Register(&Driver{})
 }
 
+// openInstance loads the library and initializes the
+// Vulkan instance, unless this was done already.
+func (d *Driver) openInstance() error {
+	if d.inst != nil {
+		return nil
+	}
+	if err := d.open(); err != nil {
+		return err
+	}
+	return d.initInstance()
+}
+
 // initInstance initializes the Vulkan instance.
 func (d *Driver) initInstance() error {
 	C.getGlobalProcs()
@@ -103,86 +175,207 @@ func (d *Driver) initInstance() error {
 	return nil
 }
 
-// initDevice initializes the Vulkan device.
-func (d *Driver) initDevice() error {
+// physDevice is a VkPhysicalDevice that the driver
+// can use.
+type physDevice struct {
+	dev   C.VkPhysicalDevice
+	props C.VkPhysicalDeviceProperties
+	// Number of queue families.
+	nfam int
+	// Family that supports graphics and compute.
+	fam int
+	// Number of queues in fam.
+	nque int
+	// Family that supports compute but not
+	// graphics, or -1 if there is none.
+	cfam int
+}
+
+// physDevices returns the physical devices that the
+// driver can use, in enumeration order.
+// The bare minimum is a device with a queue supporting
+// both graphics and compute operations.
+// It requires an instance.
+func (d *Driver) physDevices() ([]physDevice, error) {
 	var n C.uint32_t
 	if err := checkResult(C.vkEnumeratePhysicalDevices(d.inst, &n, nil)); err != nil {
-		return err
+		return nil, err
 	}
 	// The wording in the spec seems to indicate that
 	// vkEnumeratePhysicalDevices need not expose any
 	// devices at all.
 	if n == 0 {
-		return driver.ErrNoDevice
+		return nil, nil
 	}
 	p := (*C.VkPhysicalDevice)(C.malloc(C.sizeof_VkPhysicalDevice * C.size_t(n)))
 	defer C.free(unsafe.Pointer(p))
 	if err := checkResult(C.vkEnumeratePhysicalDevices(d.inst, &n, p)); err != nil {
-		return err
-	}
-
-	devs := unsafe.Slice(p, n)
-	devProps := make([]C.VkPhysicalDeviceProperties, n)
-	queProps := make([][]C.VkQueueFamilyProperties, n)
-	for i, dev := range devs {
-		C.vkGetPhysicalDeviceProperties(dev, &devProps[i])
-		C.vkGetPhysicalDeviceQueueFamilyProperties(dev, &n, nil)
-		p := (*C.VkQueueFamilyProperties)(C.malloc(C.sizeof_VkQueueFamilyProperties * C.size_t(n)))
-		defer C.free(unsafe.Pointer(p))
-		C.vkGetPhysicalDeviceQueueFamilyProperties(dev, &n, p)
-		queProps[i] = unsafe.Slice(p, n)
+		return nil, err
 	}
 
-	// Select a suitable physical device to use.
-	// The bare minimum is a device with a queue supporting
-	// both graphics and compute operations.
-	// Ideally, the device will be capable of creating swapchains
-	// and be hardware-accelerated.
-	weight := 0
-	for i, dev := range devs {
-		if isVariant(devProps[i].apiVersion) {
+	var pdevs []physDevice
+	for _, dev := range unsafe.Slice(p, n) {
+		var props C.VkPhysicalDeviceProperties
+		C.vkGetPhysicalDeviceProperties(dev, &props)
+		if isVariant(props.apiVersion) {
 			// Do not support variants.
 			continue
 		}
-		fam := len(queProps[i])
+		var nfam C.uint32_t
+		C.vkGetPhysicalDeviceQueueFamilyProperties(dev, &nfam, nil)
+		p := (*C.VkQueueFamilyProperties)(C.malloc(C.sizeof_VkQueueFamilyProperties * C.size_t(nfam)))
+		C.vkGetPhysicalDeviceQueueFamilyProperties(dev, &nfam, p)
+		fam, nque, cfam := -1, 0, -1
 		flg := C.VkFlags(C.VK_QUEUE_GRAPHICS_BIT | C.VK_QUEUE_COMPUTE_BIT)
-		for j, qp := range queProps[i] {
-			if qp.queueFlags&flg == flg {
-				fam = j
-				break
+		for j, qp := range unsafe.Slice(p, nfam) {
+			switch qp.queueFlags & flg {
+			case flg:
+				if fam == -1 {
+					fam, nque = j, int(qp.queueCount)
+				}
+			case C.VK_QUEUE_COMPUTE_BIT:
+				if cfam == -1 {
+					cfam = j
+				}
 			}
 		}
-		if fam == len(queProps[i]) {
+		C.free(unsafe.Pointer(p))
+		if fam == -1 {
 			// Device does not support graphics/compute operations.
 			continue
 		}
-		wgt := 1
-		if devProps[i].deviceType&(C.VK_PHYSICAL_DEVICE_TYPE_INTEGRATED_GPU|C.VK_PHYSICAL_DEVICE_TYPE_DISCRETE_GPU) != 0 {
-			wgt++
+		props.deviceName[len(props.deviceName)-1] = 0
+		pdevs = append(pdevs, physDevice{dev, props, int(nfam), fam, nque, cfam})
+	}
+	return pdevs, nil
+}
+
+// Adapters returns the devices that the driver can use.
+// It creates the Vulkan instance if d is not open.
+func (d *Driver) Adapters() ([]driver.Adapter, error) {
+	if err := d.openInstance(); err != nil {
+		return nil, err
+	}
+	pdevs, err := d.physDevices()
+	if err != nil {
+		return nil, err
+	}
+	adapters := make([]driver.Adapter, len(pdevs))
+	for i := range pdevs {
+		var mprop C.VkPhysicalDeviceMemoryProperties
+		C.vkGetPhysicalDeviceMemoryProperties(pdevs[i].dev, &mprop)
+		var mem int64
+		for _, h := range mprop.memoryHeaps[:mprop.memoryHeapCount] {
+			if h.flags&C.VK_MEMORY_HEAP_DEVICE_LOCAL_BIT != 0 {
+				mem += int64(h.size)
+			}
+		}
+		var typ driver.AdapterType
+		switch pdevs[i].props.deviceType {
+		case C.VK_PHYSICAL_DEVICE_TYPE_INTEGRATED_GPU:
+			typ = driver.AIntegrated
+		case C.VK_PHYSICAL_DEVICE_TYPE_DISCRETE_GPU:
+			typ = driver.ADiscrete
+		case C.VK_PHYSICAL_DEVICE_TYPE_VIRTUAL_GPU:
+			typ = driver.AVirtual
+		case C.VK_PHYSICAL_DEVICE_TYPE_CPU:
+			typ = driver.ACPU
+		}
+		adapters[i] = driver.Adapter{
+			Name:   C.GoString(&pdevs[i].props.deviceName[0]),
+			Vendor: uint32(pdevs[i].props.vendorID),
+			Type:   typ,
+			Memory: mem,
+		}
+	}
+	return adapters, nil
+}
+
+// OpenAdapter initializes the driver using the device
+// identified by index in the list returned by Adapters.
+func (d *Driver) OpenAdapter(index int) (driver.GPU, error) {
+	if index < 0 {
+		return nil, driver.ErrNoDevice
+	}
+	if d.dev != nil {
+		if d.adapter != index+1 {
+			return nil, driver.NewError("vk: driver already open with a different adapter", driver.ErrInvalidParam)
 		}
-		if exts, err := deviceExts(dev); err == nil {
-			for _, e := range exts {
-				if e == extSwapchain.name() {
-					wgt += 2
-					break
+		return d, nil
+	}
+	d.adapter = index + 1
+	return d.Open()
+}
+
+// OpenHeadless initializes the driver without support
+// for presentation.
+// If index is not negative, it identifies the device
+// to use as in OpenAdapter.
+func (d *Driver) OpenHeadless(index int) (driver.GPU, error) {
+	if d.dev != nil {
+		if !d.headless || (index >= 0 && d.adapter != index+1) {
+			return nil, driver.NewError("vk: driver already open with different options", driver.ErrInvalidParam)
+		}
+		return d, nil
+	}
+	if index >= 0 {
+		d.adapter = index + 1
+	}
+	d.headless = true
+	return d.Open()
+}
+
+// initDevice initializes the Vulkan device.
+func (d *Driver) initDevice() error {
+	pdevs, err := d.physDevices()
+	if err != nil {
+		return err
+	}
+
+	// Select a suitable physical device to use.
+	// Ideally, the device will be capable of creating swapchains
+	// and be hardware-accelerated.
+	// If an adapter was chosen with OpenAdapter, it is used
+	// instead.
+	var pdev *physDevice
+	if d.adapter > 0 {
+		if d.adapter > len(pdevs) {
+			return driver.ErrNoDevice
+		}
+		pdev = &pdevs[d.adapter-1]
+	} else {
+		weight := 0
+		for i := range pdevs {
+			wgt := 1
+			if pdevs[i].props.deviceType&(C.VK_PHYSICAL_DEVICE_TYPE_INTEGRATED_GPU|C.VK_PHYSICAL_DEVICE_TYPE_DISCRETE_GPU) != 0 {
+				wgt++
+			}
+			// Swapchains are irrelevant when headless.
+			if exts, err := deviceExts(pdevs[i].dev); err == nil && !d.headless {
+				for _, e := range exts {
+					if e == extSwapchain.name() {
+						wgt += 2
+						break
+					}
 				}
 			}
-		}
-		if wgt > weight {
-			d.pdev = dev
-			devProps[i].deviceName[len(devProps[i].deviceName)-1] = 0
-			d.dname = C.GoString(&devProps[i].deviceName[0])
-			d.dvers = devProps[i].apiVersion
-			d.ques = make([]C.VkQueue, len(queProps[i]))
-			d.qfam = C.uint32_t(fam)
-			d.setLimits(&devProps[i].limits)
-			weight = wgt
+			if wgt > weight {
+				pdev = &pdevs[i]
+				weight = wgt
+			}
 		}
 	}
-	if weight == 0 {
+	if pdev == nil {
 		// None of the exposed devices will suffice.
 		return driver.ErrNoDevice
 	}
+	d.pdev = pdev.dev
+	d.dname = C.GoString(&pdev.props.deviceName[0])
+	d.dvers = pdev.props.apiVersion
+	d.ques = make([]C.VkQueue, pdev.nfam)
+	d.qfam = C.uint32_t(pdev.fam)
+	d.cfam = d.qfam
+	d.setLimits(&pdev.props.limits)
 	C.vkGetPhysicalDeviceMemoryProperties(d.pdev, &d.mprop)
 	d.mused = make([]int64, d.mprop.memoryHeapCount)
 
@@ -191,10 +384,19 @@ func (d *Driver) initDevice() error {
 	// by d.qfam will be used. The remaining queues only exist
 	// to increase the likelihood of finding one that supports
 	// presentation.
+	// If possible, a second queue of d.qfam is created
+	// with the lowest priority, for background work
+	// (see d.bgQue). Async compute work uses the queue
+	// of a compute-only family if the device exposes
+	// one, or a third queue of d.qfam with the same
+	// priority as the first otherwise (see d.compQue).
 	// TODO: Consider changing the strategy here.
-	quePrio := (*C.float)(C.malloc(C.sizeof_float))
+	quePrio := (*C.float)(C.malloc(C.sizeof_float * 3))
 	defer C.free(unsafe.Pointer(quePrio))
-	*quePrio = 1.0
+	prios := unsafe.Slice(quePrio, 3)
+	prios[0] = 1.0
+	prios[1] = 0.0
+	prios[2] = 1.0
 	queInfos := (*C.VkDeviceQueueCreateInfo)(C.malloc(C.sizeof_VkDeviceQueueCreateInfo * C.size_t(len(d.ques))))
 	defer C.free(unsafe.Pointer(queInfos))
 	qis := unsafe.Slice(queInfos, len(d.ques))
@@ -217,6 +419,15 @@ func (d *Driver) initDevice() error {
 		return err
 	}
 	defer d.setFeatures(&info)()
+	bg := d.timeline && pdev.nque > 1
+	dedicated := d.timeline && pdev.cfam >= 0
+	comp := dedicated || d.timeline && pdev.nque > 2
+	switch {
+	case comp && !dedicated:
+		qis[d.qfam].queueCount = 3
+	case bg:
+		qis[d.qfam].queueCount = 2
+	}
 	if err := checkResult(C.vkCreateDevice(d.pdev, &info, nil, &d.dev)); err != nil {
 		return err
 	}
@@ -224,9 +435,81 @@ func (d *Driver) initDevice() error {
 	for i := range d.ques {
 		C.vkGetDeviceQueue(d.dev, C.uint32_t(i), 0, &d.ques[i])
 	}
+	if bg {
+		if err := d.initBackground(); err != nil {
+			return err
+		}
+	}
+	if comp {
+		cfam := -1
+		if dedicated {
+			cfam = pdev.cfam
+		}
+		return d.initCompute(cfam)
+	}
 	return nil
 }
 
+// newTimeline creates a new timeline semaphore.
+// Timeline semaphores must be enabled.
+func (d *Driver) newTimeline() (C.VkSemaphore, error) {
+	typ := (*C.VkSemaphoreTypeCreateInfoKHR)(C.malloc(C.sizeof_VkSemaphoreTypeCreateInfoKHR))
+	defer C.free(unsafe.Pointer(typ))
+	*typ = C.VkSemaphoreTypeCreateInfoKHR{
+		sType:         C.VK_STRUCTURE_TYPE_SEMAPHORE_TYPE_CREATE_INFO_KHR,
+		semaphoreType: C.VK_SEMAPHORE_TYPE_TIMELINE_KHR,
+	}
+	info := C.VkSemaphoreCreateInfo{
+		sType: C.VK_STRUCTURE_TYPE_SEMAPHORE_CREATE_INFO,
+		pNext: unsafe.Pointer(typ),
+	}
+	var sem C.VkSemaphore
+	if err := checkResult(C.vkCreateSemaphore(d.dev, &info, nil, &sem)); err != nil {
+		return nil, err
+	}
+	return sem, nil
+}
+
+// initBackground gets d.bgQue and creates d.bgSem.
+// The device must have been created with two queues
+// of d.qfam, and with timeline semaphores enabled.
+func (d *Driver) initBackground() (err error) {
+	if d.bgSem, err = d.newTimeline(); err != nil {
+		return
+	}
+	C.vkGetDeviceQueue(d.dev, d.qfam, 1, &d.bgQue)
+	return
+}
+
+// initCompute gets d.compQue and creates d.compSem
+// and d.mainSem. It also sets d.cfam, d.shareFams
+// and d.feat.AsyncCompute.
+// If cfam is negative, the device must have been
+// created with three queues of d.qfam. Otherwise,
+// cfam identifies a compute-only family, whose
+// first queue is used.
+// Timeline semaphores must be enabled.
+func (d *Driver) initCompute(cfam int) (err error) {
+	if d.compSem, err = d.newTimeline(); err != nil {
+		return
+	}
+	if d.mainSem, err = d.newTimeline(); err != nil {
+		return
+	}
+	if cfam < 0 {
+		C.vkGetDeviceQueue(d.dev, d.qfam, 2, &d.compQue)
+	} else {
+		d.cfam = C.uint32_t(cfam)
+		d.compQue = d.ques[cfam]
+		d.shareFams = (*C.uint32_t)(C.malloc(C.sizeof_uint32_t * 2))
+		fams := unsafe.Slice(d.shareFams, 2)
+		fams[0] = d.qfam
+		fams[1] = d.cfam
+	}
+	d.feat.AsyncCompute = true
+	return
+}
+
 // setLimits sets d.lim and d.aniso.
 func (d *Driver) setLimits(lim *C.VkPhysicalDeviceLimits) {
 	d.lim = driver.Limits{
@@ -244,14 +527,17 @@ func (d *Driver) setLimits(lim *C.VkPhysicalDeviceLimits) {
 		MaxDescSampler:       int(lim.maxPerStageDescriptorSamplers),
 		MaxDescBufferRange:   int64(lim.maxStorageBufferRange),
 		MaxDescConstantRange: int64(lim.maxUniformBufferRange),
+		MaxTexelBuffer:       int(lim.maxTexelBufferElements),
 
-		MaxColorTargets: int(lim.maxColorAttachments),
-		MaxRenderSize:   [2]int{int(lim.maxFramebufferWidth), int(lim.maxFramebufferHeight)},
-		MaxRenderLayers: int(lim.maxFramebufferLayers),
-		MaxPointSize:    float32(lim.pointSizeRange[1]),
+		MaxColorTargets:  int(lim.maxColorAttachments),
+		MaxRenderSize:    [2]int{int(lim.maxFramebufferWidth), int(lim.maxFramebufferHeight)},
+		MaxRenderLayers:  int(lim.maxFramebufferLayers),
+		MaxRenderSamples: maxSamples(lim.framebufferColorSampleCounts & lim.framebufferDepthSampleCounts),
+		MaxPointSize:     float32(lim.pointSizeRange[1]),
 
-		MaxVertexIn:   int(lim.maxVertexInputBindings),
-		MaxFragmentIn: int(lim.maxFragmentInputComponents / 4),
+		MaxVertexIn:      int(lim.maxVertexInputBindings),
+		MaxFragmentIn:    int(lim.maxFragmentInputComponents / 4),
+		MaxClipDistances: int(lim.maxClipDistances),
 
 		MaxDispatch: [3]int{
 			int(lim.maxComputeWorkGroupCount[0]),
@@ -268,6 +554,29 @@ func (d *Driver) setLimits(lim *C.VkPhysicalDeviceLimits) {
 	d.aniso = max(1, int(lim.maxSamplerAnisotropy))
 }
 
+// maxSamples returns the largest sample count in the
+// given VkSampleCountFlags such that every smaller
+// power of two is also present.
+func maxSamples(flags C.VkSampleCountFlags) int {
+	// Bits past the first unset one are ignored.
+	n := bits.TrailingZeros32(^uint32(flags))
+	return 1 << max(0, n-1)
+}
+
+// queryFeatures queries the features of the physical
+// device through vkGetPhysicalDeviceFeatures2KHR.
+// next must point to a feature structure allocated
+// in C memory, with its pNext set to nil.
+func (d *Driver) queryFeatures(next unsafe.Pointer) {
+	f2 := (*C.VkPhysicalDeviceFeatures2)(C.malloc(C.sizeof_VkPhysicalDeviceFeatures2))
+	defer C.free(unsafe.Pointer(f2))
+	*f2 = C.VkPhysicalDeviceFeatures2{
+		sType: C.VK_STRUCTURE_TYPE_PHYSICAL_DEVICE_FEATURES_2,
+		pNext: next,
+	}
+	C.vkGetPhysicalDeviceFeatures2KHR(d.pdev, f2)
+}
+
 // setFeatures sets d.feat and configures info's features.
 func (d *Driver) setFeatures(info *C.VkDeviceCreateInfo) (free func()) {
 	var fq C.VkPhysicalDeviceFeatures
@@ -275,6 +584,7 @@ func (d *Driver) setFeatures(info *C.VkDeviceCreateInfo) (free func()) {
 
 	if fq.independentBlend == C.VK_TRUE {
 		d.feat.IndependentBlend = true
+		d.feat.IndependentWriteMask = true
 	}
 	if fq.depthBiasClamp == C.VK_TRUE {
 		d.feat.DepthBiasClamp = true
@@ -285,6 +595,11 @@ func (d *Driver) setFeatures(info *C.VkDeviceCreateInfo) (free func()) {
 	if fq.imageCubeArray == C.VK_TRUE {
 		d.feat.CubeArray = true
 	}
+	if fq.shaderClipDistance == C.VK_TRUE {
+		d.feat.ClipDistance = true
+	}
+	// Mutable format is core in Vulkan 1.0.
+	d.feat.MutableFormat = true
 
 	feat := (*C.VkPhysicalDeviceFeatures)(C.malloc(C.size_t(unsafe.Sizeof(fq))))
 	// TODO: Need to expose more features through driver.Features.
@@ -325,10 +640,87 @@ func (d *Driver) setFeatures(info *C.VkDeviceCreateInfo) (free func()) {
 	}
 	proxy.pNext = (*C.VkBaseOutStructure)(unsafe.Pointer(dynr))
 
+	// Optional features are appended to sync2.
+	var opt []unsafe.Pointer
+	if d.exts[extDescriptorIndexing] && d.exts[extMaintenance3] {
+		di := (*C.VkPhysicalDeviceDescriptorIndexingFeaturesEXT)(C.malloc(C.sizeof_VkPhysicalDeviceDescriptorIndexingFeaturesEXT))
+		*di = C.VkPhysicalDeviceDescriptorIndexingFeaturesEXT{
+			sType: C.VK_STRUCTURE_TYPE_PHYSICAL_DEVICE_DESCRIPTOR_INDEXING_FEATURES_EXT,
+		}
+		d.queryFeatures(unsafe.Pointer(di))
+		if di.descriptorBindingPartiallyBound == C.VK_TRUE {
+			d.feat.PartiallyBound = true
+			*di = C.VkPhysicalDeviceDescriptorIndexingFeaturesEXT{
+				sType:                           C.VK_STRUCTURE_TYPE_PHYSICAL_DEVICE_DESCRIPTOR_INDEXING_FEATURES_EXT,
+				descriptorBindingPartiallyBound: C.VK_TRUE,
+			}
+			opt = append(opt, unsafe.Pointer(di))
+		} else {
+			C.free(unsafe.Pointer(di))
+		}
+	}
+	if d.exts[extRobustness2] {
+		rb := (*C.VkPhysicalDeviceRobustness2FeaturesEXT)(C.malloc(C.sizeof_VkPhysicalDeviceRobustness2FeaturesEXT))
+		*rb = C.VkPhysicalDeviceRobustness2FeaturesEXT{
+			sType: C.VK_STRUCTURE_TYPE_PHYSICAL_DEVICE_ROBUSTNESS_2_FEATURES_EXT,
+		}
+		d.queryFeatures(unsafe.Pointer(rb))
+		if rb.nullDescriptor == C.VK_TRUE {
+			d.feat.NullDescriptor = true
+			*rb = C.VkPhysicalDeviceRobustness2FeaturesEXT{
+				sType:          C.VK_STRUCTURE_TYPE_PHYSICAL_DEVICE_ROBUSTNESS_2_FEATURES_EXT,
+				nullDescriptor: C.VK_TRUE,
+			}
+			opt = append(opt, unsafe.Pointer(rb))
+		} else {
+			C.free(unsafe.Pointer(rb))
+		}
+	}
+	if d.exts[extTimelineSemaphore] {
+		ts := (*C.VkPhysicalDeviceTimelineSemaphoreFeaturesKHR)(C.malloc(C.sizeof_VkPhysicalDeviceTimelineSemaphoreFeaturesKHR))
+		*ts = C.VkPhysicalDeviceTimelineSemaphoreFeaturesKHR{
+			sType: C.VK_STRUCTURE_TYPE_PHYSICAL_DEVICE_TIMELINE_SEMAPHORE_FEATURES_KHR,
+		}
+		d.queryFeatures(unsafe.Pointer(ts))
+		if ts.timelineSemaphore == C.VK_TRUE {
+			d.timeline = true
+			opt = append(opt, unsafe.Pointer(ts))
+		} else {
+			C.free(unsafe.Pointer(ts))
+		}
+	}
+	// extMultiview is required, but the multiview
+	// feature itself is optional.
+	// Implementations that support it must allow at
+	// least 6 views.
+	mv := (*C.VkPhysicalDeviceMultiviewFeaturesKHR)(C.malloc(C.sizeof_VkPhysicalDeviceMultiviewFeaturesKHR))
+	*mv = C.VkPhysicalDeviceMultiviewFeaturesKHR{
+		sType: C.VK_STRUCTURE_TYPE_PHYSICAL_DEVICE_MULTIVIEW_FEATURES_KHR,
+	}
+	d.queryFeatures(unsafe.Pointer(mv))
+	if mv.multiview == C.VK_TRUE {
+		d.feat.Multiview = true
+		*mv = C.VkPhysicalDeviceMultiviewFeaturesKHR{
+			sType:     C.VK_STRUCTURE_TYPE_PHYSICAL_DEVICE_MULTIVIEW_FEATURES_KHR,
+			multiview: C.VK_TRUE,
+		}
+		opt = append(opt, unsafe.Pointer(mv))
+	} else {
+		C.free(unsafe.Pointer(mv))
+	}
+	proxy = (*C.VkBaseOutStructure)(unsafe.Pointer(sync2))
+	for _, p := range opt {
+		proxy.pNext = (*C.VkBaseOutStructure)(p)
+		proxy = proxy.pNext
+	}
+
 	return func() {
 		C.free(unsafe.Pointer(feat))
 		C.free(unsafe.Pointer(dynr))
 		C.free(unsafe.Pointer(sync2))
+		for _, p := range opt {
+			C.free(p)
+		}
 	}
 }
 
@@ -337,10 +729,7 @@ func (d *Driver) Open() (gpu driver.GPU, err error) {
 	if d.dev != nil {
 		return d, nil
 	}
-	if err = d.open(); err != nil {
-		goto fail
-	}
-	if err = d.initInstance(); err != nil {
+	if err = d.openInstance(); err != nil {
 		goto fail
 	}
 	if err = d.initDevice(); err != nil {
@@ -364,6 +753,11 @@ func (d *Driver) Open() (gpu driver.GPU, err error) {
 		}
 		d.csync <- cs
 	}
+	d.cwait = make(chan *commitSync, cap(d.csync))
+	d.cwg.Add(cap(d.csync))
+	for range cap(d.csync) {
+		go d.commitWorker()
+	}
 	return d, nil
 fail:
 	d.Close()
@@ -383,12 +777,20 @@ func (d *Driver) Close() {
 	if d.inst != nil {
 		if d.dev != nil {
 			C.vkDeviceWaitIdle(d.dev)
+			if d.cwait != nil {
+				close(d.cwait)
+				d.cwg.Wait()
+			}
 			for len(d.cinfo) > 0 {
 				d.destroyCommitInfo(<-d.cinfo)
 			}
 			for len(d.csync) > 0 {
 				d.destroyCommitSync(<-d.csync)
 			}
+			C.vkDestroySemaphore(d.dev, d.bgSem, nil)
+			C.vkDestroySemaphore(d.dev, d.compSem, nil)
+			C.vkDestroySemaphore(d.dev, d.mainSem, nil)
+			C.free(unsafe.Pointer(d.shareFams))
 			// TODO: Ensure that all objects created
 			// from d.dev were destroyed.
 			C.vkDestroyDevice(d.dev, nil)
@@ -410,6 +812,8 @@ type memory struct {
 	mem   C.VkDeviceMemory
 	typ   int
 	heap  int
+	exp   bool              // Exportable as ht.
+	ht    driver.HandleType // Only valid if exp is set.
 }
 
 // selectMemory selects a suitable memory type from the device.
@@ -428,6 +832,12 @@ func (d *Driver) selectMemory(typeBits uint, prop C.VkMemoryPropertyFlags) int {
 
 // newMemory creates a new memory allocation.
 func (d *Driver) newMemory(req C.VkMemoryRequirements, visible bool) (*memory, error) {
+	return d.allocMemory(req, visible, nil)
+}
+
+// allocMemory is like newMemory, but chains next into the
+// allocation info.
+func (d *Driver) allocMemory(req C.VkMemoryRequirements, visible bool, next unsafe.Pointer) (*memory, error) {
 	var prop C.VkMemoryPropertyFlags = C.VK_MEMORY_PROPERTY_DEVICE_LOCAL_BIT
 	if visible {
 		prop |= C.VK_MEMORY_PROPERTY_HOST_VISIBLE_BIT | C.VK_MEMORY_PROPERTY_HOST_COHERENT_BIT
@@ -440,16 +850,35 @@ func (d *Driver) newMemory(req C.VkMemoryRequirements, visible bool) (*memory, e
 	}
 	typ = d.selectMemory(uint(req.memoryTypeBits), prop)
 	if typ == -1 {
-		return nil, errors.New("vk: no suitable memory type found")
+		return nil, driver.NewError("vk: no suitable memory type found", driver.ErrUnsupported)
 	}
+	return d.allocType(req, typ, visible, next)
+}
 
+// newLazyMemory is like newMemory, but it prefers lazily
+// allocated memory. It is meant to back images created
+// with VK_IMAGE_USAGE_TRANSIENT_ATTACHMENT_BIT.
+func (d *Driver) newLazyMemory(req C.VkMemoryRequirements) (*memory, error) {
+	typ := d.selectMemory(uint(req.memoryTypeBits), C.VK_MEMORY_PROPERTY_DEVICE_LOCAL_BIT|C.VK_MEMORY_PROPERTY_LAZILY_ALLOCATED_BIT)
+	if typ == -1 {
+		return d.newMemory(req, false)
+	}
+	// NOTE: The whole size is accounted for in d.mused,
+	// even though the implementation may never commit it.
+	return d.allocType(req, typ, false, nil)
+}
+
+// allocType allocates memory of a specific type.
+// typ must have been returned by d.selectMemory.
+func (d *Driver) allocType(req C.VkMemoryRequirements, typ int, visible bool, next unsafe.Pointer) (*memory, error) {
 	info := C.VkMemoryAllocateInfo{
 		sType:           C.VK_STRUCTURE_TYPE_MEMORY_ALLOCATE_INFO,
+		pNext:           next,
 		allocationSize:  req.size,
 		memoryTypeIndex: C.uint32_t(typ),
 	}
 	var mem C.VkDeviceMemory
-	if err := checkResult(C.vkAllocateMemory(d.dev, &info, nil, &mem)); err != nil {
+	if err := d.checkLost("memory allocation", checkResult(C.vkAllocateMemory(d.dev, &info, nil, &mem))); err != nil {
 		return nil, err
 	}
 	heap := int(d.mprop.memoryTypes[typ].heapIndex)
@@ -477,7 +906,7 @@ func (m *memory) mmap() error {
 	}
 	if len(m.p) == 0 {
 		var p unsafe.Pointer
-		if err := checkResult(C.vkMapMemory(m.d.dev, m.mem, 0, C.VK_WHOLE_SIZE, 0, &p)); err != nil {
+		if err := m.d.checkLost("memory map", checkResult(C.vkMapMemory(m.d.dev, m.mem, 0, C.VK_WHOLE_SIZE, 0, &p))); err != nil {
 			return err
 		}
 		m.p = unsafe.Slice((*byte)(p), m.size)
@@ -514,6 +943,34 @@ func (d *Driver) Limits() driver.Limits { return d.lim }
 // Features returns the supported features.
 func (d *Driver) Features() driver.Features { return d.feat }
 
+// Status returns nil if d is usable, or the error that
+// describes the device loss otherwise.
+func (d *Driver) Status() error {
+	if e := d.lost.Load(); e != nil {
+		return e
+	}
+	return nil
+}
+
+// checkLost records the device loss if err indicates it.
+// op identifies the operation that produced err.
+// It returns either err or a *driver.DeviceLostError
+// describing the loss.
+// Every call that can fail with VK_ERROR_DEVICE_LOST
+// after the device is created must have its result
+// checked by this method, otherwise Status would not
+// report the loss.
+func (d *Driver) checkLost(op string, err error) error {
+	if err != errDeviceLost {
+		return err
+	}
+	e := &driver.DeviceLostError{Op: op}
+	if !d.lost.CompareAndSwap(nil, e) {
+		e = d.lost.Load()
+	}
+	return e
+}
+
 // checkResult returns an error derived from a VkResult value.
 // If such value does not indicate an error, it returns nil instead.
 func checkResult(res C.VkResult) error {
@@ -570,22 +1027,22 @@ var (
 	errNoDeviceMemory    = driver.ErrNoDeviceMemory
 	errInitFailed        = errors.New("vk: initialization failed")
 	errDeviceLost        = driver.ErrFatal
-	errMMapFailed        = errors.New("vk: memory map failed")
-	errNoLayer           = errors.New("vk: layer not present")
-	errNoExtension       = errors.New("vk: extension not present")
-	errNoFeature         = errors.New("vk: feature not present")
-	errDriverCompat      = errors.New("vk: incompatible driver")
-	errTooManyObjects    = errors.New("vk: too many objects")
-	errUnsupportedFormat = errors.New("vk: format not supported")
-	errFragmentedPool    = errors.New("vk: fragmented pool")
+	errMMapFailed        = driver.NewError("vk: memory map failed", driver.ErrOutOfMemory)
+	errNoLayer           = driver.NewError("vk: layer not present", driver.ErrUnsupported)
+	errNoExtension       = driver.NewError("vk: extension not present", driver.ErrUnsupported)
+	errNoFeature         = driver.NewError("vk: feature not present", driver.ErrUnsupported)
+	errDriverCompat      = driver.NewError("vk: incompatible driver", driver.ErrUnsupported)
+	errTooManyObjects    = driver.NewError("vk: too many objects", driver.ErrOutOfMemory)
+	errUnsupportedFormat = driver.NewError("vk: format not supported", driver.ErrUnsupported)
+	errFragmentedPool    = driver.NewError("vk: fragmented pool", driver.ErrOutOfMemory)
 	errUnknown           = errors.New("vk: unknown error")
-	errNoPoolMemory      = errors.New("vk: out of pool memory")
-	errExternalHandle    = errors.New("vk: invalid external handle")
-	errFragmentation     = errors.New("vk: fragmentation")
+	errNoPoolMemory      = driver.NewError("vk: out of pool memory", driver.ErrOutOfMemory)
+	errExternalHandle    = driver.NewError("vk: invalid external handle", driver.ErrInvalidParam)
+	errFragmentation     = driver.NewError("vk: fragmentation", driver.ErrOutOfMemory)
 	errSurfaceLost       = errors.New("vk: surface lost")
 	errWindowInUse       = errors.New("vk: native window in use")
 	errOutOfDate         = driver.ErrSwapchain
-	errDisplayCompat     = errors.New("vk: incompatible display")
+	errDisplayCompat     = driver.NewError("vk: incompatible display", driver.ErrUnsupported)
 )
 
 // DeviceName returns the name of the VkDevice that the driver
@@ -6,6 +6,7 @@ import (
 	"log"
 	"os"
 	"runtime"
+	"slices"
 	"testing"
 	"unsafe"
 )
@@ -132,6 +133,16 @@ func TestSelectExts(t *testing.T) {
 			} else if err := checkCStrings(c.exts, unsafe.Pointer(a)); err != nil {
 				t.Fatal(err)
 			}
+		} else {
+			var sel []string
+			for i, e := range c.exts {
+				if !slices.Contains(c.want, i) {
+					sel = append(sel, e)
+				}
+			}
+			if err := checkCStrings(sel, unsafe.Pointer(a)); err != nil {
+				t.Fatal(err)
+			}
 		}
 		if f == nil {
 			t.Fatal("selectExts:\nhave _, nil, _\nwant non-nil")
@@ -29,7 +29,16 @@ const (
 	extDepthStencilResolve
 	extDynamicRendering
 	extSynchronization2
+	extCopyCommands2
 	extSwapchain
+	extMaintenance3
+	extDescriptorIndexing
+	extRobustness2
+	extTimelineSemaphore
+	extExternalMemory
+	extExternalMemoryFD
+	extExternalMemoryDMABuf
+	extExternalMemoryWin32
 
 	extN int = iota
 )
@@ -61,8 +70,26 @@ func (e extension) name() string {
 		return "VK_KHR_dynamic_rendering"
 	case extSynchronization2:
 		return "VK_KHR_synchronization2"
+	case extCopyCommands2:
+		return "VK_KHR_copy_commands2"
 	case extSwapchain:
 		return "VK_KHR_swapchain"
+	case extMaintenance3:
+		return "VK_KHR_maintenance3"
+	case extDescriptorIndexing:
+		return "VK_EXT_descriptor_indexing"
+	case extRobustness2:
+		return "VK_EXT_robustness2"
+	case extTimelineSemaphore:
+		return "VK_KHR_timeline_semaphore"
+	case extExternalMemory:
+		return "VK_KHR_external_memory"
+	case extExternalMemoryFD:
+		return "VK_KHR_external_memory_fd"
+	case extExternalMemoryDMABuf:
+		return "VK_EXT_external_memory_dma_buf"
+	case extExternalMemoryWin32:
+		return "VK_KHR_external_memory_win32"
 	}
 	panic("you have to update vk.extension.name when adding new extensions")
 }
@@ -160,7 +187,7 @@ func selectExts(exts []string, from []string) (names **C.char, free func(), miss
 	// NOTE: This assumes that checkExts returns a sorted slice.
 	var si, ei, mi int
 	for si < n {
-		if len(missing) < mi {
+		if mi < len(missing) {
 			last := missing[mi]
 			for ; ei < last; ei++ {
 				s[si] = C.CString(exts[ei])
@@ -209,6 +236,14 @@ var (
 			extDepthStencilResolve,
 			extDynamicRendering,
 			extSynchronization2,
+			extCopyCommands2,
+		},
+		optional: []extension{
+			extMaintenance3,
+			extDescriptorIndexing,
+			extRobustness2,
+			extTimelineSemaphore,
+			extExternalMemory,
 		},
 	}
 )
@@ -222,7 +257,10 @@ func (d *Driver) setInstanceExts(info *C.VkInstanceCreateInfo) (free func(), err
 		free = func() {}
 		return
 	}
-	platform := platformInstanceExts()
+	var platform extInfo
+	if !d.headless {
+		platform = platformInstanceExts()
+	}
 	return d.setExts(&globalInstanceExts, &platform, set,
 		&info.enabledExtensionCount, &info.ppEnabledExtensionNames)
 }
@@ -11,10 +11,11 @@ func platformInstanceExts() extInfo {
 }
 
 func platformDeviceExts(d *Driver) extInfo {
+	info := extInfo{
+		optional: []extension{extExternalMemoryFD},
+	}
 	if d.exts[extSurface] && d.exts[extXCBSurface] {
-		return extInfo{
-			optional: []extension{extSwapchain},
-		}
+		info.optional = append(info.optional, extSwapchain)
 	}
-	return extInfo{}
+	return info
 }
@@ -23,10 +23,11 @@ func platformInstanceExts() extInfo {
 }
 
 func platformDeviceExts(d *Driver) extInfo {
+	info := extInfo{
+		optional: []extension{extExternalMemoryFD, extExternalMemoryDMABuf},
+	}
 	if d.exts[extSurface] && (d.exts[extWaylandSurface] || d.exts[extXCBSurface]) {
-		return extInfo{
-			optional: []extension{extSwapchain},
-		}
+		info.optional = append(info.optional, extSwapchain)
 	}
-	return extInfo{}
+	return info
 }
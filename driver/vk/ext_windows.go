@@ -9,10 +9,11 @@ func platformInstanceExts() extInfo {
 }
 
 func platformDeviceExts(d *Driver) extInfo {
+	info := extInfo{
+		optional: []extension{extExternalMemoryWin32},
+	}
 	if d.exts[extSurface] && d.exts[extWin32Surface] {
-		return extInfo{
-			optional: []extension{extSwapchain},
-		}
+		info.optional = append(info.optional, extSwapchain)
 	}
-	return extInfo{}
+	return info
 }
@@ -0,0 +1,125 @@
+// Copyright 2024 Gustavo C. Viegas. All rights reserved.
+
+package vk
+
+// #include <stdlib.h>
+// #include <proc.h>
+import "C"
+
+import (
+	"errors"
+	"slices"
+	"unsafe"
+
+	"gviegas/neo3/driver"
+)
+
+// extMem describes the external memory of a buffer
+// or image.
+type extMem struct {
+	// h.Handle is only used if imp is set.
+	h   driver.ExternalHandle
+	imp bool
+}
+
+// HandleTypes returns the handle types that can be imported
+// and exported.
+func (d *Driver) HandleTypes() []driver.HandleType { return d.handleTypes() }
+
+// NewExportBuffer creates a new buffer whose memory can be
+// exported.
+func (d *Driver) NewExportBuffer(size int64, visible bool, usg driver.Usage, ht driver.HandleType) (driver.Buffer, error) {
+	if !slices.Contains(d.handleTypes(), ht) {
+		return nil, driver.ErrHandleType
+	}
+	return d.newBuffer(size, visible, usg, &extMem{h: driver.ExternalHandle{Type: ht}})
+}
+
+// NewExportImage creates a new image whose memory can be
+// exported.
+func (d *Driver) NewExportImage(pf driver.PixelFmt, size driver.Dim3D, layers, levels, samples int, usg driver.Usage, ht driver.HandleType) (driver.Image, error) {
+	if !slices.Contains(d.handleTypes(), ht) {
+		return nil, driver.ErrHandleType
+	}
+	return d.newImage(pf, size, layers, levels, samples, usg, &extMem{h: driver.ExternalHandle{Type: ht}})
+}
+
+// ImportBuffer creates a new buffer backed by external
+// memory.
+func (d *Driver) ImportBuffer(h driver.ExternalHandle, size int64, visible bool, usg driver.Usage) (driver.Buffer, error) {
+	if !slices.Contains(d.handleTypes(), h.Type) {
+		return nil, driver.ErrHandleType
+	}
+	return d.newBuffer(size, visible, usg, &extMem{h: h, imp: true})
+}
+
+// ImportImage creates a new image backed by external
+// memory.
+func (d *Driver) ImportImage(h driver.ExternalHandle, pf driver.PixelFmt, size driver.Dim3D, layers, levels, samples int, usg driver.Usage) (driver.Image, error) {
+	if !slices.Contains(d.handleTypes(), h.Type) {
+		return nil, driver.ErrHandleType
+	}
+	return d.newImage(pf, size, layers, levels, samples, usg, &extMem{h: h, imp: true})
+}
+
+// newExtMemory is like newMemory, but the memory is
+// exported or imported as described by x.
+// ded identifies the resource that the memory will be
+// bound to. The allocation is dedicated to it if the
+// device supports dedicated allocations.
+func (d *Driver) newExtMemory(req C.VkMemoryRequirements, visible bool, x *extMem, ded C.VkMemoryDedicatedAllocateInfo) (*memory, error) {
+	var next unsafe.Pointer
+	if x.imp {
+		var err error
+		if next, err = d.importInfo(x.h, &req); err != nil {
+			return nil, err
+		}
+	} else {
+		exp := (*C.VkExportMemoryAllocateInfo)(C.malloc(C.sizeof_VkExportMemoryAllocateInfo))
+		*exp = C.VkExportMemoryAllocateInfo{
+			sType:       C.VK_STRUCTURE_TYPE_EXPORT_MEMORY_ALLOCATE_INFO,
+			handleTypes: C.VkExternalMemoryHandleTypeFlags(convHandleType(x.h.Type)),
+		}
+		next = unsafe.Pointer(exp)
+	}
+	defer C.free(next)
+	// Some implementations require dedicated allocations
+	// for external memory. Since the exporter and the
+	// importer must agree on this, we always use them
+	// when available.
+	if d.ivers >= C.VK_API_VERSION_1_1 && d.dvers >= C.VK_API_VERSION_1_1 {
+		pded := (*C.VkMemoryDedicatedAllocateInfo)(C.malloc(C.sizeof_VkMemoryDedicatedAllocateInfo))
+		defer C.free(unsafe.Pointer(pded))
+		*pded = ded
+		pded.sType = C.VK_STRUCTURE_TYPE_MEMORY_DEDICATED_ALLOCATE_INFO
+		pded.pNext = nil
+		(*C.VkBaseOutStructure)(next).pNext = (*C.VkBaseOutStructure)(unsafe.Pointer(pded))
+	}
+	m, err := d.allocMemory(req, visible, next)
+	if err != nil {
+		return nil, err
+	}
+	if !x.imp {
+		m.exp = true
+		m.ht = x.h.Type
+	}
+	return m, nil
+}
+
+var errNotExportable = errors.New("vk: memory is not exportable")
+
+// Export creates a new handle to the buffer's memory.
+func (b *buffer) Export() (driver.ExternalHandle, error) {
+	if !b.m.exp {
+		return driver.ExternalHandle{}, errNotExportable
+	}
+	return b.m.export()
+}
+
+// Export creates a new handle to the image's memory.
+func (im *image) Export() (driver.ExternalHandle, error) {
+	if im.m == nil || !im.m.exp {
+		return driver.ExternalHandle{}, errNotExportable
+	}
+	return im.m.export()
+}
@@ -0,0 +1,77 @@
+// Copyright 2024 Gustavo C. Viegas. All rights reserved.
+
+//go:build unix
+
+package vk
+
+// #include <stdlib.h>
+// #include <proc.h>
+import "C"
+
+import (
+	"unsafe"
+
+	"gviegas/neo3/driver"
+)
+
+// handleTypes returns the handle types that d supports.
+func (d *Driver) handleTypes() (ht []driver.HandleType) {
+	if !d.exts[extExternalMemory] || !d.exts[extExternalMemoryFD] {
+		return
+	}
+	ht = append(ht, driver.HOpaqueFD)
+	if d.exts[extExternalMemoryDMABuf] {
+		ht = append(ht, driver.HDMABuf)
+	}
+	return
+}
+
+// convHandleType converts a driver.HandleType to a
+// VkExternalMemoryHandleTypeFlagBits.
+func convHandleType(ht driver.HandleType) C.VkExternalMemoryHandleTypeFlagBits {
+	switch ht {
+	case driver.HOpaqueFD:
+		return C.VK_EXTERNAL_MEMORY_HANDLE_TYPE_OPAQUE_FD_BIT
+	case driver.HDMABuf:
+		return C.VK_EXTERNAL_MEMORY_HANDLE_TYPE_DMA_BUF_BIT_EXT
+	}
+	return 0
+}
+
+// importInfo returns a VkImportMemoryFdInfoKHR for h,
+// allocated with C.malloc.
+// It narrows req.memoryTypeBits to the memory types
+// that h can be imported as.
+func (d *Driver) importInfo(h driver.ExternalHandle, req *C.VkMemoryRequirements) (unsafe.Pointer, error) {
+	typ := convHandleType(h.Type)
+	if h.Type == driver.HDMABuf {
+		prop := C.VkMemoryFdPropertiesKHR{
+			sType: C.VK_STRUCTURE_TYPE_MEMORY_FD_PROPERTIES_KHR,
+		}
+		if err := d.checkLost("memory import", checkResult(C.vkGetMemoryFdPropertiesKHR(d.dev, typ, C.int(h.Handle), &prop))); err != nil {
+			return nil, err
+		}
+		req.memoryTypeBits &= prop.memoryTypeBits
+	}
+	info := (*C.VkImportMemoryFdInfoKHR)(C.malloc(C.sizeof_VkImportMemoryFdInfoKHR))
+	*info = C.VkImportMemoryFdInfoKHR{
+		sType:      C.VK_STRUCTURE_TYPE_IMPORT_MEMORY_FD_INFO_KHR,
+		handleType: typ,
+		fd:         C.int(h.Handle),
+	}
+	return unsafe.Pointer(info), nil
+}
+
+// export creates a new file descriptor referring to m.
+func (m *memory) export() (driver.ExternalHandle, error) {
+	info := C.VkMemoryGetFdInfoKHR{
+		sType:      C.VK_STRUCTURE_TYPE_MEMORY_GET_FD_INFO_KHR,
+		memory:     m.mem,
+		handleType: convHandleType(m.ht),
+	}
+	var fd C.int
+	if err := m.d.checkLost("memory export", checkResult(C.vkGetMemoryFdKHR(m.d.dev, &info, &fd))); err != nil {
+		return driver.ExternalHandle{}, err
+	}
+	return driver.ExternalHandle{Type: m.ht, Handle: uintptr(fd)}, nil
+}
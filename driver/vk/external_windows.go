@@ -0,0 +1,58 @@
+// Copyright 2024 Gustavo C. Viegas. All rights reserved.
+
+package vk
+
+// #include <windows.h>
+// #include <stdlib.h>
+// #include <proc.h>
+import "C"
+
+import (
+	"unsafe"
+
+	"gviegas/neo3/driver"
+)
+
+// handleTypes returns the handle types that d supports.
+func (d *Driver) handleTypes() []driver.HandleType {
+	if !d.exts[extExternalMemory] || !d.exts[extExternalMemoryWin32] {
+		return nil
+	}
+	return []driver.HandleType{driver.HOpaqueWin32}
+}
+
+// convHandleType converts a driver.HandleType to a
+// VkExternalMemoryHandleTypeFlagBits.
+func convHandleType(ht driver.HandleType) C.VkExternalMemoryHandleTypeFlagBits {
+	if ht == driver.HOpaqueWin32 {
+		return C.VK_EXTERNAL_MEMORY_HANDLE_TYPE_OPAQUE_WIN32_BIT
+	}
+	return 0
+}
+
+// importInfo returns a VkImportMemoryWin32HandleInfoKHR
+// for h, allocated with C.malloc.
+// req is not modified.
+func (d *Driver) importInfo(h driver.ExternalHandle, req *C.VkMemoryRequirements) (unsafe.Pointer, error) {
+	info := (*C.VkImportMemoryWin32HandleInfoKHR)(C.malloc(C.sizeof_VkImportMemoryWin32HandleInfoKHR))
+	*info = C.VkImportMemoryWin32HandleInfoKHR{
+		sType:      C.VK_STRUCTURE_TYPE_IMPORT_MEMORY_WIN32_HANDLE_INFO_KHR,
+		handleType: convHandleType(h.Type),
+		handle:     *(*C.HANDLE)(unsafe.Pointer(&h.Handle)),
+	}
+	return unsafe.Pointer(info), nil
+}
+
+// export creates a new NT handle referring to m.
+func (m *memory) export() (driver.ExternalHandle, error) {
+	info := C.VkMemoryGetWin32HandleInfoKHR{
+		sType:      C.VK_STRUCTURE_TYPE_MEMORY_GET_WIN32_HANDLE_INFO_KHR,
+		memory:     m.mem,
+		handleType: convHandleType(m.ht),
+	}
+	var h C.HANDLE
+	if err := m.d.checkLost("memory export", checkResult(C.vkGetMemoryWin32HandleKHR(m.d.dev, &info, &h))); err != nil {
+		return driver.ExternalHandle{}, err
+	}
+	return driver.ExternalHandle{Type: m.ht, Handle: uintptr(unsafe.Pointer(h))}, nil
+}
@@ -2,10 +2,14 @@
 
 package vk
 
+// #include <stdlib.h>
 // #include <proc.h>
 import "C"
 
 import (
+	"errors"
+	"unsafe"
+
 	"gviegas/neo3/driver"
 )
 
@@ -16,12 +20,30 @@ type image struct {
 	img    C.VkImage
 	fmt    C.VkFormat
 	nonfp  bool // Need to be aware of ui/i color formats in some cases.
+	mut    bool // Created with VK_IMAGE_CREATE_MUTABLE_FORMAT_BIT.
 	subres C.VkImageSubresourceRange
 	usg    C.VkImageUsageFlags
 }
 
 // NewImage creates a new image.
 func (d *Driver) NewImage(pf driver.PixelFmt, size driver.Dim3D, layers, levels, samples int, usg driver.Usage) (driver.Image, error) {
+	return d.newImage(pf, size, layers, levels, samples, usg, nil)
+}
+
+// errLinearImage is returned when creating an image
+// that requires linear tiling with parameters that
+// linear tiling does not support.
+var errLinearImage = driver.NewError("vk: DMA-BUF image must be a single-level, single-layer and single-sample 2D color image", driver.ErrUnsupported)
+
+// newImage creates a new image whose memory is
+// external if x is not nil.
+// Images shared through DMA-BUF use linear tiling,
+// since VK_EXT_image_drm_format_modifier is not
+// used. Linear images are only guaranteed to be
+// supported as single-level, single-layer and
+// single-sample 2D color images, so the same limits
+// apply to DMA-BUF images.
+func (d *Driver) newImage(pf driver.PixelFmt, size driver.Dim3D, layers, levels, samples int, usg driver.Usage, x *extMem) (driver.Image, error) {
 	format := convPixelFmt(pf)
 	scount := convSamples(samples)
 	aspect := aspectOf(pf)
@@ -75,12 +97,21 @@ func (d *Driver) NewImage(pf driver.PixelFmt, size driver.Dim3D, layers, levels,
 	if usg&driver.UShaderSample != 0 {
 		usage |= C.VK_IMAGE_USAGE_SAMPLED_BIT
 	}
+	if usg&driver.UMutableFmt != 0 {
+		if aspect != C.VK_IMAGE_ASPECT_COLOR_BIT {
+			return nil, errors.New("vk: mutable format requires color format")
+		}
+		flags |= C.VK_IMAGE_CREATE_MUTABLE_FORMAT_BIT
+	}
 	if usg&driver.URenderTarget != 0 {
 		if aspect == C.VK_IMAGE_ASPECT_COLOR_BIT {
 			usage |= C.VK_IMAGE_USAGE_COLOR_ATTACHMENT_BIT
 		} else {
 			usage |= C.VK_IMAGE_USAGE_DEPTH_STENCIL_ATTACHMENT_BIT
 		}
+		if usg&driver.UTransient != 0 {
+			usage |= C.VK_IMAGE_USAGE_TRANSIENT_ATTACHMENT_BIT
+		}
 	}
 	// At least one valid usage must have been set.
 	if usage == 0 {
@@ -91,8 +122,16 @@ func (d *Driver) NewImage(pf driver.PixelFmt, size driver.Dim3D, layers, levels,
 		panic("cannot create image without a valid usage")
 	}
 
+	var tiling C.VkImageTiling = C.VK_IMAGE_TILING_OPTIMAL
+	if x != nil && x.h.Type == driver.HDMABuf {
+		if typ != C.VK_IMAGE_TYPE_2D || layers != 1 || levels != 1 || samples != 1 || aspect != C.VK_IMAGE_ASPECT_COLOR_BIT {
+			return nil, errLinearImage
+		}
+		tiling = C.VK_IMAGE_TILING_LINEAR
+	}
+
 	var prop C.VkImageFormatProperties
-	res := C.vkGetPhysicalDeviceImageFormatProperties(d.pdev, format, typ, C.VK_IMAGE_TILING_OPTIMAL, usage, flags, &prop)
+	res := C.vkGetPhysicalDeviceImageFormatProperties(d.pdev, format, typ, tiling, usage, flags, &prop)
 	if err := checkResult(res); err != nil {
 		return nil, err
 	}
@@ -112,25 +151,47 @@ func (d *Driver) NewImage(pf driver.PixelFmt, size driver.Dim3D, layers, levels,
 		mipLevels:     C.uint32_t(levels),
 		arrayLayers:   C.uint32_t(layers),
 		samples:       scount,
-		tiling:        C.VK_IMAGE_TILING_OPTIMAL,
+		tiling:        tiling,
 		usage:         usage,
 		sharingMode:   C.VK_SHARING_MODE_EXCLUSIVE,
 		initialLayout: C.VK_IMAGE_LAYOUT_UNDEFINED,
 	}
+	if d.shareFams != nil {
+		info.sharingMode = C.VK_SHARING_MODE_CONCURRENT
+		info.queueFamilyIndexCount = 2
+		info.pQueueFamilyIndices = d.shareFams
+	}
+	if x != nil {
+		ext := (*C.VkExternalMemoryImageCreateInfo)(C.malloc(C.sizeof_VkExternalMemoryImageCreateInfo))
+		defer C.free(unsafe.Pointer(ext))
+		*ext = C.VkExternalMemoryImageCreateInfo{
+			sType:       C.VK_STRUCTURE_TYPE_EXTERNAL_MEMORY_IMAGE_CREATE_INFO,
+			handleTypes: C.VkExternalMemoryHandleTypeFlags(convHandleType(x.h.Type)),
+		}
+		info.pNext = unsafe.Pointer(ext)
+	}
 	var img C.VkImage
-	err := checkResult(C.vkCreateImage(d.dev, &info, nil, &img))
+	err := d.checkLost("NewImage", checkResult(C.vkCreateImage(d.dev, &info, nil, &img)))
 	if err != nil {
 		return nil, err
 	}
 
 	var req C.VkMemoryRequirements
 	C.vkGetImageMemoryRequirements(d.dev, img, &req)
-	m, err := d.newMemory(req, false)
+	var m *memory
+	switch {
+	case x != nil:
+		m, err = d.newExtMemory(req, false, x, C.VkMemoryDedicatedAllocateInfo{image: img})
+	case usage&C.VK_IMAGE_USAGE_TRANSIENT_ATTACHMENT_BIT != 0:
+		m, err = d.newLazyMemory(req)
+	default:
+		m, err = d.newMemory(req, false)
+	}
 	if err != nil {
 		C.vkDestroyImage(d.dev, img, nil)
 		return nil, err
 	}
-	err = checkResult(C.vkBindImageMemory(d.dev, img, m.mem, 0))
+	err = d.checkLost("NewImage", checkResult(C.vkBindImageMemory(d.dev, img, m.mem, 0)))
 	if err != nil {
 		m.free()
 		C.vkDestroyImage(d.dev, img, nil)
@@ -143,6 +204,7 @@ func (d *Driver) NewImage(pf driver.PixelFmt, size driver.Dim3D, layers, levels,
 		img:   img,
 		fmt:   format,
 		nonfp: pf.IsNonfloatColor(),
+		mut:   flags&C.VK_IMAGE_CREATE_MUTABLE_FORMAT_BIT != 0,
 		subres: C.VkImageSubresourceRange{
 			aspectMask: aspect,
 			levelCount: C.uint32_t(levels),
@@ -181,6 +243,20 @@ type imageView struct {
 
 // NewView creates a new image view.
 func (im *image) NewView(typ driver.ViewType, layer, layers, level, levels int) (driver.ImageView, error) {
+	return im.NewViewParam(&driver.ViewParam{
+		Type:   typ,
+		Layer:  layer,
+		Layers: layers,
+		Level:  level,
+		Levels: levels,
+	})
+}
+
+// NewViewParam creates a new image view from param.
+func (im *image) NewViewParam(param *driver.ViewParam) (driver.ImageView, error) {
+	typ := param.Type
+	layer, layers := param.Layer, param.Layers
+	level, levels := param.Level, param.Levels
 	var viewType C.VkImageViewType
 	switch typ {
 	case driver.IView1D:
@@ -198,16 +274,25 @@ func (im *image) NewView(typ driver.ViewType, layer, layers, level, levels int)
 	case driver.IViewCubeArray:
 		viewType = C.VK_IMAGE_VIEW_TYPE_CUBE_ARRAY
 	}
+	format := im.fmt
+	if param.PixelFmt != driver.FInvalid {
+		if f := convPixelFmt(param.PixelFmt); f != format {
+			if !im.mut {
+				return nil, errors.New("vk: view format requires mutable image")
+			}
+			format = f
+		}
+	}
 	info := C.VkImageViewCreateInfo{
 		sType:    C.VK_STRUCTURE_TYPE_IMAGE_VIEW_CREATE_INFO,
 		image:    im.img,
 		viewType: viewType,
-		format:   im.fmt,
+		format:   format,
 		components: C.VkComponentMapping{
-			r: C.VK_COMPONENT_SWIZZLE_IDENTITY,
-			g: C.VK_COMPONENT_SWIZZLE_IDENTITY,
-			b: C.VK_COMPONENT_SWIZZLE_IDENTITY,
-			a: C.VK_COMPONENT_SWIZZLE_IDENTITY,
+			r: convComponent(param.Swizzle[0]),
+			g: convComponent(param.Swizzle[1]),
+			b: convComponent(param.Swizzle[2]),
+			a: convComponent(param.Swizzle[3]),
 		},
 	}
 
@@ -235,15 +320,16 @@ func (im *image) NewView(typ driver.ViewType, layer, layers, level, levels int)
 		n = 2
 	}
 
-	var dev C.VkDevice
+	var d *Driver
 	if im.m != nil {
-		dev = im.m.d.dev
+		d = im.m.d
 	} else {
-		dev = im.s.d.dev
+		d = im.s.d
 	}
+	dev := d.dev
 	for i := 0; i < n; i++ {
 		info.subresourceRange = subres[i]
-		err := checkResult(C.vkCreateImageView(dev, &info, nil, &view[i]))
+		err := d.checkLost("Image.NewView", checkResult(C.vkCreateImageView(dev, &info, nil, &view[i])))
 		if err != nil {
 			for j := 0; j < i; j++ {
 				C.vkDestroyImageView(dev, view[j], nil)
@@ -280,6 +366,26 @@ func (v *imageView) Destroy() {
 	*v = imageView{}
 }
 
+// convComponent converts a driver.Component to a
+// VkComponentSwizzle.
+func convComponent(c driver.Component) C.VkComponentSwizzle {
+	switch c {
+	case driver.CZero:
+		return C.VK_COMPONENT_SWIZZLE_ZERO
+	case driver.COne:
+		return C.VK_COMPONENT_SWIZZLE_ONE
+	case driver.CR:
+		return C.VK_COMPONENT_SWIZZLE_R
+	case driver.CG:
+		return C.VK_COMPONENT_SWIZZLE_G
+	case driver.CB:
+		return C.VK_COMPONENT_SWIZZLE_B
+	case driver.CA:
+		return C.VK_COMPONENT_SWIZZLE_A
+	}
+	return C.VK_COMPONENT_SWIZZLE_IDENTITY
+}
+
 // convPixelFmt converts a driver.PixelFmt to a VkFormat.
 func convPixelFmt(pf driver.PixelFmt) C.VkFormat {
 	if pf.IsInternal() {
@@ -359,6 +465,9 @@ func convPixelFmt(pf driver.PixelFmt) C.VkFormat {
 	case driver.R32Int:
 		return C.VK_FORMAT_R32_SINT
 
+	case driver.RGB10A2Unorm:
+		return C.VK_FORMAT_A2B10G10R10_UNORM_PACK32
+
 	case driver.D16Unorm:
 		return C.VK_FORMAT_D16_UNORM
 	case driver.D32Float:
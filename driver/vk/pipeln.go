@@ -36,68 +36,206 @@ func (d *Driver) NewPipeline(state any) (driver.Pipeline, error) {
 	return nil, errors.New("vk: unknown pipeline state type")
 }
 
+// NewPipelineAsync creates a new pipeline in the background.
+// The pipeline leaks unless the caller waits for it or
+// calls AsyncPipeline.Destroy.
+func (d *Driver) NewPipelineAsync(state any) *driver.AsyncPipeline {
+	return driver.NewAsyncPipeline(func() (driver.Pipeline, error) {
+		return d.NewPipeline(state)
+	})
+}
+
+// NewPipelines creates a number of pipelines at once.
+// Graphics and compute states are created with a single
+// call to vkCreateGraphicsPipelines and
+// vkCreateComputePipelines, respectively.
+func (d *Driver) NewPipelines(states []any) (pls []driver.Pipeline, err error) {
+	var (
+		gi    []int
+		ci    []int
+		ginfo []C.VkGraphicsPipelineCreateInfo
+		cinfo []C.VkComputePipelineCreateInfo
+		ps    = make([]*pipeline, len(states))
+		free  []func()
+	)
+	defer func() {
+		for _, f := range free {
+			f()
+		}
+		if err != nil {
+			for _, p := range ps {
+				p.Destroy()
+			}
+			pls = nil
+		}
+	}()
+	for i, s := range states {
+		switch t := s.(type) {
+		case *driver.GraphState:
+			ps[i] = &pipeline{
+				d:     d,
+				bindp: C.VK_PIPELINE_BIND_POINT_GRAPHICS,
+			}
+			var info C.VkGraphicsPipelineCreateInfo
+			var f func()
+			if f, err = d.graphInfo(t, ps[i], &info); err != nil {
+				return
+			}
+			free = append(free, f)
+			gi = append(gi, i)
+			ginfo = append(ginfo, info)
+		case *driver.CompState:
+			ps[i] = &pipeline{
+				d:     d,
+				bindp: C.VK_PIPELINE_BIND_POINT_COMPUTE,
+			}
+			var info C.VkComputePipelineCreateInfo
+			var f func()
+			if f, err = d.compInfo(t, ps[i], &info); err != nil {
+				return
+			}
+			free = append(free, f)
+			ci = append(ci, i)
+			cinfo = append(cinfo, info)
+		default:
+			err = errors.New("vk: unknown pipeline state type")
+			return
+		}
+	}
+	// TODO: Pipeline cache.
+	var cache C.VkPipelineCache
+	if n := len(ginfo); n > 0 {
+		pl := make([]C.VkPipeline, n)
+		err = d.checkLost("NewPipelines", checkResult(C.vkCreateGraphicsPipelines(d.dev, cache, C.uint32_t(n), &ginfo[0], nil, &pl[0])))
+		// Pipelines that failed to be created are set
+		// to VK_NULL_HANDLE, so we can destroy every
+		// element regardless.
+		for j, i := range gi {
+			ps[i].pl = pl[j]
+		}
+		if err != nil {
+			return
+		}
+	}
+	if n := len(cinfo); n > 0 {
+		pl := make([]C.VkPipeline, n)
+		err = d.checkLost("NewPipelines", checkResult(C.vkCreateComputePipelines(d.dev, cache, C.uint32_t(n), &cinfo[0], nil, &pl[0])))
+		for j, i := range ci {
+			ps[i].pl = pl[j]
+		}
+		if err != nil {
+			return
+		}
+	}
+	pls = make([]driver.Pipeline, len(ps))
+	for i := range ps {
+		pls[i] = ps[i]
+	}
+	return
+}
+
 // newGraphics creates a new graphics pipeline.
 func (d *Driver) newGraphics(gs *driver.GraphState) (driver.Pipeline, error) {
 	p := &pipeline{
 		d:     d,
 		bindp: C.VK_PIPELINE_BIND_POINT_GRAPHICS,
 	}
+	var info C.VkGraphicsPipelineCreateInfo
+	free, err := d.graphInfo(gs, p, &info)
+	if err != nil {
+		return nil, err
+	}
+	// TODO: Pipeline cache.
+	var cache C.VkPipelineCache
+	err = d.checkLost("NewPipeline", checkResult(C.vkCreateGraphicsPipelines(d.dev, cache, 1, &info, nil, &p.pl)))
+	free()
+	if err != nil {
+		p.Destroy()
+		return nil, err
+	}
+	return p, nil
+}
+
+// graphInfo sets info for the creation of a graphics
+// pipeline described by gs.
+// It creates the shader modules of p.
+// The free function must be called after the pipeline
+// is created. If graphInfo fails, p is destroyed.
+func (d *Driver) graphInfo(gs *driver.GraphState, p *pipeline, info *C.VkGraphicsPipelineCreateInfo) (free func(), err error) {
+	if (gs.Blend.IndependentBlend || gs.Blend.IndependentWriteMask) && len(gs.Blend.Color) < len(gs.ColorFmt) {
+		p.Destroy()
+		return nil, errors.New("vk: too few color blend parameters")
+	}
+	if !d.feat.IndependentWriteMask && !gs.Blend.IndependentBlend && gs.Blend.IndependentWriteMask {
+		// Equal masks can be expressed without the feature.
+		for i := 1; i < len(gs.ColorFmt); i++ {
+			if gs.Blend.Color[i].WriteMask != gs.Blend.Color[0].WriteMask {
+				p.Destroy()
+				return nil, errors.New("vk: independent write masks not supported")
+			}
+		}
+	}
 	var layout C.VkPipelineLayout
+	var desc driver.DescTable
 	if gs.Desc == nil {
 		// We need a valid pipeline layout, so create a temporary
 		// descTable for its layout and destroy it at the end.
-		if desc, err := d.NewDescTable(nil); err != nil {
+		if desc, err = d.NewDescTable(nil); err != nil {
+			p.Destroy()
 			return nil, err
-		} else {
-			defer desc.Destroy()
-			layout = desc.(*descTable).layout
 		}
+		layout = desc.(*descTable).layout
 	} else {
 		layout = gs.Desc.(*descTable).layout
 	}
 	// TODO: Skip module creation if maintenance5 is supported.
 	if vmod, err := d.createModule(gs.VertFunc.Code); err != nil {
+		if desc != nil {
+			desc.Destroy()
+		}
+		p.Destroy()
 		return nil, err
 	} else {
 		p.mod[0] = vmod
 	}
 	if fcode := gs.FragFunc.Code; fcode != nil {
 		if fmod, err := d.createModule(fcode); err != nil {
-			C.vkDestroyShaderModule(d.dev, p.mod[0], nil)
+			if desc != nil {
+				desc.Destroy()
+			}
+			p.Destroy()
 			return nil, err
 		} else {
 			p.mod[1] = fmod
 		}
 	}
-	info := C.VkGraphicsPipelineCreateInfo{
+	*info = C.VkGraphicsPipelineCreateInfo{
 		sType:             C.VK_STRUCTURE_TYPE_GRAPHICS_PIPELINE_CREATE_INFO,
 		layout:            layout,
 		basePipelineIndex: -1,
 	}
-	free := [...]func(){
-		setGraphStages(gs, &info, p.mod),
-		setGraphInput(gs, &info),
-		setGraphIA(gs, &info),
-		setGraphTess(gs, &info),
-		setGraphViewport(gs, &info),
-		setGraphRaster(gs, &info),
-		setGraphMS(gs, &info),
-		setGraphDS(gs, &info),
-		setGraphBlend(gs, &info),
-		setGraphDynamic(gs, &info),
-		setGraphRendering(gs, &info),
-	}
-	// TODO: Pipeline cache.
-	var cache C.VkPipelineCache
-	err := checkResult(C.vkCreateGraphicsPipelines(d.dev, cache, 1, &info, nil, &p.pl))
-	for _, f := range free {
-		f()
-	}
-	if err != nil {
-		p.Destroy()
-		return nil, err
+	fs := [...]func(){
+		setGraphStages(gs, info, p.mod),
+		setGraphInput(gs, info),
+		setGraphIA(gs, info),
+		setGraphTess(gs, info),
+		setGraphViewport(gs, info),
+		setGraphRaster(gs, info),
+		setGraphMS(gs, info),
+		setGraphDS(gs, info),
+		setGraphBlend(gs, info),
+		setGraphDynamic(gs, info),
+		setGraphRendering(gs, info),
+	}
+	free = func() {
+		for _, f := range fs {
+			f()
+		}
+		if desc != nil {
+			desc.Destroy()
+		}
 	}
-	return p, nil
+	return
 }
 
 // setGraphStages sets the shader stages for graphics pipeline creation.
@@ -233,6 +371,9 @@ func setGraphRaster(gs *driver.GraphState, info *C.VkGraphicsPipelineCreateInfo)
 	if gs.Raster.DepthBias {
 		depthBias = C.VK_TRUE
 	}
+	// Clip distances are declared by the vertex
+	// shader, so gs.Raster.ClipDistances needs no
+	// state here.
 	prz := (*C.VkPipelineRasterizationStateCreateInfo)(C.malloc(C.sizeof_VkPipelineRasterizationStateCreateInfo))
 	*prz = C.VkPipelineRasterizationStateCreateInfo{
 		sType:                   C.VK_STRUCTURE_TYPE_PIPELINE_RASTERIZATION_STATE_CREATE_INFO,
@@ -332,7 +473,9 @@ func setGraphBlend(gs *driver.GraphState, info *C.VkGraphicsPipelineCreateInfo)
 			}
 		}
 	} else {
-		// gs.Blend.Color[0] only.
+		// gs.Blend.Color[0] only, except for
+		// write masks if IndependentWriteMask
+		// is set.
 		var blend C.VkBool32
 		if gs.Blend.Color[0].Blend {
 			blend = C.VK_TRUE
@@ -349,6 +492,9 @@ func setGraphBlend(gs *driver.GraphState, info *C.VkGraphicsPipelineCreateInfo)
 		}
 		for i := 1; i < ncolor; i++ {
 			sba[i] = sba[0]
+			if gs.Blend.IndependentWriteMask {
+				sba[i].colorWriteMask = convColorMask(gs.Blend.Color[i].WriteMask)
+			}
 		}
 	}
 	pbs := (*C.VkPipelineColorBlendStateCreateInfo)(C.malloc(C.sizeof_VkPipelineColorBlendStateCreateInfo))
@@ -417,7 +563,7 @@ func setGraphRendering(gs *driver.GraphState, info *C.VkGraphicsPipelineCreateIn
 	prend := (*C.VkPipelineRenderingCreateInfoKHR)(C.malloc(C.sizeof_VkPipelineRenderingCreateInfoKHR))
 	*prend = C.VkPipelineRenderingCreateInfoKHR{
 		sType:                   C.VK_STRUCTURE_TYPE_PIPELINE_RENDERING_CREATE_INFO_KHR,
-		viewMask:                0,
+		viewMask:                C.uint32_t(gs.ViewMask),
 		colorAttachmentCount:    C.uint32_t(ncolor),
 		pColorAttachmentFormats: pcolor,
 		depthAttachmentFormat:   depth,
@@ -442,27 +588,51 @@ func (d *Driver) newCompute(cs *driver.CompState) (driver.Pipeline, error) {
 		d:     d,
 		bindp: C.VK_PIPELINE_BIND_POINT_COMPUTE,
 	}
+	var info C.VkComputePipelineCreateInfo
+	free, err := d.compInfo(cs, p, &info)
+	if err != nil {
+		return nil, err
+	}
+	// TODO: Pipeline cache.
+	var cache C.VkPipelineCache
+	err = d.checkLost("NewPipeline", checkResult(C.vkCreateComputePipelines(d.dev, cache, 1, &info, nil, &p.pl)))
+	free()
+	if err != nil {
+		p.Destroy()
+		return nil, err
+	}
+	return p, nil
+}
+
+// compInfo sets info for the creation of a compute
+// pipeline described by cs.
+// It is analogous to graphInfo.
+func (d *Driver) compInfo(cs *driver.CompState, p *pipeline, info *C.VkComputePipelineCreateInfo) (free func(), err error) {
 	var layout C.VkPipelineLayout
+	var desc driver.DescTable
 	if cs.Desc == nil {
-		// Like newGraphics above.
+		// Like graphInfo above.
 		// This is unlikely to happen for compute however, since the
 		// shader would have no resource to read from nor write to.
-		if desc, err := d.NewDescTable(nil); err != nil {
+		if desc, err = d.NewDescTable(nil); err != nil {
+			p.Destroy()
 			return nil, err
-		} else {
-			defer desc.Destroy()
-			layout = desc.(*descTable).layout
 		}
+		layout = desc.(*descTable).layout
 	} else {
 		layout = cs.Desc.(*descTable).layout
 	}
 	// TODO: Skip module creation if maintenance5 is supported.
 	if cmod, err := d.createModule(cs.Func.Code); err != nil {
+		if desc != nil {
+			desc.Destroy()
+		}
+		p.Destroy()
 		return nil, err
 	} else {
 		p.mod[0] = cmod
 	}
-	info := C.VkComputePipelineCreateInfo{
+	*info = C.VkComputePipelineCreateInfo{
 		sType: C.VK_STRUCTURE_TYPE_COMPUTE_PIPELINE_CREATE_INFO,
 		stage: C.VkPipelineShaderStageCreateInfo{
 			sType:  C.VK_STRUCTURE_TYPE_PIPELINE_SHADER_STAGE_CREATE_INFO,
@@ -473,15 +643,13 @@ func (d *Driver) newCompute(cs *driver.CompState) (driver.Pipeline, error) {
 		layout:            layout,
 		basePipelineIndex: -1,
 	}
-	defer C.free(unsafe.Pointer(info.stage.pName))
-	// TODO: Pipeline cache.
-	var cache C.VkPipelineCache
-	err := checkResult(C.vkCreateComputePipelines(d.dev, cache, 1, &info, nil, &p.pl))
-	if err != nil {
-		p.Destroy()
-		return nil, err
+	free = func() {
+		C.free(unsafe.Pointer(info.stage.pName))
+		if desc != nil {
+			desc.Destroy()
+		}
 	}
-	return p, nil
+	return
 }
 
 // createModule creates a VkShaderModule from data.
@@ -506,7 +674,7 @@ func (d *Driver) createModule(data []byte) (C.VkShaderModule, error) {
 		codeSize: C.size_t(n),
 		pCode:    (*C.uint32_t)(p),
 	}
-	err := checkResult(C.vkCreateShaderModule(d.dev, &info, nil, &mod))
+	err := d.checkLost("NewPipeline", checkResult(C.vkCreateShaderModule(d.dev, &info, nil, &mod)))
 	return mod, err
 }
 
@@ -589,6 +757,18 @@ func convVertexFmt(vf driver.VertexFmt) C.VkFormat {
 		return C.VK_FORMAT_R32G32B32_SFLOAT
 	case driver.Float32x4:
 		return C.VK_FORMAT_R32G32B32A32_SFLOAT
+
+	case driver.RGB10A2Norm:
+		return C.VK_FORMAT_A2B10G10R10_SNORM_PACK32
+
+	case driver.Unorm16x2:
+		return C.VK_FORMAT_R16G16_UNORM
+	case driver.Unorm16x4:
+		return C.VK_FORMAT_R16G16B16A16_UNORM
+	case driver.Snorm16x2:
+		return C.VK_FORMAT_R16G16_SNORM
+	case driver.Snorm16x4:
+		return C.VK_FORMAT_R16G16B16A16_SNORM
 	}
 
 	// Expected to be unreachable.
@@ -166,8 +166,9 @@ func (s *swapchain) initSwapchain(imageCount int) error {
 		return driver.ErrWindow
 	}
 	if capab.currentExtent.width == ^C.uint32_t(0) {
-		extent.width = C.uint32_t(s.win.Width())
-		extent.height = C.uint32_t(s.win.Height())
+		width, height := wsi.PixelSize(s.win)
+		extent.width = C.uint32_t(width)
+		extent.height = C.uint32_t(height)
 	} else {
 		extent = capab.currentExtent
 	}
@@ -307,7 +308,7 @@ fmtLoop:
 		oldSwapchain:     s.sc,
 	}
 	res = C.vkCreateSwapchainKHR(s.d.dev, &info, nil, &s.sc)
-	if err := checkResult(res); err != nil {
+	if err := s.d.checkLost("swapchain creation", checkResult(res)); err != nil {
 		var null C.VkSwapchainKHR
 		s.sc = null
 		return err
@@ -327,12 +328,12 @@ func (s *swapchain) newViews() error {
 	}
 	var nimg C.uint32_t
 	res := C.vkGetSwapchainImagesKHR(s.d.dev, s.sc, &nimg, nil)
-	if err := checkResult(res); err != nil {
+	if err := s.d.checkLost("swapchain creation", checkResult(res)); err != nil {
 		return err
 	}
 	imgs := make([]C.VkImage, nimg)
 	res = C.vkGetSwapchainImagesKHR(s.d.dev, s.sc, &nimg, unsafe.SliceData(imgs))
-	if err := checkResult(res); err != nil {
+	if err := s.d.checkLost("swapchain creation", checkResult(res)); err != nil {
 		return err
 	}
 	img := image{
@@ -372,7 +373,7 @@ func (s *swapchain) createSem() (sem C.VkSemaphore, err error) {
 		sType: C.VK_STRUCTURE_TYPE_SEMAPHORE_CREATE_INFO,
 	}
 	res := C.vkCreateSemaphore(s.d.dev, &info, nil, &sem)
-	err = checkResult(res)
+	err = s.d.checkLost("semaphore creation", checkResult(res))
 	return
 }
 
@@ -588,7 +589,7 @@ func (s *swapchain) Next() (int, error) {
 		s.broken = true
 		return -1, driver.ErrSwapchain
 	default:
-		if err := checkResult(res); err != nil {
+		if err := s.d.checkLost("Swapchain.Next", checkResult(res)); err != nil {
 			return -1, err
 		}
 		// Should never happen.
@@ -663,7 +664,7 @@ func (s *swapchain) Present(index int) error {
 		s.broken = true
 		return driver.ErrWindow
 	default:
-		if err := checkResult(res); err != nil {
+		if err := s.d.checkLost("Swapchain.Present", checkResult(res)); err != nil {
 			s.broken = true
 			// Unlike the cases above, it cannot be assumed
 			// that the wait operation will happen, so the
@@ -80,6 +80,11 @@ PFN_vkCmdResetEvent2KHR cmdResetEvent2KHR = NULL;
 PFN_vkCreateGraphicsPipelines createGraphicsPipelines = NULL;
 PFN_vkCreateComputePipelines createComputePipelines = NULL;
 PFN_vkCmdSetEvent2KHR cmdSetEvent2KHR = NULL;
+PFN_vkWaitSemaphoresKHR waitSemaphoresKHR = NULL;
+PFN_vkCmdCopyBuffer2KHR cmdCopyBuffer2KHR = NULL;
+PFN_vkCmdCopyImage2KHR cmdCopyImage2KHR = NULL;
+PFN_vkCmdCopyBufferToImage2KHR cmdCopyBufferToImage2KHR = NULL;
+PFN_vkCmdCopyImageToBuffer2KHR cmdCopyImageToBuffer2KHR = NULL;
 PFN_vkDestroyPipeline destroyPipeline = NULL;
 PFN_vkCreatePipelineLayout createPipelineLayout = NULL;
 PFN_vkDestroyPipelineLayout destroyPipelineLayout = NULL;
@@ -193,6 +198,11 @@ PFN_vkGetPhysicalDeviceSurfacePresentModesKHR getPhysicalDeviceSurfacePresentMod
 PFN_vkCreateSwapchainKHR createSwapchainKHR = NULL;
 PFN_vkDestroySwapchainKHR destroySwapchainKHR = NULL;
 PFN_vkGetSwapchainImagesKHR getSwapchainImagesKHR = NULL;
+PFN_vkGetMemoryFdKHR getMemoryFdKHR = NULL;
+PFN_vkGetMemoryFdPropertiesKHR getMemoryFdPropertiesKHR = NULL;
+#ifdef _WIN32
+PFN_vkGetMemoryWin32HandleKHR getMemoryWin32HandleKHR = NULL;
+#endif
 
 void getGlobalProcs(void) {
 	PFN_vkVoidFunction fp = NULL;
@@ -406,6 +416,16 @@ void getDeviceProcs(VkDevice dh) {
 	createComputePipelines = (PFN_vkCreateComputePipelines)fp;
 	fp = getDeviceProcAddr(dh, "vkCmdSetEvent2KHR");
 	cmdSetEvent2KHR = (PFN_vkCmdSetEvent2KHR)fp;
+	fp = getDeviceProcAddr(dh, "vkWaitSemaphoresKHR");
+	waitSemaphoresKHR = (PFN_vkWaitSemaphoresKHR)fp;
+	fp = getDeviceProcAddr(dh, "vkCmdCopyBuffer2KHR");
+	cmdCopyBuffer2KHR = (PFN_vkCmdCopyBuffer2KHR)fp;
+	fp = getDeviceProcAddr(dh, "vkCmdCopyImage2KHR");
+	cmdCopyImage2KHR = (PFN_vkCmdCopyImage2KHR)fp;
+	fp = getDeviceProcAddr(dh, "vkCmdCopyBufferToImage2KHR");
+	cmdCopyBufferToImage2KHR = (PFN_vkCmdCopyBufferToImage2KHR)fp;
+	fp = getDeviceProcAddr(dh, "vkCmdCopyImageToBuffer2KHR");
+	cmdCopyImageToBuffer2KHR = (PFN_vkCmdCopyImageToBuffer2KHR)fp;
 	fp = getDeviceProcAddr(dh, "vkDestroyPipeline");
 	destroyPipeline = (PFN_vkDestroyPipeline)fp;
 	fp = getDeviceProcAddr(dh, "vkCreatePipelineLayout");
@@ -566,6 +586,14 @@ void getDeviceProcs(VkDevice dh) {
 	destroySwapchainKHR = (PFN_vkDestroySwapchainKHR)fp;
 	fp = getDeviceProcAddr(dh, "vkGetSwapchainImagesKHR");
 	getSwapchainImagesKHR = (PFN_vkGetSwapchainImagesKHR)fp;
+	fp = getDeviceProcAddr(dh, "vkGetMemoryFdKHR");
+	getMemoryFdKHR = (PFN_vkGetMemoryFdKHR)fp;
+	fp = getDeviceProcAddr(dh, "vkGetMemoryFdPropertiesKHR");
+	getMemoryFdPropertiesKHR = (PFN_vkGetMemoryFdPropertiesKHR)fp;
+#ifdef _WIN32
+	fp = getDeviceProcAddr(dh, "vkGetMemoryWin32HandleKHR");
+	getMemoryWin32HandleKHR = (PFN_vkGetMemoryWin32HandleKHR)fp;
+#endif
 }
 
 void clearProcs(void) {
@@ -644,6 +672,11 @@ void clearProcs(void) {
 	createGraphicsPipelines = NULL;
 	createComputePipelines = NULL;
 	cmdSetEvent2KHR = NULL;
+	waitSemaphoresKHR = NULL;
+	cmdCopyBuffer2KHR = NULL;
+	cmdCopyImage2KHR = NULL;
+	cmdCopyBufferToImage2KHR = NULL;
+	cmdCopyImageToBuffer2KHR = NULL;
 	destroyPipeline = NULL;
 	createPipelineLayout = NULL;
 	destroyPipelineLayout = NULL;
@@ -757,4 +790,9 @@ void clearProcs(void) {
 	createSwapchainKHR = NULL;
 	destroySwapchainKHR = NULL;
 	getSwapchainImagesKHR = NULL;
+	getMemoryFdKHR = NULL;
+	getMemoryFdPropertiesKHR = NULL;
+#ifdef _WIN32
+	getMemoryWin32HandleKHR = NULL;
+#endif
 }
@@ -95,6 +95,11 @@ extern PFN_vkCmdResetEvent2KHR cmdResetEvent2KHR;
 extern PFN_vkCreateGraphicsPipelines createGraphicsPipelines;
 extern PFN_vkCreateComputePipelines createComputePipelines;
 extern PFN_vkCmdSetEvent2KHR cmdSetEvent2KHR;
+extern PFN_vkWaitSemaphoresKHR waitSemaphoresKHR;
+extern PFN_vkCmdCopyBuffer2KHR cmdCopyBuffer2KHR;
+extern PFN_vkCmdCopyImage2KHR cmdCopyImage2KHR;
+extern PFN_vkCmdCopyBufferToImage2KHR cmdCopyBufferToImage2KHR;
+extern PFN_vkCmdCopyImageToBuffer2KHR cmdCopyImageToBuffer2KHR;
 extern PFN_vkDestroyPipeline destroyPipeline;
 extern PFN_vkCreatePipelineLayout createPipelineLayout;
 extern PFN_vkDestroyPipelineLayout destroyPipelineLayout;
@@ -208,6 +213,11 @@ extern PFN_vkGetPhysicalDeviceSurfacePresentModesKHR getPhysicalDeviceSurfacePre
 extern PFN_vkCreateSwapchainKHR createSwapchainKHR;
 extern PFN_vkDestroySwapchainKHR destroySwapchainKHR;
 extern PFN_vkGetSwapchainImagesKHR getSwapchainImagesKHR;
+extern PFN_vkGetMemoryFdKHR getMemoryFdKHR;
+extern PFN_vkGetMemoryFdPropertiesKHR getMemoryFdPropertiesKHR;
+#ifdef _WIN32
+extern PFN_vkGetMemoryWin32HandleKHR getMemoryWin32HandleKHR;
+#endif
 
 // Functions that obtain the function pointers.
 // The process of obtaining the procedures for use is as follows:
@@ -606,6 +616,31 @@ static inline void vkCmdSetEvent2KHR(VkCommandBuffer commandBuffer, VkEvent even
 	cmdSetEvent2KHR(commandBuffer, event, pDependencyInfo);
 }
 
+// vkWaitSemaphoresKHR
+static inline VkResult vkWaitSemaphoresKHR(VkDevice device, const VkSemaphoreWaitInfo* pWaitInfo, uint64_t timeout) {
+	return waitSemaphoresKHR(device, pWaitInfo, timeout);
+}
+
+// vkCmdCopyBuffer2KHR
+static inline void vkCmdCopyBuffer2KHR(VkCommandBuffer commandBuffer, const VkCopyBufferInfo2* pCopyBufferInfo) {
+	cmdCopyBuffer2KHR(commandBuffer, pCopyBufferInfo);
+}
+
+// vkCmdCopyImage2KHR
+static inline void vkCmdCopyImage2KHR(VkCommandBuffer commandBuffer, const VkCopyImageInfo2* pCopyImageInfo) {
+	cmdCopyImage2KHR(commandBuffer, pCopyImageInfo);
+}
+
+// vkCmdCopyBufferToImage2KHR
+static inline void vkCmdCopyBufferToImage2KHR(VkCommandBuffer commandBuffer, const VkCopyBufferToImageInfo2* pCopyBufferToImageInfo) {
+	cmdCopyBufferToImage2KHR(commandBuffer, pCopyBufferToImageInfo);
+}
+
+// vkCmdCopyImageToBuffer2KHR
+static inline void vkCmdCopyImageToBuffer2KHR(VkCommandBuffer commandBuffer, const VkCopyImageToBufferInfo2* pCopyImageToBufferInfo) {
+	cmdCopyImageToBuffer2KHR(commandBuffer, pCopyImageToBufferInfo);
+}
+
 // vkDestroyPipeline
 static inline void vkDestroyPipeline(VkDevice device, VkPipeline pipeline, const VkAllocationCallbacks* pAllocator) {
 	destroyPipeline(device, pipeline, pAllocator);
@@ -1115,6 +1150,23 @@ static inline VkResult vkGetSwapchainImagesKHR(VkDevice device, VkSwapchainKHR s
 	return getSwapchainImagesKHR(device, swapchain, pSwapchainImageCount, pSwapchainImages);
 }
 
+// vkGetMemoryFdKHR
+static inline VkResult vkGetMemoryFdKHR(VkDevice device, const VkMemoryGetFdInfoKHR* pGetFdInfo, int* pFd) {
+	return getMemoryFdKHR(device, pGetFdInfo, pFd);
+}
+
+// vkGetMemoryFdPropertiesKHR
+static inline VkResult vkGetMemoryFdPropertiesKHR(VkDevice device, VkExternalMemoryHandleTypeFlagBits handleType, int fd, VkMemoryFdPropertiesKHR* pMemoryFdProperties) {
+	return getMemoryFdPropertiesKHR(device, handleType, fd, pMemoryFdProperties);
+}
+
+// vkGetMemoryWin32HandleKHR
+#ifdef _WIN32
+static inline VkResult vkGetMemoryWin32HandleKHR(VkDevice device, const VkMemoryGetWin32HandleInfoKHR* pGetWin32HandleInfo, HANDLE* pHandle) {
+	return getMemoryWin32HandleKHR(device, pGetWin32HandleInfo, pHandle);
+}
+#endif
+
 // Macros that shadow certain values defined as static constants in
 // the API header. Used by Go code.
 
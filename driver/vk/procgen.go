@@ -211,6 +211,11 @@ var (
 		"vkCmdEndRenderPass2KHR",
 		"vkCmdNextSubpass2KHR",
 		"vkCreateRenderPass2KHR",
+		// From VK_KHR_copy_commands2:
+		"vkCmdCopyBuffer2KHR",
+		"vkCmdCopyBufferToImage2KHR",
+		"vkCmdCopyImage2KHR",
+		"vkCmdCopyImageToBuffer2KHR",
 		// From VK_KHR_dynamic_rendering:
 		"vkCmdBeginRenderingKHR",
 		"vkCmdEndRenderingKHR",
@@ -233,6 +238,11 @@ var (
 		"vkDestroySwapchainKHR",
 		"vkGetSwapchainImagesKHR",
 		"vkQueuePresentKHR",
+		// From VK_KHR_timeline_semaphore:
+		"vkWaitSemaphoresKHR",
+		// From VK_KHR_external_memory_fd:
+		"vkGetMemoryFdKHR",
+		"vkGetMemoryFdPropertiesKHR",
 	}
 	ExtAndroid = []string{
 		// From VK_KHR_android_surface:
@@ -247,6 +257,8 @@ var (
 		// From VK_KHR_win32_surface:
 		"vkCreateWin32SurfaceKHR",
 		"vkGetPhysicalDeviceWin32PresentationSupportKHR",
+		// From VK_KHR_external_memory_win32:
+		"vkGetMemoryWin32HandleKHR",
 	}
 	ExtGeneric = []string{
 		// From VK_KHR_xcb_surface:
@@ -0,0 +1,556 @@
+// Copyright 2024 Gustavo C. Viegas. All rights reserved.
+
+package engine
+
+import (
+	"image"
+	"image/color"
+	"slices"
+
+	"gviegas/neo3/driver"
+)
+
+const atlasPrefix = "atlas: "
+
+func newAtlasErr(reason string) error { return newErr(atlasPrefix, reason, ErrInvalidParam) }
+
+// AtlasPacker is the type of rectangle packing
+// algorithms used by an Atlas.
+type AtlasPacker int
+
+// Atlas packers.
+const (
+	// Skyline (bottom-left) packing.
+	// It is fast and packs regions of similar
+	// heights (e.g., glyphs) tightly, but space
+	// of freed regions is only reclaimed when
+	// every region of the page is freed.
+	PackSkyline AtlasPacker = iota
+	// Guillotine packing with best area fit.
+	// It is slower, but space of freed regions
+	// is reclaimed immediately.
+	PackGuillotine
+)
+
+// AtlasParam describes an Atlas.
+// Width and Height are the size of each page, in
+// pixels, and PixelFmt is the format of the page
+// textures. MaxPages limits the number of pages
+// (zero means no limit).
+// Padding is the number of pixels left empty to the
+// right and below each region, which prevents
+// filtering from bleeding across regions.
+// When every page is full, OnExhaust determines what
+// Alloc does: ExhaustError makes it fail with
+// ErrExhausted, and ExhaustEvict makes it clear the
+// least recently used page (see Atlas.Touch) and
+// then call OnEvict, if not nil, with the index of
+// that page. ExhaustBlock is not valid.
+type AtlasParam struct {
+	PixelFmt  driver.PixelFmt
+	Width     int
+	Height    int
+	MaxPages  int
+	Padding   int
+	Packer    AtlasPacker
+	OnExhaust ExhaustPolicy
+	OnEvict   func(page int)
+}
+
+// AtlasRegion is a rectangular area of an Atlas page.
+type AtlasRegion struct {
+	Page          int
+	X, Y          int
+	Width, Height int
+	// Generation of the page when the region was
+	// allocated.
+	gen uint32
+}
+
+// UV returns the scale and offset that map texture
+// coordinates in the [0.0, 1.0] interval to the area
+// of r in a page of the given size.
+// The mapped coordinates are uv*scale + offset.
+func (r AtlasRegion) UV(pageWidth, pageHeight int) (scale, offset [2]float32) {
+	w, h := float32(pageWidth), float32(pageHeight)
+	scale = [2]float32{float32(r.Width) / w, float32(r.Height) / h}
+	offset = [2]float32{float32(r.X) / w, float32(r.Y) / h}
+	return
+}
+
+// Atlas packs rectangular regions into pages of 2D
+// textures (e.g., glyphs, sprites or lightmaps).
+// Every page keeps a CPU copy of its contents, which
+// Update modifies and Flush uploads.
+// It is not safe for concurrent use.
+type Atlas struct {
+	param AtlasParam
+	pages []*atlasPage
+	// Incremented on every use of a page.
+	tick uint64
+}
+
+// atlasPage is a page of an Atlas.
+type atlasPage struct {
+	tex  *Texture
+	data []byte
+	pack atlasPacker
+	// Regions currently allocated, including
+	// padding.
+	live []image.Rectangle
+	// Incremented when the page is cleared, which
+	// invalidates every region allocated before.
+	gen     uint32
+	lastUse uint64
+	dirty   bool
+}
+
+// NewAtlas creates a new Atlas.
+// It has no pages initially.
+func NewAtlas(param *AtlasParam) (*Atlas, error) {
+	var reason string
+	switch {
+	case param == nil:
+		reason = "nil param"
+	case param.PixelFmt.Size() < 1:
+		reason = "invalid pixel format"
+	case param.Width < 1, param.Height < 1:
+		reason = "invalid page size"
+	case param.MaxPages < 0:
+		reason = "negative page limit"
+	case param.Padding < 0, param.Padding >= min(param.Width, param.Height):
+		reason = "invalid padding"
+	case param.Packer != PackSkyline && param.Packer != PackGuillotine:
+		reason = "undefined packer"
+	case param.OnExhaust != ExhaustError && param.OnExhaust != ExhaustEvict:
+		reason = "invalid exhaustion policy"
+	default:
+		return &Atlas{param: *param}, nil
+	}
+	return nil, newAtlasErr(reason)
+}
+
+// Param returns the parameters of a.
+func (a *Atlas) Param() AtlasParam { return a.param }
+
+// Pages returns the number of pages in a.
+func (a *Atlas) Pages() int { return len(a.pages) }
+
+// Page returns the texture of the given page.
+// Its contents are only defined after a call to Flush.
+func (a *Atlas) Page(page int) *Texture { return a.pages[page].tex }
+
+// Alloc allocates a region of the given size.
+// It tries every existing page before creating a new
+// one, and evicts a page if a.Param().OnExhaust is
+// ExhaustEvict and no page can be created.
+// The contents of the region are undefined until
+// Update is called.
+func (a *Atlas) Alloc(width, height int) (AtlasRegion, error) {
+	pad := a.param.Padding
+	w, h := width+pad, height+pad
+	if width < 1 || height < 1 || w > a.param.Width || h > a.param.Height {
+		return AtlasRegion{}, newAtlasErr("invalid region size")
+	}
+	for i, p := range a.pages {
+		if r, ok := a.place(i, p, w, h); ok {
+			return r, nil
+		}
+	}
+	if a.param.MaxPages == 0 || len(a.pages) < a.param.MaxPages {
+		p, err := a.newPage()
+		if err != nil {
+			return AtlasRegion{}, err
+		}
+		a.pages = append(a.pages, p)
+		r, _ := a.place(len(a.pages)-1, p, w, h)
+		return r, nil
+	}
+	if a.param.OnExhaust != ExhaustEvict {
+		return AtlasRegion{}, ErrExhausted
+	}
+	i := a.lru()
+	a.clear(i)
+	if a.param.OnEvict != nil {
+		a.param.OnEvict(i)
+	}
+	r, _ := a.place(i, a.pages[i], w, h)
+	return r, nil
+}
+
+// place allocates a region of w by h pixels, including
+// padding, in the given page.
+func (a *Atlas) place(i int, p *atlasPage, w, h int) (AtlasRegion, bool) {
+	x, y, ok := p.pack.alloc(w, h)
+	if !ok {
+		return AtlasRegion{}, false
+	}
+	p.live = append(p.live, image.Rect(x, y, x+w, y+h))
+	a.use(p)
+	pad := a.param.Padding
+	return AtlasRegion{
+		Page:   i,
+		X:      x,
+		Y:      y,
+		Width:  w - pad,
+		Height: h - pad,
+		gen:    p.gen,
+	}, true
+}
+
+// newPage creates a new, empty page.
+func (a *Atlas) newPage() (*atlasPage, error) {
+	tex, err := New2D(&TexParam{
+		PixelFmt: a.param.PixelFmt,
+		Dim3D:    driver.Dim3D{Width: a.param.Width, Height: a.param.Height},
+		Layers:   1,
+		Levels:   1,
+		Samples:  1,
+	})
+	if err != nil {
+		return nil, err
+	}
+	var pack atlasPacker
+	switch a.param.Packer {
+	case PackSkyline:
+		pack = new(skylinePacker)
+	case PackGuillotine:
+		pack = new(guillotinePacker)
+	}
+	pack.reset(a.param.Width, a.param.Height)
+	return &atlasPage{
+		tex:   tex,
+		data:  make([]byte, a.param.Width*a.param.Height*a.param.PixelFmt.Size()),
+		pack:  pack,
+		dirty: true,
+	}, nil
+}
+
+// use marks p as the most recently used page.
+func (a *Atlas) use(p *atlasPage) {
+	a.tick++
+	p.lastUse = a.tick
+}
+
+// lru returns the index of the least recently used
+// page.
+func (a *Atlas) lru() int {
+	i := 0
+	for j, p := range a.pages {
+		if p.lastUse < a.pages[i].lastUse {
+			i = j
+		}
+	}
+	return i
+}
+
+// clear frees every region of the given page.
+// The CPU copy is not cleared.
+func (a *Atlas) clear(page int) {
+	p := a.pages[page]
+	p.pack.reset(a.param.Width, a.param.Height)
+	p.live = p.live[:0]
+	p.gen++
+}
+
+// Valid returns whether r is a region of a that was
+// not freed nor evicted.
+func (a *Atlas) Valid(r AtlasRegion) bool {
+	if r.Page < 0 || r.Page >= len(a.pages) {
+		return false
+	}
+	p := a.pages[r.Page]
+	if r.gen != p.gen {
+		return false
+	}
+	return p.find(r, a.param.Padding) >= 0
+}
+
+// find returns the index of r in p.live, or -1 if r
+// is not allocated.
+func (p *atlasPage) find(r AtlasRegion, pad int) int {
+	rect := image.Rect(r.X, r.Y, r.X+r.Width+pad, r.Y+r.Height+pad)
+	return slices.Index(p.live, rect)
+}
+
+// Touch marks the page of r as used, which delays its
+// eviction.
+// It should be called whenever r is drawn.
+func (a *Atlas) Touch(r AtlasRegion) {
+	if a.Valid(r) {
+		a.use(a.pages[r.Page])
+	}
+}
+
+// FreeRegion frees r.
+// It does nothing if r is not valid.
+// The page is cleared when its last region is freed.
+func (a *Atlas) FreeRegion(r AtlasRegion) {
+	if !a.Valid(r) {
+		return
+	}
+	p := a.pages[r.Page]
+	pad := a.param.Padding
+	i := p.find(r, pad)
+	p.live = slices.Delete(p.live, i, i+1)
+	if len(p.live) == 0 {
+		a.clear(r.Page)
+		return
+	}
+	p.pack.free(r.X, r.Y, r.Width+pad, r.Height+pad)
+}
+
+// Update copies data into the CPU copy of r's page.
+// data must contain r.Width*r.Height pixels, tightly
+// packed, in row-major order.
+// The page texture is updated by the next call to
+// Flush.
+func (a *Atlas) Update(r AtlasRegion, data []byte) error {
+	if !a.Valid(r) {
+		return newAtlasErr("invalid region")
+	}
+	ps := a.param.PixelFmt.Size()
+	row := r.Width * ps
+	if len(data) < row*r.Height {
+		return newAtlasErr("not enough data for copying")
+	}
+	p := a.pages[r.Page]
+	stride := a.param.Width * ps
+	off := r.Y*stride + r.X*ps
+	for range r.Height {
+		copy(p.data[off:off+row], data[:row])
+		data = data[row:]
+		off += stride
+	}
+	p.dirty = true
+	a.use(p)
+	return nil
+}
+
+// Flush uploads the CPU copy of every page that
+// changed since the last call.
+// The copies are committed before Flush returns.
+func (a *Atlas) Flush() error {
+	for _, p := range a.pages {
+		if !p.dirty {
+			continue
+		}
+		if err := p.tex.CopyToView(0, p.data, true); err != nil {
+			return err
+		}
+		p.dirty = false
+	}
+	return nil
+}
+
+// DebugImage returns an image that visualizes the
+// allocation of the given page.
+// Each region is filled with a color derived from its
+// position and outlined in white. Padding and free
+// space are black.
+func (a *Atlas) DebugImage(page int) *image.RGBA {
+	p := a.pages[page]
+	img := image.NewRGBA(image.Rect(0, 0, a.param.Width, a.param.Height))
+	pad := a.param.Padding
+	for _, r := range p.live {
+		r.Max = r.Max.Sub(image.Pt(pad, pad))
+		h := uint32(r.Min.X*73856093 ^ r.Min.Y*19349663)
+		fill := color.RGBA{
+			R: uint8(64 + h%192),
+			G: uint8(64 + h/192%192),
+			B: uint8(64 + h/36864%192),
+			A: 255,
+		}
+		for y := r.Min.Y; y < r.Max.Y; y++ {
+			for x := r.Min.X; x < r.Max.X; x++ {
+				c := fill
+				if x == r.Min.X || y == r.Min.Y || x == r.Max.X-1 || y == r.Max.Y-1 {
+					c = color.RGBA{255, 255, 255, 255}
+				}
+				img.SetRGBA(x, y, c)
+			}
+		}
+	}
+	return img
+}
+
+// Free invalidates a and destroys its pages.
+func (a *Atlas) Free() {
+	for _, p := range a.pages {
+		p.tex.Free()
+	}
+	*a = Atlas{}
+}
+
+// atlasPacker is the interface that packing
+// algorithms of an Atlas page implement.
+type atlasPacker interface {
+	// reset frees every region in a page of the
+	// given size.
+	reset(width, height int)
+	// alloc allocates a region of the given size
+	// and returns its position.
+	alloc(width, height int) (x, y int, ok bool)
+	// free frees a region previously allocated.
+	// Packers that cannot reclaim space of
+	// individual regions may ignore it.
+	free(x, y, width, height int)
+}
+
+// skylinePacker is an atlasPacker that implements
+// PackSkyline.
+// The skyline is a sequence of horizontal segments
+// covering the width of the page, each at the height
+// of the tallest region placed below it.
+type skylinePacker struct {
+	width, height int
+	segs          []skylineSeg
+}
+
+// skylineSeg is a segment of the skyline.
+type skylineSeg struct{ x, y, width int }
+
+func (s *skylinePacker) reset(width, height int) {
+	s.width, s.height = width, height
+	s.segs = append(s.segs[:0], skylineSeg{0, 0, width})
+}
+
+// fit returns the height at which a region of the
+// given size would be placed if its left edge were
+// at the start of segment i.
+func (s *skylinePacker) fit(i, width, height int) (y int, ok bool) {
+	x := s.segs[i].x
+	if x+width > s.width {
+		return 0, false
+	}
+	for rem := width; rem > 0; i++ {
+		y = max(y, s.segs[i].y)
+		if y+height > s.height {
+			return 0, false
+		}
+		rem -= s.segs[i].width
+	}
+	return y, true
+}
+
+func (s *skylinePacker) alloc(width, height int) (x, y int, ok bool) {
+	best := -1
+	bestTop, bestWidth := 0, 0
+	for i := range s.segs {
+		sy, fits := s.fit(i, width, height)
+		if !fits {
+			continue
+		}
+		top := sy + height
+		if best < 0 || top < bestTop || top == bestTop && s.segs[i].width < bestWidth {
+			best, bestTop, bestWidth = i, top, s.segs[i].width
+			x = s.segs[i].x
+		}
+	}
+	if best < 0 {
+		return 0, 0, false
+	}
+	s.segs = slices.Insert(s.segs, best, skylineSeg{x, bestTop, width})
+	// Shrink or remove the segments that the new
+	// one covers.
+	for i := best + 1; i < len(s.segs); {
+		prev := s.segs[i-1]
+		seg := &s.segs[i]
+		end := prev.x + prev.width
+		if seg.x >= end {
+			break
+		}
+		if seg.x+seg.width <= end {
+			s.segs = slices.Delete(s.segs, i, i+1)
+			continue
+		}
+		seg.width -= end - seg.x
+		seg.x = end
+		break
+	}
+	// Merge adjacent segments at the same height.
+	for i := 1; i < len(s.segs); {
+		if s.segs[i].y == s.segs[i-1].y {
+			s.segs[i-1].width += s.segs[i].width
+			s.segs = slices.Delete(s.segs, i, i+1)
+			continue
+		}
+		i++
+	}
+	return x, bestTop - height, true
+}
+
+func (s *skylinePacker) free(x, y, width, height int) {}
+
+// guillotinePacker is an atlasPacker that implements
+// PackGuillotine.
+// Free space is a list of disjoint rectangles. Each
+// allocation takes the free rectangle that best fits
+// it and splits the remainder in two.
+type guillotinePacker struct {
+	rects []image.Rectangle
+}
+
+func (g *guillotinePacker) reset(width, height int) {
+	g.rects = append(g.rects[:0], image.Rect(0, 0, width, height))
+}
+
+func (g *guillotinePacker) alloc(width, height int) (x, y int, ok bool) {
+	best := -1
+	bestArea := 0
+	for i, r := range g.rects {
+		w, h := r.Dx(), r.Dy()
+		if w < width || h < height {
+			continue
+		}
+		if area := w*h - width*height; best < 0 || area < bestArea {
+			best, bestArea = i, area
+		}
+	}
+	if best < 0 {
+		return 0, 0, false
+	}
+	r := g.rects[best]
+	g.rects = slices.Delete(g.rects, best, best+1)
+	x, y = r.Min.X, r.Min.Y
+	// Split along the shorter leftover axis, so
+	// that the larger remainder is kept whole.
+	var right, below image.Rectangle
+	if r.Dx()-width < r.Dy()-height {
+		right = image.Rect(x+width, y, r.Max.X, y+height)
+		below = image.Rect(x, y+height, r.Max.X, r.Max.Y)
+	} else {
+		right = image.Rect(x+width, y, r.Max.X, r.Max.Y)
+		below = image.Rect(x, y+height, x+width, r.Max.Y)
+	}
+	for _, r := range [2]image.Rectangle{right, below} {
+		if !r.Empty() {
+			g.rects = append(g.rects, r)
+		}
+	}
+	return x, y, true
+}
+
+func (g *guillotinePacker) free(x, y, width, height int) {
+	g.rects = append(g.rects, image.Rect(x, y, x+width, y+height))
+	// Merge free rectangles that share a whole
+	// edge, until no more merges are possible.
+	for merged := true; merged; {
+		merged = false
+		for i := 0; i < len(g.rects) && !merged; i++ {
+			for j := i + 1; j < len(g.rects); j++ {
+				a, b := g.rects[i], g.rects[j]
+				switch {
+				case a.Min.Y == b.Min.Y && a.Max.Y == b.Max.Y && (a.Max.X == b.Min.X || b.Max.X == a.Min.X):
+				case a.Min.X == b.Min.X && a.Max.X == b.Max.X && (a.Max.Y == b.Min.Y || b.Max.Y == a.Min.Y):
+				default:
+					continue
+				}
+				g.rects[i] = a.Union(b)
+				g.rects = slices.Delete(g.rects, j, j+1)
+				merged = true
+				break
+			}
+		}
+	}
+}
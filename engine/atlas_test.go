@@ -0,0 +1,232 @@
+// Copyright 2024 Gustavo C. Viegas. All rights reserved.
+
+package engine
+
+import (
+	"errors"
+	"image"
+	"math/rand/v2"
+	"testing"
+
+	"gviegas/neo3/driver"
+)
+
+// testPacker allocates random regions with p until
+// it fails a number of times and checks that they are
+// within bounds and do not overlap.
+func testPacker(t *testing.T, p atlasPacker, width, height int) {
+	p.reset(width, height)
+	rng := rand.New(rand.NewPCG(1, 2))
+	bounds := image.Rect(0, 0, width, height)
+	var rects []image.Rectangle
+	for fails := 0; fails < 16; {
+		w, h := 1+rng.IntN(width/4), 1+rng.IntN(height/4)
+		x, y, ok := p.alloc(w, h)
+		if !ok {
+			fails++
+			continue
+		}
+		r := image.Rect(x, y, x+w, y+h)
+		if !r.In(bounds) {
+			t.Fatalf("%T.alloc: %v out of bounds", p, r)
+		}
+		for _, s := range rects {
+			if r.Overlaps(s) {
+				t.Fatalf("%T.alloc: %v overlaps %v", p, r, s)
+			}
+		}
+		rects = append(rects, r)
+	}
+	var area int
+	for _, r := range rects {
+		area += r.Dx() * r.Dy()
+	}
+	t.Logf("%T: %d regions, %.1f%% used", p, len(rects), float64(area)*100/float64(width*height))
+	if _, _, ok := p.alloc(width+1, 1); ok {
+		t.Fatalf("%T.alloc: region wider than the page", p)
+	}
+	p.reset(width, height)
+	if x, y, ok := p.alloc(width, height); !ok || x != 0 || y != 0 {
+		t.Fatalf("%T.reset: page should be empty", p)
+	}
+}
+
+func TestSkylinePacker(t *testing.T) {
+	var p skylinePacker
+	testPacker(t, &p, 256, 128)
+
+	// Bottom-left placement.
+	p.reset(10, 10)
+	for _, x := range [...]struct{ w, h, x, y int }{
+		{4, 2, 0, 0},
+		{4, 3, 4, 0},
+		{2, 5, 8, 0},
+		{4, 1, 0, 2},
+		{8, 1, 0, 3},
+	} {
+		if x0, y0, ok := p.alloc(x.w, x.h); !ok || x0 != x.x || y0 != x.y {
+			t.Fatalf("skylinePacker.alloc(%d, %d):\nhave %d, %d, %t\nwant %d, %d, true", x.w, x.h, x0, y0, ok, x.x, x.y)
+		}
+	}
+	if len(p.segs) != 2 || p.segs[0] != (skylineSeg{0, 4, 8}) || p.segs[1] != (skylineSeg{8, 5, 2}) {
+		t.Fatalf("skylinePacker.alloc: segments\n%v", p.segs)
+	}
+}
+
+func TestGuillotinePacker(t *testing.T) {
+	var p guillotinePacker
+	testPacker(t, &p, 128, 256)
+
+	// Space of freed regions is reclaimed.
+	p.reset(8, 8)
+	for range 4 {
+		if _, _, ok := p.alloc(4, 4); !ok {
+			t.Fatal("guillotinePacker.alloc failed")
+		}
+	}
+	if _, _, ok := p.alloc(1, 1); ok {
+		t.Fatal("guillotinePacker.alloc: page should be full")
+	}
+	p.free(4, 0, 4, 4)
+	p.free(4, 4, 4, 4)
+	if x, y, ok := p.alloc(4, 8); !ok || x != 4 || y != 0 {
+		t.Fatalf("guillotinePacker.alloc(4, 8):\nhave %d, %d, %t\nwant 4, 0, true", x, y, ok)
+	}
+}
+
+func TestAtlas(t *testing.T) {
+	for _, x := range [...]AtlasParam{
+		{PixelFmt: driver.RGBA8Unorm, Width: 0, Height: 64},
+		{PixelFmt: driver.RGBA8Unorm, Width: 64, Height: 64, MaxPages: -1},
+		{PixelFmt: driver.RGBA8Unorm, Width: 64, Height: 64, Padding: 64},
+		{PixelFmt: driver.RGBA8Unorm, Width: 64, Height: 64, Packer: -1},
+		{PixelFmt: driver.RGBA8Unorm, Width: 64, Height: 64, OnExhaust: ExhaustBlock},
+	} {
+		if _, err := NewAtlas(&x); !errors.Is(err, ErrInvalidParam) {
+			t.Fatalf("NewAtlas(%+v):\nhave %v\nwant %v", x, err, ErrInvalidParam)
+		}
+	}
+
+	var evicted []int
+	a, err := NewAtlas(&AtlasParam{
+		PixelFmt:  driver.RGBA8Unorm,
+		Width:     64,
+		Height:    64,
+		MaxPages:  2,
+		Padding:   1,
+		OnExhaust: ExhaustEvict,
+		OnEvict:   func(page int) { evicted = append(evicted, page) },
+	})
+	if err != nil {
+		t.Fatalf("NewAtlas failed:\n%v", err)
+	}
+	defer a.Free()
+
+	if _, err := a.Alloc(64, 1); !errors.Is(err, ErrInvalidParam) {
+		t.Fatalf("Atlas.Alloc: padding must count towards the page size\nhave %v\nwant %v", err, ErrInvalidParam)
+	}
+	r0, err := a.Alloc(40, 40)
+	if err != nil {
+		t.Fatalf("Atlas.Alloc failed:\n%v", err)
+	}
+	r1, err := a.Alloc(40, 40)
+	if err != nil {
+		t.Fatalf("Atlas.Alloc failed:\n%v", err)
+	}
+	if r0.Page != 0 || r1.Page != 1 || a.Pages() != 2 {
+		t.Fatalf("Atlas.Alloc: pages\nhave %d, %d (%d)\nwant 0, 1 (2)", r0.Page, r1.Page, a.Pages())
+	}
+	if s, o := r0.UV(64, 64); s != [2]float32{40.0 / 64, 40.0 / 64} || o != [2]float32{} {
+		t.Fatalf("AtlasRegion.UV:\nhave %v, %v", s, o)
+	}
+
+	data := make([]byte, 40*40*4)
+	for i := range data {
+		data[i] = byte(i)
+	}
+	if err := a.Update(r0, data[:100]); !errors.Is(err, ErrInvalidParam) {
+		t.Fatalf("Atlas.Update: short data\nhave %v\nwant %v", err, ErrInvalidParam)
+	}
+	if err := a.Update(r0, data); err != nil {
+		t.Fatalf("Atlas.Update failed:\n%v", err)
+	}
+	if err := a.Flush(); err != nil {
+		t.Fatalf("Atlas.Flush failed:\n%v", err)
+	}
+	dst := make([]byte, 64*64*4)
+	if _, err := a.Page(0).CopyFromView(0, dst); err != nil {
+		t.Fatalf("Texture.CopyFromView failed:\n%v", err)
+	}
+	for y := range 40 {
+		if string(dst[y*64*4:y*64*4+40*4]) != string(data[y*40*4:(y+1)*40*4]) {
+			t.Fatalf("Atlas.Flush: row %d differs", y)
+		}
+	}
+
+	// Page 0 is the least recently used.
+	a.Touch(r1)
+	r2, err := a.Alloc(40, 40)
+	if err != nil {
+		t.Fatalf("Atlas.Alloc failed:\n%v", err)
+	}
+	if r2.Page != 0 || len(evicted) != 1 || evicted[0] != 0 {
+		t.Fatalf("Atlas.Alloc: eviction\nhave page %d, evicted %v\nwant page 0, evicted [0]", r2.Page, evicted)
+	}
+	if a.Valid(r0) || !a.Valid(r1) || !a.Valid(r2) {
+		t.Fatal("Atlas.Valid: unexpected result after eviction")
+	}
+	if err := a.Update(r0, data); !errors.Is(err, ErrInvalidParam) {
+		t.Fatalf("Atlas.Update: evicted region\nhave %v\nwant %v", err, ErrInvalidParam)
+	}
+
+	img := a.DebugImage(0)
+	if c := img.RGBAAt(0, 0); c.A != 255 || c.R != 255 {
+		t.Fatalf("Atlas.DebugImage: outline\nhave %v", c)
+	}
+	if c := img.RGBAAt(40, 40); c.A != 0 {
+		t.Fatalf("Atlas.DebugImage: padding\nhave %v", c)
+	}
+
+	a.FreeRegion(r2)
+	if a.Valid(r2) {
+		t.Fatal("Atlas.FreeRegion: region still valid")
+	}
+	a.param.OnExhaust = ExhaustError
+	if _, err := a.Alloc(60, 60); err != nil {
+		t.Fatalf("Atlas.Alloc: freed page should be reused\n%v", err)
+	}
+	if _, err := a.Alloc(60, 60); err != ErrExhausted {
+		t.Fatalf("Atlas.Alloc:\nhave %v\nwant %v", err, ErrExhausted)
+	}
+}
+
+func TestAddLightmap(t *testing.T) {
+	a, err := NewAtlas(&AtlasParam{PixelFmt: driver.RGBA8Unorm, Width: 32, Height: 32})
+	if err != nil {
+		t.Fatalf("NewAtlas failed:\n%v", err)
+	}
+	if _, err := AddLightmap(a, 4, 4, make([]float32, 48)); !errors.Is(err, ErrInvalidParam) {
+		t.Fatalf("AddLightmap: format\nhave %v\nwant %v", err, ErrInvalidParam)
+	}
+	a.Free()
+
+	a, err = NewAtlas(&AtlasParam{PixelFmt: driver.RGBA16Float, Width: 32, Height: 32, Packer: PackGuillotine})
+	if err != nil {
+		t.Fatalf("NewAtlas failed:\n%v", err)
+	}
+	defer a.Free()
+	if _, err := AddLightmap(a, 4, 4, make([]float32, 47)); !errors.Is(err, ErrInvalidParam) {
+		t.Fatalf("AddLightmap: size\nhave %v\nwant %v", err, ErrInvalidParam)
+	}
+	for range 4 {
+		if _, err := AddLightmap(a, 16, 16, make([]float32, 16*16*3)); err != nil {
+			t.Fatalf("AddLightmap failed:\n%v", err)
+		}
+	}
+	if a.Pages() != 1 {
+		t.Fatalf("AddLightmap: pages\nhave %d\nwant 1", a.Pages())
+	}
+	if err := a.Flush(); err != nil {
+		t.Fatalf("Atlas.Flush failed:\n%v", err)
+	}
+}
@@ -0,0 +1,429 @@
+// Copyright 2024 Gustavo C. Viegas. All rights reserved.
+
+package engine
+
+import (
+	"sync"
+
+	"gviegas/neo3/driver"
+	"gviegas/neo3/engine/internal/ctxt"
+	"gviegas/neo3/internal/suballoc"
+)
+
+const bufPrefix = "buffer: "
+
+func newBufErr(reason string) error { return newErr(bufPrefix, reason, ErrInvalidParam) }
+
+// copyToBuffer copies CPU data to buf, starting at
+// offset off.
+// buf need not be host visible, but it must have been
+// created with driver.UCopyDst usage.
+// Unless commit is true, the copy may be delayed. buf
+// must not be used by the GPU until the copy executes
+// (see commitBufStg).
+func copyToBuffer(buf driver.Buffer, off int64, data []byte, commit bool) error {
+	if len(data) == 0 {
+		return nil
+	}
+	s := <-bufStg
+	soff, err := s.stage(data)
+	if err == nil {
+		err = s.copyToBuffer(buf, off, soff, int64(len(data)))
+		if commit && err == nil {
+			err = s.commit()
+		}
+	}
+	bufStg <- s
+	return err
+}
+
+// copyFromBuffer copies buf's data, starting at
+// offset off, to a given CPU buffer.
+// It returns the number of bytes written to dst.
+// buf must have been created with driver.UCopySrc
+// usage. Copying stops at the end of buf.
+// It implicitly commits the staging buffer.
+func copyFromBuffer(buf driver.Buffer, off int64, dst []byte) (int, error) {
+	if x := buf.Cap() - off; x < int64(len(dst)) {
+		dst = dst[:max(x, 0)]
+	}
+	if len(dst) == 0 {
+		return 0, nil
+	}
+	s := <-bufStg
+	var n int
+	soff, err := s.reserve(len(dst))
+	if err == nil {
+		if err = s.copyFromBuffer(buf, off, soff, int64(len(dst))); err == nil {
+			if err = s.commit(); err == nil {
+				n = s.unstage(soff, dst)
+			}
+		}
+	}
+	bufStg <- s
+	return n, err
+}
+
+var (
+	// Global buffer staging buffer(s).
+	bufStg chan *bufStgBuffer
+	// Variables for commitBufStg calls.
+	bufStgMu    sync.Mutex
+	bufStgCache []*bufStgBuffer
+	bufStgWk    chan *driver.WorkItem
+)
+
+func init() { initBufStg() }
+
+// initBufStg initializes the global bufStgBuffers.
+func initBufStg() {
+	n := config.StagingBuffers
+	bufStg = make(chan *bufStgBuffer, n)
+	for i := 0; i < n; i++ {
+		s, err := newBufStg(bufStgBlock * bufStgNBit)
+		if err != nil {
+			s = &bufStgBuffer{}
+		}
+		bufStg <- s
+	}
+	bufStgCache = make([]*bufStgBuffer, 0, n)
+	bufStgWk = make(chan *driver.WorkItem, 1)
+	bufStgWk <- &driver.WorkItem{Work: make([]driver.CmdBuffer, 0, n), Background: true}
+}
+
+// freeBufStg destroys the global bufStgBuffers.
+// initBufStg must be called before the staging
+// buffers are used again.
+func freeBufStg() {
+	bufStgMu.Lock()
+	defer bufStgMu.Unlock()
+	for range cap(bufStg) {
+		(<-bufStg).free()
+	}
+	<-bufStgWk
+}
+
+// commitBufStg executes all pending buffer copies.
+// It blocks until execution completes.
+func commitBufStg() (err error) {
+	bufStgMu.Lock()
+	swk := <-bufStgWk
+
+	// As in commitTexStg, this deferral clears
+	// global state regardless of the outcome.
+	defer func() {
+		for _, x := range bufStgCache {
+			x.alloc.Reset()
+			x.pend = x.pend[:0]
+			bufStg <- x
+		}
+		bufStgCache = bufStgCache[:0]
+		swk.Work = swk.Work[:0]
+		bufStgWk <- swk
+		bufStgMu.Unlock()
+	}()
+
+	n := cap(bufStg)
+	for i := 0; i < n; i++ {
+		bufStgCache = append(bufStgCache, <-bufStg)
+	}
+
+	for i, x := range bufStgCache {
+		wk := <-x.wk
+		if !wk.Work[0].IsRecording() {
+			if len(x.pend) != 0 {
+				// This should never happen.
+				panic("commitBufStg: pending copies while not recording")
+			}
+		} else if err = wk.Work[0].End(); err != nil {
+			x.wk <- wk
+			for _, x := range swk.Work {
+				x.Reset()
+			}
+			for _, x := range bufStgCache[i+1:] {
+				wk := <-x.wk
+				wk.Work[0].Reset()
+				x.wk <- wk
+			}
+			return
+		} else {
+			swk.Work = append(swk.Work, wk.Work[0])
+		}
+		x.wk <- wk
+	}
+
+	if len(swk.Work) == 0 {
+		return
+	}
+	span := traceBegin(traceSubmit, "buffer copy")
+	if err = ctxt.GPU().Commit(swk, bufStgWk); err != nil {
+		return
+	}
+	swk = <-bufStgWk
+	span.endGPU()
+	err, swk.Err = swk.Err, nil
+	return
+}
+
+// bufStgBuffer is used to copy buffer data
+// between the CPU and the GPU.
+type bufStgBuffer struct {
+	wk    chan *driver.WorkItem
+	buf   driver.Buffer
+	alloc suballoc.Allocator
+	pend  []pendingBufCopy
+}
+
+// pendingBufCopy is used to track buffer
+// ranges that are written by a pending copy
+// operation.
+type pendingBufCopy struct {
+	buf  driver.Buffer
+	off  int64
+	size int64
+}
+
+// overlaps returns whether the given range of buf
+// overlaps p's range.
+func (p *pendingBufCopy) overlaps(buf driver.Buffer, off, size int64) bool {
+	return p.buf == buf && off < p.off+p.size && p.off < off+size
+}
+
+// Buffer uploads are usually much smaller than
+// image uploads, so use a smaller block size
+// than texStgBuffer's.
+// bufStgBlock is set by Init (Config.BufStagingBlock).
+var bufStgBlock = defaultConfig.BufStagingBlock
+
+const bufStgNBit = suballoc.Word
+
+// newBufStg creates a new bufStgBuffer with the
+// given size in bytes.
+// n must be greater than 0; it will be rounded up
+// to a multiple of bufStgBlock * bufStgNBit.
+func newBufStg(n int) (*bufStgBuffer, error) {
+	if n <= 0 {
+		panic("newBufStg: n <= 0")
+	}
+	cb, err := cmdBufs.get()
+	if err != nil {
+		return nil, err
+	}
+	wk := make(chan *driver.WorkItem, 1)
+	wk <- &driver.WorkItem{Work: []driver.CmdBuffer{cb}, Background: true}
+	n = (n + bufStgBlock*bufStgNBit - 1) &^ (bufStgBlock*bufStgNBit - 1)
+	buf, err := ctxt.GPU().NewBuffer(int64(n), true, driver.UCopySrc|driver.UCopyDst)
+	if err != nil {
+		cmdBufs.put(cb)
+		return nil, err
+	}
+	s := &bufStgBuffer{wk: wk, buf: buf}
+	s.alloc.Grow(n / bufStgBlock)
+	return s, nil
+}
+
+// begin begins recording s's command buffer if
+// it is not recording already.
+// The caller must own wk.
+func (s *bufStgBuffer) begin(wk *driver.WorkItem) (err error) {
+	if !wk.Work[0].IsRecording() {
+		if err = wk.Work[0].Begin(); err != nil {
+			s.alloc.Reset()
+		}
+	}
+	return
+}
+
+// sync records a barrier if the given range of buf
+// overlaps a range written by a pending copy.
+// The caller must own wk.
+func (s *bufStgBuffer) sync(wk *driver.WorkItem, buf driver.Buffer, off, size int64) {
+	for i := range s.pend {
+		if !s.pend[i].overlaps(buf, off, size) {
+			continue
+		}
+		wk.Work[0].Barrier([]driver.Barrier{{
+			SyncBefore:   driver.SCopy,
+			SyncAfter:    driver.SCopy,
+			AccessBefore: driver.ACopyWrite,
+			AccessAfter:  driver.ACopyRead | driver.ACopyWrite,
+		}})
+		// The barrier applies to every
+		// preceding copy.
+		s.pend = s.pend[:0]
+		return
+	}
+}
+
+// copyToBuffer records a copy command that copies
+// size bytes from s's buffer, starting at offset
+// soff, into buf at offset off.
+// soff must have been returned by a previous call
+// to s.reserve (i.e., it must be a multiple of
+// bufStgBlock).
+func (s *bufStgBuffer) copyToBuffer(buf driver.Buffer, off, soff, size int64) (err error) {
+	switch {
+	case off < 0 || off+size > buf.Cap():
+		return newBufErr("copy range out of bounds")
+	case soff+size > s.buf.Cap():
+		return newBufErr("not enough buffer capacity for copying")
+	}
+
+	wk := <-s.wk
+	if err = s.begin(wk); err != nil {
+		s.wk <- wk
+		return
+	}
+	s.sync(wk, buf, off, size)
+	wk.Work[0].CopyBuffer(&driver.BufferCopy{
+		From:    s.buf,
+		FromOff: soff,
+		To:      buf,
+		ToOff:   off,
+		Size:    size,
+	})
+	s.pend = append(s.pend, pendingBufCopy{buf, off, size})
+	s.wk <- wk
+	return
+}
+
+// copyFromBuffer records a copy command that copies
+// size bytes from buf, starting at offset off, into
+// s's buffer at offset soff.
+// soff must have been returned by a previous call
+// to s.reserve (i.e., it must be a multiple of
+// bufStgBlock).
+func (s *bufStgBuffer) copyFromBuffer(buf driver.Buffer, off, soff, size int64) (err error) {
+	switch {
+	case off < 0 || off+size > buf.Cap():
+		return newBufErr("copy range out of bounds")
+	case soff+size > s.buf.Cap():
+		return newBufErr("not enough buffer capacity for copying")
+	}
+
+	wk := <-s.wk
+	if err = s.begin(wk); err != nil {
+		s.wk <- wk
+		return
+	}
+	// Data staged in this command buffer must
+	// be visible to the copy-back.
+	s.sync(wk, buf, off, size)
+	wk.Work[0].CopyBuffer(&driver.BufferCopy{
+		From:    buf,
+		FromOff: off,
+		To:      s.buf,
+		ToOff:   soff,
+		Size:    size,
+	})
+	s.wk <- wk
+	return
+}
+
+// stage writes CPU data to s's buffer.
+// It may need to commit pending copy commands to
+// grow the buffer.
+// It returns an offset from the start of s.buf
+// identifying where data was copied to.
+func (s *bufStgBuffer) stage(data []byte) (off int64, err error) {
+	if off, err = s.reserve(len(data)); err == nil {
+		copy(s.buf.Bytes()[off:], data)
+	}
+	return
+}
+
+// unstage writes s.buf's data to dst.
+// off must have been returned by a previous call
+// to s.reserve (i.e., it must be a multiple of
+// bufStgBlock).
+// It returns the number of bytes written.
+// As with texStgBuffer.unstage, it should be called
+// right after the copy-back command is committed.
+func (s *bufStgBuffer) unstage(off int64, dst []byte) (n int) {
+	if off >= s.buf.Cap() {
+		return
+	}
+	if off%int64(bufStgBlock) != 0 {
+		panic("bufStgBuffer.unstage: misaligned off")
+	}
+	n = copy(dst, s.buf.Bytes()[off:])
+	ib := int(off) / bufStgBlock
+	nb := (n + bufStgBlock - 1) / bufStgBlock
+	s.alloc.Free(ib, nb)
+	return
+}
+
+// reserve reserves a contiguous range of n bytes
+// within s.buf.
+// It may need to commit pending copy commands to
+// grow the buffer.
+// It returns an offset from the start of s.buf
+// identifying where the range starts.
+func (s *bufStgBuffer) reserve(n int) (off int64, err error) {
+	if n <= 0 {
+		panic("bufStgBuffer.reserve: n <= 0")
+	}
+	n = (n + bufStgBlock - 1) / bufStgBlock
+	idx, ok := s.alloc.Alloc(n, 1)
+	if !ok {
+		if err = s.commit(); err != nil {
+			return
+		}
+		// Committing frees the whole buffer, but
+		// it is grown anyway since n blocks did
+		// not fit.
+		s.alloc.Grow(n)
+		sz := int64(s.alloc.Len()) * int64(bufStgBlock)
+		if s.buf != nil {
+			s.buf.Destroy()
+		}
+		if s.buf, err = ctxt.GPU().NewBuffer(sz, true, driver.UCopySrc|driver.UCopyDst); err != nil {
+			s.alloc.Release()
+			return
+		}
+		idx, _ = s.alloc.Alloc(n, 1)
+	}
+	off = int64(idx) * int64(bufStgBlock)
+	return
+}
+
+// commit commits the copy commands for execution.
+// It blocks until execution completes.
+func (s *bufStgBuffer) commit() (err error) {
+	wk := <-s.wk
+	if !wk.Work[0].IsRecording() {
+		if len(s.pend) != 0 {
+			// This should never happen.
+			panic("bufStgBuffer.commit: pending copies while not recording")
+		}
+		s.wk <- wk
+		return
+	}
+	s.alloc.Reset()
+	s.pend = s.pend[:0]
+	if err = wk.Work[0].End(); err != nil {
+		s.wk <- wk
+		return
+	}
+	if err = ctxt.GPU().Commit(wk, s.wk); err != nil {
+		s.wk <- wk
+		return
+	}
+	wk = <-s.wk
+	err, wk.Err = wk.Err, nil
+	s.wk <- wk
+	return
+}
+
+// free invalidates s and destroys the driver
+// resources.
+func (s *bufStgBuffer) free() {
+	if s.wk != nil {
+		wk := <-s.wk
+		cmdBufs.put(wk.Work[0])
+	}
+	if s.buf != nil {
+		s.buf.Destroy()
+	}
+	*s = bufStgBuffer{}
+}
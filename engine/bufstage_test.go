@@ -0,0 +1,105 @@
+// Copyright 2024 Gustavo C. Viegas. All rights reserved.
+
+package engine
+
+import (
+	"strings"
+	"testing"
+
+	"gviegas/neo3/driver"
+	"gviegas/neo3/engine/internal/ctxt"
+)
+
+func TestBufStgBuffer(t *testing.T) {
+	n := bufStgBlock * bufStgNBit
+
+	for _, x := range [...]struct{ n, nbuf int }{
+		{n, n},
+		{n - 1, n},
+		{n + 1, n * 2},
+		{1, n},
+		{n*3 - 1, n * 3},
+	} {
+		s, err := newBufStg(x.n)
+		if err != nil {
+			t.Fatalf("driver.NewBuffer failed:\n%v", err)
+		}
+		if y := int(s.buf.Cap()); y != x.nbuf {
+			t.Fatalf("newBufStg: buf.Cap\nhave %d\nwant %d", y, x.nbuf)
+		}
+		if y := s.alloc.Len(); y != x.nbuf/bufStgBlock {
+			t.Fatalf("newBufStg: alloc.Len\nhave %d\nwant %d", y, x.nbuf/bufStgBlock)
+		}
+		s.free()
+		if s.buf != nil || s.wk != nil || s.alloc.Len() != 0 {
+			t.Fatal("bufStgBuffer.free: unexpected non-zero value")
+		}
+	}
+}
+
+func TestBufStgInit(t *testing.T) {
+	var s []*bufStgBuffer
+	for range cap(bufStg) {
+		x := <-bufStg
+		s = append(s, x)
+		if x.wk == nil || x.buf == nil {
+			if x.wk != nil || x.buf != nil {
+				t.Fatal("bufStg: unexpected non-nil wk or buf")
+			}
+			continue
+		}
+		if x.buf.Cap() != int64(bufStgBlock*bufStgNBit) {
+			t.Fatalf("bufStg: buf.Cap:\nhave %d\nwant %d", x.buf.Cap(), bufStgBlock*bufStgNBit)
+		}
+	}
+	for i := range s {
+		bufStg <- s[i]
+	}
+}
+
+func TestBufStgCopy(t *testing.T) {
+	buf, err := ctxt.GPU().NewBuffer(int64(3*bufStgBlock), false, driver.UCopySrc|driver.UCopyDst)
+	if err != nil {
+		t.Fatalf("driver.NewBuffer failed:\n%v", err)
+	}
+	defer buf.Destroy()
+
+	a := []byte(strings.Repeat("a", bufStgBlock+100))
+	b := []byte(strings.Repeat("b", 200))
+	if err := copyToBuffer(buf, 0, a, false); err != nil {
+		t.Fatalf("copyToBuffer failed:\n%v", err)
+	}
+	// Overlaps the previous copy.
+	if err := copyToBuffer(buf, int64(bufStgBlock), b, false); err != nil {
+		t.Fatalf("copyToBuffer failed:\n%v", err)
+	}
+	if err := commitBufStg(); err != nil {
+		t.Fatalf("commitBufStg failed:\n%v", err)
+	}
+
+	dst := make([]byte, bufStgBlock+300)
+	n, err := copyFromBuffer(buf, 0, dst)
+	if err != nil {
+		t.Fatalf("copyFromBuffer failed:\n%v", err)
+	}
+	if n != len(dst) {
+		t.Fatalf("copyFromBuffer: n\nhave %d\nwant %d", n, len(dst))
+	}
+	want := append(a[:bufStgBlock:bufStgBlock], b...)
+	checkData(dst, want, t)
+
+	// Copying stops at the end of buf.
+	n, err = copyFromBuffer(buf, buf.Cap()-10, dst)
+	if err != nil || n != 10 {
+		t.Fatalf("copyFromBuffer:\nhave %d, %v\nwant 10, nil", n, err)
+	}
+
+	if err := copyToBuffer(buf, buf.Cap()-1, b, true); err == nil {
+		t.Fatal("copyToBuffer: unexpected nil error")
+	} else if !strings.HasPrefix(err.Error(), bufPrefix) {
+		t.Fatalf("copyToBuffer: unexpected error:\n%v", err)
+	}
+	if err := copyToBuffer(buf, -1, b, true); err == nil {
+		t.Fatal("copyToBuffer: unexpected nil error")
+	}
+}
@@ -0,0 +1,567 @@
+// Copyright 2024 Gustavo C. Viegas. All rights reserved.
+
+package engine
+
+import (
+	"cmp"
+	"iter"
+	"math"
+	"slices"
+
+	"gviegas/neo3/linear"
+	"gviegas/neo3/node"
+)
+
+const bvhPrefix = "bvh: "
+
+func newBVHErr(reason string) error { return newErr(bvhPrefix, reason, ErrInvalidParam) }
+
+// BVH parameters.
+const (
+	// Maximum number of items in a BVH leaf.
+	bvhLeafItems = 4
+	// Number of items that a scheduled build
+	// partitions per step. A single node with
+	// more items than this is still partitioned
+	// in one step.
+	bvhStepItems = 16384
+	// Factor by which refitting may grow the
+	// total surface area of the hierarchy before
+	// a rebuild is needed.
+	bvhMaxGrowth = 2
+	// Minimum number of items inserted or removed
+	// since the last build for a rebuild to be
+	// needed.
+	bvhMinChurn = 16
+)
+
+// BVHItem identifies an item in a BVH.
+type BVHItem int
+
+// BVHParam describes an item of a BVH.
+// Min and Max are the item's bounds in local space
+// (e.g., as returned by Mesh.Bounds).
+// If Node is not node.Nil, the item's world
+// transform is that of Node in the node.Graph passed
+// to BVH.Update. Otherwise, it is the identity until
+// set with BVH.SetWorld.
+type BVHParam struct {
+	Min, Max linear.V3
+	Node     node.Node
+}
+
+// BVH is a bounding volume hierarchy of world space
+// bounds, used to accelerate ray casting and frustum
+// culling of large scenes on the CPU.
+// It is meant for geometry that seldom moves. Items
+// that move are refitted in place, which is cheap but
+// degrades the hierarchy over time; NeedsRebuild
+// reports when this is the case, and the hierarchy
+// can then be rebuilt at once (Rebuild) or across
+// several frames (ScheduleRebuild).
+// Items inserted after the last build are kept apart
+// and tested linearly until the next one.
+// A BVH is not safe for concurrent use. In
+// particular, Scheduler.Run must not be called
+// concurrently with methods of a BVH that has a
+// scheduled rebuild.
+type BVH struct {
+	items dataMap[BVHItem, bvhItem]
+	nodes []bvhNode
+	// Items of the leaves of nodes. Removed items
+	// are replaced with -1.
+	order []BVHItem
+	// Items that are not in order.
+	loose []BVHItem
+	// Whether items in order have moved or have
+	// been removed since the last refit.
+	moved bool
+	// Total surface area of nodes as of the last
+	// build.
+	area float32
+	// Number of items inserted and removed since
+	// the last build.
+	churn int
+	// Sequence of the next item inserted.
+	seq uint64
+	// Pending scheduled build.
+	build *bvhBuild
+}
+
+// bvhItem is what a BVH stores.
+type bvhItem struct {
+	node     node.Node
+	min, max linear.V3
+	world    linear.M4
+	// Bounds in world space.
+	wmin, wmax linear.V3
+	// Index in BVH.order, or -1 if the item is
+	// loose.
+	pos int
+	// Identifies the item across removals, since
+	// BVHItem values are reused.
+	seq uint64
+}
+
+// bound computes the world bounds of it.
+func (it *bvhItem) bound() {
+	var c, e linear.V3
+	c.Add(&it.min, &it.max)
+	c.Scale(0.5, &c)
+	e.Sub(&it.max, &c)
+	var wc, we linear.V3
+	for i := range wc {
+		wc[i] = it.world[3][i]
+		for j := range c {
+			wc[i] += it.world[j][i] * c[j]
+			we[i] += float32(math.Abs(float64(it.world[j][i]))) * e[j]
+		}
+	}
+	it.wmin.Sub(&wc, &we)
+	it.wmax.Add(&wc, &we)
+}
+
+// NewBVH creates a new, empty BVH.
+func NewBVH() *BVH { return new(BVH) }
+
+// Insert inserts a new item into b.
+func (b *BVH) Insert(param *BVHParam) (BVHItem, error) {
+	for i := range 3 {
+		if !(param.Min[i] <= param.Max[i]) {
+			return -1, newBVHErr("invalid item bounds")
+		}
+	}
+	it := bvhItem{
+		node:  param.Node,
+		min:   param.Min,
+		max:   param.Max,
+		world: linear.I4(),
+		pos:   -1,
+		seq:   b.seq,
+	}
+	b.seq++
+	it.bound()
+	id := b.items.insert(it)
+	b.loose = append(b.loose, id)
+	b.churn++
+	return id, nil
+}
+
+// Remove removes an item from b.
+func (b *BVH) Remove(item BVHItem) {
+	it := b.items.remove(item)
+	if it.pos < 0 {
+		i := slices.Index(b.loose, item)
+		b.loose = slices.Delete(b.loose, i, i+1)
+	} else {
+		b.order[it.pos] = -1
+		b.moved = true
+	}
+	b.churn++
+}
+
+// SetWorld sets the world transform of an item.
+// It must not be called for items that have a node,
+// since BVH.Update would override it.
+func (b *BVH) SetWorld(item BVHItem, world *linear.M4) {
+	it := b.items.get(item)
+	if it.world == *world {
+		return
+	}
+	it.world = *world
+	it.bound()
+	b.moved = b.moved || it.pos >= 0
+}
+
+// Bounds returns the world space bounds of an item,
+// as of the last update.
+func (b *BVH) Bounds(item BVHItem) (min, max linear.V3) {
+	it := b.items.get(item)
+	return it.wmin, it.wmax
+}
+
+// Len returns the number of items in b.
+func (b *BVH) Len() int { return b.items.len() }
+
+// Update updates the world transforms of the items
+// that have a node in g, refitting the hierarchy as
+// needed.
+// g must be up to date (see node.Graph.Update).
+func (b *BVH) Update(g *node.Graph) {
+	defer traceBegin(traceUpdate, "bvh").end()
+	for _, it := range b.items.all() {
+		if it.node == node.Nil {
+			continue
+		}
+		w := g.World(it.node)
+		if it.world == *w {
+			continue
+		}
+		it.world = *w
+		it.bound()
+		b.moved = b.moved || it.pos >= 0
+	}
+	b.refit()
+}
+
+// refit recomputes the bounds of b's nodes if items
+// have moved.
+func (b *BVH) refit() {
+	if !b.moved {
+		return
+	}
+	b.moved = false
+	// Children are stored after their parents.
+	for i := len(b.nodes) - 1; i >= 0; i-- {
+		nd := &b.nodes[i]
+		nd.min, nd.max = emptyBounds()
+		if nd.n == 0 {
+			for _, c := range [2]*bvhNode{&b.nodes[i+1], &b.nodes[nd.first]} {
+				growBounds(&nd.min, &nd.max, &c.min, &c.max)
+			}
+			continue
+		}
+		for _, id := range b.order[nd.first : nd.first+nd.n] {
+			if id >= 0 {
+				it := b.items.get(id)
+				growBounds(&nd.min, &nd.max, &it.wmin, &it.wmax)
+			}
+		}
+	}
+}
+
+// NeedsRebuild returns whether b should be rebuilt,
+// either because refitting has degraded its quality
+// or because many items were inserted or removed
+// since the last build.
+func (b *BVH) NeedsRebuild() bool {
+	if b.churn >= max(bvhMinChurn, len(b.order)/4) {
+		return true
+	}
+	b.refit()
+	area := surfaceArea(b.nodes)
+	return area > bvhMaxGrowth*b.area && area > 1e-6
+}
+
+// Rebuild rebuilds b at once.
+// Any scheduled rebuild is superseded.
+func (b *BVH) Rebuild() {
+	defer traceBegin(traceUpdate, "bvh rebuild").end()
+	c := b.newBuild()
+	c.step(math.MaxInt)
+	b.install(c)
+}
+
+// ScheduleRebuild schedules a rebuild of b in s.
+// The hierarchy is built from the items' bounds as of
+// this call, over as many steps as needed, and then
+// replaces the current one; until then, b can be used
+// as usual. Items inserted, moved or removed in the
+// meantime are accounted for when the new hierarchy
+// is installed.
+// If a rebuild is already pending in s, its Task is
+// returned. A rebuild scheduled in a different
+// Scheduler is superseded.
+func (b *BVH) ScheduleRebuild(s *Scheduler, prio int) (Task, error) {
+	if c := b.build; c != nil && c.s == s && s.Pending(c.task) {
+		return c.task, nil
+	}
+	c := b.newBuild()
+	c.s = s
+	task, err := s.Schedule(prio, func() bool {
+		if b.build != c {
+			// Superseded.
+			return true
+		}
+		if !c.step(bvhStepItems) {
+			return false
+		}
+		b.install(c)
+		return true
+	})
+	if err != nil {
+		b.build = nil
+		return -1, err
+	}
+	c.task = task
+	return task, nil
+}
+
+// bvhBuild is a BVH build in progress.
+type bvhBuild struct {
+	refs  []bvhRef
+	nodes []bvhNode
+	jobs  []bvhJob
+	s     *Scheduler
+	task  Task
+}
+
+// bvhRef is an item being built.
+type bvhRef struct {
+	id       BVHItem
+	seq      uint64
+	min, max linear.V3
+	// Sum of min and max.
+	c linear.V3
+}
+
+// bvhJob is a node to be built from refs[first:first+n].
+// parent is the index of the node whose right child
+// it is, or -1.
+type bvhJob struct {
+	first, n, parent int
+}
+
+// newBuild creates a build of every item in b and
+// makes it the pending build.
+func (b *BVH) newBuild() *bvhBuild {
+	c := &bvhBuild{refs: make([]bvhRef, 0, b.items.len())}
+	for id, it := range b.items.all() {
+		r := bvhRef{id: id, seq: it.seq, min: it.wmin, max: it.wmax}
+		r.c.Add(&r.min, &r.max)
+		c.refs = append(c.refs, r)
+	}
+	if len(c.refs) > 0 {
+		c.jobs = append(c.jobs, bvhJob{0, len(c.refs), -1})
+	}
+	b.build = c
+	return c
+}
+
+// step builds nodes until items items have been
+// partitioned or the build is complete.
+// It returns whether the build is complete.
+func (c *bvhBuild) step(items int) bool {
+	for len(c.jobs) > 0 && items > 0 {
+		j := c.jobs[len(c.jobs)-1]
+		c.jobs = c.jobs[:len(c.jobs)-1]
+		items -= j.n
+		i := len(c.nodes)
+		if j.parent >= 0 {
+			c.nodes[j.parent].first = i
+		}
+		nd := bvhNode{}
+		nd.min, nd.max = emptyBounds()
+		cmin, cmax := nd.min, nd.max
+		refs := c.refs[j.first : j.first+j.n]
+		for k := range refs {
+			growBounds(&nd.min, &nd.max, &refs[k].min, &refs[k].max)
+			growBounds(&cmin, &cmax, &refs[k].c, &refs[k].c)
+		}
+		if j.n <= bvhLeafItems {
+			nd.first, nd.n = j.first, j.n
+			c.nodes = append(c.nodes, nd)
+			continue
+		}
+		c.nodes = append(c.nodes, nd)
+		axis := 0
+		for k := 1; k < 3; k++ {
+			if cmax[k]-cmin[k] > cmax[axis]-cmin[axis] {
+				axis = k
+			}
+		}
+		slices.SortFunc(refs, func(x, y bvhRef) int { return cmp.Compare(x.c[axis], y.c[axis]) })
+		// The left child must be built next so
+		// that it follows its parent.
+		half := j.n / 2
+		c.jobs = append(c.jobs, bvhJob{j.first + half, j.n - half, i}, bvhJob{j.first, half, -1})
+	}
+	return len(c.jobs) == 0
+}
+
+// install replaces b's hierarchy with the one built
+// by c.
+func (b *BVH) install(c *bvhBuild) {
+	b.build = nil
+	for _, it := range b.items.all() {
+		it.pos = -1
+	}
+	b.loose = b.loose[:0]
+	b.order = slices.Grow(b.order[:0], len(c.refs))
+	for _, r := range c.refs {
+		// Items removed during the build leave a
+		// hole, even if their BVHItem was reused.
+		if !b.items.idMap.IsSet(int(r.id)) || b.items.get(r.id).seq != r.seq {
+			b.order = append(b.order, -1)
+			continue
+		}
+		b.items.get(r.id).pos = len(b.order)
+		b.order = append(b.order, r.id)
+	}
+	// Items inserted during the build are loose.
+	for id, it := range b.items.all() {
+		if it.pos < 0 {
+			b.loose = append(b.loose, id)
+		}
+	}
+	b.nodes = c.nodes
+	b.churn = 0
+	// Items may have moved since the build started.
+	b.moved = true
+	b.refit()
+	b.area = surfaceArea(b.nodes)
+}
+
+// Cast casts ray against the items of b and returns
+// the closest item hit within tmax, along with the
+// distance along the ray.
+// If test is nil, items are hit where the ray enters
+// their world bounds. Otherwise, test is called for
+// every item whose bounds the ray intersects before
+// the closest hit found so far, and must return the
+// distance at which the item is actually hit, if it
+// is (e.g., by testing the item's triangles).
+func (b *BVH) Cast(ray *Ray, tmax float32, test func(item BVHItem) (float32, bool)) (item BVHItem, t float32, ok bool) {
+	b.refit()
+	item = -1
+	var inv linear.V3
+	for i := range inv {
+		inv[i] = 1 / ray.Dir[i]
+	}
+	hit := func(id BVHItem) {
+		it := b.items.get(id)
+		d, ok := rayBox(&ray.Origin, &inv, &it.wmin, &it.wmax, tmax)
+		if !ok {
+			return
+		}
+		if test != nil {
+			if d, ok = test(id); !ok || d >= tmax {
+				return
+			}
+		}
+		item, t, tmax = id, d, d
+	}
+	for _, id := range b.loose {
+		hit(id)
+	}
+	if len(b.nodes) > 0 {
+		var stk [64]int
+		sp := 1
+		for sp > 0 {
+			sp--
+			i := stk[sp]
+			nd := &b.nodes[i]
+			if !nd.hit(&ray.Origin, &inv, tmax) {
+				continue
+			}
+			if nd.n == 0 {
+				stk[sp], stk[sp+1] = nd.first, i+1
+				sp += 2
+				continue
+			}
+			for _, id := range b.order[nd.first : nd.first+nd.n] {
+				if id >= 0 {
+					hit(id)
+				}
+			}
+		}
+	}
+	return item, t, item >= 0
+}
+
+// Cull returns an iterator over the items of b whose
+// world bounds intersect the frustum of the given
+// view-projection transform.
+// It is conservative, so it may yield items that are
+// outside of the frustum.
+func (b *BVH) Cull(viewProj *linear.M4) iter.Seq[BVHItem] {
+	return func(yield func(BVHItem) bool) {
+		b.refit()
+		var fr frustum
+		fr.set(viewProj)
+		ident := linear.I4()
+		for _, id := range b.loose {
+			it := b.items.get(id)
+			if !fr.cull(&ident, &it.wmin, &it.wmax) && !yield(id) {
+				return
+			}
+		}
+		if len(b.nodes) == 0 {
+			return
+		}
+		var stk [64]int
+		sp := 1
+		for sp > 0 {
+			sp--
+			i := stk[sp]
+			nd := &b.nodes[i]
+			if fr.cull(&ident, &nd.min, &nd.max) {
+				continue
+			}
+			if nd.n == 0 {
+				stk[sp], stk[sp+1] = nd.first, i+1
+				sp += 2
+				continue
+			}
+			for _, id := range b.order[nd.first : nd.first+nd.n] {
+				if id < 0 {
+					continue
+				}
+				it := b.items.get(id)
+				if !fr.cull(&ident, &it.wmin, &it.wmax) && !yield(id) {
+					return
+				}
+			}
+		}
+	}
+}
+
+// rayBox returns the distance at which the ray from o
+// with inverse direction inv enters the bounds
+// [lo, hi], if it does so within tmax.
+func rayBox(o, inv, lo, hi *linear.V3, tmax float32) (float32, bool) {
+	t0, t1 := float32(0), tmax
+	for i := range 3 {
+		if math.IsInf(float64(inv[i]), 0) {
+			// The ray is parallel to the slab.
+			// Computing its distances could
+			// produce NaNs (0 * Inf).
+			if o[i] < lo[i] || o[i] > hi[i] {
+				return 0, false
+			}
+			continue
+		}
+		a := (lo[i] - o[i]) * inv[i]
+		b := (hi[i] - o[i]) * inv[i]
+		if a > b {
+			a, b = b, a
+		}
+		t0 = max(t0, a)
+		t1 = min(t1, b)
+		if t0 > t1 {
+			return 0, false
+		}
+	}
+	return t0, true
+}
+
+// emptyBounds returns bounds that contain nothing.
+func emptyBounds() (min, max linear.V3) {
+	inf := float32(math.Inf(1))
+	return linear.V3{inf, inf, inf}, linear.V3{-inf, -inf, -inf}
+}
+
+// growBounds grows [min, max] to contain [bmin, bmax].
+func growBounds(min, max, bmin, bmax *linear.V3) {
+	for i := range 3 {
+		if bmin[i] < min[i] {
+			min[i] = bmin[i]
+		}
+		if bmax[i] > max[i] {
+			max[i] = bmax[i]
+		}
+	}
+}
+
+// surfaceArea returns the sum of the surface areas
+// of nodes.
+func surfaceArea(nodes []bvhNode) (area float32) {
+	for i := range nodes {
+		var e linear.V3
+		e.Sub(&nodes[i].max, &nodes[i].min)
+		if e[0] >= 0 && e[1] >= 0 && e[2] >= 0 {
+			area += 2 * (e[0]*e[1] + e[1]*e[2] + e[2]*e[0])
+		}
+	}
+	return
+}
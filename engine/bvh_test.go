@@ -0,0 +1,287 @@
+// Copyright 2024 Gustavo C. Viegas. All rights reserved.
+
+package engine
+
+import (
+	"math/rand"
+	"slices"
+	"testing"
+	"time"
+
+	"gviegas/neo3/linear"
+	"gviegas/neo3/node"
+)
+
+// bvhBox is the local bounds of the items of the
+// tests.
+var bvhBox = BVHParam{Min: linear.V3{-0.5, -0.5, -0.5}, Max: linear.V3{0.5, 0.5, 0.5}}
+
+// newTestBVH creates a BVH with n unit boxes at random
+// positions in [-50, 50]³.
+func newTestBVH(t *testing.T, rng *rand.Rand, n int) (*BVH, []BVHItem) {
+	b := NewBVH()
+	items := make([]BVHItem, n)
+	for i := range items {
+		var err error
+		if items[i], err = b.Insert(&bvhBox); err != nil {
+			t.Fatalf("BVH.Insert failed:\n%v", err)
+		}
+		w := translate(rng.Float32()*100-50, rng.Float32()*100-50, rng.Float32()*100-50)
+		b.SetWorld(items[i], &w)
+	}
+	return b, items
+}
+
+// castLinear is the expected result of b.Cast(ray,
+// tmax, nil).
+func castLinear(b *BVH, ray *Ray, tmax float32) (BVHItem, float32, bool) {
+	var inv linear.V3
+	for i := range inv {
+		inv[i] = 1 / ray.Dir[i]
+	}
+	item := BVHItem(-1)
+	var t float32
+	for id, it := range b.items.all() {
+		if d, ok := rayBox(&ray.Origin, &inv, &it.wmin, &it.wmax, tmax); ok {
+			item, t, tmax = id, d, d
+		}
+	}
+	return item, t, item >= 0
+}
+
+// checkCast checks that b.Cast agrees with
+// castLinear for random rays.
+func checkCast(t *testing.T, rng *rand.Rand, b *BVH) {
+	t.Helper()
+	for range 200 {
+		ray := Ray{Origin: linear.V3{rng.Float32()*120 - 60, rng.Float32()*120 - 60, rng.Float32()*120 - 60}}
+		ray.Dir = linear.V3{rng.Float32()*2 - 1, rng.Float32()*2 - 1, rng.Float32()*2 - 1}
+		ray.Dir.Norm(&ray.Dir)
+		hi, ht, hok := b.Cast(&ray, 200, nil)
+		wi, wt, wok := castLinear(b, &ray, 200)
+		if hok != wok || hok && (hi != wi || ht != wt) {
+			t.Fatalf("BVH.Cast:\nhave %v, %v, %v\nwant %v, %v, %v", hi, ht, hok, wi, wt, wok)
+		}
+	}
+}
+
+// checkCull checks that b.Cull yields exactly the
+// items whose bounds are not culled by viewProj.
+func checkCull(t *testing.T, b *BVH, viewProj *linear.M4) {
+	t.Helper()
+	var fr frustum
+	fr.set(viewProj)
+	ident := linear.I4()
+	var want []BVHItem
+	for id, it := range b.items.all() {
+		if !fr.cull(&ident, &it.wmin, &it.wmax) {
+			want = append(want, id)
+		}
+	}
+	have := slices.Collect(b.Cull(viewProj))
+	slices.Sort(have)
+	slices.Sort(want)
+	if !slices.Equal(have, want) {
+		t.Fatalf("BVH.Cull:\nhave %v\nwant %v", have, want)
+	}
+}
+
+func TestBVH(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	b, items := newTestBVH(t, rng, 1000)
+	if _, err := b.Insert(&BVHParam{Min: linear.V3{1, 0, 0}}); err == nil {
+		t.Fatal("BVH.Insert: unexpected success")
+	}
+	if n := b.Len(); n != len(items) {
+		t.Fatalf("BVH.Len:\nhave %d\nwant %d", n, len(items))
+	}
+	if !b.NeedsRebuild() {
+		t.Fatal("BVH.NeedsRebuild:\nhave false\nwant true")
+	}
+	// Loose items are tested linearly.
+	checkCast(t, rng, b)
+	b.Rebuild()
+	if len(b.loose) != 0 || len(b.nodes) == 0 {
+		t.Fatalf("BVH.Rebuild: %d loose items, %d nodes", len(b.loose), len(b.nodes))
+	}
+	if b.NeedsRebuild() {
+		t.Fatal("BVH.NeedsRebuild:\nhave true\nwant false")
+	}
+	checkCast(t, rng, b)
+
+	var proj, view, vp linear.M4
+	proj.Perspective(1, 16.0/9.0, 0.1, 60)
+	view.LookAt(&linear.V3{0, 0, 0}, &linear.V3{0, 0, -1}, &linear.V3{0, 1, 0})
+	vp.Mul(&proj, &view)
+	checkCull(t, b, &vp)
+
+	// Closest hit along +X.
+	w := translate(-60, 0, 0)
+	b.SetWorld(items[0], &w)
+	w = translate(-70, 0, 0)
+	b.SetWorld(items[1], &w)
+	ray := Ray{Origin: linear.V3{-80, 0, 0}, Dir: linear.V3{1, 0, 0}}
+	if item, d, ok := b.Cast(&ray, 100, nil); !ok || item != items[1] || d != 9.5 {
+		t.Fatalf("BVH.Cast:\nhave %v, %v, %v\nwant %v, 9.5, true", item, d, ok, items[1])
+	}
+	// A test that rejects items[1].
+	if item, _, ok := b.Cast(&ray, 100, func(item BVHItem) (float32, bool) {
+		if item == items[1] {
+			return 0, false
+		}
+		return 42, true
+	}); !ok || item != items[0] {
+		t.Fatalf("BVH.Cast:\nhave %v, %v\nwant %v, true", item, ok, items[0])
+	}
+	b.Remove(items[1])
+	if item, _, ok := b.Cast(&ray, 100, nil); !ok || item != items[0] {
+		t.Fatalf("BVH.Cast:\nhave %v, %v\nwant %v, true", item, ok, items[0])
+	}
+	items = slices.Delete(items, 1, 2)
+
+	// Moving items far away degrades the
+	// hierarchy.
+	for _, id := range items[:len(items)/2] {
+		w := translate(rng.Float32()*1000-500, rng.Float32()*1000-500, rng.Float32()*1000-500)
+		b.SetWorld(id, &w)
+	}
+	checkCast(t, rng, b)
+	checkCull(t, b, &vp)
+	if !b.NeedsRebuild() {
+		t.Fatal("BVH.NeedsRebuild:\nhave false\nwant true")
+	}
+	b.Rebuild()
+	checkCast(t, rng, b)
+	checkCull(t, b, &vp)
+}
+
+func TestRayBox(t *testing.T) {
+	lo, hi := bvhBox.Min, bvhBox.Max
+	for _, x := range [...]struct {
+		o, dir linear.V3
+		t      float32
+		ok     bool
+	}{
+		// Origins on slab planes of the axes that
+		// the direction has no component along.
+		{linear.V3{0.5, 0, -5}, linear.V3{0, 0, 1}, 4.5, true},
+		{linear.V3{-0.5, 0.5, 5}, linear.V3{0, 0, -1}, 4.5, true},
+		{linear.V3{0, -0.5, -0.5}, linear.V3{-1, 0, 0}, 0, true},
+		{linear.V3{0.5, 0.5, 0.5}, linear.V3{0, 1, 0}, 0, true},
+		{linear.V3{0.5, -3, 0}, linear.V3{0, 1, 0}, 2.5, true},
+		{linear.V3{0.6, 0, -5}, linear.V3{0, 0, 1}, 0, false},
+		{linear.V3{0, -0.6, 5}, linear.V3{0, 0, -1}, 0, false},
+		{linear.V3{0.5, 0, 5}, linear.V3{0, 0, 1}, 0, false},
+	} {
+		var inv linear.V3
+		for i := range inv {
+			inv[i] = 1 / x.dir[i]
+		}
+		if d, ok := rayBox(&x.o, &inv, &lo, &hi, 100); ok != x.ok || ok && d != x.t {
+			t.Fatalf("rayBox(%v, %v):\nhave %v, %v\nwant %v, %v", x.o, x.dir, d, ok, x.t, x.ok)
+		}
+		nd := bvhNode{min: lo, max: hi}
+		if ok := nd.hit(&x.o, &inv, 100); ok != x.ok {
+			t.Fatalf("bvhNode.hit(%v, %v):\nhave %t\nwant %t", x.o, x.dir, ok, x.ok)
+		}
+	}
+}
+
+func TestBVHUpdate(t *testing.T) {
+	var g node.Graph
+	nd := &staticNode{local: translate(10, 0, 0)}
+	n := g.Insert(nd, node.Nil)
+	b := NewBVH()
+	param := bvhBox
+	param.Node = n
+	item, err := b.Insert(&param)
+	if err != nil {
+		t.Fatalf("BVH.Insert failed:\n%v", err)
+	}
+	if _, err := b.Insert(&bvhBox); err != nil {
+		t.Fatalf("BVH.Insert failed:\n%v", err)
+	}
+	b.Rebuild()
+	for _, x := range [...]float32{10, -10} {
+		nd.local, nd.done = translate(x, 0, 0), false
+		g.Update()
+		b.Update(&g)
+		if min, max := b.Bounds(item); min[0] != x-0.5 || max[0] != x+0.5 {
+			t.Fatalf("BVH.Bounds:\nhave %v, %v\nwant x in [%v, %v]", min, max, x-0.5, x+0.5)
+		}
+		ray := Ray{Origin: linear.V3{x, 0, 20}, Dir: linear.V3{0, 0, -1}}
+		if have, _, ok := b.Cast(&ray, 100, nil); !ok || have != item {
+			t.Fatalf("BVH.Cast:\nhave %v, %v\nwant %v, true", have, ok, item)
+		}
+	}
+}
+
+func TestBVHSchedule(t *testing.T) {
+	rng := rand.New(rand.NewSource(2))
+	b, items := newTestBVH(t, rng, 3*bvhStepItems)
+	b.Rebuild()
+	s, c := newTestSched(t, time.Millisecond)
+	// Every step spends the whole budget.
+	s.now = func() time.Time {
+		c.t = c.t.Add(time.Millisecond)
+		return c.t
+	}
+	task, err := b.ScheduleRebuild(s, 0)
+	if err != nil {
+		t.Fatalf("BVH.ScheduleRebuild failed:\n%v", err)
+	}
+	if x, _ := b.ScheduleRebuild(s, 0); x != task {
+		t.Fatalf("BVH.ScheduleRebuild:\nhave %v\nwant %v", x, task)
+	}
+	// Changes made during the build.
+	b.Remove(items[0])
+	w := translate(1000, 0, 0)
+	b.SetWorld(items[1], &w)
+	added, err := b.Insert(&bvhBox)
+	if err != nil {
+		t.Fatalf("BVH.Insert failed:\n%v", err)
+	}
+	w = translate(0, 1000, 0)
+	b.SetWorld(added, &w)
+
+	steps := 0
+	for s.Pending(task) {
+		if steps += s.Run(); steps == 1 {
+			checkCast(t, rng, b)
+		}
+	}
+	checkCast(t, rng, b)
+	if steps < 2 {
+		t.Fatalf("BVH.ScheduleRebuild: built in %d step(s)", steps)
+	}
+	if len(b.loose) != 1 || b.loose[0] != added {
+		t.Fatalf("BVH.ScheduleRebuild: loose items\nhave %v\nwant [%v]", b.loose, added)
+	}
+	for _, x := range [...]struct {
+		org  linear.V3
+		item BVHItem
+	}{
+		{linear.V3{1000, 0, 10}, items[1]},
+		{linear.V3{0, 1000, 10}, added},
+	} {
+		ray := Ray{Origin: x.org, Dir: linear.V3{0, 0, -1}}
+		if have, _, ok := b.Cast(&ray, 100, nil); !ok || have != x.item {
+			t.Fatalf("BVH.Cast:\nhave %v, %v\nwant %v, true", have, ok, x.item)
+		}
+	}
+
+	// Rebuild supersedes scheduled builds.
+	task, err = b.ScheduleRebuild(s, 0)
+	if err != nil {
+		t.Fatalf("BVH.ScheduleRebuild failed:\n%v", err)
+	}
+	b.Rebuild()
+	nodes := b.nodes
+	for s.Pending(task) {
+		s.Run()
+	}
+	if &b.nodes[0] != &nodes[0] {
+		t.Fatal("BVH.Rebuild: scheduled build was installed")
+	}
+	checkCast(t, rng, b)
+}
@@ -0,0 +1,281 @@
+// Copyright 2024 Gustavo C. Viegas. All rights reserved.
+
+package engine
+
+import (
+	"math"
+
+	"gviegas/neo3/driver"
+	"gviegas/neo3/engine/internal/shader"
+)
+
+// CameraParam describes a physically based camera.
+// Aperture is the f-number (e.g., 2.8 for f/2.8),
+// Shutter is the exposure time in seconds and ISO is
+// the sensor sensitivity. Together they determine the
+// exposure of the image, as in EV100.
+// FocalLength and SensorHeight are in millimeters.
+// SensorHeight defaults to 24 (i.e., full frame) if
+// zero. The vertical field of view that matches them
+// is given by CameraParam.FOV.
+// If DoF is true, depth of field is simulated, with
+// FocusDistance being the distance, in world units
+// (assumed to be meters), to the plane in focus.
+// MaxCoC limits the diameter, in pixels, of the
+// circle of confusion. It defaults to 16 if zero.
+type CameraParam struct {
+	Aperture      float32
+	Shutter       float32
+	ISO           float32
+	FocalLength   float32
+	SensorHeight  float32
+	DoF           bool
+	FocusDistance float32
+	MaxCoC        float32
+}
+
+// Default camera parameters.
+const (
+	defSensorHeight = 24
+	defMaxCoC       = 16
+)
+
+// EV100 returns the exposure value, at 100 ISO, that
+// corresponds to p's settings.
+func (p *CameraParam) EV100() float32 {
+	n := float64(p.Aperture)
+	return float32(math.Log2(n * n / float64(p.Shutter) * 100 / float64(p.ISO)))
+}
+
+// FOV returns the vertical field of view, in radians,
+// of p's lens and sensor.
+func (p *CameraParam) FOV() float32 {
+	h := p.SensorHeight
+	if h == 0 {
+		h = defSensorHeight
+	}
+	return float32(2 * math.Atan(float64(h/(2*p.FocalLength))))
+}
+
+// camera is the state of the physical camera.
+type camera struct {
+	param  CameraParam
+	layout shader.CameraLayout
+	// CoC and blurred color.
+	// They are nil if DoF is disabled.
+	coc  *Texture
+	blur *Texture
+}
+
+// Names of the DoF passes.
+const (
+	dofCoCPass       = "dof.coc"
+	dofBlurPass      = "dof.blur"
+	dofCompositePass = "dof.composite"
+)
+
+// SetCamera sets the physical camera of r.
+// If param is nil, the physical camera is removed,
+// which disables depth of field.
+// The camera's exposure is used unless automatic
+// exposure is enabled, in which case only depth of
+// field is affected.
+// Depth of field runs at the start of the post stage,
+// so it operates on the resolved, lit image (i.e.,
+// after any temporal resolve), and other
+// post-processing passes (e.g., bloom) apply to the
+// defocused result.
+// Enabling depth of field fails with ErrUnsupported
+// while the DoF passes are not recorded.
+func (r *Renderer) SetCamera(param *CameraParam) error {
+	if param == nil {
+		r.freeDoF()
+		r.cam = nil
+		return nil
+	}
+	var reason string
+	switch {
+	case !(param.Aperture > 0):
+		reason = "invalid camera aperture"
+	case !(param.Shutter > 0):
+		reason = "invalid camera shutter speed"
+	case !(param.ISO > 0):
+		reason = "invalid camera ISO"
+	case !(param.FocalLength > 0):
+		reason = "invalid camera focal length"
+	case param.SensorHeight < 0:
+		reason = "negative camera sensor height"
+	case param.MaxCoC < 0:
+		reason = "negative camera CoC limit"
+	case param.DoF && !(param.FocusDistance > param.FocalLength/1000):
+		reason = "camera focus distance within focal length"
+	default:
+		goto validParam
+	}
+	return newRendErr(reason)
+validParam:
+	if param.DoF {
+		if err := checkPending("depth of field", dofCoCPass, dofBlurPass, dofCompositePass); err != nil {
+			return err
+		}
+	}
+	if r.cam == nil {
+		r.cam = new(camera)
+	}
+	c := r.cam
+	if param.DoF && c.coc == nil {
+		if err := r.initDoF(); err != nil {
+			return err
+		}
+	} else if !param.DoF {
+		r.freeDoF()
+	}
+	c.param = *param
+	c.layout.SetExposure(evToExposure(param.EV100()))
+	maxCoC := param.MaxCoC
+	if maxCoC == 0 {
+		maxCoC = defMaxCoC
+	}
+	c.layout.SetCoC(c.cocScale(r.hdr.Height()), param.FocusDistance, maxCoC)
+	return nil
+}
+
+// Camera returns the physical camera parameters of r.
+// If no camera was set, it returns false.
+func (r *Renderer) Camera() (CameraParam, bool) {
+	if r.cam == nil {
+		return CameraParam{}, false
+	}
+	return r.cam.param, true
+}
+
+// cocScale computes the scale of the circle of
+// confusion, in pixels, for an image of the given
+// height.
+// The CoC diameter on the sensor of a point at
+// distance z is
+//
+//	f² / (N * (S - f)) * |1 - S/z|
+//
+// for focal length f, f-number N and focus distance S.
+func (c *camera) cocScale(height int) float32 {
+	if !c.param.DoF {
+		return 0
+	}
+	f := float64(c.param.FocalLength) / 1000
+	s := float64(c.param.FocusDistance)
+	n := float64(c.param.Aperture)
+	h := float64(c.param.SensorHeight)
+	if h == 0 {
+		h = defSensorHeight
+	}
+	k := f * f / (n * (s - f))
+	return float32(k / (h / 1000) * float64(height))
+}
+
+// cocAt computes the signed CoC diameter, in pixels, of
+// a point at view depth z.
+// It must match the dofCoC function in dof_0.
+func (c *camera) cocAt(z float32) float32 {
+	scale, focus, maxCoC := c.layout.CoC()
+	x := scale * (1 - focus/max(z, 1e-4))
+	return min(max(x, -maxCoC), maxCoC)
+}
+
+// initDoF creates the DoF targets and adds the DoF
+// passes to r's frame graph.
+// r.cam must not be nil.
+func (r *Renderer) initDoF() (err error) {
+	if err = r.sampleableDepth(); err != nil {
+		return
+	}
+	c := r.cam
+	defer func() {
+		if err != nil {
+			c.freeTargets()
+		}
+	}()
+	size := driver.Dim3D{Width: r.hdr.Width(), Height: r.hdr.Height()}
+	c.coc, err = NewTarget(&TexParam{
+		PixelFmt: driver.R16Float,
+		Dim3D:    size,
+		Layers:   1,
+		Levels:   1,
+		Samples:  1,
+	})
+	if err != nil {
+		return
+	}
+	c.blur, err = NewTarget(&TexParam{
+		PixelFmt: r.hdr.PixelFmt(),
+		Dim3D:    size,
+		Layers:   1,
+		Levels:   1,
+		Samples:  1,
+	})
+	if err != nil {
+		return
+	}
+	// The CoC is computed from depth, then used
+	// to gather samples of the lit image. The
+	// blurred result is blended over the sharp
+	// image according to the CoC.
+	// These are inserted before any other post
+	// pass, so that passes such as bloom see the
+	// defocused image.
+	r.graph.addFirst(&passNode{
+		name:   dofCompositePass,
+		stage:  stagePost,
+		reads:  []*Texture{c.blur, c.coc},
+		writes: []*Texture{r.hdr},
+	})
+	r.graph.addFirst(&passNode{
+		name:   dofBlurPass,
+		stage:  stagePost,
+		reads:  []*Texture{r.hdr, c.coc},
+		writes: []*Texture{c.blur},
+	})
+	r.graph.addFirst(&passNode{
+		name:   dofCoCPass,
+		stage:  stagePost,
+		reads:  []*Texture{r.ds},
+		writes: []*Texture{c.coc},
+	})
+	return
+}
+
+// freeDoF removes the DoF passes from r's frame graph
+// and frees the DoF targets.
+func (r *Renderer) freeDoF() {
+	if r.cam == nil || r.cam.coc == nil {
+		return
+	}
+	r.graph.remove(dofCoCPass)
+	r.graph.remove(dofBlurPass)
+	r.graph.remove(dofCompositePass)
+	r.cam.freeTargets()
+}
+
+// freeTargets frees the DoF targets.
+func (c *camera) freeTargets() {
+	for _, t := range [...]**Texture{&c.coc, &c.blur} {
+		if *t != nil {
+			(*t).Free()
+			*t = nil
+		}
+	}
+}
+
+// exposure returns the exposure scale of r.
+// It is computed by automatic exposure if enabled,
+// or from r's physical camera if one was set.
+// Otherwise, it is 1.
+func (r *Renderer) exposure() float32 {
+	switch {
+	case r.expo != nil:
+		return r.expo.layout.Exposure()
+	case r.cam != nil:
+		return r.cam.layout.Exposure()
+	}
+	return 1
+}
@@ -0,0 +1,150 @@
+// Copyright 2024 Gustavo C. Viegas. All rights reserved.
+
+package engine
+
+import (
+	"errors"
+	"math"
+	"testing"
+)
+
+func TestCameraParam(t *testing.T) {
+	// Sunny 16: f/16, 1/100s at ISO 100 is about EV 15.
+	p := CameraParam{Aperture: 16, Shutter: 0.01, ISO: 100, FocalLength: 50}
+	if x := p.EV100(); math.Abs(float64(x)-14.644) > 1e-3 {
+		t.Fatalf("CameraParam.EV100:\nhave %v\nwant 14.644", x)
+	}
+	p.ISO = 200
+	if x := p.EV100(); math.Abs(float64(x)-13.644) > 1e-3 {
+		t.Fatalf("CameraParam.EV100:\nhave %v\nwant 13.644", x)
+	}
+	// 24mm sensor height and 12mm focal length
+	// yield a 90° field of view.
+	p.FocalLength = 12
+	if x := p.FOV(); math.Abs(float64(x)-math.Pi/2) > 1e-6 {
+		t.Fatalf("CameraParam.FOV:\nhave %v\nwant %v", x, math.Pi/2)
+	}
+	p.SensorHeight = 48
+	if x := p.FOV(); math.Abs(float64(x)-2*math.Atan(2)) > 1e-6 {
+		t.Fatalf("CameraParam.FOV:\nhave %v\nwant %v", x, 2*math.Atan(2))
+	}
+}
+
+func TestRendererCamera(t *testing.T) {
+	rend, err := NewOffscreen(256, 192)
+	if err != nil {
+		t.Fatalf("RendererCamera: NewOffscreen failed:\n%v", err)
+	}
+	defer rend.Free()
+	if _, ok := rend.Camera(); ok {
+		t.Fatal("Renderer.Camera: camera should be unset by default")
+	}
+	if x := rend.exposure(); x != 1 {
+		t.Fatalf("Renderer.exposure:\nhave %v\nwant 1", x)
+	}
+
+	valid := CameraParam{
+		Aperture:      2,
+		Shutter:       1.0 / 60,
+		ISO:           400,
+		FocalLength:   50,
+		DoF:           true,
+		FocusDistance: 5,
+	}
+	for _, f := range [...]func(p *CameraParam){
+		func(p *CameraParam) { p.Aperture = 0 },
+		func(p *CameraParam) { p.Shutter = -1 },
+		func(p *CameraParam) { p.ISO = 0 },
+		func(p *CameraParam) { p.FocalLength = 0 },
+		func(p *CameraParam) { p.SensorHeight = -1 },
+		func(p *CameraParam) { p.MaxCoC = -1 },
+		func(p *CameraParam) { p.FocusDistance = 0.05 },
+	} {
+		p := valid
+		f(&p)
+		if err := rend.SetCamera(&p); err == nil {
+			t.Fatal("Renderer.SetCamera: unexpected nil error")
+		}
+	}
+	if err := rend.SetCamera(&valid); !errors.Is(err, ErrUnsupported) {
+		t.Fatalf("Renderer.SetCamera: pending DoF passes\nhave %v\nwant %v", err, ErrUnsupported)
+	}
+	if _, ok := rend.Camera(); ok || rend.graph.find(dofCoCPass) >= 0 {
+		t.Fatal("Renderer.SetCamera: camera should be unset")
+	}
+	defer allowPending(dofCoCPass, dofBlurPass, dofCompositePass)()
+	if err := rend.SetCamera(&valid); err != nil {
+		t.Fatalf("Renderer.SetCamera failed:\n%v", err)
+	}
+	if x, ok := rend.Camera(); !ok || x != valid {
+		t.Fatalf("Renderer.Camera:\nhave %v, %t\nwant %v, true", x, ok, valid)
+	}
+	c := rend.cam
+	if x, want := rend.exposure(), evToExposure(valid.EV100()); x != want {
+		t.Fatalf("Renderer.exposure:\nhave %v\nwant %v", x, want)
+	}
+	scale, focus, maxCoC := c.layout.CoC()
+	if focus != valid.FocusDistance || maxCoC != defMaxCoC {
+		t.Fatalf("Renderer.SetCamera: layout.CoC\nhave %v, %v\nwant %v, %v", focus, maxCoC, valid.FocusDistance, defMaxCoC)
+	}
+	// f²/(N(S-f)) = 0.0025/(2*4.95) m on a 24mm
+	// sensor mapped to 192 pixels.
+	want := 0.0025 / 9.9 / 0.024 * 192
+	if math.Abs(float64(scale)-want) > 1e-4 {
+		t.Fatalf("Renderer.SetCamera: CoC scale\nhave %v\nwant %v", scale, want)
+	}
+	if x := c.cocAt(valid.FocusDistance); x != 0 {
+		t.Fatalf("camera.cocAt: in focus\nhave %v\nwant 0", x)
+	}
+	if near, far := c.cocAt(1), c.cocAt(50); near >= 0 || far <= 0 {
+		t.Fatalf("camera.cocAt: sign\nhave %v (near), %v (far)", near, far)
+	}
+	if x := c.cocAt(1e-3); x != -defMaxCoC {
+		t.Fatalf("camera.cocAt: clamp\nhave %v\nwant %v", x, -defMaxCoC)
+	}
+
+	if c.coc == nil || c.blur == nil {
+		t.Fatal("Renderer.SetCamera: DoF targets should have been created")
+	}
+	if w, h := c.coc.Width(), c.coc.Height(); w != 256 || h != 192 {
+		t.Fatalf("Renderer.SetCamera: CoC size\nhave %dx%d\nwant 256x192", w, h)
+	}
+	// DoF precedes other post passes.
+	if err := rend.SetAutoExposure(&ExposureParam{MinEV: -2, MaxEV: 16, SpeedUp: 3, SpeedDown: 1}); err != nil {
+		t.Fatalf("Renderer.SetAutoExposure failed:\n%v", err)
+	}
+	if err := rend.SetCamera(nil); err != nil {
+		t.Fatalf("Renderer.SetCamera(nil) failed:\n%v", err)
+	}
+	if err := rend.SetCamera(&valid); err != nil {
+		t.Fatalf("Renderer.SetCamera failed:\n%v", err)
+	}
+	coc, blur, comp := rend.graph.find(dofCoCPass), rend.graph.find(dofBlurPass), rend.graph.find(dofCompositePass)
+	if !(coc >= 0 && coc < blur && blur < comp && comp < rend.graph.find(exposurePass)) {
+		t.Fatalf("Renderer.SetCamera: pass order\nhave %d, %d, %d", coc, blur, comp)
+	}
+	if err := rend.graph.compile(); err != nil {
+		t.Fatalf("frameGraph.compile failed:\n%v", err)
+	}
+	if x := rend.exposure(); x != rend.expo.layout.Exposure() {
+		t.Fatal("Renderer.exposure: automatic exposure should take precedence")
+	}
+
+	p := valid
+	p.DoF = false
+	if err := rend.SetCamera(&p); err != nil {
+		t.Fatalf("Renderer.SetCamera failed:\n%v", err)
+	}
+	if rend.cam.coc != nil || rend.graph.find(dofBlurPass) >= 0 {
+		t.Fatal("Renderer.SetCamera: DoF should be disabled")
+	}
+	if scale, _, _ := rend.cam.layout.CoC(); scale != 0 {
+		t.Fatalf("Renderer.SetCamera: CoC scale\nhave %v\nwant 0", scale)
+	}
+	if err := rend.SetCamera(nil); err != nil {
+		t.Fatalf("Renderer.SetCamera(nil) failed:\n%v", err)
+	}
+	if _, ok := rend.Camera(); ok {
+		t.Fatal("Renderer.SetCamera(nil): camera should be unset")
+	}
+}
@@ -13,6 +13,7 @@
 //	NEO3_N_FRAME       | NFrame      | integer
 //	NEO3_N_LIGHT       | NLight      | integer
 //	NEO3_N_SHADOW      | NShadow     | integer
+//	NEO3_N_PROBE       | NProbe      | integer
 //	NEO3_N_JOINT       | NJoint      | integer
 //	NEO3_N_DRAWABLE    | NDrawable   | integer
 //	NEO3_N_MATERIAL    | NMaterial   | integer
@@ -98,6 +99,17 @@ var (
 		Doc:   "// Maximum number of shadow maps per frame.",
 	}
 
+	NProbe = Const[int]{
+		Value: Value[int]{
+			Min: 0,
+			Max: shader.MaxProbe,
+			Dfl: shader.MaxProbe,
+		},
+		Ident: "NProbe",
+		Env:   "NEO3_N_PROBE",
+		Doc:   "// Maximum number of reflection probes per frame.",
+	}
+
 	NJoint = Const[int]{
 		Value: Value[int]{
 			Min: 0,
@@ -185,6 +197,7 @@ func main() {
 	sb.WriteString(NFrame.String())
 	sb.WriteString(NLight.String())
 	sb.WriteString(NShadow.String())
+	sb.WriteString(NProbe.String())
 	sb.WriteString(NJoint.String())
 	sb.WriteString(NDrawable.String())
 	sb.WriteString(NMaterial.String())
@@ -0,0 +1,356 @@
+// Copyright 2024 Gustavo C. Viegas. All rights reserved.
+
+package engine
+
+import (
+	"math"
+
+	"gviegas/neo3/linear"
+)
+
+// Cluster grid dimensions.
+// The view frustum is divided into clusterX by
+// clusterY tiles, and each tile into clusterZ slices
+// whose depth increases exponentially from the near
+// plane to the far plane (as in clusterSliceDepth).
+// For orthographic projections, the slices have the
+// same depth instead (as in clusterLinearSliceDepth).
+const (
+	clusterX = 16
+	clusterY = 9
+	clusterZ = 24
+	// Bounds of the depth range covered by
+	// the grid, used when the projection's
+	// own range is unsuitable (e.g., an
+	// infinite far plane).
+	clusterMinNear = 0.01
+	clusterMaxFar  = 1e4
+)
+
+// Kinds of items that clusters hold.
+// Each kind is binned separately, so shading can
+// iterate over the items of one kind in a cluster.
+const (
+	// Point and spot lights, identified by
+	// their index in Renderer.lights.
+	binLight = iota
+	// Decals, identified by their Decal value.
+	binDecal
+	// Local fog volumes.
+	binFog
+
+	binKinds
+)
+
+// clusters is a froxel grid into which lights, decals
+// and other bounded items are binned once per frame.
+// It is shared by every feature that needs to find
+// the items that affect a given point of the view
+// frustum.
+type clusters struct {
+	view, proj linear.M4
+	near, far  float32
+	// Whether proj is orthographic, in which
+	// case the slices are linear.
+	ortho bool
+	// Items added since the last reset.
+	items [binKinds][]binItem
+	// Range of each cluster's items in idx,
+	// per kind, computed by build.
+	cells [clusterX * clusterY * clusterZ][binKinds]binCell
+	idx   []int32
+	// Cluster ranges of the items, reused
+	// across builds.
+	ranges [binKinds][][6]int
+}
+
+// binItem is an item to be binned.
+// center is in view space. A negative radius means
+// that the item is unbounded.
+type binItem struct {
+	id     int32
+	center linear.V3
+	radius float32
+}
+
+// binCell is the range of a cluster's items in
+// clusters.idx.
+type binCell struct {
+	first, n int32
+}
+
+// reset clears c and sets up the grid for the given
+// view and projection transforms.
+// The grid covers the depth range of proj. For
+// perspective projections, this range is clamped to
+// [clusterMinNear, clusterMaxFar]. Orthographic
+// projections may have a near plane that is behind
+// the camera, so their range is used as is.
+func (c *clusters) reset(view, proj *linear.M4) {
+	c.view = *view
+	c.proj = *proj
+	c.near, c.far = projDepthRange(proj)
+	c.ortho = proj[2][3] == 0
+	if c.ortho {
+		c.far = max(c.far, c.near+clusterMinNear)
+	} else {
+		c.near = max(c.near, clusterMinNear)
+		c.far = min(max(c.far, c.near*2), clusterMaxFar)
+	}
+	for i := range c.items {
+		c.items[i] = c.items[i][:0]
+	}
+}
+
+// add adds an item of the given kind to c.
+// center is the item's position in world space, and
+// radius is that of a sphere that bounds it. If
+// radius is infinite, the item is added to every
+// cluster.
+// Items that lie outside of the grid's depth range
+// are discarded.
+func (c *clusters) add(kind int, id int32, center *linear.V3, radius float32) {
+	if math.IsInf(float64(radius), 1) {
+		c.items[kind] = append(c.items[kind], binItem{id: id, radius: -1})
+		return
+	}
+	v := linear.V4{center[0], center[1], center[2], 1}
+	v.Mul(&c.view, &v)
+	if v[2]+radius < c.near || v[2]-radius > c.far {
+		return
+	}
+	c.items[kind] = append(c.items[kind], binItem{id, linear.V3{v[0], v[1], v[2]}, radius})
+}
+
+// build bins every item added to c.
+// The items of each kind are stored in the order that
+// they were added.
+func (c *clusters) build() {
+	clear(c.cells[:])
+	for k := range c.items {
+		c.ranges[k] = c.ranges[k][:0]
+		for i := range c.items[k] {
+			r := c.itemRange(&c.items[k][i])
+			c.ranges[k] = append(c.ranges[k], r)
+			c.eachCell(r, func(cell int) { c.cells[cell][k].n++ })
+		}
+	}
+	var n int32
+	for i := range c.cells {
+		for k := range c.cells[i] {
+			c.cells[i][k].first = n
+			n += c.cells[i][k].n
+			c.cells[i][k].n = 0
+		}
+	}
+	c.idx = append(c.idx[:0], make([]int32, n)...)
+	for k := range c.items {
+		for i := range c.items[k] {
+			c.eachCell(c.ranges[k][i], func(cell int) {
+				x := &c.cells[cell][k]
+				c.idx[x.first+x.n] = c.items[k][i].id
+				x.n++
+			})
+		}
+	}
+}
+
+// at returns the items of the given kind that were
+// binned into the cluster at (x, y, z).
+func (c *clusters) at(kind, x, y, z int) []int32 {
+	x0 := c.cells[clusterIndex(x, y, z)][kind]
+	return c.idx[x0.first : x0.first+x0.n]
+}
+
+// clusterIndex returns the index of the cluster at
+// (x, y, z).
+func clusterIndex(x, y, z int) int { return x + clusterX*(y+clusterY*z) }
+
+// eachCell calls fn for the index of every cluster
+// in the range r, as returned by itemRange.
+func (c *clusters) eachCell(r [6]int, fn func(cell int)) {
+	for z := r[4]; z <= r[5]; z++ {
+		for y := r[2]; y <= r[3]; y++ {
+			for x := r[0]; x <= r[1]; x++ {
+				fn(clusterIndex(x, y, z))
+			}
+		}
+	}
+}
+
+// itemRange returns the inclusive range of clusters
+// that the item overlaps, as (x0, x1, y0, y1, z0, z1).
+// It is conservative: the corners of the item's view
+// space bounding box are projected, and the range
+// covers their bounds.
+func (c *clusters) itemRange(it *binItem) [6]int {
+	if it.radius < 0 {
+		return [6]int{0, clusterX - 1, 0, clusterY - 1, 0, clusterZ - 1}
+	}
+	z0 := max(it.center[2]-it.radius, c.near)
+	z1 := min(it.center[2]+it.radius, c.far)
+	nx0, ny0 := float32(math.Inf(1)), float32(math.Inf(1))
+	nx1, ny1 := -nx0, -ny0
+	for i := range 8 {
+		p := linear.V4{it.center[0], it.center[1], z0, 1}
+		if i&1 != 0 {
+			p[0] += it.radius
+		} else {
+			p[0] -= it.radius
+		}
+		if i&2 != 0 {
+			p[1] += it.radius
+		} else {
+			p[1] -= it.radius
+		}
+		if i&4 != 0 {
+			p[2] = z1
+		}
+		p.Mul(&c.proj, &p)
+		x, y := p[0]/p[3], p[1]/p[3]
+		nx0, nx1 = min(nx0, x), max(nx1, x)
+		ny0, ny1 = min(ny0, y), max(ny1, y)
+	}
+	tile := func(ndc float32, n int) int {
+		return min(n-1, max(0, int(math.Floor(float64((ndc+1)/2*float32(n))))))
+	}
+	return [6]int{
+		tile(nx0, clusterX), tile(nx1, clusterX),
+		tile(ny0, clusterY), tile(ny1, clusterY),
+		c.slice(z0), c.slice(z1),
+	}
+}
+
+// slice returns the slice of c's grid that contains
+// the given view depth.
+func (c *clusters) slice(depth float32) int {
+	if c.ortho {
+		return clusterLinearSlice(depth, clusterZ, c.near, c.far)
+	}
+	return clusterSlice(depth, clusterZ, c.near, c.far)
+}
+
+// clusterSliceDepth returns the view depth at which
+// the given slice starts, for n slices distributed
+// exponentially between near and far.
+// slice is in [0, n].
+func clusterSliceDepth(slice, n int, near, far float32) float32 {
+	x := float64(slice) / float64(n)
+	return float32(float64(near) * math.Pow(float64(far)/float64(near), x))
+}
+
+// clusterSlice is the inverse of clusterSliceDepth.
+// It returns the slice that contains depth, clamped
+// to [0, n-1].
+func clusterSlice(depth float32, n int, near, far float32) int {
+	if depth <= near {
+		return 0
+	}
+	x := math.Log(float64(depth)/float64(near)) / math.Log(float64(far)/float64(near))
+	return min(n-1, int(x*float64(n)))
+}
+
+// clusterLinearSliceDepth is like clusterSliceDepth,
+// but the slices are distributed uniformly.
+func clusterLinearSliceDepth(slice, n int, near, far float32) float32 {
+	return near + (far-near)*float32(slice)/float32(n)
+}
+
+// clusterLinearSlice is the inverse of
+// clusterLinearSliceDepth.
+func clusterLinearSlice(depth float32, n int, near, far float32) int {
+	if depth <= near {
+		return 0
+	}
+	return min(n-1, int((depth-near)/(far-near)*float32(n)))
+}
+
+// projDepthRange returns the view depths that proj
+// maps to the near and far planes.
+// Clip space depth is assumed to be in [0, 1].
+// far is infinite for projections that have no far
+// plane.
+// If clip space depth also depends on view x/y (e.g.,
+// an oblique near plane), the planes are not parallel
+// to the view, and the range that bounds them is
+// returned instead (see projBoundsDepthRange).
+func projDepthRange(proj *linear.M4) (near, far float32) {
+	if proj[0][2] != 0 || proj[1][2] != 0 {
+		return projBoundsDepthRange(proj)
+	}
+	m22, m32 := proj[2][2], proj[3][2]
+	if proj[2][3] == 0 {
+		// Orthographic.
+		return -m32 / m22, (1 - m32) / m22
+	}
+	near = -m32 / m22
+	if m22 == 1 {
+		return near, float32(math.Inf(1))
+	}
+	return near, m32 / (1 - m22)
+}
+
+// projBoundsDepthRange returns the range of view
+// depths of the corners of proj's view volume.
+// Since the volume is convex, this range bounds the
+// depth of every point in it.
+// far is infinite if any far corner is at infinity.
+func projBoundsDepthRange(proj *linear.M4) (near, far float32) {
+	var inv linear.M4
+	inv.Invert(proj)
+	near, far = float32(math.Inf(1)), float32(math.Inf(-1))
+	for i := range 8 {
+		p := linear.V4{-1, -1, 0, 1}
+		if i&1 != 0 {
+			p[0] = 1
+		}
+		if i&2 != 0 {
+			p[1] = 1
+		}
+		if i&4 != 0 {
+			p[2] = 1
+		}
+		p.Mul(&inv, &p)
+		if p[3] < 1e-6 {
+			far = float32(math.Inf(1))
+			continue
+		}
+		z := p[2] / p[3]
+		near, far = min(near, z), max(far, z)
+	}
+	return
+}
+
+// buildClusters bins r's point and spot lights and
+// its decals into l.clusters.
+// view and proj are the view and projection
+// transforms.
+// Lights whose range is zero are unbounded.
+func (l *drawList) buildClusters(r *Renderer, view, proj *linear.M4) {
+	c := &l.clusters
+	c.reset(view, proj)
+	for i, x := range r.Lights() {
+		if x.typ == distantLight {
+			continue
+		}
+		pos := x.Position()
+		rng := x.Range()
+		if rng <= 0 {
+			rng = float32(math.Inf(1))
+		}
+		c.add(binLight, int32(i), &pos, rng)
+	}
+	for id, d := range r.decals.all() {
+		world := d.layout.World()
+		// Half the diagonal of the transformed
+		// unit cube bounds it.
+		var s float32
+		for i := range 3 {
+			v := linear.V3{world[i][0], world[i][1], world[i][2]}
+			s += v.Dot(&v)
+		}
+		pos := linear.V3{world[3][0], world[3][1], world[3][2]}
+		c.add(binDecal, int32(id), &pos, float32(math.Sqrt(float64(s)))/2)
+	}
+	c.build()
+}
@@ -0,0 +1,187 @@
+// Copyright 2024 Gustavo C. Viegas. All rights reserved.
+
+package engine
+
+import (
+	"math"
+	"slices"
+	"testing"
+
+	"gviegas/neo3/linear"
+)
+
+func TestProjDepthRange(t *testing.T) {
+	var m linear.M4
+	m.Perspective(math.Pi/3, 16.0/9.0, 0.5, 200)
+	if n, f := projDepthRange(&m); math.Abs(float64(n-0.5)) > 1e-5 || math.Abs(float64(f-200)) > 1e-1 {
+		t.Fatalf("projDepthRange: perspective\nhave %v, %v\nwant 0.5, 200", n, f)
+	}
+	m.Ortho(-1, 1, -1, 1, 2, 10)
+	if n, f := projDepthRange(&m); math.Abs(float64(n-2)) > 1e-5 || math.Abs(float64(f-10)) > 1e-5 {
+		t.Fatalf("projDepthRange: orthographic\nhave %v, %v\nwant 2, 10", n, f)
+	}
+	m.Frustum(-0.2, 0.6, -0.3, 0.1, 0.5, 50)
+	if n, f := projDepthRange(&m); math.Abs(float64(n-0.5)) > 1e-5 || math.Abs(float64(f-50)) > 1e-2 {
+		t.Fatalf("projDepthRange: off-axis\nhave %v, %v\nwant 0.5, 50", n, f)
+	}
+
+	// Every point of an oblique view volume must be
+	// within the range. The far plane is tilted as
+	// well, so the volume may be unbounded.
+	m.Perspective(math.Pi/2, 1, 1, 100)
+	m.Oblique(&m, &linear.V4{0.3, -0.1, 1, -5})
+	n, f := projDepthRange(&m)
+	if !(n > 1 && n < 5 && f > 5) {
+		t.Fatalf("projDepthRange: oblique\nhave %v, %v", n, f)
+	}
+	var inv linear.M4
+	inv.Invert(&m)
+	for _, x := range [...]float32{-1, -0.5, 0, 0.5, 1} {
+		for _, y := range [...]float32{-1, 0, 1} {
+			for _, z := range [...]float32{0, 0.25, 0.5, 1} {
+				p := linear.V4{x, y, z, 1}
+				p.Mul(&inv, &p)
+				if p[3] <= 0 {
+					continue
+				}
+				if d := p[2] / p[3]; d < n-1e-3 || d > f+1e-3 {
+					t.Fatalf("projDepthRange: oblique\nhave %v, %v\n%v out of range", n, f, d)
+				}
+			}
+		}
+	}
+	m.Ortho(-1, 1, -1, 1, 0, 10)
+	m.Oblique(&m, &linear.V4{0, 1, 1, -2})
+	if n, f := projDepthRange(&m); math.Abs(float64(n-1)) > 1e-4 || math.Abs(float64(f-12)) > 1e-3 {
+		t.Fatalf("projDepthRange: oblique orthographic\nhave %v, %v\nwant 1, 12", n, f)
+	}
+}
+
+func TestClusterSlice(t *testing.T) {
+	const near, far = 0.1, 1000
+	for i := range clusterZ {
+		d0 := clusterSliceDepth(i, clusterZ, near, far)
+		d1 := clusterSliceDepth(i+1, clusterZ, near, far)
+		if x := clusterSlice((d0+d1)/2, clusterZ, near, far); x != i {
+			t.Fatalf("clusterSlice: depth within slice %d\nhave %d", i, x)
+		}
+	}
+	if x := clusterSlice(near/2, clusterZ, near, far); x != 0 {
+		t.Fatalf("clusterSlice: depth before near\nhave %d\nwant 0", x)
+	}
+	if x := clusterSlice(far*2, clusterZ, near, far); x != clusterZ-1 {
+		t.Fatalf("clusterSlice: depth past far\nhave %d\nwant %d", x, clusterZ-1)
+	}
+
+	const lnear, lfar = -20, 40
+	for i := range clusterZ {
+		d0 := clusterLinearSliceDepth(i, clusterZ, lnear, lfar)
+		d1 := clusterLinearSliceDepth(i+1, clusterZ, lnear, lfar)
+		if d1-d0 != (lfar-lnear)/float32(clusterZ) {
+			t.Fatalf("clusterLinearSliceDepth: slice %d\nhave %v\nwant %v", i, d1-d0, (lfar-lnear)/float32(clusterZ))
+		}
+		if x := clusterLinearSlice((d0+d1)/2, clusterZ, lnear, lfar); x != i {
+			t.Fatalf("clusterLinearSlice: depth within slice %d\nhave %d", i, x)
+		}
+	}
+	if x := clusterLinearSlice(lnear-1, clusterZ, lnear, lfar); x != 0 {
+		t.Fatalf("clusterLinearSlice: depth before near\nhave %d\nwant 0", x)
+	}
+	if x := clusterLinearSlice(lfar+1, clusterZ, lnear, lfar); x != clusterZ-1 {
+		t.Fatalf("clusterLinearSlice: depth past far\nhave %d\nwant %d", x, clusterZ-1)
+	}
+}
+
+func TestClusters(t *testing.T) {
+	var view, proj linear.M4
+	view.I()
+	proj.Perspective(math.Pi/2, 16.0/9.0, 0.1, 100)
+	var c clusters
+	c.reset(&view, &proj)
+
+	// A small item in the middle of the view,
+	// another on the left, one behind the camera
+	// and an unbounded one.
+	c.add(binLight, 7, &linear.V3{0, 0, 10}, 0.5)
+	c.add(binLight, 3, &linear.V3{-15, 0, 10}, 0.5)
+	c.add(binLight, 9, &linear.V3{0, 0, -5}, 1)
+	c.add(binDecal, 4, &linear.V3{}, float32(math.Inf(1)))
+	c.build()
+
+	z := clusterSlice(10, clusterZ, c.near, c.far)
+	if x := c.at(binLight, clusterX/2, clusterY/2, z); !slices.Equal(x, []int32{7}) {
+		t.Fatalf("clusters.at: center cluster\nhave %v\nwant [7]", x)
+	}
+	if x := c.at(binLight, 0, clusterY/2, z); !slices.Equal(x, []int32{3}) {
+		t.Fatalf("clusters.at: left cluster\nhave %v\nwant [3]", x)
+	}
+	if x := c.at(binLight, clusterX/2, clusterY/2, 0); len(x) != 0 {
+		t.Fatalf("clusters.at: near cluster\nhave %v\nwant []", x)
+	}
+	var n int
+	for cz := range clusterZ {
+		for cy := range clusterY {
+			for cx := range clusterX {
+				if x := c.at(binDecal, cx, cy, cz); !slices.Equal(x, []int32{4}) {
+					t.Fatalf("clusters.at: unbounded item in (%d, %d, %d)\nhave %v\nwant [4]", cx, cy, cz, x)
+				}
+				for _, x := range c.at(binLight, cx, cy, cz) {
+					if x == 9 {
+						t.Fatal("clusters.build: item behind the camera should have been discarded")
+					}
+					n++
+				}
+			}
+		}
+	}
+	// Items cover a few clusters at most.
+	if n < 2 || n > 32 {
+		t.Fatalf("clusters.build: binned lights\nhave %d", n)
+	}
+
+	// Rebuilding from scratch discards the
+	// previous items.
+	c.reset(&view, &proj)
+	c.build()
+	if len(c.idx) != 0 {
+		t.Fatalf("clusters.build: after reset\nhave %d items\nwant 0", len(c.idx))
+	}
+}
+
+func TestClustersOrtho(t *testing.T) {
+	var view, proj linear.M4
+	view.I()
+	proj.Ortho(-8, 8, -4.5, 4.5, -10, 30)
+	var c clusters
+	c.reset(&view, &proj)
+	if !c.ortho || c.near != -10 || c.far != 30 {
+		t.Fatalf("clusters.reset: orthographic\nhave %t, %v, %v\nwant true, -10, 30", c.ortho, c.near, c.far)
+	}
+
+	// Items behind the camera are visible, and
+	// their size does not depend on depth.
+	c.add(binLight, 1, &linear.V3{0.25, 0.25, -4.25}, 0.2)
+	c.add(binLight, 2, &linear.V3{0.25, 0.25, 25.75}, 0.2)
+	c.add(binLight, 3, &linear.V3{-7.75, 0.25, 0.75}, 0.2)
+	c.add(binLight, 4, &linear.V3{0, 0, 31}, 0.5)
+	c.build()
+
+	for _, x := range [...]struct {
+		id      int32
+		x, y, z int
+	}{
+		{1, clusterX / 2, clusterY / 2, c.slice(-4.25)},
+		{2, clusterX / 2, clusterY / 2, c.slice(25.75)},
+		{3, 0, clusterY / 2, c.slice(0.75)},
+	} {
+		if y := c.at(binLight, x.x, x.y, x.z); !slices.Equal(y, []int32{x.id}) {
+			t.Fatalf("clusters.at(%d, %d, %d):\nhave %v\nwant [%d]", x.x, x.y, x.z, y, x.id)
+		}
+	}
+	if n := len(c.idx); n != 3 {
+		t.Fatalf("clusters.build: binned lights\nhave %d\nwant 3", n)
+	}
+	if c.slice(-4.25) == c.slice(25.75) || c.slice(-10) != 0 || c.slice(30) != clusterZ-1 {
+		t.Fatal("clusters.slice: linear slices")
+	}
+}
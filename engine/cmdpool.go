@@ -0,0 +1,92 @@
+// Copyright 2024 Gustavo C. Viegas. All rights reserved.
+
+package engine
+
+import (
+	"sync"
+
+	"gviegas/neo3/driver"
+	"gviegas/neo3/engine/internal/ctxt"
+)
+
+// cmdPoolMax is the maximum number of idle command
+// buffers that a cmdPool retains.
+// It is set by Init (Config.CmdPoolMax).
+var cmdPoolMax = defaultConfig.CmdPoolMax
+
+// cmdPool is a pool of driver.CmdBuffers.
+// Command buffers are created on demand and, when
+// put back, retained for reuse (up to cmdPoolMax).
+type cmdPool struct {
+	mu  sync.Mutex
+	cbs []driver.CmdBuffer
+}
+
+// cmdBufs is the global cmdPool.
+var cmdBufs cmdPool
+
+// get returns an idle command buffer, creating a
+// new one if p has none.
+func (p *cmdPool) get() (driver.CmdBuffer, error) {
+	p.mu.Lock()
+	if n := len(p.cbs); n > 0 {
+		cb := p.cbs[n-1]
+		p.cbs[n-1] = nil
+		p.cbs = p.cbs[:n-1]
+		p.mu.Unlock()
+		return cb, nil
+	}
+	p.mu.Unlock()
+	return ctxt.GPU().NewCmdBuffer()
+}
+
+// newComputeCmdBuffer creates a new command buffer
+// for async compute work (see driver.AsyncComputer).
+// If the GPU does not implement driver.AsyncComputer,
+// it creates a regular command buffer.
+func newComputeCmdBuffer() (driver.CmdBuffer, error) {
+	if ac, ok := ctxt.GPU().(driver.AsyncComputer); ok {
+		return ac.NewComputeCmdBuffer()
+	}
+	return ctxt.GPU().NewCmdBuffer()
+}
+
+// put returns command buffers to p.
+// They must not be pending execution.
+// Command buffers in the recording state are reset.
+func (p *cmdPool) put(cb ...driver.CmdBuffer) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for _, x := range cb {
+		if x == nil {
+			continue
+		}
+		if x.IsRecording() && x.Reset() != nil || len(p.cbs) >= cmdPoolMax {
+			x.Destroy()
+			continue
+		}
+		p.cbs = append(p.cbs, x)
+	}
+}
+
+// recycle puts the command buffers of wk back into
+// p and clears wk.Work.
+// wk must not be pending execution (i.e., it must
+// have been received from the channel passed to
+// GPU.Commit, or never committed at all).
+func (p *cmdPool) recycle(wk *driver.WorkItem) {
+	p.put(wk.Work...)
+	clear(wk.Work)
+	wk.Work = wk.Work[:0]
+}
+
+// free destroys every idle command buffer in p.
+func (p *cmdPool) free() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for _, cb := range p.cbs {
+		cb.Destroy()
+	}
+	clear(p.cbs)
+	p.cbs = p.cbs[:0]
+}
@@ -0,0 +1,55 @@
+// Copyright 2024 Gustavo C. Viegas. All rights reserved.
+
+package engine
+
+import (
+	"testing"
+
+	"gviegas/neo3/driver"
+)
+
+func TestCmdPool(t *testing.T) {
+	var p cmdPool
+	defer p.free()
+
+	cb, err := p.get()
+	if err != nil {
+		t.Fatalf("cmdPool.get failed:\n%v", err)
+	}
+	if err := cb.Begin(); err != nil {
+		t.Fatalf("CmdBuffer.Begin failed:\n%v", err)
+	}
+	p.put(cb)
+	if len(p.cbs) != 1 {
+		t.Fatalf("cmdPool.put: len(cbs)\nhave %d\nwant 1", len(p.cbs))
+	}
+	if cb.IsRecording() {
+		t.Fatal("cmdPool.put: command buffer should have been reset")
+	}
+	if x, _ := p.get(); x != cb {
+		t.Fatal("cmdPool.get: command buffer not reused")
+	}
+	if len(p.cbs) != 0 {
+		t.Fatalf("cmdPool.get: len(cbs)\nhave %d\nwant 0", len(p.cbs))
+	}
+
+	wk := &driver.WorkItem{}
+	for range cmdPoolMax + 1 {
+		cb, err := p.get()
+		if err != nil {
+			t.Fatalf("cmdPool.get failed:\n%v", err)
+		}
+		wk.Work = append(wk.Work, cb)
+	}
+	p.recycle(wk)
+	if len(wk.Work) != 0 {
+		t.Fatalf("cmdPool.recycle: len(wk.Work)\nhave %d\nwant 0", len(wk.Work))
+	}
+	if len(p.cbs) != cmdPoolMax {
+		t.Fatalf("cmdPool.recycle: len(cbs)\nhave %d\nwant %d", len(p.cbs), cmdPoolMax)
+	}
+	p.free()
+	if len(p.cbs) != 0 {
+		t.Fatalf("cmdPool.free: len(cbs)\nhave %d\nwant 0", len(p.cbs))
+	}
+}
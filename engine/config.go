@@ -0,0 +1,259 @@
+// Copyright 2024 Gustavo C. Viegas. All rights reserved.
+
+package engine
+
+import (
+	"runtime"
+	"sync"
+	"sync/atomic"
+
+	"gviegas/neo3/engine/internal/ctxt"
+	"gviegas/neo3/internal/suballoc"
+)
+
+// Config describes the global configuration of the
+// engine.
+// Sizes are in bytes. Block sizes must be powers of
+// two in the [256, 1<<24] interval.
+type Config struct {
+	// Number of staging buffers of each kind. It
+	// limits how many copies to GPU memory can be
+	// staged concurrently.
+	StagingBuffers int
+	// Allocation granularity of the staging buffers
+	// used for Texture copies.
+	TexStagingBlock int
+	// Allocation granularity of the staging buffers
+	// used for buffer copies (e.g., of materials).
+	BufStagingBlock int
+	// Allocation granularity of the mesh storage.
+	MeshSpanBlock int
+	// Maximum number of idle command buffers that
+	// are kept for reuse.
+	CmdPoolMax int
+	// Initial values of the settings that can be
+	// changed with SetTuning.
+	Tuning Tuning
+}
+
+// Tuning describes settings that can be changed while
+// the engine is running.
+type Tuning struct {
+	// Number of bytes that delayed Texture copies can
+	// stage before they are committed automatically.
+	// It bounds the amount of texture data streamed
+	// in a single submission.
+	StreamingBudget int64
+	// Width and height of each face of the shadow
+	// cubemaps used by point lights. It must be a
+	// power of two and cannot exceed the driver's
+	// Limits.MaxImageCube and Limits.MaxRenderSize.
+	// It applies to shadow maps created after it
+	// is set.
+	ShadowResolution int
+	// Initial MSAA sample count of Renderers. It must
+	// be a power of two. Renderers use the largest
+	// count not above it that the driver supports
+	// (see Renderer.SetMSAA). It applies to
+	// Renderers created after it is set.
+	MSAA int
+}
+
+// Option is the type of functions that modify a
+// Config in a call to Init.
+type Option func(*Config)
+
+// WithStagingBuffers sets Config.StagingBuffers.
+func WithStagingBuffers(n int) Option { return func(c *Config) { c.StagingBuffers = n } }
+
+// WithTexStagingBlock sets Config.TexStagingBlock.
+func WithTexStagingBlock(n int) Option { return func(c *Config) { c.TexStagingBlock = n } }
+
+// WithBufStagingBlock sets Config.BufStagingBlock.
+func WithBufStagingBlock(n int) Option { return func(c *Config) { c.BufStagingBlock = n } }
+
+// WithMeshSpanBlock sets Config.MeshSpanBlock.
+func WithMeshSpanBlock(n int) Option { return func(c *Config) { c.MeshSpanBlock = n } }
+
+// WithCmdPoolMax sets Config.CmdPoolMax.
+func WithCmdPoolMax(n int) Option { return func(c *Config) { c.CmdPoolMax = n } }
+
+// WithTuning sets Config.Tuning.
+func WithTuning(t Tuning) Option { return func(c *Config) { c.Tuning = t } }
+
+// Block size limits.
+const (
+	minConfigBlock = 256
+	maxConfigBlock = 1 << 24
+)
+
+var (
+	// Configuration that the engine starts with.
+	defaultConfig = Config{
+		StagingBuffers:  runtime.GOMAXPROCS(-1),
+		TexStagingBlock: 131072,
+		BufStagingBlock: 4096,
+		MeshSpanBlock:   512,
+		CmdPoolMax:      4 * NFrame,
+		Tuning: Tuning{
+			// Small enough that the staging buffers
+			// rarely need to commit (blocking)
+			// to grow.
+			StreamingBudget:  131072 * suballoc.Word,
+			ShadowResolution: ShadowCubeSize,
+			MSAA:             4,
+		},
+	}
+	// Current configuration. Tuning is only
+	// valid in defaultConfig and in calls to
+	// Init; the current Tuning is in tuning.
+	config = defaultConfig
+
+	// Current Tuning.
+	tuning = struct {
+		sync.Mutex
+		t Tuning
+	}{t: defaultConfig.Tuning}
+	// Copy of tuning.t.StreamingBudget that can
+	// be read without locking.
+	streamBudget atomic.Int64
+)
+
+func init() { streamBudget.Store(defaultConfig.Tuning.StreamingBudget) }
+
+// DefaultConfig returns the default configuration.
+// StagingBuffers is set to runtime.GOMAXPROCS(-1) at
+// the time the package is initialized.
+func DefaultConfig() Config { return defaultConfig }
+
+// CurrentConfig returns the configuration set by the
+// most recent call to Init, or DefaultConfig if Init
+// was never called. Its Tuning field is the current
+// Tuning.
+func CurrentConfig() Config {
+	c := config
+	c.Tuning = CurrentTuning()
+	return c
+}
+
+// validate checks whether c is valid.
+func (c *Config) validate() error {
+	block := func(n int) bool {
+		return n >= minConfigBlock && n <= maxConfigBlock && n&(n-1) == 0
+	}
+	var reason string
+	switch {
+	case c.StagingBuffers < 1:
+		reason = "non-positive Config.StagingBuffers"
+	case !block(c.TexStagingBlock):
+		reason = "invalid Config.TexStagingBlock"
+	case !block(c.BufStagingBlock):
+		reason = "invalid Config.BufStagingBlock"
+	case !block(c.MeshSpanBlock):
+		reason = "invalid Config.MeshSpanBlock"
+	case c.CmdPoolMax < 0:
+		reason = "negative Config.CmdPoolMax"
+	default:
+		return c.Tuning.validate()
+	}
+	return newErr("engine: ", reason, ErrInvalidParam)
+}
+
+// Init configures the engine with DefaultConfig
+// modified by opts.
+// The engine is initialized with DefaultConfig when
+// the package is loaded, so calling Init is only
+// necessary to change the configuration. It should
+// be called before any resource is created.
+// Pending copies are committed and the staging buffers
+// and mesh storage are recreated. It fails if any Mesh
+// exists, since the mesh storage cannot be recreated
+// without invalidating it.
+func Init(opts ...Option) error {
+	c := defaultConfig
+	for _, opt := range opts {
+		opt(&c)
+	}
+	if err := c.validate(); err != nil {
+		return err
+	}
+	meshes.RLock()
+	n := meshes.primMap.Len() - meshes.primMap.Rem()
+	meshes.RUnlock()
+	if n > 0 {
+		return newErr("engine: ", "Init called with live meshes", ErrInvalidParam)
+	}
+	if err := commitTexStg().Wait(); err != nil {
+		return err
+	}
+	if err := commitBufStg(); err != nil {
+		return err
+	}
+	freeTexStg()
+	freeBufStg()
+	cmdBufs.free()
+	if buf := setMeshBuffer(nil); buf != nil {
+		buf.Destroy()
+	}
+	config = c
+	texStgBlock = c.TexStagingBlock
+	bufStgBlock = c.BufStagingBlock
+	spanBlock = c.MeshSpanBlock
+	cmdPoolMax = c.CmdPoolMax
+	initTexStg()
+	initBufStg()
+	setTuning(&c.Tuning)
+	return nil
+}
+
+// validate checks whether t is valid for the current
+// driver.
+func (t *Tuning) validate() error {
+	limits := ctxt.Limits()
+	var reason string
+	switch {
+	case t == nil:
+		reason = "nil tuning"
+	case t.StreamingBudget < 1:
+		reason = "non-positive Tuning.StreamingBudget"
+	case t.ShadowResolution < 1, t.ShadowResolution&(t.ShadowResolution-1) != 0:
+		reason = "invalid Tuning.ShadowResolution"
+	case t.ShadowResolution > limits.MaxImageCube,
+		t.ShadowResolution > min(limits.MaxRenderSize[0], limits.MaxRenderSize[1]):
+		reason = "Tuning.ShadowResolution too big"
+	case t.MSAA < 1, t.MSAA&(t.MSAA-1) != 0:
+		reason = "invalid Tuning.MSAA"
+	default:
+		return nil
+	}
+	return newErr("engine: ", reason, ErrInvalidParam)
+}
+
+// SetTuning changes the settings that can be modified
+// while the engine is running.
+// Each setting is validated against the driver's
+// limits. See Tuning for when the settings take
+// effect.
+func SetTuning(t *Tuning) error {
+	if err := t.validate(); err != nil {
+		return err
+	}
+	setTuning(t)
+	return nil
+}
+
+// setTuning sets the current Tuning to a copy of *t.
+// It assumes that t is valid.
+func setTuning(t *Tuning) {
+	tuning.Lock()
+	defer tuning.Unlock()
+	tuning.t = *t
+	streamBudget.Store(t.StreamingBudget)
+}
+
+// CurrentTuning returns the current Tuning.
+func CurrentTuning() Tuning {
+	tuning.Lock()
+	defer tuning.Unlock()
+	return tuning.t
+}
@@ -0,0 +1,133 @@
+// Copyright 2024 Gustavo C. Viegas. All rights reserved.
+
+package engine
+
+import (
+	"errors"
+	"testing"
+
+	"gviegas/neo3/engine/internal/ctxt"
+)
+
+func TestConfigValidate(t *testing.T) {
+	dfl := DefaultConfig()
+	if err := dfl.validate(); err != nil {
+		t.Fatalf("DefaultConfig: validate failed:\n%v", err)
+	}
+	for _, opt := range [...]Option{
+		WithStagingBuffers(0),
+		WithTexStagingBlock(0),
+		WithTexStagingBlock(1000),
+		WithTexStagingBlock(maxConfigBlock * 2),
+		WithBufStagingBlock(minConfigBlock / 2),
+		WithMeshSpanBlock(-512),
+		WithCmdPoolMax(-1),
+		WithTuning(Tuning{}),
+	} {
+		c := dfl
+		opt(&c)
+		if err := c.validate(); !errors.Is(err, ErrInvalidParam) {
+			t.Fatalf("Config.validate: %+v\nhave %v\nwant %v", c, err, ErrInvalidParam)
+		}
+		if err := Init(opt); !errors.Is(err, ErrInvalidParam) {
+			t.Fatalf("Init: %+v\nhave %v\nwant %v", c, err, ErrInvalidParam)
+		}
+	}
+	if c := CurrentConfig(); c != dfl {
+		t.Fatalf("Init: invalid options changed the configuration\n%+v", c)
+	}
+}
+
+func TestInit(t *testing.T) {
+	defer func() {
+		if err := Init(); err != nil {
+			t.Fatalf("Init failed:\n%v", err)
+		}
+	}()
+
+	err := Init(
+		WithStagingBuffers(2),
+		WithTexStagingBlock(65536),
+		WithBufStagingBlock(1024),
+		WithMeshSpanBlock(256),
+		WithCmdPoolMax(1),
+	)
+	if err != nil {
+		t.Fatalf("Init failed:\n%v", err)
+	}
+	switch {
+	case texStgBlock != 65536, bufStgBlock != 1024, spanBlock != 256, cmdPoolMax != 1:
+		t.Fatalf("Init: unexpected block sizes\n%d %d %d %d", texStgBlock, bufStgBlock, spanBlock, cmdPoolMax)
+	case cap(texStg) != 2, cap(bufStg) != 2:
+		t.Fatalf("Init: staging buffer count\nhave %d, %d\nwant 2, 2", cap(texStg), cap(bufStg))
+	}
+	c := CurrentConfig()
+	if c.TexStagingBlock != 65536 || c.Tuning != DefaultConfig().Tuning {
+		t.Fatalf("CurrentConfig:\n%+v", c)
+	}
+
+	// Init must not invalidate existing meshes.
+	data := dummyData1(1)
+	m, err := NewMesh(&data)
+	if err != nil {
+		t.Fatalf("NewMesh failed:\n%v", err)
+	}
+	if err := Init(); !errors.Is(err, ErrInvalidParam) {
+		t.Fatalf("Init: live meshes\nhave %v\nwant %v", err, ErrInvalidParam)
+	}
+	m.Free()
+}
+
+func TestTuning(t *testing.T) {
+	dfl := CurrentTuning()
+	defer SetTuning(&dfl)
+
+	limits := ctxt.Limits()
+	for _, x := range [...]Tuning{
+		{StreamingBudget: 0, ShadowResolution: 512, MSAA: 1},
+		{StreamingBudget: 1 << 20, ShadowResolution: 0, MSAA: 1},
+		{StreamingBudget: 1 << 20, ShadowResolution: 500, MSAA: 1},
+		{StreamingBudget: 1 << 20, ShadowResolution: limits.MaxImageCube * 2, MSAA: 1},
+		{StreamingBudget: 1 << 20, ShadowResolution: 512, MSAA: 0},
+		{StreamingBudget: 1 << 20, ShadowResolution: 512, MSAA: 3},
+	} {
+		if err := SetTuning(&x); !errors.Is(err, ErrInvalidParam) {
+			t.Fatalf("SetTuning(%+v):\nhave %v\nwant %v", x, err, ErrInvalidParam)
+		}
+	}
+	if err := SetTuning(nil); !errors.Is(err, ErrInvalidParam) {
+		t.Fatalf("SetTuning(nil):\nhave %v\nwant %v", err, ErrInvalidParam)
+	}
+	if x := CurrentTuning(); x != dfl {
+		t.Fatalf("SetTuning: invalid tuning was set\n%+v", x)
+	}
+
+	x := Tuning{StreamingBudget: 1 << 20, ShadowResolution: 256, MSAA: 1}
+	if err := SetTuning(&x); err != nil {
+		t.Fatalf("SetTuning failed:\n%v", err)
+	}
+	if y := CurrentTuning(); y != x {
+		t.Fatalf("CurrentTuning:\nhave %+v\nwant %+v", y, x)
+	}
+	if n := streamBudget.Load(); n != x.StreamingBudget {
+		t.Fatalf("SetTuning: streamBudget\nhave %d\nwant %d", n, x.StreamingBudget)
+	}
+
+	tex, err := newShadowCube(1)
+	if err != nil {
+		t.Fatalf("newShadowCube failed:\n%v", err)
+	}
+	defer tex.Free()
+	if tex.Width() != 256 {
+		t.Fatalf("newShadowCube: size\nhave %d\nwant 256", tex.Width())
+	}
+
+	rend, err := NewOffscreen(64, 64)
+	if err != nil {
+		t.Fatalf("NewOffscreen failed:\n%v", err)
+	}
+	defer rend.Free()
+	if n := rend.MSAA(); n != 1 {
+		t.Fatalf("NewOffscreen: MSAA\nhave %d\nwant 1", n)
+	}
+}
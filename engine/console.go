@@ -0,0 +1,338 @@
+// Copyright 2024 Gustavo C. Viegas. All rights reserved.
+
+package engine
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+
+	"gviegas/neo3/wsi"
+)
+
+// ConsoleLines is the maximum number of output lines
+// that a Console keeps.
+const ConsoleLines = 256
+
+// consoleHistory is the maximum number of commands
+// that a Console remembers.
+const consoleHistory = 64
+
+// Console is a command line for console variables.
+// Commands are read from the keyboard, while the
+// console is open, or given to Exec directly:
+//
+//	<name>          print the variable
+//	<name> <value>  set the variable (see SetCVar)
+//	reset <name>    reset the variable to its default
+//	list [prefix]   print the variables
+//	clear           clear the output
+//	help            print the commands
+//
+// The grave key (`) opens and closes the console.
+// Return executes the input line, Tab completes
+// variable names and Up/Down browse the history.
+// Keys assume the US layout.
+// A Renderer draws the console over the final image
+// while it is open (see Renderer.SetConsole).
+// It is safe for concurrent use.
+type Console struct {
+	mu    sync.Mutex
+	open  bool
+	mod   wsi.Modifier
+	input []rune
+	// Insertion point in input.
+	cursor  int
+	history []string
+	// Index of the history entry being
+	// edited, or len(history).
+	hist   int
+	output []string
+}
+
+// NewConsole creates a new, closed Console.
+func NewConsole() *Console { return new(Console) }
+
+// Open returns whether c is open.
+// Applications should not handle keyboard input
+// themselves while it is.
+func (c *Console) Open() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.open
+}
+
+// SetOpen opens or closes c.
+func (c *Console) SetOpen(open bool) {
+	c.mu.Lock()
+	c.open = open
+	c.mu.Unlock()
+}
+
+// Input returns the line being edited in c and the
+// position of the cursor in it, in runes.
+func (c *Console) Input() (line string, cursor int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return string(c.input), c.cursor
+}
+
+// Output returns the output lines of c, oldest
+// first.
+func (c *Console) Output() []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return slices.Clone(c.output)
+}
+
+// Printf formats according to a format specifier and
+// appends the result to the output of c, one line
+// per line of text. Only the last ConsoleLines lines
+// are kept.
+func (c *Console) Printf(format string, args ...any) {
+	s := fmt.Sprintf(format, args...)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.output = append(c.output, strings.Split(strings.TrimSuffix(s, "\n"), "\n")...)
+	if n := len(c.output) - ConsoleLines; n > 0 {
+		c.output = slices.Delete(c.output, 0, n)
+	}
+}
+
+// Exec executes a command in c, as if it were typed
+// and followed by Return.
+func (c *Console) Exec(line string) {
+	line = strings.TrimSpace(line)
+	c.mu.Lock()
+	if line != "" && (len(c.history) == 0 || c.history[len(c.history)-1] != line) {
+		c.history = append(c.history, line)
+		if n := len(c.history) - consoleHistory; n > 0 {
+			c.history = slices.Delete(c.history, 0, n)
+		}
+	}
+	c.hist = len(c.history)
+	c.mu.Unlock()
+	if line == "" {
+		return
+	}
+	c.Printf("> %s", line)
+	// Commands run unlocked, since console
+	// variables may print to c when set.
+	cmd, arg := line, ""
+	if i := strings.IndexByte(line, ' '); i >= 0 {
+		cmd, arg = line[:i], strings.TrimSpace(line[i+1:])
+	}
+	switch cmd {
+	case "help":
+		c.Printf("commands: <name> [value], reset <name>, list [prefix], clear, help")
+	case "clear":
+		c.mu.Lock()
+		c.output = c.output[:0]
+		c.mu.Unlock()
+	case "list":
+		for _, x := range CVars(arg) {
+			c.Printf("%s = %s", x.Name, x.Value)
+		}
+	case "reset":
+		if err := ResetCVar(arg); err != nil {
+			c.Printf("%v", err)
+		} else {
+			c.printCVar(arg, false)
+		}
+	default:
+		if arg == "" {
+			c.printCVar(cmd, true)
+		} else if err := SetCVar(cmd, arg); err != nil {
+			c.Printf("%v", err)
+		} else {
+			c.printCVar(cmd, false)
+		}
+	}
+}
+
+// printCVar prints the value of the console variable
+// with the given name and, if verbose is set, its
+// description.
+func (c *Console) printCVar(name string, verbose bool) {
+	x, ok := LookupCVar(name)
+	switch {
+	case !ok:
+		c.Printf("%sundefined variable: %s", cvarPrefix, name)
+	case !verbose:
+		c.Printf("%s = %s", x.Name, x.Value)
+	case x.Min < x.Max:
+		c.Printf("%s = %s (%s in [%g, %g], default %s): %s", x.Name, x.Value, x.Type, x.Min, x.Max, x.Default, x.Help)
+	default:
+		c.Printf("%s = %s (%s, default %s): %s", x.Name, x.Value, x.Type, x.Default, x.Help)
+	}
+}
+
+// complete completes the first word of the input of
+// c with the names of console variables.
+// If more than one name matches, the word is extended
+// to their longest common prefix and the names are
+// printed.
+func (c *Console) complete() {
+	c.mu.Lock()
+	word := string(c.input)
+	c.mu.Unlock()
+	if strings.ContainsRune(word, ' ') {
+		return
+	}
+	xs := CVars(word)
+	if len(xs) == 0 {
+		return
+	}
+	prefix := xs[0].Name
+	for _, x := range xs[1:] {
+		n := 0
+		for n < len(prefix) && n < len(x.Name) && prefix[n] == x.Name[n] {
+			n++
+		}
+		prefix = prefix[:n]
+	}
+	if len(xs) == 1 {
+		prefix += " "
+	} else {
+		for _, x := range xs {
+			c.Printf("%s", x.Name)
+		}
+	}
+	c.mu.Lock()
+	c.input = []rune(prefix)
+	c.cursor = len(c.input)
+	c.mu.Unlock()
+}
+
+// KeyboardKey implements wsi.KeyboardKeyHandler.
+// Keys other than the grave key are ignored while c
+// is closed.
+func (c *Console) KeyboardKey(key wsi.Key, pressed bool) {
+	if !pressed {
+		return
+	}
+	c.mu.Lock()
+	if key == wsi.KeyGrave {
+		c.open = !c.open
+	}
+	if !c.open || key == wsi.KeyGrave {
+		c.mu.Unlock()
+		return
+	}
+	switch key {
+	case wsi.KeyReturn, wsi.KeyPadEnter:
+		line := string(c.input)
+		c.input = c.input[:0]
+		c.cursor = 0
+		c.mu.Unlock()
+		c.Exec(line)
+		return
+	case wsi.KeyTab:
+		c.mu.Unlock()
+		c.complete()
+		return
+	case wsi.KeyEsc:
+		c.open = false
+	case wsi.KeyBackspace:
+		if c.cursor > 0 {
+			c.input = slices.Delete(c.input, c.cursor-1, c.cursor)
+			c.cursor--
+		}
+	case wsi.KeyDelete:
+		if c.cursor < len(c.input) {
+			c.input = slices.Delete(c.input, c.cursor, c.cursor+1)
+		}
+	case wsi.KeyLeft:
+		c.cursor = max(c.cursor-1, 0)
+	case wsi.KeyRight:
+		c.cursor = min(c.cursor+1, len(c.input))
+	case wsi.KeyHome:
+		c.cursor = 0
+	case wsi.KeyEnd:
+		c.cursor = len(c.input)
+	case wsi.KeyUp, wsi.KeyDown:
+		if key == wsi.KeyUp {
+			c.hist = max(c.hist-1, 0)
+		} else {
+			c.hist = min(c.hist+1, len(c.history))
+		}
+		c.input = c.input[:0]
+		if c.hist < len(c.history) {
+			c.input = append(c.input, []rune(c.history[c.hist])...)
+		}
+		c.cursor = len(c.input)
+	default:
+		if r, ok := consoleKeys[key]; ok {
+			shift := c.mod&wsi.ModShift != 0
+			if r[0] >= 'a' && r[0] <= 'z' && c.mod&wsi.ModCapsLock != 0 {
+				shift = !shift
+			}
+			ch := r[0]
+			if shift {
+				ch = r[1]
+			}
+			c.input = slices.Insert(c.input, c.cursor, ch)
+			c.cursor++
+		}
+	}
+	c.mu.Unlock()
+}
+
+// KeyboardModifier implements
+// wsi.KeyboardModifierHandler.
+func (c *Console) KeyboardModifier(modMask wsi.Modifier) {
+	c.mu.Lock()
+	c.mod = modMask
+	c.mu.Unlock()
+}
+
+// consoleKeys maps keys to the characters that they
+// produce in the US layout, without and with shift.
+var consoleKeys = map[wsi.Key][2]rune{
+	wsi.Key1: {'1', '!'}, wsi.Key2: {'2', '@'}, wsi.Key3: {'3', '#'}, wsi.Key4: {'4', '$'}, wsi.Key5: {'5', '%'},
+	wsi.Key6: {'6', '^'}, wsi.Key7: {'7', '&'}, wsi.Key8: {'8', '*'}, wsi.Key9: {'9', '('}, wsi.Key0: {'0', ')'},
+	wsi.KeyMinus: {'-', '_'}, wsi.KeyEqual: {'=', '+'},
+	wsi.KeyQ: {'q', 'Q'}, wsi.KeyW: {'w', 'W'}, wsi.KeyE: {'e', 'E'}, wsi.KeyR: {'r', 'R'}, wsi.KeyT: {'t', 'T'},
+	wsi.KeyY: {'y', 'Y'}, wsi.KeyU: {'u', 'U'}, wsi.KeyI: {'i', 'I'}, wsi.KeyO: {'o', 'O'}, wsi.KeyP: {'p', 'P'},
+	wsi.KeyLBracket: {'[', '{'}, wsi.KeyRBracket: {']', '}'}, wsi.KeyBackslash: {'\\', '|'},
+	wsi.KeyA: {'a', 'A'}, wsi.KeyS: {'s', 'S'}, wsi.KeyD: {'d', 'D'}, wsi.KeyF: {'f', 'F'}, wsi.KeyG: {'g', 'G'},
+	wsi.KeyH: {'h', 'H'}, wsi.KeyJ: {'j', 'J'}, wsi.KeyK: {'k', 'K'}, wsi.KeyL: {'l', 'L'},
+	wsi.KeySemicolon: {';', ':'}, wsi.KeyApostrophe: {'\'', '"'},
+	wsi.KeyZ: {'z', 'Z'}, wsi.KeyX: {'x', 'X'}, wsi.KeyC: {'c', 'C'}, wsi.KeyV: {'v', 'V'}, wsi.KeyB: {'b', 'B'},
+	wsi.KeyN: {'n', 'N'}, wsi.KeyM: {'m', 'M'},
+	wsi.KeyComma: {',', '<'}, wsi.KeyDot: {'.', '>'}, wsi.KeySlash: {'/', '?'},
+	wsi.KeySpace: {' ', ' '},
+
+	wsi.KeyPad1: {'1', '1'}, wsi.KeyPad2: {'2', '2'}, wsi.KeyPad3: {'3', '3'}, wsi.KeyPad4: {'4', '4'}, wsi.KeyPad5: {'5', '5'},
+	wsi.KeyPad6: {'6', '6'}, wsi.KeyPad7: {'7', '7'}, wsi.KeyPad8: {'8', '8'}, wsi.KeyPad9: {'9', '9'}, wsi.KeyPad0: {'0', '0'},
+	wsi.KeyPadDot: {'.', '.'}, wsi.KeyPadSlash: {'/', '/'}, wsi.KeyPadStar: {'*', '*'}, wsi.KeyPadMinus: {'-', '-'}, wsi.KeyPadPlus: {'+', '+'},
+}
+
+// consolePass is the name of the console pass.
+const consolePass = "console"
+
+// SetConsole sets the console that r draws over the
+// final image.
+// The console is drawn only while it is open, as a
+// translucent panel at the top of every viewport,
+// with the input line at the bottom and the most
+// recent output above it. Text is scaled by r's UI
+// scale.
+// If c is nil, no console is drawn.
+func (r *Renderer) SetConsole(c *Console) {
+	switch {
+	case c == nil && r.console != nil:
+		r.graph.remove(consolePass)
+	case c != nil && r.console == nil:
+		r.graph.add(&passNode{
+			name:  consolePass,
+			stage: stageFinal,
+		})
+	}
+	r.console = c
+}
+
+// Console returns the console that r draws, or nil
+// if there is none.
+func (r *Renderer) Console() *Console { return r.console }
@@ -0,0 +1,154 @@
+// Copyright 2024 Gustavo C. Viegas. All rights reserved.
+
+package engine
+
+import (
+	"slices"
+	"strings"
+	"testing"
+
+	"gviegas/neo3/wsi"
+)
+
+// typeKeys presses and releases keys in c.
+func typeKeys(c *Console, keys ...wsi.Key) {
+	for _, k := range keys {
+		c.KeyboardKey(k, true)
+		c.KeyboardKey(k, false)
+	}
+}
+
+func TestConsoleInput(t *testing.T) {
+	c := NewConsole()
+	typeKeys(c, wsi.KeyA)
+	if s, _ := c.Input(); s != "" || c.Open() {
+		t.Fatalf("Console.KeyboardKey: closed console\nhave %q\nwant \"\"", s)
+	}
+	typeKeys(c, wsi.KeyGrave)
+	if !c.Open() {
+		t.Fatal("Console.KeyboardKey: console should be open")
+	}
+	typeKeys(c, wsi.KeyA, wsi.KeyB)
+	c.KeyboardModifier(wsi.ModShift)
+	typeKeys(c, wsi.KeyC, wsi.Key1)
+	c.KeyboardModifier(wsi.ModCapsLock)
+	typeKeys(c, wsi.KeyD, wsi.Key2)
+	c.KeyboardModifier(wsi.ModCapsLock | wsi.ModShift)
+	typeKeys(c, wsi.KeyE)
+	c.KeyboardModifier(0)
+	typeKeys(c, wsi.KeyLeft, wsi.KeyLeft, wsi.KeyBackspace, wsi.KeyDelete, wsi.KeyHome, wsi.KeySpace, wsi.KeyEnd, wsi.KeyDot)
+	if s, i := c.Input(); s != " abC!e." || i != 7 {
+		t.Fatalf("Console.KeyboardKey: input\nhave %q, %d\nwant \" abC!e.\", 7", s, i)
+	}
+	typeKeys(c, wsi.KeyEsc)
+	if c.Open() {
+		t.Fatal("Console.KeyboardKey: console should be closed")
+	}
+}
+
+func TestConsoleExec(t *testing.T) {
+	v, err := NewCVar(&CVarParam[int]{Name: "test.console.x", Help: "x", Default: 1, Min: 0, Max: 10})
+	if err != nil {
+		t.Fatalf("NewCVar failed:\n%v", err)
+	}
+	defer v.Remove()
+	w, err := NewCVar(&CVarParam[bool]{Name: "test.console.y"})
+	if err != nil {
+		t.Fatalf("NewCVar failed:\n%v", err)
+	}
+	defer w.Remove()
+
+	c := NewConsole()
+	c.Exec("test.console.x 7")
+	if v.Get() != 7 {
+		t.Fatalf("Console.Exec: value\nhave %d\nwant 7", v.Get())
+	}
+	if out := c.Output(); !slices.Equal(out, []string{"> test.console.x 7", "test.console.x = 7"}) {
+		t.Fatalf("Console.Exec: output\nhave %q", out)
+	}
+	c.Exec("test.console.x 11")
+	c.Exec("test.console.z 1")
+	c.Exec("test.console.x")
+	out := c.Output()
+	if len(out) != 8 || !strings.Contains(out[3], "out of range") || !strings.Contains(out[5], "undefined") ||
+		out[7] != "test.console.x = 7 (int in [0, 10], default 1): x" {
+		t.Fatalf("Console.Exec: output\nhave %q", out)
+	}
+	c.Exec("clear")
+	c.Exec("reset test.console.x")
+	c.Exec("list test.console.")
+	if out := c.Output(); !slices.Equal(out, []string{
+		"> reset test.console.x",
+		"test.console.x = 1",
+		"> list test.console.",
+		"test.console.x = 1",
+		"test.console.y = false",
+	}) {
+		t.Fatalf("Console.Exec: output\nhave %q", out)
+	}
+	for range ConsoleLines {
+		c.Printf("a\nb")
+	}
+	if out := c.Output(); len(out) != ConsoleLines || out[0] != "a" || out[len(out)-1] != "b" {
+		t.Fatalf("Console.Printf: output\nhave %d lines", len(out))
+	}
+}
+
+func TestConsoleCompletion(t *testing.T) {
+	v, err := NewCVar(&CVarParam[int]{Name: "test.console.abc"})
+	if err != nil {
+		t.Fatalf("NewCVar failed:\n%v", err)
+	}
+	defer v.Remove()
+	w, err := NewCVar(&CVarParam[int]{Name: "test.console.abd"})
+	if err != nil {
+		t.Fatalf("NewCVar failed:\n%v", err)
+	}
+	defer w.Remove()
+
+	c := NewConsole()
+	c.SetOpen(true)
+	c.Exec("test.console.abc 1")
+	c.Exec("test.console.abd 2")
+	c.Exec("test.console.abd 2")
+	typeKeys(c, wsi.KeyT, wsi.KeyE, wsi.KeyS, wsi.KeyT, wsi.KeyDot, wsi.KeyC, wsi.KeyTab)
+	if s, _ := c.Input(); s != "test.console.ab" {
+		t.Fatalf("Console.KeyboardKey: completion\nhave %q\nwant \"test.console.ab\"", s)
+	}
+	if out := c.Output(); !slices.Equal(out[len(out)-2:], []string{"test.console.abc", "test.console.abd"}) {
+		t.Fatalf("Console.KeyboardKey: completion output\nhave %q", out)
+	}
+	typeKeys(c, wsi.KeyD, wsi.KeyTab, wsi.Key3, wsi.KeyReturn)
+	if w.Get() != 3 {
+		t.Fatalf("Console.KeyboardKey: value\nhave %d\nwant 3", w.Get())
+	}
+
+	// Duplicates are not added to the history.
+	for _, want := range [...]string{"test.console.abd 3", "test.console.abd 2", "test.console.abc 1", "test.console.abc 1"} {
+		typeKeys(c, wsi.KeyUp)
+		if s, _ := c.Input(); s != want {
+			t.Fatalf("Console.KeyboardKey: history\nhave %q\nwant %q", s, want)
+		}
+	}
+	typeKeys(c, wsi.KeyDown, wsi.KeyDown, wsi.KeyDown)
+	if s, _ := c.Input(); s != "" {
+		t.Fatalf("Console.KeyboardKey: history\nhave %q\nwant \"\"", s)
+	}
+}
+
+func TestRendererConsole(t *testing.T) {
+	rend, err := NewOffscreen(64, 48)
+	if err != nil {
+		t.Fatalf("NewOffscreen failed:\n%v", err)
+	}
+	defer rend.Free()
+	c := NewConsole()
+	rend.SetConsole(c)
+	if rend.Console() != c || rend.graph.find(consolePass) < 0 {
+		t.Fatal("Renderer.SetConsole: console should be set")
+	}
+	rend.SetConsole(nil)
+	if rend.Console() != nil || rend.graph.find(consolePass) >= 0 {
+		t.Fatal("Renderer.SetConsole(nil): console should not be set")
+	}
+}
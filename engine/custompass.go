@@ -0,0 +1,247 @@
+// Copyright 2024 Gustavo C. Viegas. All rights reserved.
+
+package engine
+
+import (
+	"strings"
+
+	"gviegas/neo3/driver"
+)
+
+// Stages of custom passes.
+// See PassParam.
+const (
+	// After opaque geometry and decals are
+	// drawn. The depth target is complete.
+	StageGeometry = stageGeometry
+	// Along with screen-space lighting effects.
+	StageLighting = stageLighting
+	// Along with blended geometry.
+	StageTransparency = stageTransparency
+	// Along with HDR post-processing.
+	StagePost = stagePost
+	// Along with tonemapping and color grading.
+	// It is not affected by dynamic resolution,
+	// so overlays such as UI belong here.
+	StageFinal = stageFinal
+)
+
+// Renderer targets that custom passes can use.
+// See PassParam.
+const (
+	// The HDR color target. It is single-sample;
+	// when MSAA is enabled, passes that precede
+	// StagePost render to a multi-sample target
+	// that is resolved into it instead (see
+	// PassContext.ColorTarget).
+	TargetColor = 1 << iota
+	// The depth target. Like TargetColor, it is
+	// single-sample and resolved from a
+	// multi-sample target when MSAA is enabled.
+	// Reading it makes the Renderer keep its
+	// contents after the geometry stage.
+	TargetDepth
+)
+
+// PassParam describes a custom pass.
+// Name identifies the pass and must be unique among
+// the custom passes of a Renderer.
+// Stage determines when the pass executes. Within a
+// stage, passes execute in the order that they were
+// added, and built-in passes are added when the
+// features that they implement are enabled.
+// Reads and Writes list the textures that the pass
+// samples and renders to, respectively. The textures
+// in Writes must be render targets. The Renderer's
+// own targets can be added to these lists by setting
+// ReadTargets and WriteTargets (e.g., TargetColor).
+// A pass cannot read from a texture that it writes
+// to, nor read from a texture before the first pass
+// that writes to it in a frame.
+// Stencil is the stencil state that the pass uses
+// with the Renderer's depth target. Its Mask can only
+// contain stencil bits that are reserved in the
+// Renderer, and the depth target must be in
+// WriteTargets if Mask is not zero.
+// Record is called to record the pass's commands.
+type PassParam struct {
+	Name         string
+	Stage        int
+	Reads        []*Texture
+	Writes       []*Texture
+	ReadTargets  int
+	WriteTargets int
+	Stencil      StencilState
+	Record       func(ctx *PassContext)
+}
+
+// PassContext is given to PassParam.Record.
+// Cmd is recording commands and has no active render
+// pass. Textures in Reads have been transitioned to
+// a read-only layout and textures in Writes to a
+// render target layout.
+// Reads and Writes contain the textures of the
+// respective PassParam fields, followed by the
+// Renderer's color and then depth targets if
+// requested. When MSAA is enabled, Writes of passes
+// that precede StagePost also contain the
+// multi-sample targets. They must not be modified.
+// ColorTarget and DSTarget are the render pass
+// attachments to use for the Renderer's targets.
+// They load and store their contents and, when
+// MSAA is enabled, refer to the multi-sample
+// targets and resolve into the Renderer's ones.
+// They are only meaningful if the respective
+// target was requested in PassParam.WriteTargets.
+// Stencil is PassParam.Stencil. Passes that create
+// pipelines should configure them with
+// StencilState.SetDS.
+// Viewport and Scissor define the area that the pass
+// must render to. Passes of StageGeometry through
+// StageTransparency are called once per Viewport,
+// while the others may be called once for the whole
+// render target (see Renderer.SetViewportPost).
+// If dynamic resolution is enabled, the area is
+// scaled by Renderer.RenderScale, except in passes
+// of StageFinal, which render at the native
+// resolution to the upscaled color target.
+// UIScale is the Renderer's UI scale, by which passes
+// that draw text and UI should scale their content
+// (see Renderer.SetUIScale).
+type PassContext struct {
+	Cmd         driver.CmdBuffer
+	Reads       []*Texture
+	Writes      []*Texture
+	ColorTarget driver.ColorTarget
+	DSTarget    driver.DSTarget
+	Stencil     StencilState
+	Viewport    driver.Viewport
+	Scissor     driver.Scissor
+	UIScale     float32
+}
+
+// customPrefix is prepended to the names of custom
+// passes so they do not clash with built-in ones.
+const customPrefix = "custom."
+
+// AddPass adds a custom pass to r.
+func (r *Renderer) AddPass(param *PassParam) error {
+	var reason string
+	switch {
+	case param == nil:
+		reason = "nil pass param"
+	case param.Name == "":
+		reason = "empty pass name"
+	case param.Stage < StageGeometry || param.Stage > StageFinal:
+		reason = "undefined pass stage"
+	case (param.ReadTargets|param.WriteTargets)&^(TargetColor|TargetDepth) != 0:
+		reason = "undefined pass target"
+	case param.Record == nil:
+		reason = "nil pass Record func"
+	case r.graph.find(customPrefix+param.Name) >= 0:
+		reason = "pass " + param.Name + " already exists"
+	case param.Stencil.Mask != 0 && param.WriteTargets&TargetDepth == 0:
+		reason = "pass stencil requires TargetDepth"
+	default:
+		if reason = param.Stencil.validate(r.stencil); reason == "" {
+			goto validParam
+		}
+	}
+	return newRendErr(reason)
+validParam:
+	// The pass loads and stores r.ds (see
+	// sceneTargets), so its contents must
+	// outlive the render pass.
+	if err := r.sampleableDepth(); err != nil {
+		return err
+	}
+	reads := r.resolveTargets(param.Reads, param.ReadTargets, param.Stage)
+	writes := r.resolveTargets(param.Writes, param.WriteTargets, param.Stage)
+	record := param.Record
+	stencil := param.Stencil
+	n := &passNode{
+		name:   customPrefix + param.Name,
+		stage:  param.Stage,
+		reads:  reads,
+		writes: r.msaaWrites(writes, param.Stage),
+		msaa:   param.Stage < stagePost,
+	}
+	n.record = func(r *Renderer, cb driver.CmdBuffer) {
+		vp, sciss := r.curBounds()
+		ctx := &PassContext{
+			Cmd:      cb,
+			Reads:    n.reads,
+			Writes:   n.writes,
+			Viewport: vp,
+			Scissor:  sciss,
+			UIScale:  r.uiScale,
+			Stencil:  stencil,
+		}
+		ctx.ColorTarget, ctx.DSTarget = r.sceneTargets(n.stage)
+		record(ctx)
+	}
+	r.graph.add(n)
+	if err := r.graph.validate(); err != nil {
+		r.graph.remove(n.name)
+		return err
+	}
+	return nil
+}
+
+// RemovePass removes the custom pass with the given
+// name from r.
+// It returns false if no such pass exists.
+func (r *Renderer) RemovePass(name string) bool {
+	return r.graph.remove(customPrefix + name)
+}
+
+// Passes returns the names of the custom passes in r,
+// in execution order.
+func (r *Renderer) Passes() []string {
+	var s []string
+	for _, n := range r.graph.nodes {
+		if name, ok := strings.CutPrefix(n.name, customPrefix); ok {
+			s = append(s, name)
+		}
+	}
+	return s
+}
+
+// SetUIScale sets the scale of text and UI in r.
+// It is given to custom passes in PassContext.UIScale,
+// and should be the scale factor of the window that r
+// presents to (see wsi.WindowScale), so overlays look
+// the same on monitors of different pixel densities.
+// Sizes given in pixels elsewhere (e.g., the size of a
+// Gizmo) should be multiplied by it as well.
+// Onscreen renderers start with the scale factor of
+// their window, and others with 1.
+func (r *Renderer) SetUIScale(scale float32) error {
+	if !(scale > 0) {
+		return newRendErr("non-positive UI scale")
+	}
+	r.uiScale = scale
+	return nil
+}
+
+// UIScale returns the scale of text and UI in r.
+func (r *Renderer) UIScale() float32 { return r.uiScale }
+
+// resolveTargets returns a new slice containing texs
+// followed by the targets of r identified by flags,
+// as used by passes of the given stage.
+func (r *Renderer) resolveTargets(texs []*Texture, flags, stage int) []*Texture {
+	s := make([]*Texture, len(texs), len(texs)+2)
+	copy(s, texs)
+	if flags&TargetColor != 0 {
+		if stage == stageFinal {
+			s = append(s, r.finalColor())
+		} else {
+			s = append(s, r.hdr)
+		}
+	}
+	if flags&TargetDepth != 0 {
+		s = append(s, r.ds)
+	}
+	return s
+}
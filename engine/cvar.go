@@ -0,0 +1,494 @@
+// Copyright 2024 Gustavo C. Viegas. All rights reserved.
+
+package engine
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode"
+)
+
+const cvarPrefix = "cvar: "
+
+func newCVarErr(reason string) error { return newErr(cvarPrefix, reason, ErrInvalidParam) }
+
+// CVarType is the set of types of console variables.
+type CVarType interface {
+	bool | int | float64 | string
+}
+
+// CVarParam describes a console variable of type T.
+// Name identifies the variable. It must be non-empty
+// and cannot contain whitespace. By convention, names
+// are lowercase, dot-separated paths (e.g.,
+// "tuning.msaa").
+// Help is a short description of the variable.
+// Default is its initial value.
+// Min and Max bound the values of int and float64
+// variables, inclusive. They are ignored if Min is
+// not less than Max, and for other types.
+// OnChange, if not nil, is called with every new
+// value before it is set. If it returns an error,
+// the value is not changed. It must not set the
+// variable itself.
+type CVarParam[T CVarType] struct {
+	Name     string
+	Help     string
+	Default  T
+	Min, Max float64
+	OnChange func(T) error
+}
+
+// CVar is a console variable of type T.
+// Console variables are global, and can be changed by
+// name (see SetCVar), from configuration files (see
+// LoadCVars) and through a Console.
+// CVar methods are safe for concurrent use, but the
+// OnChange callback runs in the goroutine that sets
+// the value.
+type CVar[T CVarType] struct{ v *cvar }
+
+// cvar is what the registry stores.
+type cvar struct {
+	mu       sync.Mutex
+	name     string
+	help     string
+	def, val any
+	min, max float64
+	// set validates and sets the value.
+	// It must be called with mu held.
+	set func(any) error
+	// get, if not nil, returns the value in place
+	// of val (i.e., the variable mirrors state
+	// that can also change elsewhere).
+	get func() any
+}
+
+// cvars is the registry of console variables.
+var cvars = struct {
+	sync.Mutex
+	m map[string]*cvar
+}{m: make(map[string]*cvar)}
+
+// NewCVar registers a new console variable.
+// It fails if a variable with the same name exists.
+func NewCVar[T CVarType](param *CVarParam[T]) (*CVar[T], error) {
+	return newCVar(param, nil)
+}
+
+// newCVar is like NewCVar, but if get is not nil, the
+// value of the variable is given by it rather than by
+// the last value set.
+func newCVar[T CVarType](param *CVarParam[T], get func() T) (*CVar[T], error) {
+	var reason string
+	switch {
+	case param == nil:
+		reason = "nil param"
+	case param.Name == "", strings.ContainsFunc(param.Name, unicode.IsSpace):
+		reason = "invalid name"
+	case !cvarInRange(param.Default, param.Min, param.Max):
+		reason = "default value out of range"
+	default:
+		goto validParam
+	}
+	return nil, newCVarErr(reason)
+validParam:
+	v := &cvar{
+		name: param.Name,
+		help: param.Help,
+		def:  param.Default,
+		val:  param.Default,
+		min:  param.Min,
+		max:  param.Max,
+	}
+	onChange := param.OnChange
+	v.set = func(x any) error {
+		y := x.(T)
+		if !cvarInRange(y, v.min, v.max) {
+			return newCVarErr(v.name + ": value out of range")
+		}
+		if onChange != nil {
+			if err := onChange(y); err != nil {
+				return err
+			}
+		}
+		v.val = y
+		return nil
+	}
+	if get != nil {
+		v.get = func() any { return get() }
+	}
+	cvars.Lock()
+	defer cvars.Unlock()
+	if _, ok := cvars.m[v.name]; ok {
+		return nil, newCVarErr("duplicate name: " + v.name)
+	}
+	cvars.m[v.name] = v
+	return &CVar[T]{v}, nil
+}
+
+// cvarInRange returns whether x is in the interval
+// [min, max], if x is numeric and min < max.
+func cvarInRange[T CVarType](x T, min, max float64) bool {
+	if !(min < max) {
+		return true
+	}
+	var f float64
+	switch x := any(x).(type) {
+	case int:
+		f = float64(x)
+	case float64:
+		f = x
+	default:
+		return true
+	}
+	return f >= min && f <= max
+}
+
+// Name returns the name of c.
+func (c *CVar[T]) Name() string { return c.v.name }
+
+// Get returns the value of c.
+func (c *CVar[T]) Get() T { return c.v.value().(T) }
+
+// Set sets the value of c.
+// It fails if x is out of range or if the OnChange
+// callback fails.
+func (c *CVar[T]) Set(x T) error {
+	c.v.mu.Lock()
+	defer c.v.mu.Unlock()
+	return c.v.set(x)
+}
+
+// Reset sets the value of c to its default.
+func (c *CVar[T]) Reset() error { return c.Set(c.v.def.(T)) }
+
+// Remove unregisters c. It has no effect if c was
+// removed already.
+func (c *CVar[T]) Remove() { removeCVars(c.v) }
+
+// removeCVars unregisters the given variables.
+func removeCVars(vs ...*cvar) {
+	cvars.Lock()
+	defer cvars.Unlock()
+	for _, v := range vs {
+		if cvars.m[v.name] == v {
+			delete(cvars.m, v.name)
+		}
+	}
+}
+
+// value returns the value of v.
+func (v *cvar) value() any {
+	if v.get != nil {
+		return v.get()
+	}
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	return v.val
+}
+
+// typ returns the name of v's type.
+func (v *cvar) typ() string {
+	switch v.def.(type) {
+	case bool:
+		return "bool"
+	case int:
+		return "int"
+	case float64:
+		return "float"
+	default:
+		return "string"
+	}
+}
+
+// parse parses s as a value of v's type.
+func (v *cvar) parse(s string) (x any, err error) {
+	switch v.def.(type) {
+	case bool:
+		x, err = strconv.ParseBool(s)
+	case int:
+		x, err = strconv.Atoi(s)
+	case float64:
+		x, err = strconv.ParseFloat(s, 64)
+	default:
+		x = s
+	}
+	if err != nil {
+		return nil, newCVarErr(v.name + ": invalid " + v.typ() + " value " + strconv.Quote(s))
+	}
+	return
+}
+
+// formatCVar formats a value of a console variable.
+func formatCVar(x any) string {
+	switch x := x.(type) {
+	case bool:
+		return strconv.FormatBool(x)
+	case int:
+		return strconv.Itoa(x)
+	case float64:
+		return strconv.FormatFloat(x, 'g', -1, 64)
+	default:
+		return x.(string)
+	}
+}
+
+// lookupCVar returns the variable with the given name,
+// or nil if there is none.
+func lookupCVar(name string) *cvar {
+	cvars.Lock()
+	defer cvars.Unlock()
+	return cvars.m[name]
+}
+
+// CVarInfo describes a console variable.
+// Type is one of "bool", "int", "float" or "string",
+// and Value and Default are formatted as accepted by
+// SetCVar.
+type CVarInfo struct {
+	Name     string
+	Help     string
+	Type     string
+	Value    string
+	Default  string
+	Min, Max float64
+}
+
+// info returns the CVarInfo of v.
+func (v *cvar) info() CVarInfo {
+	return CVarInfo{
+		Name:    v.name,
+		Help:    v.help,
+		Type:    v.typ(),
+		Value:   formatCVar(v.value()),
+		Default: formatCVar(v.def),
+		Min:     v.min,
+		Max:     v.max,
+	}
+}
+
+// LookupCVar returns the description of the console
+// variable with the given name.
+// It returns false if no such variable exists.
+func LookupCVar(name string) (CVarInfo, bool) {
+	v := lookupCVar(name)
+	if v == nil {
+		return CVarInfo{}, false
+	}
+	return v.info(), true
+}
+
+// CVars returns the descriptions of the console
+// variables whose names start with prefix, sorted by
+// name.
+func CVars(prefix string) []CVarInfo {
+	cvars.Lock()
+	var vs []*cvar
+	for name, v := range cvars.m {
+		if strings.HasPrefix(name, prefix) {
+			vs = append(vs, v)
+		}
+	}
+	cvars.Unlock()
+	slices.SortFunc(vs, func(a, b *cvar) int { return strings.Compare(a.name, b.name) })
+	s := make([]CVarInfo, len(vs))
+	for i, v := range vs {
+		s[i] = v.info()
+	}
+	return s
+}
+
+// SetCVar sets the console variable with the given
+// name from its string representation. Booleans are
+// parsed with strconv.ParseBool, integers with
+// strconv.Atoi and floats with strconv.ParseFloat.
+// Strings are used as is.
+func SetCVar(name, value string) error {
+	v := lookupCVar(name)
+	if v == nil {
+		return newCVarErr("undefined variable: " + name)
+	}
+	x, err := v.parse(value)
+	if err != nil {
+		return err
+	}
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	return v.set(x)
+}
+
+// ResetCVar sets the console variable with the given
+// name to its default value.
+func ResetCVar(name string) error {
+	v := lookupCVar(name)
+	if v == nil {
+		return newCVarErr("undefined variable: " + name)
+	}
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	return v.set(v.def)
+}
+
+// LoadCVars sets console variables from a
+// configuration file.
+// Each line contains the name of a variable and its
+// value, separated by whitespace. The value extends
+// to the end of the line, excluding leading and
+// trailing whitespace. Blank lines and lines starting
+// with '#' are ignored.
+// Every line is applied, even if previous lines
+// fail. The errors of the lines that fail are joined
+// and returned, along with their line numbers.
+func LoadCVars(r io.Reader) error {
+	var errs []error
+	sc := bufio.NewScanner(r)
+	for n := 1; sc.Scan(); n++ {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" || line[0] == '#' {
+			continue
+		}
+		name, value := line, ""
+		if i := strings.IndexFunc(line, unicode.IsSpace); i >= 0 {
+			name, value = line[:i], strings.TrimSpace(line[i:])
+		}
+		if err := SetCVar(name, value); err != nil {
+			errs = append(errs, fmt.Errorf("line %d: %w", n, err))
+		}
+	}
+	if err := sc.Err(); err != nil {
+		errs = append(errs, err)
+	}
+	return errors.Join(errs...)
+}
+
+// SaveCVars writes the console variables whose values
+// differ from their defaults to w, in the format that
+// LoadCVars reads. Each variable is preceded by a
+// comment with its help text.
+func SaveCVars(w io.Writer) error {
+	bw := bufio.NewWriter(w)
+	for _, x := range CVars("") {
+		if x.Value == x.Default {
+			continue
+		}
+		if x.Help != "" {
+			fmt.Fprintf(bw, "# %s\n", x.Help)
+		}
+		fmt.Fprintf(bw, "%s %s\n", x.Name, x.Value)
+	}
+	return bw.Flush()
+}
+
+// Console variables of the current Tuning.
+// They always reflect CurrentTuning.
+func init() {
+	tune := func(f func(*Tuning)) error {
+		t := CurrentTuning()
+		f(&t)
+		return SetTuning(&t)
+	}
+	must := func(_ *cvar, err error) {
+		if err != nil {
+			panic(err)
+		}
+	}
+	must(cvarOf(newCVar(&CVarParam[int]{
+		Name:     "tuning.streaming_budget",
+		Help:     "bytes of texture data streamed per submission",
+		Default:  int(defaultConfig.Tuning.StreamingBudget),
+		OnChange: func(x int) error { return tune(func(t *Tuning) { t.StreamingBudget = int64(x) }) },
+	}, func() int { return int(CurrentTuning().StreamingBudget) })))
+	must(cvarOf(newCVar(&CVarParam[int]{
+		Name:     "tuning.shadow_resolution",
+		Help:     "size of point light shadow maps",
+		Default:  defaultConfig.Tuning.ShadowResolution,
+		OnChange: func(x int) error { return tune(func(t *Tuning) { t.ShadowResolution = x }) },
+	}, func() int { return CurrentTuning().ShadowResolution })))
+	must(cvarOf(newCVar(&CVarParam[int]{
+		Name:     "tuning.msaa",
+		Help:     "initial MSAA sample count of renderers",
+		Default:  defaultConfig.Tuning.MSAA,
+		OnChange: func(x int) error { return tune(func(t *Tuning) { t.MSAA = x }) },
+	}, func() int { return CurrentTuning().MSAA })))
+}
+
+// RegisterCVars registers console variables for the
+// settings of r. Their names are prefix followed by:
+//
+//	.debug_view   (int)   see SetDebugView
+//	.msaa         (int)   see SetMSAA
+//	.portal_depth (int)   see SetPortalDepth
+//	.ui_scale     (float) see SetUIScale
+//
+// The variables always reflect r's settings, and are
+// removed when r is freed. Distinct renderers must
+// use distinct prefixes.
+func (r *Renderer) RegisterCVars(prefix string) error {
+	if len(r.cvars) > 0 {
+		return newRendErr("console variables already registered")
+	}
+	var vs []*cvar
+	add := func(v *cvar, err error) error {
+		if err != nil {
+			removeCVars(vs...)
+			return err
+		}
+		vs = append(vs, v)
+		return nil
+	}
+	if err := add(cvarOf(newCVar(&CVarParam[int]{
+		Name:     prefix + ".debug_view",
+		Help:     "debug view (0 disables)",
+		Default:  DebugNone,
+		Min:      DebugNone,
+		Max:      DebugLightCount,
+		OnChange: r.SetDebugView,
+	}, func() int { return r.debug }))); err != nil {
+		return err
+	}
+	if err := add(cvarOf(newCVar(&CVarParam[int]{
+		Name:     prefix + ".msaa",
+		Help:     "MSAA sample count (1 disables)",
+		Default:  r.MSAA(),
+		Min:      1,
+		Max:      64,
+		OnChange: r.SetMSAA,
+	}, r.MSAA))); err != nil {
+		return err
+	}
+	if err := add(cvarOf(newCVar(&CVarParam[int]{
+		Name:     prefix + ".portal_depth",
+		Help:     "maximum recursion depth of portals",
+		Default:  r.PortalDepth(),
+		Min:      1,
+		Max:      MaxPortalDepth,
+		OnChange: r.SetPortalDepth,
+	}, r.PortalDepth))); err != nil {
+		return err
+	}
+	if err := add(cvarOf(newCVar(&CVarParam[float64]{
+		Name:     prefix + ".ui_scale",
+		Help:     "scale of text and UI",
+		Default:  float64(r.uiScale),
+		OnChange: func(x float64) error { return r.SetUIScale(float32(x)) },
+	}, func() float64 { return float64(r.uiScale) }))); err != nil {
+		return err
+	}
+	r.cvars = vs
+	return nil
+}
+
+// cvarOf returns the variable created by newCVar.
+func cvarOf[T CVarType](c *CVar[T], err error) (*cvar, error) {
+	if err != nil {
+		return nil, err
+	}
+	return c.v, nil
+}
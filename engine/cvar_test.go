@@ -0,0 +1,204 @@
+// Copyright 2024 Gustavo C. Viegas. All rights reserved.
+
+package engine
+
+import (
+	"bytes"
+	"errors"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestCVar(t *testing.T) {
+	for _, p := range [...]*CVarParam[int]{
+		nil,
+		{},
+		{Name: "test cvar"},
+		{Name: "test.cvar", Default: 10, Min: 0, Max: 5},
+	} {
+		if _, err := NewCVar(p); err == nil {
+			t.Fatalf("NewCVar(%v): should have failed", p)
+		}
+	}
+
+	var changed []int
+	c, err := NewCVar(&CVarParam[int]{
+		Name:    "test.cvar",
+		Help:    "a test variable",
+		Default: 2,
+		Min:     1,
+		Max:     4,
+		OnChange: func(x int) error {
+			if x == 3 {
+				return errors.New("3 rejected")
+			}
+			changed = append(changed, x)
+			return nil
+		},
+	})
+	if err != nil {
+		t.Fatalf("NewCVar failed:\n%v", err)
+	}
+	defer c.Remove()
+	if _, err := NewCVar(&CVarParam[bool]{Name: "test.cvar"}); err == nil {
+		t.Fatal("NewCVar: duplicate name should have failed")
+	}
+	if x := c.Get(); x != 2 {
+		t.Fatalf("CVar.Get:\nhave %d\nwant 2", x)
+	}
+	if err := c.Set(4); err != nil {
+		t.Fatalf("CVar.Set failed:\n%v", err)
+	}
+	for _, x := range [...]int{0, 3, 5} {
+		if err := c.Set(x); err == nil {
+			t.Fatalf("CVar.Set(%d): should have failed", x)
+		}
+	}
+	if x := c.Get(); x != 4 {
+		t.Fatalf("CVar.Get:\nhave %d\nwant 4", x)
+	}
+	if err := SetCVar("test.cvar", "1"); err != nil {
+		t.Fatalf("SetCVar failed:\n%v", err)
+	}
+	for _, s := range [...]string{"", "x", "1.5", "9"} {
+		if err := SetCVar("test.cvar", s); err == nil {
+			t.Fatalf("SetCVar(%q): should have failed", s)
+		}
+	}
+	if err := SetCVar("test.undefined", "1"); err == nil {
+		t.Fatal("SetCVar: undefined variable should have failed")
+	}
+	if err := ResetCVar("test.cvar"); err != nil {
+		t.Fatalf("ResetCVar failed:\n%v", err)
+	}
+	if s := []int{4, 1, 2}; !slices.Equal(changed, s) {
+		t.Fatalf("CVarParam.OnChange: calls\nhave %v\nwant %v", changed, s)
+	}
+
+	x, ok := LookupCVar("test.cvar")
+	want := CVarInfo{"test.cvar", "a test variable", "int", "2", "2", 1, 4}
+	if !ok || x != want {
+		t.Fatalf("LookupCVar:\nhave %+v, %t\nwant %+v, true", x, ok, want)
+	}
+	c.Remove()
+	if _, ok := LookupCVar("test.cvar"); ok {
+		t.Fatal("CVar.Remove: variable should not exist")
+	}
+	c.Remove()
+}
+
+func TestLoadCVars(t *testing.T) {
+	b, err := NewCVar(&CVarParam[bool]{Name: "test.b", Help: "a bool"})
+	if err != nil {
+		t.Fatalf("NewCVar failed:\n%v", err)
+	}
+	defer b.Remove()
+	f, err := NewCVar(&CVarParam[float64]{Name: "test.f", Default: 0.5, Min: 0, Max: 1})
+	if err != nil {
+		t.Fatalf("NewCVar failed:\n%v", err)
+	}
+	defer f.Remove()
+	s, err := NewCVar(&CVarParam[string]{Name: "test.s", Default: "a"})
+	if err != nil {
+		t.Fatalf("NewCVar failed:\n%v", err)
+	}
+	defer s.Remove()
+
+	cfg := "# comment\n\ntest.b true\n  test.f\t0.25 \ntest.s  two words\ntest.f 2\ntest.x 1\n"
+	err = LoadCVars(strings.NewReader(cfg))
+	if err == nil || !strings.Contains(err.Error(), "line 6: ") || !strings.Contains(err.Error(), "line 7: ") {
+		t.Fatalf("LoadCVars: lines 6 and 7 should have failed\n%v", err)
+	}
+	if b.Get() != true || f.Get() != 0.25 || s.Get() != "two words" {
+		t.Fatalf("LoadCVars:\nhave %t, %g, %q\nwant true, 0.25, \"two words\"", b.Get(), f.Get(), s.Get())
+	}
+
+	var buf bytes.Buffer
+	if err := SaveCVars(&buf); err != nil {
+		t.Fatalf("SaveCVars failed:\n%v", err)
+	}
+	var lines []string
+	for _, l := range strings.Split(buf.String(), "\n") {
+		if strings.HasPrefix(l, "test.") || l == "# a bool" {
+			lines = append(lines, l)
+		}
+	}
+	want := []string{"# a bool", "test.b true", "test.f 0.25", "test.s two words"}
+	if strings.Join(lines, "\n") != strings.Join(want, "\n") {
+		t.Fatalf("SaveCVars:\nhave %q\nwant %q", lines, want)
+	}
+
+	for _, x := range CVars("test.") {
+		if err := ResetCVar(x.Name); err != nil {
+			t.Fatalf("ResetCVar failed:\n%v", err)
+		}
+	}
+	if err := LoadCVars(&buf); err != nil {
+		t.Fatalf("LoadCVars failed:\n%v", err)
+	}
+	if b.Get() != true || f.Get() != 0.25 || s.Get() != "two words" {
+		t.Fatalf("LoadCVars(SaveCVars):\nhave %t, %g, %q\nwant true, 0.25, \"two words\"", b.Get(), f.Get(), s.Get())
+	}
+}
+
+func TestTuningCVars(t *testing.T) {
+	old := CurrentTuning()
+	defer SetTuning(&old)
+	if err := SetCVar("tuning.shadow_resolution", "512"); err != nil {
+		t.Fatalf("SetCVar failed:\n%v", err)
+	}
+	if x := CurrentTuning().ShadowResolution; x != 512 {
+		t.Fatalf("SetCVar: Tuning.ShadowResolution\nhave %d\nwant 512", x)
+	}
+	tun := CurrentTuning()
+	tun.MSAA = 4
+	if err := SetTuning(&tun); err != nil {
+		t.Fatalf("SetTuning failed:\n%v", err)
+	}
+	if x, _ := LookupCVar("tuning.msaa"); x.Value != "4" {
+		t.Fatalf("LookupCVar: tuning.msaa\nhave %s\nwant 4", x.Value)
+	}
+}
+
+func TestRendererCVars(t *testing.T) {
+	rend, err := NewOffscreen(64, 48)
+	if err != nil {
+		t.Fatalf("NewOffscreen failed:\n%v", err)
+	}
+	freed := false
+	defer func() {
+		if !freed {
+			rend.Free()
+		}
+	}()
+	if err := rend.RegisterCVars("test.rend"); err != nil {
+		t.Fatalf("Renderer.RegisterCVars failed:\n%v", err)
+	}
+	if err := rend.RegisterCVars("test.rend2"); err == nil {
+		t.Fatal("Renderer.RegisterCVars: should have failed")
+	}
+	if xs := CVars("test.rend."); len(xs) != 4 {
+		t.Fatalf("CVars: len\nhave %d\nwant 4", len(xs))
+	}
+	if err := SetCVar("test.rend.debug_view", "1"); err != nil {
+		t.Fatalf("SetCVar failed:\n%v", err)
+	}
+	if x := rend.DebugView(); x != 1 {
+		t.Fatalf("SetCVar: Renderer.DebugView\nhave %d\nwant 1", x)
+	}
+	if err := SetCVar("test.rend.portal_depth", "9"); err == nil {
+		t.Fatal("SetCVar: portal depth out of range should have failed")
+	}
+	if err := rend.SetUIScale(2); err != nil {
+		t.Fatalf("Renderer.SetUIScale failed:\n%v", err)
+	}
+	if x, _ := LookupCVar("test.rend.ui_scale"); x.Value != "2" {
+		t.Fatalf("LookupCVar: ui_scale\nhave %s\nwant 2", x.Value)
+	}
+	rend.Free()
+	freed = true
+	if xs := CVars("test.rend."); len(xs) != 0 {
+		t.Fatalf("Renderer.Free: CVars\nhave %d\nwant 0", len(xs))
+	}
+}
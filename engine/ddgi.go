@@ -0,0 +1,374 @@
+// Copyright 2024 Gustavo C. Viegas. All rights reserved.
+
+package engine
+
+import (
+	"math"
+	"math/rand/v2"
+
+	"gviegas/neo3/driver"
+	"gviegas/neo3/engine/internal/shader"
+	"gviegas/neo3/linear"
+)
+
+// DDGIParam describes a grid of irradiance probes used
+// for dynamic diffuse global illumination.
+// Probes are placed at Origin + Spacing * (x, y, z),
+// for every (x, y, z) within Counts. Every frame, each
+// probe traces Rays rays into the scene and blends the
+// resulting irradiance and distances into its data,
+// with Hysteresis (in [0, 1)) being the weight of the
+// previous data. Higher values reduce flickering at
+// the cost of slower response to lighting changes.
+// Shading interpolates the eight probes surrounding
+// each point, using the distance data to prevent
+// light from leaking through walls. NormalBias and
+// ViewBias offset the interpolation point along the
+// surface normal and towards the viewer, respectively,
+// which reduces self-shadowing.
+// If Relocate is set, probes that end up inside or too
+// close to geometry are moved, by up to MaxOffset (in
+// [0, 0.5)) times Spacing, towards open space.
+type DDGIParam struct {
+	Origin     linear.V3
+	Spacing    linear.V3
+	Counts     [3]int
+	Rays       int
+	Hysteresis float32
+	NormalBias float32
+	ViewBias   float32
+	Relocate   bool
+	MaxOffset  float32
+}
+
+// DDGI limits.
+const (
+	// Rays whose directions are not rotated.
+	// Relocation only considers these, so it does
+	// not change with the ray rotation.
+	ddgiFixedRays = 32
+	ddgiMaxRays   = 256
+	ddgiMaxProbes = 1 << 14
+)
+
+// Size, in texels, of the octahedral map that each
+// probe stores in the atlases, excluding a one texel
+// border used for bilinear filtering.
+const (
+	ddgiIrradRes = 6
+	ddgiVisRes   = 14
+)
+
+// Pixel formats of the DDGI textures.
+const (
+	ddgiIrradFmt = driver.RGBA16Float
+	ddgiVisFmt   = driver.RG16Float
+	ddgiDataFmt  = driver.RGBA16Float
+	ddgiRayFmt   = driver.RGBA16Float
+)
+
+// ddgi is the state of the DDGI passes.
+type ddgi struct {
+	param  DDGIParam
+	layout shader.DDGILayout
+	// Radiance (RGB) and hit distance (A) of
+	// every ray traced in the current frame.
+	// Each row holds the rays of one probe.
+	rays *Texture
+	// Atlases of probe irradiance and of the
+	// mean and mean squared distance to the
+	// nearest surface (RG). Probes are laid out
+	// as in ddgiProbeTile.
+	irrad *Texture
+	vis   *Texture
+	// World space offset (RGB) of every probe,
+	// one texel per probe.
+	data *Texture
+	// Number of frames advanced, used to select
+	// the ray rotation.
+	frame uint64
+}
+
+// Names of the DDGI passes.
+const (
+	ddgiTracePass    = "ddgi.trace"
+	ddgiUpdatePass   = "ddgi.update"
+	ddgiRelocatePass = "ddgi.relocate"
+)
+
+// SetDDGI enables dynamic diffuse global illumination
+// in r, replacing the global irradiance map.
+// If param is nil, DDGI is disabled.
+// Changing Counts or Rays discards the probe data.
+func (r *Renderer) SetDDGI(param *DDGIParam) error {
+	if param == nil {
+		r.freeDDGI()
+		return nil
+	}
+	var reason string
+	switch {
+	case param.Spacing[0] <= 0 || param.Spacing[1] <= 0 || param.Spacing[2] <= 0:
+		reason = "invalid DDGI probe spacing"
+	case param.Counts[0] < 1 || param.Counts[1] < 1 || param.Counts[2] < 1:
+		reason = "invalid DDGI probe count"
+	case param.Counts[0]*param.Counts[1]*param.Counts[2] > ddgiMaxProbes:
+		reason = "too many DDGI probes"
+	case param.Rays < ddgiFixedRays || param.Rays > ddgiMaxRays:
+		reason = "DDGI ray count out of range"
+	case !(param.Hysteresis >= 0 && param.Hysteresis < 1):
+		reason = "DDGI hysteresis out of range"
+	case param.NormalBias < 0 || param.ViewBias < 0:
+		reason = "negative DDGI bias"
+	case !(param.MaxOffset >= 0 && param.MaxOffset < 0.5):
+		reason = "DDGI probe offset out of range"
+	default:
+		goto validParam
+	}
+	return newRendErr(reason)
+validParam:
+	if r.ddgi != nil && (r.ddgi.param.Counts != param.Counts || r.ddgi.param.Rays != param.Rays) {
+		r.freeDDGI()
+	}
+	if r.ddgi == nil {
+		if err := r.initDDGI(param); err != nil {
+			return err
+		}
+	}
+	d := r.ddgi
+	d.param = *param
+	counts := [3]uint32{uint32(param.Counts[0]), uint32(param.Counts[1]), uint32(param.Counts[2])}
+	d.layout.SetGrid(&param.Origin, &param.Spacing, counts)
+	d.layout.SetRays(param.Rays)
+	d.layout.SetHysteresis(param.Hysteresis)
+	d.layout.SetBias(param.NormalBias, param.ViewBias)
+	d.layout.SetMaxOffset(param.MaxOffset)
+	var flags uint32
+	if param.Relocate {
+		flags |= shader.DDGIRelocate
+		if r.graph.find(ddgiRelocatePass) < 0 {
+			r.graph.addAfter(ddgiUpdatePass, &passNode{
+				name:   ddgiRelocatePass,
+				stage:  stageGeometry,
+				reads:  []*Texture{d.rays},
+				writes: []*Texture{d.data},
+				async:  true,
+			})
+		}
+	} else {
+		// Probes return to their grid positions.
+		r.graph.remove(ddgiRelocatePass)
+	}
+	d.layout.SetFlags(flags)
+	return nil
+}
+
+// DDGI returns the DDGI parameters of r.
+// If DDGI is disabled, it returns false.
+func (r *Renderer) DDGI() (DDGIParam, bool) {
+	if r.ddgi == nil {
+		return DDGIParam{}, false
+	}
+	return r.ddgi.param, true
+}
+
+// initDDGI creates the DDGI textures for the grid
+// described by param and adds the trace and update
+// passes to r's frame graph.
+func (r *Renderer) initDDGI(param *DDGIParam) (err error) {
+	d := new(ddgi)
+	defer func() {
+		if err != nil {
+			d.free()
+		}
+	}()
+	n := param.Counts[0] * param.Counts[1] * param.Counts[2]
+	for _, x := range [...]struct {
+		tex **Texture
+		pf  driver.PixelFmt
+		dim driver.Dim3D
+	}{
+		{&d.rays, ddgiRayFmt, driver.Dim3D{Width: param.Rays, Height: n}},
+		{&d.irrad, ddgiIrradFmt, ddgiAtlasSize(param.Counts, ddgiIrradRes)},
+		{&d.vis, ddgiVisFmt, ddgiAtlasSize(param.Counts, ddgiVisRes)},
+		{&d.data, ddgiDataFmt, driver.Dim3D{Width: param.Counts[0] * param.Counts[2], Height: param.Counts[1]}},
+	} {
+		*x.tex, err = newStorage(&TexParam{
+			PixelFmt: x.pf,
+			Dim3D:    x.dim,
+			Layers:   1,
+			Levels:   1,
+			Samples:  1,
+		})
+		if err != nil {
+			return
+		}
+	}
+	r.ddgi = d
+	// Probe rays are traced with compute, since
+	// the driver exposes no ray tracing pipeline.
+	// They are shaded using the previous frame's
+	// irradiance, which accumulates bounces over
+	// time. The atlases are then updated in place.
+	// Both run before opaque geometry is shaded,
+	// so shading sees the current frame's data.
+	// They only use compute, so they may run
+	// asynchronously with geometry that does not
+	// depend on them.
+	r.graph.addFirst(&passNode{
+		name:   ddgiTracePass,
+		stage:  stageGeometry,
+		reads:  []*Texture{d.irrad, d.vis, d.data},
+		writes: []*Texture{d.rays},
+		async:  true,
+	})
+	r.graph.addAfter(ddgiTracePass, &passNode{
+		name:   ddgiUpdatePass,
+		stage:  stageGeometry,
+		reads:  []*Texture{d.rays},
+		writes: []*Texture{d.irrad, d.vis},
+		async:  true,
+	})
+	return
+}
+
+// freeDDGI removes the DDGI passes from r's frame
+// graph and frees the DDGI textures.
+func (r *Renderer) freeDDGI() {
+	if r.ddgi == nil {
+		return
+	}
+	r.graph.remove(ddgiTracePass)
+	r.graph.remove(ddgiUpdatePass)
+	r.graph.remove(ddgiRelocatePass)
+	r.ddgi.free()
+	r.ddgi = nil
+}
+
+// free frees the DDGI textures.
+func (d *ddgi) free() {
+	for _, t := range [...]*Texture{d.rays, d.irrad, d.vis, d.data} {
+		if t != nil {
+			t.Free()
+		}
+	}
+}
+
+// advance prepares d for a new frame, selecting a new
+// random rotation for the probe rays.
+// It must be called once per frame.
+func (d *ddgi) advance() {
+	d.frame++
+	rng := rand.New(rand.NewPCG(d.frame, 0))
+	// Uniformly distributed unit quaternion
+	// (Shoemake, "Uniform Random Rotations").
+	u1, u2, u3 := rng.Float64(), 2*math.Pi*rng.Float64(), 2*math.Pi*rng.Float64()
+	a, b := math.Sqrt(1-u1), math.Sqrt(u1)
+	q := linear.Q{
+		V: linear.V3{float32(a * math.Sin(u2)), float32(a * math.Cos(u2)), float32(b * math.Sin(u3))},
+		R: float32(b * math.Cos(u3)),
+	}
+	var m linear.M3
+	m.RotateQ(&q)
+	d.layout.SetRotation(&m)
+}
+
+// ddgiAtlasSize returns the size of an atlas that
+// stores a res by res octahedral map, plus border,
+// for each probe of a grid with the given counts.
+func ddgiAtlasSize(counts [3]int, res int) driver.Dim3D {
+	return driver.Dim3D{
+		Width:  counts[0] * counts[2] * (res + 2),
+		Height: counts[1] * (res + 2),
+	}
+}
+
+// ddgiProbeIndex returns the index of the probe at
+// grid coordinates c.
+func ddgiProbeIndex(c, counts [3]int) int {
+	return c[0] + counts[0]*(c[2]+counts[2]*c[1])
+}
+
+// ddgiProbeTile returns the column and row of the
+// probe at index i in the atlases.
+// Each row holds one horizontal layer of the grid.
+func ddgiProbeTile(i int, counts [3]int) (col, row int) {
+	n := counts[0] * counts[2]
+	return i % n, i / n
+}
+
+// probePosition returns the grid position of the probe
+// at index i, ignoring relocation.
+func (p *DDGIParam) probePosition(i int) linear.V3 {
+	col, row := ddgiProbeTile(i, p.Counts)
+	c := [3]int{col % p.Counts[0], row, col / p.Counts[0]}
+	var pos linear.V3
+	for j := range pos {
+		pos[j] = p.Origin[j] + p.Spacing[j]*float32(c[j])
+	}
+	return pos
+}
+
+// octEncode maps the unit vector d onto the [-1, 1]
+// square using an octahedral projection.
+func octEncode(d *linear.V3) [2]float32 {
+	l1 := fabs(d[0]) + fabs(d[1]) + fabs(d[2])
+	x, y := d[0]/l1, d[1]/l1
+	if d[2] < 0 {
+		x, y = (1-fabs(y))*signNZ(x), (1-fabs(x))*signNZ(y)
+	}
+	return [2]float32{x, y}
+}
+
+// octDecode is the inverse of octEncode.
+func octDecode(e [2]float32) linear.V3 {
+	d := linear.V3{e[0], e[1], 1 - fabs(e[0]) - fabs(e[1])}
+	if d[2] < 0 {
+		d[0], d[1] = (1-fabs(e[1]))*signNZ(e[0]), (1-fabs(e[0]))*signNZ(e[1])
+	}
+	d.Norm(&d)
+	return d
+}
+
+// ddgiRayDir returns the direction of ray i out of n,
+// given the current ray rotation.
+// If relocate is set, the first ddgiFixedRays rays
+// are not rotated.
+// It must match the directions used by the trace
+// pass.
+func ddgiRayDir(i, n int, rot *linear.M3, relocate bool) linear.V3 {
+	if relocate {
+		if i < ddgiFixedRays {
+			return sphericalFibonacci(i, ddgiFixedRays)
+		}
+		i -= ddgiFixedRays
+		n -= ddgiFixedRays
+	}
+	d := sphericalFibonacci(i, n)
+	var v linear.V3
+	for j := range v {
+		v[j] = rot[0][j]*d[0] + rot[1][j]*d[1] + rot[2][j]*d[2]
+	}
+	return v
+}
+
+// sphericalFibonacci returns the i-th of n points
+// evenly distributed on the unit sphere.
+func sphericalFibonacci(i, n int) linear.V3 {
+	// Golden angle.
+	const ga = math.Pi * (3 - 2.2360679774997896)
+	z := 1 - (2*float64(i)+1)/float64(n)
+	r := math.Sqrt(max(0, 1-z*z))
+	phi := ga * float64(i)
+	return linear.V3{float32(r * math.Cos(phi)), float32(r * math.Sin(phi)), float32(z)}
+}
+
+// fabs returns the absolute value of x.
+func fabs(x float32) float32 { return max(x, -x) }
+
+// signNZ returns 1 for x >= 0 and -1 otherwise.
+func signNZ(x float32) float32 {
+	if x < 0 {
+		return -1
+	}
+	return 1
+}
@@ -0,0 +1,227 @@
+// Copyright 2024 Gustavo C. Viegas. All rights reserved.
+
+package engine
+
+import (
+	"math"
+	"testing"
+
+	"gviegas/neo3/driver"
+	"gviegas/neo3/linear"
+)
+
+func TestOctEncode(t *testing.T) {
+	for i := range 64 {
+		d := sphericalFibonacci(i, 64)
+		e := octEncode(&d)
+		if fabs(e[0])+fabs(e[1]) > 1 && d[2] >= 0 {
+			t.Fatalf("octEncode(%v): upper hemisphere outside the inner diamond\nhave %v", d, e)
+		}
+		if x := octDecode(e); !closeV3(x, d, 1e-5) {
+			t.Fatalf("octDecode(octEncode(%v))\nhave %v\nwant %v", d, x, d)
+		}
+	}
+	for _, x := range [...]struct {
+		d linear.V3
+		e [2]float32
+	}{
+		{linear.V3{0, 0, 1}, [2]float32{0, 0}},
+		{linear.V3{1, 0, 0}, [2]float32{1, 0}},
+		{linear.V3{0, -1, 0}, [2]float32{0, -1}},
+		{linear.V3{0, 0, -1}, [2]float32{1, 1}},
+	} {
+		if e := octEncode(&x.d); e != x.e {
+			t.Fatalf("octEncode(%v)\nhave %v\nwant %v", x.d, e, x.e)
+		}
+	}
+}
+
+func TestSphericalFibonacci(t *testing.T) {
+	const n = 256
+	var sum linear.V3
+	for i := range n {
+		d := sphericalFibonacci(i, n)
+		if l := d.Len(); math.Abs(float64(l-1)) > 1e-5 {
+			t.Fatalf("sphericalFibonacci(%d, %d): length\nhave %v\nwant 1", i, n, l)
+		}
+		sum.Add(&sum, &d)
+	}
+	// Evenly distributed directions cancel out.
+	if l := sum.Len() / n; l > 0.01 {
+		t.Fatalf("sphericalFibonacci: mean direction\nhave %v\nwant ~0", l)
+	}
+}
+
+func TestDDGIRayDir(t *testing.T) {
+	var rot linear.M3
+	rot.Rotate(math.Pi/3, &linear.V3{1, 1, 0})
+	const n = 128
+	for i := range n {
+		d := ddgiRayDir(i, n, &rot, true)
+		if i < ddgiFixedRays {
+			if x := sphericalFibonacci(i, ddgiFixedRays); d != x {
+				t.Fatalf("ddgiRayDir(%d): fixed ray\nhave %v\nwant %v", i, d, x)
+			}
+			continue
+		}
+		x := sphericalFibonacci(i-ddgiFixedRays, n-ddgiFixedRays)
+		var y linear.V3
+		for j := range y {
+			y[j] = rot[0][j]*x[0] + rot[1][j]*x[1] + rot[2][j]*x[2]
+		}
+		if !closeV3(d, y, 1e-6) {
+			t.Fatalf("ddgiRayDir(%d): rotated ray\nhave %v\nwant %v", i, d, y)
+		}
+	}
+	if d, x := ddgiRayDir(0, n, &rot, false), sphericalFibonacci(0, n); closeV3(d, x, 1e-3) {
+		t.Fatal("ddgiRayDir: rays should be rotated when not relocating")
+	}
+}
+
+func TestDDGIProbes(t *testing.T) {
+	p := DDGIParam{
+		Origin:  linear.V3{-4, 0, 2},
+		Spacing: linear.V3{2, 3, 1},
+		Counts:  [3]int{5, 3, 4},
+	}
+	seen := make(map[[2]int]bool)
+	for x := range p.Counts[0] {
+		for y := range p.Counts[1] {
+			for z := range p.Counts[2] {
+				i := ddgiProbeIndex([3]int{x, y, z}, p.Counts)
+				col, row := ddgiProbeTile(i, p.Counts)
+				if row != y {
+					t.Fatalf("ddgiProbeTile: row of (%d, %d, %d)\nhave %d\nwant %d", x, y, z, row, y)
+				}
+				if seen[[2]int{col, row}] {
+					t.Fatalf("ddgiProbeTile: tile (%d, %d) used twice", col, row)
+				}
+				seen[[2]int{col, row}] = true
+				want := linear.V3{-4 + 2*float32(x), 3 * float32(y), 2 + float32(z)}
+				if pos := p.probePosition(i); pos != want {
+					t.Fatalf("DDGIParam.probePosition(%d)\nhave %v\nwant %v", i, pos, want)
+				}
+			}
+		}
+	}
+	sz := ddgiAtlasSize(p.Counts, ddgiIrradRes)
+	if want := (driver.Dim3D{Width: 5 * 4 * 8, Height: 3 * 8}); sz != want {
+		t.Fatalf("ddgiAtlasSize\nhave %v\nwant %v", sz, want)
+	}
+}
+
+func TestRendererDDGI(t *testing.T) {
+	rend, err := NewOffscreen(256, 192)
+	if err != nil {
+		t.Fatalf("RendererDDGI: NewOffscreen failed:\n%v", err)
+	}
+	defer rend.Free()
+	if _, ok := rend.DDGI(); ok {
+		t.Fatal("Renderer.DDGI: DDGI should be disabled by default")
+	}
+
+	valid := DDGIParam{
+		Origin:     linear.V3{-10, 0, -10},
+		Spacing:    linear.V3{2.5, 2, 2.5},
+		Counts:     [3]int{8, 4, 8},
+		Rays:       128,
+		Hysteresis: 0.97,
+		NormalBias: 0.1,
+		ViewBias:   0.3,
+		Relocate:   true,
+		MaxOffset:  0.45,
+	}
+	for _, f := range [...]func(p *DDGIParam){
+		func(p *DDGIParam) { p.Spacing[1] = 0 },
+		func(p *DDGIParam) { p.Counts[2] = 0 },
+		func(p *DDGIParam) { p.Counts = [3]int{128, 128, 2} },
+		func(p *DDGIParam) { p.Rays = ddgiFixedRays - 1 },
+		func(p *DDGIParam) { p.Rays = ddgiMaxRays + 1 },
+		func(p *DDGIParam) { p.Hysteresis = 1 },
+		func(p *DDGIParam) { p.NormalBias = -0.1 },
+		func(p *DDGIParam) { p.MaxOffset = 0.5 },
+	} {
+		p := valid
+		f(&p)
+		if err := rend.SetDDGI(&p); err == nil {
+			t.Fatal("Renderer.SetDDGI: unexpected nil error")
+		}
+	}
+	if err := rend.SetDDGI(&valid); err != nil {
+		t.Fatalf("Renderer.SetDDGI failed:\n%v", err)
+	}
+	if x, ok := rend.DDGI(); !ok || x != valid {
+		t.Fatalf("Renderer.DDGI:\nhave %v, %t\nwant %v, true", x, ok, valid)
+	}
+	d := rend.ddgi
+	if x := d.layout.Hysteresis(); x != valid.Hysteresis {
+		t.Fatalf("Renderer.SetDDGI: ddgi.layout.Hysteresis\nhave %v\nwant %v", x, valid.Hysteresis)
+	}
+	if x := d.rays; x.Width() != valid.Rays || x.Height() != 8*4*8 {
+		t.Fatalf("Renderer.SetDDGI: ray texture size\nhave %dx%d", x.Width(), x.Height())
+	}
+	for _, x := range [...]*Texture{d.rays, d.irrad, d.vis, d.data} {
+		if x.usage&driver.UShaderWrite == 0 {
+			t.Fatal("Renderer.SetDDGI: textures should be writable by shaders")
+		}
+	}
+	for _, name := range [...]string{ddgiTracePass, ddgiUpdatePass, ddgiRelocatePass} {
+		if rend.graph.find(name) < 0 {
+			t.Fatalf("Renderer.SetDDGI: missing pass %q", name)
+		}
+	}
+	if err := rend.graph.compile(); err != nil {
+		t.Fatalf("frameGraph.compile failed:\n%v", err)
+	}
+
+	// Disabling relocation keeps the probe data.
+	p := valid
+	p.Relocate = false
+	if err := rend.SetDDGI(&p); err != nil {
+		t.Fatalf("Renderer.SetDDGI failed:\n%v", err)
+	}
+	if rend.ddgi != d || rend.graph.find(ddgiRelocatePass) >= 0 {
+		t.Fatal("Renderer.SetDDGI: relocation should have been disabled in place")
+	}
+	// Changing the grid does not.
+	p.Counts[0]++
+	if err := rend.SetDDGI(&p); err != nil {
+		t.Fatalf("Renderer.SetDDGI failed:\n%v", err)
+	}
+	if rend.ddgi == d {
+		t.Fatal("Renderer.SetDDGI: probe data should have been recreated")
+	}
+
+	rot := rend.ddgi.layout.Rotation()
+	rend.ddgi.advance()
+	if x := rend.ddgi.layout.Rotation(); x == rot {
+		t.Fatal("ddgi.advance: ray rotation should have changed")
+	} else {
+		var y linear.M3
+		y.Transpose(&x)
+		y.Mul(&y, &x)
+		for i := range y {
+			for j := range y[i] {
+				want := float32(0)
+				if i == j {
+					want = 1
+				}
+				if math.Abs(float64(y[i][j]-want)) > 1e-5 {
+					t.Fatalf("ddgi.advance: rotation is not orthonormal\nhave %v", x)
+				}
+			}
+		}
+	}
+
+	if err := rend.SetDDGI(nil); err != nil {
+		t.Fatalf("Renderer.SetDDGI(nil) failed:\n%v", err)
+	}
+	if _, ok := rend.DDGI(); ok {
+		t.Fatal("Renderer.DDGI: DDGI should have been disabled")
+	}
+	for _, name := range [...]string{ddgiTracePass, ddgiUpdatePass} {
+		if rend.graph.find(name) >= 0 {
+			t.Fatalf("Renderer.SetDDGI(nil): pass %q should have been removed", name)
+		}
+	}
+}
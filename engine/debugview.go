@@ -0,0 +1,192 @@
+// Copyright 2024 Gustavo C. Viegas. All rights reserved.
+
+package engine
+
+import (
+	"gviegas/neo3/driver"
+	"gviegas/neo3/linear"
+)
+
+// Debug views.
+// They must match the debug view constants in
+// debug_view_0.
+const (
+	// Normal rendering.
+	DebugNone = iota
+	// Triangle edges drawn over the final image.
+	DebugWireframe
+	// Shading normals in world space, mapped to
+	// the [0, 1] interval.
+	DebugNormal
+	// Material inputs after texturing.
+	DebugRoughness
+	DebugMetallic
+	DebugOcclusion
+	// Number of fragments shaded per pixel, as a
+	// heatmap.
+	DebugOverdraw
+	// Number of lights affecting each pixel, as a
+	// heatmap.
+	DebugLightCount
+)
+
+// Debug view defaults.
+const (
+	// Overdraw that maps to the hottest color.
+	debugMaxOverdraw = 16
+	// Light count that maps to the hottest color.
+	debugMaxLights = 8
+)
+
+// debugPass is the name of the debug view pass.
+const debugPass = "debug"
+
+// SetDebugView sets the debug view of r.
+// Wireframes are drawn over the final image. Other
+// views replace it, drawing opaque geometry with an
+// alternate pipeline that outputs the visualized
+// quantity directly, without lighting, exposure or
+// color grading.
+// DebugNone disables debug views.
+func (r *Renderer) SetDebugView(view int) error {
+	if view < DebugNone || view > DebugLightCount {
+		return newRendErr("undefined debug view")
+	}
+	if view == r.debug {
+		return nil
+	}
+	if r.debug != DebugNone {
+		r.graph.remove(debugPass)
+	}
+	r.debug = view
+	r.debugLayout.SetView(int32(view))
+	r.debugLayout.SetOverdraw(1.0 / debugMaxOverdraw)
+	r.debugLayout.SetMaxLights(debugMaxLights)
+	r.debugLayout.SetWireColor(&linear.V3{1, 1, 1})
+	if view != DebugNone {
+		// The pass runs after tonemapping, so
+		// that the visualized values are not
+		// altered. It needs the depth of opaque
+		// geometry, either to draw wireframes
+		// in front of it or to only shade
+		// visible fragments.
+		r.graph.add(&passNode{
+			name:  debugPass,
+			stage: stageFinal,
+			reads: []*Texture{r.ds},
+		})
+	}
+	return nil
+}
+
+// DebugView returns the debug view of r.
+func (r *Renderer) DebugView() int { return r.debug }
+
+// debugState returns the fixed-function state of the
+// debug pipeline for the given view.
+// Wireframes are depth tested against the scene,
+// with a bias so that edges are not hidden by their
+// own triangles. Overdraw disables the depth test
+// and accumulates fragments additively. The
+// remaining views redraw opaque geometry where it
+// is visible.
+func debugState(view int) (rs driver.RasterState, ds driver.DSState, cb driver.ColorBlend) {
+	rs.Cull = driver.CBack
+	ds.DepthTest = true
+	ds.DepthCmp = driver.CLessEqual
+	cb.WriteMask = driver.CAll
+	switch view {
+	case DebugWireframe:
+		rs.Fill = driver.FLines
+		rs.Cull = driver.CNone
+		rs.DepthBias = true
+		rs.BiasValue = -1
+		rs.BiasSlope = -1
+	case DebugOverdraw:
+		rs.Cull = driver.CNone
+		ds.DepthTest = false
+		cb.Blend = true
+		cb.SrcFacRGB = driver.BOne
+		cb.DstFacRGB = driver.BOne
+		cb.OpRGB = driver.BAdd
+		cb.SrcFacA = driver.BOne
+		cb.DstFacA = driver.BOne
+		cb.OpA = driver.BAdd
+	default:
+		ds.DepthCmp = driver.CEqual
+	}
+	return
+}
+
+// SceneStats describes what a viewport draws.
+// Counts refer to the last draw list built for the
+// viewport.
+type SceneStats struct {
+	// Number of drawables in the renderer.
+	Drawables int
+	// Primitives that passed culling, by pass.
+	Opaque  int
+	Blended int
+	// Primitives that were culled or replaced by
+	// imposters.
+	Culled int
+	// Triangles of visible primitives, excluding
+	// foliage.
+	Triangles int
+	Decals    int
+	// Visible foliage instances.
+	Foliage   int
+	Imposters int
+	// Lights in use.
+	Lights int
+}
+
+// SceneStats returns the statistics of v.
+func (r *Renderer) SceneStats(v Viewport) SceneStats {
+	l := &r.vports.get(v).list
+	s := SceneStats{
+		Drawables: r.drawables.len(),
+		Opaque:    len(l.opaque),
+		Blended:   len(l.blend),
+		Decals:    len(l.decal),
+		Imposters: len(l.imposter),
+	}
+	var prims int
+	for _, d := range r.drawables.all() {
+		prims += len(d.mat)
+	}
+	s.Culled = prims - s.Opaque - s.Blended
+	for _, xs := range [...][]drawItem{l.opaque, l.blend} {
+		for _, x := range xs {
+			s.Triangles += r.drawables.get(x.id).mesh.triangles(x.prim)
+		}
+	}
+	for _, b := range l.foliage {
+		s.Foliage += len(b.inst)
+	}
+	for i := range r.lights {
+		if !r.lights[i].layout.Unused() {
+			s.Lights++
+		}
+	}
+	return s
+}
+
+// debugHeat maps t to the heatmap color used by the
+// overdraw and light count views.
+// It must match the debugHeat function in
+// debug_view_0.
+func debugHeat(t float32) linear.V3 {
+	if t > 1 {
+		return linear.V3{1, 1, 1}
+	}
+	step := func(e0, e1, x float32) float32 {
+		x = min(max((x-e0)/(e1-e0), 0), 1)
+		return x * x * (3 - 2*x)
+	}
+	return linear.V3{
+		step(0.5, 0.8, t),
+		step(0, 0.4, t) - step(0.6, 1, t),
+		1 - step(0.2, 0.5, t),
+	}
+}
@@ -0,0 +1,128 @@
+// Copyright 2024 Gustavo C. Viegas. All rights reserved.
+
+package engine
+
+import (
+	"testing"
+
+	"gviegas/neo3/driver"
+	"gviegas/neo3/linear"
+)
+
+func TestDebugHeat(t *testing.T) {
+	for _, x := range [...]struct {
+		t    float32
+		want linear.V3
+	}{
+		{0, linear.V3{0, 0, 1}},
+		{1, linear.V3{1, 0, 0}},
+		{2, linear.V3{1, 1, 1}},
+	} {
+		if c := debugHeat(x.t); c != x.want {
+			t.Fatalf("debugHeat(%v):\nhave %v\nwant %v", x.t, c, x.want)
+		}
+	}
+	if c := debugHeat(0.5); c[1] != 1 || c[0] != 0 || c[2] != 0 {
+		t.Fatalf("debugHeat(0.5):\nhave %v\nwant [0 1 0]", c)
+	}
+}
+
+func TestDebugState(t *testing.T) {
+	rs, ds, _ := debugState(DebugWireframe)
+	if rs.Fill != driver.FLines || !rs.DepthBias || !ds.DepthTest {
+		t.Fatalf("debugState(DebugWireframe):\nhave %+v, %+v", rs, ds)
+	}
+	_, ds, cb := debugState(DebugOverdraw)
+	if ds.DepthTest || !cb.Blend || cb.SrcFacRGB != driver.BOne || cb.DstFacRGB != driver.BOne {
+		t.Fatalf("debugState(DebugOverdraw):\nhave %+v, %+v", ds, cb)
+	}
+	for _, v := range [...]int{DebugNormal, DebugRoughness, DebugMetallic, DebugOcclusion, DebugLightCount} {
+		rs, ds, cb := debugState(v)
+		if rs.Fill != driver.FFill || ds.DepthCmp != driver.CEqual || cb.Blend {
+			t.Fatalf("debugState(%d):\nhave %+v, %+v, %+v", v, rs, ds, cb)
+		}
+	}
+}
+
+func TestRendererDebugView(t *testing.T) {
+	rend, err := NewOffscreen(256, 192)
+	if err != nil {
+		t.Fatalf("RendererDebugView: NewOffscreen failed:\n%v", err)
+	}
+	defer rend.Free()
+	if x := rend.DebugView(); x != DebugNone {
+		t.Fatalf("Renderer.DebugView:\nhave %d\nwant %d", x, DebugNone)
+	}
+	for _, v := range [...]int{-1, DebugLightCount + 1} {
+		if err := rend.SetDebugView(v); err == nil {
+			t.Fatal("Renderer.SetDebugView: unexpected nil error")
+		}
+	}
+	for _, v := range [...]int{DebugWireframe, DebugOverdraw, DebugOverdraw, DebugNormal} {
+		if err := rend.SetDebugView(v); err != nil {
+			t.Fatalf("Renderer.SetDebugView failed:\n%v", err)
+		}
+		if x := rend.DebugView(); x != v {
+			t.Fatalf("Renderer.DebugView:\nhave %d\nwant %d", x, v)
+		}
+		if x := rend.debugLayout.View(); x != int32(v) {
+			t.Fatalf("Renderer.SetDebugView: layout.View\nhave %d\nwant %d", x, v)
+		}
+		if rend.graph.find(debugPass) < 0 {
+			t.Fatal("Renderer.SetDebugView: missing debug pass")
+		}
+	}
+	if err := rend.SetDebugView(DebugNone); err != nil {
+		t.Fatalf("Renderer.SetDebugView failed:\n%v", err)
+	}
+	if rend.graph.find(debugPass) >= 0 {
+		t.Fatal("Renderer.SetDebugView(DebugNone): debug pass should have been removed")
+	}
+}
+
+func TestRendererSceneStats(t *testing.T) {
+	rend, err := NewOffscreen(256, 192)
+	if err != nil {
+		t.Fatalf("RendererSceneStats: NewOffscreen failed:\n%v", err)
+	}
+	defer rend.Free()
+	mesh, err := NewMesh(quadData(0))
+	if err != nil {
+		t.Fatalf("RendererSceneStats: NewMesh failed:\n%v", err)
+	}
+	defer mesh.Free()
+	mat, err := NewPBR(&PBR{})
+	if err != nil {
+		t.Fatalf("RendererSceneStats: NewPBR failed:\n%v", err)
+	}
+	var proj, view linear.M4
+	proj.Perspective(1, 4.0/3.0, 0.1, 100)
+	view.Translate(0, 0, 5)
+	v, err := rend.AddViewport(&ViewportParam{View: view, Proj: proj, Rect: ViewportRect{Width: 1, Height: 1}})
+	if err != nil {
+		t.Fatalf("Renderer.AddViewport failed:\n%v", err)
+	}
+	// One drawable in front of the camera and
+	// one behind it.
+	var ids []Drawable
+	for _, z := range [...]float32{0, -20} {
+		var d drawable
+		d.mesh = mesh
+		d.mat = []*Material{mat}
+		var w linear.M4
+		w.Translate(0, 0, z)
+		d.layout.SetWorld(&w)
+		ids = append(ids, rend.drawables.insert(d))
+	}
+	rend.buildViewports()
+	s := rend.SceneStats(v)
+	if s.Drawables != 2 || s.Opaque != 1 || s.Culled != 1 || s.Blended != 0 {
+		t.Fatalf("Renderer.SceneStats:\nhave %+v", s)
+	}
+	if want := mesh.triangles(0); s.Triangles != want || want == 0 {
+		t.Fatalf("Renderer.SceneStats: Triangles\nhave %d\nwant %d", s.Triangles, want)
+	}
+	for _, id := range ids {
+		rend.drawables.remove(id)
+	}
+}
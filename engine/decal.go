@@ -0,0 +1,202 @@
+// Copyright 2024 Gustavo C. Viegas. All rights reserved.
+
+package engine
+
+import (
+	"cmp"
+	"slices"
+	"time"
+
+	"gviegas/neo3/driver"
+	"gviegas/neo3/engine/internal/shader"
+	"gviegas/neo3/linear"
+	"gviegas/neo3/node"
+)
+
+// MaxDecal is the maximum number of decals that a
+// Renderer can hold at once.
+const MaxDecal = 256
+
+// decalMap is a dataMap for decals.
+type decalMap struct{ dataMap[Decal, decal] }
+
+// decal is what a decalMap stores.
+type decal struct {
+	mat  *Material
+	node node.Node
+	life time.Duration
+	fade time.Duration
+	age  time.Duration
+	// Insertion order, used to evict the
+	// oldest decal and to draw newer decals
+	// over older ones.
+	seq    int64
+	layout shader.DecalLayout
+}
+
+// Decal identifies a projected decal.
+// A Decal is always associated with a Renderer,
+// thus there might be identical Decal values
+// that belong to different renderers.
+type Decal int
+
+// DecalParam describes a decal.
+// A decal is a box that projects Mat onto the
+// surfaces it intersects. In local space, this box
+// is the unit cube centered at the origin, and the
+// projection is along the Y axis.
+// If Node is not node.Nil, the world transform is
+// taken from the node.Graph passed to
+// Renderer.UpdateDecals, and World is ignored.
+// Lifetime specifies how long the decal will exist
+// (zero means forever). FadeOut specifies how long
+// it will take for the decal to fade out completely
+// at the end of its lifetime.
+type DecalParam struct {
+	World    linear.M4
+	Node     node.Node
+	Mat      *Material
+	Lifetime time.Duration
+	FadeOut  time.Duration
+}
+
+// AddDecal adds a new decal to r.
+// If r already holds MaxDecal decals, the oldest one
+// is removed to make room for the new one.
+// Decals are drawn after opaque primitives, using
+// positions reconstructed from the depth buffer.
+// They are not drawn on pixels with StencilDecal set
+// (see StencilState).
+func (r *Renderer) AddDecal(param *DecalParam) (Decal, error) {
+	var reason string
+	switch {
+	case param == nil:
+		reason = "nil decal param"
+	case param.Mat == nil:
+		reason = "nil decal material"
+	case param.Lifetime < 0, param.FadeOut < 0:
+		reason = "negative decal duration"
+	case param.Lifetime > 0 && param.FadeOut > param.Lifetime:
+		reason = "decal fade-out exceeds lifetime"
+	default:
+		goto validParam
+	}
+	return -1, newRendErr(reason)
+validParam:
+	if err := r.sampleableDepth(); err != nil {
+		return -1, err
+	}
+	if r.decals.len() == MaxDecal {
+		oldest := slices.MinFunc(r.decals.entries(), func(a, b dataEntry[decal]) int {
+			return cmp.Compare(a.data.seq, b.data.seq)
+		})
+		r.decals.remove(Decal(oldest.id))
+	}
+	d := decal{
+		mat:  param.Mat,
+		node: param.Node,
+		life: param.Lifetime,
+		fade: param.FadeOut,
+		seq:  r.decalSeq,
+	}
+	r.decalSeq++
+	d.layout.SetWorld(&param.World)
+	d.layout.SetOpacity(1)
+	return r.decals.insert(d), nil
+}
+
+// RemoveDecal removes d from r.
+// d must not have expired.
+func (r *Renderer) RemoveDecal(d Decal) { r.decals.remove(d) }
+
+// SetDecalWorld sets the world transform of d.
+// It has no effect if d was created with a Node.
+// d must not have expired.
+func (r *Renderer) SetDecalWorld(d Decal, world *linear.M4) {
+	if x := r.decals.get(d); x.node == node.Nil {
+		x.layout.SetWorld(world)
+	}
+}
+
+// DecalsLen returns the number of decals in r.
+func (r *Renderer) DecalsLen() int { return r.decals.len() }
+
+// UpdateDecals advances the lifetime of every decal
+// in r by dt, removing the ones that expire.
+// Decals created with a Node have their world
+// transforms updated from g, which must be the graph
+// that the node belongs to. g can be nil if no such
+// decal exists.
+// The Decal values of removed decals may be reused by
+// subsequent AddDecal calls.
+func (r *Renderer) UpdateDecals(g *node.Graph, dt time.Duration) {
+	defer traceBegin(traceUpdate, "decals").end()
+	expired := r.decalExp[:0]
+	for id, d := range r.decals.all() {
+		d.age += dt
+		if d.life > 0 && d.age >= d.life {
+			expired = append(expired, id)
+			continue
+		}
+		d.layout.SetOpacity(d.opacity())
+		if d.node != node.Nil {
+			d.layout.SetWorld(g.World(d.node))
+		}
+	}
+	for _, id := range expired {
+		r.decals.remove(id)
+	}
+	r.decalExp = expired
+}
+
+// opacity computes the opacity of d.
+func (d *decal) opacity() float32 {
+	if d.fade == 0 || d.life == 0 {
+		return 1
+	}
+	if rem := d.life - d.age; rem < d.fade {
+		return max(0, float32(rem)/float32(d.fade))
+	}
+	return 1
+}
+
+// sampleableDepth ensures that r's depth target can
+// be sampled, which is needed to draw decals, and
+// that its contents can be loaded and stored, which
+// is needed by custom passes.
+// The depth target is transient otherwise.
+// Passes that use the previous target are updated
+// to use the new one.
+func (r *Renderer) sampleableDepth() error {
+	if r.ds.usage&driver.UShaderSample != 0 {
+		return nil
+	}
+	ds, err := newTarget(&r.ds.param, driver.URenderTarget|driver.UShaderSample)
+	if err != nil {
+		return err
+	}
+	r.graph.replace(r.ds, ds)
+	r.ds.Free()
+	r.ds = ds
+	return nil
+}
+
+// buildDecals fills l.decal with every decal in r,
+// ordered from oldest to newest.
+// vp is the view-projection transform. It is used to
+// compute the clip-to-local transform of each decal.
+func (l *drawList) buildDecals(r *Renderer, vp *linear.M4) {
+	var ivp linear.M4
+	ivp.Invert(vp)
+	l.decal = l.decal[:0]
+	for id, d := range r.decals.all() {
+		clip := d.layout.World()
+		clip.Invert(&clip)
+		clip.Mul(&clip, &ivp)
+		d.layout.SetClip(&clip)
+		l.decal = append(l.decal, id)
+	}
+	slices.SortFunc(l.decal, func(a, b Decal) int {
+		return cmp.Compare(r.decals.get(a).seq, r.decals.get(b).seq)
+	})
+}
@@ -0,0 +1,75 @@
+// Copyright 2024 Gustavo C. Viegas. All rights reserved.
+
+package engine
+
+import (
+	"gviegas/neo3/engine/internal/ctxt"
+)
+
+// DeviceStatus returns nil if the GPU is usable.
+// After a device loss, it returns a
+// *driver.DeviceLostError. If a call to RecoverDevice
+// failed to recreate the GPU, it returns an error that
+// wraps ErrDeviceLost.
+func DeviceStatus() error {
+	gpu := ctxt.GPU()
+	if devFreed || gpu == nil {
+		return newErr("engine: ", "no GPU (RecoverDevice failed)", ErrDeviceLost)
+	}
+	return gpu.Status()
+}
+
+// devFreed is set when RecoverDevice frees the state
+// that depends on the GPU, and cleared once the GPU is
+// recreated.
+var devFreed bool
+
+// reopenGPU recreates the GPU.
+// It is a variable so tests can make it fail.
+var reopenGPU = ctxt.Reopen
+
+// RecoverDevice recreates the GPU after a device loss.
+// It does nothing if DeviceStatus returns nil.
+// If it fails, it can be called again to retry.
+//
+// Every Texture, Sampler, Mesh, Material, Skin,
+// Renderer and Presenter that was created before the
+// call becomes invalid and must be discarded without
+// calling Free.
+// If reload is not nil, it is called after the GPU is
+// recreated, so that persistent resources can be
+// uploaded again.
+func RecoverDevice(reload func() error) error {
+	if DeviceStatus() == nil {
+		return nil
+	}
+	return recoverDevice(reload)
+}
+
+// recoverDevice implements RecoverDevice.
+func recoverDevice(reload func() error) error {
+	if !devFreed {
+		devGen.Add(1)
+		freeTexStg()
+		freeBufStg()
+		dropRetired()
+		cmdBufs.free()
+		if buf := setMeshBuffer(nil); buf != nil {
+			buf.Destroy()
+		}
+		images.Lock()
+		clear(images.m)
+		images.Unlock()
+		devFreed = true
+	}
+	if err := reopenGPU(); err != nil {
+		return err
+	}
+	initTexStg()
+	initBufStg()
+	devFreed = false
+	if reload != nil {
+		return reload()
+	}
+	return nil
+}
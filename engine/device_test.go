@@ -0,0 +1,46 @@
+// Copyright 2024 Gustavo C. Viegas. All rights reserved.
+
+package engine
+
+import (
+	"errors"
+	"testing"
+
+	"gviegas/neo3/engine/internal/ctxt"
+)
+
+func TestRecoverDeviceFailure(t *testing.T) {
+	errReopen := errors.New("reopen failed")
+	reopenGPU = func() error { return errReopen }
+	defer func() { reopenGPU = ctxt.Reopen }()
+
+	// The first attempt frees the GPU state. Retries
+	// must not free it again (this would block on the
+	// drained staging buffers).
+	if err := recoverDevice(nil); err != errReopen {
+		t.Fatalf("recoverDevice:\nhave %v\nwant %v", err, errReopen)
+	}
+	for range 2 {
+		if err := DeviceStatus(); !errors.Is(err, ErrDeviceLost) {
+			t.Fatalf("DeviceStatus:\nhave %v\nwant %v", err, ErrDeviceLost)
+		}
+		if err := RecoverDevice(nil); err != errReopen {
+			t.Fatalf("RecoverDevice:\nhave %v\nwant %v", err, errReopen)
+		}
+	}
+
+	reopenGPU = ctxt.Reopen
+	var reloaded bool
+	if err := RecoverDevice(func() error { reloaded = true; return nil }); err != nil || !reloaded {
+		t.Fatalf("RecoverDevice:\nhave %v, %t\nwant nil, true", err, reloaded)
+	}
+	if err := DeviceStatus(); err != nil {
+		t.Fatalf("DeviceStatus:\nhave %v\nwant nil", err)
+	}
+	if err := commitTexStg().Wait(); err != nil {
+		t.Fatalf("commitTexStg failed:\n%v", err)
+	}
+	if err := commitBufStg(); err != nil {
+		t.Fatalf("commitBufStg failed:\n%v", err)
+	}
+}
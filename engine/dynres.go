@@ -0,0 +1,332 @@
+// Copyright 2024 Gustavo C. Viegas. All rights reserved.
+
+package engine
+
+import (
+	"math"
+	"time"
+
+	"gviegas/neo3/driver"
+)
+
+// DynResParam describes dynamic resolution.
+// Target is the GPU frame time that the renderer aims
+// for. The render scale is adjusted so that the frame
+// times given to Renderer.ReportFrameTime approach
+// Target, within the [MinScale, MaxScale] interval.
+// The scale applies to each dimension of the render
+// target, so a scale of 0.5 shades a quarter of the
+// pixels.
+// Hysteresis prevents the scale from oscillating:
+// Frames is the number of frames whose times are
+// averaged before each adjustment (it defaults to
+// dynResFrames if zero), Headroom is the fraction of
+// Target by which the average must fall below Target
+// before the scale is increased, and Step is the
+// largest change in scale per adjustment (it defaults
+// to dynResStep if zero).
+// Upscaler upscales the scene to the native
+// resolution. If nil, BilinearUpscaler is used.
+type DynResParam struct {
+	Target   time.Duration
+	MinScale float32
+	MaxScale float32
+	Frames   int
+	Headroom float32
+	Step     float32
+	Upscaler Upscaler
+}
+
+// Dynamic resolution defaults.
+const (
+	dynResFrames = 8
+	dynResStep   = 0.1
+)
+
+// dynRes is the state of dynamic resolution.
+type dynRes struct {
+	param DynResParam
+	// Resolved Frames and Step.
+	frames int
+	step   float32
+	scale  float32
+	// Frame times reported since the last
+	// adjustment.
+	sum time.Duration
+	n   int
+	// Frame count, used to select the jitter
+	// of temporal upscalers.
+	frame uint
+	// Whether the upscaler must discard the
+	// contents of previous frames.
+	reset bool
+	// Color target in native resolution, which
+	// the upscale pass writes to and passes of
+	// stageFinal use in place of Renderer.hdr.
+	color *Texture
+}
+
+// Name of the upscale pass.
+const upscalePass = "dynres.upscale"
+
+// SetDynamicResolution enables dynamic resolution in r.
+// If param is nil, dynamic resolution is disabled.
+// The scene is rendered to an area of r's targets
+// that is scaled by the current render scale (see
+// RenderScale), and post-processing runs in this area.
+// The result is then upscaled to the native
+// resolution before any pass of StageFinal executes,
+// so that overlays such as UI and text are drawn at
+// the native resolution. Passes of every other stage
+// render at the render scale.
+// The render scale starts at param.MaxScale.
+func (r *Renderer) SetDynamicResolution(param *DynResParam) error {
+	if param == nil {
+		r.freeDynRes()
+		return nil
+	}
+	var reason string
+	switch {
+	case param.Target <= 0:
+		reason = "non-positive dynamic resolution target"
+	case param.MinScale <= 0 || param.MaxScale > 1 || param.MinScale > param.MaxScale:
+		reason = "invalid dynamic resolution scale range"
+	case param.Frames < 0:
+		reason = "negative dynamic resolution frame count"
+	case param.Headroom < 0 || param.Headroom >= 1:
+		reason = "dynamic resolution headroom out of range"
+	case param.Step < 0 || param.Step > 1:
+		reason = "dynamic resolution step out of range"
+	case param.Upscaler != nil && param.Upscaler.Inputs()&^(UpscaleDepth|UpscaleMotion) != 0:
+		reason = "undefined upscaler input"
+	default:
+		goto validParam
+	}
+	return newRendErr(reason)
+validParam:
+	init := r.dynRes == nil
+	if init {
+		if err := r.initDynRes(); err != nil {
+			return err
+		}
+	}
+	if err := r.setUpscaler(param.Upscaler); err != nil {
+		if init {
+			r.freeDynRes()
+		}
+		return err
+	}
+	d := r.dynRes
+	d.param = *param
+	d.frames = param.Frames
+	if d.frames == 0 {
+		d.frames = dynResFrames
+	}
+	d.step = param.Step
+	if d.step == 0 {
+		d.step = dynResStep
+	}
+	d.scale = param.MaxScale
+	d.sum, d.n = 0, 0
+	d.reset = true
+	r.updateViewports()
+	return nil
+}
+
+// DynamicResolution returns the dynamic resolution
+// parameters of r.
+// If dynamic resolution is disabled, it returns false.
+func (r *Renderer) DynamicResolution() (DynResParam, bool) {
+	if r.dynRes == nil {
+		return DynResParam{}, false
+	}
+	return r.dynRes.param, true
+}
+
+// RenderScale returns the factor by which r scales
+// the width and height of the area that the scene is
+// rendered to.
+// It is 1 if dynamic resolution is disabled.
+func (r *Renderer) RenderScale() float32 {
+	if r.dynRes == nil {
+		return 1
+	}
+	return r.dynRes.scale
+}
+
+// ReportFrameTime reports the GPU time taken by the
+// most recent frame, which r uses to adjust its render
+// scale when dynamic resolution is enabled.
+// It should be called once per frame, with the
+// measured execution time of the frame's commands.
+func (r *Renderer) ReportFrameTime(dt time.Duration) {
+	if r.dynRes != nil && r.dynRes.report(dt) {
+		r.dynRes.reset = true
+		r.updateViewports()
+	}
+}
+
+// renderSize returns the size of the area of r's
+// targets into which the scene is rendered.
+func (r *Renderer) renderSize() (width, height int) {
+	width, height = r.hdr.Width(), r.hdr.Height()
+	if r.dynRes != nil {
+		width, height = r.dynRes.scaleSize(width, height)
+	}
+	return
+}
+
+// finalColor returns the color target that passes of
+// stageFinal must use.
+func (r *Renderer) finalColor() *Texture {
+	if r.dynRes != nil {
+		return r.dynRes.color
+	}
+	return r.hdr
+}
+
+// updateViewports updates the layouts of every
+// viewport in r. It must be called when the render
+// size changes.
+func (r *Renderer) updateViewports() {
+	for _, v := range r.vports.all() {
+		v.update(r)
+	}
+}
+
+// initDynRes creates the native resolution color
+// target and makes the passes of stageFinal use it.
+// The upscale pass is added by r.setUpscaler.
+func (r *Renderer) initDynRes() (err error) {
+	color, err := NewTarget(&TexParam{
+		PixelFmt: r.hdr.PixelFmt(),
+		Dim3D:    driver.Dim3D{Width: r.hdr.Width(), Height: r.hdr.Height()},
+		Layers:   1,
+		Levels:   1,
+		Samples:  1,
+	})
+	if err != nil {
+		return
+	}
+	r.graph.replaceStage(stageFinal, r.hdr, color)
+	r.dynRes = &dynRes{color: color}
+	return
+}
+
+// setUpscaler replaces the upscale pass of r's frame
+// graph with one that executes u (or BilinearUpscaler
+// if u is nil).
+// The depth target is made sampleable and the velocity
+// buffer is created if u needs them.
+func (r *Renderer) setUpscaler(u Upscaler) error {
+	if u == nil {
+		u = BilinearUpscaler{}
+	}
+	in := u.Inputs()
+	if in&UpscaleDepth != 0 {
+		if err := r.sampleableDepth(); err != nil {
+			return err
+		}
+	}
+	if in&UpscaleMotion != 0 {
+		if err := r.velocityBuffer(); err != nil {
+			return err
+		}
+	}
+	r.graph.remove(upscalePass)
+	reads := []*Texture{r.hdr}
+	if in&UpscaleDepth != 0 {
+		reads = append(reads, r.ds)
+	}
+	if in&UpscaleMotion != 0 {
+		reads = append(reads, r.vel)
+	}
+	n := &passNode{
+		name:   upscalePass,
+		stage:  stageFinal,
+		reads:  reads,
+		writes: []*Texture{r.dynRes.color},
+	}
+	n.record = func(r *Renderer, cb driver.CmdBuffer) {
+		d := r.dynRes
+		ctx := UpscaleContext{
+			Cmd:    cb,
+			Color:  n.reads[0],
+			Output: n.writes[0],
+			Jitter: d.jitter(),
+			Reset:  d.reset,
+		}
+		ctx.Width, ctx.Height = r.renderSize()
+		for _, t := range n.reads[1:] {
+			switch t {
+			case r.ds:
+				ctx.Depth = t
+			case r.vel:
+				ctx.Motion = t
+			}
+		}
+		u.Upscale(&ctx)
+		d.reset = false
+	}
+	r.graph.addFirst(n)
+	return nil
+}
+
+// freeDynRes removes the upscale pass from r's frame
+// graph and frees the native resolution color target.
+func (r *Renderer) freeDynRes() {
+	if r.dynRes == nil {
+		return
+	}
+	r.graph.remove(upscalePass)
+	r.graph.replaceStage(stageFinal, r.dynRes.color, r.hdr)
+	r.dynRes.color.Free()
+	r.dynRes = nil
+	r.updateViewports()
+}
+
+// report records the GPU time of a frame and adjusts
+// d.scale once d.frames frames have been recorded.
+// It returns whether d.scale changed.
+// Since the cost of shading is roughly proportional
+// to the number of pixels, the new scale is the one
+// expected to meet the target time, limited by d.step.
+func (d *dynRes) report(dt time.Duration) bool {
+	d.sum += max(0, dt)
+	d.n++
+	if d.n < d.frames {
+		return false
+	}
+	avg := d.sum / time.Duration(d.n)
+	d.sum, d.n = 0, 0
+	target := d.param.Target
+	switch {
+	case avg > target:
+	case float32(avg) < float32(target)*(1-d.param.Headroom):
+	default:
+		return false
+	}
+	x := d.scale
+	if avg > 0 {
+		x *= float32(math.Sqrt(float64(target) / float64(avg)))
+	} else {
+		x = d.param.MaxScale
+	}
+	x = max(d.scale-d.step, min(d.scale+d.step, x))
+	x = max(d.param.MinScale, min(d.param.MaxScale, x))
+	if x == d.scale {
+		return false
+	}
+	d.scale = x
+	return true
+}
+
+// scaleSize scales width and height by d.scale.
+// The results are rounded up and are at least 1.
+func (d *dynRes) scaleSize(width, height int) (int, int) {
+	s := float64(d.scale)
+	// Tolerate float32 rounding of d.scale.
+	w := int(math.Ceil(float64(width)*s - 1e-3))
+	h := int(math.Ceil(float64(height)*s - 1e-3))
+	return max(1, min(width, w)), max(1, min(height, h))
+}
@@ -0,0 +1,173 @@
+// Copyright 2024 Gustavo C. Viegas. All rights reserved.
+
+package engine
+
+import (
+	"testing"
+	"time"
+)
+
+func TestDynResReport(t *testing.T) {
+	d := dynRes{
+		param: DynResParam{
+			Target:   10 * time.Millisecond,
+			MinScale: 0.5,
+			MaxScale: 1,
+			Headroom: 0.2,
+		},
+		frames: 2,
+		step:   0.25,
+		scale:  1,
+	}
+	for i, x := range [...]struct {
+		dt      time.Duration
+		changed bool
+		scale   float32
+	}{
+		// Not enough frames.
+		{20 * time.Millisecond, false, 1},
+		// Average is 15ms.
+		{10 * time.Millisecond, true, 0.8164966},
+		// Within headroom.
+		{9 * time.Millisecond, false, 0.8164966},
+		{8500 * time.Microsecond, false, 0.8164966},
+		// Limited by step.
+		{100 * time.Millisecond, false, 0.8164966},
+		{100 * time.Millisecond, true, 0.5664966},
+		// Limited by MinScale.
+		{100 * time.Millisecond, false, 0.5664966},
+		{100 * time.Millisecond, true, 0.5},
+		{100 * time.Millisecond, false, 0.5},
+		{100 * time.Millisecond, false, 0.5},
+		// Limited by step and MaxScale.
+		{1 * time.Millisecond, false, 0.5},
+		{1 * time.Millisecond, true, 0.75},
+		{0, false, 0.75},
+		{0, true, 1},
+	} {
+		if changed := d.report(x.dt); changed != x.changed || d.scale != x.scale {
+			t.Fatalf("dynRes.report #%d:\nhave %t, %v\nwant %t, %v", i, changed, d.scale, x.changed, x.scale)
+		}
+	}
+}
+
+func TestDynResScaleSize(t *testing.T) {
+	for _, x := range [...]struct {
+		scale         float32
+		width, height int
+		w, h          int
+	}{
+		{1, 1920, 1080, 1920, 1080},
+		{0.5, 1920, 1080, 960, 540},
+		{0.7, 1000, 100, 700, 70},
+		{0.75, 1281, 721, 961, 541},
+		{0.001, 100, 100, 1, 1},
+	} {
+		d := dynRes{scale: x.scale}
+		if w, h := d.scaleSize(x.width, x.height); w != x.w || h != x.h {
+			t.Fatalf("dynRes.scaleSize(%d, %d) [scale %v]:\nhave %d, %d\nwant %d, %d", x.width, x.height, x.scale, w, h, x.w, x.h)
+		}
+	}
+}
+
+func TestRendererDynRes(t *testing.T) {
+	rend, err := NewOffscreen(256, 192)
+	if err != nil {
+		t.Fatalf("RendererDynRes: NewOffscreen failed:\n%v", err)
+	}
+	defer rend.Free()
+	if _, ok := rend.DynamicResolution(); ok {
+		t.Fatal("Renderer.DynamicResolution: dynamic resolution should be disabled by default")
+	}
+	if s := rend.RenderScale(); s != 1 {
+		t.Fatalf("Renderer.RenderScale:\nhave %v\nwant 1", s)
+	}
+
+	for _, p := range [...]DynResParam{
+		{MinScale: 0.5, MaxScale: 1},
+		{Target: time.Millisecond, MinScale: 0, MaxScale: 1},
+		{Target: time.Millisecond, MinScale: 0.5, MaxScale: 1.5},
+		{Target: time.Millisecond, MinScale: 0.8, MaxScale: 0.5},
+		{Target: time.Millisecond, MinScale: 0.5, MaxScale: 1, Frames: -1},
+		{Target: time.Millisecond, MinScale: 0.5, MaxScale: 1, Headroom: 1},
+		{Target: time.Millisecond, MinScale: 0.5, MaxScale: 1, Step: 2},
+		{Target: time.Millisecond, MinScale: 0.5, MaxScale: 1, Upscaler: &testUpscaler{inputs: 4}},
+	} {
+		if err := rend.SetDynamicResolution(&p); err == nil {
+			t.Fatalf("Renderer.SetDynamicResolution(%v): unexpected nil error", p)
+		}
+	}
+	if rend.dynRes != nil {
+		t.Fatal("Renderer.SetDynamicResolution: dynRes should be nil")
+	}
+
+	v, err := rend.AddViewport(&ViewportParam{Rect: ViewportRect{Width: 1, Height: 1}})
+	if err != nil {
+		t.Fatalf("Renderer.AddViewport failed:\n%v", err)
+	}
+	if err := rend.SetGrading(&GradeParam{Contrast: 1, Saturation: 1}); err != nil {
+		t.Fatalf("Renderer.SetGrading failed:\n%v", err)
+	}
+	param := DynResParam{Target: 16 * time.Millisecond, MinScale: 0.5, MaxScale: 0.75, Frames: 1}
+	if err := rend.SetDynamicResolution(&param); err != nil {
+		t.Fatalf("Renderer.SetDynamicResolution failed:\n%v", err)
+	}
+	if x, ok := rend.DynamicResolution(); !ok || x != param {
+		t.Fatalf("Renderer.DynamicResolution:\nhave %v, %t\nwant %v, true", x, ok, param)
+	}
+	if s := rend.RenderScale(); s != 0.75 {
+		t.Fatalf("Renderer.RenderScale:\nhave %v\nwant 0.75", s)
+	}
+	if w, h := rend.renderSize(); w != 192 || h != 144 {
+		t.Fatalf("Renderer.renderSize:\nhave %d, %d\nwant 192, 144", w, h)
+	}
+	if bnd := rend.vports.get(v).layout.Bounds(); bnd.Width != 192 || bnd.Height != 144 {
+		t.Fatalf("Renderer.SetDynamicResolution: viewport bounds\nhave %v\nwant 192x144", bnd)
+	}
+
+	// The upscale pass must precede every other
+	// pass of stageFinal, which must use the
+	// native resolution target.
+	i := rend.graph.find(upscalePass)
+	if i < 0 || rend.graph.nodes[i].writes[0] != rend.dynRes.color {
+		t.Fatal("Renderer.SetDynamicResolution: missing upscale pass")
+	}
+	g := rend.graph.nodes[rend.graph.find(gradePass)]
+	if g.reads[0] != rend.dynRes.color {
+		t.Fatal("Renderer.SetDynamicResolution: grading pass should read the upscaled target")
+	}
+	for _, n := range rend.graph.nodes[:i] {
+		if n.stage == stageFinal {
+			t.Fatalf("Renderer.SetDynamicResolution: pass %s precedes the upscale pass", n.name)
+		}
+	}
+	if err := rend.graph.compile(); err != nil {
+		t.Fatalf("frameGraph.compile failed:\n%v", err)
+	}
+
+	rend.ReportFrameTime(64 * time.Millisecond)
+	if s := rend.RenderScale(); s != 0.65 {
+		t.Fatalf("Renderer.ReportFrameTime: RenderScale\nhave %v\nwant 0.65", s)
+	}
+	rend.curStage = stageGeometry
+	if _, sciss := rend.curBounds(); sciss.Width != 167 || sciss.Height != 125 {
+		t.Fatalf("Renderer.curBounds:\nhave %v\nwant 167x125", sciss)
+	}
+	rend.curStage = stageFinal
+	if _, sciss := rend.curBounds(); sciss.Width != 256 || sciss.Height != 192 {
+		t.Fatalf("Renderer.curBounds:\nhave %v\nwant 256x192", sciss)
+	}
+
+	if err := rend.SetDynamicResolution(nil); err != nil {
+		t.Fatalf("Renderer.SetDynamicResolution(nil) failed:\n%v", err)
+	}
+	if rend.RenderScale() != 1 || rend.graph.find(upscalePass) >= 0 {
+		t.Fatal("Renderer.SetDynamicResolution(nil): dynamic resolution should be disabled")
+	}
+	if g.reads[0] != rend.hdr {
+		t.Fatal("Renderer.SetDynamicResolution(nil): grading pass should read the HDR target")
+	}
+	if bnd := rend.vports.get(v).layout.Bounds(); bnd.Width != 256 || bnd.Height != 192 {
+		t.Fatalf("Renderer.SetDynamicResolution(nil): viewport bounds\nhave %v\nwant 256x192", bnd)
+	}
+}
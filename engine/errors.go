@@ -0,0 +1,49 @@
+// Copyright 2024 Gustavo C. Viegas. All rights reserved.
+
+package engine
+
+import (
+	"gviegas/neo3/driver"
+)
+
+// Error kinds.
+// Errors returned by the engine wrap one of these when
+// applicable. They are the same values as the driver
+// package's error kinds, so errors.Is also classifies
+// driver errors that are returned by the engine.
+var (
+	// ErrInvalidParam means that a call was made with
+	// invalid parameters or in an invalid state.
+	ErrInvalidParam = driver.ErrInvalidParam
+	// ErrOutOfMemory means that host or device memory
+	// (or engine storage) could not be allocated.
+	ErrOutOfMemory = driver.ErrOutOfMemory
+	// ErrDeviceLost means that the GPU device was lost.
+	ErrDeviceLost = driver.ErrDeviceLost
+	// ErrUnsupported means that a feature is not
+	// supported by the driver or device.
+	ErrUnsupported = driver.ErrUnsupported
+)
+
+// Error is the error type of most errors that originate
+// in the engine.
+// Its message is prefixed with the name of the
+// component (e.g., "mesh: "), and it wraps one of the
+// error kinds.
+// Use errors.Is to check its kind, or errors.As to
+// retrieve it.
+type Error struct {
+	prefix string
+	// Reason describes the problem.
+	Reason string
+	kind   error
+}
+
+// newErr creates a new *Error.
+func newErr(prefix, reason string, kind error) error { return &Error{prefix, reason, kind} }
+
+// Error implements error.
+func (e *Error) Error() string { return e.prefix + e.Reason }
+
+// Unwrap returns the error kind.
+func (e *Error) Unwrap() error { return e.kind }
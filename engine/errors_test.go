@@ -0,0 +1,55 @@
+// Copyright 2024 Gustavo C. Viegas. All rights reserved.
+
+package engine
+
+import (
+	"errors"
+	"testing"
+
+	"gviegas/neo3/driver"
+)
+
+func TestErrorKind(t *testing.T) {
+	_, err := NewSkin(nil)
+	var e *Error
+	if !errors.As(err, &e) {
+		t.Fatalf("NewSkin: errors.As(*Error)\nhave false\nwant true")
+	}
+	if s := e.Error(); s != skinPrefix+e.Reason {
+		t.Fatalf("Error.Error:\nhave %s\nwant %s", s, skinPrefix+e.Reason)
+	}
+	if !errors.Is(err, ErrInvalidParam) {
+		t.Fatalf("NewSkin: errors.Is(ErrInvalidParam)\nhave false\nwant true")
+	}
+
+	_, err = NewMesh(nil)
+	if !errors.Is(err, ErrInvalidParam) {
+		t.Fatalf("NewMesh: errors.Is(ErrInvalidParam)\nhave false\nwant true")
+	}
+	var ve *ValidationError
+	if !errors.As(err, &ve) {
+		t.Fatalf("NewMesh: errors.As(*ValidationError)\nhave false\nwant true")
+	}
+
+	for _, x := range [...]struct {
+		err  error
+		kind error
+	}{
+		{ErrExhausted, ErrOutOfMemory},
+		{driver.ErrNoDeviceMemory, ErrOutOfMemory},
+		{driver.ErrNoHostMemory, ErrOutOfMemory},
+		{&driver.DeviceLostError{}, ErrDeviceLost},
+		{driver.ErrCannotPresent, ErrUnsupported},
+		{newErr(presPrefix, "x", ErrUnsupported), ErrUnsupported},
+	} {
+		if !errors.Is(x.err, x.kind) {
+			t.Fatalf("errors.Is(%v, %v)\nhave false\nwant true", x.err, x.kind)
+		}
+		if errors.Is(x.err, ErrInvalidParam) {
+			t.Fatalf("errors.Is(%v, %v)\nhave true\nwant false", x.err, ErrInvalidParam)
+		}
+	}
+	if err := newErr(presPrefix, "x", ErrUnsupported); err.Error() != presPrefix+"x" {
+		t.Fatalf("Error.Error:\nhave %s\nwant %s", err.Error(), presPrefix+"x")
+	}
+}
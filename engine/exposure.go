@@ -0,0 +1,236 @@
+// Copyright 2024 Gustavo C. Viegas. All rights reserved.
+
+package engine
+
+import (
+	"math"
+	"time"
+	"unsafe"
+
+	"gviegas/neo3/driver"
+	"gviegas/neo3/engine/internal/ctxt"
+	"gviegas/neo3/engine/internal/shader"
+)
+
+// ExposureParam describes automatic exposure.
+// Exposure is computed from a histogram of the
+// scene's luminance and clamped to [MinEV, MaxEV]
+// (EV100). Scenes outside of this range will appear
+// too dark or too bright.
+// SpeedUp and SpeedDown control how fast exposure
+// adapts when the scene becomes brighter and darker,
+// respectively. Higher values mean faster adaptation;
+// after 1/speed seconds, about 63% of the difference
+// will have been compensated for.
+// GradeParam.Exposure, if set, is applied on top of
+// the computed exposure.
+type ExposureParam struct {
+	MinEV     float32
+	MaxEV     float32
+	SpeedUp   float32
+	SpeedDown float32
+}
+
+// Fractions of the histogram, from the dark and bright
+// ends, that are ignored when computing the average
+// luminance.
+// This prevents small, very dark or bright areas from
+// affecting the exposure of the whole scene.
+const (
+	exposureLow  = 0.5
+	exposureHigh = 0.05
+)
+
+// exposure is the state of automatic exposure.
+type exposure struct {
+	param  ExposureParam
+	layout shader.ExposureLayout
+	// Luminance histogram written by each
+	// frame. Host visible.
+	hist [NFrame]driver.Buffer
+	// Current exposure, in EV100.
+	ev float32
+	// Whether ev is valid. Exposure is not
+	// adapted gradually until the first
+	// histogram is read.
+	init bool
+}
+
+// Name of the histogram pass.
+const exposurePass = "exposure.histogram"
+
+// SetAutoExposure enables automatic exposure in r.
+// If param is nil, automatic exposure is disabled,
+// in which case the exposure scale is given by the
+// physical camera (see SetCamera), or fixed at 1 if
+// there is none.
+func (r *Renderer) SetAutoExposure(param *ExposureParam) error {
+	if param == nil {
+		r.freeExposure()
+		return nil
+	}
+	var reason string
+	switch {
+	case param.MinEV >= param.MaxEV:
+		reason = "invalid exposure range"
+	case param.SpeedUp <= 0, param.SpeedDown <= 0:
+		reason = "invalid exposure adaptation speed"
+	default:
+		goto validParam
+	}
+	return newRendErr(reason)
+validParam:
+	if r.expo == nil {
+		if err := r.initExposure(); err != nil {
+			return err
+		}
+	}
+	e := r.expo
+	e.param = *param
+	// Log2 luminance is EV100 - 3.
+	e.layout.SetRange(param.MinEV-3, param.MaxEV-param.MinEV)
+	if e.init {
+		e.ev = min(max(e.ev, param.MinEV), param.MaxEV)
+		e.layout.SetExposure(evToExposure(e.ev))
+	}
+	return nil
+}
+
+// AutoExposure returns the automatic exposure
+// parameters of r.
+// If automatic exposure is disabled, it returns false.
+func (r *Renderer) AutoExposure() (ExposureParam, bool) {
+	if r.expo == nil {
+		return ExposureParam{}, false
+	}
+	return r.expo.param, true
+}
+
+// initExposure creates the histogram buffers and adds
+// the histogram pass to r's frame graph.
+func (r *Renderer) initExposure() (err error) {
+	e := new(exposure)
+	for i := range e.hist {
+		e.hist[i], err = ctxt.GPU().NewBuffer(4*shader.ExposureBins, true, driver.UShaderRead|driver.UShaderWrite)
+		if err != nil {
+			e.free()
+			return
+		}
+		clear(e.hist[i].Bytes())
+	}
+	e.layout.SetExposure(1)
+	r.expo = e
+	// The histogram is computed from the lit
+	// image before post-processing. It is read
+	// back once the frame completes, so the
+	// exposure used for tonemapping lags behind
+	// by up to NFrame frames.
+	r.graph.add(&passNode{
+		name:  exposurePass,
+		stage: stagePost,
+		reads: []*Texture{r.hdr},
+	})
+	return
+}
+
+// freeExposure removes the histogram pass from r's
+// frame graph and frees the histogram buffers.
+func (r *Renderer) freeExposure() {
+	if r.expo == nil {
+		return
+	}
+	r.graph.remove(exposurePass)
+	r.expo.free()
+	r.expo = nil
+}
+
+// free frees the histogram buffers.
+func (e *exposure) free() {
+	for _, b := range e.hist {
+		if b != nil {
+			b.Destroy()
+		}
+	}
+}
+
+// updateExposure adapts the exposure of r using the
+// histogram written by the given frame.
+// dt is the time elapsed since the previous update.
+// It must only be called after the frame's commands
+// complete execution. The histogram is cleared for
+// reuse.
+func (r *Renderer) updateExposure(frame int, dt time.Duration) {
+	if r.expo == nil {
+		return
+	}
+	b := r.expo.hist[frame].Bytes()
+	hist := unsafe.Slice((*uint32)(unsafe.Pointer(unsafe.SliceData(b))), shader.ExposureBins)
+	r.expo.adapt(hist, dt)
+	clear(hist)
+}
+
+// adapt moves e's exposure towards the one computed
+// from hist.
+func (e *exposure) adapt(hist []uint32, dt time.Duration) {
+	target, ok := e.targetEV(hist)
+	if !ok {
+		return
+	}
+	if !e.init {
+		e.ev = target
+		e.init = true
+	} else {
+		speed := e.param.SpeedUp
+		if target < e.ev {
+			speed = e.param.SpeedDown
+		}
+		t := 1 - math.Exp(-dt.Seconds()*float64(speed))
+		e.ev += (target - e.ev) * float32(t)
+	}
+	e.layout.SetExposure(evToExposure(e.ev))
+}
+
+// targetEV computes the exposure, in EV100, that suits
+// the average luminance in hist.
+// It returns false if hist has no samples in range.
+func (e *exposure) targetEV(hist []uint32) (float32, bool) {
+	var total uint64
+	// Pixels below the minimum luminance do
+	// count towards the dark fraction.
+	for _, n := range hist {
+		total += uint64(n)
+	}
+	if total == 0 {
+		return 0, false
+	}
+	lo := uint64(float64(total) * exposureLow)
+	hi := total - uint64(float64(total)*exposureHigh)
+	minLog, rangeLog := e.layout.Range()
+	var sum float64
+	var cnt, acc uint64
+	for i, n := range hist {
+		// Samples of this bin that fall
+		// within [lo, hi).
+		a := max(acc, lo)
+		b := min(acc+uint64(n), hi)
+		acc += uint64(n)
+		if i == 0 || a >= b {
+			continue
+		}
+		x := (float64(i) - 0.5) / float64(len(hist)-2)
+		sum += (float64(minLog) + x*float64(rangeLog)) * float64(b-a)
+		cnt += b - a
+	}
+	if cnt == 0 {
+		return 0, false
+	}
+	ev := float32(sum/float64(cnt)) + 3
+	return min(max(ev, e.param.MinEV), e.param.MaxEV), true
+}
+
+// evToExposure converts an EV100 value to an exposure
+// scale, assuming a camera with a sensitivity of 100
+// ISO.
+func evToExposure(ev float32) float32 {
+	return float32(1 / (1.2 * math.Exp2(float64(ev))))
+}
@@ -0,0 +1,109 @@
+// Copyright 2024 Gustavo C. Viegas. All rights reserved.
+
+package engine
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"gviegas/neo3/engine/internal/shader"
+)
+
+// testExposure creates an exposure for testing.
+func testExposure(minEV, maxEV float32) *exposure {
+	e := &exposure{param: ExposureParam{MinEV: minEV, MaxEV: maxEV, SpeedUp: 2, SpeedDown: 1}}
+	e.layout.SetRange(minEV-3, maxEV-minEV)
+	return e
+}
+
+// binOf returns the histogram bin of the given EV100.
+func binOf(e *exposure, ev float32) int {
+	minLog, rangeLog := e.layout.Range()
+	x := (ev - 3 - minLog) / rangeLog
+	return int(x*(shader.ExposureBins-2)) + 1
+}
+
+func TestExposureTarget(t *testing.T) {
+	e := testExposure(-4, 16)
+	var hist [shader.ExposureBins]uint32
+	if _, ok := e.targetEV(hist[:]); ok {
+		t.Fatal("exposure.targetEV: unexpected success for empty histogram")
+	}
+
+	// Uniform scene.
+	hist[binOf(e, 8)] = 1000
+	ev, ok := e.targetEV(hist[:])
+	if !ok || math.Abs(float64(ev-8)) > 0.1 {
+		t.Fatalf("exposure.targetEV:\nhave %v, %t\nwant ~8, true", ev, ok)
+	}
+
+	// A few very bright pixels must not matter.
+	hist[binOf(e, 15)] = 20
+	if x, _ := e.targetEV(hist[:]); x != ev {
+		t.Fatalf("exposure.targetEV: highlights\nhave %v\nwant %v", x, ev)
+	}
+
+	// Neither should the darker half.
+	hist[0] = 1000
+	if x, _ := e.targetEV(hist[:]); x != ev {
+		t.Fatalf("exposure.targetEV: shadows\nhave %v\nwant %v", x, ev)
+	}
+
+	// Out of range.
+	clear(hist[:])
+	hist[shader.ExposureBins-1] = 1
+	if x, _ := e.targetEV(hist[:]); x > 16 {
+		t.Fatalf("exposure.targetEV: clamping\nhave %v\nwant <= 16", x)
+	}
+	clear(hist[:])
+	hist[0] = 1
+	if _, ok := e.targetEV(hist[:]); ok {
+		t.Fatal("exposure.targetEV: unexpected success for black image")
+	}
+}
+
+func TestExposureAdapt(t *testing.T) {
+	e := testExposure(-4, 16)
+	var hist [shader.ExposureBins]uint32
+	hist[binOf(e, 4)] = 1
+	target, _ := e.targetEV(hist[:])
+
+	// The first update is not gradual.
+	e.adapt(hist[:], time.Second/60)
+	if !e.init || e.ev != target {
+		t.Fatalf("exposure.adapt: first update\nhave %v, %t\nwant %v, true", e.ev, e.init, target)
+	}
+	if x, want := e.layout.Exposure(), evToExposure(target); x != want {
+		t.Fatalf("exposure.adapt: layout.Exposure\nhave %v\nwant %v", x, want)
+	}
+
+	// Brighter scene: adapt with SpeedUp.
+	clear(hist[:])
+	hist[binOf(e, 10)] = 1
+	bright, _ := e.targetEV(hist[:])
+	e.adapt(hist[:], time.Second/2)
+	want := target + (bright-target)*float32(1-math.Exp(-0.5*2))
+	if math.Abs(float64(e.ev-want)) > 1e-4 {
+		t.Fatalf("exposure.adapt: brighter\nhave %v\nwant %v", e.ev, want)
+	}
+
+	// Darker scene: adapt with SpeedDown.
+	prev := e.ev
+	clear(hist[:])
+	hist[binOf(e, 0)] = 1
+	dark, _ := e.targetEV(hist[:])
+	e.adapt(hist[:], time.Second/2)
+	want = prev + (dark-prev)*float32(1-math.Exp(-0.5*1))
+	if math.Abs(float64(e.ev-want)) > 1e-4 {
+		t.Fatalf("exposure.adapt: darker\nhave %v\nwant %v", e.ev, want)
+	}
+
+	// Empty histograms leave exposure as is.
+	prev = e.ev
+	clear(hist[:])
+	e.adapt(hist[:], time.Second)
+	if e.ev != prev {
+		t.Fatalf("exposure.adapt: empty\nhave %v\nwant %v", e.ev, prev)
+	}
+}
@@ -0,0 +1,259 @@
+// Copyright 2024 Gustavo C. Viegas. All rights reserved.
+
+package engine
+
+import (
+	"gviegas/neo3/driver"
+	"gviegas/neo3/engine/internal/shader"
+	"gviegas/neo3/linear"
+)
+
+// FogParam describes volumetric fog.
+// The fog is a participating medium whose extinction
+// coefficient, per world unit, is Density at or below
+// Height, decreasing exponentially with Falloff above
+// it. Albedo is the fraction of extinguished light
+// that is scattered, and Anisotropy (in (-1, 1))
+// controls whether light is scattered forwards
+// (positive) or backwards (negative). Ambient is
+// light scattered uniformly, in addition to that of
+// the renderer's lights.
+// Fog is evaluated in a froxel grid (i.e., frustum
+// aligned voxels) covering Distance world units from
+// the camera. Surfaces farther than that receive the
+// fog accumulated up to Distance.
+// History is the weight, in [0, 1), of the previous
+// frame's result in temporal integration. Higher
+// values reduce noise at the cost of ghosting.
+type FogParam struct {
+	Density    float32
+	Height     float32
+	Falloff    float32
+	Albedo     linear.V3
+	Anisotropy float32
+	Ambient    linear.V3
+	Distance   float32
+	History    float32
+}
+
+// Froxel grid dimensions.
+// The Z dimension is divided into slices whose depth
+// increases exponentially from fogNear to
+// FogParam.Distance.
+const (
+	fogGridX = 160
+	fogGridY = 90
+	fogGridZ = 64
+	fogNear  = 0.1
+)
+
+// fogFmt is the pixel format of fog volumes.
+const fogFmt = driver.RGBA16Float
+
+// fog is the state of the volumetric fog passes.
+type fog struct {
+	param  FogParam
+	layout shader.FogLayout
+	// Scattering (RGB) and extinction (A) of each
+	// froxel, temporally integrated. One volume
+	// holds the current frame's result and the
+	// other, the previous frame's.
+	scatter [2]*Texture
+	cur     int
+	// In-scattered light (RGB) and transmittance
+	// (A) accumulated from the camera through
+	// each froxel. This is what shading samples.
+	integ *Texture
+	// Passes whose reads/writes are swapped
+	// every frame.
+	inject, integrate *passNode
+	// Number of frames advanced, used to select
+	// the depth jitter.
+	frame uint
+	// View-projection of the previous frame.
+	vp linear.M4
+}
+
+// Names of the fog passes.
+const (
+	fogInjectPass    = "fog.inject"
+	fogIntegratePass = "fog.integrate"
+)
+
+// SetFog enables volumetric fog in r.
+// If param is nil, fog is disabled.
+// Fog is applied during shading, so it affects both
+// opaque and blended materials.
+// It fails with ErrUnsupported while the fog passes
+// are not recorded.
+func (r *Renderer) SetFog(param *FogParam) error {
+	if param == nil {
+		r.freeFog()
+		return nil
+	}
+	var reason string
+	switch {
+	case param.Density < 0:
+		reason = "negative fog density"
+	case param.Falloff < 0:
+		reason = "negative fog falloff"
+	case param.Albedo[0] < 0 || param.Albedo[1] < 0 || param.Albedo[2] < 0,
+		param.Albedo[0] > 1 || param.Albedo[1] > 1 || param.Albedo[2] > 1:
+		reason = "fog albedo out of range"
+	case !(param.Anisotropy > -1 && param.Anisotropy < 1):
+		reason = "fog anisotropy out of range"
+	case param.Ambient[0] < 0 || param.Ambient[1] < 0 || param.Ambient[2] < 0:
+		reason = "negative fog ambient light"
+	case !(param.Distance > fogNear):
+		reason = "invalid fog distance"
+	case !(param.History >= 0 && param.History < 1):
+		reason = "fog history weight out of range"
+	default:
+		goto validParam
+	}
+	return newRendErr(reason)
+validParam:
+	if err := checkPending("fog", fogInjectPass, fogIntegratePass); err != nil {
+		return err
+	}
+	if r.fog == nil {
+		if err := r.initFog(); err != nil {
+			return err
+		}
+	}
+	f := r.fog
+	f.param = *param
+	f.layout.SetMedium(&param.Albedo, param.Density)
+	f.layout.SetAmbient(&param.Ambient)
+	f.layout.SetAnisotropy(param.Anisotropy)
+	f.layout.SetHeight(param.Height, param.Falloff)
+	f.layout.SetDistance(param.Distance)
+	f.layout.SetHistory(param.History)
+	return nil
+}
+
+// Fog returns the fog parameters of r.
+// If fog is disabled, it returns false.
+func (r *Renderer) Fog() (FogParam, bool) {
+	if r.fog == nil {
+		return FogParam{}, false
+	}
+	return r.fog.param, true
+}
+
+// initFog creates the fog volumes and adds the fog
+// passes to r's frame graph.
+func (r *Renderer) initFog() (err error) {
+	f := new(fog)
+	defer func() {
+		if err != nil {
+			f.free()
+		}
+	}()
+	param := TexParam{
+		PixelFmt: fogFmt,
+		Dim3D:    driver.Dim3D{Width: fogGridX, Height: fogGridY, Depth: fogGridZ},
+		Layers:   1,
+		Levels:   1,
+		Samples:  1,
+	}
+	for i := range f.scatter {
+		if f.scatter[i], err = newVolume(&param); err != nil {
+			return
+		}
+	}
+	if f.integ, err = newVolume(&param); err != nil {
+		return
+	}
+	// Injection computes the density and lighting
+	// of every froxel and blends them with the
+	// reprojected history. Integration then
+	// accumulates scattering and transmittance
+	// front-to-back along each froxel column.
+	// Neither depends on the depth buffer, so both
+	// run before opaque geometry is shaded, and
+	// asynchronously with it if possible.
+	f.inject = &passNode{
+		name:   fogInjectPass,
+		stage:  stageGeometry,
+		reads:  []*Texture{f.scatter[1]},
+		writes: []*Texture{f.scatter[0]},
+		async:  true,
+	}
+	f.integrate = &passNode{
+		name:   fogIntegratePass,
+		stage:  stageGeometry,
+		reads:  []*Texture{f.scatter[0]},
+		writes: []*Texture{f.integ},
+		async:  true,
+	}
+	r.fog = f
+	r.graph.add(f.inject)
+	r.graph.add(f.integrate)
+	return
+}
+
+// freeFog removes the fog passes from r's frame graph
+// and frees the fog volumes.
+func (r *Renderer) freeFog() {
+	if r.fog == nil {
+		return
+	}
+	r.graph.remove(fogInjectPass)
+	r.graph.remove(fogIntegratePass)
+	r.fog.free()
+	r.fog = nil
+}
+
+// free frees the fog volumes.
+func (f *fog) free() {
+	for _, t := range [...]*Texture{f.scatter[0], f.scatter[1], f.integ} {
+		if t != nil {
+			t.Free()
+		}
+	}
+}
+
+// advance prepares r's fog for a new frame whose
+// view-projection transform is vp.
+// The scattering volumes are swapped, so that the
+// last frame's result becomes the history, and the
+// depth jitter is advanced.
+// It must be called once per frame, before r's frame
+// graph is compiled.
+func (f *fog) advance(g *frameGraph, vp *linear.M4) {
+	if f.frame == 0 {
+		// No history to reproject from.
+		f.layout.SetPrevVP(vp)
+	} else {
+		f.layout.SetPrevVP(&f.vp)
+	}
+	f.vp = *vp
+	f.frame++
+	f.layout.SetJitter(halton(f.frame, 2))
+	f.cur ^= 1
+	f.inject.reads[0] = f.scatter[f.cur^1]
+	f.inject.writes[0] = f.scatter[f.cur]
+	f.integrate.reads[0] = f.scatter[f.cur]
+	g.trans = g.trans[:0]
+}
+
+// fogSliceDepth returns the view depth at which the
+// given slice of the froxel grid starts.
+// slice is in [0, fogGridZ]. It must match the
+// fogSliceDepth function in fog_0.
+func fogSliceDepth(slice int, distance float32) float32 {
+	return clusterSliceDepth(slice, fogGridZ, fogNear, distance)
+}
+
+// halton returns the i-th element of the Halton
+// sequence of the given base, in [0, 1).
+func halton(i uint, base uint) float32 {
+	var r float64
+	f := 1.0
+	for ; i > 0; i /= base {
+		f /= float64(base)
+		r += f * float64(i%base)
+	}
+	return float32(r)
+}
@@ -0,0 +1,152 @@
+// Copyright 2024 Gustavo C. Viegas. All rights reserved.
+
+package engine
+
+import (
+	"errors"
+	"math"
+	"testing"
+
+	"gviegas/neo3/driver"
+	"gviegas/neo3/linear"
+)
+
+func TestHalton(t *testing.T) {
+	want := []float32{0, 0.5, 0.25, 0.75, 0.125, 0.625}
+	for i, w := range want {
+		if x := halton(uint(i), 2); x != w {
+			t.Fatalf("halton(%d, 2):\nhave %v\nwant %v", i, x, w)
+		}
+	}
+	if x := halton(5, 3); math.Abs(float64(x)-7.0/9.0) > 1e-6 {
+		t.Fatalf("halton(5, 3):\nhave %v\nwant %v", x, 7.0/9.0)
+	}
+}
+
+func TestFogSliceDepth(t *testing.T) {
+	if x := fogSliceDepth(0, 100); x != fogNear {
+		t.Fatalf("fogSliceDepth(0, 100):\nhave %v\nwant %v", x, fogNear)
+	}
+	if x := fogSliceDepth(fogGridZ, 100); math.Abs(float64(x)-100) > 1e-3 {
+		t.Fatalf("fogSliceDepth(%d, 100):\nhave %v\nwant 100", fogGridZ, x)
+	}
+	// Slices get thicker with distance.
+	for i := 1; i < fogGridZ; i++ {
+		d0 := fogSliceDepth(i, 100) - fogSliceDepth(i-1, 100)
+		d1 := fogSliceDepth(i+1, 100) - fogSliceDepth(i, 100)
+		if d1 <= d0 {
+			t.Fatalf("fogSliceDepth: slice %d is not thicker than slice %d", i, i-1)
+		}
+	}
+}
+
+func TestRendererFog(t *testing.T) {
+	rend, err := NewOffscreen(256, 192)
+	if err != nil {
+		t.Fatalf("RendererFog: NewOffscreen failed:\n%v", err)
+	}
+	defer rend.Free()
+	if _, ok := rend.Fog(); ok {
+		t.Fatal("Renderer.Fog: fog should be disabled by default")
+	}
+
+	valid := FogParam{
+		Density:    0.05,
+		Height:     2,
+		Falloff:    0.5,
+		Albedo:     linear.V3{1, 1, 1},
+		Anisotropy: 0.3,
+		Ambient:    linear.V3{0.02, 0.02, 0.03},
+		Distance:   64,
+		History:    0.9,
+	}
+	for _, f := range [...]func(p *FogParam){
+		func(p *FogParam) { p.Density = -1 },
+		func(p *FogParam) { p.Falloff = -1 },
+		func(p *FogParam) { p.Albedo[1] = 1.5 },
+		func(p *FogParam) { p.Anisotropy = 1 },
+		func(p *FogParam) { p.Ambient[2] = -0.5 },
+		func(p *FogParam) { p.Distance = fogNear },
+		func(p *FogParam) { p.History = 1 },
+	} {
+		p := valid
+		f(&p)
+		if err := rend.SetFog(&p); err == nil {
+			t.Fatal("Renderer.SetFog: unexpected nil error")
+		}
+	}
+	if err := rend.SetFog(&valid); !errors.Is(err, ErrUnsupported) {
+		t.Fatalf("Renderer.SetFog: pending passes\nhave %v\nwant %v", err, ErrUnsupported)
+	}
+	if _, ok := rend.Fog(); ok || rend.graph.find(fogInjectPass) >= 0 {
+		t.Fatal("Renderer.SetFog: fog should not be enabled")
+	}
+	defer allowPending(fogInjectPass, fogIntegratePass)()
+	if err := rend.SetFog(&valid); err != nil {
+		t.Fatalf("Renderer.SetFog failed:\n%v", err)
+	}
+	if x, ok := rend.Fog(); !ok || x != valid {
+		t.Fatalf("Renderer.Fog:\nhave %v, %t\nwant %v, true", x, ok, valid)
+	}
+	f := rend.fog
+	if alb, dens := f.layout.Medium(); alb != valid.Albedo || dens != valid.Density {
+		t.Fatalf("Renderer.SetFog: fog.layout.Medium\nhave %v, %v\nwant %v, %v", alb, dens, valid.Albedo, valid.Density)
+	}
+	if x := f.layout.Distance(); x != valid.Distance {
+		t.Fatalf("Renderer.SetFog: fog.layout.Distance\nhave %v\nwant %v", x, valid.Distance)
+	}
+	for _, v := range [...]*Texture{f.scatter[0], f.scatter[1], f.integ} {
+		if v.Width() != fogGridX || v.Height() != fogGridY || v.Depth() != fogGridZ {
+			t.Fatalf("Renderer.SetFog: volume size\nhave %dx%dx%d", v.Width(), v.Height(), v.Depth())
+		}
+		if v.usage&driver.UShaderWrite == 0 {
+			t.Fatal("Renderer.SetFog: volume should be writable by shaders")
+		}
+	}
+	for _, name := range [...]string{fogInjectPass, fogIntegratePass} {
+		if rend.graph.find(name) < 0 {
+			t.Fatalf("Renderer.SetFog: missing pass %q", name)
+		}
+	}
+	if err := rend.graph.compile(); err != nil {
+		t.Fatalf("frameGraph.compile failed:\n%v", err)
+	}
+	for _, x := range rend.graph.trans[rend.graph.find(fogInjectPass)] {
+		if x.tex == f.scatter[0] && x.after != driver.LShaderStore {
+			t.Fatalf("frameGraph.compile: scatter volume layout\nhave %v\nwant %v", x.after, driver.LShaderStore)
+		}
+	}
+
+	// The first frame has no history, so it
+	// reprojects onto itself.
+	var vp0, vp1 linear.M4
+	vp0.Translate(1, 2, 3)
+	vp1.Translate(4, 5, 6)
+	f.advance(&rend.graph, &vp0)
+	if x := f.layout.PrevVP(); x != vp0 {
+		t.Fatalf("fog.advance: layout.PrevVP\nhave %v\nwant %v", x, vp0)
+	}
+	if f.inject.reads[0] != f.scatter[0] || f.inject.writes[0] != f.scatter[1] || f.integrate.reads[0] != f.scatter[1] {
+		t.Fatal("fog.advance: scatter volumes should have been swapped")
+	}
+	if len(rend.graph.trans) != 0 {
+		t.Fatal("fog.advance: frame graph should need to be compiled again")
+	}
+	f.advance(&rend.graph, &vp1)
+	if x := f.layout.PrevVP(); x != vp0 {
+		t.Fatalf("fog.advance: layout.PrevVP\nhave %v\nwant %v", x, vp0)
+	}
+	if f.inject.reads[0] != f.scatter[1] || f.inject.writes[0] != f.scatter[0] {
+		t.Fatal("fog.advance: scatter volumes should have been swapped back")
+	}
+	if x := f.layout.Jitter(); x != 0.25 {
+		t.Fatalf("fog.advance: layout.Jitter\nhave %v\nwant 0.25", x)
+	}
+
+	if err := rend.SetFog(nil); err != nil {
+		t.Fatalf("Renderer.SetFog(nil) failed:\n%v", err)
+	}
+	if _, ok := rend.Fog(); ok || rend.graph.find(fogInjectPass) >= 0 {
+		t.Fatal("Renderer.SetFog(nil): fog should be disabled")
+	}
+}
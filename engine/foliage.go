@@ -0,0 +1,388 @@
+// Copyright 2024 Gustavo C. Viegas. All rights reserved.
+
+package engine
+
+import (
+	"math"
+	"math/rand/v2"
+	"time"
+
+	"gviegas/neo3/engine/internal/shader"
+	"gviegas/neo3/linear"
+)
+
+// MaxFoliageInstance is the maximum number of instances
+// that a single foliage layer can hold.
+const MaxFoliageInstance = 1 << 20
+
+// foliageChunkSize is the size of foliage chunks
+// along the X and Z axes.
+const foliageChunkSize = 16
+
+// foliageMap is a dataMap for foliage layers.
+type foliageMap struct{ dataMap[Foliage, foliage] }
+
+// foliage is what a foliageMap stores.
+type foliage struct {
+	mesh      *Mesh
+	mat       *Material
+	chunks    []foliageChunk
+	fadeStart float32
+	fadeEnd   float32
+	// Maximum horizontal displacement caused by
+	// wind, added to chunk bounds when culling.
+	sway     float32
+	maxScale float32
+	time     time.Duration
+	layout   shader.FoliageLayout
+}
+
+// foliageChunk is a group of foliage instances that
+// are close to each other.
+// Chunks are culled as a whole.
+type foliageChunk struct {
+	// World space bounds of every instance,
+	// not including wind sway.
+	min, max linear.V3
+	inst     []shader.InstanceLayout
+}
+
+// Foliage identifies a foliage layer.
+// A Foliage is always associated with a Renderer,
+// thus there might be identical Foliage values
+// that belong to different renderers.
+type Foliage int
+
+// DensityMap is a grid of density values covering a
+// rectangle of the XZ plane.
+// Density values are in the range [0, 1], and are
+// interpolated bilinearly between grid points.
+type DensityMap struct {
+	// Number of grid points along X and Z.
+	Width, Height int
+	// Density values in row-major order.
+	// Row 0 is at Min[1].
+	Values []float32
+	// World space rectangle.
+	Min, Max [2]float32
+}
+
+// At returns the density at world position (x, z).
+// It returns 0 if (x, z) lies outside of m.
+func (m *DensityMap) At(x, z float32) float32 {
+	if x < m.Min[0] || x > m.Max[0] || z < m.Min[1] || z > m.Max[1] {
+		return 0
+	}
+	u := (x - m.Min[0]) / (m.Max[0] - m.Min[0]) * float32(m.Width-1)
+	v := (z - m.Min[1]) / (m.Max[1] - m.Min[1]) * float32(m.Height-1)
+	x0, z0 := int(u), int(v)
+	x1, z1 := min(x0+1, m.Width-1), min(z0+1, m.Height-1)
+	u -= float32(x0)
+	v -= float32(z0)
+	at := func(x, z int) float32 { return m.Values[z*m.Width+x] }
+	a := at(x0, z0) + (at(x1, z0)-at(x0, z0))*u
+	b := at(x0, z1) + (at(x1, z1)-at(x0, z1))*u
+	return a + (b-a)*v
+}
+
+// Wind describes the wind that sways foliage.
+type Wind struct {
+	// Direction in the XZ plane.
+	// It need not be normalized.
+	Direction [2]float32
+	// Displacement at the top of the mesh,
+	// in local units.
+	Strength float32
+	// Oscillations per second.
+	Frequency float32
+	// Amount of turbulent, higher frequency
+	// motion, relative to Strength.
+	Gust float32
+}
+
+// FoliageParam describes a foliage layer.
+// Instances of Mesh are scattered over the area
+// covered by Density, with one candidate position
+// per Spacing×Spacing cell. Each candidate is kept
+// with probability equal to the density at its
+// position.
+// Height gives the terrain height at a position.
+// If it returns false, no instance is placed there.
+// If Height is nil, instances are placed at Y=0.
+// Every instance has a random rotation about the Y
+// axis and a random scale in [MinScale, MaxScale].
+// Instances fade out as their distance to the
+// camera goes from FadeStart to FadeEnd, and are
+// culled past FadeEnd. If FadeEnd is zero,
+// instances never fade.
+type FoliageParam struct {
+	Mesh      *Mesh
+	Mat       *Material
+	Density   *DensityMap
+	Spacing   float32
+	Height    func(x, z float32) (float32, bool)
+	MinScale  float32
+	MaxScale  float32
+	Seed      uint64
+	Wind      Wind
+	FadeStart float32
+	FadeEnd   float32
+}
+
+// AddFoliage adds a new foliage layer to r.
+// Instances are placed by this method and do not
+// change afterwards.
+// Foliage is drawn with the opaque primitives, using
+// one instanced draw per visible layer.
+func (r *Renderer) AddFoliage(param *FoliageParam) (Foliage, error) {
+	var reason string
+	switch {
+	case param == nil:
+		reason = "nil foliage param"
+	case param.Mesh == nil:
+		reason = "nil foliage mesh"
+	case param.Mat == nil:
+		reason = "nil foliage material"
+	case param.Density == nil:
+		reason = "nil foliage density map"
+	case param.Density.Width < 1 || param.Density.Height < 1,
+		len(param.Density.Values) != param.Density.Width*param.Density.Height:
+		reason = "invalid foliage density map dimensions"
+	case !(param.Density.Max[0] > param.Density.Min[0]) || !(param.Density.Max[1] > param.Density.Min[1]):
+		reason = "invalid foliage density map area"
+	case !(param.Spacing > 0):
+		reason = "invalid foliage spacing"
+	case param.MinScale < 0 || param.MaxScale < param.MinScale:
+		reason = "invalid foliage scale range"
+	case param.Wind.Strength < 0 || param.Wind.Frequency < 0 || param.Wind.Gust < 0:
+		reason = "negative foliage wind parameter"
+	case param.FadeStart < 0 || param.FadeEnd < 0,
+		param.FadeEnd > 0 && param.FadeStart > param.FadeEnd:
+		reason = "invalid foliage fade range"
+	default:
+		goto validParam
+	}
+	return -1, newRendErr(reason)
+validParam:
+	f := foliage{
+		mesh:      param.Mesh,
+		mat:       param.Mat,
+		fadeStart: param.FadeStart,
+		fadeEnd:   param.FadeEnd,
+	}
+	if err := f.place(param); err != nil {
+		return -1, err
+	}
+	f.setWind(&param.Wind)
+	return r.foliage.insert(f), nil
+}
+
+// RemoveFoliage removes f from r.
+func (r *Renderer) RemoveFoliage(f Foliage) { r.foliage.remove(f) }
+
+// SetFoliageWind sets the wind that sways f.
+// It does not reset f's wind time.
+func (r *Renderer) SetFoliageWind(f Foliage, wind *Wind) {
+	r.foliage.get(f).setWind(wind)
+}
+
+// FoliageLen returns the number of foliage layers
+// in r.
+func (r *Renderer) FoliageLen() int { return r.foliage.len() }
+
+// FoliageInstanceLen returns the number of instances
+// in f.
+func (r *Renderer) FoliageInstanceLen(f Foliage) (n int) {
+	for _, c := range r.foliage.get(f).chunks {
+		n += len(c.inst)
+	}
+	return
+}
+
+// UpdateFoliage advances the wind time of every
+// foliage layer in r by dt.
+func (r *Renderer) UpdateFoliage(dt time.Duration) {
+	defer traceBegin(traceUpdate, "foliage").end()
+	for _, f := range r.foliage.all() {
+		f.time += dt
+		f.layout.SetTime(float32(f.time.Seconds()))
+	}
+}
+
+// setWind updates f's layout with the given wind.
+func (f *foliage) setWind(wind *Wind) {
+	var dir [2]float32
+	if l := math.Hypot(float64(wind.Direction[0]), float64(wind.Direction[1])); l > 0 {
+		dir[0] = float32(float64(wind.Direction[0]) / l)
+		dir[1] = float32(float64(wind.Direction[1]) / l)
+	}
+	f.layout.SetWind(dir, wind.Strength, wind.Frequency, wind.Gust)
+	f.sway = wind.Strength * (1 + wind.Gust) * f.maxScale
+}
+
+// place scatters the instances of f as described by
+// param, which must be valid.
+func (f *foliage) place(param *FoliageParam) error {
+	// Local bounds of the whole mesh.
+	// Instances rotate about Y, so the horizontal
+	// extent is given by the farthest corner.
+	lo, hi := f.mesh.bounds(0)
+	for i := 1; i < f.mesh.Len(); i++ {
+		l, h := f.mesh.bounds(i)
+		for j := range lo {
+			lo[j] = min(lo[j], l[j])
+			hi[j] = max(hi[j], h[j])
+		}
+	}
+	var radius float32
+	for _, x := range [2]float32{lo[0], hi[0]} {
+		for _, z := range [2]float32{lo[2], hi[2]} {
+			radius = max(radius, float32(math.Hypot(float64(x), float64(z))))
+		}
+	}
+	f.layout.SetHeight(max(hi[1], 0))
+	minScale, maxScale := param.MinScale, param.MaxScale
+	if maxScale == 0 {
+		minScale, maxScale = 1, 1
+	}
+	f.maxScale = maxScale
+
+	rng := rand.New(rand.NewPCG(param.Seed, param.Seed^0x9e3779b97f4a7c15))
+	dm := param.Density
+	nx := int(math.Ceil(float64((dm.Max[0] - dm.Min[0]) / param.Spacing)))
+	nz := int(math.Ceil(float64((dm.Max[1] - dm.Min[1]) / param.Spacing)))
+	chunks := make(map[[2]int]int)
+	var n int
+	for iz := range nz {
+		for ix := range nx {
+			x := dm.Min[0] + (float32(ix)+rng.Float32())*param.Spacing
+			z := dm.Min[1] + (float32(iz)+rng.Float32())*param.Spacing
+			// Random values are always drawn so
+			// placement in one cell does not
+			// depend on the others.
+			keep := rng.Float32()
+			yaw := rng.Float32() * 2 * math.Pi
+			scale := minScale + rng.Float32()*(maxScale-minScale)
+			phase := rng.Float32() * 2 * math.Pi
+			if keep >= dm.At(x, z) {
+				continue
+			}
+			var y float32
+			if param.Height != nil {
+				var ok bool
+				if y, ok = param.Height(x, z); !ok {
+					continue
+				}
+			}
+			if n == MaxFoliageInstance {
+				return newRendErr("too many foliage instances")
+			}
+			n++
+
+			var inst shader.InstanceLayout
+			pos := linear.V3{x, y, z}
+			inst.SetPosition(&pos)
+			inst.SetYaw(yaw)
+			inst.SetScale(scale)
+			inst.SetPhase(phase)
+			inst.SetFade(1)
+
+			key := [2]int{int(math.Floor(float64(x / foliageChunkSize))), int(math.Floor(float64(z / foliageChunkSize)))}
+			ci, ok := chunks[key]
+			if !ok {
+				ci = len(f.chunks)
+				chunks[key] = ci
+				f.chunks = append(f.chunks, foliageChunk{min: pos, max: pos})
+			}
+			c := &f.chunks[ci]
+			ext := radius * scale
+			c.min[0] = min(c.min[0], x-ext)
+			c.min[1] = min(c.min[1], y+lo[1]*scale)
+			c.min[2] = min(c.min[2], z-ext)
+			c.max[0] = max(c.max[0], x+ext)
+			c.max[1] = max(c.max[1], y+hi[1]*scale)
+			c.max[2] = max(c.max[2], z+ext)
+			c.inst = append(c.inst, inst)
+		}
+	}
+	return nil
+}
+
+// foliageBatch is the set of visible instances of a
+// foliage layer.
+type foliageBatch struct {
+	id   Foliage
+	inst []shader.InstanceLayout
+}
+
+// buildFoliage fills l.foliage with the visible
+// instances of every foliage layer in r.
+// view is the view transform. If fr is not nil,
+// chunks outside of it are culled.
+// Instances past a layer's fade end are culled, and
+// the remaining ones have their fade factors set
+// according to their distance to the camera.
+func (l *drawList) buildFoliage(r *Renderer, view *linear.M4, fr *frustum) {
+	var cam linear.M4
+	cam.Invert(view)
+	eye := linear.V3{cam[3][0], cam[3][1], cam[3][2]}
+	world := linear.I4()
+	n := 0
+	for id, f := range r.foliage.all() {
+		if n == len(l.foliage) {
+			l.foliage = append(l.foliage, foliageBatch{})
+		}
+		b := &l.foliage[n]
+		b.id = id
+		b.inst = b.inst[:0]
+		for i := range f.chunks {
+			c := &f.chunks[i]
+			min, max := c.min, c.max
+			min[0] -= f.sway
+			min[2] -= f.sway
+			max[0] += f.sway
+			max[2] += f.sway
+			if fr != nil && fr.cull(&world, &min, &max) {
+				continue
+			}
+			if f.fadeEnd > 0 && boxDist(&eye, &min, &max) >= f.fadeEnd {
+				continue
+			}
+			for _, inst := range c.inst {
+				fade := float32(1)
+				if f.fadeEnd > 0 {
+					p := inst.Position()
+					p.Sub(&p, &eye)
+					d := p.Len()
+					switch {
+					case d >= f.fadeEnd:
+						continue
+					case d > f.fadeStart:
+						fade = (f.fadeEnd - d) / (f.fadeEnd - f.fadeStart)
+					}
+				}
+				inst.SetFade(fade)
+				b.inst = append(b.inst, inst)
+			}
+		}
+		if len(b.inst) > 0 {
+			n++
+		}
+	}
+	l.foliage = l.foliage[:n]
+}
+
+// boxDist returns the distance from p to the box
+// [min, max].
+func boxDist(p, min, max *linear.V3) float32 {
+	var d linear.V3
+	for i := range d {
+		switch {
+		case p[i] < min[i]:
+			d[i] = min[i] - p[i]
+		case p[i] > max[i]:
+			d[i] = p[i] - max[i]
+		}
+	}
+	return d.Len()
+}
@@ -0,0 +1,188 @@
+// Copyright 2024 Gustavo C. Viegas. All rights reserved.
+
+package engine
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"gviegas/neo3/linear"
+)
+
+func TestDensityMap(t *testing.T) {
+	m := DensityMap{
+		Width:  2,
+		Height: 3,
+		Values: []float32{
+			0, 1,
+			1, 1,
+			0.5, 0,
+		},
+		Min: [2]float32{-1, 0},
+		Max: [2]float32{1, 4},
+	}
+	for _, x := range [...]struct {
+		x, z, want float32
+	}{
+		{-1, 0, 0},
+		{1, 0, 1},
+		{0, 0, 0.5},
+		{-1, 2, 1},
+		{0, 3, 0.625},
+		{1, 4, 0},
+		{-1.5, 2, 0},
+		{0, 4.5, 0},
+	} {
+		if d := m.At(x.x, x.z); d != x.want {
+			t.Fatalf("DensityMap.At(%v, %v):\nhave %v\nwant %v", x.x, x.z, d, x.want)
+		}
+	}
+}
+
+func TestRendererFoliage(t *testing.T) {
+	rend, err := NewOffscreen(256, 192)
+	if err != nil {
+		t.Fatalf("RendererFoliage: NewOffscreen failed:\n%v", err)
+	}
+	defer rend.Free()
+	mesh, err := NewMesh(quadData(0))
+	if err != nil {
+		t.Fatalf("RendererFoliage: NewMesh failed:\n%v", err)
+	}
+	defer mesh.Free()
+	mat, err := NewPBR(&PBR{})
+	if err != nil {
+		t.Fatalf("RendererFoliage: NewPBR failed:\n%v", err)
+	}
+
+	dm := DensityMap{
+		Width:  2,
+		Height: 2,
+		Values: []float32{1, 1, 1, 1},
+		Max:    [2]float32{10, 10},
+	}
+	for _, p := range [...]*FoliageParam{
+		nil,
+		{Mat: mat, Density: &dm, Spacing: 1},
+		{Mesh: mesh, Density: &dm, Spacing: 1},
+		{Mesh: mesh, Mat: mat, Spacing: 1},
+		{Mesh: mesh, Mat: mat, Density: &DensityMap{Width: 2, Height: 2, Values: []float32{1}, Max: dm.Max}, Spacing: 1},
+		{Mesh: mesh, Mat: mat, Density: &DensityMap{Width: 1, Height: 1, Values: []float32{1}}, Spacing: 1},
+		{Mesh: mesh, Mat: mat, Density: &dm},
+		{Mesh: mesh, Mat: mat, Density: &dm, Spacing: 1, MinScale: 2, MaxScale: 1},
+		{Mesh: mesh, Mat: mat, Density: &dm, Spacing: 1, Wind: Wind{Strength: -1}},
+		{Mesh: mesh, Mat: mat, Density: &dm, Spacing: 1, FadeStart: 5, FadeEnd: 4},
+	} {
+		if _, err := rend.AddFoliage(p); err == nil {
+			t.Fatal("Renderer.AddFoliage: unexpected nil error")
+		}
+	}
+
+	param := FoliageParam{
+		Mesh:      mesh,
+		Mat:       mat,
+		Density:   &dm,
+		Spacing:   1,
+		MinScale:  0.5,
+		MaxScale:  1.5,
+		Seed:      42,
+		Wind:      Wind{Direction: [2]float32{3, 4}, Strength: 0.25, Frequency: 1},
+		FadeStart: 2,
+		FadeEnd:   4,
+	}
+	full, err := rend.AddFoliage(&param)
+	if err != nil {
+		t.Fatalf("Renderer.AddFoliage failed:\n%v", err)
+	}
+	// Full density places one instance per cell.
+	if n := rend.FoliageInstanceLen(full); n != 100 {
+		t.Fatalf("Renderer.FoliageInstanceLen:\nhave %d\nwant 100", n)
+	}
+	f := rend.foliage.get(full)
+	if dir, str, _, _ := f.layout.Wind(); dir != [2]float32{0.6, 0.8} || str != 0.25 {
+		t.Fatalf("Renderer.AddFoliage: foliage.layout.Wind\nhave %v, %v\nwant [0.6 0.8], 0.25", dir, str)
+	}
+	if h := f.layout.Height(); h != 1 {
+		t.Fatalf("Renderer.AddFoliage: foliage.layout.Height\nhave %v\nwant 1", h)
+	}
+	for _, c := range f.chunks {
+		for _, inst := range c.inst {
+			p := inst.Position()
+			switch {
+			case p[0] < 0 || p[0] > 10 || p[2] < 0 || p[2] > 10 || p[1] != 0:
+				t.Fatalf("Renderer.AddFoliage: instance position out of bounds\nhave %v", p)
+			case inst.Scale() < 0.5 || inst.Scale() > 1.5:
+				t.Fatalf("Renderer.AddFoliage: instance scale out of range\nhave %v", inst.Scale())
+			case inst.Yaw() < 0 || inst.Yaw() > 2*math.Pi:
+				t.Fatalf("Renderer.AddFoliage: instance yaw out of range\nhave %v", inst.Yaw())
+			}
+			for i := range p {
+				if p[i] < c.min[i] || p[i] > c.max[i] {
+					t.Fatalf("Renderer.AddFoliage: instance outside of chunk bounds\nhave %v\nwant [%v, %v]", p, c.min, c.max)
+				}
+			}
+		}
+	}
+
+	// Same seed, same placement.
+	again, err := rend.AddFoliage(&param)
+	if err != nil {
+		t.Fatalf("Renderer.AddFoliage failed:\n%v", err)
+	}
+	if x, y := rend.foliage.get(again).chunks[0].inst[0], rend.foliage.get(full).chunks[0].inst[0]; x != y {
+		t.Fatalf("Renderer.AddFoliage: placement should be deterministic\nhave %v\nwant %v", x, y)
+	}
+	rend.RemoveFoliage(again)
+
+	param.Seed = 7
+	param.Height = func(x, z float32) (float32, bool) { return 2, x >= 5 }
+	half, err := rend.AddFoliage(&param)
+	if err != nil {
+		t.Fatalf("Renderer.AddFoliage failed:\n%v", err)
+	}
+	if n := rend.FoliageInstanceLen(half); n != 50 {
+		t.Fatalf("Renderer.FoliageInstanceLen:\nhave %d\nwant 50", n)
+	}
+	if x := rend.foliage.get(half).chunks[0].inst[0].Position(); x[0] < 5 || x[1] != 2 {
+		t.Fatalf("Renderer.AddFoliage: instance should follow Height\nhave %v", x)
+	}
+	if x := rend.FoliageLen(); x != 2 {
+		t.Fatalf("Renderer.FoliageLen:\nhave %d\nwant 2", x)
+	}
+
+	rend.UpdateFoliage(1500 * time.Millisecond)
+	if x := rend.foliage.get(full).layout.Time(); x != 1.5 {
+		t.Fatalf("Renderer.UpdateFoliage: foliage.layout.Time\nhave %v\nwant 1.5", x)
+	}
+	rend.SetFoliageWind(full, &Wind{Direction: [2]float32{-2, 0}, Strength: 0.5})
+	if dir, str, _, _ := rend.foliage.get(full).layout.Wind(); dir != [2]float32{-1, 0} || str != 0.5 {
+		t.Fatalf("Renderer.SetFoliageWind: foliage.layout.Wind\nhave %v, %v\nwant [-1 0], 0.5", dir, str)
+	}
+
+	// The camera is at the origin, so only
+	// instances near the corner are visible.
+	var l drawList
+	view := linear.I4()
+	l.buildFoliage(&rend.Renderer, &view, nil)
+	if len(l.foliage) != 1 || l.foliage[0].id != full {
+		t.Fatalf("drawList.buildFoliage: unexpected batches\nhave %d", len(l.foliage))
+	}
+	if n := len(l.foliage[0].inst); n == 0 || n >= 100 {
+		t.Fatalf("drawList.buildFoliage: unexpected instance count\nhave %d", n)
+	}
+	for _, inst := range l.foliage[0].inst {
+		p := inst.Position()
+		d := p.Len()
+		want := float32(1)
+		if d > 2 {
+			want = (4 - d) / 2
+		}
+		switch {
+		case d >= 4:
+			t.Fatalf("drawList.buildFoliage: instance should have been culled\nhave %v", p)
+		case math.Abs(float64(inst.Fade()-want)) > 1e-5:
+			t.Fatalf("drawList.buildFoliage: instance fade\nhave %v\nwant %v", inst.Fade(), want)
+		}
+	}
+}
@@ -0,0 +1,508 @@
+// Copyright 2024 Gustavo C. Viegas. All rights reserved.
+
+package engine
+
+import (
+	"math"
+
+	"gviegas/neo3/linear"
+	"gviegas/neo3/node"
+)
+
+const gizmoPrefix = "gizmo: "
+
+func newGizmoErr(reason string) error { return newErr(gizmoPrefix, reason, ErrInvalidParam) }
+
+// Gizmo modes.
+const (
+	// Move along an axis or, using the center
+	// handle, on the plane facing the camera.
+	GizmoTranslate = iota
+	// Rotate around an axis.
+	GizmoRotate
+	// Scale along an axis or, using the center
+	// handle, uniformly.
+	GizmoScale
+)
+
+// GizmoHandle identifies a part of a Gizmo that can
+// be dragged.
+type GizmoHandle int
+
+// Gizmo handles.
+const (
+	GizmoNone GizmoHandle = iota - 1
+	GizmoX
+	GizmoY
+	GizmoZ
+	GizmoCenter
+)
+
+// Gizmo is a widget for editing the transform of a
+// node interactively.
+// It is drawn at the origin of the node, oriented by
+// the node's world transform, and with constant size
+// on screen. Hit testing and dragging take rays in
+// world space, as produced by Renderer.ViewportRay.
+type Gizmo struct {
+	mode int
+	// Size of the gizmo on screen, in pixels.
+	size float32
+	// Origin and normalized axes of the node's
+	// world transform.
+	world  linear.M4
+	origin linear.V3
+	axes   [3]linear.V3
+	// Length of an axis handle in world units,
+	// computed by Fit.
+	scale float32
+	// Handle being dragged and the state as of
+	// the call to Begin.
+	active GizmoHandle
+	start  linear.M4
+	normal linear.V3
+	point  linear.V3
+	delta  linear.M4
+}
+
+// Gizmo geometry, relative to the length of an axis.
+const (
+	// Maximum distance from a handle for it to
+	// be hit.
+	gizmoPickDist = 0.08
+	// Radius of the center handle.
+	gizmoCenter = 0.15
+	// Number of segments of a rotation ring.
+	gizmoRingSegs = 32
+)
+
+// NewGizmo creates a new Gizmo.
+// mode must be GizmoTranslate, GizmoRotate or
+// GizmoScale. size is the length, in pixels, of the
+// gizmo's axes.
+func NewGizmo(mode int, size float32) (*Gizmo, error) {
+	switch {
+	case mode < GizmoTranslate || mode > GizmoScale:
+		return nil, newGizmoErr("undefined gizmo mode")
+	case !(size > 0):
+		return nil, newGizmoErr("invalid gizmo size")
+	}
+	g := &Gizmo{
+		mode:   mode,
+		size:   size,
+		scale:  1,
+		active: GizmoNone,
+		delta:  linear.I4(),
+	}
+	g.SetWorld(&g.delta)
+	return g, nil
+}
+
+// Mode returns the mode of g.
+func (g *Gizmo) Mode() int { return g.mode }
+
+// SetMode sets the mode of g.
+// It must not be called while dragging.
+func (g *Gizmo) SetMode(mode int) {
+	if mode < GizmoTranslate || mode > GizmoScale {
+		panic("engine.Gizmo.SetMode: undefined gizmo mode")
+	}
+	g.mode = mode
+}
+
+// SetWorld places g according to the world transform
+// of the node being edited.
+// It must not be called while dragging.
+func (g *Gizmo) SetWorld(world *linear.M4) {
+	g.world = *world
+	g.origin = linear.V3{world[3][0], world[3][1], world[3][2]}
+	for i := range g.axes {
+		ax := linear.V3{world[i][0], world[i][1], world[i][2]}
+		if l := ax.Len(); l > 1e-6 {
+			g.axes[i].Scale(1/l, &ax)
+		} else {
+			g.axes[i] = linear.V3{}
+			g.axes[i][i] = 1
+		}
+	}
+}
+
+// Attach places g at the world transform of n in gr.
+func (g *Gizmo) Attach(gr *node.Graph, n node.Node) { g.SetWorld(gr.World(n)) }
+
+// Fit computes the world size of g so that its axes
+// are g.size pixels long when viewed through the given
+// camera, which renders to a target height pixels
+// tall.
+// It must be called whenever the camera or g moves,
+// before hit testing and drawing.
+func (g *Gizmo) Fit(view, proj *linear.M4, height int) {
+	p := linear.V4{g.origin[0], g.origin[1], g.origin[2], 1}
+	p.Mul(view, &p)
+	// For perspective projections, the w
+	// coordinate is the view depth.
+	w := proj[0][3]*p[0] + proj[1][3]*p[1] + proj[2][3]*p[2] + proj[3][3]
+	w = max(w, 1e-4)
+	g.scale = 2 * g.size * w / (proj[1][1] * float32(height))
+	if g.scale < 0 {
+		g.scale = -g.scale
+	}
+}
+
+// Scale returns the length of g's axes in world units,
+// as computed by Fit.
+func (g *Gizmo) Scale() float32 { return g.scale }
+
+// Hit returns the handle of g that ray hits, or
+// GizmoNone if there is none.
+// When multiple handles are hit, the one closest to
+// the ray's origin is chosen.
+func (g *Gizmo) Hit(ray *Ray) GizmoHandle {
+	hit := GizmoNone
+	best := float32(math.Inf(1))
+	tol := gizmoPickDist * g.scale
+	if g.mode != GizmoRotate {
+		if d, t := rayPointDist(ray, &g.origin); d < gizmoCenter*g.scale && t < best {
+			hit, best = GizmoCenter, t
+		}
+	}
+	for i := range g.axes {
+		var d, t float32
+		if g.mode == GizmoRotate {
+			d, t = rayRingDist(ray, &g.origin, &g.axes[i], g.scale)
+		} else {
+			var end linear.V3
+			end.Scale(g.scale, &g.axes[i])
+			end.Add(&g.origin, &end)
+			d, t = raySegDist(ray, &g.origin, &end)
+		}
+		if d < tol && t < best {
+			hit, best = GizmoX+GizmoHandle(i), t
+		}
+	}
+	return hit
+}
+
+// Begin starts dragging the handle that ray hits.
+// It returns the handle, or GizmoNone if no handle was
+// hit, in which case nothing is dragged.
+func (g *Gizmo) Begin(ray *Ray) GizmoHandle {
+	h := g.Hit(ray)
+	if h == GizmoNone {
+		return h
+	}
+	g.active = h
+	g.start = g.world
+	g.delta = linear.I4()
+	g.normal = g.dragNormal(ray)
+	if p, ok := rayPlane(ray, &g.origin, &g.normal); ok {
+		g.point = p
+	} else {
+		g.point = g.origin
+	}
+	return h
+}
+
+// Active returns the handle being dragged, or
+// GizmoNone if g is not being dragged.
+func (g *Gizmo) Active() GizmoHandle { return g.active }
+
+// dragNormal returns the normal of the plane on which
+// the active handle is dragged.
+func (g *Gizmo) dragNormal(ray *Ray) linear.V3 {
+	var n linear.V3
+	switch {
+	case g.active == GizmoCenter:
+		// Facing the camera.
+		n.Scale(-1, &ray.Dir)
+	case g.mode == GizmoRotate:
+		n = g.axes[g.active]
+	default:
+		// The plane that contains the axis
+		// and is closest to facing the ray.
+		ax := &g.axes[g.active]
+		var t linear.V3
+		t.Cross(ax, &ray.Dir)
+		n.Cross(&t, ax)
+		if n.Len() < 1e-6 {
+			n.Scale(-1, &ray.Dir)
+		}
+		n.Norm(&n)
+	}
+	return n
+}
+
+// Drag continues dragging the active handle along
+// ray, and returns the transform that must be
+// applied, in world space, to the node's world
+// transform as of the call to Begin.
+// If g is not being dragged, it returns the identity.
+func (g *Gizmo) Drag(ray *Ray) linear.M4 {
+	if g.active == GizmoNone {
+		return linear.I4()
+	}
+	p, ok := rayPlane(ray, &g.origin, &g.normal)
+	if !ok {
+		return g.delta
+	}
+	var d linear.M4
+	switch g.mode {
+	case GizmoTranslate:
+		var v linear.V3
+		v.Sub(&p, &g.point)
+		if g.active != GizmoCenter {
+			ax := &g.axes[g.active]
+			v.Scale(v.Dot(ax), ax)
+		}
+		d.Translate(v[0], v[1], v[2])
+	case GizmoRotate:
+		var v0, v1, c linear.V3
+		v0.Sub(&g.point, &g.origin)
+		v1.Sub(&p, &g.origin)
+		c.Cross(&v0, &v1)
+		ax := &g.axes[g.active]
+		a := float32(math.Atan2(float64(c.Dot(ax)), float64(v0.Dot(&v1))))
+		d.Rotate(a, ax)
+		d = g.aboutOrigin(&d)
+	case GizmoScale:
+		var v0, v1 linear.V3
+		v0.Sub(&g.point, &g.origin)
+		v1.Sub(&p, &g.origin)
+		if g.active == GizmoCenter {
+			s := float32(1)
+			if l := v0.Len(); l > 1e-6 {
+				s = v1.Len() / l
+			}
+			d.Scale(s, s, s)
+		} else {
+			ax := &g.axes[g.active]
+			s := float32(1)
+			if l := v0.Dot(ax); math.Abs(float64(l)) > 1e-6 {
+				s = v1.Dot(ax) / l
+			}
+			var k [3]float32
+			for i := range k {
+				k[i] = 1
+			}
+			k[g.active] = s
+			// Scale in the gizmo's frame.
+			var r, ir, sc linear.M4
+			r = linear.I4()
+			for i := range g.axes {
+				copy(r[i][:3], g.axes[i][:])
+			}
+			ir.Transpose(&r)
+			sc.Scale(k[0], k[1], k[2])
+			d.Mul(&r, &sc)
+			d.Mul(&d, &ir)
+		}
+		d = g.aboutOrigin(&d)
+	}
+	g.delta = d
+	return d
+}
+
+// aboutOrigin returns m applied about g's origin.
+func (g *Gizmo) aboutOrigin(m *linear.M4) linear.M4 {
+	var t, it, d linear.M4
+	t.Translate(g.origin[0], g.origin[1], g.origin[2])
+	it.Translate(-g.origin[0], -g.origin[1], -g.origin[2])
+	d.Mul(&t, m)
+	d.Mul(&d, &it)
+	return d
+}
+
+// End stops dragging.
+// It returns the last transform computed by Drag.
+func (g *Gizmo) End() linear.M4 {
+	d := g.delta
+	g.active = GizmoNone
+	g.delta = linear.I4()
+	return d
+}
+
+// Transformable is a node.Interface whose local
+// transform can be set.
+// ImposterSwitch is an example of such node.
+type Transformable interface {
+	node.Interface
+	SetLocal(local *linear.M4)
+}
+
+// Apply applies the transform of the current drag to
+// n, which must be a Transformable in gr.
+// The node's world transform becomes the one it had
+// when Begin was called, transformed by the result
+// of the last call to Drag. The change takes effect
+// when gr is updated, after which g should be placed
+// again with Attach.
+func (g *Gizmo) Apply(gr *node.Graph, n node.Node) error {
+	x, ok := gr.Get(n).(Transformable)
+	if !ok {
+		return newGizmoErr("node is not Transformable")
+	}
+	if g.active == GizmoNone {
+		return nil
+	}
+	// parent = world * local⁻¹
+	var parent, world linear.M4
+	parent.Invert(x.Local())
+	parent.Mul(gr.World(n), &parent)
+	parent.Invert(&parent)
+	world.Mul(&g.delta, &g.start)
+	world.Mul(&parent, &world)
+	x.SetLocal(&world)
+	return nil
+}
+
+// GizmoLine is a line segment of a Gizmo, in world
+// space.
+type GizmoLine struct {
+	A, B   linear.V3
+	Handle GizmoHandle
+}
+
+// Lines appends to dst the line segments that make up
+// g, in world space.
+// Segments of the handle being dragged should be
+// highlighted. Gizmos are meant to be drawn after
+// everything else, without depth testing.
+func (g *Gizmo) Lines(dst []GizmoLine) []GizmoLine {
+	for i := range g.axes {
+		h := GizmoX + GizmoHandle(i)
+		if g.mode == GizmoRotate {
+			u, v := g.axes[(i+1)%3], g.axes[(i+2)%3]
+			prev := g.ringPoint(&u, &v, 0)
+			for s := 1; s <= gizmoRingSegs; s++ {
+				p := g.ringPoint(&u, &v, float64(s)/gizmoRingSegs)
+				dst = append(dst, GizmoLine{prev, p, h})
+				prev = p
+			}
+			continue
+		}
+		var end linear.V3
+		end.Scale(g.scale, &g.axes[i])
+		end.Add(&g.origin, &end)
+		dst = append(dst, GizmoLine{g.origin, end, h})
+	}
+	if g.mode != GizmoRotate {
+		c := gizmoCenter * g.scale
+		for i := range g.axes {
+			var a, b linear.V3
+			a.Scale(-c, &g.axes[i])
+			b.Scale(c, &g.axes[i])
+			a.Add(&g.origin, &a)
+			b.Add(&g.origin, &b)
+			dst = append(dst, GizmoLine{a, b, GizmoCenter})
+		}
+	}
+	return dst
+}
+
+// ringPoint returns the point of a rotation ring at
+// fraction t of the turn, where u and v span the
+// ring's plane.
+func (g *Gizmo) ringPoint(u, v *linear.V3, t float64) linear.V3 {
+	s, c := math.Sincos(2 * math.Pi * t)
+	var a, b linear.V3
+	a.Scale(g.scale*float32(c), u)
+	b.Scale(g.scale*float32(s), v)
+	a.Add(&a, &b)
+	a.Add(&g.origin, &a)
+	return a
+}
+
+// rayPointDist returns the distance from p to ray and
+// the ray parameter of the closest point.
+func rayPointDist(ray *Ray, p *linear.V3) (dist, t float32) {
+	var v linear.V3
+	v.Sub(p, &ray.Origin)
+	t = max(v.Dot(&ray.Dir), 0)
+	var q linear.V3
+	q.Scale(t, &ray.Dir)
+	q.Add(&ray.Origin, &q)
+	q.Sub(p, &q)
+	return q.Len(), t
+}
+
+// raySegDist returns the distance between ray and the
+// segment [a, b], and the ray parameter of the
+// closest point.
+func raySegDist(ray *Ray, a, b *linear.V3) (dist, t float32) {
+	var u, w linear.V3
+	u.Sub(b, a)
+	w.Sub(&ray.Origin, a)
+	d := &ray.Dir
+	uu, ud, uw, dw := u.Dot(&u), u.Dot(d), u.Dot(&w), d.Dot(&w)
+	den := uu - ud*ud
+	var s float32
+	if den > 1e-8 {
+		s = (uw - ud*dw) / den
+	}
+	s = min(max(s, 0), 1)
+	var p linear.V3
+	p.Scale(s, &u)
+	p.Add(a, &p)
+	return rayPointDist(ray, &p)
+}
+
+// rayRingDist returns the distance between ray and the
+// circle of the given center, normal and radius,
+// measured on the circle's plane, and the ray
+// parameter of the intersection.
+func rayRingDist(ray *Ray, center, normal *linear.V3, radius float32) (dist, t float32) {
+	p, ok := rayPlane(ray, center, normal)
+	if !ok {
+		return float32(math.Inf(1)), 0
+	}
+	var v linear.V3
+	v.Sub(&p, &ray.Origin)
+	t = v.Len()
+	v.Sub(&p, center)
+	return float32(math.Abs(float64(v.Len() - radius))), t
+}
+
+// rayPlane returns the intersection of ray with the
+// plane through p with the given normal.
+// It returns false if ray is parallel to the plane or
+// points away from it.
+func rayPlane(ray *Ray, p, normal *linear.V3) (linear.V3, bool) {
+	den := ray.Dir.Dot(normal)
+	if math.Abs(float64(den)) < 1e-6 {
+		return linear.V3{}, false
+	}
+	var v linear.V3
+	v.Sub(p, &ray.Origin)
+	t := v.Dot(normal) / den
+	if t < 0 {
+		return linear.V3{}, false
+	}
+	v.Scale(t, &ray.Dir)
+	v.Add(&ray.Origin, &v)
+	return v, true
+}
+
+// gizmoPass is the name of the gizmo pass.
+const gizmoPass = "gizmo"
+
+// SetGizmo sets the gizmo that r draws over the final
+// image, in every viewport.
+// The gizmo's lines are drawn without depth testing,
+// highlighting the active handle.
+// If g is nil, no gizmo is drawn.
+func (r *Renderer) SetGizmo(g *Gizmo) {
+	switch {
+	case g == nil && r.gizmo != nil:
+		r.graph.remove(gizmoPass)
+	case g != nil && r.gizmo == nil:
+		r.graph.add(&passNode{
+			name:  gizmoPass,
+			stage: stageFinal,
+		})
+	}
+	r.gizmo = g
+}
+
+// Gizmo returns the gizmo that r draws, or nil if
+// there is none.
+func (r *Renderer) Gizmo() *Gizmo { return r.gizmo }
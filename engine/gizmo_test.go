@@ -0,0 +1,205 @@
+// Copyright 2024 Gustavo C. Viegas. All rights reserved.
+
+package engine
+
+import (
+	"math"
+	"testing"
+
+	"gviegas/neo3/linear"
+	"gviegas/neo3/node"
+)
+
+// gizmoCam returns a camera at (0, 0, -10) looking
+// down the +Z axis.
+func gizmoCam() (view, proj linear.M4) {
+	view.Translate(0, 0, 10)
+	proj.Perspective(math.Pi/2, 1, 0.1, 100)
+	return
+}
+
+func closeV3(a, b linear.V3, eps float32) bool {
+	for i := range a {
+		if math.Abs(float64(a[i]-b[i])) > float64(eps) {
+			return false
+		}
+	}
+	return true
+}
+
+func TestGizmoFit(t *testing.T) {
+	if _, err := NewGizmo(GizmoScale+1, 100); err == nil {
+		t.Fatal("NewGizmo: unexpected nil error")
+	}
+	if _, err := NewGizmo(GizmoTranslate, 0); err == nil {
+		t.Fatal("NewGizmo: unexpected nil error")
+	}
+	g, err := NewGizmo(GizmoTranslate, 100)
+	if err != nil {
+		t.Fatalf("NewGizmo failed:\n%v", err)
+	}
+	view, proj := gizmoCam()
+	// At distance 10 with a 90° FOV, the view
+	// is 20 units tall.
+	g.Fit(&view, &proj, 200)
+	if x := g.Scale(); math.Abs(float64(x)-10) > 1e-4 {
+		t.Fatalf("Gizmo.Fit: Scale\nhave %v\nwant 10", x)
+	}
+	// Twice as far, twice as large.
+	var world linear.M4
+	world.Translate(0, 0, 10)
+	g.SetWorld(&world)
+	g.Fit(&view, &proj, 200)
+	if x := g.Scale(); math.Abs(float64(x)-20) > 1e-4 {
+		t.Fatalf("Gizmo.Fit: Scale\nhave %v\nwant 20", x)
+	}
+}
+
+func TestGizmoHit(t *testing.T) {
+	g, _ := NewGizmo(GizmoTranslate, 100)
+	view, proj := gizmoCam()
+	g.Fit(&view, &proj, 200)
+	fwd := linear.V3{0, 0, 1}
+	for _, x := range [...]struct {
+		ray  Ray
+		want GizmoHandle
+	}{
+		{Ray{linear.V3{0, 0, -10}, fwd}, GizmoCenter},
+		{Ray{linear.V3{5, 0, -10}, fwd}, GizmoX},
+		{Ray{linear.V3{0, 7, -10}, fwd}, GizmoY},
+		{Ray{linear.V3{11, 0, -10}, fwd}, GizmoNone},
+		{Ray{linear.V3{5, 5, -10}, fwd}, GizmoNone},
+		{Ray{linear.V3{-10, 0, 5}, linear.V3{1, 0, 0}}, GizmoZ},
+	} {
+		if h := g.Hit(&x.ray); h != x.want {
+			t.Fatalf("Gizmo.Hit(%v):\nhave %d\nwant %d", x.ray, h, x.want)
+		}
+	}
+	g.SetMode(GizmoRotate)
+	if h := g.Hit(&Ray{linear.V3{-10, 0, 10}, linear.V3{1, 0, 0}}); h != GizmoX {
+		t.Fatalf("Gizmo.Hit: rotation ring\nhave %d\nwant %d", h, GizmoX)
+	}
+	if h := g.Hit(&Ray{linear.V3{0, 0, -10}, fwd}); h != GizmoNone {
+		t.Fatalf("Gizmo.Hit: rotation center\nhave %d\nwant %d", h, GizmoNone)
+	}
+	if n := len(g.Lines(nil)); n != 3*gizmoRingSegs {
+		t.Fatalf("Gizmo.Lines: len\nhave %d\nwant %d", n, 3*gizmoRingSegs)
+	}
+}
+
+func TestGizmoDrag(t *testing.T) {
+	g, _ := NewGizmo(GizmoTranslate, 100)
+	view, proj := gizmoCam()
+	g.Fit(&view, &proj, 200)
+	fwd := linear.V3{0, 0, 1}
+
+	if x := g.Drag(&Ray{linear.V3{}, fwd}); x != linear.I4() {
+		t.Fatalf("Gizmo.Drag: not dragging\nhave %v\nwant %v", x, linear.I4())
+	}
+	if h := g.Begin(&Ray{linear.V3{5, 0, -10}, fwd}); h != GizmoX || g.Active() != GizmoX {
+		t.Fatalf("Gizmo.Begin:\nhave %d\nwant %d", h, GizmoX)
+	}
+	// Off-axis motion is discarded.
+	d := g.Drag(&Ray{linear.V3{8, 3, -10}, fwd})
+	if v := (linear.V3{d[3][0], d[3][1], d[3][2]}); !closeV3(v, linear.V3{3, 0, 0}, 1e-4) {
+		t.Fatalf("Gizmo.Drag: translation\nhave %v\nwant [3 0 0]", v)
+	}
+	if x := g.End(); x != d || g.Active() != GizmoNone {
+		t.Fatal("Gizmo.End: should return the last drag and stop dragging")
+	}
+
+	// Quarter turn around Z.
+	g.SetMode(GizmoRotate)
+	if h := g.Begin(&Ray{linear.V3{10, 0, -10}, fwd}); h != GizmoZ {
+		t.Fatalf("Gizmo.Begin:\nhave %d\nwant %d", h, GizmoZ)
+	}
+	d = g.Drag(&Ray{linear.V3{0, 10, -10}, fwd})
+	x := linear.V4{1, 0, 0, 1}
+	x.Mul(&d, &x)
+	if !closeV3(linear.V3{x[0], x[1], x[2]}, linear.V3{0, 1, 0}, 1e-4) {
+		t.Fatalf("Gizmo.Drag: rotation\nhave %v\nwant [0 1 0]", x)
+	}
+	g.End()
+
+	// Scale twice along Y, about the origin.
+	var world linear.M4
+	world.Translate(1, 1, 0)
+	g.SetWorld(&world)
+	g.SetMode(GizmoScale)
+	if h := g.Begin(&Ray{linear.V3{1, 6, -10}, fwd}); h != GizmoY {
+		t.Fatalf("Gizmo.Begin:\nhave %d\nwant %d", h, GizmoY)
+	}
+	d = g.Drag(&Ray{linear.V3{1, 11, -10}, fwd})
+	x = linear.V4{2, 2, 0, 1}
+	x.Mul(&d, &x)
+	if !closeV3(linear.V3{x[0], x[1], x[2]}, linear.V3{2, 3, 0}, 1e-4) {
+		t.Fatalf("Gizmo.Drag: scale\nhave %v\nwant [2 3 0]", x)
+	}
+}
+
+func TestGizmoApply(t *testing.T) {
+	var gr node.Graph
+	var pl, cl linear.M4
+	pl.Translate(10, 0, 0)
+	cl.Translate(0, 2, 0)
+	parent := gr.Insert(NewImposterSwitch(&pl), node.Nil)
+	sw := NewImposterSwitch(&cl)
+	child := gr.Insert(sw, parent)
+	gr.Update()
+
+	g, _ := NewGizmo(GizmoTranslate, 100)
+	if err := g.Apply(&gr, parent); err != nil {
+		t.Fatalf("Gizmo.Apply: not dragging\n%v", err)
+	}
+	g.Attach(&gr, child)
+	view, proj := gizmoCam()
+	g.Fit(&view, &proj, 200)
+	fwd := linear.V3{0, 0, 1}
+	if h := g.Begin(&Ray{linear.V3{10, 2 + 0.5*g.Scale(), -10}, fwd}); h != GizmoY {
+		t.Fatalf("Gizmo.Begin:\nhave %d\nwant %d", h, GizmoY)
+	}
+	g.Drag(&Ray{linear.V3{10, 4 + 0.5*g.Scale(), -10}, fwd})
+	if err := g.Apply(&gr, child); err != nil {
+		t.Fatalf("Gizmo.Apply failed:\n%v", err)
+	}
+	gr.Update()
+	w := gr.World(child)
+	if v := (linear.V3{w[3][0], w[3][1], w[3][2]}); !closeV3(v, linear.V3{10, 4, 0}, 1e-4) {
+		t.Fatalf("Gizmo.Apply: world position\nhave %v\nwant [10 4 0]", v)
+	}
+	if l := sw.Local(); !closeV3(linear.V3{l[3][0], l[3][1], l[3][2]}, linear.V3{0, 4, 0}, 1e-4) {
+		t.Fatalf("Gizmo.Apply: local position\nhave %v\nwant [0 4 0]", l[3])
+	}
+}
+
+func TestViewportRay(t *testing.T) {
+	rend, err := NewOffscreen(256, 192)
+	if err != nil {
+		t.Fatalf("ViewportRay: NewOffscreen failed:\n%v", err)
+	}
+	defer rend.Free()
+	view, proj := gizmoCam()
+	v, err := rend.AddViewport(&ViewportParam{View: view, Proj: proj, Rect: ViewportRect{0.5, 0, 0.5, 1}})
+	if err != nil {
+		t.Fatalf("Renderer.AddViewport failed:\n%v", err)
+	}
+	ray := rend.ViewportRay(v, 0.75, 0.5)
+	if !closeV3(ray.Dir, linear.V3{0, 0, 1}, 1e-4) || !closeV3(ray.Origin, linear.V3{0, 0, -9.9}, 1e-3) {
+		t.Fatalf("Renderer.ViewportRay:\nhave %v\nwant {[0 0 -9.9] [0 0 1]}", ray)
+	}
+	// The right edge of the viewport is 45° off.
+	ray = rend.ViewportRay(v, 1, 0.5)
+	if math.Abs(float64(ray.Dir[0]-ray.Dir[2])) > 1e-4 || ray.Dir[0] <= 0 {
+		t.Fatalf("Renderer.ViewportRay: edge direction\nhave %v", ray.Dir)
+	}
+
+	g, _ := NewGizmo(GizmoRotate, 64)
+	rend.SetGizmo(g)
+	if rend.Gizmo() != g || rend.graph.find(gizmoPass) < 0 {
+		t.Fatal("Renderer.SetGizmo: gizmo should be set")
+	}
+	rend.SetGizmo(nil)
+	if rend.Gizmo() != nil || rend.graph.find(gizmoPass) >= 0 {
+		t.Fatal("Renderer.SetGizmo(nil): gizmo should be unset")
+	}
+}
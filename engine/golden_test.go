@@ -0,0 +1,283 @@
+// Copyright 2024 Gustavo C. Viegas. All rights reserved.
+
+package engine
+
+import (
+	"errors"
+	"flag"
+	"image"
+	"image/color"
+	"image/png"
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"gviegas/neo3/driver"
+)
+
+var (
+	goldenUpdate = flag.Bool("golden.update", false, "overwrite reference images with the rendered ones")
+	goldenOut    = flag.String("golden.out", filepath.Join(os.TempDir(), "neo3-golden"), "directory for images of failed golden tests")
+)
+
+// goldenDir contains the reference images.
+const goldenDir = "testdata/golden"
+
+// goldenTol is the tolerance of a golden test.
+// A rendered image passes if at most Pixels pixels
+// differ by more than Channel in any channel, and
+// its SSIM against the reference is at least SSIM.
+type goldenTol struct {
+	Channel uint8
+	Pixels  int
+	SSIM    float64
+}
+
+// defGoldenTol allows for rounding differences
+// between implementations.
+var defGoldenTol = goldenTol{Channel: 2, SSIM: 0.99}
+
+// goldenScene is a scene rendered by the golden tests.
+// setup configures a new Offscreen renderer.
+type goldenScene struct {
+	name   string
+	width  int
+	height int
+	tol    goldenTol
+	setup  func(r *Offscreen) error
+}
+
+// goldenScenes are the scenes to test, registered by
+// registerGolden.
+var goldenScenes []goldenScene
+
+// registerGolden registers a scene for golden tests.
+// Its reference image is testdata/golden/<name>.png.
+func registerGolden(s goldenScene) { goldenScenes = append(goldenScenes, s) }
+
+// renderGolden renders a frame of r and returns the
+// contents of its target.
+func renderGolden(r *Offscreen) (*image.NRGBA, error) {
+	if err := r.Render(); err != nil {
+		return nil, err
+	}
+	return r.Target().ToImage()
+}
+
+// clearPass returns a pass that clears tex to c.
+func clearPass(name string, stage int, tex *Texture, c [4]float32) *PassParam {
+	return &PassParam{
+		Name:   name,
+		Stage:  stage,
+		Writes: []*Texture{tex},
+		Record: func(ctx *PassContext) {
+			ctx.Cmd.BeginPass(tex.Width(), tex.Height(), 1, []driver.ColorTarget{{
+				Color: tex.views[0],
+				Load:  driver.LClear,
+				Store: driver.SStore,
+				Clear: driver.ClearFloat32(c[0], c[1], c[2], c[3]),
+			}}, nil)
+			ctx.Cmd.EndPass()
+		},
+	}
+}
+
+func init() {
+	registerGolden(goldenScene{
+		name:   "clear",
+		width:  64,
+		height: 48,
+		tol:    defGoldenTol,
+		setup: func(r *Offscreen) error {
+			return r.AddPass(clearPass("clear", StageFinal, r.Target(), [4]float32{0.25, 0.5, 0.75, 1}))
+		},
+	})
+}
+
+func TestGolden(t *testing.T) {
+	for _, s := range goldenScenes {
+		t.Run(s.name, func(t *testing.T) { testGolden(t, &s) })
+	}
+}
+
+func testGolden(t *testing.T, s *goldenScene) {
+	rend, err := NewOffscreen(s.width, s.height)
+	if err != nil {
+		t.Fatalf("NewOffscreen failed:\n%#v", err)
+	}
+	defer rend.Free()
+	if err := s.setup(rend); err != nil {
+		t.Fatalf("goldenScene.setup failed:\n%v", err)
+	}
+	img, err := renderGolden(rend)
+	if err != nil {
+		t.Fatalf("renderGolden failed:\n%v", err)
+	}
+	ref := filepath.Join(goldenDir, s.name+".png")
+	if *goldenUpdate {
+		if err := writePNG(ref, img); err != nil {
+			t.Fatalf("writePNG failed:\n%v", err)
+		}
+		return
+	}
+	golden, err := readPNG(ref)
+	if errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("missing reference image %s (run with -golden.update to create it)", ref)
+	} else if err != nil {
+		t.Fatalf("readPNG failed:\n%v", err)
+	}
+	n, err := CompareImages(img, golden, s.tol.Channel)
+	if err != nil {
+		t.Fatalf("CompareImages failed:\n%v", err)
+	}
+	ssim, _ := imageSSIM(img, golden)
+	if n <= s.tol.Pixels && ssim >= s.tol.SSIM {
+		return
+	}
+	out := filepath.Join(*goldenOut, s.name+".png")
+	diff := filepath.Join(*goldenOut, s.name+".diff.png")
+	if err := os.MkdirAll(*goldenOut, 0o755); err == nil {
+		writePNG(out, img)
+		writePNG(diff, diffImage(img, golden, s.tol.Channel))
+	}
+	t.Fatalf("golden %s: differing pixels, SSIM\nhave %d, %.4f\nwant <= %d, >= %.4f\n(see %s)",
+		s.name, n, ssim, s.tol.Pixels, s.tol.SSIM, diff)
+}
+
+func readPNG(name string) (image.Image, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return png.Decode(f)
+}
+
+func writePNG(name string, img image.Image) error {
+	f, err := os.Create(name)
+	if err != nil {
+		return err
+	}
+	if err = png.Encode(f, img); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// imageSSIM computes the mean structural similarity
+// of the luma of two images of the same size, over
+// 8x8 windows.
+func imageSSIM(a, b image.Image) (float64, error) {
+	if a.Bounds().Size() != b.Bounds().Size() {
+		return 0, errors.New("image size mismatch")
+	}
+	la, lb := imageLuma(a), imageLuma(b)
+	w, h := a.Bounds().Dx(), a.Bounds().Dy()
+	const (
+		win = 8
+		c1  = (0.01 * 255) * (0.01 * 255)
+		c2  = (0.03 * 255) * (0.03 * 255)
+	)
+	var sum float64
+	var cnt int
+	for y0 := 0; y0 < h; y0 += win {
+		for x0 := 0; x0 < w; x0 += win {
+			var ma, mb, va, vb, cov, n float64
+			for y := y0; y < min(y0+win, h); y++ {
+				for x := x0; x < min(x0+win, w); x++ {
+					ma += la[y*w+x]
+					mb += lb[y*w+x]
+					n++
+				}
+			}
+			ma /= n
+			mb /= n
+			for y := y0; y < min(y0+win, h); y++ {
+				for x := x0; x < min(x0+win, w); x++ {
+					da, db := la[y*w+x]-ma, lb[y*w+x]-mb
+					va += da * da
+					vb += db * db
+					cov += da * db
+				}
+			}
+			va /= n
+			vb /= n
+			cov /= n
+			sum += (2*ma*mb + c1) * (2*cov + c2) / ((ma*ma + mb*mb + c1) * (va + vb + c2))
+			cnt++
+		}
+	}
+	if cnt == 0 {
+		return 1, nil
+	}
+	return sum / float64(cnt), nil
+}
+
+// imageLuma returns the luma of every pixel of img,
+// in [0, 255].
+func imageLuma(img image.Image) []float64 {
+	pix := imagePix(img)
+	l := make([]float64, len(pix)/4)
+	for i := range l {
+		r, g, b := float64(pix[4*i]), float64(pix[4*i+1]), float64(pix[4*i+2])
+		l[i] = 0.2126*r + 0.7152*g + 0.0722*b
+	}
+	return l
+}
+
+// diffImage returns an image that highlights the
+// differences between a and b, which must have the
+// same size.
+// Pixels that differ by more than tol are red, with
+// intensity proportional to the difference. The
+// others are a dimmed version of b.
+func diffImage(a, b image.Image, tol uint8) *image.NRGBA {
+	pa, pb := imagePix(a), imagePix(b)
+	dst := image.NewNRGBA(image.Rect(0, 0, a.Bounds().Dx(), a.Bounds().Dy()))
+	for i := 0; i < len(pa); i += 4 {
+		var d uint8
+		for j := range 4 {
+			d = max(d, max(pa[i+j], pb[i+j])-min(pa[i+j], pb[i+j]))
+		}
+		var c color.NRGBA
+		if d > tol {
+			c = color.NRGBA{R: uint8(math.Max(128, float64(d))), A: 255}
+		} else {
+			l := (uint16(pb[i]) + uint16(pb[i+1]) + uint16(pb[i+2])) / 12
+			c = color.NRGBA{uint8(l), uint8(l), uint8(l), 255}
+		}
+		dst.Pix[i], dst.Pix[i+1], dst.Pix[i+2], dst.Pix[i+3] = c.R, c.G, c.B, c.A
+	}
+	return dst
+}
+
+func TestImageSSIM(t *testing.T) {
+	a := image.NewNRGBA(image.Rect(0, 0, 20, 12))
+	for i := range a.Pix {
+		a.Pix[i] = byte(i * 13)
+	}
+	if x, err := imageSSIM(a, a); err != nil || math.Abs(x-1) > 1e-9 {
+		t.Fatalf("imageSSIM: identical images\nhave %v, %v\nwant 1, nil", x, err)
+	}
+	b := image.NewNRGBA(a.Rect)
+	copy(b.Pix, a.Pix)
+	for i := 0; i < len(b.Pix); i += 4 {
+		b.Pix[i] = 255 - b.Pix[i]
+	}
+	if x, _ := imageSSIM(a, b); x >= defGoldenTol.SSIM {
+		t.Fatalf("imageSSIM: distinct images\nhave %v\nwant < %v", x, defGoldenTol.SSIM)
+	}
+	if _, err := imageSSIM(a, image.NewNRGBA(image.Rect(0, 0, 20, 11))); err == nil {
+		t.Fatal("imageSSIM: size mismatch should fail")
+	}
+
+	d := diffImage(a, b, 0)
+	for i := 0; i < len(d.Pix); i += 4 {
+		same := a.Pix[i] == b.Pix[i]
+		if red := d.Pix[i] >= 128 && d.Pix[i+1] == 0; red == same {
+			t.Fatalf("diffImage: pixel %d\nhave %v\nwant red: %t", i/4, d.Pix[i:i+4], !same)
+		}
+	}
+}
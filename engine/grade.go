@@ -0,0 +1,154 @@
+// Copyright 2024 Gustavo C. Viegas. All rights reserved.
+
+package engine
+
+import (
+	"math"
+
+	"gviegas/neo3/engine/internal/shader"
+	"gviegas/neo3/linear"
+)
+
+// GradeParam describes color grading.
+// Exposure is an adjustment in EV (i.e., each unit
+// doubles the brightness).
+// Temperature and Tint adjust the white balance, in
+// the [-1, 1] interval. Positive temperatures make
+// the image warmer and positive tints make it more
+// magenta. Zero means no adjustment.
+// Contrast and Saturation are scale factors. One
+// means no adjustment.
+// LUT, if not nil, is applied after tonemapping,
+// when colors are in the [0, 1] interval.
+type GradeParam struct {
+	Exposure    float32
+	Temperature float32
+	Tint        float32
+	Contrast    float32
+	Saturation  float32
+	LUT         *LUT
+}
+
+// grade is the state of the color grading pass.
+type grade struct {
+	param  GradeParam
+	layout shader.GradeLayout
+}
+
+// Name of the color grading pass.
+const gradePass = "grade"
+
+// SetGrading enables color grading in r.
+// If param is nil, color grading is disabled.
+// Grading is the last pass that r executes, so any
+// overlay (e.g., UI) drawn afterwards is unaffected.
+// param.LUT must not be freed while in use by r.
+func (r *Renderer) SetGrading(param *GradeParam) error {
+	if param == nil {
+		r.graph.remove(gradePass)
+		r.grade = nil
+		return nil
+	}
+	var reason string
+	switch {
+	case param.Temperature < -1 || param.Temperature > 1:
+		reason = "grading temperature out of range"
+	case param.Tint < -1 || param.Tint > 1:
+		reason = "grading tint out of range"
+	case param.Contrast < 0:
+		reason = "negative grading contrast"
+	case param.Saturation < 0:
+		reason = "negative grading saturation"
+	case param.LUT != nil && param.LUT.tex == nil:
+		reason = "invalid grading LUT"
+	default:
+		goto validParam
+	}
+	return newRendErr(reason)
+validParam:
+	if r.grade == nil {
+		r.grade = new(grade)
+	} else {
+		r.graph.remove(gradePass)
+	}
+	g := r.grade
+	g.param = *param
+	wb := whiteBalance(param.Temperature, param.Tint)
+	g.layout.SetWhiteBalance(&wb)
+	g.layout.SetExposure(float32(math.Exp2(float64(param.Exposure))))
+	g.layout.SetContrast(param.Contrast)
+	g.layout.SetSaturation(param.Saturation)
+	reads := []*Texture{r.finalColor()}
+	if lut := param.LUT; lut != nil {
+		g.layout.SetLUT(lut.Size(), &lut.min, &lut.max)
+		reads = append(reads, lut.tex)
+	} else {
+		g.layout.SetLUT(0, &linear.V3{}, &linear.V3{1, 1, 1})
+	}
+	r.graph.add(&passNode{
+		name:  gradePass,
+		stage: stageFinal,
+		reads: reads,
+	})
+	return nil
+}
+
+// Grading returns the color grading parameters of r.
+// If color grading is disabled, it returns false.
+func (r *Renderer) Grading() (GradeParam, bool) {
+	if r.grade == nil {
+		return GradeParam{}, false
+	}
+	return r.grade.param, true
+}
+
+// whiteBalance computes a linear RGB transform that
+// adjusts the white balance.
+// It performs a von Kries adaptation in LMS space,
+// from D65 to a white point that is shifted along the
+// daylight locus by temp, and then across it by tint.
+func whiteBalance(temp, tint float32) linear.M3 {
+	var m linear.M3
+	if temp == 0 && tint == 0 {
+		m.I()
+		return m
+	}
+	t1 := temp * 100 / 65
+	t2 := tint * 100 / 65
+	x := 0.31271 - t1*0.05
+	if t1 < 0 {
+		x = 0.31271 - t1*0.1
+	}
+	y := 2.87*x - 3*x*x - 0.27509507 + t2*0.05
+	// CAT02 LMS of D65 and of the target white.
+	w1 := linear.V3{0.949237, 1.03542, 1.08728}
+	w2 := xyToLMS(x, y)
+	// Column-major.
+	rgbToLMS := linear.M3{
+		{3.90405e-1, 7.08416e-2, 2.31082e-2},
+		{5.49941e-1, 9.63172e-1, 1.28021e-1},
+		{8.92632e-3, 1.35775e-3, 9.36245e-1},
+	}
+	lmsToRGB := linear.M3{
+		{2.85847e+0, -2.10182e-1, -4.18120e-2},
+		{-1.62879e+0, 1.15820e+0, -1.18169e-1},
+		{-2.48910e-2, 3.24281e-4, 1.06867e+0},
+	}
+	var s linear.M3
+	s.Scale(w1[0]/w2[0], w1[1]/w2[1], w1[2]/w2[2])
+	m.Mul(&s, &rgbToLMS)
+	m.Mul(&lmsToRGB, &m)
+	return m
+}
+
+// xyToLMS converts CIE xy chromaticity coordinates
+// (with Y = 1) to CAT02 LMS.
+func xyToLMS(x, y float32) linear.V3 {
+	X := x / y
+	Z := (1 - x - y) / y
+	return linear.V3{
+		0.7328*X + 0.4296 - 0.1624*Z,
+		-0.7036*X + 1.6975 + 0.0061*Z,
+		0.0030*X + 0.0136 + 0.9834*Z,
+	}
+}
@@ -0,0 +1,255 @@
+// Copyright 2024 Gustavo C. Viegas. All rights reserved.
+
+package engine
+
+import (
+	"fmt"
+	"io"
+	"strings"
+
+	"gviegas/neo3/driver"
+)
+
+// PassBarriers describes the synchronization that the
+// frame graph records before a pass.
+// Transitions is the number of layout transitions,
+// and Barriers the number of transitions that keep
+// the layout (i.e., that are only memory barriers).
+// Issues describes the problems found by tracking the
+// layouts of the textures that the pass uses:
+// redundant transitions, which neither change the
+// layout nor order writes, and missing ones, which
+// leave a texture in a layout other than the one
+// that the pass requires.
+type PassBarriers struct {
+	Pass        string
+	Transitions int
+	Barriers    int
+	Issues      []string
+}
+
+// SetGraphDebug sets the frame graph debug mode of r.
+// If w is not nil, every frame that follows a change
+// to the frame graph writes the PassBarriers of each
+// pass to w, one line per pass, and every other frame
+// writes the issues found, if any. If w is nil, debug
+// mode is disabled.
+func (r *Renderer) SetGraphDebug(w io.Writer) {
+	r.graph.debug = w
+	r.graph.logged = false
+}
+
+// BarrierStats returns the PassBarriers of every pass
+// of r, in frame graph order.
+// Layouts are tracked from the current layouts of the
+// textures, so the issues are those that would be
+// found if a frame were recorded now.
+// The frame graph is compiled if needed.
+func (r *Renderer) BarrierStats() ([]PassBarriers, error) {
+	if len(r.graph.trans) != len(r.graph.nodes) {
+		if err := r.graph.compile(); err != nil {
+			return nil, err
+		}
+	}
+	return r.graph.barrierStats(r.texNames()), nil
+}
+
+// WriteGraphviz writes the dependency graph of r's
+// frame to w, in the DOT language of Graphviz.
+// Passes are drawn as boxes, grouped by stage and
+// labeled with their barrier counts, and textures
+// as ellipses. Async passes are dashed. Edges go
+// from textures to the passes that read them and
+// from passes to the textures that they write to.
+// The frame graph is compiled if needed.
+func (r *Renderer) WriteGraphviz(w io.Writer) error {
+	stats, err := r.BarrierStats()
+	if err != nil {
+		return err
+	}
+	g := &r.graph
+	names := r.texNames()
+	var b strings.Builder
+	b.WriteString("digraph frame {\n\trankdir=LR;\n\tnode [shape=box];\n")
+	for i, n := range g.nodes {
+		if i == 0 || g.nodes[i-1].stage != n.stage {
+			fmt.Fprintf(&b, "\tsubgraph cluster_%d {\n\t\tlabel=%q;\n", n.stage, stageNames[n.stage])
+		}
+		style := ""
+		if g.async[i] {
+			style = ", style=dashed"
+		}
+		s := &stats[i]
+		label := fmt.Sprintf("%s\n%d transitions, %d barriers", n.name, s.Transitions, s.Barriers)
+		if len(s.Issues) > 0 {
+			label += fmt.Sprintf("\n%d issues", len(s.Issues))
+			style += ", color=red"
+		}
+		fmt.Fprintf(&b, "\t\tp%d [label=%q%s];\n", i, label, style)
+		if i == len(g.nodes)-1 || g.nodes[i+1].stage != n.stage {
+			b.WriteString("\t}\n")
+		}
+	}
+	seen := make(map[*Texture]bool)
+	for _, n := range g.nodes {
+		for _, t := range append(n.reads[:len(n.reads):len(n.reads)], n.writes...) {
+			if !seen[t] {
+				seen[t] = true
+				fmt.Fprintf(&b, "\t%q [shape=ellipse];\n", names[t])
+			}
+		}
+	}
+	for i, n := range g.nodes {
+		for _, t := range n.reads {
+			fmt.Fprintf(&b, "\t%q -> p%d;\n", names[t], i)
+		}
+		for _, t := range n.writes {
+			fmt.Fprintf(&b, "\tp%d -> %q;\n", i, names[t])
+		}
+	}
+	b.WriteString("}\n")
+	_, err = io.WriteString(w, b.String())
+	return err
+}
+
+// stageNames are the names of the frame graph stages.
+var stageNames = [...]string{
+	stageGeometry:     "geometry",
+	stageLighting:     "lighting",
+	stageTransparency: "transparency",
+	stagePost:         "post",
+	stageFinal:        "final",
+}
+
+// layoutNames are the names of the driver.Layout
+// constants.
+var layoutNames = [...]string{
+	driver.LUndefined:   "LUndefined",
+	driver.LShaderStore: "LShaderStore",
+	driver.LShaderRead:  "LShaderRead",
+	driver.LColorTarget: "LColorTarget",
+	driver.LDSTarget:    "LDSTarget",
+	driver.LDSRead:      "LDSRead",
+	driver.LCopySrc:     "LCopySrc",
+	driver.LCopyDst:     "LCopyDst",
+	driver.LPresent:     "LPresent",
+}
+
+// layoutName returns the name of layout.
+func layoutName(layout driver.Layout) string {
+	if layout >= 0 && int(layout) < len(layoutNames) {
+		return layoutNames[layout]
+	}
+	return fmt.Sprintf("Layout(%d)", layout)
+}
+
+// texNames returns names that identify the textures
+// used by r's passes.
+// r's own targets are named after their purpose, and
+// any other texture is named after the order of its
+// first use and its size.
+func (r *Renderer) texNames() map[*Texture]string {
+	names := map[*Texture]string{r.hdr: "hdr", r.ds: "ds"}
+	if m := r.msaa; m != nil {
+		names[m.color] = "msaa.color"
+		names[m.ds] = "msaa.ds"
+	}
+	var i int
+	for _, n := range r.graph.nodes {
+		for _, s := range [2][]*Texture{n.reads, n.writes} {
+			for _, t := range s {
+				if _, ok := names[t]; !ok {
+					names[t] = fmt.Sprintf("t%d (%dx%d)", i, t.Width(), t.Height())
+					i++
+				}
+			}
+		}
+	}
+	return names
+}
+
+// barrierStats computes the PassBarriers of every
+// node of g, which must have been compiled.
+// names identifies the textures in the issues.
+func (g *frameGraph) barrierStats(names map[*Texture]string) []PassBarriers {
+	stats := make([]PassBarriers, len(g.nodes))
+	// Layouts of the textures as the frame is
+	// recorded. Textures whose layouts are not
+	// known (e.g., due to a pending copy) are
+	// tracked from their first transition.
+	cur := make(map[*Texture]driver.Layout)
+	layout := func(t *Texture) (driver.Layout, bool) {
+		if l, ok := cur[t]; ok {
+			return l, true
+		}
+		if len(t.layouts) == 0 {
+			return 0, false
+		}
+		l := t.layouts[0].Load()
+		if l == invalLayout {
+			return 0, false
+		}
+		return driver.Layout(l), true
+	}
+	for i, n := range g.nodes {
+		s := &stats[i]
+		s.Pass = n.name
+		issue := func(format string, args ...any) {
+			s.Issues = append(s.Issues, fmt.Sprintf(format, args...))
+		}
+		for _, x := range g.trans[i] {
+			before := x.before
+			if l, ok := layout(x.tex); ok {
+				// The first transition uses
+				// whatever the layout is.
+				if !x.first && l != x.before {
+					issue("missing transition: %s is in %s, but is transitioned from %s", names[x.tex], layoutName(l), layoutName(x.before))
+				}
+				before = l
+			}
+			if before == x.after {
+				s.Barriers++
+				if x.barrier.AccessBefore == driver.ANone && x.barrier.AccessAfter&writeAccess == 0 {
+					issue("redundant transition: %s is already in %s", names[x.tex], layoutName(x.after))
+				}
+			} else {
+				s.Transitions++
+			}
+			cur[x.tex] = x.after
+		}
+		// Textures not written to in the frame
+		// are never transitioned.
+		for _, t := range n.reads {
+			if _, ok := g.final[t]; !ok {
+				continue
+			}
+			if l, ok := layout(t); ok && l != readUsage(t).layout {
+				issue("missing transition: %s is read in %s", names[t], layoutName(l))
+			}
+		}
+		for _, t := range n.writes {
+			if l, ok := layout(t); ok && l != writeUsage(t).layout {
+				issue("missing transition: %s is written in %s", names[t], layoutName(l))
+			}
+		}
+	}
+	return stats
+}
+
+// logBarriers writes the PassBarriers of g's nodes to
+// g.debug, as described in Renderer.SetGraphDebug.
+// g must have been compiled.
+func (g *frameGraph) logBarriers(r *Renderer) {
+	stats := g.barrierStats(r.texNames())
+	all := !g.logged
+	g.logged = true
+	for i := range stats {
+		s := &stats[i]
+		if all {
+			fmt.Fprintf(g.debug, "pass %s: %d transitions, %d barriers\n", s.Pass, s.Transitions, s.Barriers)
+		}
+		for _, x := range s.Issues {
+			fmt.Fprintf(g.debug, "pass %s: %s\n", s.Pass, x)
+		}
+	}
+}
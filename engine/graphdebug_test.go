@@ -0,0 +1,126 @@
+// Copyright 2024 Gustavo C. Viegas. All rights reserved.
+
+package engine
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"gviegas/neo3/driver"
+)
+
+func TestBarrierStats(t *testing.T) {
+	rend, err := NewOffscreen(64, 48)
+	if err != nil {
+		t.Fatalf("NewOffscreen failed:\n%v", err)
+	}
+	defer rend.Free()
+	// New textures, whose layouts are known
+	// to be undefined.
+	var color, depth, tex *Texture
+	for _, x := range [...]struct {
+		tex **Texture
+		pf  driver.PixelFmt
+	}{{&color, driver.RGBA16Float}, {&depth, driver.D16Unorm}, {&tex, driver.RGBA8Unorm}} {
+		*x.tex, err = NewTarget(&TexParam{
+			PixelFmt: x.pf,
+			Dim3D:    driver.Dim3D{Width: 64, Height: 48},
+			Layers:   1,
+			Levels:   1,
+			Samples:  1,
+		})
+		if err != nil {
+			t.Fatalf("NewTarget failed:\n%v", err)
+		}
+		defer (*x.tex).Free()
+	}
+
+	old := rend.graph
+	defer func() { rend.graph = old }()
+	rend.graph = frameGraph{}
+	g := &rend.graph
+	g.add(&passNode{name: "a", stage: stageGeometry, writes: []*Texture{color, depth}})
+	// Writes to color again, which only needs a
+	// memory barrier.
+	g.add(&passNode{name: "a2", stage: stageGeometry, writes: []*Texture{color}})
+	g.add(&passNode{name: "b", stage: stageLighting, reads: []*Texture{depth}, writes: []*Texture{tex}})
+	g.add(&passNode{name: "c", stage: stagePost, reads: []*Texture{color, tex}})
+
+	stats, err := rend.BarrierStats()
+	if err != nil {
+		t.Fatalf("Renderer.BarrierStats failed:\n%v", err)
+	}
+	want := []PassBarriers{
+		{Pass: "a", Transitions: 2},
+		{Pass: "a2", Barriers: 1},
+		{Pass: "b", Transitions: 2},
+		{Pass: "c", Transitions: 2},
+	}
+	if len(stats) != len(want) {
+		t.Fatalf("Renderer.BarrierStats: len\nhave %d\nwant %d", len(stats), len(want))
+	}
+	for i := range want {
+		s := stats[i]
+		if s.Pass != want[i].Pass || s.Transitions != want[i].Transitions || s.Barriers != want[i].Barriers || len(s.Issues) != 0 {
+			t.Fatalf("Renderer.BarrierStats: [%d]\nhave %+v\nwant %+v", i, s, want[i])
+		}
+	}
+
+	// Layout tracking must flag a transition from
+	// the wrong layout and one that does nothing.
+	g.trans[2][0].before = driver.LShaderRead
+	g.trans[3] = append(g.trans[3], texTransition{
+		tex:     tex,
+		before:  driver.LShaderRead,
+		after:   driver.LShaderRead,
+		barrier: driver.Barrier{SyncAfter: driver.SFragmentShading, AccessAfter: driver.AShaderRead},
+	})
+	if stats, _ = rend.BarrierStats(); len(stats[2].Issues) != 1 || !strings.Contains(stats[2].Issues[0], "missing") {
+		t.Fatalf("Renderer.BarrierStats: issues\nhave %v\nwant a missing transition", stats[2].Issues)
+	}
+	if len(stats[3].Issues) != 1 || !strings.Contains(stats[3].Issues[0], "redundant") {
+		t.Fatalf("Renderer.BarrierStats: issues\nhave %v\nwant a redundant transition", stats[3].Issues)
+	}
+
+	// Counts are logged once per compilation,
+	// and issues every time.
+	var buf bytes.Buffer
+	rend.SetGraphDebug(&buf)
+	g.logBarriers(&rend.Renderer)
+	if n := strings.Count(buf.String(), "\n"); n != len(want)+2 {
+		t.Fatalf("frameGraph.logBarriers: line count\nhave %d\nwant %d\n%s", n, len(want)+2, buf.String())
+	}
+	buf.Reset()
+	g.logBarriers(&rend.Renderer)
+	if n := strings.Count(buf.String(), "\n"); n != 2 {
+		t.Fatalf("frameGraph.logBarriers: line count\nhave %d\nwant 2\n%s", n, buf.String())
+	}
+	rend.SetGraphDebug(nil)
+	if g.debug != nil {
+		t.Fatal("Renderer.SetGraphDebug(nil): debug mode should be disabled")
+	}
+
+	st, err := newStorage(&TexParam{
+		PixelFmt: driver.RGBA16Float,
+		Dim3D:    driver.Dim3D{Width: 64, Height: 48},
+		Layers:   1,
+		Levels:   1,
+		Samples:  1,
+	})
+	if err != nil {
+		t.Fatalf("newStorage failed:\n%v", err)
+	}
+	defer st.Free()
+	g.add(&passNode{name: "async", stage: stageGeometry, writes: []*Texture{st}, async: true})
+	buf.Reset()
+	if err := rend.WriteGraphviz(&buf); err != nil {
+		t.Fatalf("Renderer.WriteGraphviz failed:\n%v", err)
+	}
+	dot := buf.String()
+	for _, s := range [...]string{"digraph frame {", `label="lighting"`, `p0 -> "t0 (64x48)"`, `"t1 (64x48)" -> p3`, `p3 -> "t3 (64x48)"`, `barriers", style=dashed`} {
+		if !strings.Contains(dot, s) {
+			t.Fatalf("Renderer.WriteGraphviz: missing %q in\n%s", s, dot)
+		}
+	}
+}
@@ -0,0 +1,176 @@
+// Copyright 2024 Gustavo C. Viegas. All rights reserved.
+
+package engine
+
+import (
+	"errors"
+	"math"
+	"sync"
+)
+
+// GrowthPolicy describes how a storage grows.
+// Sizes are in bytes.
+type GrowthPolicy struct {
+	// Minimum capacity of the storage.
+	Initial int64
+	// Factor by which the capacity is multiplied
+	// when the storage grows. If the result is not
+	// large enough, the storage grows to the
+	// required size instead. Values less than or
+	// equal to 1 mean that the storage only grows
+	// by the required amount.
+	Factor float64
+	// Maximum capacity of the storage. Zero means
+	// no limit.
+	Max int64
+	// What to do when growing would exceed Max.
+	OnExhaust ExhaustPolicy
+	// Called when OnExhaust is ExhaustEvict, with
+	// the number of bytes required. It should free
+	// resources that use the storage and then
+	// return true, or return false if nothing can
+	// be freed. It must not create resources that
+	// use the storage.
+	Evict func(need int64) bool
+}
+
+// ExhaustPolicy is the type of behaviors on storage
+// exhaustion.
+type ExhaustPolicy int
+
+// Exhaustion behaviors.
+const (
+	// Fail with ErrExhausted.
+	ExhaustError ExhaustPolicy = iota
+	// Call GrowthPolicy.Evict and retry.
+	ExhaustEvict
+	// Block until enough space is freed by other
+	// goroutines.
+	ExhaustBlock
+)
+
+// ErrExhausted is returned when a storage cannot grow
+// any further.
+// It wraps ErrOutOfMemory.
+var ErrExhausted = newErr("engine: ", "storage exhausted", ErrOutOfMemory)
+
+// validate checks whether p is valid.
+func (p *GrowthPolicy) validate() error {
+	var reason string
+	switch {
+	case p == nil:
+		reason = "nil growth policy"
+	case p.Initial < 0, p.Max < 0:
+		reason = "negative growth policy size"
+	case p.Max != 0 && p.Initial > p.Max:
+		reason = "growth policy Initial exceeds Max"
+	case math.IsNaN(p.Factor), math.IsInf(p.Factor, 0):
+		reason = "invalid growth policy Factor"
+	case p.OnExhaust < ExhaustError || p.OnExhaust > ExhaustBlock:
+		reason = "undefined exhaustion policy"
+	case p.OnExhaust == ExhaustEvict && p.Evict == nil:
+		reason = "nil growth policy Evict func"
+	default:
+		return nil
+	}
+	return newErr("engine: ", reason, ErrInvalidParam)
+}
+
+// grow computes the new capacity of a storage whose
+// current capacity is cur and that requires at least
+// req bytes of capacity.
+// The result is a multiple of unit. Capacities below
+// Initial grow to Initial.
+// It returns false if req exceeds Max.
+func (p *GrowthPolicy) grow(cur, req, unit int64) (int64, bool) {
+	n := max(req, p.Initial)
+	if p.Factor > 1 && cur > 0 {
+		if x := float64(cur) * p.Factor; x < float64(math.MaxInt64/2) {
+			n = max(n, int64(x))
+		}
+	}
+	n = (n + unit - 1) / unit * unit
+	if p.Max > 0 && n > p.Max {
+		n = p.Max / unit * unit
+		if n < req {
+			return cur, false
+		}
+	}
+	return n, true
+}
+
+// SetMeshGrowth sets the growth policy of the mesh
+// storage.
+// If the storage is smaller than p.Initial, it grows
+// immediately.
+// The default policy has Initial set to NMeshBuffer
+// and grows by the required amount without limit.
+func SetMeshGrowth(p *GrowthPolicy) error {
+	if err := p.validate(); err != nil {
+		return err
+	}
+	meshes.Lock()
+	defer meshes.Unlock()
+	meshes.growth = *p
+	// Avoid blocking forever on a limit that
+	// was raised.
+	meshes.cond().Broadcast()
+	if cur := meshes.capacity(); cur < p.Initial {
+		return meshes.resize(p.Initial)
+	}
+	return nil
+}
+
+// MeshGrowth returns the growth policy of the mesh
+// storage.
+func MeshGrowth() GrowthPolicy {
+	meshes.RLock()
+	defer meshes.RUnlock()
+	return meshes.growth
+}
+
+// Growth policy of Texture staging buffers.
+var stgGrowth = struct {
+	sync.Mutex
+	p GrowthPolicy
+}{p: GrowthPolicy{Initial: int64(texStgBlock * texStgNBit)}}
+
+// SetStagingGrowth sets the growth policy of the
+// staging buffers used for Texture copies.
+// Every staging buffer is committed and then resized
+// to fit within [p.Initial, p.Max].
+// Since committing frees all of a staging buffer's
+// space, exhaustion only happens when a single copy
+// is larger than p.Max, in which case the copy fails
+// with ErrExhausted regardless of p.OnExhaust.
+// The default policy has Initial set to 4MiB and
+// grows by the required amount without limit.
+func SetStagingGrowth(p *GrowthPolicy) error {
+	if err := p.validate(); err != nil {
+		return err
+	}
+	stgGrowth.Lock()
+	stgGrowth.p = *p
+	stgGrowth.Unlock()
+	texStgMu.Lock()
+	defer texStgMu.Unlock()
+	stgs := make([]*texStgBuffer, 0, cap(texStg))
+	var errs []error
+	for range cap(texStg) {
+		s := <-texStg
+		errs = append(errs, s.resize(p))
+		stgs = append(stgs, s)
+	}
+	for _, s := range stgs {
+		texStg <- s
+	}
+	return errors.Join(errs...)
+}
+
+// StagingGrowth returns the growth policy of the
+// staging buffers used for Texture copies.
+func StagingGrowth() GrowthPolicy {
+	stgGrowth.Lock()
+	defer stgGrowth.Unlock()
+	return stgGrowth.p
+}
@@ -0,0 +1,163 @@
+// Copyright 2024 Gustavo C. Viegas. All rights reserved.
+
+package engine
+
+import (
+	"testing"
+
+	"gviegas/neo3/driver"
+	"gviegas/neo3/internal/suballoc"
+)
+
+func TestGrowthPolicy(t *testing.T) {
+	for _, x := range [...]struct {
+		p              GrowthPolicy
+		cur, req, unit int64
+		n              int64
+		ok             bool
+	}{
+		{GrowthPolicy{}, 0, 1, 16, 16, true},
+		{GrowthPolicy{}, 32, 40, 16, 48, true},
+		{GrowthPolicy{Initial: 100}, 0, 1, 16, 112, true},
+		{GrowthPolicy{Factor: 2}, 32, 40, 16, 64, true},
+		{GrowthPolicy{Factor: 2}, 32, 80, 16, 80, true},
+		{GrowthPolicy{Factor: 0.5}, 32, 40, 16, 48, true},
+		{GrowthPolicy{Factor: 2, Max: 56}, 32, 40, 16, 48, true},
+		{GrowthPolicy{Max: 56}, 32, 50, 16, 32, false},
+		{GrowthPolicy{Initial: 64, Max: 64}, 64, 65, 16, 64, false},
+	} {
+		n, ok := x.p.grow(x.cur, x.req, x.unit)
+		if n != x.n || ok != x.ok {
+			t.Fatalf("GrowthPolicy.grow(%d, %d, %d): %+v\nhave %d, %t\nwant %d, %t", x.cur, x.req, x.unit, x.p, n, ok, x.n, x.ok)
+		}
+	}
+
+	for _, p := range [...]*GrowthPolicy{
+		nil,
+		{Initial: -1},
+		{Max: -1},
+		{Initial: 2, Max: 1},
+		{OnExhaust: -1},
+		{OnExhaust: ExhaustBlock + 1},
+		{OnExhaust: ExhaustEvict},
+	} {
+		if err := p.validate(); err == nil {
+			t.Fatalf("GrowthPolicy.validate: %+v\nhave nil\nwant non-nil", p)
+		}
+	}
+	if err := (&GrowthPolicy{Max: 1}).validate(); err != nil {
+		t.Fatalf("GrowthPolicy.validate:\nhave %v\nwant nil", err)
+	}
+}
+
+func TestMeshGrowth(t *testing.T) {
+	if buf := setMeshBuffer(nil); buf != nil {
+		buf.Destroy()
+	}
+	dfl := MeshGrowth()
+	defer SetMeshGrowth(&dfl)
+
+	unit := int64(spanBlock * suballoc.Word)
+	if err := SetMeshGrowth(&GrowthPolicy{Initial: unit + 1, Max: unit * 2}); err != nil {
+		t.Fatalf("SetMeshGrowth failed:\n%v", err)
+	}
+	if x := meshes.capacity(); x != unit*2 {
+		t.Fatalf("SetMeshGrowth: capacity\nhave %d\nwant %d", x, unit*2)
+	}
+
+	// Each mesh takes more than a third of
+	// the maximum capacity.
+	ntris := 1
+	for {
+		data := dummyData1(ntris)
+		var n int
+		for _, x := range data.Primitives[0].Semantics {
+			n += x.Format.Size() * data.Primitives[0].VertexCount
+		}
+		if int64(n) > unit*2/3 {
+			break
+		}
+		ntris *= 2
+	}
+	newMesh := func() (*Mesh, error) {
+		data := dummyData1(ntris)
+		return NewMesh(&data)
+	}
+	m, err := newMesh()
+	if err != nil {
+		t.Fatalf("NewMesh failed:\n%v", err)
+	}
+	if _, err := newMesh(); err != ErrExhausted {
+		t.Fatalf("NewMesh:\nhave %v\nwant %v", err, ErrExhausted)
+	}
+
+	var need int64
+	if err := SetMeshGrowth(&GrowthPolicy{
+		Max:       unit * 2,
+		OnExhaust: ExhaustEvict,
+		Evict: func(n int64) bool {
+			if m == nil {
+				return false
+			}
+			need = n
+			m.Free()
+			m = nil
+			return true
+		},
+	}); err != nil {
+		t.Fatalf("SetMeshGrowth failed:\n%v", err)
+	}
+	m2, err := newMesh()
+	if err != nil {
+		t.Fatalf("NewMesh failed:\n%v", err)
+	}
+	defer m2.Free()
+	if m != nil || need <= 0 {
+		t.Fatal("GrowthPolicy.Evict: should have been called")
+	}
+	if _, err := newMesh(); err != ErrExhausted {
+		t.Fatalf("NewMesh:\nhave %v\nwant %v", err, ErrExhausted)
+	}
+	if x := meshes.capacity(); x != unit*2 {
+		t.Fatalf("NewMesh: capacity\nhave %d\nwant %d", x, unit*2)
+	}
+}
+
+func TestStagingGrowth(t *testing.T) {
+	dfl := StagingGrowth()
+	defer SetStagingGrowth(&dfl)
+
+	unit := int64(texStgBlock * texStgNBit)
+	if err := SetStagingGrowth(&GrowthPolicy{Initial: unit * 2, Max: unit * 2}); err != nil {
+		t.Fatalf("SetStagingGrowth failed:\n%v", err)
+	}
+	if s := Stats().TexStaging; s.Busy == 0 && s.Capacity != int64(s.Buffers)*unit*2 {
+		t.Fatalf("SetStagingGrowth: capacity\nhave %d\nwant %d", s.Capacity, int64(s.Buffers)*unit*2)
+	}
+
+	newTex := func(height int) *Texture {
+		tex, err := New2D(&TexParam{
+			PixelFmt: driver.RGBA8Unorm,
+			Dim3D:    driver.Dim3D{Width: 1024, Height: height},
+			Layers:   1,
+			Levels:   1,
+			Samples:  1,
+		})
+		if err != nil {
+			t.Fatalf("New2D failed:\n%v", err)
+		}
+		return tex
+	}
+	// Fits within Max.
+	tex := newTex(int(unit / 4096))
+	defer tex.Free()
+	if err := tex.CopyToView(0, make([]byte, tex.ViewSize(0)), true); err != nil {
+		t.Fatalf("Texture.CopyToView failed:\n%v", err)
+	}
+	// Exceeds Max.
+	tex2 := newTex(int(unit*2/4096 + 1))
+	defer tex2.Free()
+	if err := tex2.CopyToView(0, make([]byte, tex2.ViewSize(0)), true); err != ErrExhausted {
+		t.Fatalf("Texture.CopyToView:\nhave %v\nwant %v", err, ErrExhausted)
+	}
+}
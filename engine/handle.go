@@ -0,0 +1,168 @@
+// Copyright 2024 Gustavo C. Viegas. All rights reserved.
+
+package engine
+
+import (
+	"fmt"
+	"runtime/debug"
+	"sync"
+	"weak"
+)
+
+// TextureID is an opaque handle to a Texture.
+// Unlike a *Texture, a TextureID can be freely
+// shared between goroutines and stored as a plain
+// integer (e.g., in a scene file), and it becomes
+// invalid when the Texture is freed.
+// The zero value is never a valid TextureID.
+type TextureID uint64
+
+// MeshID is an opaque handle to a Mesh.
+// It behaves as TextureID does.
+type MeshID uint64
+
+// MaterialID is an opaque handle to a Material.
+// It behaves as TextureID does.
+type MaterialID uint64
+
+var (
+	textureIDs  handleMap[Texture]
+	meshIDs     handleMap[Mesh]
+	materialIDs handleMap[Material]
+)
+
+// ID returns the TextureID of t.
+// The first call registers t. The same value is
+// returned until t is freed.
+func (t *Texture) ID() TextureID { return TextureID(textureIDs.id(t, (*uint64)(&t.id))) }
+
+// Texture returns the Texture identified by id, or nil
+// if id is not valid.
+// Building with the neo3debug tag causes it to panic
+// instead when the Texture has been freed.
+func (id TextureID) Texture() *Texture { return textureIDs.get(uint64(id), "TextureID") }
+
+// ID returns the MeshID of m.
+// The first call registers m. The same value is
+// returned until m is freed.
+func (m *Mesh) ID() MeshID { return MeshID(meshIDs.id(m, (*uint64)(&m.id))) }
+
+// Mesh returns the Mesh identified by id, or nil if
+// id is not valid.
+// Building with the neo3debug tag causes it to panic
+// instead when the Mesh has been freed.
+func (id MeshID) Mesh() *Mesh { return meshIDs.get(uint64(id), "MeshID") }
+
+// ID returns the MaterialID of m.
+// The first call registers m. The same value is
+// returned until m is freed.
+func (m *Material) ID() MaterialID { return MaterialID(materialIDs.id(m, (*uint64)(&m.id))) }
+
+// Material returns the Material identified by id, or
+// nil if id is not valid.
+// Building with the neo3debug tag causes it to panic
+// instead when the Material has been freed.
+func (id MaterialID) Material() *Material { return materialIDs.get(uint64(id), "MaterialID") }
+
+// handleMap maps generational handles to values of
+// type T.
+// A handle stores a slot index in its lower 32 bits
+// and the slot's generation in the upper 32 bits.
+// Generations start at 1, so the zero handle is never
+// valid. Releasing a handle increments the generation
+// of its slot, which invalidates every copy of it.
+// Values are referenced weakly, so registering one
+// does not prevent it from being collected.
+type handleMap[T any] struct {
+	mu    sync.RWMutex
+	slots []handleSlot[T]
+	free  []uint32
+}
+
+// handleSlot is what a handleMap stores.
+type handleSlot[T any] struct {
+	ptr weak.Pointer[T]
+	gen uint32
+	// Stack of the release call, only set
+	// if debugHandles is true.
+	freed string
+}
+
+// id returns the handle stored in *h, registering x
+// and setting *h if it is zero.
+// h must point into x. It is accessed while holding
+// the lock, so concurrent calls are safe.
+func (m *handleMap[T]) id(x *T, h *uint64) uint64 {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if *h != 0 {
+		return *h
+	}
+	var idx uint32
+	if n := len(m.free); n > 0 {
+		idx = m.free[n-1]
+		m.free = m.free[:n-1]
+	} else {
+		idx = uint32(len(m.slots))
+		m.slots = append(m.slots, handleSlot[T]{gen: 1})
+	}
+	s := &m.slots[idx]
+	s.ptr = weak.Make(x)
+	s.freed = ""
+	*h = uint64(s.gen)<<32 | uint64(idx)
+	return *h
+}
+
+// release invalidates the handle stored in *h and sets
+// *h to zero.
+// It does nothing if *h is zero.
+func (m *handleMap[T]) release(h *uint64) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if *h == 0 {
+		return
+	}
+	idx := uint32(*h)
+	s := &m.slots[idx]
+	s.ptr = weak.Pointer[T]{}
+	if s.gen++; s.gen == 0 {
+		s.gen = 1
+	}
+	if debugHandles {
+		s.freed = string(debug.Stack())
+	}
+	m.free = append(m.free, idx)
+	*h = 0
+}
+
+// get returns the value identified by h, or nil if h
+// is not valid.
+// If debugHandles is true, it panics if h refers to a
+// released value. name is used in the panic message.
+func (m *handleMap[T]) get(h uint64, name string) *T {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	idx, gen := uint32(h), uint32(h>>32)
+	if gen == 0 || int(idx) >= len(m.slots) {
+		return nil
+	}
+	s := &m.slots[idx]
+	if s.gen != gen {
+		if debugHandles && s.gen > gen {
+			panic(fmt.Sprintf("engine: use of freed %s %#x\nfreed at:\n%s", name, h, m.freedAt(idx, gen)))
+		}
+		return nil
+	}
+	return s.ptr.Value()
+}
+
+// freedAt returns the stack of the release call that
+// invalidated generation gen of slot idx, if known.
+// Only the latest release is recorded.
+func (m *handleMap[T]) freedAt(idx, gen uint32) string {
+	s := &m.slots[idx]
+	if s.gen != gen+1 || s.freed == "" {
+		return "(unknown)"
+	}
+	return s.freed
+}
@@ -0,0 +1,9 @@
+// Copyright 2024 Gustavo C. Viegas. All rights reserved.
+
+//go:build neo3debug
+
+package engine
+
+// debugHandles enables use-after-free detection for
+// TextureID, MeshID and MaterialID.
+const debugHandles = true
@@ -0,0 +1,10 @@
+// Copyright 2024 Gustavo C. Viegas. All rights reserved.
+
+//go:build !neo3debug
+
+package engine
+
+// debugHandles enables use-after-free detection for
+// TextureID, MeshID and MaterialID.
+// Build with the neo3debug tag to enable it.
+const debugHandles = false
@@ -0,0 +1,162 @@
+// Copyright 2024 Gustavo C. Viegas. All rights reserved.
+
+package engine
+
+import (
+	"runtime"
+	"sync"
+	"testing"
+
+	"gviegas/neo3/driver"
+)
+
+func TestHandleMap(t *testing.T) {
+	type T struct {
+		x  int
+		id uint64
+	}
+	var m handleMap[T]
+	a, b := &T{x: 1}, &T{x: 2}
+	ha := m.id(a, &a.id)
+	if ha == 0 {
+		t.Fatal("handleMap.id: unexpected zero handle")
+	}
+	if x := m.id(a, &a.id); x != ha {
+		t.Fatalf("handleMap.id: second call\nhave %#x\nwant %#x", x, ha)
+	}
+	hb := m.id(b, &b.id)
+	if hb == ha {
+		t.Fatalf("handleMap.id: handles of distinct values should differ\nhave %#x", hb)
+	}
+	if x := m.get(ha, "T"); x != a {
+		t.Fatalf("handleMap.get:\nhave %p\nwant %p", x, a)
+	}
+	if x := m.get(hb, "T"); x != b {
+		t.Fatalf("handleMap.get:\nhave %p\nwant %p", x, b)
+	}
+	for _, h := range [...]uint64{0, 2 << 32, uint64(uint32(ha)), 100 | 1<<32} {
+		if x := m.get(h, "T"); x != nil {
+			t.Fatalf("handleMap.get(%#x):\nhave %p\nwant nil", h, x)
+		}
+	}
+
+	m.release(&a.id)
+	if a.id != 0 {
+		t.Fatalf("handleMap.release: id\nhave %#x\nwant 0", a.id)
+	}
+	func() {
+		defer func() {
+			if x := recover(); (x != nil) != debugHandles {
+				t.Fatalf("handleMap.get: released handle\nhave panic %v\nwant panic only if debugHandles (%t)", x, debugHandles)
+			}
+		}()
+		if x := m.get(ha, "T"); x != nil {
+			t.Fatalf("handleMap.get: released handle\nhave %p\nwant nil", x)
+		}
+	}()
+	m.release(&a.id)
+
+	// The slot is reused with a new generation.
+	c := &T{x: 3}
+	hc := m.id(c, &c.id)
+	if uint32(hc) != uint32(ha) || hc == ha {
+		t.Fatalf("handleMap.id: reused slot\nhave %#x\nwant index of %#x and new generation", hc, ha)
+	}
+	if x := m.get(hc, "T"); x != c {
+		t.Fatalf("handleMap.get:\nhave %p\nwant %p", x, c)
+	}
+	runtime.KeepAlive(b)
+}
+
+func TestHandleMapWeak(t *testing.T) {
+	type T struct {
+		x  [64]byte
+		id uint64
+	}
+	var m handleMap[T]
+	h := func() uint64 {
+		x := new(T)
+		return m.id(x, &x.id)
+	}()
+	runtime.GC()
+	runtime.GC()
+	if x := m.get(h, "T"); x != nil {
+		t.Fatalf("handleMap.get: collected value\nhave %p\nwant nil", x)
+	}
+}
+
+func TestHandleMapConcurrent(t *testing.T) {
+	type T struct{ id uint64 }
+	var m handleMap[T]
+	x := new(T)
+	var wg sync.WaitGroup
+	ids := make([]uint64, 8)
+	for i := range ids {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			ids[i] = m.id(x, &x.id)
+			if y := m.get(ids[i], "T"); y != x {
+				t.Errorf("handleMap.get:\nhave %p\nwant %p", y, x)
+			}
+		}()
+	}
+	wg.Wait()
+	for _, id := range ids[1:] {
+		if id != ids[0] {
+			t.Fatalf("handleMap.id: concurrent calls\nhave %#x\nwant %#x", id, ids[0])
+		}
+	}
+}
+
+func TestResourceIDs(t *testing.T) {
+	tex, err := New2D(&TexParam{
+		PixelFmt: driver.RGBA8Unorm,
+		Dim3D:    driver.Dim3D{Width: 64, Height: 64},
+		Layers:   1,
+		Levels:   1,
+		Samples:  1,
+	})
+	if err != nil {
+		t.Fatalf("New2D failed:\n%v", err)
+	}
+	d := dummyData1(12)
+	mesh, err := NewMesh(&d)
+	if err != nil {
+		tex.Free()
+		t.Fatalf("NewMesh failed:\n%v", err)
+	}
+	mat, err := NewUnlit(&Unlit{})
+	if err != nil {
+		tex.Free()
+		mesh.Free()
+		t.Fatalf("NewUnlit failed:\n%v", err)
+	}
+
+	tid, mid, aid := tex.ID(), mesh.ID(), mat.ID()
+	if x := tid.Texture(); x != tex {
+		t.Fatalf("TextureID.Texture:\nhave %p\nwant %p", x, tex)
+	}
+	if x := mid.Mesh(); x != mesh {
+		t.Fatalf("MeshID.Mesh:\nhave %p\nwant %p", x, mesh)
+	}
+	if x := aid.Material(); x != mat {
+		t.Fatalf("MaterialID.Material:\nhave %p\nwant %p", x, mat)
+	}
+
+	tex.Free()
+	mesh.Free()
+	mat.Free()
+	if debugHandles {
+		return
+	}
+	if x := tid.Texture(); x != nil {
+		t.Fatalf("TextureID.Texture: after Free\nhave %p\nwant nil", x)
+	}
+	if x := mid.Mesh(); x != nil {
+		t.Fatalf("MeshID.Mesh: after Free\nhave %p\nwant nil", x)
+	}
+	if x := aid.Material(); x != nil {
+		t.Fatalf("MaterialID.Material: after Free\nhave %p\nwant nil", x)
+	}
+}
@@ -5,7 +5,7 @@ package engine
 import (
 	"iter"
 
-	"gviegas/neo3/internal/bitvec"
+	"gviegas/neo3/bitvec"
 )
 
 // dataID identifies a dataMap.data element.
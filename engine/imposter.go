@@ -0,0 +1,386 @@
+// Copyright 2024 Gustavo C. Viegas. All rights reserved.
+
+package engine
+
+import (
+	"hash/maphash"
+	"math"
+	"slices"
+
+	"gviegas/neo3/driver"
+	"gviegas/neo3/engine/internal/shader"
+	"gviegas/neo3/linear"
+	"gviegas/neo3/node"
+)
+
+// Imposter limits.
+const (
+	// Maximum number of views that an imposter
+	// atlas can hold.
+	MaxImposterViews = 16
+	// Maximum width and height of each view, in
+	// pixels.
+	MaxImposterSize = 512
+)
+
+// imposterFmt is the pixel format of imposter atlases.
+const imposterFmt = driver.RGBA8Unorm
+
+// ImposterSwitch is a node.Interface that roots a
+// group that can be replaced by an imposter.
+// It is meant to be the parent of the nodes whose
+// drawables form the group. Whether the group or
+// its imposter is displayed is decided during
+// culling, based on the distance from the camera.
+type ImposterSwitch struct {
+	local   linear.M4
+	changed bool
+	// Whether the imposter was displayed by the
+	// last draw list built.
+	far bool
+}
+
+// NewImposterSwitch creates a new ImposterSwitch.
+// local can be nil, in which case the identity is
+// used.
+func NewImposterSwitch(local *linear.M4) *ImposterSwitch {
+	s := &ImposterSwitch{local: linear.I4(), changed: true}
+	if local != nil {
+		s.local = *local
+	}
+	return s
+}
+
+// SetLocal sets the local transform of s.
+func (s *ImposterSwitch) SetLocal(local *linear.M4) {
+	s.local = *local
+	s.changed = true
+}
+
+// Far returns whether the imposter was displayed in
+// place of the group by the last culling.
+// The sub-graph rooted at s can be ignored (see
+// node.Graph.Ignore) while this is the case.
+func (s *ImposterSwitch) Far() bool { return s.far }
+
+// Local implements node.Interface.
+func (s *ImposterSwitch) Local() *linear.M4 { return &s.local }
+
+// Changed implements node.Interface.
+func (s *ImposterSwitch) Changed() bool {
+	c := s.changed
+	s.changed = false
+	return c
+}
+
+// imposterMap is a dataMap for imposters.
+type imposterMap struct{ dataMap[Imposter, imposter] }
+
+// imposter is what an imposterMap stores.
+type imposter struct {
+	node  node.Node
+	sw    *ImposterSwitch
+	group []Drawable
+	dist  float32
+	views int
+	size  int
+	atlas *Texture
+	// Bounding sphere of the group in the space
+	// of the switch node.
+	center linear.V3
+	radius float32
+	// World transform of the switch node.
+	world linear.M4
+	// Hash of the group's content as of the
+	// last bake.
+	hash uint64
+	// Whether the atlas must be baked.
+	pending bool
+	// Whether the atlas has been baked at
+	// least once.
+	ready  bool
+	layout shader.ImposterLayout
+}
+
+// Imposter identifies a group imposter.
+// An Imposter is always associated with a Renderer,
+// thus there might be identical Imposter values
+// that belong to different renderers.
+type Imposter int
+
+// ImposterParam describes a group imposter.
+// Node must identify an *ImposterSwitch in the
+// node.Graph passed to Renderer.UpdateImposters.
+// Group contains the drawables of the sub-graph
+// rooted at Node. They are rendered into an atlas
+// from Views directions evenly spaced around the
+// Y axis, each view being Size×Size pixels.
+// When the camera is farther than Distance from the
+// group, a billboard sampling the atlas is drawn
+// instead of the group.
+type ImposterParam struct {
+	Node     node.Node
+	Group    []Drawable
+	Distance float32
+	Views    int
+	Size     int
+}
+
+// AddImposter adds a new group imposter to r.
+// The imposter is not displayed until its atlas has
+// been baked, which happens after UpdateImposters
+// is called.
+// The drawables in param.Group must not be removed
+// from r while the imposter exists.
+func (r *Renderer) AddImposter(param *ImposterParam) (Imposter, error) {
+	var reason string
+	switch {
+	case param == nil:
+		reason = "nil imposter param"
+	case param.Node == node.Nil:
+		reason = "nil imposter node"
+	case len(param.Group) == 0:
+		reason = "empty imposter group"
+	case !(param.Distance > 0):
+		reason = "invalid imposter distance"
+	case param.Views < 1 || param.Views > MaxImposterViews:
+		reason = "invalid imposter view count"
+	case param.Size < 1 || param.Size > MaxImposterSize:
+		reason = "invalid imposter size"
+	default:
+		goto validParam
+	}
+	return -1, newRendErr(reason)
+validParam:
+	atlas, err := NewTarget(&TexParam{
+		PixelFmt: imposterFmt,
+		Dim3D:    driver.Dim3D{Width: param.Size * param.Views, Height: param.Size},
+		Layers:   1,
+		Levels:   1,
+		Samples:  1,
+	})
+	if err != nil {
+		return -1, err
+	}
+	i := imposter{
+		node:  param.Node,
+		group: slices.Clone(param.Group),
+		dist:  param.Distance,
+		views: param.Views,
+		size:  param.Size,
+		atlas: atlas,
+	}
+	i.layout.SetViews(param.Views)
+	return r.imposters.insert(i), nil
+}
+
+// RemoveImposter removes i from r.
+// Its atlas is freed.
+func (r *Renderer) RemoveImposter(i Imposter) {
+	x := r.imposters.remove(i)
+	x.atlas.Free()
+}
+
+// BakeImposter schedules the atlas of i to be baked
+// again.
+// Baking happens automatically when the group's
+// content changes, so this is only needed when a
+// change cannot be detected (e.g., a material's
+// texture was updated).
+func (r *Renderer) BakeImposter(i Imposter) { r.imposters.get(i).pending = true }
+
+// ImpostersLen returns the number of imposters in r.
+func (r *Renderer) ImpostersLen() int { return r.imposters.len() }
+
+// UpdateImposters updates the bounds of every imposter
+// in r, taking the switch nodes' world transforms
+// from g.
+// Imposters whose groups have changed since the last
+// bake (i.e., drawables were moved relative to the
+// switch node, or had their meshes or materials
+// replaced) are scheduled to be baked again.
+func (r *Renderer) UpdateImposters(g *node.Graph) {
+	defer traceBegin(traceUpdate, "imposters").end()
+	for _, i := range r.imposters.all() {
+		sw, ok := g.Get(i.node).(*ImposterSwitch)
+		if !ok {
+			panic("engine.Renderer.UpdateImposters: node is not an *ImposterSwitch")
+		}
+		i.sw = sw
+		i.world = *g.World(i.node)
+		if h := i.update(r); h != i.hash || !i.ready {
+			i.hash = h
+			i.pending = true
+		}
+	}
+}
+
+// imposterSeed is used to hash group content.
+var imposterSeed = maphash.MakeSeed()
+
+// update recomputes i's bounds and layout.
+// It returns the hash of the group's content.
+func (i *imposter) update(r *Renderer) uint64 {
+	var inv linear.M4
+	inv.Invert(&i.world)
+	var h maphash.Hash
+	h.SetSeed(imposterSeed)
+	var lo, hi linear.V3
+	first := true
+	for _, id := range i.group {
+		d := r.drawables.get(id)
+		world := d.layout.World()
+		var rel linear.M4
+		rel.Mul(&inv, &world)
+		maphash.WriteComparable(&h, d.mesh)
+		maphash.WriteComparable(&h, rel)
+		for _, m := range d.mat {
+			maphash.WriteComparable(&h, m)
+		}
+		for p := range d.mesh.Len() {
+			min, max := d.mesh.bounds(p)
+			for _, c := range boxCorners(&min, &max) {
+				v := linear.V4{c[0], c[1], c[2], 1}
+				v.Mul(&rel, &v)
+				for k := range lo {
+					if first || v[k] < lo[k] {
+						lo[k] = v[k]
+					}
+					if first || v[k] > hi[k] {
+						hi[k] = v[k]
+					}
+				}
+				first = false
+			}
+		}
+	}
+	i.center.Add(&lo, &hi)
+	i.center.Scale(0.5, &i.center)
+	var e linear.V3
+	e.Sub(&hi, &i.center)
+	i.radius = e.Len()
+
+	c := linear.V4{i.center[0], i.center[1], i.center[2], 1}
+	c.Mul(&i.world, &c)
+	var scale float32
+	for k := range 3 {
+		col := linear.V3{i.world[k][0], i.world[k][1], i.world[k][2]}
+		scale = max(scale, col.Len())
+	}
+	i.layout.SetBounds(&linear.V3{c[0], c[1], c[2]}, i.radius*scale)
+	i.layout.SetYaw(float32(math.Atan2(float64(-i.world[0][2]), float64(i.world[0][0]))))
+	return h.Sum64()
+}
+
+// boxCorners returns the corners of the box [min, max].
+func boxCorners(min, max *linear.V3) (c [8]linear.V3) {
+	for i := range c {
+		for j := range 3 {
+			if i&(1<<j) == 0 {
+				c[i][j] = min[j]
+			} else {
+				c[i][j] = max[j]
+			}
+		}
+	}
+	return
+}
+
+// imposterBake describes a view of an imposter atlas
+// to be rendered.
+// The group's drawables are rendered into rect, which
+// is the area of the atlas that holds the view.
+type imposterBake struct {
+	id   Imposter
+	view linear.M4
+	proj linear.M4
+	rect ViewportRect
+}
+
+// nextBakes appends to dst every view of the next
+// imposter pending bake. At most one imposter is
+// baked per frame.
+// The bake is rendered through the same path as
+// viewports that target a texture, using rect to
+// select the atlas cell.
+func (r *Renderer) nextBakes(dst []imposterBake) []imposterBake {
+	for id, i := range r.imposters.all() {
+		if !i.pending {
+			continue
+		}
+		i.pending = false
+		i.ready = true
+		ctr, rad := i.layout.Bounds()
+		rad = max(rad, 1e-3)
+		up := linear.V3{0, 1, 0}
+		var proj linear.M4
+		proj.Ortho(-rad, rad, rad, -rad, 0, 4*rad)
+		yaw := float64(i.layout.Yaw())
+		for v := range i.views {
+			a := 2*math.Pi*float64(v)/float64(i.views) + yaw
+			eye := linear.V3{
+				ctr[0] + 2*rad*float32(math.Sin(a)),
+				ctr[1],
+				ctr[2] + 2*rad*float32(math.Cos(a)),
+			}
+			b := imposterBake{id: id, proj: proj}
+			b.view.LookAt(&ctr, &eye, &up)
+			b.rect = ViewportRect{
+				X:      float32(v) / float32(i.views),
+				Width:  1 / float32(i.views),
+				Height: 1,
+			}
+			dst = append(dst, b)
+		}
+		break
+	}
+	return dst
+}
+
+// switchImposters evaluates the switch of every
+// imposter in r for the camera whose view transform
+// is view, filling l.imposter with the imposters to
+// draw and l.hidden with the drawables that they
+// replace.
+// If fr is not nil, imposters outside of it are not
+// added to l.imposter, although their groups are
+// still hidden.
+func (l *drawList) switchImposters(r *Renderer, view *linear.M4, fr *frustum) {
+	l.imposter = l.imposter[:0]
+	clear(l.hidden)
+	var cam linear.M4
+	cam.Invert(view)
+	eye := linear.V3{cam[3][0], cam[3][1], cam[3][2]}
+	world := linear.I4()
+	for id, i := range r.imposters.all() {
+		ctr, rad := i.layout.Bounds()
+		var d linear.V3
+		d.Sub(&ctr, &eye)
+		far := i.ready && d.Len() > i.dist
+		if i.sw != nil {
+			i.sw.far = far
+		}
+		if !far {
+			continue
+		}
+		if l.hidden == nil {
+			l.hidden = make(map[Drawable]bool)
+		}
+		for _, x := range i.group {
+			l.hidden[x] = true
+		}
+		min := linear.V3{ctr[0] - rad, ctr[1] - rad, ctr[2] - rad}
+		max := linear.V3{ctr[0] + rad, ctr[1] + rad, ctr[2] + rad}
+		if fr == nil || !fr.cull(&world, &min, &max) {
+			l.imposter = append(l.imposter, id)
+		}
+	}
+}
+
+// freeImposters frees the atlas of every imposter
+// in r.
+func (r *Renderer) freeImposters() {
+	for _, i := range r.imposters.all() {
+		i.atlas.Free()
+	}
+}
@@ -0,0 +1,178 @@
+// Copyright 2024 Gustavo C. Viegas. All rights reserved.
+
+package engine
+
+import (
+	"testing"
+
+	"gviegas/neo3/linear"
+	"gviegas/neo3/node"
+)
+
+func TestImposterSwitch(t *testing.T) {
+	s := NewImposterSwitch(nil)
+	if x := *s.Local(); x != linear.I4() {
+		t.Fatalf("ImposterSwitch.Local:\nhave %v\nwant %v", x, linear.I4())
+	}
+	if !s.Changed() {
+		t.Fatal("ImposterSwitch.Changed: new switch should be changed")
+	}
+	if s.Changed() {
+		t.Fatal("ImposterSwitch.Changed: unexpected change")
+	}
+	var m linear.M4
+	m.Translate(1, 2, 3)
+	s.SetLocal(&m)
+	if !s.Changed() || *s.Local() != m {
+		t.Fatal("ImposterSwitch.SetLocal: local transform not updated")
+	}
+}
+
+func TestRendererImposter(t *testing.T) {
+	rend, err := NewOffscreen(256, 192)
+	if err != nil {
+		t.Fatalf("RendererImposter: NewOffscreen failed:\n%v", err)
+	}
+	defer rend.Free()
+	mesh, err := NewMesh(quadData(0))
+	if err != nil {
+		t.Fatalf("RendererImposter: NewMesh failed:\n%v", err)
+	}
+	defer mesh.Free()
+	mat, err := NewPBR(&PBR{})
+	if err != nil {
+		t.Fatalf("RendererImposter: NewPBR failed:\n%v", err)
+	}
+
+	// The group is placed at (0, 0, 100), and
+	// a lone drawable at the origin.
+	var g node.Graph
+	var local linear.M4
+	local.Translate(0, 0, 100)
+	sw := NewImposterSwitch(&local)
+	nd := g.Insert(sw, node.Nil)
+	var group []Drawable
+	for _, x := range [...]float32{-1, 1} {
+		var d drawable
+		d.mesh = mesh
+		d.mat = []*Material{mat}
+		var world linear.M4
+		world.Translate(x, 0, 100)
+		d.layout.SetWorld(&world)
+		group = append(group, rend.drawables.insert(d))
+	}
+	lone := rend.drawables.insert(drawable{mesh: mesh, mat: []*Material{mat}})
+	g.Update()
+
+	for _, p := range [...]*ImposterParam{
+		nil,
+		{Group: group, Distance: 10, Views: 8, Size: 64},
+		{Node: nd, Distance: 10, Views: 8, Size: 64},
+		{Node: nd, Group: group, Views: 8, Size: 64},
+		{Node: nd, Group: group, Distance: 10, Views: MaxImposterViews + 1, Size: 64},
+		{Node: nd, Group: group, Distance: 10, Views: 8},
+	} {
+		if _, err := rend.AddImposter(p); err == nil {
+			t.Fatal("Renderer.AddImposter: unexpected nil error")
+		}
+	}
+	imp, err := rend.AddImposter(&ImposterParam{Node: nd, Group: group, Distance: 10, Views: 8, Size: 64})
+	if err != nil {
+		t.Fatalf("Renderer.AddImposter failed:\n%v", err)
+	}
+	if x := rend.ImpostersLen(); x != 1 {
+		t.Fatalf("Renderer.ImpostersLen:\nhave %d\nwant 1", x)
+	}
+	if w, h := rend.imposters.get(imp).atlas.Width(), rend.imposters.get(imp).atlas.Height(); w != 8*64 || h != 64 {
+		t.Fatalf("Renderer.AddImposter: atlas size\nhave %dx%d\nwant %dx64", w, h, 8*64)
+	}
+
+	if b := rend.nextBakes(nil); len(b) != 0 {
+		t.Fatal("Renderer.nextBakes: imposter should not be baked before UpdateImposters")
+	}
+	rend.UpdateImposters(&g)
+	// The quads span [-1, 2]×[0, 1]×{100}.
+	ctr, rad := rend.imposters.get(imp).layout.Bounds()
+	if want := (linear.V3{0.5, 0.5, 100}); ctr != want {
+		t.Fatalf("Renderer.UpdateImposters: layout.Bounds\nhave %v\nwant %v", ctr, want)
+	}
+	if rad <= 1.5 || rad > 1.6 {
+		t.Fatalf("Renderer.UpdateImposters: layout.Bounds radius\nhave %v", rad)
+	}
+	b := rend.nextBakes(nil)
+	if len(b) != 8 {
+		t.Fatalf("Renderer.nextBakes: len\nhave %d\nwant 8", len(b))
+	}
+	for i, x := range b {
+		if x.id != imp || x.rect.X != float32(i)/8 || x.rect.Width != 1.0/8 {
+			t.Fatalf("Renderer.nextBakes: [%d]\nhave %v, %+v", i, x.id, x.rect)
+		}
+	}
+	// View 0 looks down the -Z axis.
+	fwd := linear.V3{b[0].view[0][2], b[0].view[1][2], b[0].view[2][2]}
+	if fwd != (linear.V3{0, 0, -1}) {
+		t.Fatalf("Renderer.nextBakes: view 0 forward\nhave %v\nwant [0 0 -1]", fwd)
+	}
+	if b := rend.nextBakes(nil); len(b) != 0 {
+		t.Fatal("Renderer.nextBakes: imposter should be baked only once")
+	}
+
+	// Moving the group as a whole does not
+	// require a new bake.
+	local.Translate(0, 0, 200)
+	sw.SetLocal(&local)
+	g.Update()
+	for _, id := range group {
+		d := rend.drawables.get(id)
+		w := d.layout.World()
+		w[3][2] = 200
+		d.layout.SetWorld(&w)
+	}
+	rend.UpdateImposters(&g)
+	if b := rend.nextBakes(nil); len(b) != 0 {
+		t.Fatal("Renderer.UpdateImposters: rigid motion should not require a new bake")
+	}
+	// Moving a drawable within the group does.
+	w := rend.drawables.get(group[0]).layout.World()
+	w[3][1] = 1
+	rend.drawables.get(group[0]).layout.SetWorld(&w)
+	rend.UpdateImposters(&g)
+	if b := rend.nextBakes(nil); len(b) != 8 {
+		t.Fatal("Renderer.UpdateImposters: group change should require a new bake")
+	}
+	rend.BakeImposter(imp)
+	if b := rend.nextBakes(nil); len(b) != 8 {
+		t.Fatal("Renderer.BakeImposter: imposter should be baked again")
+	}
+
+	// The camera is at the origin, far from
+	// the group.
+	var l drawList
+	view := linear.I4()
+	l.build(&rend.Renderer, &view, nil)
+	if len(l.imposter) != 1 || l.imposter[0] != imp {
+		t.Fatalf("drawList.build: imposter\nhave %v\nwant [%d]", l.imposter, imp)
+	}
+	if len(l.opaque) != 1 || l.opaque[0].id != lone {
+		t.Fatalf("drawList.build: group should have been replaced\nhave %v", l.opaque)
+	}
+	if !sw.Far() {
+		t.Fatal("ImposterSwitch.Far: should be true")
+	}
+	view.Translate(0, 0, -195)
+	l.build(&rend.Renderer, &view, nil)
+	if len(l.imposter) != 0 || len(l.opaque) != 3 {
+		t.Fatalf("drawList.build: group should have been drawn\nhave %v, %v", l.imposter, l.opaque)
+	}
+	if sw.Far() {
+		t.Fatal("ImposterSwitch.Far: should be false")
+	}
+
+	rend.RemoveImposter(imp)
+	if x := rend.ImpostersLen(); x != 0 {
+		t.Fatalf("Renderer.ImpostersLen:\nhave %d\nwant 0", x)
+	}
+	for _, id := range append(group, lone) {
+		rend.drawables.remove(id)
+	}
+}
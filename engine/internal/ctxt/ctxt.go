@@ -4,9 +4,6 @@
 package ctxt
 
 import (
-	"errors"
-	"strings"
-
 	"gviegas/neo3/driver"
 )
 
@@ -15,37 +12,66 @@ var (
 	gpu      driver.GPU
 	limits   driver.Limits
 	features driver.Features
-)
 
-var errNoDriver = errors.New("ctxt: driver not found")
+	// Options used to open drv, if any.
+	// Reopen uses them to open the same device.
+	drvOpts *driver.Options
+)
 
 // loadDriver attempts to load any driver whose name
 // contains the name string. It is case insensitive.
 // If name is the empty string, then all registered
 // drivers are considered.
+// opts can be nil.
 // It assumes that the drv and gpu vars hold invalid
-// values and replaces both on success.
+// values and replaces both on success, storing a copy
+// of opts in the drvOpts var.
 // The limits and features vars are queried from the
 // new gpu.
-func loadDriver(name string) error {
-	drivers := driver.Drivers()
-	err := errNoDriver
-	name = strings.ToLower(name)
-	for i := range drivers {
-		if !strings.Contains(strings.ToLower(drivers[i].Name()), name) {
-			continue
-		}
-		var u driver.GPU
-		if u, err = drivers[i].Open(); err != nil {
-			continue
-		}
-		drv = drivers[i]
-		gpu = u
-		limits = gpu.Limits()
-		features = gpu.Features()
-		return nil
+func loadDriver(name string, opts *driver.Options) error {
+	d, u, err := driver.Open(name, opts)
+	if err != nil {
+		return err
 	}
-	return err
+	drv = d
+	gpu = u
+	drvOpts = nil
+	if opts != nil {
+		o := *opts
+		drvOpts = &o
+	}
+	limits = gpu.Limits()
+	features = gpu.Features()
+	return nil
+}
+
+// Reopen closes the driver and then opens it again,
+// with the same options (e.g., the same adapter),
+// replacing the gpu, limits and features vars.
+// It is meant to be used for recovery from fatal
+// errors (e.g., device loss). Resources created from
+// the previous gpu must not be used after this call.
+func Reopen() error {
+	drv.Close()
+	u, err := driver.OpenWith(drv, drvOpts)
+	if err != nil {
+		gpu = nil
+		return err
+	}
+	gpu = u
+	limits = gpu.Limits()
+	features = gpu.Features()
+	return nil
+}
+
+// SetGPU replaces the gpu var with u and returns its
+// previous value. The limits and features vars are
+// not changed, so u must be the same GPU (or wrap it).
+// It is meant to be used by tests (e.g., to run the
+// engine through driver/validate).
+func SetGPU(u driver.GPU) driver.GPU {
+	gpu, u = u, gpu
+	return u
 }
 
 // Driver returns the driver.Driver.
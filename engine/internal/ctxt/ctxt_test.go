@@ -3,6 +3,7 @@
 package ctxt
 
 import (
+	"os"
 	"testing"
 )
 
@@ -24,4 +25,9 @@ func TestInit(t *testing.T) {
 			t.Error("unexpected features value")
 		}
 	}
+	if s, ok := os.LookupEnv("NEO3_ADAPTER"); ok {
+		if drvOpts == nil || drvOpts.Adapter != s {
+			t.Error("unexpected drvOpts value")
+		}
+	}
 }
@@ -5,13 +5,21 @@
 package ctxt
 
 import (
+	"os"
+
+	"gviegas/neo3/driver"
 	_ "gviegas/neo3/driver/vk"
 )
 
 func init() {
-	if err := loadDriver("vulkan"); err != nil {
+	// NEO3_ADAPTER can be used to select the device.
+	var opts *driver.Options
+	if s, ok := os.LookupEnv("NEO3_ADAPTER"); ok {
+		opts = &driver.Options{Adapter: s}
+	}
+	if err := loadDriver("vulkan", opts); err != nil {
 		// Try all drivers.
-		if err = loadDriver(""); err != nil {
+		if err = loadDriver("", opts); err != nil {
 			panic(err)
 		}
 	}
@@ -11,11 +11,16 @@
 //	DConstant/DBuffer data alignment | 256 bytes (min)
 //	DConstant/DBuffer data size      | 16 KiB (max)
 //
+// The alignment is driver.DescAlign. The data size of
+// DConstant descriptors is checked against the limit
+// of the device when creating a DrawTable.
+//
 // (the above names refer to the driver package).
 
 package shader
 
 import (
+	"errors"
 	"unsafe"
 
 	"gviegas/neo3/driver"
@@ -43,6 +48,9 @@ const (
 	ldSplrNr    = 8
 	dfgTexNr    = 9
 	dfgSplrNr   = 10
+	probeNr     = 11
+	probeTexNr  = 12
+	probeSplrNr = 13
 
 	drawableNr = 0
 
@@ -57,6 +65,8 @@ const (
 	occSplrNr   = 8
 	emisTexNr   = 9
 	emisSplrNr  = 10
+	lmapTexNr   = 11
+	lmapSplrNr  = 12
 
 	jointNr = 0
 )
@@ -64,11 +74,13 @@ const (
 // These spans are given in number of blocks.
 // Each block has blockSize bytes.
 const (
-	blockSize = 256
+	blockSize = driver.DescAlign
 
 	frameSpan    = (unsafe.Sizeof(FrameLayout{}) + blockSize - 1) &^ (blockSize - 1) / blockSize
 	lightSpan    = (MaxLight*unsafe.Sizeof(LightLayout{}) + blockSize - 1) &^ (blockSize - 1) / blockSize
 	shadowSpan   = (MaxShadow*unsafe.Sizeof(ShadowLayout{}) + blockSize - 1) &^ (blockSize - 1) / blockSize
+	probeSpan    = (MaxProbe*unsafe.Sizeof(ProbeLayout{}) + blockSize - 1) &^ (blockSize - 1) / blockSize
+	globalSpan   = frameSpan + lightSpan + shadowSpan + probeSpan
 	drawableSpan = (unsafe.Sizeof(DrawableLayout{}) + blockSize - 1) &^ (blockSize - 1) / blockSize
 	materialSpan = (unsafe.Sizeof(MaterialLayout{}) + blockSize - 1) &^ (blockSize - 1) / blockSize
 	jointSpan    = (MaxJoint*unsafe.Sizeof(JointLayout{}) + blockSize - 1) &^ (blockSize - 1) / blockSize
@@ -102,8 +114,9 @@ func samplerDesc(nr int, stages driver.Stage) driver.Descriptor {
 }
 
 // newGlobalHeap creates a new driver.DescHeap suitable
-// for frame (FrameLayout), light (LightLayout) and
-// shadow (ShadowLayout) data plus textures/samplers.
+// for frame (FrameLayout), light (LightLayout), shadow
+// (ShadowLayout) and probe (ProbeLayout) data plus
+// textures/samplers.
 //
 // TODO: Texture arrays.
 func newGlobalHeap() (driver.DescHeap, error) {
@@ -120,6 +133,9 @@ func newGlobalHeap() (driver.DescHeap, error) {
 		samplerDesc(ldSplrNr, driver.SFragment),
 		textureDesc(dfgTexNr, driver.SFragment),
 		samplerDesc(dfgSplrNr, driver.SFragment),
+		constantDesc(probeNr, driver.SFragment),
+		textureDesc(probeTexNr, driver.SFragment),
+		samplerDesc(probeSplrNr, driver.SFragment),
 	})
 }
 
@@ -148,6 +164,8 @@ func newMaterialHeap() (driver.DescHeap, error) {
 		samplerDesc(occSplrNr, driver.SFragment),
 		textureDesc(emisTexNr, driver.SFragment),
 		samplerDesc(emisSplrNr, driver.SFragment),
+		textureDesc(lmapTexNr, driver.SFragment),
+		samplerDesc(lmapSplrNr, driver.SFragment),
 	})
 }
 
@@ -214,6 +232,47 @@ type DrawTable struct {
 	// Cached cbuf.Bytes().
 	// Note that it has no offset applied.
 	cs []byte
+	// Texture/sampler pairs last set in each
+	// heap copy. Setting the same pair again
+	// does not update the descriptors.
+	pairs map[pairKey]texSplr
+}
+
+// pairKey identifies the texture descriptor of a
+// texture/sampler pair in a heap copy.
+type pairKey struct{ heap, cpy, nr int }
+
+// texSplr is a texture/sampler pair.
+type texSplr struct {
+	tex  driver.ImageView
+	splr driver.Sampler
+}
+
+// constRanges are the sizes, in bytes, of the buffer
+// ranges of every constant descriptor.
+var constRanges = [...]uintptr{
+	frameSpan * blockSize,
+	lightSpan * blockSize,
+	shadowSpan * blockSize,
+	probeSpan * blockSize,
+	drawableSpan * blockSize,
+	materialSpan * blockSize,
+	jointSpan * blockSize,
+}
+
+// errConstRange means that the device does not support
+// the constant ranges that the shaders require.
+var errConstRange = errors.New("shader: constant range exceeds driver.Limits.MaxDescConstantRange")
+
+// checkConstRange checks that every constant range is
+// within lim.MaxDescConstantRange.
+func checkConstRange(lim *driver.Limits) error {
+	for _, x := range constRanges {
+		if int64(x) > lim.MaxDescConstantRange {
+			return errConstRange
+		}
+	}
+	return nil
 }
 
 // NewDrawTable creates a new descriptor table.
@@ -221,7 +280,7 @@ type DrawTable struct {
 // allocate for a given heap. Currently, the heaps are
 // organized as follows:
 //
-//	global heap   | frame/light/shadow descriptors
+//	global heap   | frame/light/shadow/probe descriptors
 //	drawable heap | drawable descriptors
 //	material heap | material descriptors
 //	joint heap    | joint descriptors
@@ -229,7 +288,12 @@ type DrawTable struct {
 // For constant descriptors that are defined as static
 // arrays in shaders, every heap copy will require
 // enough buffer memory to store the whole array.
+// It fails if any such array does not fit in a single
+// constant range of the device.
 func NewDrawTable(globalN, drawableN, materialN, jointN int) (*DrawTable, error) {
+	if err := checkConstRange(ctxt.Limits()); err != nil {
+		return nil, err
+	}
 	dt, err := newDrawTable()
 	if err != nil {
 		return nil, err
@@ -264,7 +328,7 @@ func (t *DrawTable) SetGraph(cb driver.CmdBuffer, start int, cpy []int) {
 // ConstSize returns the number of bytes consumed by
 // all constant descriptors of t.
 func (t *DrawTable) ConstSize() int {
-	spn0 := t.dt.Heap(GlobalHeap).Len() * int(frameSpan+lightSpan+shadowSpan)
+	spn0 := t.dt.Heap(GlobalHeap).Len() * int(globalSpan)
 	spn1 := t.dt.Heap(DrawableHeap).Len() * int(drawableSpan)
 	spn2 := t.dt.Heap(MaterialHeap).Len() * int(materialSpan)
 	spn3 := t.dt.Heap(JointHeap).Len() * int(jointSpan)
@@ -301,8 +365,12 @@ func (t *DrawTable) SetConstBuf(buf driver.Buffer, off int64) (driver.Buffer, in
 		//	0 | FrameLayout
 		//	1 | [MaxLight]LightLayout
 		//	2 | [MaxShadow]ShadowLayout
+		//	3 | [MaxProbe]ProbeLayout
+		// Updates are batched since heaps
+		// may have thousands of copies.
 		dh = t.dt.Heap(GlobalHeap)
 		n = dh.Len()
+		dh.BeginUpdate()
 		for i := 0; i < n; i++ {
 			sz[0] = int64(frameSpan * blockSize)
 			dh.SetBuffer(i, frameNr, 0, buf, off, sz)
@@ -313,7 +381,11 @@ func (t *DrawTable) SetConstBuf(buf driver.Buffer, off int64) (driver.Buffer, in
 			sz[0] = int64(shadowSpan * blockSize)
 			dh.SetBuffer(i, shadowNr, 0, buf, off, sz)
 			off[0] += sz[0]
+			sz[0] = int64(probeSpan * blockSize)
+			dh.SetBuffer(i, probeNr, 0, buf, off, sz)
+			off[0] += sz[0]
 		}
+		dh.EndUpdate()
 
 		// Drawable heap constants:
 		//	0 | DrawableLayout
@@ -321,10 +393,12 @@ func (t *DrawTable) SetConstBuf(buf driver.Buffer, off int64) (driver.Buffer, in
 		n = dh.Len()
 		t.coff[DrawableHeap] = off[0]
 		sz[0] = int64(drawableSpan * blockSize)
+		dh.BeginUpdate()
 		for i := 0; i < n; i++ {
 			dh.SetBuffer(i, drawableNr, 0, buf, off, sz)
 			off[0] += sz[0]
 		}
+		dh.EndUpdate()
 
 		// Material heap constants:
 		//	0 | MaterialLayout
@@ -332,10 +406,12 @@ func (t *DrawTable) SetConstBuf(buf driver.Buffer, off int64) (driver.Buffer, in
 		n = dh.Len()
 		t.coff[MaterialHeap] = off[0]
 		sz[0] = int64(materialSpan * blockSize)
+		dh.BeginUpdate()
 		for i := 0; i < n; i++ {
 			dh.SetBuffer(i, materialNr, 0, buf, off, sz)
 			off[0] += sz[0]
 		}
+		dh.EndUpdate()
 
 		// Joint heap constants:
 		//	0 | [MaxJoint]JointLayout
@@ -343,10 +419,12 @@ func (t *DrawTable) SetConstBuf(buf driver.Buffer, off int64) (driver.Buffer, in
 		n = dh.Len()
 		t.coff[JointHeap] = off[0]
 		sz[0] = int64(jointSpan * blockSize)
+		dh.BeginUpdate()
 		for i := 0; i < n; i++ {
 			dh.SetBuffer(i, jointNr, 0, buf, off, sz)
 			off[0] += sz[0]
 		}
+		dh.EndUpdate()
 	}
 
 	pbuf := t.cbuf
@@ -362,81 +440,96 @@ func (t *DrawTable) SetConstBuf(buf driver.Buffer, off int64) (driver.Buffer, in
 // tex.Image() must support driver.UShaderSample.
 // splr must support depth comparison.
 func (t *DrawTable) SetShadowMap(cpy int, tex driver.ImageView, splr driver.Sampler) {
-	t.validateTexSplr(GlobalHeap, cpy, tex, splr)
-	t.dt.Heap(GlobalHeap).SetImage(cpy, shdwTexNr, 0, []driver.ImageView{tex}, nil)
-	t.dt.Heap(GlobalHeap).SetSampler(cpy, shdwSplrNr, 0, []driver.Sampler{splr})
+	t.setTexSplr(GlobalHeap, cpy, shdwTexNr, shdwSplrNr, tex, splr)
 }
 
 // SetIrradiance sets a diffuse irradiance texture/sampler
 // pair in the global heap.
 // tex.Image() must support driver.UShaderSample.
 func (t *DrawTable) SetIrradiance(cpy int, tex driver.ImageView, splr driver.Sampler) {
-	t.validateTexSplr(GlobalHeap, cpy, tex, splr)
-	t.dt.Heap(GlobalHeap).SetImage(cpy, irradTexNr, 0, []driver.ImageView{tex}, nil)
-	t.dt.Heap(GlobalHeap).SetSampler(cpy, irradSplrNr, 0, []driver.Sampler{splr})
+	t.setTexSplr(GlobalHeap, cpy, irradTexNr, irradSplrNr, tex, splr)
 }
 
 // SetLD sets a specular LD texture/sampler pair in the
 // global heap.
 // tex.Image() must support driver.UShaderSample.
 func (t *DrawTable) SetLD(cpy int, tex driver.ImageView, splr driver.Sampler) {
-	t.validateTexSplr(GlobalHeap, cpy, tex, splr)
-	t.dt.Heap(GlobalHeap).SetImage(cpy, ldTexNr, 0, []driver.ImageView{tex}, nil)
-	t.dt.Heap(GlobalHeap).SetSampler(cpy, ldSplrNr, 0, []driver.Sampler{splr})
+	t.setTexSplr(GlobalHeap, cpy, ldTexNr, ldSplrNr, tex, splr)
 }
 
 // SetDFG sets a specular DFG texture/sampler pair in
 // the global heap.
 // tex.Image() must support driver.UShaderSample.
 func (t *DrawTable) SetDFG(cpy int, tex driver.ImageView, splr driver.Sampler) {
-	t.validateTexSplr(GlobalHeap, cpy, tex, splr)
-	t.dt.Heap(GlobalHeap).SetImage(cpy, dfgTexNr, 0, []driver.ImageView{tex}, nil)
-	t.dt.Heap(GlobalHeap).SetSampler(cpy, dfgSplrNr, 0, []driver.Sampler{splr})
+	t.setTexSplr(GlobalHeap, cpy, dfgTexNr, dfgSplrNr, tex, splr)
+}
+
+// SetProbeMap sets a reflection probe texture/sampler
+// pair in the global heap.
+// tex must be a cube array view with MaxProbe cubes,
+// indexed as the array returned by t.Probe.
+// tex.Image() must support driver.UShaderSample.
+func (t *DrawTable) SetProbeMap(cpy int, tex driver.ImageView, splr driver.Sampler) {
+	t.setTexSplr(GlobalHeap, cpy, probeTexNr, probeSplrNr, tex, splr)
 }
 
 // SetBaseColor sets a base color texture/sampler pair in
 // the material heap.
 // tex.Image() must support driver.UShaderSample.
 func (t *DrawTable) SetBaseColor(cpy int, tex driver.ImageView, splr driver.Sampler) {
-	t.validateTexSplr(MaterialHeap, cpy, tex, splr)
-	t.dt.Heap(MaterialHeap).SetImage(cpy, colorTexNr, 0, []driver.ImageView{tex}, nil)
-	t.dt.Heap(MaterialHeap).SetSampler(cpy, colorSplrNr, 0, []driver.Sampler{splr})
+	t.setTexSplr(MaterialHeap, cpy, colorTexNr, colorSplrNr, tex, splr)
 }
 
 // SetMetalRough sets a metallic-roughness texture/sampler
 // pair in the material heap.
 // tex.Image() must support driver.UShaderSample.
 func (t *DrawTable) SetMetalRough(cpy int, tex driver.ImageView, splr driver.Sampler) {
-	t.validateTexSplr(MaterialHeap, cpy, tex, splr)
-	t.dt.Heap(MaterialHeap).SetImage(cpy, metalTexNr, 0, []driver.ImageView{tex}, nil)
-	t.dt.Heap(MaterialHeap).SetSampler(cpy, metalSplrNr, 0, []driver.Sampler{splr})
+	t.setTexSplr(MaterialHeap, cpy, metalTexNr, metalSplrNr, tex, splr)
 }
 
 // SetNormalMap sets a normal texture/sampler pair in the
 // material heap.
 // tex.Image() must support driver.UShaderSample.
 func (t *DrawTable) SetNormalMap(cpy int, tex driver.ImageView, splr driver.Sampler) {
-	t.validateTexSplr(MaterialHeap, cpy, tex, splr)
-	t.dt.Heap(MaterialHeap).SetImage(cpy, normTexNr, 0, []driver.ImageView{tex}, nil)
-	t.dt.Heap(MaterialHeap).SetSampler(cpy, normSplrNr, 0, []driver.Sampler{splr})
+	t.setTexSplr(MaterialHeap, cpy, normTexNr, normSplrNr, tex, splr)
 }
 
 // SetOcclusionMap sets an occlusion texture/sampler pair
 // in the material heap.
 // tex.Image() must support driver.UShaderSample.
 func (t *DrawTable) SetOcclusionMap(cpy int, tex driver.ImageView, splr driver.Sampler) {
-	t.validateTexSplr(MaterialHeap, cpy, tex, splr)
-	t.dt.Heap(MaterialHeap).SetImage(cpy, occTexNr, 0, []driver.ImageView{tex}, nil)
-	t.dt.Heap(MaterialHeap).SetSampler(cpy, occSplrNr, 0, []driver.Sampler{splr})
+	t.setTexSplr(MaterialHeap, cpy, occTexNr, occSplrNr, tex, splr)
 }
 
 // SetEmissiveMap sets an emissive texture/sampler pair in
 // the material heap.
 // tex.Image() must support driver.UShaderSample.
 func (t *DrawTable) SetEmissiveMap(cpy int, tex driver.ImageView, splr driver.Sampler) {
-	t.validateTexSplr(MaterialHeap, cpy, tex, splr)
-	t.dt.Heap(MaterialHeap).SetImage(cpy, emisTexNr, 0, []driver.ImageView{tex}, nil)
-	t.dt.Heap(MaterialHeap).SetSampler(cpy, emisSplrNr, 0, []driver.Sampler{splr})
+	t.setTexSplr(MaterialHeap, cpy, emisTexNr, emisSplrNr, tex, splr)
+}
+
+// SetLightmap sets a lightmap texture/sampler pair in
+// the material heap.
+// tex.Image() must support driver.UShaderSample.
+func (t *DrawTable) SetLightmap(cpy int, tex driver.ImageView, splr driver.Sampler) {
+	t.setTexSplr(MaterialHeap, cpy, lmapTexNr, lmapSplrNr, tex, splr)
+}
+
+// setTexSplr sets the texture/sampler pair identified
+// by the descriptors texNr and splrNr in the given
+// heap copy, unless tex and splr are already set.
+func (t *DrawTable) setTexSplr(heap, cpy, texNr, splrNr int, tex driver.ImageView, splr driver.Sampler) {
+	t.validateTexSplr(heap, cpy, tex, splr)
+	key := pairKey{heap, cpy, texNr}
+	if t.pairs[key] == (texSplr{tex, splr}) {
+		return
+	}
+	if t.pairs == nil {
+		t.pairs = make(map[pairKey]texSplr)
+	}
+	t.pairs[key] = texSplr{tex, splr}
+	t.dt.Heap(heap).SetImage(cpy, texNr, 0, []driver.ImageView{tex}, nil)
+	t.dt.Heap(heap).SetSampler(cpy, splrNr, 0, []driver.Sampler{splr})
 }
 
 // Frame returns a pointer to GPU memory mapping to a
@@ -447,7 +540,7 @@ func (t *DrawTable) SetEmissiveMap(cpy int, tex driver.ImageView, splr driver.Sa
 // returned by this method.
 func (t *DrawTable) Frame(cpy int) *FrameLayout {
 	t.validateHeapCopy(GlobalHeap, cpy)
-	off := t.coff[GlobalHeap] + int64(frameSpan+lightSpan+shadowSpan)*blockSize*int64(cpy)
+	off := t.coff[GlobalHeap] + int64(globalSpan)*blockSize*int64(cpy)
 	s := t.cs[off:]
 	return (*FrameLayout)(unsafe.Pointer(unsafe.SliceData(s)))
 }
@@ -460,7 +553,7 @@ func (t *DrawTable) Frame(cpy int) *FrameLayout {
 // returned by this method.
 func (t *DrawTable) Light(cpy int) *[MaxLight]LightLayout {
 	t.validateHeapCopy(GlobalHeap, cpy)
-	off := t.coff[GlobalHeap] + int64(frameSpan+lightSpan+shadowSpan)*blockSize*int64(cpy)
+	off := t.coff[GlobalHeap] + int64(globalSpan)*blockSize*int64(cpy)
 	off += int64(frameSpan) * blockSize
 	s := t.cs[off:]
 	return (*[MaxLight]LightLayout)(unsafe.Pointer(unsafe.SliceData(s)))
@@ -474,12 +567,26 @@ func (t *DrawTable) Light(cpy int) *[MaxLight]LightLayout {
 // returned by this method.
 func (t *DrawTable) Shadow(cpy int) *[MaxShadow]ShadowLayout {
 	t.validateHeapCopy(GlobalHeap, cpy)
-	off := t.coff[GlobalHeap] + int64(frameSpan+lightSpan+shadowSpan)*blockSize*int64(cpy)
+	off := t.coff[GlobalHeap] + int64(globalSpan)*blockSize*int64(cpy)
 	off += int64(frameSpan+lightSpan) * blockSize
 	s := t.cs[off:]
 	return (*[MaxShadow]ShadowLayout)(unsafe.Pointer(unsafe.SliceData(s)))
 }
 
+// Probe returns a pointer to GPU memory mapping to a
+// given ProbeLayout array of the global heap.
+// A valid constant buffer must be set when this method
+// is called.
+// Calling t.SetConstBuf invalidates any pointers
+// returned by this method.
+func (t *DrawTable) Probe(cpy int) *[MaxProbe]ProbeLayout {
+	t.validateHeapCopy(GlobalHeap, cpy)
+	off := t.coff[GlobalHeap] + int64(globalSpan)*blockSize*int64(cpy)
+	off += int64(frameSpan+lightSpan+shadowSpan) * blockSize
+	s := t.cs[off:]
+	return (*[MaxProbe]ProbeLayout)(unsafe.Pointer(unsafe.SliceData(s)))
+}
+
 // Drawable returns a pointer to GPU memory mapping to a
 // given DrawableLayout of the drawable heap.
 // A valid constant buffer must be set when this method
@@ -23,7 +23,7 @@ func (tb *DrawTable) check(globalN, drawableN, materialN, jointN int, t *testing
 		i, n int
 		spn  uintptr
 	}{
-		{"GlobalHeap", GlobalHeap, globalN, frameSpan + lightSpan + shadowSpan},
+		{"GlobalHeap", GlobalHeap, globalN, frameSpan + lightSpan + shadowSpan + probeSpan},
 		{"DrawableHeap", DrawableHeap, drawableN, drawableSpan},
 		{"MaterialHeap", MaterialHeap, materialN, materialSpan},
 		{"JointHeap", JointHeap, jointN, jointSpan},
@@ -83,6 +83,20 @@ func TestNewDrawTable(t *testing.T) {
 	}
 }
 
+func TestCheckConstRange(t *testing.T) {
+	if err := checkConstRange(ctxt.Limits()); err != nil {
+		t.Fatalf("checkConstRange: device limits\nhave %v\nwant nil", err)
+	}
+	lim := driver.Limits{MaxDescConstantRange: 16384}
+	if err := checkConstRange(&lim); err != nil {
+		t.Fatalf("checkConstRange: 16 KiB\nhave %v\nwant nil", err)
+	}
+	lim.MaxDescConstantRange = blockSize
+	if err := checkConstRange(&lim); err != errConstRange {
+		t.Fatalf("checkConstRange: %d bytes\nhave %v\nwant %v", blockSize, err, errConstRange)
+	}
+}
+
 func TestSetConstBuf(t *testing.T) {
 	const ng, nd, nm, nj = 1, 1, 1, 1
 	tb, _ := NewDrawTable(ng, nd, nm, nj)
@@ -136,7 +150,7 @@ func TestSetConstBuf(t *testing.T) {
 		}
 
 		var goff, doff, moff, joff int64
-		doff = int64(frameSpan+lightSpan+shadowSpan) * blockSize * int64(x.ng)
+		doff = int64(frameSpan+lightSpan+shadowSpan+probeSpan) * blockSize * int64(x.ng)
 		moff = doff + int64(drawableSpan)*blockSize*int64(x.nd)
 		joff = moff + int64(materialSpan)*blockSize*int64(x.nm)
 
@@ -171,6 +185,7 @@ func TestConstWrite(t *testing.T) {
 		f []FrameLayout,
 		l [][MaxLight]LightLayout,
 		s [][MaxShadow]ShadowLayout,
+		p [][MaxProbe]ProbeLayout,
 		d []DrawableLayout,
 		m []MaterialLayout,
 		j [][MaxJoint]JointLayout,
@@ -181,6 +196,7 @@ func TestConstWrite(t *testing.T) {
 		f = make([]FrameLayout, ng)
 		l = make([][MaxLight]LightLayout, ng)
 		s = make([][MaxShadow]ShadowLayout, ng)
+		p = make([][MaxProbe]ProbeLayout, ng)
 		d = make([]DrawableLayout, nd)
 		m = make([]MaterialLayout, nm)
 		j = make([][MaxJoint]JointLayout, nj)
@@ -207,6 +223,14 @@ func TestConstWrite(t *testing.T) {
 				}
 			}
 		}
+		for i := range p {
+			for j := range p[i] {
+				for k := range p[i][j] {
+					x += y
+					p[i][j][k] = x
+				}
+			}
+		}
 		for i := range d {
 			for j := range d[i] {
 				x += y
@@ -230,7 +254,7 @@ func TestConstWrite(t *testing.T) {
 
 		return
 	}
-	f, l, s, d, m, j := dummyData(3, 15, 15, 15)
+	f, l, s, p, d, m, j := dummyData(3, 15, 15, 15)
 
 	for _, x := range [...]struct{ ng, nd, nm, nj int }{
 		{ng: 1},
@@ -293,6 +317,7 @@ func TestConstWrite(t *testing.T) {
 				*tb.Frame(i) = f[i]
 				*tb.Light(i) = l[i]
 				*tb.Shadow(i) = s[i]
+				*tb.Probe(i) = p[i]
 			}
 			for i := 0; i < x.nd; i++ {
 				*tb.Drawable(i) = d[i]
@@ -312,6 +337,9 @@ func TestConstWrite(t *testing.T) {
 				for j := 0; j < int(MaxShadow); j++ {
 					checkSlicesT(tb.Shadow(i)[j][:], s[i][j][:], t, fmt.Sprintf("DrawTable.Shadow(%d)[%d]", i, j))
 				}
+				for j := 0; j < int(MaxProbe); j++ {
+					checkSlicesT(tb.Probe(i)[j][:], p[i][j][:], t, fmt.Sprintf("DrawTable.Probe(%d)[%d]", i, j))
+				}
 			}
 			for i := 0; i < x.nd; i++ {
 				checkSlicesT(tb.Drawable(i)[:], d[i][:], t, fmt.Sprintf("DrawTable.Drawable(%d)", i))
@@ -395,6 +423,17 @@ func TestGlobalWrite(t *testing.T) {
 	s = "DrawTable.Shadow(0)[0][%d:]"
 	checkSlicesT(tb.Shadow(0)[0][:], []float32{*(*float32)(unsafe.Pointer(&unused_))}, t, fmt.Sprintf(s, 0))
 	checkSlicesT(tb.Shadow(0)[0][16:], unsafe.Slice((*float32)(unsafe.Pointer(&shdw)), 16), t, fmt.Sprintf(s, 16))
+
+	pos := linear.V3{1, 2, 3}
+	rad := float32(8)
+
+	tb.Probe(0)[0].SetPosition(&pos)
+	tb.Probe(0)[MaxProbe-1].SetRadius(rad)
+
+	s = "DrawTable.Probe(0)[0][%d:]"
+	checkSlicesT(tb.Probe(0)[0][:], pos[:], t, fmt.Sprintf(s, 0))
+	s = fmt.Sprintf("DrawTable.Probe(0)[%d]%s", MaxProbe-1, "[%d:]")
+	checkSlicesT(tb.Probe(0)[MaxProbe-1][7:], []float32{rad}, t, fmt.Sprintf(s, 7))
 }
 
 func TestGlobalWriteN(t *testing.T) {
@@ -898,6 +937,7 @@ func TestSetTSFail(t *testing.T) {
 		{"ShadowMap", (*DrawTable).SetShadowMap},
 		{"Irradiance", (*DrawTable).SetIrradiance},
 		{"LD", (*DrawTable).SetLD},
+		{"ProbeMap", (*DrawTable).SetProbeMap},
 		{"DFG", (*DrawTable).SetDFG},
 		{"BaseColor", (*DrawTable).SetBaseColor},
 		{"MetalRough", (*DrawTable).SetMetalRough},
@@ -963,6 +1003,7 @@ func TestConstFail(t *testing.T) {
 		{"Frame", func(cpy int) { tb.Frame(cpy) }},
 		{"Light", func(cpy int) { tb.Light(cpy) }},
 		{"Shadow", func(cpy int) { tb.Shadow(cpy) }},
+		{"Probe", func(cpy int) { tb.Probe(cpy) }},
 		{"Drawable", func(cpy int) { tb.Drawable(cpy) }},
 		{"Material", func(cpy int) { tb.Material(cpy) }},
 		{"Joint", func(cpy int) { tb.Joint(cpy) }},
@@ -1069,3 +1110,78 @@ func TestSetGraphFail(t *testing.T) {
 		})
 	}
 }
+
+// countTable counts image/sampler updates made
+// through a driver.DescTable.
+type countTable struct {
+	driver.DescTable
+	n *int
+}
+
+func (t countTable) Heap(i int) driver.DescHeap { return countHeap{t.DescTable.Heap(i), t.n} }
+
+type countHeap struct {
+	driver.DescHeap
+	n *int
+}
+
+func (h countHeap) SetImage(cpy, nr, start int, iv []driver.ImageView, plane []int) {
+	*h.n++
+	h.DescHeap.SetImage(cpy, nr, start, iv, plane)
+}
+
+func (h countHeap) SetSampler(cpy, nr, start int, splr []driver.Sampler) {
+	*h.n++
+	h.DescHeap.SetSampler(cpy, nr, start, splr)
+}
+
+func TestSetTSRedundant(t *testing.T) {
+	const ng, nd, nm, nj = 2, 1, 1, 1
+	tb, _ := NewDrawTable(ng, nd, nm, nj)
+	tb.check(ng, nd, nm, nj, t)
+	defer tb.Free()
+	var n int
+	dt := tb.dt
+	tb.dt = countTable{dt, &n}
+	defer func() { tb.dt = dt }()
+
+	var ivs [2]driver.ImageView
+	for i := range ivs {
+		img, err := ctxt.GPU().NewImage(driver.RGBA8Unorm, driver.Dim3D{Width: 16, Height: 16},
+			1, 1, 1, driver.UShaderSample)
+		if err != nil {
+			t.Fatalf("driver.GPU.NewImage failed:\n%v", err)
+		}
+		defer img.Destroy()
+		if ivs[i], err = img.NewView(driver.IView2D, 0, 1, 0, 1); err != nil {
+			t.Fatalf("driver.Image.NewView failed:\n%v", err)
+		}
+		defer ivs[i].Destroy()
+	}
+	splr, err := ctxt.GPU().NewSampler(&driver.Sampling{MaxAniso: 1, MaxLOD: 1})
+	if err != nil {
+		t.Fatalf("driver.GPU.NewSampler failed:\n%v", err)
+	}
+	defer splr.Destroy()
+
+	for _, x := range [...]struct {
+		f    func(*DrawTable, int, driver.ImageView, driver.Sampler)
+		cpy  int
+		iv   driver.ImageView
+		want int
+	}{
+		{(*DrawTable).SetBaseColor, 0, ivs[0], 2},
+		{(*DrawTable).SetBaseColor, 0, ivs[0], 2},
+		{(*DrawTable).SetNormalMap, 0, ivs[0], 4},
+		{(*DrawTable).SetBaseColor, 0, ivs[1], 6},
+		{(*DrawTable).SetShadowMap, 1, ivs[1], 8},
+		{(*DrawTable).SetShadowMap, 0, ivs[1], 10},
+		{(*DrawTable).SetShadowMap, 1, ivs[1], 10},
+		{(*DrawTable).SetBaseColor, 0, ivs[1], 10},
+	} {
+		x.f(tb, x.cpy, x.iv, splr)
+		if n != x.want {
+			t.Fatalf("DrawTable.Set*: descriptor updates\nhave %d\nwant %d", n, x.want)
+		}
+	}
+}
@@ -50,7 +50,9 @@ func copyM3(dst []float32, m *linear.M3) {
 //	[53]    | viewport's height
 //	[54]    | viewport's near plane
 //	[55]    | viewport's far plane
-//	[56:64] | (unused)
+//	[56]    | color target scale
+//	[57:60] | (unused)
+//	[60:64] | world space clip plane
 //
 // NOTE: This layout is likely to change.
 type FrameLayout [64]float32
@@ -125,6 +127,25 @@ func (l *FrameLayout) Bounds() driver.Viewport {
 	}
 }
 
+// SetTargetScale sets the color target scale.
+// Radiance is multiplied by this value when written
+// to the color target, and divided by it when read
+// back.
+func (l *FrameLayout) SetTargetScale(s float32) { l[56] = s }
+
+// TargetScale returns the color target scale.
+func (l *FrameLayout) TargetScale() float32 { return l[56] }
+
+// SetClipPlane sets the clip plane.
+// The zero plane clips nothing.
+func (l *FrameLayout) SetClipPlane(p *linear.V4) { copy(l[60:64], p[:]) }
+
+// ClipPlane returns the clip plane.
+func (l *FrameLayout) ClipPlane() (p linear.V4) {
+	copy(p[:], l[60:64])
+	return
+}
+
 // LightLayout is the layout of light data.
 // It is defined as follows:
 //
@@ -262,10 +283,22 @@ func (l *ShadowLayout) Shadow() (m linear.M4) {
 //	[0:16]  | world matrix
 //	[16:28] | normal matrix (padded columns)
 //	[28]    | ID
-//	[29]    | ???
-//	[30]    | ???
-//	[31]    | ???
-//	[32:63] | (unused)
+//	[29]    | first reflection probe
+//	[30]    | second reflection probe
+//	[31]    | reflection probe blend weight
+//	[32:48] | previous world matrix
+//	[48:51] | position decode offset
+//	[51]    | quantization flags
+//	[52:55] | position decode scale
+//	[55]    | (unused)
+//	[56:58] | texture coordinate set 0 decode offset
+//	[58:60] | texture coordinate set 0 decode scale
+//	[60:62] | texture coordinate set 1 decode offset
+//	[62:64] | texture coordinate set 1 decode scale
+//
+// The decode constants refer to the primitive being
+// drawn. A quantized value q is decoded as
+// offset + q * scale.
 //
 // NOTE: This layout is likely to change.
 type DrawableLayout [64]float32
@@ -301,6 +334,816 @@ func (l *DrawableLayout) ID() uint32 {
 	return id
 }
 
+// NoProbe indicates the absence of a reflection probe.
+const NoProbe = ^uint32(0)
+
+// SetProbes sets the reflection probes that affect
+// the drawable.
+// p0 and p1 are indices into the probe array, or
+// NoProbe. w is the weight of p0 when blending the
+// two (p1 has weight 1-w).
+func (l *DrawableLayout) SetProbes(p0, p1 uint32, w float32) {
+	l[29] = *(*float32)(unsafe.Pointer(&p0))
+	l[30] = *(*float32)(unsafe.Pointer(&p1))
+	l[31] = w
+}
+
+// Probes returns the reflection probes that affect
+// the drawable.
+func (l *DrawableLayout) Probes() (p0, p1 uint32, w float32) {
+	p0 = *(*uint32)(unsafe.Pointer(&l[29]))
+	p1 = *(*uint32)(unsafe.Pointer(&l[30]))
+	w = l[31]
+	return
+}
+
+// SetPrevWorld sets the world matrix of the previous
+// frame.
+// It is used to compute per-object motion.
+func (l *DrawableLayout) SetPrevWorld(m *linear.M4) { copyM4(l[32:48], m) }
+
+// PrevWorld returns the previous world matrix.
+func (l *DrawableLayout) PrevWorld() (m linear.M4) {
+	for i := range m {
+		copy(m[i][:], l[32+4*i:32+4*i+4])
+	}
+	return
+}
+
+// Quantization flags.
+const (
+	// Position is stored as unsigned normalized
+	// 16-bit values within the primitive's bounds.
+	QuantPosition uint32 = 1 << iota
+	// Normal is stored as signed normalized 16-bit
+	// octahedral coordinates.
+	QuantNormal
+	// Texture coordinate set 0 is stored as unsigned
+	// normalized 16-bit values within its range.
+	QuantTexCoord0
+	// Texture coordinate set 1 is stored as unsigned
+	// normalized 16-bit values within its range.
+	QuantTexCoord1
+)
+
+// SetQuant sets the quantization flags.
+func (l *DrawableLayout) SetQuant(flags uint32) { l[51] = *(*float32)(unsafe.Pointer(&flags)) }
+
+// Quant returns the quantization flags.
+func (l *DrawableLayout) Quant() uint32 { return *(*uint32)(unsafe.Pointer(&l[51])) }
+
+// SetPosDecode sets the position decode constants.
+func (l *DrawableLayout) SetPosDecode(off, scale *linear.V3) {
+	copy(l[48:51], off[:])
+	copy(l[52:55], scale[:])
+}
+
+// PosDecode returns the position decode constants.
+func (l *DrawableLayout) PosDecode() (off, scale linear.V3) {
+	copy(off[:], l[48:51])
+	copy(scale[:], l[52:55])
+	return
+}
+
+// SetUVDecode sets the decode constants of the given
+// texture coordinate set (either 0 or 1).
+func (l *DrawableLayout) SetUVDecode(set int, off, scale *[2]float32) {
+	i := 56 + set*4
+	copy(l[i:i+2], off[:])
+	copy(l[i+2:i+4], scale[:])
+}
+
+// UVDecode returns the decode constants of the given
+// texture coordinate set (either 0 or 1).
+func (l *DrawableLayout) UVDecode(set int) (off, scale [2]float32) {
+	i := 56 + set*4
+	copy(off[:], l[i:i+2])
+	copy(scale[:], l[i+2:i+4])
+	return
+}
+
+// ProbeLayout is the layout of reflection probe data.
+// It is defined as follows:
+//
+//	[0:3]   | capture position
+//	[3]     | projection
+//	[4:7]   | box's minimum
+//	[7]     | sphere's radius
+//	[8:11]  | box's maximum
+//	[11]    | mip level count
+//	[12:16] | (unused)
+//
+// The box is used for ProbeBox projection and the
+// radius for ProbeSphere projection. Spheres are
+// centered at the capture position.
+type ProbeLayout [16]float32
+
+// Probe projections.
+const (
+	ProbeBox uint32 = iota
+	ProbeSphere
+)
+
+// SetPosition sets the capture position.
+func (l *ProbeLayout) SetPosition(p *linear.V3) { copy(l[:3], p[:]) }
+
+// Position returns the capture position.
+func (l *ProbeLayout) Position() (p linear.V3) {
+	copy(p[:], l[:3])
+	return
+}
+
+// SetProj sets the projection (ProbeBox or
+// ProbeSphere).
+func (l *ProbeLayout) SetProj(proj uint32) { l[3] = *(*float32)(unsafe.Pointer(&proj)) }
+
+// Proj returns the projection.
+func (l *ProbeLayout) Proj() uint32 {
+	proj := *(*uint32)(unsafe.Pointer(&l[3]))
+	return proj
+}
+
+// SetBox sets the box's bounds, in world space.
+func (l *ProbeLayout) SetBox(min, max *linear.V3) {
+	copy(l[4:7], min[:])
+	copy(l[8:11], max[:])
+}
+
+// Box returns the box's bounds.
+func (l *ProbeLayout) Box() (min, max linear.V3) {
+	copy(min[:], l[4:7])
+	copy(max[:], l[8:11])
+	return
+}
+
+// SetRadius sets the sphere's radius.
+func (l *ProbeLayout) SetRadius(r float32) { l[7] = r }
+
+// Radius returns the sphere's radius.
+func (l *ProbeLayout) Radius() float32 { return l[7] }
+
+// SetLevels sets the number of prefiltered mip levels.
+func (l *ProbeLayout) SetLevels(n int) { l[11] = float32(n) }
+
+// Levels returns the number of prefiltered mip levels.
+func (l *ProbeLayout) Levels() int { return int(l[11]) }
+
+// SSRLayout is the layout of screen-space reflection
+// parameters.
+// It is defined as follows:
+//
+//	[0]     | maximum number of ray march steps
+//	[1]     | depth thickness
+//	[2]     | maximum roughness
+//	[3]     | Hi-Z level count
+//	[4]     | blur level count
+//	[5]     | edge fade
+//	[6:16]  | (unused)
+type SSRLayout [16]float32
+
+// SetSteps sets the maximum number of ray march steps.
+func (l *SSRLayout) SetSteps(n int) {
+	x := uint32(n)
+	l[0] = *(*float32)(unsafe.Pointer(&x))
+}
+
+// Steps returns the maximum number of ray march steps.
+func (l *SSRLayout) Steps() int {
+	x := *(*uint32)(unsafe.Pointer(&l[0]))
+	return int(x)
+}
+
+// SetThickness sets the depth thickness assumed for
+// surfaces hit by rays, in view space units.
+func (l *SSRLayout) SetThickness(t float32) { l[1] = t }
+
+// Thickness returns the depth thickness.
+func (l *SSRLayout) Thickness() float32 { return l[1] }
+
+// SetMaxRough sets the roughness above which
+// reflections fall back to probes entirely.
+func (l *SSRLayout) SetMaxRough(r float32) { l[2] = r }
+
+// MaxRough returns the maximum roughness.
+func (l *SSRLayout) MaxRough() float32 { return l[2] }
+
+// SetLevels sets the number of Hi-Z and blur levels.
+func (l *SSRLayout) SetLevels(hiz, blur int) {
+	l[3] = float32(hiz)
+	l[4] = float32(blur)
+}
+
+// Levels returns the number of Hi-Z and blur levels.
+func (l *SSRLayout) Levels() (hiz, blur int) { return int(l[3]), int(l[4]) }
+
+// SetEdgeFade sets the fraction of the screen, from
+// its borders inwards, over which reflections fade.
+func (l *SSRLayout) SetEdgeFade(f float32) { l[5] = f }
+
+// EdgeFade returns the edge fade.
+func (l *SSRLayout) EdgeFade() float32 { return l[5] }
+
+// GradeLayout is the layout of color grading
+// parameters.
+// It is defined as follows:
+//
+//	[0:12]  | white balance matrix (3 vec4 columns)
+//	[12]    | exposure scale
+//	[13]    | contrast
+//	[14]    | saturation
+//	[15]    | LUT size (zero if no LUT)
+//	[16:19] | LUT domain min
+//	[19]    | (unused)
+//	[20:23] | LUT domain max
+//	[23]    | (unused)
+type GradeLayout [24]float32
+
+// SetWhiteBalance sets the white balance matrix.
+// It transforms linear RGB colors.
+func (l *GradeLayout) SetWhiteBalance(m *linear.M3) {
+	for i := range m {
+		copy(l[4*i:4*i+3], m[i][:])
+	}
+}
+
+// WhiteBalance returns the white balance matrix.
+func (l *GradeLayout) WhiteBalance() (m linear.M3) {
+	for i := range m {
+		copy(m[i][:], l[4*i:4*i+3])
+	}
+	return
+}
+
+// SetExposure sets the exposure scale.
+// Colors are multiplied by this value.
+func (l *GradeLayout) SetExposure(x float32) { l[12] = x }
+
+// Exposure returns the exposure scale.
+func (l *GradeLayout) Exposure() float32 { return l[12] }
+
+// SetContrast sets the contrast.
+func (l *GradeLayout) SetContrast(x float32) { l[13] = x }
+
+// Contrast returns the contrast.
+func (l *GradeLayout) Contrast() float32 { return l[13] }
+
+// SetSaturation sets the saturation.
+func (l *GradeLayout) SetSaturation(x float32) { l[14] = x }
+
+// Saturation returns the saturation.
+func (l *GradeLayout) Saturation() float32 { return l[14] }
+
+// SetLUT sets the LUT size and input domain.
+// A size of zero means that no LUT is used.
+func (l *GradeLayout) SetLUT(size int, min, max *linear.V3) {
+	l[15] = float32(size)
+	copy(l[16:19], min[:])
+	copy(l[20:23], max[:])
+}
+
+// LUT returns the LUT size and input domain.
+func (l *GradeLayout) LUT() (size int, min, max linear.V3) {
+	copy(min[:], l[16:19])
+	copy(max[:], l[20:23])
+	return int(l[15]), min, max
+}
+
+// ExposureLayout is the layout of automatic exposure
+// parameters.
+// It is defined as follows:
+//
+//	[0]     | minimum log2 luminance
+//	[1]     | log2 luminance range
+//	[2]     | exposure scale
+//	[3:8]   | (unused)
+type ExposureLayout [8]float32
+
+// ExposureBins is the number of bins in the luminance
+// histogram.
+// The first bin counts pixels that are darker than
+// the minimum luminance. The remaining bins evenly
+// divide the log2 luminance range.
+const ExposureBins = 256
+
+// SetRange sets the log2 luminance range covered by
+// the histogram.
+func (l *ExposureLayout) SetRange(minLog, rangeLog float32) {
+	l[0] = minLog
+	l[1] = rangeLog
+}
+
+// Range returns the log2 luminance range.
+func (l *ExposureLayout) Range() (minLog, rangeLog float32) { return l[0], l[1] }
+
+// SetExposure sets the exposure scale.
+// Colors are multiplied by this value before
+// tonemapping.
+func (l *ExposureLayout) SetExposure(x float32) { l[2] = x }
+
+// Exposure returns the exposure scale.
+func (l *ExposureLayout) Exposure() float32 { return l[2] }
+
+// CameraLayout is the layout of physical camera
+// parameters.
+// It is defined as follows:
+//
+//	[0]   | exposure scale
+//	[1]   | CoC scale (pixels)
+//	[2]   | focus distance
+//	[3]   | maximum CoC (pixels)
+//	[4:8] | (unused)
+type CameraLayout [8]float32
+
+// SetExposure sets the exposure scale derived from the
+// camera settings.
+func (l *CameraLayout) SetExposure(x float32) { l[0] = x }
+
+// Exposure returns the exposure scale.
+func (l *CameraLayout) Exposure() float32 { return l[0] }
+
+// SetCoC sets the parameters of the circle of
+// confusion.
+// The signed CoC diameter, in pixels, of a point at
+// view depth z is scale * (1 - focus/z), clamped to
+// [-maxCoC, maxCoC].
+func (l *CameraLayout) SetCoC(scale, focus, maxCoC float32) {
+	l[1] = scale
+	l[2] = focus
+	l[3] = maxCoC
+}
+
+// CoC returns the parameters of the circle of
+// confusion.
+func (l *CameraLayout) CoC() (scale, focus, maxCoC float32) { return l[1], l[2], l[3] }
+
+// ReprojLayout is the layout of per-viewport
+// reprojection data.
+// It is defined as follows:
+//
+//	[0:16]  | previous view-projection matrix
+//	[16:32] | current clip to previous clip matrix
+type ReprojLayout [32]float32
+
+// SetPrevVP sets the view-projection matrix of the
+// previous frame.
+func (l *ReprojLayout) SetPrevVP(m *linear.M4) { copyM4(l[:16], m) }
+
+// PrevVP returns the previous view-projection matrix.
+func (l *ReprojLayout) PrevVP() (m linear.M4) {
+	for i := range m {
+		copy(m[i][:], l[4*i:4*i+4])
+	}
+	return
+}
+
+// SetReproj sets the matrix that transforms clip
+// coordinates of the current frame into clip
+// coordinates of the previous one.
+// It is used to compute camera motion where no
+// geometry was drawn.
+func (l *ReprojLayout) SetReproj(m *linear.M4) { copyM4(l[16:32], m) }
+
+// Reproj returns the reprojection matrix.
+func (l *ReprojLayout) Reproj() (m linear.M4) {
+	for i := range m {
+		copy(m[i][:], l[16+4*i:16+4*i+4])
+	}
+	return
+}
+
+// MotionLayout is the layout of motion blur
+// parameters.
+// It is defined as follows:
+//
+//	[0]   | shutter fraction
+//	[1]   | maximum blur radius (pixels)
+//	[2]   | sample count
+//	[3]   | tile size (pixels)
+//	[4:8] | (unused)
+type MotionLayout [8]float32
+
+// SetShutter sets the fraction of the frame interval
+// during which the shutter is open.
+// Velocities are scaled by this value.
+func (l *MotionLayout) SetShutter(x float32) { l[0] = x }
+
+// Shutter returns the shutter fraction.
+func (l *MotionLayout) Shutter() float32 { return l[0] }
+
+// SetBlur sets the maximum blur radius, the number of
+// samples taken per pixel and the size of velocity
+// tiles.
+func (l *MotionLayout) SetBlur(maxBlur float32, samples, tile int) {
+	l[1] = maxBlur
+	l[2] = float32(samples)
+	l[3] = float32(tile)
+}
+
+// Blur returns the blur parameters.
+func (l *MotionLayout) Blur() (maxBlur float32, samples, tile int) {
+	return l[1], int(l[2]), int(l[3])
+}
+
+// FogLayout is the layout of volumetric fog
+// parameters.
+// It is defined as follows:
+//
+//	[0:16]  | previous view-projection matrix
+//	[16:19] | albedo
+//	[19]    | density
+//	[20:23] | ambient light
+//	[23]    | anisotropy
+//	[24]    | base height
+//	[25]    | height falloff
+//	[26]    | distance
+//	[27]    | history weight
+//	[28]    | depth jitter
+//	[29:32] | (unused)
+type FogLayout [32]float32
+
+// SetPrevVP sets the view-projection matrix of the
+// previous frame.
+// It is used to reproject froxels into the history
+// volume.
+func (l *FogLayout) SetPrevVP(m *linear.M4) { copyM4(l[:16], m) }
+
+// PrevVP returns the previous view-projection matrix.
+func (l *FogLayout) PrevVP() (m linear.M4) {
+	for i := range m {
+		copy(m[i][:], l[4*i:4*i+4])
+	}
+	return
+}
+
+// SetMedium sets the scattering albedo and the
+// extinction coefficient at the base height.
+func (l *FogLayout) SetMedium(albedo *linear.V3, density float32) {
+	copy(l[16:19], albedo[:])
+	l[19] = density
+}
+
+// Medium returns the scattering albedo and density.
+func (l *FogLayout) Medium() (albedo linear.V3, density float32) {
+	copy(albedo[:], l[16:19])
+	return albedo, l[19]
+}
+
+// SetAmbient sets the ambient light scattered by the
+// medium.
+func (l *FogLayout) SetAmbient(c *linear.V3) { copy(l[20:23], c[:]) }
+
+// Ambient returns the ambient light.
+func (l *FogLayout) Ambient() (c linear.V3) {
+	copy(c[:], l[20:23])
+	return
+}
+
+// SetAnisotropy sets the Henyey-Greenstein anisotropy
+// of the phase function.
+func (l *FogLayout) SetAnisotropy(g float32) { l[23] = g }
+
+// Anisotropy returns the phase function anisotropy.
+func (l *FogLayout) Anisotropy() float32 { return l[23] }
+
+// SetHeight sets the base height and the rate at which
+// density decreases above it.
+func (l *FogLayout) SetHeight(base, falloff float32) {
+	l[24] = base
+	l[25] = falloff
+}
+
+// Height returns the base height and falloff.
+func (l *FogLayout) Height() (base, falloff float32) { return l[24], l[25] }
+
+// SetDistance sets the distance from the camera
+// covered by the froxel grid.
+func (l *FogLayout) SetDistance(d float32) { l[26] = d }
+
+// Distance returns the distance covered by the grid.
+func (l *FogLayout) Distance() float32 { return l[26] }
+
+// SetHistory sets the weight of the history volume in
+// temporal integration.
+func (l *FogLayout) SetHistory(w float32) { l[27] = w }
+
+// History returns the history weight.
+func (l *FogLayout) History() float32 { return l[27] }
+
+// SetJitter sets the offset, in [0, 1), applied to the
+// depth of froxel samples in the current frame.
+func (l *FogLayout) SetJitter(j float32) { l[28] = j }
+
+// Jitter returns the depth jitter.
+func (l *FogLayout) Jitter() float32 { return l[28] }
+
+// DDGILayout is the layout of dynamic diffuse global
+// illumination parameters.
+// It is defined as follows:
+//
+//	[0:3]   | grid origin
+//	[3]     | rays per probe
+//	[4:7]   | probe spacing
+//	[7]     | hysteresis
+//	[8:11]  | probe counts
+//	[11]    | flags
+//	[12]    | normal bias
+//	[13]    | view bias
+//	[14]    | maximum probe offset
+//	[15]    | (unused)
+//	[16:28] | ray rotation matrix
+//	[28:32] | (unused)
+//
+// The grid origin is the position of the first probe,
+// and probe counts are given per axis.
+type DDGILayout [32]float32
+
+// DDGI flags.
+const (
+	// Probes are moved away from nearby
+	// geometry.
+	DDGIRelocate uint32 = 1 << iota
+)
+
+// SetGrid sets the grid origin, probe spacing and
+// probe counts.
+func (l *DDGILayout) SetGrid(origin, spacing *linear.V3, counts [3]uint32) {
+	copy(l[:3], origin[:])
+	copy(l[4:7], spacing[:])
+	for i, x := range counts {
+		l[8+i] = *(*float32)(unsafe.Pointer(&x))
+	}
+}
+
+// Grid returns the grid origin, probe spacing and
+// probe counts.
+func (l *DDGILayout) Grid() (origin, spacing linear.V3, counts [3]uint32) {
+	copy(origin[:], l[:3])
+	copy(spacing[:], l[4:7])
+	for i := range counts {
+		counts[i] = *(*uint32)(unsafe.Pointer(&l[8+i]))
+	}
+	return
+}
+
+// SetRays sets the number of rays traced per probe.
+func (l *DDGILayout) SetRays(n int) { l[3] = float32(n) }
+
+// Rays returns the number of rays traced per probe.
+func (l *DDGILayout) Rays() int { return int(l[3]) }
+
+// SetHysteresis sets the weight of the previous
+// probe data when blending in new rays.
+func (l *DDGILayout) SetHysteresis(h float32) { l[7] = h }
+
+// Hysteresis returns the hysteresis.
+func (l *DDGILayout) Hysteresis() float32 { return l[7] }
+
+// SetFlags sets the DDGI flags.
+func (l *DDGILayout) SetFlags(flg uint32) { l[11] = *(*float32)(unsafe.Pointer(&flg)) }
+
+// Flags returns the DDGI flags.
+func (l *DDGILayout) Flags() uint32 {
+	flg := *(*uint32)(unsafe.Pointer(&l[11]))
+	return flg
+}
+
+// SetBias sets the distances by which sample positions
+// are offset along the surface normal and towards the
+// viewer.
+func (l *DDGILayout) SetBias(normal, view float32) {
+	l[12] = normal
+	l[13] = view
+}
+
+// Bias returns the normal and view biases.
+func (l *DDGILayout) Bias() (normal, view float32) { return l[12], l[13] }
+
+// SetMaxOffset sets how far probes can be relocated,
+// as a fraction of the probe spacing.
+func (l *DDGILayout) SetMaxOffset(x float32) { l[14] = x }
+
+// MaxOffset returns the maximum probe offset.
+func (l *DDGILayout) MaxOffset() float32 { return l[14] }
+
+// SetRotation sets the rotation applied to probe ray
+// directions in the current frame.
+func (l *DDGILayout) SetRotation(m *linear.M3) { copyM3(l[16:28], m) }
+
+// Rotation returns the ray rotation matrix.
+func (l *DDGILayout) Rotation() (m linear.M3) {
+	for i := range m {
+		copy(m[i][:], l[16+4*i:16+4*i+3])
+	}
+	return
+}
+
+// DecalLayout is the layout of decal data.
+// It is defined as follows:
+//
+//	[0:16]  | world matrix
+//	[16:32] | clip-to-local matrix
+//	[32]    | opacity
+//	[33]    | ???
+//	[34]    | ???
+//	[35]    | ???
+//	[36:63] | (unused)
+//
+// NOTE: This layout is likely to change.
+type DecalLayout [64]float32
+
+// SetWorld sets the world matrix.
+func (l *DecalLayout) SetWorld(m *linear.M4) { copyM4(l[:16], m) }
+
+// World returns the world matrix.
+func (l *DecalLayout) World() (m linear.M4) {
+	for i := range m {
+		copy(m[i][:], l[4*i:4*i+4])
+	}
+	return
+}
+
+// SetClip sets the clip-to-local matrix.
+// It transforms clip space positions into the
+// decal's local space.
+func (l *DecalLayout) SetClip(m *linear.M4) { copyM4(l[16:32], m) }
+
+// Clip returns the clip-to-local matrix.
+func (l *DecalLayout) Clip() (m linear.M4) {
+	for i := range m {
+		copy(m[i][:], l[16+4*i:16+4*i+4])
+	}
+	return
+}
+
+// SetOpacity sets the decal's opacity.
+func (l *DecalLayout) SetOpacity(a float32) { l[32] = a }
+
+// Opacity returns the decal's opacity.
+func (l *DecalLayout) Opacity() float32 { return l[32] }
+
+// FoliageLayout is the layout of foliage data.
+// It is defined as follows:
+//
+//	[0:2]  | wind direction (XZ plane)
+//	[2]    | wind strength
+//	[3]    | wind frequency
+//	[4]    | gust factor
+//	[5]    | time
+//	[6]    | mesh height
+//	[7]    | (unused)
+type FoliageLayout [8]float32
+
+// SetWind sets the wind parameters.
+// dir should be normalized.
+func (l *FoliageLayout) SetWind(dir [2]float32, strength, freq, gust float32) {
+	copy(l[:2], dir[:])
+	l[2] = strength
+	l[3] = freq
+	l[4] = gust
+}
+
+// Wind returns the wind parameters.
+func (l *FoliageLayout) Wind() (dir [2]float32, strength, freq, gust float32) {
+	copy(dir[:], l[:2])
+	return dir, l[2], l[3], l[4]
+}
+
+// SetTime sets the wind time, in seconds.
+func (l *FoliageLayout) SetTime(t float32) { l[5] = t }
+
+// Time returns the wind time, in seconds.
+func (l *FoliageLayout) Time() float32 { return l[5] }
+
+// SetHeight sets the height of the foliage mesh.
+// Vertices at this height sway the most.
+func (l *FoliageLayout) SetHeight(h float32) { l[6] = h }
+
+// Height returns the height of the foliage mesh.
+func (l *FoliageLayout) Height() float32 { return l[6] }
+
+// InstanceLayout is the layout of foliage instance
+// data.
+// It is defined as follows:
+//
+//	[0:3] | position
+//	[3]   | rotation about the Y axis
+//	[4]   | scale
+//	[5]   | wind phase
+//	[6]   | fade
+//	[7]   | (unused)
+type InstanceLayout [8]float32
+
+// SetPosition sets the world position.
+func (l *InstanceLayout) SetPosition(p *linear.V3) { copy(l[:3], p[:]) }
+
+// Position returns the world position.
+func (l *InstanceLayout) Position() (p linear.V3) {
+	copy(p[:], l[:3])
+	return
+}
+
+// SetYaw sets the rotation about the Y axis, in
+// radians.
+func (l *InstanceLayout) SetYaw(rad float32) { l[3] = rad }
+
+// Yaw returns the rotation about the Y axis.
+func (l *InstanceLayout) Yaw() float32 { return l[3] }
+
+// SetScale sets the uniform scale.
+func (l *InstanceLayout) SetScale(s float32) { l[4] = s }
+
+// Scale returns the uniform scale.
+func (l *InstanceLayout) Scale() float32 { return l[4] }
+
+// SetPhase sets the wind phase, in radians.
+func (l *InstanceLayout) SetPhase(rad float32) { l[5] = rad }
+
+// Phase returns the wind phase.
+func (l *InstanceLayout) Phase() float32 { return l[5] }
+
+// SetFade sets the fade factor.
+// Zero means fully faded out.
+func (l *InstanceLayout) SetFade(x float32) { l[6] = x }
+
+// Fade returns the fade factor.
+func (l *InstanceLayout) Fade() float32 { return l[6] }
+
+// ImposterLayout is the layout of imposter data.
+// It is defined as follows:
+//
+//	[0:3] | world center
+//	[3]   | radius
+//	[4]   | number of views
+//	[5]   | rotation about the Y axis
+//	[6:8] | (unused)
+type ImposterLayout [8]float32
+
+// SetBounds sets the bounding sphere of the group,
+// in world space.
+func (l *ImposterLayout) SetBounds(center *linear.V3, radius float32) {
+	copy(l[:3], center[:])
+	l[3] = radius
+}
+
+// Bounds returns the bounding sphere of the group.
+func (l *ImposterLayout) Bounds() (center linear.V3, radius float32) {
+	copy(center[:], l[:3])
+	return center, l[3]
+}
+
+// SetViews sets the number of views in the atlas.
+func (l *ImposterLayout) SetViews(n int) { l[4] = float32(n) }
+
+// Views returns the number of views in the atlas.
+func (l *ImposterLayout) Views() int { return int(l[4]) }
+
+// SetYaw sets the rotation of the group about the Y
+// axis, in radians.
+// It is used to select the atlas view that best
+// matches the camera direction.
+func (l *ImposterLayout) SetYaw(rad float32) { l[5] = rad }
+
+// Yaw returns the rotation of the group about the Y
+// axis.
+func (l *ImposterLayout) Yaw() float32 { return l[5] }
+
+// DebugLayout is the layout of debug view parameters.
+// It is defined as follows:
+//
+//	[0]   | debug view
+//	[1]   | overdraw increment
+//	[2]   | light count of the hottest color
+//	[3]   | (unused)
+//	[4:7] | wireframe color
+//	[7]   | (unused)
+type DebugLayout [8]float32
+
+// SetView sets the debug view.
+func (l *DebugLayout) SetView(view int32) { l[0] = *(*float32)(unsafe.Pointer(&view)) }
+
+// View returns the debug view.
+func (l *DebugLayout) View() int32 { return *(*int32)(unsafe.Pointer(&l[0])) }
+
+// SetOverdraw sets the value added to the overdraw
+// counter by every fragment.
+func (l *DebugLayout) SetOverdraw(inc float32) { l[1] = inc }
+
+// Overdraw returns the overdraw increment.
+func (l *DebugLayout) Overdraw() float32 { return l[1] }
+
+// SetMaxLights sets the light count that maps to the
+// hottest color of the heatmap.
+func (l *DebugLayout) SetMaxLights(n int) { l[2] = float32(n) }
+
+// MaxLights returns the heatmap's maximum light count.
+func (l *DebugLayout) MaxLights() int { return int(l[2]) }
+
+// SetWireColor sets the color of wireframe lines.
+func (l *DebugLayout) SetWireColor(c *linear.V3) { copy(l[4:7], c[:]) }
+
+// WireColor returns the wireframe color.
+func (l *DebugLayout) WireColor() linear.V3 { return linear.V3(l[4:7]) }
+
 // MaterialLayout is the layout of material data.
 // It is defined as follows:
 //
@@ -312,8 +1155,13 @@ func (l *DrawableLayout) ID() uint32 {
 //	[8:11]  | emissive factor
 //	[11]    | alpha cutoff
 //	[12]    | flags
-//	[13:15] | (unused)
-type MaterialLayout [16]float32
+//	[13]    | lightmap intensity
+//	[14:16] | (unused)
+//	[16:18] | UV offset
+//	[18:20] | UV scale
+//	[20]    | time
+//	[21:24] | (unused)
+type MaterialLayout [24]float32
 
 // Material flags.
 const (
@@ -329,6 +1177,13 @@ const (
 	MatAMask
 	// Whether the material is double-sided.
 	MatDoubleSided
+	// Whether texture coordinates must be
+	// transformed by the UV offset/scale.
+	MatUVTransform
+	// Whether the material has a lightmap.
+	// It replaces the irradiance term of
+	// indirect diffuse lighting.
+	MatLightmap
 )
 
 // SetColorFactor sets the base color factor.
@@ -388,6 +1243,33 @@ func (l *MaterialLayout) Flags() uint32 {
 	return flg
 }
 
+// SetLightmapIntensity sets the lightmap intensity.
+func (l *MaterialLayout) SetLightmapIntensity(i float32) { l[13] = i }
+
+// LightmapIntensity returns the lightmap intensity.
+func (l *MaterialLayout) LightmapIntensity() float32 { return l[13] }
+
+// SetUVTransform sets the UV offset and scale.
+// Texture coordinates are transformed as
+// uv * scale + offset.
+func (l *MaterialLayout) SetUVTransform(off, scale [2]float32) {
+	copy(l[16:18], off[:])
+	copy(l[18:20], scale[:])
+}
+
+// UVTransform returns the UV offset and scale.
+func (l *MaterialLayout) UVTransform() (off, scale [2]float32) {
+	copy(off[:], l[16:18])
+	copy(scale[:], l[18:20])
+	return
+}
+
+// SetTime sets the material time, in seconds.
+func (l *MaterialLayout) SetTime(t float32) { l[20] = t }
+
+// Time returns the material time, in seconds.
+func (l *MaterialLayout) Time() float32 { return l[20] }
+
 // JointLayout is the layout of joint data.
 // It is defined as follows:
 //
@@ -428,5 +1310,6 @@ func (l *JointLayout) Normal() (m linear.M3) {
 const (
 	MaxLight  = 16384 / unsafe.Sizeof(LightLayout{})
 	MaxShadow = 1
+	MaxProbe  = 8
 	MaxJoint  = 16384 / unsafe.Sizeof(JointLayout{})
 )
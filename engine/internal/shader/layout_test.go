@@ -56,6 +56,12 @@ func TestFrameLayout(t *testing.T) {
 	// [50:56]
 	bnd := driver.Viewport{X: 64, Y: 32, Width: 800, Height: 600, Znear: 1, Zfar: 1e-6}
 
+	// [56:57]
+	scale := float32(0.25)
+
+	// [60:64]
+	clip := linear.V4{0, 1, 0, -2.5}
+
 	var l FrameLayout
 	l.SetVP(&vp)
 	l.SetV(&v)
@@ -63,6 +69,8 @@ func TestFrameLayout(t *testing.T) {
 	l.SetTime(tm)
 	l.SetRand(rnd)
 	l.SetBounds(&bnd)
+	l.SetTargetScale(scale)
+	l.SetClipPlane(&clip)
 
 	s := "FrameLayout."
 
@@ -116,6 +124,18 @@ func TestFrameLayout(t *testing.T) {
 	if x := l.Bounds(); x != bnd {
 		t.Fatalf("%sBounds:\nhave %v\nwant %v", s, x, bnd)
 	}
+
+	switch x, y := l[56], l.TargetScale(); {
+	case x != scale:
+		t.Fatalf("%sSetTargetScale:\nhave %f\nwant %f", s, x, scale)
+	case y != scale:
+		t.Fatalf("%sTargetScale:\nhave %f\nwant %f", s, y, scale)
+	}
+
+	checkSlicesT(l[60:64], clip[:], t, s+"SetClipPlane")
+	if x := l.ClipPlane(); x != clip {
+		t.Fatalf("%sClipPlane:\nhave %v\nwant %v", s, x, clip)
+	}
 }
 
 func TestLightLayout(t *testing.T) {
@@ -310,6 +330,562 @@ func TestDrawableLayout(t *testing.T) {
 	case y != id:
 		t.Fatalf("%sID:\nhave %d\nwant %d", s, y, id)
 	}
+
+	// [29:32]
+	p0, p1, w := uint32(3), NoProbe, float32(0.75)
+	l.SetProbes(p0, p1, w)
+	switch x, y, z := l.Probes(); {
+	case *(*uint32)(unsafe.Pointer(&l[29])) != p0, *(*uint32)(unsafe.Pointer(&l[30])) != p1, l[31] != w:
+		t.Fatalf("%sSetProbes:\nhave %v\nwant %d, %d, %v", s, l[29:32], p0, p1, w)
+	case x != p0 || y != p1 || z != w:
+		t.Fatalf("%sProbes:\nhave %d, %d, %v\nwant %d, %d, %v", s, x, y, z, p0, p1, w)
+	}
+	if x := l.ID(); x != id {
+		t.Fatalf("%sID:\nhave %d\nwant %d", s, x, id)
+	}
+
+	// [32:48]
+	var prev linear.M4
+	prev.Translate(-1, 0.5, 2)
+	l.SetPrevWorld(&prev)
+	checkSlicesT(l[32:48], unsafe.Slice((*float32)(unsafe.Pointer(&prev)), 16), t, s+"SetPrevWorld")
+	if x := l.PrevWorld(); x != prev {
+		t.Fatalf("%sPrevWorld:\nhave %v\nwant %v", s, x, prev)
+	}
+	if x := l.World(); x != wld {
+		t.Fatalf("%sWorld:\nhave %v\nwant %v", s, x, wld)
+	}
+
+	// [48:64]
+	flags := QuantPosition | QuantNormal | QuantTexCoord1
+	off, scale := linear.V3{-1, -2, -3}, linear.V3{2, 4, 6}
+	uvOff, uvScale := [2]float32{-0.5, 0}, [2]float32{2, 1}
+	l.SetQuant(flags)
+	l.SetPosDecode(&off, &scale)
+	l.SetUVDecode(1, &uvOff, &uvScale)
+	if x := *(*uint32)(unsafe.Pointer(&l[51])); x != flags {
+		t.Fatalf("%sSetQuant:\nhave %d\nwant %d", s, x, flags)
+	}
+	if x := l.Quant(); x != flags {
+		t.Fatalf("%sQuant:\nhave %d\nwant %d", s, x, flags)
+	}
+	checkSlicesT(l[48:51], off[:], t, s+"SetPosDecode")
+	checkSlicesT(l[52:55], scale[:], t, s+"SetPosDecode")
+	if x, y := l.PosDecode(); x != off || y != scale {
+		t.Fatalf("%sPosDecode:\nhave %v, %v\nwant %v, %v", s, x, y, off, scale)
+	}
+	checkSlicesT(l[56:60], []float32{0, 0, 0, 0}, t, s+"SetUVDecode")
+	checkSlicesT(l[60:64], []float32{uvOff[0], uvOff[1], uvScale[0], uvScale[1]}, t, s+"SetUVDecode")
+	if x, y := l.UVDecode(1); x != uvOff || y != uvScale {
+		t.Fatalf("%sUVDecode:\nhave %v, %v\nwant %v, %v", s, x, y, uvOff, uvScale)
+	}
+	if x := l.PrevWorld(); x != prev {
+		t.Fatalf("%sPrevWorld:\nhave %v\nwant %v", s, x, prev)
+	}
+}
+
+func TestProbeLayout(t *testing.T) {
+	// [0:3]
+	pos := linear.V3{1, 2, -3}
+
+	// [3:4]
+	proj := ProbeSphere
+
+	// [4:7], [8:11]
+	min, max := linear.V3{-5, 0, -10}, linear.V3{5, 4, 6}
+
+	// [7:8]
+	rad := float32(12.5)
+
+	// [11:12]
+	lvls := 8
+
+	var l ProbeLayout
+	l.SetPosition(&pos)
+	l.SetProj(proj)
+	l.SetBox(&min, &max)
+	l.SetRadius(rad)
+	l.SetLevels(lvls)
+
+	s := "ProbeLayout."
+
+	checkSlicesT(l[:3], pos[:], t, s+"SetPosition")
+	if x := l.Position(); x != pos {
+		t.Fatalf("%sPosition:\nhave %v\nwant %v", s, x, pos)
+	}
+
+	switch x, y := *(*uint32)(unsafe.Pointer(&l[3])), l.Proj(); {
+	case x != proj:
+		t.Fatalf("%sSetProj:\nhave %d\nwant %d", s, x, proj)
+	case y != proj:
+		t.Fatalf("%sProj:\nhave %d\nwant %d", s, y, proj)
+	}
+
+	checkSlicesT(l[4:7], min[:], t, s+"SetBox")
+	checkSlicesT(l[8:11], max[:], t, s+"SetBox")
+	if x, y := l.Box(); x != min || y != max {
+		t.Fatalf("%sBox:\nhave %v, %v\nwant %v, %v", s, x, y, min, max)
+	}
+
+	switch x, y := l[7], l.Radius(); {
+	case x != rad:
+		t.Fatalf("%sSetRadius:\nhave %v\nwant %v", s, x, rad)
+	case y != rad:
+		t.Fatalf("%sRadius:\nhave %v\nwant %v", s, y, rad)
+	}
+
+	if x := l.Levels(); x != lvls {
+		t.Fatalf("%sLevels:\nhave %d\nwant %d", s, x, lvls)
+	}
+}
+
+func TestSSRLayout(t *testing.T) {
+	// [0:1]
+	steps := 48
+
+	// [1:2]
+	thick := float32(0.25)
+
+	// [2:3]
+	rough := float32(0.6)
+
+	// [3:5]
+	hiz, blur := 10, 5
+
+	// [5:6]
+	fade := float32(0.1)
+
+	var l SSRLayout
+	l.SetSteps(steps)
+	l.SetThickness(thick)
+	l.SetMaxRough(rough)
+	l.SetLevels(hiz, blur)
+	l.SetEdgeFade(fade)
+
+	s := "SSRLayout."
+
+	switch x, y := *(*uint32)(unsafe.Pointer(&l[0])), l.Steps(); {
+	case x != uint32(steps):
+		t.Fatalf("%sSetSteps:\nhave %d\nwant %d", s, x, steps)
+	case y != steps:
+		t.Fatalf("%sSteps:\nhave %d\nwant %d", s, y, steps)
+	}
+	checkSlicesT(l[1:6], []float32{thick, rough, float32(hiz), float32(blur), fade}, t, s+"Set*")
+	if x := l.Thickness(); x != thick {
+		t.Fatalf("%sThickness:\nhave %v\nwant %v", s, x, thick)
+	}
+	if x := l.MaxRough(); x != rough {
+		t.Fatalf("%sMaxRough:\nhave %v\nwant %v", s, x, rough)
+	}
+	if x, y := l.Levels(); x != hiz || y != blur {
+		t.Fatalf("%sLevels:\nhave %d, %d\nwant %d, %d", s, x, y, hiz, blur)
+	}
+	if x := l.EdgeFade(); x != fade {
+		t.Fatalf("%sEdgeFade:\nhave %v\nwant %v", s, x, fade)
+	}
+}
+
+func TestGradeLayout(t *testing.T) {
+	// [0:12]
+	wb := linear.M3{{1.1, 0.01, 0.02}, {0.03, 1, 0.04}, {0.05, 0.06, 0.9}}
+
+	// [12:15]
+	expo, contr, sat := float32(2), float32(1.2), float32(0.8)
+
+	// [15:23]
+	size := 33
+	dmin, dmax := linear.V3{-0.5, 0, 0}, linear.V3{1, 1, 2}
+
+	var l GradeLayout
+	l.SetWhiteBalance(&wb)
+	l.SetExposure(expo)
+	l.SetContrast(contr)
+	l.SetSaturation(sat)
+	l.SetLUT(size, &dmin, &dmax)
+
+	s := "GradeLayout."
+
+	for i := range wb {
+		checkSlicesT(l[4*i:4*i+3], wb[i][:], t, s+"SetWhiteBalance")
+	}
+	checkSlicesT(l[12:16], []float32{expo, contr, sat, float32(size)}, t, s+"Set*")
+	checkSlicesT(l[16:19], dmin[:], t, s+"SetLUT")
+	checkSlicesT(l[20:23], dmax[:], t, s+"SetLUT")
+	if x := l.WhiteBalance(); x != wb {
+		t.Fatalf("%sWhiteBalance:\nhave %v\nwant %v", s, x, wb)
+	}
+	if x := l.Exposure(); x != expo {
+		t.Fatalf("%sExposure:\nhave %v\nwant %v", s, x, expo)
+	}
+	if x := l.Contrast(); x != contr {
+		t.Fatalf("%sContrast:\nhave %v\nwant %v", s, x, contr)
+	}
+	if x := l.Saturation(); x != sat {
+		t.Fatalf("%sSaturation:\nhave %v\nwant %v", s, x, sat)
+	}
+	if x, y, z := l.LUT(); x != size || y != dmin || z != dmax {
+		t.Fatalf("%sLUT:\nhave %d, %v, %v\nwant %d, %v, %v", s, x, y, z, size, dmin, dmax)
+	}
+}
+
+func TestExposureLayout(t *testing.T) {
+	// [0:2]
+	minLog, rangeLog := float32(-8), float32(20)
+
+	// [2:3]
+	expo := float32(0.25)
+
+	var l ExposureLayout
+	l.SetRange(minLog, rangeLog)
+	l.SetExposure(expo)
+
+	s := "ExposureLayout."
+
+	checkSlicesT(l[:3], []float32{minLog, rangeLog, expo}, t, s+"Set*")
+	if x, y := l.Range(); x != minLog || y != rangeLog {
+		t.Fatalf("%sRange:\nhave %v, %v\nwant %v, %v", s, x, y, minLog, rangeLog)
+	}
+	if x := l.Exposure(); x != expo {
+		t.Fatalf("%sExposure:\nhave %v\nwant %v", s, x, expo)
+	}
+}
+
+func TestCameraLayout(t *testing.T) {
+	// [0:1]
+	expo := float32(0.125)
+
+	// [1:4]
+	scale, focus, maxCoC := float32(42.5), float32(3), float32(16)
+
+	var l CameraLayout
+	l.SetExposure(expo)
+	l.SetCoC(scale, focus, maxCoC)
+
+	s := "CameraLayout."
+
+	checkSlicesT(l[:4], []float32{expo, scale, focus, maxCoC}, t, s+"Set*")
+	if x := l.Exposure(); x != expo {
+		t.Fatalf("%sExposure:\nhave %v\nwant %v", s, x, expo)
+	}
+	if x, y, z := l.CoC(); x != scale || y != focus || z != maxCoC {
+		t.Fatalf("%sCoC:\nhave %v, %v, %v\nwant %v, %v, %v", s, x, y, z, scale, focus, maxCoC)
+	}
+}
+
+func TestReprojLayout(t *testing.T) {
+	// [0:16]
+	var vp linear.M4
+	vp.Perspective(math.Pi/3, 16.0/9.0, 0.1, 100)
+
+	// [16:32]
+	var rp linear.M4
+	rp.Translate(0.25, -0.5, 0)
+
+	var l ReprojLayout
+	l.SetPrevVP(&vp)
+	l.SetReproj(&rp)
+
+	s := "ReprojLayout."
+
+	checkSlicesT(l[:16], unsafe.Slice((*float32)(unsafe.Pointer(&vp)), 16), t, s+"SetPrevVP")
+	if x := l.PrevVP(); x != vp {
+		t.Fatalf("%sPrevVP:\nhave %v\nwant %v", s, x, vp)
+	}
+	checkSlicesT(l[16:32], unsafe.Slice((*float32)(unsafe.Pointer(&rp)), 16), t, s+"SetReproj")
+	if x := l.Reproj(); x != rp {
+		t.Fatalf("%sReproj:\nhave %v\nwant %v", s, x, rp)
+	}
+}
+
+func TestMotionLayout(t *testing.T) {
+	// [0:1]
+	shut := float32(0.5)
+
+	// [1:4]
+	maxBlur, samples, tile := float32(24), 12, 32
+
+	var l MotionLayout
+	l.SetShutter(shut)
+	l.SetBlur(maxBlur, samples, tile)
+
+	s := "MotionLayout."
+
+	checkSlicesT(l[:4], []float32{shut, maxBlur, float32(samples), float32(tile)}, t, s+"Set*")
+	if x := l.Shutter(); x != shut {
+		t.Fatalf("%sShutter:\nhave %v\nwant %v", s, x, shut)
+	}
+	if x, y, z := l.Blur(); x != maxBlur || y != samples || z != tile {
+		t.Fatalf("%sBlur:\nhave %v, %d, %d\nwant %v, %d, %d", s, x, y, z, maxBlur, samples, tile)
+	}
+}
+
+func TestFogLayout(t *testing.T) {
+	// [0:16]
+	var vp linear.M4
+	vp.Perspective(math.Pi/3, 16.0/9.0, 0.1, 100)
+
+	// [16:20]
+	alb := linear.V3{0.9, 0.95, 1}
+	dens := float32(0.02)
+
+	// [20:24]
+	amb := linear.V3{0.1, 0.125, 0.25}
+	g := float32(0.4)
+
+	// [24:29]
+	base, fall := float32(-5), float32(0.125)
+	dist := float32(96)
+	hist := float32(0.9)
+	jit := float32(0.375)
+
+	var l FogLayout
+	l.SetPrevVP(&vp)
+	l.SetMedium(&alb, dens)
+	l.SetAmbient(&amb)
+	l.SetAnisotropy(g)
+	l.SetHeight(base, fall)
+	l.SetDistance(dist)
+	l.SetHistory(hist)
+	l.SetJitter(jit)
+
+	s := "FogLayout."
+
+	checkSlicesT(l[:16], unsafe.Slice((*float32)(unsafe.Pointer(&vp)), 16), t, s+"SetPrevVP")
+	if x := l.PrevVP(); x != vp {
+		t.Fatalf("%sPrevVP:\nhave %v\nwant %v", s, x, vp)
+	}
+	checkSlicesT(l[16:29], []float32{
+		alb[0], alb[1], alb[2], dens,
+		amb[0], amb[1], amb[2], g,
+		base, fall, dist, hist, jit,
+	}, t, s+"Set*")
+	if x, y := l.Medium(); x != alb || y != dens {
+		t.Fatalf("%sMedium:\nhave %v, %v\nwant %v, %v", s, x, y, alb, dens)
+	}
+	if x := l.Ambient(); x != amb {
+		t.Fatalf("%sAmbient:\nhave %v\nwant %v", s, x, amb)
+	}
+	if x := l.Anisotropy(); x != g {
+		t.Fatalf("%sAnisotropy:\nhave %v\nwant %v", s, x, g)
+	}
+	if x, y := l.Height(); x != base || y != fall {
+		t.Fatalf("%sHeight:\nhave %v, %v\nwant %v, %v", s, x, y, base, fall)
+	}
+	if x, y, z := l.Distance(), l.History(), l.Jitter(); x != dist || y != hist || z != jit {
+		t.Fatalf("%sDistance/History/Jitter:\nhave %v, %v, %v\nwant %v, %v, %v", s, x, y, z, dist, hist, jit)
+	}
+}
+
+func TestDDGILayout(t *testing.T) {
+	// [0:16]
+	org := linear.V3{-8, 0.5, -4}
+	spc := linear.V3{2, 1.5, 2}
+	cnt := [3]uint32{9, 4, 5}
+	rays := 192
+	hyst := float32(0.97)
+	flg := DDGIRelocate
+	nb, vb := float32(0.1), float32(0.3)
+	moff := float32(0.45)
+
+	// [16:28]
+	var rot linear.M3
+	rot.Rotate(math.Pi/5, &linear.V3{1, 2, 3})
+
+	var l DDGILayout
+	l.SetGrid(&org, &spc, cnt)
+	l.SetRays(rays)
+	l.SetHysteresis(hyst)
+	l.SetFlags(flg)
+	l.SetBias(nb, vb)
+	l.SetMaxOffset(moff)
+	l.SetRotation(&rot)
+
+	s := "DDGILayout."
+
+	checkSlicesT(l[:8], []float32{org[0], org[1], org[2], float32(rays), spc[0], spc[1], spc[2], hyst}, t, s+"Set*")
+	checkSlicesT(l[12:15], []float32{nb, vb, moff}, t, s+"Set*")
+	if x, y, z := l.Grid(); x != org || y != spc || z != cnt {
+		t.Fatalf("%sGrid:\nhave %v, %v, %v\nwant %v, %v, %v", s, x, y, z, org, spc, cnt)
+	}
+	if x, y := l.Rays(), l.Hysteresis(); x != rays || y != hyst {
+		t.Fatalf("%sRays/Hysteresis:\nhave %v, %v\nwant %v, %v", s, x, y, rays, hyst)
+	}
+	if x := l.Flags(); x != flg {
+		t.Fatalf("%sFlags:\nhave %v\nwant %v", s, x, flg)
+	}
+	if x, y := l.Bias(); x != nb || y != vb {
+		t.Fatalf("%sBias:\nhave %v, %v\nwant %v, %v", s, x, y, nb, vb)
+	}
+	if x := l.MaxOffset(); x != moff {
+		t.Fatalf("%sMaxOffset:\nhave %v\nwant %v", s, x, moff)
+	}
+	if x := l.Rotation(); x != rot {
+		t.Fatalf("%sRotation:\nhave %v\nwant %v", s, x, rot)
+	}
+}
+
+func TestDecalLayout(t *testing.T) {
+	// [0:16]
+	var wld linear.M4
+	wld.Scale(2, 0.5, 3)
+
+	// [16:32]
+	var clip linear.M4
+	clip.Rotate(math.Pi/3, &linear.V3{1, 0, 0})
+
+	// [32:33]
+	opac := float32(0.625)
+
+	var l DecalLayout
+	l.SetWorld(&wld)
+	l.SetClip(&clip)
+	l.SetOpacity(opac)
+
+	s := "DecalLayout."
+
+	checkSlicesT(l[:16], unsafe.Slice((*float32)(unsafe.Pointer(&wld)), 16), t, s+"SetWorld")
+	if x := l.World(); x != wld {
+		t.Fatalf("%sWorld:\nhave %v\nwant %v", s, x, wld)
+	}
+
+	checkSlicesT(l[16:32], unsafe.Slice((*float32)(unsafe.Pointer(&clip)), 16), t, s+"SetClip")
+	if x := l.Clip(); x != clip {
+		t.Fatalf("%sClip:\nhave %v\nwant %v", s, x, clip)
+	}
+
+	switch x, y := l[32], l.Opacity(); {
+	case x != opac:
+		t.Fatalf("%sSetOpacity:\nhave %v\nwant %v", s, x, opac)
+	case y != opac:
+		t.Fatalf("%sOpacity:\nhave %v\nwant %v", s, y, opac)
+	}
+}
+
+func TestFoliageLayout(t *testing.T) {
+	// [0:5]
+	dir := [2]float32{0.6, -0.8}
+	str, freq, gust := float32(0.25), float32(1.5), float32(0.375)
+
+	// [5:6]
+	secs := float32(12.5)
+
+	// [6:7]
+	hgt := float32(0.75)
+
+	var l FoliageLayout
+	l.SetWind(dir, str, freq, gust)
+	l.SetTime(secs)
+	l.SetHeight(hgt)
+
+	s := "FoliageLayout."
+
+	checkSlicesT(l[:5], []float32{dir[0], dir[1], str, freq, gust}, t, s+"SetWind")
+	if x, y, z, w := l.Wind(); x != dir || y != str || z != freq || w != gust {
+		t.Fatalf("%sWind:\nhave %v, %v, %v, %v\nwant %v, %v, %v, %v", s, x, y, z, w, dir, str, freq, gust)
+	}
+
+	switch x, y := l[5], l.Time(); {
+	case x != secs:
+		t.Fatalf("%sSetTime:\nhave %v\nwant %v", s, x, secs)
+	case y != secs:
+		t.Fatalf("%sTime:\nhave %v\nwant %v", s, y, secs)
+	}
+
+	switch x, y := l[6], l.Height(); {
+	case x != hgt:
+		t.Fatalf("%sSetHeight:\nhave %v\nwant %v", s, x, hgt)
+	case y != hgt:
+		t.Fatalf("%sHeight:\nhave %v\nwant %v", s, y, hgt)
+	}
+}
+
+func TestInstanceLayout(t *testing.T) {
+	pos := linear.V3{-3, 0.5, 12}
+	yaw, scale, phase, fade := float32(1.25), float32(0.8), float32(4.5), float32(0.5)
+
+	var l InstanceLayout
+	l.SetPosition(&pos)
+	l.SetYaw(yaw)
+	l.SetScale(scale)
+	l.SetPhase(phase)
+	l.SetFade(fade)
+
+	s := "InstanceLayout."
+
+	checkSlicesT(l[:7], []float32{pos[0], pos[1], pos[2], yaw, scale, phase, fade}, t, s+"Set*")
+	if x := l.Position(); x != pos {
+		t.Fatalf("%sPosition:\nhave %v\nwant %v", s, x, pos)
+	}
+	if x, y, z, w := l.Yaw(), l.Scale(), l.Phase(), l.Fade(); x != yaw || y != scale || z != phase || w != fade {
+		t.Fatalf("%sYaw/Scale/Phase/Fade:\nhave %v, %v, %v, %v\nwant %v, %v, %v, %v", s, x, y, z, w, yaw, scale, phase, fade)
+	}
+}
+
+func TestImposterLayout(t *testing.T) {
+	// [0:4]
+	ctr := linear.V3{1, -2, 3.5}
+	rad := float32(4.25)
+
+	// [4:5]
+	views := 12
+
+	// [5:6]
+	yaw := float32(-0.5)
+
+	var l ImposterLayout
+	l.SetBounds(&ctr, rad)
+	l.SetViews(views)
+	l.SetYaw(yaw)
+
+	s := "ImposterLayout."
+
+	checkSlicesT(l[:6], []float32{ctr[0], ctr[1], ctr[2], rad, float32(views), yaw}, t, s+"Set*")
+	if x, y := l.Bounds(); x != ctr || y != rad {
+		t.Fatalf("%sBounds:\nhave %v, %v\nwant %v, %v", s, x, y, ctr, rad)
+	}
+	if x := l.Views(); x != views {
+		t.Fatalf("%sViews:\nhave %d\nwant %d", s, x, views)
+	}
+	if x := l.Yaw(); x != yaw {
+		t.Fatalf("%sYaw:\nhave %v\nwant %v", s, x, yaw)
+	}
+}
+
+func TestDebugLayout(t *testing.T) {
+	// [0:1]
+	view := int32(6)
+
+	// [1:3]
+	inc, maxLights := float32(1.0/16), 8
+
+	// [4:7]
+	wire := linear.V3{0, 1, 0.5}
+
+	var l DebugLayout
+	l.SetView(view)
+	l.SetOverdraw(inc)
+	l.SetMaxLights(maxLights)
+	l.SetWireColor(&wire)
+
+	s := "DebugLayout."
+
+	if x := *(*int32)(unsafe.Pointer(&l[0])); x != view {
+		t.Fatalf("%sSetView:\nhave %d\nwant %d", s, x, view)
+	}
+	if x := l.View(); x != view {
+		t.Fatalf("%sView:\nhave %d\nwant %d", s, x, view)
+	}
+	checkSlicesT(l[1:3], []float32{inc, float32(maxLights)}, t, s+"Set*")
+	if x := l.Overdraw(); x != inc {
+		t.Fatalf("%sOverdraw:\nhave %v\nwant %v", s, x, inc)
+	}
+	if x := l.MaxLights(); x != maxLights {
+		t.Fatalf("%sMaxLights:\nhave %d\nwant %d", s, x, maxLights)
+	}
+	checkSlicesT(l[4:7], wire[:], t, s+"SetWireColor")
+	if x := l.WireColor(); x != wire {
+		t.Fatalf("%sWireColor:\nhave %v\nwant %v", s, x, wire)
+	}
 }
 
 func TestMaterialLayout(t *testing.T) {
@@ -332,7 +908,16 @@ func TestMaterialLayout(t *testing.T) {
 	cutoff := float32(0.93)
 
 	// [12:13]
-	flags := MatPBR | MatABlend | MatDoubleSided
+	flags := MatPBR | MatABlend | MatDoubleSided | MatUVTransform | MatLightmap
+
+	// [13:14]
+	lmap := float32(1.5)
+
+	// [16:18], [18:20]
+	off, uvScale := [2]float32{0.25, -0.5}, [2]float32{2, 0.125}
+
+	// [20:21]
+	secs := float32(12.5)
 
 	var l MaterialLayout
 	l.SetColorFactor(&color)
@@ -342,6 +927,9 @@ func TestMaterialLayout(t *testing.T) {
 	l.SetEmisFactor(&emissive)
 	l.SetAlphaCutoff(cutoff)
 	l.SetFlags(flags)
+	l.SetLightmapIntensity(lmap)
+	l.SetUVTransform(off, uvScale)
+	l.SetTime(secs)
 
 	s := "MaterialLayout."
 
@@ -389,6 +977,26 @@ func TestMaterialLayout(t *testing.T) {
 	case y != flags:
 		t.Fatalf("%sFlags:\nhave 0x%x\nwant 0x%x", s, y, flags)
 	}
+
+	switch x, y := l[13], l.LightmapIntensity(); {
+	case x != lmap:
+		t.Fatalf("%sSetLightmapIntensity:\nhave %f\nwant %f", s, x, lmap)
+	case y != lmap:
+		t.Fatalf("%sLightmapIntensity:\nhave %f\nwant %f", s, y, lmap)
+	}
+
+	checkSlicesT(l[16:18], off[:], t, s+"SetUVTransform")
+	checkSlicesT(l[18:20], uvScale[:], t, s+"SetUVTransform")
+	if x, y := l.UVTransform(); x != off || y != uvScale {
+		t.Fatalf("%sUVTransform:\nhave %v,%v\nwant %v,%v", s, x, y, off, uvScale)
+	}
+
+	switch x, y := l[20], l.Time(); {
+	case x != secs:
+		t.Fatalf("%sSetTime:\nhave %f\nwant %f", s, x, secs)
+	case y != secs:
+		t.Fatalf("%sTime:\nhave %f\nwant %f", s, y, secs)
+	}
 }
 
 func TestJointLayout(t *testing.T) {
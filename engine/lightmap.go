@@ -0,0 +1,432 @@
+// Copyright 2024 Gustavo C. Viegas. All rights reserved.
+
+package engine
+
+import (
+	"encoding/binary"
+	"io"
+	"math"
+	"math/rand/v2"
+	"slices"
+	"strconv"
+
+	"gviegas/neo3/driver"
+	"gviegas/neo3/linear"
+)
+
+const bakePrefix = "lightmap: "
+
+func newBakeErr(reason string) error { return newErr(bakePrefix, reason, ErrInvalidParam) }
+
+// BakeMesh is a mesh that takes part in lightmap baking.
+// World transforms its vertices into world space.
+// Albedo is the diffuse reflectance of its surface,
+// which determines how much light it bounces.
+// If Bake is set, the mesh receives baked lighting at
+// the lightmap texels covered by its LightmapUV
+// coordinates, which should lie in [0.0, 1.0] and not
+// overlap. Every mesh occludes and bounces light,
+// regardless of Bake.
+// Only primitives with driver.TTriangle topology are
+// considered.
+type BakeMesh struct {
+	Data   *MeshData
+	World  linear.M4
+	Albedo [3]float32
+	Bake   bool
+}
+
+// BakeParam describes a lightmap bake.
+// Width and Height are the size of the lightmap.
+// Lights are the direct light sources, and Sky is the
+// radiance of rays that escape the scene.
+// Samples is the number of paths traced per texel to
+// estimate indirect lighting, and Bounces is the
+// maximum number of surfaces that such paths hit.
+// If either is zero, only direct lighting is baked
+// and Sky is ignored.
+// Baking is deterministic for a given Seed.
+type BakeParam struct {
+	Width   int
+	Height  int
+	Meshes  []BakeMesh
+	Lights  []Light
+	Sky     [3]float32
+	Samples int
+	Bounces int
+	Seed    uint64
+}
+
+// BakeLightmap computes the diffuse lighting of the
+// meshes in param whose Bake field is set, using a
+// CPU path tracer.
+// It returns tightly packed RGB texels, in row-major
+// order, with the first row at V = 0.0. Each texel
+// holds the irradiance at the surface divided by π,
+// so the diffuse radiance leaving the surface is the
+// texel value times the albedo. Texels that no mesh
+// covers are filled from their neighbors, which
+// prevents seams when the lightmap is filtered.
+// The mesh data sources are read but not otherwise
+// modified. Lighting is accumulated in float32, and
+// the quality is meant for previews and small scenes.
+func BakeLightmap(param *BakeParam) ([]float32, error) {
+	if err := param.validate(); err != nil {
+		return nil, err
+	}
+	var bk baker
+	for i := range param.Meshes {
+		var err error
+		if bk.bvh.tris, err = loadBakeMesh(&param.Meshes[i], bk.bvh.tris); err != nil {
+			return nil, err
+		}
+	}
+	bk.lights = param.Lights
+	bk.sky = param.Sky
+	bk.samples = param.Samples
+	bk.bounces = param.Bounces
+	bk.init()
+
+	w, h := param.Width, param.Height
+	texels := bk.raster(w, h)
+	data := make([]float32, w*h*3)
+	parallelRows(h, func(y int) {
+		// Seeding per row makes results
+		// independent of scheduling.
+		rng := rand.New(rand.NewPCG(param.Seed, uint64(y)))
+		for x := range w {
+			t := &texels[y*w+x]
+			if t.tri < 0 {
+				continue
+			}
+			p, n := bk.bvh.tris[t.tri].interp(t.u, t.v)
+			e := bk.irradiance(&p, &n, rng)
+			copy(data[(y*w+x)*3:], e[:])
+		}
+	})
+	dilate(data, texels, w, h)
+	for i := range data {
+		data[i] /= math.Pi
+	}
+	return data, nil
+}
+
+// NewLightmap creates a 2D texture from lightmap data
+// produced by BakeLightmap.
+// Its format is driver.RGBA16Float, with alpha set to
+// 1.0. It has a single layer and mip level.
+// The copy of data may be delayed.
+func NewLightmap(width, height int, data []float32) (*Texture, error) {
+	if width < 1 || height < 1 || len(data) != width*height*3 {
+		return nil, newBakeErr("lightmap data does not match size")
+	}
+	t, err := New2D(&TexParam{
+		PixelFmt: driver.RGBA16Float,
+		Dim3D:    driver.Dim3D{Width: width, Height: height},
+		Layers:   1,
+		Levels:   1,
+		Samples:  1,
+	})
+	if err != nil {
+		return nil, err
+	}
+	if err = t.CopyToView(0, halfPixRGB(data), false); err != nil {
+		t.Free()
+		return nil, err
+	}
+	return t, nil
+}
+
+// AddLightmap allocates a region of a and copies
+// lightmap data produced by BakeLightmap into it, so
+// that the lightmaps of many meshes can share a few
+// textures. The PixelFmt of a must be
+// driver.RGBA16Float.
+// LightmapUV coordinates must be transformed as
+// described in AtlasRegion.UV. The data is uploaded
+// by the next call to a.Flush.
+func AddLightmap(a *Atlas, width, height int, data []float32) (AtlasRegion, error) {
+	switch {
+	case a.param.PixelFmt != driver.RGBA16Float:
+		return AtlasRegion{}, newBakeErr("lightmap atlas format must be RGBA16Float")
+	case width < 1 || height < 1 || len(data) != width*height*3:
+		return AtlasRegion{}, newBakeErr("lightmap data does not match size")
+	}
+	r, err := a.Alloc(width, height)
+	if err != nil {
+		return AtlasRegion{}, err
+	}
+	if err = a.Update(r, halfPixRGB(data)); err != nil {
+		a.FreeRegion(r)
+		return AtlasRegion{}, err
+	}
+	return r, nil
+}
+
+func (p *BakeParam) validate() error {
+	var reason string
+	switch {
+	case p == nil:
+		reason = "nil BakeParam"
+	case p.Width < 1 || p.Height < 1:
+		reason = "invalid lightmap size"
+	case p.Samples < 0:
+		reason = "negative sample count"
+	case p.Bounces < 0:
+		reason = "negative bounce count"
+	case !slices.ContainsFunc(p.Meshes, func(m BakeMesh) bool { return m.Bake }):
+		reason = "no mesh to bake"
+	default:
+		return nil
+	}
+	return newBakeErr(reason)
+}
+
+// loadBakeMesh appends the triangles of m, in world
+// space, to tris.
+func loadBakeMesh(m *BakeMesh, tris []traceTri) ([]traceTri, error) {
+	if m.Data == nil {
+		return tris, newBakeErr("nil BakeMesh.Data")
+	}
+	if err := validateMeshData(m.Data); err != nil {
+		return tris, err
+	}
+	var nm linear.M4
+	nm.Invert(&m.World)
+	nm.Transpose(&nm)
+	mat := &traceMat{base: m.Albedo, diffuse: true}
+	for i := range m.Data.Primitives {
+		pdata := &m.Data.Primitives[i]
+		if pdata.Topology != driver.TTriangle {
+			continue
+		}
+		if m.Bake && pdata.SemanticMask&LightmapUV == 0 {
+			return tris, newBakeErr("Primitives[" + strconv.Itoa(i) + "] has no LightmapUV data")
+		}
+		pos, err := readBakeAttr(m.Data, pdata, Position)
+		if err != nil {
+			return tris, err
+		}
+		var norm, uv []float32
+		if pdata.SemanticMask&Normal != 0 {
+			if norm, err = readBakeAttr(m.Data, pdata, Normal); err != nil {
+				return tris, err
+			}
+		}
+		if m.Bake {
+			if uv, err = readBakeAttr(m.Data, pdata, LightmapUV); err != nil {
+				return tris, err
+			}
+		}
+		idx, err := readBakeIndices(m.Data, pdata)
+		if err != nil {
+			return tris, err
+		}
+		for j := 0; j+2 < len(idx); j += 3 {
+			t := traceTri{mat: mat, bake: m.Bake}
+			for k, x := range idx[j : j+3] {
+				p := linear.V4{pos[x*3], pos[x*3+1], pos[x*3+2], 1}
+				p.Mul(&m.World, &p)
+				t.pos[k] = linear.V3{p[0], p[1], p[2]}
+				if uv != nil {
+					t.lmuv[k] = [2]float32{uv[x*2], uv[x*2+1]}
+				}
+			}
+			if t.setNormals(norm, idx[j:j+3], &nm) {
+				tris = append(tris, t)
+			}
+		}
+	}
+	return tris, nil
+}
+
+// readBakeAttr reads the data of sem, converted into
+// sem.format(), as float32 values.
+// sem's format must be a driver.Float32xN one.
+func readBakeAttr(data *MeshData, pdata *PrimitiveData, sem Semantic) ([]float32, error) {
+	sdata := &pdata.Semantics[sem.I()]
+	src := data.Srcs[sdata.Src]
+	if _, err := src.Seek(sdata.Offset, io.SeekStart); err != nil {
+		return nil, err
+	}
+	conv, err := sem.conv(sdata.Format, src, pdata.VertexCount)
+	if err != nil {
+		return nil, err
+	}
+	b := make([]byte, pdata.VertexCount*sem.format().Size())
+	if _, err := io.ReadFull(conv, b); err != nil {
+		return nil, err
+	}
+	f := make([]float32, len(b)/4)
+	for i := range f {
+		f[i] = math.Float32frombits(binary.NativeEndian.Uint32(b[i*4:]))
+	}
+	return f, nil
+}
+
+// readBakeIndices reads the indices of pdata.
+// Non-indexed primitives produce sequential indices.
+func readBakeIndices(data *MeshData, pdata *PrimitiveData) ([]int, error) {
+	if pdata.IndexCount <= 0 {
+		idx := make([]int, pdata.VertexCount)
+		for i := range idx {
+			idx[i] = i
+		}
+		return idx, nil
+	}
+	isz := 2
+	if pdata.Index.Format == driver.Index32 {
+		isz = 4
+	}
+	src := data.Srcs[pdata.Index.Src]
+	if _, err := src.Seek(pdata.Index.Offset, io.SeekStart); err != nil {
+		return nil, err
+	}
+	b := make([]byte, pdata.IndexCount*isz)
+	if _, err := io.ReadFull(src, b); err != nil {
+		return nil, err
+	}
+	idx := make([]int, pdata.IndexCount)
+	for i := range idx {
+		if isz == 2 {
+			idx[i] = int(binary.LittleEndian.Uint16(b[i*2:]))
+		} else {
+			idx[i] = int(binary.LittleEndian.Uint32(b[i*4:]))
+		}
+		if idx[i] >= pdata.VertexCount {
+			return nil, newBakeErr("index out of range")
+		}
+	}
+	return idx, nil
+}
+
+// baker bakes lightmaps for BakeLightmap.
+type baker struct {
+	pathTracer
+	samples int
+	bounces int
+}
+
+// bakeTexel identifies the surface point of a texel.
+// tri is -1 for texels not covered by any triangle.
+type bakeTexel struct {
+	tri  int
+	u, v float32
+}
+
+// raster rasterizes the lightmap UVs of the baked
+// triangles into a w by h grid, sampling texel
+// centers.
+func (bk *baker) raster(w, h int) []bakeTexel {
+	texels := make([]bakeTexel, w*h)
+	for i := range texels {
+		texels[i].tri = -1
+	}
+	for i := range bk.bvh.tris {
+		t := &bk.bvh.tris[i]
+		if !t.bake {
+			continue
+		}
+		var uv [3][2]float32
+		for k := range 3 {
+			uv[k] = [2]float32{t.lmuv[k][0] * float32(w), t.lmuv[k][1] * float32(h)}
+		}
+		x0 := max(0, int(math.Floor(float64(min(uv[0][0], uv[1][0], uv[2][0])))))
+		x1 := min(w-1, int(math.Ceil(float64(max(uv[0][0], uv[1][0], uv[2][0])))))
+		y0 := max(0, int(math.Floor(float64(min(uv[0][1], uv[1][1], uv[2][1])))))
+		y1 := min(h-1, int(math.Ceil(float64(max(uv[0][1], uv[1][1], uv[2][1])))))
+		d := (uv[1][1]-uv[2][1])*(uv[0][0]-uv[2][0]) + (uv[2][0]-uv[1][0])*(uv[0][1]-uv[2][1])
+		if d == 0 {
+			continue
+		}
+		for y := y0; y <= y1; y++ {
+			for x := x0; x <= x1; x++ {
+				px, py := float32(x)+0.5, float32(y)+0.5
+				// Barycentric coordinates of
+				// the first vertex, then the
+				// second and third.
+				a := ((uv[1][1]-uv[2][1])*(px-uv[2][0]) + (uv[2][0]-uv[1][0])*(py-uv[2][1])) / d
+				b := ((uv[2][1]-uv[0][1])*(px-uv[2][0]) + (uv[0][0]-uv[2][0])*(py-uv[2][1])) / d
+				c := 1 - a - b
+				const e = -1e-4
+				if a < e || b < e || c < e {
+					continue
+				}
+				texels[y*w+x] = bakeTexel{i, b, c}
+			}
+		}
+	}
+	return texels
+}
+
+// irradiance estimates the irradiance at p, on a
+// surface with normal n.
+func (bk *baker) irradiance(p, n *linear.V3, rng *rand.Rand) linear.V3 {
+	e := bk.direct(p, n, nil)
+	if bk.samples == 0 || bk.bounces == 0 {
+		return e
+	}
+	// Since the pdf of cosineDir is cos/π, the
+	// irradiance estimate of one sample is π
+	// times the incident radiance.
+	o := bk.offset(p, n)
+	var ind linear.V3
+	for range bk.samples {
+		d := cosineDir(n, rng)
+		l := bk.radiance(&o, &d, rng, bk.bounces)
+		ind.Add(&ind, &l)
+	}
+	ind.Scale(math.Pi/float32(bk.samples), &ind)
+	e.Add(&e, &ind)
+	return e
+}
+
+// dilateIters is the number of texels by which
+// dilate extends covered regions.
+const dilateIters = 4
+
+// dilate fills uncovered texels of data with the
+// average of their covered neighbors, repeatedly.
+func dilate(data []float32, texels []bakeTexel, w, h int) {
+	cov := make([]bool, w*h)
+	for i := range cov {
+		cov[i] = texels[i].tri >= 0
+	}
+	next := slices.Clone(cov)
+	for range dilateIters {
+		var changed bool
+		for y := range h {
+			for x := range w {
+				if cov[y*w+x] {
+					continue
+				}
+				var sum linear.V3
+				var n int
+				for dy := -1; dy <= 1; dy++ {
+					for dx := -1; dx <= 1; dx++ {
+						nx, ny := x+dx, y+dy
+						if nx < 0 || ny < 0 || nx >= w || ny >= h || !cov[ny*w+nx] {
+							continue
+						}
+						for i := range sum {
+							sum[i] += data[(ny*w+nx)*3+i]
+						}
+						n++
+					}
+				}
+				if n == 0 {
+					continue
+				}
+				for i := range sum {
+					data[(y*w+x)*3+i] = sum[i] / float32(n)
+				}
+				next[y*w+x] = true
+				changed = true
+			}
+		}
+		if !changed {
+			break
+		}
+		copy(cov, next)
+	}
+}
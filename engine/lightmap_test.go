@@ -0,0 +1,165 @@
+// Copyright 2024 Gustavo C. Viegas. All rights reserved.
+
+package engine
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"math"
+	"slices"
+	"testing"
+
+	"gviegas/neo3/driver"
+	"gviegas/neo3/linear"
+)
+
+// quadMesh creates a non-indexed quad spanning
+// [x0, x1] x [z0, z1] at height y, facing up, with
+// lightmap UVs covering the whole [0, 1] range.
+func quadMesh(x0, x1, y, z0, z1 float32) *MeshData {
+	pos := []float32{
+		x0, y, z0, x0, y, z1, x1, y, z1,
+		x0, y, z0, x1, y, z1, x1, y, z0,
+	}
+	uv := []float32{
+		0, 0, 0, 1, 1, 1,
+		0, 0, 1, 1, 1, 0,
+	}
+	var b []byte
+	for _, x := range append(pos, uv...) {
+		b = binary.LittleEndian.AppendUint32(b, math.Float32bits(x))
+	}
+	prim := PrimitiveData{
+		Topology:     driver.TTriangle,
+		VertexCount:  6,
+		SemanticMask: Position | LightmapUV,
+	}
+	prim.Semantics[Position.I()] = SemanticData{Format: driver.Float32x3}
+	prim.Semantics[LightmapUV.I()] = SemanticData{Format: driver.Float32x2, Offset: int64(len(pos) * 4)}
+	return &MeshData{
+		Primitives: []PrimitiveData{prim},
+		Srcs:       []io.ReadSeeker{bytes.NewReader(b)},
+	}
+}
+
+func TestBakeLightmap(t *testing.T) {
+	const w, h = 16, 16
+	floor := BakeMesh{Data: quadMesh(-1, 1, 0, -1, 1), World: linear.I4(), Albedo: [3]float32{0.5, 0.5, 0.5}, Bake: true}
+	sun := (&DistantLight{Direction: linear.V3{0, -1, 0}, Intensity: 2, R: 1, G: 1, B: 1}).Light()
+
+	// Direct lighting only.
+	data, err := BakeLightmap(&BakeParam{Width: w, Height: h, Meshes: []BakeMesh{floor}, Lights: []Light{sun}})
+	if err != nil {
+		t.Fatalf("BakeLightmap failed: %v", err)
+	}
+	if len(data) != w*h*3 {
+		t.Fatalf("BakeLightmap: len\nhave %d\nwant %d", len(data), w*h*3)
+	}
+	want := float32(2 / math.Pi)
+	for i, x := range data {
+		if math.Abs(float64(x-want)) > 1e-4 {
+			t.Fatalf("BakeLightmap: texel %d\nhave %v\nwant %v", i/3, x, want)
+		}
+	}
+
+	// An occluder over half of the floor, which is
+	// the V < 0.5 half of the lightmap.
+	roof := BakeMesh{Data: quadMesh(-2, 2, 1, -2, 0), World: linear.I4(), Albedo: [3]float32{0.5, 0.5, 0.5}}
+	data, err = BakeLightmap(&BakeParam{Width: w, Height: h, Meshes: []BakeMesh{floor, roof}, Lights: []Light{sun}})
+	if err != nil {
+		t.Fatalf("BakeLightmap failed: %v", err)
+	}
+	for y := range h {
+		x := data[(y*w+w/2)*3]
+		switch {
+		case y < h/2-1 && x != 0:
+			t.Fatalf("BakeLightmap: shadowed texel (%d, %d)\nhave %v\nwant 0", w/2, y, x)
+		case y > h/2 && math.Abs(float64(x-want)) > 1e-4:
+			t.Fatalf("BakeLightmap: lit texel (%d, %d)\nhave %v\nwant %v", w/2, y, x, want)
+		}
+	}
+
+	// Every ray escapes, so the sky contribution is
+	// exact regardless of the sample count.
+	param := BakeParam{
+		Width:   w,
+		Height:  h,
+		Meshes:  []BakeMesh{floor},
+		Sky:     [3]float32{0.25, 0.5, 1},
+		Samples: 3,
+		Bounces: 2,
+		Seed:    42,
+	}
+	data, err = BakeLightmap(&param)
+	if err != nil {
+		t.Fatalf("BakeLightmap failed: %v", err)
+	}
+	for i, x := range data {
+		if y := param.Sky[i%3]; math.Abs(float64(x-y)) > 1e-4 {
+			t.Fatalf("BakeLightmap: sky-lit texel %d\nhave %v\nwant %v", i/3, x, y)
+		}
+	}
+
+	// Sunlight reaches the shadowed half by bouncing
+	// off the lit half and then the roof's underside.
+	// Results depend only on the seed.
+	param = BakeParam{
+		Width:   w,
+		Height:  h,
+		Meshes:  []BakeMesh{floor, roof},
+		Lights:  []Light{sun},
+		Samples: 32,
+		Bounces: 2,
+		Seed:    7,
+	}
+	a, err := BakeLightmap(&param)
+	if err != nil {
+		t.Fatalf("BakeLightmap failed: %v", err)
+	}
+	b, _ := BakeLightmap(&param)
+	if !slices.Equal(a, b) {
+		t.Fatal("BakeLightmap: same seed should produce the same lightmap")
+	}
+	var sum float32
+	for x := range w {
+		sum += a[(h/4*w+x)*3]
+	}
+	if sum <= 0 || sum/w >= want {
+		t.Fatalf("BakeLightmap: indirect lighting\nhave %v\nwant in (0, %v)", sum/w, want)
+	}
+}
+
+func TestBakeLightmapInvalid(t *testing.T) {
+	floor := BakeMesh{Data: quadMesh(-1, 1, 0, -1, 1), World: linear.I4(), Bake: true}
+	noUV := quadMesh(-1, 1, 0, -1, 1)
+	noUV.Primitives[0].SemanticMask = Position
+	for _, x := range [...]*BakeParam{
+		nil,
+		{Width: 0, Height: 8, Meshes: []BakeMesh{floor}},
+		{Width: 8, Height: 8, Meshes: []BakeMesh{floor}, Samples: -1},
+		{Width: 8, Height: 8, Meshes: []BakeMesh{floor}, Bounces: -1},
+		{Width: 8, Height: 8, Meshes: []BakeMesh{{Data: floor.Data, World: linear.I4()}}},
+		{Width: 8, Height: 8, Meshes: []BakeMesh{floor, {Bake: true}}},
+		{Width: 8, Height: 8, Meshes: []BakeMesh{{Data: noUV, World: linear.I4(), Bake: true}}},
+	} {
+		if _, err := BakeLightmap(x); err == nil {
+			t.Fatalf("BakeLightmap(%v): unexpected success", x)
+		}
+	}
+}
+
+func TestNewLightmap(t *testing.T) {
+	data := []float32{0, 0.5, 1, 2, 4, 8}
+	tex, err := NewLightmap(2, 1, data)
+	if err != nil {
+		t.Fatalf("NewLightmap failed: %v", err)
+	}
+	defer tex.Free()
+	if pf := tex.PixelFmt(); pf != driver.RGBA16Float {
+		t.Fatalf("NewLightmap: Texture.PixelFmt\nhave %v\nwant %v", pf, driver.RGBA16Float)
+	}
+	if _, err := NewLightmap(2, 2, data); err == nil {
+		t.Fatal("NewLightmap: size mismatch should fail")
+	}
+}
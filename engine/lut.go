@@ -0,0 +1,221 @@
+// Copyright 2024 Gustavo C. Viegas. All rights reserved.
+
+package engine
+
+import (
+	"bufio"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+
+	"gviegas/neo3/driver"
+	"gviegas/neo3/linear"
+)
+
+// MaxLUTSize is the maximum size of a LUT.
+// Common sizes are 17, 33 and 65.
+const MaxLUTSize = 256
+
+// lutFmt is the pixel format of LUT textures.
+const lutFmt = driver.RGBA16Float
+
+// LUT is a 3D color lookup table.
+// Colors in the input domain are mapped to the LUT's
+// [0, 1] texture coordinates, with red along the X
+// axis, green along Y and blue along Z.
+type LUT struct {
+	tex      *Texture
+	min, max linear.V3
+}
+
+// NewLUTFromCube creates a LUT from a .cube file.
+// Only 3D tables are supported.
+// The copy of the table's data may be delayed.
+func NewLUTFromCube(r io.Reader) (*LUT, error) {
+	c, err := parseCube(r)
+	if err != nil {
+		return nil, err
+	}
+	tex, err := New3D(&TexParam{
+		PixelFmt: lutFmt,
+		Dim3D:    driver.Dim3D{Width: c.size, Height: c.size, Depth: c.size},
+		Layers:   1,
+		Levels:   1,
+		Samples:  1,
+	})
+	if err != nil {
+		return nil, err
+	}
+	if err = tex.CopyToView(0, c.pix(), false); err != nil {
+		tex.Free()
+		return nil, err
+	}
+	return &LUT{tex, c.min, c.max}, nil
+}
+
+// Size returns the number of entries along each
+// dimension of l.
+func (l *LUT) Size() int { return l.tex.Width() }
+
+// Domain returns the input range of l.
+func (l *LUT) Domain() (min, max linear.V3) { return l.min, l.max }
+
+// Free invalidates l and frees its texture.
+func (l *LUT) Free() {
+	if l.tex != nil {
+		l.tex.Free()
+	}
+	*l = LUT{}
+}
+
+// cubeLUT is the parsed content of a .cube file.
+type cubeLUT struct {
+	size     int
+	min, max linear.V3
+	// RGB triples. Red varies fastest.
+	data []float32
+}
+
+// parseCube parses a .cube file.
+func parseCube(r io.Reader) (*cubeLUT, error) {
+	c := &cubeLUT{max: linear.V3{1, 1, 1}}
+	sc := bufio.NewScanner(r)
+	for ln := 1; sc.Scan(); ln++ {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" || line[0] == '#' {
+			continue
+		}
+		fields := strings.Fields(line)
+		lnErr := func(reason string) error {
+			return newTexErr("cube line " + strconv.Itoa(ln) + ": " + reason)
+		}
+		switch fields[0] {
+		case "TITLE":
+			continue
+		case "LUT_1D_SIZE":
+			return nil, lnErr("1D LUT not supported")
+		case "LUT_3D_SIZE":
+			if c.size != 0 {
+				return nil, lnErr("duplicate LUT_3D_SIZE")
+			}
+			n, err := strconv.Atoi(strings.Join(fields[1:], " "))
+			if err != nil || n < 2 || n > MaxLUTSize {
+				return nil, lnErr("invalid LUT_3D_SIZE")
+			}
+			c.size = n
+			c.data = make([]float32, 0, 3*n*n*n)
+			continue
+		case "DOMAIN_MIN", "DOMAIN_MAX":
+			v, ok := parseCubeRGB(fields[1:])
+			if !ok {
+				return nil, lnErr("invalid " + fields[0])
+			}
+			if fields[0] == "DOMAIN_MIN" {
+				c.min = v
+			} else {
+				c.max = v
+			}
+			continue
+		}
+		v, ok := parseCubeRGB(fields)
+		switch {
+		case !ok:
+			return nil, lnErr("unexpected " + strconv.Quote(fields[0]))
+		case c.size == 0:
+			return nil, lnErr("table data before LUT_3D_SIZE")
+		case len(c.data) == cap(c.data):
+			return nil, lnErr("too many table entries")
+		}
+		c.data = append(c.data, v[:]...)
+	}
+	if err := sc.Err(); err != nil {
+		return nil, err
+	}
+	var reason string
+	switch {
+	case c.size == 0:
+		reason = "missing LUT_3D_SIZE"
+	case len(c.data) != cap(c.data):
+		reason = "missing table entries"
+	case c.min[0] >= c.max[0] || c.min[1] >= c.max[1] || c.min[2] >= c.max[2]:
+		reason = "invalid domain"
+	default:
+		return c, nil
+	}
+	return nil, newTexErr("cube: " + reason)
+}
+
+// parseCubeRGB parses three floating-point fields.
+func parseCubeRGB(fields []string) (v linear.V3, ok bool) {
+	if len(fields) != 3 {
+		return
+	}
+	for i, s := range fields {
+		x, err := strconv.ParseFloat(s, 32)
+		if err != nil {
+			return
+		}
+		v[i] = float32(x)
+	}
+	return v, true
+}
+
+// pix returns c's table as tightly packed RGBA16Float
+// data. Alpha is set to 1.
+func (c *cubeLUT) pix() []byte { return halfPixRGB(c.data) }
+
+// halfPixRGB converts tightly packed RGB values into
+// tightly packed RGBA16Float data. Alpha is set to 1.
+func halfPixRGB(rgb []float32) []byte {
+	const one = 0x3c00
+	pix := make([]byte, 0, len(rgb)/3*8)
+	for i := 0; i+2 < len(rgb); i += 3 {
+		r, g, b := halfBits(rgb[i]), halfBits(rgb[i+1]), halfBits(rgb[i+2])
+		pix = append(pix,
+			byte(r), byte(r>>8),
+			byte(g), byte(g>>8),
+			byte(b), byte(b>>8),
+			one&0xff, one>>8)
+	}
+	return pix
+}
+
+// halfBits converts f to a IEEE 754 half-precision
+// value, rounding to nearest even.
+// Values that are too large become infinity.
+func halfBits(f float32) uint16 {
+	x := math.Float32bits(f)
+	sign := uint16(x>>16) & 0x8000
+	exp := int(x>>23&0xff) - 127 + 15
+	mant := x & 0x7fffff
+	switch {
+	case x&0x7fffffff > 0x7f800000:
+		// NaN.
+		return sign | 0x7e00
+	case exp >= 0x1f:
+		return sign | 0x7c00
+	case exp <= 0:
+		if exp < -10 {
+			return sign
+		}
+		// Subnormal.
+		mant |= 0x800000
+		shift := uint(14 - exp)
+		h := mant >> shift
+		rem := mant & (1<<shift - 1)
+		half := uint32(1) << (shift - 1)
+		if rem > half || rem == half && h&1 != 0 {
+			h++
+		}
+		return sign | uint16(h)
+	}
+	h := uint32(exp)<<10 | mant>>13
+	rem := mant & 0x1fff
+	if rem > 0x1000 || rem == 0x1000 && h&1 != 0 {
+		// May carry into the exponent, which
+		// correctly yields infinity on overflow.
+		h++
+	}
+	return sign | uint16(h)
+}
@@ -0,0 +1,135 @@
+// Copyright 2024 Gustavo C. Viegas. All rights reserved.
+
+package engine
+
+import (
+	"math"
+	"strings"
+	"testing"
+
+	"gviegas/neo3/linear"
+)
+
+const testCube = `# Identity.
+TITLE "test"
+LUT_3D_SIZE 2
+DOMAIN_MIN 0 0 0
+DOMAIN_MAX 1 1 2
+
+0 0 0
+1 0 0
+0 1 0
+1 1 0
+0 0 1
+1 0 1
+0 1 1
+1 1 1
+`
+
+func TestParseCube(t *testing.T) {
+	c, err := parseCube(strings.NewReader(testCube))
+	if err != nil {
+		t.Fatalf("parseCube failed:\n%v", err)
+	}
+	if c.size != 2 {
+		t.Fatalf("parseCube: size\nhave %d\nwant 2", c.size)
+	}
+	if c.min != (linear.V3{}) || c.max != (linear.V3{1, 1, 2}) {
+		t.Fatalf("parseCube: domain\nhave %v, %v\nwant %v, %v", c.min, c.max, linear.V3{}, linear.V3{1, 1, 2})
+	}
+	if n := len(c.data); n != 3*8 {
+		t.Fatalf("parseCube: len(data)\nhave %d\nwant %d", n, 3*8)
+	}
+	// Red varies fastest.
+	for i := range 8 {
+		want := [3]float32{float32(i & 1), float32(i >> 1 & 1), float32(i >> 2)}
+		if x := [3]float32(c.data[3*i:]); x != want {
+			t.Fatalf("parseCube: data[%d]\nhave %v\nwant %v", i, x, want)
+		}
+	}
+	pix := c.pix()
+	if n := len(pix); n != 8*8 {
+		t.Fatalf("cubeLUT.pix: len\nhave %d\nwant %d", n, 8*8)
+	}
+	// Last entry is white.
+	for i, x := range pix[7*8:] {
+		if want := [8]byte{0x00, 0x3c, 0x00, 0x3c, 0x00, 0x3c, 0x00, 0x3c}[i]; x != want {
+			t.Fatalf("cubeLUT.pix: [%d]\nhave 0x%02x\nwant 0x%02x", 7*8+i, x, want)
+		}
+	}
+
+	for _, s := range [...]string{
+		"",
+		"0 0 0\n",
+		"LUT_1D_SIZE 2\n",
+		"LUT_3D_SIZE 1\n0 0 0\n",
+		"LUT_3D_SIZE 2\n0 0 0\n",
+		"LUT_3D_SIZE 2\nLUT_3D_SIZE 2\n",
+		"LUT_3D_SIZE 2\nDOMAIN_MIN 1 1 1\nDOMAIN_MAX 1 1 1\n" + strings.Repeat("0 0 0\n", 8),
+		"LUT_3D_SIZE 2\n" + strings.Repeat("0 0 0\n", 9),
+		"LUT_3D_SIZE 2\n" + strings.Repeat("0 0\n", 8),
+		"LUT_3D_SIZE 2\nKEYWORD 1\n" + strings.Repeat("0 0 0\n", 8),
+	} {
+		_, err := parseCube(strings.NewReader(s))
+		switch {
+		case err == nil:
+			t.Fatalf("parseCube: unexpected success\n%q", s)
+		case !strings.HasPrefix(err.Error(), texPrefix):
+			t.Fatalf("parseCube: unexpected error:\n%v", err)
+		}
+	}
+}
+
+func TestHalfBits(t *testing.T) {
+	for _, x := range [...]struct {
+		f float32
+		h uint16
+	}{
+		{0, 0x0000},
+		{float32(math.Copysign(0, -1)), 0x8000},
+		{1, 0x3c00},
+		{-2, 0xc000},
+		{0.5, 0x3800},
+		{65504, 0x7bff},
+		{65520, 0x7c00},
+		{1e10, 0x7c00},
+		{float32(math.Inf(-1)), 0xfc00},
+		{0x1p-14, 0x0400},
+		{0x1p-24, 0x0001},
+		{0x1p-26, 0x0000},
+		{1 + 0x1p-11, 0x3c00},
+		{1 + 3*0x1p-11, 0x3c02},
+	} {
+		if h := halfBits(x.f); h != x.h {
+			t.Fatalf("halfBits(%v):\nhave 0x%04x\nwant 0x%04x", x.f, h, x.h)
+		}
+	}
+	if h := halfBits(float32(math.NaN())); h&0x7c00 != 0x7c00 || h&0x3ff == 0 {
+		t.Fatalf("halfBits(NaN):\nhave 0x%04x\nwant NaN", h)
+	}
+}
+
+func TestWhiteBalance(t *testing.T) {
+	if m := whiteBalance(0, 0); m != linear.I3() {
+		t.Fatalf("whiteBalance(0, 0):\nhave %v\nwant %v", m, linear.I3())
+	}
+	// Warmer means more red and less blue.
+	white := linear.V3{1, 1, 1}
+	var v linear.V3
+	m := whiteBalance(0.5, 0)
+	v.Mul(&m, &white)
+	if v[0] <= v[2] {
+		t.Fatalf("whiteBalance(0.5, 0): white should become warmer\nhave %v", v)
+	}
+	m = whiteBalance(-0.5, 0)
+	v.Mul(&m, &white)
+	if v[0] >= v[2] {
+		t.Fatalf("whiteBalance(-0.5, 0): white should become cooler\nhave %v", v)
+	}
+	// Positive tint means less green.
+	m = whiteBalance(0, 0.5)
+	v.Mul(&m, &white)
+	if v[1] >= v[0] || v[1] >= v[2] {
+		t.Fatalf("whiteBalance(0, 0.5): white should become magenta\nhave %v", v)
+	}
+}
@@ -0,0 +1,239 @@
+// Copyright 2024 Gustavo C. Viegas. All rights reserved.
+
+package engine
+
+import (
+	"cmp"
+	"math"
+	"slices"
+	"time"
+
+	"gviegas/neo3/engine/internal/shader"
+)
+
+// UVKey is a keyframe of a material's UV transform.
+// Texture coordinates are transformed as
+// uv * Scale + Offset.
+type UVKey struct {
+	Time   time.Duration
+	Offset [2]float32
+	Scale  [2]float32
+}
+
+// Flipbook describes a texture atlas whose cells are
+// displayed in sequence.
+// Cells are laid out in row-major order, starting at
+// the cell whose UV offset is (0, 0).
+type Flipbook struct {
+	Columns int
+	Rows    int
+	// Number of cells to play.
+	// Zero means Columns * Rows.
+	Frames int
+	// Frames per second.
+	FPS float32
+	// Whether to restart after the last frame.
+	// Otherwise the last frame is held.
+	Loop bool
+}
+
+// MaterialAnim describes how the parameters of a
+// material change over time.
+// The UV transform is computed by interpolating UV
+// linearly, then adding Scroll multiplied by the
+// material time to the offset. If Flipbook is not
+// nil, the result is then mapped into the current
+// flipbook cell.
+type MaterialAnim struct {
+	// Keyframes in increasing Time order.
+	// If empty, the UV transform is the identity.
+	UV []UVKey
+	// Whether to repeat UV keyframes.
+	LoopUV bool
+	// Scroll speed, in UV units per second.
+	Scroll   [2]float32
+	Flipbook *Flipbook
+}
+
+// matAnim is the animation state of a Material.
+type matAnim struct {
+	MaterialAnim
+	time time.Duration
+	// Set by Renderer.UpdateMaterials to avoid
+	// advancing shared materials more than once.
+	update uint64
+}
+
+// SetAnim sets the animation of m.
+// If anim is nil, m's animation is removed and its
+// UV transform is reset.
+// Setting an animation resets the material time.
+func (m *Material) SetAnim(anim *MaterialAnim) error {
+	if anim == nil {
+		m.anim = nil
+		m.layout.SetUVTransform([2]float32{}, [2]float32{1, 1})
+		m.layout.SetTime(0)
+		m.layout.SetFlags(m.layout.Flags() &^ shader.MatUVTransform)
+		return nil
+	}
+	if err := anim.validate(); err != nil {
+		return err
+	}
+	a := &matAnim{MaterialAnim: *anim}
+	a.UV = slices.Clone(anim.UV)
+	if anim.Flipbook != nil {
+		fb := *anim.Flipbook
+		if fb.Frames == 0 {
+			fb.Frames = fb.Columns * fb.Rows
+		}
+		a.Flipbook = &fb
+	}
+	m.anim = a
+	m.layout.SetFlags(m.layout.Flags() | shader.MatUVTransform)
+	m.SetTime(0)
+	return nil
+}
+
+// SetTime sets the time of m's animation.
+// It has no effect if m is not animated.
+func (m *Material) SetTime(t time.Duration) {
+	if m.anim == nil {
+		return
+	}
+	m.anim.time = t
+	off, scale := m.anim.uvTransform()
+	m.layout.SetUVTransform(off, scale)
+	m.layout.SetTime(float32(t.Seconds()))
+}
+
+// Time returns the time of m's animation.
+func (m *Material) Time() time.Duration {
+	if m.anim == nil {
+		return 0
+	}
+	return m.anim.time
+}
+
+// Advance advances the time of m's animation by dt.
+func (m *Material) Advance(dt time.Duration) {
+	if m.anim != nil {
+		m.SetTime(m.anim.time + dt)
+	}
+}
+
+// uvTransform computes the UV transform at a.time.
+func (a *matAnim) uvTransform() (off, scale [2]float32) {
+	scale = [2]float32{1, 1}
+	if n := len(a.UV); n > 0 {
+		t := a.time
+		if last := a.UV[n-1].Time; a.LoopUV && last > 0 {
+			t %= last
+			if t < 0 {
+				t += last
+			}
+		}
+		i, _ := slices.BinarySearchFunc(a.UV, t, func(k UVKey, t time.Duration) int {
+			return cmp.Compare(k.Time, t)
+		})
+		switch {
+		case i == 0:
+			off, scale = a.UV[0].Offset, a.UV[0].Scale
+		case i == n:
+			off, scale = a.UV[n-1].Offset, a.UV[n-1].Scale
+		default:
+			k0, k1 := &a.UV[i-1], &a.UV[i]
+			s := float32(t-k0.Time) / float32(k1.Time-k0.Time)
+			for j := range off {
+				off[j] = k0.Offset[j] + (k1.Offset[j]-k0.Offset[j])*s
+				scale[j] = k0.Scale[j] + (k1.Scale[j]-k0.Scale[j])*s
+			}
+		}
+	}
+	secs := a.time.Seconds()
+	for j := range off {
+		// Only the fractional part of the
+		// scroll is kept, which preserves
+		// precision over time.
+		x := float64(a.Scroll[j]) * secs
+		off[j] += float32(x - math.Floor(x))
+	}
+	if fb := a.Flipbook; fb != nil {
+		frame := int(math.Floor(secs * float64(fb.FPS)))
+		if fb.Loop {
+			frame %= fb.Frames
+			if frame < 0 {
+				frame += fb.Frames
+			}
+		} else {
+			frame = min(max(frame, 0), fb.Frames-1)
+		}
+		cs := [2]float32{1 / float32(fb.Columns), 1 / float32(fb.Rows)}
+		co := [2]float32{float32(frame%fb.Columns) * cs[0], float32(frame/fb.Columns) * cs[1]}
+		for j := range off {
+			off[j] = off[j]*cs[j] + co[j]
+			scale[j] *= cs[j]
+		}
+	}
+	return
+}
+
+// UpdateMaterials advances by dt the animation of every
+// material used by r's drawables, decals and foliage.
+// Materials that are shared between drawables are
+// advanced only once. Materials that are shared with
+// other renderers will be advanced by each of them,
+// so in this case Material.Advance should be called
+// directly instead.
+func (r *Renderer) UpdateMaterials(dt time.Duration) {
+	defer traceBegin(traceUpdate, "materials").end()
+	matUpdate++
+	advance := func(m *Material) {
+		if m.anim != nil && m.anim.update != matUpdate {
+			m.anim.update = matUpdate
+			m.Advance(dt)
+		}
+	}
+	for _, d := range r.drawables.all() {
+		for _, m := range d.mat {
+			advance(m)
+		}
+	}
+	for _, d := range r.decals.all() {
+		advance(d.mat)
+	}
+	for _, f := range r.foliage.all() {
+		advance(f.mat)
+	}
+}
+
+// Incremented by Renderer.UpdateMaterials.
+var matUpdate uint64
+
+func (a *MaterialAnim) validate() error {
+	var reason string
+	for i := range a.UV {
+		switch {
+		case a.UV[i].Time < 0:
+			reason = "negative UV keyframe time"
+		case i > 0 && a.UV[i].Time <= a.UV[i-1].Time:
+			reason = "UV keyframes not in increasing time order"
+		default:
+			continue
+		}
+		return newMatErr(reason)
+	}
+	if fb := a.Flipbook; fb != nil {
+		switch {
+		case fb.Columns < 1, fb.Rows < 1:
+			reason = "invalid flipbook dimensions"
+		case fb.Frames < 0 || fb.Frames > fb.Columns*fb.Rows:
+			reason = "invalid flipbook frame count"
+		case !(fb.FPS > 0):
+			reason = "invalid flipbook FPS"
+		default:
+			return nil
+		}
+		return newMatErr(reason)
+	}
+	return nil
+}
@@ -0,0 +1,132 @@
+// Copyright 2024 Gustavo C. Viegas. All rights reserved.
+
+package engine
+
+import (
+	"testing"
+	"time"
+
+	"gviegas/neo3/engine/internal/shader"
+)
+
+func TestMaterialAnim(t *testing.T) {
+	mat, err := NewUnlit(&Unlit{})
+	if err != nil {
+		t.Fatalf("NewUnlit failed:\n%v", err)
+	}
+	if off, scale := mat.layout.UVTransform(); off != [2]float32{} || scale != [2]float32{1, 1} {
+		t.Fatalf("NewUnlit: UV transform\nhave %v, %v\nwant [0 0], [1 1]", off, scale)
+	}
+	if mat.layout.Flags()&shader.MatUVTransform != 0 {
+		t.Fatal("NewUnlit: unexpected shader.MatUVTransform flag")
+	}
+
+	for _, x := range [...]MaterialAnim{
+		{UV: []UVKey{{Time: -1}}},
+		{UV: []UVKey{{Time: time.Second}, {Time: time.Second}}},
+		{Flipbook: &Flipbook{Columns: 0, Rows: 1, FPS: 1}},
+		{Flipbook: &Flipbook{Columns: 2, Rows: 2, Frames: 5, FPS: 1}},
+		{Flipbook: &Flipbook{Columns: 2, Rows: 2}},
+	} {
+		if err := mat.SetAnim(&x); err == nil {
+			t.Fatalf("Material.SetAnim(%v): unexpected success", x)
+		}
+	}
+
+	check := func(d time.Duration, off, scale [2]float32) {
+		t.Helper()
+		mat.SetTime(d)
+		x, y := mat.layout.UVTransform()
+		for i := range x {
+			if abs(x[i]-off[i]) > 1e-5 || abs(y[i]-scale[i]) > 1e-5 {
+				t.Fatalf("Material.SetTime(%v): UV transform\nhave %v, %v\nwant %v, %v", d, x, y, off, scale)
+			}
+		}
+		if x := mat.layout.Time(); x != float32(d.Seconds()) {
+			t.Fatalf("Material.SetTime(%v): layout time\nhave %v\nwant %v", d, x, d.Seconds())
+		}
+	}
+
+	err = mat.SetAnim(&MaterialAnim{
+		UV: []UVKey{
+			{Time: time.Second, Offset: [2]float32{0, 0}, Scale: [2]float32{1, 1}},
+			{Time: 3 * time.Second, Offset: [2]float32{0.5, 0.25}, Scale: [2]float32{2, 1}},
+		},
+		LoopUV: true,
+	})
+	if err != nil {
+		t.Fatalf("Material.SetAnim failed:\n%v", err)
+	}
+	if mat.layout.Flags()&shader.MatUVTransform == 0 {
+		t.Fatal("Material.SetAnim: missing shader.MatUVTransform flag")
+	}
+	check(0, [2]float32{}, [2]float32{1, 1})
+	check(2*time.Second, [2]float32{0.25, 0.125}, [2]float32{1.5, 1})
+	check(3*time.Second-1, [2]float32{0.5, 0.25}, [2]float32{2, 1})
+	// Looped.
+	check(5*time.Second, [2]float32{0.25, 0.125}, [2]float32{1.5, 1})
+
+	err = mat.SetAnim(&MaterialAnim{
+		Scroll:   [2]float32{0.75, 0},
+		Flipbook: &Flipbook{Columns: 4, Rows: 2, Frames: 6, FPS: 2},
+	})
+	if err != nil {
+		t.Fatalf("Material.SetAnim failed:\n%v", err)
+	}
+	check(0, [2]float32{}, [2]float32{0.25, 0.5})
+	// Frame 1, scrolled by 0.375.
+	check(time.Second/2, [2]float32{0.375*0.25 + 0.25, 0}, [2]float32{0.25, 0.5})
+	// Frame 5 (last), scrolled by 0.25.
+	check(3*time.Second, [2]float32{0.25*0.25 + 0.25, 0.5}, [2]float32{0.25, 0.5})
+	// Held.
+	check(10*time.Second, [2]float32{0.25 + 0.5*0.25, 0.5}, [2]float32{0.25, 0.5})
+
+	mat.Advance(time.Second)
+	if x := mat.Time(); x != 11*time.Second {
+		t.Fatalf("Material.Advance: Time\nhave %v\nwant %v", x, 11*time.Second)
+	}
+
+	if err = mat.SetAnim(nil); err != nil {
+		t.Fatalf("Material.SetAnim(nil) failed:\n%v", err)
+	}
+	mat.Advance(time.Second)
+	if off, scale := mat.layout.UVTransform(); off != [2]float32{} || scale != [2]float32{1, 1} || mat.Time() != 0 {
+		t.Fatal("Material.SetAnim(nil): animation not removed")
+	}
+	if mat.layout.Flags()&shader.MatUVTransform != 0 {
+		t.Fatal("Material.SetAnim(nil): unexpected shader.MatUVTransform flag")
+	}
+}
+
+func abs(x float32) float32 { return max(x, -x) }
+
+func TestRendererUpdateMaterials(t *testing.T) {
+	rend, err := NewOffscreen(256, 192)
+	if err != nil {
+		t.Fatalf("RendererUpdateMaterials: NewOffscreen failed:\n%v", err)
+	}
+	defer rend.Free()
+	var mat [2]*Material
+	for i := range mat {
+		if mat[i], err = NewPBR(&PBR{}); err != nil {
+			t.Fatalf("RendererUpdateMaterials: NewPBR failed:\n%v", err)
+		}
+	}
+	mat[0].SetAnim(&MaterialAnim{Scroll: [2]float32{0.1, 0}})
+	var d drawable
+	d.mat = []*Material{mat[0], mat[1], mat[0]}
+	id := rend.drawables.insert(d)
+	defer rend.drawables.remove(id)
+	d.mat = []*Material{mat[0]}
+	id2 := rend.drawables.insert(d)
+	defer rend.drawables.remove(id2)
+
+	rend.UpdateMaterials(time.Second)
+	rend.UpdateMaterials(time.Second)
+	if x := mat[0].Time(); x != 2*time.Second {
+		t.Fatalf("Renderer.UpdateMaterials: Time\nhave %v\nwant %v", x, 2*time.Second)
+	}
+	if x := mat[1].Time(); x != 0 {
+		t.Fatalf("Renderer.UpdateMaterials: Time\nhave %v\nwant 0", x)
+	}
+}
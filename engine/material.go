@@ -3,15 +3,14 @@
 package engine
 
 import (
-	"errors"
-
+	"gviegas/neo3/driver"
 	"gviegas/neo3/engine/internal/shader"
 	"gviegas/neo3/linear"
 )
 
 const matPrefix = "material: "
 
-func newMatErr(reason string) error { return errors.New(matPrefix + reason) }
+func newMatErr(reason string) error { return newErr(matPrefix, reason, ErrInvalidParam) }
 
 // Material defines the material properties to be applied
 // to geometry during rendering.
@@ -21,7 +20,11 @@ type Material struct {
 	normal     TexRef
 	occlusion  TexRef
 	emissive   TexRef
+	lightmap   TexRef
 	layout     shader.MaterialLayout
+	stencil    StencilState
+	anim       *matAnim
+	id         MaterialID
 
 	// TODO: Descriptors; const buffer.
 }
@@ -29,6 +32,9 @@ type Material struct {
 // TexRef identifies a particular view of a 2D texture
 // and its sampler, with sampling operations using a
 // given UV set.
+// The texture can be a render target created by
+// NewTarget, in which case it can be rendered to by
+// an earlier pass of the same frame.
 type TexRef struct {
 	Texture *Texture
 	View    int
@@ -42,6 +48,9 @@ const (
 	UVSet0 = iota
 	// TexCoord1.
 	UVSet1
+
+	// LightmapUV.
+	UVSetLightmap = UVSet1
 )
 
 // BaseColor is the material's base color.
@@ -75,6 +84,16 @@ type EmissiveMap struct {
 	Factor [3]float32
 }
 
+// Lightmap is the material's lightmap.
+// It holds precomputed diffuse lighting, as created
+// by BakeLightmap, and should use UVSetLightmap.
+// When present, it replaces the irradiance used for
+// indirect diffuse lighting, scaled by Intensity.
+type Lightmap struct {
+	TexRef
+	Intensity float32
+}
+
 // Alpha modes.
 const (
 	// No transparency.
@@ -88,15 +107,22 @@ const (
 )
 
 // PBR defines properties of the default material model.
+// Stencil is the stencil test and writes of primitives
+// that use the material. It can only use StencilDecal
+// and stencil bits from StencilUser, which must be
+// reserved in the Renderer (see
+// Renderer.ReserveStencil).
 type PBR struct {
 	BaseColor   BaseColor
 	MetalRough  MetalRough
 	Normal      NormalMap
 	Occlusion   OcclusionMap
 	Emissive    EmissiveMap
+	Lightmap    Lightmap
 	AlphaMode   int
 	AlphaCutoff float32
 	DoubleSided bool
+	Stencil     StencilState
 }
 
 // shaderLayout creates the shader.MaterialLayout of p.
@@ -108,7 +134,12 @@ func (p *PBR) shaderLayout() (l shader.MaterialLayout) {
 	l.SetOccStrength(p.Occlusion.Strength)
 	l.SetEmisFactor((*linear.V3)(&p.Emissive.Factor))
 	l.SetAlphaCutoff(p.AlphaCutoff)
+	l.SetUVTransform([2]float32{}, [2]float32{1, 1})
 	flags := shader.MatPBR
+	if p.Lightmap.Texture != nil {
+		l.SetLightmapIntensity(p.Lightmap.Intensity)
+		flags |= shader.MatLightmap
+	}
 	switch p.AlphaMode {
 	case AlphaOpaque:
 		flags |= shader.MatAOpaque
@@ -125,11 +156,13 @@ func (p *PBR) shaderLayout() (l shader.MaterialLayout) {
 }
 
 // Unlit defines properties of the unlit material model.
+// Stencil is as described in PBR.
 type Unlit struct {
 	BaseColor   BaseColor
 	AlphaMode   int
 	AlphaCutoff float32
 	DoubleSided bool
+	Stencil     StencilState
 }
 
 // shaderLayout creates the shader.MaterialLayout of u.
@@ -137,6 +170,7 @@ type Unlit struct {
 func (u *Unlit) shaderLayout() (l shader.MaterialLayout) {
 	l.SetColorFactor((*linear.V4)(&u.BaseColor.Factor))
 	l.SetAlphaCutoff(u.AlphaCutoff)
+	l.SetUVTransform([2]float32{}, [2]float32{1, 1})
 	flags := shader.MatUnlit
 	switch u.AlphaMode {
 	case AlphaOpaque:
@@ -164,7 +198,9 @@ func NewPBR(prop *PBR) (*Material, error) {
 		normal:     prop.Normal.TexRef,
 		occlusion:  prop.Occlusion.TexRef,
 		emissive:   prop.Emissive.TexRef,
+		lightmap:   prop.Lightmap.TexRef,
 		layout:     prop.shaderLayout(),
+		stencil:    prop.Stencil,
 	}, nil
 }
 
@@ -176,22 +212,44 @@ func NewUnlit(prop *Unlit) (*Material, error) {
 	return &Material{
 		baseColor: prop.BaseColor.TexRef,
 		layout:    prop.shaderLayout(),
+		stencil:   prop.Stencil,
 	}, nil
 }
 
+// Stencil returns the stencil state of m.
+func (m *Material) Stencil() StencilState { return m.stencil }
+
+// Free invalidates m.
+// Materials hold no GPU resources of their own (the
+// textures they refer to must be freed separately),
+// so this is only needed to invalidate m's MaterialID
+// before m becomes unreachable.
+func (m *Material) Free() {
+	materialIDs.release((*uint64)(&m.id))
+	*m = Material{}
+}
+
 // Parameter validation for New* functions.
 
 func (p *TexRef) validate(optional bool) error {
-	// TODO: Should ensure somehow that the Texture
-	// was created by a call to New2D
-	// (it is fine to ignore this for now because
-	// all textures support driver.UShaderSample).
 	if p.Texture == nil {
 		if optional {
 			return nil
 		}
 		return newMatErr("nil TexRef.Texture")
 	}
+	// Render targets are allowed, provided that
+	// they can be sampled. They are transitioned
+	// to a read-only layout by the frame graph.
+	if p.Texture.usage&driver.UShaderSample == 0 {
+		return newMatErr("TexRef.Texture cannot be sampled")
+	}
+	if p.Texture.Samples() != 1 {
+		return newMatErr("TexRef.Texture is multi-sample")
+	}
+	if p.Texture.Depth() != 0 {
+		return newMatErr("TexRef.Texture is not 2D")
+	}
 	if !p.Texture.IsValidView(p.View) {
 		return newMatErr("invalid TexRef.View")
 	}
@@ -281,6 +339,21 @@ func (p *EmissiveMap) validate() error {
 	return nil
 }
 
+func (p *Lightmap) validate() error {
+	if p.Texture != nil {
+		if err := p.TexRef.validate(false); err != nil {
+			return err
+		}
+		if p.Texture.PixelFmt().Channels() < 3 {
+			return newMatErr("Lightmap.Texture has insufficient channels")
+		}
+	}
+	if p.Intensity < 0 {
+		return newMatErr("Lightmap.Intensity less than 0.0")
+	}
+	return nil
+}
+
 func validateAlphaMode(mode int, cutoff float32) error {
 	switch mode {
 	case AlphaOpaque, AlphaBlend:
@@ -309,10 +382,13 @@ func (p *PBR) validate() error {
 	if err := p.Emissive.validate(); err != nil {
 		return err
 	}
+	if err := p.Lightmap.validate(); err != nil {
+		return err
+	}
 	if err := validateAlphaMode(p.AlphaMode, p.AlphaCutoff); err != nil {
 		return err
 	}
-	return nil
+	return validateMatStencil(&p.Stencil)
 }
 
 func (p *Unlit) validate() error {
@@ -322,5 +398,12 @@ func (p *Unlit) validate() error {
 	if err := validateAlphaMode(p.AlphaMode, p.AlphaCutoff); err != nil {
 		return err
 	}
+	return validateMatStencil(&p.Stencil)
+}
+
+func validateMatStencil(s *StencilState) error {
+	if reason := s.validate(StencilDecal | StencilUser); reason != "" {
+		return newMatErr(reason)
+	}
 	return nil
 }
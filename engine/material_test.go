@@ -7,6 +7,7 @@ import (
 	"testing"
 
 	"gviegas/neo3/driver"
+	"gviegas/neo3/engine/internal/shader"
 )
 
 func TestMaterial(t *testing.T) {
@@ -105,6 +106,7 @@ func TestMaterial(t *testing.T) {
 				normal:     prop.Normal.TexRef,
 				occlusion:  prop.Occlusion.TexRef,
 				emissive:   prop.Emissive.TexRef,
+				lightmap:   prop.Lightmap.TexRef,
 				layout:     prop.shaderLayout(),
 			}
 		case *Unlit:
@@ -130,6 +132,9 @@ func TestMaterial(t *testing.T) {
 		if mat.emissive != want.emissive {
 			t.Fatalf("New*: Material.emissive\nhave %v\nwant %v", mat.emissive, want.emissive)
 		}
+		if mat.lightmap != want.lightmap {
+			t.Fatalf("New*: Material.lightmap\nhave %v\nwant %v", mat.lightmap, want.lightmap)
+		}
 		if mat.layout != want.layout {
 			// TODO: Should validate layout contents.
 			t.Fatalf("New*: Material.layout\nhave %v\nwant %v", mat.layout, want.layout)
@@ -173,11 +178,18 @@ func TestMaterial(t *testing.T) {
 				TexRef: TexRef{emissive, 0, splr, UVSet0},
 				Factor: [3]float32{1, 1, 1},
 			},
+			Lightmap: Lightmap{
+				TexRef:    TexRef{emissive, 0, splr, UVSetLightmap},
+				Intensity: 2,
+			},
 			AlphaMode:   AlphaOpaque,
 			DoubleSided: false,
 		}
 		mat, err = NewPBR(&pbr)
 		check(mat, err, &pbr)
+		if mat.layout.Flags()&shader.MatLightmap == 0 || mat.layout.LightmapIntensity() != 2 {
+			t.Fatalf("NewPBR: Material.layout lightmap\nhave %t, %v\nwant true, 2", mat.layout.Flags()&shader.MatLightmap != 0, mat.layout.LightmapIntensity())
+		}
 
 		pbr = PBR{
 			BaseColor: BaseColor{
@@ -501,6 +513,22 @@ func TestMaterial(t *testing.T) {
 		})
 		checkFail(mat, err, "EmissiveMap.Factor outside [0.0, 1.0] interval")
 
+		mat, err = NewPBR(&PBR{
+			Lightmap: Lightmap{
+				TexRef:    TexRef{twoChTex, 0, splr, UVSetLightmap},
+				Intensity: 1,
+			},
+		})
+		checkFail(mat, err, "Lightmap.Texture has insufficient channels")
+
+		mat, err = NewPBR(&PBR{
+			Lightmap: Lightmap{
+				TexRef:    TexRef{emissive, 0, splr, UVSetLightmap},
+				Intensity: -1,
+			},
+		})
+		checkFail(mat, err, "Lightmap.Intensity less than 0.0")
+
 		mat, err = NewPBR(&PBR{AlphaMode: AlphaMask + 1})
 		checkFail(mat, err, "undefined alpha mode constant")
 	})
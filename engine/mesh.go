@@ -5,21 +5,30 @@ package engine
 import (
 	"bytes"
 	"encoding/binary"
-	"errors"
 	"fmt"
 	"io"
 	"math"
+	"runtime"
+	"strconv"
 	"sync"
-	"unsafe"
 
+	"gviegas/neo3/bitvec"
 	"gviegas/neo3/driver"
 	"gviegas/neo3/engine/internal/ctxt"
-	"gviegas/neo3/internal/bitvec"
+	"gviegas/neo3/internal/meshopt"
+	"gviegas/neo3/internal/suballoc"
+	"gviegas/neo3/linear"
 )
 
 const meshPrefix = "mesh: "
 
-func newMeshErr(reason string) error { return errors.New(meshPrefix + reason) }
+func newMeshErr(reason string) error { return newErr(meshPrefix, reason, ErrInvalidParam) }
+
+// newMeshDataErr returns a *ValidationError for an
+// invalid MeshData field.
+func newMeshDataErr(field, reason string) error {
+	return &ValidationError{meshPrefix, field, reason}
+}
 
 // Mesh is a collection of primitives.
 // Each primitive defines the data for a draw call.
@@ -27,16 +36,91 @@ type Mesh struct {
 	bufIdx  int
 	primIdx int
 	primLen int
+	id      MeshID
+	cleanup runtime.Cleanup
 }
 
 // Len returns the number of primitives in m.
 func (m *Mesh) Len() int { return m.primLen }
 
+// center returns the center of the bounds of the
+// primitive at index prim, in local space.
+// prim must be in [0, m.Len()).
+func (m *Mesh) center(prim int) (c linear.V3) {
+	min, max := m.bounds(prim)
+	c.Add(&min, &max)
+	c.Scale(0.5, &c)
+	return
+}
+
+// bounds returns the bounds of the primitive at index
+// prim, in local space.
+// prim must be in [0, m.Len()).
+func (m *Mesh) bounds(prim int) (min, max linear.V3) {
+	meshes.RLock()
+	defer meshes.RUnlock()
+	idx := m.primIdx
+	for i := 0; i < prim; i++ {
+		idx, _ = meshes.next(idx)
+	}
+	p := &meshes.prims[idx]
+	return p.min, p.max
+}
+
+// Bounds returns the bounds of all primitives of m,
+// in local space.
+func (m *Mesh) Bounds() (min, max linear.V3) {
+	meshes.RLock()
+	defer meshes.RUnlock()
+	idx := m.primIdx
+	for i := 0; i < m.primLen; i++ {
+		p := &meshes.prims[idx]
+		if i == 0 {
+			min, max = p.min, p.max
+		} else {
+			for j := range 3 {
+				if p.min[j] < min[j] {
+					min[j] = p.min[j]
+				}
+				if p.max[j] > max[j] {
+					max[j] = p.max[j]
+				}
+			}
+		}
+		idx, _ = meshes.next(idx)
+	}
+	return
+}
+
+// triangles returns the number of triangles of the
+// primitive at index prim.
+// Primitives whose topology is not made of triangles
+// have none.
+// prim must be in [0, m.Len()).
+func (m *Mesh) triangles(prim int) int {
+	meshes.RLock()
+	defer meshes.RUnlock()
+	idx := m.primIdx
+	for i := 0; i < prim; i++ {
+		idx, _ = meshes.next(idx)
+	}
+	p := &meshes.prims[idx]
+	switch p.topology {
+	case driver.TTriangle:
+		return p.count / 3
+	case driver.TTriStrip:
+		return max(p.count-2, 0)
+	}
+	return 0
+}
+
 // inputs returns a driver.VertexIn slice describing the
 // vertex input layout of the primitive at index prim.
 // If prim is out of bounds, it returns a nil slice.
 // Inputs are ordered by the Semantic value they represent.
-// driver.VertexIn.Nr is set to Semantic.I().
+// driver.VertexIn.Nr is set to the semantic's shader
+// location, which is Semantic.I() for the predefined
+// semantics and SemanticParam.Location for custom ones.
 func (m *Mesh) inputs(prim int) []driver.VertexIn {
 	if prim >= m.primLen || prim < 0 {
 		return nil
@@ -48,20 +132,33 @@ func (m *Mesh) inputs(prim int) []driver.VertexIn {
 		idx, _ = meshes.next(idx)
 	}
 	p := &meshes.prims[idx]
-	var vin [MaxSemantic]driver.VertexIn
-	var n int
-	for i := 0; i < MaxSemantic; i++ {
-		if p.mask&(1<<i) == 0 {
+	vin := make([]driver.VertexIn, 0, MaxSemantic)
+	for i := range semanticCount() {
+		sem := Semantic(1 << i)
+		if p.mask&sem == 0 {
 			continue
 		}
-		vin[n] = driver.VertexIn{
-			Format: p.vertex[i].format,
-			Stride: p.vertex[i].format.Size(),
-			Nr:     i,
-		}
-		n++
+		v := p.attr(i)
+		vin = append(vin, driver.VertexIn{
+			Format: v.format,
+			Stride: v.format.Size(),
+			Nr:     sem.location(),
+		})
+	}
+	return vin
+}
+
+// meshlets returns the meshlets of the primitive at
+// index prim, or nil if it has none.
+// prim must be in [0, m.Len()).
+func (m *Mesh) meshlets(prim int) []meshopt.Meshlet {
+	meshes.RLock()
+	defer meshes.RUnlock()
+	idx := m.primIdx
+	for i := 0; i < prim; i++ {
+		idx, _ = meshes.next(idx)
 	}
-	return vin[:n]
+	return meshes.prims[idx].meshlets
 }
 
 // draw sets the vertex/index buffers and draws the primitive
@@ -89,15 +186,15 @@ func (m *Mesh) draw(prim int, cb driver.CmdBuffer, instCnt int) {
 	// TODO: Consider computing these during
 	// Mesh creation and storing alongside
 	// the primitive (probably not worth it).
-	var buf [MaxSemantic]driver.Buffer
-	var off [MaxSemantic]int64
+	var buf [MaxSemantic + MaxCustomSemantic]driver.Buffer
+	var off [MaxSemantic + MaxCustomSemantic]int64
 	var n int
-	for i := 0; i < MaxSemantic; i++ {
+	for i := range semanticCount() {
 		if p.mask&(1<<i) == 0 {
 			continue
 		}
 		buf[n] = meshes.buf
-		off[n] = int64(p.vertex[i].byteStart())
+		off[n] = int64(p.attr(i).byteStart())
 		n++
 	}
 	cb.SetVertexBuf(0, buf[:n], off[:n])
@@ -115,18 +212,25 @@ func (m *Mesh) Free() {
 	if m.primLen < 1 {
 		return
 	}
+	m.cleanup.Stop()
+	freePrims(m.primIdx)
+	meshIDs.release((*uint64)(&m.id))
+	*m = Mesh{}
+}
+
+// freePrims frees the chain of primitives that
+// starts at meshes.prims[prim].
+func freePrims(prim int) {
 	meshes.Lock()
 	defer meshes.Unlock()
-	p := m.primIdx
 	for {
-		next, ok := meshes.next(p)
-		meshes.freeEntry(p)
+		next, ok := meshes.next(prim)
+		meshes.freeEntry(prim)
 		if !ok {
 			break
 		}
-		p = next
+		prim = next
 	}
-	*m = Mesh{}
 }
 
 // Semantic specifies the intended use of a primitive's attribute.
@@ -146,8 +250,17 @@ const (
 	MaxSemantic int = iota
 )
 
+// LightmapUV is the semantic from which lightmap
+// coordinates are fetched.
+// It is the second texture coordinate set, which is
+// where asset pipelines usually place unique,
+// non-overlapping UVs for lightmapping.
+const LightmapUV = TexCoord1
+
 // I computes log₂(s).
 // This value can be used to index into PrimitiveData.Semantics.
+// For custom semantics (see RegisterSemantic), I() - MaxSemantic
+// is the index into PrimitiveData.Custom.
 func (s Semantic) I() (i int) {
 	for s > 1 {
 		s >>= 1
@@ -176,6 +289,9 @@ func (s Semantic) String() string {
 	case Weights0:
 		return "Weights0"
 	default:
+		if p, ok := s.custom(); ok {
+			return p.Name
+		}
 		return "!engine.Semantic"
 	}
 }
@@ -196,6 +312,9 @@ func (s Semantic) format() driver.VertexFmt {
 	case Joints0:
 		return driver.Uint16x4
 	default:
+		if p, ok := s.custom(); ok {
+			return p.Format
+		}
 		panic("undefined Semantic constant")
 	}
 }
@@ -213,12 +332,20 @@ func (s Semantic) format() driver.VertexFmt {
 //		driver.Float32x3 (no-op)
 //	Normal:
 //		driver.Float32x3 (no-op)
+//		driver.Int16x3
+//		driver.Int8x3
+//		driver.RGB10A2Norm
 //	Tangent:
 //		driver.Float32x4 (no-op)
+//		driver.Int16x4
+//		driver.Int8x4
+//		driver.RGB10A2Norm
 //	TexCoord0,1:
 //		driver.Float32x2 (no-op)
 //		driver.Uint16x2
 //		driver.Uint8x2
+//		driver.Int16x2
+//		driver.Int8x2
 //	Color0:
 //		driver.Float32x4 (no-op)
 //		driver.Float32x3
@@ -233,9 +360,17 @@ func (s Semantic) format() driver.VertexFmt {
 //		driver.Float32x4 (no-op)
 //		driver.Uint16x4
 //		driver.Uint8x4
+//	Custom semantics:
+//		SemanticParam.Format (no-op)
+//
+// Integer formats are interpreted as normalized (i.e., signed
+// values are mapped to [-1.0, 1.0] and unsigned ones to
+// [0.0, 1.0]), except for Joints0.
 //
 // If fmt is the expected format (i.e., s.format()), then nothing
-// is done and it returns (src, nil).
+// is done and it returns (src, nil). Otherwise, src is read
+// lazily by the returned io.Reader, which converts the data in
+// chunks of at most convChunk elements.
 func (s Semantic) conv(fmt driver.VertexFmt, src io.Reader, cnt int) (io.Reader, error) {
 	if cnt < 1 {
 		panic("Semantic.conv call has invalid count")
@@ -245,171 +380,184 @@ func (s Semantic) conv(fmt driver.VertexFmt, src io.Reader, cnt int) (io.Reader,
 		return src, nil
 	}
 
-	// Read the whole data upfront.
-	var b []byte
-	switch x := src.(type) {
-	case *bytes.Buffer:
-		b = x.Bytes()[:cnt*fmt.Size()]
-	default:
-		b = make([]byte, cnt*fmt.Size())
-		if _, err := io.ReadFull(src, b); err != nil {
-			return nil, err
-		}
-	}
-
-	// IEEE-754 binary => float32
-	nextFloat32 := func() float32 {
-		u := binary.LittleEndian.Uint32(b)
-		b = b[4:]
-		return math.Float32frombits(u)
-	}
-	// [0:65536) => [0.0:1.0]
-	nextUnorm16AsFloat32 := func() float32 {
-		u := binary.LittleEndian.Uint16(b)
-		b = b[2:]
-		return float32(u) / float32(^uint16(0))
+	// Input component types.
+	const (
+		float32In = iota
+		unorm16In
+		unorm8In
+		snorm16In
+		snorm8In
+		packedIn
+		uint8In
+	)
+	var in int
+	switch fmt {
+	case driver.Float32x3:
+		in = float32In
+	case driver.Uint16x2, driver.Uint16x3, driver.Uint16x4:
+		in = unorm16In
+	case driver.Uint8x2, driver.Uint8x3, driver.Uint8x4:
+		in = unorm8In
+	case driver.Int16x2, driver.Int16x3, driver.Int16x4:
+		in = snorm16In
+	case driver.Int8x2, driver.Int8x3, driver.Int8x4:
+		in = snorm8In
+	case driver.RGB10A2Norm:
+		in = packedIn
 	}
-	// [0:256) => [0.0:1.0]
-	nextUnorm8AsFloat32 := func() float32 {
-		u := b[0]
-		b = b[1:]
-		return float32(u) / float32(^uint8(0))
-	}
-	// [0:256) => [0:256)
-	nextUint8AsUint16 := func() uint16 {
-		u := b[0]
-		b = b[1:]
-		return uint16(u)
-	}
-
-	err := newMeshErr("unsupported vertex format for " + s.String())
-	var p *byte
-
+	var ok bool
 	switch s {
-	case Position, Normal, Tangent:
-		// These must match exactly.
-		return nil, err
-
+	case Normal:
+		switch fmt {
+		case driver.Int16x3, driver.Int8x3, driver.RGB10A2Norm:
+			ok = true
+		}
+	case Tangent:
+		switch fmt {
+		case driver.Int16x4, driver.Int8x4, driver.RGB10A2Norm:
+			ok = true
+		}
 	case TexCoord0, TexCoord1:
-		// Into driver.Float32x2.
-		v := make([]float32, cnt*2)
-		p = (*byte)(unsafe.Pointer(unsafe.SliceData(v)))
 		switch fmt {
-		case driver.Uint16x2:
-			for len(v) > 0 {
-				v[0] = nextUnorm16AsFloat32()
-				v[1] = nextUnorm16AsFloat32()
-				v = v[2:]
-			}
-		case driver.Uint8x2:
-			for len(v) > 0 {
-				v[0] = nextUnorm8AsFloat32()
-				v[1] = nextUnorm8AsFloat32()
-				v = v[2:]
-			}
-		default:
-			return nil, err
+		case driver.Uint16x2, driver.Uint8x2, driver.Int16x2, driver.Int8x2:
+			ok = true
 		}
-
 	case Color0:
-		// Into driver.Float32x4.
-		v := make([]float32, cnt*4)
-		p = (*byte)(unsafe.Pointer(unsafe.SliceData(v)))
 		switch fmt {
-		case driver.Float32x3:
-			for len(v) > 0 {
-				v[0] = nextFloat32()
-				v[1] = nextFloat32()
-				v[2] = nextFloat32()
-				v[3] = 1
-				v = v[4:]
-			}
-		case driver.Uint16x4:
-			for len(v) > 0 {
-				v[0] = nextUnorm16AsFloat32()
-				v[1] = nextUnorm16AsFloat32()
-				v[2] = nextUnorm16AsFloat32()
-				v[3] = nextUnorm16AsFloat32()
-				v = v[4:]
-			}
-		case driver.Uint16x3:
-			for len(v) > 0 {
-				v[0] = nextUnorm16AsFloat32()
-				v[1] = nextUnorm16AsFloat32()
-				v[2] = nextUnorm16AsFloat32()
-				v[3] = 1
-				v = v[4:]
-			}
-		case driver.Uint8x4:
-			for len(v) > 0 {
-				v[0] = nextUnorm8AsFloat32()
-				v[1] = nextUnorm8AsFloat32()
-				v[2] = nextUnorm8AsFloat32()
-				v[3] = nextUnorm8AsFloat32()
-				v = v[4:]
-			}
-		case driver.Uint8x3:
-			for len(v) > 0 {
-				v[0] = nextUnorm8AsFloat32()
-				v[1] = nextUnorm8AsFloat32()
-				v[2] = nextUnorm8AsFloat32()
-				v[3] = 1
-				v = v[4:]
-			}
-		default:
-			return nil, err
+		case driver.Float32x3, driver.Uint16x4, driver.Uint16x3, driver.Uint8x4, driver.Uint8x3:
+			ok = true
 		}
-
 	case Joints0:
-		// Into driver.Uint16x4.
-		v := make([]uint16, cnt*4)
-		p = (*byte)(unsafe.Pointer(unsafe.SliceData(v)))
-		switch fmt {
-		case driver.Uint8x4:
-			for len(v) > 0 {
-				v[0] = nextUint8AsUint16()
-				v[1] = nextUint8AsUint16()
-				v[2] = nextUint8AsUint16()
-				v[3] = nextUint8AsUint16()
-				v = v[4:]
+		ok = fmt == driver.Uint8x4
+		in = uint8In
+	case Weights0:
+		ok = fmt == driver.Uint16x4 || fmt == driver.Uint8x4
+	default:
+		// Position and custom semantics must
+		// match exactly. Anything else would
+		// already have panicked due to the
+		// s.format call above.
+	}
+	if !ok {
+		return nil, newMeshErr("unsupported vertex format for " + s.String())
+	}
+
+	nin := fmt.Components()
+	nout := f.Components()
+	var elem func(dst, src []byte)
+	switch in {
+	case uint8In:
+		// [0:256) => [0:256)
+		elem = func(dst, src []byte) {
+			for i := range nout {
+				binary.NativeEndian.PutUint16(dst[i*2:], uint16(src[i]))
 			}
-		default:
-			return nil, err
 		}
-
-	case Weights0:
-		// Into driver.Float32x4.
-		v := make([]float32, cnt*4)
-		p = (*byte)(unsafe.Pointer(unsafe.SliceData(v)))
-		switch fmt {
-		case driver.Uint16x4:
-			for len(v) > 0 {
-				v[0] = nextUnorm16AsFloat32()
-				v[1] = nextUnorm16AsFloat32()
-				v[2] = nextUnorm16AsFloat32()
-				v[3] = nextUnorm16AsFloat32()
-				v = v[4:]
+	default:
+		// Into driver.Float32xN. Missing components
+		// are set to 1 (i.e., opaque alpha).
+		next := func(b []byte, i int) float32 {
+			switch in {
+			case float32In:
+				return math.Float32frombits(binary.LittleEndian.Uint32(b[i*4:]))
+			case unorm16In:
+				// [0:65536) => [0.0:1.0]
+				return float32(binary.LittleEndian.Uint16(b[i*2:])) / float32(^uint16(0))
+			case unorm8In:
+				// [0:256) => [0.0:1.0]
+				return float32(b[i]) / float32(^uint8(0))
+			case snorm16In:
+				// [-32768:32768) => [-1.0:1.0]
+				return snorm(int32(int16(binary.LittleEndian.Uint16(b[i*2:]))), 16)
+			case snorm8In:
+				// [-128:128) => [-1.0:1.0]
+				return snorm(int32(int8(b[i])), 8)
+			default:
+				// 10-10-10-2 => [-1.0:1.0]
+				u := binary.LittleEndian.Uint32(b)
+				if i == 3 {
+					return snorm(int32(u)>>30, 2)
+				}
+				return snorm(int32(u<<(22-10*i))>>22, 10)
 			}
-		case driver.Uint8x4:
-			for len(v) > 0 {
-				v[0] = nextUnorm8AsFloat32()
-				v[1] = nextUnorm8AsFloat32()
-				v[2] = nextUnorm8AsFloat32()
-				v[3] = nextUnorm8AsFloat32()
-				v = v[4:]
+		}
+		elem = func(dst, src []byte) {
+			for i := range nout {
+				v := float32(1)
+				if i < nin {
+					v = next(src, i)
+				}
+				binary.NativeEndian.PutUint32(dst[i*4:], math.Float32bits(v))
 			}
-		default:
-			return nil, err
 		}
+	}
+	return newConvReader(src, elem, fmt.Size(), f.Size(), cnt), nil
+}
 
-	default:
-		// It would already have panicked due to the
-		// s.format call above.
-		panic("unreachable")
+// snorm converts a signed normalized value of the
+// given bit width into a float32.
+// The most negative value is clamped to -1.0.
+func snorm(v int32, bits int) float32 {
+	return max(float32(v)/float32(int32(1)<<(bits-1)-1), -1)
+}
+
+// convChunk is the maximum number of elements that a
+// convReader converts at once.
+const convChunk = 4096
+
+// convReader is an io.Reader that converts vertex data
+// read from another io.Reader.
+// It buffers at most convChunk elements, regardless of
+// the total amount of data.
+type convReader struct {
+	src     io.Reader
+	elem    func(dst, src []byte)
+	in, out int // Element sizes.
+	rem     int // Elements not yet read from src.
+	buf     []byte
+	conv    []byte
+	pend    []byte // Converted data not yet read.
+}
+
+// newConvReader creates a new convReader that reads cnt
+// elements of size in from src and converts each into
+// out bytes using elem.
+func newConvReader(src io.Reader, elem func(dst, src []byte), in, out, cnt int) *convReader {
+	n := min(cnt, convChunk)
+	return &convReader{
+		src:  src,
+		elem: elem,
+		in:   in,
+		out:  out,
+		rem:  cnt,
+		buf:  make([]byte, n*in),
+		conv: make([]byte, n*out),
 	}
+}
 
-	n := cnt * f.Size()
-	return bytes.NewReader(unsafe.Slice(p, n)), nil
+// Read implements io.Reader.
+// Short source data causes io.ErrUnexpectedEOF.
+func (r *convReader) Read(p []byte) (int, error) {
+	if len(r.pend) == 0 {
+		if r.rem == 0 {
+			return 0, io.EOF
+		}
+		n := min(r.rem, convChunk)
+		if _, err := io.ReadFull(r.src, r.buf[:n*r.in]); err != nil {
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return 0, err
+		}
+		for i := range n {
+			r.elem(r.conv[i*r.out:], r.buf[i*r.in:])
+		}
+		r.pend = r.conv[:n*r.out]
+		r.rem -= n
+	}
+	n := copy(p, r.pend)
+	r.pend = r.pend[n:]
+	return n, nil
 }
 
 // SemanticData describes how to fetch semantic data
@@ -440,6 +588,11 @@ type PrimitiveData struct {
 	// array. Unused semantics need not be set.
 	SemanticMask Semantic
 	Semantics    [MaxSemantic]SemanticData
+	// Custom describes the data of custom
+	// semantics, indexed by Semantic.I() minus
+	// MaxSemantic. It need only be long enough
+	// to hold the ones set in SemanticMask.
+	Custom []SemanticData
 	// Index describes the index buffer's data.
 	// It is ignored if IndexCount is less than
 	// or equal to zero.
@@ -448,9 +601,21 @@ type PrimitiveData struct {
 
 // MeshData defines the data layout of a whole mesh
 // and provides the data sources to read from.
+// Data is copied from the sources directly into mesh
+// storage. Data that needs conversion is converted
+// in bounded chunks, so the sources need not fit in
+// memory.
 type MeshData struct {
 	Primitives []PrimitiveData
 	Srcs       []io.ReadSeeker
+	// Optimize indicates which optimizations
+	// NewMesh should apply to the data.
+	Optimize MeshOpt
+	// NoQuantize prevents NewMesh from quantizing
+	// the vertex data of this mesh.
+	// It has no effect unless quantization is
+	// enabled (see SetVertexQuantization).
+	NoQuantize bool
 }
 
 // NewMesh creates a new mesh.
@@ -459,18 +624,35 @@ func NewMesh(data *MeshData) (m *Mesh, err error) {
 	if err != nil {
 		return
 	}
+	quant := vertexQuant.Load() && !data.NoQuantize
+	var mls []*meshopt.Meshlets
+	if data.Optimize != 0 {
+		if data, mls, err = optimizeMesh(data); err != nil {
+			return
+		}
+	}
 	// TODO: Check whether locking/unlocking at
 	// call sites improves performance.
 	meshes.Lock()
 	defer meshes.Unlock()
+	newEntry := func(i int) (int, error) {
+		p, err := meshes.newEntry(&data.Primitives[i], data.Srcs, quant)
+		if err != nil || mls == nil || mls[i] == nil {
+			return p, err
+		}
+		if err = meshes.storeMeshlets(p, mls[i]); err != nil {
+			meshes.freeEntry(p)
+		}
+		return p, err
+	}
 	var prim, next, prev int
-	prim, err = meshes.newEntry(&data.Primitives[0], data.Srcs)
+	prim, err = newEntry(0)
 	if err != nil {
 		return
 	}
 	prev = prim
 	for i := 1; i < len(data.Primitives); i++ {
-		next, err = meshes.newEntry(&data.Primitives[i], data.Srcs)
+		next, err = newEntry(i)
 		if err != nil {
 			prev = prim
 			for {
@@ -491,30 +673,79 @@ func NewMesh(data *MeshData) (m *Mesh, err error) {
 		primIdx: prim,
 		primLen: len(data.Primitives),
 	}
+	m.cleanup = addCleanup(m, freePrims, prim)
 	return
 }
 
 // validateMeshData checks whether data is valid.
+// Besides the layout itself, it checks that the
+// sources are large enough for the data fetched from
+// them, which requires seeking to their ends.
 func validateMeshData(data *MeshData) error {
 	switch {
 	case data == nil:
-		return newMeshErr("nil data")
+		return newMeshDataErr("", "nil data")
 	case len(data.Primitives) == 0:
-		return newMeshErr("no primitive data")
+		return newMeshDataErr("Primitives", "no primitive data")
 	case len(data.Srcs) == 0:
-		return newMeshErr("no data source")
+		return newMeshDataErr("Srcs", "no data source")
+	}
+	srcLens := make([]int64, len(data.Srcs))
+	for i, src := range data.Srcs {
+		field := "Srcs[" + strconv.Itoa(i) + "]"
+		if src == nil {
+			return newMeshDataErr(field, "nil data source")
+		}
+		n, err := src.Seek(0, io.SeekEnd)
+		if err != nil {
+			return newMeshDataErr(field, "data source not seekable")
+		}
+		srcLens[i] = n
+	}
+	// inBounds checks whether cnt elements of the
+	// given size fit in a source starting at off.
+	inBounds := func(src int, off int64, cnt, size int) bool {
+		n := srcLens[src]
+		return off <= n && int64(cnt) <= (n-off)/int64(size)
 	}
 
 	for i := range data.Primitives {
 		pdata := &data.Primitives[i]
+		field := func(name string) string {
+			return "Primitives[" + strconv.Itoa(i) + "]." + name
+		}
 
 		switch {
-		case pdata.VertexCount < 0:
-			return newMeshErr("invalid vertex count")
+		case pdata.VertexCount < 1:
+			return newMeshDataErr(field("VertexCount"), "invalid vertex count")
+		case pdata.IndexCount < 0:
+			return newMeshDataErr(field("IndexCount"), "invalid index count")
 		case pdata.SemanticMask&Position == 0:
-			return newMeshErr("no position semantic")
-		case pdata.IndexCount > 0 && uint(pdata.Index.Src) >= uint(len(data.Srcs)):
-			return newMeshErr("index data source out of bounds")
+			return newMeshDataErr(field("SemanticMask"), "no position semantic")
+		case uint(pdata.SemanticMask) >= 1<<semanticCount():
+			return newMeshDataErr(field("SemanticMask"), "undefined Semantic constant")
+		case uint(pdata.SemanticMask) >= 1<<(MaxSemantic+len(pdata.Custom)):
+			return newMeshDataErr(field("Custom"), "missing custom semantic data")
+		}
+
+		if pdata.IndexCount > 0 {
+			var isz int
+			switch pdata.Index.Format {
+			case driver.Index16:
+				isz = 2
+			case driver.Index32:
+				isz = 4
+			default:
+				return newMeshDataErr(field("Index.Format"), "undefined driver.IndexFmt constant")
+			}
+			switch x := &pdata.Index; {
+			case uint(x.Src) >= uint(len(data.Srcs)):
+				return newMeshDataErr(field("Index.Src"), "index data source out of bounds")
+			case x.Offset < 0:
+				return newMeshDataErr(field("Index.Offset"), "negative offset")
+			case !inBounds(x.Src, x.Offset, pdata.IndexCount, isz):
+				return newMeshDataErr(field("Index"), "index data source too short")
+			}
 		}
 
 		// While back-ends usually can handle wrong counts
@@ -524,34 +755,56 @@ func validateMeshData(data *MeshData) error {
 		if x := pdata.IndexCount; x > 0 {
 			cnt = x
 		}
+		var reason string
 		switch pdata.Topology {
 		case driver.TPoint:
 		case driver.TLine:
 			if cnt&1 != 0 {
-				return newMeshErr("invalid count for driver.TLine")
+				reason = "invalid count for driver.TLine"
 			}
 		case driver.TLnStrip:
 			if cnt < 2 {
-				return newMeshErr("invalid count for driver.TLnStrip")
+				reason = "invalid count for driver.TLnStrip"
 			}
 		case driver.TTriangle:
 			if cnt%3 != 0 {
-				return newMeshErr("invalid count for driver.TTriangle")
+				reason = "invalid count for driver.TTriangle"
 			}
 		case driver.TTriStrip:
 			if cnt < 3 {
-				return newMeshErr("invalid count for driver.TTriStrip")
+				reason = "invalid count for driver.TTriStrip"
 			}
 		default:
-			return newMeshErr("undefined driver.Topology constant")
+			return newMeshDataErr(field("Topology"), "undefined driver.Topology constant")
+		}
+		if reason != "" {
+			if pdata.IndexCount > 0 {
+				return newMeshDataErr(field("IndexCount"), reason)
+			}
+			return newMeshDataErr(field("VertexCount"), reason)
 		}
 
-		for i := range pdata.Semantics {
-			if pdata.SemanticMask&(1<<i) == 0 {
+		for j := range semanticCount() {
+			if pdata.SemanticMask&(1<<j) == 0 {
 				continue
 			}
-			if uint(pdata.Semantics[i].Src) >= uint(len(data.Srcs)) {
-				return newMeshErr("semantic data source out of bounds")
+			x := pdata.semantic(j)
+			sname := "Semantics[" + strconv.Itoa(j) + "]"
+			if j >= MaxSemantic {
+				sname = "Custom[" + strconv.Itoa(j-MaxSemantic) + "]"
+			}
+			sfield := func(name string) string {
+				return field(sname + "." + name)
+			}
+			switch size := x.Format.Size(); {
+			case uint(x.Src) >= uint(len(data.Srcs)):
+				return newMeshDataErr(sfield("Src"), "semantic data source out of bounds")
+			case x.Offset < 0:
+				return newMeshDataErr(sfield("Offset"), "negative offset")
+			case size < 1:
+				return newMeshDataErr(sfield("Format"), "invalid vertex format")
+			case !inBounds(x.Src, x.Offset, pdata.VertexCount, size):
+				return newMeshDataErr(field(sname), "semantic data source too short")
 			}
 		}
 	}
@@ -560,13 +813,17 @@ func validateMeshData(data *MeshData) error {
 }
 
 // Global mesh storage.
-var meshes meshBuffer
+var meshes = meshBuffer{
+	spans:  suballoc.Allocator{Fit: suballoc.NextFit},
+	growth: GrowthPolicy{Initial: NMeshBuffer},
+}
 
 // setMeshBuffer sets the GPU buffer into which mesh data
 // will be stored.
 // The buffer must be host-visible, its usage must include
 // both driver.UVertexData and driver.UIndexData, and its
-// capacity must be a multiple of 16384 bytes.
+// capacity must be a multiple of spanBlock * suballoc.Word
+// bytes.
 // It returns the replaced buffer, if any.
 //
 // NOTE: Calls to this function invalidate all previously
@@ -581,17 +838,18 @@ func setMeshBuffer(buf driver.Buffer) driver.Buffer {
 	case meshes.buf:
 		return nil
 	case nil:
-		meshes.spanMap = bitvec.V[uint32]{}
+		meshes.spans.Release()
 		meshes.primMap = bitvec.V[uint16]{}
 		meshes.prims = nil
 	default:
 		c := buf.Cap()
-		n := c / (spanBlock * spanMapNBit)
-		if n > int64(^uint(0)>>1) || c != n*(spanBlock*spanMapNBit) {
+		unit := int64(spanBlock * suballoc.Word)
+		n := c / unit
+		if n > int64(^uint(0)>>1) || c != n*unit {
 			panic("invalid mesh buffer capacity")
 		}
-		meshes.spanMap = bitvec.V[uint32]{}
-		meshes.spanMap.Grow(int(n))
+		meshes.spans.Release()
+		meshes.spans.Grow(int(n) * suballoc.Word)
 		meshes.primMap = bitvec.V[uint16]{}
 		meshes.prims = meshes.prims[:0]
 	}
@@ -604,15 +862,16 @@ func setMeshBuffer(buf driver.Buffer) driver.Buffer {
 type meshBuffer struct {
 	sync.RWMutex
 	buf     driver.Buffer
-	spanMap bitvec.V[uint32]
+	spans   suballoc.Allocator
 	primMap bitvec.V[uint16]
 	prims   []primitive
+	growth  GrowthPolicy
+	// Signaled when spans are freed.
+	// Created on demand.
+	cv *sync.Cond
 }
 
-const (
-	spanMapNBit = 32
-	primMapNBit = 16
-)
+const primMapNBit = 16
 
 // store reads byteLen bytes from src and writes the data
 // into the GPU buffer.
@@ -621,25 +880,35 @@ const (
 func (b *meshBuffer) store(src io.Reader, byteLen int) (span, error) {
 	nb := (byteLen + (spanBlock - 1)) &^ (spanBlock - 1)
 	ns := nb / spanBlock
-	is, ok := b.spanMap.SearchRange(ns)
-	if !ok {
-		// TODO: Reconsider the growth strategy here.
-		// Currently it assumes that SetMeshBuffer will
-		// be called with a sensibly sized buffer and
-		// that reallocations will not happen often,
-		// so it optimizes for space.
-		nplus := (ns + (spanMapNBit - 1)) / spanMapNBit
-		bcap := int64(b.spanMap.Len()+nplus*spanMapNBit) * spanBlock
-		buf, err := ctxt.GPU().NewBuffer(bcap, true, driver.UVertexData|driver.UIndexData)
-		if err != nil {
-			return span{}, err
-		}
-		if b.buf != nil {
-			copy(buf.Bytes(), b.buf.Bytes())
-			b.buf.Destroy()
+	is, ok := b.spans.Alloc(ns, 1)
+	for !ok {
+		// Free spans at the end of the buffer
+		// are not taken into account, so this
+		// always makes room for nb bytes.
+		cur := b.capacity()
+		if n, ok := b.growth.grow(cur, cur+int64(nb), int64(spanBlock*suballoc.Word)); ok {
+			if err := b.resize(n); err != nil {
+				return span{}, err
+			}
+		} else {
+			switch b.growth.OnExhaust {
+			case ExhaustEvict:
+				evict := b.growth.Evict
+				// evict will likely free meshes,
+				// which requires the lock.
+				b.Unlock()
+				ok := evict(int64(nb))
+				b.Lock()
+				if !ok {
+					return span{}, ErrExhausted
+				}
+			case ExhaustBlock:
+				b.cond().Wait()
+			default:
+				return span{}, ErrExhausted
+			}
 		}
-		b.buf = buf
-		is = b.spanMap.Grow(nplus)
+		is, ok = b.spans.Alloc(ns, 1)
 	}
 	slc := b.buf.Bytes()[is*spanBlock : is*spanBlock+byteLen]
 	for len(slc) > 0 {
@@ -647,23 +916,26 @@ func (b *meshBuffer) store(src io.Reader, byteLen int) (span, error) {
 		case n > 0:
 			slc = slc[n:]
 		case err != nil:
+			b.spans.Free(is, ns)
 			return span{}, err
 		}
 	}
-	for i := 0; i < ns; i++ {
-		b.spanMap.Set(is + i)
-	}
 	return span{is, is + ns}, nil
 }
 
 // newEntry creates a new entry in the buffer containing
 // the primitive specified by data.
-func (b *meshBuffer) newEntry(data *PrimitiveData, srcs []io.ReadSeeker) (p int, err error) {
+// If quant is true, semantics in quantSemantics are
+// stored in quantized form.
+func (b *meshBuffer) newEntry(data *PrimitiveData, srcs []io.ReadSeeker, quant bool) (p int, err error) {
 	prim := primitive{
 		topology: data.Topology,
 		mask:     data.SemanticMask,
 		next:     -1,
 	}
+	if n := data.SemanticMask >> MaxSemantic; n != 0 {
+		prim.custom = make([]vertexData, Semantic(n).I()+1)
+	}
 	if data.IndexCount != 0 {
 		prim.count = data.IndexCount
 		prim.index.format = data.Index.Format
@@ -675,6 +947,7 @@ func (b *meshBuffer) newEntry(data *PrimitiveData, srcs []io.ReadSeeker) (p int,
 			isz = 4
 		default:
 			err = newMeshErr("undefined driver.IndexFmt constant")
+			return
 		}
 		src := srcs[data.Index.Src]
 		off := data.Index.Offset
@@ -687,29 +960,62 @@ func (b *meshBuffer) newEntry(data *PrimitiveData, srcs []io.ReadSeeker) (p int,
 	} else {
 		prim.count = data.VertexCount
 	}
-	for i := range data.Semantics {
+	for i := range semanticCount() {
 		sem := Semantic(1 << i)
 		if data.SemanticMask&sem == 0 {
 			continue
 		}
-		fmt := data.Semantics[i].Format
-		src := srcs[data.Semantics[i].Src]
-		off := data.Semantics[i].Offset
+		sdata := data.semantic(i)
+		fmt := sdata.Format
+		src := srcs[sdata.Src]
+		off := sdata.Offset
 		if _, err = src.Seek(off, io.SeekStart); err != nil {
 			b._freeEntry(&prim)
 			return
 		}
+		if quant && sem&quantSemantics != 0 {
+			conv, off, scale, lo, hi, err := sem.quantize(fmt, src, data.VertexCount)
+			if err != nil {
+				b._freeEntry(&prim)
+				return 0, err
+			}
+			fmt = sem.quantFormat()
+			v := prim.attr(i)
+			v.format = fmt
+			if v.span, err = b.store(conv, data.VertexCount*fmt.Size()); err != nil {
+				b._freeEntry(&prim)
+				return 0, err
+			}
+			if sem == Position {
+				prim.min, prim.max = lo, hi
+			}
+			if slot := quantSlot(sem); slot >= 0 {
+				prim.decode.off[slot] = off
+				prim.decode.scale[slot] = scale
+			}
+			prim.decode.mask |= sem
+			continue
+		}
 		var conv io.Reader
 		if conv, err = sem.conv(fmt, src, data.VertexCount); err != nil {
 			b._freeEntry(&prim)
 			return
 		}
+		var bnd *boundsReader
+		if sem == Position {
+			bnd = &boundsReader{r: conv}
+			conv = bnd
+		}
 		fmt = sem.format()
-		prim.vertex[i].format = fmt
-		if prim.vertex[i].span, err = b.store(conv, data.VertexCount*fmt.Size()); err != nil {
+		v := prim.attr(i)
+		v.format = fmt
+		if v.span, err = b.store(conv, data.VertexCount*fmt.Size()); err != nil {
 			b._freeEntry(&prim)
 			return
 		}
+		if bnd != nil {
+			prim.min, prim.max = bnd.min, bnd.max
+		}
 	}
 	if i, ok := b.primMap.Search(); !ok {
 		// TODO: Grow exponentially.
@@ -724,6 +1030,68 @@ func (b *meshBuffer) newEntry(data *PrimitiveData, srcs []io.ReadSeeker) (p int,
 	return
 }
 
+// storeMeshlets stores the meshlets of the primitive
+// at index p.
+// The vertex indices of every meshlet are stored as
+// 32-bit values, followed by the triangle data.
+func (b *meshBuffer) storeMeshlets(p int, ml *meshopt.Meshlets) error {
+	data := make([]byte, 0, len(ml.Vertices)*4+len(ml.Triangles))
+	for _, x := range ml.Vertices {
+		data = binary.LittleEndian.AppendUint32(data, x)
+	}
+	data = append(data, ml.Triangles...)
+	if len(data) == 0 {
+		return nil
+	}
+	// store may unlock b, so b.prims must
+	// not be referenced until it returns.
+	s, err := b.store(bytes.NewReader(data), len(data))
+	if err != nil {
+		return err
+	}
+	prim := &b.prims[p]
+	prim.meshlet = s
+	prim.meshlets = ml.Meshlets
+	prim.meshletTri = len(ml.Vertices) * 4
+	return nil
+}
+
+// boundsReader is an io.Reader that computes the
+// bounds of the Position data read through it.
+type boundsReader struct {
+	r        io.Reader
+	min, max linear.V3
+	buf      [12]byte
+	n        int // Bytes in buf.
+	cnt      int // Positions read.
+}
+
+// Read implements io.Reader.
+func (b *boundsReader) Read(p []byte) (n int, err error) {
+	n, err = b.r.Read(p)
+	for _, x := range p[:n] {
+		b.buf[b.n] = x
+		if b.n++; b.n < len(b.buf) {
+			continue
+		}
+		b.n = 0
+		var v linear.V3
+		for i := range v {
+			v[i] = math.Float32frombits(binary.LittleEndian.Uint32(b.buf[4*i:]))
+		}
+		if b.cnt == 0 {
+			b.min, b.max = v, v
+		} else {
+			for i := range v {
+				b.min[i] = min(b.min[i], v[i])
+				b.max[i] = max(b.max[i], v[i])
+			}
+		}
+		b.cnt++
+	}
+	return
+}
+
 // next returns the next primitive in the list.
 // If prim has no subsequent primitive (i.e., it was not
 // linked to another primitive), then ok will be false.
@@ -743,6 +1111,45 @@ func (b *meshBuffer) next(prim int) (p int, ok bool) {
 func (b *meshBuffer) freeEntry(prim int) {
 	b.primMap.Unset(prim)
 	b._freeEntry(&b.prims[prim])
+	if b.cv != nil {
+		b.cv.Broadcast()
+	}
+}
+
+// capacity returns the capacity of b in bytes.
+func (b *meshBuffer) capacity() int64 { return int64(b.spans.Len()) * int64(spanBlock) }
+
+// resize grows b's GPU buffer to hold at least n
+// bytes.
+// It does nothing if b is large enough already.
+// The data of created meshes is preserved.
+func (b *meshBuffer) resize(n int64) error {
+	unit := int64(spanBlock * suballoc.Word)
+	n = (n + unit - 1) / unit * unit
+	cur := b.capacity()
+	if n <= cur {
+		return nil
+	}
+	buf, err := ctxt.GPU().NewBuffer(n, true, driver.UVertexData|driver.UIndexData)
+	if err != nil {
+		return err
+	}
+	if b.buf != nil {
+		copy(buf.Bytes(), b.buf.Bytes())
+		b.buf.Destroy()
+	}
+	b.buf = buf
+	b.spans.Grow(int((n - cur) / int64(spanBlock)))
+	return nil
+}
+
+// cond returns the sync.Cond used to wait for spans
+// to be freed. Its Locker is b's write lock.
+func (b *meshBuffer) cond() *sync.Cond {
+	if b.cv == nil {
+		b.cv = sync.NewCond(&b.RWMutex)
+	}
+	return b.cv
 }
 
 func (b *meshBuffer) _freeEntry(prim *primitive) {
@@ -750,30 +1157,48 @@ func (b *meshBuffer) _freeEntry(prim *primitive) {
 	// empty spans instead, so it is safe to
 	// call from newEntry when it fails with
 	// a partially set primitive.
-	for i := range prim.vertex {
-		for j := prim.vertex[i].start; j < prim.vertex[i].end; j++ {
-			b.spanMap.Unset(j)
-		}
+	for _, s := range prim.vertex {
+		b.spans.Free(s.start, s.end-s.start)
 	}
-	for i := prim.index.start; i < prim.index.end; i++ {
-		b.spanMap.Unset(i)
+	for _, s := range prim.custom {
+		b.spans.Free(s.start, s.end-s.start)
 	}
+	b.spans.Free(prim.index.start, prim.index.end-prim.index.start)
+	b.spans.Free(prim.meshlet.start, prim.meshlet.end-prim.meshlet.start)
 	*prim = primitive{}
 }
 
+// vertexData is the vertex data of a semantic
+// in a mesh buffer.
+type vertexData struct {
+	format driver.VertexFmt
+	span
+}
+
 // primitive is an entry in a mesh buffer.
 type primitive struct {
 	topology driver.Topology
 	count    int
 	mask     Semantic
-	vertex   [MaxSemantic]struct {
-		format driver.VertexFmt
-		span
-	}
-	index struct {
+	vertex   [MaxSemantic]vertexData
+	// Vertex data of custom semantics, indexed
+	// by Semantic.I() minus MaxSemantic.
+	custom []vertexData
+	index  struct {
 		format driver.IndexFmt
 		span
 	}
+	// Bounds of the Position data.
+	min, max linear.V3
+	// Decode constants of quantized
+	// vertex data.
+	decode vertexDecode
+	// Meshlets created by GenerateMeshlets.
+	// Their data is stored in meshlet, with
+	// triangles starting at meshletTri bytes.
+	meshlets   []meshopt.Meshlet
+	meshlet    span
+	meshletTri int
 	// Index into meshBuffer.prims identifying
 	// the next primitive of a mesh. Whether
 	// this value is meaningful or not depends
@@ -788,7 +1213,8 @@ type span struct {
 }
 
 // span block size.
-const spanBlock = 512
+// It is set by Init (Config.MeshSpanBlock).
+var spanBlock = defaultConfig.MeshSpanBlock
 
 // byteStart computes the span's first byte.
 func (s span) byteStart() int { return s.start * spanBlock }
@@ -4,14 +4,20 @@ package engine
 
 import (
 	"bytes"
+	"encoding/binary"
 	"fmt"
 	"io"
+	"math"
+	"slices"
 	"strings"
+	"sync"
 	"testing"
 	"unsafe"
 
 	"gviegas/neo3/driver"
 	"gviegas/neo3/engine/internal/ctxt"
+	"gviegas/neo3/internal/suballoc"
+	"gviegas/neo3/linear"
 )
 
 func TestSemantic(t *testing.T) {
@@ -46,8 +52,8 @@ func TestSetMeshBuffer(t *testing.T) {
 	if meshes.buf != nil {
 		t.Fatalf("setMeshBuffer: meshes.buf\nhave %v\nwant nil", meshes.buf)
 	}
-	if x := meshes.spanMap.Len(); x != 0 {
-		t.Fatalf("setMeshBuffer: meshes.spanMap.Len\nhave %d\nwant 0", x)
+	if x := meshes.spans.Len(); x != 0 {
+		t.Fatalf("setMeshBuffer: meshes.spans.Len\nhave %d\nwant 0", x)
 	}
 	if x := meshes.primMap.Len(); x != 0 {
 		t.Fatalf("setMeshBuffer: meshes.primMap.Len\nhave %d\nwant 0", x)
@@ -73,9 +79,9 @@ func TestSetMeshBuffer(t *testing.T) {
 		if meshes.buf != buf {
 			t.Fatalf("setMeshBuffer: meshes.buf\nhave %v\nwant %v", meshes.buf, buf)
 		}
-		n := meshes.spanMap.Len()
-		if x := s / spanBlock; int(x) != n {
-			t.Fatalf("setMeshBuffer: meshes.spanMap.Len\nhave %d\nwant %d", n, x)
+		n := meshes.spans.Len()
+		if x := s / int64(spanBlock); int(x) != n {
+			t.Fatalf("setMeshBuffer: meshes.spans.Len\nhave %d\nwant %d", n, x)
 		}
 		if x := meshes.primMap.Len(); x != 0 {
 			t.Fatalf("setMeshBuffer: meshes.primMap.Len\nhave %d\nwant 0", x)
@@ -93,8 +99,8 @@ func TestSetMeshBuffer(t *testing.T) {
 	if meshes.buf != nil {
 		t.Fatalf("setMeshBuffer: meshes.buf\nhave %v\nwant nil", meshes.buf)
 	}
-	if x := meshes.spanMap.Len(); x != 0 {
-		t.Fatalf("setMeshBuffer: meshes.spanMap.Len\nhave %d\nwant 0", x)
+	if x := meshes.spans.Len(); x != 0 {
+		t.Fatalf("setMeshBuffer: meshes.spans.Len\nhave %d\nwant 0", x)
 	}
 	if x := meshes.primMap.Len(); x != 0 {
 		t.Fatalf("setMeshBuffer: meshes.primMap.Len\nhave %d\nwant 0", x)
@@ -148,10 +154,10 @@ func TestMeshBuffer(t *testing.T) {
 	}
 
 	check := func(s span, err error, byteLen int, mark byte) {
-		if x, y := b.buf.Cap(), int64(b.spanMap.Len())*spanBlock; x < y {
-			t.Fatalf("meshBuffer.store: buf.Cap() < spanMap.Len()*spanBlock: %d/%d", x, y)
+		if x, y := b.buf.Cap(), int64(b.spans.Len())*int64(spanBlock); x < y {
+			t.Fatalf("meshBuffer.store: buf.Cap() < spans.Len()*spanBlock: %d/%d", x, y)
 		} else if x != y {
-			t.Logf("[!] meshBuffer.store: buf.Cap() != spanMap.Len()*spanBlock: %d/%d", x, y)
+			t.Logf("[!] meshBuffer.store: buf.Cap() != spans.Len()*spanBlock: %d/%d", x, y)
 		}
 		if s == (span{}) || err != nil {
 			t.Fatalf("meshBuffer.store: unexpected result: (%v, %v)", s, err)
@@ -184,12 +190,12 @@ func TestMeshBuffer(t *testing.T) {
 		4<<20 - 1,
 		1 << 16,
 		3<<20 + 1,
-		spanBlock * spanMapNBit,
-		spanBlock*spanMapNBit - 1,
-		spanBlock*spanMapNBit + 1,
-		16 * spanBlock * spanMapNBit,
-		4*spanBlock*spanMapNBit + 3,
-		9*spanBlock*spanMapNBit - 6,
+		spanBlock * suballoc.Word,
+		spanBlock*suballoc.Word - 1,
+		spanBlock*suballoc.Word + 1,
+		16 * spanBlock * suballoc.Word,
+		4*spanBlock*suballoc.Word + 3,
+		9*spanBlock*suballoc.Word - 6,
 		spanBlock,
 		spanBlock * 2,
 		spanBlock + 1,
@@ -201,8 +207,8 @@ func TestMeshBuffer(t *testing.T) {
 		acc += x
 	}
 
-	slen := b.spanMap.Len()
-	srem := b.spanMap.Rem()
+	slen := b.spans.Len()
+	srem := slen - b.spans.Used()
 	bcap := int(b.buf.Cap())
 	t.Logf("total requested size: %d bytes", acc)
 	t.Logf("spans:\n%v", spans)
@@ -266,6 +272,7 @@ func TestSemanticConv(t *testing.T) {
 		driver.Uint16,
 		driver.Uint32, driver.Uint32x2, driver.Uint32x3, driver.Uint32x4,
 		driver.Float32,
+		driver.RGB10A2Norm,
 	}
 	var fmts [MaxSemantic][]driver.VertexFmt
 	fmts[Position.I()] = append(append([]driver.VertexFmt{},
@@ -300,6 +307,21 @@ func TestSemanticConv(t *testing.T) {
 		driver.Uint16x2, driver.Uint16x3,
 		driver.Float32x2, driver.Float32x3,
 	), finval[:]...)
+	// Signed normalized formats that some
+	// semantics accept.
+	for _, x := range [...]struct {
+		sem  Semantic
+		fmts []driver.VertexFmt
+	}{
+		{Normal, []driver.VertexFmt{driver.Int8x3, driver.Int16x3, driver.RGB10A2Norm}},
+		{Tangent, []driver.VertexFmt{driver.Int8x4, driver.Int16x4, driver.RGB10A2Norm}},
+		{TexCoord0, []driver.VertexFmt{driver.Int8x2, driver.Int16x2}},
+		{TexCoord1, []driver.VertexFmt{driver.Int8x2, driver.Int16x2}},
+	} {
+		fmts[x.sem.I()] = slices.DeleteFunc(fmts[x.sem.I()], func(f driver.VertexFmt) bool {
+			return slices.Contains(x.fmts, f)
+		})
+	}
 	for i := range fmts {
 		sem := Semantic(1 << i)
 		for _, f := range fmts[i] {
@@ -566,7 +588,7 @@ func dummyData1(ntris int) MeshData {
 		fillDummySem(s, d)
 		srcs[i] = bytes.NewReader(d)
 	}
-	return MeshData{[]PrimitiveData{p}, srcs}
+	return MeshData{Primitives: []PrimitiveData{p}, Srcs: srcs}
 }
 
 func checkDummyData1(m *Mesh, ntris int, t *testing.T) {
@@ -637,7 +659,7 @@ func dummyData2(ntris int) MeshData {
 	fillDummyIdx(p.Index.Format, d)
 	srcs[1] = bytes.NewReader(d)
 
-	return MeshData{[]PrimitiveData{p}, srcs}
+	return MeshData{Primitives: []PrimitiveData{p}, Srcs: srcs}
 }
 
 func checkDummyData2(m *Mesh, ntris int, t *testing.T) {
@@ -746,7 +768,7 @@ func dummyData3(ntris int) MeshData {
 		fillDummySem(Semantic(1<<i), d[x.Offset:x.Offset+sz])
 	}
 
-	return MeshData{[]PrimitiveData{p}, []io.ReadSeeker{bytes.NewReader(d)}}
+	return MeshData{Primitives: []PrimitiveData{p}, Srcs: []io.ReadSeeker{bytes.NewReader(d)}}
 }
 
 func checkDummyData3(m *Mesh, ntris int, t *testing.T) {
@@ -848,7 +870,7 @@ func dummyData4(ntris int) MeshData {
 		srcs = append(srcs, bytes.NewReader(d))
 	}
 
-	return MeshData{[]PrimitiveData{p3, p1, p2}, srcs}
+	return MeshData{Primitives: []PrimitiveData{p3, p1, p2}, Srcs: srcs}
 }
 
 func checkDummyData4(m *Mesh, ntris int, t *testing.T) {
@@ -1096,7 +1118,7 @@ func TestMeshFree(t *testing.T) {
 	take := func(m *Mesh, s *snapshot) {
 		s.spans = s.spans[:0]
 		s.nspan, s.nprim = 0, m.primLen
-		s.rspan, s.rprim = meshes.spanMap.Rem(), meshes.primMap.Rem()
+		s.rspan, s.rprim = meshes.spans.Len()-meshes.spans.Used(), meshes.primMap.Rem()
 		p := m.primIdx
 		for {
 			prim := &meshes.prims[p]
@@ -1117,16 +1139,16 @@ func TestMeshFree(t *testing.T) {
 		}
 	}
 	check := func(s *snapshot) {
-		if x, y := meshes.spanMap.Rem(), s.rspan+s.nspan; x != y {
-			t.Fatalf("Mesh.Free: spanMap.Rem()\nhave %d\nwant %d\n(should remove %d block(s))", x, y, s.nspan)
+		if x, y := meshes.spans.Len()-meshes.spans.Used(), s.rspan+s.nspan; x != y {
+			t.Fatalf("Mesh.Free: spans.Len()-spans.Used()\nhave %d\nwant %d\n(should remove %d block(s))", x, y, s.nspan)
 		}
 		if x, y := meshes.primMap.Rem(), s.rprim+s.nprim; x != y {
 			t.Fatalf("Mesh.Free: primMap.Rem()\nhave %d\nwant %d\n(should remove %d primitive(s))", x, y, s.nprim)
 		}
 		for _, x := range s.spans {
 			for i := x.start; i < x.end; i++ {
-				if meshes.spanMap.IsSet(i) {
-					t.Fatalf("Mesh.Free: spanMap.IsSet(%d)\nhave true\nwant false", i)
+				if meshes.spans.Allocated(i) {
+					t.Fatalf("Mesh.Free: spans.Allocated(%d)\nhave true\nwant false", i)
 				}
 			}
 		}
@@ -1348,6 +1370,9 @@ func BenchmarkMeshGrow(b *testing.B) {
 	if buf := setMeshBuffer(nil); buf != nil {
 		buf.Destroy()
 	}
+	dfl := MeshGrowth()
+	SetMeshGrowth(&GrowthPolicy{})
+	defer SetMeshGrowth(&dfl)
 	data := dummyData1(ntrisBench)
 	b.Run("x", func(b *testing.B) {
 		// Will grow the buffer on every iteration.
@@ -1367,7 +1392,7 @@ func BenchmarkMeshGrow(b *testing.B) {
 		})
 	})
 	b.Log("buf.Cap():", meshes.buf.Cap())
-	b.Log("spanMap.Rem()/Len():", meshes.spanMap.Rem(), meshes.spanMap.Len())
+	b.Log("spans.Used()/Len():", meshes.spans.Used(), meshes.spans.Len())
 	b.Log("primMap.Rem()/Len():", meshes.primMap.Rem(), meshes.primMap.Len())
 }
 
@@ -1398,7 +1423,7 @@ func BenchmarkMeshPre(b *testing.B) {
 		})
 	})
 	b.Log("buf.Cap():", meshes.buf.Cap())
-	b.Log("spanMap.Rem()/Len():", meshes.spanMap.Rem(), meshes.spanMap.Len())
+	b.Log("spans.Used()/Len():", meshes.spans.Used(), meshes.spans.Len())
 	b.Log("primMap.Rem()/Len():", meshes.primMap.Rem(), meshes.primMap.Len())
 }
 
@@ -1428,6 +1453,245 @@ func BenchmarkMeshFree(b *testing.B) {
 		})
 	})
 	b.Log("buf.Cap():", meshes.buf.Cap())
-	b.Log("spanMap.Rem()/Len():", meshes.spanMap.Rem(), meshes.spanMap.Len())
+	b.Log("spans.Used()/Len():", meshes.spans.Used(), meshes.spans.Len())
 	b.Log("primMap.Rem()/Len():", meshes.primMap.Rem(), meshes.primMap.Len())
 }
+
+func TestSemanticConvSigned(t *testing.T) {
+	i16 := []int16{32767, -32767, -32768, 16384, 0, -1, 1, 100}
+	i8 := []int8{127, -127, -128, 64, 0, -1, 1, 100}
+	b16 := make([]byte, len(i16)*2)
+	for i, x := range i16 {
+		binary.LittleEndian.PutUint16(b16[i*2:], uint16(x))
+	}
+	b8 := make([]byte, len(i8))
+	for i, x := range i8 {
+		b8[i] = byte(x)
+	}
+	snorm16 := func(x int16) float32 { return max(float32(x)/32767, -1) }
+	snorm8 := func(x int8) float32 { return max(float32(x)/127, -1) }
+	read := func(r io.Reader, n int) []float32 {
+		b, err := io.ReadAll(r)
+		if err != nil || len(b) != n*4 {
+			t.Fatalf("Semantic.conv: ReadAll: %d, %v", len(b), err)
+		}
+		v := make([]float32, n)
+		for i := range v {
+			v[i] = *(*float32)(unsafe.Pointer(&b[i*4]))
+		}
+		return v
+	}
+	for _, x := range [...]struct {
+		sem  Semantic
+		fmt  driver.VertexFmt
+		src  []byte
+		want func(i int) float32
+	}{
+		{Normal, driver.Int16x3, b16[:12], func(i int) float32 { return snorm16(i16[i]) }},
+		{Normal, driver.Int8x3, b8[:6], func(i int) float32 { return snorm8(i8[i]) }},
+		{Tangent, driver.Int16x4, b16, func(i int) float32 { return snorm16(i16[i]) }},
+		{Tangent, driver.Int8x4, b8, func(i int) float32 { return snorm8(i8[i]) }},
+		{TexCoord0, driver.Int16x2, b16, func(i int) float32 { return snorm16(i16[i]) }},
+		{TexCoord1, driver.Int8x2, b8, func(i int) float32 { return snorm8(i8[i]) }},
+	} {
+		cnt := len(x.src) / x.fmt.Size()
+		r, err := x.sem.conv(x.fmt, bytes.NewReader(x.src), cnt)
+		if err != nil {
+			t.Fatalf("%s.conv(%v) failed: %v", x.sem, x.fmt, err)
+		}
+		for i, v := range read(r, cnt*x.sem.format().Components()) {
+			if w := x.want(i); v != w {
+				t.Fatalf("%s.conv(%v): [%d]\nhave %f\nwant %f", x.sem, x.fmt, i, v, w)
+			}
+		}
+	}
+
+	// 10-10-10-2: x = 511, y = -512, z = 256, w = -1.
+	pk := uint32(511) | uint32(0x200)<<10 | uint32(256)<<20 | uint32(3)<<30
+	var src [8]byte
+	binary.LittleEndian.PutUint32(src[:], pk)
+	binary.LittleEndian.PutUint32(src[4:], 0)
+	want := []float32{1, -1, 256.0 / 511, -1, 0, 0, 0, 0}
+	r, err := Tangent.conv(driver.RGB10A2Norm, bytes.NewReader(src[:]), 2)
+	if err != nil {
+		t.Fatalf("Tangent.conv(RGB10A2Norm) failed: %v", err)
+	}
+	if v := read(r, 8); !slices.Equal(v, want) {
+		t.Fatalf("Tangent.conv(RGB10A2Norm):\nhave %v\nwant %v", v, want)
+	}
+	r, err = Normal.conv(driver.RGB10A2Norm, bytes.NewReader(src[:4]), 1)
+	if err != nil {
+		t.Fatalf("Normal.conv(RGB10A2Norm) failed: %v", err)
+	}
+	if v := read(r, 3); !slices.Equal(v, want[:3]) {
+		t.Fatalf("Normal.conv(RGB10A2Norm):\nhave %v\nwant %v", v, want[:3])
+	}
+}
+
+func TestConvReader(t *testing.T) {
+	const cnt = convChunk*2 + 5
+	src := make([]byte, cnt*4)
+	for i := range src {
+		src[i] = byte(i)
+	}
+	r, err := Color0.conv(driver.Uint8x4, bytes.NewReader(src), cnt)
+	if err != nil {
+		t.Fatalf("Color0.conv failed: %v", err)
+	}
+	cr := r.(*convReader)
+	if n := len(cr.buf) + len(cr.conv); n != convChunk*(4+16) {
+		t.Fatalf("convReader: buffer size\nhave %d\nwant %d", n, convChunk*(4+16))
+	}
+	var dst [16]byte
+	for i := range cnt {
+		if _, err := io.ReadFull(r, dst[:]); err != nil {
+			t.Fatalf("convReader.Read failed: %v", err)
+		}
+		for j := range 4 {
+			have := *(*float32)(unsafe.Pointer(&dst[j*4]))
+			want := float32(src[i*4+j]) / 255
+			if have != want {
+				t.Fatalf("convReader.Read: element %d[%d]\nhave %f\nwant %f", i, j, have, want)
+			}
+		}
+	}
+	if n, err := r.Read(dst[:]); n != 0 || err != io.EOF {
+		t.Fatalf("convReader.Read: n, err\nhave %d, %v\nwant 0, %v", n, err, io.EOF)
+	}
+
+	// Truncated source.
+	r, _ = Color0.conv(driver.Uint8x4, bytes.NewReader(src[:convChunk*4+2]), cnt)
+	if _, err := io.Copy(io.Discard, r); err != io.ErrUnexpectedEOF {
+		t.Fatalf("convReader.Read: err\nhave %v\nwant %v", err, io.ErrUnexpectedEOF)
+	}
+}
+
+func TestBoundsReader(t *testing.T) {
+	pos := []linear.V3{
+		{1, -2, 0.5},
+		{-3, 4, 0},
+		{0, 0, -6},
+		{2, 1, 1},
+	}
+	var buf bytes.Buffer
+	for i := range pos {
+		binary.Write(&buf, binary.LittleEndian, pos[i])
+	}
+	b := boundsReader{r: &buf}
+	// Use a short, odd-sized buffer so positions
+	// span multiple reads.
+	p := make([]byte, 5)
+	for {
+		if _, err := b.Read(p); err == io.EOF {
+			break
+		} else if err != nil {
+			t.Fatalf("boundsReader.Read failed:\n%#v", err)
+		}
+	}
+	if b.cnt != len(pos) {
+		t.Fatalf("boundsReader.cnt\nhave %d\nwant %d", b.cnt, len(pos))
+	}
+	if want := (linear.V3{-3, -2, -6}); b.min != want {
+		t.Fatalf("boundsReader.min\nhave %v\nwant %v", b.min, want)
+	}
+	if want := (linear.V3{2, 4, 1}); b.max != want {
+		t.Fatalf("boundsReader.max\nhave %v\nwant %v", b.max, want)
+	}
+}
+
+// prevPosition is a custom semantic registered
+// for testing.
+var prevPosition = sync.OnceValues(func() (Semantic, error) {
+	return RegisterSemantic(&SemanticParam{
+		Name:     "PrevPosition",
+		Format:   driver.Float32x3,
+		Location: MaxSemantic + 4,
+	})
+})
+
+func TestCustomSemantic(t *testing.T) {
+	defer func() {
+		b := setMeshBuffer(nil)
+		if b != nil {
+			b.Destroy()
+		}
+	}()
+
+	for _, x := range [...]*SemanticParam{
+		nil,
+		{Name: "Bad", Format: driver.Float32x3, Location: Weights0.I()},
+		{Name: "Bad", Format: driver.Float32x3, Location: ctxt.Limits().MaxVertexIn},
+		{Name: "Bad", Format: 0, Location: MaxSemantic},
+	} {
+		if s, err := RegisterSemantic(x); err == nil {
+			t.Fatalf("RegisterSemantic(%v): unexpected success (%v)", x, s)
+		}
+	}
+	sem, err := prevPosition()
+	if err != nil {
+		t.Fatalf("RegisterSemantic failed: %v", err)
+	}
+	if sem.I() < MaxSemantic {
+		t.Fatalf("RegisterSemantic: Semantic.I\nhave %d\nwant >= %d", sem.I(), MaxSemantic)
+	}
+	if _, err := RegisterSemantic(&SemanticParam{Name: "Dup", Format: driver.Float32x2, Location: MaxSemantic + 4}); err == nil {
+		t.Fatal("RegisterSemantic: duplicate location should fail")
+	}
+	if s := sem.String(); s != "PrevPosition" {
+		t.Fatalf("Semantic.String\nhave %s\nwant PrevPosition", s)
+	}
+	if f := sem.format(); f != driver.Float32x3 {
+		t.Fatalf("Semantic.format\nhave %v\nwant %v", f, driver.Float32x3)
+	}
+	if _, err := sem.conv(driver.Int16x3, bytes.NewReader(make([]byte, 6)), 1); err == nil {
+		t.Fatal("Semantic.conv: custom semantic should not convert")
+	}
+
+	const nv = 3
+	pos := make([]byte, nv*12)
+	for i := range nv * 3 {
+		binary.LittleEndian.PutUint32(pos[i*4:], math.Float32bits(float32(i)))
+	}
+	data := MeshData{
+		Primitives: []PrimitiveData{{
+			Topology:     driver.TTriangle,
+			VertexCount:  nv,
+			SemanticMask: Position,
+		}},
+		Srcs: []io.ReadSeeker{bytes.NewReader(pos)},
+	}
+	pdata := &data.Primitives[0]
+	pdata.Semantics[Position.I()] = SemanticData{Format: driver.Float32x3}
+	pdata.SemanticMask |= sem
+	if err := validateMeshData(&data); err == nil {
+		t.Fatal("validateMeshData: missing custom data should fail")
+	}
+	pdata.SetSemantic(sem, SemanticData{Format: driver.Float32x3})
+	if n := sem.I() - MaxSemantic + 1; len(pdata.Custom) != n {
+		t.Fatalf("PrimitiveData.SetSemantic: len(Custom)\nhave %d\nwant %d", len(pdata.Custom), n)
+	}
+
+	used := meshes.spans.Used()
+	m, err := NewMesh(&data)
+	if err != nil {
+		t.Fatalf("NewMesh failed: %v", err)
+	}
+	want := []driver.VertexIn{
+		{Format: driver.Float32x3, Stride: 12, Nr: Position.I()},
+		{Format: driver.Float32x3, Stride: 12, Nr: MaxSemantic + 4},
+	}
+	if have := m.inputs(0); !slices.Equal(have, want) {
+		t.Fatalf("Mesh.inputs\nhave %v\nwant %v", have, want)
+	}
+	meshes.RLock()
+	v := meshes.prims[m.primIdx].attr(sem.I())
+	b := meshes.buf.Bytes()[v.byteStart() : v.byteStart()+len(pos)]
+	meshes.RUnlock()
+	if !bytes.Equal(b, pos) {
+		t.Fatalf("NewMesh: custom semantic data\nhave %v\nwant %v", b, pos)
+	}
+	m.Free()
+	if x := meshes.spans.Used(); x != used {
+		t.Fatalf("Mesh.Free: mesh buffer spans in use\nhave %d\nwant %d", x, used)
+	}
+}
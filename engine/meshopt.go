@@ -0,0 +1,233 @@
+// Copyright 2024 Gustavo C. Viegas. All rights reserved.
+
+package engine
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"slices"
+
+	"gviegas/neo3/driver"
+	"gviegas/neo3/internal/meshopt"
+)
+
+// MeshOpt is a bit mask of optimizations that NewMesh
+// can apply to mesh data.
+type MeshOpt int
+
+// Mesh optimizations.
+const (
+	// Merge vertices whose data is identical in every
+	// semantic. Primitives that have no index data
+	// will be made indexed if any vertex is merged.
+	WeldVertices MeshOpt = 1 << iota
+	// Reorder triangles to improve the hit rate of the
+	// post-transform vertex cache, then reorder vertices
+	// by first use. Only indexed driver.TTriangle
+	// primitives are affected.
+	// Vertices that are not referenced are removed.
+	OptimizeVertexCache
+	// Store indices as driver.Index16 whenever every
+	// index fits in 16 bits.
+	NarrowIndices
+	// Partition driver.TTriangle primitives into
+	// meshlets and store them alongside the mesh.
+	// This is done after other optimizations.
+	GenerateMeshlets
+)
+
+// Meshlet limits used by GenerateMeshlets.
+const (
+	maxMeshletVertices  = 64
+	maxMeshletTriangles = 124
+)
+
+// optimizeMesh applies data.Optimize to every primitive
+// in data.
+// It returns a new MeshData whose data sources are
+// data.Srcs followed by one source for each
+// optimized primitive.
+// If GenerateMeshlets is set, it also returns the
+// meshlets of each primitive (nil for primitives
+// that cannot be partitioned).
+// data must have been validated.
+func optimizeMesh(data *MeshData) (*MeshData, []*meshopt.Meshlets, error) {
+	opt := &MeshData{
+		Primitives: slices.Clone(data.Primitives),
+		Srcs:       slices.Clone(data.Srcs),
+	}
+	var mls []*meshopt.Meshlets
+	if data.Optimize&GenerateMeshlets != 0 {
+		mls = make([]*meshopt.Meshlets, len(opt.Primitives))
+	}
+	for i := range opt.Primitives {
+		pdata := &opt.Primitives[i]
+		// Custom is shared with data.
+		pdata.Custom = slices.Clone(pdata.Custom)
+		b, ml, err := optimizePrimitive(pdata, data.Srcs, data.Optimize, len(opt.Srcs))
+		if err != nil {
+			return nil, nil, err
+		}
+		if b != nil {
+			opt.Srcs = append(opt.Srcs, bytes.NewReader(b))
+		}
+		if mls != nil {
+			mls[i] = ml
+		}
+	}
+	return opt, mls, nil
+}
+
+// optimizePrimitive reads the data of pdata from srcs
+// and applies the given optimizations to it.
+// It updates pdata to refer to the returned data, which
+// is identified by src.
+// It returns nil data if pdata is left unchanged.
+func optimizePrimitive(pdata *PrimitiveData, srcs []io.ReadSeeker, opt MeshOpt, src int) ([]byte, *meshopt.Meshlets, error) {
+	nv := pdata.VertexCount
+	if nv == 0 {
+		return nil, nil, nil
+	}
+
+	var sems []int
+	var streams []meshopt.Stream
+	for i := range semanticCount() {
+		sem := Semantic(1 << i)
+		if pdata.SemanticMask&sem == 0 {
+			continue
+		}
+		sdata := pdata.semantic(i)
+		r := srcs[sdata.Src]
+		if _, err := r.Seek(sdata.Offset, io.SeekStart); err != nil {
+			return nil, nil, err
+		}
+		conv, err := sem.conv(sdata.Format, r, nv)
+		if err != nil {
+			return nil, nil, err
+		}
+		stride := sem.format().Size()
+		b := make([]byte, nv*stride)
+		if _, err := io.ReadFull(conv, b); err != nil {
+			return nil, nil, err
+		}
+		sems = append(sems, i)
+		streams = append(streams, meshopt.Stream{Data: b, Stride: stride})
+	}
+
+	var indices []uint32
+	format := driver.Index32
+	if pdata.IndexCount > 0 {
+		format = pdata.Index.Format
+		var isz int
+		switch format {
+		case driver.Index16:
+			isz = 2
+		case driver.Index32:
+			isz = 4
+		default:
+			return nil, nil, newMeshErr("undefined driver.IndexFmt constant")
+		}
+		r := srcs[pdata.Index.Src]
+		if _, err := r.Seek(pdata.Index.Offset, io.SeekStart); err != nil {
+			return nil, nil, err
+		}
+		b := make([]byte, pdata.IndexCount*isz)
+		if _, err := io.ReadFull(r, b); err != nil {
+			return nil, nil, err
+		}
+		indices = make([]uint32, pdata.IndexCount)
+		for i := range indices {
+			if isz == 2 {
+				indices[i] = uint32(binary.LittleEndian.Uint16(b[i*2:]))
+			} else {
+				indices[i] = binary.LittleEndian.Uint32(b[i*4:])
+			}
+			if indices[i] >= uint32(nv) {
+				return nil, nil, newMeshErr("index out of bounds")
+			}
+		}
+	}
+
+	changed := false
+	remapStreams := func(remap []uint32, n int) {
+		for i := range streams {
+			streams[i].Data = meshopt.RemapStream(streams[i], remap, n)
+		}
+		nv = n
+		changed = true
+	}
+	if opt&WeldVertices != 0 {
+		if remap, n := meshopt.Weld(nv, streams...); n < nv {
+			if indices == nil {
+				indices = remap
+			} else {
+				meshopt.RemapIndices(indices, remap)
+			}
+			remapStreams(remap, n)
+		}
+	}
+	if opt&OptimizeVertexCache != 0 && pdata.Topology == driver.TTriangle && indices != nil {
+		meshopt.OptimizeVertexCache(indices, nv)
+		remapStreams(meshopt.OptimizeVertexFetch(indices, nv))
+	}
+	var narrow []uint16
+	if opt&NarrowIndices != 0 && indices != nil && format != driver.Index16 {
+		var ok bool
+		if narrow, ok = meshopt.Narrow(indices); ok {
+			format = driver.Index16
+			changed = true
+		}
+	}
+	var ml *meshopt.Meshlets
+	if opt&GenerateMeshlets != 0 && pdata.Topology == driver.TTriangle {
+		tris := indices
+		if tris == nil {
+			tris = make([]uint32, nv)
+			for i := range tris {
+				tris[i] = uint32(i)
+			}
+		}
+		// Position is always present and
+		// is the first semantic.
+		x := meshopt.BuildMeshlets(tris, streams[0], maxMeshletVertices, maxMeshletTriangles)
+		ml = &x
+	}
+	if !changed {
+		return nil, ml, nil
+	}
+
+	var b []byte
+	for i, x := range sems {
+		*pdata.semantic(x) = SemanticData{
+			Format: Semantic(1 << x).format(),
+			Offset: int64(len(b)),
+			Src:    src,
+		}
+		b = append(b, streams[i].Data...)
+	}
+	pdata.VertexCount = nv
+	if indices != nil {
+		pdata.IndexCount = len(indices)
+		pdata.Index = IndexData{
+			Format: format,
+			Offset: int64(len(b)),
+			Src:    src,
+		}
+		switch {
+		case narrow != nil:
+			for _, x := range narrow {
+				b = binary.LittleEndian.AppendUint16(b, x)
+			}
+		case format == driver.Index16:
+			for _, x := range indices {
+				b = binary.LittleEndian.AppendUint16(b, uint16(x))
+			}
+		default:
+			for _, x := range indices {
+				b = binary.LittleEndian.AppendUint32(b, x)
+			}
+		}
+	}
+	return b, ml, nil
+}
@@ -0,0 +1,174 @@
+// Copyright 2024 Gustavo C. Viegas. All rights reserved.
+
+package engine
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"math"
+	"slices"
+	"testing"
+
+	"gviegas/neo3/driver"
+)
+
+// quadData creates a non-indexed quad made of two
+// triangles, with an unused trailing vertex.
+func quadData(opt MeshOpt) *MeshData {
+	pos := [...][3]float32{
+		{0, 0, 0}, {0, 1, 0}, {1, 0, 0},
+		{1, 0, 0}, {0, 1, 0}, {1, 1, 0},
+	}
+	var d []byte
+	for _, v := range pos {
+		for _, x := range v {
+			d = binary.LittleEndian.AppendUint32(d, math.Float32bits(x))
+		}
+	}
+	// TexCoord0 as driver.Uint8x2, which must
+	// be converted.
+	d = append(d, 0, 0, 0, 255, 255, 0, 255, 0, 0, 255, 255, 255)
+	p := PrimitiveData{
+		Topology:     driver.TTriangle,
+		VertexCount:  len(pos),
+		SemanticMask: Position | TexCoord0,
+	}
+	p.Semantics[Position.I()] = SemanticData{Format: driver.Float32x3}
+	p.Semantics[TexCoord0.I()] = SemanticData{Format: driver.Uint8x2, Offset: int64(len(pos) * 12)}
+	return &MeshData{
+		Primitives: []PrimitiveData{p},
+		Srcs:       []io.ReadSeeker{bytes.NewReader(d)},
+		Optimize:   opt,
+	}
+}
+
+// readIndices reads the index data of p.
+func readIndices(p *PrimitiveData, srcs []io.ReadSeeker, t *testing.T) (s []uint32) {
+	b := make([]byte, p.IndexCount*int(p.Index.Format))
+	srcs[p.Index.Src].Seek(p.Index.Offset, io.SeekStart)
+	if _, err := io.ReadFull(srcs[p.Index.Src], b); err != nil {
+		t.Fatal(err)
+	}
+	for len(b) > 0 {
+		if p.Index.Format == driver.Index16 {
+			s = append(s, uint32(binary.LittleEndian.Uint16(b)))
+			b = b[2:]
+		} else {
+			s = append(s, binary.LittleEndian.Uint32(b))
+			b = b[4:]
+		}
+	}
+	return
+}
+
+func TestOptimizeMesh(t *testing.T) {
+	data := quadData(WeldVertices | NarrowIndices)
+	opt, _, err := optimizeMesh(data)
+	if err != nil {
+		t.Fatalf("optimizeMesh failed:\n%v", err)
+	}
+	if x := len(opt.Srcs); x != 2 {
+		t.Fatalf("optimizeMesh: len(Srcs)\nhave %d\nwant 2", x)
+	}
+	p := &opt.Primitives[0]
+	if p.VertexCount != 4 || p.IndexCount != 6 || p.Index.Format != driver.Index16 {
+		t.Fatalf("optimizeMesh: VertexCount, IndexCount, Index.Format\nhave %d, %d, %v\nwant 4, 6, %v",
+			p.VertexCount, p.IndexCount, p.Index.Format, driver.Index16)
+	}
+	if x, y := readIndices(p, opt.Srcs, t), []uint32{0, 1, 2, 2, 1, 3}; !slices.Equal(x, y) {
+		t.Fatalf("optimizeMesh: indices\nhave %v\nwant %v", x, y)
+	}
+	for _, s := range [...]Semantic{Position, TexCoord0} {
+		if x := p.Semantics[s.I()]; x.Format != s.format() || x.Src != 1 {
+			t.Fatalf("optimizeMesh: Semantics[%v]\nhave %v\nwant format %v, src 1", s, x, s.format())
+		}
+	}
+	// The original data must be left intact.
+	if x := data.Primitives[0]; x.VertexCount != 6 || x.IndexCount != 0 {
+		t.Fatal("optimizeMesh: original data modified")
+	}
+
+	// Nothing to weld in an indexed primitive that
+	// uses 32-bit indices.
+	data = quadData(WeldVertices | OptimizeVertexCache)
+	if opt, _, err = optimizeMesh(data); err != nil {
+		t.Fatalf("optimizeMesh failed:\n%v", err)
+	}
+	data = opt
+	data.Optimize = WeldVertices
+	if opt, _, err = optimizeMesh(data); err != nil {
+		t.Fatalf("optimizeMesh failed:\n%v", err)
+	}
+	if x, y := len(opt.Srcs), len(data.Srcs); x != y {
+		t.Fatalf("optimizeMesh: len(Srcs)\nhave %d\nwant %d", x, y)
+	}
+	if p := &opt.Primitives[0]; p.Index.Format != driver.Index32 {
+		t.Fatalf("optimizeMesh: Index.Format\nhave %v\nwant %v", p.Index.Format, driver.Index32)
+	}
+
+	data = quadData(WeldVertices)
+	data.Primitives[0].IndexCount = 3
+	data.Primitives[0].Index = IndexData{Format: driver.Index16, Src: 1}
+	data.Srcs = append(data.Srcs, bytes.NewReader([]byte{0, 0, 1, 0, 6, 0}))
+	if _, _, err = optimizeMesh(data); err == nil {
+		t.Fatal("optimizeMesh: unexpected success with out of bounds index")
+	}
+}
+
+func TestOptimizeMeshlets(t *testing.T) {
+	data := quadData(GenerateMeshlets)
+	opt, mls, err := optimizeMesh(data)
+	if err != nil {
+		t.Fatalf("optimizeMesh failed:\n%v", err)
+	}
+	// Meshlets alone do not change the data.
+	if x := len(opt.Srcs); x != 1 {
+		t.Fatalf("optimizeMesh: len(Srcs)\nhave %d\nwant 1", x)
+	}
+	if len(mls) != 1 || mls[0] == nil {
+		t.Fatalf("optimizeMesh: meshlets\nhave %v\nwant 1 non-nil", mls)
+	}
+	ml := mls[0]
+	if len(ml.Meshlets) != 1 || len(ml.Vertices) != 6 || len(ml.Triangles) != 6 {
+		t.Fatalf("optimizeMesh: meshlets\nhave %+v", ml)
+	}
+
+	data = quadData(WeldVertices | GenerateMeshlets)
+	if _, mls, err = optimizeMesh(data); err != nil {
+		t.Fatalf("optimizeMesh failed:\n%v", err)
+	}
+	if ml = mls[0]; len(ml.Vertices) != 4 || !slices.Equal(ml.Triangles, []uint8{0, 1, 2, 2, 1, 3}) {
+		t.Fatalf("optimizeMesh: meshlets\nhave %+v", ml)
+	}
+
+	data = quadData(GenerateMeshlets)
+	data.Primitives[0].Topology = driver.TTriStrip
+	if _, mls, err = optimizeMesh(data); err != nil {
+		t.Fatalf("optimizeMesh failed:\n%v", err)
+	}
+	if mls[0] != nil {
+		t.Fatalf("optimizeMesh: meshlets\nhave %+v\nwant nil", mls[0])
+	}
+}
+
+func TestMeshMeshlets(t *testing.T) {
+	rem := meshes.spans.Len() - meshes.spans.Used()
+	m, err := NewMesh(quadData(WeldVertices | GenerateMeshlets))
+	if err != nil {
+		t.Fatalf("NewMesh failed:\n%v", err)
+	}
+	if x := m.meshlets(0); len(x) != 1 || x[0].TriangleCount != 2 {
+		t.Fatalf("Mesh.meshlets:\nhave %+v\nwant 1 meshlet with 2 triangles", x)
+	}
+	meshes.RLock()
+	p := meshes.prims[m.primIdx]
+	meshes.RUnlock()
+	if p.meshlet.start >= p.meshlet.end || p.meshletTri != 16 {
+		t.Fatalf("NewMesh: meshlet span/offset\nhave %v, %d\nwant non-empty, 16", p.meshlet, p.meshletTri)
+	}
+	m.Free()
+	if x := meshes.spans.Len() - meshes.spans.Used(); x < rem {
+		t.Fatalf("Mesh.Free: free blocks\nhave %d\nwant >= %d", x, rem)
+	}
+}
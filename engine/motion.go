@@ -0,0 +1,312 @@
+// Copyright 2024 Gustavo C. Viegas. All rights reserved.
+
+package engine
+
+import (
+	"gviegas/neo3/driver"
+	"gviegas/neo3/engine/internal/shader"
+	"gviegas/neo3/linear"
+)
+
+// MotionBlurParam describes motion blur.
+// Shutter is the fraction, in (0, 1], of the frame
+// interval during which the shutter is open (e.g., 0.5
+// for a 180° shutter). Longer exposures produce longer
+// streaks.
+// MaxBlur is the maximum blur radius, in pixels. It
+// defaults to motionTile if zero, and must not exceed
+// that.
+// Samples is the number of samples taken per pixel.
+// It defaults to 12 if zero.
+type MotionBlurParam struct {
+	Shutter float32
+	MaxBlur int
+	Samples int
+}
+
+// Motion blur limits.
+const (
+	// Size of velocity tiles, in pixels.
+	motionTile = 32
+	// Maximum number of samples per pixel.
+	// It must match MOTION_MAX_SAMPLES in motion_0.
+	motionMaxSamples = 32
+	// Default number of samples.
+	motionSamples = 12
+)
+
+// velocityFmt is the pixel format of velocity buffers.
+const velocityFmt = driver.RG16Float
+
+// motion is the state of the motion blur passes.
+type motion struct {
+	param  MotionBlurParam
+	layout shader.MotionLayout
+	// Largest velocity of each tile, and the
+	// same dilated over neighboring tiles.
+	tileMax  *Texture
+	neighbor *Texture
+	// Blurred color, copied back to the HDR
+	// target by the resolve pass.
+	blur *Texture
+}
+
+// Names of the motion passes.
+const (
+	velocityPass       = "velocity"
+	motionTileMaxPass  = "motion.tileMax"
+	motionNeighborPass = "motion.neighborMax"
+	motionBlurPass     = "motion.blur"
+	motionResolvePass  = "motion.resolve"
+)
+
+// motionPasses are the passes that motion blur
+// needs.
+var motionPasses = [...]string{
+	velocityPass,
+	motionTileMaxPass,
+	motionNeighborPass,
+	motionBlurPass,
+	motionResolvePass,
+}
+
+// SetMotionBlur enables motion blur in r.
+// If param is nil, motion blur is disabled.
+// Both camera and object motion are blurred, using a
+// velocity buffer rendered along with opaque geometry.
+// Motion blur runs after depth of field (see
+// SetCamera) and before any other post-processing.
+// It fails with ErrUnsupported while the motion blur
+// passes are not recorded.
+func (r *Renderer) SetMotionBlur(param *MotionBlurParam) error {
+	if param == nil {
+		r.freeMotion()
+		return nil
+	}
+	var reason string
+	switch {
+	case !(param.Shutter > 0 && param.Shutter <= 1):
+		reason = "motion blur shutter out of range"
+	case param.MaxBlur < 0 || param.MaxBlur > motionTile:
+		reason = "motion blur radius out of range"
+	case param.Samples < 0 || param.Samples > motionMaxSamples:
+		reason = "invalid motion blur sample count"
+	default:
+		goto validParam
+	}
+	return newRendErr(reason)
+validParam:
+	if err := checkPending("motion blur", motionPasses[:]...); err != nil {
+		return err
+	}
+	if r.motion == nil {
+		if err := r.initMotion(); err != nil {
+			return err
+		}
+	}
+	m := r.motion
+	m.param = *param
+	maxBlur := param.MaxBlur
+	if maxBlur == 0 {
+		maxBlur = motionTile
+	}
+	samples := param.Samples
+	if samples == 0 {
+		samples = motionSamples
+	}
+	m.layout.SetShutter(param.Shutter)
+	m.layout.SetBlur(float32(maxBlur), samples, motionTile)
+	return nil
+}
+
+// MotionBlur returns the motion blur parameters of r.
+// If motion blur is disabled, it returns false.
+func (r *Renderer) MotionBlur() (MotionBlurParam, bool) {
+	if r.motion == nil {
+		return MotionBlurParam{}, false
+	}
+	return r.motion.param, true
+}
+
+// velocityBuffer ensures that r has a velocity buffer,
+// which holds the screen-space motion of every pixel
+// since the previous frame.
+// The velocity pass draws opaque geometry transformed
+// by both the current and the previous transforms,
+// testing against the depth of the geometry stage.
+// It then draws a full-screen triangle at the far
+// plane, so that pixels not covered by geometry get
+// the motion of the camera alone.
+// Once created, the velocity buffer is kept for the
+// lifetime of r, since it can be shared by any pass
+// that reprojects the previous frame.
+func (r *Renderer) velocityBuffer() (err error) {
+	if r.vel != nil {
+		return
+	}
+	r.vel, err = NewTarget(&TexParam{
+		PixelFmt: velocityFmt,
+		Dim3D:    driver.Dim3D{Width: r.hdr.Width(), Height: r.hdr.Height()},
+		Layers:   1,
+		Levels:   1,
+		Samples:  1,
+	})
+	if err != nil {
+		return
+	}
+	r.graph.add(&passNode{
+		name:   velocityPass,
+		stage:  stageGeometry,
+		writes: []*Texture{r.vel, r.ds},
+	})
+	return
+}
+
+// initMotion creates the motion blur targets and adds
+// the motion blur passes to r's frame graph.
+func (r *Renderer) initMotion() (err error) {
+	if err = r.sampleableDepth(); err != nil {
+		return
+	}
+	if err = r.velocityBuffer(); err != nil {
+		return
+	}
+	m := new(motion)
+	defer func() {
+		if err != nil {
+			m.free()
+		}
+	}()
+	tiles := driver.Dim3D{
+		Width:  (r.hdr.Width() + motionTile - 1) / motionTile,
+		Height: (r.hdr.Height() + motionTile - 1) / motionTile,
+	}
+	for _, t := range [...]**Texture{&m.tileMax, &m.neighbor} {
+		*t, err = NewTarget(&TexParam{
+			PixelFmt: velocityFmt,
+			Dim3D:    tiles,
+			Layers:   1,
+			Levels:   1,
+			Samples:  1,
+		})
+		if err != nil {
+			return
+		}
+	}
+	m.blur, err = NewTarget(&TexParam{
+		PixelFmt: r.hdr.PixelFmt(),
+		Dim3D:    driver.Dim3D{Width: r.hdr.Width(), Height: r.hdr.Height()},
+		Layers:   1,
+		Levels:   1,
+		Samples:  1,
+	})
+	if err != nil {
+		return
+	}
+	r.motion = m
+	// The tile passes find the dominant velocity
+	// around each tile, which bounds how far the
+	// blur pass needs to gather from. The blurred
+	// image is then copied back to the HDR target.
+	// These go right after depth of field, if
+	// enabled, so that defocused regions are
+	// blurred as well.
+	nodes := [...]*passNode{
+		{
+			name:   motionTileMaxPass,
+			stage:  stagePost,
+			reads:  []*Texture{r.vel},
+			writes: []*Texture{m.tileMax},
+		},
+		{
+			name:   motionNeighborPass,
+			stage:  stagePost,
+			reads:  []*Texture{m.tileMax},
+			writes: []*Texture{m.neighbor},
+		},
+		{
+			name:   motionBlurPass,
+			stage:  stagePost,
+			reads:  []*Texture{r.hdr, r.vel, m.neighbor, r.ds},
+			writes: []*Texture{m.blur},
+		},
+		{
+			name:   motionResolvePass,
+			stage:  stagePost,
+			reads:  []*Texture{m.blur},
+			writes: []*Texture{r.hdr},
+		},
+	}
+	prev := dofCompositePass
+	for _, n := range nodes {
+		r.graph.addAfter(prev, n)
+		prev = n.name
+	}
+	return
+}
+
+// freeMotion removes the motion blur passes from r's
+// frame graph and frees the motion blur targets.
+// The velocity buffer is not freed.
+func (r *Renderer) freeMotion() {
+	if r.motion == nil {
+		return
+	}
+	r.graph.remove(motionTileMaxPass)
+	r.graph.remove(motionNeighborPass)
+	r.graph.remove(motionBlurPass)
+	r.graph.remove(motionResolvePass)
+	r.motion.free()
+	r.motion = nil
+}
+
+// free frees the motion blur targets.
+func (m *motion) free() {
+	for _, t := range [...]*Texture{m.tileMax, m.neighbor, m.blur} {
+		if t != nil {
+			t.Free()
+		}
+	}
+}
+
+// updateReproj updates the reprojection data of every
+// viewport in r, for the view-projection transforms
+// of the current frame.
+// It must be called once per frame, after the
+// viewports' cameras are set, if r has a velocity
+// buffer.
+func (r *Renderer) updateReproj() {
+	for _, v := range r.vports.all() {
+		vp := v.layout.VP()
+		if !v.hasPrev {
+			// No previous frame, so there
+			// is no camera motion.
+			v.reproj.SetPrevVP(&vp)
+			v.hasPrev = true
+		}
+		prev := v.reproj.PrevVP()
+		var ivp linear.M4
+		ivp.Invert(&vp)
+		ivp.Mul(&prev, &ivp)
+		v.reproj.SetReproj(&ivp)
+	}
+}
+
+// storeMotion stores the current transforms of r's
+// viewports and drawables as the ones of the previous
+// frame.
+// It must be called once per frame, after the frame's
+// commands are recorded, if r has a velocity buffer.
+// Transforms that are not changed until the next
+// frame produce no motion.
+func (r *Renderer) storeMotion() {
+	for _, v := range r.vports.all() {
+		vp := v.layout.VP()
+		v.reproj.SetPrevVP(&vp)
+		v.hasPrev = true
+	}
+	for _, d := range r.drawables.all() {
+		w := d.layout.World()
+		d.layout.SetPrevWorld(&w)
+	}
+}
@@ -0,0 +1,155 @@
+// Copyright 2024 Gustavo C. Viegas. All rights reserved.
+
+package engine
+
+import (
+	"errors"
+	"math"
+	"testing"
+
+	"gviegas/neo3/linear"
+)
+
+func TestRendererMotionBlur(t *testing.T) {
+	rend, err := NewOffscreen(256, 192)
+	if err != nil {
+		t.Fatalf("RendererMotionBlur: NewOffscreen failed:\n%v", err)
+	}
+	defer rend.Free()
+	if _, ok := rend.MotionBlur(); ok {
+		t.Fatal("Renderer.MotionBlur: motion blur should be disabled by default")
+	}
+
+	for _, p := range [...]MotionBlurParam{
+		{},
+		{Shutter: 1.5},
+		{Shutter: 0.5, MaxBlur: -1},
+		{Shutter: 0.5, MaxBlur: motionTile + 1},
+		{Shutter: 0.5, Samples: motionMaxSamples + 1},
+	} {
+		if err := rend.SetMotionBlur(&p); err == nil {
+			t.Fatal("Renderer.SetMotionBlur: unexpected nil error")
+		}
+	}
+	param := MotionBlurParam{Shutter: 0.5}
+	if err := rend.SetMotionBlur(&param); !errors.Is(err, ErrUnsupported) {
+		t.Fatalf("Renderer.SetMotionBlur: pending passes\nhave %v\nwant %v", err, ErrUnsupported)
+	}
+	if _, ok := rend.MotionBlur(); ok || rend.vel != nil {
+		t.Fatal("Renderer.SetMotionBlur: motion blur should not be enabled")
+	}
+	defer allowPending(append(motionPasses[:], dofCoCPass, dofBlurPass, dofCompositePass)...)()
+	// Enabling DoF afterwards must not change
+	// the relative order of the passes.
+	if err := rend.SetMotionBlur(&param); err != nil {
+		t.Fatalf("Renderer.SetMotionBlur failed:\n%v", err)
+	}
+	if err := rend.SetCamera(&CameraParam{Aperture: 2, Shutter: 0.01, ISO: 100, FocalLength: 35, DoF: true, FocusDistance: 3}); err != nil {
+		t.Fatalf("Renderer.SetCamera failed:\n%v", err)
+	}
+	if x, ok := rend.MotionBlur(); !ok || x != param {
+		t.Fatalf("Renderer.MotionBlur:\nhave %v, %t\nwant %v, true", x, ok, param)
+	}
+	m := rend.motion
+	if x := m.layout.Shutter(); x != 0.5 {
+		t.Fatalf("Renderer.SetMotionBlur: layout.Shutter\nhave %v\nwant 0.5", x)
+	}
+	if b, n, tile := m.layout.Blur(); b != motionTile || n != motionSamples || tile != motionTile {
+		t.Fatalf("Renderer.SetMotionBlur: layout.Blur\nhave %v, %d, %d\nwant %v, %d, %d", b, n, tile, motionTile, motionSamples, motionTile)
+	}
+	if w, h := m.tileMax.Width(), m.tileMax.Height(); w != 8 || h != 6 {
+		t.Fatalf("Renderer.SetMotionBlur: tile size\nhave %dx%d\nwant 8x6", w, h)
+	}
+	if rend.vel == nil || rend.vel.PixelFmt() != velocityFmt {
+		t.Fatal("Renderer.SetMotionBlur: velocity buffer should have been created")
+	}
+	order := []string{
+		dofCoCPass, dofBlurPass, dofCompositePass,
+		motionTileMaxPass, motionNeighborPass, motionBlurPass, motionResolvePass,
+	}
+	for i := 1; i < len(order); i++ {
+		if a, b := rend.graph.find(order[i-1]), rend.graph.find(order[i]); a < 0 || a+1 != b {
+			t.Fatalf("Renderer.SetMotionBlur: %q should immediately follow %q", order[i], order[i-1])
+		}
+	}
+	if x := rend.graph.find(velocityPass); x < 0 || rend.graph.nodes[x].stage != stageGeometry {
+		t.Fatal("Renderer.SetMotionBlur: missing velocity pass")
+	}
+	if err := rend.graph.compile(); err != nil {
+		t.Fatalf("frameGraph.compile failed:\n%v", err)
+	}
+
+	if err := rend.SetMotionBlur(nil); err != nil {
+		t.Fatalf("Renderer.SetMotionBlur(nil) failed:\n%v", err)
+	}
+	if _, ok := rend.MotionBlur(); ok || rend.graph.find(motionBlurPass) >= 0 {
+		t.Fatal("Renderer.SetMotionBlur(nil): motion blur should be disabled")
+	}
+	if rend.vel == nil || rend.graph.find(velocityPass) < 0 {
+		t.Fatal("Renderer.SetMotionBlur(nil): velocity buffer should have been kept")
+	}
+}
+
+func TestRendererMotion(t *testing.T) {
+	rend, err := NewOffscreen(256, 192)
+	if err != nil {
+		t.Fatalf("RendererMotion: NewOffscreen failed:\n%v", err)
+	}
+	defer rend.Free()
+	if err := rend.velocityBuffer(); err != nil {
+		t.Fatalf("Renderer.velocityBuffer failed:\n%v", err)
+	}
+	var proj, view linear.M4
+	proj.Perspective(math.Pi/3, 4.0/3.0, 0.1, 100)
+	view.Translate(0, 0, -5)
+	v, err := rend.AddViewport(&ViewportParam{View: view, Proj: proj, Rect: ViewportRect{Width: 1, Height: 1}})
+	if err != nil {
+		t.Fatalf("Renderer.AddViewport failed:\n%v", err)
+	}
+	var world linear.M4
+	world.Translate(1, 2, 3)
+	var d drawable
+	d.layout.SetWorld(&world)
+	id := rend.drawables.insert(d)
+	defer rend.drawables.remove(id)
+
+	// The first frame has no camera motion.
+	rend.updateReproj()
+	vpt := rend.vports.get(v)
+	vp := vpt.layout.VP()
+	if x := vpt.reproj.PrevVP(); x != vp {
+		t.Fatalf("Renderer.updateReproj: reproj.PrevVP\nhave %v\nwant %v", x, vp)
+	}
+	rp := vpt.reproj.Reproj()
+	for i := range rp {
+		for j := range rp[i] {
+			if w := linear.I4()[i][j]; math.Abs(float64(rp[i][j]-w)) > 1e-5 {
+				t.Fatalf("Renderer.updateReproj: reproj.Reproj\nhave %v\nwant %v", rp, linear.I4())
+			}
+		}
+	}
+
+	rend.storeMotion()
+	if x := rend.drawables.get(id).layout.PrevWorld(); x != world {
+		t.Fatalf("Renderer.storeMotion: layout.PrevWorld\nhave %v\nwant %v", x, world)
+	}
+	var moved linear.M4
+	moved.Translate(0, 0, -6)
+	rend.SetViewportCamera(v, &moved, &proj)
+	rend.updateReproj()
+	if x := vpt.reproj.PrevVP(); x != vp {
+		t.Fatalf("Renderer.updateReproj: reproj.PrevVP\nhave %v\nwant %v", x, vp)
+	}
+	// A point on the view axis reprojects to the
+	// center of the previous frame's image.
+	cur := vpt.layout.VP()
+	p := linear.V4{0, 0, 0, 1}
+	p.Mul(&cur, &p)
+	rp = vpt.reproj.Reproj()
+	p.Mul(&rp, &p)
+	q := linear.V4{0, 0, 0, 1}
+	q.Mul(&vp, &q)
+	if math.Abs(float64(p[2]/p[3]-q[2]/q[3])) > 1e-4 || math.Abs(float64(p[0]/p[3])) > 1e-5 {
+		t.Fatalf("Renderer.updateReproj: reprojected point\nhave %v\nwant %v", p, q)
+	}
+}
@@ -0,0 +1,175 @@
+// Copyright 2024 Gustavo C. Viegas. All rights reserved.
+
+package engine
+
+import (
+	"math/bits"
+	"slices"
+
+	"gviegas/neo3/driver"
+	"gviegas/neo3/engine/internal/ctxt"
+)
+
+// msaa is the state of multi-sample anti-aliasing.
+// Passes that render to r.hdr/r.ds before the post
+// stage render to color/ds instead, which are then
+// resolved to r.hdr/r.ds.
+type msaa struct {
+	samples   int
+	color, ds *Texture
+}
+
+// supportedSamples returns the largest sample count
+// not above n that the driver supports.
+func supportedSamples(n int) int {
+	n = min(n, ctxt.Limits().MaxRenderSamples)
+	return 1 << (bits.Len(uint(max(n, 1))) - 1)
+}
+
+// SetMSAA sets the number of samples per pixel that r
+// uses to render the scene. A value of 1 disables
+// multi-sample anti-aliasing.
+// If samples is not supported by the driver, the
+// largest supported count below it is used instead
+// (see MSAA).
+// Multi-sample color and depth targets are created as
+// needed, and every pass that renders to r's targets
+// before post-processing also renders to them and
+// resolves them (see PassContext.ColorTarget).
+func (r *Renderer) SetMSAA(samples int) error {
+	if samples < 1 {
+		return newRendErr("invalid MSAA sample count")
+	}
+	samples = supportedSamples(samples)
+	if samples == r.MSAA() {
+		return nil
+	}
+	var m *msaa
+	if samples > 1 {
+		m = &msaa{samples: samples}
+		param := TexParam{
+			Dim3D:   driver.Dim3D{Width: r.hdr.Width(), Height: r.hdr.Height()},
+			Layers:  1,
+			Levels:  1,
+			Samples: samples,
+		}
+		// The multi-sample targets are only
+		// rendered to and resolved, but every
+		// pass that precedes post-processing
+		// loads and stores them, so they must
+		// be backed by memory.
+		var err error
+		param.PixelFmt = r.hdr.PixelFmt()
+		if m.color, err = newTarget(&param, driver.URenderTarget); err != nil {
+			return err
+		}
+		param.PixelFmt = r.ds.PixelFmt()
+		if m.ds, err = newTarget(&param, driver.URenderTarget); err != nil {
+			m.free()
+			return err
+		}
+	}
+	if r.oit[0] != nil {
+		if err := r.resampleOIT(samples); err != nil {
+			m.free()
+			return err
+		}
+	}
+	old := r.msaa
+	r.msaa = m
+	r.rewireMSAA(old)
+	old.free()
+	return nil
+}
+
+// MSAA returns the number of samples per pixel that r
+// uses to render the scene.
+func (r *Renderer) MSAA() int {
+	if r.msaa == nil {
+		return 1
+	}
+	return r.msaa.samples
+}
+
+// rewireMSAA replaces the multi-sample targets of old
+// with those of r.msaa in the writes of r's passes.
+// Only passes whose msaa field is set are affected.
+func (r *Renderer) rewireMSAA(old *msaa) {
+	for _, n := range r.graph.nodes {
+		if !n.msaa {
+			continue
+		}
+		if old != nil {
+			n.writes = slices.DeleteFunc(n.writes, func(t *Texture) bool {
+				return t == old.color || t == old.ds
+			})
+		}
+		n.writes = r.msaaWrites(n.writes, n.stage)
+	}
+	r.graph.trans = r.graph.trans[:0]
+}
+
+// msaaWrites appends to writes the multi-sample
+// targets of r that a pass of the given stage must
+// write to, given that it writes to the textures in
+// writes and renders with r.sceneTargets.
+func (r *Renderer) msaaWrites(writes []*Texture, stage int) []*Texture {
+	m := r.msaa
+	if m == nil || stage >= stagePost {
+		return writes
+	}
+	color := slices.Contains(writes, r.hdr)
+	ds := slices.Contains(writes, r.ds)
+	if color {
+		writes = append(writes, m.color)
+	}
+	if ds {
+		writes = append(writes, m.ds)
+	}
+	return writes
+}
+
+// sceneTargets returns the render pass attachments for
+// rendering to r's color and depth targets in passes
+// of the given stage.
+// If MSAA is enabled and stage precedes stagePost,
+// the attachments refer to the multi-sample targets,
+// and resolve to r.hdr/r.ds.
+// Contents are loaded and stored, so neither target
+// can be transient (see sampleableDepth).
+func (r *Renderer) sceneTargets(stage int) (driver.ColorTarget, driver.DSTarget) {
+	hdr := r.hdr
+	if stage == stageFinal {
+		hdr = r.finalColor()
+	}
+	color := driver.ColorTarget{
+		Color: hdr.views[0],
+		Load:  driver.LLoad,
+		Store: driver.SStore,
+	}
+	ds := driver.DSTarget{
+		DS:     r.ds.views[0],
+		LoadD:  driver.LLoad,
+		StoreD: driver.SStore,
+		LoadS:  driver.LLoad,
+		StoreS: driver.SStore,
+	}
+	if m := r.msaa; m != nil && stage < stagePost {
+		color.Color, color.Resolve = m.color.views[0], r.hdr.views[0]
+		ds.DS, ds.Resolve = m.ds.views[0], r.ds.views[0]
+	}
+	return color, ds
+}
+
+// free frees the multi-sample targets.
+// m may be nil.
+func (m *msaa) free() {
+	if m == nil {
+		return
+	}
+	for _, t := range [...]*Texture{m.color, m.ds} {
+		if t != nil {
+			t.Free()
+		}
+	}
+}
@@ -0,0 +1,163 @@
+// Copyright 2024 Gustavo C. Viegas. All rights reserved.
+
+package engine
+
+import (
+	"errors"
+	"slices"
+	"testing"
+
+	"gviegas/neo3/driver"
+	"gviegas/neo3/driver/validate"
+	"gviegas/neo3/engine/internal/ctxt"
+)
+
+func TestMSAA(t *testing.T) {
+	rend, err := NewOffscreen(64, 48)
+	if err != nil {
+		t.Fatalf("NewOffscreen failed:\n%v", err)
+	}
+	defer rend.Free()
+
+	if err := rend.SetMSAA(0); !errors.Is(err, ErrInvalidParam) {
+		t.Fatalf("Renderer.SetMSAA(0):\nhave %v\nwant %v", err, ErrInvalidParam)
+	}
+	if err := rend.SetMSAA(1); err != nil {
+		t.Fatalf("Renderer.SetMSAA failed:\n%v", err)
+	}
+	if n := rend.MSAA(); n != 1 || rend.msaa != nil {
+		t.Fatalf("Renderer.MSAA:\nhave %d\nwant 1", n)
+	}
+
+	var ctxs []PassContext
+	if err := rend.AddPass(&PassParam{
+		Name:         "outline",
+		Stage:        StageLighting,
+		WriteTargets: TargetColor | TargetDepth,
+		Record:       func(ctx *PassContext) { ctxs = append(ctxs, *ctx) },
+	}); err != nil {
+		t.Fatalf("Renderer.AddPass failed:\n%v", err)
+	}
+	if err := rend.SetTransparency(TranspOIT); err != nil {
+		t.Fatalf("Renderer.SetTransparency failed:\n%v", err)
+	}
+	defer rend.SetTransparency(TranspSortObject)
+
+	// Counts that are not supported are clamped.
+	if err := rend.SetMSAA(64); err != nil {
+		t.Fatalf("Renderer.SetMSAA failed:\n%v", err)
+	}
+	n := rend.MSAA()
+	if n < 1 || n&(n-1) != 0 || n > ctxt.Limits().MaxRenderSamples || n > 64 {
+		t.Fatalf("Renderer.MSAA: unsupported count %d", n)
+	}
+	if n == 1 {
+		t.Skip("MSAA not supported by the driver")
+	}
+	m := rend.msaa
+	if m.color.Samples() != n || m.ds.Samples() != n || m.color.PixelFmt() != rend.hdr.PixelFmt() || m.ds.PixelFmt() != rend.ds.PixelFmt() {
+		t.Fatalf("Renderer.SetMSAA: targets\n%+v\n%+v", m.color.param, m.ds.param)
+	}
+	if rend.hdr.Samples() != 1 || rend.ds.Samples() != 1 {
+		t.Fatal("Renderer.SetMSAA: hdr and ds should be single-sample")
+	}
+	for _, x := range rend.oit {
+		if x.Samples() != n {
+			t.Fatalf("Renderer.SetMSAA: OIT samples\nhave %d\nwant %d", x.Samples(), n)
+		}
+	}
+	if i := rend.graph.find(oitAccumPass); !slices.Equal(rend.graph.nodes[i].writes, rend.oit[:]) {
+		t.Fatal("Renderer.SetMSAA: OIT targets not replaced in the frame graph")
+	}
+	if i := rend.graph.find(oitCompositePass); !slices.Contains(rend.graph.nodes[i].writes, m.color) {
+		t.Fatal("Renderer.SetMSAA: OIT composite should write to the multi-sample color target")
+	}
+	if err := rend.graph.compile(); err != nil {
+		t.Fatalf("frameGraph.compile failed:\n%v", err)
+	}
+
+	n0 := rend.graph.nodes[rend.graph.find(customPrefix+"outline")]
+	n0.record(&rend.Renderer, nil)
+	if len(ctxs) != 1 {
+		t.Fatalf("PassParam.Record: call count\nhave %d\nwant 1", len(ctxs))
+	}
+	ctx := ctxs[0]
+	if want := []*Texture{rend.hdr, rend.ds, m.color, m.ds}; !slices.Equal(ctx.Writes, want) {
+		t.Fatalf("PassContext.Writes:\nhave %v\nwant %v", ctx.Writes, want)
+	}
+	if c := ctx.ColorTarget; c.Color != m.color.views[0] || c.Resolve != rend.hdr.views[0] {
+		t.Fatal("PassContext.ColorTarget: should resolve the multi-sample target")
+	}
+	if ds := ctx.DSTarget; ds.DS != m.ds.views[0] || ds.Resolve != rend.ds.views[0] {
+		t.Fatal("PassContext.DSTarget: should resolve the multi-sample target")
+	}
+	if c, _ := rend.sceneTargets(stagePost); c.Color != rend.hdr.views[0] || c.Resolve != nil {
+		t.Fatal("Renderer.sceneTargets: post-processing should not use MSAA")
+	}
+
+	// Disabling MSAA removes the targets from
+	// every pass.
+	if err := rend.SetMSAA(1); err != nil {
+		t.Fatalf("Renderer.SetMSAA failed:\n%v", err)
+	}
+	if rend.MSAA() != 1 {
+		t.Fatalf("Renderer.MSAA:\nhave %d\nwant 1", rend.MSAA())
+	}
+	for _, x := range rend.graph.nodes {
+		if slices.Contains(x.writes, m.color) || slices.Contains(x.writes, m.ds) {
+			t.Fatalf("Renderer.SetMSAA: %s still writes to multi-sample targets", x.name)
+		}
+	}
+	for _, x := range rend.oit {
+		if x.Samples() != 1 {
+			t.Fatalf("Renderer.SetMSAA: OIT samples\nhave %d\nwant 1", x.Samples())
+		}
+	}
+	if c, ds := rend.sceneTargets(stageLighting); c.Resolve != nil || ds.Resolve != nil {
+		t.Fatal("Renderer.sceneTargets: unexpected resolve targets")
+	}
+}
+
+func TestMSAAValidate(t *testing.T) {
+	// Every resource must be created by the
+	// validating GPU.
+	defer ctxt.SetGPU(ctxt.SetGPU(validate.New(ctxt.GPU())))
+	rend, err := NewOffscreen(64, 48)
+	if err != nil {
+		t.Fatalf("NewOffscreen failed:\n%v", err)
+	}
+	defer rend.Free()
+	if err := rend.SetMSAA(4); err != nil {
+		t.Fatalf("Renderer.SetMSAA failed:\n%v", err)
+	}
+	if rend.MSAA() == 1 {
+		t.Skip("MSAA not supported by the driver")
+	}
+
+	// Both passes render to the multi-sample
+	// targets, so the second one loads what the
+	// first one stores.
+	record := func(ctx *PassContext) {
+		ctx.Cmd.BeginPass(rend.hdr.Width(), rend.hdr.Height(), 1, []driver.ColorTarget{ctx.ColorTarget}, &ctx.DSTarget)
+		ctx.Cmd.EndPass()
+	}
+	for _, x := range [...]struct {
+		name  string
+		stage int
+	}{
+		{"first", StageGeometry},
+		{"second", StageLighting},
+	} {
+		if err := rend.AddPass(&PassParam{
+			Name:         x.name,
+			Stage:        x.stage,
+			WriteTargets: TargetColor | TargetDepth,
+			Record:       record,
+		}); err != nil {
+			t.Fatalf("Renderer.AddPass failed:\n%v", err)
+		}
+	}
+	if _, err := renderGolden(rend); err != nil {
+		t.Fatalf("renderGolden failed:\n%v", err)
+	}
+}
@@ -0,0 +1,155 @@
+// Copyright 2024 Gustavo C. Viegas. All rights reserved.
+
+package engine
+
+import (
+	"gviegas/neo3/linear"
+)
+
+// OutlineParam describes the selection outline.
+// Color is the (linear, premultiplied) color of the
+// outline and Width its width in pixels, which is
+// multiplied by the Renderer's UI scale.
+type OutlineParam struct {
+	Color linear.V4
+	Width float32
+}
+
+// outline is the state of the selection outline.
+type outline struct {
+	param OutlineParam
+	set   map[Drawable]bool
+}
+
+// Names of the outline passes.
+const (
+	outlineMarkPass = "outline.mark"
+	outlinePass     = "outline"
+)
+
+// SetOutline enables the selection outline in r.
+// If param is nil, the outline is disabled and every
+// drawable stops being outlined.
+// Drawables that are outlined (see SetOutlined) set
+// StencilOutline in the geometry stage. They are then
+// drawn again, expanded by the outline width, after
+// the transparency stage, coloring only the pixels
+// that do not have StencilOutline set. Thus the
+// outline surrounds the silhouette of the selection
+// and is visible through other geometry.
+// The outline requires StencilOutline to be free.
+func (r *Renderer) SetOutline(param *OutlineParam) error {
+	if param == nil {
+		r.freeOutline()
+		return nil
+	}
+	var reason string
+	switch {
+	case !(param.Width > 0):
+		reason = "non-positive outline width"
+	case param.Color[0] < 0 || param.Color[1] < 0 || param.Color[2] < 0 || param.Color[3] < 0,
+		param.Color[0] > 1 || param.Color[1] > 1 || param.Color[2] > 1 || param.Color[3] > 1:
+		reason = "outline color out of range"
+	default:
+		goto validParam
+	}
+	return newRendErr(reason)
+validParam:
+	if r.outline == nil {
+		if err := r.reserveStencil(StencilOutline); err != nil {
+			return err
+		}
+		r.outline = &outline{set: make(map[Drawable]bool)}
+		// The mark pass draws the outlined
+		// drawables with depth testing only,
+		// replacing StencilOutline. The outline
+		// pass tests against it.
+		r.graph.add(&passNode{
+			name:   outlineMarkPass,
+			stage:  stageGeometry,
+			writes: r.msaaWrites([]*Texture{r.ds}, stageGeometry),
+			msaa:   true,
+		})
+		r.graph.add(&passNode{
+			name:   outlinePass,
+			stage:  stageTransparency,
+			writes: r.msaaWrites([]*Texture{r.hdr, r.ds}, stageTransparency),
+			msaa:   true,
+		})
+	}
+	r.outline.param = *param
+	return nil
+}
+
+// Outline returns the selection outline parameters of
+// r. If the outline is disabled, it returns false.
+func (r *Renderer) Outline() (OutlineParam, bool) {
+	if r.outline == nil {
+		return OutlineParam{}, false
+	}
+	return r.outline.param, true
+}
+
+// SetOutlined sets whether d is outlined.
+// The outline must be enabled (see SetOutline).
+func (r *Renderer) SetOutlined(d Drawable, outlined bool) error {
+	var reason string
+	switch {
+	case r.outline == nil:
+		reason = "outline not enabled"
+	case int(d) < 0 || int(d) >= r.drawables.idMap.Len() || !r.drawables.idMap.IsSet(int(d)):
+		reason = "invalid drawable"
+	default:
+		goto validParam
+	}
+	return newRendErr(reason)
+validParam:
+	if outlined {
+		r.outline.set[d] = true
+	} else {
+		delete(r.outline.set, d)
+	}
+	return nil
+}
+
+// Outlined returns whether d is outlined.
+func (r *Renderer) Outlined(d Drawable) bool {
+	return r.outline != nil && r.outline.set[d]
+}
+
+// freeOutline removes the outline passes from r's
+// frame graph and releases StencilOutline.
+func (r *Renderer) freeOutline() {
+	if r.outline == nil {
+		return
+	}
+	r.graph.remove(outlineMarkPass)
+	r.graph.remove(outlinePass)
+	r.stencil &^= StencilOutline
+	r.outline = nil
+}
+
+// buildOutline fills l.outline with the primitives
+// in l.opaque and l.blend whose drawables are
+// outlined. It must be called after l.build.
+// Drawables that no longer exist in r stop being
+// outlined.
+func (l *drawList) buildOutline(r *Renderer) {
+	l.outline = l.outline[:0]
+	o := r.outline
+	if o == nil || len(o.set) == 0 {
+		return
+	}
+	for d := range o.set {
+		if int(d) >= r.drawables.idMap.Len() || !r.drawables.idMap.IsSet(int(d)) {
+			delete(o.set, d)
+		}
+	}
+	for _, s := range [2][]drawItem{l.opaque, l.blend} {
+		for _, x := range s {
+			if o.set[x.id] {
+				l.outline = append(l.outline, x)
+			}
+		}
+	}
+}
@@ -0,0 +1,98 @@
+// Copyright 2024 Gustavo C. Viegas. All rights reserved.
+
+package engine
+
+import (
+	"errors"
+	"testing"
+
+	"gviegas/neo3/linear"
+)
+
+func TestOutline(t *testing.T) {
+	rend, err := NewOffscreen(64, 48)
+	if err != nil {
+		t.Fatalf("NewOffscreen failed:\n%v", err)
+	}
+	defer rend.Free()
+
+	if _, ok := rend.Outline(); ok {
+		t.Fatal("Renderer.Outline: should be disabled by default")
+	}
+	if err := rend.SetOutlined(0, true); !errors.Is(err, ErrInvalidParam) {
+		t.Fatalf("Renderer.SetOutlined: outline disabled\nhave %v\nwant %v", err, ErrInvalidParam)
+	}
+	for _, x := range [...]OutlineParam{
+		{Color: linear.V4{1, 1, 0, 1}, Width: 0},
+		{Color: linear.V4{1, 1, 0, 1}, Width: -2},
+		{Color: linear.V4{2, 1, 0, 1}, Width: 2},
+		{Color: linear.V4{1, 1, 0, -1}, Width: 2},
+	} {
+		if err := rend.SetOutline(&x); !errors.Is(err, ErrInvalidParam) {
+			t.Fatalf("Renderer.SetOutline(%+v):\nhave %v\nwant %v", x, err, ErrInvalidParam)
+		}
+	}
+
+	// StencilOutline is not a user bit, so it
+	// is always free at this point.
+	param := OutlineParam{Color: linear.V4{1, 0.5, 0, 1}, Width: 2}
+	if err := rend.SetOutline(&param); err != nil {
+		t.Fatalf("Renderer.SetOutline failed:\n%v", err)
+	}
+	if x, ok := rend.Outline(); !ok || x != param {
+		t.Fatalf("Renderer.Outline:\nhave %+v, %t\nwant %+v, true", x, ok, param)
+	}
+	if rend.stencil&StencilOutline == 0 {
+		t.Fatal("Renderer.SetOutline: StencilOutline not reserved")
+	}
+	if rend.graph.find(outlineMarkPass) < 0 || rend.graph.find(outlinePass) < 0 {
+		t.Fatal("Renderer.SetOutline: outline passes not added")
+	}
+
+	data := dummyData1(1)
+	mesh, err := NewMesh(&data)
+	if err != nil {
+		t.Fatalf("NewMesh failed:\n%v", err)
+	}
+	defer mesh.Free()
+	mat, err := NewUnlit(&Unlit{BaseColor: BaseColor{Factor: [4]float32{1, 1, 1, 1}}})
+	if err != nil {
+		t.Fatalf("NewUnlit failed:\n%v", err)
+	}
+	d := drawable{mesh: mesh, mat: []*Material{mat}}
+	id := rend.drawables.insert(d)
+	id2 := rend.drawables.insert(d)
+	defer rend.drawables.remove(id2)
+
+	if err := rend.SetOutlined(id2+1, true); !errors.Is(err, ErrInvalidParam) {
+		t.Fatalf("Renderer.SetOutlined: invalid drawable\nhave %v\nwant %v", err, ErrInvalidParam)
+	}
+	if err := rend.SetOutlined(id, true); err != nil {
+		t.Fatalf("Renderer.SetOutlined failed:\n%v", err)
+	}
+	if !rend.Outlined(id) || rend.Outlined(id2) {
+		t.Fatal("Renderer.Outlined: unexpected result")
+	}
+
+	view := linear.I4()
+	var l drawList
+	l.build(&rend.Renderer, &view, nil)
+	l.buildOutline(&rend.Renderer)
+	if len(l.outline) != 1 || l.outline[0].id != id {
+		t.Fatalf("drawList.buildOutline:\nhave %v\nwant [%d]", l.outline, id)
+	}
+	// Removed drawables stop being outlined.
+	rend.drawables.remove(id)
+	l.build(&rend.Renderer, &view, nil)
+	l.buildOutline(&rend.Renderer)
+	if len(l.outline) != 0 || rend.Outlined(id) {
+		t.Fatal("drawList.buildOutline: removed drawable still outlined")
+	}
+
+	if err := rend.SetOutline(nil); err != nil {
+		t.Fatalf("Renderer.SetOutline(nil) failed:\n%v", err)
+	}
+	if rend.stencil&StencilOutline != 0 || rend.graph.find(outlinePass) >= 0 {
+		t.Fatal("Renderer.SetOutline(nil): outline not disabled")
+	}
+}
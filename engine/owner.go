@@ -0,0 +1,64 @@
+// Copyright 2024 Gustavo C. Viegas. All rights reserved.
+
+package engine
+
+import (
+	"runtime"
+	"sync/atomic"
+)
+
+// Ownership modes.
+const (
+	// ManualOwnership requires every Texture, Sampler
+	// and Mesh to be explicitly freed. Values that are
+	// never freed leak their driver resources.
+	// This is the default.
+	ManualOwnership = iota
+	// GCOwnership causes the driver resources held by
+	// Texture, Sampler and Mesh values to be released
+	// when such values become unreachable, if Free
+	// was not called first.
+	// Since frames in flight may still use them, the
+	// resources are retired rather than released
+	// immediately (see FreeRetired).
+	GCOwnership
+)
+
+var (
+	ownership atomic.Int32
+	// devGen is incremented on device recovery, so
+	// cleanups of objects created before the device
+	// was recreated do nothing.
+	devGen atomic.Int64
+)
+
+// SetOwnership sets the ownership mode used by
+// subsequently created objects.
+// It returns the previous mode.
+// The mode of objects created before the call is
+// not changed.
+func SetOwnership(mode int) int {
+	switch mode {
+	case GCOwnership, ManualOwnership:
+	default:
+		panic("engine.SetOwnership: invalid mode")
+	}
+	return int(ownership.Swap(int32(mode)))
+}
+
+// addCleanup arranges for free(arg) to be retired (see
+// retire) when obj becomes unreachable.
+// arg must not reference obj.
+// It returns the zero runtime.Cleanup when the current
+// mode is ManualOwnership.
+func addCleanup[T, A any](obj *T, free func(A), arg A) runtime.Cleanup {
+	if ownership.Load() == ManualOwnership {
+		return runtime.Cleanup{}
+	}
+	gen := devGen.Load()
+	return runtime.AddCleanup(obj, func(arg A) {
+		if devGen.Load() == gen {
+			retire(func() { free(arg) })
+		}
+	}, arg)
+}
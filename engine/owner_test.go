@@ -0,0 +1,51 @@
+// Copyright 2024 Gustavo C. Viegas. All rights reserved.
+
+package engine
+
+import (
+	"runtime"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestOwnership(t *testing.T) {
+	if m := SetOwnership(ManualOwnership); m != ManualOwnership {
+		t.Fatalf("SetOwnership: default mode\nhave %d\nwant %d", m, ManualOwnership)
+	}
+	x := new(int)
+	if c := addCleanup(x, func(int) {}, 0); c != (runtime.Cleanup{}) {
+		t.Fatal("addCleanup: ManualOwnership should not add a cleanup")
+	}
+
+	SetOwnership(GCOwnership)
+	defer SetOwnership(ManualOwnership)
+	FreeRetired()
+	var freed atomic.Bool
+	addCleanup(new(int), func(*atomic.Bool) { freed.Store(true) }, &freed)
+	// The cleanup must retire the resource
+	// instead of freeing it.
+	for i := 0; ; i++ {
+		runtime.GC()
+		retired.Lock()
+		n := len(retired.list)
+		retired.Unlock()
+		if n > 0 {
+			break
+		}
+		if i == 100 {
+			t.Skip("cleanup did not run")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	for range NFrame - 1 {
+		retireFrame()
+		if freed.Load() {
+			t.Fatal("addCleanup: resource freed while frames are in flight")
+		}
+	}
+	retireFrame()
+	if !freed.Load() {
+		t.Fatal("addCleanup: resource not freed after frames retired")
+	}
+}
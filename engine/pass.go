@@ -0,0 +1,577 @@
+// Copyright 2024 Gustavo C. Viegas. All rights reserved.
+
+package engine
+
+import (
+	"io"
+	"slices"
+
+	"gviegas/neo3/driver"
+)
+
+// Frame graph stages.
+// Passes execute in stage order and, within a stage,
+// in the order that they were added.
+const (
+	// Opaque geometry and decals.
+	stageGeometry = iota
+	// Screen-space lighting effects that
+	// need the final depth buffer.
+	stageLighting
+	// Blended geometry.
+	stageTransparency
+	// Post-processing in HDR.
+	stagePost
+	// Tonemapping and anything else that
+	// targets the presentation image.
+	stageFinal
+)
+
+// passNode is a node of the frame graph.
+type passNode struct {
+	// Unique name of the pass.
+	name  string
+	stage int
+	// Textures that the pass samples and the
+	// ones that it renders to.
+	reads  []*Texture
+	writes []*Texture
+	// Whether the pass renders to r.hdr/r.ds
+	// through r.sceneTargets, and thus must
+	// also write to the multi-sample targets
+	// when MSAA is enabled (see msaaWrites).
+	msaa bool
+	// Whether the pass only records compute and
+	// copy commands, and thus may execute in the
+	// async compute queue (see executeAsync).
+	// It must not write to render targets.
+	async bool
+	// record records the pass's commands.
+	// cb is recording commands and has no
+	// active render pass.
+	// It is called once per viewport, and must use
+	// the bounds given by r.curBounds.
+	record func(r *Renderer, cb driver.CmdBuffer)
+}
+
+// pendingPasses are the passes whose commands are
+// not recorded yet, either because they have no
+// record function or because it does not draw.
+// Features that depend on them report ErrUnsupported
+// rather than appearing enabled while having no
+// effect (see checkPending).
+var pendingPasses = map[string]bool{
+	objectIDPass:       true,
+	ssrHiZPass:         true,
+	ssrTracePass:       true,
+	fogInjectPass:      true,
+	fogIntegratePass:   true,
+	dofCoCPass:         true,
+	dofBlurPass:        true,
+	dofCompositePass:   true,
+	velocityPass:       true,
+	motionTileMaxPass:  true,
+	motionNeighborPass: true,
+	motionBlurPass:     true,
+	motionResolvePass:  true,
+}
+
+// checkPending returns an error of kind
+// ErrUnsupported if any of the named passes is
+// pending.
+// feature describes the feature that needs them.
+func checkPending(feature string, names ...string) error {
+	for _, name := range names {
+		if pendingPasses[name] {
+			return newErr(rendPrefix, feature+" not supported yet", ErrUnsupported)
+		}
+	}
+	return nil
+}
+
+// frameGraph is the sequence of passes that a Renderer
+// executes every frame.
+type frameGraph struct {
+	nodes []*passNode
+	// Transitions recorded before each node,
+	// computed by compile.
+	trans [][]texTransition
+	// Textures that are written to in the frame,
+	// and their layouts at the end of it.
+	final map[*Texture]driver.Layout
+	// Whether each node executes asynchronously,
+	// and the index of the first node that does
+	// not and that must wait for those that do,
+	// computed by compile.
+	async []bool
+	join  int
+	// Where to log barrier statistics, and
+	// whether they were logged since the last
+	// compilation (see Renderer.SetGraphDebug).
+	debug  io.Writer
+	logged bool
+}
+
+// texTransition describes a layout transition of a
+// whole texture that precedes a pass.
+type texTransition struct {
+	tex *Texture
+	// Whether this is the first use of tex in
+	// the frame. If so, before is not known
+	// in advance.
+	first   bool
+	before  driver.Layout
+	after   driver.Layout
+	barrier driver.Barrier
+}
+
+// texUsage describes how a pass uses a texture.
+type texUsage struct {
+	layout driver.Layout
+	sync   driver.Sync
+	access driver.Access
+}
+
+// writeAccess is the union of write access scopes.
+const writeAccess = driver.AShaderWrite | driver.AColorWrite | driver.ADSWrite | driver.AResolveWrite | driver.ACopyWrite | driver.AWrite
+
+// readUsage returns the usage of t when sampled.
+func readUsage(t *Texture) texUsage {
+	u := texUsage{driver.LShaderRead, driver.SFragmentShading | driver.SComputeShading, driver.AShaderRead}
+	if !t.PixelFmt().IsColor() {
+		u.layout = driver.LDSRead
+	}
+	return u
+}
+
+// writeUsage returns the usage of t when rendered to.
+// Textures that are not render targets are written
+// to as storage images by compute passes.
+func writeUsage(t *Texture) texUsage {
+	if t.usage&driver.URenderTarget == 0 {
+		return texUsage{driver.LShaderStore, driver.SComputeShading, driver.AShaderRead | driver.AShaderWrite}
+	}
+	if t.PixelFmt().IsColor() {
+		return texUsage{driver.LColorTarget, driver.SColorOutput, driver.AColorRead | driver.AColorWrite}
+	}
+	return texUsage{driver.LDSTarget, driver.SDSOutput, driver.ADSRead | driver.ADSWrite}
+}
+
+// add adds n to g.
+// There must not be a node with the same name in g.
+func (g *frameGraph) add(n *passNode) {
+	if g.find(n.name) >= 0 {
+		panic("duplicate pass name: " + n.name)
+	}
+	i := len(g.nodes)
+	for i > 0 && g.nodes[i-1].stage > n.stage {
+		i--
+	}
+	g.nodes = slices.Insert(g.nodes, i, n)
+	g.trans = g.trans[:0]
+}
+
+// addFirst is like add, but n is inserted before the
+// other nodes of its stage rather than after them.
+func (g *frameGraph) addFirst(n *passNode) {
+	if g.find(n.name) >= 0 {
+		panic("duplicate pass name: " + n.name)
+	}
+	i := 0
+	for i < len(g.nodes) && g.nodes[i].stage < n.stage {
+		i++
+	}
+	g.nodes = slices.Insert(g.nodes, i, n)
+	g.trans = g.trans[:0]
+}
+
+// addAfter inserts n right after the node with the
+// given name, which must be in the same stage as n.
+// If no such node exists, n is inserted as by
+// addFirst.
+func (g *frameGraph) addAfter(name string, n *passNode) {
+	i := g.find(name)
+	if i < 0 {
+		g.addFirst(n)
+		return
+	}
+	if g.nodes[i].stage != n.stage {
+		panic("pass stage mismatch: " + n.name)
+	}
+	if g.find(n.name) >= 0 {
+		panic("duplicate pass name: " + n.name)
+	}
+	g.nodes = slices.Insert(g.nodes, i+1, n)
+	g.trans = g.trans[:0]
+}
+
+// remove removes the node with the given name from g.
+// It returns false if no such node exists.
+func (g *frameGraph) remove(name string) bool {
+	i := g.find(name)
+	if i < 0 {
+		return false
+	}
+	g.nodes = slices.Delete(g.nodes, i, i+1)
+	g.trans = g.trans[:0]
+	return true
+}
+
+// replace replaces every occurrence of old in the
+// reads and writes of g's nodes with new.
+// It is used when a texture is recreated.
+func (g *frameGraph) replace(old, new *Texture) {
+	for _, n := range g.nodes {
+		for _, s := range [2][]*Texture{n.reads, n.writes} {
+			for i := range s {
+				if s[i] == old {
+					s[i] = new
+				}
+			}
+		}
+	}
+	g.trans = g.trans[:0]
+}
+
+// replaceStage is like replace, but only considers
+// the nodes of the given stage.
+func (g *frameGraph) replaceStage(stage int, old, new *Texture) {
+	for _, n := range g.nodes {
+		if n.stage != stage {
+			continue
+		}
+		for i := range n.reads {
+			if n.reads[i] == old {
+				n.reads[i] = new
+			}
+		}
+		for i := range n.writes {
+			if n.writes[i] == old {
+				n.writes[i] = new
+			}
+		}
+	}
+	g.trans = g.trans[:0]
+}
+
+// find returns the index of the node with the given
+// name, or -1 if g contains no such node.
+func (g *frameGraph) find(name string) int {
+	return slices.IndexFunc(g.nodes, func(n *passNode) bool { return n.name == name })
+}
+
+// validate checks that no pass reads from a texture
+// that it writes to, and that no texture is read
+// before the first pass that writes to it, unless it
+// is never written to in the frame (i.e., its
+// contents come from elsewhere).
+// It also checks that textures support the usage
+// that passes require of them.
+func (g *frameGraph) validate() error {
+	written := make(map[*Texture]bool)
+	for _, n := range g.nodes {
+		for _, t := range n.writes {
+			if t.usage&(driver.URenderTarget|driver.UShaderWrite) == 0 {
+				return newRendErr("pass " + n.name + " writes to a texture that is neither a render target nor writable by shaders")
+			}
+			if n.async && t.usage&driver.URenderTarget != 0 {
+				return newRendErr("async pass " + n.name + " writes to a render target")
+			}
+			written[t] = false
+		}
+	}
+	for _, n := range g.nodes {
+		for _, t := range n.reads {
+			if slices.Contains(n.writes, t) {
+				return newRendErr("pass " + n.name + " reads from its own target")
+			}
+			if w, ok := written[t]; ok {
+				if !w {
+					return newRendErr("pass " + n.name + " reads a target before it is written")
+				}
+				if t.usage&driver.UShaderSample == 0 {
+					return newRendErr("pass " + n.name + " reads a target that cannot be sampled")
+				}
+			}
+		}
+		for _, t := range n.writes {
+			written[t] = true
+		}
+	}
+	return nil
+}
+
+// compile validates g and computes the layout
+// transitions that must precede each pass.
+// Targets are transitioned to a render target layout
+// before being written to, and then to a read-only
+// layout before being sampled by a subsequent pass.
+// Textures that no pass writes to are assumed to be
+// in a read-only layout already, and are not
+// transitioned.
+// It must be called whenever g changes.
+func (g *frameGraph) compile() error {
+	if err := g.validate(); err != nil {
+		return err
+	}
+	g.trans = slices.Grow(g.trans[:0], len(g.nodes))[:len(g.nodes)]
+	final := make(map[*Texture]driver.Layout)
+	last := make(map[*Texture]texUsage)
+	for _, n := range g.nodes {
+		for _, t := range n.writes {
+			final[t] = driver.LUndefined
+		}
+	}
+	// The first use of a texture must wait for
+	// its last use in the previous frame.
+	for _, n := range g.nodes {
+		for _, t := range n.reads {
+			if _, ok := final[t]; ok {
+				last[t] = readUsage(t)
+			}
+		}
+		for _, t := range n.writes {
+			last[t] = writeUsage(t)
+		}
+	}
+	seen := make(map[*Texture]bool)
+	for i, n := range g.nodes {
+		xs := g.trans[i][:0]
+		use := func(t *Texture, u texUsage) {
+			prev := last[t]
+			first := !seen[t]
+			// Consecutive reads need no barrier.
+			if !first && prev.layout == u.layout && u.access&^driver.AShaderRead == 0 {
+				return
+			}
+			xs = append(xs, texTransition{
+				tex:    t,
+				first:  first,
+				before: prev.layout,
+				after:  u.layout,
+				barrier: driver.Barrier{
+					SyncBefore:   prev.sync,
+					SyncAfter:    u.sync,
+					AccessBefore: prev.access & writeAccess,
+					AccessAfter:  u.access,
+				},
+			})
+			seen[t] = true
+			last[t] = u
+			final[t] = u.layout
+		}
+		for _, t := range n.reads {
+			if _, ok := final[t]; ok {
+				use(t, readUsage(t))
+			}
+		}
+		for _, t := range n.writes {
+			use(t, writeUsage(t))
+		}
+		g.trans[i] = xs
+	}
+	g.final = final
+	g.split()
+	g.logged = false
+	return nil
+}
+
+// split sets g.async and g.join.
+// Async passes run ahead of the other passes of the
+// frame, so an async pass executes asynchronously
+// only if it does not depend on a preceding pass that
+// does not. Otherwise, it executes as a regular pass.
+// Passes that do not execute asynchronously are
+// split at the first one that depends on a pass that
+// does: passes before it overlap with async passes,
+// while it and the remaining passes wait for them.
+func (g *frameGraph) split() {
+	g.async = slices.Grow(g.async[:0], len(g.nodes))[:len(g.nodes)]
+	// Textures written to in the frame that are
+	// accessed by each kind of pass, and whether
+	// they are written to.
+	// Textures that are only read need not be
+	// tracked, since they are not transitioned.
+	sync := make(map[*Texture]bool)
+	async := make(map[*Texture]bool)
+	use := func(n *passNode, m map[*Texture]bool) {
+		for _, t := range n.reads {
+			if _, ok := g.final[t]; ok && !m[t] {
+				m[t] = false
+			}
+		}
+		for _, t := range n.writes {
+			m[t] = true
+		}
+	}
+	// An async pass must not access any texture
+	// that a preceding regular pass accesses, as
+	// even reads may depend on the transitions
+	// that the latter records.
+	for i, n := range g.nodes {
+		g.async[i] = n.async
+		if n.async {
+			for _, t := range slices.Concat(n.reads, n.writes) {
+				if _, ok := sync[t]; ok {
+					g.async[i] = false
+					break
+				}
+			}
+		}
+		if g.async[i] {
+			use(n, async)
+		} else {
+			use(n, sync)
+		}
+	}
+	g.join = len(g.nodes)
+	if len(async) == 0 {
+		return
+	}
+	for i, n := range g.nodes {
+		if g.async[i] {
+			continue
+		}
+		for _, t := range n.reads {
+			if async[t] {
+				g.join = i
+				return
+			}
+		}
+		for _, t := range n.writes {
+			if _, ok := async[t]; ok {
+				g.join = i
+				return
+			}
+		}
+	}
+}
+
+// execute records the commands of every pass in g.
+// cb must be recording and have no active render pass.
+// g must have been compiled.
+// The caller must call g.finish once cb completes
+// execution, or fails to.
+func (g *frameGraph) execute(r *Renderer, cb driver.CmdBuffer) {
+	if len(g.trans) != len(g.nodes) {
+		panic("frame graph not compiled")
+	}
+	if g.debug != nil {
+		g.logBarriers(r)
+	}
+	for i := range g.nodes {
+		g.record(r, i, cb, false)
+	}
+}
+
+// executeAsync is like execute, but records the passes
+// of g into the command buffers of three work items,
+// which must be recording and have no active render
+// pass: passes that execute asynchronously into
+// wk[0], the passes that precede g.join into wk[1],
+// and the remaining passes into wk[2].
+// It sets the Compute and WaitCompute fields of the
+// work items, which must be committed in order, by
+// separate Commit calls. Async passes then overlap
+// with the passes in wk[1] if the GPU supports
+// driver.Features.AsyncCompute, and execute in
+// order otherwise.
+// The caller must call g.finish once every command
+// buffer completes execution, or fails to.
+func (g *frameGraph) executeAsync(r *Renderer, wk *[3]*driver.WorkItem) {
+	if len(g.trans) != len(g.nodes) {
+		panic("frame graph not compiled")
+	}
+	if g.debug != nil {
+		g.logBarriers(r)
+	}
+	wk[0].Compute, wk[0].WaitCompute = true, false
+	wk[1].Compute, wk[1].WaitCompute = false, false
+	wk[2].Compute, wk[2].WaitCompute = false, true
+	// Async passes are recorded first, since
+	// they execute first. They never share
+	// textures with the passes in wk[1].
+	for i := range g.nodes {
+		if g.async[i] {
+			g.record(r, i, wk[0].Work[0], true)
+		}
+	}
+	for i := range g.nodes {
+		switch {
+		case g.async[i]:
+		case i < g.join:
+			g.record(r, i, wk[1].Work[0], false)
+		default:
+			g.record(r, i, wk[2].Work[0], false)
+		}
+	}
+}
+
+// Synchronization and access scopes that barriers
+// recorded by async passes can use, since these may
+// execute in a queue that does not support graphics
+// (see driver.AsyncComputer). Dependencies on other
+// scopes are satisfied by the order in which the
+// work items are committed.
+const (
+	computeSync   = driver.SComputeShading | driver.SCopy
+	computeAccess = driver.AShaderRead | driver.AShaderWrite | driver.ACopyRead | driver.ACopyWrite
+)
+
+// record records the transitions and commands of the
+// i-th node of g into cb.
+// compute indicates whether cb is the command buffer
+// of async compute work, in which case barriers are
+// restricted to computeSync and computeAccess.
+func (g *frameGraph) record(r *Renderer, i int, cb driver.CmdBuffer, compute bool) {
+	n := g.nodes[i]
+	var xs []driver.Transition
+	for _, x := range g.trans[i] {
+		if compute {
+			x.barrier.SyncBefore &= computeSync
+			x.barrier.SyncAfter &= computeSync
+			x.barrier.AccessBefore &= computeAccess
+			x.barrier.AccessAfter &= computeAccess
+		}
+		if x.first {
+			// Whatever the current layout
+			// is, it will be set again by
+			// g.finish.
+			x.tex.transition(len(x.tex.views)-1, cb, x.after, x.barrier)
+			continue
+		}
+		xs = append(xs, driver.Transition{
+			Barrier:      x.barrier,
+			LayoutBefore: x.before,
+			LayoutAfter:  x.after,
+			Img:          x.tex.views[0].Image(),
+			Layers:       x.tex.param.Layers,
+			Levels:       x.tex.param.Levels,
+		})
+	}
+	if len(xs) > 0 {
+		cb.Transition(xs)
+	}
+	if n.record != nil {
+		span := traceBegin(traceRecord, n.name)
+		r.curStage = n.stage
+		// Post-processing may run once
+		// for all viewports.
+		r.executeViewports(n.stage >= stagePost, func() { n.record(r, cb) })
+		span.end()
+	}
+}
+
+// finish updates the layouts of textures written to
+// by g's passes.
+// failed indicates whether the command buffer passed
+// to g.execute (or any of those passed to
+// g.executeAsync) failed to execute.
+func (g *frameGraph) finish(failed bool) {
+	for t, layout := range g.final {
+		if failed {
+			layout = driver.LUndefined
+		}
+		t.setLayout(len(t.views)-1, layout)
+	}
+}
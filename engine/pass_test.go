@@ -0,0 +1,349 @@
+// Copyright 2024 Gustavo C. Viegas. All rights reserved.
+
+package engine
+
+import (
+	"errors"
+	"maps"
+	"slices"
+	"testing"
+
+	"gviegas/neo3/driver"
+	"gviegas/neo3/driver/validate"
+	"gviegas/neo3/engine/internal/ctxt"
+)
+
+// allowPending lets tests use the given passes as if
+// they were recorded. It returns a function that
+// restores pendingPasses.
+func allowPending(names ...string) func() {
+	old := maps.Clone(pendingPasses)
+	for _, name := range names {
+		delete(pendingPasses, name)
+	}
+	return func() { pendingPasses = old }
+}
+
+func TestCheckPending(t *testing.T) {
+	if err := checkPending("SSR", ssrPasses[:]...); !errors.Is(err, ErrUnsupported) {
+		t.Fatalf("checkPending:\nhave %v\nwant %v", err, ErrUnsupported)
+	}
+	restore := allowPending(ssrHiZPass)
+	if err := checkPending("SSR", ssrPasses[:]...); !errors.Is(err, ErrUnsupported) {
+		t.Fatalf("checkPending: partially pending\nhave %v\nwant %v", err, ErrUnsupported)
+	}
+	restore()
+	defer allowPending(ssrPasses[:]...)()
+	if err := checkPending("SSR", ssrPasses[:]...); err != nil {
+		t.Fatalf("checkPending: not pending\nhave %v\nwant nil", err)
+	}
+	if err := checkPending("none"); err != nil {
+		t.Fatalf("checkPending: no passes\nhave %v\nwant nil", err)
+	}
+}
+
+func TestFrameGraph(t *testing.T) {
+	var g frameGraph
+	names := func() (s []string) {
+		for _, n := range g.nodes {
+			s = append(s, n.name)
+		}
+		return
+	}
+	g.add(&passNode{name: "post", stage: stagePost})
+	g.add(&passNode{name: "geom", stage: stageGeometry})
+	g.add(&passNode{name: "final", stage: stageFinal})
+	g.add(&passNode{name: "light0", stage: stageLighting})
+	g.add(&passNode{name: "light1", stage: stageLighting})
+	g.add(&passNode{name: "decal", stage: stageGeometry})
+	want := []string{"geom", "decal", "light0", "light1", "post", "final"}
+	if s := names(); !slices.Equal(s, want) {
+		t.Fatalf("frameGraph.add:\nhave %v\nwant %v", s, want)
+	}
+
+	if i := g.find("light1"); i != 3 {
+		t.Fatalf("frameGraph.find:\nhave %d\nwant 3", i)
+	}
+	if i := g.find("none"); i != -1 {
+		t.Fatalf("frameGraph.find:\nhave %d\nwant -1", i)
+	}
+	if !g.remove("light0") || g.remove("light0") {
+		t.Fatal("frameGraph.remove: unexpected result")
+	}
+	want = slices.Delete(want, 2, 3)
+	if s := names(); !slices.Equal(s, want) {
+		t.Fatalf("frameGraph.remove:\nhave %v\nwant %v", s, want)
+	}
+
+	func() {
+		defer func() {
+			if recover() == nil {
+				t.Fatal("frameGraph.add: should panic on duplicate name")
+			}
+		}()
+		g.add(&passNode{name: "geom", stage: stagePost})
+	}()
+
+	a := Texture{usage: driver.URenderTarget | driver.UShaderSample}
+	b, c := a, a
+	g = frameGraph{}
+	g.add(&passNode{name: "a", stage: stageGeometry, writes: []*Texture{&a}})
+	g.add(&passNode{name: "b", stage: stageLighting, reads: []*Texture{&a, &c}, writes: []*Texture{&b}})
+	if err := g.validate(); err != nil {
+		t.Fatalf("frameGraph.validate:\n%v", err)
+	}
+	g.add(&passNode{name: "c", stage: stageGeometry, reads: []*Texture{&b}})
+	if err := g.validate(); err == nil {
+		t.Fatal("frameGraph.validate: read before write should fail")
+	}
+	g.remove("c")
+	g.add(&passNode{name: "d", stage: stagePost, reads: []*Texture{&b}, writes: []*Texture{&b}})
+	if err := g.validate(); err == nil {
+		t.Fatal("frameGraph.validate: read from own target should fail")
+	}
+	g.remove("d")
+
+	b.usage = driver.URenderTarget
+	g.add(&passNode{name: "e", stage: stagePost, reads: []*Texture{&b}})
+	if err := g.validate(); err == nil {
+		t.Fatal("frameGraph.validate: read of non-sampleable target should fail")
+	}
+	g.remove("e")
+
+	b.usage |= driver.UShaderSample
+	d := b
+	g.replace(&b, &d)
+	if n := g.nodes[g.find("b")]; n.writes[0] != &d || n.reads[0] != &a {
+		t.Fatal("frameGraph.replace: texture not replaced")
+	}
+	if n := g.nodes[g.find("a")]; n.writes[0] != &a {
+		t.Fatal("frameGraph.replace: unexpected replacement")
+	}
+
+	c.usage = driver.UShaderSample
+	g.add(&passNode{name: "f", stage: stagePost, writes: []*Texture{&c}})
+	if err := g.validate(); err == nil {
+		t.Fatal("frameGraph.validate: write to non-target should fail")
+	}
+}
+
+func TestFrameGraphCompile(t *testing.T) {
+	newTex := func(pf driver.PixelFmt) *Texture {
+		return &Texture{
+			param: TexParam{PixelFmt: pf, Layers: 1, Levels: 1, Samples: 1},
+			usage: driver.URenderTarget | driver.UShaderSample,
+		}
+	}
+	color, depth, extra, out := newTex(driver.RGBA16Float), newTex(driver.D16Unorm), newTex(driver.RGBA8Unorm), newTex(driver.RGBA8Unorm)
+	// Not written to in the frame.
+	ext := newTex(driver.RGBA8Unorm)
+	var g frameGraph
+	g.add(&passNode{name: "geom", stage: stageGeometry, reads: []*Texture{ext}, writes: []*Texture{color, depth}})
+	g.add(&passNode{name: "light", stage: stageLighting, reads: []*Texture{depth}, writes: []*Texture{extra}})
+	g.add(&passNode{name: "post", stage: stagePost, reads: []*Texture{color, depth, extra}, writes: []*Texture{out}})
+	g.add(&passNode{name: "final", stage: stageFinal, reads: []*Texture{color}})
+	if err := g.compile(); err != nil {
+		t.Fatalf("frameGraph.compile failed:\n%v", err)
+	}
+	if len(g.trans) != len(g.nodes) {
+		t.Fatalf("frameGraph.compile: len(trans)\nhave %d\nwant %d", len(g.trans), len(g.nodes))
+	}
+
+	type x struct {
+		tex    *Texture
+		first  bool
+		before driver.Layout
+		after  driver.Layout
+	}
+	want := [][]x{
+		// ext is not transitioned. The layouts of
+		// the first uses are those of the last
+		// uses in the previous frame.
+		{{color, true, driver.LShaderRead, driver.LColorTarget}, {depth, true, driver.LDSRead, driver.LDSTarget}},
+		{{depth, false, driver.LDSTarget, driver.LDSRead}, {extra, true, driver.LShaderRead, driver.LColorTarget}},
+		// depth is already readable.
+		{{color, false, driver.LColorTarget, driver.LShaderRead}, {extra, false, driver.LColorTarget, driver.LShaderRead}, {out, true, driver.LColorTarget, driver.LColorTarget}},
+		// color is already readable.
+		{},
+	}
+	for i := range want {
+		if len(g.trans[i]) != len(want[i]) {
+			t.Fatalf("frameGraph.compile: len(trans[%d])\nhave %d\nwant %d", i, len(g.trans[i]), len(want[i]))
+		}
+		for j, w := range want[i] {
+			tr := g.trans[i][j]
+			if y := (x{tr.tex, tr.first, tr.before, tr.after}); y != w {
+				t.Fatalf("frameGraph.compile: trans[%d][%d]\nhave %v\nwant %v", i, j, y, w)
+			}
+		}
+	}
+	// Writes must wait for previous reads and
+	// reads must wait for previous writes.
+	if b := g.trans[1][0].barrier; b.SyncBefore != driver.SDSOutput || b.AccessBefore != driver.ADSWrite || b.AccessAfter != driver.AShaderRead {
+		t.Fatalf("frameGraph.compile: barrier\nhave %+v", b)
+	}
+	if b := g.trans[1][1].barrier; b.SyncBefore&driver.SFragmentShading == 0 || b.AccessBefore != driver.ANone || b.AccessAfter&driver.AColorWrite == 0 {
+		t.Fatalf("frameGraph.compile: barrier\nhave %+v", b)
+	}
+	if b := g.trans[2][2].barrier; b.SyncBefore != driver.SColorOutput || b.AccessBefore != driver.AColorWrite {
+		t.Fatalf("frameGraph.compile: barrier\nhave %+v", b)
+	}
+	wantFinal := map[*Texture]driver.Layout{
+		color: driver.LShaderRead,
+		depth: driver.LDSRead,
+		extra: driver.LShaderRead,
+		out:   driver.LColorTarget,
+	}
+	if !maps.Equal(g.final, wantFinal) {
+		t.Fatalf("frameGraph.compile: final\nhave %v\nwant %v", g.final, wantFinal)
+	}
+
+	g.remove("final")
+	if len(g.trans) != 0 {
+		t.Fatal("frameGraph.remove: trans should have been reset")
+	}
+	func() {
+		defer func() {
+			if recover() == nil {
+				t.Fatal("frameGraph.execute: should panic if not compiled")
+			}
+		}()
+		g.execute(nil, nil)
+	}()
+
+	g.add(&passNode{name: "bad", stage: stageFinal, reads: []*Texture{color}, writes: []*Texture{color}})
+	if err := g.compile(); err == nil {
+		t.Fatal("frameGraph.compile: unexpected nil error")
+	}
+}
+
+func TestFrameGraphAsync(t *testing.T) {
+	newTex := func(usage driver.Usage) *Texture {
+		return &Texture{
+			param: TexParam{PixelFmt: driver.RGBA16Float, Layers: 1, Levels: 1, Samples: 1},
+			usage: usage | driver.UShaderSample,
+		}
+	}
+	color, out := newTex(driver.URenderTarget), newTex(driver.URenderTarget)
+	s0, s1, s2 := newTex(driver.UShaderWrite), newTex(driver.UShaderWrite), newTex(driver.UShaderWrite)
+	var g frameGraph
+	g.add(&passNode{name: "a0", stage: stageGeometry, writes: []*Texture{s0}, async: true})
+	g.add(&passNode{name: "geom", stage: stageGeometry, writes: []*Texture{color}})
+	g.add(&passNode{name: "a1", stage: stageGeometry, reads: []*Texture{s0}, writes: []*Texture{s1}, async: true})
+	// Depends on a regular pass.
+	g.add(&passNode{name: "a2", stage: stageGeometry, reads: []*Texture{color}, writes: []*Texture{s2}, async: true})
+	g.add(&passNode{name: "light", stage: stageLighting, reads: []*Texture{s1, s2}, writes: []*Texture{out}})
+	if err := g.compile(); err != nil {
+		t.Fatalf("frameGraph.compile failed:\n%v", err)
+	}
+	if want := []bool{true, false, true, false, false}; !slices.Equal(g.async, want) {
+		t.Fatalf("frameGraph.compile: async\nhave %v\nwant %v", g.async, want)
+	}
+	if g.join != 4 {
+		t.Fatalf("frameGraph.compile: join\nhave %d\nwant 4", g.join)
+	}
+
+	// Regular passes that access textures that
+	// async passes write to must wait for them.
+	g.add(&passNode{name: "sample", stage: stageGeometry, reads: []*Texture{s0}})
+	if err := g.compile(); err != nil {
+		t.Fatalf("frameGraph.compile failed:\n%v", err)
+	}
+	if i := g.find("sample"); g.join != i || g.async[i] {
+		t.Fatalf("frameGraph.compile: join\nhave %d\nwant %d", g.join, i)
+	}
+	g.remove("sample")
+
+	for i := range g.nodes {
+		g.nodes[i].async = false
+	}
+	if err := g.compile(); err != nil {
+		t.Fatalf("frameGraph.compile failed:\n%v", err)
+	}
+	if slices.Contains(g.async, true) || g.join != len(g.nodes) {
+		t.Fatalf("frameGraph.compile: no async passes\nhave %v, %d\nwant all false, %d", g.async, g.join, len(g.nodes))
+	}
+
+	g.add(&passNode{name: "bad", stage: stagePost, writes: []*Texture{newTex(driver.URenderTarget)}, async: true})
+	if err := g.compile(); err == nil {
+		t.Fatal("frameGraph.compile: async pass writing to a render target should fail")
+	}
+}
+
+// commitLog is a driver.GPU that records the work
+// items committed to it.
+type commitLog struct {
+	driver.GPU
+	wk []driver.WorkItem
+}
+
+func (g *commitLog) NewComputeCmdBuffer() (driver.CmdBuffer, error) {
+	return g.GPU.(driver.AsyncComputer).NewComputeCmdBuffer()
+}
+
+func (g *commitLog) Commit(wk *driver.WorkItem, ch chan<- *driver.WorkItem) error {
+	g.wk = append(g.wk, driver.WorkItem{
+		Work:        slices.Clone(wk.Work),
+		Compute:     wk.Compute,
+		WaitCompute: wk.WaitCompute,
+	})
+	return g.GPU.Commit(wk, ch)
+}
+
+func TestRenderAsync(t *testing.T) {
+	log := &commitLog{GPU: validate.New(ctxt.GPU())}
+	defer ctxt.SetGPU(ctxt.SetGPU(log))
+	rend, err := NewOffscreen(64, 48)
+	if err != nil {
+		t.Fatalf("NewOffscreen failed:\n%v", err)
+	}
+	defer rend.Free()
+	st, err := newStorage(&TexParam{
+		PixelFmt: driver.RGBA16Float,
+		Dim3D:    driver.Dim3D{Width: 64, Height: 48},
+		Layers:   1,
+		Levels:   1,
+		Samples:  1,
+	})
+	if err != nil {
+		t.Fatalf("newStorage failed:\n%v", err)
+	}
+	defer st.Free()
+	rend.graph.add(&passNode{name: "async", stage: stageGeometry, writes: []*Texture{st}, async: true})
+	rend.graph.add(&passNode{name: "sample", stage: stageLighting, reads: []*Texture{st}})
+
+	// The async pass is committed first, and the
+	// pass that samples its output waits for it.
+	for range 2 {
+		log.wk = log.wk[:0]
+		if err := rend.Render(); err != nil {
+			t.Fatalf("Offscreen.Render failed:\n%v", err)
+		}
+		if len(log.wk) != 3 {
+			t.Fatalf("Offscreen.Render: commits\nhave %d\nwant 3", len(log.wk))
+		}
+		for i, x := range [3][2]bool{{true, false}, {false, false}, {false, true}} {
+			if have := [2]bool{log.wk[i].Compute, log.wk[i].WaitCompute}; have != x {
+				t.Fatalf("Offscreen.Render: work item %d Compute/WaitCompute\nhave %v\nwant %v", i, have, x)
+			}
+		}
+		if log.wk[0].Work[0] != rend.acb[0] {
+			t.Fatal("Offscreen.Render: async pass not recorded in compute command buffer")
+		}
+		if l := driver.Layout(st.layouts[0].Load()); l != driver.LShaderRead {
+			t.Fatalf("Offscreen.Render: storage layout\nhave %v\nwant %v", l, driver.LShaderRead)
+		}
+	}
+
+	// Without async passes, the frame is
+	// committed at once.
+	rend.graph.remove("async")
+	rend.graph.remove("sample")
+	log.wk = log.wk[:0]
+	if err := rend.Render(); err != nil {
+		t.Fatalf("Offscreen.Render failed:\n%v", err)
+	}
+	if len(log.wk) != 1 || log.wk[0].Compute || log.wk[0].WaitCompute {
+		t.Fatalf("Offscreen.Render: commits\nhave %d\nwant 1 non-compute", len(log.wk))
+	}
+}
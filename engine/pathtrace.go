@@ -0,0 +1,802 @@
+// Copyright 2024 Gustavo C. Viegas. All rights reserved.
+
+package engine
+
+import (
+	"cmp"
+	"encoding/binary"
+	"image"
+	"math"
+	"math/rand/v2"
+	"runtime"
+	"slices"
+	"sync"
+	"sync/atomic"
+
+	"gviegas/neo3/driver"
+	"gviegas/neo3/engine/internal/shader"
+	"gviegas/neo3/linear"
+)
+
+// ReferenceParam configures RenderReference.
+// Width and Height are the size of the image.
+// Samples is the number of paths traced per pixel,
+// and Bounces is the maximum number of surfaces that
+// each path hits after the first one.
+// Sky is the radiance of rays that escape the scene.
+// Rendering is deterministic for a given Seed.
+type ReferenceParam struct {
+	Width   int
+	Height  int
+	Samples int
+	Bounces int
+	Sky     [3]float32
+	Seed    uint64
+}
+
+// RenderReference renders what v sees using a CPU path
+// tracer, producing a reference for validating the
+// real-time renderer.
+// It reads back the meshes, materials and lights of r.
+// Materials are evaluated with the metallic-roughness
+// model (Lambertian diffuse plus GGX specular) using
+// their factors and, if readable, their RGBA8 base
+// color textures. Skins, decals, fog and
+// post-processing other than exposure are ignored.
+// It returns tightly packed RGB radiance values, in
+// row-major order with the first row at the top,
+// scaled by r's exposure. RadianceToImage converts
+// them into an image.
+// It is very slow.
+func (r *Renderer) RenderReference(v Viewport, param *ReferenceParam) ([]float32, error) {
+	var reason string
+	switch {
+	case param == nil:
+		reason = "nil reference param"
+	case param.Width < 1 || param.Height < 1:
+		reason = "invalid reference size"
+	case param.Samples < 1:
+		reason = "invalid reference sample count"
+	case param.Bounces < 0:
+		reason = "negative reference bounce count"
+	default:
+		goto validParam
+	}
+	return nil, newRendErr(reason)
+validParam:
+	var tr pathTracer
+	texs := make(map[TexRef]*traceTex)
+	for _, d := range r.drawables.all() {
+		tr.addDrawable(d, texs)
+	}
+	for _, l := range r.Lights() {
+		tr.lights = append(tr.lights, *l)
+	}
+	tr.sky = param.Sky
+	tr.init()
+
+	w, h := param.Width, param.Height
+	rect := r.vports.get(v).param.Rect
+	scale := r.exposure()
+	data := make([]float32, w*h*3)
+	parallelRows(h, func(y int) {
+		rng := rand.New(rand.NewPCG(param.Seed, uint64(y)))
+		for x := range w {
+			var sum linear.V3
+			for range param.Samples {
+				nx := rect.X + (float32(x)+rng.Float32())/float32(w)*rect.Width
+				ny := rect.Y + (float32(y)+rng.Float32())/float32(h)*rect.Height
+				ray := r.ViewportRay(v, nx, ny)
+				l := tr.radiance(&ray.Origin, &ray.Dir, rng, param.Bounces+1)
+				sum.Add(&sum, &l)
+			}
+			sum.Scale(scale/float32(param.Samples), &sum)
+			copy(data[(y*w+x)*3:], sum[:])
+		}
+	})
+	return data, nil
+}
+
+// RadianceToImage converts tightly packed RGB values,
+// such as the ones produced by RenderReference, into
+// an sRGB-encoded image.
+// Values are clamped to [0.0, 1.0] before encoding.
+func RadianceToImage(data []float32, width, height int) *image.NRGBA {
+	img := image.NewNRGBA(image.Rect(0, 0, width, height))
+	for i := range min(width*height, len(data)/3) {
+		for j := range 3 {
+			img.Pix[i*4+j] = uint8(math.Round(float64(linearToSRGB(data[i*3+j])) * 255))
+		}
+		img.Pix[i*4+3] = 255
+	}
+	return img
+}
+
+// linearToSRGB applies the sRGB transfer function to
+// x, clamped to [0.0, 1.0].
+func linearToSRGB(x float32) float32 {
+	x = min(1, max(0, x))
+	if x <= 0.0031308 {
+		return x * 12.92
+	}
+	return 1.055*float32(math.Pow(float64(x), 1/2.4)) - 0.055
+}
+
+// srgbToLinear is the inverse of linearToSRGB.
+func srgbToLinear(x float32) float32 {
+	if x <= 0.04045 {
+		return x / 12.92
+	}
+	return float32(math.Pow(float64((x+0.055)/1.055), 2.4))
+}
+
+// parallelRows calls fn for every row in [0, n),
+// spreading the rows across GOMAXPROCS goroutines.
+// Callers that need determinism must derive any
+// random state from the row alone.
+func parallelRows(n int, fn func(row int)) {
+	var row atomic.Int64
+	var wg sync.WaitGroup
+	for range runtime.GOMAXPROCS(0) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				y := int(row.Add(1) - 1)
+				if y >= n {
+					return
+				}
+				fn(y)
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+// traceMat is a material as evaluated by pathTracer.
+// If diffuse is set, the material is Lambertian and
+// only base is used.
+type traceMat struct {
+	base    linear.V3
+	metal   float32
+	rough   float32
+	emis    linear.V3
+	tex     *traceTex
+	diffuse bool
+}
+
+// traceTex is a base color texture read back for
+// tracing, converted into linear RGB.
+type traceTex struct {
+	width  int
+	height int
+	rgb    []float32
+	uvSet  int
+}
+
+// at returns the texel of t nearest to uv, which
+// wraps around.
+func (t *traceTex) at(uv [2]float32) (c linear.V3) {
+	u := uv[0] - float32(math.Floor(float64(uv[0])))
+	v := uv[1] - float32(math.Floor(float64(uv[1])))
+	x := min(t.width-1, int(u*float32(t.width)))
+	y := min(t.height-1, int(v*float32(t.height)))
+	copy(c[:], t.rgb[(y*t.width+x)*3:])
+	return
+}
+
+// readTraceTex reads back the base color texture of
+// ref. It returns nil if the texture cannot be read
+// or has a format other than RGBA8.
+func readTraceTex(ref *TexRef) *traceTex {
+	srgb := false
+	switch ref.Texture.PixelFmt() {
+	case driver.RGBA8SRGB:
+		srgb = true
+	case driver.RGBA8Unorm:
+	default:
+		return nil
+	}
+	w, h := ref.Texture.Width(), ref.Texture.Height()
+	b := make([]byte, w*h*4)
+	if n, err := ref.Texture.CopyFromView(ref.View, b); err != nil || n < len(b) {
+		return nil
+	}
+	t := &traceTex{width: w, height: h, rgb: make([]float32, w*h*3), uvSet: ref.UVSet}
+	for i := range w * h {
+		for j := range 3 {
+			x := float32(b[i*4+j]) / 255
+			if srgb {
+				x = srgbToLinear(x)
+			}
+			t.rgb[i*3+j] = x
+		}
+	}
+	return t
+}
+
+// traceTri is a world space triangle.
+// uv holds the material's texture coordinates and
+// lmuv the lightmap ones (LightmapUV). bake is used
+// by BakeLightmap.
+type traceTri struct {
+	pos  [3]linear.V3
+	norm [3]linear.V3
+	uv   [3][2]float32
+	lmuv [3][2]float32
+	mat  *traceMat
+	bake bool
+}
+
+// setNormals transforms the object space normals of
+// t by nm, or uses t's geometric normal if norm is
+// nil. idx are the vertex indices into norm.
+// It returns false if t is degenerate.
+func (t *traceTri) setNormals(norm []float32, idx []int, nm *linear.M4) bool {
+	var e1, e2, g linear.V3
+	e1.Sub(&t.pos[1], &t.pos[0])
+	e2.Sub(&t.pos[2], &t.pos[0])
+	g.Cross(&e1, &e2)
+	l := g.Len()
+	if l == 0 {
+		return false
+	}
+	g.Scale(1/l, &g)
+	for k, x := range idx {
+		if norm == nil {
+			t.norm[k] = g
+			continue
+		}
+		n := linear.V4{norm[x*3], norm[x*3+1], norm[x*3+2], 0}
+		n.Mul(nm, &n)
+		t.norm[k] = linear.V3{n[0], n[1], n[2]}
+		if l := t.norm[k].Len(); l > 0 {
+			t.norm[k].Scale(1/l, &t.norm[k])
+		} else {
+			t.norm[k] = g
+		}
+	}
+	return true
+}
+
+// interp interpolates the position and normal of t at
+// barycentric coordinates (u, v).
+func (t *traceTri) interp(u, v float32) (p, n linear.V3) {
+	w := 1 - u - v
+	for i := range 3 {
+		p[i] = w*t.pos[0][i] + u*t.pos[1][i] + v*t.pos[2][i]
+		n[i] = w*t.norm[0][i] + u*t.norm[1][i] + v*t.norm[2][i]
+	}
+	if l := n.Len(); l > 0 {
+		n.Scale(1/l, &n)
+	}
+	return
+}
+
+// baseColor returns the base color of t's material at
+// barycentric coordinates (u, v).
+func (t *traceTri) baseColor(u, v float32) linear.V3 {
+	c := t.mat.base
+	if t.mat.tex == nil {
+		return c
+	}
+	w := 1 - u - v
+	var uv [2]float32
+	for i := range uv {
+		uv[i] = w*t.uv[0][i] + u*t.uv[1][i] + v*t.uv[2][i]
+	}
+	x := t.mat.tex.at(uv)
+	for i := range c {
+		c[i] *= x[i]
+	}
+	return c
+}
+
+// intersect intersects the ray from o in direction d
+// with t, returning the distance along the ray and the
+// barycentric coordinates of the hit.
+// Both sides of t are hit.
+func (t *traceTri) intersect(o, d *linear.V3) (dist, u, v float32, ok bool) {
+	var e1, e2, p, s, q linear.V3
+	e1.Sub(&t.pos[1], &t.pos[0])
+	e2.Sub(&t.pos[2], &t.pos[0])
+	p.Cross(d, &e2)
+	det := e1.Dot(&p)
+	if det > -1e-12 && det < 1e-12 {
+		return
+	}
+	idet := 1 / det
+	s.Sub(o, &t.pos[0])
+	if u = s.Dot(&p) * idet; u < 0 || u > 1 {
+		return
+	}
+	q.Cross(&s, &e1)
+	if v = d.Dot(&q) * idet; v < 0 || u+v > 1 {
+		return
+	}
+	dist = e2.Dot(&q) * idet
+	ok = dist > 0
+	return
+}
+
+// bvhLeafTris is the maximum number of triangles in
+// a BVH leaf.
+const bvhLeafTris = 4
+
+// bvhNode is a node of a bounding volume hierarchy.
+// Leaves reference n > 0 triangles starting at first.
+// Interior nodes have n == 0, with their left child
+// immediately after them and their right child at
+// first.
+type bvhNode struct {
+	min, max linear.V3
+	first    int
+	n        int
+}
+
+// traceBVH is a bounding volume hierarchy of
+// triangles, used for ray casting.
+type traceBVH struct {
+	nodes []bvhNode
+	tris  []traceTri
+}
+
+// build builds the hierarchy, reordering b.tris.
+func (b *traceBVH) build() {
+	b.nodes = b.nodes[:0]
+	if len(b.tris) > 0 {
+		b.buildNode(0, len(b.tris))
+	}
+}
+
+func (b *traceBVH) buildNode(first, n int) int {
+	inf := float32(math.Inf(1))
+	nd := bvhNode{min: linear.V3{inf, inf, inf}, max: linear.V3{-inf, -inf, -inf}}
+	cmin, cmax := nd.min, nd.max
+	tris := b.tris[first : first+n]
+	for i := range tris {
+		for j := range 3 {
+			var c float32
+			for _, p := range tris[i].pos {
+				nd.min[j] = min(nd.min[j], p[j])
+				nd.max[j] = max(nd.max[j], p[j])
+				c += p[j]
+			}
+			cmin[j] = min(cmin[j], c)
+			cmax[j] = max(cmax[j], c)
+		}
+	}
+	i := len(b.nodes)
+	b.nodes = append(b.nodes, nd)
+	if n <= bvhLeafTris {
+		b.nodes[i].first, b.nodes[i].n = first, n
+		return i
+	}
+	axis := 0
+	for j := 1; j < 3; j++ {
+		if cmax[j]-cmin[j] > cmax[axis]-cmin[axis] {
+			axis = j
+		}
+	}
+	slices.SortFunc(tris, func(x, y traceTri) int {
+		return cmp.Compare(x.pos[0][axis]+x.pos[1][axis]+x.pos[2][axis], y.pos[0][axis]+y.pos[1][axis]+y.pos[2][axis])
+	})
+	b.buildNode(first, n/2)
+	b.nodes[i].first = b.buildNode(first+n/2, n-n/2)
+	return i
+}
+
+// intersect casts a ray from o in direction d and
+// returns the closest triangle hit within tmax, or -1
+// if there is none.
+// If any is set, it returns the first hit found.
+// u and v are the barycentric coordinates of the hit.
+func (b *traceBVH) intersect(o, d *linear.V3, tmax float32, any bool) (hit int, t, u, v float32) {
+	hit = -1
+	if len(b.nodes) == 0 {
+		return
+	}
+	var inv linear.V3
+	for i := range inv {
+		inv[i] = 1 / d[i]
+	}
+	// Median splits keep the depth logarithmic.
+	var stk [64]int
+	sp := 1
+	for sp > 0 {
+		sp--
+		i := stk[sp]
+		nd := &b.nodes[i]
+		if !nd.hit(o, &inv, tmax) {
+			continue
+		}
+		if nd.n == 0 {
+			stk[sp], stk[sp+1] = nd.first, i+1
+			sp += 2
+			continue
+		}
+		for j := nd.first; j < nd.first+nd.n; j++ {
+			if x, y, z, ok := b.tris[j].intersect(o, d); ok && x < tmax {
+				hit, t, u, v, tmax = j, x, y, z, x
+				if any {
+					return
+				}
+			}
+		}
+	}
+	return
+}
+
+// hit reports whether the ray from o with inverse
+// direction inv hits nd's bounds within tmax.
+// It uses the same slab test as BVH.Cast.
+func (nd *bvhNode) hit(o, inv *linear.V3, tmax float32) bool {
+	_, ok := rayBox(o, inv, &nd.min, &nd.max, tmax)
+	return ok
+}
+
+// pathTracer is a CPU path tracer.
+// It is used by RenderReference and BakeLightmap.
+type pathTracer struct {
+	bvh    traceBVH
+	lights []Light
+	sky    linear.V3
+	// Offset applied to ray origins to
+	// prevent self-intersection.
+	eps float32
+}
+
+// init builds t's BVH. It must be called after every
+// triangle has been added.
+func (t *pathTracer) init() {
+	t.bvh.build()
+	t.eps = 1e-6
+	if len(t.bvh.nodes) > 0 {
+		var ext linear.V3
+		ext.Sub(&t.bvh.nodes[0].max, &t.bvh.nodes[0].min)
+		t.eps = max(ext.Len()*1e-4, 1e-6)
+	}
+}
+
+// addDrawable adds the triangles of d to t.
+// texs caches the base color textures read back.
+func (t *pathTracer) addDrawable(d *drawable, texs map[TexRef]*traceTex) {
+	if d.mesh == nil {
+		return
+	}
+	world := d.layout.World()
+	var nm linear.M4
+	nm.Invert(&world)
+	nm.Transpose(&nm)
+	for i := range d.mesh.Len() {
+		var mat *traceMat
+		if i < len(d.mat) && d.mat[i] != nil {
+			mat = newTraceMat(d.mat[i], texs)
+		} else {
+			mat = &traceMat{base: linear.V3{1, 1, 1}, rough: 1}
+		}
+		t.addPrim(d.mesh, i, &world, &nm, mat)
+	}
+}
+
+// newTraceMat creates a traceMat from m.
+func newTraceMat(m *Material, texs map[TexRef]*traceTex) *traceMat {
+	c := m.layout.ColorFactor()
+	metal, rough := m.layout.MetalRough()
+	mat := &traceMat{
+		base:  linear.V3{c[0], c[1], c[2]},
+		metal: metal,
+		rough: rough,
+		emis:  m.layout.EmisFactor(),
+	}
+	if m.baseColor.Texture != nil {
+		tex, ok := texs[m.baseColor]
+		if !ok {
+			tex = readTraceTex(&m.baseColor)
+			texs[m.baseColor] = tex
+		}
+		mat.tex = tex
+	}
+	if m.layout.Flags()&shader.MatUnlit != 0 {
+		// Unlit surfaces emit their color and
+		// reflect nothing.
+		mat.emis = mat.base
+		mat.base = linear.V3{}
+		mat.diffuse = true
+	}
+	return mat
+}
+
+// addPrim adds the triangles of m's primitive at
+// index prim to t, reading them back from the mesh
+// buffer.
+func (t *pathTracer) addPrim(m *Mesh, prim int, world, nm *linear.M4, mat *traceMat) {
+	meshes.RLock()
+	defer meshes.RUnlock()
+	idx := m.primIdx
+	for i := 0; i < prim; i++ {
+		idx, _ = meshes.next(idx)
+	}
+	p := &meshes.prims[idx]
+	if p.topology != driver.TTriangle {
+		return
+	}
+	buf := meshes.buf.Bytes()
+	pos := p.readAttr(buf, Position)
+	norm := p.readAttr(buf, Normal)
+	var uv []float32
+	if mat.tex != nil {
+		uv = p.readAttr(buf, Semantic(1<<(TexCoord0.I()+mat.tex.uvSet)))
+	}
+	nv := len(pos) / 3
+	indices := make([]int, p.count)
+	for i := range indices {
+		switch {
+		case p.index.start >= p.index.end:
+			indices[i] = i
+		case p.index.format == driver.Index16:
+			indices[i] = int(binary.NativeEndian.Uint16(buf[p.index.byteStart()+i*2:]))
+		default:
+			indices[i] = int(binary.NativeEndian.Uint32(buf[p.index.byteStart()+i*4:]))
+		}
+	}
+	for j := 0; j+2 < len(indices); j += 3 {
+		tri := traceTri{mat: mat}
+		vs := indices[j : j+3]
+		if slices.ContainsFunc(vs, func(x int) bool { return x >= nv }) {
+			continue
+		}
+		for k, x := range vs {
+			v := linear.V4{pos[x*3], pos[x*3+1], pos[x*3+2], 1}
+			v.Mul(world, &v)
+			tri.pos[k] = linear.V3{v[0], v[1], v[2]}
+			if x*2+1 < len(uv) {
+				tri.uv[k] = [2]float32{uv[x*2], uv[x*2+1]}
+			}
+		}
+		if len(norm) < nv*3 {
+			norm = nil
+		}
+		if tri.setNormals(norm, vs, nm) {
+			t.bvh.tris = append(t.bvh.tris, tri)
+		}
+	}
+}
+
+// radiance traces a ray from o in direction d and
+// estimates the radiance arriving at o from that
+// direction, following at most depth surface hits.
+// It returns t.sky if the ray escapes.
+func (t *pathTracer) radiance(o, d *linear.V3, rng *rand.Rand, depth int) linear.V3 {
+	hit, dist, u, v := t.bvh.intersect(o, d, float32(math.Inf(1)), false)
+	if hit < 0 {
+		return t.sky
+	}
+	tri := &t.bvh.tris[hit]
+	var p linear.V3
+	p.Scale(dist, d)
+	p.Add(&p, o)
+	_, n := tri.interp(u, v)
+	if n.Dot(d) > 0 {
+		n.Scale(-1, &n)
+	}
+	var wo linear.V3
+	wo.Scale(-1, d)
+	s := surface{mat: tri.mat, base: tri.baseColor(u, v), n: n, wo: wo}
+
+	l := tri.mat.emis
+	e := t.direct(&p, &n, &s)
+	l.Add(&l, &e)
+	if depth > 1 {
+		if wi, w, ok := s.sample(rng); ok {
+			o := t.offset(&p, &n)
+			li := t.radiance(&o, &wi, rng, depth-1)
+			for i := range l {
+				l[i] += w[i] * li[i]
+			}
+		}
+	}
+	return l
+}
+
+// surface is a shading point.
+// wo is the direction towards the viewer, and n is
+// the normal, facing wo.
+type surface struct {
+	mat  *traceMat
+	base linear.V3
+	n    linear.V3
+	wo   linear.V3
+}
+
+// specProb is the probability of sampling the
+// specular lobe of non-diffuse materials.
+const specProb = 0.5
+
+// brdf evaluates s's BRDF for incident direction wi.
+func (s *surface) brdf(wi *linear.V3) (f linear.V3) {
+	nl := s.n.Dot(wi)
+	nv := s.n.Dot(&s.wo)
+	if nl <= 0 || nv <= 0 {
+		return
+	}
+	if s.mat.diffuse {
+		f.Scale(1/math.Pi, &s.base)
+		return
+	}
+	var h linear.V3
+	h.Add(wi, &s.wo)
+	h.Norm(&h)
+	nh := max(0, s.n.Dot(&h))
+	vh := max(0, s.wo.Dot(&h))
+	a := max(s.mat.rough*s.mat.rough, 1e-3)
+	a2 := a * a
+	dd := nh*nh*(a2-1) + 1
+	dggx := a2 / (math.Pi * dd * dd)
+	vis := 0.5 / (nl*float32(math.Sqrt(float64(nv*nv*(1-a2)+a2))) + nv*float32(math.Sqrt(float64(nl*nl*(1-a2)+a2))))
+	fw := float32(math.Pow(float64(1-vh), 5))
+	for i := range f {
+		f0 := 0.04 + (s.base[i]-0.04)*s.mat.metal
+		fr := f0 + (1-f0)*fw
+		diff := (1 - fr) * (1 - s.mat.metal) * s.base[i] / math.Pi
+		f[i] = diff + fr*dggx*vis
+	}
+	return
+}
+
+// sample samples an incident direction for s, returning
+// it with the weight BRDF * cos / pdf.
+func (s *surface) sample(rng *rand.Rand) (wi, w linear.V3, ok bool) {
+	a := max(s.mat.rough*s.mat.rough, 1e-3)
+	if s.mat.diffuse || rng.Float32() >= specProb {
+		wi = cosineDir(&s.n, rng)
+	} else {
+		// GGX half-vector sampling.
+		u1, u2 := rng.Float32(), rng.Float32()
+		ct := float32(math.Sqrt(float64((1 - u1) / (1 + (a*a-1)*u1))))
+		st := float32(math.Sqrt(float64(max(0, 1-ct*ct))))
+		phi := 2 * math.Pi * float64(u2)
+		h := onb(&s.n, st*float32(math.Cos(phi)), st*float32(math.Sin(phi)), ct)
+		h.Scale(2*s.wo.Dot(&h), &h)
+		wi.Sub(&h, &s.wo)
+	}
+	nl := s.n.Dot(&wi)
+	if nl <= 0 {
+		return
+	}
+	pdf := s.pdf(&wi)
+	if pdf <= 0 {
+		return
+	}
+	f := s.brdf(&wi)
+	w.Scale(nl/pdf, &f)
+	return wi, w, true
+}
+
+// pdf returns the probability density with which
+// s.sample produces wi.
+func (s *surface) pdf(wi *linear.V3) float32 {
+	nl := s.n.Dot(wi)
+	if nl <= 0 {
+		return 0
+	}
+	cos := nl / math.Pi
+	if s.mat.diffuse {
+		return cos
+	}
+	var h linear.V3
+	h.Add(wi, &s.wo)
+	h.Norm(&h)
+	nh := max(0, s.n.Dot(&h))
+	vh := s.wo.Dot(&h)
+	if vh <= 0 {
+		return (1 - specProb) * cos
+	}
+	a := max(s.mat.rough*s.mat.rough, 1e-3)
+	a2 := a * a
+	dd := nh*nh*(a2-1) + 1
+	dggx := a2 / (math.Pi * dd * dd)
+	return (1-specProb)*cos + specProb*dggx*nh/(4*vh)
+}
+
+// lightAt computes the irradiance at p, on a surface
+// with normal n, due to l.
+// It returns the direction towards l and its distance,
+// or false if l does not reach p (ignoring shadows).
+// Punctual lights use the falloff recommended by
+// KHR_lights_punctual.
+func lightAt(l *Light, p, n *linear.V3) (e, dir linear.V3, dist float32, ok bool) {
+	dist = float32(math.Inf(1))
+	atten := float32(1)
+	switch l.typ {
+	case distantLight:
+		d := l.Direction()
+		dir.Scale(-1, &d)
+	default:
+		pos := l.Position()
+		dir.Sub(&pos, p)
+		if dist = dir.Len(); dist < 1e-6 {
+			return
+		}
+		dir.Scale(1/dist, &dir)
+		atten = 1 / (dist * dist)
+		if r := l.Range(); r > 0 {
+			x := dist / r
+			w := max(0, 1-x*x*x*x)
+			atten *= w * w
+		}
+		if l.typ == spotLight {
+			d := l.Direction()
+			cd := -dir.Dot(&d)
+			a := min(1, max(0, cd*l.layout.AngScale()+l.layout.AngOffset()))
+			atten *= a * a
+		}
+	}
+	cos := n.Dot(&dir)
+	if cos <= 0 || atten <= 0 {
+		return
+	}
+	e = l.layout.Color()
+	e.Scale(l.Intensity()*atten*cos, &e)
+	return e, dir, dist, true
+}
+
+// direct computes the radiance that s reflects
+// towards s.wo due to t.lights, at point p with
+// normal n. Shadows are computed by ray casting.
+// If s is nil, it computes the irradiance at p
+// instead.
+func (t *pathTracer) direct(p, n *linear.V3, s *surface) (l linear.V3) {
+	o := t.offset(p, n)
+	for i := range t.lights {
+		e, dir, dist, ok := lightAt(&t.lights[i], p, n)
+		if !ok {
+			continue
+		}
+		if hit, _, _, _ := t.bvh.intersect(&o, &dir, dist, true); hit >= 0 {
+			continue
+		}
+		if s != nil {
+			f := s.brdf(&dir)
+			for i := range e {
+				e[i] *= f[i]
+			}
+		}
+		l.Add(&l, &e)
+	}
+	return
+}
+
+// offset offsets p along n by t.eps.
+func (t *pathTracer) offset(p, n *linear.V3) (o linear.V3) {
+	o.Scale(t.eps, n)
+	o.Add(&o, p)
+	return
+}
+
+// onb returns x*t + y*b + z*n, where t and b complete
+// an orthonormal basis with n.
+func onb(n *linear.V3, x, y, z float32) (d linear.V3) {
+	// Duff et al., "Building an Orthonormal Basis,
+	// Revisited".
+	sign := float32(math.Copysign(1, float64(n[2])))
+	a := -1 / (sign + n[2])
+	b := n[0] * n[1] * a
+	t := linear.V3{1 + sign*n[0]*n[0]*a, sign * b, -sign * n[0]}
+	s := linear.V3{b, sign + n[1]*n[1]*a, -n[1]}
+	for i := range d {
+		d[i] = x*t[i] + y*s[i] + z*n[i]
+	}
+	return
+}
+
+// cosineDir returns a random direction in the
+// hemisphere about n, with cosine-weighted
+// distribution.
+func cosineDir(n *linear.V3, rng *rand.Rand) linear.V3 {
+	r1, r2 := rng.Float32(), rng.Float32()
+	phi := 2 * math.Pi * float64(r1)
+	r := float32(math.Sqrt(float64(r2)))
+	z := float32(math.Sqrt(float64(max(0, 1-r2))))
+	return onb(n, r*float32(math.Cos(phi)), r*float32(math.Sin(phi)), z)
+}
@@ -0,0 +1,105 @@
+// Copyright 2024 Gustavo C. Viegas. All rights reserved.
+
+package engine
+
+import (
+	"math"
+	"math/rand/v2"
+	"testing"
+
+	"gviegas/neo3/linear"
+)
+
+func TestSurfaceSample(t *testing.T) {
+	n := linear.V3{0, 0, 1}
+	wo := linear.V3{0.6, 0, 0.8}
+	for _, mat := range [...]traceMat{
+		{base: linear.V3{1, 1, 1}, diffuse: true},
+		{base: linear.V3{1, 1, 1}, rough: 1},
+		{base: linear.V3{1, 1, 1}, metal: 1, rough: 0.5},
+		{base: linear.V3{0.5, 0.5, 0.5}, metal: 0.5, rough: 0.2},
+	} {
+		s := surface{mat: &mat, base: mat.base, n: n, wo: wo}
+		rng := rand.New(rand.NewPCG(1, 2))
+		const iters = 1 << 17
+
+		// Estimate the directional albedo with the
+		// importance sampled weights and with uniform
+		// hemisphere sampling, which must agree.
+		var imp, uni float64
+		for range iters {
+			if _, w, ok := s.sample(rng); ok {
+				imp += float64(w[0])
+			}
+			z := rng.Float32()
+			r := float32(math.Sqrt(float64(1 - z*z)))
+			phi := 2 * math.Pi * rng.Float64()
+			wi := linear.V3{r * float32(math.Cos(phi)), r * float32(math.Sin(phi)), z}
+			f := s.brdf(&wi)
+			uni += float64(f[0] * z * 2 * math.Pi)
+		}
+		imp /= iters
+		uni /= iters
+		if imp > 1.01 || math.Abs(imp-uni) > 0.03 {
+			t.Fatalf("surface.sample: %+v\nhave %v\nwant %v", mat, imp, uni)
+		}
+		if mat.diffuse && math.Abs(imp-1) > 1e-4 {
+			t.Fatalf("surface.sample: white furnace\nhave %v\nwant 1", imp)
+		}
+	}
+}
+
+func TestRadianceToImage(t *testing.T) {
+	data := []float32{0, 1, 2, 0.5, -1, 0.0031308}
+	img := RadianceToImage(data, 2, 1)
+	want := []uint8{0, 255, 255, 255, 188, 0, 10, 255}
+	for i, x := range want {
+		if img.Pix[i] != x {
+			t.Fatalf("RadianceToImage: Pix[%d]\nhave %d\nwant %d", i, img.Pix[i], x)
+		}
+	}
+	for _, x := range [...]float32{0, 0.001, 0.2, 0.5, 1} {
+		if y := srgbToLinear(linearToSRGB(x)); math.Abs(float64(x-y)) > 1e-5 {
+			t.Fatalf("srgbToLinear(linearToSRGB(%v))\nhave %v\nwant %v", x, y, x)
+		}
+	}
+}
+
+func TestRenderReference(t *testing.T) {
+	rend, err := NewOffscreen(64, 64)
+	if err != nil {
+		t.Fatalf("RenderReference: NewOffscreen failed:\n%v", err)
+	}
+	defer rend.Free()
+	view, proj := gizmoCam()
+	v, err := rend.AddViewport(&ViewportParam{View: view, Proj: proj, Rect: ViewportRect{0, 0, 1, 1}})
+	if err != nil {
+		t.Fatalf("Renderer.AddViewport failed:\n%v", err)
+	}
+
+	// Every ray escapes.
+	param := ReferenceParam{Width: 8, Height: 4, Samples: 2, Bounces: 1, Sky: [3]float32{0.25, 0.5, 1}}
+	data, err := rend.RenderReference(v, &param)
+	if err != nil {
+		t.Fatalf("Renderer.RenderReference failed:\n%v", err)
+	}
+	if len(data) != 8*4*3 {
+		t.Fatalf("Renderer.RenderReference: len\nhave %d\nwant %d", len(data), 8*4*3)
+	}
+	for i, x := range data {
+		if y := param.Sky[i%3]; x != y {
+			t.Fatalf("Renderer.RenderReference: pixel %d\nhave %v\nwant %v", i/3, x, y)
+		}
+	}
+
+	for _, x := range [...]*ReferenceParam{
+		nil,
+		{Width: 0, Height: 4, Samples: 1},
+		{Width: 4, Height: 4, Samples: 0},
+		{Width: 4, Height: 4, Samples: 1, Bounces: -1},
+	} {
+		if _, err := rend.RenderReference(v, x); err == nil {
+			t.Fatalf("Renderer.RenderReference(%v): unexpected success", x)
+		}
+	}
+}
@@ -0,0 +1,147 @@
+// Copyright 2024 Gustavo C. Viegas. All rights reserved.
+
+package engine
+
+import (
+	"encoding/binary"
+
+	"gviegas/neo3/driver"
+)
+
+// objectIDFmt is the pixel format of object ID
+// targets.
+const objectIDFmt = driver.R32Uint
+
+// objectIDPass is the name of the object ID pass.
+const objectIDPass = "objectID"
+
+// Pick is the result of a picking query.
+// It identifies the Drawable that was hit and the
+// Mesh it renders. Drawables are not associated with
+// nodes, so mapping the Drawable back to a node is
+// left to the caller.
+type Pick struct {
+	Drawable Drawable
+	Mesh     *Mesh
+}
+
+// SetPicking enables or disables picking in r.
+// When enabled, the ID of every opaque drawable is
+// rendered into an object ID target along with the
+// geometry stage, which PickAt then queries.
+// Enabling picking fails with ErrUnsupported while
+// the object ID pass does not record draws.
+func (r *Renderer) SetPicking(enable bool) error {
+	if !enable {
+		r.freeObjectID()
+		return nil
+	}
+	if r.ids != nil {
+		return nil
+	}
+	if err := checkPending("picking", objectIDPass); err != nil {
+		return err
+	}
+	var err error
+	r.ids, err = NewTarget(&TexParam{
+		PixelFmt: objectIDFmt,
+		Dim3D:    driver.Dim3D{Width: r.hdr.Width(), Height: r.hdr.Height()},
+		Layers:   1,
+		Levels:   1,
+		Samples:  1,
+	})
+	if err != nil {
+		return err
+	}
+	// Like the velocity pass, this draws opaque
+	// geometry testing against the depth of the
+	// geometry stage. The fragment shader writes
+	// the drawable's ID (see object_id_0).
+	r.graph.add(&passNode{
+		name:   objectIDPass,
+		stage:  stageGeometry,
+		writes: []*Texture{r.ids, r.ds},
+		record: recordObjectID,
+	})
+	return nil
+}
+
+// recordObjectID records the commands of the object
+// ID pass.
+// The object ID target is cleared to zero (i.e., no
+// drawable) once per frame, before the first
+// viewport is recorded, so that pixels not covered
+// by any drawable never keep IDs of past frames.
+func recordObjectID(r *Renderer, cb driver.CmdBuffer) {
+	if r.curVport >= 0 && r.curVport != r.vportOrder[0] {
+		return
+	}
+	cb.BeginPass(r.ids.Width(), r.ids.Height(), 1, []driver.ColorTarget{{
+		Color: r.ids.views[0],
+		Load:  driver.LClear,
+		Store: driver.SStore,
+		Clear: driver.ClearUint32(0, 0, 0, 0),
+	}}, nil)
+	cb.EndPass()
+}
+
+// Picking returns whether picking is enabled in r.
+func (r *Renderer) Picking() bool { return r.ids != nil }
+
+// freeObjectID removes the object ID pass from r's
+// frame graph and frees the object ID target.
+func (r *Renderer) freeObjectID() {
+	if r.ids == nil {
+		return
+	}
+	r.graph.remove(objectIDPass)
+	r.ids.Free()
+	r.ids = nil
+}
+
+// PickAt returns the drawable rendered at pixel x/y
+// of r's render target, as of the last frame that
+// completed execution.
+// The coordinates are in the native resolution of r;
+// they are scaled accordingly when dynamic resolution
+// is enabled.
+// It returns false if no drawable covers the pixel,
+// or if the drawable has since been removed.
+// Picking must have been enabled (see SetPicking).
+// PickAt blocks until the read completes.
+// It fails with ErrUnsupported while the object ID
+// pass does not record draws.
+func (r *Renderer) PickAt(x, y int) (Pick, bool, error) {
+	if err := checkPending("picking", objectIDPass); err != nil {
+		return Pick{}, false, err
+	}
+	var reason string
+	switch {
+	case r.ids == nil:
+		reason = "picking not enabled"
+	case x < 0 || y < 0 || x >= r.ids.Width() || y >= r.ids.Height():
+		reason = "pick position out of bounds"
+	default:
+		goto validParam
+	}
+	return Pick{}, false, newRendErr(reason)
+validParam:
+	if s := r.RenderScale(); s != 1 {
+		w, h := r.renderSize()
+		x = min(int(float32(x)*s), w-1)
+		y = min(int(float32(y)*s), h-1)
+	}
+	b, err := ReadTarget(r.ids, 0, x, y, 1, 1).Wait()
+	if err != nil {
+		return Pick{}, false, err
+	}
+	id := binary.LittleEndian.Uint32(b)
+	if id == 0 {
+		return Pick{}, false, nil
+	}
+	d := Drawable(id - 1)
+	if int(d) >= r.drawables.idMap.Len() || !r.drawables.idMap.IsSet(int(d)) {
+		return Pick{}, false, nil
+	}
+	return Pick{Drawable: d, Mesh: r.drawables.get(d).mesh}, true, nil
+}
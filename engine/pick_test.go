@@ -0,0 +1,104 @@
+// Copyright 2024 Gustavo C. Viegas. All rights reserved.
+
+package engine
+
+import (
+	"encoding/binary"
+	"errors"
+	"testing"
+)
+
+func TestPicking(t *testing.T) {
+	rend, err := NewOffscreen(64, 48)
+	if err != nil {
+		t.Fatalf("NewOffscreen failed:\n%v", err)
+	}
+	defer rend.Free()
+
+	// The object ID pass does not draw yet.
+	if _, _, err := rend.PickAt(0, 0); !errors.Is(err, ErrUnsupported) {
+		t.Fatalf("Renderer.PickAt: pending pass\nhave %v\nwant %v", err, ErrUnsupported)
+	}
+	if err := rend.SetPicking(true); !errors.Is(err, ErrUnsupported) {
+		t.Fatalf("Renderer.SetPicking: pending pass\nhave %v\nwant %v", err, ErrUnsupported)
+	}
+	if rend.Picking() || rend.graph.find(objectIDPass) >= 0 {
+		t.Fatal("Renderer.SetPicking: picking should not be enabled")
+	}
+	defer allowPending(objectIDPass)()
+
+	if _, _, err := rend.PickAt(0, 0); !errors.Is(err, ErrInvalidParam) {
+		t.Fatalf("Renderer.PickAt: picking disabled\nhave %v\nwant %v", err, ErrInvalidParam)
+	}
+	if err := rend.SetPicking(true); err != nil {
+		t.Fatalf("Renderer.SetPicking failed:\n%v", err)
+	}
+	if !rend.Picking() || rend.graph.find(objectIDPass) < 0 {
+		t.Fatal("Renderer.SetPicking: object ID pass not added")
+	}
+	if rend.ids.PixelFmt() != objectIDFmt || rend.ids.Width() != 64 || rend.ids.Height() != 48 {
+		t.Fatalf("Renderer.SetPicking: object ID target\n%+v", rend.ids.param)
+	}
+	for _, x := range [...][2]int{{-1, 0}, {0, -1}, {64, 0}, {0, 48}} {
+		if _, _, err := rend.PickAt(x[0], x[1]); !errors.Is(err, ErrInvalidParam) {
+			t.Fatalf("Renderer.PickAt(%d, %d):\nhave %v\nwant %v", x[0], x[1], err, ErrInvalidParam)
+		}
+	}
+
+	data := dummyData1(1)
+	mesh, err := NewMesh(&data)
+	if err != nil {
+		t.Fatalf("NewMesh failed:\n%v", err)
+	}
+	defer mesh.Free()
+	id := rend.drawables.insert(drawable{mesh: mesh})
+
+	// Write what the object ID pass would.
+	ids := make([]byte, 64*48*4)
+	binary.LittleEndian.PutUint32(ids[(20*64+10)*4:], uint32(id)+1)
+	binary.LittleEndian.PutUint32(ids[(30*64+40)*4:], uint32(id)+2)
+	if err := rend.ids.CopyToView(0, ids, true); err != nil {
+		t.Fatalf("Texture.CopyToView failed:\n%v", err)
+	}
+
+	p, ok, err := rend.PickAt(10, 20)
+	if err != nil {
+		t.Fatalf("Renderer.PickAt failed:\n%v", err)
+	}
+	if !ok || p.Drawable != id || p.Mesh != mesh {
+		t.Fatalf("Renderer.PickAt(10, 20):\nhave %+v, %t\nwant %+v, true", p, ok, Pick{id, mesh})
+	}
+	// Background and stale IDs.
+	for _, x := range [...][2]int{{0, 0}, {11, 20}, {40, 30}} {
+		if p, ok, err := rend.PickAt(x[0], x[1]); err != nil || ok {
+			t.Fatalf("Renderer.PickAt(%d, %d):\nhave %+v, %t, %v\nwant false", x[0], x[1], p, ok, err)
+		}
+	}
+
+	// IDs are cleared every frame.
+	if _, err := rend.AddViewport(&ViewportParam{Rect: ViewportRect{Width: 1, Height: 1}}); err != nil {
+		t.Fatalf("Renderer.AddViewport failed:\n%v", err)
+	}
+	rend.buildViewports()
+	if err := rend.Render(); err != nil {
+		t.Fatalf("Offscreen.Render failed:\n%v", err)
+	}
+	if _, ok, err := rend.PickAt(10, 20); err != nil || ok {
+		t.Fatalf("Renderer.PickAt: after Render\nhave %t, %v\nwant false", ok, err)
+	}
+	if err := rend.ids.CopyToView(0, ids, true); err != nil {
+		t.Fatalf("Texture.CopyToView failed:\n%v", err)
+	}
+
+	rend.drawables.remove(id)
+	if _, ok, err := rend.PickAt(10, 20); err != nil || ok {
+		t.Fatalf("Renderer.PickAt: removed drawable\nhave %t, %v\nwant false", ok, err)
+	}
+
+	if err := rend.SetPicking(false); err != nil {
+		t.Fatalf("Renderer.SetPicking failed:\n%v", err)
+	}
+	if rend.Picking() || rend.ids != nil || rend.graph.find(objectIDPass) >= 0 {
+		t.Fatal("Renderer.SetPicking: object ID pass not removed")
+	}
+}
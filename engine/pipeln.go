@@ -0,0 +1,73 @@
+// Copyright 2024 Gustavo C. Viegas. All rights reserved.
+
+package engine
+
+import (
+	"gviegas/neo3/driver"
+	"gviegas/neo3/engine/internal/ctxt"
+)
+
+// pipelineVariant is a specialized pipeline whose
+// creation happens in the background.
+// Until the specialized pipeline is ready, a fallback
+// pipeline (i.e., one created from an ubershader) is
+// used in its place, so drawing need not block on
+// pipeline compilation.
+type pipelineVariant struct {
+	async *driver.AsyncPipeline
+	pl    driver.Pipeline
+	// Not owned by the variant.
+	fallback driver.Pipeline
+}
+
+// newPipelineVariant starts the creation of the
+// pipeline described by state.
+// fallback must remain valid for as long as the
+// variant is in use.
+func newPipelineVariant(state any, fallback driver.Pipeline) *pipelineVariant {
+	pipelineCount.Add(1)
+	return &pipelineVariant{
+		async:    ctxt.GPU().NewPipelineAsync(state),
+		fallback: fallback,
+	}
+}
+
+// pipeline returns the pipeline that should be used
+// for drawing.
+// It never blocks. If the specialized pipeline failed
+// to be created, the fallback is used indefinitely.
+func (v *pipelineVariant) pipeline() driver.Pipeline {
+	if v.async != nil && v.async.Ready() {
+		if pl, err := v.async.Wait(); err == nil {
+			v.pl = pl
+		}
+		v.async = nil
+	}
+	if v.pl != nil {
+		return v.pl
+	}
+	return v.fallback
+}
+
+// specialized returns whether v is using the
+// specialized pipeline.
+func (v *pipelineVariant) specialized() bool {
+	return v.pipeline() != v.fallback
+}
+
+// free invalidates v and destroys the specialized
+// pipeline. It does not wait for pending creation to
+// complete.
+// The fallback pipeline is not destroyed.
+func (v *pipelineVariant) free() {
+	if *v != (pipelineVariant{}) {
+		pipelineCount.Add(-1)
+	}
+	if v.async != nil {
+		v.async.Destroy()
+	}
+	if v.pl != nil {
+		v.pl.Destroy()
+	}
+	*v = pipelineVariant{}
+}
@@ -0,0 +1,47 @@
+// Copyright 2024 Gustavo C. Viegas. All rights reserved.
+
+package engine
+
+import (
+	"testing"
+)
+
+// fallbackPipeline is a driver.Pipeline that is only
+// used as a fallback.
+type fallbackPipeline struct{}
+
+func (fallbackPipeline) Destroy() {}
+
+func TestPipelineVariant(t *testing.T) {
+	n := Stats().Pipelines
+	var fallback fallbackPipeline
+	// Invalid state; creation must fail and the
+	// fallback must be used indefinitely.
+	v := newPipelineVariant(nil, fallback)
+	if x := Stats().Pipelines; x != n+1 {
+		t.Fatalf("Stats: Pipelines\nhave %d\nwant %d", x, n+1)
+	}
+	if pl := v.pipeline(); pl != fallback {
+		t.Fatalf("pipelineVariant.pipeline:\nhave %v\nwant %v", pl, fallback)
+	}
+	if v.async != nil {
+		v.async.Wait()
+	}
+	if v.specialized() {
+		t.Fatal("pipelineVariant.specialized:\nhave true\nwant false")
+	}
+	if v.async != nil {
+		t.Fatal("pipelineVariant.async: should be nil once creation completes")
+	}
+	v.free()
+	if x := Stats().Pipelines; x != n {
+		t.Fatalf("Stats: Pipelines\nhave %d\nwant %d", x, n)
+	}
+
+	// Freeing a pending variant must not block.
+	v = newPipelineVariant(nil, fallback)
+	v.free()
+	if *v != (pipelineVariant{}) {
+		t.Fatal("pipelineVariant.free: variant should be zeroed")
+	}
+}
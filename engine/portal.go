@@ -0,0 +1,339 @@
+// Copyright 2024 Gustavo C. Viegas. All rights reserved.
+
+package engine
+
+import (
+	"gviegas/neo3/engine/internal/ctxt"
+	"gviegas/neo3/engine/internal/shader"
+	"gviegas/neo3/linear"
+)
+
+// MaxPortalDepth is the maximum recursion depth of
+// portals. It is limited by the number of bits in
+// StencilPortal.
+const MaxPortalDepth = 3
+
+// MaxPortalViews is the maximum number of views that
+// are rendered through portals per viewport and
+// frame, across all recursion levels.
+const MaxPortalViews = 16
+
+// portalMap is a dataMap for portals.
+type portalMap struct{ dataMap[Portal, portal] }
+
+// portal is what a portalMap stores.
+type portal struct {
+	param PortalParam
+}
+
+// Portal identifies a portal or mirror.
+// A Portal is always associated with a Renderer,
+// thus there might be identical Portal values
+// that belong to different renderers.
+type Portal int
+
+// PortalParam describes a portal.
+// A portal is a planar opening through which the
+// scene is rendered from another point of view. In
+// local space, the opening is the unit square
+// centered at the origin of the XY plane, and it is
+// only visible from the +Z side.
+// World is the world transform of the opening.
+// Exit is the world transform of the opening at the
+// other side of the portal: the camera is moved by
+// Exit × World⁻¹, so geometry in front (+Z) of World
+// is seen as geometry behind (-Z) of Exit. Only
+// geometry on the -Z side of Exit is rendered.
+// If Mirror is true, Exit is ignored and the scene
+// is reflected about the plane of the opening
+// instead, rendering only the +Z side of it.
+// Reflections invert the winding order of
+// primitives, which pipelines must account for.
+type PortalParam struct {
+	World  linear.M4
+	Exit   linear.M4
+	Mirror bool
+}
+
+// portalView is a view rendered through a portal.
+type portalView struct {
+	portal Portal
+	// Recursion level, starting at 1. Pixels
+	// covered by the view have level<<2 in
+	// StencilPortal.
+	level int
+	// Index of the view through which the
+	// portal was seen, or -1 if it was seen
+	// by the viewport itself.
+	parent int
+	// Camera and culling data of the view.
+	// fr bounds what is seen through the
+	// opening.
+	view   linear.M4
+	proj   linear.M4
+	fr     frustum
+	layout shader.FrameLayout
+	list   drawList
+}
+
+// Name of the portal pass.
+const portalPass = "portal"
+
+// AddPortal adds a new portal to r.
+// Portals are drawn in the geometry stage: for every
+// visible portal, the opening is first drawn into the
+// stencil target, incrementing its StencilPortal
+// level, and then the scene is drawn through it, up
+// to the depth set by SetPortalDepth. Geometry that
+// is not visible through the opening is culled, and
+// the space between the camera and the exit plane is
+// clipped (see ViewportParam.ClipPlane).
+// Portals require StencilPortal to be free.
+func (r *Renderer) AddPortal(param *PortalParam) (Portal, error) {
+	if param == nil {
+		return -1, newRendErr("nil portal param")
+	}
+	if r.portals.len() == 0 {
+		if err := r.reserveStencil(StencilPortal); err != nil {
+			return -1, err
+		}
+		if r.portalDepth == 0 {
+			r.portalDepth = 1
+		}
+		// Drawing through a portal reads and
+		// writes the same targets as opaque
+		// geometry.
+		r.graph.add(&passNode{
+			name:   portalPass,
+			stage:  stageGeometry,
+			writes: r.msaaWrites([]*Texture{r.hdr, r.ds}, stageGeometry),
+			msaa:   true,
+		})
+	}
+	return r.portals.insert(portal{param: *param}), nil
+}
+
+// RemovePortal removes p from r.
+func (r *Renderer) RemovePortal(p Portal) {
+	r.portals.remove(p)
+	if r.portals.len() == 0 {
+		r.graph.remove(portalPass)
+		r.stencil &^= StencilPortal
+		for _, v := range r.vports.all() {
+			v.portals = v.portals[:0]
+		}
+	}
+}
+
+// SetPortalWorld sets the world transforms of p.
+// exit is ignored if p is a mirror.
+func (r *Renderer) SetPortalWorld(p Portal, world, exit *linear.M4) {
+	x := r.portals.get(p)
+	x.param.World = *world
+	x.param.Exit = *exit
+}
+
+// PortalsLen returns the number of portals in r.
+func (r *Renderer) PortalsLen() int { return r.portals.len() }
+
+// SetPortalDepth sets the maximum recursion depth of
+// portals in r (i.e., how many portals can be seen
+// through one another). It must be in the interval
+// [1, MaxPortalDepth]. The default is 1.
+func (r *Renderer) SetPortalDepth(depth int) error {
+	if depth < 1 || depth > MaxPortalDepth {
+		return newRendErr("portal depth out of bounds")
+	}
+	r.portalDepth = depth
+	return nil
+}
+
+// PortalDepth returns the maximum recursion depth of
+// portals in r.
+func (r *Renderer) PortalDepth() int { return max(r.portalDepth, 1) }
+
+// camera returns the view transform and the world
+// space clip plane of the view through p.
+// view is the view transform of the camera that
+// looks at p.
+func (p *portal) camera(view *linear.M4) (linear.M4, linear.V4) {
+	w := &p.param.World
+	n := linear.V3{w[2][0], w[2][1], w[2][2]}
+	o := linear.V3{w[3][0], w[3][1], w[3][2]}
+	var v linear.M4
+	if p.param.Mirror {
+		n.Norm(&n)
+		plane := linear.V4{n[0], n[1], n[2], -n.Dot(&o)}
+		var refl linear.M4
+		refl.Reflect(&plane)
+		v.Mul(view, &refl)
+		return v, plane
+	}
+	// view × World × Exit⁻¹.
+	var inv linear.M4
+	inv.Invert(&p.param.Exit)
+	v.Mul(view, w)
+	v.Mul(&v, &inv)
+	e := &p.param.Exit
+	n = linear.V3{-e[2][0], -e[2][1], -e[2][2]}
+	o = linear.V3{e[3][0], e[3][1], e[3][2]}
+	n.Norm(&n)
+	return v, linear.V4{n[0], n[1], n[2], -n.Dot(&o)}
+}
+
+// corners returns the world space corners of the
+// opening of p that is seen by the portal view,
+// in counter-clockwise order when viewed from the
+// visible side.
+func (p *portal) corners() (c [4]linear.V3) {
+	m := &p.param.World
+	if !p.param.Mirror {
+		m = &p.param.Exit
+	}
+	for i, xy := range [4][2]float32{{-0.5, -0.5}, {0.5, -0.5}, {0.5, 0.5}, {-0.5, 0.5}} {
+		for j := range c[i] {
+			c[i][j] = m[0][j]*xy[0] + m[1][j]*xy[1] + m[3][j]
+		}
+	}
+	return
+}
+
+// visible returns whether the opening of p is
+// visible from a camera with the given view
+// transform and frustum.
+func (p *portal) visible(view *linear.M4, fr *frustum) bool {
+	min, max := linear.V3{-0.5, -0.5}, linear.V3{0.5, 0.5}
+	if fr.cull(&p.param.World, &min, &max) {
+		return false
+	}
+	// The camera must be on the +Z side.
+	var inv linear.M4
+	inv.Invert(view)
+	w := &p.param.World
+	var d linear.V3
+	for i := range d {
+		d[i] = inv[3][i] - w[3][i]
+	}
+	n := linear.V3{w[2][0], w[2][1], w[2][2]}
+	return n.Dot(&d) > 0
+}
+
+// frustum returns a frustum that bounds the volume
+// seen through the opening of p by a camera with the
+// given view transform (i.e., that of the view
+// returned by p.camera). The near plane is the clip
+// plane, and the far plane is taken from proj.
+func (p *portal) frustum(view, proj *linear.M4, clip *linear.V4) (f frustum) {
+	var inv linear.M4
+	inv.Invert(view)
+	eye := linear.V3{inv[3][0], inv[3][1], inv[3][2]}
+	c := p.corners()
+	var center linear.V3
+	for i := range c {
+		center.Add(&center, &c[i])
+	}
+	center.Scale(0.25, &center)
+	// A point beyond the opening, which must be
+	// inside every side plane.
+	var in linear.V3
+	in.Sub(&center, &eye)
+	in.Add(&center, &in)
+	for i := range 4 {
+		var e0, e1, n linear.V3
+		e0.Sub(&c[i], &eye)
+		e1.Sub(&c[(i+1)%4], &eye)
+		n.Cross(&e0, &e1)
+		if n.Dot(&n) < 1e-12 {
+			// Degenerate: the camera is on the
+			// plane of the opening. Do not
+			// restrict culling by this edge.
+			f[i] = linear.V4{3: 1}
+			continue
+		}
+		n.Norm(&n)
+		f[i] = linear.V4{n[0], n[1], n[2], -n.Dot(&eye)}
+		q := linear.V4{in[0], in[1], in[2], 1}
+		if f[i].Dot(&q) < 0 {
+			f[i].Scale(-1, &f[i])
+		}
+	}
+	f[4] = *clip
+	var vp linear.M4
+	vp.Mul(proj, view)
+	var full frustum
+	full.set(&vp)
+	f[5] = full[5]
+	return
+}
+
+// buildPortals builds the portal views of v, up to
+// r's portal depth, as well as their draw lists.
+// It must be called after v's own draw list is
+// built.
+func (v *viewport) buildPortals(r *Renderer) {
+	v.portals = v.portals[:0]
+	if r.portals.len() == 0 {
+		return
+	}
+	vp := v.layout.VP()
+	var fr frustum
+	fr.set(&vp)
+	v.addPortalViews(r, -1, 1, &v.param.View, &fr)
+	// Views are appended while iterating, so
+	// this visits every level in turn.
+	for i := 0; i < len(v.portals); i++ {
+		if v.portals[i].level >= r.PortalDepth() {
+			continue
+		}
+		view, fr := v.portals[i].view, v.portals[i].fr
+		v.addPortalViews(r, i, v.portals[i].level+1, &view, &fr)
+	}
+	scale := r.targetScale()
+	for i := range v.portals {
+		pv := &v.portals[i]
+		pv.layout.SetTargetScale(scale)
+		pv.list.build(r, &pv.view, &pv.fr)
+		pv.list.buildFoliage(r, &pv.view, &pv.fr)
+		pv.list.buildClusters(r, &pv.view, &pv.proj)
+	}
+}
+
+// addPortalViews appends to v.portals a view for
+// every portal that is visible from the given view
+// transform and frustum, up to MaxPortalViews.
+func (v *viewport) addPortalViews(r *Renderer, parent, level int, view *linear.M4, fr *frustum) {
+	for id, p := range r.portals.all() {
+		if len(v.portals) == MaxPortalViews {
+			return
+		}
+		if !p.visible(view, fr) {
+			continue
+		}
+		pview, clip := p.camera(view)
+		pv := portalView{
+			portal: id,
+			level:  level,
+			parent: parent,
+			view:   pview,
+		}
+		// Reuse the list's storage from a
+		// previous frame.
+		if n := len(v.portals); n < cap(v.portals) {
+			pv.list = v.portals[:n+1][n].list
+		}
+		pv.layout = v.layout
+		pv.proj = v.param.Proj
+		pv.fr = p.frustum(&pv.view, &pv.proj, &clip)
+		if !ctxt.Features().ClipDistance {
+			obliqueClip(&pv.proj, &pv.view, &clip)
+			clip = linear.V4{}
+		}
+		var vp linear.M4
+		vp.Mul(&pv.proj, &pv.view)
+		pv.layout.SetVP(&vp)
+		pv.layout.SetV(&pv.view)
+		pv.layout.SetP(&pv.proj)
+		pv.layout.SetClipPlane(&clip)
+		v.portals = append(v.portals, pv)
+	}
+}
@@ -0,0 +1,141 @@
+// Copyright 2024 Gustavo C. Viegas. All rights reserved.
+
+package engine
+
+import (
+	"errors"
+	"math"
+	"testing"
+
+	"gviegas/neo3/linear"
+)
+
+// transformPoint returns m × p.
+func transformPoint(m *linear.M4, p linear.V3) linear.V3 {
+	var v linear.V4
+	v.Mul(m, &linear.V4{p[0], p[1], p[2], 1})
+	return linear.V3{v[0], v[1], v[2]}
+}
+
+func TestPortalCamera(t *testing.T) {
+	near := func(a, b linear.V3) bool {
+		for i := range a {
+			if math.Abs(float64(a[i]-b[i])) > 1e-4 {
+				return false
+			}
+		}
+		return true
+	}
+	var view linear.M4
+	view.Translate(-1, 0, -5)
+
+	// Geometry behind the exit is seen as if it
+	// were behind the entrance.
+	var p portal
+	p.param.World.Translate(0, 0, 0)
+	p.param.Exit.Translate(10, 2, 0)
+	v, clip := p.camera(&view)
+	for _, x := range [...]linear.V3{{0, 0, -3}, {1, -2, -7}} {
+		exit := linear.V3{x[0] + 10, x[1] + 2, x[2]}
+		if a, b := transformPoint(&v, exit), transformPoint(&view, x); !near(a, b) {
+			t.Fatalf("portal.camera: view\nhave %v\nwant %v", a, b)
+		}
+	}
+	if want := (linear.V4{0, 0, -1, 0}); clip != want {
+		t.Fatalf("portal.camera: clip plane\nhave %v\nwant %v", clip, want)
+	}
+
+	// Mirrors reflect about the opening.
+	p.param.Mirror = true
+	p.param.World.Translate(0, 0, 1)
+	v, clip = p.camera(&view)
+	if a, b := transformPoint(&v, linear.V3{1, 2, 4}), transformPoint(&view, linear.V3{1, 2, -2}); !near(a, b) {
+		t.Fatalf("portal.camera: mirror view\nhave %v\nwant %v", a, b)
+	}
+	if want := (linear.V4{0, 0, 1, -1}); clip != want {
+		t.Fatalf("portal.camera: mirror clip plane\nhave %v\nwant %v", clip, want)
+	}
+}
+
+func TestPortal(t *testing.T) {
+	rend, err := NewOffscreen(64, 64)
+	if err != nil {
+		t.Fatalf("NewOffscreen failed:\n%v", err)
+	}
+	defer rend.Free()
+
+	if _, err := rend.AddPortal(nil); !errors.Is(err, ErrInvalidParam) {
+		t.Fatalf("Renderer.AddPortal(nil):\nhave %v\nwant %v", err, ErrInvalidParam)
+	}
+	for _, n := range [...]int{0, MaxPortalDepth + 1} {
+		if err := rend.SetPortalDepth(n); !errors.Is(err, ErrInvalidParam) {
+			t.Fatalf("Renderer.SetPortalDepth(%d):\nhave %v\nwant %v", n, err, ErrInvalidParam)
+		}
+	}
+	if n := rend.PortalDepth(); n != 1 {
+		t.Fatalf("Renderer.PortalDepth:\nhave %d\nwant 1", n)
+	}
+
+	// Two mirrors facing each other, with the
+	// camera between them looking at the second.
+	var a, b PortalParam
+	a.World.Translate(0, 0, 0)
+	var rot linear.M4
+	rot.Rotate(math.Pi, &linear.V3{0, 1, 0})
+	b.World.Translate(0, 0, 10)
+	b.World.Mul(&b.World, &rot)
+	a.Mirror, b.Mirror = true, true
+	pa, err := rend.AddPortal(&a)
+	if err != nil {
+		t.Fatalf("Renderer.AddPortal failed:\n%v", err)
+	}
+	pb, err := rend.AddPortal(&b)
+	if err != nil {
+		t.Fatalf("Renderer.AddPortal failed:\n%v", err)
+	}
+	if rend.PortalsLen() != 2 || rend.stencil&StencilPortal != StencilPortal || rend.graph.find(portalPass) < 0 {
+		t.Fatal("Renderer.AddPortal: portals not set up")
+	}
+
+	var view, proj linear.M4
+	view.Translate(0, 0, -5)
+	proj.Perspective(math.Pi/3, 1, 0.1, 100)
+	id, err := rend.AddViewport(&ViewportParam{View: view, Proj: proj, Rect: ViewportRect{Width: 1, Height: 1}})
+	if err != nil {
+		t.Fatalf("Renderer.AddViewport failed:\n%v", err)
+	}
+	v := rend.vports.get(id)
+	for depth := 1; depth <= MaxPortalDepth; depth++ {
+		if err := rend.SetPortalDepth(depth); err != nil {
+			t.Fatalf("Renderer.SetPortalDepth failed:\n%v", err)
+		}
+		v.buildPortals(&rend.Renderer)
+		if len(v.portals) != depth {
+			t.Fatalf("viewport.buildPortals: depth %d\nhave %d views\nwant %d", depth, len(v.portals), depth)
+		}
+		for i, x := range v.portals {
+			want := pb
+			if i%2 != 0 {
+				want = pa
+			}
+			if x.level != i+1 || x.parent != i-1 || x.portal != want {
+				t.Fatalf("viewport.buildPortals: view %d\n%+v", i, x)
+			}
+		}
+	}
+
+	// Turned around, the first mirror is seen
+	// first.
+	view.Mul(&rot, &view)
+	rend.SetViewportCamera(id, &view, &proj)
+	v.buildPortals(&rend.Renderer)
+	if len(v.portals) == 0 || v.portals[0].portal != pa {
+		t.Fatalf("viewport.buildPortals: facing the first mirror\nhave %d views", len(v.portals))
+	}
+
+	rend.RemovePortal(pa)
+	rend.RemovePortal(pb)
+	if rend.stencil&StencilPortal != 0 || rend.graph.find(portalPass) >= 0 || len(v.portals) != 0 {
+		t.Fatal("Renderer.RemovePortal: portals not torn down")
+	}
+}
@@ -0,0 +1,367 @@
+// Copyright 2024 Gustavo C. Viegas. All rights reserved.
+
+package engine
+
+import (
+	"time"
+
+	"gviegas/neo3/driver"
+	"gviegas/neo3/engine/internal/ctxt"
+	"gviegas/neo3/wsi"
+)
+
+const presPrefix = "presenter: "
+
+func newPresErr(reason string) error { return newErr(presPrefix, reason, ErrInvalidParam) }
+
+// Presenter manages the presentation of frames on
+// a wsi.Window.
+// It tracks NFrame frames in flight, each using
+// a command buffer taken from the engine's pool,
+// and owns the swapchain of the window, which is
+// recreated as needed.
+type Presenter struct {
+	win wsi.Window
+	sc  driver.Swapchain
+	ch  chan *driver.WorkItem
+
+	// Current frame, set by BeginFrame.
+	wk   *driver.WorkItem
+	next int
+
+	// Size of the backbuffers, in pixels.
+	width  int
+	height int
+	broken bool
+}
+
+// Frame describes a frame in the process of
+// being recorded.
+type Frame struct {
+	// Index of the frame in flight, in the
+	// interval [0, NFrame).
+	// Per-frame resources indexed by this value
+	// are not in use by the GPU.
+	Index int
+	// Cmd is the command buffer in which to
+	// record the frame's commands.
+	// It is in the recording state.
+	Cmd driver.CmdBuffer
+	// View is the backbuffer to render into.
+	// It is in the driver.LColorTarget layout,
+	// and must remain so when EndFrame is called.
+	// Its contents are undefined.
+	View driver.ImageView
+	// Resized indicates whether the swapchain was
+	// recreated since the previous frame, which
+	// invalidates resources that depend on the
+	// window's dimensions.
+	Resized bool
+	// Width and Height are the size of View,
+	// in pixels.
+	Width, Height int
+	// Scale is the window's scale factor (see
+	// wsi.WindowScale). Text and UI should be
+	// scaled by it to look the same on monitors
+	// of different pixel densities.
+	Scale float32
+}
+
+// backbufferWait is how long BeginFrame waits for
+// a backbuffer to become available before failing
+// with driver.ErrNoBackbuffer.
+const backbufferWait = time.Second
+
+// NewPresenter creates a new presenter.
+func NewPresenter(win wsi.Window) (*Presenter, error) {
+	return newPresenter(win, presPrefix, "NewPresenter")
+}
+
+// newPresenter implements NewPresenter.
+// prefix and caller identify the function that
+// creates the presenter in error messages, since
+// Onscreen uses a Presenter to manage its window's
+// swapchain.
+func newPresenter(win wsi.Window, prefix, caller string) (p *Presenter, err error) {
+	if win == nil {
+		return nil, newErr(prefix, "nil wsi.Window in call to "+caller, ErrInvalidParam)
+	}
+	pres, ok := ctxt.GPU().(driver.Presenter)
+	if !ok {
+		return nil, newErr(prefix, caller+" requires driver.Presenter", ErrUnsupported)
+	}
+	sc, err := pres.NewSwapchain(win, NFrame+1)
+	if err != nil {
+		return nil, err
+	}
+	p = &Presenter{
+		win: win,
+		sc:  sc,
+		ch:  make(chan *driver.WorkItem, NFrame),
+	}
+	p.width, p.height = wsi.PixelSize(win)
+	for i := range cap(p.ch) {
+		p.ch <- &driver.WorkItem{
+			Work:   make([]driver.CmdBuffer, 0, 1),
+			Custom: i,
+		}
+	}
+	return
+}
+
+// Window returns the wsi.Window associated with p.
+func (p *Presenter) Window() wsi.Window { return p.win }
+
+// Format returns the PixelFmt of the backbuffers.
+func (p *Presenter) Format() driver.PixelFmt { return p.sc.Format() }
+
+// BeginFrame begins a new frame.
+// It blocks until the oldest frame in flight
+// completes execution, then acquires the next
+// backbuffer, recreating the swapchain if the
+// window's pixel size changed (see wsi.PixelSize)
+// or the swapchain became unusable.
+// The frame must be ended with EndFrame before
+// BeginFrame is called again.
+// If no backbuffer becomes available within a
+// bounded wait, BeginFrame fails with
+// driver.ErrNoBackbuffer.
+// If the commit of the frame that previously used
+// f.Index failed, BeginFrame returns that error;
+// it can be called again.
+// Queued uploads are staged and, along with delayed
+// Texture copies, submitted for execution when a
+// frame begins (see ScheduleUploads and FlushUploads).
+// Resources replaced by Reload calls are freed once
+// they can no longer be in use.
+func (p *Presenter) BeginFrame() (f Frame, err error) {
+	if p.wk != nil {
+		err = newPresErr("BeginFrame called during frame")
+		return
+	}
+	ScheduleUploads()
+	autoCommitTexStg()
+	return p.begin(true)
+}
+
+// begin implements BeginFrame, except for the
+// submission of uploads.
+// retireFrame is called only if retire is set, so
+// that a PresentGroup can call it once for all of
+// its presenters.
+func (p *Presenter) begin(retire bool) (f Frame, err error) {
+	wk := <-p.ch
+	cmdBufs.recycle(wk)
+	if retire {
+		retireFrame()
+	}
+	if wk.Err != nil {
+		err = wk.Err
+		wk.Err = nil
+		p.ch <- wk
+		return
+	}
+	defer func() {
+		if err != nil {
+			cmdBufs.recycle(wk)
+			p.ch <- wk
+		}
+	}()
+
+	if width, height := wsi.PixelSize(p.win); p.broken || p.width != width || p.height != height {
+		if err = p.recreate(); err != nil {
+			return
+		}
+		f.Resized = true
+	}
+	var next int
+	var deadline time.Time
+	for {
+		next, err = p.sc.Next()
+		switch err {
+		case nil:
+		case driver.ErrNoBackbuffer:
+			if deadline.IsZero() {
+				deadline = time.Now().Add(backbufferWait)
+			} else if time.Now().After(deadline) {
+				return
+			}
+			time.Sleep(time.Millisecond)
+			continue
+		case driver.ErrSwapchain:
+			if err = p.recreate(); err != nil {
+				return
+			}
+			f.Resized = true
+			continue
+		default:
+			return
+		}
+		break
+	}
+
+	cb, err := cmdBufs.get()
+	if err != nil {
+		p.abandon(next)
+		return
+	}
+	wk.Work = append(wk.Work, cb)
+	if err = cb.Begin(); err != nil {
+		p.abandon(next)
+		return
+	}
+	view := p.sc.Views()[next]
+	cb.Transition([]driver.Transition{{
+		Barrier: driver.Barrier{
+			SyncBefore:  driver.SColorOutput,
+			SyncAfter:   driver.SColorOutput,
+			AccessAfter: driver.AColorWrite,
+		},
+		LayoutBefore: driver.LUndefined,
+		LayoutAfter:  driver.LColorTarget,
+		Img:          view.Image(),
+		Layers:       1,
+		Levels:       1,
+	}})
+	p.wk = wk
+	p.next = next
+	f.Index = wk.Custom.(int)
+	f.Cmd = cb
+	f.View = view
+	f.Width = p.width
+	f.Height = p.height
+	f.Scale = float32(wsi.WindowScale(p.win))
+	return
+}
+
+// EndFrame ends the current frame, committing its
+// commands and presenting the backbuffer.
+// It does not wait for the commands to complete.
+func (p *Presenter) EndFrame() error {
+	defer traceBegin(traceSubmit, "frame").end()
+	wk := p.wk
+	if wk == nil {
+		return newPresErr("EndFrame called without BeginFrame")
+	}
+	p.wk = nil
+	cb := wk.Work[0]
+	cb.Transition([]driver.Transition{{
+		Barrier: driver.Barrier{
+			SyncBefore:   driver.SColorOutput,
+			SyncAfter:    driver.SColorOutput,
+			AccessBefore: driver.AColorWrite,
+		},
+		LayoutBefore: driver.LColorTarget,
+		LayoutAfter:  driver.LPresent,
+		Img:          p.sc.Views()[p.next].Image(),
+		Layers:       1,
+		Levels:       1,
+	}})
+	if err := cb.End(); err != nil {
+		cmdBufs.recycle(wk)
+		p.ch <- wk
+		p.abandon(p.next)
+		return err
+	}
+	if err := ctxt.GPU().Commit(wk, p.ch); err != nil {
+		cmdBufs.recycle(wk)
+		p.ch <- wk
+		p.abandon(p.next)
+		return err
+	}
+	switch err := p.sc.Present(p.next); err {
+	case nil:
+	case driver.ErrSwapchain:
+		// Recreated by the next BeginFrame.
+		p.broken = true
+	default:
+		return err
+	}
+	return nil
+}
+
+// abandon releases the backbuffer identified by
+// next, which was acquired but whose frame could
+// not be committed.
+// It presents the backbuffer using a separate
+// command buffer that only transitions it to
+// driver.LPresent. If that fails too, the
+// swapchain is marked for recreation, which
+// releases every acquired backbuffer.
+func (p *Presenter) abandon(next int) {
+	err := func() error {
+		cb, err := cmdBufs.get()
+		if err != nil {
+			return err
+		}
+		wk := &driver.WorkItem{Work: []driver.CmdBuffer{cb}}
+		defer cmdBufs.recycle(wk)
+		if err = cb.Begin(); err != nil {
+			return err
+		}
+		cb.Transition([]driver.Transition{{
+			Barrier: driver.Barrier{
+				SyncBefore: driver.SColorOutput,
+				SyncAfter:  driver.SColorOutput,
+			},
+			LayoutBefore: driver.LUndefined,
+			LayoutAfter:  driver.LPresent,
+			Img:          p.sc.Views()[next].Image(),
+			Layers:       1,
+			Levels:       1,
+		}})
+		if err = cb.End(); err != nil {
+			return err
+		}
+		ch := make(chan *driver.WorkItem, 1)
+		if err = ctxt.GPU().Commit(wk, ch); err != nil {
+			return err
+		}
+		if err = (<-ch).Err; err != nil {
+			return err
+		}
+		return p.sc.Present(next)
+	}()
+	if err != nil {
+		p.broken = true
+	}
+}
+
+// recreate recreates the swapchain.
+// It waits for every other frame in flight to
+// complete execution first.
+func (p *Presenter) recreate() error {
+	var wk [NFrame - 1]*driver.WorkItem
+	for i := range wk {
+		wk[i] = <-p.ch
+	}
+	defer func() {
+		for _, wk := range wk {
+			p.ch <- wk
+		}
+	}()
+	if err := p.sc.Recreate(); err != nil {
+		return err
+	}
+	p.width, p.height = wsi.PixelSize(p.win)
+	p.broken = false
+	return nil
+}
+
+// Free invalidates p and destroys the driver
+// resources it holds.
+// It does not call Close on the wsi.Window.
+// The current frame, if any, is discarded.
+func (p *Presenter) Free() {
+	if p == nil {
+		return
+	}
+	if p.wk != nil {
+		p.ch <- p.wk
+	}
+	for range cap(p.ch) {
+		cmdBufs.recycle(<-p.ch)
+	}
+	p.sc.Destroy()
+	*p = Presenter{}
+}
@@ -0,0 +1,201 @@
+// Copyright 2024 Gustavo C. Viegas. All rights reserved.
+
+package engine
+
+import (
+	"testing"
+
+	"gviegas/neo3/driver"
+	"gviegas/neo3/wsi"
+)
+
+func TestPresenter(t *testing.T) {
+	if _, err := NewPresenter(nil); err == nil {
+		t.Fatal("NewPresenter: unexpected success with nil window")
+	}
+	win, err := wsi.NewWindow(480, 270, "TestPresenter")
+	if err != nil {
+		t.Fatalf("Presenter: wsi.NewWindow failed:\n%v", err)
+	}
+	defer win.Close()
+	p, err := NewPresenter(win)
+	if err != nil {
+		if err == driver.ErrCannotPresent {
+			t.Skip(err)
+		}
+		t.Fatalf("NewPresenter failed:\n%v", err)
+	}
+	if p.Window() != win {
+		t.Fatal("Presenter.Window: windows differ")
+	}
+	if err := p.EndFrame(); err == nil {
+		t.Fatal("Presenter.EndFrame: unexpected success without BeginFrame")
+	}
+	seen := make(map[int]bool)
+	for range 3 * NFrame {
+		f, err := p.BeginFrame()
+		if err != nil {
+			t.Fatalf("Presenter.BeginFrame failed:\n%v", err)
+		}
+		if f.Index < 0 || f.Index >= NFrame {
+			t.Fatalf("Frame.Index: out of bounds (%d)", f.Index)
+		}
+		seen[f.Index] = true
+		if !f.Cmd.IsRecording() {
+			t.Fatal("Frame.Cmd: should be recording")
+		}
+		if f.View == nil {
+			t.Fatal("Frame.View: unexpected nil view")
+		}
+		if w, h := wsi.PixelSize(win); f.Width != w || f.Height != h {
+			t.Fatalf("Frame.Width/Height:\nhave %d, %d\nwant %d, %d", f.Width, f.Height, w, h)
+		}
+		if f.Scale <= 0 {
+			t.Fatalf("Frame.Scale: non-positive scale %v", f.Scale)
+		}
+		if _, err := p.BeginFrame(); err == nil {
+			t.Fatal("Presenter.BeginFrame: unexpected success during frame")
+		}
+		if err := p.EndFrame(); err != nil {
+			t.Fatalf("Presenter.EndFrame failed:\n%v", err)
+		}
+	}
+	if len(seen) != NFrame {
+		t.Fatalf("Frame.Index: got %d distinct values, want %d", len(seen), NFrame)
+	}
+	if _, err := p.BeginFrame(); err != nil {
+		t.Fatalf("Presenter.BeginFrame failed:\n%v", err)
+	}
+	p.Free()
+	if p.Window() != nil {
+		t.Fatal("Presenter.Window: window should be nil")
+	}
+}
+
+func TestPresentGroup(t *testing.T) {
+	var wins [3]wsi.Window
+	for i := range wins {
+		win, err := wsi.NewWindow(320+i*80, 180+i*45, "TestPresentGroup")
+		if err != nil {
+			t.Fatalf("PresentGroup: wsi.NewWindow failed:\n%v", err)
+		}
+		defer win.Close()
+		wins[i] = win
+	}
+	g, err := NewPresentGroup(wins[:2]...)
+	if err != nil {
+		if err == driver.ErrCannotPresent {
+			t.Skip(err)
+		}
+		t.Fatalf("NewPresentGroup failed:\n%v", err)
+	}
+	defer g.Free()
+	if _, err := g.Add(wins[0]); err == nil {
+		t.Fatal("PresentGroup.Add: unexpected success with duplicate window")
+	}
+	if _, err := g.Add(wins[2]); err != nil {
+		t.Fatalf("PresentGroup.Add failed:\n%v", err)
+	}
+	if x := g.Len(); x != 3 {
+		t.Fatalf("PresentGroup.Len:\nhave %d\nwant 3", x)
+	}
+	for _, win := range wins {
+		if p := g.Presenter(win); p == nil || p.Window() != win {
+			t.Fatal("PresentGroup.Presenter: wrong presenter")
+		}
+	}
+	if err := g.EndFrame(); err == nil {
+		t.Fatal("PresentGroup.EndFrame: unexpected success without BeginFrame")
+	}
+
+	for i := range 3 * NFrame {
+		focus := wins[i%len(wins)]
+		g.KeyboardEnter(focus)
+		if g.Focus() != focus {
+			t.Fatal("PresentGroup.KeyboardEnter: focus not set")
+		}
+		fs, err := g.BeginFrame()
+		if err != nil {
+			t.Fatalf("PresentGroup.BeginFrame failed:\n%v", err)
+		}
+		if len(fs) != len(wins) {
+			t.Fatalf("PresentGroup.BeginFrame: len\nhave %d\nwant %d", len(fs), len(wins))
+		}
+		if fs[0].Win != focus {
+			t.Fatal("PresentGroup.BeginFrame: focused window should come first")
+		}
+		for _, f := range fs {
+			if f.Index < 0 || f.Index >= NFrame {
+				t.Fatalf("WindowFrame.Index: out of bounds (%d)", f.Index)
+			}
+			if !f.Cmd.IsRecording() {
+				t.Fatal("WindowFrame.Cmd: should be recording")
+			}
+			if f.View == nil {
+				t.Fatal("WindowFrame.View: unexpected nil view")
+			}
+		}
+		if _, err := g.BeginFrame(); err == nil {
+			t.Fatal("PresentGroup.BeginFrame: unexpected success during frame")
+		}
+		if err := g.Remove(wins[0]); err == nil {
+			t.Fatal("PresentGroup.Remove: unexpected success during frame")
+		}
+		if err := g.EndFrame(); err != nil {
+			t.Fatalf("PresentGroup.EndFrame failed:\n%v", err)
+		}
+	}
+
+	if err := g.Remove(wins[1]); err != nil {
+		t.Fatalf("PresentGroup.Remove failed:\n%v", err)
+	}
+	if g.Presenter(wins[1]) != nil || g.Focus() == wins[1] {
+		t.Fatal("PresentGroup.Remove: window still in group")
+	}
+	fs, err := g.BeginFrame()
+	if err != nil {
+		t.Fatalf("PresentGroup.BeginFrame failed:\n%v", err)
+	}
+	if len(fs) != 2 {
+		t.Fatalf("PresentGroup.BeginFrame: len\nhave %d\nwant 2", len(fs))
+	}
+	if err := g.EndFrame(); err != nil {
+		t.Fatalf("PresentGroup.EndFrame failed:\n%v", err)
+	}
+}
+
+// busySwapchain is a driver.Swapchain whose
+// backbuffers are always in use.
+type busySwapchain struct{ driver.Swapchain }
+
+func (busySwapchain) Next() (int, error) { return -1, driver.ErrNoBackbuffer }
+
+func TestPresenterNoBackbuffer(t *testing.T) {
+	win, err := wsi.NewWindow(480, 270, "TestPresenterNoBackbuffer")
+	if err != nil {
+		t.Fatalf("Presenter: wsi.NewWindow failed:\n%v", err)
+	}
+	defer win.Close()
+	p, err := NewPresenter(win)
+	if err != nil {
+		if err == driver.ErrCannotPresent {
+			t.Skip(err)
+		}
+		t.Fatalf("NewPresenter failed:\n%v", err)
+	}
+	defer p.Free()
+	sc := p.sc
+	p.sc = busySwapchain{sc}
+	if _, err := p.BeginFrame(); err != driver.ErrNoBackbuffer {
+		t.Fatalf("Presenter.BeginFrame:\nhave %v\nwant %v", err, driver.ErrNoBackbuffer)
+	}
+	p.sc = sc
+	for range 2 * NFrame {
+		if _, err := p.BeginFrame(); err != nil {
+			t.Fatalf("Presenter.BeginFrame failed:\n%v", err)
+		}
+		if err := p.EndFrame(); err != nil {
+			t.Fatalf("Presenter.EndFrame failed:\n%v", err)
+		}
+	}
+}
@@ -0,0 +1,205 @@
+// Copyright 2024 Gustavo C. Viegas. All rights reserved.
+
+package engine
+
+import (
+	"errors"
+	"slices"
+
+	"gviegas/neo3/wsi"
+)
+
+// PresentGroup drives the presentation of frames on
+// several windows.
+// Each window has its own Presenter (and thus its own
+// swapchain), and is expected to be rendered by its
+// own Renderer and Camera. GPU resources such as
+// meshes, textures and materials are shared.
+// The per-frame work of the engine (i.e., submission
+// of uploads and freeing of retired resources) is done
+// once per group frame, rather than once per window.
+type PresentGroup struct {
+	pres  []*Presenter
+	focus wsi.Window
+	// Frames of the current group frame, in
+	// presentation order.
+	frames  []WindowFrame
+	inFrame bool
+}
+
+// WindowFrame is a Frame of a specific window.
+type WindowFrame struct {
+	Frame
+	Win wsi.Window
+}
+
+// NewPresentGroup creates a new presenter group for
+// the given windows.
+// More windows can be added with Add.
+func NewPresentGroup(wins ...wsi.Window) (*PresentGroup, error) {
+	g := new(PresentGroup)
+	for _, win := range wins {
+		if _, err := g.Add(win); err != nil {
+			g.Free()
+			return nil, err
+		}
+	}
+	return g, nil
+}
+
+// Add adds win to g.
+// It creates a new Presenter for win. The Presenter
+// can be queried (e.g., for its Format), but its
+// BeginFrame and EndFrame methods must not be called
+// while it belongs to g.
+// It cannot be called during a group frame.
+func (g *PresentGroup) Add(win wsi.Window) (*Presenter, error) {
+	switch {
+	case g.inFrame:
+		return nil, newPresErr("Add called during frame")
+	case g.Presenter(win) != nil:
+		return nil, newPresErr("window already in PresentGroup")
+	}
+	p, err := NewPresenter(win)
+	if err != nil {
+		return nil, err
+	}
+	g.pres = append(g.pres, p)
+	return p, nil
+}
+
+// Remove removes win from g, freeing its Presenter.
+// It does not call Close on the wsi.Window.
+// It cannot be called during a group frame.
+func (g *PresentGroup) Remove(win wsi.Window) error {
+	if g.inFrame {
+		return newPresErr("Remove called during frame")
+	}
+	i := slices.IndexFunc(g.pres, func(p *Presenter) bool { return p.win == win })
+	if i < 0 {
+		return newPresErr("window not in PresentGroup")
+	}
+	g.pres[i].Free()
+	g.pres = slices.Delete(g.pres, i, i+1)
+	if g.focus == win {
+		g.focus = nil
+	}
+	return nil
+}
+
+// Presenter returns the Presenter of win, or nil if
+// win is not in g.
+func (g *PresentGroup) Presenter(win wsi.Window) *Presenter {
+	for _, p := range g.pres {
+		if p.win == win {
+			return p
+		}
+	}
+	return nil
+}
+
+// Len returns the number of windows in g.
+func (g *PresentGroup) Len() int { return len(g.pres) }
+
+// SetFocus sets the window that has focus.
+// Its frames are presented before those of other
+// windows, reducing the latency of the window with
+// which the user is interacting.
+// win need not be in g; nil clears the focus.
+func (g *PresentGroup) SetFocus(win wsi.Window) { g.focus = win }
+
+// Focus returns the window that has focus.
+func (g *PresentGroup) Focus() wsi.Window { return g.focus }
+
+// KeyboardEnter implements wsi.KeyboardEnterHandler.
+// It calls g.SetFocus(win).
+func (g *PresentGroup) KeyboardEnter(win wsi.Window) { g.SetFocus(win) }
+
+// BeginFrame begins a new group frame.
+// It calls Presenter.BeginFrame for every window in g
+// whose dimensions are not zero (e.g., minimized
+// windows are skipped), and returns the frames in
+// presentation order: the focused window first, then
+// the others in the order they were added.
+// The returned slice is only valid until EndFrame is
+// called, which must happen before BeginFrame is
+// called again.
+// If any window fails to begin its frame, the frames
+// that began successfully are ended (presenting
+// undefined contents) and the error is returned; no
+// group frame is in progress in this case.
+func (g *PresentGroup) BeginFrame() ([]WindowFrame, error) {
+	if g.inFrame {
+		return nil, newPresErr("BeginFrame called during frame")
+	}
+	ScheduleUploads()
+	autoCommitTexStg()
+	g.frames = g.frames[:0]
+	for _, p := range g.order() {
+		if w, h := wsi.PixelSize(p.win); w <= 0 || h <= 0 {
+			continue
+		}
+		f, err := p.begin(false)
+		if err != nil {
+			for _, x := range g.frames {
+				g.Presenter(x.Win).EndFrame()
+			}
+			clear(g.frames)
+			g.frames = g.frames[:0]
+			return nil, err
+		}
+		g.frames = append(g.frames, WindowFrame{f, p.win})
+	}
+	// Every presenter has waited for its oldest
+	// frame in flight by now.
+	retireFrame()
+	g.inFrame = true
+	return g.frames, nil
+}
+
+// EndFrame ends the current group frame, committing
+// and presenting the frames of every window in the
+// order that BeginFrame returned them.
+// Every frame is ended even if some fail; the errors
+// are joined.
+func (g *PresentGroup) EndFrame() error {
+	if !g.inFrame {
+		return newPresErr("EndFrame called without BeginFrame")
+	}
+	g.inFrame = false
+	var errs []error
+	for _, x := range g.frames {
+		if err := g.Presenter(x.Win).EndFrame(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	clear(g.frames)
+	g.frames = g.frames[:0]
+	return errors.Join(errs...)
+}
+
+// order returns the presenters of g in presentation
+// order.
+func (g *PresentGroup) order() []*Presenter {
+	i := slices.IndexFunc(g.pres, func(p *Presenter) bool { return p.win == g.focus })
+	if i <= 0 {
+		return g.pres
+	}
+	pres := make([]*Presenter, 0, len(g.pres))
+	pres = append(pres, g.pres[i])
+	pres = append(pres, g.pres[:i]...)
+	return append(pres, g.pres[i+1:]...)
+}
+
+// Free invalidates g and frees every Presenter in it.
+// It does not call Close on the wsi.Windows.
+// The current group frame, if any, is discarded.
+func (g *PresentGroup) Free() {
+	if g == nil {
+		return
+	}
+	for _, p := range g.pres {
+		p.Free()
+	}
+	*g = PresentGroup{}
+}
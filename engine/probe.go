@@ -0,0 +1,312 @@
+// Copyright 2024 Gustavo C. Viegas. All rights reserved.
+
+package engine
+
+import (
+	"gviegas/neo3/driver"
+	"gviegas/neo3/engine/internal/shader"
+	"gviegas/neo3/linear"
+)
+
+// Probe projections.
+// They determine the proxy geometry used to correct
+// cubemap lookups for parallax.
+const (
+	// The cubemap is projected onto a box.
+	// This suits rooms and other enclosed spaces.
+	ProbeBox = iota
+	// The cubemap is projected onto a sphere
+	// centered at the capture position.
+	ProbeSphere
+)
+
+// ProbeSize is the width and height of each face of
+// a reflection probe's cubemap.
+const ProbeSize = 256
+
+// probeFmt is the pixel format of probe cubemaps.
+const probeFmt = driver.RGBA16Float
+
+// ProbeParam describes a reflection probe.
+// Position is the world position from which the scene
+// is captured.
+// For ProbeBox projection, Min and Max define a world
+// space box that bounds the probe's influence and
+// serves as proxy geometry. For ProbeSphere, Radius
+// defines a sphere centered at Position that serves
+// the same purpose.
+// Blend is the distance, from the boundary of the
+// influence volume inwards, over which the probe
+// fades out when blended with another probe.
+// Realtime probes are re-captured continuously, a few
+// faces per frame. Other probes are captured once
+// after being added, and again whenever CaptureProbe
+// is called.
+type ProbeParam struct {
+	Position linear.V3
+	Proj     int
+	Min, Max linear.V3
+	Radius   float32
+	Blend    float32
+	Realtime bool
+}
+
+// probeMap is a dataMap for probes.
+type probeMap struct{ dataMap[Probe, probe] }
+
+// probe is what a probeMap stores.
+type probe struct {
+	param ProbeParam
+	// Cube index in Renderer.probeTex.
+	slot int
+	// Bit i is set if face i must be captured.
+	pending uint8
+	// Whether every face has been captured and
+	// the mip levels prefiltered at least once.
+	ready  bool
+	layout shader.ProbeLayout
+}
+
+// allFaces has one bit set for each cube face.
+const allFaces = 1<<6 - 1
+
+// Probe identifies a reflection probe.
+// A Probe is always associated with a Renderer,
+// thus there might be identical Probe values
+// that belong to different renderers.
+type Probe int
+
+// AddProbe adds a new reflection probe to r.
+// r can hold at most NProbe probes.
+// The probe only affects shading after its cubemap
+// has been captured.
+func (r *Renderer) AddProbe(param *ProbeParam) (Probe, error) {
+	var reason string
+	switch {
+	case param == nil:
+		reason = "nil probe param"
+	case param.Proj == ProbeBox && (param.Min[0] > param.Max[0] || param.Min[1] > param.Max[1] || param.Min[2] > param.Max[2]):
+		reason = "invalid probe box"
+	case param.Proj == ProbeSphere && param.Radius <= 0:
+		reason = "invalid probe radius"
+	case param.Proj != ProbeBox && param.Proj != ProbeSphere:
+		reason = "undefined probe projection"
+	case param.Blend < 0:
+		reason = "negative probe blend distance"
+	case r.probes.len() >= NProbe:
+		reason = "too many probes"
+	default:
+		goto validParam
+	}
+	return -1, newRendErr(reason)
+validParam:
+	if r.probeTex == nil {
+		var err error
+		r.probeTex, err = NewCubeTarget(&TexParam{
+			PixelFmt: probeFmt,
+			Dim3D:    driver.Dim3D{Width: ProbeSize, Height: ProbeSize},
+			Layers:   6 * NProbe,
+			Levels:   ComputeLevels(driver.Dim3D{Width: ProbeSize, Height: ProbeSize}),
+			Samples:  1,
+		})
+		if err != nil {
+			return -1, err
+		}
+	}
+	p := probe{param: *param, pending: allFaces}
+	for p.slot = range r.probeSlot {
+		if !r.probeSlot[p.slot] {
+			break
+		}
+	}
+	r.probeSlot[p.slot] = true
+	p.layout.SetPosition(&param.Position)
+	switch param.Proj {
+	case ProbeBox:
+		p.layout.SetProj(shader.ProbeBox)
+		p.layout.SetBox(&param.Min, &param.Max)
+	case ProbeSphere:
+		p.layout.SetProj(shader.ProbeSphere)
+		p.layout.SetRadius(param.Radius)
+	}
+	p.layout.SetLevels(r.probeTex.Levels())
+	return r.probes.insert(p), nil
+}
+
+// RemoveProbe removes p from r.
+func (r *Renderer) RemoveProbe(p Probe) {
+	r.probeSlot[r.probes.remove(p).slot] = false
+}
+
+// CaptureProbe schedules p to be captured again.
+// The previous capture will continue to be used
+// until the new one completes.
+func (r *Renderer) CaptureProbe(p Probe) { r.probes.get(p).pending = allFaces }
+
+// ProbesLen returns the number of probes in r.
+func (r *Renderer) ProbesLen() int { return r.probes.len() }
+
+// SetProbeBudget sets the maximum number of cube faces
+// that r captures per frame, across all probes.
+// Higher values make probes converge faster, at a
+// higher per-frame cost. The default is 6 (i.e., one
+// whole cubemap per frame).
+// faces is clamped to be at least 1.
+func (r *Renderer) SetProbeBudget(faces int) { r.probeBudget = max(1, faces) }
+
+// probeCapture describes a probe face to be captured.
+type probeCapture struct {
+	id   Probe
+	face int
+	view linear.M4
+	// Whether this is the last face of the probe
+	// pending capture, meaning that the cubemap's
+	// mip levels must be prefiltered after it
+	// completes.
+	last bool
+}
+
+// nextCaptures appends to dst the probe faces that
+// r must capture in the current frame, limited by the
+// probe budget.
+// Probes that are not real-time are given priority.
+// Real-time probes are serviced in a round-robin
+// fashion.
+func (r *Renderer) nextCaptures(dst []probeCapture) []probeCapture {
+	budget := r.probeBudget
+	if budget == 0 {
+		budget = 6
+	}
+	ents := r.probes.entries()
+	take := func(i int) {
+		p := &ents[i].data
+		for f := 0; f < 6 && budget > 0; f++ {
+			if p.pending&(1<<f) == 0 {
+				continue
+			}
+			p.pending &^= 1 << f
+			budget--
+			dst = append(dst, probeCapture{
+				id:   Probe(ents[i].id),
+				face: f,
+				view: probeView(&p.param.Position, f),
+				last: p.pending == 0,
+			})
+		}
+		if p.pending == 0 {
+			p.ready = true
+		}
+	}
+	for i := range ents {
+		if budget == 0 {
+			return dst
+		}
+		if !ents[i].data.param.Realtime && ents[i].data.pending != 0 {
+			take(i)
+		}
+	}
+	for n := len(ents); n > 0 && budget > 0; n-- {
+		i := r.probeNext % len(ents)
+		if p := &ents[i].data; p.param.Realtime {
+			if p.pending == 0 {
+				p.pending = allFaces
+			}
+			take(i)
+			if p.pending != 0 {
+				// Continue from this probe
+				// in the next frame.
+				break
+			}
+		}
+		r.probeNext = i + 1
+	}
+	return dst
+}
+
+// probeView returns the view transform used to capture
+// the given face of a cubemap whose center is at pos.
+// Faces are ordered +X, -X, +Y, -Y, +Z, -Z.
+// The capture must use a 90° field of view and an
+// aspect ratio of 1.
+func probeView(pos *linear.V3, face int) linear.M4 {
+	// Right, down and forward directions,
+	// as defined for cube map sampling.
+	basis := [6][3]linear.V3{
+		{{0, 0, -1}, {0, -1, 0}, {1, 0, 0}},
+		{{0, 0, 1}, {0, -1, 0}, {-1, 0, 0}},
+		{{1, 0, 0}, {0, 0, 1}, {0, 1, 0}},
+		{{1, 0, 0}, {0, 0, -1}, {0, -1, 0}},
+		{{1, 0, 0}, {0, -1, 0}, {0, 0, 1}},
+		{{-1, 0, 0}, {0, -1, 0}, {0, 0, -1}},
+	}
+	s, u, f := &basis[face][0], &basis[face][1], &basis[face][2]
+	return linear.M4{
+		{s[0], u[0], f[0]},
+		{s[1], u[1], f[1]},
+		{s[2], u[2], f[2]},
+		{-s.Dot(pos), -u.Dot(pos), -f.Dot(pos), 1},
+	}
+}
+
+// influence returns the weight of p at the world
+// position pos, in the [0, 1] interval.
+func (p *probe) influence(pos *linear.V3) float32 {
+	var d float32
+	switch p.param.Proj {
+	case ProbeBox:
+		d = pos[0] - p.param.Min[0]
+		for i := range pos {
+			d = min(d, pos[i]-p.param.Min[i], p.param.Max[i]-pos[i])
+		}
+	case ProbeSphere:
+		var v linear.V3
+		v.Sub(pos, &p.param.Position)
+		d = p.param.Radius - v.Len()
+	}
+	switch {
+	case d < 0:
+		return 0
+	case d >= p.param.Blend:
+		return 1
+	}
+	return d / p.param.Blend
+}
+
+// selectProbes selects the two probes with the highest
+// influence at the world position pos.
+// It returns their slots in r.probeTex, or
+// shader.NoProbe, and the blend weight of the first.
+func (r *Renderer) selectProbes(pos *linear.V3) (p0, p1 uint32, w float32) {
+	p0, p1 = shader.NoProbe, shader.NoProbe
+	var w0, w1 float32
+	for _, p := range r.probes.all() {
+		if !p.ready {
+			continue
+		}
+		switch x := p.influence(pos); {
+		case x <= w1:
+		case x > w0:
+			p1, w1 = p0, w0
+			p0, w0 = uint32(p.slot), x
+		default:
+			p1, w1 = uint32(p.slot), x
+		}
+	}
+	switch {
+	case p0 == shader.NoProbe:
+		return
+	case p1 == shader.NoProbe:
+		return p0, p1, 1
+	}
+	return p0, p1, w0 / (w0 + w1)
+}
+
+// setDrawableProbes selects the probes that affect
+// each drawable in r, based on their world positions.
+func (r *Renderer) setDrawableProbes() {
+	for _, d := range r.drawables.all() {
+		world := d.layout.World()
+		pos := linear.V3{world[3][0], world[3][1], world[3][2]}
+		d.layout.SetProbes(r.selectProbes(&pos))
+	}
+}
@@ -0,0 +1,277 @@
+// Copyright 2024 Gustavo C. Viegas. All rights reserved.
+
+package engine
+
+import (
+	"encoding/binary"
+	"io"
+	"math"
+	"sync/atomic"
+
+	"gviegas/neo3/driver"
+	"gviegas/neo3/engine/internal/shader"
+	"gviegas/neo3/linear"
+)
+
+// Whether NewMesh quantizes vertex data.
+var vertexQuant atomic.Bool
+
+// SetVertexQuantization sets whether meshes created
+// by subsequent NewMesh calls store vertex data in
+// quantized form.
+// It returns the previous setting.
+// Quantization is disabled by default.
+//
+// When enabled, the following semantics are stored
+// in 16-bit formats:
+//
+//	Position:
+//		driver.Unorm16x4, within the primitive's bounds
+//	Normal:
+//		driver.Snorm16x2, as octahedral coordinates
+//	TexCoord0,1:
+//		driver.Unorm16x2, within the data's range
+//
+// Shaders decode such data using the constants that
+// are set in the drawable layout for each primitive.
+// Meshes can opt out with MeshData.NoQuantize.
+func SetVertexQuantization(enable bool) bool { return vertexQuant.Swap(enable) }
+
+// quantSemantics is the set of semantics that can be
+// quantized.
+const quantSemantics = Position | Normal | TexCoord0 | TexCoord1
+
+// quantFormat returns the driver.VertexFmt that the
+// engine uses for storing s's quantized data.
+// s must be in quantSemantics.
+func (s Semantic) quantFormat() driver.VertexFmt {
+	switch s {
+	case Position:
+		return driver.Unorm16x4
+	case Normal:
+		return driver.Snorm16x2
+	case TexCoord0, TexCoord1:
+		return driver.Unorm16x2
+	}
+	panic("semantic cannot be quantized")
+}
+
+// vertexDecode holds the constants needed to decode
+// the quantized vertex data of a primitive.
+// A quantized value q in [0.0, 1.0] is decoded as
+// off + q * scale.
+type vertexDecode struct {
+	mask Semantic
+	// Position, TexCoord0 and TexCoord1, in this
+	// order. Texture coordinates use only the first
+	// two components.
+	off   [3]linear.V3
+	scale [3]linear.V3
+}
+
+// quantSlot returns the index into vertexDecode.off
+// and vertexDecode.scale for s.
+// It returns -1 if s needs no such constants.
+func quantSlot(s Semantic) int {
+	switch s {
+	case Position:
+		return 0
+	case TexCoord0:
+		return 1
+	case TexCoord1:
+		return 2
+	}
+	return -1
+}
+
+// setLayout sets the decode constants in l.
+func (d *vertexDecode) setLayout(l *shader.DrawableLayout) {
+	var flags uint32
+	for _, x := range [...]struct {
+		sem  Semantic
+		flag uint32
+	}{
+		{Position, shader.QuantPosition},
+		{Normal, shader.QuantNormal},
+		{TexCoord0, shader.QuantTexCoord0},
+		{TexCoord1, shader.QuantTexCoord1},
+	} {
+		if d.mask&x.sem != 0 {
+			flags |= x.flag
+		}
+	}
+	l.SetQuant(flags)
+	l.SetPosDecode(&d.off[0], &d.scale[0])
+	for i := range 2 {
+		off := [2]float32{d.off[1+i][0], d.off[1+i][1]}
+		scale := [2]float32{d.scale[1+i][0], d.scale[1+i][1]}
+		l.SetUVDecode(i, &off, &scale)
+	}
+}
+
+// decode sets in l the decode constants of the
+// primitive at index prim.
+// Primitives that are not quantized clear the
+// quantization flags.
+// prim must be in [0, m.Len()).
+func (m *Mesh) decode(prim int, l *shader.DrawableLayout) {
+	meshes.RLock()
+	defer meshes.RUnlock()
+	idx := m.primIdx
+	for i := 0; i < prim; i++ {
+		idx, _ = meshes.next(idx)
+	}
+	meshes.prims[idx].decode.setLayout(l)
+}
+
+// quantize returns an io.Reader that quantizes cnt
+// elements of s's data, read from src in the given
+// format, into s.quantFormat().
+// It also returns the decode constants of the data
+// and its bounds (both unused for Normal).
+// Computing the range of the data requires reading
+// it twice, so src is seeked back to its current
+// offset before the returned io.Reader is created.
+func (s Semantic) quantize(fmt driver.VertexFmt, src io.ReadSeeker, cnt int) (r io.Reader, off, scale, lo, hi linear.V3, err error) {
+	if r, err = s.conv(fmt, src, cnt); err != nil {
+		return
+	}
+	out := s.quantFormat().Size()
+	if s == Normal {
+		r = newConvReader(r, quantNormal, 12, out, cnt)
+		return
+	}
+	n := s.format().Components()
+	// s.conv reads src lazily, so this is
+	// where the data starts.
+	var start int64
+	if start, err = src.Seek(0, io.SeekCurrent); err != nil {
+		return
+	}
+	if lo, hi, err = attrBounds(r, n, cnt); err != nil {
+		return
+	}
+	if _, err = src.Seek(start, io.SeekStart); err != nil {
+		return
+	}
+	if r, err = s.conv(fmt, src, cnt); err != nil {
+		return
+	}
+	off = lo
+	scale.Sub(&hi, &lo)
+	var inv linear.V3
+	for i := range n {
+		if scale[i] > 0 {
+			inv[i] = 1 / scale[i]
+		}
+	}
+	elem := func(dst, src []byte) {
+		for i := range n {
+			v := math.Float32frombits(binary.NativeEndian.Uint32(src[i*4:]))
+			binary.NativeEndian.PutUint16(dst[i*2:], unorm16((v-off[i])*inv[i]))
+		}
+		if out > n*2 {
+			// Set w to 1.0.
+			binary.NativeEndian.PutUint16(dst[n*2:], ^uint16(0))
+		}
+	}
+	r = newConvReader(r, elem, n*4, out, cnt)
+	return
+}
+
+// attrBounds computes the per-component bounds of cnt
+// elements of n float32 components read from r.
+func attrBounds(r io.Reader, n, cnt int) (lo, hi linear.V3, err error) {
+	sz := n * 4
+	buf := make([]byte, min(cnt, convChunk)*sz)
+	for i := 0; cnt > 0; {
+		k := min(cnt, convChunk)
+		if _, err = io.ReadFull(r, buf[:k*sz]); err != nil {
+			return
+		}
+		for j := range k {
+			for c := range n {
+				v := math.Float32frombits(binary.NativeEndian.Uint32(buf[j*sz+c*4:]))
+				if i == 0 && j == 0 {
+					lo[c], hi[c] = v, v
+				} else {
+					lo[c] = min(lo[c], v)
+					hi[c] = max(hi[c], v)
+				}
+			}
+		}
+		i += k
+		cnt -= k
+	}
+	return
+}
+
+// quantNormal converts a driver.Float32x3 normal into
+// driver.Snorm16x2 octahedral coordinates.
+func quantNormal(dst, src []byte) {
+	var n linear.V3
+	for i := range n {
+		n[i] = math.Float32frombits(binary.NativeEndian.Uint32(src[i*4:]))
+	}
+	var e [2]float32
+	if n != (linear.V3{}) {
+		e = octEncode(&n)
+	}
+	binary.NativeEndian.PutUint16(dst, uint16(snorm16(e[0])))
+	binary.NativeEndian.PutUint16(dst[2:], uint16(snorm16(e[1])))
+}
+
+// unorm16 converts a value in [0.0, 1.0] into an
+// unsigned normalized 16-bit integer.
+func unorm16(v float32) uint16 {
+	return uint16(math.Round(float64(max(0, min(1, v)) * 65535)))
+}
+
+// snorm16 converts a value in [-1.0, 1.0] into a
+// signed normalized 16-bit integer.
+func snorm16(v float32) int16 {
+	return int16(math.Round(float64(max(-1, min(1, v)) * 32767)))
+}
+
+// readAttr reads back the data of p's semantic s from
+// buf, decoding it into s.format().
+// s.format() must have float32 components.
+// It returns nil if p does not have s.
+func (p *primitive) readAttr(buf []byte, s Semantic) []float32 {
+	if p.mask&s == 0 {
+		return nil
+	}
+	v := p.attr(s.I())
+	b := buf[v.byteStart():v.byteEnd()]
+	if p.decode.mask&s == 0 {
+		f := make([]float32, len(b)/4)
+		for i := range f {
+			f[i] = math.Float32frombits(binary.NativeEndian.Uint32(b[i*4:]))
+		}
+		return f
+	}
+	n := s.format().Components()
+	sz := v.format.Size()
+	cnt := len(b) / sz
+	f := make([]float32, 0, cnt*n)
+	if s == Normal {
+		for i := range cnt {
+			var e [2]float32
+			for j := range e {
+				e[j] = snorm(int32(int16(binary.NativeEndian.Uint16(b[i*sz+j*2:]))), 16)
+			}
+			n := octDecode(e)
+			f = append(f, n[:]...)
+		}
+		return f
+	}
+	slot := quantSlot(s)
+	off, scale := &p.decode.off[slot], &p.decode.scale[slot]
+	for i := range cnt {
+		for c := range n {
+			q := float32(binary.NativeEndian.Uint16(b[i*sz+c*2:])) / float32(^uint16(0))
+			f = append(f, off[c]+q*scale[c])
+		}
+	}
+	return f
+}
@@ -0,0 +1,218 @@
+// Copyright 2024 Gustavo C. Viegas. All rights reserved.
+
+package engine
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"math"
+	"testing"
+
+	"gviegas/neo3/driver"
+	"gviegas/neo3/engine/internal/shader"
+	"gviegas/neo3/linear"
+)
+
+func quantData(cnt int) (pos, norm, uv []float32) {
+	for i := range cnt {
+		x := float32(i)
+		pos = append(pos, x*0.5-10, float32(math.Sin(float64(x))), 3)
+		n := linear.V3{float32(math.Cos(float64(x))), float32(math.Sin(float64(x))), x/float32(cnt)*2 - 1}
+		n.Norm(&n)
+		norm = append(norm, n[:]...)
+		uv = append(uv, x/float32(cnt)*4-2, 1-x/float32(cnt))
+	}
+	return
+}
+
+func floatBytes(f []float32) []byte {
+	b := make([]byte, 0, len(f)*4)
+	for _, x := range f {
+		b = binary.LittleEndian.AppendUint32(b, math.Float32bits(x))
+	}
+	return b
+}
+
+func TestSemanticQuantize(t *testing.T) {
+	const cnt = convChunk + 31
+	pos, norm, uv := quantData(cnt)
+
+	for _, x := range [...]struct {
+		sem  Semantic
+		data []float32
+	}{
+		{Position, pos},
+		{TexCoord0, uv},
+		{TexCoord1, uv},
+		{Normal, norm},
+	} {
+		// Data that precedes the semantic's
+		// must not be read.
+		src := bytes.NewReader(append(make([]byte, 40), floatBytes(x.data)...))
+		src.Seek(40, io.SeekStart)
+		r, off, scale, lo, hi, err := x.sem.quantize(x.sem.format(), src, cnt)
+		if err != nil {
+			t.Fatalf("%s.quantize failed: %v", x.sem, err)
+		}
+		b, err := io.ReadAll(r)
+		if err != nil {
+			t.Fatalf("%s.quantize: ReadAll failed: %v", x.sem, err)
+		}
+		fmt := x.sem.quantFormat()
+		if n := cnt * fmt.Size(); len(b) != n {
+			t.Fatalf("%s.quantize: len(b)\nhave %d\nwant %d", x.sem, len(b), n)
+		}
+
+		p := primitive{mask: x.sem, decode: vertexDecode{mask: x.sem}}
+		p.attr(x.sem.I()).format = fmt
+		p.attr(x.sem.I()).span = span{0, (len(b) + spanBlock - 1) / spanBlock}
+		buf := make([]byte, p.attr(x.sem.I()).byteLen())
+		copy(buf, b)
+		n := x.sem.format().Components()
+		// Octahedral normals are compared by
+		// component.
+		tol := []float32{1e-3, 1e-3, 1e-3}
+		if slot := quantSlot(x.sem); slot >= 0 {
+			for i := range n {
+				l, h := x.data[i], x.data[i]
+				for j := i; j < len(x.data); j += n {
+					l, h = min(l, x.data[j]), max(h, x.data[j])
+				}
+				if lo[i] != l || hi[i] != h {
+					t.Fatalf("%s.quantize: bounds[%d]\nhave %v, %v\nwant %v, %v", x.sem, i, lo[i], hi[i], l, h)
+				}
+				if off[i] != l || scale[i] != h-l {
+					t.Fatalf("%s.quantize: decode[%d]\nhave %v, %v\nwant %v, %v", x.sem, i, off[i], scale[i], l, h-l)
+				}
+				// One step, to account for
+				// rounding errors.
+				tol[i] = (h - l) / 65535
+			}
+			p.decode.off[slot], p.decode.scale[slot] = off, scale
+		}
+		f := p.readAttr(buf, x.sem)
+		if len(f) < len(x.data) {
+			t.Fatalf("primitive.readAttr(%s): len\nhave %d\nwant >= %d", x.sem, len(f), len(x.data))
+		}
+		for i, v := range x.data {
+			if d := f[i] - v; d > tol[i%n] || d < -tol[i%n] {
+				t.Fatalf("primitive.readAttr(%s): [%d]\nhave %v\nwant %v", x.sem, i, f[i], v)
+			}
+		}
+	}
+}
+
+func TestQuantNormal(t *testing.T) {
+	var dst [4]byte
+	for _, n := range [...]linear.V3{
+		{0, 0, 1},
+		{0, 0, -1},
+		{1, 0, 0},
+		{0, -1, 0},
+		{0.5773, -0.5773, -0.5773},
+		{-0.7071, 0, 0.7071},
+	} {
+		var src [12]byte
+		for i, x := range n {
+			binary.NativeEndian.PutUint32(src[i*4:], math.Float32bits(x))
+		}
+		quantNormal(dst[:], src[:])
+		var e [2]float32
+		for i := range e {
+			e[i] = snorm(int32(int16(binary.NativeEndian.Uint16(dst[i*2:]))), 16)
+		}
+		var want linear.V3
+		want.Norm(&n)
+		have := octDecode(e)
+		var d linear.V3
+		d.Sub(&have, &want)
+		if d.Len() > 1e-3 {
+			t.Fatalf("quantNormal: %v\nhave %v\nwant %v", n, have, want)
+		}
+	}
+	// Zero-length normals must not produce NaNs.
+	quantNormal(dst[:], make([]byte, 12))
+	if dst != [4]byte{} {
+		t.Fatalf("quantNormal: zero normal\nhave %v\nwant %v", dst, [4]byte{})
+	}
+}
+
+func TestMeshQuantize(t *testing.T) {
+	defer func() {
+		b := setMeshBuffer(nil)
+		if b != nil {
+			b.Destroy()
+		}
+	}()
+	defer SetVertexQuantization(SetVertexQuantization(true))
+
+	const cnt = 300
+	pos, norm, uv := quantData(cnt)
+	newData := func() MeshData {
+		p := PrimitiveData{
+			Topology:     driver.TTriangle,
+			VertexCount:  cnt,
+			SemanticMask: Position | Normal | TexCoord0,
+		}
+		var srcs []io.ReadSeeker
+		for i, x := range [...]struct {
+			sem  Semantic
+			data []float32
+		}{
+			{Position, pos},
+			{Normal, norm},
+			{TexCoord0, uv},
+		} {
+			p.Semantics[x.sem.I()] = SemanticData{Format: x.sem.format(), Src: i}
+			srcs = append(srcs, bytes.NewReader(floatBytes(x.data)))
+		}
+		return MeshData{Primitives: []PrimitiveData{p}, Srcs: srcs}
+	}
+
+	d := newData()
+	m, err := NewMesh(&d)
+	if err != nil {
+		t.Fatalf("NewMesh failed: %v", err)
+	}
+	defer m.Free()
+	want := []driver.VertexIn{
+		{Format: driver.Unorm16x4, Stride: 8, Nr: Position.I()},
+		{Format: driver.Snorm16x2, Stride: 4, Nr: Normal.I()},
+		{Format: driver.Unorm16x2, Stride: 4, Nr: TexCoord0.I()},
+	}
+	for i, x := range m.inputs(0) {
+		if x != want[i] {
+			t.Fatalf("Mesh.inputs: quantized\nhave %v\nwant %v", x, want[i])
+		}
+	}
+	min, max := m.bounds(0)
+	if x, y := float32(-10), float32(cnt-1)*0.5-10; min[0] != x || max[0] != y || min[2] != 3 || max[2] != 3 {
+		t.Fatalf("Mesh.bounds: quantized\nhave %v, %v\nwant [%v _ 3], [%v _ 3]", min, max, x, y)
+	}
+	var l shader.DrawableLayout
+	m.decode(0, &l)
+	if x, y := l.Quant(), shader.QuantPosition|shader.QuantNormal|shader.QuantTexCoord0; x != y {
+		t.Fatalf("Mesh.decode: flags\nhave %d\nwant %d", x, y)
+	}
+	if off, _ := l.PosDecode(); off != min {
+		t.Fatalf("Mesh.decode: position offset\nhave %v\nwant %v", off, min)
+	}
+
+	d = newData()
+	d.NoQuantize = true
+	m2, err := NewMesh(&d)
+	if err != nil {
+		t.Fatalf("NewMesh failed: %v", err)
+	}
+	defer m2.Free()
+	for _, x := range m2.inputs(0) {
+		if f := Semantic(1 << x.Nr).format(); x.Format != f {
+			t.Fatalf("Mesh.inputs: NoQuantize\nhave %v\nwant %v", x.Format, f)
+		}
+	}
+	m2.decode(0, &l)
+	if x := l.Quant(); x != 0 {
+		t.Fatalf("Mesh.decode: NoQuantize flags\nhave %d\nwant 0", x)
+	}
+}
@@ -0,0 +1,217 @@
+// Copyright 2024 Gustavo C. Viegas. All rights reserved.
+
+package engine
+
+import (
+	"slices"
+
+	"gviegas/neo3/driver"
+	"gviegas/neo3/engine/internal/ctxt"
+)
+
+// Readback represents a copy of GPU data to the CPU
+// that was submitted for execution.
+// It is akin to a future: the data is available once
+// the copy completes.
+type Readback struct {
+	done chan struct{}
+	data []byte
+	err  error
+	// Ended when the copy completes.
+	span traceSpan
+}
+
+// Done returns whether rb has completed.
+func (rb *Readback) Done() bool {
+	select {
+	case <-rb.done:
+		return true
+	default:
+		return false
+	}
+}
+
+// Wait blocks until rb completes and returns the
+// data that was read, or the error of the copy's
+// execution.
+// The returned slice is owned by the caller.
+func (rb *Readback) Wait() ([]byte, error) {
+	<-rb.done
+	return rb.data, rb.err
+}
+
+// ReadBuffer copies size bytes of buf, starting at
+// offset off, to the CPU.
+// buf must have been created with driver.UCopySrc
+// usage. It need not be host visible.
+// A barrier makes the writes of every command
+// committed before the call (e.g., of compute
+// dispatches that produce histograms or statistics)
+// visible to the copy. The copy is committed
+// immediately, but ReadBuffer does not wait for it to
+// complete; call Wait on the returned Readback.
+// Invalid arguments are reported by Wait as well.
+func ReadBuffer(buf driver.Buffer, off, size int64) *Readback {
+	switch {
+	case buf == nil:
+		return failedReadback(newBufErr("nil buffer"))
+	case off < 0 || size < 1 || off+size > buf.Cap():
+		return failedReadback(newBufErr("read range out of bounds"))
+	}
+	return submitReadback(size, "buffer readback", func(cb driver.CmdBuffer, stg driver.Buffer) {
+		cb.Barrier([]driver.Barrier{{
+			SyncBefore:   driver.SAll,
+			SyncAfter:    driver.SCopy,
+			AccessBefore: driver.AWrite,
+			AccessAfter:  driver.ACopyRead,
+		}})
+		cb.CopyBuffer(&driver.BufferCopy{
+			From:    buf,
+			FromOff: off,
+			To:      stg,
+			Size:    size,
+		})
+	}, nil)
+}
+
+// ReadTarget copies a width×height region of the
+// first mip level of the given view of t, starting at
+// pixel x/y, to the CPU.
+// t must have been created with driver.UCopySrc usage
+// (as is the case for every NewTarget texture), must
+// not be multisample and the view must refer to a
+// single layer.
+// The data is tightly packed, row by row.
+// Like ReadBuffer, it makes prior writes visible to
+// the copy and does not wait for it to complete.
+// No other copies may target the view until the
+// returned Readback completes.
+func ReadTarget(t *Texture, view, x, y, width, height int) *Readback {
+	var reason string
+	switch {
+	case t == nil:
+		reason = "nil texture"
+	case t.usage&driver.UCopySrc == 0:
+		reason = "texture cannot be used as copy source"
+	case t.param.Samples != 1:
+		reason = "cannot read multisample texture"
+	case !t.IsValidView(view):
+		reason = "invalid view"
+	case t.ViewLayers(view) != 1:
+		reason = "view must refer to a single layer"
+	case x < 0 || y < 0 || width < 1 || height < 1 || x+width > t.param.Width || y+height > t.param.Height:
+		reason = "read region out of bounds"
+	case t.viewPending(view):
+		reason = "view has a pending copy"
+	default:
+		goto validParam
+	}
+	return failedReadback(newTexErr(reason))
+validParam:
+	// TODO: Handle depth/stencil formats.
+	il, _ := t.viewLayers(view)
+	size := int64(t.param.PixelFmt.Size() * width * height)
+	return submitReadback(size, "target readback", func(cb driver.CmdBuffer, stg driver.Buffer) {
+		before := []driver.Layout{t.setPending(il, 0)}
+		cb.Transition(mergeTransitions(before, il, 1, 0, 1, driver.Transition{
+			Barrier: driver.Barrier{
+				SyncBefore:   driver.SAll,
+				SyncAfter:    driver.SCopy,
+				AccessBefore: driver.AWrite,
+				AccessAfter:  driver.ACopyRead,
+			},
+			LayoutAfter: driver.LCopySrc,
+			Img:         t.views[view].Image(),
+		}))
+		cb.CopyImgToBuf(&driver.BufImgCopy{
+			Buf: stg,
+			// TODO: RowStrd must be 256-byte aligned.
+			RowStrd: width,
+			SlcStrd: height,
+			Img:     t.views[view].Image(),
+			ImgOff:  driver.Off3D{X: x, Y: y},
+			Layer:   il,
+			Level:   0,
+			Size:    driver.Dim3D{Width: width, Height: height},
+			Layers:  1,
+		})
+	}, func(failed bool) {
+		if failed {
+			t.unsetPending(il, 0, driver.LUndefined)
+		} else {
+			t.unsetPending(il, 0, driver.LCopySrc)
+		}
+	})
+}
+
+// failedReadback returns a completed Readback that
+// reports err.
+func failedReadback(err error) *Readback {
+	rb := &Readback{done: make(chan struct{}), err: err}
+	close(rb.done)
+	return rb
+}
+
+// submitReadback creates a host-visible staging buffer
+// of the given size, calls record to record the
+// commands that copy data into it and then commits
+// them.
+// The commands are committed to the same queue as
+// the frame work, so they execute after every command
+// committed previously.
+// If done is not nil and record was called, done is
+// called once the commands complete or fail to be
+// committed. failed indicates whether they did not
+// execute successfully.
+func submitReadback(size int64, name string, record func(cb driver.CmdBuffer, stg driver.Buffer), done func(failed bool)) *Readback {
+	stg, err := ctxt.GPU().NewBuffer(size, true, driver.UCopyDst)
+	if err != nil {
+		return failedReadback(err)
+	}
+	cb, err := cmdBufs.get()
+	if err != nil {
+		stg.Destroy()
+		return failedReadback(err)
+	}
+	recorded := false
+	if err = cb.Begin(); err == nil {
+		record(cb, stg)
+		recorded = true
+		err = cb.End()
+	}
+	rb := &Readback{done: make(chan struct{})}
+	ch := make(chan *driver.WorkItem, 1)
+	if err == nil {
+		rb.span = traceBegin(traceSubmit, name)
+		err = ctxt.GPU().Commit(&driver.WorkItem{Work: []driver.CmdBuffer{cb}}, ch)
+	}
+	if err != nil {
+		cb.Reset()
+		cmdBufs.put(cb)
+		stg.Destroy()
+		if recorded && done != nil {
+			done(true)
+		}
+		return failedReadback(err)
+	}
+	go rb.finish(stg, size, ch, done)
+	return rb
+}
+
+// finish waits for the commit of rb to complete and
+// then copies the data out of stg, which is
+// destroyed.
+// done, if not nil, is called before rb completes.
+func (rb *Readback) finish(stg driver.Buffer, size int64, ch chan *driver.WorkItem, done func(failed bool)) {
+	wk := <-ch
+	rb.span.endGPU()
+	if rb.err = wk.Err; rb.err == nil {
+		rb.data = slices.Clone(stg.Bytes()[:size])
+	}
+	cmdBufs.put(wk.Work...)
+	stg.Destroy()
+	if done != nil {
+		done(rb.err != nil)
+	}
+	close(rb.done)
+}
@@ -0,0 +1,118 @@
+// Copyright 2024 Gustavo C. Viegas. All rights reserved.
+
+package engine
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"gviegas/neo3/driver"
+	"gviegas/neo3/engine/internal/ctxt"
+)
+
+func TestReadBuffer(t *testing.T) {
+	const n = 3000
+	buf, err := ctxt.GPU().NewBuffer(n, false, driver.UCopySrc|driver.UCopyDst)
+	if err != nil {
+		t.Fatalf("GPU.NewBuffer failed:\n%v", err)
+	}
+	defer buf.Destroy()
+	data := []byte(strings.Repeat("readback", n/8))
+	if err := copyToBuffer(buf, 0, data, true); err != nil {
+		t.Fatalf("copyToBuffer failed:\n%v", err)
+	}
+
+	for _, x := range [...]struct{ off, size int64 }{
+		{0, n},
+		{0, 1},
+		{n - 1, 1},
+		{100, 1234},
+	} {
+		rb := ReadBuffer(buf, x.off, x.size)
+		b, err := rb.Wait()
+		if err != nil {
+			t.Fatalf("ReadBuffer(%d, %d): Readback.Wait failed:\n%v", x.off, x.size, err)
+		}
+		if !rb.Done() {
+			t.Fatal("Readback.Done: should be true after Wait")
+		}
+		if want := data[x.off : x.off+x.size]; string(b) != string(want) {
+			t.Fatalf("ReadBuffer(%d, %d):\nhave %q\nwant %q", x.off, x.size, b, want)
+		}
+	}
+
+	for _, x := range [...]struct{ off, size int64 }{
+		{-1, 1},
+		{0, 0},
+		{0, n + 1},
+		{n, 1},
+	} {
+		if _, err := ReadBuffer(buf, x.off, x.size).Wait(); !errors.Is(err, ErrInvalidParam) {
+			t.Fatalf("ReadBuffer(%d, %d):\nhave %v\nwant %v", x.off, x.size, err, ErrInvalidParam)
+		}
+	}
+	if _, err := ReadBuffer(nil, 0, 1).Wait(); !errors.Is(err, ErrInvalidParam) {
+		t.Fatalf("ReadBuffer(nil):\nhave %v\nwant %v", err, ErrInvalidParam)
+	}
+}
+
+func TestReadTarget(t *testing.T) {
+	const w, h = 32, 16
+	tex, err := NewTarget(&TexParam{
+		PixelFmt: driver.RGBA8Unorm,
+		Dim3D:    driver.Dim3D{Width: w, Height: h},
+		Layers:   1,
+		Levels:   1,
+		Samples:  1,
+	})
+	if err != nil {
+		t.Fatalf("NewTarget failed:\n%v", err)
+	}
+	defer tex.Free()
+	data := make([]byte, w*h*4)
+	for i := range data {
+		data[i] = byte(i * 7)
+	}
+	if err := tex.CopyToView(0, data, true); err != nil {
+		t.Fatalf("Texture.CopyToView failed:\n%v", err)
+	}
+
+	for _, x := range [...]struct{ x, y, w, h int }{
+		{0, 0, w, h},
+		{0, 0, 1, 1},
+		{w - 1, h - 1, 1, 1},
+		{5, 3, 10, 7},
+	} {
+		b, err := ReadTarget(tex, 0, x.x, x.y, x.w, x.h).Wait()
+		if err != nil {
+			t.Fatalf("ReadTarget(%+v): Readback.Wait failed:\n%v", x, err)
+		}
+		for y := range x.h {
+			row := b[y*x.w*4 : (y+1)*x.w*4]
+			off := ((x.y+y)*w + x.x) * 4
+			if want := data[off : off+x.w*4]; string(row) != string(want) {
+				t.Fatalf("ReadTarget(%+v): row %d\nhave %v\nwant %v", x, y, row, want)
+			}
+		}
+	}
+	// Reading leaves the view usable.
+	if err := tex.CopyToView(0, data, true); err != nil {
+		t.Fatalf("Texture.CopyToView failed after ReadTarget:\n%v", err)
+	}
+
+	for _, x := range [...]struct{ view, x, y, w, h int }{
+		{1, 0, 0, 1, 1},
+		{0, -1, 0, 1, 1},
+		{0, 0, 0, 0, 1},
+		{0, 0, 0, w + 1, 1},
+		{0, 0, h, 1, 1},
+	} {
+		if _, err := ReadTarget(tex, x.view, x.x, x.y, x.w, x.h).Wait(); !errors.Is(err, ErrInvalidParam) {
+			t.Fatalf("ReadTarget(%+v):\nhave %v\nwant %v", x, err, ErrInvalidParam)
+		}
+	}
+	if _, err := ReadTarget(nil, 0, 0, 0, 1, 1).Wait(); !errors.Is(err, ErrInvalidParam) {
+		t.Fatalf("ReadTarget(nil):\nhave %v\nwant %v", err, ErrInvalidParam)
+	}
+}
@@ -0,0 +1,340 @@
+// Copyright 2024 Gustavo C. Viegas. All rights reserved.
+
+package engine
+
+import (
+	"errors"
+	"path/filepath"
+	"slices"
+	"sync"
+
+	"gviegas/neo3/watch"
+)
+
+const reloadPrefix = "reloader: "
+
+// Resources replaced by Reload calls or released by
+// GCOwnership cleanups, which may still be in use by
+// frames in flight.
+var retired = struct {
+	sync.Mutex
+	list []retiredRes
+}{}
+
+// retiredRes is a retired resource.
+type retiredRes struct {
+	free func()
+	// Number of Presenter.BeginFrame calls
+	// after which free can be called.
+	frames int
+}
+
+// retire arranges for free to be called once every
+// frame that is currently in flight (including the one
+// being recorded, if any) completes execution.
+func retire(free func()) {
+	retired.Lock()
+	retired.list = append(retired.list, retiredRes{free, NFrame})
+	retired.Unlock()
+}
+
+// retireFrame is called by Presenter.BeginFrame after
+// it waits for the oldest frame in flight.
+// It frees the retired resources that can no longer
+// be in use.
+func retireFrame() {
+	retired.Lock()
+	defer retired.Unlock()
+	n := 0
+	for _, x := range retired.list {
+		if x.frames--; x.frames > 0 {
+			retired.list[n] = x
+			n++
+			continue
+		}
+		x.free()
+	}
+	clear(retired.list[n:])
+	retired.list = retired.list[:n]
+}
+
+// FreeRetired frees every resource that was replaced
+// by a Reload call, or that became unreachable under
+// GCOwnership, and has not been freed yet.
+// Retired resources are freed automatically by
+// Presenter.BeginFrame, so this is only needed when
+// rendering without a Presenter. The caller must
+// ensure that no pending GPU work uses them.
+func FreeRetired() {
+	retired.Lock()
+	defer retired.Unlock()
+	for _, x := range retired.list {
+		x.free()
+	}
+	clear(retired.list)
+	retired.list = retired.list[:0]
+}
+
+// dropRetired discards every retired resource without
+// freeing it.
+// It is called on device recovery.
+func dropRetired() {
+	retired.Lock()
+	clear(retired.list)
+	retired.list = retired.list[:0]
+	retired.Unlock()
+}
+
+// Reload replaces the image of t with that of src, so
+// that the contents of an asset can be updated while
+// every reference to t (including its TextureID)
+// remains valid.
+// src must have been created in the same way as t
+// (i.e., same usage and number of views), but its
+// size and format may differ. src is invalidated by
+// the call.
+// The previous image of t is destroyed once the frames
+// in flight complete (see FreeRetired).
+// As with Free, there must be no pending copies
+// targeting either texture. Reload must not be called
+// while a Renderer that uses t is rendering.
+func (t *Texture) Reload(src *Texture) error {
+	var reason string
+	switch {
+	case src == nil:
+		reason = "nil Texture in call to Reload"
+	case src == t:
+		reason = "Reload called with the same Texture"
+	case len(t.views) == 0 || len(src.views) == 0:
+		reason = "Reload called with invalid Texture"
+	case t.usage != src.usage:
+		reason = "Reload called with Texture of different usage"
+	case len(t.views) != len(src.views):
+		reason = "Reload called with Texture of different view count"
+	default:
+		goto validParam
+	}
+	return newTexErr(reason)
+validParam:
+	t.cleanup.Stop()
+	src.cleanup.Stop()
+	retire(texRes{t.views, t.cache}.free)
+	textureIDs.release((*uint64)(&src.id))
+	id := t.id
+	*t = *src
+	t.id = id
+	t.cleanup = addCleanup(t, texRes.free, texRes{t.views, t.cache})
+	*src = Texture{}
+	return nil
+}
+
+// Reload replaces the primitives of m with those of
+// src, so that the contents of an asset can be updated
+// while every reference to m (including its MeshID)
+// remains valid.
+// src is invalidated by the call.
+// The previous primitives of m are freed once the
+// frames in flight complete (see FreeRetired).
+// Reload must not be called while a Renderer that
+// uses m is rendering.
+func (m *Mesh) Reload(src *Mesh) error {
+	var reason string
+	switch {
+	case src == nil:
+		reason = "nil Mesh in call to Reload"
+	case src == m:
+		reason = "Reload called with the same Mesh"
+	case m.primLen < 1 || src.primLen < 1:
+		reason = "Reload called with invalid Mesh"
+	default:
+		goto validParam
+	}
+	return newMeshErr(reason)
+validParam:
+	m.cleanup.Stop()
+	src.cleanup.Stop()
+	prim := m.primIdx
+	retire(func() { freePrims(prim) })
+	meshIDs.release((*uint64)(&src.id))
+	m.bufIdx = src.bufIdx
+	m.primIdx = src.primIdx
+	m.primLen = src.primLen
+	m.cleanup = addCleanup(m, freePrims, m.primIdx)
+	*src = Mesh{}
+	return nil
+}
+
+// Reloader reloads Textures and Meshes when the files
+// that they were loaded from change.
+// Changes are detected by a watch.Watcher, while the
+// reloads themselves are performed by Apply, which
+// must be called when no Renderer that uses these
+// resources is rendering (e.g., before
+// Presenter.BeginFrame).
+// Reloader methods are safe for concurrent use.
+type Reloader struct {
+	w *watch.Watcher
+
+	mu sync.Mutex
+	// Reload functions of watched files,
+	// keyed by path.
+	files map[string]func(path string) error
+	// Files that changed since the last
+	// Apply call.
+	changed map[string]bool
+	// Errors reported by w since the last
+	// Apply call.
+	errs []error
+
+	done chan struct{}
+}
+
+// NewReloader creates a new Reloader.
+func NewReloader() (*Reloader, error) {
+	w, err := watch.New(watch.DefaultDebounce)
+	if err != nil {
+		return nil, err
+	}
+	r := &Reloader{
+		w:       w,
+		files:   make(map[string]func(string) error),
+		changed: make(map[string]bool),
+		done:    make(chan struct{}),
+	}
+	go r.run()
+	return r, nil
+}
+
+// run records the changes that r.w reports until r.w
+// is closed.
+func (r *Reloader) run() {
+	defer close(r.done)
+	evs, errs := r.w.Events(), r.w.Errors()
+	for {
+		select {
+		case batch, ok := <-evs:
+			if !ok {
+				return
+			}
+			r.mu.Lock()
+			for _, ev := range batch {
+				// Files that are saved by moving a
+				// new file over the old one are
+				// reported as created.
+				if ev.Op&(watch.Create|watch.Write) == 0 {
+					continue
+				}
+				if _, ok := r.files[ev.Path]; ok {
+					r.changed[ev.Path] = true
+				}
+			}
+			r.mu.Unlock()
+		case err := <-errs:
+			r.mu.Lock()
+			r.errs = append(r.errs, err)
+			r.mu.Unlock()
+		}
+	}
+}
+
+// WatchTexture starts watching the file at path.
+// When it changes, Apply calls load with path and
+// replaces the image of t with that of the returned
+// Texture (see Texture.Reload).
+func (r *Reloader) WatchTexture(t *Texture, path string, load func(path string) (*Texture, error)) error {
+	if t == nil || load == nil {
+		return newErr(reloadPrefix, "nil Texture or load function in call to WatchTexture", ErrInvalidParam)
+	}
+	return r.watch(path, func(path string) error {
+		src, err := load(path)
+		if err != nil {
+			return err
+		}
+		if err = t.Reload(src); err != nil {
+			src.Free()
+		}
+		return err
+	})
+}
+
+// WatchMesh starts watching the file at path.
+// When it changes, Apply calls load with path and
+// replaces the primitives of m with those of the
+// returned Mesh (see Mesh.Reload).
+func (r *Reloader) WatchMesh(m *Mesh, path string, load func(path string) (*Mesh, error)) error {
+	if m == nil || load == nil {
+		return newErr(reloadPrefix, "nil Mesh or load function in call to WatchMesh", ErrInvalidParam)
+	}
+	return r.watch(path, func(path string) error {
+		src, err := load(path)
+		if err != nil {
+			return err
+		}
+		if err = m.Reload(src); err != nil {
+			src.Free()
+		}
+		return err
+	})
+}
+
+// watch starts watching the file at path, which is
+// reloaded by calling reload.
+// It replaces any previous reload function of path.
+func (r *Reloader) watch(path string, reload func(string) error) error {
+	path = filepath.Clean(path)
+	if err := r.w.Add(path); err != nil {
+		return err
+	}
+	r.mu.Lock()
+	r.files[path] = reload
+	r.mu.Unlock()
+	return nil
+}
+
+// Unwatch stops watching the file at path.
+// Pending changes to the file are discarded.
+func (r *Reloader) Unwatch(path string) error {
+	path = filepath.Clean(path)
+	r.mu.Lock()
+	delete(r.files, path)
+	delete(r.changed, path)
+	r.mu.Unlock()
+	return r.w.Remove(path)
+}
+
+// Apply reloads the resources whose files changed
+// since the previous call, in path order.
+// It returns the errors that occurred while loading
+// or reloading them, along with those reported by
+// the watch.Watcher, if any.
+// A resource that fails to reload is left unchanged.
+func (r *Reloader) Apply() error {
+	r.mu.Lock()
+	paths := make([]string, 0, len(r.changed))
+	for path := range r.changed {
+		paths = append(paths, path)
+	}
+	slices.Sort(paths)
+	reload := make([]func(string) error, len(paths))
+	for i, path := range paths {
+		reload[i] = r.files[path]
+	}
+	clear(r.changed)
+	errs := r.errs
+	r.errs = nil
+	r.mu.Unlock()
+	for i, path := range paths {
+		if err := reload[i](path); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Close stops watching every file.
+// It does not free the watched resources.
+func (r *Reloader) Close() error {
+	err := r.w.Close()
+	<-r.done
+	return err
+}
@@ -0,0 +1,211 @@
+// Copyright 2024 Gustavo C. Viegas. All rights reserved.
+
+package engine
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"gviegas/neo3/driver"
+)
+
+func TestRetire(t *testing.T) {
+	FreeRetired()
+	var n [2]int
+	retire(func() { n[0]++ })
+	for i := range NFrame - 1 {
+		retireFrame()
+		if n[0] != 0 {
+			t.Fatalf("retireFrame: freed after %d frame(s)\nhave %d\nwant 0", i+1, n[0])
+		}
+		if i == 0 {
+			retire(func() { n[1]++ })
+		}
+	}
+	retireFrame()
+	if n[0] != 1 || n[1] != 0 {
+		t.Fatalf("retireFrame: after %d frames\nhave %v\nwant [1 0]", NFrame, n)
+	}
+	FreeRetired()
+	if n[1] != 1 || len(retired.list) != 0 {
+		t.Fatalf("FreeRetired:\nhave %v, %d retired\nwant [1 1], 0 retired", n, len(retired.list))
+	}
+	retireFrame()
+	if n != [2]int{1, 1} {
+		t.Fatalf("retireFrame: freed twice\nhave %v\nwant [1 1]", n)
+	}
+}
+
+func TestTextureReload(t *testing.T) {
+	param := TexParam{
+		PixelFmt: driver.RGBA8Unorm,
+		Dim3D:    driver.Dim3D{Width: 64, Height: 64},
+		Layers:   1,
+		Levels:   1,
+		Samples:  1,
+	}
+	tex, err := New2D(&param)
+	if err != nil {
+		t.Fatalf("New2D failed:\n%v", err)
+	}
+	defer tex.Free()
+	param.Dim3D = driver.Dim3D{Width: 128, Height: 32}
+	param.Levels = 2
+	src, err := New2D(&param)
+	if err != nil {
+		t.Fatalf("New2D failed:\n%v", err)
+	}
+	id := tex.ID()
+	src.ID()
+	img := tex.views[0].Image()
+
+	if err := tex.Reload(tex); err == nil {
+		t.Fatal("Texture.Reload: same Texture: unexpected nil error")
+	}
+	tgt, err := NewTarget(&param)
+	if err != nil {
+		t.Fatalf("NewTarget failed:\n%v", err)
+	}
+	if err := tex.Reload(tgt); err == nil {
+		t.Fatal("Texture.Reload: different usage: unexpected nil error")
+	}
+	tgt.Free()
+
+	if err := tex.Reload(src); err != nil {
+		t.Fatalf("Texture.Reload failed:\n%v", err)
+	}
+	if x := id.Texture(); x != tex {
+		t.Fatalf("Texture.Reload: TextureID.Texture\nhave %p\nwant %p", x, tex)
+	}
+	if tex.Width() != 128 || tex.Height() != 32 || tex.Levels() != 2 {
+		t.Fatalf("Texture.Reload: size\nhave %dx%d, %d levels\nwant 128x32, 2 levels", tex.Width(), tex.Height(), tex.Levels())
+	}
+	if tex.views[0].Image() == img {
+		t.Fatal("Texture.Reload: image should have been replaced")
+	}
+	if len(src.views) != 0 || src.id != 0 {
+		t.Fatal("Texture.Reload: src should have been invalidated")
+	}
+	if len(retired.list) == 0 {
+		t.Fatal("Texture.Reload: previous image should have been retired")
+	}
+	FreeRetired()
+}
+
+func TestMeshReload(t *testing.T) {
+	d := dummyData1(12)
+	mesh, err := NewMesh(&d)
+	if err != nil {
+		t.Fatalf("NewMesh failed:\n%v", err)
+	}
+	defer mesh.Free()
+	d = dummyData2(24)
+	src, err := NewMesh(&d)
+	if err != nil {
+		t.Fatalf("NewMesh failed:\n%v", err)
+	}
+	id := mesh.ID()
+	prim, n := src.primIdx, src.Len()
+
+	if err := mesh.Reload(&Mesh{}); err == nil {
+		t.Fatal("Mesh.Reload: invalid Mesh: unexpected nil error")
+	}
+	if err := mesh.Reload(src); err != nil {
+		t.Fatalf("Mesh.Reload failed:\n%v", err)
+	}
+	if x := id.Mesh(); x != mesh {
+		t.Fatalf("Mesh.Reload: MeshID.Mesh\nhave %p\nwant %p", x, mesh)
+	}
+	if mesh.primIdx != prim || mesh.Len() != n {
+		t.Fatalf("Mesh.Reload: primitives\nhave %d, %d\nwant %d, %d", mesh.primIdx, mesh.Len(), prim, n)
+	}
+	if src.Len() != 0 {
+		t.Fatal("Mesh.Reload: src should have been invalidated")
+	}
+	FreeRetired()
+}
+
+func TestReloader(t *testing.T) {
+	param := TexParam{
+		PixelFmt: driver.RGBA8Unorm,
+		Dim3D:    driver.Dim3D{Width: 16, Height: 16},
+		Layers:   1,
+		Levels:   1,
+		Samples:  1,
+	}
+	tex, err := New2D(&param)
+	if err != nil {
+		t.Fatalf("New2D failed:\n%v", err)
+	}
+	defer tex.Free()
+	r, err := NewReloader()
+	if err != nil {
+		t.Fatalf("NewReloader failed:\n%v", err)
+	}
+	defer r.Close()
+	if err := r.WatchTexture(nil, "x", nil); err == nil {
+		t.Fatal("Reloader.WatchTexture: unexpected success with nil Texture")
+	}
+
+	path := filepath.Join(t.TempDir(), "tex")
+	errLoad := errors.New("load failed")
+	var loads int
+	var fail bool
+	load := func(p string) (*Texture, error) {
+		if p != path {
+			t.Fatalf("Reloader.Apply: load path\nhave %q\nwant %q", p, path)
+		}
+		loads++
+		if fail {
+			return nil, errLoad
+		}
+		param.Dim3D.Width *= 2
+		return New2D(&param)
+	}
+	if err := r.WatchTexture(tex, path, load); err != nil {
+		t.Fatalf("Reloader.WatchTexture failed:\n%v", err)
+	}
+	// apply writes to the file and calls Apply
+	// until it reloads.
+	apply := func() error {
+		t.Helper()
+		if err := os.WriteFile(path, []byte{byte(loads)}, 0o644); err != nil {
+			t.Fatalf("os.WriteFile failed:\n%v", err)
+		}
+		n := loads
+		for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); {
+			time.Sleep(20 * time.Millisecond)
+			err := r.Apply()
+			if loads != n {
+				return err
+			}
+		}
+		t.Fatal("Reloader.Apply: file change not observed")
+		return nil
+	}
+
+	if err := apply(); err != nil {
+		t.Fatalf("Reloader.Apply failed:\n%v", err)
+	}
+	if loads != 1 || tex.Width() != 32 {
+		t.Fatalf("Reloader.Apply:\nhave %d load(s), width %d\nwant 1, 32", loads, tex.Width())
+	}
+	fail = true
+	if err := apply(); !errors.Is(err, errLoad) {
+		t.Fatalf("Reloader.Apply:\nhave %v\nwant %v", err, errLoad)
+	}
+	if tex.Width() != 32 {
+		t.Fatalf("Reloader.Apply: failed reload changed width to %d", tex.Width())
+	}
+	FreeRetired()
+
+	if err := r.Unwatch(path); err != nil {
+		t.Fatalf("Reloader.Unwatch failed:\n%v", err)
+	}
+	if err := r.Unwatch(path); err == nil {
+		t.Fatal("Reloader.Unwatch: unexpected success with unwatched path")
+	}
+}
@@ -3,17 +3,18 @@
 package engine
 
 import (
-	"errors"
 	"iter"
+	"slices"
 
 	"gviegas/neo3/driver"
 	"gviegas/neo3/engine/internal/ctxt"
+	"gviegas/neo3/engine/internal/shader"
 	"gviegas/neo3/wsi"
 )
 
 const rendPrefix = "renderer: "
 
-func newRendErr(reason string) error { return errors.New(rendPrefix + reason) }
+func newRendErr(reason string) error { return newErr(rendPrefix, reason, ErrInvalidParam) }
 
 // Renderer is a real-time renderer.
 // Onscreen and Offscreen embed a Renderer
@@ -22,6 +23,12 @@ func newRendErr(reason string) error { return errors.New(rendPrefix + reason) }
 type Renderer struct {
 	cb [NFrame]driver.CmdBuffer
 	ch chan *driver.WorkItem
+	// Command buffers used by render when
+	// the frame graph has async passes,
+	// created on demand: one for async
+	// compute work and one for the passes
+	// that wait on it.
+	acb [2]driver.CmdBuffer
 
 	lights [NLight]Light
 	nlight int
@@ -30,9 +37,97 @@ type Renderer struct {
 
 	drawables drawableMap
 
+	decals   decalMap
+	decalSeq int64
+	decalExp []Decal
+
+	foliage foliageMap
+
+	imposters imposterMap
+
+	// Reflection probes. Their cubemaps are
+	// layers of probeTex.
+	probes      probeMap
+	probeTex    *Texture
+	probeSlot   [NProbe]bool
+	probeNext   int
+	probeBudget int
+	// Irradiance probe grid.
+	ddgi *ddgi
+
 	hdr *Texture
 	ds  *Texture
 
+	// Cameras and the areas of the render
+	// target that they draw to.
+	vports    viewportMap
+	vportPost int
+	curVport  Viewport
+	// Stage of the pass being recorded.
+	curStage int
+	// Viewports sorted by layer.
+	vportOrder []Viewport
+
+	// Passes executed every frame, in order.
+	graph frameGraph
+	ssr   *ssr
+	fog   *fog
+	cam   *camera
+	// Velocity buffer and motion blur.
+	// The former is created on demand.
+	vel    *Texture
+	motion *motion
+
+	// Object ID target, created by SetPicking.
+	ids *Texture
+
+	// Transparency mode and, for TranspOIT,
+	// the accumulation/revealage targets.
+	transp int
+	oit    [2]*Texture
+
+	// Automatic exposure and color grading,
+	// the latter applied last.
+	expo  *exposure
+	grade *grade
+
+	// Dynamic resolution. If set, the scene
+	// is rendered to a scaled area of hdr/ds.
+	dynRes *dynRes
+
+	// Multi-sample targets, resolved to
+	// hdr/ds. Set by SetMSAA.
+	msaa *msaa
+
+	// Reserved stencil bits, and the
+	// selection outline that uses one of
+	// them.
+	stencil uint8
+	outline *outline
+
+	// Portals and mirrors, and how many
+	// can be seen through one another.
+	portals     portalMap
+	portalDepth int
+
+	// Editing gizmo, drawn last.
+	gizmo *Gizmo
+
+	// Console drawn over the final image
+	// while open, and the console variables
+	// registered by RegisterCVars.
+	console *Console
+	cvars   []*cvar
+
+	// Scale of text and UI drawn by passes
+	// of stageFinal.
+	uiScale float32
+
+	// Debug view, drawn by an alternate
+	// pipeline if not DebugNone.
+	debug       int
+	debugLayout shader.DebugLayout
+
 	// TODO: Post-processing data.
 }
 
@@ -46,7 +141,7 @@ func (r *Renderer) init(width, height int) (err error) {
 		}
 	}()
 	for i := range r.cb {
-		r.cb[i], err = ctxt.GPU().NewCmdBuffer()
+		r.cb[i], err = cmdBufs.get()
 		if err != nil {
 			return
 		}
@@ -61,9 +156,9 @@ func (r *Renderer) init(width, height int) (err error) {
 	for i := range r.lights {
 		r.lights[i].layout.SetUnused(true)
 	}
+	r.curVport = -1
+	r.uiScale = 1
 	// TODO: Initialize r.drawables.
-	// TODO: Customizable sample count.
-	// TODO: Choose a better DS format if available.
 	r.hdr, err = NewTarget(&TexParam{
 		PixelFmt: driver.RGBA16Float,
 		Dim3D: driver.Dim3D{
@@ -72,24 +167,36 @@ func (r *Renderer) init(width, height int) (err error) {
 		},
 		Layers:  1,
 		Levels:  1,
-		Samples: 4,
+		Samples: 1,
 	})
 	if err != nil {
 		return
 	}
-	r.ds, err = NewTarget(&TexParam{
-		PixelFmt: driver.D16Unorm,
-		Dim3D: driver.Dim3D{
-			Width:  width,
-			Height: height,
-		},
-		Layers:  1,
-		Levels:  1,
-		Samples: 4,
-	})
-	//if err != nil {
-	//	return
-	//}
+	// Depth is not needed after rendering, so the
+	// DS target need not be backed by memory.
+	// Not every stencil format is supported by
+	// every driver, so try them in order.
+	for _, pf := range stencilFmts {
+		r.ds, err = NewTransient(&TexParam{
+			PixelFmt: pf,
+			Dim3D: driver.Dim3D{
+				Width:  width,
+				Height: height,
+			},
+			Layers:  1,
+			Levels:  1,
+			Samples: 1,
+		})
+		if err == nil {
+			break
+		}
+	}
+	if err != nil {
+		return
+	}
+	// Decals are always available.
+	r.stencil = StencilDecal
+	err = r.SetMSAA(CurrentTuning().MSAA)
 	return
 }
 
@@ -165,43 +272,148 @@ func (r *Renderer) free() {
 	for range cap(r.ch) {
 		<-r.ch
 	}
-	for _, cb := range r.cb {
-		cb.Destroy()
+	cmdBufs.put(r.cb[:]...)
+	for _, cb := range r.acb {
+		if cb != nil {
+			cb.Destroy()
+		}
 	}
 	// TODO: Deinitialize r.drawables.
 	r.hdr.Free()
 	r.ds.Free()
+	r.freeOIT()
+	r.freeSSR()
+	r.freeFog()
+	r.freeDDGI()
+	r.freeDoF()
+	r.freeMotion()
+	r.freeDynRes()
+	r.msaa.free()
+	r.freeOutline()
+	if r.vel != nil {
+		r.vel.Free()
+	}
+	r.freeObjectID()
+	r.freeExposure()
+	r.freeImposters()
+	if r.probeTex != nil {
+		r.probeTex.Free()
+	}
+	removeCVars(r.cvars...)
 	*r = Renderer{}
 }
 
+// render executes r's frame graph, compiling it
+// first if it changed.
+// If the graph has passes that execute
+// asynchronously, it is recorded by executeAsync
+// into three work items, so that these passes
+// overlap with rendering if the GPU supports
+// driver.Features.AsyncCompute. Otherwise, it is
+// recorded into a single work item.
+// It blocks until the commands complete execution.
+func (r *Renderer) render() (err error) {
+	g := &r.graph
+	if len(g.trans) != len(g.nodes) {
+		if err = g.compile(); err != nil {
+			return
+		}
+	}
+	frame := <-r.ch
+	defer func() { r.ch <- frame }()
+	wk := [3]*driver.WorkItem{nil, frame, nil}
+	if slices.Contains(g.async, true) {
+		for i, create := range [2]func() (driver.CmdBuffer, error){newComputeCmdBuffer, ctxt.GPU().NewCmdBuffer} {
+			if r.acb[i] == nil {
+				if r.acb[i], err = create(); err != nil {
+					return
+				}
+			}
+		}
+		wk[0] = &driver.WorkItem{Work: []driver.CmdBuffer{r.acb[0]}}
+		wk[2] = &driver.WorkItem{Work: []driver.CmdBuffer{r.acb[1]}}
+	}
+	// Command buffers that are not committed
+	// must be reset before they can be begun
+	// again.
+	var committed [3]bool
+	defer func() {
+		for i, x := range wk {
+			if x != nil && !committed[i] {
+				x.Work[0].Reset()
+			}
+		}
+	}()
+	for _, x := range wk {
+		if x == nil {
+			continue
+		}
+		if err = x.Work[0].Begin(); err != nil {
+			return
+		}
+	}
+	if wk[0] == nil {
+		frame.Compute, frame.WaitCompute = false, false
+		g.execute(r, frame.Work[0])
+	} else {
+		g.executeAsync(r, &wk)
+	}
+	for _, x := range wk {
+		if x == nil {
+			continue
+		}
+		if err = x.Work[0].End(); err != nil {
+			g.finish(true)
+			return
+		}
+	}
+	// Work items are committed in order, by
+	// separate Commit calls, as required by
+	// executeAsync.
+	ch := make(chan *driver.WorkItem, len(wk))
+	var n int
+	for i, x := range wk {
+		if x == nil {
+			continue
+		}
+		if err = ctxt.GPU().Commit(x, ch); err != nil {
+			break
+		}
+		committed[i] = true
+		n++
+	}
+	for range n {
+		if x := <-ch; x.Err != nil && err == nil {
+			err = x.Err
+		}
+	}
+	frame.Err = nil
+	g.finish(err != nil)
+	return
+}
+
 // Onscreen is a Renderer that targets a wsi.Window.
 type Onscreen struct {
 	Renderer
-	win wsi.Window
-	sc  driver.Swapchain
+	win  wsi.Window
+	pres *Presenter
 }
 
 // NewOnscreen creates a new onscreen renderer.
 func NewOnscreen(win wsi.Window) (*Onscreen, error) {
-	if win == nil {
-		return nil, newRendErr("nil wsi.Window in call to NewOnscreen")
-	}
-	pres, ok := ctxt.GPU().(driver.Presenter)
-	if !ok {
-		return nil, newRendErr("NewOnscreen requires driver.Presenter")
-	}
-	sc, err := pres.NewSwapchain(win, NFrame+1)
+	pres, err := newPresenter(win, rendPrefix, "NewOnscreen")
 	if err != nil {
 		return nil, err
 	}
 	var r Onscreen
-	err = r.init(win.Width(), win.Height())
+	err = r.init(wsi.PixelSize(win))
 	if err != nil {
-		sc.Destroy()
+		pres.Free()
 		return nil, err
 	}
+	r.uiScale = float32(wsi.WindowScale(win))
 	r.win = win
-	r.sc = sc
+	r.pres = pres
 	return &r, nil
 }
 
@@ -216,9 +428,9 @@ func (r *Onscreen) Free() {
 		return
 	}
 	r.free()
-	r.sc.Destroy()
+	r.pres.Free()
 	r.win = nil
-	r.sc = nil
+	r.pres = nil
 }
 
 // Offscreen is a Renderer that targets a Texture.
@@ -252,6 +464,14 @@ func NewOffscreen(width, height int) (*Offscreen, error) {
 // Target returns the Texture into which r renders.
 func (r *Offscreen) Target() *Texture { return r.rt }
 
+// Render executes the passes of a frame.
+// Passes that only record compute work (e.g., the
+// update of the irradiance probe grid) run in a
+// separate queue, overlapping with rendering, if
+// the GPU supports driver.Features.AsyncCompute.
+// It blocks until the frame completes execution.
+func (r *Offscreen) Render() error { return r.render() }
+
 // Free invalidates r and destroys/releases the
 // driver resources it holds.
 // It does call Free on its target Texture.
@@ -3,12 +3,19 @@
 package engine
 
 import (
+	"errors"
+	"math"
+	"slices"
 	"strings"
 	"testing"
+	"time"
+	"unsafe"
 
 	"gviegas/neo3/driver"
 	"gviegas/neo3/engine/internal/ctxt"
+	"gviegas/neo3/engine/internal/shader"
 	"gviegas/neo3/linear"
+	"gviegas/neo3/node"
 	"gviegas/neo3/wsi"
 )
 
@@ -162,7 +169,11 @@ func (r *Onscreen) checkNew(err error, win wsi.Window, t *testing.T) {
 	if win != r.Window() {
 		t.Fatal("Onscreen.Window: windows differ")
 	}
-	r.checkInit(r.Window().Width(), r.Window().Height(), t)
+	width, height := wsi.PixelSize(r.Window())
+	r.checkInit(width, height, t)
+	if s := float32(wsi.WindowScale(win)); r.UIScale() != s {
+		t.Fatalf("Onscreen.UIScale:\nhave %v\nwant %v", r.UIScale(), s)
+	}
 }
 
 // checkFree checks whether r.Free worked.
@@ -424,3 +435,838 @@ func TestRendererLight(t *testing.T) {
 		}
 	}
 }
+
+func TestRendererTransparency(t *testing.T) {
+	rend, err := NewOffscreen(256, 192)
+	if err != nil {
+		t.Fatalf("RendererTransparency: NewOffscreen failed:\n%v", err)
+	}
+	defer rend.Free()
+	if x := rend.Transparency(); x != TranspSortObject {
+		t.Fatalf("Renderer.Transparency:\nhave %d\nwant %d", x, TranspSortObject)
+	}
+
+	var mat [3]*Material
+	for i, mode := range [3]int{AlphaOpaque, AlphaBlend, AlphaMask} {
+		if mat[i], err = NewPBR(&PBR{AlphaMode: mode}); err != nil {
+			t.Fatalf("RendererTransparency: NewPBR failed:\n%v", err)
+		}
+	}
+	// Drawables are placed along the view direction,
+	// in no particular order.
+	zs := []float32{4, -1, 10, 2, 7}
+	ids := make([]Drawable, len(zs))
+	for i, z := range zs {
+		var d drawable
+		d.mat = []*Material{mat[1], mat[i%3], mat[2]}
+		var world linear.M4
+		world.Translate(0, 0, z)
+		d.layout.SetWorld(&world)
+		ids[i] = rend.drawables.insert(d)
+	}
+	defer func() {
+		for _, id := range ids {
+			rend.drawables.remove(id)
+		}
+	}()
+	view := linear.I4()
+
+	var l drawList
+	l.build(&rend.Renderer, &view, nil)
+	// One opaque/mask primitive per drawable plus
+	// the ones using mat[i%3].
+	if n, want := len(l.opaque), len(zs)+3; n != want {
+		t.Fatalf("drawList.build: len(opaque)\nhave %d\nwant %d", n, want)
+	}
+	if n, want := len(l.blend), len(zs)+2; n != want {
+		t.Fatalf("drawList.build: len(blend)\nhave %d\nwant %d", n, want)
+	}
+	for i, x := range l.blend {
+		if m := rend.drawables.get(x.id).mat[x.prim]; m != mat[1] {
+			t.Fatalf("drawList.build: blend[%d] is not blended", i)
+		}
+		if x.depth != zs[x.id] {
+			t.Fatalf("drawList.build: blend[%d].depth\nhave %v\nwant %v", i, x.depth, zs[x.id])
+		}
+		if i > 0 && x.depth > l.blend[i-1].depth {
+			t.Fatal("drawList.build: blend should be sorted back-to-front")
+		}
+	}
+	for i, x := range l.opaque {
+		if m := rend.drawables.get(x.id).mat[x.prim]; m == mat[1] {
+			t.Fatalf("drawList.build: opaque[%d] is blended", i)
+		}
+	}
+
+	if err := rend.SetTransparency(TranspOIT); err != nil {
+		t.Fatalf("Renderer.SetTransparency failed:\n%v", err)
+	}
+	if x := rend.Transparency(); x != TranspOIT {
+		t.Fatalf("Renderer.Transparency:\nhave %d\nwant %d", x, TranspOIT)
+	}
+	for i, pf := range [2]driver.PixelFmt{oitAccumFmt, oitRevealFmt} {
+		switch tex := rend.oit[i]; {
+		case tex == nil:
+			t.Fatalf("Renderer.SetTransparency: oit[%d] is nil", i)
+		case tex.PixelFmt() != pf:
+			t.Fatalf("Renderer.SetTransparency: oit[%d].PixelFmt\nhave %v\nwant %v", i, tex.PixelFmt(), pf)
+		case tex.Width() != 256 || tex.Height() != 192:
+			t.Fatalf("Renderer.SetTransparency: oit[%d].Width/Height\nhave %d, %d\nwant 256, 192", i, tex.Width(), tex.Height())
+		case tex.usage&driver.UShaderSample == 0:
+			t.Fatalf("Renderer.SetTransparency: oit[%d] should be sampleable", i)
+		}
+	}
+	switch i, j := rend.graph.find(oitAccumPass), rend.graph.find(oitCompositePass); {
+	case i < 0 || j < 0:
+		t.Fatal("Renderer.SetTransparency: missing OIT passes")
+	case i > j:
+		t.Fatal("Renderer.SetTransparency: OIT composite should come after accumulation")
+	}
+	if err := rend.graph.validate(); err != nil {
+		t.Fatalf("frameGraph.validate failed:\n%v", err)
+	}
+	for i, ct := range rend.oitTargets() {
+		if ct.Color != rend.oit[i].views[0] || ct.Load != driver.LClear || ct.Clear != oitClear[i] {
+			t.Fatalf("Renderer.oitTargets: [%d]\nhave %v\nwant %v, %v, %v", i, ct, rend.oit[i].views[0], driver.LClear, oitClear[i])
+		}
+	}
+	l.build(&rend.Renderer, &view, nil)
+	if n, want := len(l.blend), len(zs)+2; n != want {
+		t.Fatalf("drawList.build: len(blend)\nhave %d\nwant %d", n, want)
+	}
+
+	if err := rend.SetTransparency(TranspSortPrimitive); err != nil {
+		t.Fatalf("Renderer.SetTransparency failed:\n%v", err)
+	}
+	if rend.oit[0] != nil || rend.oit[1] != nil {
+		t.Fatal("Renderer.SetTransparency: oit should be nil")
+	}
+	if rend.graph.find(oitAccumPass) >= 0 || rend.graph.find(oitCompositePass) >= 0 {
+		t.Fatal("Renderer.SetTransparency: OIT passes should have been removed")
+	}
+	if err := rend.SetTransparency(-1); err == nil {
+		t.Fatal("Renderer.SetTransparency: unexpected nil error")
+	}
+	if x := rend.Transparency(); x != TranspSortPrimitive {
+		t.Fatalf("Renderer.Transparency:\nhave %d\nwant %d", x, TranspSortPrimitive)
+	}
+}
+
+// decalNode is a node.Interface for testing.
+type decalNode struct{ local linear.M4 }
+
+func (n *decalNode) Local() *linear.M4 { return &n.local }
+func (n *decalNode) Changed() bool     { return true }
+
+func TestRendererDecal(t *testing.T) {
+	rend, err := NewOffscreen(256, 192)
+	if err != nil {
+		t.Fatalf("RendererDecal: NewOffscreen failed:\n%v", err)
+	}
+	defer rend.Free()
+	mat, err := NewPBR(&PBR{AlphaMode: AlphaBlend})
+	if err != nil {
+		t.Fatalf("RendererDecal: NewPBR failed:\n%v", err)
+	}
+
+	for _, p := range [...]*DecalParam{
+		nil,
+		{},
+		{Mat: mat, Lifetime: -1},
+		{Mat: mat, Lifetime: time.Second, FadeOut: 2 * time.Second},
+	} {
+		if _, err := rend.AddDecal(p); err == nil {
+			t.Fatal("Renderer.AddDecal: unexpected nil error")
+		}
+	}
+	if rend.ds.usage&driver.UShaderSample != 0 {
+		t.Fatal("Renderer.AddDecal: depth should not be sampleable until a decal is added")
+	}
+
+	var world linear.M4
+	world.Translate(1, 2, 3)
+	forever, err := rend.AddDecal(&DecalParam{World: world, Mat: mat})
+	if err != nil {
+		t.Fatalf("Renderer.AddDecal failed:\n%v", err)
+	}
+	if rend.ds.usage&driver.UShaderSample == 0 {
+		t.Fatal("Renderer.AddDecal: depth should be sampleable")
+	}
+	if x := rend.decals.get(forever).layout.World(); x != world {
+		t.Fatalf("Renderer.AddDecal: decal.layout.World\nhave %v\nwant %v", x, world)
+	}
+	fading, err := rend.AddDecal(&DecalParam{
+		Mat:      mat,
+		Lifetime: 4 * time.Second,
+		FadeOut:  2 * time.Second,
+	})
+	if err != nil {
+		t.Fatalf("Renderer.AddDecal failed:\n%v", err)
+	}
+
+	var g node.Graph
+	var n decalNode
+	n.local.Translate(-5, 0, 0)
+	nd := g.Insert(&n, node.Nil)
+	g.Update()
+	noded, err := rend.AddDecal(&DecalParam{Node: nd, Mat: mat})
+	if err != nil {
+		t.Fatalf("Renderer.AddDecal failed:\n%v", err)
+	}
+	if x := rend.DecalsLen(); x != 3 {
+		t.Fatalf("Renderer.DecalsLen:\nhave %d\nwant 3", x)
+	}
+
+	rend.UpdateDecals(&g, 3*time.Second)
+	if x := rend.decals.get(fading).layout.Opacity(); x != 0.5 {
+		t.Fatalf("Renderer.UpdateDecals: decal.layout.Opacity\nhave %v\nwant 0.5", x)
+	}
+	if x := rend.decals.get(forever).layout.Opacity(); x != 1 {
+		t.Fatalf("Renderer.UpdateDecals: decal.layout.Opacity\nhave %v\nwant 1", x)
+	}
+	if x := rend.decals.get(noded).layout.World(); x != n.local {
+		t.Fatalf("Renderer.UpdateDecals: decal.layout.World\nhave %v\nwant %v", x, n.local)
+	}
+	rend.SetDecalWorld(noded, &world)
+	if x := rend.decals.get(noded).layout.World(); x != n.local {
+		t.Fatal("Renderer.SetDecalWorld: should not override node transform")
+	}
+	rend.UpdateDecals(&g, time.Second)
+	if x := rend.DecalsLen(); x != 2 {
+		t.Fatalf("Renderer.UpdateDecals: DecalsLen\nhave %d\nwant 2", x)
+	}
+
+	var l drawList
+	vp := linear.I4()
+	l.buildDecals(&rend.Renderer, &vp)
+	if len(l.decal) != 2 || l.decal[0] != forever || l.decal[1] != noded {
+		t.Fatalf("drawList.buildDecals:\nhave %v\nwant [%d %d]", l.decal, forever, noded)
+	}
+	var clip linear.M4
+	clip.Translate(-1, -2, -3)
+	if x := rend.decals.get(forever).layout.Clip(); x != clip {
+		t.Fatalf("drawList.buildDecals: decal.layout.Clip\nhave %v\nwant %v", x, clip)
+	}
+
+	rend.RemoveDecal(noded)
+	for range MaxDecal {
+		if _, err := rend.AddDecal(&DecalParam{Mat: mat}); err != nil {
+			t.Fatalf("Renderer.AddDecal failed:\n%v", err)
+		}
+	}
+	if x := rend.DecalsLen(); x != MaxDecal {
+		t.Fatalf("Renderer.DecalsLen:\nhave %d\nwant %d", x, MaxDecal)
+	}
+	for _, e := range rend.decals.entries() {
+		if e.id == int(forever) && e.data.seq == 0 {
+			t.Fatal("Renderer.AddDecal: oldest decal should have been evicted")
+		}
+	}
+}
+
+func TestRendererProbe(t *testing.T) {
+	rend, err := NewOffscreen(256, 192)
+	if err != nil {
+		t.Fatalf("RendererProbe: NewOffscreen failed:\n%v", err)
+	}
+	defer rend.Free()
+
+	for _, p := range [...]*ProbeParam{
+		nil,
+		{Proj: ProbeBox, Min: linear.V3{1, 0, 0}},
+		{Proj: ProbeSphere},
+		{Proj: -1},
+		{Proj: ProbeSphere, Radius: 1, Blend: -1},
+	} {
+		if _, err := rend.AddProbe(p); err == nil {
+			t.Fatal("Renderer.AddProbe: unexpected nil error")
+		}
+	}
+	if NProbe < 2 {
+		t.Skip("RendererProbe: NProbe < 2")
+	}
+
+	box, err := rend.AddProbe(&ProbeParam{
+		Proj:  ProbeBox,
+		Min:   linear.V3{-10, -10, -10},
+		Max:   linear.V3{10, 10, 10},
+		Blend: 4,
+	})
+	if err != nil {
+		t.Fatalf("Renderer.AddProbe failed:\n%v", err)
+	}
+	if rend.probeTex == nil || !rend.probeTex.IsValidView(0) {
+		t.Fatal("Renderer.AddProbe: probeTex should be valid")
+	}
+	if x := rend.probeTex.Layers(); x != 6*NProbe {
+		t.Fatalf("Renderer.AddProbe: probeTex.Layers\nhave %d\nwant %d", x, 6*NProbe)
+	}
+	sph, err := rend.AddProbe(&ProbeParam{
+		Position: linear.V3{5, 0, 0},
+		Proj:     ProbeSphere,
+		Radius:   4,
+		Realtime: true,
+	})
+	if err != nil {
+		t.Fatalf("Renderer.AddProbe failed:\n%v", err)
+	}
+	if x := rend.ProbesLen(); x != 2 {
+		t.Fatalf("Renderer.ProbesLen:\nhave %d\nwant 2", x)
+	}
+
+	// Probes are not used until captured.
+	pos := linear.V3{7, 0, 0}
+	if p0, p1, _ := rend.selectProbes(&pos); p0 != shader.NoProbe || p1 != shader.NoProbe {
+		t.Fatalf("Renderer.selectProbes:\nhave %d, %d\nwant NoProbe, NoProbe", p0, p1)
+	}
+
+	rend.SetProbeBudget(4)
+	c := rend.nextCaptures(nil)
+	if len(c) != 4 {
+		t.Fatalf("Renderer.nextCaptures: len\nhave %d\nwant 4", len(c))
+	}
+	for i, x := range c {
+		if x.id != box || x.face != i || x.last {
+			t.Fatalf("Renderer.nextCaptures: [%d]\nhave %d, %d, %t\nwant %d, %d, false", i, x.id, x.face, x.last, box, i)
+		}
+	}
+	c = rend.nextCaptures(c[:0])
+	if len(c) != 4 || c[1].id != box || !c[1].last || c[2].id != sph {
+		t.Fatal("Renderer.nextCaptures: static probe should complete before real-time probe")
+	}
+	// Real-time probes are never done.
+	for range 3 {
+		c = rend.nextCaptures(c[:0])
+		if len(c) != 4 {
+			t.Fatalf("Renderer.nextCaptures: len\nhave %d\nwant 4", len(c))
+		}
+		for _, x := range c {
+			if x.id != sph {
+				t.Fatal("Renderer.nextCaptures: static probe should not be captured again")
+			}
+		}
+	}
+	rend.CaptureProbe(box)
+	c = rend.nextCaptures(c[:0])
+	if c[0].id != box {
+		t.Fatal("Renderer.CaptureProbe: probe should be captured again")
+	}
+
+	bslot := uint32(rend.probes.get(box).slot)
+	sslot := uint32(rend.probes.get(sph).slot)
+	// Sphere has full influence, box is
+	// 3 units away from its boundary.
+	p0, p1, w := rend.selectProbes(&pos)
+	if p0 != sslot || p1 != bslot || w != 1/1.75 {
+		t.Fatalf("Renderer.selectProbes:\nhave %d, %d, %v\nwant %d, %d, %v", p0, p1, w, sslot, bslot, 1/1.75)
+	}
+
+	var d drawable
+	world := linear.I4()
+	d.layout.SetWorld(&world)
+	id := rend.drawables.insert(d)
+	defer rend.drawables.remove(id)
+	rend.setDrawableProbes()
+	if p0, p1, w := rend.drawables.get(id).layout.Probes(); p0 != bslot || p1 != shader.NoProbe || w != 1 {
+		t.Fatalf("Renderer.setDrawableProbes:\nhave %d, %d, %v\nwant %d, NoProbe, 1", p0, p1, w, bslot)
+	}
+
+	rend.RemoveProbe(sph)
+	if x := rend.ProbesLen(); x != 1 {
+		t.Fatalf("Renderer.ProbesLen:\nhave %d\nwant 1", x)
+	}
+	if rend.probeSlot[sslot] {
+		t.Fatal("Renderer.RemoveProbe: slot should be free")
+	}
+}
+
+func TestProbeView(t *testing.T) {
+	pos := linear.V3{1, -2, 3}
+	// Directions whose view space coordinates
+	// must be (1, 0, 0), (0, 1, 0) and (0, 0, 1),
+	// respectively, for each cube face.
+	want := [6][3]linear.V3{
+		{{0, 0, -1}, {0, -1, 0}, {1, 0, 0}},
+		{{0, 0, 1}, {0, -1, 0}, {-1, 0, 0}},
+		{{1, 0, 0}, {0, 0, 1}, {0, 1, 0}},
+		{{1, 0, 0}, {0, 0, -1}, {0, -1, 0}},
+		{{1, 0, 0}, {0, -1, 0}, {0, 0, 1}},
+		{{-1, 0, 0}, {0, -1, 0}, {0, 0, -1}},
+	}
+	for f := range 6 {
+		v := probeView(&pos, f)
+		eye := linear.V4{pos[0], pos[1], pos[2], 1}
+		eye.Mul(&v, &eye)
+		if eye != (linear.V4{0, 0, 0, 1}) {
+			t.Fatalf("probeView: face %d: eye\nhave %v\nwant [0 0 0 1]", f, eye)
+		}
+		for i, d := range want[f] {
+			x := linear.V4{d[0], d[1], d[2], 0}
+			x.Mul(&v, &x)
+			var y linear.V4
+			y[i] = 1
+			if x != y {
+				t.Fatalf("probeView: face %d: axis %d\nhave %v\nwant %v", f, i, x, y)
+			}
+		}
+	}
+}
+
+func TestRendererSSR(t *testing.T) {
+	rend, err := NewOffscreen(256, 192)
+	if err != nil {
+		t.Fatalf("RendererSSR: NewOffscreen failed:\n%v", err)
+	}
+	defer rend.Free()
+	if _, ok := rend.SSR(); ok {
+		t.Fatal("Renderer.SSR: SSR should be disabled by default")
+	}
+
+	for _, p := range [...]SSRParam{
+		{MaxSteps: 0, Thickness: 0.1, MaxRoughness: 0.5},
+		{MaxSteps: 32, Thickness: 0, MaxRoughness: 0.5},
+		{MaxSteps: 32, Thickness: 0.1, MaxRoughness: 1.5},
+		{MaxSteps: 32, Thickness: 0.1, MaxRoughness: 0.5, EdgeFade: 0.75},
+	} {
+		if err := rend.SetSSR(&p); err == nil {
+			t.Fatal("Renderer.SetSSR: unexpected nil error")
+		}
+	}
+	if rend.ssr != nil {
+		t.Fatal("Renderer.SetSSR: ssr should be nil")
+	}
+
+	param := SSRParam{MaxSteps: 64, Thickness: 0.2, MaxRoughness: 0.6, EdgeFade: 0.1}
+	if err := rend.SetSSR(&param); !errors.Is(err, ErrUnsupported) {
+		t.Fatalf("Renderer.SetSSR: pending passes\nhave %v\nwant %v", err, ErrUnsupported)
+	}
+	if _, ok := rend.SSR(); ok || rend.graph.find(ssrHiZPass) >= 0 {
+		t.Fatal("Renderer.SetSSR: SSR should not be enabled")
+	}
+	defer allowPending(ssrPasses[:]...)()
+	if err := rend.SetSSR(&param); err != nil {
+		t.Fatalf("Renderer.SetSSR failed:\n%v", err)
+	}
+	if x, ok := rend.SSR(); !ok || x != param {
+		t.Fatalf("Renderer.SSR:\nhave %v, %t\nwant %v, true", x, ok, param)
+	}
+	if rend.ds.usage&driver.UShaderSample == 0 {
+		t.Fatal("Renderer.SetSSR: depth should be sampleable")
+	}
+	hiz, refl := rend.ssr.hiz, rend.ssr.refl
+	if hiz.Width() != 256 || hiz.Height() != 192 || hiz.Levels() != ComputeLevels(driver.Dim3D{Width: 256, Height: 192}) {
+		t.Fatal("Renderer.SetSSR: unexpected Hi-Z texture parameters")
+	}
+	if refl.Levels() != ssrBlurLevels {
+		t.Fatalf("Renderer.SetSSR: refl.Levels\nhave %d\nwant %d", refl.Levels(), ssrBlurLevels)
+	}
+	if x, y := rend.ssr.layout.Levels(); x != hiz.Levels() || y != refl.Levels() {
+		t.Fatalf("Renderer.SetSSR: layout.Levels\nhave %d, %d\nwant %d, %d", x, y, hiz.Levels(), refl.Levels())
+	}
+	if rend.graph.find(ssrHiZPass) < 0 || rend.graph.find(ssrTracePass) < 0 {
+		t.Fatal("Renderer.SetSSR: missing SSR passes")
+	}
+	if rend.graph.find(ssrHiZPass) > rend.graph.find(ssrTracePass) {
+		t.Fatal("Renderer.SetSSR: Hi-Z pass should precede trace pass")
+	}
+	if err := rend.graph.validate(); err != nil {
+		t.Fatalf("frameGraph.validate:\n%v", err)
+	}
+
+	// Changing parameters should not recreate
+	// the targets.
+	param.MaxSteps = 16
+	if err := rend.SetSSR(&param); err != nil {
+		t.Fatalf("Renderer.SetSSR failed:\n%v", err)
+	}
+	if rend.ssr.hiz != hiz || rend.ssr.layout.Steps() != 16 {
+		t.Fatal("Renderer.SetSSR: unexpected state after update")
+	}
+	if n := len(rend.graph.nodes); n != 2 {
+		t.Fatalf("Renderer.SetSSR: len(graph.nodes)\nhave %d\nwant 2", n)
+	}
+
+	if err := rend.SetSSR(nil); err != nil {
+		t.Fatalf("Renderer.SetSSR failed:\n%v", err)
+	}
+	if _, ok := rend.SSR(); ok || len(rend.graph.nodes) != 0 {
+		t.Fatal("Renderer.SetSSR: SSR should be disabled")
+	}
+}
+
+func TestRendererGrading(t *testing.T) {
+	rend, err := NewOffscreen(256, 192)
+	if err != nil {
+		t.Fatalf("RendererGrading: NewOffscreen failed:\n%v", err)
+	}
+	defer rend.Free()
+	if _, ok := rend.Grading(); ok {
+		t.Fatal("Renderer.Grading: grading should be disabled by default")
+	}
+
+	for _, p := range [...]GradeParam{
+		{Temperature: 1.5, Contrast: 1, Saturation: 1},
+		{Tint: -2, Contrast: 1, Saturation: 1},
+		{Contrast: -1, Saturation: 1},
+		{Contrast: 1, Saturation: -1},
+		{Contrast: 1, Saturation: 1, LUT: &LUT{}},
+	} {
+		if err := rend.SetGrading(&p); err == nil {
+			t.Fatal("Renderer.SetGrading: unexpected nil error")
+		}
+	}
+	if rend.grade != nil {
+		t.Fatal("Renderer.SetGrading: grade should be nil")
+	}
+
+	param := GradeParam{Exposure: 1, Temperature: 0.2, Contrast: 1.1, Saturation: 0.9}
+	if err := rend.SetGrading(&param); err != nil {
+		t.Fatalf("Renderer.SetGrading failed:\n%v", err)
+	}
+	if x, ok := rend.Grading(); !ok || x != param {
+		t.Fatalf("Renderer.Grading:\nhave %v, %t\nwant %v, true", x, ok, param)
+	}
+	if x := rend.grade.layout.Exposure(); x != 2 {
+		t.Fatalf("Renderer.SetGrading: layout.Exposure\nhave %v\nwant 2", x)
+	}
+	if x, _, _ := rend.grade.layout.LUT(); x != 0 {
+		t.Fatalf("Renderer.SetGrading: layout.LUT\nhave %d\nwant 0", x)
+	}
+
+	lut, err := NewLUTFromCube(strings.NewReader(testCube))
+	if err != nil {
+		t.Fatalf("NewLUTFromCube failed:\n%v", err)
+	}
+	defer lut.Free()
+	if n := lut.Size(); n != 2 {
+		t.Fatalf("LUT.Size:\nhave %d\nwant 2", n)
+	}
+	param.LUT = lut
+	if err := rend.SetGrading(&param); err != nil {
+		t.Fatalf("Renderer.SetGrading failed:\n%v", err)
+	}
+	if x, _, max := rend.grade.layout.LUT(); x != 2 || max != (linear.V3{1, 1, 2}) {
+		t.Fatalf("Renderer.SetGrading: layout.LUT\nhave %d, %v\nwant 2, %v", x, max, linear.V3{1, 1, 2})
+	}
+	// Grading must be the last pass.
+	if i := rend.graph.find(gradePass); i != len(rend.graph.nodes)-1 {
+		t.Fatalf("Renderer.SetGrading: pass index\nhave %d\nwant %d", i, len(rend.graph.nodes)-1)
+	}
+	if n := len(rend.graph.nodes[len(rend.graph.nodes)-1].reads); n != 2 {
+		t.Fatalf("Renderer.SetGrading: len(reads)\nhave %d\nwant 2", n)
+	}
+	if err := rend.graph.validate(); err != nil {
+		t.Fatalf("frameGraph.validate:\n%v", err)
+	}
+
+	if err := rend.SetGrading(nil); err != nil {
+		t.Fatalf("Renderer.SetGrading failed:\n%v", err)
+	}
+	if _, ok := rend.Grading(); ok || rend.graph.find(gradePass) >= 0 {
+		t.Fatal("Renderer.SetGrading: grading should be disabled")
+	}
+}
+
+func TestRendererAutoExposure(t *testing.T) {
+	rend, err := NewOffscreen(256, 192)
+	if err != nil {
+		t.Fatalf("RendererAutoExposure: NewOffscreen failed:\n%v", err)
+	}
+	defer rend.Free()
+	if _, ok := rend.AutoExposure(); ok {
+		t.Fatal("Renderer.AutoExposure: auto exposure should be disabled by default")
+	}
+
+	for _, p := range [...]ExposureParam{
+		{MinEV: 4, MaxEV: 4, SpeedUp: 1, SpeedDown: 1},
+		{MinEV: 4, MaxEV: -4, SpeedUp: 1, SpeedDown: 1},
+		{MinEV: -4, MaxEV: 16, SpeedUp: 0, SpeedDown: 1},
+		{MinEV: -4, MaxEV: 16, SpeedUp: 1, SpeedDown: -1},
+	} {
+		if err := rend.SetAutoExposure(&p); err == nil {
+			t.Fatal("Renderer.SetAutoExposure: unexpected nil error")
+		}
+	}
+	if rend.expo != nil {
+		t.Fatal("Renderer.SetAutoExposure: expo should be nil")
+	}
+
+	param := ExposureParam{MinEV: -4, MaxEV: 16, SpeedUp: 3, SpeedDown: 1}
+	if err := rend.SetAutoExposure(&param); err != nil {
+		t.Fatalf("Renderer.SetAutoExposure failed:\n%v", err)
+	}
+	if x, ok := rend.AutoExposure(); !ok || x != param {
+		t.Fatalf("Renderer.AutoExposure:\nhave %v, %t\nwant %v, true", x, ok, param)
+	}
+	if x := rend.expo.layout.Exposure(); x != 1 {
+		t.Fatalf("Renderer.SetAutoExposure: layout.Exposure\nhave %v\nwant 1", x)
+	}
+	if rend.graph.find(exposurePass) < 0 {
+		t.Fatal("Renderer.SetAutoExposure: missing histogram pass")
+	}
+	if err := rend.graph.validate(); err != nil {
+		t.Fatalf("frameGraph.validate:\n%v", err)
+	}
+
+	// Fill the histogram as the GPU would.
+	b := rend.expo.hist[1].Bytes()
+	hist := unsafe.Slice((*uint32)(unsafe.Pointer(unsafe.SliceData(b))), shader.ExposureBins)
+	hist[shader.ExposureBins/2] = 256 * 192
+	rend.updateExposure(1, time.Second/60)
+	if !rend.expo.init {
+		t.Fatal("Renderer.updateExposure: exposure should have been initialized")
+	}
+	if x, want := rend.expo.layout.Exposure(), evToExposure(rend.expo.ev); x != want {
+		t.Fatalf("Renderer.updateExposure: layout.Exposure\nhave %v\nwant %v", x, want)
+	}
+	for i, x := range hist {
+		if x != 0 {
+			t.Fatalf("Renderer.updateExposure: hist[%d]\nhave %d\nwant 0", i, x)
+		}
+	}
+
+	// Narrowing the range clamps the current
+	// exposure.
+	param.MaxEV = rend.expo.ev - 1
+	if err := rend.SetAutoExposure(&param); err != nil {
+		t.Fatalf("Renderer.SetAutoExposure failed:\n%v", err)
+	}
+	if rend.expo.ev != param.MaxEV {
+		t.Fatalf("Renderer.SetAutoExposure: ev\nhave %v\nwant %v", rend.expo.ev, param.MaxEV)
+	}
+
+	if err := rend.SetAutoExposure(nil); err != nil {
+		t.Fatalf("Renderer.SetAutoExposure failed:\n%v", err)
+	}
+	if _, ok := rend.AutoExposure(); ok || rend.graph.find(exposurePass) >= 0 {
+		t.Fatal("Renderer.SetAutoExposure: auto exposure should be disabled")
+	}
+}
+
+func TestRendererViewport(t *testing.T) {
+	rend, err := NewOffscreen(256, 192)
+	if err != nil {
+		t.Fatalf("RendererViewport: NewOffscreen failed:\n%v", err)
+	}
+	defer rend.Free()
+	if n := rend.ViewportsLen(); n != 0 {
+		t.Fatalf("Renderer.ViewportsLen:\nhave %d\nwant 0", n)
+	}
+
+	var proj linear.M4
+	proj.Perspective(1, 1, 0.1, 100)
+	for _, p := range [...]*ViewportParam{
+		nil,
+		{Proj: proj, Rect: ViewportRect{0, 0, 0, 1}},
+		{Proj: proj, Rect: ViewportRect{0.5, 0, 0.75, 1}},
+	} {
+		if _, err := rend.AddViewport(p); err == nil {
+			t.Fatal("Renderer.AddViewport: unexpected nil error")
+		}
+	}
+
+	// Split-screen with a picture-in-picture
+	// viewport on top.
+	params := [...]ViewportParam{
+		{View: linear.I4(), Proj: proj, Rect: ViewportRect{0, 0, 0.5, 1}},
+		{View: linear.I4(), Proj: proj, Rect: ViewportRect{0.75, 0, 0.25, 0.25}, Layer: 1},
+		{View: linear.I4(), Proj: proj, Rect: ViewportRect{0.5, 0, 0.5, 1}},
+	}
+	var vps [len(params)]Viewport
+	for i := range params {
+		if vps[i], err = rend.AddViewport(&params[i]); err != nil {
+			t.Fatalf("Renderer.AddViewport failed:\n%v", err)
+		}
+	}
+	if n := rend.ViewportsLen(); n != len(params) {
+		t.Fatalf("Renderer.ViewportsLen:\nhave %d\nwant %d", n, len(params))
+	}
+	if b, want := rend.vports.get(vps[2]).layout.Bounds(), (driver.Viewport{X: 128, Width: 128, Height: 192, Zfar: 1}); b != want {
+		t.Fatalf("Renderer.AddViewport: layout.Bounds\nhave %v\nwant %v", b, want)
+	}
+	for i := len(params); i < MaxViewport; i++ {
+		if _, err := rend.AddViewport(&params[0]); err != nil {
+			t.Fatalf("Renderer.AddViewport failed:\n%v", err)
+		}
+	}
+	if _, err := rend.AddViewport(&params[0]); err == nil {
+		t.Fatal("Renderer.AddViewport: unexpected nil error")
+	}
+	for rend.ViewportsLen() > len(params) {
+		rend.RemoveViewport(Viewport(rend.ViewportsLen() - 1))
+	}
+
+	var view linear.M4
+	view.Translate(0, 0, -5)
+	rend.SetViewportCamera(vps[0], &view, &proj)
+	var vp linear.M4
+	vp.Mul(&proj, &view)
+	if x := rend.vports.get(vps[0]).layout.VP(); x != vp {
+		t.Fatalf("Renderer.SetViewportCamera: layout.VP\nhave %v\nwant %v", x, vp)
+	}
+	if err := rend.SetViewportRect(vps[0], &ViewportRect{0, 0, 2, 1}); err == nil {
+		t.Fatal("Renderer.SetViewportRect: unexpected nil error")
+	}
+	if err := rend.SetViewportRect(vps[0], &ViewportRect{0, 0.5, 0.5, 0.5}); err != nil {
+		t.Fatalf("Renderer.SetViewportRect failed:\n%v", err)
+	}
+
+	// Viewports are recorded by layer.
+	rend.buildViewports()
+	var order []Viewport
+	var sciss []driver.Scissor
+	record := func() {
+		order = append(order, rend.curVport)
+		_, s := rend.curBounds()
+		sciss = append(sciss, s)
+	}
+	rend.executeViewports(false, record)
+	if want := []Viewport{vps[0], vps[2], vps[1]}; !slices.Equal(order, want) {
+		t.Fatalf("Renderer.executeViewports:\nhave %v\nwant %v", order, want)
+	}
+	if want := (driver.Scissor{X: 0, Y: 96, Width: 128, Height: 96}); sciss[0] != want {
+		t.Fatalf("Renderer.curBounds:\nhave %v\nwant %v", sciss[0], want)
+	}
+	if rend.curVport != -1 {
+		t.Fatalf("Renderer.executeViewports: curVport\nhave %d\nwant -1", rend.curVport)
+	}
+
+	// Post-processing.
+	if x := rend.ViewportPost(); x != PostShared {
+		t.Fatalf("Renderer.ViewportPost:\nhave %d\nwant %d", x, PostShared)
+	}
+	order, sciss = order[:0], sciss[:0]
+	rend.executeViewports(true, record)
+	if len(order) != 1 || order[0] != -1 || sciss[0] != (driver.Scissor{Width: 256, Height: 192}) {
+		t.Fatalf("Renderer.executeViewports: shared post\nhave %v, %v", order, sciss)
+	}
+	if err := rend.SetViewportPost(PostPerViewport); err != nil {
+		t.Fatalf("Renderer.SetViewportPost failed:\n%v", err)
+	}
+	order = order[:0]
+	rend.executeViewports(true, record)
+	if len(order) != len(params) {
+		t.Fatalf("Renderer.executeViewports: per-viewport post\nhave %v", order)
+	}
+	if err := rend.SetViewportPost(-1); err == nil {
+		t.Fatal("Renderer.SetViewportPost: unexpected nil error")
+	}
+}
+
+func TestRendererPass(t *testing.T) {
+	rend, err := NewOffscreen(256, 192)
+	if err != nil {
+		t.Fatalf("RendererPass: NewOffscreen failed:\n%v", err)
+	}
+	defer rend.Free()
+	if s := rend.Passes(); len(s) != 0 {
+		t.Fatalf("Renderer.Passes:\nhave %v\nwant []", s)
+	}
+
+	glow, err := NewTarget(&TexParam{
+		PixelFmt: driver.RGBA16Float,
+		Dim3D:    driver.Dim3D{Width: 128, Height: 96},
+		Layers:   1,
+		Levels:   1,
+		Samples:  1,
+	})
+	if err != nil {
+		t.Fatalf("RendererPass: NewTarget failed:\n%v", err)
+	}
+	defer glow.Free()
+
+	var ctxs []PassContext
+	record := func(ctx *PassContext) { ctxs = append(ctxs, *ctx) }
+	for _, p := range [...]*PassParam{
+		nil,
+		{Stage: StagePost, Record: record},
+		{Name: "x", Stage: -1, Record: record},
+		{Name: "x", Stage: StageFinal + 1, Record: record},
+		{Name: "x", Stage: StagePost, ReadTargets: 1 << 8, Record: record},
+		{Name: "x", Stage: StagePost},
+		// Read before write.
+		{Name: "x", Stage: StagePost, Reads: []*Texture{glow}, Record: record},
+		// Read from own target.
+		{Name: "x", Stage: StagePost, ReadTargets: TargetColor, WriteTargets: TargetColor, Record: record},
+	} {
+		if err := rend.AddPass(p); err == nil {
+			t.Fatal("Renderer.AddPass: unexpected nil error")
+		}
+	}
+	if s := rend.Passes(); len(s) != 0 {
+		t.Fatalf("Renderer.Passes: failed calls should not add passes\nhave %v", s)
+	}
+
+	if err := rend.AddPass(&PassParam{
+		Name:         "glow.composite",
+		Stage:        StagePost,
+		Reads:        []*Texture{glow},
+		WriteTargets: TargetColor,
+		Record:       record,
+	}); err != nil {
+		t.Fatalf("Renderer.AddPass failed:\n%v", err)
+	}
+	if err := rend.AddPass(&PassParam{
+		Name:        "glow",
+		Stage:       StageLighting,
+		Writes:      []*Texture{glow},
+		ReadTargets: TargetColor | TargetDepth,
+		Record:      record,
+	}); err != nil {
+		t.Fatalf("Renderer.AddPass failed:\n%v", err)
+	}
+	if err := rend.AddPass(&PassParam{Name: "glow", Stage: StagePost, Record: record}); err == nil {
+		t.Fatal("Renderer.AddPass: unexpected nil error")
+	}
+	if s, want := rend.Passes(), []string{"glow", "glow.composite"}; !slices.Equal(s, want) {
+		t.Fatalf("Renderer.Passes:\nhave %v\nwant %v", s, want)
+	}
+	if rend.ds.usage&driver.UShaderSample == 0 {
+		t.Fatal("Renderer.AddPass: depth should be sampleable")
+	}
+	if err := rend.graph.compile(); err != nil {
+		t.Fatalf("frameGraph.compile failed:\n%v", err)
+	}
+
+	for _, n := range rend.graph.nodes {
+		n.record(&rend.Renderer, nil)
+	}
+	if len(ctxs) != 2 {
+		t.Fatalf("PassParam.Record: call count\nhave %d\nwant 2", len(ctxs))
+	}
+	if x := ctxs[0]; !slices.Equal(x.Reads, []*Texture{rend.hdr, rend.ds}) || !slices.Equal(x.Writes, []*Texture{glow}) {
+		t.Fatalf("PassContext: glow\nhave %v, %v", x.Reads, x.Writes)
+	}
+	if x := ctxs[1]; !slices.Equal(x.Reads, []*Texture{glow}) || !slices.Equal(x.Writes, []*Texture{rend.hdr}) {
+		t.Fatalf("PassContext: glow.composite\nhave %v, %v", x.Reads, x.Writes)
+	}
+	if x := ctxs[1].Scissor; x != (driver.Scissor{Width: 256, Height: 192}) {
+		t.Fatalf("PassContext.Scissor:\nhave %v\nwant %v", x, driver.Scissor{Width: 256, Height: 192})
+	}
+	if x := ctxs[1].UIScale; x != 1 {
+		t.Fatalf("PassContext.UIScale:\nhave %v\nwant 1", x)
+	}
+	for _, x := range [...]float32{0, -1, float32(math.NaN())} {
+		if err := rend.SetUIScale(x); err == nil {
+			t.Fatalf("Renderer.SetUIScale(%v): unexpected nil error", x)
+		}
+	}
+	if err := rend.SetUIScale(2); err != nil || rend.UIScale() != 2 {
+		t.Fatalf("Renderer.SetUIScale failed:\n%v", err)
+	}
+	ctxs = ctxs[:0]
+	rend.graph.nodes[rend.graph.find(customPrefix+"glow.composite")].record(&rend.Renderer, nil)
+	if len(ctxs) != 1 || ctxs[0].UIScale != 2 {
+		t.Fatalf("PassContext.UIScale:\nhave %v\nwant 2", ctxs)
+	}
+
+	if !rend.RemovePass("glow") || rend.RemovePass("glow") {
+		t.Fatal("Renderer.RemovePass: unexpected result")
+	}
+	if rend.RemovePass(oitAccumPass) {
+		t.Fatal("Renderer.RemovePass: built-in passes must not be removed")
+	}
+	if s, want := rend.Passes(), []string{"glow.composite"}; !slices.Equal(s, want) {
+		t.Fatalf("Renderer.Passes:\nhave %v\nwant %v", s, want)
+	}
+}
@@ -0,0 +1,236 @@
+// Copyright 2024 Gustavo C. Viegas. All rights reserved.
+
+package engine
+
+import (
+	"sync"
+	"time"
+)
+
+const schedPrefix = "scheduler: "
+
+func newSchedErr(reason string) error { return newErr(schedPrefix, reason, ErrInvalidParam) }
+
+// Task identifies a task in a Scheduler.
+// Task values are never reused by a Scheduler, so a
+// Task that completed or was canceled remains invalid.
+type Task int64
+
+// TaskFunc performs one step of a task.
+// It returns whether the task is complete.
+// Each step should take a small fraction of the
+// Scheduler's budget (e.g., one cube face capture,
+// one mip level bake or one acceleration structure
+// build), since a step is never interrupted.
+type TaskFunc func() (done bool)
+
+// Scheduler spreads expensive work across frames.
+// Work is split into tasks, each of which executes as
+// a sequence of steps. Every call to Run executes
+// steps until a per-frame time budget is spent, so
+// background work (e.g., refining probes or baking
+// imposters) does not cause frame time spikes.
+// Tasks with higher priority run first. Tasks of equal
+// priority run in the order in which they were
+// scheduled, one step at a time in round-robin
+// fashion.
+// It is safe for concurrent use, except that Run must
+// not be called concurrently nor from a TaskFunc.
+type Scheduler struct {
+	mu     sync.Mutex
+	budget time.Duration
+	tasks  map[Task]*task
+	next   Task
+	seq    int64
+	now    func() time.Time
+	// Last budget use.
+	used time.Duration
+}
+
+// task is what a Scheduler stores.
+type task struct {
+	fn   TaskFunc
+	prio int
+	// Order in which the task will be considered
+	// among tasks of equal priority.
+	seq int64
+	// Estimated duration of the next step.
+	cost time.Duration
+}
+
+// NewScheduler creates a new Scheduler whose
+// per-frame budget is the given duration.
+func NewScheduler(budget time.Duration) (*Scheduler, error) {
+	if budget <= 0 {
+		return nil, newSchedErr("non-positive budget")
+	}
+	return &Scheduler{
+		budget: budget,
+		tasks:  make(map[Task]*task),
+		now:    time.Now,
+	}, nil
+}
+
+// SetBudget sets the per-frame budget of s.
+// budget is clamped to be at least 1µs.
+func (s *Scheduler) SetBudget(budget time.Duration) {
+	s.mu.Lock()
+	s.budget = max(time.Microsecond, budget)
+	s.mu.Unlock()
+}
+
+// Budget returns the per-frame budget of s.
+func (s *Scheduler) Budget() time.Duration {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.budget
+}
+
+// Schedule adds a new task to s.
+// fn is called once per step, from Run, until it
+// returns true or the task is canceled.
+// Higher prio values mean higher priority.
+func (s *Scheduler) Schedule(prio int, fn TaskFunc) (Task, error) {
+	if fn == nil {
+		return -1, newSchedErr("nil task func")
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	id := s.next
+	s.next++
+	s.seq++
+	s.tasks[id] = &task{fn: fn, prio: prio, seq: s.seq}
+	return id, nil
+}
+
+// Cancel cancels t.
+// The step of t that is executing, if any, is allowed
+// to complete, but no further steps will execute.
+// It returns false if t is not pending in s.
+func (s *Scheduler) Cancel(t Task) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.tasks[t]; !ok {
+		return false
+	}
+	delete(s.tasks, t)
+	return true
+}
+
+// SetPriority changes the priority of t.
+// It returns false if t is not pending in s.
+func (s *Scheduler) SetPriority(t Task, prio int) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	x, ok := s.tasks[t]
+	if ok {
+		x.prio = prio
+	}
+	return ok
+}
+
+// Pending returns whether t has yet to complete.
+func (s *Scheduler) Pending(t Task) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, ok := s.tasks[t]
+	return ok
+}
+
+// Len returns the number of pending tasks in s.
+func (s *Scheduler) Len() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.tasks)
+}
+
+// Used returns the time spent executing steps during
+// the most recent call to Run.
+func (s *Scheduler) Used() time.Duration {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.used
+}
+
+// Run executes task steps until the budget of s is
+// spent, and returns the number of steps executed.
+// It should be called once per frame.
+// The highest priority task always executes a step,
+// even if it is expected to exceed the budget, so
+// that every task eventually completes. Further steps
+// only execute if their estimated duration, based on
+// previous steps of the same task, fits in the
+// remaining budget.
+func (s *Scheduler) Run() (steps int) {
+	start := s.now()
+	var used time.Duration
+	for {
+		s.mu.Lock()
+		id, t := s.pick(steps == 0, s.budget-used)
+		s.mu.Unlock()
+		if t == nil {
+			break
+		}
+		t0 := s.now()
+		done := t.fn()
+		dt := s.now().Sub(t0)
+		steps++
+
+		s.mu.Lock()
+		// t may have been canceled while its
+		// step was executing.
+		if _, ok := s.tasks[id]; ok {
+			if done {
+				delete(s.tasks, id)
+			} else {
+				t.cost = estimateCost(t.cost, dt)
+				s.seq++
+				t.seq = s.seq
+			}
+		}
+		s.mu.Unlock()
+
+		used = s.now().Sub(start)
+		if used >= s.Budget() {
+			break
+		}
+	}
+	s.mu.Lock()
+	s.used = used
+	s.mu.Unlock()
+	return
+}
+
+// pick selects the task that must execute next.
+// If first is true, it selects the highest priority
+// task. Otherwise, it selects the highest priority
+// task whose estimated cost fits in rem.
+// It returns a nil *task if no task can execute.
+// s.mu must be held.
+func (s *Scheduler) pick(first bool, rem time.Duration) (Task, *task) {
+	var (
+		id   Task = -1
+		best *task
+	)
+	for i, t := range s.tasks {
+		if !first && t.cost > rem {
+			continue
+		}
+		if best == nil || t.prio > best.prio || (t.prio == best.prio && t.seq < best.seq) {
+			id, best = i, t
+		}
+	}
+	return id, best
+}
+
+// estimateCost updates the estimated step cost given
+// the duration of the latest step.
+// It is an exponential moving average that favors
+// recent steps, except that it adapts immediately
+// to steps that are more expensive than expected.
+func estimateCost(cost, dt time.Duration) time.Duration {
+	if dt >= cost {
+		return dt
+	}
+	return (3*cost + dt) / 4
+}
@@ -0,0 +1,168 @@
+// Copyright 2024 Gustavo C. Viegas. All rights reserved.
+
+package engine
+
+import (
+	"slices"
+	"testing"
+	"time"
+)
+
+// fakeClock is a clock that only advances when
+// told to.
+type fakeClock struct{ t time.Time }
+
+func (c *fakeClock) now() time.Time { return c.t }
+
+// newTestSched creates a Scheduler with the given
+// budget that uses a fakeClock.
+func newTestSched(t *testing.T, budget time.Duration) (*Scheduler, *fakeClock) {
+	s, err := NewScheduler(budget)
+	if err != nil {
+		t.Fatalf("NewScheduler failed:\n%v", err)
+	}
+	c := new(fakeClock)
+	s.now = c.now
+	return s, c
+}
+
+// stepper returns a TaskFunc that takes n steps, each
+// advancing c by dt and appending name to log.
+func stepper(c *fakeClock, log *[]string, name string, n int, dt time.Duration) TaskFunc {
+	return func() bool {
+		c.t = c.t.Add(dt)
+		*log = append(*log, name)
+		n--
+		return n == 0
+	}
+}
+
+func TestNewScheduler(t *testing.T) {
+	for _, x := range [...]time.Duration{0, -time.Millisecond} {
+		if _, err := NewScheduler(x); err == nil {
+			t.Fatalf("NewScheduler(%v): unexpected success", x)
+		}
+	}
+	s, err := NewScheduler(2 * time.Millisecond)
+	if err != nil {
+		t.Fatalf("NewScheduler failed:\n%v", err)
+	}
+	if have, want := s.Budget(), 2*time.Millisecond; have != want {
+		t.Fatalf("Scheduler.Budget:\nhave %v\nwant %v", have, want)
+	}
+	s.SetBudget(0)
+	if have, want := s.Budget(), time.Microsecond; have != want {
+		t.Fatalf("Scheduler.Budget:\nhave %v\nwant %v", have, want)
+	}
+	if _, err := s.Schedule(0, nil); err == nil {
+		t.Fatal("Scheduler.Schedule(0, nil): unexpected success")
+	}
+	if n := s.Run(); n != 0 {
+		t.Fatalf("Scheduler.Run:\nhave %d\nwant 0", n)
+	}
+}
+
+func TestSchedulerBudget(t *testing.T) {
+	s, c := newTestSched(t, 2*time.Millisecond)
+	var log []string
+	a, _ := s.Schedule(0, stepper(c, &log, "a", 8, 500*time.Microsecond))
+
+	for i, want := range [...]int{4, 4} {
+		if n := s.Run(); n != want {
+			t.Fatalf("Scheduler.Run #%d:\nhave %d\nwant %d", i, n, want)
+		}
+		if have, want := s.Used(), 2*time.Millisecond; have != want {
+			t.Fatalf("Scheduler.Used:\nhave %v\nwant %v", have, want)
+		}
+	}
+	if s.Pending(a) || s.Len() != 0 {
+		t.Fatal("Scheduler.Pending: task not completed")
+	}
+
+	// A step that exceeds the budget still
+	// executes, but only once per Run.
+	b, _ := s.Schedule(0, stepper(c, &log, "b", 2, 5*time.Millisecond))
+	for range 2 {
+		if n := s.Run(); n != 1 {
+			t.Fatalf("Scheduler.Run:\nhave %d\nwant 1", n)
+		}
+	}
+	if s.Pending(b) {
+		t.Fatal("Scheduler.Pending: task not completed")
+	}
+}
+
+func TestSchedulerPriority(t *testing.T) {
+	s, c := newTestSched(t, 3*time.Millisecond)
+	var log []string
+	s.Schedule(0, stepper(c, &log, "lo", 2, time.Millisecond))
+	s.Schedule(1, stepper(c, &log, "hi1", 2, time.Millisecond))
+	s.Schedule(1, stepper(c, &log, "hi2", 2, time.Millisecond))
+	s.Run()
+	s.Run()
+	want := []string{"hi1", "hi2", "hi1", "hi2", "lo", "lo"}
+	if !slices.Equal(log, want) {
+		t.Fatalf("Scheduler.Run: step order\nhave %v\nwant %v", log, want)
+	}
+
+	// Expensive steps are skipped in favor of
+	// cheaper ones that fit, but not when they
+	// would execute first.
+	log = nil
+	s.Schedule(1, stepper(c, &log, "big", 3, 2*time.Millisecond))
+	s.Schedule(0, stepper(c, &log, "small", 4, 500*time.Microsecond))
+	s.Run()
+	s.Run()
+	want = []string{"big", "small", "small", "big", "small", "small"}
+	if !slices.Equal(log, want) {
+		t.Fatalf("Scheduler.Run: step order\nhave %v\nwant %v", log, want)
+	}
+}
+
+func TestSchedulerCancel(t *testing.T) {
+	s, c := newTestSched(t, 4*time.Millisecond)
+	var log []string
+	a, _ := s.Schedule(0, stepper(c, &log, "a", 10, time.Millisecond))
+	var b Task
+	b, _ = s.Schedule(1, func() bool {
+		// Canceling itself from a step.
+		log = append(log, "b")
+		if !s.Cancel(b) {
+			t.Error("Scheduler.Cancel: unexpected failure")
+		}
+		return false
+	})
+	if !s.SetPriority(a, 2) {
+		t.Fatal("Scheduler.SetPriority: unexpected failure")
+	}
+	s.Run()
+	if !s.Cancel(a) {
+		t.Fatal("Scheduler.Cancel: unexpected failure")
+	}
+	if s.Cancel(a) || s.Pending(a) || s.SetPriority(a, 0) {
+		t.Fatal("Scheduler: canceled task still pending")
+	}
+	if n := s.Run(); n != 1 || s.Len() != 0 {
+		t.Fatalf("Scheduler.Run:\nhave %d steps, %d tasks\nwant 1 step, 0 tasks", n, s.Len())
+	}
+	want := []string{"a", "a", "a", "a", "b"}
+	if !slices.Equal(log, want) {
+		t.Fatalf("Scheduler.Run: step order\nhave %v\nwant %v", log, want)
+	}
+}
+
+func TestEstimateCost(t *testing.T) {
+	for _, x := range [...]struct {
+		cost, dt, want time.Duration
+	}{
+		{0, 100, 100},
+		{100, 200, 200},
+		{200, 200, 200},
+		{200, 0, 150},
+		{400, 200, 350},
+	} {
+		if have := estimateCost(x.cost, x.dt); have != x.want {
+			t.Fatalf("estimateCost(%v, %v):\nhave %v\nwant %v", x.cost, x.dt, have, x.want)
+		}
+	}
+}
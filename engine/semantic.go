@@ -0,0 +1,143 @@
+// Copyright 2024 Gustavo C. Viegas. All rights reserved.
+
+package engine
+
+import (
+	"sync"
+	"sync/atomic"
+
+	"gviegas/neo3/driver"
+	"gviegas/neo3/engine/internal/ctxt"
+)
+
+// MaxCustomSemantic is the maximum number of custom
+// semantics that can be registered.
+const MaxCustomSemantic = 8
+
+// SemanticParam describes a custom semantic.
+// Name is used for display purposes only.
+// Format is the format in which the semantic's data
+// is stored. MeshData must provide data in this same
+// format, since no conversion is done.
+// Location is the vertex shader input location that
+// the data is bound to. It must not be less than
+// MaxSemantic, since lower locations are reserved
+// for the predefined semantics, and must be unique
+// among custom semantics.
+type SemanticParam struct {
+	Name     string
+	Format   driver.VertexFmt
+	Location int
+}
+
+// Registered custom semantics.
+// Entries are never modified after being published
+// by incrementing n, so they can be read without
+// locking.
+var customSems struct {
+	sync.Mutex
+	params [MaxCustomSemantic]SemanticParam
+	n      atomic.Int32
+}
+
+// RegisterSemantic registers a custom semantic
+// (e.g., a second set of texture coordinates or the
+// previous position of animated vertices), returning
+// the Semantic that identifies it.
+// Custom semantics are provided by setting them in
+// PrimitiveData.SemanticMask and describing their
+// data in PrimitiveData.Custom (see
+// PrimitiveData.SetSemantic).
+// Registration is permanent.
+func RegisterSemantic(param *SemanticParam) (Semantic, error) {
+	customSems.Lock()
+	defer customSems.Unlock()
+	n := int(customSems.n.Load())
+	var reason string
+	switch {
+	case param == nil:
+		reason = "nil semantic param"
+	case n == MaxCustomSemantic:
+		reason = "too many custom semantics"
+	case param.Format.Size() < 1 || param.Format.Components() < 1:
+		reason = "invalid custom semantic format"
+	case param.Location < MaxSemantic || param.Location >= ctxt.Limits().MaxVertexIn:
+		reason = "custom semantic location out of range"
+	default:
+		for i := range n {
+			if customSems.params[i].Location == param.Location {
+				reason = "custom semantic location already in use"
+				goto invalidParam
+			}
+		}
+		customSems.params[n] = *param
+		customSems.n.Store(int32(n + 1))
+		return Semantic(1 << (MaxSemantic + n)), nil
+	}
+invalidParam:
+	return 0, newMeshErr(reason)
+}
+
+// semanticCount returns the number of semantics,
+// including registered custom ones.
+func semanticCount() int { return MaxSemantic + int(customSems.n.Load()) }
+
+// custom returns the parameters of s if it is a
+// registered custom semantic.
+func (s Semantic) custom() (*SemanticParam, bool) {
+	i := s.I() - MaxSemantic
+	if s&(s-1) != 0 || i < 0 || i >= int(customSems.n.Load()) {
+		return nil, false
+	}
+	return &customSems.params[i], true
+}
+
+// location returns the shader input location of s.
+func (s Semantic) location() int {
+	if p, ok := s.custom(); ok {
+		return p.Location
+	}
+	return s.I()
+}
+
+// semantic returns the data of the semantic at index
+// i, or nil if p has no room for it.
+func (p *PrimitiveData) semantic(i int) *SemanticData {
+	if i < MaxSemantic {
+		return &p.Semantics[i]
+	}
+	if i -= MaxSemantic; i < len(p.Custom) {
+		return &p.Custom[i]
+	}
+	return nil
+}
+
+// SetSemantic sets the data of s in p and adds s to
+// p.SemanticMask.
+// s must be either one of the predefined semantics
+// or a registered custom semantic.
+func (p *PrimitiveData) SetSemantic(s Semantic, data SemanticData) {
+	i := s.I()
+	if i >= MaxSemantic {
+		if _, ok := s.custom(); !ok {
+			panic("undefined Semantic constant")
+		}
+		if n := i - MaxSemantic + 1; len(p.Custom) < n {
+			p.Custom = append(p.Custom, make([]SemanticData, n-len(p.Custom))...)
+		}
+	}
+	*p.semantic(i) = data
+	p.SemanticMask |= s
+}
+
+// attr returns the vertex data of the semantic at
+// index i, or nil if p has no room for it.
+func (p *primitive) attr(i int) *vertexData {
+	if i < MaxSemantic {
+		return &p.vertex[i]
+	}
+	if i -= MaxSemantic; i < len(p.custom) {
+		return &p.custom[i]
+	}
+	return nil
+}
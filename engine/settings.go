@@ -0,0 +1,349 @@
+// Copyright 2024 Gustavo C. Viegas. All rights reserved.
+
+package engine
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"gviegas/neo3/driver"
+	"gviegas/neo3/engine/internal/ctxt"
+)
+
+// Quality presets.
+const (
+	QualityLow = iota
+	QualityMedium
+	QualityHigh
+	QualityUltra
+)
+
+// qualityNames are the names of the quality presets,
+// as used in settings files.
+var qualityNames = [...]string{
+	QualityLow:    "low",
+	QualityMedium: "medium",
+	QualityHigh:   "high",
+	QualityUltra:  "ultra",
+}
+
+// transpNames are the names of the transparency
+// modes, as used in settings files.
+var transpNames = [...]string{
+	TranspSortObject:    "sort_object",
+	TranspSortPrimitive: "sort_primitive",
+	TranspOIT:           "oit",
+}
+
+// Settings is a profile of quality settings.
+// ShadowResolution and StreamingBudget are global
+// (see Tuning). MSAA is the sample count of the
+// renderer (see Renderer.SetMSAA) and Transparency
+// its transparency mode (see
+// Renderer.SetTransparency).
+// SSRSteps is the maximum number of steps of
+// screen-space reflections (see SSRParam.MaxSteps)
+// and MotionBlurSamples the number of samples of
+// motion blur (see MotionBlurParam.Samples). Zero
+// disables the respective effect.
+type Settings struct {
+	ShadowResolution  int
+	StreamingBudget   int64
+	MSAA              int
+	Transparency      int
+	SSRSteps          int
+	MotionBlurSamples int
+}
+
+// Preset returns the settings of a quality preset.
+// Values that the driver does not support are
+// lowered to the largest that it does, and effects
+// that are not supported yet are disabled.
+func Preset(quality int) (Settings, error) {
+	budget := defaultConfig.Tuning.StreamingBudget
+	var s Settings
+	switch quality {
+	case QualityLow:
+		s = Settings{256, budget / 2, 1, TranspSortObject, 0, 0}
+	case QualityMedium:
+		s = Settings{512, budget, 2, TranspSortObject, 0, 8}
+	case QualityHigh:
+		s = Settings{1024, budget * 2, 4, TranspSortPrimitive, 32, motionSamples}
+	case QualityUltra:
+		s = Settings{2048, budget * 4, 8, TranspOIT, 64, 24}
+	default:
+		return Settings{}, newRendErr("undefined quality preset")
+	}
+	limits := ctxt.Limits()
+	for s.ShadowResolution > max(limits.MaxImageCube, 1) ||
+		s.ShadowResolution > max(min(limits.MaxRenderSize[0], limits.MaxRenderSize[1]), 1) {
+		s.ShadowResolution >>= 1
+	}
+	s.MSAA = supportedSamples(s.MSAA)
+	if checkPending("SSR", ssrPasses[:]...) != nil {
+		s.SSRSteps = 0
+	}
+	if checkPending("motion blur", motionPasses[:]...) != nil {
+		s.MotionBlurSamples = 0
+	}
+	return s, nil
+}
+
+// validate checks whether s is valid.
+func (s *Settings) validate() error {
+	tun := Tuning{
+		StreamingBudget:  s.StreamingBudget,
+		ShadowResolution: s.ShadowResolution,
+		MSAA:             1,
+	}
+	var reason string
+	switch {
+	case s.MSAA < 1:
+		reason = "invalid MSAA sample count"
+	case s.Transparency < 0 || s.Transparency >= len(transpNames):
+		reason = "undefined transparency mode"
+	case s.SSRSteps < 0:
+		reason = "invalid SSR step count"
+	case s.MotionBlurSamples < 0 || s.MotionBlurSamples > motionMaxSamples:
+		reason = "invalid motion blur sample count"
+	default:
+		return tun.validate()
+	}
+	return newRendErr(reason)
+}
+
+// Default parameters of effects that are enabled by
+// ApplySettings.
+var (
+	settingsSSRParam    = SSRParam{Thickness: 0.1, MaxRoughness: 0.5, EdgeFade: 0.1}
+	settingsMotionParam = MotionBlurParam{Shutter: 0.5}
+)
+
+// Settings returns the current settings of r.
+func (r *Renderer) Settings() Settings {
+	t := CurrentTuning()
+	s := Settings{
+		ShadowResolution: t.ShadowResolution,
+		StreamingBudget:  t.StreamingBudget,
+		MSAA:             r.MSAA(),
+		Transparency:     r.transp,
+	}
+	if p, ok := r.SSR(); ok {
+		s.SSRSteps = p.MaxSteps
+	}
+	if p, ok := r.MotionBlur(); ok {
+		s.MotionBlurSamples = p.Samples
+		if p.Samples == 0 {
+			s.MotionBlurSamples = motionSamples
+		}
+	}
+	return s
+}
+
+// ApplySettings changes the settings of r.
+// It waits for the frames that r has submitted to
+// complete, so that resources used by them can be
+// recreated (e.g., the multi-sample and OIT targets),
+// and thus must be called between frames.
+// Effects that are enabled by s keep their other
+// parameters if already enabled in r, and use
+// defaults otherwise.
+// Shadow maps created afterwards use the new
+// ShadowResolution.
+// If it fails, the previous settings are restored.
+func (r *Renderer) ApplySettings(s *Settings) error {
+	if s == nil {
+		return newRendErr("nil settings")
+	}
+	if err := s.validate(); err != nil {
+		return err
+	}
+	r.wait()
+	old := r.Settings()
+	if err := r.applySettings(s); err != nil {
+		// Restoring only frees resources or
+		// recreates ones that existed before,
+		// so it is not expected to fail.
+		r.applySettings(&old)
+		return err
+	}
+	return nil
+}
+
+// applySettings sets the settings of r.
+// s must be valid.
+func (r *Renderer) applySettings(s *Settings) error {
+	t := CurrentTuning()
+	t.ShadowResolution = s.ShadowResolution
+	t.StreamingBudget = s.StreamingBudget
+	setTuning(&t)
+	if err := r.SetMSAA(s.MSAA); err != nil {
+		return err
+	}
+	if err := r.SetTransparency(s.Transparency); err != nil {
+		return err
+	}
+	if s.SSRSteps == 0 {
+		r.SetSSR(nil)
+	} else {
+		p, ok := r.SSR()
+		if !ok {
+			p = settingsSSRParam
+		}
+		p.MaxSteps = s.SSRSteps
+		if err := r.SetSSR(&p); err != nil {
+			return err
+		}
+	}
+	if s.MotionBlurSamples == 0 {
+		r.SetMotionBlur(nil)
+	} else {
+		p, ok := r.MotionBlur()
+		if !ok {
+			p = settingsMotionParam
+		}
+		p.Samples = s.MotionBlurSamples
+		if err := r.SetMotionBlur(&p); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// wait waits for the frames that r has submitted to
+// complete.
+func (r *Renderer) wait() {
+	var wk [NFrame]*driver.WorkItem
+	for i := range cap(r.ch) {
+		wk[i] = <-r.ch
+	}
+	for i := range cap(r.ch) {
+		r.ch <- wk[i]
+	}
+}
+
+// Keys of settings files.
+const (
+	settingsPreset       = "preset"
+	settingsShadow       = "shadow_resolution"
+	settingsBudget       = "streaming_budget"
+	settingsMSAA         = "msaa"
+	settingsTransparency = "transparency"
+	settingsSSR          = "ssr_steps"
+	settingsMotionBlur   = "motion_blur_samples"
+)
+
+// LoadSettings reads a settings file into s.
+// Each line contains a key and its value, separated
+// by whitespace. Blank lines and lines starting with
+// '#' are ignored. The keys are:
+//
+//	preset              low, medium, high or ultra
+//	shadow_resolution   int
+//	streaming_budget    int
+//	msaa                int
+//	transparency        sort_object, sort_primitive or oit
+//	ssr_steps           int
+//	motion_blur_samples int
+//
+// A preset replaces every setting with those of
+// the given quality preset (see Preset), so later
+// lines override it. Settings not in the file are
+// not changed.
+// Every line is applied, even if previous lines
+// fail. The errors of the lines that fail are joined
+// and returned, along with their line numbers.
+// s is not validated (ApplySettings does it).
+func LoadSettings(r io.Reader, s *Settings) error {
+	var errs []error
+	sc := bufio.NewScanner(r)
+	for n := 1; sc.Scan(); n++ {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" || line[0] == '#' {
+			continue
+		}
+		key, value := line, ""
+		if i := strings.IndexFunc(line, unicode.IsSpace); i >= 0 {
+			key, value = line[:i], strings.TrimSpace(line[i:])
+		}
+		if err := s.set(key, value); err != nil {
+			errs = append(errs, fmt.Errorf("line %d: %w", n, err))
+		}
+	}
+	if err := sc.Err(); err != nil {
+		errs = append(errs, err)
+	}
+	return errors.Join(errs...)
+}
+
+// set sets the setting identified by a key of
+// settings files.
+func (s *Settings) set(key, value string) error {
+	var dst *int
+	switch key {
+	case settingsPreset:
+		q := slices.Index(qualityNames[:], value)
+		if q < 0 {
+			return newRendErr("undefined quality preset: " + value)
+		}
+		p, err := Preset(q)
+		if err != nil {
+			return err
+		}
+		*s = p
+		return nil
+	case settingsTransparency:
+		m := slices.Index(transpNames[:], value)
+		if m < 0 {
+			return newRendErr("undefined transparency mode: " + value)
+		}
+		s.Transparency = m
+		return nil
+	case settingsBudget:
+		x, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return newRendErr("invalid " + key + " value " + strconv.Quote(value))
+		}
+		s.StreamingBudget = x
+		return nil
+	case settingsShadow:
+		dst = &s.ShadowResolution
+	case settingsMSAA:
+		dst = &s.MSAA
+	case settingsSSR:
+		dst = &s.SSRSteps
+	case settingsMotionBlur:
+		dst = &s.MotionBlurSamples
+	default:
+		return newRendErr("undefined setting: " + key)
+	}
+	x, err := strconv.Atoi(value)
+	if err != nil {
+		return newRendErr("invalid " + key + " value " + strconv.Quote(value))
+	}
+	*dst = x
+	return nil
+}
+
+// SaveSettings writes s to w, in the format that
+// LoadSettings reads. Every setting is written.
+func SaveSettings(w io.Writer, s *Settings) error {
+	transp := strconv.Itoa(s.Transparency)
+	if s.Transparency >= 0 && s.Transparency < len(transpNames) {
+		transp = transpNames[s.Transparency]
+	}
+	_, err := fmt.Fprintf(w, "%s %d\n%s %d\n%s %d\n%s %s\n%s %d\n%s %d\n",
+		settingsShadow, s.ShadowResolution,
+		settingsBudget, s.StreamingBudget,
+		settingsMSAA, s.MSAA,
+		settingsTransparency, transp,
+		settingsSSR, s.SSRSteps,
+		settingsMotionBlur, s.MotionBlurSamples)
+	return err
+}
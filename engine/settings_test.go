@@ -0,0 +1,133 @@
+// Copyright 2024 Gustavo C. Viegas. All rights reserved.
+
+package engine
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestPreset(t *testing.T) {
+	var prev Settings
+	for q := QualityLow; q <= QualityUltra; q++ {
+		s, err := Preset(q)
+		if err != nil {
+			t.Fatalf("Preset(%d) failed:\n%v", q, err)
+		}
+		if err := s.validate(); err != nil {
+			t.Fatalf("Preset(%d): invalid settings\n%v", q, err)
+		}
+		// These are not supported yet.
+		if s.SSRSteps != 0 || s.MotionBlurSamples != 0 {
+			t.Fatalf("Preset(%d): pending effects should be disabled\nhave %+v", q, s)
+		}
+		if q > QualityLow && (s.ShadowResolution < prev.ShadowResolution || s.MSAA < prev.MSAA || s.SSRSteps < prev.SSRSteps) {
+			t.Fatalf("Preset(%d): lower quality than Preset(%d)\nhave %+v\nprev %+v", q, q-1, s, prev)
+		}
+		prev = s
+	}
+	if _, err := Preset(QualityUltra + 1); err == nil {
+		t.Fatal("Preset: undefined preset should have failed")
+	}
+}
+
+func TestLoadSettings(t *testing.T) {
+	var s Settings
+	cfg := "# comment\npreset low\nmsaa 2\n\ntransparency  oit\nssr_steps x\nfoo 1\n"
+	err := LoadSettings(strings.NewReader(cfg), &s)
+	if err == nil || !strings.Contains(err.Error(), "line 6: ") || !strings.Contains(err.Error(), "line 7: ") {
+		t.Fatalf("LoadSettings: lines 6 and 7 should have failed\n%v", err)
+	}
+	want, _ := Preset(QualityLow)
+	want.MSAA = 2
+	want.Transparency = TranspOIT
+	if s != want {
+		t.Fatalf("LoadSettings:\nhave %+v\nwant %+v", s, want)
+	}
+
+	var buf bytes.Buffer
+	if err := SaveSettings(&buf, &s); err != nil {
+		t.Fatalf("SaveSettings failed:\n%v", err)
+	}
+	if !strings.Contains(buf.String(), "transparency oit\n") {
+		t.Fatalf("SaveSettings: missing transparency in\n%s", buf.String())
+	}
+	var x Settings
+	if err := LoadSettings(&buf, &x); err != nil {
+		t.Fatalf("LoadSettings failed:\n%v", err)
+	}
+	if x != s {
+		t.Fatalf("LoadSettings(SaveSettings):\nhave %+v\nwant %+v", x, s)
+	}
+}
+
+func TestApplySettings(t *testing.T) {
+	old := CurrentTuning()
+	defer SetTuning(&old)
+	rend, err := NewOffscreen(64, 48)
+	if err != nil {
+		t.Fatalf("NewOffscreen failed:\n%v", err)
+	}
+	defer rend.Free()
+
+	for _, q := range [...]int{QualityUltra, QualityLow, QualityHigh} {
+		s, _ := Preset(q)
+		if err := rend.ApplySettings(&s); err != nil {
+			t.Fatalf("Renderer.ApplySettings(%s) failed:\n%v", qualityNames[q], err)
+		}
+		if x := rend.Settings(); x != s {
+			t.Fatalf("Renderer.ApplySettings(%s):\nhave %+v\nwant %+v", qualityNames[q], x, s)
+		}
+		if _, ok := rend.SSR(); ok != (s.SSRSteps > 0) {
+			t.Fatalf("Renderer.ApplySettings(%s): SSR enabled\nhave %t\nwant %t", qualityNames[q], ok, !ok)
+		}
+		if x := CurrentTuning().ShadowResolution; x != s.ShadowResolution {
+			t.Fatalf("Renderer.ApplySettings(%s): Tuning.ShadowResolution\nhave %d\nwant %d", qualityNames[q], x, s.ShadowResolution)
+		}
+	}
+
+	// Effects that are not supported yet
+	// cannot be enabled.
+	s, _ := Preset(QualityHigh)
+	s.SSRSteps = 32
+	if err := rend.ApplySettings(&s); !errors.Is(err, ErrUnsupported) {
+		t.Fatalf("Renderer.ApplySettings: pending SSR passes\nhave %v\nwant %v", err, ErrUnsupported)
+	}
+	if _, ok := rend.SSR(); ok {
+		t.Fatal("Renderer.ApplySettings: SSR should not be enabled")
+	}
+	defer allowPending(append(ssrPasses[:], motionPasses[:]...)...)()
+
+	// Effects keep their other parameters.
+	mb := MotionBlurParam{Shutter: 0.25, MaxBlur: 16}
+	if err := rend.SetMotionBlur(&mb); err != nil {
+		t.Fatalf("Renderer.SetMotionBlur failed:\n%v", err)
+	}
+	s = rend.Settings()
+	s.MotionBlurSamples = 4
+	if err := rend.ApplySettings(&s); err != nil {
+		t.Fatalf("Renderer.ApplySettings failed:\n%v", err)
+	}
+	mb.Samples = 4
+	if x, _ := rend.MotionBlur(); x != mb {
+		t.Fatalf("Renderer.ApplySettings: MotionBlurParam\nhave %+v\nwant %+v", x, mb)
+	}
+
+	want := rend.Settings()
+	for _, s := range [...]Settings{
+		{ShadowResolution: 500, StreamingBudget: 1, MSAA: 1},
+		{ShadowResolution: 512, StreamingBudget: 0, MSAA: 1},
+		{ShadowResolution: 512, StreamingBudget: 1, MSAA: 0},
+		{ShadowResolution: 512, StreamingBudget: 1, MSAA: 1, Transparency: -1},
+		{ShadowResolution: 512, StreamingBudget: 1, MSAA: 1, MotionBlurSamples: motionMaxSamples + 1},
+	} {
+		if err := rend.ApplySettings(&s); err == nil {
+			t.Fatalf("Renderer.ApplySettings(%+v): should have failed", s)
+		}
+	}
+	if x := rend.Settings(); x != want {
+		t.Fatalf("Renderer.ApplySettings: settings changed on failure\nhave %+v\nwant %+v", x, want)
+	}
+}
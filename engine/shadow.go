@@ -0,0 +1,127 @@
+// Copyright 2024 Gustavo C. Viegas. All rights reserved.
+
+package engine
+
+import (
+	"math/bits"
+
+	"gviegas/neo3/driver"
+	"gviegas/neo3/engine/internal/ctxt"
+	"gviegas/neo3/linear"
+)
+
+// ShadowCubeSize is the default width and height of
+// each face of a point light's shadow cubemap.
+// See Tuning.ShadowResolution.
+const ShadowCubeSize = 512
+
+// shadowFmt is the pixel format of shadow maps.
+const shadowFmt = driver.D16Unorm
+
+// LayeredCube returns whether r renders every face of
+// a cube shadow map (as used by point lights) in a
+// single render pass.
+// This requires driver.Features.Multiview: each face
+// is a view that the driver routes to the matching
+// layer of the cubemap. Otherwise, faces are rendered
+// in separate passes. There is no geometry shader
+// fallback since the driver has no such stage.
+func (r *Renderer) LayeredCube() bool { return ctxt.Features().Multiview }
+
+// newShadowCube creates a cube depth texture suitable
+// for point light shadows.
+// cubes is the number of cubemaps in the texture.
+// Its size is the current Tuning.ShadowResolution.
+func newShadowCube(cubes int) (*Texture, error) {
+	size := CurrentTuning().ShadowResolution
+	return newCube(&TexParam{
+		PixelFmt: shadowFmt,
+		Dim3D:    driver.Dim3D{Width: size, Height: size},
+		Layers:   6 * cubes,
+		Levels:   1,
+		Samples:  1,
+	}, driver.UShaderSample|driver.URenderTarget)
+}
+
+// cubePass is a render pass that draws to one or more
+// faces of a cube shadow map.
+type cubePass struct {
+	// Layer of the first face in the texture.
+	layer int
+	// Faces drawn, relative to layer.
+	// If this is not 1, the pass is a multiview
+	// pass whose view i draws to layer+i.
+	faces uint32
+}
+
+// cubePasses appends to dst the passes that draw the
+// given faces of the cube at index cube of a cube
+// texture. faces is a mask of cube faces, ordered
+// +X, -X, +Y, -Y, +Z, -Z (see allFaces).
+// If layered is true, a single pass is appended.
+// Otherwise, one pass is appended per face.
+func cubePasses(dst []cubePass, cube int, faces uint32, layered bool) []cubePass {
+	faces &= allFaces
+	if faces == 0 {
+		return dst
+	}
+	if layered {
+		return append(dst, cubePass{6 * cube, faces})
+	}
+	for f := range 6 {
+		if faces&(1<<f) != 0 {
+			dst = append(dst, cubePass{6*cube + f, 1})
+		}
+	}
+	return dst
+}
+
+// viewMask returns the view mask of pipelines used in
+// p (i.e., GraphState.ViewMask).
+func (p *cubePass) viewMask() uint32 {
+	if p.faces == 1 {
+		return 0
+	}
+	return p.faces
+}
+
+// begin begins p in cb, clearing the depth of the faces
+// that it draws to in tex.
+// The faces must be in the driver.LDSTarget layout.
+// In a multiview pass, shaders must select the view
+// transform of each face (see shadowCubeView) using the
+// view index.
+// The caller must record the draws and end the pass.
+func (p *cubePass) begin(cb driver.CmdBuffer, tex *Texture) error {
+	view, err := tex.NewView(&ViewParam{
+		Layer:  p.layer,
+		Layers: bits.Len32(p.faces),
+		Levels: 1,
+	})
+	if err != nil {
+		return err
+	}
+	iv, err := view.imageView()
+	if err != nil {
+		return err
+	}
+	ds := &driver.DSTarget{
+		DS:     iv,
+		LoadD:  driver.LClear,
+		StoreD: driver.SStore,
+		ClearD: 1,
+	}
+	size := tex.Width()
+	if vm := p.viewMask(); vm != 0 {
+		cb.BeginPassViews(size, size, vm, nil, ds)
+	} else {
+		cb.BeginPass(size, size, 1, nil, ds)
+	}
+	return nil
+}
+
+// shadowCubeView returns the view transform used to
+// render the given face of a point light's shadow
+// cubemap, with the light at pos.
+// It uses the same conventions as probeView.
+func shadowCubeView(pos *linear.V3, face int) linear.M4 { return probeView(pos, face) }
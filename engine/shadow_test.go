@@ -0,0 +1,88 @@
+// Copyright 2024 Gustavo C. Viegas. All rights reserved.
+
+package engine
+
+import (
+	"slices"
+	"testing"
+
+	"gviegas/neo3/driver"
+	"gviegas/neo3/engine/internal/ctxt"
+	"gviegas/neo3/linear"
+)
+
+func TestCubePasses(t *testing.T) {
+	for _, x := range [...]struct {
+		cube    int
+		faces   uint32
+		layered bool
+		want    []cubePass
+	}{
+		{0, allFaces, true, []cubePass{{0, allFaces}}},
+		{2, allFaces, true, []cubePass{{12, allFaces}}},
+		{1, 0b110100, true, []cubePass{{6, 0b110100}}},
+		{0, allFaces, false, []cubePass{{0, 1}, {1, 1}, {2, 1}, {3, 1}, {4, 1}, {5, 1}}},
+		{1, 0b100010, false, []cubePass{{7, 1}, {11, 1}}},
+		{0, 0, true, nil},
+		{0, 1 << 6, false, nil},
+	} {
+		have := cubePasses(nil, x.cube, x.faces, x.layered)
+		if !slices.Equal(have, x.want) {
+			t.Fatalf("cubePasses(nil, %d, %#b, %t):\nhave %v\nwant %v", x.cube, x.faces, x.layered, have, x.want)
+		}
+		for _, p := range have {
+			if have, want := p.viewMask(), p.faces; x.layered && have != want {
+				t.Fatalf("cubePass.viewMask:\nhave %#b\nwant %#b", have, want)
+			} else if !x.layered && have != 0 {
+				t.Fatalf("cubePass.viewMask:\nhave %#b\nwant 0", have)
+			}
+		}
+	}
+}
+
+func TestShadowCube(t *testing.T) {
+	var r Renderer
+	if have, want := r.LayeredCube(), ctxt.Features().Multiview; have != want {
+		t.Fatalf("Renderer.LayeredCube:\nhave %t\nwant %t", have, want)
+	}
+
+	tex, err := newShadowCube(2)
+	if err != nil {
+		t.Fatalf("newShadowCube failed:\n%v", err)
+	}
+	defer tex.Free()
+	if tex.Layers() != 12 || tex.PixelFmt() != shadowFmt || tex.Width() != ShadowCubeSize {
+		t.Fatalf("newShadowCube: unexpected texture params\n%v", tex.param)
+	}
+
+	cb, err := ctxt.GPU().NewCmdBuffer()
+	if err != nil {
+		t.Fatalf("driver.GPU.NewCmdBuffer failed:\n%v", err)
+	}
+	defer cb.Destroy()
+	if err = cb.Begin(); err != nil {
+		t.Fatalf("driver.CmdBuffer.Begin failed:\n%v", err)
+	}
+	tex.transition(1, cb, driver.LDSTarget, driver.Barrier{
+		SyncAfter:   driver.SDSOutput,
+		AccessAfter: driver.ADSRead | driver.ADSWrite,
+	})
+	for _, p := range cubePasses(nil, 1, allFaces, r.LayeredCube()) {
+		if err := p.begin(cb, tex); err != nil {
+			t.Fatalf("cubePass.begin failed:\n%v", err)
+		}
+		cb.EndPass()
+	}
+	if err = cb.End(); err != nil {
+		t.Fatalf("driver.CmdBuffer.End failed:\n%v", err)
+	}
+}
+
+func TestShadowCubeView(t *testing.T) {
+	pos := linear.V3{1, 2, -3}
+	for i := range 6 {
+		if have, want := shadowCubeView(&pos, i), probeView(&pos, i); have != want {
+			t.Fatalf("shadowCubeView(%v, %d):\nhave %v\nwant %v", pos, i, have, want)
+		}
+	}
+}
@@ -3,7 +3,6 @@
 package engine
 
 import (
-	"errors"
 	"sort"
 
 	"gviegas/neo3/linear"
@@ -11,7 +10,7 @@ import (
 
 const skinPrefix = "skin: "
 
-func newSkinErr(reason string) error { return errors.New(skinPrefix + reason) }
+func newSkinErr(reason string) error { return newErr(skinPrefix, reason, ErrInvalidParam) }
 
 // Skin defines skinning data.
 type Skin struct {
@@ -22,6 +21,13 @@ type Skin struct {
 	// Sorted such that every parent comes
 	// before any of its descendants.
 	hier []int
+	// Joint transforms in the skin's space.
+	// Computed on demand.
+	global []linear.M4
+	stale  bool
+	// Incremented whenever a joint matrix
+	// changes.
+	gen uint64
 
 	// TODO: Descriptors; const buffer
 	// (per-instance, most likely).
@@ -117,5 +123,55 @@ func NewSkin(joints []Joint) (*Skin, error) {
 		hier[i] = wgts[i].idx
 	}
 
-	return &Skin{js, ibm, hier}, nil
+	return &Skin{
+		joints: js,
+		ibm:    ibm,
+		hier:   hier,
+		stale:  true,
+	}, nil
+}
+
+// JointLen returns the number of joints in s.
+func (s *Skin) JointLen() int { return len(s.joints) }
+
+// JointIndex returns the index of the first joint
+// in s whose name is name, or -1 if there is no
+// such joint.
+func (s *Skin) JointIndex(name string) int {
+	for i := range s.joints {
+		if s.joints[i].name == name {
+			return i
+		}
+	}
+	return -1
+}
+
+// SetJoint sets the joint matrix of the joint at
+// index i.
+// This is the joint's transform relative to its
+// parent, as in Joint.JM.
+func (s *Skin) SetJoint(i int, jm *linear.M4) {
+	s.joints[i].jm = *jm
+	s.stale = true
+	s.gen++
+}
+
+// JointTransform returns the transform of the joint
+// at index i in the skin's space (i.e., the product
+// of the joint matrices from the root joint to i).
+func (s *Skin) JointTransform(i int) linear.M4 {
+	if s.stale {
+		if s.global == nil {
+			s.global = make([]linear.M4, len(s.joints))
+		}
+		for _, j := range s.hier {
+			if pnt := s.joints[j].parent; pnt >= 0 {
+				s.global[j].Mul(&s.global[pnt], &s.joints[j].jm)
+			} else {
+				s.global[j] = s.joints[j].jm
+			}
+		}
+		s.stale = false
+	}
+	return s.global[i]
 }
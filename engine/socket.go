@@ -0,0 +1,94 @@
+// Copyright 2024 Gustavo C. Viegas. All rights reserved.
+
+package engine
+
+import (
+	"gviegas/neo3/linear"
+)
+
+// Socket is a node.Interface whose local transform
+// follows a joint of a Skin.
+// It is meant to be inserted into a node.Graph as a
+// descendant of the node that holds the skinned
+// mesh, so that nodes attached to it (e.g., a weapon
+// or a camera) move with the joint.
+// The local transform of a Socket is the joint's
+// transform in the skin's space, followed by an
+// offset.
+type Socket struct {
+	skin   *Skin
+	joint  int
+	offset linear.M4
+	local  linear.M4
+	// Skin.gen as of the last update.
+	gen     uint64
+	changed bool
+}
+
+// NewSocket creates a new Socket that follows the
+// joint of skin at index joint.
+// offset is applied after the joint's transform. It
+// can be nil, in which case the identity is used.
+func NewSocket(skin *Skin, joint int, offset *linear.M4) (*Socket, error) {
+	switch {
+	case skin == nil:
+		return nil, newSkinErr("nil Socket skin")
+	case joint < 0 || joint >= len(skin.joints):
+		return nil, newSkinErr("Socket joint out of bounds")
+	}
+	s := &Socket{
+		skin:    skin,
+		joint:   joint,
+		offset:  linear.I4(),
+		changed: true,
+	}
+	if offset != nil {
+		s.offset = *offset
+	}
+	s.update()
+	return s, nil
+}
+
+// Skin returns the Skin that s follows.
+func (s *Socket) Skin() *Skin { return s.skin }
+
+// Joint returns the index of the joint that s
+// follows.
+func (s *Socket) Joint() int { return s.joint }
+
+// SetOffset sets the offset of s.
+func (s *Socket) SetOffset(offset *linear.M4) {
+	s.offset = *offset
+	s.changed = true
+}
+
+// Offset returns the offset of s.
+func (s *Socket) Offset() linear.M4 { return s.offset }
+
+// update recomputes s.local.
+func (s *Socket) update() {
+	jt := s.skin.JointTransform(s.joint)
+	s.local.Mul(&jt, &s.offset)
+	s.gen = s.skin.gen
+}
+
+// Local implements node.Interface.
+func (s *Socket) Local() *linear.M4 { return &s.local }
+
+// Changed implements node.Interface.
+// The local transform is refreshed here, since
+// node.Graph.Update calls Changed before Local.
+// Thus joint matrices must be set before the graph
+// is updated for the change to take effect in the
+// same frame.
+func (s *Socket) Changed() bool {
+	if s.gen != s.skin.gen {
+		s.changed = true
+	}
+	if !s.changed {
+		return false
+	}
+	s.update()
+	s.changed = false
+	return true
+}
@@ -0,0 +1,93 @@
+// Copyright 2024 Gustavo C. Viegas. All rights reserved.
+
+package engine
+
+import (
+	"testing"
+
+	"gviegas/neo3/linear"
+	"gviegas/neo3/node"
+)
+
+// staticNode is a node.Interface that never changes
+// after the first update.
+type staticNode struct {
+	local linear.M4
+	done  bool
+}
+
+func (n *staticNode) Local() *linear.M4 { return &n.local }
+
+func (n *staticNode) Changed() bool {
+	if n.done {
+		return false
+	}
+	n.done = true
+	return true
+}
+
+func translate(x, y, z float32) (m linear.M4) {
+	m.Translate(x, y, z)
+	return
+}
+
+func TestSocket(t *testing.T) {
+	sk, err := NewSkin([]Joint{
+		{Name: "hand", JM: translate(0, 2, 0), Parent: 1},
+		{Name: "root", JM: translate(1, 0, 0), Parent: -1},
+	})
+	if err != nil {
+		t.Fatalf("NewSkin failed:\n%v", err)
+	}
+	if x := sk.JointLen(); x != 2 {
+		t.Fatalf("Skin.JointLen:\nhave %d\nwant 2", x)
+	}
+	if x, y := sk.JointIndex("hand"), sk.JointIndex("foot"); x != 0 || y != -1 {
+		t.Fatalf("Skin.JointIndex:\nhave %d, %d\nwant 0, -1", x, y)
+	}
+	if x := sk.JointTransform(0); x != translate(1, 2, 0) {
+		t.Fatalf("Skin.JointTransform:\nhave %v\nwant %v", x, translate(1, 2, 0))
+	}
+
+	for _, x := range [...]struct {
+		sk    *Skin
+		joint int
+	}{{nil, 0}, {sk, -1}, {sk, 2}} {
+		if _, err := NewSocket(x.sk, x.joint, nil); err == nil {
+			t.Fatalf("NewSocket(%v, %d): unexpected success", x.sk, x.joint)
+		}
+	}
+	off := translate(0, 0, 3)
+	sock, err := NewSocket(sk, sk.JointIndex("hand"), &off)
+	if err != nil {
+		t.Fatalf("NewSocket failed:\n%v", err)
+	}
+	if sock.Skin() != sk || sock.Joint() != 0 || sock.Offset() != off {
+		t.Fatal("NewSocket: unexpected Socket state")
+	}
+
+	var g node.Graph
+	body := g.Insert(&staticNode{local: translate(10, 0, 0)}, node.Nil)
+	sn := g.Insert(sock, body)
+	weapon := g.Insert(&staticNode{local: linear.I4()}, sn)
+	check := func(want linear.M4) {
+		t.Helper()
+		if x := *g.World(weapon); x != want {
+			t.Fatalf("Graph.World:\nhave %v\nwant %v", x, want)
+		}
+	}
+	g.Update()
+	check(translate(11, 2, 3))
+	if sock.Changed() {
+		t.Fatal("Socket.Changed: unexpected change")
+	}
+
+	sk.SetJoint(1, &linear.M4{{1}, {1: 1}, {2: 1}, {5, 0, 0, 1}})
+	g.Update()
+	check(translate(15, 2, 3))
+
+	off = translate(0, -1, 0)
+	sock.SetOffset(&off)
+	g.Update()
+	check(translate(15, 1, 0))
+}
@@ -0,0 +1,177 @@
+// Copyright 2024 Gustavo C. Viegas. All rights reserved.
+
+package engine
+
+import (
+	"gviegas/neo3/driver"
+	"gviegas/neo3/engine/internal/shader"
+)
+
+// SSRParam describes screen-space reflections.
+// MaxSteps is the maximum number of ray march steps.
+// Rays are traced through a hierarchical depth buffer,
+// so relatively few steps can cover the whole screen.
+// Thickness is the depth assumed for surfaces, in view
+// space units, when testing rays for intersection.
+// MaxRoughness is the roughness above which SSR is not
+// used at all. Below it, reflections are increasingly
+// blurred as roughness increases, and then blended
+// with reflection probes (or the global LD map) as
+// roughness approaches MaxRoughness.
+// EdgeFade is the fraction of the screen, from its
+// borders inwards, over which reflections fade out.
+type SSRParam struct {
+	MaxSteps     int
+	Thickness    float32
+	MaxRoughness float32
+	EdgeFade     float32
+}
+
+// ssrBlurLevels is the maximum number of mip levels
+// used for blurring reflections.
+const ssrBlurLevels = 5
+
+// ssr is the state of the SSR passes.
+type ssr struct {
+	param  SSRParam
+	layout shader.SSRLayout
+	// Hierarchical min/max depth.
+	hiz *Texture
+	// Reflected color, whose mip levels are
+	// increasingly blurred.
+	refl *Texture
+}
+
+// Names of the SSR passes.
+const (
+	ssrHiZPass   = "ssr.hiz"
+	ssrTracePass = "ssr.trace"
+)
+
+// ssrPasses are the passes that SSR needs.
+var ssrPasses = [...]string{ssrHiZPass, ssrTracePass}
+
+// SetSSR enables screen-space reflections in r.
+// If param is nil, SSR is disabled.
+// It fails with ErrUnsupported while the SSR passes
+// are not recorded.
+func (r *Renderer) SetSSR(param *SSRParam) error {
+	if param == nil {
+		r.freeSSR()
+		return nil
+	}
+	var reason string
+	switch {
+	case param.MaxSteps < 1:
+		reason = "invalid SSR step count"
+	case param.Thickness <= 0:
+		reason = "invalid SSR thickness"
+	case param.MaxRoughness < 0 || param.MaxRoughness > 1:
+		reason = "SSR roughness out of range"
+	case param.EdgeFade < 0 || param.EdgeFade > 0.5:
+		reason = "SSR edge fade out of range"
+	default:
+		goto validParam
+	}
+	return newRendErr(reason)
+validParam:
+	if err := checkPending("SSR", ssrPasses[:]...); err != nil {
+		return err
+	}
+	if r.ssr == nil {
+		if err := r.initSSR(); err != nil {
+			return err
+		}
+	}
+	r.ssr.param = *param
+	r.ssr.layout.SetSteps(param.MaxSteps)
+	r.ssr.layout.SetThickness(param.Thickness)
+	r.ssr.layout.SetMaxRough(param.MaxRoughness)
+	r.ssr.layout.SetEdgeFade(param.EdgeFade)
+	return nil
+}
+
+// SSR returns the SSR parameters of r.
+// If SSR is disabled, it returns false.
+func (r *Renderer) SSR() (SSRParam, bool) {
+	if r.ssr == nil {
+		return SSRParam{}, false
+	}
+	return r.ssr.param, true
+}
+
+// initSSR creates the SSR targets and adds the SSR
+// passes to r's frame graph.
+func (r *Renderer) initSSR() (err error) {
+	if err = r.sampleableDepth(); err != nil {
+		return
+	}
+	size := driver.Dim3D{Width: r.hdr.Width(), Height: r.hdr.Height()}
+	s := new(ssr)
+	defer func() {
+		if err != nil {
+			s.free()
+		}
+	}()
+	s.hiz, err = NewTarget(&TexParam{
+		PixelFmt: driver.RG32Float,
+		Dim3D:    size,
+		Layers:   1,
+		Levels:   ComputeLevels(size),
+		Samples:  1,
+	})
+	if err != nil {
+		return
+	}
+	s.refl, err = NewTarget(&TexParam{
+		PixelFmt: driver.RGBA16Float,
+		Dim3D:    size,
+		Layers:   1,
+		Levels:   min(ssrBlurLevels, ComputeLevels(size)),
+		Samples:  1,
+	})
+	if err != nil {
+		return
+	}
+	s.layout.SetLevels(s.hiz.Levels(), s.refl.Levels())
+	r.ssr = s
+	// The depth pyramid is built from the
+	// resolved depth of opaque geometry.
+	// Tracing reads the lit opaque image and
+	// writes the reflections' base level,
+	// then blurs it into the remaining levels.
+	r.graph.add(&passNode{
+		name:   ssrHiZPass,
+		stage:  stageLighting,
+		reads:  []*Texture{r.ds},
+		writes: []*Texture{s.hiz},
+	})
+	r.graph.add(&passNode{
+		name:   ssrTracePass,
+		stage:  stageLighting,
+		reads:  []*Texture{s.hiz, r.hdr},
+		writes: []*Texture{s.refl},
+	})
+	return
+}
+
+// freeSSR removes the SSR passes from r's frame graph
+// and frees the SSR targets.
+func (r *Renderer) freeSSR() {
+	if r.ssr == nil {
+		return
+	}
+	r.graph.remove(ssrHiZPass)
+	r.graph.remove(ssrTracePass)
+	r.ssr.free()
+	r.ssr = nil
+}
+
+// free frees the SSR targets.
+func (s *ssr) free() {
+	for _, t := range [...]*Texture{s.hiz, s.refl} {
+		if t != nil {
+			t.Free()
+		}
+	}
+}
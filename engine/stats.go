@@ -0,0 +1,191 @@
+// Copyright 2024 Gustavo C. Viegas. All rights reserved.
+
+package engine
+
+import (
+	"cmp"
+	"slices"
+	"sync"
+	"sync/atomic"
+
+	"gviegas/neo3/driver"
+	"gviegas/neo3/internal/suballoc"
+)
+
+// ResourceStats describes the engine's resource usage.
+type ResourceStats struct {
+	Mesh MeshStats
+	// Texture memory, one entry per distinct
+	// combination of pixel format and usage.
+	// Entries are sorted by PixelFmt and then by
+	// Usage.
+	Textures []TexStats
+	// Staging buffers used by Texture copies.
+	TexStaging StagingStats
+	// Staging buffers used by buffer copies.
+	BufStaging StagingStats
+	// Number of pipeline variants in use.
+	Pipelines int
+}
+
+// MeshStats describes the usage of mesh storage.
+// Sizes are in bytes.
+type MeshStats struct {
+	Capacity int64
+	Used     int64
+	// Size of the largest contiguous free range.
+	// Allocations larger than this will cause the
+	// storage to grow.
+	LargestFree int64
+	// Fraction of free storage that is not part of
+	// the largest free range, in the [0, 1] interval.
+	Fragmentation float64
+	Primitives    int
+}
+
+// TexStats describes the memory used by textures of
+// a given pixel format and usage.
+// Bytes is an estimate that considers every layer,
+// level and sample.
+type TexStats struct {
+	PixelFmt driver.PixelFmt
+	Usage    driver.Usage
+	Count    int
+	Bytes    int64
+}
+
+// StagingStats describes the usage of staging
+// buffers.
+// Busy buffers are in use by copies and are not
+// counted towards Capacity and Used.
+// Sizes are in bytes.
+type StagingStats struct {
+	Buffers  int
+	Busy     int
+	Capacity int64
+	Used     int64
+}
+
+// Stats returns the current resource usage.
+// It does not block on pending copies.
+func Stats() ResourceStats {
+	var s ResourceStats
+	s.Mesh = meshes.stats()
+	s.Textures = texStats()
+	s.TexStaging = stagingStats(texStg, func(x *texStgBuffer) (int64, int64) {
+		return stgUsage(x.buf, &x.alloc, int64(texStgBlock))
+	})
+	s.BufStaging = stagingStats(bufStg, func(x *bufStgBuffer) (int64, int64) {
+		return stgUsage(x.buf, &x.alloc, int64(bufStgBlock))
+	})
+	s.Pipelines = int(pipelineCount.Load())
+	return s
+}
+
+// stats computes b's MeshStats.
+func (b *meshBuffer) stats() (s MeshStats) {
+	b.RLock()
+	defer b.RUnlock()
+	st := b.spans.Stats()
+	s.Capacity = int64(st.Len) * int64(spanBlock)
+	s.Used = int64(st.Used) * int64(spanBlock)
+	s.Primitives = b.primMap.Len() - b.primMap.Rem()
+	s.LargestFree = int64(st.LargestFree) * int64(spanBlock)
+	s.Fragmentation = st.Fragmentation
+	return
+}
+
+// stagingStats computes the StagingStats of the
+// given staging buffers.
+// Buffers that cannot be taken immediately are
+// considered busy.
+func stagingStats[T any](ch chan *T, usage func(*T) (capacity, used int64)) (s StagingStats) {
+	s.Buffers = cap(ch)
+	var xs []*T
+	for range cap(ch) {
+		select {
+		case x := <-ch:
+			xs = append(xs, x)
+		default:
+			s.Busy++
+		}
+	}
+	for _, x := range xs {
+		c, u := usage(x)
+		s.Capacity += c
+		s.Used += u
+		ch <- x
+	}
+	return
+}
+
+// stgUsage returns the capacity of a staging buffer
+// and the number of bytes currently reserved.
+func stgUsage(buf driver.Buffer, a *suballoc.Allocator, block int64) (capacity, used int64) {
+	if buf == nil {
+		return
+	}
+	return buf.Cap(), int64(a.Used()) * block
+}
+
+// Live driver.Images created by makeViews.
+var images = struct {
+	sync.Mutex
+	m map[driver.Image]TexStats
+}{m: make(map[driver.Image]TexStats)}
+
+// trackImage records img for texStats.
+func trackImage(img driver.Image, param *TexParam, usage driver.Usage) {
+	var n int64
+	for i := range param.Levels {
+		w := int64(max(param.Width>>i, 1))
+		h := int64(max(param.Height>>i, 1))
+		d := int64(max(param.slices()>>i, 1))
+		n += w * h * d
+	}
+	n *= int64(param.Size() * param.Layers * param.Samples)
+	images.Lock()
+	images.m[img] = TexStats{param.PixelFmt, usage, 1, n}
+	images.Unlock()
+}
+
+// untrackImage removes img from texStats.
+func untrackImage(img driver.Image) {
+	images.Lock()
+	delete(images.m, img)
+	images.Unlock()
+}
+
+// texStats aggregates the tracked images.
+func texStats() []TexStats {
+	type key struct {
+		pf    driver.PixelFmt
+		usage driver.Usage
+	}
+	m := make(map[key]*TexStats)
+	images.Lock()
+	for _, x := range images.m {
+		k := key{x.PixelFmt, x.Usage}
+		if s, ok := m[k]; ok {
+			s.Count++
+			s.Bytes += x.Bytes
+		} else {
+			m[k] = &x
+		}
+	}
+	images.Unlock()
+	s := make([]TexStats, 0, len(m))
+	for _, x := range m {
+		s = append(s, *x)
+	}
+	slices.SortFunc(s, func(a, b TexStats) int {
+		if c := cmp.Compare(a.PixelFmt, b.PixelFmt); c != 0 {
+			return c
+		}
+		return cmp.Compare(a.Usage, b.Usage)
+	})
+	return s
+}
+
+// Number of live pipelineVariants.
+var pipelineCount atomic.Int64
@@ -0,0 +1,80 @@
+// Copyright 2024 Gustavo C. Viegas. All rights reserved.
+
+package engine
+
+import (
+	"testing"
+
+	"gviegas/neo3/driver"
+)
+
+func TestStats(t *testing.T) {
+	find := func(s []TexStats, pf driver.PixelFmt, usage driver.Usage) TexStats {
+		for _, x := range s {
+			if x.PixelFmt == pf && x.Usage == usage {
+				return x
+			}
+		}
+		return TexStats{}
+	}
+
+	param := TexParam{
+		PixelFmt: driver.RG16Float,
+		Dim3D:    driver.Dim3D{Width: 64, Height: 32},
+		Layers:   2,
+		Levels:   3,
+		Samples:  1,
+	}
+	before := Stats()
+	tex, err := New2D(&param)
+	if err != nil {
+		t.Fatalf("New2D failed:\n%v", err)
+	}
+	prev := find(before.Textures, param.PixelFmt, tex.usage)
+	x := find(Stats().Textures, param.PixelFmt, tex.usage)
+	// (64*32 + 32*16 + 16*8) * 4 bytes * 2 layers.
+	const n = (2048 + 512 + 128) * 4 * 2
+	if x.Count != prev.Count+1 || x.Bytes != prev.Bytes+n {
+		t.Fatalf("Stats: Textures\nhave %d, %d\nwant %d, %d", x.Count, x.Bytes, prev.Count+1, prev.Bytes+n)
+	}
+	tex.Free()
+	if x := find(Stats().Textures, param.PixelFmt, tex.usage); x != prev {
+		t.Fatalf("Stats: Textures\nhave %v\nwant %v", x, prev)
+	}
+
+	s := Stats()
+	if s.TexStaging.Buffers != cap(texStg) || s.BufStaging.Buffers != cap(bufStg) {
+		t.Fatal("Stats: unexpected staging buffer count")
+	}
+	if s.TexStaging.Used > s.TexStaging.Capacity || s.BufStaging.Used > s.BufStaging.Capacity {
+		t.Fatal("Stats: staging Used > Capacity")
+	}
+}
+
+func TestMeshStats(t *testing.T) {
+	var b meshBuffer
+	if s := b.stats(); s != (MeshStats{}) {
+		t.Fatalf("meshBuffer.stats:\nhave %v\nwant %v", s, MeshStats{})
+	}
+	// Allocate blocks 0, 1, 5, 20 and 21.
+	b.spans.Grow(1)
+	for _, n := range [...]int{2, 3, 1, 14, 2} {
+		b.spans.Alloc(n, 1)
+	}
+	b.spans.Free(2, 3)
+	b.spans.Free(6, 14)
+	b.primMap.Grow(1)
+	b.primMap.Set(3)
+	s := b.stats()
+	lf, free := 14.0, 27.0
+	want := MeshStats{
+		Capacity:      int64(32 * spanBlock),
+		Used:          int64(5 * spanBlock),
+		LargestFree:   int64(14 * spanBlock),
+		Fragmentation: 1 - lf/free,
+		Primitives:    1,
+	}
+	if s != want {
+		t.Fatalf("meshBuffer.stats:\nhave %v\nwant %v", s, want)
+	}
+}
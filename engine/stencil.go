@@ -0,0 +1,138 @@
+// Copyright 2024 Gustavo C. Viegas. All rights reserved.
+
+package engine
+
+import (
+	"math/bits"
+
+	"gviegas/neo3/driver"
+)
+
+// Stencil bits.
+// The depth/stencil target of a Renderer has 8 bits
+// of stencil, which are shared by built-in features,
+// materials and custom passes. Built-in features use
+// fixed bits, which are reserved only while the
+// features are in use. The remaining bits are
+// reserved by calling Renderer.ReserveStencil.
+const (
+	// Set on the pixels of outlined drawables
+	// (see Renderer.SetOutlined).
+	StencilOutline = 1 << iota
+	// Decals are not drawn on pixels that have
+	// this bit set. Materials can set it to
+	// mask decals out (see StencilState).
+	StencilDecal
+	// Recursion level of portals (2 bits).
+	StencilPortal = 3 << 2
+	// Bits that built-in features never use.
+	StencilUser = 0xf0
+)
+
+// StencilState describes the stencil test and writes
+// of a material or custom pass.
+// Mask selects the stencil bits that are tested and
+// written. A zero Mask disables the stencil test.
+// The bits of Ref that are in Mask are compared
+// against the stencil value using Cmp, and then
+// Pass, Fail or DepthFail updates the bits in Mask
+// depending on the outcome of the stencil and depth
+// tests. Both faces use the same state.
+type StencilState struct {
+	Mask      uint8
+	Ref       uint8
+	Cmp       driver.CmpFunc
+	Pass      driver.StencilOp
+	Fail      driver.StencilOp
+	DepthFail driver.StencilOp
+}
+
+// SetDS sets the stencil fields of ds from s.
+// Custom passes can use it to create pipelines, in
+// which case the reference value must be set with
+// driver.CmdBuffer.SetStencilRef(uint32(s.Ref)).
+func (s *StencilState) SetDS(ds *driver.DSState) {
+	ds.StencilTest = s.Mask != 0
+	if !ds.StencilTest {
+		ds.Front = driver.StencilT{}
+		ds.Back = driver.StencilT{}
+		return
+	}
+	ds.Front = driver.StencilT{
+		FailS:     s.Fail,
+		FailD:     s.DepthFail,
+		Pass:      s.Pass,
+		ReadMask:  uint32(s.Mask),
+		WriteMask: uint32(s.Mask),
+		Cmp:       s.Cmp,
+	}
+	ds.Back = ds.Front
+}
+
+// validate checks that s is valid, given the stencil
+// bits that it is allowed to use.
+// It returns the reason otherwise.
+func (s *StencilState) validate(allowed uint8) string {
+	switch {
+	case s.Mask&^allowed != 0:
+		return "stencil mask uses bits not reserved"
+	case s.Cmp < driver.CNever || s.Cmp > driver.CAlways:
+		return "undefined stencil comparison function"
+	}
+	for _, op := range [...]driver.StencilOp{s.Pass, s.Fail, s.DepthFail} {
+		if op < driver.SKeep || op > driver.SDecWrap {
+			return "undefined stencil operation"
+		}
+	}
+	return ""
+}
+
+// ReserveStencil reserves n bits of the stencil
+// target of r for exclusive use by the caller.
+// The bits are taken from StencilUser, and are
+// returned as a mask. They must be released with
+// ReleaseStencil when no longer needed.
+// Materials and custom passes can only use stencil
+// bits that are reserved (or StencilDecal).
+func (r *Renderer) ReserveStencil(n int) (uint8, error) {
+	if n < 1 || n > bits.OnesCount8(StencilUser) {
+		return 0, newRendErr("invalid number of stencil bits")
+	}
+	free := StencilUser &^ r.stencil
+	if bits.OnesCount8(free) < n {
+		return 0, newRendErr("not enough stencil bits")
+	}
+	var mask uint8
+	for range n {
+		// Bits are taken from the most
+		// significant end.
+		b := uint8(0x80) >> bits.LeadingZeros8(free)
+		mask |= b
+		free &^= b
+	}
+	r.stencil |= mask
+	return mask, nil
+}
+
+// ReleaseStencil releases stencil bits reserved by
+// ReserveStencil. Bits not in StencilUser are
+// ignored.
+func (r *Renderer) ReleaseStencil(mask uint8) {
+	r.stencil &^= mask & StencilUser
+}
+
+// reserveStencil reserves the stencil bits in mask
+// for a built-in feature.
+// It fails if any of them is already reserved.
+func (r *Renderer) reserveStencil(mask uint8) error {
+	if r.stencil&mask != 0 {
+		return newRendErr("stencil bits already reserved")
+	}
+	r.stencil |= mask
+	return nil
+}
+
+// stencilFmts are the pixel formats that are tried,
+// in order, for the depth/stencil target of a
+// Renderer.
+var stencilFmts = [...]driver.PixelFmt{driver.D24UnormS8Uint, driver.D32FloatS8Uint}
@@ -0,0 +1,127 @@
+// Copyright 2024 Gustavo C. Viegas. All rights reserved.
+
+package engine
+
+import (
+	"errors"
+	"testing"
+
+	"gviegas/neo3/driver"
+)
+
+func TestStencilState(t *testing.T) {
+	var ds driver.DSState
+	(&StencilState{}).SetDS(&ds)
+	if ds.StencilTest {
+		t.Fatal("StencilState.SetDS: zero Mask should disable the stencil test")
+	}
+	s := StencilState{
+		Mask:      StencilDecal,
+		Ref:       StencilDecal,
+		Cmp:       driver.CAlways,
+		Pass:      driver.SReplace,
+		DepthFail: driver.SKeep,
+	}
+	s.SetDS(&ds)
+	want := driver.StencilT{
+		FailS:     driver.SKeep,
+		FailD:     driver.SKeep,
+		Pass:      driver.SReplace,
+		ReadMask:  StencilDecal,
+		WriteMask: StencilDecal,
+		Cmp:       driver.CAlways,
+	}
+	if !ds.StencilTest || ds.Front != want || ds.Back != want {
+		t.Fatalf("StencilState.SetDS:\nhave %+v\nwant %+v", ds, want)
+	}
+
+	for _, x := range [...]StencilState{
+		{Mask: StencilOutline},
+		{Mask: StencilPortal},
+		{Mask: StencilDecal, Cmp: driver.CAlways + 1},
+		{Mask: StencilDecal, Pass: -1},
+		{Mask: StencilDecal, DepthFail: driver.SDecWrap + 1},
+	} {
+		if _, err := NewUnlit(&Unlit{Stencil: x}); !errors.Is(err, ErrInvalidParam) {
+			t.Fatalf("NewUnlit(Stencil: %+v):\nhave %v\nwant %v", x, err, ErrInvalidParam)
+		}
+	}
+	m, err := NewUnlit(&Unlit{Stencil: s})
+	if err != nil {
+		t.Fatalf("NewUnlit failed:\n%v", err)
+	}
+	if x := m.Stencil(); x != s {
+		t.Fatalf("Material.Stencil:\nhave %+v\nwant %+v", x, s)
+	}
+}
+
+func TestReserveStencil(t *testing.T) {
+	rend, err := NewOffscreen(64, 48)
+	if err != nil {
+		t.Fatalf("NewOffscreen failed:\n%v", err)
+	}
+	defer rend.Free()
+
+	if _, s := rend.ds.PixelFmt().IsDS(); !s {
+		t.Fatalf("Renderer.init: depth target has no stencil\n%v", rend.ds.PixelFmt())
+	}
+	if rend.stencil != StencilDecal {
+		t.Fatalf("Renderer.init: reserved stencil bits\nhave %#x\nwant %#x", rend.stencil, StencilDecal)
+	}
+	for _, n := range [...]int{0, -1, 5} {
+		if _, err := rend.ReserveStencil(n); !errors.Is(err, ErrInvalidParam) {
+			t.Fatalf("Renderer.ReserveStencil(%d):\nhave %v\nwant %v", n, err, ErrInvalidParam)
+		}
+	}
+	m1, err := rend.ReserveStencil(1)
+	if err != nil {
+		t.Fatalf("Renderer.ReserveStencil failed:\n%v", err)
+	}
+	m2, err := rend.ReserveStencil(2)
+	if err != nil {
+		t.Fatalf("Renderer.ReserveStencil failed:\n%v", err)
+	}
+	if m1 != 0x80 || m2 != 0x60 {
+		t.Fatalf("Renderer.ReserveStencil:\nhave %#x, %#x\nwant 0x80, 0x60", m1, m2)
+	}
+	if _, err := rend.ReserveStencil(2); !errors.Is(err, ErrInvalidParam) {
+		t.Fatalf("Renderer.ReserveStencil: exhausted\nhave %v\nwant %v", err, ErrInvalidParam)
+	}
+	rend.ReleaseStencil(m1 | StencilDecal)
+	if rend.stencil != StencilDecal|m2 {
+		t.Fatalf("Renderer.ReleaseStencil: reserved stencil bits\nhave %#x\nwant %#x", rend.stencil, StencilDecal|m2)
+	}
+
+	record := func(*PassContext) {}
+	for _, x := range [...]StencilState{
+		{Mask: m1, Cmp: driver.CEqual},
+		{Mask: StencilOutline, Cmp: driver.CEqual},
+		{Mask: m2, Cmp: -1},
+	} {
+		p := PassParam{Name: "mask", Stage: StageLighting, WriteTargets: TargetDepth, Stencil: x, Record: record}
+		if err := rend.AddPass(&p); !errors.Is(err, ErrInvalidParam) {
+			t.Fatalf("Renderer.AddPass(Stencil: %+v):\nhave %v\nwant %v", x, err, ErrInvalidParam)
+		}
+	}
+	s := StencilState{Mask: m2, Ref: m2, Cmp: driver.CAlways, Pass: driver.SReplace}
+	if err := rend.AddPass(&PassParam{Name: "mask", Stage: StageLighting, WriteTargets: TargetColor, Stencil: s, Record: record}); !errors.Is(err, ErrInvalidParam) {
+		t.Fatalf("Renderer.AddPass: no depth target\nhave %v\nwant %v", err, ErrInvalidParam)
+	}
+	var ctx PassContext
+	if err := rend.AddPass(&PassParam{
+		Name:         "mask",
+		Stage:        StageLighting,
+		WriteTargets: TargetDepth,
+		Stencil:      s,
+		Record:       func(c *PassContext) { ctx = *c },
+	}); err != nil {
+		t.Fatalf("Renderer.AddPass failed:\n%v", err)
+	}
+	rend.graph.nodes[rend.graph.find(customPrefix+"mask")].record(&rend.Renderer, nil)
+	if ctx.Stencil != s {
+		t.Fatalf("PassContext.Stencil:\nhave %+v\nwant %+v", ctx.Stencil, s)
+	}
+	if ctx.DSTarget.LoadS != driver.LLoad || ctx.DSTarget.StoreS != driver.SStore {
+		t.Fatal("PassContext.DSTarget: stencil should be loaded and stored")
+	}
+}
@@ -0,0 +1,399 @@
+// Copyright 2024 Gustavo C. Viegas. All rights reserved.
+
+package engine
+
+import (
+	"cmp"
+	"errors"
+	"iter"
+	"math"
+	"slices"
+	"sync"
+
+	"gviegas/neo3/linear"
+	"gviegas/neo3/node"
+)
+
+const streamPrefix = "streamer: "
+
+func newStreamErr(reason string) error { return newErr(streamPrefix, reason, ErrInvalidParam) }
+
+// Cell identifies a streaming cell by its coordinates
+// in the grid of a Streamer.
+type Cell [3]int32
+
+// CellState is the state of a streaming cell.
+type CellState int
+
+// Cell states.
+const (
+	// The cell is not loaded.
+	CellUnloaded CellState = iota
+	// The cell's content is being loaded by its
+	// CellLoader.
+	CellLoading
+	// The cell's content is being made resident
+	// by the Streamer's Scheduler.
+	CellPending
+	// The cell's content is in the graph.
+	CellResident
+	// The cell's content failed to load. It will
+	// not be loaded again until the cell is
+	// unloaded.
+	CellFailed
+)
+
+// CellLoader loads the content of a cell.
+// It is called in its own goroutine, so it can block
+// on I/O and decoding. It should not create GPU
+// resources nor modify the graph; this is left to the
+// CellContent that it returns, which the Streamer
+// drives from Scheduler steps.
+type CellLoader func(c Cell) (CellContent, error)
+
+// CellContent is the loaded content of a cell.
+// Its methods are called from Scheduler.Run and
+// Streamer.Update, never concurrently.
+type CellContent interface {
+	// Step performs one step of making the content
+	// resident (e.g., creating one Mesh or copying
+	// one Texture level). It is called until it
+	// returns true or an error.
+	// Each step should take a small fraction of the
+	// Scheduler's budget. Texture copies that are
+	// not committed are bounded by the streaming
+	// budget (see Tuning.StreamingBudget).
+	Step() (done bool, err error)
+	// Insert inserts the content into g and returns
+	// the root of its sub-graph. The Streamer
+	// removes this sub-graph when the cell is
+	// unloaded.
+	Insert(g *node.Graph) node.Node
+	// Free frees the content's resources.
+	// It is called when the cell is unloaded or
+	// fails to load, and must handle contents that
+	// were not completely made resident.
+	Free()
+}
+
+// StreamParam describes a Streamer.
+// The world is divided into cells of CellSize
+// extent, the cell (0, 0, 0) having its minimum
+// corner at the origin. Cells closer than LoadRadius
+// from the camera are loaded, nearest first, and
+// cells farther than UnloadRadius are unloaded.
+// UnloadRadius must not be less than LoadRadius, so
+// that cells on the boundary do not load and unload
+// repeatedly.
+// At most MaxLoads cells are loaded concurrently.
+// Making content resident and freeing it is done
+// in Scheduler with priority Priority.
+type StreamParam struct {
+	CellSize     linear.V3
+	LoadRadius   float32
+	UnloadRadius float32
+	MaxLoads     int
+	Graph        *node.Graph
+	Scheduler    *Scheduler
+	Priority     int
+	Load         CellLoader
+}
+
+// Streamer streams the content of a world divided in
+// cells, based on the position of the camera.
+// Loading happens in background goroutines, while
+// work that must not happen concurrently with
+// rendering (e.g., creating GPU resources and
+// inserting nodes into the graph) is spread across
+// frames by a Scheduler, to avoid frame spikes.
+// A Streamer is not safe for concurrent use. Its
+// Scheduler must be run on the goroutine that calls
+// Update.
+type Streamer struct {
+	param StreamParam
+	cells map[Cell]*streamCell
+	// Number of loads in progress.
+	loads int
+	done  chan streamLoad
+	wg    sync.WaitGroup
+	// Errors to be reported by the next Update.
+	errs []error
+}
+
+// streamCell is a cell that is not unloaded.
+type streamCell struct {
+	state   CellState
+	content CellContent
+	task    Task
+	root    node.Node
+	// Whether the cell must be dropped when its
+	// load completes.
+	drop bool
+}
+
+// streamLoad is the result of a cell load.
+type streamLoad struct {
+	cell    Cell
+	content CellContent
+	err     error
+}
+
+// NewStreamer creates a new Streamer.
+func NewStreamer(param *StreamParam) (*Streamer, error) {
+	var reason string
+	switch {
+	case !(param.CellSize[0] > 0 && param.CellSize[1] > 0 && param.CellSize[2] > 0):
+		reason = "non-positive cell size"
+	case !(param.LoadRadius >= 0):
+		reason = "negative load radius"
+	case !(param.UnloadRadius >= param.LoadRadius):
+		reason = "unload radius less than load radius"
+	case param.MaxLoads < 1:
+		reason = "non-positive max loads"
+	case param.Graph == nil:
+		reason = "nil graph"
+	case param.Scheduler == nil:
+		reason = "nil scheduler"
+	case param.Load == nil:
+		reason = "nil loader"
+	default:
+		goto validParam
+	}
+	return nil, newStreamErr(reason)
+validParam:
+	return &Streamer{
+		param: *param,
+		cells: make(map[Cell]*streamCell),
+		done:  make(chan streamLoad, param.MaxLoads),
+	}, nil
+}
+
+// CellAt returns the cell that contains p.
+func (s *Streamer) CellAt(p *linear.V3) (c Cell) {
+	for i := range c {
+		c[i] = int32(math.Floor(float64(p[i] / s.param.CellSize[i])))
+	}
+	return
+}
+
+// CellBounds returns the bounds of a cell.
+func (s *Streamer) CellBounds(c Cell) (min, max linear.V3) {
+	for i := range c {
+		min[i] = float32(c[i]) * s.param.CellSize[i]
+		max[i] = min[i] + s.param.CellSize[i]
+	}
+	return
+}
+
+// dist returns the distance from p to the bounds of
+// cell c.
+func (s *Streamer) dist(c Cell, p *linear.V3) float32 {
+	min, max := s.CellBounds(c)
+	var d linear.V3
+	for i := range d {
+		switch {
+		case p[i] < min[i]:
+			d[i] = min[i] - p[i]
+		case p[i] > max[i]:
+			d[i] = p[i] - max[i]
+		}
+	}
+	return d.Len()
+}
+
+// State returns the state of a cell.
+func (s *Streamer) State(c Cell) CellState {
+	if sc, ok := s.cells[c]; ok && !sc.drop {
+		return sc.state
+	}
+	return CellUnloaded
+}
+
+// Cells returns an iterator over the cells that are
+// not unloaded and their states, in an arbitrary
+// order.
+func (s *Streamer) Cells() iter.Seq2[Cell, CellState] {
+	return func(yield func(Cell, CellState) bool) {
+		for c, sc := range s.cells {
+			if !sc.drop && !yield(c, sc.state) {
+				return
+			}
+		}
+	}
+}
+
+// Root returns the root node of a resident cell's
+// sub-graph, or node.Nil if the cell is not resident.
+func (s *Streamer) Root(c Cell) node.Node {
+	if sc, ok := s.cells[c]; ok && sc.state == CellResident {
+		return sc.root
+	}
+	return node.Nil
+}
+
+// Update updates the streaming state for a camera at
+// position camera. It should be called once per
+// frame, before the Scheduler is run.
+// It returns the errors of the loads that failed
+// since the previous call, if any.
+func (s *Streamer) Update(camera *linear.V3) error {
+	defer traceBegin(traceUpdate, "streaming").end()
+	s.receive()
+
+	for c, sc := range s.cells {
+		if s.dist(c, camera) > s.param.UnloadRadius {
+			s.unload(c, sc, true)
+		}
+	}
+
+	var want []Cell
+	r := s.param.LoadRadius
+	lo := s.CellAt(&linear.V3{camera[0] - r, camera[1] - r, camera[2] - r})
+	hi := s.CellAt(&linear.V3{camera[0] + r, camera[1] + r, camera[2] + r})
+	for x := lo[0]; x <= hi[0]; x++ {
+		for y := lo[1]; y <= hi[1]; y++ {
+			for z := lo[2]; z <= hi[2]; z++ {
+				c := Cell{x, y, z}
+				if s.dist(c, camera) > r {
+					continue
+				}
+				if sc, ok := s.cells[c]; ok {
+					// Needed again before its
+					// load completed.
+					sc.drop = false
+					continue
+				}
+				want = append(want, c)
+			}
+		}
+	}
+	slices.SortFunc(want, func(a, b Cell) int {
+		return cmp.Compare(s.dist(a, camera), s.dist(b, camera))
+	})
+	for _, c := range want[:min(len(want), s.param.MaxLoads-s.loads)] {
+		s.load(c)
+	}
+
+	err := errors.Join(s.errs...)
+	s.errs = s.errs[:0]
+	return err
+}
+
+// load starts loading c.
+func (s *Streamer) load(c Cell) {
+	s.cells[c] = &streamCell{state: CellLoading}
+	s.loads++
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		content, err := s.param.Load(c)
+		s.done <- streamLoad{c, content, err}
+	}()
+}
+
+// receive handles the loads that have completed.
+func (s *Streamer) receive() {
+	for {
+		select {
+		case ld := <-s.done:
+			s.loads--
+			s.complete(&ld)
+		default:
+			return
+		}
+	}
+}
+
+// complete schedules the content of a completed
+// load to be made resident.
+func (s *Streamer) complete(ld *streamLoad) {
+	sc := s.cells[ld.cell]
+	switch {
+	case sc.drop:
+		if ld.content != nil {
+			ld.content.Free()
+		}
+		delete(s.cells, ld.cell)
+		return
+	case ld.err != nil:
+		sc.state = CellFailed
+		s.errs = append(s.errs, ld.err)
+		return
+	}
+	sc.state = CellPending
+	sc.content = ld.content
+	inserted := false
+	task, err := s.param.Scheduler.Schedule(s.param.Priority, func() bool {
+		if !inserted {
+			done, err := sc.content.Step()
+			switch {
+			case err != nil:
+				sc.content.Free()
+				sc.content = nil
+				sc.state = CellFailed
+				s.errs = append(s.errs, err)
+				return true
+			case !done:
+				return false
+			}
+			// Insertion takes a step of its
+			// own.
+			inserted = true
+			return false
+		}
+		sc.root = sc.content.Insert(s.param.Graph)
+		sc.state = CellResident
+		return true
+	})
+	if err != nil {
+		sc.content.Free()
+		sc.content = nil
+		sc.state = CellFailed
+		s.errs = append(s.errs, err)
+		return
+	}
+	sc.task = task
+}
+
+// unload unloads c.
+// The sub-graph of a resident cell is removed at
+// once, while its content is freed by the Scheduler
+// if sched is set.
+func (s *Streamer) unload(c Cell, sc *streamCell, sched bool) {
+	switch sc.state {
+	case CellLoading:
+		sc.drop = true
+		return
+	case CellPending:
+		s.param.Scheduler.Cancel(sc.task)
+		sc.content.Free()
+	case CellResident:
+		s.param.Graph.Remove(sc.root)
+		content := sc.content
+		if !sched {
+			content.Free()
+		} else if _, err := s.param.Scheduler.Schedule(s.param.Priority, func() bool {
+			content.Free()
+			return true
+		}); err != nil {
+			content.Free()
+		}
+	}
+	delete(s.cells, c)
+}
+
+// Free unloads every cell and invalidates s.
+// It waits for loads in progress to complete.
+func (s *Streamer) Free() {
+	for c, sc := range s.cells {
+		s.unload(c, sc, false)
+	}
+	go func() {
+		s.wg.Wait()
+		close(s.done)
+	}()
+	for ld := range s.done {
+		s.complete(&ld)
+	}
+	*s = Streamer{}
+}
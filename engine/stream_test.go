@@ -0,0 +1,191 @@
+// Copyright 2024 Gustavo C. Viegas. All rights reserved.
+
+package engine
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"gviegas/neo3/linear"
+	"gviegas/neo3/node"
+)
+
+// testContent is a CellContent that takes steps
+// steps to become resident.
+type testContent struct {
+	cell  Cell
+	steps int
+	freed *sync.Map
+}
+
+func (c *testContent) Step() (bool, error) {
+	c.steps--
+	return c.steps <= 0, nil
+}
+
+func (c *testContent) Insert(g *node.Graph) node.Node {
+	m := translate(float32(c.cell[0]), float32(c.cell[1]), float32(c.cell[2]))
+	return g.Insert(&staticNode{local: m}, node.Nil)
+}
+
+func (c *testContent) Free() { c.freed.Store(c.cell, true) }
+
+// streamUntil updates s and runs its scheduler until
+// cond is true, and returns the errors reported by
+// s.Update. It fails the test if this takes more
+// than a second.
+func streamUntil(t *testing.T, s *Streamer, camera *linear.V3, cond func() bool) (errs []error) {
+	t.Helper()
+	for start := time.Now(); !cond(); {
+		if time.Since(start) > time.Second {
+			t.Fatal("Streamer.Update: timed out")
+		}
+		if err := s.Update(camera); err != nil {
+			errs = append(errs, err)
+		}
+		s.param.Scheduler.Run()
+		time.Sleep(time.Millisecond)
+	}
+	return
+}
+
+func TestStreamer(t *testing.T) {
+	var g node.Graph
+	sched, err := NewScheduler(time.Millisecond)
+	if err != nil {
+		t.Fatalf("NewScheduler failed:\n%v", err)
+	}
+	var freed sync.Map
+	var mu sync.Mutex
+	loaded := make(map[Cell]int)
+	param := StreamParam{
+		CellSize:     linear.V3{10, 1000, 10},
+		LoadRadius:   12,
+		UnloadRadius: 25,
+		MaxLoads:     2,
+		Graph:        &g,
+		Scheduler:    sched,
+		Load: func(c Cell) (CellContent, error) {
+			mu.Lock()
+			loaded[c]++
+			mu.Unlock()
+			if c == (Cell{-2, 0, 0}) {
+				return nil, errors.New("bad cell")
+			}
+			return &testContent{cell: c, steps: 3, freed: &freed}, nil
+		},
+	}
+	for _, x := range [...]func(p *StreamParam){
+		func(p *StreamParam) { p.CellSize[1] = 0 },
+		func(p *StreamParam) { p.UnloadRadius = 11 },
+		func(p *StreamParam) { p.MaxLoads = 0 },
+		func(p *StreamParam) { p.Graph = nil },
+		func(p *StreamParam) { p.Load = nil },
+	} {
+		p := param
+		x(&p)
+		if _, err := NewStreamer(&p); err == nil {
+			t.Fatal("NewStreamer: unexpected success")
+		}
+	}
+	s, err := NewStreamer(&param)
+	if err != nil {
+		t.Fatalf("NewStreamer failed:\n%v", err)
+	}
+
+	if c := s.CellAt(&linear.V3{-0.5, 3, 25}); c != (Cell{-1, 0, 2}) {
+		t.Fatalf("Streamer.CellAt:\nhave %v\nwant [-1 0 2]", c)
+	}
+	// Cells within 12 units of (5, 500, 5).
+	cam := linear.V3{5, 500, 5}
+	near := []Cell{
+		{0, 0, 0},
+		{-1, 0, 0}, {1, 0, 0}, {0, 0, -1}, {0, 0, 1},
+		{-1, 0, -1}, {-1, 0, 1}, {1, 0, -1}, {1, 0, 1},
+	}
+	resident := func() bool {
+		for _, c := range near {
+			if s.State(c) != CellResident {
+				return false
+			}
+		}
+		return true
+	}
+	if err := s.Update(&cam); err != nil {
+		t.Fatalf("Streamer.Update failed:\n%v", err)
+	}
+	// The nearest cell loads first.
+	if st := s.State(Cell{0, 0, 0}); st != CellLoading {
+		t.Fatalf("Streamer.State:\nhave %v\nwant %v", st, CellLoading)
+	}
+	if s.loads != param.MaxLoads {
+		t.Fatalf("Streamer: loads\nhave %d\nwant %d", s.loads, param.MaxLoads)
+	}
+	if errs := streamUntil(t, s, &cam, resident); len(errs) != 0 {
+		t.Fatalf("Streamer.Update failed:\n%v", errs)
+	}
+	n := 0
+	for _, st := range s.Cells() {
+		if st != CellResident {
+			t.Fatalf("Streamer.Cells: state\nhave %v\nwant %v", st, CellResident)
+		}
+		n++
+	}
+	if n != len(near) || g.Len() != len(near) {
+		t.Fatalf("Streamer.Cells: %d cells, %d nodes\nwant %d", n, g.Len(), len(near))
+	}
+	g.Update()
+	if r := s.Root(Cell{1, 0, 1}); r == node.Nil || *g.World(r) != translate(1, 0, 1) {
+		t.Fatal("Streamer.Root: unexpected node")
+	}
+
+	// Moving within the unload radius keeps
+	// cells loaded.
+	cam = linear.V3{-7, 500, 5}
+	errs := streamUntil(t, s, &cam, func() bool { return s.State(Cell{-2, 0, 0}) == CellFailed })
+	if err := s.Update(&cam); err != nil {
+		errs = append(errs, err)
+	}
+	if len(errs) != 1 || errs[0].Error() != "bad cell" {
+		t.Fatalf("Streamer.Update:\nhave %v\nwant [bad cell]", errs)
+	}
+	if st := s.State(Cell{1, 0, 0}); st != CellResident {
+		t.Fatalf("Streamer.State:\nhave %v\nwant %v", st, CellResident)
+	}
+
+	// Moving away unloads cells.
+	cam = linear.V3{1005, 500, 5}
+	if errs := streamUntil(t, s, &cam, func() bool { return s.State(Cell{100, 0, 0}) == CellResident }); len(errs) != 0 {
+		t.Fatalf("Streamer.Update failed:\n%v", errs)
+	}
+	for _, c := range near {
+		if st := s.State(c); st != CellUnloaded {
+			t.Fatalf("Streamer.State:\nhave %v\nwant %v", st, CellUnloaded)
+		}
+	}
+	for s.param.Scheduler.Len() > 0 {
+		s.param.Scheduler.Run()
+	}
+	for _, c := range near {
+		if _, ok := freed.Load(c); !ok {
+			t.Fatalf("Streamer: content of %v not freed", c)
+		}
+	}
+	mu.Lock()
+	for c, n := range loaded {
+		if n != 1 {
+			t.Fatalf("Streamer: %v loaded %d times", c, n)
+		}
+	}
+	mu.Unlock()
+
+	s.Free()
+	if g.Len() != 0 {
+		t.Fatalf("Streamer.Free: graph has %d nodes", g.Len())
+	}
+	if _, ok := freed.Load(Cell{100, 0, 0}); !ok {
+		t.Fatal("Streamer.Free: content not freed")
+	}
+}
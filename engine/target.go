@@ -0,0 +1,86 @@
+// Copyright 2024 Gustavo C. Viegas. All rights reserved.
+
+package engine
+
+import (
+	"gviegas/neo3/driver"
+)
+
+// targetHeadroom is how many times brighter than
+// the exposed white point radiance can be before it
+// saturates an unorm color target.
+const targetHeadroom = 4
+
+// SetTargetFormat sets the format of r's color
+// target, into which geometry is lit and which post
+// passes read and write in HDR.
+// It must be either driver.RGBA16Float (the default)
+// or driver.RGB10A2Unorm. The latter halves the
+// bandwidth of the target, but cannot represent
+// values above one, so radiance is stored scaled by
+// the exposure (see shader.FrameLayout.TargetScale)
+// and highlights brighter than targetHeadroom times
+// the exposed white point are clamped. Its alpha
+// channel has only two bits, which is enough for
+// the blend modes that r uses since they do not
+// read destination alpha.
+// Intermediates that hold copies of the color target
+// (e.g., the blurred image of depth of field or the
+// upscaled image of dynamic resolution) are
+// recreated in the same format. OIT targets keep
+// their own formats.
+func (r *Renderer) SetTargetFormat(pf driver.PixelFmt) error {
+	switch pf {
+	case driver.RGBA16Float, driver.RGB10A2Unorm:
+	default:
+		return newRendErr("unsupported color target format")
+	}
+	if pf == r.hdr.PixelFmt() {
+		return nil
+	}
+	targets := []**Texture{&r.hdr}
+	if r.cam != nil && r.cam.blur != nil {
+		targets = append(targets, &r.cam.blur)
+	}
+	if r.motion != nil {
+		targets = append(targets, &r.motion.blur)
+	}
+	if r.dynRes != nil {
+		targets = append(targets, &r.dynRes.color)
+	}
+	// Create every new target before replacing
+	// any, so that r is left unchanged on failure.
+	news := make([]*Texture, len(targets))
+	for i, t := range targets {
+		param := (*t).param
+		param.PixelFmt = pf
+		x, err := newTarget(&param, (*t).usage)
+		if err != nil {
+			for _, x := range news[:i] {
+				x.Free()
+			}
+			return err
+		}
+		news[i] = x
+	}
+	for i, t := range targets {
+		r.graph.replace(*t, news[i])
+		(*t).Free()
+		*t = news[i]
+	}
+	return nil
+}
+
+// TargetFormat returns the format of r's color
+// target.
+func (r *Renderer) TargetFormat() driver.PixelFmt { return r.hdr.PixelFmt() }
+
+// targetScale returns the factor by which radiance
+// is scaled when written to r's color target.
+// Float targets store radiance as is.
+func (r *Renderer) targetScale() float32 {
+	if r.hdr.PixelFmt() == driver.RGB10A2Unorm {
+		return r.exposure() / targetHeadroom
+	}
+	return 1
+}
@@ -0,0 +1,93 @@
+// Copyright 2024 Gustavo C. Viegas. All rights reserved.
+
+package engine
+
+import (
+	"testing"
+
+	"gviegas/neo3/driver"
+)
+
+func TestRendererTargetFormat(t *testing.T) {
+	rend, err := NewOffscreen(256, 144)
+	if err != nil {
+		t.Fatalf("RendererTargetFormat: NewOffscreen failed:\n%v", err)
+	}
+	defer rend.Free()
+	if x := rend.TargetFormat(); x != driver.RGBA16Float {
+		t.Fatalf("Renderer.TargetFormat:\nhave %v\nwant %v", x, driver.RGBA16Float)
+	}
+	if x := rend.targetScale(); x != 1 {
+		t.Fatalf("Renderer.targetScale: float target\nhave %v\nwant 1", x)
+	}
+	for _, pf := range [...]driver.PixelFmt{driver.RGBA8Unorm, driver.RGBA32Float, driver.D16Unorm} {
+		if err := rend.SetTargetFormat(pf); err == nil {
+			t.Fatalf("Renderer.SetTargetFormat(%v): unexpected nil error", pf)
+		}
+	}
+
+	defer allowPending(append(motionPasses[:], dofCoCPass, dofBlurPass, dofCompositePass)...)()
+	cam := CameraParam{
+		Aperture:      2,
+		Shutter:       1.0 / 60,
+		ISO:           400,
+		FocalLength:   50,
+		DoF:           true,
+		FocusDistance: 5,
+	}
+	if err := rend.SetCamera(&cam); err != nil {
+		t.Fatalf("Renderer.SetCamera failed:\n%v", err)
+	}
+	if err := rend.SetMotionBlur(&MotionBlurParam{Shutter: 0.5}); err != nil {
+		t.Fatalf("Renderer.SetMotionBlur failed:\n%v", err)
+	}
+	hdr := rend.hdr
+	if err := rend.SetTargetFormat(driver.RGB10A2Unorm); err != nil {
+		t.Fatalf("Renderer.SetTargetFormat failed:\n%v", err)
+	}
+	if x := rend.TargetFormat(); x != driver.RGB10A2Unorm {
+		t.Fatalf("Renderer.TargetFormat:\nhave %v\nwant %v", x, driver.RGB10A2Unorm)
+	}
+	if rend.hdr.Width() != hdr.Width() || rend.hdr.Height() != hdr.Height() || rend.hdr.Samples() != hdr.Samples() {
+		t.Fatal("Renderer.SetTargetFormat: color target should keep its size and sample count")
+	}
+	for _, x := range [...]*Texture{rend.cam.blur, rend.motion.blur} {
+		if x.PixelFmt() != driver.RGB10A2Unorm {
+			t.Fatalf("Renderer.SetTargetFormat: intermediate format\nhave %v\nwant %v", x.PixelFmt(), driver.RGB10A2Unorm)
+		}
+	}
+	for _, n := range rend.graph.nodes {
+		for _, s := range [2][]*Texture{n.reads, n.writes} {
+			for _, x := range s {
+				if x == hdr {
+					t.Fatalf("Renderer.SetTargetFormat: pass %q uses the previous target", n.name)
+				}
+			}
+		}
+	}
+	if err := rend.graph.compile(); err != nil {
+		t.Fatalf("frameGraph.compile failed:\n%v", err)
+	}
+	if x, want := rend.targetScale(), rend.exposure()/targetHeadroom; x != want {
+		t.Fatalf("Renderer.targetScale: unorm target\nhave %v\nwant %v", x, want)
+	}
+
+	// Features enabled afterwards use the
+	// current format as well.
+	if err := rend.SetMotionBlur(nil); err != nil {
+		t.Fatalf("Renderer.SetMotionBlur(nil) failed:\n%v", err)
+	}
+	if err := rend.SetMotionBlur(&MotionBlurParam{Shutter: 0.5}); err != nil {
+		t.Fatalf("Renderer.SetMotionBlur failed:\n%v", err)
+	}
+	if x := rend.motion.blur.PixelFmt(); x != driver.RGB10A2Unorm {
+		t.Fatalf("Renderer.SetMotionBlur: intermediate format\nhave %v\nwant %v", x, driver.RGB10A2Unorm)
+	}
+
+	if err := rend.SetTargetFormat(driver.RGBA16Float); err != nil {
+		t.Fatalf("Renderer.SetTargetFormat failed:\n%v", err)
+	}
+	if x := rend.targetScale(); x != 1 {
+		t.Fatalf("Renderer.targetScale: float target\nhave %v\nwant 1", x)
+	}
+}
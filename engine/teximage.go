@@ -0,0 +1,122 @@
+// Copyright 2024 Gustavo C. Viegas. All rights reserved.
+
+package engine
+
+import (
+	"image"
+	"image/draw"
+
+	"gviegas/neo3/driver"
+)
+
+// NewTextureFromImage creates a 2D texture from img.
+// The texture has a single layer and mip level.
+// Its format is driver.RGBA8SRGB, since image.Image
+// colors are assumed to be sRGB-encoded; a TextureView
+// can be used to sample it as driver.RGBA8Unorm
+// instead (e.g., for normal maps).
+// Alpha is not premultiplied.
+// The copy of img's data may be delayed.
+func NewTextureFromImage(img image.Image) (*Texture, error) {
+	if img == nil {
+		return nil, newTexErr("nil image.Image")
+	}
+	b := img.Bounds()
+	t, err := New2D(&TexParam{
+		PixelFmt: driver.RGBA8SRGB,
+		Dim3D:    driver.Dim3D{Width: b.Dx(), Height: b.Dy()},
+		Layers:   1,
+		Levels:   1,
+		Samples:  1,
+	})
+	if err != nil {
+		return nil, err
+	}
+	if err = t.CopyToView(0, imagePix(img), false); err != nil {
+		t.Free()
+		return nil, err
+	}
+	return t, nil
+}
+
+// imagePix returns the pixels of img as tightly packed,
+// non-premultiplied RGBA8 data.
+// The returned slice may alias img's memory.
+func imagePix(img image.Image) []byte {
+	b := img.Bounds()
+	w, h := b.Dx(), b.Dy()
+	if x, ok := img.(*image.NRGBA); ok {
+		if x.Stride == 4*w {
+			return x.Pix[:4*w*h]
+		}
+		pix := make([]byte, 4*w*h)
+		for y := range h {
+			copy(pix[4*w*y:4*w*(y+1)], x.Pix[x.Stride*y:])
+		}
+		return pix
+	}
+	dst := image.NewNRGBA(image.Rect(0, 0, w, h))
+	draw.Draw(dst, dst.Rect, img, b.Min, draw.Src)
+	return dst.Pix
+}
+
+// ToImage copies the first layer of t's first mip level
+// into a new image.NRGBA.
+// t must have one of the RGBA8 or BGRA8 formats, and a
+// single sample.
+// Color values are copied as is, without conversion
+// between sRGB and linear encodings.
+// It implicitly commits the staging buffer.
+func (t *Texture) ToImage() (*image.NRGBA, error) {
+	var bgra bool
+	switch t.param.PixelFmt {
+	case driver.RGBA8Unorm, driver.RGBA8SRGB:
+	case driver.BGRA8Unorm, driver.BGRA8SRGB:
+		bgra = true
+	default:
+		return nil, newTexErr("ToImage requires a RGBA8 or BGRA8 format")
+	}
+	img := image.NewNRGBA(image.Rect(0, 0, t.param.Width, t.param.Height))
+	// The first view of cube textures has six layers.
+	dst := img.Pix
+	if t.ViewLayers(0) > 1 {
+		dst = make([]byte, t.ViewSize(0))
+	}
+	if _, err := t.CopyFromView(0, dst); err != nil {
+		return nil, err
+	}
+	copy(img.Pix, dst)
+	if bgra {
+		for i := 0; i < len(img.Pix); i += 4 {
+			img.Pix[i], img.Pix[i+2] = img.Pix[i+2], img.Pix[i]
+		}
+	}
+	return img, nil
+}
+
+// CompareImages compares img against golden, as in
+// golden-image regression tests.
+// It returns the number of pixels in which any
+// channel differs by more than tol. The images must
+// have the same size.
+// Colors are compared as non-premultiplied RGBA8.
+func CompareImages(img, golden image.Image, tol uint8) (int, error) {
+	if img == nil || golden == nil {
+		return 0, newTexErr("nil image.Image")
+	}
+	if img.Bounds().Size() != golden.Bounds().Size() {
+		return 0, newTexErr("image size mismatch")
+	}
+	a, b := imagePix(img), imagePix(golden)
+	var n int
+	for i := 0; i < len(a); i += 4 {
+		for j := range 4 {
+			x, y := a[i+j], b[i+j]
+			if max(x, y)-min(x, y) > tol {
+				n++
+				break
+			}
+		}
+	}
+	return n, nil
+}
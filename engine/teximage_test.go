@@ -0,0 +1,108 @@
+// Copyright 2024 Gustavo C. Viegas. All rights reserved.
+
+package engine
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"testing"
+
+	"gviegas/neo3/driver"
+)
+
+func TestImagePix(t *testing.T) {
+	src := image.NewNRGBA(image.Rect(0, 0, 4, 3))
+	for i := range src.Pix {
+		src.Pix[i] = byte(i)
+	}
+	if pix := imagePix(src); &pix[0] != &src.Pix[0] || len(pix) != len(src.Pix) {
+		t.Fatal("imagePix: tightly packed image.NRGBA should not be copied")
+	}
+
+	// Sub-image whose stride differs from its width.
+	sub := src.SubImage(image.Rect(1, 1, 3, 3)).(*image.NRGBA)
+	want := append(append([]byte{}, src.Pix[20:28]...), src.Pix[36:44]...)
+	if pix := imagePix(sub); !bytes.Equal(pix, want) {
+		t.Fatalf("imagePix:\nhave %v\nwant %v", pix, want)
+	}
+
+	// Premultiplied alpha.
+	rgba := image.NewRGBA(image.Rect(0, 0, 1, 1))
+	rgba.Set(0, 0, color.NRGBA{R: 255, G: 0, B: 255, A: 128})
+	want = []byte{255, 0, 255, 128}
+	if pix := imagePix(rgba); !bytes.Equal(pix, want) {
+		t.Fatalf("imagePix:\nhave %v\nwant %v", pix, want)
+	}
+}
+
+func TestTextureImage(t *testing.T) {
+	src := image.NewNRGBA(image.Rect(0, 0, 64, 32))
+	for i := range src.Pix {
+		src.Pix[i] = byte(i * 7)
+	}
+	tex, err := NewTextureFromImage(src)
+	if err != nil {
+		t.Fatalf("NewTextureFromImage failed:\n%#v", err)
+	}
+	defer tex.Free()
+	tex.check(t)
+	if pf := tex.PixelFmt(); pf != driver.RGBA8SRGB {
+		t.Fatalf("NewTextureFromImage: Texture.PixelFmt\nhave %v\nwant %v", pf, driver.RGBA8SRGB)
+	}
+	if w, h := tex.Width(), tex.Height(); w != 64 || h != 32 {
+		t.Fatalf("NewTextureFromImage: Texture.Width/Height\nhave %d, %d\nwant 64, 32", w, h)
+	}
+	img, err := tex.ToImage()
+	if err != nil {
+		t.Fatalf("Texture.ToImage failed:\n%#v", err)
+	}
+	if !bytes.Equal(img.Pix, src.Pix) {
+		t.Fatal("Texture.ToImage: pixels differ from source")
+	}
+
+	if _, err := NewTextureFromImage(nil); err == nil {
+		t.Fatal("NewTextureFromImage: unexpected nil error")
+	}
+	tex2, err := New2D(&TexParam{
+		PixelFmt: driver.RGBA16Float,
+		Dim3D:    driver.Dim3D{Width: 16, Height: 16},
+		Layers:   1,
+		Levels:   1,
+		Samples:  1,
+	})
+	if err != nil {
+		t.Fatalf("New2D failed:\n%#v", err)
+	}
+	defer tex2.Free()
+	if _, err := tex2.ToImage(); err == nil {
+		t.Fatal("Texture.ToImage: unexpected nil error")
+	}
+}
+
+func TestCompareImages(t *testing.T) {
+	a := image.NewNRGBA(image.Rect(0, 0, 4, 4))
+	b := image.NewNRGBA(image.Rect(0, 0, 4, 4))
+	for i := range a.Pix {
+		a.Pix[i] = byte(i * 3)
+		b.Pix[i] = byte(i * 3)
+	}
+	if n, err := CompareImages(a, b, 0); err != nil || n != 0 {
+		t.Fatalf("CompareImages: n, err\nhave %d, %v\nwant 0, nil", n, err)
+	}
+	b.Pix[1] += 2
+	b.Pix[2] -= 2
+	b.Pix[40] -= 1
+	if n, _ := CompareImages(a, b, 0); n != 2 {
+		t.Fatalf("CompareImages: n\nhave %d\nwant 2", n)
+	}
+	if n, _ := CompareImages(a, b, 1); n != 1 {
+		t.Fatalf("CompareImages: n\nhave %d\nwant 1", n)
+	}
+	if n, _ := CompareImages(a, b, 2); n != 0 {
+		t.Fatalf("CompareImages: n\nhave %d\nwant 0", n)
+	}
+	if _, err := CompareImages(a, image.NewNRGBA(image.Rect(0, 0, 4, 3)), 255); err == nil {
+		t.Fatal("CompareImages: size mismatch should fail")
+	}
+}
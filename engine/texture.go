@@ -10,12 +10,18 @@ import (
 
 	"gviegas/neo3/driver"
 	"gviegas/neo3/engine/internal/ctxt"
-	"gviegas/neo3/internal/bitvec"
+	"gviegas/neo3/internal/suballoc"
 )
 
 const texPrefix = "texture: "
 
-func newTexErr(reason string) error { return errors.New(texPrefix + reason) }
+func newTexErr(reason string) error { return newErr(texPrefix, reason, ErrInvalidParam) }
+
+// newTexParamErr returns a *ValidationError for an
+// invalid TexParam field.
+func newTexParamErr(field, reason string) error {
+	return &ValidationError{texPrefix, field, reason}
+}
 
 // Texture wraps a driver.Image.
 type Texture struct {
@@ -26,26 +32,44 @@ type Texture struct {
 	views []driver.ImageView
 	usage driver.Usage
 	param TexParam
-	// The driver.Layout of each layer.
+	// The driver.Layout of each subresource,
+	// indexed by layer*param.Levels + level.
 	// A given layouts element will contain an
 	// invalid layout value while there is an
 	// uncommitted copy or ongoing Transition
-	// targeting the layer.
+	// targeting the subresource.
 	layouts []atomic.Int64
+	// Views created for TextureView.
+	cache   *viewCache
+	id      TextureID
+	cleanup runtime.Cleanup
 }
 
 // TexParam describes parameters of a texture.
+// MutableFmt indicates that the texture can also be
+// viewed with the sRGB/UNORM counterpart of its format
+// (see ViewParam.PixelFmt). It may prevent the driver
+// from compressing the texture, so it should only be
+// set when needed. It has no effect on formats that
+// have no such counterpart, nor when the driver does
+// not support mutable formats.
 type TexParam struct {
 	driver.PixelFmt
 	driver.Dim3D
-	Layers  int
-	Levels  int
-	Samples int
+	Layers     int
+	Levels     int
+	Samples    int
+	MutableFmt bool
 }
 
+// slices returns the number of depth slices in the
+// first mip level of a texture created from p.
+func (p *TexParam) slices() int { return max(1, p.Depth) }
+
 const (
 	tex2D = iota
 	texCube
+	tex3D
 	texTarget
 )
 
@@ -105,6 +129,10 @@ func makeViews(param *TexParam, usage driver.Usage, texType int) (v []driver.Ima
 			v = []driver.ImageView{nil}
 		}
 		nl = 6
+	case tex3D:
+		typ = driver.IView3D
+		v = []driver.ImageView{nil}
+		nl = 1
 	default:
 		panic("undefined texture type")
 	}
@@ -120,18 +148,49 @@ func makeViews(param *TexParam, usage driver.Usage, texType int) (v []driver.Ima
 				v[param.Layers/nl].Destroy()
 			}
 			img.Destroy()
-			v = nil
-			break
+			return nil, err
 		}
 	}
+	trackImage(img, param, usage)
 	return
 }
 
+// srgbPair returns the sRGB counterpart of a UNORM
+// format, or vice versa.
+func srgbPair(pf driver.PixelFmt) (driver.PixelFmt, bool) {
+	switch pf {
+	case driver.RGBA8Unorm:
+		return driver.RGBA8SRGB, true
+	case driver.RGBA8SRGB:
+		return driver.RGBA8Unorm, true
+	case driver.BGRA8Unorm:
+		return driver.BGRA8SRGB, true
+	case driver.BGRA8SRGB:
+		return driver.BGRA8Unorm, true
+	}
+	return driver.FInvalid, false
+}
+
+// mutableUsage returns driver.UMutableFmt if param
+// requests it, its format has an sRGB counterpart and
+// the driver supports mutable formats, so TextureViews
+// can toggle gamma handling.
+func mutableUsage(param *TexParam) driver.Usage {
+	if !param.MutableFmt {
+		return 0
+	}
+	if _, ok := srgbPair(param.PixelFmt); ok && ctxt.Features().MutableFormat {
+		return driver.UMutableFmt
+	}
+	return 0
+}
+
 // makeLayouts makes the initial layouts slice that
 // Texture expects.
+// There is one layout per layer per mip level.
 // All layouts are set to driver.LUndefined.
 func makeLayouts(param *TexParam) []atomic.Int64 {
-	layouts := make([]atomic.Int64, param.Layers)
+	layouts := make([]atomic.Int64, param.Layers*param.Levels)
 	if driver.LUndefined != 0 {
 		// This path should never be taken.
 		for i := range layouts {
@@ -144,124 +203,226 @@ func makeLayouts(param *TexParam) []atomic.Int64 {
 // New2D creates a 2D texture.
 func New2D(param *TexParam) (t *Texture, err error) {
 	limits := ctxt.Limits()
-	var reason string
+	var reason, field string
 	switch {
 	case param == nil:
 		reason = "nil param"
 	case param.Dim3D.Width < 1, param.Dim3D.Height < 1, param.Dim3D.Depth != 0:
-		reason = "invalid size"
+		reason, field = "invalid size", "Dim3D"
 	case param.Dim3D.Width > limits.MaxImage2D, param.Dim3D.Height > limits.MaxImage2D:
-		reason = "size too big"
+		reason, field = "size too big", "Dim3D"
 	case param.Layers < 1:
-		reason = "invalid layer count"
+		reason, field = "invalid layer count", "Layers"
 	case param.Layers > limits.MaxLayers:
-		reason = "too many layers"
+		reason, field = "too many layers", "Layers"
 	case param.Levels < 1, param.Levels > ComputeLevels(param.Dim3D):
-		reason = "invalid level count"
+		reason, field = "invalid level count", "Levels"
 	case param.Samples < 1, param.Samples&(param.Samples-1) != 0:
-		reason = "invalid sample count"
+		reason, field = "invalid sample count", "Samples"
 	case param.Levels > 1 && param.Samples != 1:
-		reason = "multi-sample mipmap"
+		reason, field = "multi-sample mipmap", "Samples"
 	default:
 		goto validParam
 	}
-	err = newTexErr(reason)
+	err = newTexParamErr(field, reason)
 	return
 validParam:
 	// TODO: Consider removing driver.UCopySrc and
 	// disallowing CopyFromView calls instead.
-	usage := driver.UCopySrc | driver.UCopyDst | driver.UShaderSample
+	usage := driver.UCopySrc | driver.UCopyDst | driver.UShaderSample | mutableUsage(param)
 	views, err := makeViews(param, usage, tex2D)
 	if err == nil {
-		// TODO: Should destroy driver resources
-		// when unreachable (unless Texture.Free
-		// is called first).
-		t = &Texture{views, usage, *param, makeLayouts(param)}
+		t = &Texture{
+			views:   views,
+			usage:   usage,
+			param:   *param,
+			layouts: makeLayouts(param),
+			cache:   new(viewCache),
+		}
+		t.cleanup = addCleanup(t, texRes.free, texRes{views, t.cache})
 	}
 	return
 }
 
 // NewCube creates a new cube texture.
-func NewCube(param *TexParam) (t *Texture, err error) {
+func NewCube(param *TexParam) (*Texture, error) {
+	// TODO: Consider removing driver.UCopySrc and
+	// disallowing CopyFromView calls instead.
+	return newCube(param, driver.UCopySrc|driver.UCopyDst|driver.UShaderSample)
+}
+
+// NewCubeTarget creates a new cube texture that can
+// also be used as render target.
+// Each face is rendered to separately, so the
+// texture need not support layered rendering.
+func NewCubeTarget(param *TexParam) (*Texture, error) {
+	return newCube(param, driver.UCopySrc|driver.UCopyDst|driver.UShaderSample|driver.URenderTarget)
+}
+
+// newCube creates a new cube texture with the given
+// usage.
+func newCube(param *TexParam, usage driver.Usage) (t *Texture, err error) {
 	limits := ctxt.Limits()
 	features := ctxt.Features()
-	var reason string
+	var reason, field string
 	switch {
 	case param == nil:
 		reason = "nil param"
 	case param.Dim3D.Width < 1, param.Dim3D.Height < 1, param.Dim3D.Depth != 0:
-		reason = "invalid size"
+		reason, field = "invalid size", "Dim3D"
 	case param.Dim3D.Width != param.Dim3D.Height:
-		reason = "cube's width and height differs"
+		reason, field = "cube's width and height differs", "Dim3D"
 	case param.Dim3D.Width > limits.MaxImageCube:
-		reason = "size too big"
+		reason, field = "size too big", "Dim3D"
+	case usage&driver.URenderTarget != 0 && param.Width > min(limits.MaxRenderSize[0], limits.MaxRenderSize[1]):
+		reason, field = "size too big", "Dim3D"
 	case param.Layers < 1:
-		reason = "invalid layer count"
+		reason, field = "invalid layer count", "Layers"
 	case param.Layers > limits.MaxLayers:
-		reason = "too many layers"
+		reason, field = "too many layers", "Layers"
 	case param.Layers%6 != 0:
-		reason = "cube's layer count not a multiple of 6"
+		reason, field = "cube's layer count not a multiple of 6", "Layers"
 	case param.Layers > 6 && !features.CubeArray:
-		reason = "cube arrays not supported"
+		reason, field = "cube arrays not supported", "Layers"
 	case param.Levels < 1, param.Levels > ComputeLevels(param.Dim3D):
-		reason = "invalid level count"
+		reason, field = "invalid level count", "Levels"
 	case param.Samples != 1:
-		reason = "multi-sample cube"
+		reason, field = "multi-sample cube", "Samples"
 	default:
 		goto validParam
 	}
-	err = newTexErr(reason)
+	err = newTexParamErr(field, reason)
 	return
 validParam:
-	// TODO: Consider removing driver.UCopySrc and
-	// disallowing CopyFromView calls instead.
-	usage := driver.UCopySrc | driver.UCopyDst | driver.UShaderSample
+	usage |= mutableUsage(param)
 	views, err := makeViews(param, usage, texCube)
 	if err == nil {
-		// TODO: Should destroy driver resources
-		// when unreachable (unless Texture.Free
-		// is called first).
-		t = &Texture{views, usage, *param, makeLayouts(param)}
+		t = &Texture{
+			views:   views,
+			usage:   usage,
+			param:   *param,
+			layouts: makeLayouts(param),
+			cache:   new(viewCache),
+		}
+		t.cleanup = addCleanup(t, texRes.free, texRes{views, t.cache})
+	}
+	return
+}
+
+// New3D creates a new 3D texture.
+// The depth of param.Dim3D must be at least 1, and
+// arrays and multi-sampling are not supported.
+func New3D(param *TexParam) (*Texture, error) {
+	return new3D(param, driver.UCopySrc|driver.UCopyDst|driver.UShaderSample)
+}
+
+// newVolume creates a new 3D texture that compute
+// passes can write to.
+func newVolume(param *TexParam) (*Texture, error) {
+	return new3D(param, driver.UShaderSample|driver.UShaderRead|driver.UShaderWrite)
+}
+
+// new3D creates a new 3D texture with the given usage.
+func new3D(param *TexParam, usage driver.Usage) (t *Texture, err error) {
+	limits := ctxt.Limits()
+	var reason, field string
+	switch {
+	case param == nil:
+		reason = "nil param"
+	case param.Dim3D.Width < 1, param.Dim3D.Height < 1, param.Dim3D.Depth < 1:
+		reason, field = "invalid size", "Dim3D"
+	case param.Dim3D.Width > limits.MaxImage3D, param.Dim3D.Height > limits.MaxImage3D, param.Dim3D.Depth > limits.MaxImage3D:
+		reason, field = "size too big", "Dim3D"
+	case param.Layers != 1:
+		reason, field = "3D texture arrays not supported", "Layers"
+	case param.Levels < 1, param.Levels > ComputeLevels(param.Dim3D):
+		reason, field = "invalid level count", "Levels"
+	case param.Samples != 1:
+		reason, field = "multi-sample 3D texture", "Samples"
+	default:
+		goto validParam
+	}
+	err = newTexParamErr(field, reason)
+	return
+validParam:
+	usage |= mutableUsage(param)
+	views, err := makeViews(param, usage, tex3D)
+	if err == nil {
+		t = &Texture{
+			views:   views,
+			usage:   usage,
+			param:   *param,
+			layouts: makeLayouts(param),
+			cache:   new(viewCache),
+		}
+		t.cleanup = addCleanup(t, texRes.free, texRes{views, t.cache})
 	}
 	return
 }
 
 // NewTarget creates a new render target texture.
-func NewTarget(param *TexParam) (t *Texture, err error) {
+func NewTarget(param *TexParam) (*Texture, error) {
+	// TODO: Consider removing driver.UCopyDst and
+	// disallowing CopyToView calls instead.
+	return newTarget(param, driver.UCopySrc|driver.UCopyDst|driver.UShaderSample|driver.URenderTarget)
+}
+
+// newStorage creates a new 2D texture that compute
+// passes can write to.
+func newStorage(param *TexParam) (*Texture, error) {
+	return newTarget(param, driver.UShaderSample|driver.UShaderRead|driver.UShaderWrite)
+}
+
+// NewTransient creates a new render target texture
+// whose contents do not outlive a render pass.
+// It can neither be sampled nor copied to/from, and
+// tile-based GPUs may not allocate memory for it at
+// all. This makes it suitable for intermediate
+// targets that are resolved or discarded by the end
+// of the pass, such as multisample depth buffers.
+func NewTransient(param *TexParam) (*Texture, error) {
+	return newTarget(param, driver.URenderTarget|driver.UTransient)
+}
+
+// newTarget creates a new render target texture with
+// the given usage.
+func newTarget(param *TexParam, usage driver.Usage) (t *Texture, err error) {
 	limits := ctxt.Limits()
-	var reason string
+	var reason, field string
 	switch {
 	case param == nil:
 		reason = "nil param"
 	case param.Dim3D.Width < 1, param.Dim3D.Height < 1, param.Dim3D.Depth != 0:
-		reason = "invalid size"
+		reason, field = "invalid size", "Dim3D"
 	case param.Width > limits.MaxRenderSize[0], param.Height > limits.MaxRenderSize[1]:
-		reason = "size too big"
+		reason, field = "size too big", "Dim3D"
 	case param.Layers < 1:
-		reason = "invalid layer count"
+		reason, field = "invalid layer count", "Layers"
 	case param.Layers > limits.MaxRenderLayers:
-		reason = "too many layers"
+		reason, field = "too many layers", "Layers"
 	case param.Levels < 1, param.Levels > ComputeLevels(param.Dim3D):
-		reason = "invalid level count"
+		reason, field = "invalid level count", "Levels"
 	case param.Samples < 1, param.Samples&(param.Samples-1) != 0:
-		reason = "invalid sample count"
+		reason, field = "invalid sample count", "Samples"
 	case param.Levels > 1 && param.Samples != 1:
-		reason = "multi-sample mipmap"
+		reason, field = "multi-sample mipmap", "Samples"
 	default:
 		goto validParam
 	}
-	err = newTexErr(reason)
+	err = newTexParamErr(field, reason)
 	return
 validParam:
-	// TODO: Consider removing driver.UCopyDst and
-	// disallowing CopyToView calls instead.
-	usage := driver.UCopySrc | driver.UCopyDst | driver.UShaderSample | driver.URenderTarget
+	usage |= mutableUsage(param)
 	views, err := makeViews(param, usage, texTarget)
 	if err == nil {
-		// TODO: Should destroy driver resources
-		// when unreachable (unless Texture.Free
-		// is called first).
-		t = &Texture{views, usage, *param, makeLayouts(param)}
+		t = &Texture{
+			views:   views,
+			usage:   usage,
+			param:   *param,
+			layouts: makeLayouts(param),
+			cache:   new(viewCache),
+		}
+		t.cleanup = addCleanup(t, texRes.free, texRes{views, t.cache})
 	}
 	return
 }
@@ -303,7 +464,7 @@ func (t *Texture) ViewLayers(view int) int {
 		panic("not a valid view of Texture")
 	}
 	if t.param.Layers > 1 {
-		if view == t.param.Layers {
+		if view == len(t.views)-1 {
 			// Entire array.
 			return t.param.Layers
 		}
@@ -318,13 +479,10 @@ func (t *Texture) ViewLayers(view int) int {
 // ViewSize returns the size in bytes of the given
 // view's memory.
 // It does not consider the memory consumed by
-// additional mip levels.
-//
-// TODO: Provide a method that actually considers
-// the whole mip chain.
+// additional mip levels (see ViewLevelsSize).
 func (t *Texture) ViewSize(view int) int {
 	nl := t.ViewLayers(view)
-	n := t.param.Size() * t.param.Width * t.param.Height
+	n := t.param.Size() * t.param.Width * t.param.Height * t.param.slices()
 	return nl * n
 }
 
@@ -333,9 +491,10 @@ func (t *Texture) ViewSize(view int) int {
 // If t is arrayed and view is the last view, then
 // data must contain the first level of every layer,
 // in order and tightly packed.
-// Unless commit is true, the copy may be delayed.
-//
-// TODO: Allow copying data to any mip level.
+// Unless commit is true, the copy may be delayed
+// (see FlushUploads).
+// Use CopyLevelsToView to copy data to other mip
+// levels.
 func (t *Texture) CopyToView(view int, data []byte, commit bool) error {
 	if x := t.ViewSize(view); x < len(data) {
 		data = data[:x]
@@ -349,9 +508,73 @@ func (t *Texture) CopyToView(view int, data []byte, commit bool) error {
 		}
 	}
 	texStg <- s
+	if !commit && err == nil && texStgSize.Add(int64(len(data))) >= streamBudget.Load() {
+		autoCommitTexStg()
+	}
+	return err
+}
+
+// CopyLevelsToView copies CPU data to a range of mip
+// levels of the given view of t.
+// data must contain every layer of the view for the
+// first level in the range, then every layer for the
+// next level, and so on, tightly packed. The size of
+// each level is computed as in ComputeLevels (i.e.,
+// dimensions are halved and rounded down, with a
+// minimum of 1).
+// Every level is staged at once and recorded in a
+// single command buffer, so the levels are uploaded
+// in one submission.
+// Unless commit is true, the copy may be delayed
+// (see FlushUploads).
+func (t *Texture) CopyLevelsToView(view, level, levels int, data []byte, commit bool) error {
+	if level < 0 || levels < 1 || level+levels > t.param.Levels {
+		return newTexErr("level range out of bounds")
+	}
+	n := t.ViewLevelsSize(view, level, levels)
+	if len(data) < n {
+		return newTexErr("not enough data for copying")
+	}
+	data = data[:n]
+	s := <-texStg
+	err := s.copyLevelsToView(t, view, level, levels, data)
+	if commit && err == nil {
+		err = s.commit()
+	}
+	texStg <- s
+	if !commit && err == nil && texStgSize.Add(int64(len(data))) >= streamBudget.Load() {
+		autoCommitTexStg()
+	}
 	return err
 }
 
+// ViewLevelsSize returns the number of bytes that
+// CopyLevelsToView requires for the given view and
+// range of levels.
+// It returns 0 if the range is not valid.
+func (t *Texture) ViewLevelsSize(view, level, levels int) int {
+	if !t.IsValidView(view) || level < 0 || levels < 1 || level+levels > t.param.Levels {
+		return 0
+	}
+	nl := t.ViewLayers(view)
+	var n int
+	for i := level; i < level+levels; i++ {
+		w, h, d := t.levelDim(i)
+		n += t.param.Size() * w * h * d * nl
+	}
+	return n
+}
+
+// levelDim returns the dimensions of the given mip
+// level of t.
+// d is 1 for textures that are not 3D.
+func (t *Texture) levelDim(level int) (w, h, d int) {
+	w = max(1, t.param.Width>>level)
+	h = max(1, t.param.Height>>level)
+	d = max(1, t.param.slices()>>level)
+	return
+}
+
 // CopyFromView copies t's view to a given CPU buffer.
 // It returns the number of bytes written to dst.
 // This method does not grow the dst buffer, so data
@@ -378,26 +601,61 @@ func (t *Texture) CopyFromView(view int, dst []byte) (int, error) {
 
 const invalLayout = -1
 
-// setPending stores invalLayout in t.layouts[layer] and
-// returns the replaced layout.
+// setPending stores invalLayout in the layout of the
+// given layer/level and returns the replaced layout.
 // It panics if the current layout is invalid.
-func (t *Texture) setPending(layer int) driver.Layout {
-	if layout := t.layouts[layer].Swap(invalLayout); layout != invalLayout {
+func (t *Texture) setPending(layer, level int) driver.Layout {
+	i := layer*t.param.Levels + level
+	if layout := t.layouts[i].Swap(invalLayout); layout != invalLayout {
 		return driver.Layout(layout)
 	}
 	panic("layout already pending")
 }
 
-// unsetPending stores layout in t.layouts[layer].
+// viewPending returns whether the first level of any
+// layer in the given view has a pending operation.
+func (t *Texture) viewPending(view int) bool {
+	il, nl := t.viewLayers(view)
+	for i := il; i < il+nl; i++ {
+		if t.layouts[i*t.param.Levels].Load() == invalLayout {
+			return true
+		}
+	}
+	return false
+}
+
+// unsetPending stores layout in the layout of the
+// given layer/level.
 // It panics if the current layout is valid.
-func (t *Texture) unsetPending(layer int, layout driver.Layout) {
-	if !t.layouts[layer].CompareAndSwap(invalLayout, int64(layout)) {
+func (t *Texture) unsetPending(layer, level int, layout driver.Layout) {
+	i := layer*t.param.Levels + level
+	if !t.layouts[i].CompareAndSwap(invalLayout, int64(layout)) {
 		panic("layout not pending")
 	}
 }
 
+// viewLayers returns the range of layers that view
+// refers to.
+func (t *Texture) viewLayers(view int) (il, nl int) {
+	il = view
+	nl = 1
+	if t.param.Layers > 1 {
+		if view == len(t.views)-1 {
+			// Entire array.
+			il = 0
+			nl = t.param.Layers
+		} else if len(t.views) < t.param.Layers {
+			// Cube faces.
+			il = view * 6
+			nl = 6
+		}
+	}
+	return
+}
+
 // transition records a layout transition for view in
 // the given command buffer.
+// Every mip level of the view is transitioned.
 // The caller must ensure that no copies targeting
 // this particular view of t happen until the command
 // completes execution.
@@ -405,9 +663,22 @@ func (t *Texture) unsetPending(layer int, layout driver.Layout) {
 // t.setLayout after the transition executes to
 // update t's state.
 func (t *Texture) transition(view int, cb driver.CmdBuffer, layout driver.Layout, barrier driver.Barrier) {
+	t.transitionLevels(view, 0, t.param.Levels, cb, layout, barrier)
+}
+
+// transitionLevels is like transition, but it only
+// transitions the levels in the range
+// [level, level+levels).
+// The caller is responsible for calling
+// t.setLevelsLayout with the same range after the
+// transition executes to update t's state.
+func (t *Texture) transitionLevels(view, level, levels int, cb driver.CmdBuffer, layout driver.Layout, barrier driver.Barrier) {
 	if !t.IsValidView(view) {
 		panic("not a valid view of Texture")
 	}
+	if level < 0 || levels < 1 || level+levels > t.param.Levels {
+		panic("level range out of bounds")
+	}
 	if !cb.IsRecording() {
 		panic("driver.CmdBuffer is not recording")
 	}
@@ -415,59 +686,72 @@ func (t *Texture) transition(view int, cb driver.CmdBuffer, layout driver.Layout
 		panic("layout is driver.LUndefined")
 	}
 
-	il := view
-	nl := 1
-	if t.param.Layers > 1 {
-		if view == t.param.Layers {
-			// Entire array.
-			il = 0
-			nl = t.param.Layers
-		} else if len(t.views) < t.param.Layers {
-			// Cube faces.
-			il = view * 6
-			nl = 6
+	il, nl := t.viewLayers(view)
+	before := make([]driver.Layout, 0, nl*levels)
+	for i := 0; i < nl; i++ {
+		for j := 0; j < levels; j++ {
+			before = append(before, t.setPending(il+i, level+j))
 		}
 	}
-	// Need to split the transition if the
-	// layouts of any two layers differ.
-	// TODO: Maybe try to merge contiguous
-	// layers that share the same layout.
-	var differ bool
-	before := []driver.Layout{t.setPending(il)}
-	for i := 1; i < nl; i++ {
-		layout := t.setPending(il + i)
-		before = append(before, layout)
-		differ = differ || layout != before[0]
-	}
-
-	if differ {
-		// TODO: Consider caching this on t.
-		xs := make([]driver.Transition, nl)
-		for i := 0; i < nl; i++ {
-			xs = append(xs, driver.Transition{
-				Barrier:      barrier,
-				LayoutBefore: before[i],
-				LayoutAfter:  layout,
-				Img:          t.views[view].Image(),
-				Layer:        il + i,
-				Layers:       1,
-				Level:        0,
-				Levels:       t.param.Levels,
-			})
+	xs := mergeTransitions(before, il, nl, level, levels, driver.Transition{
+		Barrier:     barrier,
+		LayoutAfter: layout,
+		Img:         t.views[view].Image(),
+	})
+	cb.Transition(xs)
+}
+
+// mergeTransitions generates the transitions for the
+// subresource range that starts at layer il/level lv
+// and spans nl layers and nlv levels.
+// before contains the current layout of each
+// subresource in the range, ordered by layer and
+// then by level.
+// Contiguous levels sharing the same layout are
+// merged into a single transition, as are contiguous
+// layers whose levels were merged in the same way.
+// x provides the remaining fields of the transitions.
+func mergeTransitions(before []driver.Layout, il, nl, lv, nlv int, x driver.Transition) []driver.Transition {
+	var xs []driver.Transition
+	// The transitions of the previous layer
+	// start at xs[p].
+	p := 0
+	for i := 0; i < nl; i++ {
+		n := len(xs)
+		layer := before[i*nlv : (i+1)*nlv]
+		for j := 0; j < nlv; {
+			k := j + 1
+			for k < nlv && layer[k] == layer[j] {
+				k++
+			}
+			x.LayoutBefore = layer[j]
+			x.Layer = il + i
+			x.Layers = 1
+			x.Level = lv + j
+			x.Levels = k - j
+			xs = append(xs, x)
+			j = k
 		}
-		cb.Transition(xs)
-	} else {
-		cb.Transition([]driver.Transition{{
-			Barrier:      barrier,
-			LayoutBefore: before[0],
-			LayoutAfter:  layout,
-			Img:          t.views[view].Image(),
-			Layer:        il,
-			Layers:       nl,
-			Level:        0,
-			Levels:       t.param.Levels,
-		}})
+		cur, prev := xs[n:], xs[p:n]
+		if i > 0 && len(cur) == len(prev) {
+			same := true
+			for j := range cur {
+				if cur[j].LayoutBefore != prev[j].LayoutBefore || cur[j].Levels != prev[j].Levels {
+					same = false
+					break
+				}
+			}
+			if same {
+				for j := range prev {
+					prev[j].Layers++
+				}
+				xs = xs[:n]
+				continue
+			}
+		}
+		p = n
 	}
+	return xs
 }
 
 // setLayout sets the layout of view.
@@ -479,24 +763,24 @@ func (t *Texture) transition(view int, cb driver.CmdBuffer, layout driver.Layout
 // Calling this method with no preceding transition is
 // not allowed.
 func (t *Texture) setLayout(view int, layout driver.Layout) {
+	t.setLevelsLayout(view, 0, t.param.Levels, layout)
+}
+
+// setLevelsLayout is like setLayout, but it only sets
+// the layout of levels in the range [level, level+levels).
+// It must be called after t.transitionLevels.
+func (t *Texture) setLevelsLayout(view, level, levels int, layout driver.Layout) {
 	if !t.IsValidView(view) {
 		panic("not a valid view of Texture")
 	}
-	il := view
-	nl := 1
-	if t.param.Layers > 1 {
-		if view == t.param.Layers {
-			// Entire array.
-			il = 0
-			nl = t.param.Layers
-		} else if len(t.views) < t.param.Layers {
-			// Cube faces.
-			il = view * 6
-			nl = 6
-		}
+	if level < 0 || levels < 1 || level+levels > t.param.Levels {
+		panic("level range out of bounds")
 	}
+	il, nl := t.viewLayers(view)
 	for i := 0; i < nl; i++ {
-		t.unsetPending(il+i, layout)
+		for j := 0; j < levels; j++ {
+			t.unsetPending(il+i, level+j, layout)
+		}
 	}
 }
 
@@ -509,6 +793,10 @@ func (t *Texture) Width() int { return t.param.Width }
 // Height returns the height of t's first mip level.
 func (t *Texture) Height() int { return t.param.Height }
 
+// Depth returns the depth of t's first mip level.
+// It is zero unless t is a 3D texture.
+func (t *Texture) Depth() int { return t.param.Depth }
+
 // Layers returns the number of layers in t.
 func (t *Texture) Layers() int { return t.param.Layers }
 
@@ -524,14 +812,26 @@ func (t *Texture) Samples() int { return t.param.Samples }
 // are no pending copies targeting any view of t, and
 // that none is issued during the call.
 func (t *Texture) Free() {
-	if len(t.views) > 0 {
-		img := t.views[0].Image()
-		for _, v := range t.views {
+	t.cleanup.Stop()
+	if t.cache != nil {
+		t.cache.free()
+	}
+	freeViews(t.views)
+	textureIDs.release((*uint64)(&t.id))
+	*t = Texture{}
+}
+
+// freeViews destroys the views of a Texture and
+// the driver.Image they refer to.
+func freeViews(views []driver.ImageView) {
+	if len(views) > 0 {
+		img := views[0].Image()
+		untrackImage(img)
+		for _, v := range views {
 			v.Destroy()
 		}
 		img.Destroy()
 	}
-	*t = Texture{}
 }
 
 // ComputeLevels returns the maximum number of mip levels
@@ -557,6 +857,7 @@ func ComputeLevels(size driver.Dim3D) int {
 type Sampler struct {
 	sampler driver.Sampler
 	param   SplrParam
+	cleanup runtime.Cleanup
 }
 
 // SplrParam describes parameters of a sampler.
@@ -584,10 +885,8 @@ func NewSampler(param *SplrParam) (s *Sampler, err error) {
 validParam:
 	splr, err := ctxt.GPU().NewSampler(param)
 	if err == nil {
-		// TODO: Should destroy driver resource
-		// when unreachable (unless Sampler.Free
-		// is called first).
-		s = &Sampler{splr, *param}
+		s = &Sampler{sampler: splr, param: *param}
+		s.cleanup = addCleanup(s, driver.Sampler.Destroy, splr)
 	}
 	return
 }
@@ -631,6 +930,7 @@ func (s *Sampler) MaxLOD() float32 { return s.param.MaxLOD }
 
 // Free invalidates s and destroys the driver.Sampler.
 func (s *Sampler) Free() {
+	s.cleanup.Stop()
 	if s.sampler != nil {
 		s.sampler.Destroy()
 	}
@@ -638,83 +938,148 @@ func (s *Sampler) Free() {
 }
 
 // TODO:
-// - Separate read/write staging buffers;
-// - Give more control to when commit happens;
-// - Support buffer staging.
+// - Separate read/write staging buffers.
 
 var (
 	// Global texture staging buffer(s).
 	texStg chan *texStgBuffer
 	// Variables for commitTexStg calls.
-	texStgMu    sync.Mutex
-	texStgCache []*texStgBuffer
-	texStgWk    chan *driver.WorkItem
+	texStgMu sync.Mutex
+	// Number of bytes staged by delayed copies
+	// since the last commit.
+	texStgSize atomic.Int64
+	// Upload of the last automatic commit, if no
+	// commitTexStg call happened since.
+	texStgAuto *Upload
 )
 
-func init() {
-	n := runtime.GOMAXPROCS(-1)
+func init() { initTexStg() }
+
+// initTexStg initializes the global texStgBuffers.
+func initTexStg() {
+	n := config.StagingBuffers
 	texStg = make(chan *texStgBuffer, n)
 	for i := 0; i < n; i++ {
-		s, err := newTexStg(texStgBlock * texStgNBit)
+		s, err := newTexStg(max(int(StagingGrowth().Initial), 1))
 		if err != nil {
 			s = &texStgBuffer{}
 		}
 		texStg <- s
 	}
-	texStgCache = make([]*texStgBuffer, 0, n)
-	texStgWk = make(chan *driver.WorkItem, 1)
-	texStgWk <- &driver.WorkItem{Work: make([]driver.CmdBuffer, 0, n)}
+	texStgSize.Store(0)
+	texStgAuto = nil
 }
 
-// commitTexStg executes all pending Texture copies.
-// It blocks until execution completes.
-func commitTexStg() (err error) {
+// freeTexStg destroys the global texStgBuffers.
+// It waits for every commit to complete.
+// initTexStg must be called before the staging
+// buffers are used again.
+func freeTexStg() {
 	texStgMu.Lock()
-	swk := <-texStgWk
-
-	// This deferral correctly clears global
-	// state, regardless of the outcome.
-	// Code below ensures that the command
-	// buffers are reset if necessary.
-	defer func() {
-		for _, x := range texStgCache {
-			x.bv.Clear()
-			x.drainPending(err != nil)
-			texStg <- x
-		}
-		texStgCache = texStgCache[:0]
-		swk.Work = swk.Work[:0]
-		texStgWk <- swk
-		texStgMu.Unlock()
-	}()
+	defer texStgMu.Unlock()
+	for range cap(texStg) {
+		(<-texStg).free()
+	}
+}
+
+// Upload represents Texture copies that were
+// submitted for execution.
+// The copied views cannot be used until the Upload
+// completes.
+type Upload struct {
+	done chan struct{}
+	err  error
+	// Automatic commit whose outcome is reported
+	// by this Upload. Cleared on completion.
+	prev *Upload
+	// Ended when the commit completes.
+	span traceSpan
+}
+
+// Done returns whether u has completed.
+func (u *Upload) Done() bool {
+	select {
+	case <-u.done:
+		return true
+	default:
+		return false
+	}
+}
+
+// Wait blocks until u completes and returns the
+// error of the copies' execution, if any.
+// Errors of copies that were committed automatically
+// (see FlushUploads) are reported by the next Upload.
+func (u *Upload) Wait() error {
+	<-u.done
+	return u.err
+}
+
+// FlushUploads submits every pending Texture copy for
+// execution. It does not wait for the copies to
+// complete.
+// Copies made by Texture.CopyToView with commit set to
+// false are batched until either FlushUploads is
+// called, a Presenter frame begins or enough data is
+// staged. This way, loading many small textures
+// results in few submissions.
+func FlushUploads() *Upload { return commitTexStg() }
+
+// commitTexStg submits all pending Texture copies for
+// execution.
+// The staging buffers are unavailable until the
+// returned Upload completes, so other copies will
+// block in the meantime.
+func commitTexStg() *Upload { return submitTexStg(false) }
+
+// autoCommitTexStg is like commitTexStg, except that
+// the outcome is reported by the next commitTexStg.
+func autoCommitTexStg() { submitTexStg(true) }
+
+// submitTexStg ends the command buffers of the global
+// texStgBuffers and commits them.
+// It does not wait for execution to complete.
+func submitTexStg(auto bool) *Upload {
+	texStgMu.Lock()
+	defer texStgMu.Unlock()
+	texStgSize.Store(0)
+	u := &Upload{done: make(chan struct{}), prev: texStgAuto}
+	if auto {
+		texStgAuto = u
+	} else {
+		texStgAuto = nil
+	}
 
 	n := cap(texStg)
+	stgs := make([]*texStgBuffer, 0, n)
 	for i := 0; i < n; i++ {
-		texStgCache = append(texStgCache, <-texStg)
+		stgs = append(stgs, <-texStg)
 	}
+	swk := &driver.WorkItem{Work: make([]driver.CmdBuffer, 0, n), Background: true}
 
-	for i, x := range texStgCache {
+	for i, x := range stgs {
 		wk := <-x.wk
 		if !wk.Work[0].IsRecording() {
 			if len(x.pend) != 0 {
 				// This should never happen.
 				panic("commitTexStg: pending copies while not recording")
 			}
-		} else if err = wk.Work[0].End(); err != nil {
+		} else if err := wk.Work[0].End(); err != nil {
 			x.wk <- wk
 			for _, x := range swk.Work {
 				// Need to reset these since
 				// they won't be committed.
 				x.Reset()
 			}
-			for _, x := range texStgCache[i+1:] {
+			for _, x := range stgs[i+1:] {
 				// Need to reset these since
 				// they won't be ended.
 				wk := <-x.wk
 				wk.Work[0].Reset()
 				x.wk <- wk
 			}
-			return
+			go u.finish(stgs, nil, err)
+			return u
 		} else {
 			swk.Work = append(swk.Work, wk.Work[0])
 		}
@@ -722,30 +1087,63 @@ func commitTexStg() (err error) {
 	}
 
 	if len(swk.Work) == 0 {
-		return
+		go u.finish(stgs, nil, nil)
+		return u
+	}
+	ch := make(chan *driver.WorkItem, 1)
+	u.span = traceBegin(traceSubmit, "texture copy")
+	if err := ctxt.GPU().Commit(swk, ch); err != nil {
+		for _, x := range swk.Work {
+			x.Reset()
+		}
+		go u.finish(stgs, nil, err)
+		return u
 	}
-	if err = ctxt.GPU().Commit(swk, texStgWk); err != nil {
-		return
+	go u.finish(stgs, ch, nil)
+	return u
+}
+
+// finish completes u.
+// If ch is not nil, it waits for the commit to
+// complete first. Then it updates the pending
+// textures and returns stgs to the global staging
+// buffers.
+func (u *Upload) finish(stgs []*texStgBuffer, ch chan *driver.WorkItem, err error) {
+	if ch != nil {
+		err = (<-ch).Err
+		u.span.endGPU()
+	}
+	for _, x := range stgs {
+		x.alloc.Reset()
+		x.drainPending(err != nil)
+		texStg <- x
+	}
+	if u.prev != nil {
+		if perr := u.prev.Wait(); perr != nil {
+			err = errors.Join(perr, err)
+		}
+		u.prev = nil
 	}
-	swk = <-texStgWk
-	err, swk.Err = swk.Err, nil
-	return
+	u.err = err
+	close(u.done)
 }
 
 // texStgBuffer is used to copy image data
 // between the CPU and the GPU.
 type texStgBuffer struct {
-	wk   chan *driver.WorkItem
-	buf  driver.Buffer
-	bv   bitvec.V[uint32]
-	pend []pendingCopy
+	wk    chan *driver.WorkItem
+	buf   driver.Buffer
+	alloc suballoc.Allocator
+	pend  []pendingCopy
 }
 
-// pendingCopy is used to track Texture/view
-// pairs that have a pending copy operation.
+// pendingCopy is used to track Texture
+// subresources that have a pending copy
+// operation.
 type pendingCopy struct {
-	tex  *Texture
-	view int
+	tex   *Texture
+	layer int
+	level int
 	// The layout that will be set
 	// after the copy executes.
 	layout driver.Layout
@@ -754,11 +1152,11 @@ type pendingCopy struct {
 // Use a large block size since textures usually
 // need large allocations.
 // 1024x1024 32-bit textures (no mip) will take
-// one bit vector word with this configuration.
-const (
-	texStgBlock = 131072
-	texStgNBit  = 32
-)
+// one allocator word with the default block size.
+// texStgBlock is set by Init (Config.TexStagingBlock).
+var texStgBlock = defaultConfig.TexStagingBlock
+
+const texStgNBit = suballoc.Word
 
 // newTexStg creates a new texStgBuffer with the
 // given size in bytes.
@@ -768,21 +1166,21 @@ func newTexStg(n int) (*texStgBuffer, error) {
 	if n <= 0 {
 		panic("newTexStg: n <= 0")
 	}
-	cb, err := ctxt.GPU().NewCmdBuffer()
+	cb, err := cmdBufs.get()
 	if err != nil {
 		return nil, err
 	}
 	wk := make(chan *driver.WorkItem, 1)
-	wk <- &driver.WorkItem{Work: []driver.CmdBuffer{cb}}
+	wk <- &driver.WorkItem{Work: []driver.CmdBuffer{cb}, Background: true}
 	n = (n + texStgBlock*texStgNBit - 1) &^ (texStgBlock*texStgNBit - 1)
 	buf, err := ctxt.GPU().NewBuffer(int64(n), true, driver.UCopySrc|driver.UCopyDst)
 	if err != nil {
-		cb.Destroy()
+		cmdBufs.put(cb)
 		return nil, err
 	}
-	var bv bitvec.V[uint32]
-	bv.Grow(n / texStgBlock / texStgNBit)
-	return &texStgBuffer{wk, buf, bv, nil}, nil
+	s := &texStgBuffer{wk: wk, buf: buf}
+	s.alloc.Grow(n / texStgBlock)
+	return s, nil
 }
 
 // copyToView records a copy command that copies
@@ -798,6 +1196,9 @@ func (s *texStgBuffer) copyToView(t *Texture, view int, off int64) (err error) {
 	if t.param.Samples != 1 {
 		return newTexErr("cannot copy data to MS texture")
 	}
+	if t.usage&driver.UCopyDst == 0 {
+		return newTexErr("cannot copy data to transient texture")
+	}
 	if view < 0 || view >= len(t.views) {
 		return newTexErr("view index out of bounds")
 	}
@@ -815,7 +1216,7 @@ func (s *texStgBuffer) copyToView(t *Texture, view int, off int64) (err error) {
 			nl = 6
 		}
 	}
-	n := t.param.PixelFmt.Size() * t.param.Dim3D.Width * t.param.Dim3D.Height
+	n := t.param.PixelFmt.Size() * t.param.Dim3D.Width * t.param.Dim3D.Height * t.param.slices()
 	if off+int64(n*nl) > s.buf.Cap() {
 		return newTexErr("not enough buffer capacity for copying")
 	}
@@ -823,7 +1224,7 @@ func (s *texStgBuffer) copyToView(t *Texture, view int, off int64) (err error) {
 	wk := <-s.wk
 	if !wk.Work[0].IsRecording() {
 		if err = wk.Work[0].Begin(); err != nil {
-			s.bv.Clear()
+			s.alloc.Reset()
 			s.wk <- wk
 			return
 		}
@@ -865,8 +1266,8 @@ func (s *texStgBuffer) copyToView(t *Texture, view int, off int64) (err error) {
 		// be overwritten by this command.
 		// TODO: Change this when adding support
 		// for sub-view copying.
-		_ = t.setPending(il + i)
-		s.pend = append(s.pend, pendingCopy{t, il + i, driver.LCopyDst})
+		_ = t.setPending(il+i, 0)
+		s.pend = append(s.pend, pendingCopy{t, il + i, 0, driver.LCopyDst})
 	}
 	if t.param.Levels > 1 {
 		// TODO
@@ -877,6 +1278,142 @@ func (s *texStgBuffer) copyToView(t *Texture, view int, off int64) (err error) {
 	return
 }
 
+// Alignment of data staged for buffer/image copies
+// (see driver.BufImgCopy).
+const (
+	texStgOffAlign = 512
+	texStgRowAlign = 256
+)
+
+// stagedLevel describes the placement of a mip level
+// in a staging buffer.
+type stagedLevel struct {
+	// Offset from the start of the staged data.
+	off int64
+	// Row length, in pixels.
+	rowStrd int
+}
+
+// stageLayout computes the placement of the given
+// range of mip levels of t, for nl layers, such that
+// the requirements of driver.BufImgCopy are met.
+// It returns the placement of each level and the
+// total number of bytes required.
+func (t *Texture) stageLayout(level, levels, nl int) (lvs []stagedLevel, n int64) {
+	ps := t.param.Size()
+	lvs = make([]stagedLevel, levels)
+	for i := range lvs {
+		w, h, d := t.levelDim(level + i)
+		row := int64(w * ps)
+		if texStgRowAlign%ps == 0 {
+			row = driver.AlignUp(row, texStgRowAlign)
+		}
+		n = driver.AlignUp(n, texStgOffAlign)
+		lvs[i] = stagedLevel{n, int(row) / ps}
+		n += row * int64(h*d*nl)
+	}
+	return
+}
+
+// copyLevelsToView stages data and records copy
+// commands that copy it into the given range of mip
+// levels of view.
+// data must be laid out as described in
+// Texture.CopyLevelsToView.
+func (s *texStgBuffer) copyLevelsToView(t *Texture, view, level, levels int, data []byte) (err error) {
+	if t.param.Samples != 1 {
+		return newTexErr("cannot copy data to MS texture")
+	}
+	if t.usage&driver.UCopyDst == 0 {
+		return newTexErr("cannot copy data to transient texture")
+	}
+	if view < 0 || view >= len(t.views) {
+		return newTexErr("view index out of bounds")
+	}
+	il, nl := t.viewLayers(view)
+	lvs, n := t.stageLayout(level, levels, nl)
+	off, err := s.reserve(int(n))
+	if err != nil {
+		return
+	}
+
+	// Rows are copied one by one only if they
+	// need padding.
+	ps := t.param.Size()
+	p := s.buf.Bytes()[off:]
+	for i, lv := range lvs {
+		w, h, d := t.levelDim(level + i)
+		row := w * ps
+		rows := h * d * nl
+		if lv.rowStrd == w {
+			data = data[copy(p[lv.off:], data[:row*rows]):]
+			continue
+		}
+		dst := p[lv.off:]
+		for range rows {
+			copy(dst, data[:row])
+			data = data[row:]
+			dst = dst[lv.rowStrd*ps:]
+		}
+	}
+
+	wk := <-s.wk
+	if !wk.Work[0].IsRecording() {
+		if err = wk.Work[0].Begin(); err != nil {
+			s.alloc.Reset()
+			s.wk <- wk
+			return
+		}
+	}
+
+	img := t.views[view].Image()
+	wk.Work[0].Transition([]driver.Transition{{
+		Barrier: driver.Barrier{
+			SyncBefore:   driver.SNone,
+			SyncAfter:    driver.SCopy,
+			AccessBefore: driver.ANone,
+			AccessAfter:  driver.ACopyWrite,
+		},
+		LayoutBefore: driver.LUndefined,
+		LayoutAfter:  driver.LCopyDst,
+		Img:          img,
+		Layer:        il,
+		Layers:       nl,
+		Level:        level,
+		Levels:       levels,
+	}})
+
+	for i, lv := range lvs {
+		w, h, d := t.levelDim(level + i)
+		size := driver.Dim3D{Width: w, Height: h}
+		if t.param.Depth > 0 {
+			size.Depth = d
+		}
+		wk.Work[0].CopyBufToImg(&driver.BufImgCopy{
+			Buf:     s.buf,
+			BufOff:  off + lv.off,
+			RowStrd: lv.rowStrd,
+			SlcStrd: h,
+			Img:     img,
+			Layer:   il,
+			Level:   level + i,
+			Size:    size,
+			Layers:  nl,
+			// TODO: Handle depth/stencil formats.
+		})
+		for j := range nl {
+			// Every level in the range is
+			// overwritten, so the current
+			// layout is not relevant.
+			_ = t.setPending(il+j, level+i)
+			s.pend = append(s.pend, pendingCopy{t, il + j, level + i, driver.LCopyDst})
+		}
+	}
+
+	s.wk <- wk
+	return
+}
+
 // copyFromView records a copy command that copies
 // data from view into s's buffer.
 // off must have been returned by a previous call
@@ -886,6 +1423,9 @@ func (s *texStgBuffer) copyFromView(t *Texture, view int, off int64) (err error)
 	if t.param.Samples != 1 {
 		return newTexErr("cannot copy data from MS texture")
 	}
+	if t.usage&driver.UCopySrc == 0 {
+		return newTexErr("cannot copy data from transient texture")
+	}
 	if view < 0 || view >= len(t.views) {
 		return newTexErr("view index out of bounds")
 	}
@@ -905,71 +1445,35 @@ func (s *texStgBuffer) copyFromView(t *Texture, view int, off int64) (err error)
 	}
 	// TODO: Consider the required space for
 	// all mip levels.
-	n := t.param.PixelFmt.Size() * t.param.Dim3D.Width * t.param.Dim3D.Height
+	n := t.param.PixelFmt.Size() * t.param.Dim3D.Width * t.param.Dim3D.Height * t.param.slices()
 	if off+int64(n*nl) > s.buf.Cap() {
 		return newTexErr("not enough buffer capacity for copying")
 	}
-	// Need to split the transition if the
-	// layouts of any two layers differ.
-	// TODO: Maybe try to merge contiguous
-	// layers that share the same layout.
-	var differ bool
-	before := []driver.Layout{t.setPending(il)}
-	for i := 1; i < nl; i++ {
-		layout := t.setPending(il + i)
-		before = append(before, layout)
-		differ = differ || layout != before[0]
+	// Only the first level is copied.
+	before := make([]driver.Layout, nl)
+	for i := range before {
+		before[i] = t.setPending(il+i, 0)
 	}
 
 	wk := <-s.wk
 	if !wk.Work[0].IsRecording() {
 		if err = wk.Work[0].Begin(); err != nil {
-			s.bv.Clear()
+			s.alloc.Reset()
 			s.wk <- wk
 			return
 		}
 	}
 
-	if differ {
-		// TODO: Consider caching this on s
-		// (or t; see Texture.Transition).
-		xs := make([]driver.Transition, nl)
-		img := t.views[view].Image()
-		for i := 0; i < nl; i++ {
-			xs = append(xs, driver.Transition{
-				Barrier: driver.Barrier{
-					SyncBefore:   driver.SNone,
-					SyncAfter:    driver.SCopy,
-					AccessBefore: driver.ANone,
-					AccessAfter:  driver.ACopyRead,
-				},
-				LayoutBefore: before[i],
-				LayoutAfter:  driver.LCopySrc,
-				Img:          img,
-				Layer:        il + i,
-				Layers:       1,
-				Level:        0,
-				Levels:       1, // TODO
-			})
-		}
-		wk.Work[0].Transition(xs)
-	} else {
-		wk.Work[0].Transition([]driver.Transition{{
-			Barrier: driver.Barrier{
-				SyncBefore:   driver.SNone,
-				SyncAfter:    driver.SCopy,
-				AccessBefore: driver.ANone,
-				AccessAfter:  driver.ACopyRead,
-			},
-			LayoutBefore: before[0],
-			LayoutAfter:  driver.LCopySrc,
-			Img:          t.views[view].Image(),
-			Layer:        il,
-			Layers:       nl,
-			Level:        0,
-			Levels:       1, // TODO
-		}})
-	}
+	wk.Work[0].Transition(mergeTransitions(before, il, nl, 0, 1, driver.Transition{
+		Barrier: driver.Barrier{
+			SyncBefore:   driver.SNone,
+			SyncAfter:    driver.SCopy,
+			AccessBefore: driver.ANone,
+			AccessAfter:  driver.ACopyRead,
+		},
+		LayoutAfter: driver.LCopySrc,
+		Img:         t.views[view].Image(),
+	}))
 
 	wk.Work[0].CopyImgToBuf(&driver.BufImgCopy{
 		Buf:    s.buf,
@@ -986,7 +1490,7 @@ func (s *texStgBuffer) copyFromView(t *Texture, view int, off int64) (err error)
 		// TODO: Handle depth/stencil formats.
 	})
 	for i := 0; i < nl; i++ {
-		s.pend = append(s.pend, pendingCopy{t, il + i, driver.LCopySrc})
+		s.pend = append(s.pend, pendingCopy{t, il + i, 0, driver.LCopySrc})
 	}
 	if t.param.Levels > 1 {
 		// TODO
@@ -1016,7 +1520,7 @@ func (s *texStgBuffer) stage(data []byte) (off int64, err error) {
 // It returns the number of bytes written.
 //
 // NOTE: Since texStgBuffer methods may flush
-// the command buffer and/or clear the bit vector,
+// the command buffer and/or reset the allocator,
 // unstage usually should be called right after a
 // copy-back command is committed and before
 // staging new copy commands.
@@ -1024,15 +1528,13 @@ func (s *texStgBuffer) unstage(off int64, dst []byte) (n int) {
 	if off >= s.buf.Cap() {
 		return
 	}
-	if off%texStgBlock != 0 {
+	if off%int64(texStgBlock) != 0 {
 		panic("texStgBuffer.unstage: misaligned off")
 	}
 	n = copy(dst, s.buf.Bytes()[off:])
 	ib := int(off) / texStgBlock
 	nb := (n + texStgBlock - 1) / texStgBlock
-	for i := 0; i < nb; i++ {
-		s.bv.Unset(ib + i)
-	}
+	s.alloc.Free(ib, nb)
 	return
 }
 
@@ -1047,35 +1549,73 @@ func (s *texStgBuffer) reserve(n int) (off int64, err error) {
 		panic("texStgBuffer.reserve: n <= 0")
 	}
 	n = (n + texStgBlock - 1) / texStgBlock
-	idx, ok := s.bv.SearchRange(n)
+	idx, ok := s.alloc.Alloc(n, 1)
 	if !ok {
 		if err = s.commit(); err != nil {
 			return
 		}
-		// TODO: Consider using idx 0 instead.
-		idx = s.bv.Len()
-		n := (n + texStgNBit - 1) / texStgNBit
-		s.bv.Grow(n)
-		// TODO: Make buffer cap bounds configurable.
-		n = n * texStgBlock * texStgNBit
-		if s.buf != nil {
-			n += int(s.buf.Cap())
-			s.buf.Destroy()
-		}
-		if s.buf, err = ctxt.GPU().NewBuffer(int64(n), true, 0); err != nil {
-			// TODO: Try again ignoring previous
-			// s.buf.Cap() value (if not 0).
-			s.bv = bitvec.V[uint32]{}
-			return
+		// Committing frees the whole buffer.
+		if idx, ok = s.alloc.Alloc(n, 1); !ok {
+			var cur int64
+			if s.buf != nil {
+				cur = s.buf.Cap()
+			}
+			policy := StagingGrowth()
+			ncap, ok := policy.grow(cur, int64(n)*int64(texStgBlock), int64(texStgBlock*texStgNBit))
+			if !ok {
+				err = ErrExhausted
+				return
+			}
+			if err = s.realloc(ncap); err != nil {
+				return
+			}
+			idx, _ = s.alloc.Alloc(n, 1)
 		}
 	}
-	for i := 0; i < n; i++ {
-		s.bv.Set(idx + i)
+	off = int64(idx) * int64(texStgBlock)
+	return
+}
+
+// realloc replaces s.buf with a new buffer of n bytes.
+// n must be a multiple of texStgBlock * texStgNBit.
+// s must not have pending copies.
+func (s *texStgBuffer) realloc(n int64) (err error) {
+	if s.buf != nil {
+		s.buf.Destroy()
+	}
+	s.alloc.Release()
+	if s.buf, err = ctxt.GPU().NewBuffer(n, true, driver.UCopySrc|driver.UCopyDst); err != nil {
+		return
 	}
-	off = int64(idx) * texStgBlock
+	s.alloc.Grow(int(n) / texStgBlock)
 	return
 }
 
+// resize commits s and then resizes its buffer to fit
+// within the bounds of p.
+func (s *texStgBuffer) resize(p *GrowthPolicy) error {
+	if s.wk == nil {
+		// Creation failed in initTexStg.
+		return nil
+	}
+	if err := s.commit(); err != nil {
+		return err
+	}
+	unit := int64(texStgBlock * texStgNBit)
+	var cur int64
+	if s.buf != nil {
+		cur = s.buf.Cap()
+	}
+	n := max(cur, (p.Initial+unit-1)/unit*unit, unit)
+	if p.Max > 0 {
+		n = min(n, max(p.Max/unit*unit, unit))
+	}
+	if n == cur {
+		return nil
+	}
+	return s.realloc(n)
+}
+
 // commit commits the copy commands for execution.
 // It blocks until execution completes.
 func (s *texStgBuffer) commit() (err error) {
@@ -1089,8 +1629,8 @@ func (s *texStgBuffer) commit() (err error) {
 		return
 	}
 	// TODO: May have to clear the
-	// bit vector unconditionally.
-	s.bv.Clear()
+	// allocator unconditionally.
+	s.alloc.Reset()
 	if err = wk.Work[0].End(); err != nil {
 		s.drainPending(true)
 		s.wk <- wk
@@ -1115,11 +1655,11 @@ func (s *texStgBuffer) commit() (err error) {
 func (s *texStgBuffer) drainPending(failed bool) {
 	if failed {
 		for _, x := range s.pend {
-			x.tex.unsetPending(x.view, driver.LUndefined)
+			x.tex.unsetPending(x.layer, x.level, driver.LUndefined)
 		}
 	} else {
 		for _, x := range s.pend {
-			x.tex.unsetPending(x.view, x.layout)
+			x.tex.unsetPending(x.layer, x.level, x.layout)
 		}
 	}
 	s.pend = s.pend[:0]
@@ -1130,7 +1670,7 @@ func (s *texStgBuffer) drainPending(failed bool) {
 func (s *texStgBuffer) free() {
 	if s.wk != nil {
 		wk := <-s.wk
-		wk.Work[0].Destroy()
+		cmdBufs.put(wk.Work[0])
 	}
 	if s.buf != nil {
 		s.buf.Destroy()
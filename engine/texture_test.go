@@ -23,7 +23,7 @@ func (tex *Texture) check(t *testing.T) {
 			t.Fatalf("Texture.views[%d].Image: differs from [0]\nhave %v\nwant %v", i, x, img)
 		}
 	}
-	usg := ^(driver.UCopySrc | driver.UCopyDst | driver.UShaderRead | driver.UShaderWrite | driver.UShaderSample | driver.URenderTarget)
+	usg := ^(driver.UCopySrc | driver.UCopyDst | driver.UShaderRead | driver.UShaderWrite | driver.UShaderSample | driver.URenderTarget | driver.UMutableFmt | driver.UTransient)
 	if tex.usage == 0 || tex.usage&usg != 0 {
 		t.Fatalf("Texture.usage: unexpected flag(s) set:\n0x%x", tex.usage&usg)
 	}
@@ -696,6 +696,48 @@ func (s *Sampler) check(t *testing.T) {
 	}
 }
 
+func TestTransient(t *testing.T) {
+	param := TexParam{
+		PixelFmt: driver.D16Unorm,
+		Dim3D:    driver.Dim3D{Width: 1280, Height: 720},
+		Layers:   1,
+		Levels:   1,
+		Samples:  4,
+	}
+	tex, err := NewTransient(&param)
+	if err != nil {
+		t.Fatalf("NewTransient failed:\n%#v", err)
+	}
+	defer tex.Free()
+	tex.check(t)
+	if tex.usage&driver.UTransient == 0 {
+		t.Fatal("NewTransient: Texture.usage should contain driver.UTransient")
+	}
+	if tex.usage&(driver.UCopySrc|driver.UCopyDst|driver.UShaderSample) != 0 {
+		t.Fatalf("NewTransient: Texture.usage: unexpected flag(s) set:\n0x%x", tex.usage)
+	}
+
+	param.PixelFmt = driver.RGBA8Unorm
+	param.Samples = 1
+	tex2, err := NewTransient(&param)
+	if err != nil {
+		t.Fatalf("NewTransient failed:\n%#v", err)
+	}
+	defer tex2.Free()
+	err = tex2.CopyToView(0, make([]byte, tex2.ViewSize(0)), true)
+	if err == nil || !strings.HasPrefix(err.Error(), texPrefix) {
+		t.Fatalf("Texture.CopyToView: unexpected error:\n%v", err)
+	}
+	_, err = tex2.CopyFromView(0, make([]byte, tex2.ViewSize(0)))
+	if err == nil || !strings.HasPrefix(err.Error(), texPrefix) {
+		t.Fatalf("Texture.CopyFromView: unexpected error:\n%v", err)
+	}
+
+	if _, err = NewTransient(nil); err == nil {
+		t.Fatal("NewTransient: unexpected success")
+	}
+}
+
 func TestSampler(t *testing.T) {
 	s, err := NewSampler(&SplrParam{
 		Min:      driver.FNearest,
@@ -974,20 +1016,20 @@ func TestTexStgBuffer(t *testing.T) {
 		if x := int(s.buf.Cap()); x != nbuf {
 			t.Fatalf("newTexStg: buf.Cap\nhave %d\nwant %d", x, nbuf)
 		}
-		if x := s.bv.Len(); x != nbv {
-			t.Fatalf("newTexStg: bv.Len\nhave %d\nwant %d", x, nbv)
+		if x := s.alloc.Len(); x != nbv {
+			t.Fatalf("newTexStg: alloc.Len\nhave %d\nwant %d", x, nbv)
 		}
 	}
 	checkFree := func() {
 		if s.buf != nil {
 			t.Fatalf("texStgBuffer.free: buf\nhave %v\nwant nil", s.buf)
 		}
-		if x := s.bv.Len(); x != 0 {
-			t.Fatalf("texStgBuffer.free: bv.Len\nhave %d\nwant 0", x)
+		if x := s.alloc.Len(); x != 0 {
+			t.Fatalf("texStgBuffer.free: alloc.Len\nhave %d\nwant 0", x)
 		}
 	}
 
-	const n = texStgBlock * texStgNBit
+	n := texStgBlock * texStgNBit
 
 	s, err = newTexStg(n)
 	check(n, texStgNBit)
@@ -1046,7 +1088,7 @@ func TestTexStgInit(t *testing.T) {
 			if wk.Err != nil {
 				t.Fatalf("texStg: (<-wk).Err\nhave %v\nwant nil", wk.Err)
 			}
-			if x.buf.Cap() != texStgBlock*texStgNBit {
+			if x.buf.Cap() != int64(texStgBlock*texStgNBit) {
 				t.Fatalf("texStg: buf.Cap:\nhave %d\nwant %d", x.buf.Cap(), texStgBlock*texStgNBit)
 			}
 			x.wk <- wk
@@ -1306,11 +1348,8 @@ func TestViewCopyPending(t *testing.T) {
 			}
 			texStg <- s
 		}
-		texStgCache = texStgCache[:0]
-		wk := <-texStgWk
-		wk.Work = wk.Work[:0]
-		wk.Err = nil
-		texStgWk <- wk
+		texStgSize.Store(0)
+		texStgAuto = nil
 		texStgMu.Unlock()
 
 		tex.Free()
@@ -1370,7 +1409,7 @@ func TestCommitTexStg(t *testing.T) {
 		for i := 0; i < n; i++ {
 			go func() {
 				time.Sleep(time.Nanosecond * 20)
-				errs <- commitTexStg()
+				errs <- commitTexStg().Wait()
 			}()
 		}
 		for i := n; i > 0; i-- {
@@ -1484,6 +1523,85 @@ func TestCommitTexStg(t *testing.T) {
 	tex1.Free()
 }
 
+func TestFlushUploads(t *testing.T) {
+	param := TexParam{
+		PixelFmt: driver.RGBA8Unorm,
+		Dim3D:    driver.Dim3D{Width: 64, Height: 64},
+		Layers:   1,
+		Levels:   1,
+		Samples:  1,
+	}
+	data := make([]byte, param.Size()*param.Width*param.Height)
+	var texs []*Texture
+	defer func() {
+		for _, x := range texs {
+			x.Free()
+		}
+	}()
+	for range 16 {
+		tex, err := New2D(&param)
+		if err != nil {
+			t.Fatalf("New2D failed:\n%v", err)
+		}
+		texs = append(texs, tex)
+		if err = tex.CopyToView(0, data, false); err != nil {
+			t.Fatalf("Texture.CopyToView failed:\n%v", err)
+		}
+	}
+	if n := texStgSize.Load(); n != int64(16*len(data)) {
+		t.Fatalf("texStgSize:\nhave %d\nwant %d", n, 16*len(data))
+	}
+	for _, x := range texs {
+		if x.layouts[0].Load() != invalLayout {
+			t.Fatal("Texture.CopyToView: should not have committed")
+		}
+	}
+	u := FlushUploads()
+	if err := u.Wait(); err != nil {
+		t.Fatalf("Upload.Wait failed:\n%v", err)
+	}
+	if !u.Done() {
+		t.Fatal("Upload.Done:\nhave false\nwant true")
+	}
+	if n := texStgSize.Load(); n != 0 {
+		t.Fatalf("texStgSize:\nhave %d\nwant 0", n)
+	}
+	for _, x := range texs {
+		if x.layouts[0].Load() == invalLayout {
+			t.Fatal("FlushUploads: should have set a valid layout")
+		}
+	}
+
+	// Staging enough data commits automatically.
+	// The outcome is reported by the next call to
+	// FlushUploads.
+	param.Width = 512
+	param.Height = 512
+	data = make([]byte, param.Size()*param.Width*param.Height)
+	for n := 0; int64(n) < streamBudget.Load(); n += len(data) {
+		if texStgSize.Load() != int64(n) {
+			t.Fatal("Texture.CopyToView: should not have committed")
+		}
+		tex, err := New2D(&param)
+		if err != nil {
+			t.Fatalf("New2D failed:\n%v", err)
+		}
+		texs = append(texs, tex)
+		if err = tex.CopyToView(0, data, false); err != nil {
+			t.Fatalf("Texture.CopyToView failed:\n%v", err)
+		}
+	}
+	if texStgSize.Load() != 0 || texStgAuto == nil {
+		t.Fatal("Texture.CopyToView: should have committed automatically")
+	}
+	if err := FlushUploads().Wait(); err != nil {
+		t.Fatalf("Upload.Wait failed:\n%v", err)
+	}
+	if texStgAuto != nil {
+		t.Fatal("FlushUploads: texStgAuto\nhave non-nil\nwant nil")
+	}
+}
+
 func TestTransition(t *testing.T) {
 	tex1, err := NewTarget(&TexParam{
 		PixelFmt: driver.RGBA8Unorm,
@@ -1708,3 +1826,260 @@ func TestSetLayoutPanic(t *testing.T) {
 	tex.setLayout(0, driver.LShaderRead)
 	t.Fatal("Texture.setLayout: expected to be unreachable")
 }
+
+func TestMergeTransitions(t *testing.T) {
+	const (
+		u = driver.LUndefined
+		r = driver.LShaderRead
+		c = driver.LCopyDst
+	)
+	for _, x := range [...]struct {
+		before      []driver.Layout
+		il, nl      int
+		lv, nlv     int
+		want        [][4]int // layer, layers, level, levels
+		wantLayouts []driver.Layout
+	}{
+		{[]driver.Layout{u}, 0, 1, 0, 1, [][4]int{{0, 1, 0, 1}}, []driver.Layout{u}},
+		{[]driver.Layout{u, u, u}, 2, 3, 0, 1, [][4]int{{2, 3, 0, 1}}, []driver.Layout{u}},
+		{[]driver.Layout{u, u, r, r}, 0, 1, 0, 4, [][4]int{{0, 1, 0, 2}, {0, 1, 2, 2}}, []driver.Layout{u, r}},
+		{
+			[]driver.Layout{u, r, u, r, c, c},
+			0, 3, 1, 2,
+			[][4]int{{0, 2, 1, 1}, {0, 2, 2, 1}, {2, 1, 1, 2}},
+			[]driver.Layout{u, r, c},
+		},
+		{
+			[]driver.Layout{r, u, r},
+			6, 3, 0, 1,
+			[][4]int{{6, 1, 0, 1}, {7, 1, 0, 1}, {8, 1, 0, 1}},
+			[]driver.Layout{r, u, r},
+		},
+	} {
+		xs := mergeTransitions(x.before, x.il, x.nl, x.lv, x.nlv, driver.Transition{LayoutAfter: c})
+		if len(xs) != len(x.want) {
+			t.Fatalf("mergeTransitions: len:\nhave %d\nwant %d", len(xs), len(x.want))
+		}
+		for i := range xs {
+			have := [4]int{xs[i].Layer, xs[i].Layers, xs[i].Level, xs[i].Levels}
+			if have != x.want[i] {
+				t.Fatalf("mergeTransitions: [%d] range:\nhave %v\nwant %v", i, have, x.want[i])
+			}
+			if xs[i].LayoutBefore != x.wantLayouts[i] || xs[i].LayoutAfter != c {
+				t.Fatalf("mergeTransitions: [%d] layouts:\nhave %d/%d\nwant %d/%d", i, xs[i].LayoutBefore, xs[i].LayoutAfter, x.wantLayouts[i], c)
+			}
+		}
+	}
+}
+
+func TestCubeTarget(t *testing.T) {
+	param := TexParam{
+		PixelFmt: driver.RGBA16Float,
+		Dim3D:    driver.Dim3D{Width: 128, Height: 128},
+		Layers:   6,
+		Levels:   ComputeLevels(driver.Dim3D{Width: 128, Height: 128}),
+		Samples:  1,
+	}
+	tex, err := NewCubeTarget(&param)
+	if err != nil {
+		t.Fatalf("NewCubeTarget failed:\n%#v", err)
+	}
+	defer tex.Free()
+	tex.check(t)
+	if n := tex.ViewLayers(0); n != 6 {
+		t.Fatalf("NewCubeTarget: Texture.ViewLayers\nhave %d\nwant 6", n)
+	}
+	if tex.usage&(driver.URenderTarget|driver.UShaderSample) != driver.URenderTarget|driver.UShaderSample {
+		t.Fatalf("NewCubeTarget: Texture.usage: missing flag(s):\n0x%x", tex.usage)
+	}
+
+	param.Samples = 4
+	if _, err := NewCubeTarget(&param); err == nil {
+		t.Fatal("NewCubeTarget: unexpected nil error")
+	}
+}
+
+func Test3D(t *testing.T) {
+	param := TexParam{
+		PixelFmt: driver.RGBA16Float,
+		Dim3D:    driver.Dim3D{Width: 32, Height: 32, Depth: 32},
+		Layers:   1,
+		Levels:   1,
+		Samples:  1,
+	}
+	tex, err := New3D(&param)
+	if err != nil {
+		t.Fatalf("New3D failed:\n%#v", err)
+	}
+	defer tex.Free()
+	tex.check(t)
+	if d := tex.Depth(); d != 32 {
+		t.Fatalf("Texture.Depth:\nhave %d\nwant 32", d)
+	}
+	if n, want := tex.ViewSize(0), 32*32*32*driver.RGBA16Float.Size(); n != want {
+		t.Fatalf("Texture.ViewSize:\nhave %d\nwant %d", n, want)
+	}
+	data := make([]byte, tex.ViewSize(0))
+	for i := range data {
+		data[i] = byte(i)
+	}
+	if err := tex.CopyToView(0, data, true); err != nil {
+		t.Fatalf("Texture.CopyToView failed:\n%#v", err)
+	}
+	dst := make([]byte, len(data))
+	if n, err := tex.CopyFromView(0, dst); err != nil || n != len(dst) {
+		t.Fatalf("Texture.CopyFromView:\nhave %d, %#v\nwant %d, nil", n, err, len(dst))
+	}
+	if string(dst) != string(data) {
+		t.Fatal("Texture.CopyFromView: data differs")
+	}
+
+	for _, p := range [...]TexParam{
+		{PixelFmt: driver.RGBA8Unorm, Dim3D: driver.Dim3D{Width: 16, Height: 16}, Layers: 1, Levels: 1, Samples: 1},
+		{PixelFmt: driver.RGBA8Unorm, Dim3D: driver.Dim3D{Width: 16, Height: 16, Depth: 16}, Layers: 2, Levels: 1, Samples: 1},
+		{PixelFmt: driver.RGBA8Unorm, Dim3D: driver.Dim3D{Width: 16, Height: 16, Depth: 16}, Layers: 1, Levels: 6, Samples: 1},
+		{PixelFmt: driver.RGBA8Unorm, Dim3D: driver.Dim3D{Width: 16, Height: 16, Depth: 16}, Layers: 1, Levels: 1, Samples: 4},
+	} {
+		_, err := New3D(&p)
+		switch {
+		case err == nil:
+			t.Fatal("New3D: unexpected success")
+		case !strings.HasPrefix(err.Error(), texPrefix):
+			t.Fatalf("New3D: unexpected error:\n%v", err)
+		}
+	}
+}
+
+func TestStageLayout(t *testing.T) {
+	tex := Texture{param: TexParam{
+		PixelFmt: driver.RGBA8Unorm,
+		Dim3D:    driver.Dim3D{Width: 100, Height: 40},
+		Layers:   2,
+		Levels:   7,
+		Samples:  1,
+	}}
+	lvs, n := tex.stageLayout(0, 7, 2)
+	var end int64
+	for i, lv := range lvs {
+		w, h, _ := tex.levelDim(i)
+		if lv.off%texStgOffAlign != 0 || lv.off < end {
+			t.Fatalf("Texture.stageLayout: [%d].off\nhave %d\nwant multiple of %d, >= %d", i, lv.off, texStgOffAlign, end)
+		}
+		if lv.rowStrd < w || lv.rowStrd*4%texStgRowAlign != 0 {
+			t.Fatalf("Texture.stageLayout: [%d].rowStrd\nhave %d\nwant >= %d, aligned", i, lv.rowStrd, w)
+		}
+		end = lv.off + int64(lv.rowStrd*4*h*2)
+	}
+	if n != end {
+		t.Fatalf("Texture.stageLayout: n\nhave %d\nwant %d", n, end)
+	}
+	if w, h, d := tex.levelDim(6); w != 1 || h != 1 || d != 1 {
+		t.Fatalf("Texture.levelDim(6):\nhave %d, %d, %d\nwant 1, 1, 1", w, h, d)
+	}
+}
+
+func TestCopyLevelsToView(t *testing.T) {
+	param := TexParam{
+		PixelFmt: driver.RGBA8Unorm,
+		Dim3D:    driver.Dim3D{Width: 300, Height: 200},
+		Layers:   3,
+		Levels:   ComputeLevels(driver.Dim3D{Width: 300, Height: 200}),
+		Samples:  1,
+	}
+	tex, err := New2D(&param)
+	if err != nil {
+		t.Fatalf("New2D failed:\n%v", err)
+	}
+	defer tex.Free()
+
+	view := param.Layers
+	n := tex.ViewLevelsSize(view, 0, param.Levels)
+	want := 0
+	for i := range param.Levels {
+		want += 4 * max(1, 300>>i) * max(1, 200>>i) * param.Layers
+	}
+	if n != want {
+		t.Fatalf("Texture.ViewLevelsSize:\nhave %d\nwant %d", n, want)
+	}
+	if x := tex.ViewLevelsSize(view, 0, 1); x != tex.ViewSize(view) {
+		t.Fatalf("Texture.ViewLevelsSize: first level\nhave %d\nwant %d", x, tex.ViewSize(view))
+	}
+	if x := tex.ViewLevelsSize(view, 1, param.Levels); x != 0 {
+		t.Fatalf("Texture.ViewLevelsSize: invalid range\nhave %d\nwant 0", x)
+	}
+
+	data := make([]byte, n)
+	if err := tex.CopyLevelsToView(view, 0, param.Levels, data[:n-1], true); err == nil {
+		t.Fatal("Texture.CopyLevelsToView: short data\nhave nil\nwant non-nil")
+	}
+	if err := tex.CopyLevelsToView(view, 2, param.Levels, data, true); err == nil {
+		t.Fatal("Texture.CopyLevelsToView: invalid range\nhave nil\nwant non-nil")
+	}
+	if err := tex.CopyLevelsToView(view, 0, param.Levels, data, false); err != nil {
+		t.Fatalf("Texture.CopyLevelsToView:\nhave %v\nwant nil", err)
+	}
+	for i := range param.Layers {
+		for j := range param.Levels {
+			if x := tex.layouts[i*param.Levels+j].Load(); x != invalLayout {
+				t.Fatalf("Texture.CopyLevelsToView: layouts[%d][%d]\nhave %d\nwant %d", i, j, x, invalLayout)
+			}
+		}
+	}
+	if err := FlushUploads().Wait(); err != nil {
+		t.Fatalf("FlushUploads: Wait\nhave %v\nwant nil", err)
+	}
+	for i := range param.Layers {
+		for j := range param.Levels {
+			if x := driver.Layout(tex.layouts[i*param.Levels+j].Load()); x != driver.LCopyDst {
+				t.Fatalf("Texture.CopyLevelsToView: layouts[%d][%d]\nhave %d\nwant %d", i, j, x, driver.LCopyDst)
+			}
+		}
+	}
+
+	// A single layer and a subrange of levels.
+	k := tex.ViewLevelsSize(1, 3, 2)
+	if err := tex.CopyLevelsToView(1, 3, 2, data[:k], true); err != nil {
+		t.Fatalf("Texture.CopyLevelsToView:\nhave %v\nwant nil", err)
+	}
+}
+
+// BenchmarkCopyLevelsToView uploads the full mip chain
+// of a 4K texture either in a single submission or
+// with one submission per level.
+func BenchmarkCopyLevelsToView(b *testing.B) {
+	param := TexParam{
+		PixelFmt: driver.RGBA8Unorm,
+		Dim3D:    driver.Dim3D{Width: 4096, Height: 4096},
+		Layers:   1,
+		Levels:   12,
+		Samples:  1,
+	}
+	tex, err := New2D(&param)
+	if err != nil {
+		b.Fatalf("New2D failed:\n%v", err)
+	}
+	defer tex.Free()
+	data := make([]byte, tex.ViewLevelsSize(0, 0, param.Levels))
+
+	b.Run("Batched", func(b *testing.B) {
+		b.SetBytes(int64(len(data)))
+		for range b.N {
+			if err := tex.CopyLevelsToView(0, 0, param.Levels, data, true); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+	b.Run("PerLevel", func(b *testing.B) {
+		b.SetBytes(int64(len(data)))
+		for range b.N {
+			p := data
+			for i := range param.Levels {
+				n := tex.ViewLevelsSize(0, i, 1)
+				if err := tex.CopyLevelsToView(0, i, 1, p[:n], true); err != nil {
+					b.Fatal(err)
+				}
+				p = p[n:]
+			}
+		}
+	})
+}
@@ -0,0 +1,190 @@
+// Copyright 2024 Gustavo C. Viegas. All rights reserved.
+
+package engine
+
+import (
+	"sync"
+
+	"gviegas/neo3/driver"
+)
+
+// ViewParam describes parameters of a TextureView.
+type ViewParam struct {
+	// Component mapping.
+	Swizzle driver.Swizzle
+	// Format of the view. driver.FInvalid means
+	// the Texture's format. Otherwise, it must be
+	// the Texture's format or its sRGB/UNORM
+	// counterpart (e.g., driver.RGBA8Unorm for a
+	// driver.RGBA8SRGB Texture), in which case the
+	// Texture must have been created with
+	// TexParam.MutableFmt set.
+	PixelFmt driver.PixelFmt
+	// Range of layers. If Layers is 0, the view
+	// contains every layer starting at Layer.
+	Layer  int
+	Layers int
+	// Range of mip levels. If Levels is 0, the view
+	// contains every level starting at Level.
+	Level  int
+	Levels int
+	// Cube indicates whether the view should be
+	// a cube (or cube array) view. The layer count
+	// must be a multiple of 6.
+	Cube bool
+}
+
+// TextureView is a view of a Texture for shader
+// access, with custom component mapping and
+// subresource range.
+type TextureView struct {
+	tex   *Texture
+	param ViewParam
+}
+
+// viewCache holds the driver.ImageViews created
+// on behalf of TextureViews.
+type viewCache struct {
+	mu sync.Mutex
+	m  map[ViewParam]driver.ImageView
+}
+
+// free destroys every cached view.
+func (c *viewCache) free() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, v := range c.m {
+		v.Destroy()
+	}
+	c.m = nil
+}
+
+// texRes is the set of driver resources held by
+// a Texture.
+type texRes struct {
+	views []driver.ImageView
+	cache *viewCache
+}
+
+// free destroys the resources.
+func (r texRes) free() {
+	r.cache.free()
+	freeViews(r.views)
+}
+
+// NewView creates a TextureView of t.
+// The driver view is created when first needed,
+// and is shared by every TextureView of t that
+// has the same parameters. It is destroyed when
+// t is freed.
+func (t *Texture) NewView(param *ViewParam) (v *TextureView, err error) {
+	var reason string
+	switch p := t.resolveView(param); {
+	case param == nil:
+		reason = "nil view param"
+	case t.param.Samples != 1:
+		reason = "cannot view MS texture"
+	case p.Layer < 0, p.Layers < 1, p.Layer+p.Layers > t.param.Layers:
+		reason = "view layer range out of bounds"
+	case p.Level < 0, p.Levels < 1, p.Level+p.Levels > t.param.Levels:
+		reason = "view level range out of bounds"
+	case p.Cube && p.Layers%6 != 0:
+		reason = "cube view layer count not multiple of 6"
+	case p.Cube && t.param.Width != t.param.Height:
+		reason = "cube view of non-square texture"
+	case p.Cube && t.param.Depth > 0:
+		reason = "cube view of 3D texture"
+	case p.PixelFmt != t.param.PixelFmt && !t.canViewAs(p.PixelFmt):
+		reason = "view format not compatible"
+	default:
+		for _, c := range p.Swizzle {
+			if c < driver.CIdentity || c > driver.CA {
+				reason = "undefined swizzle component"
+				goto invalidParam
+			}
+		}
+		v = &TextureView{t, p}
+		return
+	}
+invalidParam:
+	err = newTexErr(reason)
+	return
+}
+
+// resolveView replaces the zero counts in param
+// with the remaining counts of t.
+func (t *Texture) resolveView(param *ViewParam) (p ViewParam) {
+	if param == nil {
+		return
+	}
+	p = *param
+	if p.Layers == 0 {
+		p.Layers = t.param.Layers - p.Layer
+	}
+	if p.Levels == 0 {
+		p.Levels = t.param.Levels - p.Level
+	}
+	if p.PixelFmt == driver.FInvalid {
+		p.PixelFmt = t.param.PixelFmt
+	}
+	return
+}
+
+// canViewAs returns whether t can be viewed with
+// format pf (other than its own).
+func (t *Texture) canViewAs(pf driver.PixelFmt) bool {
+	if t.usage&driver.UMutableFmt == 0 {
+		return false
+	}
+	x, ok := srgbPair(t.param.PixelFmt)
+	return ok && x == pf
+}
+
+// Texture returns the Texture that v views.
+func (v *TextureView) Texture() *Texture { return v.tex }
+
+// Param returns the parameters of v.
+// Zero counts and driver.FInvalid are replaced by
+// the actual values.
+func (v *TextureView) Param() ViewParam { return v.param }
+
+// imageView returns the driver.ImageView of v,
+// creating it if necessary.
+func (v *TextureView) imageView() (driver.ImageView, error) {
+	c := v.tex.cache
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if iv, ok := c.m[v.param]; ok {
+		return iv, nil
+	}
+	var typ driver.ViewType
+	switch {
+	case v.param.Cube && v.param.Layers > 6:
+		typ = driver.IViewCubeArray
+	case v.param.Cube:
+		typ = driver.IViewCube
+	case v.tex.param.Depth > 0:
+		typ = driver.IView3D
+	case v.param.Layers > 1:
+		typ = driver.IView2DArray
+	default:
+		typ = driver.IView2D
+	}
+	iv, err := v.tex.views[0].Image().NewViewParam(&driver.ViewParam{
+		Type:     typ,
+		Layer:    v.param.Layer,
+		Layers:   v.param.Layers,
+		Level:    v.param.Level,
+		Levels:   v.param.Levels,
+		Swizzle:  v.param.Swizzle,
+		PixelFmt: v.param.PixelFmt,
+	})
+	if err != nil {
+		return nil, err
+	}
+	if c.m == nil {
+		c.m = make(map[ViewParam]driver.ImageView)
+	}
+	c.m[v.param] = iv
+	return iv, nil
+}
@@ -0,0 +1,112 @@
+// Copyright 2024 Gustavo C. Viegas. All rights reserved.
+
+package engine
+
+import (
+	"testing"
+
+	"gviegas/neo3/driver"
+	"gviegas/neo3/engine/internal/ctxt"
+)
+
+func TestTextureView(t *testing.T) {
+	tex, err := New2D(&TexParam{
+		PixelFmt: driver.RGBA8Unorm,
+		Dim3D:    driver.Dim3D{Width: 256, Height: 256},
+		Layers:   6,
+		Levels:   4,
+		Samples:  1,
+	})
+	if err != nil {
+		t.Fatalf("New2D failed:\n%v", err)
+	}
+	defer tex.Free()
+
+	for _, x := range [...]ViewParam{
+		{Layer: 6},
+		{Layer: 2, Layers: 5},
+		{Level: 4},
+		{Level: 1, Levels: 4},
+		{Layers: 4, Cube: true},
+		{Swizzle: driver.Swizzle{driver.CA + 1}},
+	} {
+		if _, err := tex.NewView(&x); err == nil {
+			t.Fatalf("Texture.NewView(%v): unexpected success", x)
+		}
+	}
+	if _, err := tex.NewView(nil); err == nil {
+		t.Fatal("Texture.NewView(nil): unexpected success")
+	}
+
+	param := ViewParam{
+		Swizzle: driver.Swizzle{driver.CR, driver.CR, driver.CR, driver.COne},
+		Layer:   1,
+		Level:   2,
+	}
+	v1, err := tex.NewView(&param)
+	if err != nil {
+		t.Fatalf("Texture.NewView failed:\n%v", err)
+	}
+	if p := v1.Param(); p.Layers != 5 || p.Levels != 2 {
+		t.Fatalf("TextureView.Param: counts:\nhave %d, %d\nwant 5, 2", p.Layers, p.Levels)
+	}
+	v2, _ := tex.NewView(&ViewParam{Swizzle: param.Swizzle, Layer: 1, Layers: 5, Level: 2, Levels: 2})
+	iv1, err := v1.imageView()
+	if err != nil {
+		t.Fatalf("TextureView.imageView failed:\n%v", err)
+	}
+	iv2, err := v2.imageView()
+	if err != nil {
+		t.Fatalf("TextureView.imageView failed:\n%v", err)
+	}
+	if iv1 != iv2 {
+		t.Fatal("TextureView.imageView: views should be shared")
+	}
+	if n := len(tex.cache.m); n != 1 {
+		t.Fatalf("Texture.cache: len:\nhave %d\nwant 1", n)
+	}
+
+	cube, _ := tex.NewView(&ViewParam{Cube: true})
+	if _, err := cube.imageView(); err != nil {
+		t.Fatalf("TextureView.imageView failed:\n%v", err)
+	}
+	if n := len(tex.cache.m); n != 2 {
+		t.Fatalf("Texture.cache: len:\nhave %d\nwant 2", n)
+	}
+
+	if _, err := tex.NewView(&ViewParam{PixelFmt: driver.RG8Unorm}); err == nil {
+		t.Fatal("Texture.NewView: unexpected success with incompatible format")
+	}
+	// Mutable formats are opt-in.
+	if tex.usage&driver.UMutableFmt != 0 {
+		t.Fatal("New2D: Texture.usage should not contain driver.UMutableFmt")
+	}
+	if _, err := tex.NewView(&ViewParam{PixelFmt: driver.RGBA8SRGB}); err == nil {
+		t.Fatal("Texture.NewView: unexpected success with no TexParam.MutableFmt")
+	}
+	mut, err := New2D(&TexParam{
+		PixelFmt:   driver.RGBA8Unorm,
+		Dim3D:      driver.Dim3D{Width: 16, Height: 16},
+		Layers:     1,
+		Levels:     1,
+		Samples:    1,
+		MutableFmt: true,
+	})
+	if err != nil {
+		t.Fatalf("New2D failed:\n%v", err)
+	}
+	defer mut.Free()
+	srgb, err := mut.NewView(&ViewParam{PixelFmt: driver.RGBA8SRGB})
+	if !ctxt.Features().MutableFormat {
+		if err == nil {
+			t.Fatal("Texture.NewView: unexpected success with no mutable format support")
+		}
+		return
+	}
+	if err != nil {
+		t.Fatalf("Texture.NewView failed:\n%v", err)
+	}
+	if _, err := srgb.imageView(); err != nil {
+		t.Fatalf("TextureView.imageView failed:\n%v", err)
+	}
+}
@@ -0,0 +1,161 @@
+// Copyright 2024 Gustavo C. Viegas. All rights reserved.
+
+package engine
+
+import (
+	"encoding/json"
+	"io"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Trace categories.
+// Each identifies an engine system.
+const (
+	traceUpdate = "update"
+	traceCull   = "cull"
+	traceRecord = "record"
+	traceSubmit = "submit"
+	traceGPU    = "gpu"
+)
+
+// Trace tracks, shown as threads in the viewer.
+const (
+	traceCPUTrack = 1
+	traceGPUTrack = 2
+)
+
+// tracer records spans while tracing is enabled.
+var tracer struct {
+	on atomic.Bool
+	sync.Mutex
+	start  time.Time
+	events []traceEvent
+}
+
+// traceEvent is an event in the Chrome tracing format.
+// Times are in microseconds since the start of the
+// trace.
+type traceEvent struct {
+	Name string         `json:"name"`
+	Cat  string         `json:"cat,omitempty"`
+	Ph   string         `json:"ph"`
+	Ts   float64        `json:"ts"`
+	Dur  float64        `json:"dur"`
+	Pid  int            `json:"pid"`
+	Tid  int            `json:"tid"`
+	Args map[string]any `json:"args,omitempty"`
+}
+
+// StartTrace starts recording a timeline of engine
+// activity.
+// CPU spans cover updates, culling, command recording
+// and submission. GPU spans cover the execution of
+// committed work, measured from commit to observed
+// completion, since per-pass timestamps are not
+// available.
+// Any previous trace is discarded.
+// While tracing is disabled, spans cost no more than
+// an atomic load.
+func StartTrace() {
+	tracer.Lock()
+	tracer.start = time.Now()
+	tracer.events = tracer.events[:0]
+	tracer.Unlock()
+	tracer.on.Store(true)
+}
+
+// StopTrace stops recording.
+// The recorded trace is kept until the next call to
+// StartTrace.
+func StopTrace() { tracer.on.Store(false) }
+
+// Tracing returns whether a trace is being recorded.
+func Tracing() bool { return tracer.on.Load() }
+
+// WriteTrace writes the recorded trace to w, in the
+// JSON format of chrome://tracing (also understood
+// by Perfetto).
+// It can be called while tracing is enabled, in which
+// case spans that have not ended are not included.
+func WriteTrace(w io.Writer) error {
+	tracer.Lock()
+	evs := make([]traceEvent, 0, len(tracer.events)+2)
+	for _, x := range [...]struct {
+		tid  int
+		name string
+	}{{traceCPUTrack, "CPU"}, {traceGPUTrack, "GPU"}} {
+		evs = append(evs, traceEvent{
+			Name: "thread_name",
+			Ph:   "M",
+			Pid:  1,
+			Tid:  x.tid,
+			Args: map[string]any{"name": x.name},
+		})
+	}
+	evs = append(evs, tracer.events...)
+	tracer.Unlock()
+	return json.NewEncoder(w).Encode(struct {
+		TraceEvents     []traceEvent `json:"traceEvents"`
+		DisplayTimeUnit string       `json:"displayTimeUnit"`
+	}{evs, "ms"})
+}
+
+// traceSpan is a span being recorded.
+// The zero value is a no-op span.
+type traceSpan struct {
+	cat, name string
+	start     time.Time
+}
+
+// traceBegin starts a CPU span.
+// It returns a no-op span if tracing is disabled.
+// The usual pattern is:
+//
+//	defer traceBegin(traceUpdate, "decals").end()
+func traceBegin(cat, name string) traceSpan {
+	if !tracer.on.Load() {
+		return traceSpan{}
+	}
+	return traceSpan{cat, name, time.Now()}
+}
+
+// end ends s, recording it on the CPU track.
+func (s traceSpan) end() {
+	if s.cat == "" {
+		return
+	}
+	traceAdd(s.cat, s.name, traceCPUTrack, s.start, time.Now())
+}
+
+// endGPU ends s, recording it on the GPU track.
+// s must have been started when the work was
+// committed.
+func (s traceSpan) endGPU() {
+	if s.cat == "" {
+		return
+	}
+	traceAdd(traceGPU, s.name, traceGPUTrack, s.start, time.Now())
+}
+
+// traceAdd records a complete event.
+// Events that started before the current trace are
+// discarded.
+func traceAdd(cat, name string, tid int, start, end time.Time) {
+	tracer.Lock()
+	defer tracer.Unlock()
+	if !tracer.on.Load() || start.Before(tracer.start) {
+		return
+	}
+	us := func(d time.Duration) float64 { return float64(d.Nanoseconds()) / 1e3 }
+	tracer.events = append(tracer.events, traceEvent{
+		Name: name,
+		Cat:  cat,
+		Ph:   "X",
+		Ts:   us(start.Sub(tracer.start)),
+		Dur:  us(end.Sub(start)),
+		Pid:  1,
+		Tid:  tid,
+	})
+}
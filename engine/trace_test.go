@@ -0,0 +1,78 @@
+// Copyright 2024 Gustavo C. Viegas. All rights reserved.
+
+package engine
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+func TestTrace(t *testing.T) {
+	if Tracing() {
+		t.Fatal("Tracing: should be disabled by default")
+	}
+	if s := traceBegin(traceUpdate, "disabled"); s != (traceSpan{}) {
+		t.Fatalf("traceBegin: disabled\nhave %v\nwant %v", s, traceSpan{})
+	}
+
+	StartTrace()
+	if !Tracing() {
+		t.Fatal("StartTrace: should be tracing")
+	}
+	s := traceBegin(traceCull, "viewports")
+	time.Sleep(time.Millisecond)
+	s.end()
+	g := traceBegin(traceSubmit, "buffer copy")
+	g.endGPU()
+	stale := traceBegin(traceUpdate, "stale")
+	StopTrace()
+	traceBegin(traceRecord, "late").end()
+
+	var buf bytes.Buffer
+	if err := WriteTrace(&buf); err != nil {
+		t.Fatalf("WriteTrace failed:\n%v", err)
+	}
+	var out struct {
+		TraceEvents []traceEvent `json:"traceEvents"`
+	}
+	if err := json.Unmarshal(buf.Bytes(), &out); err != nil {
+		t.Fatalf("WriteTrace: invalid JSON:\n%v", err)
+	}
+	var spans []traceEvent
+	for _, e := range out.TraceEvents {
+		switch e.Ph {
+		case "M":
+			if e.Name != "thread_name" {
+				t.Fatalf("WriteTrace: metadata event\nhave %q\nwant \"thread_name\"", e.Name)
+			}
+		case "X":
+			spans = append(spans, e)
+		default:
+			t.Fatalf("WriteTrace: unexpected phase %q", e.Ph)
+		}
+	}
+	if len(spans) != 2 {
+		t.Fatalf("WriteTrace: spans\nhave %v\nwant 2 spans", spans)
+	}
+	if x := spans[0]; x.Name != "viewports" || x.Cat != traceCull || x.Tid != traceCPUTrack || x.Dur < 1000 {
+		t.Fatalf("WriteTrace: CPU span\nhave %+v", x)
+	}
+	if x := spans[1]; x.Name != "buffer copy" || x.Cat != traceGPU || x.Tid != traceGPUTrack || x.Ts < spans[0].Ts {
+		t.Fatalf("WriteTrace: GPU span\nhave %+v", x)
+	}
+
+	// Restarting discards the previous trace,
+	// including spans that began before it.
+	StartTrace()
+	defer StopTrace()
+	stale.end()
+	buf.Reset()
+	if err := WriteTrace(&buf); err != nil {
+		t.Fatalf("WriteTrace failed:\n%v", err)
+	}
+	if bytes.Contains(buf.Bytes(), []byte("stale")) || bytes.Contains(buf.Bytes(), []byte("viewports")) {
+		t.Fatalf("StartTrace: previous spans should have been discarded\n%s", buf.Bytes())
+	}
+}
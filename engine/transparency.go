@@ -0,0 +1,335 @@
+// Copyright 2024 Gustavo C. Viegas. All rights reserved.
+
+package engine
+
+import (
+	"cmp"
+	"slices"
+
+	"gviegas/neo3/driver"
+	"gviegas/neo3/engine/internal/shader"
+	"gviegas/neo3/linear"
+)
+
+// Transparency modes.
+// They determine how primitives whose material uses
+// AlphaBlend are rendered. Such primitives are always
+// drawn after the ones using AlphaOpaque/AlphaMask.
+const (
+	// Blended primitives are sorted back-to-front
+	// by the view depth of the drawable that
+	// contains them.
+	TranspSortObject = iota
+	// Blended primitives are sorted back-to-front
+	// by their own view depth. This produces better
+	// results for meshes that have several blended
+	// primitives, at a higher cost.
+	TranspSortPrimitive
+	// Weighted, blended order-independent
+	// transparency.
+	// Blended primitives are not sorted. Instead,
+	// they are accumulated into separate render
+	// targets which are then composited over the
+	// opaque image. This approximates the correct
+	// result regardless of draw order.
+	TranspOIT
+)
+
+// OIT render target formats.
+const (
+	oitAccumFmt  = driver.RGBA16Float
+	oitRevealFmt = driver.R16Float
+)
+
+// Names of the OIT passes.
+const (
+	oitAccumPass     = "oit.accum"
+	oitCompositePass = "oit.composite"
+)
+
+// oitBlend is the blend state of pipelines that render
+// into the OIT targets (i.e., blended materials and
+// particles when using TranspOIT).
+// The accumulation target sums weighted, premultiplied
+// colors, and the revealage target is multiplied by
+// one minus each fragment's alpha.
+var oitBlend = driver.BlendState{
+	IndependentBlend: true,
+	Color: []driver.ColorBlend{
+		{
+			Blend:     true,
+			WriteMask: driver.CAll,
+			SrcFacRGB: driver.BOne,
+			DstFacRGB: driver.BOne,
+			OpRGB:     driver.BAdd,
+			SrcFacA:   driver.BOne,
+			DstFacA:   driver.BOne,
+			OpA:       driver.BAdd,
+		},
+		{
+			Blend:     true,
+			WriteMask: driver.CRed,
+			SrcFacRGB: driver.BZero,
+			DstFacRGB: driver.BInvSrcColor,
+			OpRGB:     driver.BAdd,
+			SrcFacA:   driver.BZero,
+			DstFacA:   driver.BInvSrcAlpha,
+			OpA:       driver.BAdd,
+		},
+	},
+}
+
+// oitClear contains the clear values of the OIT
+// targets. Revealage starts at one (i.e., nothing
+// covers the opaque image).
+var oitClear = [2]driver.ClearColor{
+	driver.ClearFloat32(0, 0, 0, 0),
+	driver.ClearFloat32(1, 0, 0, 0),
+}
+
+// oitCompositeBlend is the blend state of the pipeline
+// that composites the OIT targets over the opaque
+// image. The composite shader outputs the average
+// color in RGB and the revealage in alpha.
+var oitCompositeBlend = driver.BlendState{
+	Color: []driver.ColorBlend{
+		{
+			Blend:     true,
+			WriteMask: driver.CAll,
+			SrcFacRGB: driver.BInvSrcAlpha,
+			DstFacRGB: driver.BSrcAlpha,
+			OpRGB:     driver.BAdd,
+			SrcFacA:   driver.BZero,
+			DstFacA:   driver.BOne,
+			OpA:       driver.BAdd,
+		},
+	},
+}
+
+// SetTransparency sets the transparency mode of r.
+// The default mode is TranspSortObject.
+// TranspOIT requires two additional render targets,
+// which are created by this method.
+func (r *Renderer) SetTransparency(mode int) error {
+	switch mode {
+	case TranspSortObject, TranspSortPrimitive:
+		r.freeOIT()
+	case TranspOIT:
+		if r.oit[0] == nil {
+			if err := r.initOIT(); err != nil {
+				return err
+			}
+		}
+	default:
+		return newRendErr("undefined transparency mode")
+	}
+	r.transp = mode
+	return nil
+}
+
+// Transparency returns the transparency mode of r.
+func (r *Renderer) Transparency() int { return r.transp }
+
+// initOIT creates the OIT render targets and adds the
+// OIT passes to r's frame graph.
+// The targets' size matches r.hdr's, and their
+// sample count is r.MSAA().
+// They are sampled by the composite pass, so they
+// cannot be transient.
+func (r *Renderer) initOIT() (err error) {
+	for i, pf := range [2]driver.PixelFmt{oitAccumFmt, oitRevealFmt} {
+		r.oit[i], err = r.newOITTarget(pf, r.MSAA())
+		if err != nil {
+			r.freeOIT()
+			return
+		}
+	}
+	// Blended primitives are drawn into the OIT
+	// targets, which are then resolved into the
+	// opaque image.
+	r.graph.add(&passNode{
+		name:   oitAccumPass,
+		stage:  stageTransparency,
+		writes: r.oit[:],
+	})
+	r.graph.add(&passNode{
+		name:   oitCompositePass,
+		stage:  stageTransparency,
+		reads:  r.oit[:],
+		writes: r.msaaWrites([]*Texture{r.hdr}, stageTransparency),
+		msaa:   true,
+	})
+	return
+}
+
+// newOITTarget creates an OIT render target.
+func (r *Renderer) newOITTarget(pf driver.PixelFmt, samples int) (*Texture, error) {
+	return NewTarget(&TexParam{
+		PixelFmt: pf,
+		Dim3D:    driver.Dim3D{Width: r.hdr.Width(), Height: r.hdr.Height()},
+		Layers:   1,
+		Levels:   1,
+		Samples:  samples,
+	})
+}
+
+// resampleOIT recreates the OIT render targets with
+// the given sample count, replacing them in r's frame
+// graph. r.oit must not be empty.
+// If it fails, the current targets are kept.
+func (r *Renderer) resampleOIT(samples int) error {
+	var oit [len(r.oit)]*Texture
+	for i, pf := range [2]driver.PixelFmt{oitAccumFmt, oitRevealFmt} {
+		var err error
+		if oit[i], err = r.newOITTarget(pf, samples); err != nil {
+			for _, t := range oit[:i] {
+				t.Free()
+			}
+			return err
+		}
+	}
+	// The accumulation pass writes to r.oit[:],
+	// so replacing may update r.oit itself.
+	for i, old := range r.oit {
+		r.graph.replace(old, oit[i])
+		old.Free()
+		r.oit[i] = oit[i]
+	}
+	return nil
+}
+
+// oitTargets returns the color targets of the OIT
+// accumulation pass.
+func (r *Renderer) oitTargets() [2]driver.ColorTarget {
+	var ct [2]driver.ColorTarget
+	for i := range ct {
+		ct[i] = driver.ColorTarget{
+			Color: r.oit[i].views[0],
+			Load:  driver.LClear,
+			Store: driver.SStore,
+			Clear: oitClear[i],
+		}
+	}
+	return ct
+}
+
+// freeOIT removes the OIT passes from r's frame graph
+// and frees the OIT render targets.
+func (r *Renderer) freeOIT() {
+	r.graph.remove(oitAccumPass)
+	r.graph.remove(oitCompositePass)
+	for i := range r.oit {
+		if r.oit[i] != nil {
+			r.oit[i].Free()
+			r.oit[i] = nil
+		}
+	}
+}
+
+// drawItem identifies a primitive to be drawn.
+type drawItem struct {
+	id   Drawable
+	prim int
+	// View depth used for sorting.
+	// Only meaningful for blended primitives.
+	depth float32
+}
+
+// drawList is the list of primitives to draw,
+// split by pass.
+type drawList struct {
+	// AlphaOpaque and AlphaMask.
+	opaque []drawItem
+	// AlphaBlend.
+	blend []drawItem
+	// Decals, drawn between opaque and
+	// blended primitives.
+	decal []Decal
+	// Outlined primitives, drawn by the
+	// outline passes.
+	outline []drawItem
+	// Visible foliage instances, drawn with
+	// opaque primitives.
+	foliage []foliageBatch
+	// Imposters to draw, and the drawables
+	// that they replace.
+	imposter []Imposter
+	hidden   map[Drawable]bool
+	// Lights and decals binned into the
+	// view frustum.
+	clusters clusters
+}
+
+// build fills l with the primitives of every drawable
+// in r.
+// view is the view transform (i.e., the inverse of
+// the camera's world transform).
+// If fr is not nil, primitives outside of it are
+// not added to l. Skinned primitives are never
+// culled, since their bounds are not known.
+// Blended primitives are sorted back-to-front unless
+// r uses TranspOIT.
+// Drawables that belong to a group replaced by its
+// imposter are not added to l.
+func (l *drawList) build(r *Renderer, view *linear.M4, fr *frustum) {
+	clear(l.opaque)
+	clear(l.blend)
+	l.opaque = l.opaque[:0]
+	l.blend = l.blend[:0]
+	l.switchImposters(r, view, fr)
+	for id, d := range r.drawables.all() {
+		if l.hidden[id] {
+			continue
+		}
+		var world linear.M4
+		var objDepth float32
+		var blended bool
+		cull := fr != nil && d.skin == nil
+		if cull {
+			world = d.layout.World()
+		}
+		for i, m := range d.mat {
+			if cull {
+				min, max := d.mesh.bounds(i)
+				if fr.cull(&world, &min, &max) {
+					continue
+				}
+			}
+			if m.layout.Flags()&shader.MatABlend == 0 {
+				l.opaque = append(l.opaque, drawItem{id: id, prim: i})
+				continue
+			}
+			if !blended {
+				if !cull {
+					world = d.layout.World()
+				}
+				objDepth = viewDepth(view, &world, &linear.V3{})
+				blended = true
+			}
+			x := drawItem{id: id, prim: i, depth: objDepth}
+			if r.transp == TranspSortPrimitive {
+				c := d.mesh.center(i)
+				x.depth = viewDepth(view, &world, &c)
+			}
+			l.blend = append(l.blend, x)
+		}
+	}
+	if r.transp != TranspOIT {
+		slices.SortStableFunc(l.blend, func(a, b drawItem) int {
+			return cmp.Compare(b.depth, a.depth)
+		})
+	}
+}
+
+// viewDepth returns the view-space depth of the local
+// position p.
+// Depth increases away from the camera.
+func viewDepth(view, world *linear.M4, p *linear.V3) float32 {
+	w := linear.V4{p[0], p[1], p[2], 1}
+	w.Mul(world, &w)
+	var z float32
+	for i := range w {
+		z += view[i][2] * w[i]
+	}
+	return z
+}
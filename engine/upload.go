@@ -0,0 +1,210 @@
+// Copyright 2024 Gustavo C. Viegas. All rights reserved.
+
+package engine
+
+import (
+	"sync"
+
+	"gviegas/neo3/driver"
+)
+
+// UploadPriority is the type of upload priorities.
+type UploadPriority int
+
+// Upload priorities.
+// Queued uploads of higher priority are always
+// staged first. Uploads of equal priority are staged
+// in the order that they were queued.
+const (
+	// For data that is needed to render the
+	// current view (e.g., visible textures).
+	UploadHigh UploadPriority = iota
+	UploadNormal
+	// For data that may be needed eventually
+	// (e.g., prefetched assets).
+	UploadLow
+
+	uploadPrioN
+)
+
+// DefaultUploadBudget is the default number of bytes
+// of queued uploads that are staged per frame.
+const DefaultUploadBudget = 16 << 20
+
+// uploadTask is a queued upload.
+// Either tex or buf is set.
+type uploadTask struct {
+	tex  *Texture
+	view int
+	buf  driver.Buffer
+	off  int64
+	data []byte
+	u    *Upload
+}
+
+// Global upload queue.
+var uploads = struct {
+	sync.Mutex
+	queue  [uploadPrioN][]*uploadTask
+	budget int
+}{budget: DefaultUploadBudget}
+
+// SetUploadBudget sets the number of bytes of queued
+// uploads that are staged per frame.
+// If n is less than or equal to zero, queued uploads
+// are staged without limit.
+// At least one queued upload is staged per frame
+// regardless of its size, so large uploads cannot
+// stall the queue.
+func SetUploadBudget(n int) {
+	uploads.Lock()
+	uploads.budget = n
+	uploads.Unlock()
+}
+
+// QueueCopyToView queues a copy of CPU data to the
+// given view of t.
+// The copy is staged by a later Presenter.BeginFrame
+// call (or ScheduleUploads), according to prio and to
+// the upload budget. data must not be modified until
+// the returned Upload completes.
+// The requirements of CopyToView apply.
+func (t *Texture) QueueCopyToView(view int, data []byte, prio UploadPriority) (*Upload, error) {
+	var reason string
+	switch {
+	case !t.IsValidView(view):
+		reason = "view index out of bounds"
+	case prio < UploadHigh || prio >= uploadPrioN:
+		reason = "undefined upload priority"
+	default:
+		goto validParam
+	}
+	return nil, newTexErr(reason)
+validParam:
+	if x := t.ViewSize(view); x < len(data) {
+		data = data[:x]
+	}
+	return queueUpload(&uploadTask{tex: t, view: view, data: data}, prio), nil
+}
+
+// queueCopyToBuffer is like copyToBuffer, but the copy
+// is queued as in Texture.QueueCopyToView.
+func queueCopyToBuffer(buf driver.Buffer, off int64, data []byte, prio UploadPriority) *Upload {
+	if prio < UploadHigh || prio >= uploadPrioN {
+		panic("undefined upload priority")
+	}
+	return queueUpload(&uploadTask{buf: buf, off: off, data: data}, prio)
+}
+
+// queueUpload adds x to the upload queue.
+func queueUpload(x *uploadTask, prio UploadPriority) *Upload {
+	x.u = &Upload{done: make(chan struct{})}
+	uploads.Lock()
+	uploads.queue[prio] = append(uploads.queue[prio], x)
+	uploads.Unlock()
+	return x.u
+}
+
+// UploadsQueued returns the number of queued uploads
+// that were not staged yet.
+func UploadsQueued() (n int) {
+	uploads.Lock()
+	for _, q := range uploads.queue {
+		n += len(q)
+	}
+	uploads.Unlock()
+	return
+}
+
+// ScheduleUploads stages queued uploads, within the
+// upload budget, and submits them for execution.
+// It is called by Presenter.BeginFrame; applications
+// that do not use a Presenter should call it once
+// per frame instead.
+// It does not wait for the uploads to complete.
+func ScheduleUploads() {
+	var texs, bufs []*uploadTask
+	// Views of a Texture may overlap, so at most
+	// one copy per Texture is staged per call.
+	dsts := make(map[*Texture]bool)
+	uploads.Lock()
+	budget := uploads.budget
+	if budget <= 0 {
+		budget = int(^uint(0) >> 1)
+	}
+	n := 0
+	full := false
+	for p := range uploads.queue {
+		q := uploads.queue[p][:0]
+		for _, x := range uploads.queue[p] {
+			if full || n > 0 && n+len(x.data) > budget {
+				// Do not let lower priority
+				// uploads go first.
+				full = true
+				q = append(q, x)
+				continue
+			}
+			if x.tex != nil {
+				// A view that is the destination
+				// of a copy in flight must wait,
+				// otherwise staging would fail.
+				if dsts[x.tex] || x.tex.viewPending(x.view) {
+					q = append(q, x)
+					continue
+				}
+				dsts[x.tex] = true
+				texs = append(texs, x)
+			} else {
+				bufs = append(bufs, x)
+			}
+			n += len(x.data)
+		}
+		clear(uploads.queue[p][len(q):])
+		uploads.queue[p] = q
+	}
+	uploads.Unlock()
+
+	texs = stageUploads(texs, func(x *uploadTask) error {
+		return x.tex.CopyToView(x.view, x.data, false)
+	})
+	if len(texs) > 0 {
+		u := submitTexStg(true)
+		go resolveUploads(texs, u.Wait)
+	}
+	bufs = stageUploads(bufs, func(x *uploadTask) error {
+		return copyToBuffer(x.buf, x.off, x.data, false)
+	})
+	if len(bufs) > 0 {
+		go resolveUploads(bufs, commitBufStg)
+	}
+}
+
+// stageUploads calls stage for every task in xs.
+// Tasks that fail to stage are completed immediately.
+// It returns the tasks that were staged, reusing xs.
+func stageUploads(xs []*uploadTask, stage func(*uploadTask) error) []*uploadTask {
+	staged := xs[:0]
+	for _, x := range xs {
+		if err := stage(x); err != nil {
+			x.u.resolve(err)
+		} else {
+			staged = append(staged, x)
+		}
+	}
+	return staged
+}
+
+// resolveUploads completes the Uploads of xs with the
+// error returned by wait.
+func resolveUploads(xs []*uploadTask, wait func() error) {
+	err := wait()
+	for _, x := range xs {
+		x.u.resolve(err)
+	}
+}
+
+// resolve completes u with the given error.
+func (u *Upload) resolve(err error) {
+	u.err = err
+	close(u.done)
+}
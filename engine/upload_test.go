@@ -0,0 +1,92 @@
+// Copyright 2024 Gustavo C. Viegas. All rights reserved.
+
+package engine
+
+import (
+	"testing"
+
+	"gviegas/neo3/driver"
+	"gviegas/neo3/engine/internal/ctxt"
+)
+
+func TestScheduleUploads(t *testing.T) {
+	defer SetUploadBudget(DefaultUploadBudget)
+
+	param := TexParam{
+		PixelFmt: driver.RGBA8Unorm,
+		Dim3D:    driver.Dim3D{Width: 32, Height: 32},
+		Layers:   1,
+		Levels:   1,
+		Samples:  1,
+	}
+	data := make([]byte, param.Size()*param.Width*param.Height)
+	var texs [3]*Texture
+	for i := range texs {
+		var err error
+		if texs[i], err = New2D(&param); err != nil {
+			t.Fatalf("New2D failed:\n%v", err)
+		}
+		defer texs[i].Free()
+	}
+	buf, err := ctxt.GPU().NewBuffer(int64(len(data)), false, driver.UCopyDst)
+	if err != nil {
+		t.Fatalf("driver.NewBuffer failed:\n%v", err)
+	}
+	defer buf.Destroy()
+
+	if _, err := texs[0].QueueCopyToView(1, data, UploadHigh); err == nil {
+		t.Fatal("Texture.QueueCopyToView: unexpected nil error")
+	}
+	if _, err := texs[0].QueueCopyToView(0, data, uploadPrioN); err == nil {
+		t.Fatal("Texture.QueueCopyToView: unexpected nil error")
+	}
+
+	// One upload per frame.
+	SetUploadBudget(len(data))
+	var us [5]*Upload
+	queue := func(i int, tex *Texture, prio UploadPriority) {
+		if us[i], err = tex.QueueCopyToView(0, data, prio); err != nil {
+			t.Fatalf("Texture.QueueCopyToView failed:\n%v", err)
+		}
+	}
+	queue(0, texs[0], UploadLow)
+	queue(1, texs[1], UploadNormal)
+	us[2] = queueCopyToBuffer(buf, 0, data, UploadNormal)
+	queue(3, texs[2], UploadHigh)
+	// Same Texture as above.
+	queue(4, texs[2], UploadHigh)
+	if n := UploadsQueued(); n != len(us) {
+		t.Fatalf("UploadsQueued:\nhave %d\nwant %d", n, len(us))
+	}
+
+	order := []int{3, 4, 1, 2, 0}
+	for i, want := range order {
+		ScheduleUploads()
+		if n := UploadsQueued(); n != len(us)-i-1 {
+			t.Fatalf("ScheduleUploads: UploadsQueued\nhave %d\nwant %d", n, len(us)-i-1)
+		}
+		if err := us[want].Wait(); err != nil {
+			t.Fatalf("Upload.Wait failed:\n%v", err)
+		}
+		for _, j := range order[i+1:] {
+			if us[j].Done() {
+				t.Fatalf("ScheduleUploads: Upload %d should not have been staged", j)
+			}
+		}
+	}
+
+	// No limit.
+	SetUploadBudget(0)
+	for i := range texs {
+		queue(i, texs[i], UploadLow)
+	}
+	ScheduleUploads()
+	if n := UploadsQueued(); n != 0 {
+		t.Fatalf("ScheduleUploads: UploadsQueued\nhave %d\nwant 0", n)
+	}
+	for _, u := range us[:len(texs)] {
+		if err := u.Wait(); err != nil {
+			t.Fatalf("Upload.Wait failed:\n%v", err)
+		}
+	}
+}
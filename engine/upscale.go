@@ -0,0 +1,197 @@
+// Copyright 2024 Gustavo C. Viegas. All rights reserved.
+
+package engine
+
+import (
+	"gviegas/neo3/driver"
+	"gviegas/neo3/linear"
+)
+
+// Upscaler inputs other than color.
+// See Upscaler.Inputs.
+const (
+	// The depth target.
+	UpscaleDepth = 1 << iota
+	// The velocity buffer (i.e., motion vectors).
+	UpscaleMotion
+)
+
+// UpscaleContext is given to Upscaler.Upscale.
+// Cmd is recording commands and has no active render
+// pass.
+// Color is the Renderer's color target, in a read-only
+// layout. The scene was rendered to its upper-left
+// area, whose size is given by Width and Height.
+// Depth and Motion are the Renderer's depth target and
+// velocity buffer, in read-only layouts, if requested
+// by Upscaler.Inputs, and nil otherwise. They cover the
+// same area as Color.
+// Output is the target that the upscaled image must be
+// written to, in a render target layout. Its whole
+// extent must be written.
+// Jitter is the sub-pixel offset, in pixels of the
+// scaled area, that was applied to the projection of
+// every viewport in the current frame. It is zero
+// unless the Upscaler is a TemporalUpscaler.
+// Reset is set when the contents of previous frames
+// must not be reused (e.g., because the render scale
+// changed).
+type UpscaleContext struct {
+	Cmd           driver.CmdBuffer
+	Color         *Texture
+	Depth         *Texture
+	Motion        *Texture
+	Width, Height int
+	Output        *Texture
+	Jitter        [2]float32
+	Reset         bool
+}
+
+// Upscaler is the interface that defines how the scene
+// is upscaled from the render scale to the native
+// resolution when dynamic resolution is enabled.
+// BilinearUpscaler is the built-in implementation.
+// Others (e.g., integrating FSR) can be supplied by
+// the application.
+// See DynResParam.
+type Upscaler interface {
+	// Inputs returns the inputs that the upscaler
+	// needs in addition to color, as a combination
+	// of UpscaleDepth and UpscaleMotion.
+	// It is called when the upscaler is set and
+	// must always return the same value.
+	Inputs() int
+
+	// Upscale records the commands that upscale
+	// ctx.Color into ctx.Output.
+	// It is called once per frame.
+	Upscale(ctx *UpscaleContext)
+}
+
+// TemporalUpscaler is an Upscaler that accumulates
+// samples across frames (e.g., in the manner of FSR 2).
+// The Renderer offsets the projection of its viewports
+// every frame by the jitter that the upscaler returns,
+// so that consecutive frames sample different
+// positions within each pixel.
+type TemporalUpscaler interface {
+	Upscaler
+
+	// Jitter returns the sub-pixel offset to apply in
+	// the given frame, in pixels of the scaled area.
+	// Both x and y should be in the [-0.5, 0.5]
+	// interval.
+	Jitter(frame uint) (x, y float32)
+}
+
+// BilinearUpscaler is an Upscaler that filters the
+// scaled image bilinearly.
+// It is the cheapest upscaler, but it blurs the image
+// noticeably at low render scales.
+type BilinearUpscaler struct{}
+
+// Inputs implements Upscaler.
+func (BilinearUpscaler) Inputs() int { return 0 }
+
+// Upscale implements Upscaler.
+// It blits the scaled area of ctx.Color to the whole
+// of ctx.Output with a linear filter.
+func (BilinearUpscaler) Upscale(ctx *UpscaleContext) {
+	color := ctx.Color.views[0].Image()
+	output := ctx.Output.views[0].Image()
+	ctx.Cmd.Transition([]driver.Transition{
+		{
+			Barrier: driver.Barrier{
+				SyncBefore:  driver.SFragmentShading | driver.SComputeShading,
+				SyncAfter:   driver.SCopy,
+				AccessAfter: driver.ACopyRead,
+			},
+			LayoutBefore: driver.LShaderRead,
+			LayoutAfter:  driver.LCopySrc,
+			Img:          color,
+			Layers:       ctx.Color.param.Layers,
+			Levels:       ctx.Color.param.Levels,
+		},
+		{
+			Barrier: driver.Barrier{
+				SyncBefore:   driver.SColorOutput,
+				SyncAfter:    driver.SCopy,
+				AccessBefore: driver.AColorWrite,
+				AccessAfter:  driver.ACopyWrite,
+			},
+			LayoutBefore: driver.LColorTarget,
+			LayoutAfter:  driver.LCopyDst,
+			Img:          output,
+			Layers:       ctx.Output.param.Layers,
+			Levels:       ctx.Output.param.Levels,
+		},
+	})
+	ctx.Cmd.BlitImage(&driver.ImageBlit{
+		From:     color,
+		FromSize: driver.Dim3D{Width: ctx.Width, Height: ctx.Height},
+		To:       output,
+		ToSize:   driver.Dim3D{Width: ctx.Output.Width(), Height: ctx.Output.Height()},
+		Layers:   1,
+		Filter:   driver.FLinear,
+	})
+	// Both textures must be left in the layouts
+	// that the frame graph expects.
+	ctx.Cmd.Transition([]driver.Transition{
+		{
+			Barrier: driver.Barrier{
+				SyncBefore:  driver.SCopy,
+				SyncAfter:   driver.SFragmentShading | driver.SComputeShading,
+				AccessAfter: driver.AShaderRead,
+			},
+			LayoutBefore: driver.LCopySrc,
+			LayoutAfter:  driver.LShaderRead,
+			Img:          color,
+			Layers:       ctx.Color.param.Layers,
+			Levels:       ctx.Color.param.Levels,
+		},
+		{
+			Barrier: driver.Barrier{
+				SyncBefore:   driver.SCopy,
+				SyncAfter:    driver.SColorOutput,
+				AccessBefore: driver.ACopyWrite,
+				AccessAfter:  driver.AColorRead | driver.AColorWrite,
+			},
+			LayoutBefore: driver.LCopyDst,
+			LayoutAfter:  driver.LColorTarget,
+			Img:          output,
+			Layers:       ctx.Output.param.Layers,
+			Levels:       ctx.Output.param.Levels,
+		},
+	})
+}
+
+// jitter returns the jitter offset of the current
+// frame, in pixels of the scaled area, or zero if the
+// upscaler of d is not temporal.
+func (d *dynRes) jitter() [2]float32 {
+	if u, ok := d.param.Upscaler.(TemporalUpscaler); ok {
+		x, y := u.Jitter(d.frame)
+		return [2]float32{x, y}
+	}
+	return [2]float32{}
+}
+
+// applyJitter offsets v's projection by the given
+// jitter, in pixels of a render area of the given
+// size, and updates v's layout accordingly.
+// The jitter is applied to v.proj, so it does not
+// accumulate over frames.
+func (v *viewport) applyJitter(jitter [2]float32, width, height int) {
+	if jitter == [2]float32{} {
+		return
+	}
+	rect := &v.param.Rect
+	t := linear.I4()
+	t[3][0] = 2 * jitter[0] / (rect.Width * float32(width))
+	t[3][1] = 2 * jitter[1] / (rect.Height * float32(height))
+	var p, vp linear.M4
+	p.Mul(&t, &v.proj)
+	vp.Mul(&p, &v.param.View)
+	v.layout.SetP(&p)
+	v.layout.SetVP(&vp)
+}
@@ -0,0 +1,130 @@
+// Copyright 2024 Gustavo C. Viegas. All rights reserved.
+
+package engine
+
+import (
+	"testing"
+	"time"
+
+	"gviegas/neo3/driver/validate"
+	"gviegas/neo3/engine/internal/ctxt"
+	"gviegas/neo3/linear"
+)
+
+// testUpscaler is a TemporalUpscaler that records the
+// contexts that it is given.
+type testUpscaler struct {
+	inputs int
+	ctx    []UpscaleContext
+}
+
+func (u *testUpscaler) Inputs() int { return u.inputs }
+
+func (u *testUpscaler) Upscale(ctx *UpscaleContext) { u.ctx = append(u.ctx, *ctx) }
+
+func (u *testUpscaler) Jitter(frame uint) (x, y float32) {
+	return halton(frame, 2) - 0.5, halton(frame, 3) - 0.5
+}
+
+func TestApplyJitter(t *testing.T) {
+	v := viewport{param: ViewportParam{
+		View: linear.I4(),
+		Proj: linear.I4(),
+		Rect: ViewportRect{Width: 0.5, Height: 1},
+	}}
+	v.applyJitter([2]float32{}, 100, 50)
+	if p := v.layout.P(); p != (linear.M4{}) {
+		t.Fatalf("viewport.applyJitter: zero jitter changed P\n%v", p)
+	}
+	for range 2 {
+		v.applyJitter([2]float32{0.5, -0.25}, 100, 50)
+	}
+	want := linear.I4()
+	want[3][0] = 0.02
+	want[3][1] = -0.01
+	if p := v.layout.P(); p != want {
+		t.Fatalf("viewport.applyJitter: P\nhave %v\nwant %v", p, want)
+	}
+	if vp := v.layout.VP(); vp != want {
+		t.Fatalf("viewport.applyJitter: VP\nhave %v\nwant %v", vp, want)
+	}
+}
+
+func TestRendererUpscaler(t *testing.T) {
+	rend, err := NewOffscreen(256, 192)
+	if err != nil {
+		t.Fatalf("RendererUpscaler: NewOffscreen failed:\n%v", err)
+	}
+	defer rend.Free()
+
+	u := &testUpscaler{inputs: UpscaleDepth | UpscaleMotion}
+	param := DynResParam{Target: 16 * time.Millisecond, MinScale: 0.5, MaxScale: 0.5, Upscaler: u}
+	if err := rend.SetDynamicResolution(&param); err != nil {
+		t.Fatalf("Renderer.SetDynamicResolution failed:\n%v", err)
+	}
+	if rend.vel == nil {
+		t.Fatal("Renderer.SetDynamicResolution: velocity buffer not created")
+	}
+	n := rend.graph.nodes[rend.graph.find(upscalePass)]
+	if len(n.reads) != 3 || n.reads[0] != rend.hdr || n.reads[1] != rend.ds || n.reads[2] != rend.vel {
+		t.Fatalf("Renderer.SetDynamicResolution: upscale pass reads\nhave %v\nwant [hdr ds vel]", n.reads)
+	}
+	if err := rend.graph.compile(); err != nil {
+		t.Fatalf("frameGraph.compile failed:\n%v", err)
+	}
+
+	for range 2 {
+		rend.buildViewports()
+		n.record(&rend.Renderer, nil)
+	}
+	if len(u.ctx) != 2 {
+		t.Fatalf("Upscaler.Upscale: call count\nhave %d\nwant 2", len(u.ctx))
+	}
+	for i, ctx := range u.ctx {
+		x, y := u.Jitter(uint(i + 1))
+		switch {
+		case ctx.Color != rend.hdr || ctx.Depth != rend.ds || ctx.Motion != rend.vel || ctx.Output != rend.dynRes.color:
+			t.Fatalf("Upscaler.Upscale: unexpected context textures\n%+v", ctx)
+		case ctx.Width != 128 || ctx.Height != 96:
+			t.Fatalf("Upscaler.Upscale: size\nhave %dx%d\nwant 128x96", ctx.Width, ctx.Height)
+		case ctx.Jitter != [2]float32{x, y}:
+			t.Fatalf("Upscaler.Upscale: jitter\nhave %v\nwant %v", ctx.Jitter, [2]float32{x, y})
+		case ctx.Reset != (i == 0):
+			t.Fatalf("Upscaler.Upscale: reset\nhave %t\nwant %t", ctx.Reset, i == 0)
+		}
+	}
+
+	// Switching to a spatial upscaler must not
+	// keep the previous inputs.
+	param.Upscaler = BilinearUpscaler{}
+	if err := rend.SetDynamicResolution(&param); err != nil {
+		t.Fatalf("Renderer.SetDynamicResolution failed:\n%v", err)
+	}
+	n = rend.graph.nodes[rend.graph.find(upscalePass)]
+	if len(n.reads) != 1 {
+		t.Fatalf("Renderer.SetDynamicResolution: upscale pass reads\nhave %v\nwant [hdr]", n.reads)
+	}
+	if j := rend.dynRes.jitter(); j != [2]float32{} {
+		t.Fatalf("dynRes.jitter:\nhave %v\nwant zero", j)
+	}
+}
+
+func TestBilinearUpscaler(t *testing.T) {
+	defer ctxt.SetGPU(ctxt.SetGPU(validate.New(ctxt.GPU())))
+	rend, err := NewOffscreen(64, 48)
+	if err != nil {
+		t.Fatalf("NewOffscreen failed:\n%v", err)
+	}
+	defer rend.Free()
+	param := DynResParam{Target: 16 * time.Millisecond, MinScale: 0.5, MaxScale: 0.5}
+	if err := rend.SetDynamicResolution(&param); err != nil {
+		t.Fatalf("Renderer.SetDynamicResolution failed:\n%v", err)
+	}
+	if err := rend.AddPass(clearPass("clear", StageGeometry, rend.hdr, [4]float32{1, 0, 0, 1})); err != nil {
+		t.Fatalf("Renderer.AddPass failed:\n%v", err)
+	}
+	// The blit and its transitions must be valid.
+	if _, err := renderGolden(rend); err != nil {
+		t.Fatalf("renderGolden failed:\n%v", err)
+	}
+}
@@ -0,0 +1,30 @@
+// Copyright 2024 Gustavo C. Viegas. All rights reserved.
+
+package engine
+
+// ValidationError is the error returned by functions
+// such as NewMesh and New2D when their parameters are
+// not valid.
+// It wraps ErrInvalidParam.
+// Use errors.As to retrieve it.
+type ValidationError struct {
+	prefix string
+	// Field identifies the invalid field, relative
+	// to the parameter and in Go syntax (e.g.,
+	// "Primitives[1].Index.Offset"). It is empty if
+	// the parameter as a whole is invalid.
+	Field string
+	// Reason describes the problem.
+	Reason string
+}
+
+// Error implements error.
+func (e *ValidationError) Error() string {
+	if e.Field == "" {
+		return e.prefix + e.Reason
+	}
+	return e.prefix + e.Field + ": " + e.Reason
+}
+
+// Unwrap returns ErrInvalidParam.
+func (e *ValidationError) Unwrap() error { return ErrInvalidParam }
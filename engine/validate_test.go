@@ -0,0 +1,224 @@
+// Copyright 2024 Gustavo C. Viegas. All rights reserved.
+
+package engine
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+
+	"gviegas/neo3/driver"
+)
+
+func TestValidationError(t *testing.T) {
+	var verr *ValidationError
+
+	_, err := New2D(&TexParam{
+		PixelFmt: driver.RGBA8Unorm,
+		Dim3D:    driver.Dim3D{Width: 16, Height: 16},
+		Layers:   1,
+		Levels:   1,
+		Samples:  3,
+	})
+	if !errors.As(err, &verr) {
+		t.Fatalf("New2D: error type\nhave %T\nwant %T", err, verr)
+	}
+	if verr.Field != "Samples" || verr.Reason != "invalid sample count" {
+		t.Fatalf("New2D: ValidationError\nhave %q, %q\nwant \"Samples\", \"invalid sample count\"", verr.Field, verr.Reason)
+	}
+	if s := err.Error(); !strings.HasPrefix(s, texPrefix) {
+		t.Fatalf("ValidationError.Error: %q should have prefix %q", s, texPrefix)
+	}
+
+	pos := make([]byte, 3*12)
+	prim := PrimitiveData{
+		Topology:     driver.TTriangle,
+		VertexCount:  3,
+		IndexCount:   3,
+		SemanticMask: Position,
+		Index:        IndexData{Format: driver.Index16},
+	}
+	prim.Semantics[Position.I()] = SemanticData{Format: driver.Float32x3}
+	for _, x := range [...]struct {
+		edit   func(*MeshData)
+		field  string
+		reason string
+	}{
+		{func(d *MeshData) { d.Primitives = nil }, "Primitives", "no primitive data"},
+		{func(d *MeshData) { d.Srcs[0] = nil }, "Srcs[0]", "nil data source"},
+		{func(d *MeshData) { d.Primitives[1].VertexCount = 0 }, "Primitives[1].VertexCount", "invalid vertex count"},
+		{func(d *MeshData) { d.Primitives[0].IndexCount = -3 }, "Primitives[0].IndexCount", "invalid index count"},
+		{func(d *MeshData) { d.Primitives[1].IndexCount = 4 }, "Primitives[1].IndexCount", "invalid count for driver.TTriangle"},
+		{func(d *MeshData) { d.Primitives[0].Index.Format = 7 }, "Primitives[0].Index.Format", "undefined driver.IndexFmt constant"},
+		{func(d *MeshData) { d.Primitives[0].Index.Src = 2 }, "Primitives[0].Index.Src", "index data source out of bounds"},
+		{func(d *MeshData) { d.Primitives[0].Index.Offset = -1 }, "Primitives[0].Index.Offset", "negative offset"},
+		{func(d *MeshData) { d.Primitives[0].Index.Offset = 1<<63 - 1 }, "Primitives[0].Index", "index data source too short"},
+		{func(d *MeshData) { d.Primitives[0].IndexCount = 9 }, "Primitives[0].Index", "index data source too short"},
+		{func(d *MeshData) { d.Primitives[1].Semantics[0].Offset = 1 }, "Primitives[1].Semantics[0]", "semantic data source too short"},
+		{func(d *MeshData) { d.Primitives[1].Semantics[0].Src = -1 }, "Primitives[1].Semantics[0].Src", "semantic data source out of bounds"},
+		{func(d *MeshData) { d.Srcs[1] = bytes.NewReader(pos[:35]) }, "Primitives[0].Semantics[0]", "semantic data source too short"},
+	} {
+		data := MeshData{
+			Primitives: []PrimitiveData{prim, prim},
+			Srcs:       []io.ReadSeeker{bytes.NewReader(make([]byte, 12)), bytes.NewReader(pos)},
+		}
+		for i := range data.Primitives {
+			data.Primitives[i].Semantics[Position.I()].Src = 1
+		}
+		if err := validateMeshData(&data); err != nil {
+			t.Fatalf("validateMeshData: unexpected error:\n%v", err)
+		}
+		x.edit(&data)
+		err := validateMeshData(&data)
+		if !errors.As(err, &verr) {
+			t.Fatalf("validateMeshData: error type\nhave %T\nwant %T", err, verr)
+		}
+		if verr.Field != x.field || verr.Reason != x.reason {
+			t.Fatalf("validateMeshData: ValidationError\nhave %q, %q\nwant %q, %q", verr.Field, verr.Reason, x.field, x.reason)
+		}
+		if s := err.Error(); s != meshPrefix+x.field+": "+x.reason {
+			t.Fatalf("ValidationError.Error:\nhave %q\nwant %q", s, meshPrefix+x.field+": "+x.reason)
+		}
+	}
+}
+
+// fuzzReader reads fuzzer input as little-endian
+// values, yielding zeros once it is exhausted.
+type fuzzReader []byte
+
+func (r *fuzzReader) u8() uint8 {
+	if len(*r) == 0 {
+		return 0
+	}
+	x := (*r)[0]
+	*r = (*r)[1:]
+	return x
+}
+
+func (r *fuzzReader) i16() int {
+	var b [2]byte
+	b[0], b[1] = r.u8(), r.u8()
+	return int(int16(binary.LittleEndian.Uint16(b[:])))
+}
+
+func (r *fuzzReader) i32() int64 {
+	var b [4]byte
+	for i := range b {
+		b[i] = r.u8()
+	}
+	return int64(int32(binary.LittleEndian.Uint32(b[:])))
+}
+
+// FuzzMeshData checks that NewMesh does not panic on
+// invalid MeshData, that it fails with a validation
+// error when the data is malformed, and that failed
+// calls do not leak mesh buffer memory.
+func FuzzMeshData(f *testing.F) {
+	f.Add([]byte{1, 3, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 36, 0})
+	f.Add([]byte{2, 3, 0, 3, 0, 1, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 36, 6, 0})
+	f.Add([]byte{1, 255, 127, 255, 127, 2, 255, 255, 255, 127, 0, 0, 0, 128, 0, 0, 4, 0})
+	fmts := [...]driver.VertexFmt{
+		driver.Float32x3,
+		driver.Float32x2,
+		driver.Uint16x4,
+		driver.Uint8x4,
+		driver.Float32x4,
+		driver.VertexFmt(0),
+	}
+	f.Fuzz(func(t *testing.T, b []byte) {
+		r := fuzzReader(b)
+		nsrc := int(r.u8()%4) + 1
+		nprim := int(r.u8()%3) + 1
+		data := MeshData{Primitives: make([]PrimitiveData, nprim)}
+		for i := range data.Primitives {
+			p := &data.Primitives[i]
+			p.Topology = driver.Topology(r.u8() % 6)
+			p.VertexCount = r.i16()
+			p.IndexCount = r.i16()
+			p.SemanticMask = Semantic(r.u8())
+			p.Index = IndexData{
+				Format: driver.IndexFmt(r.u8() % 3),
+				Offset: r.i32(),
+				Src:    int(r.u8()%5) - 1,
+			}
+			for j := range p.Semantics {
+				if p.SemanticMask&(1<<j) == 0 {
+					continue
+				}
+				p.Semantics[j] = SemanticData{
+					Format: fmts[int(r.u8())%len(fmts)],
+					Offset: r.i32(),
+					Src:    int(r.u8()%5) - 1,
+				}
+			}
+		}
+		for range nsrc {
+			src := make([]byte, int(r.u8())*4)
+			for i := range src {
+				src[i] = byte(i)
+			}
+			data.Srcs = append(data.Srcs, bytes.NewReader(src))
+		}
+
+		used := meshes.spans.Used()
+		verr := validateMeshData(&data)
+		m, err := NewMesh(&data)
+		switch {
+		case err == nil:
+			if verr != nil {
+				t.Fatalf("NewMesh: unexpected success\n(validateMeshData: %v)", verr)
+			}
+			if m.Len() != nprim {
+				t.Fatalf("NewMesh: Mesh.Len\nhave %d\nwant %d", m.Len(), nprim)
+			}
+			m.Free()
+		case verr != nil:
+			if !errors.As(err, new(*ValidationError)) {
+				t.Fatalf("NewMesh: error type\nhave %T\nwant %T", err, (*ValidationError)(nil))
+			}
+		}
+		if x := meshes.spans.Used(); x != used {
+			t.Fatalf("NewMesh: mesh buffer spans in use\nhave %d\nwant %d", x, used)
+		}
+	})
+}
+
+// FuzzTexParam checks that the texture constructors
+// do not panic on invalid TexParam, and that they
+// fail with a validation error if they fail before
+// creating any driver resource.
+func FuzzTexParam(f *testing.F) {
+	f.Add(uint8(0), 64, 64, 0, 1, 1, 1)
+	f.Add(uint8(1), 32, 32, 0, 6, 2, 1)
+	f.Add(uint8(2), 8, 8, 8, 1, 4, 1)
+	f.Add(uint8(3), -1, 1<<30, 0, 0, 99, 3)
+	f.Fuzz(func(t *testing.T, kind uint8, w, h, d, layers, levels, samples int) {
+		// Keep valid sizes small.
+		if w > 1024 || h > 1024 || d > 64 || layers > 64 {
+			return
+		}
+		param := TexParam{
+			PixelFmt: driver.RGBA8Unorm,
+			Dim3D:    driver.Dim3D{Width: w, Height: h, Depth: d},
+			Layers:   layers,
+			Levels:   levels,
+			Samples:  samples,
+		}
+		ctors := [...]func(*TexParam) (*Texture, error){New2D, NewCube, New3D, NewTarget}
+		tex, err := ctors[int(kind)%len(ctors)](&param)
+		if err != nil {
+			var verr *ValidationError
+			if errors.As(err, &verr) && !strings.HasPrefix(err.Error(), texPrefix) {
+				t.Fatalf("ValidationError.Error: %q should have prefix %q", err.Error(), texPrefix)
+			}
+			return
+		}
+		if tex.param != param {
+			t.Fatalf("Texture.param\nhave %v\nwant %v", tex.param, param)
+		}
+		tex.Free()
+	})
+}
@@ -0,0 +1,404 @@
+// Copyright 2024 Gustavo C. Viegas. All rights reserved.
+
+package engine
+
+import (
+	"cmp"
+	"math"
+	"slices"
+
+	"gviegas/neo3/driver"
+	"gviegas/neo3/engine/internal/ctxt"
+	"gviegas/neo3/engine/internal/shader"
+	"gviegas/neo3/linear"
+)
+
+// MaxViewport is the maximum number of viewports that
+// a Renderer can hold at once.
+const MaxViewport = 4
+
+// viewportMap is a dataMap for viewports.
+type viewportMap struct{ dataMap[Viewport, viewport] }
+
+// viewport is what a viewportMap stores.
+type viewport struct {
+	param  ViewportParam
+	layout shader.FrameLayout
+	// Projection transform that is used for
+	// rendering. It differs from param.Proj
+	// if the clip plane is emulated with an
+	// oblique projection.
+	proj linear.M4
+	// Transforms of the previous frame, used
+	// when r has a velocity buffer.
+	reproj  shader.ReprojLayout
+	hasPrev bool
+	// Primitives that pass the viewport's culling
+	// test, built every frame.
+	list drawList
+	// Views rendered through portals, built
+	// every frame after list.
+	portals []portalView
+}
+
+// Viewport identifies a camera that renders to an
+// area of a Renderer's target.
+// A Viewport is always associated with a Renderer,
+// thus there might be identical Viewport values
+// that belong to different renderers.
+type Viewport int
+
+// ViewportRect is a rectangle in normalized coordinates.
+// The origin is at the upper-left corner of the
+// render target, and (1, 1) is at the lower-right
+// corner.
+type ViewportRect struct {
+	X, Y, Width, Height float32
+}
+
+// ViewportParam describes a viewport.
+// View is the view transform (i.e., the inverse of
+// the camera's world transform) and Proj is the
+// projection transform. Proj must map depth to the
+// [0, 1] interval. It need not be a symmetric
+// perspective projection: orthographic, off-axis and
+// oblique (e.g., for clipped reflections) projections
+// are supported as well.
+// ClipPlane is an optional world space plane whose
+// negative side is not rendered (e.g., for planar
+// reflections). The zero plane clips nothing. If the
+// GPU does not support driver.Features.ClipDistance,
+// the near plane of Proj is replaced by ClipPlane
+// instead (see linear.M4.Oblique), which only clips
+// if the camera is on the negative side of the plane.
+// Rect is the area of the render target that the
+// viewport renders to. Viewports can overlap, in which
+// case the ones with higher Layer values are drawn on
+// top (e.g., for picture-in-picture).
+type ViewportParam struct {
+	View      linear.M4
+	Proj      linear.M4
+	ClipPlane linear.V4
+	Rect      ViewportRect
+	Layer     int
+}
+
+// Post-processing modes for multiple viewports.
+const (
+	// Post-processing passes run once for the
+	// whole render target, after every viewport
+	// has been drawn.
+	PostShared = iota
+	// Post-processing passes run once per
+	// viewport, restricted to its area.
+	PostPerViewport
+)
+
+// AddViewport adds a new viewport to r.
+// r can hold at most MaxViewport viewports.
+func (r *Renderer) AddViewport(param *ViewportParam) (Viewport, error) {
+	var reason string
+	switch {
+	case param == nil:
+		reason = "nil viewport param"
+	case !param.Rect.valid():
+		reason = "invalid viewport rect"
+	case r.vports.len() >= MaxViewport:
+		reason = "too many viewports"
+	default:
+		goto validParam
+	}
+	return -1, newRendErr(reason)
+validParam:
+	v := viewport{param: *param}
+	v.update(r)
+	return r.vports.insert(v), nil
+}
+
+// RemoveViewport removes v from r.
+func (r *Renderer) RemoveViewport(v Viewport) { r.vports.remove(v) }
+
+// SetViewportCamera sets the view and projection
+// transforms of v.
+func (r *Renderer) SetViewportCamera(v Viewport, view, proj *linear.M4) {
+	x := r.vports.get(v)
+	x.param.View = *view
+	x.param.Proj = *proj
+	x.update(r)
+}
+
+// SetViewportClipPlane sets the world space clip plane
+// of v. If plane is nil, v is not clipped.
+func (r *Renderer) SetViewportClipPlane(v Viewport, plane *linear.V4) {
+	x := r.vports.get(v)
+	if plane == nil {
+		x.param.ClipPlane = linear.V4{}
+	} else {
+		x.param.ClipPlane = *plane
+	}
+	x.update(r)
+}
+
+// SetViewportRect sets the area of r's target that v
+// renders to.
+func (r *Renderer) SetViewportRect(v Viewport, rect *ViewportRect) error {
+	if !rect.valid() {
+		return newRendErr("invalid viewport rect")
+	}
+	x := r.vports.get(v)
+	x.param.Rect = *rect
+	x.update(r)
+	return nil
+}
+
+// Ray is a half-line in world space.
+// Dir is normalized.
+type Ray struct {
+	Origin linear.V3
+	Dir    linear.V3
+}
+
+// ViewportRay returns the ray that passes through the
+// point (x, y) of r's target, from the near plane of
+// v's camera towards its far plane.
+// x and y are normalized coordinates, as in
+// ViewportRect. Points outside of v's rect produce
+// rays outside of its frustum.
+func (r *Renderer) ViewportRay(v Viewport, x, y float32) Ray {
+	vp := r.vports.get(v)
+	rect := &vp.param.Rect
+	ndcX := 2*(x-rect.X)/rect.Width - 1
+	ndcY := 2*(y-rect.Y)/rect.Height - 1
+	ivp := vp.layout.VP()
+	ivp.Invert(&ivp)
+	var p [2]linear.V3
+	for i := range p {
+		w := linear.V4{ndcX, ndcY, float32(i), 1}
+		w.Mul(&ivp, &w)
+		p[i] = linear.V3{w[0] / w[3], w[1] / w[3], w[2] / w[3]}
+	}
+	var ray Ray
+	ray.Origin = p[0]
+	ray.Dir.Sub(&p[1], &p[0])
+	ray.Dir.Norm(&ray.Dir)
+	return ray
+}
+
+// ViewportsLen returns the number of viewports in r.
+func (r *Renderer) ViewportsLen() int { return r.vports.len() }
+
+// SetViewportPost sets how r runs post-processing
+// when there are multiple viewports.
+// mode must be PostShared (the default) or
+// PostPerViewport.
+func (r *Renderer) SetViewportPost(mode int) error {
+	switch mode {
+	case PostShared, PostPerViewport:
+		r.vportPost = mode
+		return nil
+	}
+	return newRendErr("undefined viewport post-processing mode")
+}
+
+// ViewportPost returns the post-processing mode of r.
+func (r *Renderer) ViewportPost() int { return r.vportPost }
+
+// valid checks whether rect is a non-empty rectangle
+// within the [0, 1] interval.
+func (rect *ViewportRect) valid() bool {
+	return rect.X >= 0 && rect.Y >= 0 &&
+		rect.Width > 0 && rect.Height > 0 &&
+		rect.X+rect.Width <= 1 && rect.Y+rect.Height <= 1
+}
+
+// bounds returns the viewport and scissor rectangle
+// of rect in a render target of the given size.
+// The scissor covers every pixel whose center is
+// within the viewport, so adjacent rects neither
+// overlap nor leave gaps.
+func (rect *ViewportRect) bounds(width, height int) (driver.Viewport, driver.Scissor) {
+	w, h := float32(width), float32(height)
+	vp := driver.Viewport{
+		X:      rect.X * w,
+		Y:      rect.Y * h,
+		Width:  rect.Width * w,
+		Height: rect.Height * h,
+		Znear:  0,
+		Zfar:   1,
+	}
+	round := func(x float32) int { return int(math.Floor(float64(x) + 0.5)) }
+	x0, y0 := round(vp.X), round(vp.Y)
+	x1, y1 := round(vp.X+vp.Width), round(vp.Y+vp.Height)
+	return vp, driver.Scissor{X: x0, Y: y0, Width: x1 - x0, Height: y1 - y0}
+}
+
+// update updates v's layout to reflect v.param.
+func (v *viewport) update(r *Renderer) {
+	v.proj = v.param.Proj
+	clip := v.param.ClipPlane
+	if clip != (linear.V4{}) && !ctxt.Features().ClipDistance {
+		obliqueClip(&v.proj, &v.param.View, &clip)
+		clip = linear.V4{}
+	}
+	var vp linear.M4
+	vp.Mul(&v.proj, &v.param.View)
+	v.layout.SetVP(&vp)
+	v.layout.SetV(&v.param.View)
+	v.layout.SetP(&v.proj)
+	v.layout.SetClipPlane(&clip)
+	bnd, _ := v.param.Rect.bounds(r.renderSize())
+	v.layout.SetBounds(&bnd)
+}
+
+// obliqueClip replaces the near plane of proj with the
+// world space plane, using the view transform to bring
+// it to view space.
+// proj is not changed if the view origin is not on
+// the negative side of plane.
+func obliqueClip(proj, view *linear.M4, plane *linear.V4) {
+	// Planes transform by the inverse
+	// transpose.
+	var inv, it linear.M4
+	inv.Invert(view)
+	it.Transpose(&inv)
+	var c linear.V4
+	c.Mul(&it, plane)
+	if c[3] < 0 {
+		proj.Oblique(proj, &c)
+	}
+}
+
+// buildViewports builds the draw list of every
+// viewport in r.
+// Primitives that are outside of a viewport's frustum
+// are culled.
+// The projections are jittered first if r uses a
+// TemporalUpscaler.
+// It also sorts r.vportOrder by layer, sets the
+// color target scale, which depends on the current
+// exposure, and, if r has a velocity buffer, updates
+// the viewports' reprojection data.
+func (r *Renderer) buildViewports() {
+	defer traceBegin(traceCull, "viewports").end()
+	if r.dynRes != nil {
+		r.dynRes.frame++
+		if jitter := r.dynRes.jitter(); jitter != [2]float32{} {
+			width, height := r.renderSize()
+			for _, v := range r.vports.all() {
+				v.applyJitter(jitter, width, height)
+			}
+		}
+	}
+	if r.vel != nil {
+		r.updateReproj()
+	}
+	scale := r.targetScale()
+	r.vportOrder = r.vportOrder[:0]
+	for id, v := range r.vports.all() {
+		r.vportOrder = append(r.vportOrder, id)
+		v.layout.SetTargetScale(scale)
+		vp := v.layout.VP()
+		var fr frustum
+		fr.set(&vp)
+		// Decals are not culled, and their
+		// clip transforms are computed when
+		// recording each viewport.
+		v.list.build(r, &v.param.View, &fr)
+		v.list.buildOutline(r)
+		v.list.buildFoliage(r, &v.param.View, &fr)
+		v.list.buildClusters(r, &v.param.View, &v.proj)
+		v.buildPortals(r)
+	}
+	slices.SortFunc(r.vportOrder, func(a, b Viewport) int {
+		if c := cmp.Compare(r.vports.get(a).param.Layer, r.vports.get(b).param.Layer); c != 0 {
+			return c
+		}
+		return cmp.Compare(a, b)
+	})
+}
+
+// executeViewports calls record for every viewport
+// in r, in the order given by r.vportOrder.
+// r.curVport identifies the viewport being recorded.
+// If full is true and r uses PostShared, record is
+// called only once, with r.curVport set to -1, since
+// the pass covers the whole render target.
+func (r *Renderer) executeViewports(full bool, record func()) {
+	if full && r.vportPost == PostShared {
+		r.curVport = -1
+		record()
+		return
+	}
+	for _, id := range r.vportOrder {
+		r.curVport = id
+		record()
+	}
+	r.curVport = -1
+}
+
+// curBounds returns the viewport and scissor rectangle
+// that passes must use when recording commands.
+// Passes of stageFinal use the native resolution, and
+// the others use r.renderSize.
+func (r *Renderer) curBounds() (driver.Viewport, driver.Scissor) {
+	width, height := r.renderSize()
+	if r.curStage == stageFinal {
+		width, height = r.hdr.Width(), r.hdr.Height()
+	}
+	if r.curVport < 0 {
+		rect := ViewportRect{Width: 1, Height: 1}
+		return rect.bounds(width, height)
+	}
+	return r.vports.get(r.curVport).param.Rect.bounds(width, height)
+}
+
+// frustum is a view frustum defined by six planes.
+// The planes' normals point inwards.
+type frustum [6]linear.V4
+
+// set sets f to contain the frustum of the given
+// view-projection transform.
+// Clip space depth is assumed to be in [0, 1].
+func (f *frustum) set(vp *linear.M4) {
+	var row [4]linear.V4
+	for i := range row {
+		row[i] = linear.V4{vp[0][i], vp[1][i], vp[2][i], vp[3][i]}
+	}
+	f[0].Add(&row[3], &row[0])
+	f[1].Sub(&row[3], &row[0])
+	f[2].Add(&row[3], &row[1])
+	f[3].Sub(&row[3], &row[1])
+	f[4] = row[2]
+	f[5].Sub(&row[3], &row[2])
+}
+
+// cull returns whether the local bounds [min, max],
+// transformed by world, are completely outside of f.
+// It is conservative, so it may return false for
+// bounds that are outside of f.
+func (f *frustum) cull(world *linear.M4, min, max *linear.V3) bool {
+	var c, e linear.V3
+	c.Add(min, max)
+	c.Scale(0.5, &c)
+	e.Sub(max, &c)
+	// World space bounding box.
+	var wc, we linear.V3
+	for i := range wc {
+		wc[i] = world[3][i]
+		for j := range c {
+			wc[i] += world[j][i] * c[j]
+			we[i] += float32(math.Abs(float64(world[j][i]))) * e[j]
+		}
+	}
+	for _, p := range f {
+		n := linear.V3{p[0], p[1], p[2]}
+		d := n.Dot(&wc) + p[3]
+		r := we[0]*float32(math.Abs(float64(n[0]))) +
+			we[1]*float32(math.Abs(float64(n[1]))) +
+			we[2]*float32(math.Abs(float64(n[2])))
+		if d+r < 0 {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,161 @@
+// Copyright 2024 Gustavo C. Viegas. All rights reserved.
+
+package engine
+
+import (
+	"math"
+	"testing"
+
+	"gviegas/neo3/driver"
+	"gviegas/neo3/engine/internal/ctxt"
+	"gviegas/neo3/linear"
+)
+
+func TestFrustum(t *testing.T) {
+	var proj linear.M4
+	proj.Perspective(math.Pi/2, 1, 0.1, 100)
+	var fr frustum
+	fr.set(&proj)
+
+	min, max := linear.V3{-0.5, -0.5, -0.5}, linear.V3{0.5, 0.5, 0.5}
+	for _, x := range [...]struct {
+		pos  linear.V3
+		cull bool
+	}{
+		{linear.V3{0, 0, 10}, false},
+		{linear.V3{0, 0, -10}, true},
+		{linear.V3{50, 0, 10}, true},
+		{linear.V3{-50, 0, 10}, true},
+		{linear.V3{0, 50, 10}, true},
+		{linear.V3{0, -50, 10}, true},
+		{linear.V3{0, 0, 200}, true},
+		// Intersecting the near, far and side
+		// planes.
+		{linear.V3{0, 0, 0.1}, false},
+		{linear.V3{0, 0, 100}, false},
+		{linear.V3{10.4, 0, 10}, false},
+	} {
+		var world linear.M4
+		world.Translate(x.pos[0], x.pos[1], x.pos[2])
+		if c := fr.cull(&world, &min, &max); c != x.cull {
+			t.Fatalf("frustum.cull: %v\nhave %t\nwant %t", x.pos, c, x.cull)
+		}
+	}
+
+	// Scaling and rotation must be taken into
+	// account.
+	var world, s linear.M4
+	world.Translate(12, 0, 10)
+	s.Scale(8, 1, 1)
+	world.Mul(&world, &s)
+	if fr.cull(&world, &min, &max) {
+		t.Fatal("frustum.cull: scaled bounds should not be culled")
+	}
+	min = linear.V3{-0.5, -0.5, -8}
+	world.Translate(0, 0, -1)
+	if !fr.cull(&world, &min, &max) {
+		t.Fatal("frustum.cull: bounds should be culled")
+	}
+	// Local Z becomes world -Z.
+	world.Rotate(math.Pi, &linear.V3{0, 1, 0})
+	world[3] = linear.V4{0, 0, -1, 1}
+	if fr.cull(&world, &min, &max) {
+		t.Fatal("frustum.cull: rotated bounds should not be culled")
+	}
+}
+
+func TestViewportRect(t *testing.T) {
+	for _, x := range [...]struct {
+		rect  ViewportRect
+		valid bool
+	}{
+		{ViewportRect{0, 0, 1, 1}, true},
+		{ViewportRect{0.5, 0, 0.5, 1}, true},
+		{ViewportRect{0, 0, 0, 1}, false},
+		{ViewportRect{-0.1, 0, 0.5, 1}, false},
+		{ViewportRect{0.6, 0, 0.5, 1}, false},
+		{ViewportRect{0, 0.5, 1, 0.6}, false},
+	} {
+		if v := x.rect.valid(); v != x.valid {
+			t.Fatalf("ViewportRect.valid: %v\nhave %t\nwant %t", x.rect, v, x.valid)
+		}
+	}
+
+	// Three-way split of an odd width must
+	// neither overlap nor leave gaps.
+	var x int
+	for i := range 3 {
+		rect := ViewportRect{float32(i) / 3, 0, 1.0 / 3, 1}
+		vp, sciss := rect.bounds(101, 60)
+		if sciss.X != x {
+			t.Fatalf("ViewportRect.bounds: Scissor.X\nhave %d\nwant %d", sciss.X, x)
+		}
+		if sciss.Y != 0 || sciss.Height != 60 || vp.Height != 60 || vp.Zfar != 1 {
+			t.Fatalf("ViewportRect.bounds: unexpected bounds\n%v, %v", vp, sciss)
+		}
+		x += sciss.Width
+	}
+	if x != 101 {
+		t.Fatalf("ViewportRect.bounds: total width\nhave %d\nwant 101", x)
+	}
+	vp, _ := (&ViewportRect{0.25, 0.5, 0.5, 0.5}).bounds(200, 100)
+	if want := (driver.Viewport{X: 50, Y: 50, Width: 100, Height: 50, Zfar: 1}); vp != want {
+		t.Fatalf("ViewportRect.bounds:\nhave %v\nwant %v", vp, want)
+	}
+}
+
+func TestViewportClipPlane(t *testing.T) {
+	rend, err := NewOffscreen(64, 64)
+	if err != nil {
+		t.Fatalf("NewOffscreen failed:\n%v", err)
+	}
+	defer rend.Free()
+
+	// Camera above a water plane at y=1, whose
+	// reflection must only show what is above it.
+	var view, proj linear.M4
+	view.LookAt(&linear.V3{0, 0, 10}, &linear.V3{0, 3, 0}, &linear.V3{0, 1, 0})
+	proj.Perspective(math.Pi/3, 1, 0.1, 100)
+	var refl linear.M4
+	refl.Reflect(&linear.V4{0, 1, 0, -1})
+	view.Mul(&view, &refl)
+	plane := linear.V4{0, 1, 0, -1}
+	v, err := rend.AddViewport(&ViewportParam{View: view, Proj: proj, ClipPlane: plane, Rect: ViewportRect{Width: 1, Height: 1}})
+	if err != nil {
+		t.Fatalf("Renderer.AddViewport failed:\n%v", err)
+	}
+	x := rend.vports.get(v)
+	if ctxt.Features().ClipDistance {
+		if c := x.layout.ClipPlane(); c != plane || x.proj != proj {
+			t.Fatalf("Renderer.AddViewport: clip plane\nhave %v\nwant %v", c, plane)
+		}
+	} else {
+		if c := x.layout.ClipPlane(); c != (linear.V4{}) || x.proj == proj {
+			t.Fatalf("Renderer.AddViewport: oblique projection\nhave %v, %v", c, x.proj)
+		}
+	}
+
+	// The reflected camera is below the plane, so
+	// points on it are at the near plane.
+	p := proj
+	obliqueClip(&p, &view, &plane)
+	var vp linear.M4
+	vp.Mul(&p, &view)
+	for _, w := range [...]linear.V4{{0, 1, 5, 1}, {1, 1, 4, 1}, {-1, 1, 6, 1}} {
+		w.Mul(&vp, &w)
+		if z := w[2] / w[3]; math.Abs(float64(z)) > 1e-3 {
+			t.Fatalf("obliqueClip: depth on the plane\nhave %v\nwant 0", z)
+		}
+	}
+	// Nothing changes if the camera is above it.
+	view.LookAt(&linear.V3{0, 0, 10}, &linear.V3{0, 3, 0}, &linear.V3{0, 1, 0})
+	p = proj
+	if obliqueClip(&p, &view, &plane); p != proj {
+		t.Fatalf("obliqueClip: camera on the visible side\nhave %v\nwant %v", p, proj)
+	}
+
+	rend.SetViewportClipPlane(v, nil)
+	if c := x.layout.ClipPlane(); c != (linear.V4{}) || x.proj != proj {
+		t.Fatal("Renderer.SetViewportClipPlane: clip plane not removed")
+	}
+}
@@ -0,0 +1,166 @@
+// Copyright 2024 Gustavo C. Viegas. All rights reserved.
+
+package meshopt
+
+import (
+	"encoding/binary"
+	"math"
+
+	"gviegas/neo3/linear"
+)
+
+// Meshlet is a small cluster of triangles that can be
+// processed by a single mesh shader workgroup.
+type Meshlet struct {
+	// Range of Meshlets.Vertices.
+	VertexOffset int
+	VertexCount  int
+	// Range of Meshlets.Triangles, in bytes.
+	// Each triangle takes three bytes.
+	TriangleOffset int
+	TriangleCount  int
+	// Bounding sphere.
+	Center linear.V3
+	Radius float32
+	// Normal cone.
+	// The meshlet is back-facing when seen from
+	// eye if
+	//
+	//	dot(norm(Center - eye), ConeAxis) >= ConeCutoff
+	//
+	// ConeCutoff is 1 when the cone is too wide
+	// to be of use.
+	ConeAxis   linear.V3
+	ConeCutoff float32
+}
+
+// Meshlets is the result of BuildMeshlets.
+type Meshlets struct {
+	Meshlets []Meshlet
+	// Vertex indices of every meshlet.
+	Vertices []uint32
+	// Triangles of every meshlet, as indices
+	// into the meshlet's vertices.
+	Triangles []uint8
+}
+
+// BuildMeshlets partitions a triangle list into
+// meshlets containing at most maxVertices vertices
+// and maxTriangles triangles.
+// Triangles are taken in order, so indices should be
+// optimized for the vertex cache beforehand.
+// pos must contain three float32 components per
+// vertex. maxVertices must be in [3, 256].
+func BuildMeshlets(indices []uint32, pos Stream, maxVertices, maxTriangles int) (ms Meshlets) {
+	if maxVertices < 3 || maxVertices > 256 || maxTriangles < 1 {
+		panic("meshopt.BuildMeshlets: invalid limits")
+	}
+	// Position of each vertex in the current
+	// meshlet, or -1.
+	local := make([]int, len(pos.Data)/pos.Stride)
+	for i := range local {
+		local[i] = -1
+	}
+	var cur Meshlet
+	finish := func() {
+		if cur.TriangleCount == 0 {
+			return
+		}
+		cur.bounds(&ms, pos)
+		ms.Meshlets = append(ms.Meshlets, cur)
+		for _, v := range ms.Vertices[cur.VertexOffset:] {
+			local[v] = -1
+		}
+		cur = Meshlet{
+			VertexOffset:   len(ms.Vertices),
+			TriangleOffset: len(ms.Triangles),
+		}
+	}
+	for i := 0; i+2 < len(indices); i += 3 {
+		t := indices[i : i+3]
+		var need int
+		for j, v := range t {
+			if local[v] < 0 && (j == 0 || v != t[0]) && (j < 2 || v != t[1]) {
+				need++
+			}
+		}
+		if cur.VertexCount+need > maxVertices || cur.TriangleCount == maxTriangles {
+			finish()
+		}
+		for _, v := range t {
+			if local[v] < 0 {
+				local[v] = cur.VertexCount
+				ms.Vertices = append(ms.Vertices, v)
+				cur.VertexCount++
+			}
+			ms.Triangles = append(ms.Triangles, uint8(local[v]))
+		}
+		cur.TriangleCount++
+	}
+	finish()
+	return
+}
+
+// position returns the position of vertex v.
+func position(pos Stream, v uint32) (p linear.V3) {
+	b := pos.Data[int(v)*pos.Stride:]
+	for i := range p {
+		p[i] = math.Float32frombits(binary.LittleEndian.Uint32(b[i*4:]))
+	}
+	return
+}
+
+// bounds computes the bounding sphere and normal cone
+// of m.
+func (m *Meshlet) bounds(ms *Meshlets, pos Stream) {
+	verts := ms.Vertices[m.VertexOffset : m.VertexOffset+m.VertexCount]
+	lo := position(pos, verts[0])
+	hi := lo
+	for _, v := range verts[1:] {
+		p := position(pos, v)
+		for i := range p {
+			lo[i] = min(lo[i], p[i])
+			hi[i] = max(hi[i], p[i])
+		}
+	}
+	m.Center.Add(&lo, &hi)
+	m.Center.Scale(0.5, &m.Center)
+	m.Radius = 0
+	for _, v := range verts {
+		p := position(pos, v)
+		p.Sub(&p, &m.Center)
+		m.Radius = max(m.Radius, p.Len())
+	}
+
+	tris := ms.Triangles[m.TriangleOffset : m.TriangleOffset+m.TriangleCount*3]
+	normals := make([]linear.V3, 0, m.TriangleCount)
+	var axis linear.V3
+	for i := 0; i < len(tris); i += 3 {
+		a := position(pos, verts[tris[i]])
+		b := position(pos, verts[tris[i+1]])
+		c := position(pos, verts[tris[i+2]])
+		b.Sub(&b, &a)
+		c.Sub(&c, &a)
+		var n linear.V3
+		n.Cross(&b, &c)
+		if l := n.Len(); l > 0 {
+			n.Scale(1/l, &n)
+			normals = append(normals, n)
+			axis.Add(&axis, &n)
+		}
+	}
+	m.ConeAxis = linear.V3{}
+	m.ConeCutoff = 1
+	if axis.Len() == 0 {
+		return
+	}
+	axis.Norm(&axis)
+	m.ConeAxis = axis
+	mindp := float32(1)
+	for i := range normals {
+		mindp = min(mindp, axis.Dot(&normals[i]))
+	}
+	if mindp > 0 {
+		m.ConeCutoff = float32(math.Sqrt(float64(1 - mindp*mindp)))
+	}
+}
@@ -0,0 +1,296 @@
+// Copyright 2024 Gustavo C. Viegas. All rights reserved.
+
+// Package meshopt implements index buffer optimizations
+// that improve the rendering performance of meshes.
+// The engine applies them during mesh creation.
+package meshopt
+
+import (
+	"math"
+	"slices"
+)
+
+// Stream is a vertex attribute stream.
+type Stream struct {
+	Data   []byte
+	Stride int
+}
+
+// Weld merges vertices whose data is identical in every
+// stream.
+// It returns a remap table that maps each of the first
+// vertexCount vertices to its new index, and the number
+// of unique vertices.
+// New indices are assigned in order of first occurrence.
+func Weld(vertexCount int, streams ...Stream) (remap []uint32, n int) {
+	remap = make([]uint32, vertexCount)
+	var size int
+	for _, s := range streams {
+		size += s.Stride
+	}
+	seen := make(map[string]uint32, vertexCount)
+	key := make([]byte, 0, size)
+	for i := range vertexCount {
+		key = key[:0]
+		for _, s := range streams {
+			key = append(key, s.Data[i*s.Stride:(i+1)*s.Stride]...)
+		}
+		if j, ok := seen[string(key)]; ok {
+			remap[i] = j
+			continue
+		}
+		seen[string(key)] = uint32(n)
+		remap[i] = uint32(n)
+		n++
+	}
+	return
+}
+
+// RemapStream returns a copy of s.Data whose vertices
+// were moved as specified by remap.
+// n is the number of vertices in the result.
+// When multiple vertices map to the same index, the
+// last one is used.
+func RemapStream(s Stream, remap []uint32, n int) []byte {
+	dst := make([]byte, n*s.Stride)
+	for i, j := range remap {
+		if j == ^uint32(0) {
+			continue
+		}
+		copy(dst[int(j)*s.Stride:], s.Data[i*s.Stride:(i+1)*s.Stride])
+	}
+	return dst
+}
+
+// RemapIndices replaces every index i in indices with
+// remap[i].
+func RemapIndices(indices []uint32, remap []uint32) {
+	for i, x := range indices {
+		indices[i] = remap[x]
+	}
+}
+
+// OptimizeVertexFetch reorders vertices by the order in
+// which indices reference them, updating indices in
+// place.
+// It returns the remap table to apply to vertex
+// streams and the number of vertices referenced.
+// Vertices that are not referenced are mapped to
+// ^uint32(0) and thus are dropped by RemapStream.
+func OptimizeVertexFetch(indices []uint32, vertexCount int) (remap []uint32, n int) {
+	remap = make([]uint32, vertexCount)
+	for i := range remap {
+		remap[i] = ^uint32(0)
+	}
+	for i, x := range indices {
+		if remap[x] == ^uint32(0) {
+			remap[x] = uint32(n)
+			n++
+		}
+		indices[i] = remap[x]
+	}
+	return
+}
+
+// Narrow converts indices to 16-bit if every index
+// fits in 16 bits.
+// The value 0xffff is never used since some APIs treat
+// it as a primitive restart marker.
+func Narrow(indices []uint32) ([]uint16, bool) {
+	for _, x := range indices {
+		if x >= math.MaxUint16 {
+			return nil, false
+		}
+	}
+	s := make([]uint16, len(indices))
+	for i, x := range indices {
+		s[i] = uint16(x)
+	}
+	return s, true
+}
+
+// Parameters of the vertex cache optimizer.
+const (
+	// Size of the simulated cache.
+	cacheSize = 32
+	// Score of vertices used by the last
+	// emitted triangle.
+	lastTriScore = 0.75
+	cacheDecay   = 1.5
+	valenceScale = 2.0
+	valencePow   = 0.5
+)
+
+// Precomputed terms of vertexScore.
+var cacheScore, valenceScore = func() (c [cacheSize]float32, v [32]float32) {
+	for i := range c {
+		if i < 3 {
+			c[i] = lastTriScore
+		} else {
+			c[i] = float32(math.Pow(1-float64(i-3)/(cacheSize-3), cacheDecay))
+		}
+	}
+	for i := 1; i < len(v); i++ {
+		v[i] = float32(valenceScale * math.Pow(float64(i), -valencePow))
+	}
+	return
+}()
+
+// vertexScore computes the score of a vertex given its
+// position in the simulated cache (-1 if not cached) and
+// the number of triangles that still use it.
+func vertexScore(pos, remaining int) (s float32) {
+	if remaining == 0 {
+		return -1
+	}
+	if pos >= 0 {
+		s = cacheScore[pos]
+	}
+	if remaining < len(valenceScore) {
+		s += valenceScore[remaining]
+	} else {
+		s += float32(valenceScale * math.Pow(float64(remaining), -valencePow))
+	}
+	return
+}
+
+// OptimizeVertexCache reorders the triangles of a
+// triangle list to improve the hit rate of the
+// post-transform vertex cache.
+// It implements Tom Forsyth's linear-speed vertex
+// cache optimization.
+// indices are modified in place. Their length must be
+// a multiple of 3 and every index must be less than
+// vertexCount.
+func OptimizeVertexCache(indices []uint32, vertexCount int) {
+	ntri := len(indices) / 3
+	if ntri < 2 {
+		return
+	}
+
+	// Triangles that use each vertex, stored
+	// contiguously as in CSR.
+	// Emitted triangles are swapped to the end
+	// of the vertex's range.
+	first := make([]int, vertexCount+1)
+	for _, x := range indices {
+		first[x+1]++
+	}
+	for i := range vertexCount {
+		first[i+1] += first[i]
+	}
+	remaining := make([]int, vertexCount)
+	adj := make([]int, len(indices))
+	for i, x := range indices {
+		adj[first[x]+remaining[x]] = i / 3
+		remaining[x]++
+	}
+
+	pos := make([]int, vertexCount)
+	score := make([]float32, vertexCount)
+	for i := range vertexCount {
+		pos[i] = -1
+		score[i] = vertexScore(-1, remaining[i])
+	}
+	triScore := make([]float32, ntri)
+	emitted := make([]bool, ntri)
+	for i := range ntri {
+		t := indices[i*3 : i*3+3]
+		triScore[i] = score[t[0]] + score[t[1]] + score[t[2]]
+	}
+
+	out := make([]uint32, 0, len(indices))
+	var cache, next []uint32
+	best := -1
+	// Scanned linearly when the cache yields no
+	// candidate.
+	cursor := 0
+	for len(out) < len(indices) {
+		if best < 0 {
+			var bs float32 = -1
+			for ; cursor < ntri && emitted[cursor]; cursor++ {
+			}
+			for i := cursor; i < ntri; i++ {
+				if !emitted[i] && triScore[i] > bs {
+					bs, best = triScore[i], i
+				}
+			}
+		}
+		t := indices[best*3 : best*3+3]
+		out = append(out, t...)
+		emitted[best] = true
+		for _, v := range t {
+			// Move the triangle past the live range.
+			s := adj[first[v] : first[v]+remaining[v]]
+			for i, x := range s {
+				if x == best {
+					s[i], s[len(s)-1] = s[len(s)-1], s[i]
+					break
+				}
+			}
+			remaining[v]--
+		}
+
+		next = append(next[:0], t...)
+		for _, v := range cache {
+			if v != t[0] && v != t[1] && v != t[2] {
+				next = append(next, v)
+			}
+		}
+		cache, next = next, cache
+		for i, v := range cache {
+			if i < cacheSize {
+				pos[v] = i
+			} else {
+				pos[v] = -1
+			}
+			score[v] = vertexScore(pos[v], remaining[v])
+		}
+		if len(cache) > cacheSize {
+			cache = cache[:cacheSize]
+		}
+
+		best = -1
+		var bs float32 = -1
+		for _, v := range cache {
+			for _, x := range adj[first[v] : first[v]+remaining[v]] {
+				u := indices[x*3 : x*3+3]
+				s := score[u[0]] + score[u[1]] + score[u[2]]
+				triScore[x] = s
+				if s > bs {
+					bs, best = s, x
+				}
+			}
+		}
+		// Vertices evicted from the cache may
+		// have their scores changed as well, but
+		// they are not considered here; their
+		// triangles will be updated when one of
+		// their vertices enters the cache again.
+	}
+	copy(indices, out)
+}
+
+// ACMR computes the average cache miss ratio (i.e.,
+// the number of vertex transforms per triangle) of
+// the given triangle list when using a FIFO cache of
+// size entries.
+// Lower is better; the minimum is around 0.5.
+func ACMR(indices []uint32, size int) float64 {
+	if len(indices) < 3 {
+		return 0
+	}
+	var fifo []uint32
+	var miss int
+	for _, x := range indices {
+		if slices.Contains(fifo, x) {
+			continue
+		}
+		miss++
+		fifo = append(fifo, x)
+		if len(fifo) > size {
+			fifo = fifo[1:]
+		}
+	}
+	return float64(miss) / float64(len(indices)/3)
+}
@@ -0,0 +1,229 @@
+// Copyright 2024 Gustavo C. Viegas. All rights reserved.
+
+package meshopt
+
+import (
+	"encoding/binary"
+	"math"
+	"math/rand"
+	"slices"
+	"testing"
+
+	"gviegas/neo3/linear"
+)
+
+// grid creates an indexed triangle list with n*n quads.
+func grid(n int) (indices []uint32, vertexCount int) {
+	for y := range n {
+		for x := range n {
+			i := uint32(y*(n+1) + x)
+			j := i + uint32(n+1)
+			indices = append(indices, i, j, i+1, i+1, j, j+1)
+		}
+	}
+	return indices, (n + 1) * (n + 1)
+}
+
+// shuffle shuffles the triangles of a triangle list.
+func shuffle(indices []uint32, seed int64) {
+	r := rand.New(rand.NewSource(seed))
+	r.Shuffle(len(indices)/3, func(i, j int) {
+		a, b := indices[i*3:i*3+3], indices[j*3:j*3+3]
+		a[0], a[1], a[2], b[0], b[1], b[2] = b[0], b[1], b[2], a[0], a[1], a[2]
+	})
+}
+
+// triangles returns the sorted triangles of a
+// triangle list, rotated so that each starts with
+// its smallest index (this preserves winding).
+func triangles(indices []uint32) (s [][3]uint32) {
+	for i := 0; i < len(indices); i += 3 {
+		t := [3]uint32(indices[i : i+3])
+		for t[0] > t[1] || t[0] > t[2] {
+			t = [3]uint32{t[1], t[2], t[0]}
+		}
+		s = append(s, t)
+	}
+	slices.SortFunc(s, func(a, b [3]uint32) int {
+		for i := range a {
+			if a[i] != b[i] {
+				return int(a[i]) - int(b[i])
+			}
+		}
+		return 0
+	})
+	return
+}
+
+func TestWeld(t *testing.T) {
+	pos := Stream{[]byte{0, 0, 1, 1, 0, 0, 2, 2, 1, 1}, 2}
+	uv := Stream{[]byte{5, 6, 5, 6, 7}, 1}
+	remap, n := Weld(5, pos, uv)
+	want := []uint32{0, 1, 0, 2, 3}
+	if n != 4 || !slices.Equal(remap, want) {
+		t.Fatalf("Weld:\nhave %v, %d\nwant %v, 4", remap, n, want)
+	}
+	remap, n = Weld(5, pos)
+	want = []uint32{0, 1, 0, 2, 1}
+	if n != 3 || !slices.Equal(remap, want) {
+		t.Fatalf("Weld:\nhave %v, %d\nwant %v, 3", remap, n, want)
+	}
+	data := RemapStream(pos, remap, n)
+	if x := []byte{0, 0, 1, 1, 2, 2}; !slices.Equal(data, x) {
+		t.Fatalf("RemapStream:\nhave %v\nwant %v", data, x)
+	}
+	indices := []uint32{4, 3, 2, 1, 0}
+	RemapIndices(indices, remap)
+	if x := []uint32{1, 2, 0, 1, 0}; !slices.Equal(indices, x) {
+		t.Fatalf("RemapIndices:\nhave %v\nwant %v", indices, x)
+	}
+}
+
+func TestOptimizeVertexFetch(t *testing.T) {
+	indices := []uint32{4, 2, 5, 2, 5, 0}
+	remap, n := OptimizeVertexFetch(indices, 6)
+	if x := []uint32{0, 1, 2, 1, 2, 3}; !slices.Equal(indices, x) {
+		t.Fatalf("OptimizeVertexFetch: indices\nhave %v\nwant %v", indices, x)
+	}
+	none := ^uint32(0)
+	if x := []uint32{3, none, 1, none, 0, 2}; n != 4 || !slices.Equal(remap, x) {
+		t.Fatalf("OptimizeVertexFetch:\nhave %v, %d\nwant %v, 4", remap, n, x)
+	}
+	data := RemapStream(Stream{[]byte{10, 11, 12, 13, 14, 15}, 1}, remap, n)
+	if x := []byte{14, 12, 15, 10}; !slices.Equal(data, x) {
+		t.Fatalf("RemapStream:\nhave %v\nwant %v", data, x)
+	}
+}
+
+func TestNarrow(t *testing.T) {
+	s, ok := Narrow([]uint32{0, 1, 65534})
+	if !ok || !slices.Equal(s, []uint16{0, 1, 65534}) {
+		t.Fatalf("Narrow:\nhave %v, %t\nwant [0 1 65534], true", s, ok)
+	}
+	if s, ok = Narrow([]uint32{0, 65535}); ok || s != nil {
+		t.Fatalf("Narrow:\nhave %v, %t\nwant [], false", s, ok)
+	}
+}
+
+func TestOptimizeVertexCache(t *testing.T) {
+	for _, n := range [...]int{1, 2, 16, 64} {
+		indices, nv := grid(n)
+		shuffle(indices, int64(n))
+		tris := triangles(indices)
+		before := ACMR(indices, 16)
+		OptimizeVertexCache(indices, nv)
+		if x := triangles(indices); !slices.Equal(x, tris) {
+			t.Fatalf("OptimizeVertexCache(%d): triangles differ", n)
+		}
+		after := ACMR(indices, 16)
+		if n > 2 && after >= before {
+			t.Fatalf("OptimizeVertexCache(%d): ACMR\nhave %v\nwant < %v", n, after, before)
+		}
+		t.Logf("%d triangles: ACMR %.3f -> %.3f", 2*n*n, before, after)
+	}
+
+	// Disjoint triangles and a degenerate one.
+	indices := []uint32{0, 1, 2, 3, 4, 5, 6, 6, 7, 2, 1, 3}
+	tris := triangles(indices)
+	OptimizeVertexCache(indices, 8)
+	if x := triangles(indices); !slices.Equal(x, tris) {
+		t.Fatalf("OptimizeVertexCache: triangles differ\nhave %v\nwant %v", x, tris)
+	}
+}
+
+func BenchmarkOptimizeVertexCache(b *testing.B) {
+	indices, nv := grid(256)
+	shuffle(indices, 1)
+	s := make([]uint32, len(indices))
+	for b.Loop() {
+		copy(s, indices)
+		OptimizeVertexCache(s, nv)
+	}
+}
+
+// gridPos creates positions for grid(n) on the z=0
+// plane.
+func gridPos(n int) Stream {
+	var b []byte
+	for y := range n + 1 {
+		for x := range n + 1 {
+			for _, f := range [3]float32{float32(x), float32(y), 0} {
+				b = binary.LittleEndian.AppendUint32(b, math.Float32bits(f))
+			}
+		}
+	}
+	return Stream{b, 12}
+}
+
+func TestBuildMeshlets(t *testing.T) {
+	for _, x := range [...]struct{ n, nv, nt int }{
+		{1, 3, 1},
+		{1, 64, 124},
+		{8, 64, 124},
+		{32, 64, 124},
+		{32, 16, 8},
+		{32, 255, 512},
+	} {
+		indices, nv := grid(x.n)
+		OptimizeVertexCache(indices, nv)
+		pos := gridPos(x.n)
+		ms := BuildMeshlets(indices, pos, x.nv, x.nt)
+		var ntri int
+		var out []uint32
+		for i, m := range ms.Meshlets {
+			switch {
+			case m.VertexCount > x.nv, m.TriangleCount > x.nt:
+				t.Fatalf("BuildMeshlets: meshlet %d exceeds limits: %d/%d vertices, %d/%d triangles",
+					i, m.VertexCount, x.nv, m.TriangleCount, x.nt)
+			case m.TriangleOffset != ntri*3:
+				t.Fatalf("BuildMeshlets: meshlet %d TriangleOffset\nhave %d\nwant %d", i, m.TriangleOffset, ntri*3)
+			}
+			ntri += m.TriangleCount
+			verts := ms.Vertices[m.VertexOffset : m.VertexOffset+m.VertexCount]
+			for _, j := range ms.Triangles[m.TriangleOffset : m.TriangleOffset+m.TriangleCount*3] {
+				if int(j) >= m.VertexCount {
+					t.Fatalf("BuildMeshlets: meshlet %d has local index %d out of bounds", i, j)
+				}
+				v := verts[j]
+				out = append(out, v)
+				p := position(pos, v)
+				p.Sub(&p, &m.Center)
+				if p.Len() > m.Radius+1e-5 {
+					t.Fatalf("BuildMeshlets: meshlet %d: vertex %d outside bounds", i, v)
+				}
+			}
+			// Every triangle faces -z.
+			if m.ConeAxis[2] > -0.9999 || m.ConeCutoff > 1e-3 {
+				t.Fatalf("BuildMeshlets: meshlet %d cone\nhave %v, %v\nwant [0 0 -1], 0", i, m.ConeAxis, m.ConeCutoff)
+			}
+		}
+		if !slices.Equal(out, indices) {
+			t.Fatalf("BuildMeshlets(%d, %d, %d): triangles differ", x.n, x.nv, x.nt)
+		}
+		t.Logf("%d triangles, %d/%d limits: %d meshlets", len(indices)/3, x.nv, x.nt, len(ms.Meshlets))
+	}
+
+	// A cube's normals span every direction.
+	var b []byte
+	for i := range 8 {
+		for _, f := range [3]float32{float32(i & 1), float32(i >> 1 & 1), float32(i >> 2)} {
+			b = binary.LittleEndian.AppendUint32(b, math.Float32bits(f))
+		}
+	}
+	cube := []uint32{
+		0, 2, 1, 1, 2, 3, 4, 5, 6, 5, 7, 6,
+		0, 1, 4, 1, 5, 4, 2, 6, 3, 3, 6, 7,
+		0, 4, 2, 2, 4, 6, 1, 3, 5, 3, 7, 5,
+	}
+	ms := BuildMeshlets(cube, Stream{b, 12}, 64, 124)
+	if n := len(ms.Meshlets); n != 1 {
+		t.Fatalf("BuildMeshlets: len(Meshlets)\nhave %d\nwant 1", n)
+	}
+	m := ms.Meshlets[0]
+	if m.ConeCutoff != 1 || m.VertexCount != 8 || m.TriangleCount != 12 {
+		t.Fatalf("BuildMeshlets: cube meshlet\nhave %+v", m)
+	}
+	if c := (linear.V3{0.5, 0.5, 0.5}); m.Center != c {
+		t.Fatalf("BuildMeshlets: Center\nhave %v\nwant %v", m.Center, c)
+	}
+}
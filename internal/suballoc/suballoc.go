@@ -0,0 +1,165 @@
+// Copyright 2024 Gustavo C. Viegas. All rights reserved.
+
+// Package suballoc implements a suballocator that
+// manages ranges of fixed-size blocks within a larger
+// allocation (e.g., a GPU buffer).
+package suballoc
+
+import "gviegas/neo3/bitvec"
+
+// Fit is the type of allocation strategies.
+type Fit int
+
+// Allocation strategies.
+const (
+	// Use the first free range that fits.
+	FirstFit Fit = iota
+	// Like FirstFit, but start searching where the
+	// previous allocation ended.
+	NextFit
+	// Use the smallest free range that fits.
+	BestFit
+)
+
+// Word is the granularity of an Allocator's length,
+// in blocks.
+const Word = 32
+
+// Allocator is a block suballocator.
+// Allocations are ranges of contiguous blocks. Free
+// ranges that are adjacent coalesce implicitly.
+// The zero value is an empty allocator that uses
+// FirstFit.
+type Allocator struct {
+	Fit  Fit
+	bv   bitvec.V[uint32]
+	hint int
+}
+
+// Stats describes the usage of an Allocator.
+// Values are in blocks.
+type Stats struct {
+	Len  int
+	Used int
+	// Length of the largest free range.
+	LargestFree int
+	// Fraction of free blocks that are not part of
+	// the largest free range, in the [0, 1] interval.
+	Fragmentation float64
+}
+
+// Len returns the number of blocks in a.
+func (a *Allocator) Len() int { return a.bv.Len() }
+
+// Used returns the number of allocated blocks.
+func (a *Allocator) Used() int { return a.bv.Len() - a.bv.Rem() }
+
+// Allocated returns whether the block at index is
+// allocated.
+func (a *Allocator) Allocated(index int) bool { return a.bv.IsSet(index) }
+
+// Grow appends at least n free blocks to a.
+// The number of blocks appended is rounded up to a
+// multiple of Word.
+// It returns the value of a.Len prior to the call,
+// which is where the new blocks start.
+func (a *Allocator) Grow(n int) int {
+	return a.bv.Grow((n + Word - 1) / Word)
+}
+
+// Alloc allocates n contiguous blocks whose first
+// block is a multiple of align.
+// align must be a power of two; values less than 2
+// mean no alignment.
+// It returns the index of the first block, or false
+// if there is no free range large enough.
+func (a *Allocator) Alloc(n, align int) (index int, ok bool) {
+	switch {
+	case n < 1:
+		panic("suballoc: invalid allocation size")
+	case align&(align-1) != 0:
+		panic("suballoc: alignment is not a power of two")
+	}
+	if a.bv.Rem() < n {
+		return
+	}
+	switch {
+	case align > 1, a.Fit == BestFit:
+		index, ok = a.search(n, max(align, 1))
+	case a.Fit == NextFit:
+		index, ok = a.bv.SearchRangeFrom(a.hint, n)
+	default:
+		index, ok = a.bv.SearchRange(n)
+	}
+	if !ok {
+		return
+	}
+	for i := index; i < index+n; i++ {
+		a.bv.Set(i)
+	}
+	a.hint = index + n
+	return
+}
+
+// search finds a free range of n blocks aligned to
+// align, honoring a.Fit.
+func (a *Allocator) search(n, align int) (index int, ok bool) {
+	best := -1
+	for i, m := range a.bv.Runs(false) {
+		start := (i + align - 1) &^ (align - 1)
+		if start+n > i+m {
+			continue
+		}
+		switch a.Fit {
+		case BestFit:
+			if !ok || m < best {
+				index, ok, best = start, true, m
+			}
+		case NextFit:
+			if !ok || index < a.hint && start >= a.hint {
+				index, ok = start, true
+			}
+		default:
+			return start, true
+		}
+	}
+	return
+}
+
+// Free frees n blocks starting at index.
+// Freeing blocks that are not allocated has no
+// effect.
+func (a *Allocator) Free(index, n int) {
+	if index < 0 || index+n > a.bv.Len() {
+		panic("suballoc: range out of bounds")
+	}
+	for i := index; i < index+n; i++ {
+		a.bv.Unset(i)
+	}
+}
+
+// Reset frees every allocation.
+func (a *Allocator) Reset() {
+	a.bv.Clear()
+	a.hint = 0
+}
+
+// Release frees every allocation and removes every
+// block from a.
+func (a *Allocator) Release() {
+	a.bv = bitvec.V[uint32]{}
+	a.hint = 0
+}
+
+// Stats returns the current Stats of a.
+func (a *Allocator) Stats() (s Stats) {
+	s.Len = a.Len()
+	s.Used = a.Used()
+	for _, m := range a.bv.Runs(false) {
+		s.LargestFree = max(s.LargestFree, m)
+	}
+	if free := s.Len - s.Used; free > 0 {
+		s.Fragmentation = 1 - float64(s.LargestFree)/float64(free)
+	}
+	return
+}
@@ -0,0 +1,172 @@
+// Copyright 2024 Gustavo C. Viegas. All rights reserved.
+
+package suballoc
+
+import (
+	"testing"
+)
+
+func TestAllocator(t *testing.T) {
+	var a Allocator
+	if _, ok := a.Alloc(1, 1); ok {
+		t.Fatal("Allocator.Alloc: unexpected success")
+	}
+	if i := a.Grow(1); i != 0 || a.Len() != Word {
+		t.Fatalf("Allocator.Grow:\nhave %d, %d\nwant 0, %d", i, a.Len(), Word)
+	}
+	if i := a.Grow(Word + 1); i != Word || a.Len() != 3*Word {
+		t.Fatalf("Allocator.Grow:\nhave %d, %d\nwant %d, %d", i, a.Len(), Word, 3*Word)
+	}
+	alloc := func(n, align, want int) {
+		t.Helper()
+		i, ok := a.Alloc(n, align)
+		switch {
+		case want < 0 && ok:
+			t.Fatalf("Allocator.Alloc(%d, %d):\nhave %d, true\nwant _, false", n, align, i)
+		case want >= 0 && (!ok || i != want):
+			t.Fatalf("Allocator.Alloc(%d, %d):\nhave %d, %t\nwant %d, true", n, align, i, ok, want)
+		}
+	}
+	alloc(3, 1, 0)
+	alloc(3, 4, 4)
+	alloc(1, 0, 3)
+	alloc(2, 16, 16)
+	for i, x := range map[int]bool{0: true, 3: true, 4: true, 7: false, 16: true, 18: false} {
+		if a.Allocated(i) != x {
+			t.Fatalf("Allocator.Allocated(%d):\nhave %t\nwant %t", i, !x, x)
+		}
+	}
+	if n := a.Used(); n != 9 {
+		t.Fatalf("Allocator.Used:\nhave %d\nwant 9", n)
+	}
+	s := a.Stats()
+	if s.Len != 3*Word || s.Used != 9 || s.LargestFree != 3*Word-18 {
+		t.Fatalf("Allocator.Stats:\nhave %+v", s)
+	}
+
+	// Free ranges coalesce.
+	a.Free(4, 3)
+	a.Free(3, 1)
+	alloc(12, 1, 3)
+	a.Free(3, 12)
+
+	// [3, 16) and [18, 96) are free.
+	a.Fit = BestFit
+	alloc(8, 1, 3)
+	a.Free(3, 8)
+	alloc(14, 1, 18)
+	a.Free(18, 14)
+	a.Free(0, 3)
+	alloc(16, 1, 0)
+	a.Free(0, 16)
+
+	// Next fit resumes after the last allocation
+	// and wraps around when nothing fits past it.
+	a.Fit = NextFit
+	alloc(2, 1, 18)
+	alloc(2, 1, 20)
+	a.Free(18, 2)
+	alloc(2, 1, 22)
+	alloc(2, 4, 24)
+	alloc(3*Word-26, 1, 26)
+	alloc(4, 2, 0)
+	alloc(3*Word, 1, -1)
+
+	a.Reset()
+	if a.Used() != 0 || a.Len() != 3*Word {
+		t.Fatal("Allocator.Reset: unexpected state")
+	}
+	alloc(3*Word, 1, 0)
+	a.Release()
+	if a.Len() != 0 {
+		t.Fatal("Allocator.Release: unexpected state")
+	}
+}
+
+// FuzzAllocator checks that allocations never overlap,
+// are aligned and within bounds, and that Alloc only
+// fails when there is no suitable free range.
+func FuzzAllocator(f *testing.F) {
+	f.Add([]byte{0, 3, 0, 1, 2, 0, 1, 5, 2, 1, 200, 3})
+	f.Add([]byte{2, 10, 1, 4, 4, 3, 1, 1, 1, 0, 9, 9, 4, 0, 0, 2})
+	f.Fuzz(func(t *testing.T, ops []byte) {
+		var a Allocator
+		type alloc struct{ index, n int }
+		var allocs []alloc
+		// Model of the allocator.
+		var used []bool
+		fits := func(n, align int) bool {
+			for i := 0; i+n <= len(used); i += align {
+				ok := true
+				for j := i; j < i+n; j++ {
+					if used[j] {
+						ok = false
+						break
+					}
+				}
+				if ok {
+					return true
+				}
+			}
+			return false
+		}
+		for len(ops) >= 2 {
+			op, x := ops[0], int(ops[1])
+			ops = ops[2:]
+			switch op % 5 {
+			case 0:
+				a.Fit = Fit(x % 3)
+			case 1:
+				if len(used) >= 64*Word {
+					continue
+				}
+				a.Grow(x%(2*Word) + 1)
+				used = append(used, make([]bool, a.Len()-len(used))...)
+			case 2, 3:
+				n := x%(Word*2) + 1
+				align := 1 << (x / 64)
+				i, ok := a.Alloc(n, align)
+				if !ok {
+					if fits(n, align) {
+						t.Fatalf("Alloc(%d, %d) failed with free range available", n, align)
+					}
+					continue
+				}
+				if i%align != 0 || i < 0 || i+n > len(used) {
+					t.Fatalf("Alloc(%d, %d): invalid index %d", n, align, i)
+				}
+				for j := i; j < i+n; j++ {
+					if used[j] {
+						t.Fatalf("Alloc(%d, %d): overlapping range at %d", n, align, i)
+					}
+					used[j] = true
+				}
+				allocs = append(allocs, alloc{i, n})
+			case 4:
+				if len(allocs) == 0 {
+					continue
+				}
+				k := x % len(allocs)
+				y := allocs[k]
+				a.Free(y.index, y.n)
+				for j := y.index; j < y.index+y.n; j++ {
+					used[j] = false
+				}
+				allocs = append(allocs[:k], allocs[k+1:]...)
+			}
+			var n int
+			for _, y := range allocs {
+				n += y.n
+			}
+			s := a.Stats()
+			switch {
+			case s.Len != len(used) || s.Used != n:
+				t.Fatalf("Stats: Len, Used\nhave %d, %d\nwant %d, %d", s.Len, s.Used, len(used), n)
+			case s.LargestFree > s.Len-s.Used, s.Len > s.Used && s.LargestFree == 0:
+				t.Fatalf("Stats: LargestFree\nhave %d", s.LargestFree)
+			case s.Fragmentation < 0 || s.Fragmentation > 1:
+				t.Fatalf("Stats: Fragmentation\nhave %v", s.Fragmentation)
+			}
+		}
+	})
+}
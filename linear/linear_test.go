@@ -204,6 +204,52 @@ func TestM4(t *testing.T) {
 	}
 }
 
+func TestReflect(t *testing.T) {
+	var m M4
+	m.Reflect(&V4{0, 1, 0, -2})
+	for _, x := range [...][2]V4{
+		{{0, 2, 0, 1}, {0, 2, 0, 1}},
+		{{1, 3, -1, 1}, {1, 1, -1, 1}},
+		{{0, 0, 5, 1}, {0, 4, 5, 1}},
+		{{0, 1, 0, 0}, {0, -1, 0, 0}},
+	} {
+		var v V4
+		if v.Mul(&m, &x[0]); v != x[1] {
+			t.Fatalf("M4.Reflect: %v\nhave %v\nwant %v", x[0], v, x[1])
+		}
+	}
+}
+
+func TestOblique(t *testing.T) {
+	var p, m M4
+	p.Perspective(math.Pi/2, 1, 1, 100)
+	plane := V4{0, 0.6, 0.8, -4}
+	m.Oblique(&p, &plane)
+	// Points on the plane map to depth 0, and
+	// x/y/w are not affected.
+	for _, x := range [...]V4{{0, 0, 5, 1}, {2, 5, 1.25, 1}, {-3, -5, 8.75, 1}} {
+		var u, v V4
+		u.Mul(&p, &x)
+		v.Mul(&m, &x)
+		if math.Abs(float64(v[2])) > 1e-4 {
+			t.Fatalf("M4.Oblique: %v\nhave depth %v\nwant 0", x, v[2]/v[3])
+		}
+		if v[0] != u[0] || v[1] != u[1] || v[3] != u[3] {
+			t.Fatalf("M4.Oblique: %v\nhave %v\nwant x/y/w of %v", x, v, u)
+		}
+	}
+	// Points in front of the plane are visible.
+	var v V4
+	v.Mul(&m, &V4{0, 0, 10, 1})
+	if z := v[2] / v[3]; z <= 0 || z >= 1 {
+		t.Fatalf("M4.Oblique: depth\nhave %v\nwant (0, 1)", z)
+	}
+	v.Mul(&m, &V4{0, 0, 3, 1})
+	if z := v[2] / v[3]; z >= 0 {
+		t.Fatalf("M4.Oblique: depth behind plane\nhave %v\nwant < 0", z)
+	}
+}
+
 func TestQ(t *testing.T) {
 	var r Q
 	q := Q{V: V3{1, 0, 0}, R: 3}
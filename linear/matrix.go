@@ -288,6 +288,47 @@ func (m *M4) Ortho(left, right, top, bottom, znear, zfar float32) {
 	}
 }
 
+// Oblique sets m to contain the projection n with its
+// near plane replaced by plane, given in view space.
+// Points for which the dot product with plane is
+// negative are clipped.
+// n must map depth to [0, 1], and the view origin
+// must lie on the negative side of plane.
+func (m *M4) Oblique(n *M4, plane *V4) {
+	sgn := func(x float32) float32 {
+		if x < 0 {
+			return -1
+		}
+		return 1
+	}
+	var inv M4
+	inv.Invert(n)
+	// Corner of the view volume opposite
+	// to plane, which the far plane must
+	// still contain.
+	q := V4{sgn(plane[0]), sgn(plane[1]), 1, 1}
+	q.Mul(&inv, &q)
+	var c V4
+	c.Scale(1/plane.Dot(&q), plane)
+	*m = *n
+	for i := range m {
+		m[i][2] = c[i]
+	}
+}
+
+// Reflect sets m to contain a reflection across plane.
+// The plane's normal (i.e., plane[:3]) must be
+// normalized.
+func (m *M4) Reflect(plane *V4) {
+	a, b, c, d := plane[0], plane[1], plane[2], plane[3]
+	*m = M4{
+		{1 - 2*a*a, -2 * a * b, -2 * a * c},
+		{-2 * a * b, 1 - 2*b*b, -2 * b * c},
+		{-2 * a * c, -2 * b * c, 1 - 2*c*c},
+		{-2 * a * d, -2 * b * d, -2 * c * d, 1},
+	}
+}
+
 // FromM3 sets m to contain n as its upper-left and
 // {0, 0, 0, 1} as its last column/row.
 func (m *M4) FromM3(n *M3) {
@@ -6,7 +6,7 @@ package node
 import (
 	"iter"
 
-	"gviegas/neo3/internal/bitvec"
+	"gviegas/neo3/bitvec"
 	"gviegas/neo3/linear"
 )
 
@@ -248,6 +248,24 @@ func (g *Graph) Get(n Node) Interface {
 	return g.data[data].local
 }
 
+// Parent returns the parent of a given Node, or Nil
+// if n is an unconnected node.
+// It returns Nil if n is the Nil Node.
+// If n is not Nil, it must belong to g.
+func (g *Graph) Parent(n Node) Node {
+	for n != Nil {
+		// node.prev in the first child refers
+		// to its parent, and in other nodes,
+		// to the previous sibling.
+		prev := g.nodes[n-1].prev
+		if prev != Nil && g.nodes[prev-1].sub == n {
+			return prev
+		}
+		n = prev
+	}
+	return Nil
+}
+
 // World returns the world transform of a given Node.
 // When n is Nil, it returns the global world, which will
 // be equal to linear.M4{} if SetWorld was never called
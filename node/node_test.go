@@ -1300,3 +1300,35 @@ func TestIter(t *testing.T) {
 	checkDescend(n112, doneLen)
 	checkDescend(n113, 0)
 }
+
+func TestParent(t *testing.T) {
+	var g Graph
+	if p := g.Parent(Nil); p != Nil {
+		t.Fatalf("Graph.Parent(Nil):\nhave %d\nwant Nil", p)
+	}
+	n1 := g.Insert(&inode{name: "/1"}, Nil)
+	n2 := g.Insert(&inode{name: "/2"}, Nil)
+	n11 := g.Insert(&inode{name: "/1/1"}, n1)
+	n12 := g.Insert(&inode{name: "/1/2"}, n1)
+	n13 := g.Insert(&inode{name: "/1/3"}, n1)
+	n121 := g.Insert(&inode{name: "/1/2/1"}, n12)
+	n3 := g.Insert(&inode{name: "/3"}, Nil)
+	check := func(n, want Node) {
+		if p := g.Parent(n); p != want {
+			t.Fatalf("Graph.Parent(%s):\nhave %d\nwant %d", g.Get(n).(*inode).name, p, want)
+		}
+	}
+	for _, n := range [...]Node{n1, n2, n3} {
+		check(n, Nil)
+	}
+	for _, n := range [...]Node{n11, n12, n13} {
+		check(n, n1)
+	}
+	check(n121, n12)
+	g.Remove(n13)
+	g.Remove(n2)
+	check(n11, n1)
+	check(n12, n1)
+	check(n3, Nil)
+	check(n1, Nil)
+}
@@ -0,0 +1,334 @@
+// Copyright 2024 Gustavo C. Viegas. All rights reserved.
+
+package scene
+
+import (
+	"errors"
+	"iter"
+	"slices"
+	"time"
+
+	"gviegas/neo3/node"
+)
+
+// Behavior is per-frame logic attached to a node.
+// Behaviors are driven by a Behaviors runner, which
+// the game loop updates once per frame.
+type Behavior interface {
+	// Start is called once, in the first update
+	// after the behavior is attached, before any
+	// call to Update.
+	// If it fails, the behavior is detached (without
+	// a call to OnDestroy).
+	Start(c *Context) error
+	// Update is called once per frame.
+	Update(c *Context) error
+	// OnDestroy is called when a started behavior
+	// is detached, including when its node is
+	// destroyed.
+	OnDestroy(c *Context)
+}
+
+// Context is the argument of Behavior methods.
+// It is only valid during the call and must not be
+// retained.
+type Context struct {
+	// Behaviors is the runner that invoked the
+	// method. It can be used to attach and detach
+	// behaviors and to destroy nodes.
+	Behaviors *Behaviors
+	// Graph is the scene's graph.
+	Graph *node.Graph
+	// Node is the node that the behavior is
+	// attached to. It is node.Nil for behaviors
+	// that are not attached to any node.
+	Node node.Node
+	// Delta is the time elapsed since the previous
+	// update.
+	Delta time.Duration
+	// Time is the sum of all update deltas.
+	Time time.Duration
+}
+
+// attachment is a Behavior attached to a node.
+type attachment struct {
+	b       Behavior
+	n       node.Node
+	started bool
+	// Set when detached. The attachment is
+	// removed from the runner lazily.
+	gone bool
+}
+
+// Behaviors runs the Behaviors attached to the nodes
+// of a node.Graph.
+// Nodes that have behaviors attached to them should
+// be removed through Destroy, or have their behaviors
+// detached before being removed by other means.
+// A Behaviors is not safe for concurrent use.
+type Behaviors struct {
+	g     *node.Graph
+	list  []*attachment
+	nodes map[node.Node][]*attachment
+	ctx   Context
+	// Set during Update and flush, when detachment
+	// and node destruction are deferred.
+	updating bool
+	flushing bool
+	dead     []*attachment
+	doomed   []node.Node
+}
+
+// NewBehaviors creates a new Behaviors for g.
+func NewBehaviors(g *node.Graph) *Behaviors {
+	b := &Behaviors{
+		g:     g,
+		nodes: make(map[node.Node][]*attachment),
+	}
+	b.ctx.Behaviors = b
+	b.ctx.Graph = g
+	return b
+}
+
+// Graph returns the graph whose nodes b runs
+// behaviors for.
+func (b *Behaviors) Graph() *node.Graph { return b.g }
+
+// Time returns the sum of all update deltas.
+func (b *Behaviors) Time() time.Duration { return b.ctx.Time }
+
+// Len returns the number of attached behaviors.
+func (b *Behaviors) Len() (n int) {
+	for _, a := range b.list {
+		if !a.gone {
+			n++
+		}
+	}
+	return
+}
+
+// Attach attaches bh to n.
+// n can be node.Nil, in which case bh is not attached
+// to any node.
+// Behaviors are started and updated in the order they
+// are attached. bh is started in the next update.
+// The same Behavior can be attached more than once.
+// If n is not node.Nil, it must belong to b's graph.
+func (b *Behaviors) Attach(n node.Node, bh Behavior) {
+	if bh == nil {
+		panic("scene: cannot attach Behavior(nil)")
+	}
+	a := &attachment{b: bh, n: n}
+	b.list = append(b.list, a)
+	b.nodes[n] = append(b.nodes[n], a)
+}
+
+// Attached returns the behaviors attached to n,
+// in the order they were attached.
+func (b *Behaviors) Attached(n node.Node) (s []Behavior) {
+	for _, a := range b.nodes[n] {
+		if !a.gone {
+			s = append(s, a.b)
+		}
+	}
+	return
+}
+
+// Detach detaches bh from n.
+// If bh was attached more than once, only the first
+// attachment is removed. It returns false if bh is
+// not attached to n.
+// During an update, the call to OnDestroy is deferred
+// until every behavior has been updated, but bh is
+// not updated after Detach returns.
+func (b *Behaviors) Detach(n node.Node, bh Behavior) bool {
+	for _, a := range b.nodes[n] {
+		if a.b == bh && !a.gone {
+			a.gone = true
+			b.dead = append(b.dead, a)
+			b.flush()
+			return true
+		}
+	}
+	return false
+}
+
+// Destroy detaches the behaviors of n and of its
+// descendants and then removes n from the graph.
+// During an update, it is deferred until every
+// behavior has been updated (behaviors of the
+// sub-graph are still updated in the current frame).
+// n must belong to b's graph.
+func (b *Behaviors) Destroy(n node.Node) {
+	if n == node.Nil {
+		panic("scene: cannot destroy node.Nil")
+	}
+	b.doomed = append(b.doomed, n)
+	b.flush()
+}
+
+// Clear detaches every behavior.
+// It must not be called during an update.
+func (b *Behaviors) Clear() {
+	if b.updating {
+		panic("scene: Behaviors.Clear called during an update")
+	}
+	for _, a := range b.list {
+		if !a.gone {
+			a.gone = true
+			b.dead = append(b.dead, a)
+		}
+	}
+	b.flush()
+}
+
+// Update advances the time by delta and updates every
+// attached behavior, starting the ones that were not
+// started yet.
+// Behaviors attached during the update are started in
+// the next update.
+// It returns the errors of the Start and Update calls
+// that failed, joined.
+func (b *Behaviors) Update(delta time.Duration) error {
+	if b.updating {
+		panic("scene: Behaviors.Update called during an update")
+	}
+	b.updating = true
+	b.ctx.Delta = delta
+	b.ctx.Time += delta
+	var errs []error
+	n := len(b.list)
+	for _, a := range b.list[:n] {
+		if a.started || a.gone {
+			continue
+		}
+		b.ctx.Node = a.n
+		if err := a.b.Start(&b.ctx); err != nil {
+			errs = append(errs, err)
+			a.gone = true
+			continue
+		}
+		a.started = true
+	}
+	for _, a := range b.list[:n] {
+		if !a.started || a.gone {
+			continue
+		}
+		b.ctx.Node = a.n
+		if err := a.b.Update(&b.ctx); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	b.updating = false
+	b.flush()
+	return errors.Join(errs...)
+}
+
+// flush calls OnDestroy for the detached behaviors and
+// destroys the doomed nodes, unless an update or
+// another flush is in progress.
+func (b *Behaviors) flush() {
+	if b.updating || b.flushing {
+		return
+	}
+	b.flushing = true
+	defer func() { b.flushing = false }()
+	// OnDestroy may detach behaviors and destroy
+	// nodes itself.
+	for len(b.dead) > 0 || len(b.doomed) > 0 {
+		if len(b.dead) > 0 {
+			dead := b.dead
+			b.dead = nil
+			for _, a := range dead {
+				b.unlink(a)
+				b.destroy(a)
+			}
+			continue
+		}
+		doomed := b.doomed
+		b.doomed = nil
+		// Removing a node invalidates its
+		// descendants, so skip nodes that
+		// descend from other doomed nodes.
+		for i, n := range doomed {
+			if n == node.Nil {
+				continue
+			}
+			for p := b.g.Parent(n); p != node.Nil; p = b.g.Parent(p) {
+				if slices.Contains(doomed, p) {
+					doomed[i] = node.Nil
+					break
+				}
+			}
+			if j := slices.Index(doomed, n); j < i {
+				doomed[i] = node.Nil
+			}
+		}
+		for _, n := range doomed {
+			if n == node.Nil {
+				continue
+			}
+			var as []*attachment
+			for x := range b.subgraph(n) {
+				as = append(as, b.nodes[x]...)
+				delete(b.nodes, x)
+			}
+			for _, a := range as {
+				if !a.gone {
+					a.gone = true
+					b.destroy(a)
+				}
+			}
+			b.g.Remove(n)
+		}
+	}
+	b.compact()
+}
+
+// subgraph returns an iterator over n and its
+// descendants.
+func (b *Behaviors) subgraph(n node.Node) iter.Seq[node.Node] {
+	return func(yield func(node.Node) bool) {
+		if !yield(n) {
+			return
+		}
+		for x := range b.g.Descendants(n) {
+			if !yield(x) {
+				return
+			}
+		}
+	}
+}
+
+// destroy calls OnDestroy for a if it was started.
+func (b *Behaviors) destroy(a *attachment) {
+	if a.started {
+		b.ctx.Node = a.n
+		a.b.OnDestroy(&b.ctx)
+	}
+}
+
+// unlink removes a from the per-node attachments.
+func (b *Behaviors) unlink(a *attachment) {
+	s := b.nodes[a.n]
+	if i := slices.Index(s, a); i >= 0 {
+		s = slices.Delete(s, i, i+1)
+	}
+	if len(s) == 0 {
+		delete(b.nodes, a.n)
+	} else {
+		b.nodes[a.n] = s
+	}
+}
+
+// compact removes the detached behaviors from the list
+// of attachments.
+func (b *Behaviors) compact() {
+	b.list = slices.DeleteFunc(b.list, func(a *attachment) bool {
+		if a.gone {
+			b.unlink(a)
+			return true
+		}
+		return false
+	})
+}
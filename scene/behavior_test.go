@@ -0,0 +1,170 @@
+// Copyright 2024 Gustavo C. Viegas. All rights reserved.
+
+package scene
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"testing"
+	"time"
+
+	"gviegas/neo3/node"
+)
+
+// tbehavior is a Behavior that records its calls in
+// log and moves its node along the X axis at one unit
+// per second.
+type tbehavior struct {
+	name string
+	log  *[]string
+	// Optional hooks.
+	start  func(c *Context) error
+	update func(c *Context) error
+}
+
+func (b *tbehavior) Start(c *Context) error {
+	*b.log = append(*b.log, b.name+".start")
+	if b.start != nil {
+		return b.start(c)
+	}
+	return nil
+}
+
+func (b *tbehavior) Update(c *Context) error {
+	*b.log = append(*b.log, fmt.Sprintf("%s.update(%v)", b.name, c.Delta))
+	if n, ok := c.Graph.Get(c.Node).(*tnode); ok {
+		m := translate(n.local[3][0]+float32(c.Delta.Seconds()), 0, 0)
+		n.SetLocal(&m)
+	}
+	if b.update != nil {
+		return b.update(c)
+	}
+	return nil
+}
+
+func (b *tbehavior) OnDestroy(c *Context) {
+	*b.log = append(*b.log, b.name+".destroy")
+}
+
+// checkLog checks and then clears log.
+func checkLog(t *testing.T, log *[]string, want ...string) {
+	t.Helper()
+	if !slices.Equal(*log, want) {
+		t.Fatalf("Behavior calls:\nhave %v\nwant %v", *log, want)
+	}
+	*log = (*log)[:0]
+}
+
+func TestBehaviors(t *testing.T) {
+	var g node.Graph
+	var log []string
+	b := NewBehaviors(&g)
+	n := g.Insert(newTNode("n", 0), node.Nil)
+	x := &tbehavior{name: "x", log: &log}
+	y := &tbehavior{name: "y", log: &log}
+	glob := &tbehavior{name: "g", log: &log}
+	b.Attach(n, x)
+	b.Attach(node.Nil, glob)
+	b.Attach(n, y)
+	if b.Len() != 3 || !slices.Equal(b.Attached(n), []Behavior{x, y}) {
+		t.Fatalf("Behaviors.Attached:\nhave %v\nwant [x y]", b.Attached(n))
+	}
+	checkLog(t, &log)
+
+	if err := b.Update(time.Second); err != nil {
+		t.Fatalf("Behaviors.Update failed:\n%v", err)
+	}
+	checkLog(t, &log, "x.start", "g.start", "y.start", "x.update(1s)", "g.update(1s)", "y.update(1s)")
+	if err := b.Update(time.Second / 2); err != nil {
+		t.Fatalf("Behaviors.Update failed:\n%v", err)
+	}
+	checkLog(t, &log, "x.update(500ms)", "g.update(500ms)", "y.update(500ms)")
+	g.Update()
+	if w := g.World(n); *w != translate(3, 0, 0) {
+		t.Fatalf("Graph.World:\nhave %v\nwant %v", *w, translate(3, 0, 0))
+	}
+	if tm := b.Time(); tm != 1500*time.Millisecond {
+		t.Fatalf("Behaviors.Time:\nhave %v\nwant 1.5s", tm)
+	}
+
+	if !b.Detach(n, x) || b.Detach(n, x) {
+		t.Fatal("Behaviors.Detach: unexpected result")
+	}
+	checkLog(t, &log, "x.destroy")
+	b.Update(time.Second)
+	checkLog(t, &log, "g.update(1s)", "y.update(1s)")
+
+	// Detaching and destroying during an update
+	// are deferred.
+	c := g.Insert(newTNode("c", 0), n)
+	z := &tbehavior{name: "z", log: &log}
+	b.Attach(c, z)
+	w := &tbehavior{name: "w", log: &log}
+	glob.update = func(c *Context) error {
+		c.Behaviors.Destroy(n)
+		c.Behaviors.Detach(node.Nil, glob)
+		c.Behaviors.Attach(node.Nil, w)
+		return nil
+	}
+	b.Update(time.Second)
+	checkLog(t, &log, "z.start", "g.update(1s)", "y.update(1s)", "z.update(1s)", "g.destroy", "y.destroy", "z.destroy")
+	if g.Len() != 0 || b.Len() != 1 || len(b.Attached(n)) != 0 || len(b.Attached(c)) != 0 {
+		t.Fatalf("Behaviors.Destroy: %d nodes, %d behaviors", g.Len(), b.Len())
+	}
+	b.Update(time.Second)
+	checkLog(t, &log, "w.start", "w.update(1s)")
+
+	// Failed starts detach behaviors.
+	e := &tbehavior{name: "e", log: &log, start: func(*Context) error { return errors.New("e") }}
+	u := &tbehavior{name: "u", log: &log, update: func(*Context) error { return errors.New("u") }}
+	b.Attach(node.Nil, e)
+	b.Attach(node.Nil, u)
+	if err := b.Update(time.Second); err == nil || err.Error() != "e\nu" {
+		t.Fatalf("Behaviors.Update:\nhave %v\nwant e, u", err)
+	}
+	checkLog(t, &log, "e.start", "u.start", "w.update(1s)", "u.update(1s)")
+	if !slices.Equal(b.Attached(node.Nil), []Behavior{w, u}) {
+		t.Fatalf("Behaviors.Attached:\nhave %v\nwant [w u]", b.Attached(node.Nil))
+	}
+
+	b.Clear()
+	checkLog(t, &log, "w.destroy", "u.destroy")
+	if b.Len() != 0 {
+		t.Fatalf("Behaviors.Len:\nhave %d\nwant 0", b.Len())
+	}
+}
+
+func TestBehaviorsDestroy(t *testing.T) {
+	var g node.Graph
+	var log []string
+	b := NewBehaviors(&g)
+	a := g.Insert(newTNode("a", 0), node.Nil)
+	c := g.Insert(newTNode("c", 0), a)
+	d := g.Insert(newTNode("d", 0), c)
+	e := g.Insert(newTNode("e", 0), node.Nil)
+	for i, n := range [...]node.Node{a, c, d, e} {
+		b.Attach(n, &tbehavior{name: string(rune('a' + i)), log: &log})
+	}
+	b.Update(0)
+	log = log[:0]
+	// Nodes that descend from other doomed nodes
+	// must not be removed twice.
+	b.Attach(node.Nil, &tbehavior{name: "k", log: &log, update: func(x *Context) error {
+		x.Behaviors.Destroy(d)
+		x.Behaviors.Destroy(a)
+		x.Behaviors.Destroy(d)
+		return nil
+	}})
+	b.Update(0)
+	checkLog(t, &log, "k.start", "a.update(0s)", "b.update(0s)", "c.update(0s)", "d.update(0s)", "k.update(0s)",
+		"a.destroy", "b.destroy", "c.destroy")
+	if g.Len() != 1 || b.Len() != 2 {
+		t.Fatalf("Behaviors.Destroy: %d nodes, %d behaviors", g.Len(), b.Len())
+	}
+	b.Destroy(e)
+	checkLog(t, &log, "d.destroy")
+	if g.Len() != 0 {
+		t.Fatalf("Behaviors.Destroy: %d nodes", g.Len())
+	}
+}
@@ -0,0 +1,508 @@
+// Copyright 2024 Gustavo C. Viegas. All rights reserved.
+
+package scene
+
+import (
+	"errors"
+	"slices"
+
+	"gviegas/neo3/linear"
+	"gviegas/neo3/node"
+)
+
+// Command is a reversible mutation.
+// Commands are executed through an Editor, which
+// records them for undoing and redoing.
+// Implementations can be used to make mutations of
+// other registries (e.g., the materials of engine
+// drawables) part of the same history as mutations
+// of the graph.
+type Command interface {
+	// Do performs the mutation. It is called when
+	// the command is first executed and when it is
+	// redone.
+	Do() error
+	// Undo reverts the mutation.
+	Undo() error
+}
+
+// LocalSetter is implemented by node.Interface types
+// whose local transform can be set by an Editor.
+type LocalSetter interface {
+	node.Interface
+	SetLocal(local *linear.M4)
+}
+
+// Ref is a stable reference to a node in the graph of
+// an Editor.
+// Removing a node and undoing the removal inserts the
+// node into the graph again, which gives it a new
+// node.Node value. A Ref follows such changes.
+type Ref struct{ n node.Node }
+
+// Node returns the node that r refers to, or node.Nil
+// if the node is not in the graph.
+func (r *Ref) Node() node.Node { return r.n }
+
+// Editor mutates a node.Graph through Commands,
+// recording them in an undo history.
+// Mutations of the graph made other than through the
+// Editor invalidate the Refs of the nodes involved.
+// An Editor is not safe for concurrent use.
+type Editor struct {
+	g      *node.Graph
+	refs   map[node.Node]*Ref
+	done   []Command
+	undone []Command
+	limit  int
+	// Commands of the current transaction.
+	tx    []Command
+	depth int
+}
+
+// NewEditor creates a new Editor for g that records
+// at most limit commands (transactions count as one
+// command). If limit is not positive, the history is
+// unbounded.
+func NewEditor(g *node.Graph, limit int) *Editor {
+	return &Editor{
+		g:     g,
+		refs:  make(map[node.Node]*Ref),
+		limit: limit,
+	}
+}
+
+// Graph returns the graph that e mutates.
+func (e *Editor) Graph() *node.Graph { return e.g }
+
+// Ref returns a reference to n.
+// It returns the same Ref for the same node until the
+// node is removed from the graph.
+// n must belong to e's graph.
+// It returns nil if n is node.Nil.
+func (e *Editor) Ref(n node.Node) *Ref {
+	if n == node.Nil {
+		return nil
+	}
+	r, ok := e.refs[n]
+	if !ok {
+		r = &Ref{n}
+		e.refs[n] = r
+	}
+	return r
+}
+
+// valid returns whether r refers to a node in the
+// graph. A nil r is valid.
+func (e *Editor) valid(r *Ref) bool { return r == nil || r.n != node.Nil && e.refs[r.n] == r }
+
+// errInvalidRef is returned when a Ref does not refer
+// to a node in the graph.
+var errInvalidRef = errors.New("scene: Ref not in graph")
+
+// Do executes c and records it.
+// If c fails, it is not recorded.
+// It clears the redo history.
+func (e *Editor) Do(c Command) error {
+	if err := c.Do(); err != nil {
+		return err
+	}
+	e.record(c)
+	return nil
+}
+
+// record records c as done.
+func (e *Editor) record(c Command) {
+	if e.depth > 0 {
+		e.tx = append(e.tx, c)
+		return
+	}
+	e.undone = e.undone[:0]
+	e.done = append(e.done, c)
+	if e.limit > 0 && len(e.done) > e.limit {
+		e.done = slices.Delete(e.done, 0, len(e.done)-e.limit)
+	}
+}
+
+// Begin begins a transaction.
+// Commands executed until the matching call to Commit
+// are undone and redone as a single command.
+// Transactions can be nested, in which case only the
+// outermost transaction is recorded.
+func (e *Editor) Begin() { e.depth++ }
+
+// Commit commits the current transaction.
+// Empty transactions are not recorded.
+func (e *Editor) Commit() {
+	if e.depth == 0 {
+		panic("scene: Editor.Commit called without Begin")
+	}
+	if e.depth--; e.depth > 0 || len(e.tx) == 0 {
+		return
+	}
+	tx := txCommand(slices.Clone(e.tx))
+	e.tx = e.tx[:0]
+	e.record(tx)
+}
+
+// Rollback undoes the commands of the current
+// transaction and ends it.
+// If nested, it ends every transaction.
+func (e *Editor) Rollback() error {
+	if e.depth == 0 {
+		panic("scene: Editor.Rollback called without Begin")
+	}
+	e.depth = 0
+	tx := txCommand(e.tx)
+	e.tx = nil
+	return tx.Undo()
+}
+
+// InTransaction returns whether a transaction is in
+// progress.
+func (e *Editor) InTransaction() bool { return e.depth > 0 }
+
+// CanUndo returns whether there is a command to undo.
+func (e *Editor) CanUndo() bool { return len(e.done) > 0 }
+
+// CanRedo returns whether there is a command to redo.
+func (e *Editor) CanRedo() bool { return len(e.undone) > 0 }
+
+// Undo undoes the last command that was done.
+// If it fails, the command remains in the history.
+// It must not be called during a transaction.
+func (e *Editor) Undo() error {
+	if e.depth > 0 {
+		panic("scene: Editor.Undo called during a transaction")
+	}
+	if len(e.done) == 0 {
+		return errors.New("scene: nothing to undo")
+	}
+	c := e.done[len(e.done)-1]
+	if err := c.Undo(); err != nil {
+		return err
+	}
+	e.done = e.done[:len(e.done)-1]
+	e.undone = append(e.undone, c)
+	return nil
+}
+
+// Redo redoes the last command that was undone.
+// If it fails, the command remains in the history.
+// It must not be called during a transaction.
+func (e *Editor) Redo() error {
+	if e.depth > 0 {
+		panic("scene: Editor.Redo called during a transaction")
+	}
+	if len(e.undone) == 0 {
+		return errors.New("scene: nothing to redo")
+	}
+	c := e.undone[len(e.undone)-1]
+	if err := c.Do(); err != nil {
+		return err
+	}
+	e.undone = e.undone[:len(e.undone)-1]
+	e.done = append(e.done, c)
+	return nil
+}
+
+// Clear clears the history.
+func (e *Editor) Clear() {
+	clear(e.done)
+	clear(e.undone)
+	e.done = e.done[:0]
+	e.undone = e.undone[:0]
+}
+
+// txCommand is a Command made of other commands.
+type txCommand []Command
+
+func (t txCommand) Do() error {
+	for i, c := range t {
+		if err := c.Do(); err != nil {
+			// Keep the transaction atomic.
+			txCommand(t[:i]).Undo()
+			return err
+		}
+	}
+	return nil
+}
+
+func (t txCommand) Undo() error {
+	for i := len(t) - 1; i >= 0; i-- {
+		if err := t[i].Undo(); err != nil {
+			txCommand(t[i+1:]).Do()
+			return err
+		}
+	}
+	return nil
+}
+
+// subtree is a sub-graph detached from the graph.
+type subtree struct {
+	nodes []subtreeNode
+}
+
+// subtreeNode is a node of a subtree.
+type subtreeNode struct {
+	iface node.Interface
+	ref   *Ref
+	// Index of the parent in subtree.nodes, or -1
+	// for the root.
+	parent int
+}
+
+// detach removes the sub-graph rooted at r from the
+// graph. The nodes' Refs refer to node.Nil until the
+// sub-graph is attached again.
+func (e *Editor) detach(r *Ref) *subtree {
+	st := &subtree{nodes: []subtreeNode{{e.g.Get(r.n), r, -1}}}
+	idx := map[node.Node]int{r.n: 0}
+	for n := range e.g.Descendants(r.n) {
+		idx[n] = len(st.nodes)
+		st.nodes = append(st.nodes, subtreeNode{e.g.Get(n), e.Ref(n), idx[e.g.Parent(n)]})
+	}
+	e.g.Remove(r.n)
+	for _, x := range st.nodes {
+		if n, ok := x.iface.(*InstanceNode); ok {
+			delete(n.inst.nodes, n.id)
+		}
+		delete(e.refs, x.ref.n)
+		x.ref.n = node.Nil
+	}
+	return st
+}
+
+// attach inserts st into the graph as a descendant of
+// parent, which can be nil.
+// Since node.Graph.Update only recomputes the world
+// transforms of nodes that have changed, these are
+// computed here.
+func (e *Editor) attach(st *subtree, parent *Ref) {
+	var insert func(i int, prev node.Node)
+	insert = func(i int, prev node.Node) {
+		x := &st.nodes[i]
+		n := e.g.Insert(x.iface, prev)
+		x.ref.n = n
+		e.refs[n] = x.ref
+		if in, ok := x.iface.(*InstanceNode); ok {
+			in.inst.nodes[in.id] = n
+		}
+		pw := e.g.World(prev)
+		if prev == node.Nil && *pw == (linear.M4{}) {
+			// The global world transform
+			// was never set.
+			*e.g.World(n) = *x.iface.Local()
+		} else {
+			e.g.World(n).Mul(pw, x.iface.Local())
+		}
+		// Children are prepended, so inserting
+		// them in reverse preserves their order.
+		for j := len(st.nodes) - 1; j > i; j-- {
+			if st.nodes[j].parent == i {
+				insert(j, n)
+			}
+		}
+	}
+	var prev node.Node
+	if parent != nil {
+		prev = parent.n
+	}
+	insert(0, prev)
+}
+
+// insertCmd is the Command of Editor.Insert.
+type insertCmd struct {
+	e      *Editor
+	st     *subtree
+	parent *Ref
+}
+
+func (c *insertCmd) Do() error {
+	if !c.e.valid(c.parent) {
+		return errInvalidRef
+	}
+	c.e.attach(c.st, c.parent)
+	return nil
+}
+
+func (c *insertCmd) Undo() error {
+	if !c.e.valid(c.st.nodes[0].ref) {
+		return errInvalidRef
+	}
+	c.st = c.e.detach(c.st.nodes[0].ref)
+	return nil
+}
+
+// Insert inserts n into the graph as a descendant of
+// parent.
+// parent can be nil, in which case n is inserted as
+// an unconnected node.
+// It returns a Ref to the new node.
+func (e *Editor) Insert(n node.Interface, parent *Ref) (*Ref, error) {
+	r := new(Ref)
+	c := &insertCmd{e, &subtree{[]subtreeNode{{n, r, -1}}}, parent}
+	if err := e.Do(c); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// Remove removes the node that r refers to from the
+// graph, along with its descendants.
+// Undoing the removal inserts the nodes again as the
+// first child of their former parent.
+// Nodes of prefab instances that are removed will be
+// inserted again if the instance is synchronized
+// (see Instance.Sync).
+func (e *Editor) Remove(r *Ref) error {
+	if r == nil || !e.valid(r) {
+		return errInvalidRef
+	}
+	// Removal is the inverse of insertion.
+	parent := e.Ref(e.g.Parent(r.n))
+	c := &insertCmd{e, &subtree{[]subtreeNode{{nil, r, -1}}}, parent}
+	if err := c.Undo(); err != nil {
+		return err
+	}
+	e.record(inverse{c})
+	return nil
+}
+
+// inverse is a Command that reverses another.
+type inverse struct{ c Command }
+
+func (c inverse) Do() error   { return c.c.Undo() }
+func (c inverse) Undo() error { return c.c.Do() }
+
+// moveCmd is the Command of Editor.Move.
+type moveCmd struct {
+	e        *Editor
+	r        *Ref
+	from, to *Ref
+}
+
+func (c *moveCmd) move(to *Ref) error {
+	if !c.e.valid(c.r) || !c.e.valid(to) {
+		return errInvalidRef
+	}
+	c.e.attach(c.e.detach(c.r), to)
+	return nil
+}
+
+func (c *moveCmd) Do() error   { return c.move(c.to) }
+func (c *moveCmd) Undo() error { return c.move(c.from) }
+
+// Move makes the node that r refers to a descendant of
+// parent, keeping its descendants.
+// parent can be nil, in which case the node becomes
+// an unconnected node. It must not be the node itself
+// nor one of its descendants.
+// The node's local transform is not changed.
+func (e *Editor) Move(r, parent *Ref) error {
+	if r == nil || !e.valid(r) || !e.valid(parent) {
+		return errInvalidRef
+	}
+	if parent != nil {
+		for n := parent.n; n != node.Nil; n = e.g.Parent(n) {
+			if n == r.n {
+				return errors.New("scene: cannot move a node into its own sub-graph")
+			}
+		}
+	}
+	return e.Do(&moveCmd{e, r, e.Ref(e.g.Parent(r.n)), parent})
+}
+
+// localCmd is the Command of Editor.SetLocal.
+type localCmd struct {
+	e        *Editor
+	r        *Ref
+	old, new linear.M4
+	// Whether old was an override of an
+	// InstanceNode.
+	overridden bool
+}
+
+func (c *localCmd) set(local *linear.M4, override bool) error {
+	if !c.e.valid(c.r) {
+		return errInvalidRef
+	}
+	switch n := c.e.g.Get(c.r.n).(type) {
+	case *InstanceNode:
+		if override {
+			n.inst.SetLocal(n.id, local)
+		} else {
+			n.inst.ClearLocal(n.id)
+		}
+	case LocalSetter:
+		n.SetLocal(local)
+	}
+	return nil
+}
+
+func (c *localCmd) Do() error   { return c.set(&c.new, true) }
+func (c *localCmd) Undo() error { return c.set(&c.old, c.overridden) }
+
+// SetLocal sets the local transform of the node that r
+// refers to.
+// The node must be an *InstanceNode, whose instance's
+// override is set, or implement LocalSetter.
+func (e *Editor) SetLocal(r *Ref, local *linear.M4) error {
+	if r == nil || !e.valid(r) {
+		return errInvalidRef
+	}
+	c := &localCmd{e: e, r: r, new: *local, overridden: true}
+	switch n := e.g.Get(r.n).(type) {
+	case *InstanceNode:
+		c.old, c.overridden = n.inst.locals[n.id]
+	case LocalSetter:
+		c.old = *n.Local()
+	default:
+		return errors.New("scene: node's local transform cannot be set")
+	}
+	return e.Do(c)
+}
+
+// propCmd is the Command of Editor.SetProp.
+type propCmd struct {
+	e        *Editor
+	r        *Ref
+	key      propKey
+	old, new string
+	// Whether old was an override.
+	overridden bool
+}
+
+func (c *propCmd) set(value string, override bool) error {
+	if !c.e.valid(c.r) {
+		return errInvalidRef
+	}
+	n := c.e.g.Get(c.r.n).(*InstanceNode)
+	if override {
+		n.inst.SetProp(n.id, c.key.typ, c.key.key, value)
+	} else {
+		n.inst.ClearProp(n.id, c.key.typ, c.key.key)
+	}
+	return nil
+}
+
+func (c *propCmd) Do() error   { return c.set(c.new, true) }
+func (c *propCmd) Undo() error { return c.set(c.old, c.overridden) }
+
+// SetProp overrides a property of the component of
+// type typ of the node that r refers to (e.g., to
+// assign a material).
+// The node must be an *InstanceNode.
+func (e *Editor) SetProp(r *Ref, typ, key, value string) error {
+	if r == nil || !e.valid(r) {
+		return errInvalidRef
+	}
+	n, ok := e.g.Get(r.n).(*InstanceNode)
+	if !ok {
+		return errors.New("scene: node has no component properties")
+	}
+	k := propKey{n.id, typ, key}
+	c := &propCmd{e: e, r: r, key: k, new: value}
+	c.old, c.overridden = n.inst.props[k]
+	return e.Do(c)
+}
@@ -0,0 +1,301 @@
+// Copyright 2024 Gustavo C. Viegas. All rights reserved.
+
+package scene
+
+import (
+	"errors"
+	"slices"
+	"testing"
+
+	"gviegas/neo3/linear"
+	"gviegas/neo3/node"
+)
+
+// tnode is a LocalSetter for testing.
+type tnode struct {
+	name    string
+	local   linear.M4
+	changed bool
+}
+
+func newTNode(name string, x float32) *tnode {
+	return &tnode{name: name, local: translate(x, 0, 0), changed: true}
+}
+
+func (n *tnode) Local() *linear.M4 { return &n.local }
+
+func (n *tnode) SetLocal(local *linear.M4) {
+	n.local = *local
+	n.changed = true
+}
+
+func (n *tnode) Changed() bool {
+	c := n.changed
+	n.changed = false
+	return c
+}
+
+// names returns the names of the children of n, or of
+// the unconnected nodes if n is node.Nil, in order.
+func names(g *node.Graph, n node.Node) (s []string) {
+	for x := range g.All() {
+		if g.Parent(x) == n {
+			s = append(s, g.Get(x).(*tnode).name)
+		}
+	}
+	return
+}
+
+// checkNames checks the names of the children of n.
+func checkNames(t *testing.T, g *node.Graph, n node.Node, want ...string) {
+	t.Helper()
+	if s := names(g, n); !slices.Equal(s, want) {
+		t.Fatalf("children of %d:\nhave %v\nwant %v", n, s, want)
+	}
+}
+
+// checkWorld checks that the world transform of r is
+// a translation along the X axis by x.
+func checkWorld(t *testing.T, g *node.Graph, r *Ref, x float32) {
+	t.Helper()
+	if w := g.World(r.Node()); *w != translate(x, 0, 0) {
+		t.Fatalf("Graph.World:\nhave %v\nwant x = %v", *w, x)
+	}
+}
+
+func TestEditor(t *testing.T) {
+	var g node.Graph
+	e := NewEditor(&g, 0)
+	if e.CanUndo() || e.CanRedo() {
+		t.Fatal("Editor: unexpected history")
+	}
+	a, _ := e.Insert(newTNode("a", 1), nil)
+	b, _ := e.Insert(newTNode("b", 2), a)
+	c, _ := e.Insert(newTNode("c", 4), a)
+	d, err := e.Insert(newTNode("d", 8), c)
+	if err != nil {
+		t.Fatalf("Editor.Insert failed:\n%v", err)
+	}
+	g.Update()
+	checkNames(t, &g, a.Node(), "c", "b")
+	checkWorld(t, &g, d, 13)
+
+	// Removal and its undoing keep the
+	// sub-graph and the Refs.
+	if err := e.Remove(c); err != nil {
+		t.Fatalf("Editor.Remove failed:\n%v", err)
+	}
+	if c.Node() != node.Nil || d.Node() != node.Nil || g.Len() != 2 {
+		t.Fatalf("Editor.Remove: %d, %d, %d nodes", c.Node(), d.Node(), g.Len())
+	}
+	if err := e.Remove(d); err == nil {
+		t.Fatal("Editor.Remove: unexpected success")
+	}
+	if err := e.Undo(); err != nil {
+		t.Fatalf("Editor.Undo failed:\n%v", err)
+	}
+	checkNames(t, &g, a.Node(), "c", "b")
+	checkNames(t, &g, c.Node(), "d")
+	checkWorld(t, &g, d, 13)
+	if e.Ref(d.Node()) != d {
+		t.Fatal("Editor.Ref: Ref not preserved")
+	}
+
+	// Move.
+	if err := e.Move(a, d); err == nil {
+		t.Fatal("Editor.Move: unexpected success")
+	}
+	if err := e.Move(c, b); err != nil {
+		t.Fatalf("Editor.Move failed:\n%v", err)
+	}
+	checkNames(t, &g, b.Node(), "c")
+	checkWorld(t, &g, d, 15)
+	if err := e.Move(d, nil); err != nil {
+		t.Fatalf("Editor.Move failed:\n%v", err)
+	}
+	checkNames(t, &g, node.Nil, "d", "a")
+	checkWorld(t, &g, d, 8)
+
+	// SetLocal.
+	m := translate(16, 0, 0)
+	if err := e.SetLocal(a, &m); err != nil {
+		t.Fatalf("Editor.SetLocal failed:\n%v", err)
+	}
+	g.Update()
+	checkWorld(t, &g, c, 22)
+
+	for _, x := range [...]float32{8, 15, 13} {
+		if err := e.Undo(); err != nil {
+			t.Fatalf("Editor.Undo failed:\n%v", err)
+		}
+		g.Update()
+		checkWorld(t, &g, d, x)
+	}
+	// Only insertions remain.
+	for e.CanUndo() {
+		e.Undo()
+	}
+	if g.Len() != 0 || a.Node() != node.Nil {
+		t.Fatalf("Editor.Undo: %d nodes", g.Len())
+	}
+	if err := e.Undo(); err == nil {
+		t.Fatal("Editor.Undo: unexpected success")
+	}
+	for e.CanRedo() {
+		if err := e.Redo(); err != nil {
+			t.Fatalf("Editor.Redo failed:\n%v", err)
+		}
+	}
+	g.Update()
+	checkNames(t, &g, node.Nil, "d", "a")
+	checkNames(t, &g, b.Node(), "c")
+	checkWorld(t, &g, c, 22)
+	checkWorld(t, &g, d, 8)
+
+	// New commands clear the redo history.
+	e.Undo()
+	e.Insert(newTNode("e", 0), nil)
+	if e.CanRedo() {
+		t.Fatal("Editor.CanRedo: unexpected redo history")
+	}
+}
+
+func TestEditorTransaction(t *testing.T) {
+	var g node.Graph
+	e := NewEditor(&g, 2)
+	a, _ := e.Insert(newTNode("a", 1), nil)
+	e.Begin()
+	b, _ := e.Insert(newTNode("b", 2), a)
+	e.Begin()
+	m := translate(3, 0, 0)
+	e.SetLocal(a, &m)
+	e.Commit()
+	e.Insert(newTNode("c", 4), b)
+	e.Commit()
+	if n := len(e.done); n != 2 {
+		t.Fatalf("Editor: history\nhave %d\nwant 2", n)
+	}
+	if err := e.Undo(); err != nil {
+		t.Fatalf("Editor.Undo failed:\n%v", err)
+	}
+	if g.Len() != 1 || *g.Get(a.Node()).Local() != translate(1, 0, 0) {
+		t.Fatalf("Editor.Undo: transaction not undone")
+	}
+	e.Redo()
+	if g.Len() != 3 || *g.Get(a.Node()).Local() != translate(3, 0, 0) {
+		t.Fatalf("Editor.Redo: transaction not redone")
+	}
+
+	e.Begin()
+	e.Remove(b)
+	e.SetLocal(a, &linear.M4{})
+	if err := e.Rollback(); err != nil {
+		t.Fatalf("Editor.Rollback failed:\n%v", err)
+	}
+	if e.InTransaction() || g.Len() != 3 || *g.Get(a.Node()).Local() != translate(3, 0, 0) {
+		t.Fatal("Editor.Rollback: transaction not undone")
+	}
+
+	// The history is limited.
+	e.Insert(newTNode("d", 0), nil)
+	e.Insert(newTNode("e", 0), nil)
+	e.Undo()
+	e.Undo()
+	if e.CanUndo() {
+		t.Fatal("Editor.CanUndo: history not limited")
+	}
+}
+
+// failCmd is a Command that fails when fail is set.
+type failCmd struct {
+	n    *int
+	fail bool
+}
+
+func (c *failCmd) Do() error {
+	if c.fail {
+		return errors.New("fail")
+	}
+	*c.n++
+	return nil
+}
+
+func (c *failCmd) Undo() error {
+	if c.fail {
+		return errors.New("fail")
+	}
+	*c.n--
+	return nil
+}
+
+func TestEditorCommand(t *testing.T) {
+	var g node.Graph
+	e := NewEditor(&g, 0)
+	var n int
+	x, y := &failCmd{n: &n}, &failCmd{n: &n}
+	e.Begin()
+	e.Do(x)
+	e.Do(y)
+	e.Commit()
+	if n != 2 {
+		t.Fatalf("Editor.Do:\nhave %d\nwant 2", n)
+	}
+	y.fail = true
+	if err := e.Undo(); err == nil || n != 2 {
+		t.Fatalf("Editor.Undo: have %v, %d\nwant error, 2", err, n)
+	}
+	y.fail = false
+	e.Undo()
+	x.fail = true
+	if err := e.Redo(); err == nil || n != 0 {
+		t.Fatalf("Editor.Redo: have %v, %d\nwant error, 0", err, n)
+	}
+	if err := e.Do(x); err == nil || e.CanUndo() {
+		t.Fatal("Editor.Do: failed command recorded")
+	}
+}
+
+func TestEditorInstance(t *testing.T) {
+	var g node.Graph
+	p, trunk, crown, bird := newTestPrefab()
+	i := p.Instantiate(&g, node.Nil, nil)
+	e := NewEditor(&g, 0)
+	r := e.Ref(i.Node(trunk))
+	if err := e.SetProp(r, "mesh", "material", "bark"); err != nil {
+		t.Fatalf("Editor.SetProp failed:\n%v", err)
+	}
+	if c, _ := instNode(t, &g, i, trunk).Component("mesh"); c.Props["material"] != "bark" {
+		t.Fatalf("InstanceNode.Component:\nhave %v\nwant material bark", c)
+	}
+	e.SetProp(r, "mesh", "material", "moss")
+	m := translate(0, 9, 0)
+	e.SetLocal(e.Ref(i.Node(crown)), &m)
+	g.Update()
+	if w := g.World(i.Node(bird)); *w != translate(0, 10, 0) {
+		t.Fatalf("Graph.World:\nhave %v\nwant %v", *w, translate(0, 10, 0))
+	}
+	e.Remove(e.Ref(i.Node(crown)))
+	if i.Node(bird) != node.Nil {
+		t.Fatal("Editor.Remove: Instance.Node not updated")
+	}
+	e.Undo()
+	if w := g.World(i.Node(bird)); *w != translate(0, 10, 0) {
+		t.Fatalf("Graph.World:\nhave %v\nwant %v", *w, translate(0, 10, 0))
+	}
+	e.Undo()
+	g.Update()
+	if w := g.World(i.Node(bird)); *w != translate(0, 3, 0) {
+		t.Fatalf("Graph.World:\nhave %v\nwant %v", *w, translate(0, 3, 0))
+	}
+	e.Undo()
+	if c, _ := instNode(t, &g, i, trunk).Component("mesh"); c.Props["material"] != "bark" {
+		t.Fatalf("InstanceNode.Component:\nhave %v\nwant material bark", c)
+	}
+	e.Undo()
+	if c, _ := instNode(t, &g, i, trunk).Component("mesh"); c.Props["material"] != "" {
+		t.Fatalf("InstanceNode.Component:\nhave %v\nwant no material", c)
+	}
+	if err := e.SetProp(e.Ref(g.Insert(newTNode("x", 0), node.Nil)), "mesh", "material", "x"); err == nil {
+		t.Fatal("Editor.SetProp: unexpected success")
+	}
+}
@@ -0,0 +1,518 @@
+// Copyright 2024 Gustavo C. Viegas. All rights reserved.
+
+package scene
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"maps"
+	"slices"
+	"strconv"
+
+	"gviegas/neo3/linear"
+	"gviegas/neo3/node"
+)
+
+// PrefabNode identifies a node in a Prefab.
+// PrefabNode values are never reused by a Prefab, and
+// are preserved by EncodePrefab/DecodePrefab, so they
+// can be used to refer to nodes across edits and in
+// serialized overrides.
+type PrefabNode int
+
+// Component is data attached to a node of a Prefab.
+// Type identifies the kind of component (e.g., "mesh"
+// or "light"), which defines how Props are
+// interpreted by the application. A node has at most
+// one component of each Type.
+type Component struct {
+	Type  string            `json:"type"`
+	Props map[string]string `json:"props,omitempty"`
+}
+
+// clone returns a deep copy of c.
+func (c *Component) clone() Component { return Component{c.Type, maps.Clone(c.Props)} }
+
+// Prefab is a reusable node sub-graph, along with the
+// components of its nodes.
+// It can be instantiated any number of times into
+// node graphs, and edits made to it afterwards can be
+// propagated to its instances (see Propagate).
+type Prefab struct {
+	// Nodes in an order such that parents come
+	// before their children. The root node is
+	// the first one.
+	nodes []prefabNode
+	next  PrefabNode
+	// Incremented on every edit.
+	gen   int
+	insts []*Instance
+}
+
+// prefabNode is a node of a Prefab.
+type prefabNode struct {
+	ID     PrefabNode  `json:"id"`
+	Parent PrefabNode  `json:"parent"`
+	Name   string      `json:"name,omitempty"`
+	Local  linear.M4   `json:"local"`
+	Comps  []Component `json:"components,omitempty"`
+}
+
+// NewPrefab creates a new Prefab whose root node has
+// the given name and local transform.
+// local can be nil, in which case the identity is
+// used.
+func NewPrefab(name string, local *linear.M4) *Prefab {
+	root := prefabNode{ID: 0, Parent: -1, Name: name, Local: linear.I4()}
+	if local != nil {
+		root.Local = *local
+	}
+	return &Prefab{nodes: []prefabNode{root}, next: 1}
+}
+
+// node returns the node identified by n, or nil if
+// there is no such node.
+func (p *Prefab) node(n PrefabNode) *prefabNode {
+	i := slices.IndexFunc(p.nodes, func(x prefabNode) bool { return x.ID == n })
+	if i < 0 {
+		return nil
+	}
+	return &p.nodes[i]
+}
+
+// get is like node but panics if there is no such
+// node.
+func (p *Prefab) get(n PrefabNode) *prefabNode {
+	x := p.node(n)
+	if x == nil {
+		panic("scene: PrefabNode does not belong to Prefab: " + strconv.Itoa(int(n)))
+	}
+	return x
+}
+
+// Root returns the root node of p.
+func (p *Prefab) Root() PrefabNode { return p.nodes[0].ID }
+
+// Len returns the number of nodes in p.
+func (p *Prefab) Len() int { return len(p.nodes) }
+
+// Nodes returns the nodes of p. Parents are placed
+// before their children.
+func (p *Prefab) Nodes() []PrefabNode {
+	ns := make([]PrefabNode, len(p.nodes))
+	for i := range p.nodes {
+		ns[i] = p.nodes[i].ID
+	}
+	return ns
+}
+
+// Insert inserts a new node into p as a child of
+// parent.
+// local can be nil, in which case the identity is
+// used.
+// parent must belong to p.
+func (p *Prefab) Insert(parent PrefabNode, name string, local *linear.M4) PrefabNode {
+	p.get(parent)
+	n := prefabNode{ID: p.next, Parent: parent, Name: name, Local: linear.I4()}
+	if local != nil {
+		n.Local = *local
+	}
+	p.next++
+	p.nodes = append(p.nodes, n)
+	p.gen++
+	return n.ID
+}
+
+// Remove removes a node from p, along with its
+// descendants.
+// n must belong to p and must not be its root.
+func (p *Prefab) Remove(n PrefabNode) {
+	if p.get(n) == &p.nodes[0] {
+		panic("scene: cannot remove the root of a Prefab")
+	}
+	rem := map[PrefabNode]bool{n: true}
+	p.nodes = slices.DeleteFunc(p.nodes, func(x prefabNode) bool {
+		if x.ID == n || rem[x.Parent] {
+			rem[x.ID] = true
+			return true
+		}
+		return false
+	})
+	p.gen++
+}
+
+// Name returns the name of a node.
+func (p *Prefab) Name(n PrefabNode) string { return p.get(n).Name }
+
+// Parent returns the parent of a node, or -1 if n is
+// the root.
+func (p *Prefab) Parent(n PrefabNode) PrefabNode { return p.get(n).Parent }
+
+// Find returns the first node of p with the given
+// name.
+func (p *Prefab) Find(name string) (PrefabNode, bool) {
+	for i := range p.nodes {
+		if p.nodes[i].Name == name {
+			return p.nodes[i].ID, true
+		}
+	}
+	return -1, false
+}
+
+// Local returns the local transform of a node.
+func (p *Prefab) Local(n PrefabNode) linear.M4 { return p.get(n).Local }
+
+// SetLocal sets the local transform of a node.
+func (p *Prefab) SetLocal(n PrefabNode, local *linear.M4) {
+	p.get(n).Local = *local
+	p.gen++
+}
+
+// Components returns a copy of the components of a
+// node.
+func (p *Prefab) Components(n PrefabNode) []Component {
+	x := p.get(n)
+	cs := make([]Component, len(x.Comps))
+	for i := range cs {
+		cs[i] = x.Comps[i].clone()
+	}
+	return cs
+}
+
+// SetComponent sets a component of a node, replacing
+// the node's component of the same type, if any.
+// c is copied.
+func (p *Prefab) SetComponent(n PrefabNode, c Component) {
+	x := p.get(n)
+	c = c.clone()
+	if i := slices.IndexFunc(x.Comps, func(y Component) bool { return y.Type == c.Type }); i >= 0 {
+		x.Comps[i] = c
+	} else {
+		x.Comps = append(x.Comps, c)
+	}
+	p.gen++
+}
+
+// RemoveComponent removes the component of type typ
+// from a node.
+func (p *Prefab) RemoveComponent(n PrefabNode, typ string) {
+	x := p.get(n)
+	x.Comps = slices.DeleteFunc(x.Comps, func(y Component) bool { return y.Type == typ })
+	p.gen++
+}
+
+// Instances returns the instances of p that have not
+// been removed.
+func (p *Prefab) Instances() []*Instance { return slices.Clone(p.insts) }
+
+// Propagate updates every instance of p to reflect
+// edits made to p since they were instantiated or
+// last updated.
+func (p *Prefab) Propagate() {
+	for _, i := range p.insts {
+		i.Sync()
+	}
+}
+
+// prefabFile is the JSON representation of a Prefab.
+// Next is stored so that the IDs of removed nodes
+// are not reused after decoding.
+type prefabFile struct {
+	Nodes []prefabNode `json:"nodes"`
+	Next  PrefabNode   `json:"next"`
+}
+
+// EncodePrefab encodes p as JSON into w.
+func EncodePrefab(w io.Writer, p *Prefab) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "\t")
+	return enc.Encode(&prefabFile{p.nodes, p.next})
+}
+
+// DecodePrefab decodes a Prefab from r, as encoded by
+// EncodePrefab.
+func DecodePrefab(r io.Reader) (*Prefab, error) {
+	var f prefabFile
+	if err := json.NewDecoder(r).Decode(&f); err != nil {
+		return nil, err
+	}
+	if len(f.Nodes) == 0 {
+		return nil, errors.New("scene: prefab has no nodes")
+	}
+	if f.Nodes[0].Parent != -1 {
+		return nil, errors.New("scene: first prefab node must be the root")
+	}
+	p := &Prefab{nodes: f.Nodes, next: f.Next}
+	ids := make(map[PrefabNode]bool, len(f.Nodes))
+	for i := range f.Nodes {
+		x := &f.Nodes[i]
+		switch {
+		case x.ID < 0 || ids[x.ID]:
+			return nil, errors.New("scene: invalid prefab node ID: " + strconv.Itoa(int(x.ID)))
+		case i > 0 && !ids[x.Parent]:
+			return nil, errors.New("scene: prefab node parent must precede its children: " + strconv.Itoa(int(x.ID)))
+		}
+		types := make(map[string]bool, len(x.Comps))
+		for _, c := range x.Comps {
+			if types[c.Type] {
+				return nil, errors.New("scene: duplicate prefab component type: " + c.Type)
+			}
+			types[c.Type] = true
+		}
+		ids[x.ID] = true
+		p.next = max(p.next, x.ID+1)
+	}
+	return p, nil
+}
+
+// Instance is an instantiation of a Prefab into a
+// node.Graph.
+// Every node of the prefab is inserted into the graph
+// as an *InstanceNode. An Instance can override the
+// local transforms and component properties of its
+// nodes without affecting the prefab or other
+// instances.
+type Instance struct {
+	prefab *Prefab
+	graph  *node.Graph
+	parent node.Node
+	gen    int
+	nodes  map[PrefabNode]node.Node
+	locals map[PrefabNode]linear.M4
+	props  map[propKey]string
+}
+
+// propKey identifies an overridden component
+// property.
+type propKey struct {
+	node PrefabNode
+	typ  string
+	key  string
+}
+
+// InstanceNode is the node.Interface of the nodes of
+// an Instance.
+type InstanceNode struct {
+	inst    *Instance
+	id      PrefabNode
+	parent  PrefabNode
+	name    string
+	local   linear.M4
+	comps   []Component
+	changed bool
+}
+
+// Instantiate instantiates p into g, as a descendant
+// of parent.
+// parent can be node.Nil, in which case the instance
+// is inserted as an unconnected sub-graph.
+// local overrides the local transform of the root
+// node. It can be nil, in which case the prefab's is
+// used.
+func (p *Prefab) Instantiate(g *node.Graph, parent node.Node, local *linear.M4) *Instance {
+	i := &Instance{
+		prefab: p,
+		graph:  g,
+		parent: parent,
+		gen:    p.gen,
+		nodes:  make(map[PrefabNode]node.Node, len(p.nodes)),
+		locals: make(map[PrefabNode]linear.M4),
+		props:  make(map[propKey]string),
+	}
+	if local != nil {
+		i.locals[p.Root()] = *local
+	}
+	for j := range p.nodes {
+		i.insert(&p.nodes[j])
+	}
+	p.insts = append(p.insts, i)
+	return i
+}
+
+// insert inserts the instance node of x into the
+// graph.
+func (i *Instance) insert(x *prefabNode) {
+	n := &InstanceNode{inst: i, id: x.ID, parent: x.Parent}
+	i.resolve(n, x)
+	prev := i.parent
+	if x.Parent >= 0 {
+		prev = i.nodes[x.Parent]
+	}
+	i.nodes[x.ID] = i.graph.Insert(n, prev)
+}
+
+// resolve sets the transform and components of n to
+// those of x, with i's overrides applied.
+func (i *Instance) resolve(n *InstanceNode, x *prefabNode) {
+	n.name = x.Name
+	if l, ok := i.locals[x.ID]; ok {
+		n.local = l
+	} else {
+		n.local = x.Local
+	}
+	n.comps = make([]Component, 0, len(x.Comps))
+	for j := range x.Comps {
+		c := x.Comps[j].clone()
+		for k, v := range i.props {
+			if k.node == x.ID && k.typ == c.Type {
+				if c.Props == nil {
+					c.Props = make(map[string]string)
+				}
+				c.Props[k.key] = v
+			}
+		}
+		n.comps = append(n.comps, c)
+	}
+	n.changed = true
+}
+
+// Prefab returns the prefab that i instantiates.
+func (i *Instance) Prefab() *Prefab { return i.prefab }
+
+// Node returns the node of the graph that corresponds
+// to a prefab node, or node.Nil if n has no
+// corresponding node (e.g., because n was inserted
+// into the prefab after i was last updated).
+func (i *Instance) Node(n PrefabNode) node.Node { return i.nodes[n] }
+
+// Root returns the root node of i in the graph.
+func (i *Instance) Root() node.Node { return i.nodes[i.prefab.Root()] }
+
+// SetLocal overrides the local transform of a node
+// of i.
+func (i *Instance) SetLocal(n PrefabNode, local *linear.M4) {
+	i.locals[n] = *local
+	i.refresh(n)
+}
+
+// ClearLocal removes the override of the local
+// transform of a node of i.
+func (i *Instance) ClearLocal(n PrefabNode) {
+	delete(i.locals, n)
+	i.refresh(n)
+}
+
+// SetProp overrides a property of the component of
+// type typ of a node of i.
+// The override has no effect while the node has no
+// such component.
+func (i *Instance) SetProp(n PrefabNode, typ, key, value string) {
+	i.props[propKey{n, typ, key}] = value
+	i.refresh(n)
+}
+
+// ClearProp removes the override of a property of
+// the component of type typ of a node of i.
+func (i *Instance) ClearProp(n PrefabNode, typ, key string) {
+	delete(i.props, propKey{n, typ, key})
+	i.refresh(n)
+}
+
+// ClearOverrides removes every override of i.
+func (i *Instance) ClearOverrides() {
+	clear(i.locals)
+	clear(i.props)
+	i.refreshAll()
+}
+
+// refresh resolves the instance node of n again.
+// It does nothing if n has no corresponding node.
+func (i *Instance) refresh(n PrefabNode) {
+	gn, ok := i.nodes[n]
+	if !ok {
+		return
+	}
+	if x := i.prefab.node(n); x != nil {
+		i.resolve(i.graph.Get(gn).(*InstanceNode), x)
+	}
+}
+
+// refreshAll resolves every instance node of i again.
+func (i *Instance) refreshAll() {
+	for n := range i.nodes {
+		i.refresh(n)
+	}
+}
+
+// Sync updates i to reflect edits made to its prefab
+// since i was instantiated or last updated.
+// Nodes removed from the prefab are removed from the
+// graph, along with their descendants, and nodes
+// inserted into the prefab are inserted into the
+// graph. Overrides of removed nodes are discarded.
+func (i *Instance) Sync() {
+	p := i.prefab
+	if i.gen == p.gen {
+		return
+	}
+	i.gen = p.gen
+	for id, gn := range i.nodes {
+		if p.node(id) != nil {
+			continue
+		}
+		// Removing the topmost removed node
+		// removes its descendants.
+		n := i.graph.Get(gn).(*InstanceNode)
+		if p.node(n.parent) != nil {
+			i.graph.Remove(gn)
+		}
+	}
+	maps.DeleteFunc(i.nodes, func(id PrefabNode, _ node.Node) bool { return p.node(id) == nil })
+	maps.DeleteFunc(i.locals, func(id PrefabNode, _ linear.M4) bool { return p.node(id) == nil })
+	maps.DeleteFunc(i.props, func(k propKey, _ string) bool { return p.node(k.node) == nil })
+	for j := range p.nodes {
+		x := &p.nodes[j]
+		if gn, ok := i.nodes[x.ID]; ok {
+			i.resolve(i.graph.Get(gn).(*InstanceNode), x)
+		} else {
+			i.insert(x)
+		}
+	}
+}
+
+// Remove removes i from its graph.
+// i must not be used afterwards.
+func (i *Instance) Remove() {
+	i.graph.Remove(i.Root())
+	p := i.prefab
+	p.insts = slices.DeleteFunc(p.insts, func(x *Instance) bool { return x == i })
+	*i = Instance{}
+}
+
+// Instance returns the instance that n belongs to.
+func (n *InstanceNode) Instance() *Instance { return n.inst }
+
+// PrefabNode returns the prefab node that n
+// instantiates.
+func (n *InstanceNode) PrefabNode() PrefabNode { return n.id }
+
+// Name returns the name of the prefab node that n
+// instantiates.
+func (n *InstanceNode) Name() string { return n.name }
+
+// Component returns the component of type typ of n,
+// with the instance's overrides applied.
+// The returned Props must not be modified.
+func (n *InstanceNode) Component(typ string) (Component, bool) {
+	i := slices.IndexFunc(n.comps, func(c Component) bool { return c.Type == typ })
+	if i < 0 {
+		return Component{}, false
+	}
+	return n.comps[i], true
+}
+
+// Components returns the components of n, with the
+// instance's overrides applied.
+// The returned slice must not be modified.
+func (n *InstanceNode) Components() []Component { return n.comps }
+
+// Local implements node.Interface.
+func (n *InstanceNode) Local() *linear.M4 { return &n.local }
+
+// Changed implements node.Interface.
+func (n *InstanceNode) Changed() bool {
+	c := n.changed
+	n.changed = false
+	return c
+}
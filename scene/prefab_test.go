@@ -0,0 +1,204 @@
+// Copyright 2024 Gustavo C. Viegas. All rights reserved.
+
+package scene
+
+import (
+	"bytes"
+	"slices"
+	"strings"
+	"testing"
+
+	"gviegas/neo3/linear"
+	"gviegas/neo3/node"
+)
+
+func translate(x, y, z float32) (m linear.M4) {
+	m.Translate(x, y, z)
+	return
+}
+
+// newTestPrefab creates a Prefab with the following
+// hierarchy:
+//
+//	tree
+//	├── trunk (mesh)
+//	└── crown (mesh, sway)
+//	    └── bird
+func newTestPrefab() (p *Prefab, trunk, crown, bird PrefabNode) {
+	p = NewPrefab("tree", nil)
+	trunk = p.Insert(p.Root(), "trunk", nil)
+	p.SetComponent(trunk, Component{"mesh", map[string]string{"path": "trunk.gltf"}})
+	m := translate(0, 2, 0)
+	crown = p.Insert(p.Root(), "crown", &m)
+	p.SetComponent(crown, Component{"mesh", map[string]string{"path": "crown.gltf"}})
+	p.SetComponent(crown, Component{Type: "sway"})
+	m = translate(0, 1, 0)
+	bird = p.Insert(crown, "bird", &m)
+	return
+}
+
+// instNode returns the *InstanceNode of n in i.
+func instNode(t *testing.T, g *node.Graph, i *Instance, n PrefabNode) *InstanceNode {
+	t.Helper()
+	gn := i.Node(n)
+	if gn == node.Nil {
+		t.Fatalf("Instance.Node(%d): node.Nil", n)
+	}
+	return g.Get(gn).(*InstanceNode)
+}
+
+func TestPrefab(t *testing.T) {
+	p, trunk, crown, bird := newTestPrefab()
+	if n := p.Len(); n != 4 {
+		t.Fatalf("Prefab.Len:\nhave %d\nwant 4", n)
+	}
+	if n, ok := p.Find("bird"); !ok || n != bird {
+		t.Fatalf("Prefab.Find:\nhave %d, %t\nwant %d, true", n, ok, bird)
+	}
+	if n := p.Parent(bird); n != crown {
+		t.Fatalf("Prefab.Parent:\nhave %d\nwant %d", n, crown)
+	}
+	p.SetComponent(crown, Component{"mesh", map[string]string{"path": "crown_hd.gltf"}})
+	if cs := p.Components(crown); len(cs) != 2 || cs[0].Props["path"] != "crown_hd.gltf" {
+		t.Fatalf("Prefab.Components:\nhave %v", cs)
+	}
+	p.RemoveComponent(crown, "mesh")
+	if cs := p.Components(crown); len(cs) != 1 || cs[0].Type != "sway" {
+		t.Fatalf("Prefab.Components:\nhave %v", cs)
+	}
+	p.Remove(crown)
+	if ns := p.Nodes(); !slices.Equal(ns, []PrefabNode{p.Root(), trunk}) {
+		t.Fatalf("Prefab.Nodes:\nhave %v\nwant [%d %d]", ns, p.Root(), trunk)
+	}
+	// IDs are not reused.
+	if n := p.Insert(trunk, "leaf", nil); n <= bird {
+		t.Fatalf("Prefab.Insert:\nhave %d\nwant > %d", n, bird)
+	}
+}
+
+func TestInstance(t *testing.T) {
+	var g node.Graph
+	p, trunk, crown, bird := newTestPrefab()
+	par := NewPrefab("parent", nil).Instantiate(&g, node.Nil, nil).Root()
+	m := translate(10, 0, 0)
+	a := p.Instantiate(&g, par, &m)
+	b := p.Instantiate(&g, node.Nil, nil)
+	if n := g.Len(); n != 9 {
+		t.Fatalf("Graph.Len:\nhave %d\nwant 9", n)
+	}
+	if n := len(p.Instances()); n != 2 {
+		t.Fatalf("Prefab.Instances:\nhave %d\nwant 2", n)
+	}
+	a.SetProp(trunk, "mesh", "path", "birch.gltf")
+	a.SetProp(bird, "mesh", "path", "ignored.gltf")
+	m = translate(0, 3, 0)
+	b.SetLocal(crown, &m)
+	g.Update()
+	if w := g.World(a.Node(bird)); *w != translate(10, 3, 0) {
+		t.Fatalf("Graph.World:\nhave %v\nwant %v", *w, translate(10, 3, 0))
+	}
+	if w := g.World(b.Node(bird)); *w != translate(0, 4, 0) {
+		t.Fatalf("Graph.World:\nhave %v\nwant %v", *w, translate(0, 4, 0))
+	}
+	if c, ok := instNode(t, &g, a, trunk).Component("mesh"); !ok || c.Props["path"] != "birch.gltf" {
+		t.Fatalf("InstanceNode.Component:\nhave %v, %t\nwant path birch.gltf", c, ok)
+	}
+	if c, _ := instNode(t, &g, b, trunk).Component("mesh"); c.Props["path"] != "trunk.gltf" {
+		t.Fatalf("InstanceNode.Component:\nhave %v\nwant path trunk.gltf", c)
+	}
+	if _, ok := instNode(t, &g, a, bird).Component("mesh"); ok {
+		t.Fatal("InstanceNode.Component: unexpected mesh component")
+	}
+	if n := instNode(t, &g, b, bird); n.Name() != "bird" || n.PrefabNode() != bird || n.Instance() != b {
+		t.Fatalf("InstanceNode: unexpected %s, %d, %p", n.Name(), n.PrefabNode(), n.Instance())
+	}
+
+	// Propagate edits.
+	m = translate(0, 5, 0)
+	p.SetLocal(crown, &m)
+	p.SetComponent(trunk, Component{"mesh", map[string]string{"path": "oak.gltf", "lod": "2"}})
+	p.Remove(bird)
+	leaf := p.Insert(crown, "leaf", nil)
+	p.Propagate()
+	g.Update()
+	if n := g.Len(); n != 9 {
+		t.Fatalf("Graph.Len:\nhave %d\nwant 9", n)
+	}
+	if n := a.Node(bird); n != node.Nil {
+		t.Fatalf("Instance.Node:\nhave %d\nwant node.Nil", n)
+	}
+	if w := g.World(a.Node(leaf)); *w != translate(10, 5, 0) {
+		t.Fatalf("Graph.World:\nhave %v\nwant %v", *w, translate(10, 5, 0))
+	}
+	// Overrides take precedence.
+	if w := g.World(b.Node(leaf)); *w != translate(0, 3, 0) {
+		t.Fatalf("Graph.World:\nhave %v\nwant %v", *w, translate(0, 3, 0))
+	}
+	if c, _ := instNode(t, &g, a, trunk).Component("mesh"); c.Props["path"] != "birch.gltf" || c.Props["lod"] != "2" {
+		t.Fatalf("InstanceNode.Component:\nhave %v\nwant path birch.gltf, lod 2", c)
+	}
+	if c, _ := instNode(t, &g, b, trunk).Component("mesh"); c.Props["path"] != "oak.gltf" {
+		t.Fatalf("InstanceNode.Component:\nhave %v\nwant path oak.gltf", c)
+	}
+
+	b.ClearOverrides()
+	a.ClearProp(trunk, "mesh", "path")
+	g.Update()
+	if w := g.World(b.Node(leaf)); *w != translate(0, 5, 0) {
+		t.Fatalf("Graph.World:\nhave %v\nwant %v", *w, translate(0, 5, 0))
+	}
+	if c, _ := instNode(t, &g, a, trunk).Component("mesh"); c.Props["path"] != "oak.gltf" {
+		t.Fatalf("InstanceNode.Component:\nhave %v\nwant path oak.gltf", c)
+	}
+
+	a.Remove()
+	if n := g.Len(); n != 5 {
+		t.Fatalf("Graph.Len:\nhave %d\nwant 5", n)
+	}
+	if is := p.Instances(); len(is) != 1 || is[0] != b {
+		t.Fatalf("Prefab.Instances:\nhave %v\nwant [%p]", is, b)
+	}
+}
+
+func TestEncodePrefab(t *testing.T) {
+	p, _, crown, bird := newTestPrefab()
+	p.Remove(bird)
+	var buf bytes.Buffer
+	if err := EncodePrefab(&buf, p); err != nil {
+		t.Fatalf("EncodePrefab failed:\n%v", err)
+	}
+	q, err := DecodePrefab(&buf)
+	if err != nil {
+		t.Fatalf("DecodePrefab failed:\n%v", err)
+	}
+	if !slices.Equal(p.Nodes(), q.Nodes()) {
+		t.Fatalf("DecodePrefab: Nodes\nhave %v\nwant %v", q.Nodes(), p.Nodes())
+	}
+	for _, n := range p.Nodes() {
+		if p.Name(n) != q.Name(n) || p.Local(n) != q.Local(n) || p.Parent(n) != q.Parent(n) {
+			t.Fatalf("DecodePrefab: node %d differs", n)
+		}
+		pc, qc := p.Components(n), q.Components(n)
+		if !slices.EqualFunc(pc, qc, func(x, y Component) bool {
+			return x.Type == y.Type && len(x.Props) == len(y.Props) && x.Props["path"] == y.Props["path"]
+		}) {
+			t.Fatalf("DecodePrefab: Components\nhave %v\nwant %v", qc, pc)
+		}
+	}
+	if n := q.Insert(crown, "", nil); n <= bird {
+		t.Fatalf("Prefab.Insert:\nhave %d\nwant > %d", n, bird)
+	}
+
+	for _, s := range [...]string{
+		`{"nodes":[]}`,
+		`{"nodes":[{"id":0,"parent":0}]}`,
+		`{"nodes":[{"id":0,"parent":-1},{"id":0,"parent":0}]}`,
+		`{"nodes":[{"id":0,"parent":-1},{"id":1,"parent":2},{"id":2,"parent":0}]}`,
+		`{"nodes":[{"id":0,"parent":-1,"components":[{"type":"a"},{"type":"a"}]}]}`,
+		`{"nodes":`,
+	} {
+		if _, err := DecodePrefab(strings.NewReader(s)); err == nil {
+			t.Fatalf("DecodePrefab(%s): unexpected success", s)
+		}
+	}
+}
@@ -0,0 +1,97 @@
+// Copyright 2024 Gustavo C. Viegas. All rights reserved.
+
+package scene
+
+import (
+	"errors"
+)
+
+// VM is an embedded scripting runtime (e.g., a
+// bytecode interpreter).
+// Implementations integrate a scripting language with
+// Behaviors through the Program and Script interfaces.
+type VM interface {
+	// Compile compiles src into a Program.
+	// name identifies the source in errors.
+	Compile(name string, src []byte) (Program, error)
+}
+
+// Program is a compiled script.
+type Program interface {
+	// New creates a new Script that runs the
+	// program. Each Script has its own state.
+	New() (Script, error)
+}
+
+// Script is an instance of a Program.
+type Script interface {
+	// Call calls the script function named fn.
+	// It returns ErrNoFunc if the script does not
+	// define fn.
+	// c must not be retained after Call returns.
+	Call(fn string, c *Context) error
+	// Close releases the resources of the script.
+	Close()
+}
+
+// ErrNoFunc is returned by Script.Call when the script
+// does not define the function.
+var ErrNoFunc = errors.New("scene: script function not defined")
+
+// Names of the script functions that implement the
+// Behavior methods.
+const (
+	ScriptStart   = "start"
+	ScriptUpdate  = "update"
+	ScriptDestroy = "destroy"
+)
+
+// scriptBehavior is a Behavior implemented by a Script.
+type scriptBehavior struct {
+	s Script
+	// Whether the script defines the update
+	// function.
+	update bool
+}
+
+// NewScript creates a new Behavior that runs a new
+// instance of p.
+// The behavior's methods call the script functions
+// ScriptStart, ScriptUpdate and ScriptDestroy. These
+// functions are optional. Errors returned by the
+// destroy function are ignored.
+// The Script is closed after OnDestroy is called or
+// when Start fails.
+func NewScript(p Program) (Behavior, error) {
+	s, err := p.New()
+	if err != nil {
+		return nil, err
+	}
+	return &scriptBehavior{s: s, update: true}, nil
+}
+
+func (b *scriptBehavior) Start(c *Context) error {
+	if err := b.s.Call(ScriptStart, c); err != nil && !errors.Is(err, ErrNoFunc) {
+		b.s.Close()
+		return err
+	}
+	return nil
+}
+
+func (b *scriptBehavior) Update(c *Context) error {
+	if !b.update {
+		return nil
+	}
+	err := b.s.Call(ScriptUpdate, c)
+	if errors.Is(err, ErrNoFunc) {
+		// Do not call it again.
+		b.update = false
+		return nil
+	}
+	return err
+}
+
+func (b *scriptBehavior) OnDestroy(c *Context) {
+	b.s.Call(ScriptDestroy, c)
+	b.s.Close()
+}
@@ -0,0 +1,111 @@
+// Copyright 2024 Gustavo C. Viegas. All rights reserved.
+
+package scene
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"gviegas/neo3/node"
+)
+
+// tvm is a VM for testing.
+// Each line of the source defines a function as a name
+// followed by instructions, which are either "inc"
+// (increments the counter) or "fail".
+type tvm struct{}
+
+// tprogram is the Program of tvm.
+type tprogram map[string][]string
+
+// tscript is the Script of tvm.
+type tscript struct {
+	prog   tprogram
+	count  int
+	calls  []string
+	closed bool
+}
+
+func (tvm) Compile(name string, src []byte) (Program, error) {
+	p := make(tprogram)
+	for _, s := range strings.Split(strings.TrimSpace(string(src)), "\n") {
+		f := strings.Fields(s)
+		if len(f) == 0 {
+			return nil, errors.New(name + ": empty function")
+		}
+		p[f[0]] = f[1:]
+	}
+	return p, nil
+}
+
+func (p tprogram) New() (Script, error) { return &tscript{prog: p}, nil }
+
+func (s *tscript) Call(fn string, c *Context) error {
+	s.calls = append(s.calls, fn)
+	code, ok := s.prog[fn]
+	if !ok {
+		return ErrNoFunc
+	}
+	for _, op := range code {
+		switch op {
+		case "inc":
+			s.count++
+		case "fail":
+			return errors.New(fn + " failed")
+		}
+	}
+	return nil
+}
+
+func (s *tscript) Close() { s.closed = true }
+
+func TestScript(t *testing.T) {
+	var g node.Graph
+	b := NewBehaviors(&g)
+	var vm VM = tvm{}
+	p, err := vm.Compile("counter", []byte("start inc inc\nupdate inc"))
+	if err != nil {
+		t.Fatalf("VM.Compile failed:\n%v", err)
+	}
+	bh, err := NewScript(p)
+	if err != nil {
+		t.Fatalf("NewScript failed:\n%v", err)
+	}
+	n := g.Insert(newTNode("n", 0), node.Nil)
+	b.Attach(n, bh)
+	for range 3 {
+		if err := b.Update(time.Millisecond); err != nil {
+			t.Fatalf("Behaviors.Update failed:\n%v", err)
+		}
+	}
+	s := bh.(*scriptBehavior).s.(*tscript)
+	if s.count != 5 {
+		t.Fatalf("Script: count\nhave %d\nwant 5", s.count)
+	}
+	b.Destroy(n)
+	if !s.closed || strings.Join(s.calls, " ") != "start update update update destroy" {
+		t.Fatalf("Script: calls\nhave %v (closed: %t)", s.calls, s.closed)
+	}
+
+	// Undefined functions are not called again.
+	p, _ = vm.Compile("empty", []byte("other"))
+	bh, _ = NewScript(p)
+	b.Attach(node.Nil, bh)
+	b.Update(0)
+	b.Update(0)
+	if s := bh.(*scriptBehavior).s.(*tscript); strings.Join(s.calls, " ") != "start update" {
+		t.Fatalf("Script: calls\nhave %v\nwant [start update]", s.calls)
+	}
+
+	p, _ = vm.Compile("bad", []byte("start fail"))
+	bh, _ = NewScript(p)
+	b.Attach(node.Nil, bh)
+	if err := b.Update(0); err == nil || err.Error() != "start failed" {
+		t.Fatalf("Behaviors.Update:\nhave %v\nwant start failed", err)
+	}
+	if s := bh.(*scriptBehavior).s.(*tscript); !s.closed || len(b.Attached(node.Nil)) != 1 {
+		t.Fatal("Script: failed start not closed and detached")
+	}
+}
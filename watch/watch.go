@@ -0,0 +1,397 @@
+// Copyright 2024 Gustavo C. Viegas. All rights reserved.
+
+// Package watch implements file watching, as needed
+// by hot reloading of assets.
+package watch
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Op is a mask of file operations.
+type Op int
+
+// File operations.
+const (
+	// The file was created, or moved into a watched
+	// directory.
+	Create Op = 1 << iota
+	// The contents of the file changed.
+	Write
+	// The file was removed.
+	Remove
+	// The file was moved out of its location.
+	// Its new location, if watched, has a Create
+	// operation.
+	Rename
+)
+
+// String returns the names of the operations in op,
+// separated by '|'.
+func (op Op) String() string {
+	var s []string
+	for i, x := range [...]string{"Create", "Write", "Remove", "Rename"} {
+		if op&(1<<i) != 0 {
+			s = append(s, x)
+		}
+	}
+	if len(s) == 0 {
+		return "0"
+	}
+	return strings.Join(s, "|")
+}
+
+// Event describes changes to a file.
+// Op has every operation observed since the previous
+// event of Path. Programs that reload files should
+// do so on Create or Write, since many programs save
+// files by moving a new file over the old one.
+type Event struct {
+	Path string
+	Op   Op
+}
+
+// DefaultDebounce is a debounce interval suitable for
+// reloading files that are saved by editors.
+const DefaultDebounce = 100 * time.Millisecond
+
+// ErrOverflow is sent to Watcher.Errors when events
+// are lost because they were not read fast enough.
+var ErrOverflow = errors.New("watch: event queue overflow")
+
+// errClosed is returned by Watcher methods after Close.
+var errClosed = errors.New("watch: Watcher closed")
+
+// Watcher watches files and directories for changes.
+// Changes are debounced: events are delivered in
+// batches once no change has been observed for the
+// debounce interval, with the operations on each path
+// merged into a single Event. This way, a file that
+// is written several times in quick succession (as
+// when it is saved) produces one Event.
+// Watcher methods are safe for concurrent use.
+type Watcher struct {
+	debounce time.Duration
+	events   chan []Event
+	errs     chan error
+	raw      chan Event
+	done     chan struct{}
+
+	mu     sync.Mutex
+	b      backend
+	closed bool
+	// Paths given to Add, and whether they are
+	// directories (watched recursively).
+	roots map[string]bool
+	// Directories watched by b.
+	dirs map[string]bool
+}
+
+// backend is the interface of the platform-specific
+// watchers.
+// A backend watches the entries of directories, not
+// recursively. It sends the changes that it observes
+// to Watcher.send and errors to Watcher.error, from
+// any goroutine.
+// The methods are called with Watcher.mu held,
+// including from the goroutine that receives what
+// send sends. They must not call back into the
+// Watcher nor wait for a call to send to return,
+// since that would deadlock.
+type backend interface {
+	// add starts watching a directory.
+	add(dir string) error
+	// remove stops watching a directory.
+	remove(dir string) error
+	// close stops watching every directory.
+	close() error
+}
+
+// New creates a new Watcher that delivers events
+// after changes have stopped for debounce (see
+// DefaultDebounce).
+func New(debounce time.Duration) (*Watcher, error) {
+	if debounce < 0 {
+		return nil, errors.New("watch: negative debounce interval")
+	}
+	w := &Watcher{
+		debounce: debounce,
+		events:   make(chan []Event),
+		errs:     make(chan error, 1),
+		raw:      make(chan Event, 64),
+		done:     make(chan struct{}),
+		roots:    make(map[string]bool),
+		dirs:     make(map[string]bool),
+	}
+	var err error
+	if w.b, err = newBackend(w); err != nil {
+		return nil, err
+	}
+	go w.dispatch()
+	return w, nil
+}
+
+// Events returns the channel on which w delivers
+// events, in batches sorted by path.
+// Changes keep being merged while a batch is not
+// received.
+func (w *Watcher) Events() <-chan []Event { return w.events }
+
+// Errors returns the channel on which w delivers
+// errors that occur while watching (e.g.,
+// ErrOverflow). Errors are dropped if the channel
+// is not drained.
+func (w *Watcher) Errors() <-chan error { return w.errs }
+
+// Add starts watching a file or directory.
+// Directories are watched recursively, including
+// subdirectories created afterwards. Files are
+// watched through their parent directories, so they
+// need not exist.
+func (w *Watcher) Add(path string) error {
+	path = filepath.Clean(path)
+	fi, err := os.Stat(path)
+	dir := err == nil && fi.IsDir()
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.closed {
+		return errClosed
+	}
+	if _, ok := w.roots[path]; ok {
+		return nil
+	}
+	if !dir {
+		if err := w.addDir(filepath.Dir(path), false); err != nil {
+			return err
+		}
+	} else if err := w.addDir(path, true); err != nil {
+		w.prune()
+		return err
+	}
+	w.roots[path] = dir
+	return nil
+}
+
+// Remove stops watching a path given to Add.
+func (w *Watcher) Remove(path string) error {
+	path = filepath.Clean(path)
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.closed {
+		return errClosed
+	}
+	if _, ok := w.roots[path]; !ok {
+		return errors.New("watch: path not watched: " + path)
+	}
+	delete(w.roots, path)
+	w.prune()
+	return nil
+}
+
+// Close stops watching and closes the Events
+// channel.
+func (w *Watcher) Close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.closed {
+		return nil
+	}
+	w.closed = true
+	close(w.done)
+	clear(w.roots)
+	clear(w.dirs)
+	return w.b.close()
+}
+
+// addDir watches dir and, if recursive is set, every
+// subdirectory of it.
+// It must be called with w.mu held.
+func (w *Watcher) addDir(dir string, recursive bool) error {
+	if !recursive {
+		if w.dirs[dir] {
+			return nil
+		}
+		if err := w.b.add(dir); err != nil {
+			return err
+		}
+		w.dirs[dir] = true
+		return nil
+	}
+	return filepath.WalkDir(dir, func(path string, d os.DirEntry, err error) error {
+		switch {
+		case err != nil:
+			// Subdirectories may be removed
+			// while walking.
+			if path != dir && os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		case !d.IsDir():
+			return nil
+		}
+		return w.addDir(path, false)
+	})
+}
+
+// prune stops watching directories that no path
+// given to Add needs.
+// It must be called with w.mu held.
+func (w *Watcher) prune() {
+	for dir := range w.dirs {
+		if !w.needs(dir) {
+			w.b.remove(dir)
+			delete(w.dirs, dir)
+		}
+	}
+}
+
+// needs returns whether dir must be watched (i.e.,
+// whether it is within a directory given to Add or
+// is the parent of a file given to Add).
+// It must be called with w.mu held.
+func (w *Watcher) needs(dir string) bool {
+	for root, isDir := range w.roots {
+		if isDir && within(dir, root) || !isDir && filepath.Dir(root) == dir {
+			return true
+		}
+	}
+	return false
+}
+
+// watched returns whether events of path must be
+// delivered, and whether path is within a directory
+// given to Add.
+// It must be called with w.mu held.
+func (w *Watcher) watched(path string) (ok, recursive bool) {
+	for root, isDir := range w.roots {
+		if isDir && within(path, root) {
+			return true, true
+		}
+		if path == root {
+			ok = true
+		}
+	}
+	return
+}
+
+// within returns whether path is root or is within
+// root.
+func within(path, root string) bool {
+	if !strings.HasPrefix(path, root) {
+		return false
+	}
+	return len(path) == len(root) || os.IsPathSeparator(path[len(root)]) || os.IsPathSeparator(root[len(root)-1])
+}
+
+// send is called by the backend to report a change.
+// It returns false if w was closed.
+func (w *Watcher) send(ev Event) bool {
+	select {
+	case w.raw <- ev:
+		return true
+	case <-w.done:
+		return false
+	}
+}
+
+// error is called by the backend to report an error.
+func (w *Watcher) error(err error) {
+	select {
+	case w.errs <- err:
+	default:
+	}
+}
+
+// dispatch debounces the changes reported by the
+// backend and delivers them on w.events until w is
+// closed.
+func (w *Watcher) dispatch() {
+	defer close(w.events)
+	pending := make(map[string]Op)
+	timer := time.NewTimer(w.debounce)
+	timer.Stop()
+	var (
+		batch []Event
+		out   chan []Event
+	)
+	for {
+		select {
+		case ev := <-w.raw:
+			if w.filter(ev, pending) {
+				timer.Reset(w.debounce)
+			}
+		case <-timer.C:
+			for path, op := range pending {
+				i := slices.IndexFunc(batch, func(e Event) bool { return e.Path == path })
+				if i < 0 {
+					batch = append(batch, Event{path, op})
+				} else {
+					batch[i].Op |= op
+				}
+			}
+			clear(pending)
+			slices.SortFunc(batch, func(a, b Event) int { return strings.Compare(a.Path, b.Path) })
+			if len(batch) > 0 {
+				out = w.events
+			}
+		case out <- batch:
+			batch = nil
+			out = nil
+		case <-w.done:
+			timer.Stop()
+			return
+		}
+	}
+}
+
+// filter merges ev into pending if it must be
+// delivered, and returns whether it was.
+// Directories created within directories given to
+// Add are watched, and their contents reported as
+// created, since they may have been created before
+// the directory was watched.
+// Directories that are removed stop being watched.
+func (w *Watcher) filter(ev Event, pending map[string]Op) bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.closed {
+		return false
+	}
+	ok, recursive := w.watched(ev.Path)
+	if !ok {
+		return false
+	}
+	pending[ev.Path] |= ev.Op
+	switch {
+	case ev.Op&(Remove|Rename) != 0 && w.dirs[ev.Path]:
+		for dir := range w.dirs {
+			if within(dir, ev.Path) {
+				w.b.remove(dir)
+				delete(w.dirs, dir)
+			}
+		}
+	case ev.Op&Create != 0 && recursive:
+		if fi, err := os.Lstat(ev.Path); err != nil || !fi.IsDir() || w.dirs[ev.Path] {
+			break
+		}
+		if err := w.addDir(ev.Path, true); err != nil {
+			w.error(err)
+		}
+		filepath.WalkDir(ev.Path, func(path string, _ os.DirEntry, err error) error {
+			if err == nil && path != ev.Path {
+				pending[path] |= Create
+			}
+			return nil
+		})
+	}
+	return true
+}
@@ -0,0 +1,248 @@
+// Copyright 2024 Gustavo C. Viegas. All rights reserved.
+
+//go:build darwin || dragonfly || freebsd || netbsd || openbsd
+
+package watch
+
+import (
+	"os"
+	"path/filepath"
+	"sync"
+	"syscall"
+)
+
+// kqueueMask is the mask of vnode events that are
+// watched.
+const kqueueMask = syscall.NOTE_WRITE | syscall.NOTE_EXTEND | syscall.NOTE_DELETE | syscall.NOTE_RENAME
+
+// kqueue is the backend that uses kqueue(2).
+// kqueue watches open files rather than paths, so
+// every entry of a watched directory is opened, and
+// directories are read again when their entries
+// change to find out which ones did.
+type kqueue struct {
+	w  *Watcher
+	kq int
+	// Pipe that wakes the reader when b is
+	// closed.
+	wake [2]int
+
+	mu sync.Mutex
+	// Open files, by path and by descriptor.
+	fds   map[string]int
+	paths map[int]string
+	// Entries of the watched directories.
+	dirs map[string]map[string]bool
+}
+
+func newBackend(w *Watcher) (backend, error) {
+	kq, err := syscall.Kqueue()
+	if err != nil {
+		return nil, os.NewSyscallError("kqueue", err)
+	}
+	b := &kqueue{
+		w:     w,
+		kq:    kq,
+		fds:   make(map[string]int),
+		paths: make(map[int]string),
+		dirs:  make(map[string]map[string]bool),
+	}
+	if err := syscall.Pipe(b.wake[:]); err != nil {
+		syscall.Close(kq)
+		return nil, os.NewSyscallError("pipe", err)
+	}
+	var k syscall.Kevent_t
+	syscall.SetKevent(&k, b.wake[0], syscall.EVFILT_READ, syscall.EV_ADD)
+	if _, err := syscall.Kevent(kq, []syscall.Kevent_t{k}, nil, nil); err != nil {
+		syscall.Close(kq)
+		syscall.Close(b.wake[0])
+		syscall.Close(b.wake[1])
+		return nil, os.NewSyscallError("kevent", err)
+	}
+	go b.read()
+	return b, nil
+}
+
+func (b *kqueue) add(dir string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if err := b.open(dir); err != nil {
+		return err
+	}
+	names, err := readNames(dir)
+	if err != nil {
+		b.closeFile(dir)
+		return err
+	}
+	for name := range names {
+		// Entries that cannot be opened (e.g.,
+		// sockets) are only tracked by name.
+		b.open(filepath.Join(dir, name))
+	}
+	b.dirs[dir] = names
+	return nil
+}
+
+func (b *kqueue) remove(dir string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.removeDir(dir)
+	return nil
+}
+
+func (b *kqueue) close() error {
+	_, err := syscall.Write(b.wake[1], []byte{0})
+	return err
+}
+
+// open opens path and watches it.
+// It must be called with b.mu held.
+func (b *kqueue) open(path string) error {
+	if _, ok := b.fds[path]; ok {
+		return nil
+	}
+	fd, err := syscall.Open(path, syscall.O_RDONLY|syscall.O_NONBLOCK|syscall.O_CLOEXEC, 0)
+	if err != nil {
+		return &os.PathError{Op: "open", Path: path, Err: err}
+	}
+	var k syscall.Kevent_t
+	syscall.SetKevent(&k, fd, syscall.EVFILT_VNODE, syscall.EV_ADD|syscall.EV_CLEAR)
+	k.Fflags = kqueueMask
+	if _, err := syscall.Kevent(b.kq, []syscall.Kevent_t{k}, nil, nil); err != nil {
+		syscall.Close(fd)
+		return &os.PathError{Op: "kevent", Path: path, Err: err}
+	}
+	b.fds[path] = fd
+	b.paths[fd] = path
+	return nil
+}
+
+// closeFile stops watching path.
+// It must be called with b.mu held.
+func (b *kqueue) closeFile(path string) {
+	if fd, ok := b.fds[path]; ok {
+		delete(b.fds, path)
+		delete(b.paths, fd)
+		syscall.Close(fd)
+	}
+}
+
+// removeDir stops watching dir and its entries.
+// Entries that are watched directories themselves,
+// and dir if its parent is watched, stay open.
+// It must be called with b.mu held.
+func (b *kqueue) removeDir(dir string) {
+	names, ok := b.dirs[dir]
+	if !ok {
+		return
+	}
+	delete(b.dirs, dir)
+	for name := range names {
+		if path := filepath.Join(dir, name); b.dirs[path] == nil {
+			b.closeFile(path)
+		}
+	}
+	if b.dirs[filepath.Dir(dir)] == nil {
+		b.closeFile(dir)
+	}
+}
+
+// read reads events until b is closed.
+func (b *kqueue) read() {
+	defer func() {
+		b.mu.Lock()
+		for path := range b.fds {
+			b.closeFile(path)
+		}
+		clear(b.dirs)
+		b.mu.Unlock()
+		syscall.Close(b.kq)
+		syscall.Close(b.wake[0])
+		syscall.Close(b.wake[1])
+	}()
+	kevs := make([]syscall.Kevent_t, 64)
+	for {
+		n, err := syscall.Kevent(b.kq, nil, kevs, nil)
+		if err != nil {
+			if err == syscall.EINTR {
+				continue
+			}
+			b.w.error(os.NewSyscallError("kevent", err))
+			return
+		}
+		var evs []Event
+		for _, k := range kevs[:n] {
+			fd := int(k.Ident)
+			if fd == b.wake[0] {
+				return
+			}
+			evs = b.changes(evs, fd, k.Fflags)
+		}
+		for _, ev := range evs {
+			if !b.w.send(ev) {
+				return
+			}
+		}
+	}
+}
+
+// changes appends to evs the changes indicated by
+// vnode event flags of the file open as fd.
+func (b *kqueue) changes(evs []Event, fd int, flags uint32) []Event {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	path, ok := b.paths[fd]
+	if !ok {
+		// Closed while the event was queued.
+		return evs
+	}
+	names, dir := b.dirs[path]
+	switch {
+	case flags&(syscall.NOTE_DELETE|syscall.NOTE_RENAME) != 0:
+		op := Remove
+		if flags&syscall.NOTE_DELETE == 0 {
+			op = Rename
+		}
+		evs = append(evs, Event{path, op})
+		b.removeDir(path)
+		b.closeFile(path)
+	case dir && flags&syscall.NOTE_WRITE != 0:
+		// Entries were created or removed.
+		cur, err := readNames(path)
+		if err != nil {
+			break
+		}
+		for name := range cur {
+			if !names[name] {
+				p := filepath.Join(path, name)
+				evs = append(evs, Event{p, Create})
+				b.open(p)
+			}
+		}
+		for name := range names {
+			if p := filepath.Join(path, name); !cur[name] {
+				evs = append(evs, Event{p, Remove})
+				if b.dirs[p] == nil {
+					b.closeFile(p)
+				}
+			}
+		}
+		b.dirs[path] = cur
+	case !dir && flags&(syscall.NOTE_WRITE|syscall.NOTE_EXTEND) != 0:
+		evs = append(evs, Event{path, Write})
+	}
+	return evs
+}
+
+// readNames returns the names of the entries of dir.
+func readNames(dir string) (map[string]bool, error) {
+	ents, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	names := make(map[string]bool, len(ents))
+	for _, e := range ents {
+		names[e.Name()] = true
+	}
+	return names, nil
+}
@@ -0,0 +1,138 @@
+// Copyright 2024 Gustavo C. Viegas. All rights reserved.
+
+package watch
+
+import (
+	"encoding/binary"
+	"errors"
+	"os"
+	"path/filepath"
+	"sync"
+	"syscall"
+)
+
+// inotifyMask is the mask of inotify events that are
+// watched.
+const inotifyMask = syscall.IN_CREATE | syscall.IN_MODIFY | syscall.IN_CLOSE_WRITE |
+	syscall.IN_DELETE | syscall.IN_DELETE_SELF | syscall.IN_MOVED_FROM | syscall.IN_MOVED_TO |
+	syscall.IN_EXCL_UNLINK
+
+// inotify is the backend that uses inotify(7).
+type inotify struct {
+	w  *Watcher
+	fd int
+	// File of fd, which is non-blocking so that
+	// reads are interrupted by Close.
+	f *os.File
+
+	mu   sync.Mutex
+	wds  map[int32]string
+	dirs map[string]int32
+}
+
+func newBackend(w *Watcher) (backend, error) {
+	fd, err := syscall.InotifyInit1(syscall.IN_CLOEXEC | syscall.IN_NONBLOCK)
+	if err != nil {
+		return nil, os.NewSyscallError("inotify_init1", err)
+	}
+	b := &inotify{
+		w:    w,
+		fd:   fd,
+		f:    os.NewFile(uintptr(fd), "inotify"),
+		wds:  make(map[int32]string),
+		dirs: make(map[string]int32),
+	}
+	go b.read()
+	return b, nil
+}
+
+func (b *inotify) add(dir string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	wd, err := syscall.InotifyAddWatch(b.fd, dir, inotifyMask|syscall.IN_ONLYDIR)
+	if err != nil {
+		return &os.PathError{Op: "inotify_add_watch", Path: dir, Err: err}
+	}
+	b.wds[int32(wd)] = dir
+	b.dirs[dir] = int32(wd)
+	return nil
+}
+
+func (b *inotify) remove(dir string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	wd, ok := b.dirs[dir]
+	if !ok {
+		return nil
+	}
+	delete(b.dirs, dir)
+	delete(b.wds, wd)
+	if _, err := syscall.InotifyRmWatch(b.fd, uint32(wd)); err != nil {
+		return &os.PathError{Op: "inotify_rm_watch", Path: dir, Err: err}
+	}
+	return nil
+}
+
+func (b *inotify) close() error { return b.f.Close() }
+
+// read reads events until b is closed.
+func (b *inotify) read() {
+	const size = syscall.SizeofInotifyEvent
+	buf := make([]byte, 64*(size+syscall.NAME_MAX+1))
+	for {
+		n, err := b.f.Read(buf)
+		if err != nil {
+			if !errors.Is(err, os.ErrClosed) {
+				b.w.error(err)
+			}
+			return
+		}
+		for off := 0; off+size <= n; {
+			wd := int32(binary.NativeEndian.Uint32(buf[off:]))
+			mask := binary.NativeEndian.Uint32(buf[off+4:])
+			nameLen := int(binary.NativeEndian.Uint32(buf[off+12:]))
+			name := buf[off+size : off+size+nameLen]
+			off += size + nameLen
+			for len(name) > 0 && name[len(name)-1] == 0 {
+				name = name[:len(name)-1]
+			}
+
+			if mask&syscall.IN_Q_OVERFLOW != 0 {
+				b.w.error(ErrOverflow)
+				continue
+			}
+			b.mu.Lock()
+			dir, ok := b.wds[wd]
+			if mask&syscall.IN_IGNORED != 0 {
+				delete(b.wds, wd)
+				if b.dirs[dir] == wd {
+					delete(b.dirs, dir)
+				}
+			}
+			b.mu.Unlock()
+			if !ok {
+				continue
+			}
+			var op Op
+			switch {
+			case mask&(syscall.IN_CREATE|syscall.IN_MOVED_TO) != 0:
+				op = Create
+			case mask&(syscall.IN_MODIFY|syscall.IN_CLOSE_WRITE) != 0:
+				op = Write
+			case mask&(syscall.IN_DELETE|syscall.IN_DELETE_SELF) != 0:
+				op = Remove
+			case mask&syscall.IN_MOVED_FROM != 0:
+				op = Rename
+			default:
+				continue
+			}
+			path := dir
+			if len(name) > 0 {
+				path = filepath.Join(dir, string(name))
+			}
+			if !b.w.send(Event{path, op}) {
+				return
+			}
+		}
+	}
+}
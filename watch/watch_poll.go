@@ -0,0 +1,140 @@
+// Copyright 2024 Gustavo C. Viegas. All rights reserved.
+
+//go:build !darwin && !dragonfly && !freebsd && !linux && !netbsd && !openbsd && !windows
+
+package watch
+
+import (
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// pollInterval is the interval between scans of the
+// poll backend.
+const pollInterval = 250 * time.Millisecond
+
+// poll is the backend used when the system provides
+// no means of watching files. It scans the watched
+// directories periodically, comparing the sizes and
+// modification times of their entries.
+type poll struct {
+	w    *Watcher
+	quit chan struct{}
+
+	mu   sync.Mutex
+	dirs map[string]map[string]pollEntry
+}
+
+// pollEntry is the state of a directory entry when
+// it was last scanned.
+type pollEntry struct {
+	size int64
+	mod  time.Time
+}
+
+func newBackend(w *Watcher) (backend, error) {
+	b := &poll{
+		w:    w,
+		quit: make(chan struct{}),
+		dirs: make(map[string]map[string]pollEntry),
+	}
+	go b.run()
+	return b, nil
+}
+
+func (b *poll) add(dir string) error {
+	ents, err := scan(dir)
+	if err != nil {
+		return err
+	}
+	b.mu.Lock()
+	b.dirs[dir] = ents
+	b.mu.Unlock()
+	return nil
+}
+
+func (b *poll) remove(dir string) error {
+	b.mu.Lock()
+	delete(b.dirs, dir)
+	b.mu.Unlock()
+	return nil
+}
+
+func (b *poll) close() error {
+	close(b.quit)
+	return nil
+}
+
+// run scans the watched directories until b is
+// closed.
+func (b *poll) run() {
+	tick := time.NewTicker(pollInterval)
+	defer tick.Stop()
+	for {
+		select {
+		case <-tick.C:
+		case <-b.quit:
+			return
+		}
+		for _, ev := range b.scanAll() {
+			if !b.w.send(ev) {
+				return
+			}
+		}
+	}
+}
+
+// scanAll scans every watched directory and returns
+// the changes found.
+func (b *poll) scanAll() (evs []Event) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for dir, old := range b.dirs {
+		cur, err := scan(dir)
+		if err != nil {
+			if os.IsNotExist(err) {
+				evs = append(evs, Event{dir, Remove})
+				delete(b.dirs, dir)
+			}
+			continue
+		}
+		for name, e := range cur {
+			path := filepath.Join(dir, name)
+			if x, ok := old[name]; !ok {
+				evs = append(evs, Event{path, Create})
+			} else if x != e {
+				evs = append(evs, Event{path, Write})
+			}
+		}
+		for name := range old {
+			if _, ok := cur[name]; !ok {
+				evs = append(evs, Event{filepath.Join(dir, name), Remove})
+			}
+		}
+		b.dirs[dir] = cur
+	}
+	return
+}
+
+// scan returns the state of the entries of dir.
+func scan(dir string) (map[string]pollEntry, error) {
+	ents, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	m := make(map[string]pollEntry, len(ents))
+	for _, e := range ents {
+		fi, err := e.Info()
+		if err != nil {
+			continue
+		}
+		var x pollEntry
+		if !fi.IsDir() {
+			x = pollEntry{fi.Size(), fi.ModTime()}
+		}
+		m[e.Name()] = x
+	}
+	return m, nil
+}
@@ -0,0 +1,166 @@
+// Copyright 2024 Gustavo C. Viegas. All rights reserved.
+
+package watch
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+)
+
+// Debounce interval of the tests.
+const testDebounce = 50 * time.Millisecond
+
+// next returns the next batch of events of w.
+// It fails the test if none is delivered within a
+// second.
+func next(t *testing.T, w *Watcher) []Event {
+	t.Helper()
+	select {
+	case evs := <-w.Events():
+		return evs
+	case err := <-w.Errors():
+		t.Fatalf("Watcher.Errors:\n%v", err)
+	case <-time.After(time.Second):
+		t.Fatal("Watcher.Events: timed out")
+	}
+	return nil
+}
+
+// none fails the test if w delivers events within
+// a few debounce intervals.
+func none(t *testing.T, w *Watcher) {
+	t.Helper()
+	select {
+	case evs := <-w.Events():
+		t.Fatalf("Watcher.Events:\nhave %v\nwant nothing", evs)
+	case <-time.After(4 * testDebounce):
+	}
+}
+
+func write(t *testing.T, path, s string) {
+	t.Helper()
+	if err := os.WriteFile(path, []byte(s), 0o644); err != nil {
+		t.Fatalf("os.WriteFile failed:\n%v", err)
+	}
+}
+
+func TestWatcher(t *testing.T) {
+	dir := t.TempDir()
+	w, err := New(testDebounce)
+	if err != nil {
+		t.Fatalf("New failed:\n%v", err)
+	}
+	defer w.Close()
+	if err := w.Add(dir); err != nil {
+		t.Fatalf("Watcher.Add failed:\n%v", err)
+	}
+
+	// Repeated writes are merged.
+	a := filepath.Join(dir, "a")
+	write(t, a, "x")
+	write(t, a, "xy")
+	write(t, a, "xyz")
+	evs := next(t, w)
+	if len(evs) != 1 || evs[0].Path != a || evs[0].Op&Create == 0 {
+		t.Fatalf("Watcher.Events:\nhave %v\nwant [{%s Create|Write}]", evs, a)
+	}
+	write(t, a, "w")
+	if evs := next(t, w); !slices.Equal(evs, []Event{{a, Write}}) {
+		t.Fatalf("Watcher.Events:\nhave %v\nwant [{%s Write}]", evs, a)
+	}
+
+	// New directories are watched, and their
+	// contents reported.
+	sub := filepath.Join(dir, "sub", "sub")
+	if err := os.MkdirAll(sub, 0o755); err != nil {
+		t.Fatalf("os.MkdirAll failed:\n%v", err)
+	}
+	b := filepath.Join(sub, "b")
+	write(t, b, "b")
+	evs = next(t, w)
+	if i := slices.IndexFunc(evs, func(e Event) bool { return e.Path == b }); i < 0 || evs[i].Op&Create == 0 {
+		t.Fatalf("Watcher.Events:\nhave %v\nwant Create of %s", evs, b)
+	}
+	write(t, b, "bb")
+	if evs := next(t, w); !slices.Equal(evs, []Event{{b, Write}}) {
+		t.Fatalf("Watcher.Events:\nhave %v\nwant [{%s Write}]", evs, b)
+	}
+
+	c := filepath.Join(dir, "c")
+	if err := os.Rename(a, c); err != nil {
+		t.Fatalf("os.Rename failed:\n%v", err)
+	}
+	if err := os.Remove(b); err != nil {
+		t.Fatalf("os.Remove failed:\n%v", err)
+	}
+	// Backends that cannot tell renames apart
+	// report removals.
+	evs = next(t, w)
+	if len(evs) != 3 || evs[0].Path != a || evs[0].Op&(Rename|Remove) == 0 || evs[1] != (Event{c, Create}) || evs[2] != (Event{b, Remove}) {
+		t.Fatalf("Watcher.Events:\nhave %v\nwant [{%s Rename} {%s Create} {%s Remove}]", evs, a, c, b)
+	}
+
+	if err := w.Remove(dir); err != nil {
+		t.Fatalf("Watcher.Remove failed:\n%v", err)
+	}
+	if err := w.Remove(dir); err == nil {
+		t.Fatal("Watcher.Remove: should have failed")
+	}
+	write(t, c, "c")
+	none(t, w)
+
+	if err := w.Close(); err != nil {
+		t.Fatalf("Watcher.Close failed:\n%v", err)
+	}
+	if _, ok := <-w.Events(); ok {
+		t.Fatal("Watcher.Close: Events should be closed")
+	}
+	if err := w.Add(dir); err == nil {
+		t.Fatal("Watcher.Add: should have failed after Close")
+	}
+}
+
+func TestWatcherFile(t *testing.T) {
+	dir := t.TempDir()
+	w, err := New(testDebounce)
+	if err != nil {
+		t.Fatalf("New failed:\n%v", err)
+	}
+	defer w.Close()
+	// The file need not exist.
+	a := filepath.Join(dir, "a")
+	if err := w.Add(a); err != nil {
+		t.Fatalf("Watcher.Add failed:\n%v", err)
+	}
+	write(t, filepath.Join(dir, "b"), "b")
+	none(t, w)
+
+	// Replace a by moving a new file over it.
+	tmp := filepath.Join(dir, "a.tmp")
+	write(t, tmp, "a")
+	if err := os.Rename(tmp, a); err != nil {
+		t.Fatalf("os.Rename failed:\n%v", err)
+	}
+	if evs := next(t, w); !slices.Equal(evs, []Event{{a, Create}}) {
+		t.Fatalf("Watcher.Events:\nhave %v\nwant [{%s Create}]", evs, a)
+	}
+}
+
+func TestOpString(t *testing.T) {
+	for _, x := range [...]struct {
+		op Op
+		s  string
+	}{
+		{0, "0"},
+		{Create, "Create"},
+		{Create | Write, "Create|Write"},
+		{Remove | Rename, "Remove|Rename"},
+	} {
+		if s := x.op.String(); s != x.s {
+			t.Fatalf("Op.String:\nhave %s\nwant %s", s, x.s)
+		}
+	}
+}
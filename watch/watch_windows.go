@@ -0,0 +1,200 @@
+// Copyright 2024 Gustavo C. Viegas. All rights reserved.
+
+package watch
+
+import (
+	"os"
+	"path/filepath"
+	"sync"
+	"syscall"
+	"unsafe"
+)
+
+// rdcwMask is the mask of changes that are watched.
+const rdcwMask = syscall.FILE_NOTIFY_CHANGE_FILE_NAME | syscall.FILE_NOTIFY_CHANGE_DIR_NAME |
+	syscall.FILE_NOTIFY_CHANGE_SIZE | syscall.FILE_NOTIFY_CHANGE_LAST_WRITE
+
+// rdcw is the backend that uses ReadDirectoryChangesW,
+// with completions queued to an I/O completion port.
+type rdcw struct {
+	w    *Watcher
+	port syscall.Handle
+
+	mu   sync.Mutex
+	dirs map[string]*rdcwDir
+	// Directories with pending reads, by
+	// completion key. Directories that are
+	// removed stay here until their reads are
+	// aborted, since the system writes to them.
+	keys map[uint32]*rdcwDir
+	next uint32
+}
+
+// rdcwDir is a watched directory.
+type rdcwDir struct {
+	ov      syscall.Overlapped
+	h       syscall.Handle
+	path    string
+	key     uint32
+	removed bool
+	// Notification buffer. Entries are aligned
+	// to 4 bytes.
+	buf [16 << 10]uint32
+}
+
+func newBackend(w *Watcher) (backend, error) {
+	port, err := syscall.CreateIoCompletionPort(syscall.InvalidHandle, 0, 0, 1)
+	if err != nil {
+		return nil, os.NewSyscallError("CreateIoCompletionPort", err)
+	}
+	b := &rdcw{
+		w:    w,
+		port: port,
+		dirs: make(map[string]*rdcwDir),
+		keys: make(map[uint32]*rdcwDir),
+	}
+	go b.read()
+	return b, nil
+}
+
+func (b *rdcw) add(dir string) error {
+	p, err := syscall.UTF16PtrFromString(dir)
+	if err != nil {
+		return err
+	}
+	h, err := syscall.CreateFile(p, syscall.FILE_LIST_DIRECTORY,
+		syscall.FILE_SHARE_READ|syscall.FILE_SHARE_WRITE|syscall.FILE_SHARE_DELETE, nil,
+		syscall.OPEN_EXISTING, syscall.FILE_FLAG_BACKUP_SEMANTICS|syscall.FILE_FLAG_OVERLAPPED, 0)
+	if err != nil {
+		return &os.PathError{Op: "CreateFile", Path: dir, Err: err}
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	// Key 0 is posted by close.
+	b.next++
+	d := &rdcwDir{h: h, path: dir, key: b.next}
+	if _, err := syscall.CreateIoCompletionPort(h, b.port, d.key, 0); err != nil {
+		syscall.CloseHandle(h)
+		return &os.PathError{Op: "CreateIoCompletionPort", Path: dir, Err: err}
+	}
+	if err := d.start(); err != nil {
+		syscall.CloseHandle(h)
+		return err
+	}
+	b.dirs[dir] = d
+	b.keys[d.key] = d
+	return nil
+}
+
+func (b *rdcw) remove(dir string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	d, ok := b.dirs[dir]
+	if !ok {
+		return nil
+	}
+	delete(b.dirs, dir)
+	d.removed = true
+	return syscall.CloseHandle(d.h)
+}
+
+func (b *rdcw) close() error {
+	b.mu.Lock()
+	for dir, d := range b.dirs {
+		delete(b.dirs, dir)
+		d.removed = true
+		syscall.CloseHandle(d.h)
+	}
+	b.mu.Unlock()
+	return syscall.PostQueuedCompletionStatus(b.port, 0, 0, nil)
+}
+
+// start starts reading the changes of d.
+func (d *rdcwDir) start() error {
+	err := syscall.ReadDirectoryChanges(d.h, (*byte)(unsafe.Pointer(&d.buf[0])), uint32(len(d.buf)*4),
+		false, rdcwMask, nil, &d.ov, 0)
+	if err != nil {
+		return &os.PathError{Op: "ReadDirectoryChanges", Path: d.path, Err: err}
+	}
+	return nil
+}
+
+// read reads completions until b is closed.
+func (b *rdcw) read() {
+	defer syscall.CloseHandle(b.port)
+	for {
+		var n, key uint32
+		var ov *syscall.Overlapped
+		err := syscall.GetQueuedCompletionStatus(b.port, &n, &key, &ov, syscall.INFINITE)
+		if key == 0 {
+			return
+		}
+		b.mu.Lock()
+		d := b.keys[key]
+		done := d == nil || d.removed || err != nil
+		if d != nil && done {
+			// The read was aborted, or the
+			// directory was removed.
+			delete(b.keys, key)
+			if !d.removed {
+				delete(b.dirs, d.path)
+				syscall.CloseHandle(d.h)
+			}
+		}
+		b.mu.Unlock()
+		if done {
+			continue
+		}
+		if n == 0 {
+			// The buffer was too small.
+			b.w.error(ErrOverflow)
+		}
+		evs := d.parse(n)
+		b.mu.Lock()
+		if d.removed {
+			// No read is pending.
+			delete(b.keys, key)
+		} else if err := d.start(); err != nil {
+			delete(b.keys, key)
+			delete(b.dirs, d.path)
+			syscall.CloseHandle(d.h)
+			b.w.error(err)
+		}
+		b.mu.Unlock()
+		for _, ev := range evs {
+			if !b.w.send(ev) {
+				return
+			}
+		}
+	}
+}
+
+// parse parses the first n bytes of d.buf.
+func (d *rdcwDir) parse(n uint32) (evs []Event) {
+	if n == 0 {
+		return
+	}
+	buf := unsafe.Slice((*byte)(unsafe.Pointer(&d.buf[0])), n)
+	for off := uint32(0); ; {
+		info := (*syscall.FileNotifyInformation)(unsafe.Pointer(&buf[off]))
+		name := unsafe.Slice(&info.FileName, info.FileNameLength/2)
+		var op Op
+		switch info.Action {
+		case syscall.FILE_ACTION_ADDED, syscall.FILE_ACTION_RENAMED_NEW_NAME:
+			op = Create
+		case syscall.FILE_ACTION_MODIFIED:
+			op = Write
+		case syscall.FILE_ACTION_REMOVED:
+			op = Remove
+		case syscall.FILE_ACTION_RENAMED_OLD_NAME:
+			op = Rename
+		}
+		if op != 0 {
+			evs = append(evs, Event{filepath.Join(d.path, syscall.UTF16ToString(name)), op})
+		}
+		if info.NextEntryOffset == 0 {
+			return
+		}
+		off += info.NextEntryOffset
+	}
+}
@@ -9,6 +9,10 @@ import (
 )
 
 func init() {
+	if _, ok := os.LookupEnv("NEO3_HEADLESS"); ok {
+		initDummy()
+		return
+	}
 	// TODO: Prefer X11 for now as Wayland lacks decorations.
 	_, useWL := os.LookupEnv("NEO3_USE_WAYLAND")
 	switch os.Getenv("XDG_SESSION_TYPE") {
@@ -9,6 +9,10 @@ import (
 )
 
 func init() {
+	if _, ok := os.LookupEnv("NEO3_HEADLESS"); ok {
+		initDummy()
+		return
+	}
 	if os.Getenv("XDG_SESSION_TYPE") == "x11" || os.Getenv("DISPLAY") != "" {
 		if err := initXCB(); err != nil {
 			os.Stderr.WriteString(err.Error() + "\n")
@@ -8,6 +8,10 @@ import (
 )
 
 func init() {
+	if _, ok := os.LookupEnv("NEO3_HEADLESS"); ok {
+		initDummy()
+		return
+	}
 	runtime.LockOSThread()
 	if err := initWin32(); err != nil {
 		runtime.UnlockOSThread()
@@ -0,0 +1,460 @@
+// Copyright 2024 Gustavo C. Viegas. All rights reserved.
+
+package wsi
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"io"
+	"math"
+	"time"
+)
+
+// replayMagic identifies replay files.
+// The last byte is the format version.
+var replayMagic = [8]byte{'n', 'e', 'o', '3', 'r', 'p', 'l', 3}
+
+var errReplay = errors.New("wsi: invalid replay data")
+
+// Replay record operations.
+const (
+	opFrame = iota + 1
+	opWindowClose
+	opWindowResize
+	opKeyboardEnter
+	opKeyboardLeave
+	opKeyboardKey
+	opKeyboardModifier
+	opPointerEnter
+	opPointerLeave
+	opPointerMotion
+	opPointerButton
+	opPointerRelativeMotion
+	opTextInput
+	opTextComposition
+	opWindowScale
+)
+
+// maxReplayText is the maximum length of the text that
+// follows a text record.
+const maxReplayText = 1 << 16
+
+// record is a replay record.
+// Win is the index of the window in the set of created
+// windows, or -1 for nil windows. For frame records, N
+// is the RNG seed and M is the frame interval, in
+// nanoseconds. Text records are followed by A bytes of
+// text, and B is the composition's cursor. For window
+// scale records, A holds the bits of the float32 scale.
+type record struct {
+	Op      uint8
+	Win     int8
+	Pressed bool
+	_       uint8
+	A, B    int32
+	N, M    uint64
+}
+
+// windowIndex returns the index of win in createdWindows.
+func windowIndex(win Window) int8 {
+	if win != nil {
+		for i := range createdWindows {
+			if createdWindows[i] == win {
+				return int8(i)
+			}
+		}
+	}
+	return -1
+}
+
+// windowAt is the inverse of windowIndex.
+func windowAt(i int8) Window {
+	if i < 0 || int(i) >= len(createdWindows) {
+		return nil
+	}
+	return createdWindows[i]
+}
+
+// Recorder records input events and per-frame state,
+// so that a session can be replayed deterministically.
+// While recording, it replaces every event handler,
+// forwarding events to the handlers that were set
+// when the Recorder was created. Handlers must not be
+// set while recording.
+//
+// A frame is recorded by calling Frame before
+// Dispatch. Events dispatched afterwards belong to
+// that frame, until the next call to Frame.
+type Recorder struct {
+	w   *bufio.Writer
+	err error
+
+	windowClose      WindowCloseHandler
+	windowResize     WindowResizeHandler
+	keyboardEnter    KeyboardEnterHandler
+	keyboardLeave    KeyboardLeaveHandler
+	keyboardKey      KeyboardKeyHandler
+	keyboardModifier KeyboardModifierHandler
+	pointerEnter     PointerEnterHandler
+	pointerLeave     PointerLeaveHandler
+	pointerMotion    PointerMotionHandler
+	pointerButton    PointerButtonHandler
+	pointerRelMotion PointerRelativeMotionHandler
+	textInput        TextInputHandler
+	textComposition  TextCompositionHandler
+	windowScale      WindowScaleHandler
+}
+
+// NewRecorder creates a new Recorder that writes to w
+// and starts recording.
+func NewRecorder(w io.Writer) (*Recorder, error) {
+	r := &Recorder{
+		w:                bufio.NewWriter(w),
+		windowClose:      windowCloseHandler,
+		windowResize:     windowResizeHandler,
+		keyboardEnter:    keyboardEnterHandler,
+		keyboardLeave:    keyboardLeaveHandler,
+		keyboardKey:      keyboardKeyHandler,
+		keyboardModifier: keyboardModifierHandler,
+		pointerEnter:     pointerEnterHandler,
+		pointerLeave:     pointerLeaveHandler,
+		pointerMotion:    pointerMotionHandler,
+		pointerButton:    pointerButtonHandler,
+		pointerRelMotion: pointerRelativeMotionHandler,
+		textInput:        textInputHandler,
+		textComposition:  textCompositionHandler,
+		windowScale:      windowScaleHandler,
+	}
+	if _, err := r.w.Write(replayMagic[:]); err != nil {
+		return nil, err
+	}
+	SetWindowHandler(r)
+	SetKeyboardHandler(r)
+	SetPointerHandler(r)
+	SetPointerRelativeMotionHandler(r)
+	SetTextHandler(r)
+	SetWindowScaleHandler(r)
+	return r, nil
+}
+
+// Frame records the start of a frame.
+// seed is the seed from which the frame's random
+// numbers are generated, and dt is the time step of
+// the frame update.
+func (r *Recorder) Frame(seed uint64, dt time.Duration) error {
+	r.put(record{Op: opFrame, N: seed, M: uint64(dt)})
+	return r.err
+}
+
+// Stop stops recording and restores the handlers that
+// were replaced by r.
+// It returns the first error that occurred while
+// recording, if any.
+func (r *Recorder) Stop() error {
+	windowCloseHandler = r.windowClose
+	windowResizeHandler = r.windowResize
+	keyboardEnterHandler = r.keyboardEnter
+	keyboardLeaveHandler = r.keyboardLeave
+	keyboardKeyHandler = r.keyboardKey
+	keyboardModifierHandler = r.keyboardModifier
+	pointerEnterHandler = r.pointerEnter
+	pointerLeaveHandler = r.pointerLeave
+	pointerMotionHandler = r.pointerMotion
+	pointerButtonHandler = r.pointerButton
+	pointerRelativeMotionHandler = r.pointerRelMotion
+	textInputHandler = r.textInput
+	textCompositionHandler = r.textComposition
+	windowScaleHandler = r.windowScale
+	if r.err == nil {
+		r.err = r.w.Flush()
+	}
+	return r.err
+}
+
+// put writes rec unless a previous write failed.
+func (r *Recorder) put(rec record) {
+	if r.err == nil {
+		r.err = binary.Write(r.w, binary.LittleEndian, &rec)
+	}
+}
+
+// putText writes a text record followed by text.
+// Text longer than maxReplayText is truncated.
+func (r *Recorder) putText(op uint8, text string, cursor int) {
+	text = text[:min(len(text), maxReplayText)]
+	r.put(record{Op: op, A: int32(len(text)), B: int32(cursor)})
+	if r.err == nil {
+		_, r.err = r.w.WriteString(text)
+	}
+}
+
+// WindowClose implements WindowCloseHandler.
+func (r *Recorder) WindowClose(win Window) {
+	r.put(record{Op: opWindowClose, Win: windowIndex(win)})
+	if r.windowClose != nil {
+		r.windowClose.WindowClose(win)
+	}
+}
+
+// WindowResize implements WindowResizeHandler.
+func (r *Recorder) WindowResize(win Window, newWidth, newHeight int) {
+	r.put(record{Op: opWindowResize, Win: windowIndex(win), A: int32(newWidth), B: int32(newHeight)})
+	if r.windowResize != nil {
+		r.windowResize.WindowResize(win, newWidth, newHeight)
+	}
+}
+
+// WindowScale implements WindowScaleHandler.
+func (r *Recorder) WindowScale(win Window, newScale float64) {
+	r.put(record{Op: opWindowScale, Win: windowIndex(win), A: int32(math.Float32bits(float32(newScale)))})
+	if r.windowScale != nil {
+		r.windowScale.WindowScale(win, newScale)
+	}
+}
+
+// KeyboardEnter implements KeyboardEnterHandler.
+func (r *Recorder) KeyboardEnter(win Window) {
+	r.put(record{Op: opKeyboardEnter, Win: windowIndex(win)})
+	if r.keyboardEnter != nil {
+		r.keyboardEnter.KeyboardEnter(win)
+	}
+}
+
+// KeyboardLeave implements KeyboardLeaveHandler.
+func (r *Recorder) KeyboardLeave(win Window) {
+	r.put(record{Op: opKeyboardLeave, Win: windowIndex(win)})
+	if r.keyboardLeave != nil {
+		r.keyboardLeave.KeyboardLeave(win)
+	}
+}
+
+// KeyboardKey implements KeyboardKeyHandler.
+func (r *Recorder) KeyboardKey(key Key, pressed bool) {
+	r.put(record{Op: opKeyboardKey, A: int32(key), Pressed: pressed})
+	if r.keyboardKey != nil {
+		r.keyboardKey.KeyboardKey(key, pressed)
+	}
+}
+
+// KeyboardModifier implements KeyboardModifierHandler.
+func (r *Recorder) KeyboardModifier(modMask Modifier) {
+	r.put(record{Op: opKeyboardModifier, A: int32(modMask)})
+	if r.keyboardModifier != nil {
+		r.keyboardModifier.KeyboardModifier(modMask)
+	}
+}
+
+// PointerEnter implements PointerEnterHandler.
+func (r *Recorder) PointerEnter(win Window, x, y int) {
+	r.put(record{Op: opPointerEnter, Win: windowIndex(win), A: int32(x), B: int32(y)})
+	if r.pointerEnter != nil {
+		r.pointerEnter.PointerEnter(win, x, y)
+	}
+}
+
+// PointerLeave implements PointerLeaveHandler.
+func (r *Recorder) PointerLeave(win Window) {
+	r.put(record{Op: opPointerLeave, Win: windowIndex(win)})
+	if r.pointerLeave != nil {
+		r.pointerLeave.PointerLeave(win)
+	}
+}
+
+// PointerMotion implements PointerMotionHandler.
+func (r *Recorder) PointerMotion(newX, newY int) {
+	r.put(record{Op: opPointerMotion, A: int32(newX), B: int32(newY)})
+	if r.pointerMotion != nil {
+		r.pointerMotion.PointerMotion(newX, newY)
+	}
+}
+
+// PointerButton implements PointerButtonHandler.
+func (r *Recorder) PointerButton(btn Button, pressed bool) {
+	r.put(record{Op: opPointerButton, A: int32(btn), Pressed: pressed})
+	if r.pointerButton != nil {
+		r.pointerButton.PointerButton(btn, pressed)
+	}
+}
+
+// PointerRelativeMotion implements PointerRelativeMotionHandler.
+func (r *Recorder) PointerRelativeMotion(dx, dy int) {
+	r.put(record{Op: opPointerRelativeMotion, A: int32(dx), B: int32(dy)})
+	if r.pointerRelMotion != nil {
+		r.pointerRelMotion.PointerRelativeMotion(dx, dy)
+	}
+}
+
+// TextInput implements TextInputHandler.
+func (r *Recorder) TextInput(text string) {
+	r.putText(opTextInput, text, 0)
+	if r.textInput != nil {
+		r.textInput.TextInput(text)
+	}
+}
+
+// TextComposition implements TextCompositionHandler.
+func (r *Recorder) TextComposition(text string, cursor int) {
+	r.putText(opTextComposition, text, cursor)
+	if r.textComposition != nil {
+		r.textComposition.TextComposition(text, cursor)
+	}
+}
+
+// Replayer replays a session recorded by a Recorder.
+// Events are dispatched to the handlers that are set
+// when they are replayed. Windows are identified by
+// creation order, so the same windows must be created
+// (and closed) as in the recorded session.
+type Replayer struct {
+	r    *bufio.Reader
+	next record
+	text string
+	eof  bool
+}
+
+// NewReplayer creates a new Replayer that reads from r.
+func NewReplayer(r io.Reader) (*Replayer, error) {
+	p := &Replayer{r: bufio.NewReader(r)}
+	var magic [8]byte
+	if _, err := io.ReadFull(p.r, magic[:]); err != nil {
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			err = errReplay
+		}
+		return nil, err
+	}
+	if magic != replayMagic {
+		return nil, errReplay
+	}
+	if err := p.read(); err != nil {
+		return nil, err
+	}
+	if !p.eof && p.next.Op != opFrame {
+		return nil, errReplay
+	}
+	return p, nil
+}
+
+// read reads the next record.
+func (p *Replayer) read() error {
+	err := binary.Read(p.r, binary.LittleEndian, &p.next)
+	switch err {
+	case nil:
+		return p.readText()
+	case io.EOF:
+		p.eof = true
+		return nil
+	case io.ErrUnexpectedEOF:
+		return errReplay
+	}
+	return err
+}
+
+// readText reads the text that follows p.next, if any.
+func (p *Replayer) readText() error {
+	p.text = ""
+	switch p.next.Op {
+	case opTextInput, opTextComposition:
+	default:
+		return nil
+	}
+	n := p.next.A
+	if n < 0 || n > maxReplayText {
+		return errReplay
+	}
+	b := make([]byte, n)
+	if _, err := io.ReadFull(p.r, b); err != nil {
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			err = errReplay
+		}
+		return err
+	}
+	p.text = string(b)
+	return nil
+}
+
+// Frame replays the next frame.
+// It should be called in place of Dispatch. It
+// dispatches the frame's events and returns the values
+// that were passed to Recorder.Frame.
+// It returns io.EOF when there are no more frames.
+func (p *Replayer) Frame() (seed uint64, dt time.Duration, err error) {
+	if p.eof {
+		return 0, 0, io.EOF
+	}
+	seed, dt = p.next.N, time.Duration(p.next.M)
+	for {
+		if err = p.read(); err != nil || p.eof || p.next.Op == opFrame {
+			return
+		}
+		if err = p.dispatch(&p.next); err != nil {
+			return
+		}
+	}
+}
+
+// dispatch dispatches the event of rec.
+func (p *Replayer) dispatch(rec *record) error {
+	win := windowAt(rec.Win)
+	switch rec.Op {
+	case opWindowClose:
+		if windowCloseHandler != nil {
+			windowCloseHandler.WindowClose(win)
+		}
+	case opWindowResize:
+		if windowResizeHandler != nil {
+			windowResizeHandler.WindowResize(win, int(rec.A), int(rec.B))
+		}
+	case opWindowScale:
+		if windowScaleHandler != nil {
+			windowScaleHandler.WindowScale(win, float64(math.Float32frombits(uint32(rec.A))))
+		}
+	case opKeyboardEnter:
+		if keyboardEnterHandler != nil {
+			keyboardEnterHandler.KeyboardEnter(win)
+		}
+	case opKeyboardLeave:
+		if keyboardLeaveHandler != nil {
+			keyboardLeaveHandler.KeyboardLeave(win)
+		}
+	case opKeyboardKey:
+		if keyboardKeyHandler != nil {
+			keyboardKeyHandler.KeyboardKey(Key(rec.A), rec.Pressed)
+		}
+	case opKeyboardModifier:
+		if keyboardModifierHandler != nil {
+			keyboardModifierHandler.KeyboardModifier(Modifier(rec.A))
+		}
+	case opPointerEnter:
+		if pointerEnterHandler != nil {
+			pointerEnterHandler.PointerEnter(win, int(rec.A), int(rec.B))
+		}
+	case opPointerLeave:
+		if pointerLeaveHandler != nil {
+			pointerLeaveHandler.PointerLeave(win)
+		}
+	case opPointerMotion:
+		if pointerMotionHandler != nil {
+			pointerMotionHandler.PointerMotion(int(rec.A), int(rec.B))
+		}
+	case opPointerButton:
+		if pointerButtonHandler != nil {
+			pointerButtonHandler.PointerButton(Button(rec.A), rec.Pressed)
+		}
+	case opPointerRelativeMotion:
+		if pointerRelativeMotionHandler != nil {
+			pointerRelativeMotionHandler.PointerRelativeMotion(int(rec.A), int(rec.B))
+		}
+	case opTextInput:
+		if textInputHandler != nil {
+			textInputHandler.TextInput(p.text)
+		}
+	case opTextComposition:
+		if textCompositionHandler != nil {
+			textCompositionHandler.TextComposition(p.text, int(rec.B))
+		}
+	default:
+		return errReplay
+	}
+	return nil
+}
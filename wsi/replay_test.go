@@ -0,0 +1,142 @@
+// Copyright 2024 Gustavo C. Viegas. All rights reserved.
+
+package wsi
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"slices"
+	"testing"
+	"time"
+)
+
+// L logs the events that it handles.
+type L struct{ log []string }
+
+func (l *L) add(format string, args ...any) { l.log = append(l.log, fmt.Sprintf(format, args...)) }
+
+func (l *L) WindowClose(win Window)                 { l.add("close %v", win) }
+func (l *L) WindowResize(win Window, w, h int)      { l.add("resize %v %d %d", win, w, h) }
+func (l *L) WindowScale(win Window, scale float64)  { l.add("scale %v %v", win, scale) }
+func (l *L) KeyboardEnter(win Window)               { l.add("kenter %v", win) }
+func (l *L) KeyboardLeave(win Window)               { l.add("kleave %v", win) }
+func (l *L) KeyboardKey(key Key, pressed bool)      { l.add("key %d %t", key, pressed) }
+func (l *L) KeyboardModifier(modMask Modifier)      { l.add("mod %x", modMask) }
+func (l *L) PointerEnter(win Window, x, y int)      { l.add("penter %v %d %d", win, x, y) }
+func (l *L) PointerLeave(win Window)                { l.add("pleave %v", win) }
+func (l *L) PointerMotion(x, y int)                 { l.add("motion %d %d", x, y) }
+func (l *L) PointerButton(btn Button, pressed bool) { l.add("button %d %t", btn, pressed) }
+func (l *L) PointerRelativeMotion(dx, dy int)       { l.add("relmotion %d %d", dx, dy) }
+func (l *L) TextInput(text string)                  { l.add("text %q", text) }
+func (l *L) TextComposition(text string, cursor int) {
+	l.add("composition %q %d", text, cursor)
+}
+
+func setL(l *L) {
+	SetWindowHandler(l)
+	SetKeyboardHandler(l)
+	SetPointerHandler(l)
+	SetPointerRelativeMotionHandler(l)
+	SetTextHandler(l)
+	SetWindowScaleHandler(l)
+}
+
+func TestReplay(t *testing.T) {
+	defer func() {
+		SetWindowHandler(nil)
+		SetKeyboardHandler(nil)
+		SetPointerHandler(nil)
+		SetPointerRelativeMotionHandler(nil)
+		SetTextHandler(nil)
+		SetWindowScaleHandler(nil)
+	}()
+	var rec L
+	setL(&rec)
+	var buf bytes.Buffer
+	r, err := NewRecorder(&buf)
+	if err != nil {
+		t.Fatalf("NewRecorder failed: %v", err)
+	}
+	type frame struct {
+		seed uint64
+		dt   time.Duration
+	}
+	frames := []frame{{1, time.Second / 60}, {0xdeadbeef, time.Second / 30}, {42, 0}}
+	// The events are delivered through the
+	// installed handlers, as Dispatch would do.
+	r.Frame(frames[0].seed, frames[0].dt)
+	keyboardEnterHandler.KeyboardEnter(nil)
+	keyboardKeyHandler.KeyboardKey(KeyW, true)
+	keyboardModifierHandler.KeyboardModifier(ModShift | ModCtrl)
+	r.Frame(frames[1].seed, frames[1].dt)
+	pointerEnterHandler.PointerEnter(nil, 10, 20)
+	pointerMotionHandler.PointerMotion(-5, 300)
+	pointerButtonHandler.PointerButton(BtnLeft, true)
+	pointerButtonHandler.PointerButton(BtnLeft, false)
+	keyboardKeyHandler.KeyboardKey(KeyW, false)
+	pointerRelativeMotionHandler.PointerRelativeMotion(3, -7)
+	textCompositionHandler.TextComposition("にほ", 6)
+	textCompositionHandler.TextComposition("", 0)
+	textInputHandler.TextInput("日本")
+	textInputHandler.TextInput("")
+	r.Frame(frames[2].seed, frames[2].dt)
+	windowResizeHandler.WindowResize(nil, 640, 480)
+	windowScaleHandler.WindowScale(nil, 1.5)
+	pointerLeaveHandler.PointerLeave(nil)
+	keyboardLeaveHandler.KeyboardLeave(nil)
+	windowCloseHandler.WindowClose(nil)
+	if err := r.Stop(); err != nil {
+		t.Fatalf("Recorder.Stop failed: %v", err)
+	}
+	if keyboardKeyHandler != KeyboardKeyHandler(&rec) {
+		t.Fatal("Recorder.Stop: handlers not restored")
+	}
+	if len(rec.log) != 18 {
+		t.Fatalf("Recorder: forwarded events\nhave %d\nwant 18", len(rec.log))
+	}
+
+	var rep L
+	setL(&rep)
+	p, err := NewReplayer(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatalf("NewReplayer failed: %v", err)
+	}
+	for i, f := range frames {
+		seed, dt, err := p.Frame()
+		if err != nil {
+			t.Fatalf("Replayer.Frame failed: %v", err)
+		}
+		if seed != f.seed || dt != f.dt {
+			t.Fatalf("Replayer.Frame [%d]: seed, dt\nhave %v, %v\nwant %v, %v", i, seed, dt, f.seed, f.dt)
+		}
+	}
+	if _, _, err := p.Frame(); err != io.EOF {
+		t.Fatalf("Replayer.Frame: err\nhave %v\nwant %v", err, io.EOF)
+	}
+	if !slices.Equal(rep.log, rec.log) {
+		t.Fatalf("Replayer: events\nhave %v\nwant %v", rep.log, rec.log)
+	}
+}
+
+func TestReplayInvalid(t *testing.T) {
+	for _, b := range [][]byte{
+		nil,
+		[]byte("neo3"),
+		[]byte("not a replay file"),
+		append(replayMagic[:], 1, 2, 3),
+		// Text longer than the data that follows.
+		append(replayMagic[:], opTextInput, 0, 0, 0, 4, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 'a'),
+	} {
+		if _, err := NewReplayer(bytes.NewReader(b)); err != errReplay {
+			t.Fatalf("NewReplayer(%q): err\nhave %v\nwant %v", b, err, errReplay)
+		}
+	}
+	p, err := NewReplayer(bytes.NewReader(replayMagic[:]))
+	if err != nil {
+		t.Fatalf("NewReplayer failed: %v", err)
+	}
+	if _, _, err := p.Frame(); err != io.EOF {
+		t.Fatalf("Replayer.Frame: err\nhave %v\nwant %v", err, io.EOF)
+	}
+}
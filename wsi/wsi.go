@@ -6,6 +6,12 @@
 // is conditionally supported. Moreover, WSI support in
 // a driver is not guaranteed.
 //
+// Setting the NEO3_HEADLESS environment variable (to
+// any value) prevents the package from connecting to
+// the window system, so programs that only use the GPU
+// for computation can run without a display.
+// PlatformInUse reports None in this case.
+//
 // NOTE: This package's functionality must only be used
 // on main's goroutine.
 package wsi
@@ -425,6 +431,285 @@ var (
 	pointerButtonHandler PointerButtonHandler
 )
 
+// PointerRelativeMotionHandler is the callback for relative pointer
+// motion events.
+type PointerRelativeMotionHandler interface {
+	// PointerRelativeMotion is called when the pointer moves
+	// while the cursor is locked (see CursorLocked).
+	PointerRelativeMotion(dx, dy int)
+}
+
+// SetPointerRelativeMotionHandler sets the relative pointer
+// motion handler.
+func SetPointerRelativeMotionHandler(ph PointerRelativeMotionHandler) {
+	pointerRelativeMotionHandler = ph
+}
+
+var pointerRelativeMotionHandler PointerRelativeMotionHandler
+
+// CursorMode is the type of cursor modes.
+type CursorMode int
+
+// Cursor modes.
+const (
+	// The cursor is visible and moves freely.
+	CursorNormal CursorMode = iota
+	// The cursor is hidden while over the window.
+	CursorHidden
+	// The cursor is hidden and locked to the window,
+	// as needed by first-person cameras. Pointer
+	// motion is reported through the relative motion
+	// handler rather than the motion handler.
+	// It is not supported on Wayland.
+	CursorLocked
+)
+
+// SetCursorMode sets the cursor mode of win.
+// At most one window can have its cursor locked at a
+// time. Locking the cursor to win sets the mode of the
+// previously locked window to CursorNormal.
+// It returns an error if mode is not supported by the
+// platform.
+func SetCursorMode(win Window, mode CursorMode) error {
+	if win == nil {
+		return errors.New("wsi: nil window")
+	}
+	switch mode {
+	case CursorNormal, CursorHidden, CursorLocked:
+	default:
+		return errors.New("wsi: undefined cursor mode")
+	}
+	return setCursorMode(win, mode)
+}
+
+var setCursorMode func(Window, CursorMode) error
+
+// Cursor is the interface that defines a custom cursor
+// image.
+type Cursor interface {
+	// Close destroys the cursor.
+	// Windows using the cursor revert to the default
+	// cursor.
+	Close()
+}
+
+// The maximum width and height of a cursor.
+const MaxCursorSize = 256
+
+// NewCursor creates a new cursor.
+// data contains the width by height image in row-major
+// order, with four bytes per pixel in RGBA order (not
+// premultiplied). hotX and hotY identify the pixel
+// that is the cursor's position.
+// Platforms that lack color cursors reduce the image
+// to black and white.
+func NewCursor(width, height, hotX, hotY int, data []byte) (Cursor, error) {
+	switch {
+	case width <= 0 || height <= 0 || width > MaxCursorSize || height > MaxCursorSize:
+		return nil, errors.New("wsi: invalid cursor size")
+	case hotX < 0 || hotY < 0 || hotX >= width || hotY >= height:
+		return nil, errors.New("wsi: cursor hotspot out of bounds")
+	case len(data) < width*height*4:
+		return nil, errors.New("wsi: cursor data too short")
+	}
+	return newCursor(width, height, hotX, hotY, data)
+}
+
+// SetCursor sets the cursor displayed while the pointer
+// is over win.
+// If cur is nil, the default cursor is used.
+// It has no visible effect until the cursor mode of win
+// is CursorNormal.
+func SetCursor(win Window, cur Cursor) error {
+	if win == nil {
+		return errors.New("wsi: nil window")
+	}
+	return setCursor(win, cur)
+}
+
+var (
+	newCursor func(int, int, int, int, []byte) (Cursor, error)
+	setCursor func(Window, Cursor) error
+)
+
+// Clipboard returns the text in the clipboard.
+// It returns an empty string if the clipboard is empty
+// or contains no text.
+func Clipboard() (string, error) {
+	return clipboard()
+}
+
+// SetClipboard replaces the contents of the clipboard
+// with the given text.
+func SetClipboard(s string) error {
+	return setClipboard(s)
+}
+
+var (
+	clipboard    func() (string, error)
+	setClipboard func(string) error
+)
+
+// TextInputHandler is the callback for text input events.
+type TextInputHandler interface {
+	// TextInput is called when text is entered, either
+	// by typing or by committing an IME composition.
+	// Keys pressed with Ctrl or Alt held do not produce
+	// text.
+	TextInput(text string)
+}
+
+// TextCompositionHandler is the callback for IME composition
+// events.
+// Compositions are only reported on Windows. Other
+// platforms have no input method support and report
+// just the text of key presses.
+type TextCompositionHandler interface {
+	// TextComposition is called when the text being
+	// composed changes. cursor is the byte offset in
+	// text of the IME's caret. An empty text ends the
+	// composition.
+	TextComposition(text string, cursor int)
+}
+
+// TextHandler is the interface that groups all text callbacks.
+type TextHandler interface {
+	TextInputHandler
+	TextCompositionHandler
+}
+
+// SetTextInputHandler sets the text input handler.
+func SetTextInputHandler(th TextInputHandler) {
+	textInputHandler = th
+}
+
+// SetTextCompositionHandler sets the text composition handler.
+func SetTextCompositionHandler(th TextCompositionHandler) {
+	textCompositionHandler = th
+}
+
+// SetTextHandler sets the text handler.
+func SetTextHandler(th TextHandler) {
+	SetTextInputHandler(th)
+	SetTextCompositionHandler(th)
+}
+
+var (
+	textInputHandler       TextInputHandler
+	textCompositionHandler TextCompositionHandler
+)
+
+// StartTextInput enables text input events for win.
+// x, y, width and height describe the area of the
+// window where text is being edited, which the IME
+// uses to place its candidate window (the area is
+// ignored on platforms without input method support).
+// It can be called again to move the area.
+// On Wayland, it fails if libxkbcommon is not
+// available.
+func StartTextInput(win Window, x, y, width, height int) error {
+	if win == nil {
+		return errors.New("wsi: nil window")
+	}
+	return startTextInput(win, x, y, width, height)
+}
+
+// StopTextInput disables text input events for win.
+// Any ongoing composition is canceled.
+func StopTextInput(win Window) {
+	if win != nil {
+		stopTextInput(win)
+	}
+}
+
+var (
+	startTextInput func(Window, int, int, int, int) error
+	stopTextInput  func(Window)
+)
+
+// Monitor describes a display monitor.
+// X, Y, Width and Height give the monitor's area in the
+// desktop, in pixels. PhysWidth and PhysHeight are the
+// monitor's physical size in millimeters, or zero if
+// unknown.
+// Scale is the factor by which content should be
+// scaled to appear at its intended size (e.g., 2 for a
+// monitor with twice the pixel density of a 96 DPI
+// one).
+// RefreshRate is the refresh rate of the monitor's
+// current mode in hertz, or zero if unknown.
+type Monitor struct {
+	Name                  string
+	X, Y                  int
+	Width, Height         int
+	PhysWidth, PhysHeight int
+	Scale                 float64
+	RefreshRate           float64
+	Primary               bool
+}
+
+// Monitors returns the connected monitors.
+// The primary monitor, if known, comes first.
+func Monitors() ([]Monitor, error) {
+	return monitors()
+}
+
+// WindowScale returns the scale factor of win.
+// It is the Scale of the monitor that the window is on
+// (the largest one, if the window spans several) and
+// changes when the window moves between monitors (see
+// WindowScaleHandler).
+// Content drawn at this scale appears crisp and at its
+// intended size. It is 1 if unknown.
+func WindowScale(win Window) float64 {
+	if win == nil {
+		return 1
+	}
+	return windowScale(win)
+}
+
+// PixelSize returns the size of win's drawable area, in
+// pixels.
+// Window.Width and Window.Height are given in the
+// platform's window coordinates, which are pixels on
+// Win32 and XCB but logical units on Wayland, where the
+// compositor scales windows by their buffer scale.
+// Swapchains must be created with the pixel size, and
+// should be recreated when it changes.
+func PixelSize(win Window) (width, height int) {
+	if win == nil {
+		return
+	}
+	return pixelSize(win)
+}
+
+// WindowScaleHandler is the callback for window scale events.
+type WindowScaleHandler interface {
+	// WindowScale is called when the scale factor of a
+	// window changes (see WindowScale).
+	WindowScale(win Window, newScale float64)
+}
+
+// SetWindowScaleHandler sets the window scale handler.
+func SetWindowScaleHandler(wh WindowScaleHandler) {
+	windowScaleHandler = wh
+}
+
+var windowScaleHandler WindowScaleHandler
+
+var (
+	monitors    func() ([]Monitor, error)
+	windowScale func(Window) float64
+	pixelSize   func(Window) (int, int)
+)
+
+// windowPixelSize returns the width and height of win.
+// It is used as pixelSize by platforms whose window
+// coordinates are pixels.
+func windowPixelSize(win Window) (int, int) {
+	return win.Width(), win.Height()
+}
+
 // Dispatch dispatches queued events.
 func Dispatch() {
 	dispatch()
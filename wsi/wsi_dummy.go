@@ -12,6 +12,16 @@ func initDummy() {
 	newWindow = newWindowDummy
 	dispatch = dispatchDummy
 	setAppName = setAppNameDummy
+	setCursorMode = setCursorModeDummy
+	newCursor = newCursorDummy
+	setCursor = setCursorDummy
+	clipboard = clipboardDummy
+	setClipboard = setClipboardDummy
+	startTextInput = startTextInputDummy
+	stopTextInput = stopTextInputDummy
+	monitors = monitorsDummy
+	windowScale = windowScaleDummy
+	pixelSize = windowPixelSize
 	platform = None
 }
 
@@ -19,5 +29,18 @@ func newWindowDummy(int, int, string) (Window, error) {
 	return nil, errMissing
 }
 
-func dispatchDummy()         {}
-func setAppNameDummy(string) {}
+func newCursorDummy(int, int, int, int, []byte) (Cursor, error) {
+	return nil, errMissing
+}
+
+func setCursorModeDummy(Window, CursorMode) error          { return errMissing }
+func setCursorDummy(Window, Cursor) error                  { return errMissing }
+func clipboardDummy() (string, error)                      { return "", errMissing }
+func setClipboardDummy(string) error                       { return errMissing }
+func startTextInputDummy(Window, int, int, int, int) error { return errMissing }
+func monitorsDummy() ([]Monitor, error)                    { return nil, errMissing }
+func windowScaleDummy(Window) float64                      { return 1 }
+
+func dispatchDummy()            {}
+func setAppNameDummy(string)    {}
+func stopTextInputDummy(Window) {}
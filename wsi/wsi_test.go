@@ -21,6 +21,9 @@ func TestWSI(t *testing.T) {
 	SetPointerLeaveHandler(E{})
 	SetPointerMotionHandler(E{})
 	SetPointerButtonHandler(E{})
+	SetPointerRelativeMotionHandler(E{})
+	SetTextHandler(E{})
+	SetWindowScaleHandler(E{})
 	switch plat {
 	case None:
 		win, err := NewWindow(480, 360, "Will fail")
@@ -34,6 +37,18 @@ func TestWSI(t *testing.T) {
 		Dispatch()
 		// Dummy SetAppName does nothing.
 		SetAppName("Won't be displayed")
+		if _, err := Clipboard(); err != errMissing {
+			t.Fatalf("Clipboard: err\nhave %v\nwant %v", err, errMissing)
+		}
+		if err := SetClipboard("Won't be copied"); err != errMissing {
+			t.Fatalf("SetClipboard: err\nhave %v\nwant %v", err, errMissing)
+		}
+		if cur, err := NewCursor(1, 1, 0, 0, make([]byte, 4)); cur != nil || err != errMissing {
+			t.Fatalf("NewCursor: cur, err\nhave %v, %v\nwant nil, %v", cur, err, errMissing)
+		}
+		if mons, err := Monitors(); mons != nil || err != errMissing {
+			t.Fatalf("Monitors: mons, err\nhave %v, %v\nwant nil, %v", mons, err, errMissing)
+		}
 	default:
 		win, err := NewWindow(480, 360, "My window")
 		if err != nil {
@@ -47,12 +62,57 @@ func TestWSI(t *testing.T) {
 		if n := len(Windows()); n != 1 {
 			t.Fatalf("len(Windows())\nhave %v\nwant 1", n)
 		}
+		if mons, err := Monitors(); err != nil {
+			t.Logf("Monitors (error): %v", err)
+		} else {
+			for _, m := range mons {
+				t.Logf("Monitor: %+v", m)
+				if m.Scale <= 0 {
+					t.Fatalf("Monitors: %s: non-positive Scale %v", m.Name, m.Scale)
+				}
+			}
+		}
 		win.Unmap()
 		win.Map()
 		for i := 0; i < 100; i++ {
 			Dispatch()
 			time.Sleep(time.Millisecond * 42)
 		}
+		if err := SetCursorMode(win, CursorHidden); err != nil {
+			t.Logf("SetCursorMode (error): %v", err)
+		}
+		data := make([]byte, 16*16*4)
+		for i := 0; i < len(data); i += 4 {
+			copy(data[i:], []byte{255, 0, 0, 255})
+		}
+		if cur, err := NewCursor(16, 16, 8, 8, data); err != nil {
+			t.Logf("NewCursor (error): %v", err)
+		} else {
+			if err := SetCursor(win, cur); err != nil {
+				t.Logf("SetCursor (error): %v", err)
+			}
+			SetCursorMode(win, CursorNormal)
+			for i := 0; i < 24; i++ {
+				Dispatch()
+				time.Sleep(time.Millisecond * 42)
+			}
+			cur.Close()
+		}
+		if err := SetClipboard("neo3/wsi"); err != nil {
+			t.Logf("SetClipboard (error): %v", err)
+		} else if s, err := Clipboard(); err != nil || s != "neo3/wsi" {
+			t.Fatalf("Clipboard: s, err\nhave %q, %v\nwant \"neo3/wsi\", nil", s, err)
+		}
+		if err := StartTextInput(win, 0, 0, 100, 20); err != nil {
+			t.Logf("StartTextInput (error): %v", err)
+		}
+		StopTextInput(win)
+		if s := WindowScale(win); s <= 0 {
+			t.Fatalf("WindowScale: non-positive scale %v", s)
+		}
+		if w, h := PixelSize(win); w < win.Width() || h < win.Height() {
+			t.Fatalf("PixelSize: size smaller than the window's\nhave %d, %d\nwant at least %d, %d", w, h, win.Width(), win.Height())
+		}
 		win.Resize(600, 300)
 		win.SetTitle(time.Now().Format(time.RFC1123))
 		if s := AppName(); s != "" {
@@ -72,6 +132,55 @@ func TestWSI(t *testing.T) {
 	}
 }
 
+// W is a Window that no platform created.
+type W struct{ Window }
+
+func TestCursorParam(t *testing.T) {
+	for _, x := range [...]struct {
+		w, h, hotX, hotY, n int
+	}{
+		{0, 16, 0, 0, 1024},
+		{16, -1, 0, 0, 1024},
+		{MaxCursorSize + 1, 16, 0, 0, 1 << 20},
+		{16, 16, 16, 0, 1024},
+		{16, 16, 0, -1, 1024},
+		{16, 16, 0, 0, 1023},
+	} {
+		cur, err := NewCursor(x.w, x.h, x.hotX, x.hotY, make([]byte, x.n))
+		if cur != nil || err == nil || err == errMissing {
+			t.Fatalf("NewCursor(%d, %d, %d, %d, [%d]byte): unexpected result %v, %v", x.w, x.h, x.hotX, x.hotY, x.n, cur, err)
+		}
+	}
+	for _, x := range [...]struct {
+		win  Window
+		mode CursorMode
+	}{
+		{nil, CursorNormal},
+		{nil, CursorLocked},
+		{W{}, CursorLocked + 1},
+		{W{}, -1},
+	} {
+		if err := SetCursorMode(x.win, x.mode); err == nil || err == errMissing {
+			t.Fatalf("SetCursorMode(%v, %d): unexpected result %v", x.win, x.mode, err)
+		}
+	}
+	if err := SetCursor(nil, nil); err == nil || err == errMissing {
+		t.Fatalf("SetCursor(nil, nil): unexpected result %v", err)
+	}
+	if err := StartTextInput(nil, 0, 0, 1, 1); err == nil || err == errMissing {
+		t.Fatalf("StartTextInput(nil, ...): unexpected result %v", err)
+	}
+}
+
+func TestScaleParam(t *testing.T) {
+	if s := WindowScale(nil); s != 1 {
+		t.Fatalf("WindowScale(nil)\nhave %v\nwant 1", s)
+	}
+	if w, h := PixelSize(nil); w != 0 || h != 0 {
+		t.Fatalf("PixelSize(nil)\nhave %d, %d\nwant 0, 0", w, h)
+	}
+}
+
 type E struct{}
 
 func (E) WindowClose(win Window) {
@@ -82,6 +191,10 @@ func (E) WindowResize(win Window, newWidth, newHeight int) {
 	fmt.Printf("E.WindowResize: %v, %d, %d\n", win, newWidth, newHeight)
 }
 
+func (E) WindowScale(win Window, newScale float64) {
+	fmt.Printf("E.WindowScale: %v, %v\n", win, newScale)
+}
+
 func (E) KeyboardEnter(win Window) {
 	fmt.Printf("E.KeyboardEnter: %v\n", win)
 }
@@ -113,3 +226,15 @@ func (E) PointerMotion(newX, newY int) {
 func (E) PointerButton(btn Button, pressed bool) {
 	fmt.Printf("E.PointerButton: %d, %t\n", btn, pressed)
 }
+
+func (E) PointerRelativeMotion(dx, dy int) {
+	fmt.Printf("E.PointerRelativeMotion: %d, %d\n", dx, dy)
+}
+
+func (E) TextInput(text string) {
+	fmt.Printf("E.TextInput: %q\n", text)
+}
+
+func (E) TextComposition(text string, cursor int) {
+	fmt.Printf("E.TextComposition: %q, %d\n", text, cursor)
+}
@@ -9,6 +9,7 @@
 #include <_cgo_export.h>
 
 #define LIBWAYLAND "libwayland-client.so.0"
+#define LIBXKBCOMMON "libxkbcommon.so.0"
 
 static struct wl_display* (*displayConnect)(const char*);
 static void (*displayDisconnect)(struct wl_display*);
@@ -68,6 +69,100 @@ void closeWayland(void* handle) {
 	dlclose(handle);
 }
 
+static struct xkb_context* (*contextNewXKB)(int);
+static void (*contextUnrefXKB)(struct xkb_context*);
+static struct xkb_keymap* (*keymapNewFromStringXKB)(struct xkb_context*, const char*, int, int);
+static void (*keymapUnrefXKB)(struct xkb_keymap*);
+static struct xkb_state* (*stateNewXKB)(struct xkb_keymap*);
+static void (*stateUnrefXKB)(struct xkb_state*);
+static int (*stateUpdateMaskXKB)(struct xkb_state*, uint32_t, uint32_t, uint32_t, uint32_t, uint32_t, uint32_t);
+static uint32_t (*stateKeyGetOneSymXKB)(struct xkb_state*, uint32_t);
+static int (*stateModNameIsActiveXKB)(struct xkb_state*, const char*, int);
+static uint32_t (*keysymToUTF32XKB)(uint32_t);
+
+void* openXKB(void) {
+	void* handle = dlopen(LIBXKBCOMMON, RTLD_LAZY|RTLD_LOCAL);
+	if (handle == NULL)
+		return NULL;
+
+	contextNewXKB = dlsym(handle, "xkb_context_new");
+	if (contextNewXKB == NULL)
+		goto nosym;
+	contextUnrefXKB = dlsym(handle, "xkb_context_unref");
+	if (contextUnrefXKB == NULL)
+		goto nosym;
+	keymapNewFromStringXKB = dlsym(handle, "xkb_keymap_new_from_string");
+	if (keymapNewFromStringXKB == NULL)
+		goto nosym;
+	keymapUnrefXKB = dlsym(handle, "xkb_keymap_unref");
+	if (keymapUnrefXKB == NULL)
+		goto nosym;
+	stateNewXKB = dlsym(handle, "xkb_state_new");
+	if (stateNewXKB == NULL)
+		goto nosym;
+	stateUnrefXKB = dlsym(handle, "xkb_state_unref");
+	if (stateUnrefXKB == NULL)
+		goto nosym;
+	stateUpdateMaskXKB = dlsym(handle, "xkb_state_update_mask");
+	if (stateUpdateMaskXKB == NULL)
+		goto nosym;
+	stateKeyGetOneSymXKB = dlsym(handle, "xkb_state_key_get_one_sym");
+	if (stateKeyGetOneSymXKB == NULL)
+		goto nosym;
+	stateModNameIsActiveXKB = dlsym(handle, "xkb_state_mod_name_is_active");
+	if (stateModNameIsActiveXKB == NULL)
+		goto nosym;
+	keysymToUTF32XKB = dlsym(handle, "xkb_keysym_to_utf32");
+	if (keysymToUTF32XKB == NULL)
+		goto nosym;
+
+	return handle;
+
+nosym:
+	dlclose(handle);
+	return NULL;
+}
+
+void closeXKB(void* handle) {
+	dlclose(handle);
+}
+
+// XKB_KEYMAP_FORMAT_TEXT_V1.
+#define KEYMAP_FORMAT_XKB 1
+
+// XKB_STATE_MODS_EFFECTIVE.
+#define MODS_EFFECTIVE_XKB (1 << 3)
+
+struct xkb_state* newStateXKB(const char* keymap) {
+	struct xkb_context* ctx = contextNewXKB(0);
+	if (ctx == NULL)
+		return NULL;
+	struct xkb_keymap* km = keymapNewFromStringXKB(ctx, keymap, KEYMAP_FORMAT_XKB, 0);
+	contextUnrefXKB(ctx);
+	if (km == NULL)
+		return NULL;
+	struct xkb_state* st = stateNewXKB(km);
+	keymapUnrefXKB(km);
+	return st;
+}
+
+void destroyStateXKB(struct xkb_state* st) {
+	stateUnrefXKB(st);
+}
+
+void updateStateXKB(struct xkb_state* st, uint32_t depressed, uint32_t latched, uint32_t locked, uint32_t group) {
+	stateUpdateMaskXKB(st, depressed, latched, locked, 0, 0, group);
+}
+
+uint32_t keyCharXKB(struct xkb_state* st, uint32_t key) {
+	return keysymToUTF32XKB(stateKeyGetOneSymXKB(st, key));
+}
+
+int shortcutXKB(struct xkb_state* st) {
+	return stateModNameIsActiveXKB(st, "Control", MODS_EFFECTIVE_XKB) > 0 ||
+		stateModNameIsActiveXKB(st, "Mod1", MODS_EFFECTIVE_XKB) > 0;
+}
+
 static const struct wl_interface* nullInterface[8];
 
 const struct wl_interface displayInterfaceWayland = {
@@ -396,6 +491,77 @@ const struct wl_interface popupInterfaceXDG = {
 	},
 };
 
+const struct wl_interface dataDeviceManagerInterfaceWayland = {
+	.name = "wl_data_device_manager",
+	.version = 3,
+	.method_count = 2,
+	.methods = (const struct wl_message[2]){
+		{ "create_data_source", "n", (const struct wl_interface*[1]){&dataSourceInterfaceWayland} },
+		{ "get_data_device", "no", (const struct wl_interface*[2]){&dataDeviceInterfaceWayland, &seatInterfaceWayland} },
+	},
+	.event_count = 0,
+	.events = NULL,
+};
+
+const struct wl_interface dataSourceInterfaceWayland = {
+	.name = "wl_data_source",
+	.version = 3,
+	.method_count = 3,
+	.methods = (const struct wl_message[3]){
+		{ "offer", "s", nullInterface },
+		{ "destroy", "", nullInterface },
+		{ "set_actions", "3u", nullInterface },
+	},
+	.event_count = 6,
+	.events = (const struct wl_message[6]){
+		{ "target", "?s", nullInterface },
+		{ "send", "sh", nullInterface },
+		{ "cancelled", "", nullInterface },
+		{ "dnd_drop_performed", "3", nullInterface },
+		{ "dnd_finished", "3", nullInterface },
+		{ "action", "3u", nullInterface },
+	},
+};
+
+const struct wl_interface dataDeviceInterfaceWayland = {
+	.name = "wl_data_device",
+	.version = 3,
+	.method_count = 3,
+	.methods = (const struct wl_message[3]){
+		{ "start_drag", "?oo?ou", (const struct wl_interface*[4]){&dataSourceInterfaceWayland, &surfaceInterfaceWayland, &surfaceInterfaceWayland} },
+		{ "set_selection", "?ou", (const struct wl_interface*[2]){&dataSourceInterfaceWayland} },
+		{ "release", "2", nullInterface },
+	},
+	.event_count = 6,
+	.events = (const struct wl_message[6]){
+		{ "data_offer", "n", (const struct wl_interface*[1]){&dataOfferInterfaceWayland} },
+		{ "enter", "uoff?o", (const struct wl_interface*[5]){NULL, &surfaceInterfaceWayland, NULL, NULL, &dataOfferInterfaceWayland} },
+		{ "leave", "", nullInterface },
+		{ "motion", "uff", nullInterface },
+		{ "drop", "", nullInterface },
+		{ "selection", "?o", (const struct wl_interface*[1]){&dataOfferInterfaceWayland} },
+	},
+};
+
+const struct wl_interface dataOfferInterfaceWayland = {
+	.name = "wl_data_offer",
+	.version = 3,
+	.method_count = 5,
+	.methods = (const struct wl_message[5]){
+		{ "accept", "u?s", nullInterface },
+		{ "receive", "sh", nullInterface },
+		{ "destroy", "", nullInterface },
+		{ "finish", "3", nullInterface },
+		{ "set_actions", "3uu", nullInterface },
+	},
+	.event_count = 3,
+	.events = (const struct wl_message[3]){
+		{ "offer", "s", nullInterface },
+		{ "source_actions", "3u", nullInterface },
+		{ "action", "3u", nullInterface },
+	},
+};
+
 struct wl_display* displayConnectWayland(const char* name) {
 	return displayConnect(name);
 }
@@ -553,6 +719,54 @@ void surfaceDamageBufferWayland(struct wl_surface* sf, int32_t x, int32_t y, int
 	proxyMarshalFlags((struct wl_proxy*)sf, WL_SURFACE_DAMAGE_BUFFER, NULL, proxyGetVersion((struct wl_proxy*)sf), 0, x, y, width, height);
 }
 
+void surfaceSetBufferScaleWayland(struct wl_surface* sf, int32_t scale) {
+	uint32_t vers = proxyGetVersion((struct wl_proxy*)sf);
+	if (vers >= WL_SURFACE_SET_BUFFER_SCALE_SINCE_VERSION)
+		proxyMarshalFlags((struct wl_proxy*)sf, WL_SURFACE_SET_BUFFER_SCALE, NULL, vers, 0, scale);
+}
+
+static void outputGeometry(void* data_, struct wl_output* out, int32_t x, int32_t y, int32_t physWidth, int32_t physHeight, int32_t subpixel_, const char* manuf, const char* model, int32_t xform_) {
+	outputGeometryWayland(out, x, y, physWidth, physHeight, (char*)manuf, (char*)model);
+}
+
+static void outputMode(void* data_, struct wl_output* out, uint32_t flags, int32_t width, int32_t height, int32_t refresh) {
+	outputModeWayland(out, flags, width, height, refresh);
+}
+
+static void outputDone(void* data_, struct wl_output* out) {
+	outputDoneWayland(out);
+}
+
+static void outputScale(void* data_, struct wl_output* out, int32_t factor) {
+	outputScaleWayland(out, factor);
+}
+
+static void outputName(void* data_, struct wl_output* out, const char* name) {
+	outputNameWayland(out, (char*)name);
+}
+
+static void outputDescription(void* data_, struct wl_output* out_, const char* desc_) {}
+
+int outputAddListenerWayland(struct wl_output* out) {
+	static const struct wl_output_listener ltn = {
+		.geometry = outputGeometry,
+		.mode = outputMode,
+		.done = outputDone,
+		.scale = outputScale,
+		.name = outputName,
+		.description = outputDescription,
+	};
+	return proxyAddListener((struct wl_proxy*)out, (void (**)(void))&ltn, NULL);
+}
+
+void outputDestroyWayland(struct wl_output* out) {
+	proxyDestroy((struct wl_proxy*)out);
+}
+
+void outputReleaseWayland(struct wl_output* out) {
+	proxyMarshalFlags((struct wl_proxy*)out, WL_OUTPUT_RELEASE, NULL, proxyGetVersion((struct wl_proxy*)out), WL_MARSHAL_FLAG_DESTROY);
+}
+
 static void wmBasePing(void* data_, struct xdg_wm_base* wm_, uint32_t serial) {
 	wmBasePingXDG(serial);
 }
@@ -827,3 +1041,116 @@ void keyboardDestroyWayland(struct wl_keyboard* kb) {
 void keyboardReleaseWayland(struct wl_keyboard* kb) {
 	proxyMarshalFlags((struct wl_proxy*)kb, WL_KEYBOARD_RELEASE, NULL, proxyGetVersion((struct wl_proxy*)kb), WL_MARSHAL_FLAG_DESTROY);
 }
+
+void dataDeviceManagerDestroyWayland(struct wl_data_device_manager* ddm) {
+	proxyDestroy((struct wl_proxy*)ddm);
+}
+
+struct wl_data_source* dataDeviceManagerCreateDataSourceWayland(struct wl_data_device_manager* ddm) {
+	return (struct wl_data_source*)proxyMarshalFlags(
+		(struct wl_proxy*)ddm, WL_DATA_DEVICE_MANAGER_CREATE_DATA_SOURCE, &dataSourceInterfaceWayland, proxyGetVersion((struct wl_proxy*)ddm), 0, NULL);
+}
+
+struct wl_data_device* dataDeviceManagerGetDataDeviceWayland(struct wl_data_device_manager* ddm, struct wl_seat* seat) {
+	return (struct wl_data_device*)proxyMarshalFlags(
+		(struct wl_proxy*)ddm, WL_DATA_DEVICE_MANAGER_GET_DATA_DEVICE, &dataDeviceInterfaceWayland, proxyGetVersion((struct wl_proxy*)ddm), 0, NULL, seat);
+}
+
+static void dataSourceTarget(void* data_, struct wl_data_source* src_, const char* mime_) {}
+
+static void dataSourceSend(void* data_, struct wl_data_source* src, const char* mime, int32_t fd) {
+	dataSourceSendWayland(src, (char*)mime, fd);
+}
+
+static void dataSourceCancelled(void* data_, struct wl_data_source* src) {
+	dataSourceCancelledWayland(src);
+}
+
+static void dataSourceDnDDropPerformed(void* data_, struct wl_data_source* src_) {}
+
+static void dataSourceDnDFinished(void* data_, struct wl_data_source* src_) {}
+
+static void dataSourceAction(void* data_, struct wl_data_source* src_, uint32_t action_) {}
+
+int dataSourceAddListenerWayland(struct wl_data_source* src) {
+	static const struct wl_data_source_listener ltn = {
+		.target = dataSourceTarget,
+		.send = dataSourceSend,
+		.cancelled = dataSourceCancelled,
+		.dnd_drop_performed = dataSourceDnDDropPerformed,
+		.dnd_finished = dataSourceDnDFinished,
+		.action = dataSourceAction,
+	};
+	return proxyAddListener((struct wl_proxy*)src, (void (**)(void))&ltn, NULL);
+}
+
+void dataSourceOfferWayland(struct wl_data_source* src, const char* mime) {
+	proxyMarshalFlags((struct wl_proxy*)src, WL_DATA_SOURCE_OFFER, NULL, proxyGetVersion((struct wl_proxy*)src), 0, mime);
+}
+
+void dataSourceDestroyWayland(struct wl_data_source* src) {
+	proxyMarshalFlags((struct wl_proxy*)src, WL_DATA_SOURCE_DESTROY, NULL, proxyGetVersion((struct wl_proxy*)src), WL_MARSHAL_FLAG_DESTROY);
+}
+
+static void dataDeviceDataOffer(void* data_, struct wl_data_device* dd_, struct wl_data_offer* offer) {
+	dataDeviceDataOfferWayland(offer);
+}
+
+static void dataDeviceEnter(void* data_, struct wl_data_device* dd_, uint32_t serial_, struct wl_surface* sf_, wl_fixed_t x_, wl_fixed_t y_, struct wl_data_offer* offer) {
+	dataDeviceEnterWayland(offer);
+}
+
+static void dataDeviceLeave(void* data_, struct wl_data_device* dd_) {}
+
+static void dataDeviceMotion(void* data_, struct wl_data_device* dd_, uint32_t millis_, wl_fixed_t x_, wl_fixed_t y_) {}
+
+static void dataDeviceDrop(void* data_, struct wl_data_device* dd_) {}
+
+static void dataDeviceSelection(void* data_, struct wl_data_device* dd_, struct wl_data_offer* offer) {
+	dataDeviceSelectionWayland(offer);
+}
+
+int dataDeviceAddListenerWayland(struct wl_data_device* dd) {
+	static const struct wl_data_device_listener ltn = {
+		.data_offer = dataDeviceDataOffer,
+		.enter = dataDeviceEnter,
+		.leave = dataDeviceLeave,
+		.motion = dataDeviceMotion,
+		.drop = dataDeviceDrop,
+		.selection = dataDeviceSelection,
+	};
+	return proxyAddListener((struct wl_proxy*)dd, (void (**)(void))&ltn, NULL);
+}
+
+void dataDeviceDestroyWayland(struct wl_data_device* dd) {
+	proxyDestroy((struct wl_proxy*)dd);
+}
+
+void dataDeviceSetSelectionWayland(struct wl_data_device* dd, struct wl_data_source* src, uint32_t serial) {
+	proxyMarshalFlags((struct wl_proxy*)dd, WL_DATA_DEVICE_SET_SELECTION, NULL, proxyGetVersion((struct wl_proxy*)dd), 0, src, serial);
+}
+
+static void dataOfferOffer(void* data_, struct wl_data_offer* offer, const char* mime) {
+	dataOfferOfferWayland(offer, (char*)mime);
+}
+
+static void dataOfferSourceActions(void* data_, struct wl_data_offer* offer_, uint32_t actions_) {}
+
+static void dataOfferAction(void* data_, struct wl_data_offer* offer_, uint32_t action_) {}
+
+int dataOfferAddListenerWayland(struct wl_data_offer* offer) {
+	static const struct wl_data_offer_listener ltn = {
+		.offer = dataOfferOffer,
+		.source_actions = dataOfferSourceActions,
+		.action = dataOfferAction,
+	};
+	return proxyAddListener((struct wl_proxy*)offer, (void (**)(void))&ltn, NULL);
+}
+
+void dataOfferReceiveWayland(struct wl_data_offer* offer, const char* mime, int32_t fd) {
+	proxyMarshalFlags((struct wl_proxy*)offer, WL_DATA_OFFER_RECEIVE, NULL, proxyGetVersion((struct wl_proxy*)offer), 0, mime, fd);
+}
+
+void dataOfferDestroyWayland(struct wl_data_offer* offer) {
+	proxyMarshalFlags((struct wl_proxy*)offer, WL_DATA_OFFER_DESTROY, NULL, proxyGetVersion((struct wl_proxy*)offer), WL_MARSHAL_FLAG_DESTROY);
+}
@@ -14,14 +14,23 @@ import "C"
 import (
 	"errors"
 	"fmt"
+	"io"
 	"math"
 	"os"
+	"slices"
+	"time"
+	"unicode"
 	"unsafe"
 )
 
 // Handle for the shared object.
 var hWayland unsafe.Pointer
 
+// Handle for the xkbcommon shared object, which is
+// needed for text input. It is nil if the library
+// could not be opened.
+var hXKB unsafe.Pointer
+
 // Common Wayland variables.
 var (
 	dpyWayland  *C.struct_wl_display
@@ -32,12 +41,15 @@ var (
 	seatWayland *C.struct_wl_seat
 	ptWayland   *C.struct_wl_pointer
 	kbWayland   *C.struct_wl_keyboard
+	ddmWayland  *C.struct_wl_data_device_manager
+	ddWayland   *C.struct_wl_data_device
 
 	// Name of globals in the server.
 	nameCptWayland  C.uint32_t
 	nameShmWayland  C.uint32_t
 	nameWMXDG       C.uint32_t
 	nameSeatWayland C.uint32_t
+	nameDDMWayland  C.uint32_t
 )
 
 // initWayland initializes the Wayland platform.
@@ -106,6 +118,18 @@ func initWayland() (err error) {
 		err = errors.New("wsi: keyboardAddListenerWayland failed")
 		return
 	}
+	if ddmWayland != nil {
+		if ddWayland = C.dataDeviceManagerGetDataDeviceWayland(ddmWayland, seatWayland); ddWayland == nil {
+			err = errors.New("wsi: dataDeviceManagerGetDataDeviceWayland failed")
+			return
+		}
+		if C.dataDeviceAddListenerWayland(ddWayland) != 0 {
+			err = errors.New("wsi: dataDeviceAddListenerWayland failed")
+			return
+		}
+	}
+	// Text input is not available if this fails.
+	hXKB = C.openXKB()
 	C.displayRoundtripWayland(dpyWayland)
 
 	initDefaultCursorWayland()
@@ -113,6 +137,16 @@ func initWayland() (err error) {
 	newWindow = newWindowWayland
 	dispatch = dispatchWayland
 	setAppName = setAppNameWayland
+	setCursorMode = setCursorModeWayland
+	newCursor = newCursorFromRGBAWayland
+	setCursor = setCursorWayland
+	clipboard = clipboardWayland
+	setClipboard = setClipboardWayland
+	startTextInput = startTextInputWayland
+	stopTextInput = stopTextInputWayland
+	monitors = monitorsWayland
+	windowScale = windowScaleWayland
+	pixelSize = pixelSizeWayland
 	platform = Wayland
 	return
 }
@@ -128,6 +162,15 @@ func deinitWayland() {
 	}
 	curWayland.destroy()
 	if dpyWayland != nil {
+		freeClipboardWayland()
+		if ddmWayland != nil {
+			C.dataDeviceManagerDestroyWayland(ddmWayland)
+			ddmWayland = nil
+		}
+		for _, o := range outputsWayland {
+			o.destroy()
+		}
+		outputsWayland = nil
 		if cptWayland != nil {
 			C.compositorDestroyWayland(cptWayland)
 			cptWayland = nil
@@ -159,20 +202,37 @@ func deinitWayland() {
 		C.displayDisconnectWayland(dpyWayland)
 		dpyWayland = nil
 	}
+	if xkbWayland != nil {
+		C.destroyStateXKB(xkbWayland)
+		xkbWayland = nil
+	}
+	if hXKB != nil {
+		C.closeXKB(hXKB)
+		hXKB = nil
+	}
 	C.closeWayland(hWayland)
 	initDummy()
 }
 
 // windowWayland implements Window.
 type windowWayland struct {
-	wsf      *C.struct_wl_surface
-	xsf      *C.struct_xdg_surface
-	toplevel *C.struct_xdg_toplevel
-	width    int
-	height   int
-	title    string
-	ctitle   []C.char
-	mapped   bool
+	wsf       *C.struct_wl_surface
+	xsf       *C.struct_xdg_surface
+	toplevel  *C.struct_xdg_toplevel
+	width     int
+	height    int
+	title     string
+	ctitle    []C.char
+	mapped    bool
+	mode      CursorMode
+	cursor    *cursorWayland
+	textInput bool
+	// Buffer scale, the scale preferred by the
+	// compositor (0 if not sent) and the outputs
+	// that the surface is on.
+	scale     int
+	prefScale int
+	outputs   []*outputWayland
 }
 
 // newWindowWayland creates a new window.
@@ -226,6 +286,7 @@ func newWindowWayland(width, height int, title string) (Window, error) {
 		title:    title,
 		ctitle:   unsafe.Slice(C.CString(title), len(title)+1),
 		mapped:   false,
+		scale:    1,
 	}, nil
 }
 
@@ -281,6 +342,12 @@ func (w *windowWayland) SetTitle(title string) error {
 func (w *windowWayland) Close() {
 	if w != nil {
 		closeWindow(w)
+		if ptFocusWayland == w {
+			ptFocusWayland = nil
+		}
+		if kbFocusWayland == w {
+			kbFocusWayland = nil
+		}
 		if dpyWayland != nil {
 			C.toplevelDestroyXDG(w.toplevel)
 			C.surfaceDestroyXDG(w.xsf)
@@ -301,15 +368,103 @@ func (w *windowWayland) Height() int { return w.height }
 // Title returns the window's title.
 func (w *windowWayland) Title() string { return w.title }
 
+// updateScale updates the buffer scale of w from the
+// compositor's preference or, failing that, from the
+// largest scale among the outputs that w is on.
+// The new scale takes effect on the next commit, so
+// swapchains must be recreated with the new pixel size
+// before presenting again.
+// TODO: Support fractional scales.
+func (w *windowWayland) updateScale() {
+	scale := w.prefScale
+	if scale <= 0 {
+		scale = 1
+		for _, o := range w.outputs {
+			scale = max(scale, o.scale)
+		}
+	}
+	if scale == w.scale {
+		return
+	}
+	w.scale = scale
+	C.surfaceSetBufferScaleWayland(w.wsf, C.int32_t(scale))
+	if windowScaleHandler != nil {
+		windowScaleHandler.WindowScale(w, float64(scale))
+	}
+}
+
+// windowScaleWayland returns the scale factor of the given
+// window.
+func windowScaleWayland(win Window) float64 {
+	return float64(win.(*windowWayland).scale)
+}
+
+// pixelSizeWayland returns the size of the given window
+// in pixels.
+func pixelSizeWayland(win Window) (int, int) {
+	w := win.(*windowWayland)
+	return w.width * w.scale, w.height * w.scale
+}
+
+// outputWayland is a wl_output global.
+type outputWayland struct {
+	out   *C.struct_wl_output
+	name  C.uint32_t
+	vers  C.uint32_t
+	scale int
+	mon   Monitor
+}
+
+// Outputs advertised by the compositor.
+var outputsWayland []*outputWayland
+
+// outputFromWayland returns the outputWayland in
+// outputsWayland whose out field matches out, or nil
+// if none does.
+func outputFromWayland(out *C.struct_wl_output) *outputWayland {
+	for _, o := range outputsWayland {
+		if o.out == out {
+			return o
+		}
+	}
+	return nil
+}
+
+// destroy destroys the wl_output of o.
+func (o *outputWayland) destroy() {
+	if o.vers >= 3 {
+		C.outputReleaseWayland(o.out)
+	} else {
+		C.outputDestroyWayland(o.out)
+	}
+	o.out = nil
+}
+
+// monitorsWayland returns the connected monitors.
+// Wayland has no notion of a primary monitor.
+func monitorsWayland() ([]Monitor, error) {
+	ms := make([]Monitor, len(outputsWayland))
+	for i, o := range outputsWayland {
+		ms[i] = o.mon
+		ms[i].Scale = float64(o.scale)
+	}
+	return ms, nil
+}
+
 // cursorWayland defines a cursor surface.
+// It implements Cursor.
 type cursorWayland struct {
-	fd  C.int
-	buf *C.struct_wl_buffer
-	sf  *C.struct_wl_surface
+	fd   C.int
+	buf  *C.struct_wl_buffer
+	sf   *C.struct_wl_surface
+	hotX C.int32_t
+	hotY C.int32_t
 }
 
 // newCursorWayland creates a new cursor.
-func newCursorWayland(data []byte) (*cursorWayland, error) {
+// data must contain premultiplied ARGB8888 pixels in
+// the byte order of wl_shm.
+func newCursorWayland(width, height, hotX, hotY int, data []byte) (*cursorWayland, error) {
 	if cptWayland == nil {
 		return nil, errors.New("wsi: cptWayland is nil")
 	}
@@ -317,14 +472,9 @@ func newCursorWayland(data []byte) (*cursorWayland, error) {
 		return nil, errors.New("wsi: shmWayland is nil")
 	}
 
-	// TODO: Take as param.
-	const (
-		width  = 16
-		height = 16
-		stride = width * 4
-		format = C.WL_SHM_FORMAT_ARGB8888
-		size   = width * height * 4
-	)
+	const format = C.WL_SHM_FORMAT_ARGB8888
+	stride := width * 4
+	size := stride * height
 
 	if len(data) < size {
 		return nil, errors.New("wsi: invalid cursor data")
@@ -338,7 +488,7 @@ func newCursorWayland(data []byte) (*cursorWayland, error) {
 	}
 	n := C.size_t(size)
 	for {
-		i := size - n
+		i := C.size_t(size) - n
 		x := C.write(fd, unsafe.Pointer(&data[i]), n)
 		if x == -1 {
 			// TODO: Handle errors if possible.
@@ -351,12 +501,12 @@ func newCursorWayland(data []byte) (*cursorWayland, error) {
 		}
 	}
 
-	shmp := C.shmCreatePoolWayland(shmWayland, fd, size)
+	shmp := C.shmCreatePoolWayland(shmWayland, fd, C.int32_t(size))
 	if shmp == nil {
 		C.close(fd)
 		return nil, errors.New("wsi: shmCreatePoolWayland failed")
 	}
-	buf := C.shmPoolCreateBufferWayland(shmp, 0, width, height, stride, format)
+	buf := C.shmPoolCreateBufferWayland(shmp, 0, C.int32_t(width), C.int32_t(height), C.int32_t(stride), format)
 	C.shmPoolDestroyWayland(shmp)
 	if buf == nil {
 		C.close(fd)
@@ -375,12 +525,45 @@ func newCursorWayland(data []byte) (*cursorWayland, error) {
 	C.displayFlushWayland(dpyWayland)
 
 	return &cursorWayland{
-		fd:  fd,
-		buf: buf,
-		sf:  sf,
+		fd:   fd,
+		buf:  buf,
+		sf:   sf,
+		hotX: C.int32_t(hotX),
+		hotY: C.int32_t(hotY),
 	}, nil
 }
 
+// newCursorFromRGBAWayland creates a new cursor from
+// RGBA data that is not premultiplied.
+func newCursorFromRGBAWayland(width, height, hotX, hotY int, data []byte) (Cursor, error) {
+	argb := make([]byte, width*height*4)
+	for i := 0; i < len(argb); i += 4 {
+		a := uint(data[i+3])
+		argb[i] = byte(uint(data[i+2]) * a / 255)
+		argb[i+1] = byte(uint(data[i+1]) * a / 255)
+		argb[i+2] = byte(uint(data[i]) * a / 255)
+		argb[i+3] = data[i+3]
+	}
+	c, err := newCursorWayland(width, height, hotX, hotY, argb)
+	if err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// Close destroys the cursor.
+func (c *cursorWayland) Close() {
+	if c == nil || c == curWayland {
+		return
+	}
+	for _, w := range createdWindows {
+		if w != nil && w.(*windowWayland).cursor == c {
+			setCursorWayland(w, nil)
+		}
+	}
+	c.destroy()
+}
+
 // destroy destroys the cursor.
 func (c *cursorWayland) destroy() {
 	if c == nil {
@@ -477,10 +660,242 @@ func initDefaultCursorWayland() (err error) {
 			}
 		}
 	}
-	curWayland, err = newCursorWayland(data)
+	curWayland, err = newCursorWayland(width, height, width/2, height/2, data)
 	return
 }
 
+// Pointer focus, as given by the latest enter event.
+var (
+	ptFocusWayland  *windowWayland
+	ptSerialWayland C.uint32_t
+)
+
+// applyCursorWayland sets the cursor image to that of
+// the window that has pointer focus.
+func applyCursorWayland() {
+	w := ptFocusWayland
+	if w == nil || ptWayland == nil {
+		return
+	}
+	cur := w.cursor
+	if cur == nil {
+		cur = curWayland
+	}
+	switch {
+	case w.mode != CursorNormal:
+		C.pointerSetCursorWayland(ptWayland, ptSerialWayland, nil, 0, 0)
+	case cur != nil:
+		C.pointerSetCursorWayland(ptWayland, ptSerialWayland, cur.sf, cur.hotX, cur.hotY)
+	default:
+		fmt.Fprint(os.Stderr, "[!] wsi: undefined cursor image\n")
+	}
+}
+
+// setCursorModeWayland sets the cursor mode of the given
+// window.
+func setCursorModeWayland(win Window, mode CursorMode) error {
+	if mode == CursorLocked {
+		// TODO: Requires the pointer-constraints and
+		// relative-pointer protocols.
+		return errors.New("wsi: cursor locking not supported on Wayland")
+	}
+	w := win.(*windowWayland)
+	w.mode = mode
+	if ptFocusWayland == w {
+		applyCursorWayland()
+		C.displayFlushWayland(dpyWayland)
+	}
+	return nil
+}
+
+// setCursorWayland sets the cursor of the given window.
+func setCursorWayland(win Window, cur Cursor) error {
+	w := win.(*windowWayland)
+	if cur != nil {
+		w.cursor = cur.(*cursorWayland)
+	} else {
+		w.cursor = nil
+	}
+	if ptFocusWayland == w {
+		applyCursorWayland()
+		C.displayFlushWayland(dpyWayland)
+	}
+	return nil
+}
+
+// Clipboard state.
+// offersWayland holds the MIME types of every data
+// offer that was not destroyed yet. The offer of the
+// current selection is selOfferWayland. clipSrcWayland
+// is the source of the selection set by SetClipboard,
+// and clipTextWayland is its text.
+var (
+	offersWayland   = make(map[*C.struct_wl_data_offer][]string)
+	selOfferWayland *C.struct_wl_data_offer
+	clipSrcWayland  *C.struct_wl_data_source
+	clipTextWayland string
+)
+
+// MIME types of text in the clipboard, in order of
+// preference.
+var textMIMEWayland = [...]string{
+	"text/plain;charset=utf-8",
+	"UTF8_STRING",
+	"text/plain",
+	"TEXT",
+	"STRING",
+}
+
+// How long to wait for the selection's source to send
+// the clipboard's contents.
+const clipTimeoutWayland = time.Second
+
+// Serial of the latest input event, which is needed to
+// set the selection.
+var serialWayland C.uint32_t
+
+// clipboardWayland returns the text in the clipboard.
+func clipboardWayland() (string, error) {
+	if ddWayland == nil {
+		return "", errors.New("wsi: wl_data_device_manager not present")
+	}
+	if clipSrcWayland != nil {
+		return clipTextWayland, nil
+	}
+	offer := selOfferWayland
+	if offer == nil {
+		return "", nil
+	}
+	var mime string
+	for _, m := range textMIMEWayland {
+		if slices.Contains(offersWayland[offer], m) {
+			mime = m
+			break
+		}
+	}
+	if mime == "" {
+		// The source has no text to give.
+		return "", nil
+	}
+	r, w, err := os.Pipe()
+	if err != nil {
+		return "", err
+	}
+	defer r.Close()
+	cmime := C.CString(mime)
+	// The file descriptor is duplicated when the
+	// request is marshaled.
+	C.dataOfferReceiveWayland(offer, cmime, C.int32_t(w.Fd()))
+	C.free(unsafe.Pointer(cmime))
+	C.displayFlushWayland(dpyWayland)
+	w.Close()
+	r.SetReadDeadline(time.Now().Add(clipTimeoutWayland))
+	b, err := io.ReadAll(r)
+	if err != nil {
+		if errors.Is(err, os.ErrDeadlineExceeded) {
+			return "", errors.New("wsi: clipboard request timed out")
+		}
+		return "", err
+	}
+	return string(b), nil
+}
+
+// setClipboardWayland sets the text in the clipboard.
+func setClipboardWayland(s string) error {
+	if ddWayland == nil {
+		return errors.New("wsi: wl_data_device_manager not present")
+	}
+	src := C.dataDeviceManagerCreateDataSourceWayland(ddmWayland)
+	if src == nil {
+		return errors.New("wsi: dataDeviceManagerCreateDataSourceWayland failed")
+	}
+	if C.dataSourceAddListenerWayland(src) != 0 {
+		C.dataSourceDestroyWayland(src)
+		return errors.New("wsi: dataSourceAddListenerWayland failed")
+	}
+	for _, m := range textMIMEWayland {
+		cmime := C.CString(m)
+		C.dataSourceOfferWayland(src, cmime)
+		C.free(unsafe.Pointer(cmime))
+	}
+	C.dataDeviceSetSelectionWayland(ddWayland, src, serialWayland)
+	C.displayFlushWayland(dpyWayland)
+	if clipSrcWayland != nil {
+		C.dataSourceDestroyWayland(clipSrcWayland)
+	}
+	clipSrcWayland = src
+	clipTextWayland = s
+	return nil
+}
+
+// destroyOfferWayland destroys a data offer.
+func destroyOfferWayland(offer *C.struct_wl_data_offer) {
+	if offer != nil {
+		C.dataOfferDestroyWayland(offer)
+		delete(offersWayland, offer)
+	}
+}
+
+// freeClipboardWayland destroys the data device and the
+// clipboard state.
+func freeClipboardWayland() {
+	destroyOfferWayland(selOfferWayland)
+	selOfferWayland = nil
+	if clipSrcWayland != nil {
+		C.dataSourceDestroyWayland(clipSrcWayland)
+		clipSrcWayland = nil
+		clipTextWayland = ""
+	}
+	if ddWayland != nil {
+		C.dataDeviceDestroyWayland(ddWayland)
+		ddWayland = nil
+	}
+}
+
+// Keyboard state, created from the keymap sent by the
+// compositor. It is nil if hXKB is nil.
+var xkbWayland *C.struct_xkb_state
+
+// Keyboard focus, as given by the latest enter event.
+var kbFocusWayland *windowWayland
+
+// startTextInputWayland enables text input in the given
+// window.
+// There is no input method support, so the text of
+// key presses is reported but compositions are not.
+func startTextInputWayland(win Window, _, _, _, _ int) error {
+	if hXKB == nil {
+		return errors.New("wsi: text input requires libxkbcommon")
+	}
+	win.(*windowWayland).textInput = true
+	return nil
+}
+
+// stopTextInputWayland disables text input in the given
+// window.
+func stopTextInputWayland(win Window) {
+	win.(*windowWayland).textInput = false
+}
+
+// textEventWayland reports the text of a key press.
+// key is an evdev keycode.
+func textEventWayland(key C.uint32_t) {
+	w := kbFocusWayland
+	if textInputHandler == nil || xkbWayland == nil || w == nil || !w.textInput {
+		return
+	}
+	if C.shortcutXKB(xkbWayland) != 0 {
+		// Shortcuts are reported as keys.
+		return
+	}
+	// XKB keycodes are evdev keycodes plus 8.
+	r := rune(C.keyCharXKB(xkbWayland, key+8))
+	if r == 0 || unicode.IsControl(r) {
+		return
+	}
+	textInputHandler.TextInput(string(r))
+}
+
 //export registryGlobalWayland
 func registryGlobalWayland(name C.uint32_t, iface *C.char, vers C.uint32_t) {
 	switch C.GoString(iface) {
@@ -508,6 +923,25 @@ func registryGlobalWayland(name C.uint32_t, iface *C.char, vers C.uint32_t) {
 		p := C.registryBindWayland(rtyWayland, name, i, vers)
 		seatWayland = (*C.struct_wl_seat)(p)
 		nameSeatWayland = name
+	case "wl_data_device_manager":
+		i := &C.dataDeviceManagerInterfaceWayland
+		vers = min(vers, C.uint32_t(i.version))
+		p := C.registryBindWayland(rtyWayland, name, i, vers)
+		ddmWayland = (*C.struct_wl_data_device_manager)(p)
+		nameDDMWayland = name
+	case "wl_output":
+		i := &C.outputInterfaceWayland
+		vers = min(vers, C.uint32_t(i.version))
+		p := C.registryBindWayland(rtyWayland, name, i, vers)
+		if p == nil {
+			return
+		}
+		o := &outputWayland{out: (*C.struct_wl_output)(p), name: name, vers: vers, scale: 1}
+		if C.outputAddListenerWayland(o.out) != 0 {
+			o.destroy()
+			return
+		}
+		outputsWayland = append(outputsWayland, o)
 	}
 }
 
@@ -539,6 +973,8 @@ func registryGlobalRemoveWayland(name C.uint32_t) {
 		wmXDG = nil
 		nameWMXDG = 0
 	case name == nameSeatWayland && seatWayland != nil:
+		freeClipboardWayland()
+		kbFocusWayland = nil
 		if ptWayland != nil {
 			C.pointerDestroyWayland(ptWayland)
 			ptWayland = nil
@@ -550,6 +986,30 @@ func registryGlobalRemoveWayland(name C.uint32_t) {
 		C.seatDestroyWayland(seatWayland)
 		seatWayland = nil
 		nameSeatWayland = 0
+	case name == nameDDMWayland && ddmWayland != nil:
+		freeClipboardWayland()
+		C.dataDeviceManagerDestroyWayland(ddmWayland)
+		ddmWayland = nil
+		nameDDMWayland = 0
+	default:
+		for i, o := range outputsWayland {
+			if o.name != name {
+				continue
+			}
+			outputsWayland = slices.Delete(outputsWayland, i, i+1)
+			for _, w := range createdWindows {
+				if w == nil {
+					continue
+				}
+				w := w.(*windowWayland)
+				if j := slices.Index(w.outputs, o); j >= 0 {
+					w.outputs = slices.Delete(w.outputs, j, j+1)
+					w.updateScale()
+				}
+			}
+			o.destroy()
+			break
+		}
 	}
 }
 
@@ -560,17 +1020,94 @@ func shmFormatWayland(format C.uint32_t) {}
 func bufferReleaseWayland(buf *C.struct_wl_buffer) {}
 
 //export surfaceEnterWayland
-func surfaceEnterWayland(sf *C.struct_wl_surface, out *C.struct_wl_output) {}
+func surfaceEnterWayland(sf *C.struct_wl_surface, out *C.struct_wl_output) {
+	win, o := windowFromWayland(sf), outputFromWayland(out)
+	if win == nil || o == nil {
+		return
+	}
+	w := win.(*windowWayland)
+	if !slices.Contains(w.outputs, o) {
+		w.outputs = append(w.outputs, o)
+		w.updateScale()
+	}
+}
 
 //export surfaceLeaveWayland
-func surfaceLeaveWayland(sf *C.struct_wl_surface, out *C.struct_wl_output) {}
+func surfaceLeaveWayland(sf *C.struct_wl_surface, out *C.struct_wl_output) {
+	win, o := windowFromWayland(sf), outputFromWayland(out)
+	if win == nil || o == nil {
+		return
+	}
+	w := win.(*windowWayland)
+	if i := slices.Index(w.outputs, o); i >= 0 {
+		w.outputs = slices.Delete(w.outputs, i, i+1)
+		w.updateScale()
+	}
+}
 
 //export surfacePreferredBufferScaleWayland
-func surfacePreferredBufferScaleWayland(sf *C.struct_wl_surface, factor C.int32_t) {}
+func surfacePreferredBufferScaleWayland(sf *C.struct_wl_surface, factor C.int32_t) {
+	if win := windowFromWayland(sf); win != nil {
+		w := win.(*windowWayland)
+		w.prefScale = int(factor)
+		w.updateScale()
+	}
+}
 
 //export surfacePreferredBufferTransformWayland
 func surfacePreferredBufferTransformWayland(sf *C.struct_wl_surface, xform C.uint32_t) {}
 
+//export outputGeometryWayland
+func outputGeometryWayland(out *C.struct_wl_output, x, y, physWidth, physHeight C.int32_t, manuf, model *C.char) {
+	if o := outputFromWayland(out); o != nil {
+		o.mon.X = int(x)
+		o.mon.Y = int(y)
+		o.mon.PhysWidth = int(physWidth)
+		o.mon.PhysHeight = int(physHeight)
+		if o.mon.Name == "" {
+			// Replaced by the output's name
+			// if the compositor sends it.
+			o.mon.Name = C.GoString(manuf) + " " + C.GoString(model)
+		}
+	}
+}
+
+//export outputModeWayland
+func outputModeWayland(out *C.struct_wl_output, flags C.uint32_t, width, height, refresh C.int32_t) {
+	if o := outputFromWayland(out); o != nil && flags&C.WL_OUTPUT_MODE_CURRENT != 0 {
+		o.mon.Width = int(width)
+		o.mon.Height = int(height)
+		o.mon.RefreshRate = float64(refresh) / 1000
+	}
+}
+
+//export outputDoneWayland
+func outputDoneWayland(out *C.struct_wl_output) {
+	o := outputFromWayland(out)
+	if o == nil {
+		return
+	}
+	for _, w := range createdWindows {
+		if w != nil && slices.Contains(w.(*windowWayland).outputs, o) {
+			w.(*windowWayland).updateScale()
+		}
+	}
+}
+
+//export outputScaleWayland
+func outputScaleWayland(out *C.struct_wl_output, factor C.int32_t) {
+	if o := outputFromWayland(out); o != nil {
+		o.scale = max(1, int(factor))
+	}
+}
+
+//export outputNameWayland
+func outputNameWayland(out *C.struct_wl_output, name *C.char) {
+	if o := outputFromWayland(out); o != nil {
+		o.mon.Name = C.GoString(name)
+	}
+}
+
 //export wmBasePingXDG
 func wmBasePingXDG(serial C.uint32_t) {
 	C.wmBasePongXDG(wmXDG, serial)
@@ -631,21 +1168,22 @@ func seatNameWayland(name *C.char) {}
 
 //export pointerEnterWayland
 func pointerEnterWayland(serial C.uint32_t, sf *C.struct_wl_surface, x, y C.wl_fixed_t) {
-	if curWayland != nil {
-		const x, y = 8, 8 // TODO
-		C.pointerSetCursorWayland(ptWayland, serial, curWayland.sf, x, y)
-	} else {
-		fmt.Fprint(os.Stderr, "[!] wsi: undefined cursor image\n")
+	win := windowFromWayland(sf)
+	ptFocusWayland = nil
+	if win != nil {
+		ptFocusWayland = win.(*windowWayland)
 	}
-	if pointerEnterHandler != nil {
-		if win := windowFromWayland(sf); win != nil {
-			pointerEnterHandler.PointerEnter(win, int(x/256), int(y/256))
-		}
+	ptSerialWayland = serial
+	serialWayland = serial
+	applyCursorWayland()
+	if pointerEnterHandler != nil && win != nil {
+		pointerEnterHandler.PointerEnter(win, int(x/256), int(y/256))
 	}
 }
 
 //export pointerLeaveWayland
 func pointerLeaveWayland(serial C.uint32_t, sf *C.struct_wl_surface) {
+	ptFocusWayland = nil
 	if pointerLeaveHandler != nil {
 		if win := windowFromWayland(sf); win != nil {
 			pointerLeaveHandler.PointerLeave(win)
@@ -662,6 +1200,7 @@ func pointerMotionWayland(millis C.uint32_t, x, y C.wl_fixed_t) {
 
 //export pointerButtonWayland
 func pointerButtonWayland(serial, millis, button, state C.uint32_t) {
+	serialWayland = serial
 	if pointerButtonHandler != nil {
 		btn := BtnUnknown
 		switch button {
@@ -720,27 +1259,52 @@ func pointerAxisRelativeDirectionWayland(axis C.uint32_t, direction C.uint32_t)
 
 //export keyboardKeymapWayland
 func keyboardKeymapWayland(format C.uint32_t, fd C.int32_t, size C.uint32_t) {
+	defer C.close(C.int(fd))
 	if format != C.WL_KEYBOARD_KEYMAP_FORMAT_XKB_V1 {
 		fmt.Fprintf(os.Stderr, "wsi: unknown Wayland keymap format (%d) - cannot use seat's keyboard\n", format)
 		if kbWayland != nil {
 			C.keyboardDestroyWayland(kbWayland)
 			kbWayland = nil
 		}
+		return
+	}
+	if hXKB == nil {
+		return
+	}
+	p := C.mmap(nil, C.size_t(size), C.PROT_READ, C.MAP_PRIVATE, C.int(fd), 0)
+	if uintptr(p) == ^uintptr(0) {
+		fmt.Fprint(os.Stderr, "[!] wsi: failed to map Wayland keymap\n")
+		return
+	}
+	st := C.newStateXKB((*C.char)(p))
+	C.munmap(p, C.size_t(size))
+	if st == nil {
+		fmt.Fprint(os.Stderr, "[!] wsi: failed to compile Wayland keymap\n")
+		return
 	}
+	if xkbWayland != nil {
+		C.destroyStateXKB(xkbWayland)
+	}
+	xkbWayland = st
 }
 
 //export keyboardEnterWayland
 func keyboardEnterWayland(serial C.uint32_t, sf *C.struct_wl_surface, keys *C.struct_wl_array) {
 	// TODO: Check keys.
-	if keyboardEnterHandler != nil {
-		if win := windowFromWayland(sf); win != nil {
-			keyboardEnterHandler.KeyboardEnter(win)
-		}
+	serialWayland = serial
+	win := windowFromWayland(sf)
+	kbFocusWayland = nil
+	if win != nil {
+		kbFocusWayland = win.(*windowWayland)
+	}
+	if keyboardEnterHandler != nil && win != nil {
+		keyboardEnterHandler.KeyboardEnter(win)
 	}
 }
 
 //export keyboardLeaveWayland
 func keyboardLeaveWayland(serial C.uint32_t, sf *C.struct_wl_surface) {
+	kbFocusWayland = nil
 	if keyboardLeaveHandler != nil {
 		if win := windowFromWayland(sf); win != nil {
 			keyboardLeaveHandler.KeyboardLeave(win)
@@ -750,15 +1314,21 @@ func keyboardLeaveWayland(serial C.uint32_t, sf *C.struct_wl_surface) {
 
 //export keyboardKeyWayland
 func keyboardKeyWayland(serial, millis, key, state C.uint32_t) {
+	serialWayland = serial
+	pressed := state == C.WL_KEYBOARD_KEY_STATE_PRESSED
 	if keyboardKeyHandler != nil {
-		key := keyFrom(int(key))
-		pressed := state == C.WL_KEYBOARD_KEY_STATE_PRESSED
-		keyboardKeyHandler.KeyboardKey(key, pressed)
+		keyboardKeyHandler.KeyboardKey(keyFrom(int(key)), pressed)
+	}
+	if pressed {
+		textEventWayland(key)
 	}
 }
 
 //export keyboardModifiersWayland
 func keyboardModifiersWayland(serial, depressed, latched, locked, group C.uint32_t) {
+	if xkbWayland != nil {
+		C.updateStateXKB(xkbWayland, depressed, latched, locked, group)
+	}
 	// XXX
 	const (
 		shift = 1 << iota
@@ -791,6 +1361,60 @@ func keyboardRepeatInfoWayland(rate, delay C.int32_t) {
 	// TODO
 }
 
+//export dataSourceSendWayland
+func dataSourceSendWayland(src *C.struct_wl_data_source, mime *C.char, fd C.int32_t) {
+	f := os.NewFile(uintptr(fd), "wl_data_source")
+	if src != clipSrcWayland {
+		f.Close()
+		return
+	}
+	// The receiver may be this same client, which
+	// reads the pipe only after the request is sent.
+	s := clipTextWayland
+	go func() {
+		defer f.Close()
+		io.WriteString(f, s)
+	}()
+}
+
+//export dataSourceCancelledWayland
+func dataSourceCancelledWayland(src *C.struct_wl_data_source) {
+	C.dataSourceDestroyWayland(src)
+	if src == clipSrcWayland {
+		clipSrcWayland = nil
+		clipTextWayland = ""
+	}
+}
+
+//export dataDeviceDataOfferWayland
+func dataDeviceDataOfferWayland(offer *C.struct_wl_data_offer) {
+	if C.dataOfferAddListenerWayland(offer) != 0 {
+		fmt.Fprint(os.Stderr, "[!] wsi: dataOfferAddListenerWayland failed\n")
+	}
+	offersWayland[offer] = nil
+}
+
+//export dataOfferOfferWayland
+func dataOfferOfferWayland(offer *C.struct_wl_data_offer, mime *C.char) {
+	if s, ok := offersWayland[offer]; ok {
+		offersWayland[offer] = append(s, C.GoString(mime))
+	}
+}
+
+//export dataDeviceEnterWayland
+func dataDeviceEnterWayland(offer *C.struct_wl_data_offer) {
+	// Drag and drop is not supported.
+	destroyOfferWayland(offer)
+}
+
+//export dataDeviceSelectionWayland
+func dataDeviceSelectionWayland(offer *C.struct_wl_data_offer) {
+	if selOfferWayland != offer {
+		destroyOfferWayland(selOfferWayland)
+	}
+	selOfferWayland = offer
+}
+
 // DisplayWayland returns the Wayland display (*C.struct_wl_display).
 // It must not be called if Wayland is not the platform in use.
 func DisplayWayland() unsafe.Pointer { return unsafe.Pointer(dpyWayland) }
@@ -15,6 +15,39 @@ void* openWayland(void);
 // calling this function.
 void closeWayland(void* handle);
 
+// xkbcommon types.
+struct xkb_context;
+struct xkb_keymap;
+struct xkb_state;
+
+// openXKB opens the xkbcommon shared library and gets
+// function pointers.
+// It is not safe to call any of the *XKB wrappers unless
+// this function succeeds.
+void* openXKB(void);
+
+// closeXKB closes the xkbcommon shared library.
+void closeXKB(void* handle);
+
+// newStateXKB creates a keyboard state from a keymap in
+// the XKB text format. It returns NULL on failure.
+struct xkb_state* newStateXKB(const char* keymap);
+
+// destroyStateXKB destroys a keyboard state.
+void destroyStateXKB(struct xkb_state* st);
+
+// updateStateXKB updates the modifiers and layout of a
+// keyboard state.
+void updateStateXKB(struct xkb_state* st, uint32_t depressed, uint32_t latched, uint32_t locked, uint32_t group);
+
+// keyCharXKB returns the UTF-32 character that the keysym
+// of the given XKB keycode represents, or 0 if none.
+uint32_t keyCharXKB(struct xkb_state* st, uint32_t key);
+
+// shortcutXKB returns whether the Control or the Mod1
+// (i.e., Alt) modifiers are active.
+int shortcutXKB(struct xkb_state* st);
+
 // wl_*_interface.
 extern const struct wl_interface displayInterfaceWayland;
 extern const struct wl_interface registryInterfaceWayland;
@@ -30,6 +63,10 @@ extern const struct wl_interface seatInterfaceWayland;
 extern const struct wl_interface pointerInterfaceWayland;
 extern const struct wl_interface keyboardInterfaceWayland;
 extern const struct wl_interface touchInterfaceWayland;
+extern const struct wl_interface dataDeviceManagerInterfaceWayland;
+extern const struct wl_interface dataSourceInterfaceWayland;
+extern const struct wl_interface dataDeviceInterfaceWayland;
+extern const struct wl_interface dataOfferInterfaceWayland;
 
 // xdg_*_interface.
 extern const struct wl_interface wmBaseInterfaceXDG;
@@ -132,6 +169,26 @@ void surfaceCommitWayland(struct wl_surface* sf);
 // wl_surface_damage_buffer.
 void surfaceDamageBufferWayland(struct wl_surface* sf, int32_t x, int32_t y, int32_t width, int32_t height);
 
+// wl_surface_set_buffer_scale.
+// It does nothing if the surface's version is less than 3.
+void surfaceSetBufferScaleWayland(struct wl_surface* sf, int32_t scale);
+
+// wl_output_add_listener.
+// This wrapper requires the following exported Go functions:
+//
+// - outputGeometryWayland(out *C.struct_wl_output, x, y, physWidth, physHeight C.int32_t, manuf, model *C.char)
+// - outputModeWayland(out *C.struct_wl_output, flags C.uint32_t, width, height, refresh C.int32_t)
+// - outputDoneWayland(out *C.struct_wl_output)
+// - outputScaleWayland(out *C.struct_wl_output, factor C.int32_t)
+// - outputNameWayland(out *C.struct_wl_output, name *C.char)
+int outputAddListenerWayland(struct wl_output* out);
+
+// wl_output_destroy.
+void outputDestroyWayland(struct wl_output* out);
+
+// wl_output_release.
+void outputReleaseWayland(struct wl_output* out);
+
 // xdg_wm_base_add_listener.
 // This wrapper requires the following exported Go function:
 //
@@ -267,3 +324,51 @@ void keyboardDestroyWayland(struct wl_keyboard* kb);
 
 // wl_keyboard_release.
 void keyboardReleaseWayland(struct wl_keyboard* kb);
+
+// wl_data_device_manager_destroy.
+void dataDeviceManagerDestroyWayland(struct wl_data_device_manager* ddm);
+
+// wl_data_device_manager_create_data_source.
+struct wl_data_source* dataDeviceManagerCreateDataSourceWayland(struct wl_data_device_manager* ddm);
+
+// wl_data_device_manager_get_data_device.
+struct wl_data_device* dataDeviceManagerGetDataDeviceWayland(struct wl_data_device_manager* ddm, struct wl_seat* seat);
+
+// wl_data_source_add_listener.
+// This wrapper requires the following exported Go functions:
+//
+// - dataSourceSendWayland(src *C.struct_wl_data_source, mime *C.char, fd C.int32_t)
+// - dataSourceCancelledWayland(src *C.struct_wl_data_source)
+int dataSourceAddListenerWayland(struct wl_data_source* src);
+
+// wl_data_source_offer.
+void dataSourceOfferWayland(struct wl_data_source* src, const char* mime);
+
+// wl_data_source_destroy.
+void dataSourceDestroyWayland(struct wl_data_source* src);
+
+// wl_data_device_add_listener.
+// This wrapper requires the following exported Go functions:
+//
+// - dataDeviceDataOfferWayland(offer *C.struct_wl_data_offer)
+// - dataDeviceEnterWayland(offer *C.struct_wl_data_offer)
+// - dataDeviceSelectionWayland(offer *C.struct_wl_data_offer)
+int dataDeviceAddListenerWayland(struct wl_data_device* dd);
+
+// wl_data_device_destroy.
+void dataDeviceDestroyWayland(struct wl_data_device* dd);
+
+// wl_data_device_set_selection.
+void dataDeviceSetSelectionWayland(struct wl_data_device* dd, struct wl_data_source* src, uint32_t serial);
+
+// wl_data_offer_add_listener.
+// This wrapper requires the following exported Go function:
+//
+// - dataOfferOfferWayland(offer *C.struct_wl_data_offer, mime *C.char)
+int dataOfferAddListenerWayland(struct wl_data_offer* offer);
+
+// wl_data_offer_receive.
+void dataOfferReceiveWayland(struct wl_data_offer* offer, const char* mime, int32_t fd);
+
+// wl_data_offer_destroy.
+void dataOfferDestroyWayland(struct wl_data_offer* offer);
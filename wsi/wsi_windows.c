@@ -1,7 +1,109 @@
 // Copyright 2022 Gustavo C. Viegas. All rights reserved.
 
+#include <string.h>
 #include <_cgo_export.h>
 
 LRESULT CALLBACK wndProcWrapper(HWND hwnd, UINT msg, WPARAM wprm, LPARAM lprm) {
     return wndProcWin32(hwnd, msg, wprm, lprm);
 }
+
+BOOL rawMouseMotionWrapper(LPARAM lprm, LONG* dx, LONG* dy) {
+    RAWINPUT raw;
+    UINT size = sizeof raw;
+    if (GetRawInputData((HRAWINPUT)lprm, RID_INPUT, &raw, &size, sizeof(RAWINPUTHEADER)) == (UINT)-1)
+        return FALSE;
+    if (raw.header.dwType != RIM_TYPEMOUSE || (raw.data.mouse.usFlags & MOUSE_MOVE_ABSOLUTE))
+        return FALSE;
+    *dx = raw.data.mouse.lLastX;
+    *dy = raw.data.mouse.lLastY;
+    return TRUE;
+}
+
+// Functions that are missing from older versions of
+// Windows are loaded at run time.
+typedef BOOL (WINAPI *setDpiAwarenessContextFn)(HANDLE);
+typedef UINT (WINAPI *getDpiForWindowFn)(HWND);
+typedef HRESULT (WINAPI *getDpiForMonitorFn)(HMONITOR, int, UINT*, UINT*);
+
+static getDpiForWindowFn getDpiForWindow;
+static getDpiForMonitorFn getDpiForMonitor;
+
+void dpiAwarenessWrapper(void) {
+    HMODULE user32 = GetModuleHandleW(L"user32.dll");
+    HMODULE shcore = LoadLibraryW(L"shcore.dll");
+    setDpiAwarenessContextFn setDpiAwarenessContext = NULL;
+    if (user32 != NULL) {
+        setDpiAwarenessContext = (setDpiAwarenessContextFn)GetProcAddress(user32, "SetProcessDpiAwarenessContext");
+        getDpiForWindow = (getDpiForWindowFn)GetProcAddress(user32, "GetDpiForWindow");
+    }
+    if (shcore != NULL)
+        getDpiForMonitor = (getDpiForMonitorFn)GetProcAddress(shcore, "GetDpiForMonitor");
+    // DPI_AWARENESS_CONTEXT_PER_MONITOR_AWARE_V2.
+    if (setDpiAwarenessContext == NULL || !setDpiAwarenessContext((HANDLE)-4))
+        SetProcessDPIAware();
+}
+
+static UINT systemDpi(void) {
+    UINT dpi = 0;
+    HDC dc = GetDC(NULL);
+    if (dc != NULL) {
+        dpi = GetDeviceCaps(dc, LOGPIXELSX);
+        ReleaseDC(NULL, dc);
+    }
+    return dpi != 0 ? dpi : USER_DEFAULT_SCREEN_DPI;
+}
+
+UINT windowDpiWrapper(HWND hwnd) {
+    UINT dpi = getDpiForWindow != NULL ? getDpiForWindow(hwnd) : 0;
+    return dpi != 0 ? dpi : systemDpi();
+}
+
+typedef struct {
+    monitorWin32* mons;
+    int count;
+    int cap;
+} monitorListWin32;
+
+static BOOL CALLBACK monitorEnumProc(HMONITOR mon, HDC dc_, LPRECT rect_, LPARAM lprm) {
+    monitorListWin32* list = (monitorListWin32*)lprm;
+    if (list->count >= list->cap)
+        return FALSE;
+    MONITORINFOEXW info;
+    info.cbSize = sizeof info;
+    if (!GetMonitorInfoW(mon, (MONITORINFO*)&info))
+        return TRUE;
+    monitorWin32* m = &list->mons[list->count++];
+    memcpy(m->name, info.szDevice, sizeof m->name);
+    m->x = info.rcMonitor.left;
+    m->y = info.rcMonitor.top;
+    m->width = info.rcMonitor.right - info.rcMonitor.left;
+    m->height = info.rcMonitor.bottom - info.rcMonitor.top;
+    m->primary = (info.dwFlags & MONITORINFOF_PRIMARY) != 0;
+    UINT dpiX, dpiY;
+    // MDT_EFFECTIVE_DPI.
+    if (getDpiForMonitor != NULL && getDpiForMonitor(mon, 0, &dpiX, &dpiY) == S_OK)
+        m->dpi = dpiX;
+    else
+        m->dpi = systemDpi();
+    DEVMODEW mode = {.dmSize = sizeof mode};
+    m->refresh = EnumDisplaySettingsW(info.szDevice, ENUM_CURRENT_SETTINGS, &mode) ? mode.dmDisplayFrequency : 0;
+    m->mmWidth = m->mmHeight = 0;
+    HDC dc = CreateDCW(info.szDevice, NULL, NULL, NULL);
+    if (dc != NULL) {
+        m->mmWidth = GetDeviceCaps(dc, HORZSIZE);
+        m->mmHeight = GetDeviceCaps(dc, VERTSIZE);
+        DeleteDC(dc);
+    }
+    return TRUE;
+}
+
+int monitorsWrapper(monitorWin32* mons, int n) {
+    monitorListWin32 list = {mons, 0, n};
+    EnumDisplayMonitors(NULL, NULL, monitorEnumProc, (LPARAM)&list);
+    return list.count;
+}
+
+void dpiChangedWrapper(HWND hwnd, LPARAM lprm) {
+    const RECT* r = (const RECT*)lprm;
+    SetWindowPos(hwnd, NULL, r->left, r->top, r->right - r->left, r->bottom - r->top, SWP_NOZORDER | SWP_NOACTIVATE);
+}
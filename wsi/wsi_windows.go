@@ -2,15 +2,30 @@
 
 package wsi
 
-// #cgo LDFLAGS: -lgdi32
+// #cgo LDFLAGS: -lgdi32 -limm32
 //
 // #ifndef UNICODE
 // #define UNICODE
 // #endif
 //
 // #include <windows.h>
+// #include <imm.h>
+//
+// typedef struct {
+// 	WCHAR name[CCHDEVICENAME];
+// 	LONG x, y, width, height;
+// 	int mmWidth, mmHeight;
+// 	UINT dpi;
+// 	DWORD refresh;
+// 	BOOL primary;
+// } monitorWin32;
 //
 // LRESULT CALLBACK wndProcWrapper(HWND, UINT, WPARAM, LPARAM);
+// BOOL rawMouseMotionWrapper(LPARAM, LONG*, LONG*);
+// void dpiAwarenessWrapper(void);
+// UINT windowDpiWrapper(HWND);
+// int monitorsWrapper(monitorWin32*, int);
+// void dpiChangedWrapper(HWND, LPARAM);
 import "C"
 
 import (
@@ -30,6 +45,9 @@ func initWin32() error {
 	if hinst = C.GetModuleHandle(nil); hinst == nil {
 		return errors.New("wsi: failed to obtain Win32 instance handle")
 	}
+	// Window sizes are given in pixels, and the
+	// scale factor is reported to the application.
+	C.dpiAwarenessWrapper()
 	className = stringToLPCWSTR("neo3/wsi")
 	wc := C.WNDCLASS{
 		style:         C.CS_HREDRAW | C.CS_VREDRAW,
@@ -52,6 +70,16 @@ func initWin32() error {
 	newWindow = newWindowWin32
 	dispatch = dispatchWin32
 	setAppName = setAppNameWin32
+	setCursorMode = setCursorModeWin32
+	newCursor = newCursorWin32
+	setCursor = setCursorWin32
+	clipboard = clipboardWin32
+	setClipboard = setClipboardWin32
+	startTextInput = startTextInputWin32
+	stopTextInput = stopTextInputWin32
+	monitors = monitorsWin32
+	windowScale = windowScaleWin32
+	pixelSize = windowPixelSize
 	platform = Win32
 	return nil
 }
@@ -78,11 +106,14 @@ func deinitWin32() {
 
 // windowWin32 implements Window.
 type windowWin32 struct {
-	hwnd   C.HWND
-	width  int
-	height int
-	title  string
-	mapped bool
+	hwnd      C.HWND
+	width     int
+	height    int
+	title     string
+	mapped    bool
+	mode      CursorMode
+	cursor    *cursorWin32
+	textInput bool
 }
 
 // newWindowWin32 creates a new window.
@@ -101,6 +132,8 @@ func newWindowWin32(width, height int, title string) (Window, error) {
 	if hwnd == nil {
 		return nil, errors.New("wsi: failed to create Win32 window")
 	}
+	// The IME is only enabled by StartTextInput.
+	C.ImmAssociateContextEx(hwnd, nil, 0)
 	return &windowWin32{
 		hwnd:   hwnd,
 		width:  width,
@@ -169,6 +202,9 @@ func (w *windowWin32) SetTitle(title string) error {
 func (w *windowWin32) Close() {
 	if w != nil {
 		closeWindow(w)
+		if lockWin32 == w {
+			unlockWin32()
+		}
 		if w.hwnd != nil {
 			C.DestroyWindow(w.hwnd)
 		}
@@ -206,6 +242,11 @@ func wndProcWin32(hwnd C.HWND, msg C.UINT, wprm C.WPARAM, lprm C.LPARAM) C.LRESU
 	case C.WM_SIZE:
 		sizeMsgWin32(hwnd, lprm)
 		return 0
+	case C.WM_MOVE:
+		if lockWin32 != nil && lockWin32.hwnd == hwnd {
+			clipCursorWin32(lockWin32)
+		}
+		return 0
 	case C.WM_KEYDOWN, C.WM_KEYUP:
 		keyMsgWin32(wprm, lprm)
 		return 0
@@ -259,6 +300,38 @@ func wndProcWin32(hwnd C.HWND, msg C.UINT, wprm C.WPARAM, lprm C.LPARAM) C.LRESU
 	case C.WM_MOUSELEAVE:
 		mouseLeaveMsgWin32(hwnd)
 		return 0
+	case C.WM_SETCURSOR:
+		if lprm&0xffff == C.HTCLIENT {
+			if win := windowFromWin32(hwnd); win != nil {
+				applyCursorWin32(win.(*windowWin32))
+				return C.TRUE
+			}
+		}
+		return C.DefWindowProc(hwnd, msg, wprm, lprm)
+	case C.WM_INPUT:
+		inputMsgWin32(hwnd, lprm)
+		return C.DefWindowProc(hwnd, msg, wprm, lprm)
+	case C.WM_CHAR:
+		charMsgWin32(hwnd, wprm)
+		return 0
+	case C.WM_IME_SETCONTEXT:
+		// The composition string is displayed by
+		// the application.
+		lprm &^= C.ISC_SHOWUICOMPOSITIONWINDOW
+		return C.DefWindowProc(hwnd, msg, wprm, lprm)
+	case C.WM_IME_COMPOSITION:
+		if compositionMsgWin32(hwnd, lprm) {
+			return 0
+		}
+		return C.DefWindowProc(hwnd, msg, wprm, lprm)
+	case C.WM_IME_ENDCOMPOSITION:
+		if textCompositionHandler != nil {
+			textCompositionHandler.TextComposition("", 0)
+		}
+		return C.DefWindowProc(hwnd, msg, wprm, lprm)
+	case C.WM_DPICHANGED:
+		dpiChangedMsgWin32(hwnd, wprm, lprm)
+		return 0
 	case C.WM_DESTROY:
 		C.PostQuitMessage(0)
 		return 0
@@ -297,6 +370,9 @@ func sizeMsgWin32(hwnd C.HWND, lprm C.LPARAM) {
 		win := win.(*windowWin32)
 		win.width = int(lprm & 0xffff)
 		win.height = int(lprm >> 16 & 0xffff)
+		if lockWin32 == win {
+			clipCursorWin32(win)
+		}
 		if windowResizeHandler != nil {
 			windowResizeHandler.WindowResize(win, win.width, win.height)
 		}
@@ -362,6 +438,9 @@ func keyMsgWin32(wprm C.WPARAM, lprm C.LPARAM) {
 
 // setFocusMsgWin32 handles WM_SETFOCUS messages.
 func setFocusMsgWin32(hwnd C.HWND) {
+	if lockWin32 != nil && lockWin32.hwnd == hwnd {
+		clipCursorWin32(lockWin32)
+	}
 	if keyboardEnterHandler != nil {
 		if win := windowFromWin32(hwnd); win != nil {
 			keyboardEnterHandler.KeyboardEnter(win)
@@ -371,6 +450,11 @@ func setFocusMsgWin32(hwnd C.HWND) {
 
 // killFocusMsgWin32 handles WM_KILLFOCUS messages.
 func killFocusMsgWin32(hwnd C.HWND) {
+	if lockWin32 != nil && lockWin32.hwnd == hwnd {
+		// The clip rectangle is global, so it must
+		// not outlive the focus.
+		C.ClipCursor(nil)
+	}
 	if keyboardLeaveHandler != nil {
 		if win := windowFromWin32(hwnd); win != nil {
 			keyboardLeaveHandler.KeyboardLeave(win)
@@ -413,6 +497,10 @@ func mouseMoveMsgWin32(hwnd C.HWND, lprm C.LPARAM) {
 			}
 		}
 	}
+	if lockWin32 != nil && lockWin32.hwnd == hwnd {
+		// Reported by inputMsgWin32 instead.
+		return
+	}
 	if pointerMotionHandler != nil {
 		pointerMotionHandler.PointerMotion(newX, newY)
 	}
@@ -430,6 +518,425 @@ func mouseLeaveMsgWin32(hwnd C.HWND) {
 	}
 }
 
+// The window whose cursor is locked, if any.
+var lockWin32 *windowWin32
+
+// setCursorModeWin32 sets the cursor mode of the given
+// window.
+func setCursorModeWin32(win Window, mode CursorMode) error {
+	w := win.(*windowWin32)
+	if mode == CursorLocked {
+		if lockWin32 != w {
+			if lockWin32 != nil {
+				unlockWin32()
+			}
+			// Raw input provides unaccelerated motion
+			// that is not limited by the clip rectangle.
+			rid := C.RAWINPUTDEVICE{
+				usUsagePage: 0x01, // HID_USAGE_PAGE_GENERIC
+				usUsage:     0x02, // HID_USAGE_GENERIC_MOUSE
+				dwFlags:     0,
+				hwndTarget:  w.hwnd,
+			}
+			if C.RegisterRawInputDevices(&rid, 1, C.sizeof_RAWINPUTDEVICE) == C.FALSE {
+				return errors.New("wsi: failed to register Win32 raw input device")
+			}
+			lockWin32 = w
+		}
+		if C.GetFocus() == w.hwnd {
+			clipCursorWin32(w)
+		}
+	} else if lockWin32 == w {
+		unlockWin32()
+	}
+	w.mode = mode
+	if hwndMouse == w.hwnd {
+		applyCursorWin32(w)
+	}
+	return nil
+}
+
+// unlockWin32 releases the cursor of lockWin32.
+func unlockWin32() {
+	rid := C.RAWINPUTDEVICE{
+		usUsagePage: 0x01,
+		usUsage:     0x02,
+		dwFlags:     C.RIDEV_REMOVE,
+		hwndTarget:  nil,
+	}
+	C.RegisterRawInputDevices(&rid, 1, C.sizeof_RAWINPUTDEVICE)
+	C.ClipCursor(nil)
+	lockWin32.mode = CursorNormal
+	lockWin32 = nil
+}
+
+// clipCursorWin32 confines the cursor to the client
+// area of the given window and moves it to the center.
+func clipCursorWin32(w *windowWin32) {
+	var rect C.RECT
+	if C.GetClientRect(w.hwnd, &rect) == C.FALSE {
+		return
+	}
+	C.MapWindowPoints(w.hwnd, nil, (*C.POINT)(unsafe.Pointer(&rect)), 2)
+	C.ClipCursor(&rect)
+	C.SetCursorPos(C.int((rect.left+rect.right)/2), C.int((rect.top+rect.bottom)/2))
+}
+
+// applyCursorWin32 sets the cursor image to that of
+// the given window.
+func applyCursorWin32(w *windowWin32) {
+	switch {
+	case w.mode != CursorNormal:
+		C.SetCursor(nil)
+	case w.cursor != nil:
+		C.SetCursor(w.cursor.hcur)
+	default:
+		C.SetCursor(C.LoadCursor(nil, C.IDC_ARROW))
+	}
+}
+
+// inputMsgWin32 handles WM_INPUT messages.
+func inputMsgWin32(hwnd C.HWND, lprm C.LPARAM) {
+	if lockWin32 == nil || lockWin32.hwnd != hwnd || pointerRelativeMotionHandler == nil {
+		return
+	}
+	// TODO: Absolute devices (e.g., tablets and
+	// remote desktop) are ignored.
+	var dx, dy C.LONG
+	if C.rawMouseMotionWrapper(lprm, &dx, &dy) != C.FALSE && (dx != 0 || dy != 0) {
+		pointerRelativeMotionHandler.PointerRelativeMotion(int(dx), int(dy))
+	}
+}
+
+// cursorWin32 implements Cursor.
+type cursorWin32 struct {
+	hcur C.HCURSOR
+}
+
+// newCursorWin32 creates a new cursor.
+func newCursorWin32(width, height, hotX, hotY int, data []byte) (Cursor, error) {
+	bi := C.BITMAPV5HEADER{
+		bV5Size:        C.sizeof_BITMAPV5HEADER,
+		bV5Width:       C.LONG(width),
+		bV5Height:      -C.LONG(height), // Top-down.
+		bV5Planes:      1,
+		bV5BitCount:    32,
+		bV5Compression: C.BI_BITFIELDS,
+		bV5RedMask:     0x00ff0000,
+		bV5GreenMask:   0x0000ff00,
+		bV5BlueMask:    0x000000ff,
+		bV5AlphaMask:   0xff000000,
+	}
+	var bits unsafe.Pointer
+	dc := C.GetDC(nil)
+	color := C.CreateDIBSection(dc, (*C.BITMAPINFO)(unsafe.Pointer(&bi)), C.DIB_RGB_COLORS, &bits, nil, 0)
+	C.ReleaseDC(nil, dc)
+	if color == nil {
+		return nil, errors.New("wsi: failed to create Win32 cursor bitmap")
+	}
+	defer C.DeleteObject(C.HGDIOBJ(color))
+	dst := unsafe.Slice((*byte)(bits), width*height*4)
+	for i := 0; i < len(dst); i += 4 {
+		dst[i] = data[i+2]
+		dst[i+1] = data[i+1]
+		dst[i+2] = data[i]
+		dst[i+3] = data[i+3]
+	}
+	// The mask is ignored since the color bitmap
+	// has alpha, but it must be present.
+	// Rows are aligned to 16 bits.
+	maskBits := make([]byte, (width+15)/16*2*height)
+	mask := C.CreateBitmap(C.int(width), C.int(height), 1, 1, unsafe.Pointer(&maskBits[0]))
+	if mask == nil {
+		return nil, errors.New("wsi: failed to create Win32 cursor mask")
+	}
+	defer C.DeleteObject(C.HGDIOBJ(mask))
+	ii := C.ICONINFO{
+		fIcon:    C.FALSE,
+		xHotspot: C.DWORD(hotX),
+		yHotspot: C.DWORD(hotY),
+		hbmMask:  mask,
+		hbmColor: color,
+	}
+	hcur := C.HCURSOR(C.CreateIconIndirect(&ii))
+	if hcur == nil {
+		return nil, errors.New("wsi: failed to create Win32 cursor")
+	}
+	return &cursorWin32{hcur}, nil
+}
+
+// Close destroys the cursor.
+func (c *cursorWin32) Close() {
+	if c == nil || c.hcur == nil {
+		return
+	}
+	for _, w := range createdWindows {
+		if w != nil && w.(*windowWin32).cursor == c {
+			setCursorWin32(w, nil)
+		}
+	}
+	C.DestroyCursor(c.hcur)
+	*c = cursorWin32{}
+}
+
+// setCursorWin32 sets the cursor of the given window.
+func setCursorWin32(win Window, cur Cursor) error {
+	w := win.(*windowWin32)
+	if cur != nil {
+		w.cursor = cur.(*cursorWin32)
+	} else {
+		w.cursor = nil
+	}
+	if hwndMouse == w.hwnd {
+		applyCursorWin32(w)
+	}
+	return nil
+}
+
+// clipboardWin32 returns the text in the clipboard.
+func clipboardWin32() (string, error) {
+	if C.IsClipboardFormatAvailable(C.CF_UNICODETEXT) == C.FALSE {
+		return "", nil
+	}
+	if C.OpenClipboard(nil) == C.FALSE {
+		return "", errors.New("wsi: failed to open Win32 clipboard")
+	}
+	defer C.CloseClipboard()
+	h := C.HGLOBAL(C.GetClipboardData(C.CF_UNICODETEXT))
+	if h == nil {
+		return "", errors.New("wsi: failed to get Win32 clipboard data")
+	}
+	p := C.GlobalLock(h)
+	if p == nil {
+		return "", errors.New("wsi: failed to lock Win32 clipboard data")
+	}
+	defer C.GlobalUnlock(h)
+	ws := unsafe.Slice((*uint16)(p), C.GlobalSize(h)/2)
+	for i := range ws {
+		if ws[i] == 0 {
+			ws = ws[:i]
+			break
+		}
+	}
+	return string(utf16.Decode(ws)), nil
+}
+
+// setClipboardWin32 sets the text in the clipboard.
+// The clipboard is owned by one of the created windows,
+// so at least one must exist.
+func setClipboardWin32(s string) error {
+	var hwnd C.HWND
+	for _, w := range createdWindows {
+		if w != nil {
+			hwnd = w.(*windowWin32).hwnd
+			break
+		}
+	}
+	if hwnd == nil {
+		return errors.New("wsi: setting the Win32 clipboard requires a window")
+	}
+	u16 := append(utf16.Encode([]rune(s)), 0)
+	sz := C.SIZE_T(len(u16) * 2)
+	h := C.GlobalAlloc(C.GMEM_MOVEABLE, sz)
+	if h == nil {
+		return errors.New("wsi: failed to allocate Win32 clipboard data")
+	}
+	C.memcpy(C.GlobalLock(h), unsafe.Pointer(&u16[0]), C.size_t(sz))
+	C.GlobalUnlock(h)
+	if C.OpenClipboard(hwnd) == C.FALSE {
+		C.GlobalFree(h)
+		return errors.New("wsi: failed to open Win32 clipboard")
+	}
+	defer C.CloseClipboard()
+	if C.EmptyClipboard() == C.FALSE || C.SetClipboardData(C.CF_UNICODETEXT, C.HANDLE(h)) == nil {
+		C.GlobalFree(h)
+		return errors.New("wsi: failed to set Win32 clipboard data")
+	}
+	return nil
+}
+
+// startTextInputWin32 enables text input in the given
+// window.
+func startTextInputWin32(win Window, x, y, width, height int) error {
+	w := win.(*windowWin32)
+	if C.ImmAssociateContextEx(w.hwnd, nil, C.IACE_DEFAULT) == C.FALSE {
+		return errors.New("wsi: failed to associate Win32 input context")
+	}
+	w.textInput = true
+	if himc := C.ImmGetContext(w.hwnd); himc != nil {
+		cf := C.COMPOSITIONFORM{
+			dwStyle:      C.CFS_POINT,
+			ptCurrentPos: C.POINT{x: C.LONG(x), y: C.LONG(y)},
+		}
+		C.ImmSetCompositionWindow(himc, &cf)
+		cand := C.CANDIDATEFORM{
+			dwIndex:      0,
+			dwStyle:      C.CFS_EXCLUDE,
+			ptCurrentPos: C.POINT{x: C.LONG(x), y: C.LONG(y)},
+			rcArea: C.RECT{
+				left:   C.LONG(x),
+				top:    C.LONG(y),
+				right:  C.LONG(x + width),
+				bottom: C.LONG(y + height),
+			},
+		}
+		C.ImmSetCandidateWindow(himc, &cand)
+		C.ImmReleaseContext(w.hwnd, himc)
+	}
+	return nil
+}
+
+// stopTextInputWin32 disables text input in the given
+// window.
+func stopTextInputWin32(win Window) {
+	w := win.(*windowWin32)
+	if !w.textInput {
+		return
+	}
+	if himc := C.ImmGetContext(w.hwnd); himc != nil {
+		C.ImmNotifyIME(himc, C.NI_COMPOSITIONSTR, C.CPS_CANCEL, 0)
+		C.ImmReleaseContext(w.hwnd, himc)
+	}
+	C.ImmAssociateContextEx(w.hwnd, nil, 0)
+	w.textInput = false
+}
+
+// High surrogate of a pending WM_CHAR pair.
+var surrogateWin32 rune
+
+// charMsgWin32 handles WM_CHAR messages.
+func charMsgWin32(hwnd C.HWND, wprm C.WPARAM) {
+	win := windowFromWin32(hwnd)
+	if win == nil || !win.(*windowWin32).textInput {
+		return
+	}
+	r := rune(wprm & 0xffff)
+	switch {
+	case utf16.IsSurrogate(r) && r < 0xdc00:
+		surrogateWin32 = r
+		return
+	case utf16.IsSurrogate(r):
+		r = utf16.DecodeRune(surrogateWin32, r)
+		surrogateWin32 = 0
+	case r < 0x20 || r == 0x7f:
+		// Control characters are reported as keys.
+		return
+	}
+	if textInputHandler != nil {
+		textInputHandler.TextInput(string(r))
+	}
+}
+
+// compositionMsgWin32 handles WM_IME_COMPOSITION
+// messages.
+// It returns false if the message was not handled.
+func compositionMsgWin32(hwnd C.HWND, lprm C.LPARAM) bool {
+	win := windowFromWin32(hwnd)
+	if win == nil || !win.(*windowWin32).textInput {
+		return false
+	}
+	himc := C.ImmGetContext(hwnd)
+	if himc == nil {
+		return false
+	}
+	defer C.ImmReleaseContext(hwnd, himc)
+	if lprm&C.GCS_RESULTSTR != 0 && textInputHandler != nil {
+		if s, _ := compositionStringWin32(himc, C.GCS_RESULTSTR, -1); s != "" {
+			textInputHandler.TextInput(s)
+		}
+	}
+	if lprm&C.GCS_COMPSTR != 0 && textCompositionHandler != nil {
+		pos := -1
+		if lprm&C.GCS_CURSORPOS != 0 {
+			pos = int(C.ImmGetCompositionString(himc, C.GCS_CURSORPOS, nil, 0))
+		}
+		s, cursor := compositionStringWin32(himc, C.GCS_COMPSTR, pos)
+		textCompositionHandler.TextComposition(s, cursor)
+	}
+	return true
+}
+
+// compositionStringWin32 returns the given composition
+// string as UTF-8, and the byte offset that corresponds
+// to the UTF-16 offset pos (or the length of the string
+// if pos is negative).
+func compositionStringWin32(himc C.HIMC, index C.DWORD, pos int) (string, int) {
+	n := C.ImmGetCompositionString(himc, index, nil, 0)
+	if n <= 0 {
+		return "", 0
+	}
+	ws := make([]uint16, n/2)
+	C.ImmGetCompositionString(himc, index, unsafe.Pointer(&ws[0]), C.DWORD(n))
+	if pos < 0 || pos > len(ws) {
+		pos = len(ws)
+	}
+	return string(utf16.Decode(ws)), len(string(utf16.Decode(ws[:pos])))
+}
+
+// dpiChangedMsgWin32 handles WM_DPICHANGED messages.
+func dpiChangedMsgWin32(hwnd C.HWND, wprm C.WPARAM, lprm C.LPARAM) {
+	// The suggested rectangle keeps the window's
+	// apparent size on the new monitor. Resizing
+	// sends WM_SIZE.
+	C.dpiChangedWrapper(hwnd, lprm)
+	if windowScaleHandler != nil {
+		if win := windowFromWin32(hwnd); win != nil {
+			windowScaleHandler.WindowScale(win, float64(wprm&0xffff)/C.USER_DEFAULT_SCREEN_DPI)
+		}
+	}
+}
+
+// The maximum number of monitors that monitorsWin32
+// reports.
+const maxMonitorsWin32 = 16
+
+// monitorsWin32 returns the connected monitors.
+func monitorsWin32() ([]Monitor, error) {
+	var mons [maxMonitorsWin32]C.monitorWin32
+	n := int(C.monitorsWrapper(&mons[0], maxMonitorsWin32))
+	if n == 0 {
+		return nil, errors.New("wsi: failed to enumerate Win32 monitors")
+	}
+	ms := make([]Monitor, 0, n)
+	for i := range mons[:n] {
+		m := &mons[i]
+		name := unsafe.Slice((*uint16)(unsafe.Pointer(&m.name[0])), len(m.name))
+		for j := range name {
+			if name[j] == 0 {
+				name = name[:j]
+				break
+			}
+		}
+		x := Monitor{
+			Name:       string(utf16.Decode(name)),
+			X:          int(m.x),
+			Y:          int(m.y),
+			Width:      int(m.width),
+			Height:     int(m.height),
+			PhysWidth:  int(m.mmWidth),
+			PhysHeight: int(m.mmHeight),
+			Scale:      float64(m.dpi) / C.USER_DEFAULT_SCREEN_DPI,
+			Primary:    m.primary != C.FALSE,
+		}
+		// 0 and 1 mean the hardware's default rate.
+		if m.refresh > 1 {
+			x.RefreshRate = float64(m.refresh)
+		}
+		if x.Primary {
+			ms = append([]Monitor{x}, ms...)
+		} else {
+			ms = append(ms, x)
+		}
+	}
+	return ms, nil
+}
+
+// windowScaleWin32 returns the scale factor of the given
+// window.
+func windowScaleWin32(win Window) float64 {
+	return float64(C.windowDpiWrapper(win.(*windowWin32).hwnd)) / C.USER_DEFAULT_SCREEN_DPI
+}
+
 // setAppNameWin32 updates the string used to identify the
 // application.
 func setAppNameWin32(s string) {
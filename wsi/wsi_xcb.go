@@ -13,6 +13,11 @@ import "C"
 
 import (
 	"errors"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
 	"unsafe"
 )
 
@@ -31,6 +36,14 @@ var (
 	titleAtomXCB C.xcb_atom_t
 	utf8AtomXCB  C.xcb_atom_t
 	classAtomXCB C.xcb_atom_t
+
+	clipAtomXCB    C.xcb_atom_t
+	targetsAtomXCB C.xcb_atom_t
+	incrAtomXCB    C.xcb_atom_t
+	propAtomXCB    C.xcb_atom_t
+
+	bitOrderXCB C.uint8_t
+	padXCB      int
 )
 
 // openXCB opens the shared library and gets function pointers.
@@ -94,6 +107,16 @@ func initXCB() error {
 	rootXCB = screenIt.data.root
 	whitePixXCB = screenIt.data.white_pixel
 	blackPixXCB = screenIt.data.black_pixel
+	bitOrderXCB = setup.bitmap_format_bit_order
+	padXCB = int(setup.bitmap_format_scanline_pad)
+	screenXCB = Monitor{
+		Name:       "screen",
+		Width:      int(screenIt.data.width_in_pixels),
+		Height:     int(screenIt.data.height_in_pixels),
+		PhysWidth:  int(screenIt.data.width_in_millimeters),
+		PhysHeight: int(screenIt.data.height_in_millimeters),
+		Primary:    true,
+	}
 
 	var genErr *C.xcb_generic_error_t
 
@@ -106,6 +129,10 @@ func initXCB() error {
 		{C.CString("WM_NAME"), &titleAtomXCB},
 		{C.CString("UTF8_STRING"), &utf8AtomXCB},
 		{C.CString("WM_CLASS"), &classAtomXCB},
+		{C.CString("CLIPBOARD"), &clipAtomXCB},
+		{C.CString("TARGETS"), &targetsAtomXCB},
+		{C.CString("INCR"), &incrAtomXCB},
+		{C.CString("NEO3_CLIPBOARD"), &propAtomXCB},
 	}
 	for i := range atoms {
 		defer C.free(unsafe.Pointer(atoms[i].name))
@@ -137,6 +164,22 @@ func initXCB() error {
 		}
 	}
 
+	if err := initClipboardXCB(); err != nil {
+		C.disconnectXCB(connXCB)
+		connXCB = nil
+		return err
+	}
+	if err := initKeysymsXCB(); err != nil {
+		C.disconnectXCB(connXCB)
+		connXCB = nil
+		return err
+	}
+	if err := initScaleXCB(); err != nil {
+		C.disconnectXCB(connXCB)
+		connXCB = nil
+		return err
+	}
+
 	if C.flushXCB(connXCB) <= 0 {
 		C.disconnectXCB(connXCB)
 		connXCB = nil
@@ -146,6 +189,16 @@ func initXCB() error {
 	newWindow = newWindowXCB
 	dispatch = dispatchXCB
 	setAppName = setAppNameXCB
+	setCursorMode = setCursorModeXCB
+	newCursor = newCursorXCB
+	setCursor = setCursorXCB
+	clipboard = clipboardXCB
+	setClipboard = setClipboardXCB
+	startTextInput = startTextInputXCB
+	stopTextInput = stopTextInputXCB
+	monitors = monitorsXCB
+	windowScale = windowScaleXCB
+	pixelSize = windowPixelSize
 	platform = XCB
 	return nil
 }
@@ -159,21 +212,36 @@ func deinitXCB() {
 			}
 		}
 	}
+	for _, e := range queuedXCB {
+		C.free(unsafe.Pointer(e))
+	}
+	queuedXCB = nil
 	if connXCB != nil {
 		C.disconnectXCB(connXCB)
 		connXCB = nil
 	}
+	// Server resources are freed on disconnection.
+	hiddenCursorXCB = cursorXCB{}
+	clipWinXCB = 0
+	clipTextXCB = ""
+	clipOwnedXCB = false
+	keysymsXCB = nil
+	screenXCB = Monitor{}
+	scaleXCB = 1
 	closeXCB()
 	initDummy()
 }
 
 // windowXCB implements Window.
 type windowXCB struct {
-	id     C.xcb_window_t
-	width  int
-	height int
-	title  string
-	mapped bool
+	id        C.xcb_window_t
+	width     int
+	height    int
+	title     string
+	mapped    bool
+	mode      CursorMode
+	cursor    *cursorXCB
+	textInput bool
 }
 
 // newWindowXCB creates a new window.
@@ -288,6 +356,11 @@ func (w *windowXCB) SetTitle(title string) error {
 func (w *windowXCB) Close() {
 	if w != nil {
 		closeWindow(w)
+		if lockXCB == w {
+			// Destroying the window releases
+			// the grab.
+			lockXCB = nil
+		}
 		if connXCB != nil {
 			C.destroyWindowXCB(connXCB, w.id)
 		}
@@ -354,10 +427,21 @@ func setDeleteXCB(t bool, id C.xcb_window_t) error {
 	return nil
 }
 
+// Events received while waiting for a selection.
+// They are processed before any new event.
+var queuedXCB []*C.xcb_generic_event_t
+
 // pollXCB process the next event.
 // It returns false if there are no events to process.
 func pollXCB() bool {
-	event := C.pollForEventXCB(connXCB)
+	var event *C.xcb_generic_event_t
+	if len(queuedXCB) > 0 {
+		event = queuedXCB[0]
+		queuedXCB[0] = nil
+		queuedXCB = queuedXCB[1:]
+	} else {
+		event = C.pollForEventXCB(connXCB)
+	}
 	if event != nil {
 		defer C.free(unsafe.Pointer(event))
 		typ := event.response_type & 127
@@ -382,6 +466,14 @@ func pollXCB() bool {
 			configureEventXCB(event)
 		case C.XCB_CLIENT_MESSAGE:
 			clientEventXCB(event)
+		case C.XCB_SELECTION_REQUEST:
+			selectionRequestEventXCB(event)
+		case C.XCB_SELECTION_CLEAR:
+			selectionClearEventXCB(event)
+		case C.XCB_MAPPING_NOTIFY:
+			mappingEventXCB(event)
+		case C.XCB_PROPERTY_NOTIFY:
+			propertyEventXCB(event)
 		}
 		return true
 	}
@@ -452,6 +544,9 @@ func keyEventXCB(event *C.xcb_generic_event_t) {
 	if keyboardKeyHandler != nil {
 		keyboardKeyHandler.KeyboardKey(key, pressed)
 	}
+	if pressed {
+		textEventXCB(evt)
+	}
 	modMask := modCapsXCB | modLeftXCB | modRightXCB
 	if modMask != prevModMask && keyboardModifierHandler != nil {
 		keyboardModifierHandler.KeyboardModifier(modMask)
@@ -480,10 +575,25 @@ func buttonEventXCB(event *C.xcb_generic_event_t) {
 
 // motionEventXCB handles motion notify events.
 func motionEventXCB(event *C.xcb_generic_event_t) {
+	evt := (*C.xcb_motion_notify_event_t)(unsafe.Pointer(event))
+	newX := int(evt.event_x)
+	newY := int(evt.event_y)
+	if lockXCB != nil && lockXCB.id == evt.event {
+		// The pointer is kept at the center of the
+		// window, so motion away from it is relative.
+		// Warping back generates an event with no
+		// motion, which is ignored.
+		dx := newX - lockXCB.width/2
+		dy := newY - lockXCB.height/2
+		if dx != 0 || dy != 0 {
+			if pointerRelativeMotionHandler != nil {
+				pointerRelativeMotionHandler.PointerRelativeMotion(dx, dy)
+			}
+			centerPointerXCB(lockXCB)
+		}
+		return
+	}
 	if pointerMotionHandler != nil {
-		evt := (*C.xcb_motion_notify_event_t)(unsafe.Pointer(event))
-		newX := int(evt.event_x)
-		newY := int(evt.event_y)
 		pointerMotionHandler.PointerMotion(newX, newY)
 	}
 }
@@ -529,6 +639,12 @@ func focusOutXCB(event *C.xcb_generic_event_t) {
 // configureEventXCB handles configure notify events.
 func configureEventXCB(event *C.xcb_generic_event_t) {
 	evt := (*C.xcb_configure_notify_event_t)(unsafe.Pointer(event))
+	if evt.event == rootXCB {
+		// The screen was resized.
+		screenXCB.Width = int(evt.width)
+		screenXCB.Height = int(evt.height)
+		return
+	}
 	win := windowFromXCB(evt.event)
 	newWidth := int(evt.width)
 	newHeight := int(evt.height)
@@ -554,6 +670,15 @@ func clientEventXCB(event *C.xcb_generic_event_t) {
 	}
 }
 
+// mappingEventXCB handles mapping notify events.
+func mappingEventXCB(event *C.xcb_generic_event_t) {
+	evt := (*C.xcb_mapping_notify_event_t)(unsafe.Pointer(event))
+	if evt.request == C.XCB_MAPPING_KEYBOARD {
+		// TODO: Handle errors if possible.
+		initKeysymsXCB()
+	}
+}
+
 // dispatchXCB dispatches queued events.
 func dispatchXCB() {
 	for pollXCB() {
@@ -571,6 +696,569 @@ func setAppNameXCB(s string) {
 	}
 }
 
+// The window whose pointer is grabbed, if any.
+var lockXCB *windowXCB
+
+// setCursorModeXCB sets the cursor mode of the given
+// window.
+// The window must be mapped for the cursor to be
+// locked.
+func setCursorModeXCB(win Window, mode CursorMode) error {
+	w := win.(*windowXCB)
+	if mode == CursorLocked {
+		if lockXCB != w {
+			if lockXCB != nil {
+				unlockXCB()
+			}
+			hidden, err := hiddenCursorXCB.get()
+			if err != nil {
+				return err
+			}
+			var genErr *C.xcb_generic_error_t
+			evtMask := C.uint16_t(C.XCB_EVENT_MASK_BUTTON_PRESS | C.XCB_EVENT_MASK_BUTTON_RELEASE | C.XCB_EVENT_MASK_POINTER_MOTION)
+			cookie := C.grabPointerXCB(connXCB, 1, w.id, evtMask, C.XCB_GRAB_MODE_ASYNC, C.XCB_GRAB_MODE_ASYNC, w.id, hidden, C.XCB_CURRENT_TIME)
+			reply := C.grabPointerReplyXCB(connXCB, cookie, &genErr)
+			if genErr != nil || reply == nil || reply.status != C.XCB_GRAB_STATUS_SUCCESS {
+				C.free(unsafe.Pointer(genErr))
+				C.free(unsafe.Pointer(reply))
+				return errors.New("wsi: grabPointerXCB failed")
+			}
+			C.free(unsafe.Pointer(reply))
+			lockXCB = w
+			centerPointerXCB(w)
+		}
+	} else if lockXCB == w {
+		unlockXCB()
+	}
+	w.mode = mode
+	return applyCursorXCB(w)
+}
+
+// unlockXCB releases the pointer grabbed by lockXCB.
+func unlockXCB() {
+	C.ungrabPointerXCB(connXCB, C.XCB_CURRENT_TIME)
+	w := lockXCB
+	lockXCB = nil
+	w.mode = CursorNormal
+	applyCursorXCB(w)
+}
+
+// centerPointerXCB moves the pointer to the center of the
+// given window.
+func centerPointerXCB(w *windowXCB) {
+	x := C.int16_t(w.width / 2)
+	y := C.int16_t(w.height / 2)
+	C.warpPointerXCB(connXCB, C.XCB_NONE, w.id, 0, 0, 0, 0, x, y)
+	C.flushXCB(connXCB)
+}
+
+// applyCursorXCB sets the cursor attribute of the given
+// window.
+func applyCursorXCB(w *windowXCB) error {
+	cur := C.xcb_cursor_t(C.XCB_CURSOR_NONE)
+	switch {
+	case w.mode != CursorNormal:
+		hidden, err := hiddenCursorXCB.get()
+		if err != nil {
+			return err
+		}
+		cur = hidden
+	case w.cursor != nil:
+		cur = w.cursor.id
+	}
+	cookie := C.changeWindowAttributesCheckedXCB(connXCB, w.id, C.XCB_CW_CURSOR, unsafe.Pointer(&cur))
+	genErr := C.requestCheckXCB(connXCB, cookie)
+	if genErr != nil {
+		C.free(unsafe.Pointer(genErr))
+		return errors.New("wsi: changeWindowAttributesCheckedXCB failed")
+	}
+	return nil
+}
+
+// cursorXCB implements Cursor.
+type cursorXCB struct {
+	id C.xcb_cursor_t
+}
+
+// Invisible cursor, created on first use.
+var hiddenCursorXCB cursorXCB
+
+// get returns c.id, creating an invisible cursor if c
+// is not valid.
+func (c *cursorXCB) get() (C.xcb_cursor_t, error) {
+	if c.id == 0 {
+		id, err := createCursorXCB(1, 1, 0, 0, make([]byte, 4), make([]byte, 4))
+		if err != nil {
+			return 0, err
+		}
+		c.id = id
+	}
+	return c.id, nil
+}
+
+// newCursorXCB creates a new cursor.
+// The core protocol only supports two-color cursors, so
+// the image is reduced to black and white: opaque
+// pixels are black if dark and white otherwise.
+func newCursorXCB(width, height, hotX, hotY int, data []byte) (Cursor, error) {
+	stride := bitmapStrideXCB(width)
+	src := make([]byte, stride*height)
+	mask := make([]byte, stride*height)
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			px := data[4*(y*width+x):]
+			if px[3] < 128 {
+				continue
+			}
+			setBitXCB(mask, stride, x, y)
+			if 299*int(px[0])+587*int(px[1])+114*int(px[2]) < 128000 {
+				setBitXCB(src, stride, x, y)
+			}
+		}
+	}
+	id, err := createCursorXCB(width, height, hotX, hotY, src, mask)
+	if err != nil {
+		return nil, err
+	}
+	return &cursorXCB{id}, nil
+}
+
+// bitmapStrideXCB returns the number of bytes in a row
+// of a bitmap of the given width.
+func bitmapStrideXCB(width int) int {
+	return (width + padXCB - 1) / padXCB * padXCB / 8
+}
+
+// setBitXCB sets the bit of a bitmap that corresponds to
+// the pixel at x, y.
+// It assumes that the scanline unit of the server is
+// laid out in the same order as the bits.
+func setBitXCB(bitmap []byte, stride, x, y int) {
+	bit := x % 8
+	if bitOrderXCB == C.XCB_IMAGE_ORDER_MSB_FIRST {
+		bit = 7 - bit
+	}
+	bitmap[y*stride+x/8] |= 1 << bit
+}
+
+// createCursorXCB creates a cursor from source and mask
+// bitmaps.
+// Source bits select the foreground color (black) and
+// mask bits select which pixels are drawn.
+func createCursorXCB(width, height, hotX, hotY int, src, mask []byte) (C.xcb_cursor_t, error) {
+	wdt := C.uint16_t(width)
+	hgt := C.uint16_t(height)
+	var pix [2]C.xcb_pixmap_t
+	for i := range pix {
+		pix[i] = C.generateIdXCB(connXCB)
+		C.createPixmapXCB(connXCB, 1, pix[i], C.xcb_drawable_t(rootXCB), wdt, hgt)
+	}
+	gc := C.generateIdXCB(connXCB)
+	C.createGCXCB(connXCB, gc, C.xcb_drawable_t(pix[0]), 0, nil)
+	for i, bits := range [2][]byte{src, mask} {
+		n := C.uint32_t(len(bits))
+		data := (*C.uint8_t)(unsafe.Pointer(unsafe.SliceData(bits)))
+		C.putImageXCB(connXCB, C.XCB_IMAGE_FORMAT_XY_PIXMAP, C.xcb_drawable_t(pix[i]), gc, wdt, hgt, 0, 0, 0, 1, n, data)
+	}
+	id := C.generateIdXCB(connXCB)
+	cookie := C.createCursorXCB(connXCB, id, pix[0], pix[1], 0, 0, 0, 0xffff, 0xffff, 0xffff, C.uint16_t(hotX), C.uint16_t(hotY))
+	genErr := C.requestCheckXCB(connXCB, cookie)
+	C.freeGCXCB(connXCB, gc)
+	C.freePixmapXCB(connXCB, pix[0])
+	C.freePixmapXCB(connXCB, pix[1])
+	if genErr != nil {
+		C.free(unsafe.Pointer(genErr))
+		return 0, errors.New("wsi: createCursorXCB failed")
+	}
+	return id, nil
+}
+
+// Close destroys the cursor.
+func (c *cursorXCB) Close() {
+	if c == nil || c.id == 0 {
+		return
+	}
+	for _, w := range createdWindows {
+		if w != nil && w.(*windowXCB).cursor == c {
+			setCursorXCB(w, nil)
+		}
+	}
+	if connXCB != nil {
+		C.freeCursorXCB(connXCB, c.id)
+		C.flushXCB(connXCB)
+	}
+	*c = cursorXCB{}
+}
+
+// setCursorXCB sets the cursor of the given window.
+func setCursorXCB(win Window, cur Cursor) error {
+	w := win.(*windowXCB)
+	if cur != nil {
+		w.cursor = cur.(*cursorXCB)
+	} else {
+		w.cursor = nil
+	}
+	return applyCursorXCB(w)
+}
+
+// Clipboard state.
+// clipWinXCB is an unmapped window that owns the
+// CLIPBOARD selection on behalf of the application and
+// receives the selection from other clients.
+var (
+	clipWinXCB   C.xcb_window_t
+	clipTextXCB  string
+	clipOwnedXCB bool
+)
+
+// How long to wait for the selection owner to convert
+// the clipboard's contents.
+const clipTimeoutXCB = time.Second
+
+// initClipboardXCB creates clipWinXCB.
+func initClipboardXCB() error {
+	id := C.generateIdXCB(connXCB)
+	wclass := C.uint16_t(C.XCB_WINDOW_CLASS_INPUT_ONLY)
+	cookie := C.createWindowCheckedXCB(connXCB, 0, id, rootXCB, 0, 0, 1, 1, 0, wclass, 0, 0, nil)
+	genErr := C.requestCheckXCB(connXCB, cookie)
+	if genErr != nil {
+		C.free(unsafe.Pointer(genErr))
+		return errors.New("wsi: createWindowCheckedXCB failed")
+	}
+	clipWinXCB = id
+	return nil
+}
+
+// clipboardOwnerXCB returns the owner of the CLIPBOARD
+// selection.
+func clipboardOwnerXCB() (C.xcb_window_t, error) {
+	var genErr *C.xcb_generic_error_t
+	cookie := C.getSelectionOwnerXCB(connXCB, clipAtomXCB)
+	reply := C.getSelectionOwnerReplyXCB(connXCB, cookie, &genErr)
+	if genErr != nil || reply == nil {
+		C.free(unsafe.Pointer(genErr))
+		return 0, errors.New("wsi: getSelectionOwnerXCB failed")
+	}
+	owner := reply.owner
+	C.free(unsafe.Pointer(reply))
+	return owner, nil
+}
+
+// clipboardXCB returns the text in the clipboard.
+func clipboardXCB() (string, error) {
+	if clipOwnedXCB {
+		return clipTextXCB, nil
+	}
+	owner, err := clipboardOwnerXCB()
+	if err != nil || owner == C.XCB_NONE {
+		return "", err
+	}
+	C.convertSelectionXCB(connXCB, clipWinXCB, clipAtomXCB, utf8AtomXCB, propAtomXCB, C.XCB_CURRENT_TIME)
+	C.flushXCB(connXCB)
+	prop, err := waitSelectionXCB()
+	if err != nil || prop == C.XCB_NONE {
+		// The owner has no text to give.
+		return "", err
+	}
+	var genErr *C.xcb_generic_error_t
+	cookie := C.getPropertyXCB(connXCB, 1, clipWinXCB, propAtomXCB, C.XCB_GET_PROPERTY_TYPE_ANY, 0, math.MaxUint32/4)
+	reply := C.getPropertyReplyXCB(connXCB, cookie, &genErr)
+	if genErr != nil || reply == nil {
+		C.free(unsafe.Pointer(genErr))
+		return "", errors.New("wsi: getPropertyXCB failed")
+	}
+	defer C.free(unsafe.Pointer(reply))
+	if reply._type == incrAtomXCB {
+		// TODO: Support incremental transfers.
+		return "", errors.New("wsi: clipboard contents too large")
+	}
+	n := C.getPropertyValueLengthXCB(reply)
+	return C.GoStringN((*C.char)(C.getPropertyValueXCB(reply)), n), nil
+}
+
+// waitSelectionXCB waits for the selection notify event
+// that follows a call to convertSelectionXCB and returns
+// its property.
+// Any other event received while waiting is added to
+// queuedXCB.
+func waitSelectionXCB() (C.xcb_atom_t, error) {
+	deadline := time.Now().Add(clipTimeoutXCB)
+	for {
+		event := C.pollForEventXCB(connXCB)
+		if event == nil {
+			if time.Now().After(deadline) {
+				return C.XCB_NONE, errors.New("wsi: clipboard request timed out")
+			}
+			time.Sleep(time.Millisecond)
+			continue
+		}
+		if event.response_type&127 == C.XCB_SELECTION_NOTIFY {
+			evt := (*C.xcb_selection_notify_event_t)(unsafe.Pointer(event))
+			if evt.requestor == clipWinXCB && evt.selection == clipAtomXCB {
+				prop := evt.property
+				C.free(unsafe.Pointer(event))
+				return prop, nil
+			}
+		}
+		queuedXCB = append(queuedXCB, event)
+	}
+}
+
+// setClipboardXCB sets the text in the clipboard.
+func setClipboardXCB(s string) error {
+	C.setSelectionOwnerXCB(connXCB, clipWinXCB, clipAtomXCB, C.XCB_CURRENT_TIME)
+	owner, err := clipboardOwnerXCB()
+	if err != nil {
+		return err
+	}
+	if owner != clipWinXCB {
+		return errors.New("wsi: failed to acquire clipboard")
+	}
+	clipTextXCB = s
+	clipOwnedXCB = true
+	return nil
+}
+
+// selectionRequestEventXCB handles selection request
+// events.
+func selectionRequestEventXCB(event *C.xcb_generic_event_t) {
+	evt := (*C.xcb_selection_request_event_t)(unsafe.Pointer(event))
+	prop := evt.property
+	if prop == C.XCB_NONE {
+		// Obsolete requestor.
+		prop = evt.target
+	}
+	var cookie C.xcb_void_cookie_t
+	switch {
+	case !clipOwnedXCB || evt.selection != clipAtomXCB:
+		prop = C.XCB_NONE
+	case evt.target == targetsAtomXCB:
+		targets := [...]C.xcb_atom_t{targetsAtomXCB, utf8AtomXCB, C.XCB_ATOM_STRING}
+		cookie = C.changePropertyCheckedXCB(connXCB, C.XCB_PROP_MODE_REPLACE, evt.requestor, prop, C.XCB_ATOM_ATOM, 32, C.uint32_t(len(targets)), unsafe.Pointer(&targets[0]))
+	case evt.target == utf8AtomXCB || evt.target == C.XCB_ATOM_STRING:
+		// TODO: STRING should be Latin-1.
+		n := C.uint32_t(len(clipTextXCB))
+		data := unsafe.Pointer(unsafe.StringData(clipTextXCB))
+		cookie = C.changePropertyCheckedXCB(connXCB, C.XCB_PROP_MODE_REPLACE, evt.requestor, prop, evt.target, 8, n, data)
+	default:
+		prop = C.XCB_NONE
+	}
+	if prop != C.XCB_NONE {
+		if genErr := C.requestCheckXCB(connXCB, cookie); genErr != nil {
+			C.free(unsafe.Pointer(genErr))
+			prop = C.XCB_NONE
+		}
+	}
+	var notify [32]byte
+	*(*C.xcb_selection_notify_event_t)(unsafe.Pointer(&notify)) = C.xcb_selection_notify_event_t{
+		response_type: C.XCB_SELECTION_NOTIFY,
+		time:          evt.time,
+		requestor:     evt.requestor,
+		selection:     evt.selection,
+		target:        evt.target,
+		property:      prop,
+	}
+	C.sendEventXCB(connXCB, 0, evt.requestor, C.XCB_EVENT_MASK_NO_EVENT, (*C.char)(unsafe.Pointer(&notify)))
+	C.flushXCB(connXCB)
+}
+
+// selectionClearEventXCB handles selection clear events.
+func selectionClearEventXCB(event *C.xcb_generic_event_t) {
+	evt := (*C.xcb_selection_clear_event_t)(unsafe.Pointer(event))
+	if evt.selection == clipAtomXCB && evt.owner == clipWinXCB {
+		clipTextXCB = ""
+		clipOwnedXCB = false
+	}
+}
+
+// Keyboard mapping, used to translate key presses into
+// text.
+var (
+	keysymsXCB    []C.xcb_keysym_t
+	keysymsPerXCB int
+	minKeycodeXCB int
+)
+
+// initKeysymsXCB fetches the keyboard mapping.
+func initKeysymsXCB() error {
+	setup := C.getSetupXCB(connXCB)
+	first := setup.min_keycode
+	count := C.uint8_t(setup.max_keycode - first + 1)
+	var genErr *C.xcb_generic_error_t
+	cookie := C.getKeyboardMappingXCB(connXCB, first, count)
+	reply := C.getKeyboardMappingReplyXCB(connXCB, cookie, &genErr)
+	if genErr != nil || reply == nil {
+		C.free(unsafe.Pointer(genErr))
+		return errors.New("wsi: getKeyboardMappingXCB failed")
+	}
+	defer C.free(unsafe.Pointer(reply))
+	n := C.getKeyboardMappingKeysymsLengthXCB(reply)
+	syms := unsafe.Slice(C.getKeyboardMappingKeysymsXCB(reply), n)
+	keysymsXCB = append(keysymsXCB[:0], syms...)
+	keysymsPerXCB = int(reply.keysyms_per_keycode)
+	minKeycodeXCB = int(first)
+	return nil
+}
+
+// startTextInputXCB enables text input in the given
+// window.
+// There is no input method support, so the text of
+// key presses is reported but compositions are not.
+func startTextInputXCB(win Window, _, _, _, _ int) error {
+	win.(*windowXCB).textInput = true
+	return nil
+}
+
+// stopTextInputXCB disables text input in the given
+// window.
+func stopTextInputXCB(win Window) {
+	win.(*windowXCB).textInput = false
+}
+
+// textEventXCB reports the text of a key press event.
+func textEventXCB(evt *C.xcb_key_press_event_t) {
+	if textInputHandler == nil {
+		return
+	}
+	win := windowFromXCB(evt.event)
+	if win == nil || !win.(*windowXCB).textInput {
+		return
+	}
+	if evt.state&(C.XCB_MOD_MASK_CONTROL|C.XCB_MOD_MASK_1) != 0 {
+		// Shortcuts are reported as keys.
+		return
+	}
+	if r := runeXCB(int(evt.detail), evt.state); r != 0 {
+		textInputHandler.TextInput(string(r))
+	}
+}
+
+// runeXCB returns the character produced by the given
+// keycode and modifier state, or 0 if none.
+// TODO: Keypad keysyms.
+func runeXCB(code int, state C.uint16_t) rune {
+	i := (code - minKeycodeXCB) * keysymsPerXCB
+	if i < 0 || i >= len(keysymsXCB) {
+		return 0
+	}
+	lower := keysymRuneXCB(keysymsXCB[i])
+	shift := state&C.XCB_MOD_MASK_SHIFT != 0
+	if state&C.XCB_MOD_MASK_LOCK != 0 && unicode.IsLower(lower) {
+		shift = !shift
+	}
+	switch {
+	case !shift:
+		return lower
+	case keysymsPerXCB < 2 || keysymsXCB[i+1] == 0:
+		return unicode.ToUpper(lower)
+	default:
+		return keysymRuneXCB(keysymsXCB[i+1])
+	}
+}
+
+// keysymRuneXCB returns the character that the given
+// keysym represents, or 0 if none.
+// TODO: Legacy keysyms other than Latin-1.
+func keysymRuneXCB(sym C.xcb_keysym_t) rune {
+	switch {
+	case sym >= 0x20 && sym <= 0x7e, sym >= 0xa0 && sym <= 0xff:
+		// Latin-1 keysyms match their code points.
+		return rune(sym)
+	case sym&0xff000000 == 0x01000000:
+		return rune(sym & 0xffffff)
+	}
+	return 0
+}
+
+// Screen and scale factor of the XCB platform.
+// Windows are assumed to be in the same screen.
+var (
+	screenXCB Monitor
+	scaleXCB  = 1.0
+)
+
+// initScaleXCB sets scaleXCB and selects the root window
+// events that update screenXCB and scaleXCB.
+func initScaleXCB() error {
+	evtMask := C.uint32_t(C.XCB_EVENT_MASK_STRUCTURE_NOTIFY | C.XCB_EVENT_MASK_PROPERTY_CHANGE)
+	cookie := C.changeWindowAttributesCheckedXCB(connXCB, rootXCB, C.XCB_CW_EVENT_MASK, unsafe.Pointer(&evtMask))
+	genErr := C.requestCheckXCB(connXCB, cookie)
+	if genErr != nil {
+		C.free(unsafe.Pointer(genErr))
+		return errors.New("wsi: changeWindowAttributesCheckedXCB failed")
+	}
+	scaleXCB = resourceScaleXCB()
+	return nil
+}
+
+// resourceScaleXCB returns the scale factor defined by
+// the Xft.dpi resource, or 1 if the resource is not set.
+func resourceScaleXCB() float64 {
+	var genErr *C.xcb_generic_error_t
+	cookie := C.getPropertyXCB(connXCB, 0, rootXCB, C.XCB_ATOM_RESOURCE_MANAGER, C.XCB_ATOM_STRING, 0, math.MaxUint32/4)
+	reply := C.getPropertyReplyXCB(connXCB, cookie, &genErr)
+	if genErr != nil || reply == nil {
+		C.free(unsafe.Pointer(genErr))
+		return 1
+	}
+	defer C.free(unsafe.Pointer(reply))
+	n := C.getPropertyValueLengthXCB(reply)
+	res := C.GoStringN((*C.char)(C.getPropertyValueXCB(reply)), n)
+	if dpi := xftDPIXCB(res); dpi > 0 {
+		return dpi / 96
+	}
+	return 1
+}
+
+// xftDPIXCB returns the value of the Xft.dpi resource in
+// the given resource database, or 0 if it is not set.
+func xftDPIXCB(res string) float64 {
+	for _, line := range strings.Split(res, "\n") {
+		name, val, ok := strings.Cut(line, ":")
+		if !ok || strings.TrimSpace(name) != "Xft.dpi" {
+			continue
+		}
+		if dpi, err := strconv.ParseFloat(strings.TrimSpace(val), 64); err == nil && dpi > 0 {
+			return dpi
+		}
+	}
+	return 0
+}
+
+// propertyEventXCB handles property notify events.
+func propertyEventXCB(event *C.xcb_generic_event_t) {
+	evt := (*C.xcb_property_notify_event_t)(unsafe.Pointer(event))
+	if evt.window != rootXCB || evt.atom != C.XCB_ATOM_RESOURCE_MANAGER {
+		return
+	}
+	scale := resourceScaleXCB()
+	if scale == scaleXCB {
+		return
+	}
+	scaleXCB = scale
+	if windowScaleHandler != nil {
+		for _, w := range createdWindows {
+			if w != nil {
+				windowScaleHandler.WindowScale(w, scale)
+			}
+		}
+	}
+}
+
+// monitorsXCB returns the connected monitors.
+// TODO: Use RandR to enumerate monitors and obtain
+// their refresh rates. The whole screen is reported
+// as a single monitor for now.
+func monitorsXCB() ([]Monitor, error) {
+	m := screenXCB
+	m.Scale = scaleXCB
+	return []Monitor{m}, nil
+}
+
+// windowScaleXCB returns the scale factor of the given
+// window.
+func windowScaleXCB(Window) float64 { return scaleXCB }
+
 // ConnXCB returns the XCB connection (*C.xcb_connection_t).
 // It must not be called if XCB is not the platform is use.
 func ConnXCB() unsafe.Pointer { return unsafe.Pointer(connXCB) }
@@ -43,7 +43,57 @@ const char* const nameXCB[] = {
 #define CHANGE_PROPERTY_CHECKED_XCB 16
 	"xcb_change_property_checked",
 #define CHANGE_KEYBOARD_CONTROL_CHECKED_XCB 17
-	"xcb_change_keyboard_control_checked"
+	"xcb_change_keyboard_control_checked",
+#define CHANGE_WINDOW_ATTRIBUTES_CHECKED_XCB 18
+	"xcb_change_window_attributes_checked",
+#define CREATE_PIXMAP_XCB 19
+	"xcb_create_pixmap",
+#define FREE_PIXMAP_XCB 20
+	"xcb_free_pixmap",
+#define CREATE_GC_XCB 21
+	"xcb_create_gc",
+#define FREE_GC_XCB 22
+	"xcb_free_gc",
+#define PUT_IMAGE_XCB 23
+	"xcb_put_image",
+#define CREATE_CURSOR_XCB 24
+	"xcb_create_cursor",
+#define FREE_CURSOR_XCB 25
+	"xcb_free_cursor",
+#define GRAB_POINTER_XCB 26
+	"xcb_grab_pointer",
+#define GRAB_POINTER_REPLY_XCB 27
+	"xcb_grab_pointer_reply",
+#define UNGRAB_POINTER_XCB 28
+	"xcb_ungrab_pointer",
+#define WARP_POINTER_XCB 29
+	"xcb_warp_pointer",
+#define SET_SELECTION_OWNER_XCB 30
+	"xcb_set_selection_owner",
+#define GET_SELECTION_OWNER_XCB 31
+	"xcb_get_selection_owner",
+#define GET_SELECTION_OWNER_REPLY_XCB 32
+	"xcb_get_selection_owner_reply",
+#define CONVERT_SELECTION_XCB 33
+	"xcb_convert_selection",
+#define SEND_EVENT_XCB 34
+	"xcb_send_event",
+#define GET_PROPERTY_XCB 35
+	"xcb_get_property",
+#define GET_PROPERTY_REPLY_XCB 36
+	"xcb_get_property_reply",
+#define GET_PROPERTY_VALUE_XCB 37
+	"xcb_get_property_value",
+#define GET_PROPERTY_VALUE_LENGTH_XCB 38
+	"xcb_get_property_value_length",
+#define GET_KEYBOARD_MAPPING_XCB 39
+	"xcb_get_keyboard_mapping",
+#define GET_KEYBOARD_MAPPING_REPLY_XCB 40
+	"xcb_get_keyboard_mapping_reply",
+#define GET_KEYBOARD_MAPPING_KEYSYMS_XCB 41
+	"xcb_get_keyboard_mapping_keysyms",
+#define GET_KEYBOARD_MAPPING_KEYSYMS_LENGTH_XCB 42
+	"xcb_get_keyboard_mapping_keysyms_length"
 };
 
 // Symbol pointers.
@@ -174,3 +224,178 @@ inline xcb_void_cookie_t changeKeyboardControlCheckedXCB(xcb_connection_t* conn,
 	*(void**)(&f) = ptrXCB[CHANGE_KEYBOARD_CONTROL_CHECKED_XCB];
 	return f(conn, valMask, valList);
 }
+
+// xcb_change_window_attributes_checked.
+inline xcb_void_cookie_t changeWindowAttributesCheckedXCB(xcb_connection_t* conn, xcb_window_t id, uint32_t valMask, const void* valList) {
+	xcb_void_cookie_t (*f)(xcb_connection_t*, xcb_window_t, uint32_t, const void*);
+	*(void**)(&f) = ptrXCB[CHANGE_WINDOW_ATTRIBUTES_CHECKED_XCB];
+	return f(conn, id, valMask, valList);
+}
+
+// xcb_create_pixmap.
+inline xcb_void_cookie_t createPixmapXCB(xcb_connection_t* conn, uint8_t depth, xcb_pixmap_t id, xcb_drawable_t drawable, uint16_t w, uint16_t h) {
+	xcb_void_cookie_t (*f)(xcb_connection_t*, uint8_t, xcb_pixmap_t, xcb_drawable_t, uint16_t, uint16_t);
+	*(void**)(&f) = ptrXCB[CREATE_PIXMAP_XCB];
+	return f(conn, depth, id, drawable, w, h);
+}
+
+// xcb_free_pixmap.
+inline xcb_void_cookie_t freePixmapXCB(xcb_connection_t* conn, xcb_pixmap_t id) {
+	xcb_void_cookie_t (*f)(xcb_connection_t*, xcb_pixmap_t);
+	*(void**)(&f) = ptrXCB[FREE_PIXMAP_XCB];
+	return f(conn, id);
+}
+
+// xcb_create_gc.
+inline xcb_void_cookie_t createGCXCB(xcb_connection_t* conn, xcb_gcontext_t id, xcb_drawable_t drawable, uint32_t valMask, const void* valList) {
+	xcb_void_cookie_t (*f)(xcb_connection_t*, xcb_gcontext_t, xcb_drawable_t, uint32_t, const void*);
+	*(void**)(&f) = ptrXCB[CREATE_GC_XCB];
+	return f(conn, id, drawable, valMask, valList);
+}
+
+// xcb_free_gc.
+inline xcb_void_cookie_t freeGCXCB(xcb_connection_t* conn, xcb_gcontext_t id) {
+	xcb_void_cookie_t (*f)(xcb_connection_t*, xcb_gcontext_t);
+	*(void**)(&f) = ptrXCB[FREE_GC_XCB];
+	return f(conn, id);
+}
+
+// xcb_put_image.
+inline xcb_void_cookie_t putImageXCB(xcb_connection_t* conn, uint8_t format, xcb_drawable_t drawable, xcb_gcontext_t gc, uint16_t w, uint16_t h, int16_t x, int16_t y, uint8_t leftPad, uint8_t depth, uint32_t dataLen, const uint8_t* data) {
+	xcb_void_cookie_t (*f)(xcb_connection_t*, uint8_t, xcb_drawable_t, xcb_gcontext_t, uint16_t, uint16_t, int16_t, int16_t, uint8_t, uint8_t, uint32_t, const uint8_t*);
+	*(void**)(&f) = ptrXCB[PUT_IMAGE_XCB];
+	return f(conn, format, drawable, gc, w, h, x, y, leftPad, depth, dataLen, data);
+}
+
+// xcb_create_cursor.
+inline xcb_void_cookie_t createCursorXCB(xcb_connection_t* conn, xcb_cursor_t id, xcb_pixmap_t source, xcb_pixmap_t mask, uint16_t foreRed, uint16_t foreGreen, uint16_t foreBlue, uint16_t backRed, uint16_t backGreen, uint16_t backBlue, uint16_t x, uint16_t y) {
+	xcb_void_cookie_t (*f)(xcb_connection_t*, xcb_cursor_t, xcb_pixmap_t, xcb_pixmap_t, uint16_t, uint16_t, uint16_t, uint16_t, uint16_t, uint16_t, uint16_t, uint16_t);
+	*(void**)(&f) = ptrXCB[CREATE_CURSOR_XCB];
+	return f(conn, id, source, mask, foreRed, foreGreen, foreBlue, backRed, backGreen, backBlue, x, y);
+}
+
+// xcb_free_cursor.
+inline xcb_void_cookie_t freeCursorXCB(xcb_connection_t* conn, xcb_cursor_t id) {
+	xcb_void_cookie_t (*f)(xcb_connection_t*, xcb_cursor_t);
+	*(void**)(&f) = ptrXCB[FREE_CURSOR_XCB];
+	return f(conn, id);
+}
+
+// xcb_grab_pointer.
+inline xcb_grab_pointer_cookie_t grabPointerXCB(xcb_connection_t* conn, uint8_t ownerEvents, xcb_window_t grabWindow, uint16_t eventMask, uint8_t pointerMode, uint8_t keyboardMode, xcb_window_t confineTo, xcb_cursor_t cursor, xcb_timestamp_t time) {
+	xcb_grab_pointer_cookie_t (*f)(xcb_connection_t*, uint8_t, xcb_window_t, uint16_t, uint8_t, uint8_t, xcb_window_t, xcb_cursor_t, xcb_timestamp_t);
+	*(void**)(&f) = ptrXCB[GRAB_POINTER_XCB];
+	return f(conn, ownerEvents, grabWindow, eventMask, pointerMode, keyboardMode, confineTo, cursor, time);
+}
+
+// xcb_grab_pointer_reply.
+inline xcb_grab_pointer_reply_t* grabPointerReplyXCB(xcb_connection_t* conn, xcb_grab_pointer_cookie_t cookie, xcb_generic_error_t** error) {
+	xcb_grab_pointer_reply_t* (*f)(xcb_connection_t*, xcb_grab_pointer_cookie_t, xcb_generic_error_t**);
+	*(void**)(&f) = ptrXCB[GRAB_POINTER_REPLY_XCB];
+	return f(conn, cookie, error);
+}
+
+// xcb_ungrab_pointer.
+inline xcb_void_cookie_t ungrabPointerXCB(xcb_connection_t* conn, xcb_timestamp_t time) {
+	xcb_void_cookie_t (*f)(xcb_connection_t*, xcb_timestamp_t);
+	*(void**)(&f) = ptrXCB[UNGRAB_POINTER_XCB];
+	return f(conn, time);
+}
+
+// xcb_warp_pointer.
+inline xcb_void_cookie_t warpPointerXCB(xcb_connection_t* conn, xcb_window_t srcWindow, xcb_window_t dstWindow, int16_t srcX, int16_t srcY, uint16_t srcW, uint16_t srcH, int16_t dstX, int16_t dstY) {
+	xcb_void_cookie_t (*f)(xcb_connection_t*, xcb_window_t, xcb_window_t, int16_t, int16_t, uint16_t, uint16_t, int16_t, int16_t);
+	*(void**)(&f) = ptrXCB[WARP_POINTER_XCB];
+	return f(conn, srcWindow, dstWindow, srcX, srcY, srcW, srcH, dstX, dstY);
+}
+
+// xcb_set_selection_owner.
+inline xcb_void_cookie_t setSelectionOwnerXCB(xcb_connection_t* conn, xcb_window_t owner, xcb_atom_t selection, xcb_timestamp_t time) {
+	xcb_void_cookie_t (*f)(xcb_connection_t*, xcb_window_t, xcb_atom_t, xcb_timestamp_t);
+	*(void**)(&f) = ptrXCB[SET_SELECTION_OWNER_XCB];
+	return f(conn, owner, selection, time);
+}
+
+// xcb_get_selection_owner.
+inline xcb_get_selection_owner_cookie_t getSelectionOwnerXCB(xcb_connection_t* conn, xcb_atom_t selection) {
+	xcb_get_selection_owner_cookie_t (*f)(xcb_connection_t*, xcb_atom_t);
+	*(void**)(&f) = ptrXCB[GET_SELECTION_OWNER_XCB];
+	return f(conn, selection);
+}
+
+// xcb_get_selection_owner_reply.
+inline xcb_get_selection_owner_reply_t* getSelectionOwnerReplyXCB(xcb_connection_t* conn, xcb_get_selection_owner_cookie_t cookie, xcb_generic_error_t** error) {
+	xcb_get_selection_owner_reply_t* (*f)(xcb_connection_t*, xcb_get_selection_owner_cookie_t, xcb_generic_error_t**);
+	*(void**)(&f) = ptrXCB[GET_SELECTION_OWNER_REPLY_XCB];
+	return f(conn, cookie, error);
+}
+
+// xcb_convert_selection.
+inline xcb_void_cookie_t convertSelectionXCB(xcb_connection_t* conn, xcb_window_t requestor, xcb_atom_t selection, xcb_atom_t target, xcb_atom_t property, xcb_timestamp_t time) {
+	xcb_void_cookie_t (*f)(xcb_connection_t*, xcb_window_t, xcb_atom_t, xcb_atom_t, xcb_atom_t, xcb_timestamp_t);
+	*(void**)(&f) = ptrXCB[CONVERT_SELECTION_XCB];
+	return f(conn, requestor, selection, target, property, time);
+}
+
+// xcb_send_event.
+inline xcb_void_cookie_t sendEventXCB(xcb_connection_t* conn, uint8_t propagate, xcb_window_t destination, uint32_t eventMask, const char* event) {
+	xcb_void_cookie_t (*f)(xcb_connection_t*, uint8_t, xcb_window_t, uint32_t, const char*);
+	*(void**)(&f) = ptrXCB[SEND_EVENT_XCB];
+	return f(conn, propagate, destination, eventMask, event);
+}
+
+// xcb_get_property.
+inline xcb_get_property_cookie_t getPropertyXCB(xcb_connection_t* conn, uint8_t del, xcb_window_t id, xcb_atom_t property, xcb_atom_t type, uint32_t longOffset, uint32_t longLength) {
+	xcb_get_property_cookie_t (*f)(xcb_connection_t*, uint8_t, xcb_window_t, xcb_atom_t, xcb_atom_t, uint32_t, uint32_t);
+	*(void**)(&f) = ptrXCB[GET_PROPERTY_XCB];
+	return f(conn, del, id, property, type, longOffset, longLength);
+}
+
+// xcb_get_property_reply.
+inline xcb_get_property_reply_t* getPropertyReplyXCB(xcb_connection_t* conn, xcb_get_property_cookie_t cookie, xcb_generic_error_t** error) {
+	xcb_get_property_reply_t* (*f)(xcb_connection_t*, xcb_get_property_cookie_t, xcb_generic_error_t**);
+	*(void**)(&f) = ptrXCB[GET_PROPERTY_REPLY_XCB];
+	return f(conn, cookie, error);
+}
+
+// xcb_get_property_value.
+inline void* getPropertyValueXCB(const xcb_get_property_reply_t* reply) {
+	void* (*f)(const xcb_get_property_reply_t*);
+	*(void**)(&f) = ptrXCB[GET_PROPERTY_VALUE_XCB];
+	return f(reply);
+}
+
+// xcb_get_property_value_length.
+inline int getPropertyValueLengthXCB(const xcb_get_property_reply_t* reply) {
+	int (*f)(const xcb_get_property_reply_t*);
+	*(void**)(&f) = ptrXCB[GET_PROPERTY_VALUE_LENGTH_XCB];
+	return f(reply);
+}
+
+// xcb_get_keyboard_mapping.
+inline xcb_get_keyboard_mapping_cookie_t getKeyboardMappingXCB(xcb_connection_t* conn, xcb_keycode_t firstKeycode, uint8_t count) {
+	xcb_get_keyboard_mapping_cookie_t (*f)(xcb_connection_t*, xcb_keycode_t, uint8_t);
+	*(void**)(&f) = ptrXCB[GET_KEYBOARD_MAPPING_XCB];
+	return f(conn, firstKeycode, count);
+}
+
+// xcb_get_keyboard_mapping_reply.
+inline xcb_get_keyboard_mapping_reply_t* getKeyboardMappingReplyXCB(xcb_connection_t* conn, xcb_get_keyboard_mapping_cookie_t cookie, xcb_generic_error_t** error) {
+	xcb_get_keyboard_mapping_reply_t* (*f)(xcb_connection_t*, xcb_get_keyboard_mapping_cookie_t, xcb_generic_error_t**);
+	*(void**)(&f) = ptrXCB[GET_KEYBOARD_MAPPING_REPLY_XCB];
+	return f(conn, cookie, error);
+}
+
+// xcb_get_keyboard_mapping_keysyms.
+inline xcb_keysym_t* getKeyboardMappingKeysymsXCB(const xcb_get_keyboard_mapping_reply_t* reply) {
+	xcb_keysym_t* (*f)(const xcb_get_keyboard_mapping_reply_t*);
+	*(void**)(&f) = ptrXCB[GET_KEYBOARD_MAPPING_KEYSYMS_XCB];
+	return f(reply);
+}
+
+// xcb_get_keyboard_mapping_keysyms_length.
+inline int getKeyboardMappingKeysymsLengthXCB(const xcb_get_keyboard_mapping_reply_t* reply) {
+	int (*f)(const xcb_get_keyboard_mapping_reply_t*);
+	*(void**)(&f) = ptrXCB[GET_KEYBOARD_MAPPING_KEYSYMS_LENGTH_XCB];
+	return f(reply);
+}